@@ -53,6 +53,24 @@ include $(dir)/Rules.mk
 dir := filestore/pb
 include $(dir)/Rules.mk
 
+dir := p2p/rendezvous/pb
+include $(dir)/Rules.mk
+
+dir := p2p/staticrouting/pb
+include $(dir)/Rules.mk
+
+dir := p2p/relay/pb
+include $(dir)/Rules.mk
+
+dir := p2p/holepunch/pb
+include $(dir)/Rules.mk
+
+dir := p2p/pex/pb
+include $(dir)/Rules.mk
+
+dir := p2p/fullrt/pb
+include $(dir)/Rules.mk
+
 
 # -------------------- #
 #   universal rules    #
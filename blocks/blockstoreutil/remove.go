@@ -17,10 +17,12 @@ import (
 // empty.  If a block could not be removed than Error will contain the
 // reason the block could not be removed.  If the removal was aborted
 // due to a fatal error Hash will be be empty, Error will contain the
-// reason, and no more results will be sent.
+// reason, and no more results will be sent. If a protected block was
+// skipped with the SkipProtected option, Skipped will contain the reason.
 type RemovedBlock struct {
-	Hash  string `json:",omitempty"`
-	Error string `json:",omitempty"`
+	Hash    string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+	Skipped string `json:",omitempty"`
 }
 
 // RmBlocksOpts is used to wrap options for RmBlocks().
@@ -28,12 +30,22 @@ type RmBlocksOpts struct {
 	Prefix string
 	Quiet  bool
 	Force  bool
+
+	// Protected holds blocks that must not be removed although they aren't
+	// pinned, like the blocks of the files API root.
+	Protected *cid.Set
+	// SkipProtected reports the pinned and protected blocks as skipped
+	// instead of failing to remove them.
+	SkipProtected bool
 }
 
+const protectedReason = "referenced by the files API root"
+
 // RmBlocks removes the blocks provided in the cids slice.
 // It returns a channel where objects of type RemovedBlock are placed, when
 // not using the Quiet option. Block removal is asynchronous and will
-// skip any pinned blocks.
+// skip any pinned or protected blocks. The pinset is checked once for all the
+// blocks.
 func RmBlocks(blocks bs.GCBlockstore, pins pin.Pinner, cids []cid.Cid, opts RmBlocksOpts) (<-chan interface{}, error) {
 	// make the channel large enough to hold any result to avoid
 	// blocking while holding the GCLock
@@ -44,7 +56,7 @@ func RmBlocks(blocks bs.GCBlockstore, pins pin.Pinner, cids []cid.Cid, opts RmBl
 		unlocker := blocks.GCLock()
 		defer unlocker.Unlock()
 
-		stillOkay := FilterPinned(pins, out, cids)
+		stillOkay := filterProtected(pins, out, cids, opts)
 
 		for _, c := range stillOkay {
 			err := blocks.DeleteBlock(c)
@@ -66,20 +78,38 @@ func RmBlocks(blocks bs.GCBlockstore, pins pin.Pinner, cids []cid.Cid, opts RmBl
 // This function is used in RmBlocks to filter out any blocks which are not
 // to be removed (because they are pinned).
 func FilterPinned(pins pin.Pinner, out chan<- interface{}, cids []cid.Cid) []cid.Cid {
+	return filterProtected(pins, out, cids, RmBlocksOpts{})
+}
+
+// filterProtected is FilterPinned, also filtering out the blocks in
+// opts.Protected, and reporting the filtered blocks as skipped if
+// opts.SkipProtected is set.
+func filterProtected(pins pin.Pinner, out chan<- interface{}, cids []cid.Cid, opts RmBlocksOpts) []cid.Cid {
 	stillOkay := make([]cid.Cid, 0, len(cids))
 	res, err := pins.CheckIfPinned(cids...)
 	if err != nil {
 		out <- &RemovedBlock{Error: fmt.Sprintf("pin check failed: %s", err)}
 		return nil
 	}
+
+	keep := func(c cid.Cid, reason string) {
+		if opts.SkipProtected {
+			if !opts.Quiet {
+				out <- &RemovedBlock{Hash: c.String(), Skipped: reason}
+			}
+		} else {
+			out <- &RemovedBlock{Hash: c.String(), Error: reason}
+		}
+	}
+
 	for _, r := range res {
-		if !r.Pinned() {
+		switch {
+		case r.Pinned():
+			keep(r.Key, r.String())
+		case opts.Protected != nil && opts.Protected.Has(r.Key):
+			keep(r.Key, protectedReason)
+		default:
 			stillOkay = append(stillOkay, r.Key)
-		} else {
-			out <- &RemovedBlock{
-				Hash:  r.Key.String(),
-				Error: r.String(),
-			}
 		}
 	}
 	return stillOkay
@@ -102,6 +132,8 @@ func ProcRmOutput(next func() (interface{}, error), sout io.Writer, serr io.Writ
 		} else if r.Error != "" {
 			someFailed = true
 			fmt.Fprintf(serr, "cannot remove %s: %s\n", r.Hash, r.Error)
+		} else if r.Skipped != "" {
+			fmt.Fprintf(sout, "skipped %s: %s\n", r.Hash, r.Skipped)
 		} else {
 			fmt.Fprintf(sout, "removed %s\n", r.Hash)
 		}
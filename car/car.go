@@ -0,0 +1,188 @@
+// Package car reads and writes CAR (content addressable archive) files, the
+// version 1 format used to move DAGs between nodes outside of the network.
+//
+// A CAR file starts with a varint prefixed dag-cbor header listing the root
+// CIDs, followed by the blocks, each prefixed by the varint length of its CID
+// and data.
+package car
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	cbor "gx/ipfs/QmPrv66vmh2P7vLJMpYx6DWLTNKvVB4Jdkyxs6V3QvWKvf/go-ipld-cbor"
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// Version is the version of the CAR format written by this package.
+const Version = 1
+
+// maxSectionSize bounds the size of the header and blocks read, so that a
+// corrupted length doesn't make the reader allocate arbitrary amounts of
+// memory.
+const maxSectionSize = 32 << 20
+
+// Header is the header of a CAR file.
+type Header struct {
+	Roots   []cid.Cid `refmt:"roots"`
+	Version uint64    `refmt:"version"`
+}
+
+func init() {
+	cbor.RegisterCborType(Header{})
+}
+
+// Writer writes a CAR file. Blocks are only written once.
+type Writer struct {
+	w    io.Writer
+	seen *cid.Set
+}
+
+// NewWriter writes the header of a CAR file with the given roots to w.
+func NewWriter(w io.Writer, roots []cid.Cid) (*Writer, error) {
+	hdr, err := cbor.DumpObject(&Header{Roots: roots, Version: Version})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSection(w, hdr); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, seen: cid.NewSet()}, nil
+}
+
+// Put writes blk to the CAR file, unless it was already written.
+func (cw *Writer) Put(blk blocks.Block) error {
+	if !cw.seen.Visit(blk.Cid()) {
+		return nil
+	}
+	return writeSection(cw.w, blk.Cid().Bytes(), blk.RawData())
+}
+
+// WriteDAG writes a CAR file with the given roots and all the blocks
+// reachable from them to w, fetching them from ng.
+func WriteDAG(ctx context.Context, ng ipld.NodeGetter, roots []cid.Cid, w io.Writer) error {
+	cw, err := NewWriter(w, roots)
+	if err != nil {
+		return err
+	}
+	for _, c := range roots {
+		if err := cw.writeDAG(ctx, ng, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cw *Writer) writeDAG(ctx context.Context, ng ipld.NodeGetter, c cid.Cid) error {
+	if cw.seen.Has(c) {
+		return nil
+	}
+	nd, err := ng.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := cw.Put(nd); err != nil {
+		return err
+	}
+	for _, l := range nd.Links() {
+		if err := cw.writeDAG(ctx, ng, l.Cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSection(w io.Writer, parts ...[]byte) error {
+	var size int
+	for _, p := range parts {
+		size += len(p)
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(size))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader reads the blocks of a CAR file.
+type Reader struct {
+	Header Header
+
+	r *bufio.Reader
+}
+
+// NewReader reads the header of the CAR file in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReader(r)}
+
+	hdr, err := cr.readSection()
+	if err == io.EOF {
+		return nil, errors.New("car: empty file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := cbor.DecodeInto(hdr, &cr.Header); err != nil {
+		return nil, fmt.Errorf("car: invalid header: %s", err)
+	}
+	if cr.Header.Version != Version {
+		return nil, fmt.Errorf("car: unsupported version %d", cr.Header.Version)
+	}
+	return cr, nil
+}
+
+// Next returns the next block of the CAR file, or io.EOF after the last one.
+// The block is checked against its CID.
+func (cr *Reader) Next() (blocks.Block, error) {
+	data, err := cr.readSection()
+	if err != nil {
+		return nil, err
+	}
+
+	n, c, err := cid.CidFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("car: invalid block: %s", err)
+	}
+	data = data[n:]
+
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !chk.Equals(c) {
+		return nil, fmt.Errorf("car: block %s doesn't match its hash", c)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (cr *Reader) readSection() ([]byte, error) {
+	size, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 || size > maxSectionSize {
+		return nil, fmt.Errorf("car: invalid section length %d", size)
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,157 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	dagtest "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag/test"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	ds := dagtest.Mock()
+
+	a := dag.NodeWithData([]byte("a"))
+	b := dag.NodeWithData([]byte("b"))
+	root := dag.NodeWithData([]byte("root"))
+	for _, child := range []*dag.ProtoNode{a, b, a} {
+		if err := root.AddNodeLink(child.Cid().String(), child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, nd := range []*dag.ProtoNode{a, b, root} {
+		if err := ds.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteDAG(ctx, ds, []cid.Cid{root.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	cr, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Header.Roots) != 1 || !cr.Header.Roots[0].Equals(root.Cid()) {
+		t.Fatalf("unexpected roots: %v", cr.Header.Roots)
+	}
+
+	seen := cid.NewSet()
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !seen.Visit(blk.Cid()) {
+			t.Fatalf("block %s written twice", blk.Cid())
+		}
+	}
+	if seen.Len() != 3 {
+		t.Fatalf("expected 3 blocks, got %d", seen.Len())
+	}
+}
+
+func TestReadCorrupted(t *testing.T) {
+	ctx := context.Background()
+	ds := dagtest.Mock()
+
+	nd := dag.NodeWithData([]byte("some data"))
+	if err := ds.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteDAG(ctx, ds, []cid.Cid{nd.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+
+	cr, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cr.Next(); err == nil {
+		t.Fatal("expected an error reading a corrupted block")
+	}
+}
+
+func TestSpool(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "car-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := dag.NodeWithData([]byte("a"))
+	root := dag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("a", a); err != nil {
+		t.Fatal(err)
+	}
+	// an intermediate node unreachable from the root
+	old := dag.NodeWithData([]byte("old root"))
+	for _, nd := range []*dag.ProtoNode{a, old, a, root} {
+		if err := s.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nd, err := s.Get(ctx, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pbnd, ok := nd.(*dag.ProtoNode); !ok || string(pbnd.Data()) != "root" || len(pbnd.Links()) != 1 {
+		t.Fatalf("unexpected node read back: %v", nd)
+	}
+	if _, err := s.Get(ctx, dag.NodeWithData([]byte("missing")).Cid()); err != ipld.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteDAG(ctx, s, []cid.Cid{root.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for {
+		_, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("expected the 2 reachable blocks, got %d", n)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Fatal("expected the spool file to be removed")
+	}
+}
@@ -0,0 +1,116 @@
+package car
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// spooled locates the data of a block in the spool file.
+type spooled struct {
+	off  int64
+	size int
+}
+
+// Spool is a DAG service keeping the blocks added to it in a temporary file,
+// for a DAG to be built and written to a CAR file without being stored in
+// the repo or held in memory: only the offsets of the blocks are. Removing
+// blocks is a no-op.
+type Spool struct {
+	lk     sync.Mutex
+	f      *os.File
+	size   int64
+	blocks map[string]spooled
+}
+
+var _ ipld.DAGService = (*Spool)(nil)
+
+// NewSpool creates the spool file in dir.
+func NewSpool(dir string) (*Spool, error) {
+	f, err := ioutil.TempFile(dir, ".car-spool")
+	if err != nil {
+		return nil, err
+	}
+	return &Spool{f: f, blocks: make(map[string]spooled)}, nil
+}
+
+// Close removes the spool file.
+func (s *Spool) Close() error {
+	err := s.f.Close()
+	if rerr := os.Remove(s.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (s *Spool) put(blk blocks.Block) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	key := blk.Cid().KeyString()
+	if _, ok := s.blocks[key]; ok {
+		return nil
+	}
+	data := blk.RawData()
+	if _, err := s.f.WriteAt(data, s.size); err != nil {
+		return err
+	}
+	s.blocks[key] = spooled{off: s.size, size: len(data)}
+	s.size += int64(len(data))
+	return nil
+}
+
+func (s *Spool) Add(ctx context.Context, nd ipld.Node) error {
+	return s.put(nd)
+}
+
+func (s *Spool) AddMany(ctx context.Context, nds []ipld.Node) error {
+	for _, nd := range nds {
+		if err := s.put(nd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spool) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	s.lk.Lock()
+	loc, ok := s.blocks[c.KeyString()]
+	s.lk.Unlock()
+	if !ok {
+		return nil, ipld.ErrNotFound
+	}
+
+	data := make([]byte, loc.size)
+	if _, err := s.f.ReadAt(data, loc.off); err != nil {
+		return nil, err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return nil, err
+	}
+	return ipld.Decode(blk)
+}
+
+func (s *Spool) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+	for _, c := range cids {
+		nd, err := s.Get(ctx, c)
+		out <- &ipld.NodeOption{Node: nd, Err: err}
+	}
+	close(out)
+	return out
+}
+
+func (s *Spool) Remove(ctx context.Context, c cid.Cid) error {
+	return nil
+}
+
+func (s *Spool) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	return nil
+}
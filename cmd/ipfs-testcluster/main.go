@@ -0,0 +1,199 @@
+// ipfs-testcluster runs a cluster of ephemeral IPFS nodes in one process,
+// connected to each other on the loopback interface, for integration tests
+// and demos of multi-node behavior.
+//
+// The nodes keep everything in memory and are gone once the process exits.
+// Each one serves the HTTP API on a random port, listed on stdout as
+//
+//	<index> <peer ID> <API address>
+//
+// so that they can be driven with `ipfs --api=<API address> ...`.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	commands "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	corehttp "github.com/ipfs/go-ipfs/core/corehttp"
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	datastore "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	syncds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+var nodes = flag.Int("n", 3, "number of nodes")
+var seed = flag.String("seed", "", "INSECURE, for testing only: derive the identities of the nodes from this hex seed")
+var noAPI = flag.Bool("no-api", false, "don't serve the HTTP API of the nodes")
+
+func main() {
+	flag.Parse()
+
+	if *nodes < 1 {
+		log.Fatalf("invalid number of nodes: %d", *nodes)
+	}
+
+	var seedb []byte
+	if *seed != "" {
+		var err error
+		seedb, err = hex.DecodeString(*seed)
+		if err != nil {
+			log.Fatalf("invalid seed: %s", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := run(ctx, *nodes, seedb, !*noAPI); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, n int, seed []byte, api bool) error {
+	cluster := make([]*core.IpfsNode, 0, n)
+	defer func() {
+		for _, nd := range cluster {
+			nd.Close()
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		nd, err := newNode(ctx, nodeSeed(seed, i))
+		if err != nil {
+			return fmt.Errorf("node %d: %s", i, err)
+		}
+		cluster = append(cluster, nd)
+	}
+
+	if err := connectAll(ctx, cluster); err != nil {
+		return err
+	}
+
+	errc := make(chan error, n)
+	for i, nd := range cluster {
+		apiAddr := "-"
+		if api {
+			addr, err := serveAPI(nd, errc)
+			if err != nil {
+				return fmt.Errorf("node %d: %s", i, err)
+			}
+			apiAddr = addr.String()
+		}
+		fmt.Printf("%d %s %s\n", i, nd.Identity.Pretty(), apiAddr)
+	}
+	log.Printf("%d nodes running, interrupt to stop", n)
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-interrupts:
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+// nodeSeed derives the seed of the i-th node from the seed of the cluster,
+// or returns a random seed if the cluster has none.
+func nodeSeed(seed []byte, i int) []byte {
+	if seed == nil {
+		s := make([]byte, 32)
+		if _, err := rand.Read(s); err != nil {
+			panic(err)
+		}
+		return s
+	}
+
+	s := make([]byte, len(seed)+4)
+	copy(s, seed)
+	binary.BigEndian.PutUint32(s[len(seed):], uint32(i))
+	return s
+}
+
+func newNode(ctx context.Context, seed []byte) (*core.IpfsNode, error) {
+	identity, err := core.SeededIdentity(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	c := config.Config{}
+	c.Identity = identity
+	c.Addresses.Swarm = []string{"/ip4/127.0.0.1/tcp/0"}
+
+	r := &repo.Mock{
+		C: c,
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+		K: keystore.NewMemKeystore(),
+	}
+
+	return core.NewNode(ctx, &core.BuildCfg{
+		Online: true,
+		Repo:   r,
+	})
+}
+
+// connectAll connects every node of the cluster to all the others.
+func connectAll(ctx context.Context, cluster []*core.IpfsNode) error {
+	for i, a := range cluster {
+		for _, b := range cluster[i+1:] {
+			pi := pstore.PeerInfo{
+				ID:    b.Identity,
+				Addrs: b.PeerHost.Addrs(),
+			}
+			if err := a.PeerHost.Connect(ctx, pi); err != nil {
+				return fmt.Errorf("connecting %s to %s: %s", a.Identity.Pretty(), b.Identity.Pretty(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// serveAPI serves the HTTP API of nd on a random port of the loopback
+// interface, sending the error to errc if serving stops.
+func serveAPI(nd *core.IpfsNode, errc chan<- error) (ma.Multiaddr, error) {
+	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	if err != nil {
+		return nil, err
+	}
+	lis, err := manet.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []corehttp.ServeOption{
+		corehttp.CommandsOption(cmdCtx(nd)),
+		corehttp.VersionOption(),
+	}
+	go func() {
+		errc <- corehttp.Serve(nd, manet.NetListener(lis), opts...)
+	}()
+	return lis.Multiaddr(), nil
+}
+
+func cmdCtx(node *core.IpfsNode) commands.Context {
+	return commands.Context{
+		Online: true,
+		LoadConfig: func(path string) (*config.Config, error) {
+			return node.Repo.Config()
+		},
+		ConstructNode: func() (*core.IpfsNode, error) {
+			return node, nil
+		},
+	}
+}
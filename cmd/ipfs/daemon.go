@@ -16,6 +16,7 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 	commands "github.com/ipfs/go-ipfs/core/commands"
 	corehttp "github.com/ipfs/go-ipfs/core/corehttp"
+	corelog "github.com/ipfs/go-ipfs/core/corelog"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	nodeMount "github.com/ipfs/go-ipfs/fuse/node"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
@@ -24,7 +25,6 @@ import (
 	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
 	mprome "gx/ipfs/QmUHHsirrDtP6WEHhE8SZeG672CLqDJn6XGzAHnvBHUiA3/go-metrics-prometheus"
-	"gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
 	"gx/ipfs/QmYYv3QFnfQbiwmi1tpkgKF8o4xFnZoBrvpupTiGJwL9nH/client_golang/prometheus"
 	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
 )
@@ -39,11 +39,16 @@ const (
 	migrateKwd                = "migrate"
 	mountKwd                  = "mount"
 	offlineKwd                = "offline"
+	repoReadOnlyKwd           = "repo-read-only"
 	routingOptionKwd          = "routing"
 	routingOptionSupernodeKwd = "supernode"
 	routingOptionDHTClientKwd = "dhtclient"
 	routingOptionDHTKwd       = "dht"
+	routingOptionDHTServerKwd = "dhtserver"
+	routingOptionDHTAutoKwd   = "dhtauto"
 	routingOptionNoneKwd      = "none"
+	routingOptionStaticKwd    = "static"
+	routingOptionCustomKwd    = "custom"
 	routingOptionDefaultKwd   = "default"
 	unencryptTransportKwd     = "disable-transport-encryption"
 	unrestrictedApiAccessKwd  = "unrestricted-api"
@@ -126,14 +131,42 @@ environment variable:
 
 Routing
 
-IPFS by default will use a DHT for content routing. There is a highly
-experimental alternative that operates the DHT in a 'client only' mode that
-can be enabled by running the daemon as:
+IPFS by default will use a DHT for content routing, and answer the DHT
+queries of its peers. The mode is set in Routing.Type, or with the --routing
+flag:
 
   ipfs daemon --routing=dhtclient
 
-This will later be transitioned into a config option once it gets out of the
-'experimental' stage.
+'dhtclient' never answers the queries, which reduces the inbound traffic of
+resource-constrained nodes. 'dhtauto' answers them only while the node listens
+on a public interface address, which suits the nodes that know they are not
+behind a NAT nor a cloud provider's address translation: the node is never
+dialable otherwise. The current mode is shown by 'ipfs dht mode'.
+
+For fully private deployments, the daemon can route only through a static set
+of peers, without a DHT nor the public bootstrap peers:
+
+  ipfs config Routing.Type static
+  ipfs routing static add /ip4/10.0.0.2/tcp/4001/ipfs/QmPeer...
+
+The static peers are listed in Routing.Static.Peers.
+
+With Routing.Type set to 'custom', the daemon routes through the router named
+by Routing.Router among the routers of Routing.Routers, which combine the DHT,
+delegated and static routers in parallel or in sequence.
+
+Read-only repo
+
+For mirrors and replicas, the daemon can be prevented from modifying the
+content of its repo:
+
+  ipfs daemon --repo-read-only
+
+Commands that would add, remove or pin content, change mfs, keys or the
+config are then rejected, and the gateway isn't writable. Only the content
+already stored in the repo is served, since content fetched from the network
+couldn't be stored. The daemon still needs write access to the repo for its
+lock and its internal records.
 
 DEPRECATION NOTICE
 
@@ -160,6 +193,7 @@ Headers.
 		cmdkit.BoolOption(enableGCKwd, "Enable automatic periodic repo garbage collection"),
 		cmdkit.BoolOption(adjustFDLimitKwd, "Check and raise file descriptor limits if needed").WithDefault(true),
 		cmdkit.BoolOption(offlineKwd, "Run offline. Do not connect to the rest of the network but provide local API."),
+		cmdkit.BoolOption(repoReadOnlyKwd, "Reject all commands modifying the repo, and only serve the content it already holds."),
 		cmdkit.BoolOption(migrateKwd, "If true, assume yes at the migrate prompt. If false, assume no."),
 		cmdkit.BoolOption(enableFloodSubKwd, "Instantiate the ipfs daemon with the experimental pubsub feature enabled."),
 		cmdkit.BoolOption(enableIPNSPubSubKwd, "Enable IPNS record distribution through pubsub; enables pubsub."),
@@ -274,7 +308,30 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	logSinks, err := corelog.Setup(repo, cctx.ConfigRoot)
+	if err != nil {
+		return err
+	}
+	defer logSinks.Close()
+
+	audit, err := corelog.OpenAuditLog(repo, cctx.ConfigRoot)
+	if err != nil {
+		return err
+	}
+	if audit != nil {
+		defer audit.Close()
+	}
+
 	offline, _ := req.Options[offlineKwd].(bool)
+	readOnly, _ := req.Options[repoReadOnlyKwd].(bool)
+	if readOnly {
+		if gc, _ := req.Options[enableGCKwd].(bool); gc {
+			return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s", enableGCKwd, repoReadOnlyKwd)
+		}
+		if writable, _ := req.Options[writableKwd].(bool); writable {
+			return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s", writableKwd, repoReadOnlyKwd)
+		}
+	}
 	ipnsps, _ := req.Options[enableIPNSPubSubKwd].(bool)
 	pubsub, _ := req.Options[enableFloodSubKwd].(bool)
 	mplex, _ := req.Options[enableMultiplexKwd].(bool)
@@ -284,6 +341,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		Repo:      repo,
 		Permanent: true, // It is temporary way to signify that node is permanent
 		Online:    !offline,
+		ReadOnly:  readOnly,
 		DisableEncryptedConnections: unencrypted,
 		ExtraOpts: map[string]bool{
 			"pubsub": pubsub,
@@ -310,10 +368,24 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return errors.New("supernode routing was never fully implemented and has been removed")
 	case routingOptionDHTClientKwd:
 		ncfg.Routing = core.DHTClientOption
-	case routingOptionDHTKwd:
+	case routingOptionDHTKwd, routingOptionDHTServerKwd:
 		ncfg.Routing = core.DHTOption
+	case routingOptionDHTAutoKwd:
+		ncfg.Routing = core.DHTAutoOption
 	case routingOptionNoneKwd:
 		ncfg.Routing = core.NilRouterOption
+	case routingOptionStaticKwd:
+		peers, err := core.StaticRoutingPeers(repo)
+		if err != nil {
+			return err
+		}
+		ncfg.Routing = core.StaticRoutingOption(peers)
+	case routingOptionCustomKwd:
+		custom, err := core.CustomRoutingConfig(repo)
+		if err != nil {
+			return err
+		}
+		ncfg.Routing = core.CustomRoutingOption(custom)
 	default:
 		return fmt.Errorf("unrecognized routing option: %s", routingOption)
 	}
@@ -347,9 +419,29 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	cctx.ConstructNode = func() (*core.IpfsNode, error) {
 		return node, nil
 	}
+	cctx.ReadOnly = readOnly
+	if readOnly {
+		fmt.Println("Repo is read-only, commands modifying it will be rejected")
+	}
+
+	// resume the mirrors of the files API, they update mfs
+	if !offline && !readOnly {
+		if err := commands.ResumeFilesMirrors(node); err != nil {
+			return err
+		}
+	}
+
+	// construct the HTTPS listeners - if HTTPS sets addresses
+	https, err := core.NewHTTPS(node.Repo)
+	if err != nil {
+		return err
+	}
+	if https != nil {
+		defer https.Close()
+	}
 
 	// construct api endpoint - every time
-	apiErrc, err := serveHTTPApi(req, cctx)
+	apiErrc, err := serveHTTPApi(req, cctx, https, audit)
 	if err != nil {
 		return err
 	}
@@ -371,11 +463,14 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
-	// construct http gateway - if it is set in the config
+	// construct http gateway - if it is set in the config, light clients
+	// don't serve it
 	var gwErrc <-chan error
-	if len(cfg.Addresses.Gateway) > 0 {
+	if node.LightClient() {
+		fmt.Println("Running as a light client: the DHT, the reprovider and the gateway are disabled")
+	} else if len(cfg.Addresses.Gateway) > 0 || (https != nil && len(https.Config.Gateway) > 0) {
 		var err error
-		gwErrc, err = serveHTTPGateway(req, cctx)
+		gwErrc, err = serveHTTPGateway(req, cctx, https, audit)
 		if err != nil {
 			return err
 		}
@@ -384,6 +479,16 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// initialize metrics collector
 	prometheus.MustRegister(&corehttp.IpfsNodeCollector{Node: node})
 
+	// export the pin and repo inventory - if Metrics.Inventory is enabled
+	inventory, err := corehttp.NewInventoryCollector(node)
+	if err != nil {
+		return err
+	}
+	if inventory != nil {
+		prometheus.MustRegister(inventory)
+		go inventory.Run(req.Context)
+	}
+
 	fmt.Printf("Daemon is ready\n")
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesnt follow this pattern for graceful shutdown
@@ -397,7 +502,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 }
 
 // serveHTTPApi collects options, creates listener, prints status message and starts serving requests
-func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error) {
+func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context, https *core.HTTPS, audit *corelog.AuditLog) (<-chan error, error) {
 	cfg, err := cctx.GetConfig()
 	if err != nil {
 		return nil, fmt.Errorf("serveHTTPApi: GetConfig() failed: %s", err)
@@ -412,12 +517,11 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 		return nil, fmt.Errorf("serveHTTPApi: invalid API address: %q (err: %s)", apiAddr, err)
 	}
 
-	apiLis, err := manet.Listen(apiMaddr)
+	apiLis, apiMaddr, err := core.ListenHTTP(apiMaddr)
 	if err != nil {
-		return nil, fmt.Errorf("serveHTTPApi: manet.Listen(%s) failed: %s", apiMaddr, err)
+		return nil, fmt.Errorf("serveHTTPApi: Listen(%s) failed: %s", apiAddr, err)
 	}
 	// we might have listened to /tcp/0 - lets see what we are listing on
-	apiMaddr = apiLis.Multiaddr()
 	fmt.Printf("API server listening on %s\n", apiMaddr)
 
 	// by default, we don't let you load arbitrary ipfs objects through the api,
@@ -432,6 +536,7 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("api"),
+		corehttp.AuditOption(audit),
 		corehttp.CheckVersionOption(),
 		corehttp.CommandsOption(*cctx),
 		corehttp.WebUIOption,
@@ -452,16 +557,29 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 		return nil, fmt.Errorf("serveHTTPApi: ConstructNode() failed: %s", err)
 	}
 
+	so, err := corehttp.LoadServerOptions(node.Repo, "API.Server")
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPApi: %s", err)
+	}
+
 	if err := node.Repo.SetAPIAddr(apiMaddr); err != nil {
 		return nil, fmt.Errorf("serveHTTPApi: SetAPIAddr() failed: %s", err)
 	}
 
 	errc := make(chan error)
 	go func() {
-		errc <- corehttp.Serve(node, manet.NetListener(apiLis), opts...)
+		errc <- corehttp.ServeWithOptions(node, apiLis, so, opts...)
 		close(errc)
 	}()
-	return errc, nil
+
+	if https == nil {
+		return errc, nil
+	}
+	httpsErrc, err := serveHTTPS(node, https, https.Config.API, "API server", so, opts)
+	if err != nil {
+		return nil, err
+	}
+	return merge(errc, httpsErrc), nil
 }
 
 // printSwarmAddrs prints the addresses of the host
@@ -496,40 +614,50 @@ func printSwarmAddrs(node *core.IpfsNode) {
 }
 
 // serveHTTPGateway collects options, creates listener, prints status message and starts serving requests
-func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error) {
+func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context, https *core.HTTPS, audit *corelog.AuditLog) (<-chan error, error) {
 	cfg, err := cctx.GetConfig()
 	if err != nil {
 		return nil, fmt.Errorf("serveHTTPGateway: GetConfig() failed: %s", err)
 	}
 
-	gatewayMaddr, err := ma.NewMultiaddr(cfg.Addresses.Gateway)
-	if err != nil {
-		return nil, fmt.Errorf("serveHTTPGateway: invalid gateway address: %q (err: %s)", cfg.Addresses.Gateway, err)
-	}
-
 	writable, writableOptionFound := req.Options[writableKwd].(bool)
 	if !writableOptionFound {
 		writable = cfg.Gateway.Writable
 	}
-
-	gwLis, err := manet.Listen(gatewayMaddr)
-	if err != nil {
-		return nil, fmt.Errorf("serveHTTPGateway: manet.Listen(%s) failed: %s", gatewayMaddr, err)
+	if cctx.ReadOnly {
+		writable = false
 	}
-	// we might have listened to /tcp/0 - lets see what we are listing on
-	gatewayMaddr = gwLis.Multiaddr()
 
+	name := "Gateway (readonly) server"
 	if writable {
-		fmt.Printf("Gateway (writable) server listening on %s\n", gatewayMaddr)
-	} else {
-		fmt.Printf("Gateway (readonly) server listening on %s\n", gatewayMaddr)
+		name = "Gateway (writable) server"
+	}
+
+	// the gateway may only be served over HTTPS
+	var gwLis net.Listener
+	if len(cfg.Addresses.Gateway) > 0 {
+		gatewayMaddr, err := ma.NewMultiaddr(cfg.Addresses.Gateway)
+		if err != nil {
+			return nil, fmt.Errorf("serveHTTPGateway: invalid gateway address: %q (err: %s)", cfg.Addresses.Gateway, err)
+		}
+
+		gwLis, gatewayMaddr, err = core.ListenHTTP(gatewayMaddr)
+		if err != nil {
+			return nil, fmt.Errorf("serveHTTPGateway: Listen(%s) failed: %s", cfg.Addresses.Gateway, err)
+		}
+		// we might have listened to /tcp/0 - lets see what we are listing on
+		fmt.Printf("%s listening on %s\n", name, gatewayMaddr)
 	}
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("gateway"),
-		corehttp.IPNSHostnameOption(),
+		corehttp.GatewayHostnameOption(),
+		corehttp.GatewayAllowlistOption(),
+		corehttp.GatewayNoFetchOption(),
 		corehttp.GatewayOption(writable, "/ipfs", "/ipns"),
+		corehttp.CarIngestOption("/car"),
 		corehttp.VersionOption(),
+		corehttp.AuditOption(audit),
 		corehttp.CheckVersionOption(),
 		corehttp.CommandsROOption(*cctx),
 	}
@@ -543,12 +671,52 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 		return nil, fmt.Errorf("serveHTTPGateway: ConstructNode() failed: %s", err)
 	}
 
-	errc := make(chan error)
-	go func() {
-		errc <- corehttp.Serve(node, manet.NetListener(gwLis), opts...)
-		close(errc)
-	}()
-	return errc, nil
+	so, err := corehttp.LoadServerOptions(node.Repo, "Gateway.Server")
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPGateway: %s", err)
+	}
+
+	var errc chan error
+	if gwLis != nil {
+		errc = make(chan error)
+		go func() {
+			errc <- corehttp.ServeWithOptions(node, gwLis, so, opts...)
+			close(errc)
+		}()
+	}
+
+	if https == nil {
+		return errc, nil
+	}
+	httpsErrc, err := serveHTTPS(node, https, https.Config.Gateway, name, so, opts)
+	if err != nil {
+		return nil, err
+	}
+	return merge(errc, httpsErrc), nil
+}
+
+// serveHTTPS serves opts on the HTTPS addresses addrs with the server options
+// so, name naming the server in the status messages.
+func serveHTTPS(node *core.IpfsNode, https *core.HTTPS, addrs []string, name string, so *corehttp.ServerOptions, opts []corehttp.ServeOption) (<-chan error, error) {
+	tso := *so
+	tso.TLS = https.TLSConfig()
+
+	var errcs []<-chan error
+	for _, a := range addrs {
+		lis, maddr, err := https.Listen(a)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("%s listening over HTTPS on %s\n", name, maddr)
+
+		errc := make(chan error)
+		go func() {
+			errc <- corehttp.ServeWithOptions(node, lis, &tso, opts...)
+			close(errc)
+		}()
+		errcs = append(errcs, errc)
+	}
+	return merge(errcs...), nil
 }
 
 //collects options and opens the fuse mountpoint
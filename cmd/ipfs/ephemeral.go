@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	corecmds "github.com/ipfs/go-ipfs/core/commands"
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+)
+
+// ephemeralEnv returns the environment of the commands run with --ephemeral:
+// their node has a new identity and an in-memory repo, dropped once the
+// command returns, and the repo of the user is never opened. The config root
+// is an empty temporary directory, returned to be removed after the command,
+// so the commands editing the repo fail rather than editing the user's one.
+func ephemeralEnv(ctx context.Context, req *cmds.Request) (*oldcmds.Context, string, error) {
+	if req.Command == daemonCmd || req.Command == initCmd {
+		return nil, "", cmds.ClientError("this command can't run with --ephemeral")
+	}
+	if api, _ := req.Options[corecmds.ApiOption].(string); api != "" {
+		return nil, "", cmds.ClientError("--ephemeral and --api are exclusive")
+	}
+
+	cfg, err := config.Init(ioutil.Discard, nBitsForKeypairDefault)
+	if err != nil {
+		return nil, "", err
+	}
+	// the node listens on random ports, not to conflict with a daemon, and
+	// serves neither the API nor the gateway
+	cfg.Addresses.Swarm = []string{"/ip4/0.0.0.0/tcp/0", "/ip6/::/tcp/0"}
+	cfg.Addresses.API = ""
+	cfg.Addresses.Gateway = ""
+
+	root, err := ioutil.TempDir("", "ipfs-ephemeral")
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &oldcmds.Context{
+		ConfigRoot: root,
+		LoadConfig: func(string) (*config.Config, error) {
+			return cfg, nil
+		},
+		ReqLog: &oldcmds.ReqLog{},
+		ConstructNode: func() (*core.IpfsNode, error) {
+			r := &repo.Mock{
+				C: *cfg,
+				D: dsync.MutexWrap(ds.NewMapDatastore()),
+				K: keystore.NewMemKeystore(),
+			}
+			n, err := core.NewNode(ctx, &core.BuildCfg{
+				Repo:   r,
+				Online: true,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			// the node is online, as a daemon's, for the commands to fetch
+			// the blocks they need from the network
+			n.SetLocal(false)
+			return n, nil
+		},
+	}, root, nil
+}
+
+// removeEphemeralRoot removes the temporary config root of an ephemeral node.
+func removeEphemeralRoot(root string) {
+	if root == "" {
+		return
+	}
+	if err := os.RemoveAll(root); err != nil {
+		log.Errorf("failed to remove %s: %s", root, err)
+	}
+}
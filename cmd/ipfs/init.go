@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
@@ -14,6 +16,7 @@ import (
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	core "github.com/ipfs/go-ipfs/core"
 	namesys "github.com/ipfs/go-ipfs/namesys"
+	repo "github.com/ipfs/go-ipfs/repo"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	"gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
@@ -41,6 +44,11 @@ located at ~/.ipfs. To change the repo location, set the $IPFS_PATH
 environment variable:
 
     export IPFS_PATH=/path/to/ipfsrepo
+
+INSECURE: --seed derives the identity of the node from the given hex seed
+instead of generating a random keypair, so that the same seed always gives
+the same peer ID. Anyone knowing the seed has the private key of the node:
+only use it for tests and demos.
 `,
 	},
 	Arguments: []cmdkit.Argument{
@@ -50,6 +58,7 @@ environment variable:
 		cmdkit.IntOption("bits", "b", "Number of bits to use in the generated RSA private key.").WithDefault(nBitsForKeypairDefault),
 		cmdkit.BoolOption("empty-repo", "e", "Don't add and pin help files to the local storage."),
 		cmdkit.StringOption("profile", "p", "Apply profile settings to config. Multiple profiles can be separated by ','"),
+		cmdkit.StringOption("seed", "INSECURE, for testing only: derive the identity from this hex seed."),
 
 		// TODO need to decide whether to expose the override as a file or a
 		// directory. That is: should we allow the user to also specify the
@@ -103,7 +112,19 @@ environment variable:
 			profiles = strings.Split(profile, ",")
 		}
 
-		return doInit(os.Stdout, cctx.ConfigRoot, empty, nBitsForKeypair, profiles, conf)
+		var seed []byte
+		if seedStr, ok := req.Options["seed"].(string); ok {
+			var err error
+			seed, err = hex.DecodeString(seedStr)
+			if err != nil {
+				return fmt.Errorf("invalid seed: %s", err)
+			}
+			if len(seed) == 0 {
+				return errors.New("invalid seed: empty")
+			}
+		}
+
+		return doInit(os.Stdout, cctx.ConfigRoot, empty, nBitsForKeypair, profiles, conf, seed)
 	},
 }
 
@@ -117,10 +138,12 @@ func initWithDefaults(out io.Writer, repoRoot string, profile string) error {
 		profiles = strings.Split(profile, ",")
 	}
 
-	return doInit(out, repoRoot, false, nBitsForKeypairDefault, profiles, nil)
+	return doInit(out, repoRoot, false, nBitsForKeypairDefault, profiles, nil, nil)
 }
 
-func doInit(out io.Writer, repoRoot string, empty bool, nBitsForKeypair int, confProfiles []string, conf *config.Config) error {
+// doInit initializes the repo at repoRoot. The identity is derived from seed
+// if it isn't nil, replacing the one of conf.
+func doInit(out io.Writer, repoRoot string, empty bool, nBitsForKeypair int, confProfiles []string, conf *config.Config, seed []byte) error {
 	if _, err := fmt.Fprintf(out, "initializing IPFS node at %s\n", repoRoot); err != nil {
 		return err
 	}
@@ -134,28 +157,53 @@ func doInit(out io.Writer, repoRoot string, empty bool, nBitsForKeypair int, con
 	}
 
 	if conf == nil {
+		// the generated keypair is replaced by the seeded one, don't
+		// report it
+		initOut := out
+		if seed != nil {
+			initOut = ioutil.Discard
+		}
+
 		var err error
-		conf, err = config.Init(out, nBitsForKeypair)
+		conf, err = config.Init(initOut, nBitsForKeypair)
 		if err != nil {
 			return err
 		}
 	}
 
-	for _, profile := range confProfiles {
-		transformer, ok := config.Profiles[profile]
+	if seed != nil {
+		identity, err := core.SeededIdentity(seed)
+		if err != nil {
+			return err
+		}
+		conf.Identity = identity
+
+		if _, err := fmt.Fprintf(out, "peer identity: %s (derived from the seed, INSECURE)\n", identity.PeerID); err != nil {
+			return err
+		}
+	}
+
+	var profiles []core.ConfigProfile
+	for _, name := range confProfiles {
+		profile, ok := core.LookupConfigProfile(name)
 		if !ok {
-			return fmt.Errorf("invalid configuration profile: %s", profile)
+			return fmt.Errorf("invalid configuration profile: %s", name)
 		}
 
-		if err := transformer.Transform(conf); err != nil {
+		if err := profile.Transform(conf); err != nil {
 			return err
 		}
+		profiles = append(profiles, profile)
 	}
 
 	if err := fsrepo.Init(repoRoot, conf); err != nil {
 		return err
 	}
 
+	if err := applyProfiles(repoRoot, profiles); err != nil {
+		return err
+	}
+
 	if !empty {
 		if err := addDefaultAssets(out, repoRoot); err != nil {
 			return err
@@ -165,6 +213,30 @@ func doInit(out io.Writer, repoRoot string, empty bool, nBitsForKeypair int, con
 	return initializeIpnsKeyspace(repoRoot)
 }
 
+// applyProfiles sets the config keys of the profiles which aren't part of
+// the config structs, once the repo is initialized.
+func applyProfiles(repoRoot string, profiles []core.ConfigProfile) error {
+	var r repo.Repo
+	for _, profile := range profiles {
+		if profile.Apply == nil {
+			continue
+		}
+
+		if r == nil {
+			var err error
+			r, err = fsrepo.Open(repoRoot)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+		}
+		if err := profile.Apply(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func checkWritable(dir string) error {
 	_, err := os.Stat(dir)
 	if err == nil {
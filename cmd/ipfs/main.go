@@ -95,8 +95,23 @@ func mainRet() int {
 	// so we need to make sure it's stable
 	os.Args[0] = "ipfs"
 
+	// the temporary config root of the command run with --ephemeral
+	var ephemeralRoot string
+	defer func() {
+		removeEphemeralRoot(ephemeralRoot)
+	}()
+
 	buildEnv := func(ctx context.Context, req *cmds.Request) (cmds.Environment, error) {
 		checkDebug(req)
+		if ephemeral, _ := req.Options[corecmds.EphemeralOption].(bool); ephemeral {
+			env, root, err := ephemeralEnv(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			ephemeralRoot = root
+			return env, nil
+		}
+
 		repoPath, err := getRepoPath(req)
 		if err != nil {
 			return nil, err
@@ -233,6 +248,14 @@ func commandShouldRunOnDaemon(details cmdDetails, req *cmds.Request, cctx *oldcm
 		return nil, nil
 	}
 
+	// the ephemeral nodes never use the daemon
+	if ephemeral, _ := req.Options[corecmds.EphemeralOption].(bool); ephemeral {
+		if details.cannotRunOnClient {
+			return nil, cmds.ClientError("must run on the ipfs daemon, not on an ephemeral node")
+		}
+		return nil, nil
+	}
+
 	// at this point need to know whether api is running. we defer
 	// to this point so that we don't check unnecessarily
 
@@ -21,6 +21,10 @@ type Context struct {
 	ConfigRoot string
 	ReqLog     *ReqLog
 
+	// ReadOnly is set when the node serving the commands can't modify its
+	// repo.
+	ReadOnly bool
+
 	config     *config.Config
 	LoadConfig func(path string) (*config.Config, error)
 
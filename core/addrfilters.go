@@ -0,0 +1,93 @@
+package core
+
+import (
+	"fmt"
+	"net"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	mamask "gx/ipfs/QmSMZwvs3n4GBikZ7hKzT17c3bk65FmyZo2JqtJ16swqCv/multiaddr-filter"
+)
+
+// AddrFiltersDefaultDenyKey is the config key switching Swarm.AddrFilters
+// from a list of denied ranges to a list of allowed ranges.
+const AddrFiltersDefaultDenyKey = "Swarm.AddrFiltersDefaultDeny"
+
+// AddrFiltersDefaultDeny returns whether the address filters of r are the
+// ranges allowed rather than the ones denied.
+func AddrFiltersDefaultDeny(r repo.Repo) (bool, error) {
+	var deny bool
+	if err := repo.ConfigSection(r, AddrFiltersDefaultDenyKey, &deny); err != nil {
+		return false, err
+	}
+	return deny, nil
+}
+
+// AddrFilterMasks returns the masks to filter in the swarm for the given
+// address filters. The swarm can only deny addresses, so with defaultDeny,
+// where the filters are the ranges allowed, the masks cover every IPv4 and
+// IPv6 address outside of them.
+func AddrFilterMasks(filters []string, defaultDeny bool) ([]*net.IPNet, error) {
+	masks := make([]*net.IPNet, 0, len(filters))
+	for _, s := range filters {
+		f, err := mamask.NewMask(s)
+		if err != nil {
+			return nil, fmt.Errorf("incorrectly formatted address filter: %s", s)
+		}
+		masks = append(masks, f)
+	}
+	if !defaultDeny {
+		return masks, nil
+	}
+
+	var allowed4, allowed6 []*net.IPNet
+	for _, m := range masks {
+		if ip := m.IP.To4(); ip != nil && len(m.Mask) == net.IPv4len {
+			allowed4 = append(allowed4, &net.IPNet{IP: ip, Mask: m.Mask})
+		} else {
+			allowed6 = append(allowed6, &net.IPNet{IP: m.IP.To16(), Mask: m.Mask})
+		}
+	}
+
+	all4 := &net.IPNet{IP: make(net.IP, net.IPv4len), Mask: net.CIDRMask(0, 32)}
+	all6 := &net.IPNet{IP: make(net.IP, net.IPv6len), Mask: net.CIDRMask(0, 128)}
+	denied := complement(all4, allowed4)
+	return append(denied, complement(all6, allowed6)...), nil
+}
+
+// complement returns the prefixes covering the addresses of p outside of the
+// allowed ranges, splitting p in halves until each half is either fully
+// allowed or not allowed at all.
+func complement(p *net.IPNet, allowed []*net.IPNet) []*net.IPNet {
+	pOnes, _ := p.Mask.Size()
+	overlaps := false
+	for _, a := range allowed {
+		aOnes, _ := a.Mask.Size()
+		if aOnes <= pOnes && a.Contains(p.IP) {
+			return nil
+		}
+		if p.Contains(a.IP) {
+			overlaps = true
+		}
+	}
+	if !overlaps {
+		return []*net.IPNet{p}
+	}
+
+	lo, hi := splitPrefix(p)
+	return append(complement(lo, allowed), complement(hi, allowed)...)
+}
+
+// splitPrefix returns the two halves of p.
+func splitPrefix(p *net.IPNet) (*net.IPNet, *net.IPNet) {
+	ones, bits := p.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+
+	lo := make(net.IP, len(p.IP))
+	copy(lo, p.IP)
+	hi := make(net.IP, len(p.IP))
+	copy(hi, p.IP)
+	hi[ones/8] |= 0x80 >> uint(ones%8)
+
+	return &net.IPNet{IP: lo, Mask: mask}, &net.IPNet{IP: hi, Mask: mask}
+}
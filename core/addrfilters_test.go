@@ -0,0 +1,59 @@
+package core
+
+import (
+	"net"
+	"testing"
+)
+
+func blocked(masks []*net.IPNet, ip string) bool {
+	for _, m := range masks {
+		if m.Contains(net.ParseIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAddrFilterMasks(t *testing.T) {
+	filters := []string{"/ip4/192.168.0.0/ipcidr/16", "/ip6/2008:bcd::/ipcidr/32"}
+
+	masks, err := AddrFilterMasks(filters, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masks) != 2 {
+		t.Fatalf("expected 2 masks, got %v", masks)
+	}
+
+	masks, err = AddrFilterMasks(filters, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, deny := range map[string]bool{
+		"192.168.1.2":   false,
+		"192.169.0.1":   true,
+		"127.0.0.1":     true,
+		"8.8.8.8":       true,
+		"2008:bcd::1":   false,
+		"2008:bce::1":   true,
+		"::1":           true,
+		"255.255.255.0": true,
+	} {
+		if blocked(masks, ip) != deny {
+			t.Errorf("expected %s to be blocked: %t", ip, deny)
+		}
+	}
+
+	// without any filter, everything is denied
+	masks, err = AddrFilterMasks(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masks) != 2 || !blocked(masks, "10.0.0.1") || !blocked(masks, "fe80::1") {
+		t.Fatalf("expected everything to be denied, got %v", masks)
+	}
+
+	if _, err := AddrFilterMasks([]string{"/ip4/1.2.3.4"}, false); err == nil {
+		t.Fatal("expected an invalid filter to fail")
+	}
+}
@@ -5,13 +5,16 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"os"
 	"syscall"
 	"time"
 
+	coredag "github.com/ipfs/go-ipfs/core/coredag"
 	filestore "github.com/ipfs/go-ipfs/filestore"
 	pin "github.com/ipfs/go-ipfs/pin"
 	repo "github.com/ipfs/go-ipfs/repo"
+	journal "github.com/ipfs/go-ipfs/repo/journal"
 	cidv0v1 "github.com/ipfs/go-ipfs/thirdparty/cidv0v1"
 	"github.com/ipfs/go-ipfs/thirdparty/verifbs"
 	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
@@ -19,6 +22,7 @@ import (
 	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
 	bserv "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
 
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
 	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
 	goprocessctx "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess/context"
@@ -29,6 +33,7 @@ import (
 	cfg "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
 	ipns "gx/ipfs/QmbUUxB9ErnEQdwTzy6HTxucnBvAH4am6vsfbD8CiqKhi9/go-ipns"
 	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
 	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
 	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
 	pstoremem "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore/pstoremem"
@@ -55,6 +60,10 @@ type BuildCfg struct {
 	// If NilRepo is set, a repo backed by a nil datastore will be constructed
 	NilRepo bool
 
+	// If ReadOnly is set, blocks can't be added to or removed from the repo,
+	// and only the blocks already stored in it can be served.
+	ReadOnly bool
+
 	Routing RoutingOption
 	Host    HostOption
 	Repo    repo.Repo
@@ -188,19 +197,31 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 		TempErrFunc: isTooManyFDError,
 	}
 
+	conf, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+
 	// hash security
 	bs := bstore.NewBlockstore(rds)
+	if conf.Datastore.HashOnRead {
+		bs = verifbs.NewRehashBS(ctx, bs)
+	}
 	bs = &verifbs.VerifBS{Blockstore: bs}
 
 	opts := bstore.DefaultCacheOpts()
-	conf, err := n.Repo.Config()
-	if err != nil {
-		return err
-	}
 
 	// TEMP: setting global sharding switch here
 	uio.UseHAMTSharding = conf.Experimental.ShardingEnabled
 
+	// When all directories are sharded, there is nothing to decide.
+	if !uio.UseHAMTSharding {
+		n.Sharding, err = loadShardingPolicy(n.Repo)
+		if err != nil {
+			return err
+		}
+	}
+
 	opts.HasBloomFilterSize = conf.Datastore.BloomFilterSize
 	if !cfg.Permanent {
 		opts.HasBloomFilterSize = 0
@@ -228,15 +249,15 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 		n.Blockstore = &verifbs.VerifBSGC{GCBlockstore: n.Blockstore}
 	}
 
+	if cfg.ReadOnly {
+		n.Blockstore = readOnlyBlockstore{n.Blockstore}
+	}
+
 	rcfg, err := n.Repo.Config()
 	if err != nil {
 		return err
 	}
 
-	if rcfg.Datastore.HashOnRead {
-		bs.HashOnRead(true)
-	}
-
 	hostOption := cfg.Host
 	if cfg.DisableEncryptedConnections {
 		innerHostOption := hostOption
@@ -248,7 +269,10 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 	}
 
 	if cfg.Online {
-		do := setupDiscoveryOption(rcfg.Discovery)
+		do, err := setupDiscoveryOption(n.Repo, rcfg.Discovery)
+		if err != nil {
+			return err
+		}
 		if err := n.startOnlineServices(ctx, cfg.Routing, hostOption, do, cfg.getOpt("pubsub"), cfg.getOpt("ipnsps"), cfg.getOpt("mplex")); err != nil {
 			return err
 		}
@@ -257,18 +281,35 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 	}
 
 	n.Blocks = bserv.New(n.Blockstore, n.Exchange)
+	if cfg.ReadOnly {
+		// Fetched blocks couldn't be stored, so don't fetch them. The
+		// exchange still serves local blocks to other peers.
+		n.Blocks = bserv.New(n.Blockstore, offline.Exchange(n.Blockstore))
+	}
 	n.DAG = dag.NewDAGService(n.Blocks)
 
 	internalDag := dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore)))
-	n.Pinning, err = pin.LoadPinner(n.Repo.Datastore(), n.DAG, internalDag)
+	if err := n.setupJournal(ctx, internalDag); err != nil {
+		return err
+	}
+
+	n.Pinning, err = pin.LoadPinner(n.rootsDatastore(), n.DAG, internalDag)
 	if err != nil {
 		// TODO: we should move towards only running 'NewPinner' explicitly on
 		// node init instead of implicitly here as a result of the pinner keys
 		// not being found in the datastore.
 		// this is kinda sketchy and could cause data loss
-		n.Pinning = pin.NewPinner(n.Repo.Datastore(), n.DAG, internalDag)
+		n.Pinning = pin.NewPinner(n.rootsDatastore(), n.DAG, internalDag)
+	}
+	n.Resolver = &resolver.Resolver{
+		DAG:         n.DAG,
+		ResolveOnce: coredag.ResolveOnce,
 	}
-	n.Resolver = resolver.NewBasicResolver(n.DAG)
+	n.PinJobs = NewPinJobs(n.Context())
+	if err := n.setupSearch(internalDag); err != nil {
+		return err
+	}
+	n.FetchQueue = NewFetchQueue(FetchSlots)
 
 	if cfg.Online {
 		if err := n.startLateOnlineServices(ctx); err != nil {
@@ -278,3 +319,38 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 
 	return n.loadFilesRoot()
 }
+
+// setupJournal enables the journal of the pin set and files root updates if
+// Datastore.Journal is set, recovering what a node that didn't shut down
+// cleanly left in it. The roots are checked with the local blocks only.
+func (n *IpfsNode) setupJournal(ctx context.Context, internalDag ipld.DAGService) error {
+	enabled := false
+	if err := repo.ConfigSection(n.Repo, "Datastore.Journal", &enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	n.Journal = journal.New(n.Repo.Datastore())
+	recovered, err := n.Journal.Recover(func(key ds.Key, c cid.Cid) bool {
+		if key == pin.PinDatastoreKey {
+			// the pin set is only usable if all its internal nodes are
+			d := dsync.MutexWrap(ds.NewMapDatastore())
+			if err := d.Put(key, c.Bytes()); err != nil {
+				return false
+			}
+			_, err := pin.LoadPinner(d, internalDag, internalDag)
+			return err == nil
+		}
+		_, err := internalDag.Get(ctx, c)
+		return err == nil
+	})
+	if err != nil {
+		return fmt.Errorf("journal recovery failed: %s", err)
+	}
+	for _, r := range recovered {
+		log.Warningf("journal recovery: %s %s to %s", r.Action, r.Key, r.New)
+	}
+	return nil
+}
@@ -1,28 +1,37 @@
 package commands
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	gopath "path"
+	"path/filepath"
 	"strings"
 
+	car "github.com/ipfs/go-ipfs/car"
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	"github.com/ipfs/go-ipfs/core/coreunix"
-	filestore "github.com/ipfs/go-ipfs/filestore"
+	features "github.com/ipfs/go-ipfs/core/features"
 	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
 	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
 	dagtest "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag/test"
 	blockservice "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
 
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
-	mh "gx/ipfs/QmPnFwZ2JXKnXgMw8CdBPxn7FWh6LLdjUjxV1fKHuJnkr8/go-multihash"
 	pb "gx/ipfs/QmPtj12fdwuAqj9sBSTNUxBNu8kCGNp8b3o8yUzMm5GHpq/pb"
 	cidutil "gx/ipfs/QmQJSeE3CX4zos9qeaG8EhecEK9zvrTEfTG84J8C5NVRwt/go-cidutil"
 	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
 	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
 	files "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit/files"
 	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
 	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
 )
 
@@ -35,6 +44,8 @@ const (
 	silentOptionName      = "silent"
 	progressOptionName    = "progress"
 	trickleOptionName     = "trickle"
+	layoutOptionName      = "layout"
+	manifestOptionName    = "manifest"
 	wrapOptionName        = "wrap-with-directory"
 	stdinPathName         = "stdin-name"
 	hiddenOptionName      = "hidden"
@@ -48,10 +59,22 @@ const (
 	hashOptionName        = "hash"
 	inlineOptionName      = "inline"
 	inlineLimitOptionName = "inline-limit"
+	preserveModeName      = "preserve-mode"
+	preserveMtimeName     = "preserve-mtime"
+	toFilesOptionName     = "to-files"
+	carOutputOptionName   = "car-output"
+	storeOptionName       = "store"
+	deterministicName     = "deterministic"
+	profileOptionName     = "profile"
+	detectMimeOptionName  = "detect-mime"
 )
 
 const adderOutChanSize = 8
 
+// maxInlineLimit is the largest block inlined with --inline. Identity CIDs
+// carry the whole block, so larger ones would make paths and links unwieldy.
+const maxInlineLimit = 127
+
 var AddCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Add a file or directory to ipfs.",
@@ -81,10 +104,14 @@ You can now refer to the added file in a gateway, like so:
 The chunker option, '-s', specifies the chunking strategy that dictates
 how to break files into blocks. Blocks with same content can
 be deduplicated. The default is a fixed block size of
-256 * 1024 bytes, 'size-262144'. Alternatively, you can use the
-rabin chunker for content defined chunking by specifying
-rabin-[min]-[avg]-[max] (where min/avg/max refer to the resulting
-chunk sizes). Using other chunking strategies will produce
+256 * 1024 bytes, 'size-262144', unless another one is set in the
+Import.Chunker config. Alternatively, you can use the rabin or the
+buzhash chunker for content defined chunking by specifying
+rabin-[min]-[avg]-[max] or buzhash-[min]-[avg]-[max] (where min/avg/max
+refer to the resulting chunk sizes), or just 'rabin' or 'buzhash' for
+their default sizes. Content defined chunking keeps most blocks of a file
+unchanged when data is inserted or removed in the middle of it, buzhash
+being faster than rabin. Using other chunking strategies will produce
 different hashes for the same file.
 
   > ipfs add --chunker=size-2048 ipfs-logo.svg
@@ -92,6 +119,12 @@ different hashes for the same file.
   > ipfs add --chunker=rabin-512-1024-2048 ipfs-logo.svg
   added Qmf1hDN65tR55Ubh2RN1FPxr69xq3giVBz1KApsresY8Gn ipfs-logo.svg
 
+The hash function option, '--hash', defaults to sha2-256 unless another one
+is set in the Import.HashFunction config. The sha2, sha3, keccak and
+blake2b-160 to blake2b-512 functions can be used, and imply CIDv1. Hash
+functions that are insecure or too short can't be used, since other nodes
+refuse the blocks hashed with them.
+
 You can now check what blocks have been created by:
 
   > ipfs object links QmafrLBfzRLV4XSH1XcaMMeaXEUhDJjmtDfsYU95TrWG87
@@ -101,6 +134,85 @@ You can now check what blocks have been created by:
   QmY6yj1GsermExDXoosVE3aSPxdMNYr6aKuw3nA8LoWPRS 2059
   QmerURi9k4XzKCaaPbsK6BL5pMEjF7PGphjDvkkjDtsVf3 868
   QmQB28iwSriSUSMqG2nXDTLtdPHgWb4rebBrU7Q1j4vxPv 338
+
+The '--inline' option stores blocks of at most '--inline-limit' bytes in
+their CIDs, using the identity hash. Inlined blocks are never written to the
+repo nor fetched from the network. Whether a block is inlined only depends on
+its size, so the same content added with the same options always gives the
+same CIDs, with '--only-hash' or not.
+
+  > echo "hello" | ipfs add --inline --raw-leaves
+  added z5LkPo4pdsXsBo z5LkPo4pdsXsBo
+
+The '--to-files' option places the result in mfs at the given path, before
+the content can be garbage collected. If the path ends with a '/', the added
+files and directories are put into that directory with their own names,
+otherwise the path is the new name of the single added file or directory:
+
+  > ipfs add -r --to-files=/photos/ holidays
+  > ipfs files ls /photos
+  holidays
+
+The '--car-output' option also writes the added DAG to a CAR file, which can
+be imported on another node. With '--store=false', the blocks are only written
+to the CAR file and not to the repo; they are kept in a temporary file next
+to the CAR file during the add. The path of the CAR file is on the machine
+running the daemon.
+
+  > ipfs add -r --car-output=holidays.car --store=false holidays
+
+The '--layout' option selects how the blocks of files are arranged: 'balanced'
+trees, the default, are fast to seek in, 'trickle' trees are fast to stream
+from the start, which suits audio and video. '--trickle' is the same as
+'--layout=trickle'.
+
+The '--manifest' option reads a JSON file setting the layout and the chunker
+of the added files by path pattern. Patterns without a '/' are matched
+against the file names, the others against their path from the added
+directory. The first rule setting a layout or a chunker wins, the options
+apply to the files no rule matches. The manifest is read by the client, which
+sends its content to the daemon: the 'manifest' argument of the HTTP API holds
+the JSON content, not a path.
+
+  > cat manifest.json
+  {
+    "Rules": [
+      {"Pattern": "*.mp4", "Layout": "trickle", "Chunker": "size-1048576"},
+      {"Pattern": "backups/*", "Chunker": "buzhash"}
+    ]
+  }
+  > ipfs add -r --manifest=manifest.json holidays
+
+The '--deterministic' option adds with the parameters of a named profile,
+making the CIDs only depend on the added files and on the profile: the same
+files added with the same profile give the same CIDs on any node, whatever its
+config, and with any later version of ipfs. A profile sets the CID version,
+the hash function, the chunker, the layout, the use of raw leaves, the number
+of links per node and when directories are sharded. Its name ends with its
+version, a profile never changes once released. The options it sets, and the
+ones recording metadata, can't be used with '--deterministic'. '--profile'
+selects the profile, which is reported on the last line of the output.
+
+  cidv0-1   CIDv0, sha2-256, size-262144, balanced, no raw leaves
+  cidv1-1   CIDv1, sha2-256, size-1048576, balanced, raw leaves (default)
+
+  > ipfs add -r --deterministic --profile=cidv1-1 holidays
+
+The '--preserve-mode' and '--preserve-mtime' options store the permissions
+and modification times of the added files in their unixfs nodes, as in the
+version 1.5 of the unixfs format, which changes their CIDs. The directories
+don't keep them. They are only known when ipfs reads the files itself, not
+when they are sent to a running daemon.
+
+The '--detect-mime' option detects the MIME type of each added file, from its
+extension or else from its first 512 bytes, and wraps the file in a unixfs
+metadata node recording it. The gateway serves such files with the recorded
+Content-Type instead of detecting it on every request, and 'ipfs ls
+--resolve-type' reports it. The CIDs of the files differ from the ones added
+without the option. mfs can't hold metadata nodes, so '--detect-mime' can't be
+used with '--to-files'.
+
+  > ipfs add -r --detect-mime site
 `,
 	},
 
@@ -114,21 +226,54 @@ You can now check what blocks have been created by:
 		cmdkit.BoolOption(silentOptionName, "Write no output."),
 		cmdkit.BoolOption(progressOptionName, "p", "Stream progress data."),
 		cmdkit.BoolOption(trickleOptionName, "t", "Use trickle-dag format for dag generation."),
+		cmdkit.StringOption(layoutOptionName, "DAG layout of the files, balanced or trickle. Default: balanced."),
+		cmdkit.StringOption(manifestOptionName, "JSON file setting the layout and the chunker of the files by path pattern."),
 		cmdkit.BoolOption(onlyHashOptionName, "n", "Only chunk and hash - do not write to disk."),
 		cmdkit.BoolOption(wrapOptionName, "w", "Wrap files with a directory object."),
 		cmdkit.StringOption(stdinPathName, "Assign a name if the file source is stdin."),
 		cmdkit.BoolOption(hiddenOptionName, "H", "Include files that are hidden. Only takes effect on recursive add."),
-		cmdkit.StringOption(chunkerOptionName, "s", "Chunking algorithm, size-[bytes] or rabin-[min]-[avg]-[max]").WithDefault("size-262144"),
+		cmdkit.StringOption(chunkerOptionName, "s", "Chunking algorithm, size-[bytes], rabin-[min]-[avg]-[max] or buzhash-[min]-[avg]-[max]. Default: Import.Chunker or size-262144."),
 		cmdkit.BoolOption(pinOptionName, "Pin this object when adding.").WithDefault(true),
-		cmdkit.BoolOption(rawLeavesOptionName, "Use raw blocks for leaf nodes. (experimental)"),
-		cmdkit.BoolOption(noCopyOptionName, "Add the file using filestore. Implies raw-leaves. (experimental)"),
-		cmdkit.BoolOption(fstoreCacheOptionName, "Check the filestore for pre-existing blocks. (experimental)"),
-		cmdkit.IntOption(cidVersionOptionName, "CID version. Defaults to 0 unless an option that depends on CIDv1 is passed. (experimental)"),
-		cmdkit.StringOption(hashOptionName, "Hash function to use. Implies CIDv1 if not sha2-256. (experimental)").WithDefault("sha2-256"),
-		cmdkit.BoolOption(inlineOptionName, "Inline small blocks into CIDs. (experimental)"),
-		cmdkit.IntOption(inlineLimitOptionName, "Maximum block size to inline. (experimental)").WithDefault(32),
+		cmdkit.BoolOption(rawLeavesOptionName, features.Help(features.RawLeaves, "Use raw blocks for leaf nodes. Default: Import.RawLeaves, or true with CIDv1.")),
+		cmdkit.BoolOption(noCopyOptionName, features.Help(features.Filestore, "Add the file using filestore. Implies raw-leaves.")),
+		cmdkit.BoolOption(fstoreCacheOptionName, features.Help(features.Filestore, "Check the filestore for pre-existing blocks.")),
+		cmdkit.IntOption(cidVersionOptionName, features.Help(features.CidVersion, "CID version. Defaults to Import.CidVersion, or 0 unless an option that depends on CIDv1 is passed.")),
+		cmdkit.StringOption(hashOptionName, features.Help(features.HashFunction, "Hash function to use. Implies CIDv1 if not sha2-256. Default: Import.HashFunction config, or sha2-256.")),
+		cmdkit.BoolOption(inlineOptionName, features.Help(features.InlineBlocks, "Inline small blocks into CIDs.")),
+		cmdkit.IntOption(inlineLimitOptionName, features.Help(features.InlineBlocks, "Maximum block size to inline.")).WithDefault(32),
+		cmdkit.BoolOption(preserveModeName, features.Help(features.FileMetadata, "Store the permissions of added files in their nodes.")),
+		cmdkit.BoolOption(preserveMtimeName, features.Help(features.FileMetadata, "Store the modification time of added files in their nodes.")),
+		cmdkit.StringOption(toFilesOptionName, "Add the result to mfs at the given path. A trailing '/' adds it into that directory."),
+		cmdkit.StringOption(carOutputOptionName, "Write the added DAG to a CAR file at the given path."),
+		cmdkit.BoolOption(storeOptionName, "Store the added blocks in the repo. Use with --car-output.").WithDefault(true),
+		cmdkit.BoolOption(deterministicName, "Use the parameters of a profile, giving the same CIDs on any node."),
+		cmdkit.StringOption(profileOptionName, "Profile of --deterministic. Default: "+coreunix.DefaultProfile+"."),
+		cmdkit.BoolOption(detectMimeOptionName, features.Help(features.MimeDetection, "Record the MIME types of added files in unixfs metadata.")),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// Relative paths are relative to where the command is run, not to
+		// where the daemon was started.
+		if v, ok := req.Options[carOutputOptionName].(string); ok {
+			p, err := filepath.Abs(v)
+			if err != nil {
+				return err
+			}
+			req.Options[carOutputOptionName] = p
+		}
+
+		// The manifest is on the machine of the client, its content is sent
+		// instead of its path.
+		if v, ok := req.Options[manifestOptionName].(string); ok {
+			data, err := ioutil.ReadFile(v)
+			if err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", manifestOptionName, err)
+			}
+			if _, err := coreunix.ParseManifest(strings.NewReader(string(data))); err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", manifestOptionName, err)
+			}
+			req.Options[manifestOptionName] = string(data)
+		}
+
 		quiet, _ := req.Options[quietOptionName].(bool)
 		quieter, _ := req.Options[quieterOptionName].(bool)
 		quiet = quiet || quieter
@@ -153,34 +298,150 @@ You can now check what blocks have been created by:
 			return err
 		}
 
-		cfg, err := n.Repo.Config()
-		if err != nil {
-			return err
-		}
-		// check if repo will exceed storage limit if added
-		// TODO: this doesn't handle the case if the hashed file is already in blocks (deduplicated)
-		// TODO: conditional GC is disabled due to it is somehow not possible to pass the size to the daemon
-		//if err := corerepo.ConditionalGC(req.Context(), n, uint64(size)); err != nil {
-		//	res.SetError(err, cmdkit.ErrNormal)
-		//	return
-		//}
-
 		progress, _ := req.Options[progressOptionName].(bool)
 		trickle, _ := req.Options[trickleOptionName].(bool)
+		layout, layoutSet := req.Options[layoutOptionName].(string)
+		manifestData, manifestSet := req.Options[manifestOptionName].(string)
 		wrap, _ := req.Options[wrapOptionName].(bool)
 		hash, _ := req.Options[onlyHashOptionName].(bool)
 		hidden, _ := req.Options[hiddenOptionName].(bool)
 		silent, _ := req.Options[silentOptionName].(bool)
-		chunker, _ := req.Options[chunkerOptionName].(string)
+		chunker, chunkerSet := req.Options[chunkerOptionName].(string)
 		dopin, _ := req.Options[pinOptionName].(bool)
 		rawblks, rbset := req.Options[rawLeavesOptionName].(bool)
 		nocopy, _ := req.Options[noCopyOptionName].(bool)
 		fscache, _ := req.Options[fstoreCacheOptionName].(bool)
 		cidVer, cidVerSet := req.Options[cidVersionOptionName].(int)
-		hashFunStr, _ := req.Options[hashOptionName].(string)
+		hashFunStr, hashFunSet := req.Options[hashOptionName].(string)
 		inline, _ := req.Options[inlineOptionName].(bool)
 		inlineLimit, _ := req.Options[inlineLimitOptionName].(int)
 		pathName, _ := req.Options[stdinPathName].(string)
+		preserveMode, _ := req.Options[preserveModeName].(bool)
+		preserveMtime, _ := req.Options[preserveMtimeName].(bool)
+		toFiles, toFilesSet := req.Options[toFilesOptionName].(string)
+		carOutput, carSet := req.Options[carOutputOptionName].(string)
+		store, _ := req.Options[storeOptionName].(bool)
+		deterministic, _ := req.Options[deterministicName].(bool)
+		profileName, profileSet := req.Options[profileOptionName].(string)
+		detectMime, _ := req.Options[detectMimeOptionName].(bool)
+
+		if !store {
+			switch {
+			case !carSet:
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s=false requires --%s", storeOptionName, carOutputOptionName)
+			case toFilesSet:
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s=false", toFilesOptionName, storeOptionName)
+			case nocopy:
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s=false", noCopyOptionName, storeOptionName)
+			}
+		}
+
+		if carSet && hash {
+			return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s", carOutputOptionName, onlyHashOptionName)
+		}
+
+		if toFilesSet {
+			if hash {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s", toFilesOptionName, onlyHashOptionName)
+			}
+			if detectMime {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s", toFilesOptionName, detectMimeOptionName)
+			}
+			toFiles, err = checkPath(toFiles)
+			if err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", toFilesOptionName, err)
+			}
+			if err := checkNotMirrored(n, toFiles); err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", toFilesOptionName, err)
+			}
+		}
+
+		var profile coreunix.Profile
+		if profileSet && !deterministic {
+			return cmdkit.Errorf(cmdkit.ErrClient, "--%s requires --%s", profileOptionName, deterministicName)
+		}
+		if deterministic {
+			profile, err = coreunix.LookupProfile(profileName)
+			if err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", profileOptionName, err)
+			}
+			for _, name := range []string{
+				chunkerOptionName, trickleOptionName, layoutOptionName, manifestOptionName,
+				cidVersionOptionName, hashOptionName, rawLeavesOptionName, inlineOptionName,
+				preserveModeName, preserveMtimeName, detectMimeOptionName,
+			} {
+				if _, set := req.Options[name]; set {
+					return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s", name, deterministicName)
+				}
+			}
+			if uio.UseHAMTSharding {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with Experimental.ShardingEnabled", deterministicName)
+			}
+
+			chunker, chunkerSet = profile.Chunker, true
+			layout, layoutSet = profile.Layout, true
+			cidVer, cidVerSet = profile.CidVersion, true
+			hashFunStr, hashFunSet = profile.HashFunction, true
+			rawblks, rbset = profile.RawLeaves, true
+		}
+
+		if !hashFunSet {
+			hashFunStr, err = coreunix.ConfiguredHashFunction(n.Repo)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !cidVerSet {
+			cidVer, _, err = coreunix.ConfiguredCidVersion(n.Repo)
+			if err != nil {
+				return err
+			}
+		}
+		// the config doesn't conflict with --nocopy, which implies raw leaves
+		if !rbset && !nocopy {
+			rawblks, rbset, err = coreunix.ConfiguredRawLeaves(n.Repo)
+			if err != nil {
+				return err
+			}
+		}
+
+		if chunkerSet {
+			if err := coreunix.ValidateChunker(chunker); err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", chunkerOptionName, err)
+			}
+		} else {
+			chunker, err = coreunix.ConfiguredChunker(n.Repo)
+			if err != nil {
+				return err
+			}
+		}
+
+		if layoutSet {
+			if err := coreunix.ValidateLayout(layout); err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", layoutOptionName, err)
+			}
+			if trickle && layout != coreunix.LayoutTrickle {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s can't be used with --%s=%s", trickleOptionName, layoutOptionName, layout)
+			}
+		}
+
+		var manifest *coreunix.Manifest
+		if manifestSet {
+			manifest, err = coreunix.ParseManifest(strings.NewReader(manifestData))
+			if err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", manifestOptionName, err)
+			}
+		}
+
+		// check if repo will exceed storage limit if added
+		// TODO: it is somehow not possible to pass the size to the daemon, so
+		// this only refuses adding to a repo that is already full
+		if !hash {
+			if err := corerepo.CheckStorageQuota(req.Context, n, 0); err != nil {
+				return err
+			}
+		}
 
 		// The arguments are subject to the following constraints.
 		//
@@ -191,8 +452,10 @@ You can now check what blocks have been created by:
 		// NOTE: 'rawblocks -> cidv1' is missing. Legacy reasons.
 
 		// nocopy -> filestoreEnabled
-		if nocopy && !cfg.Experimental.FilestoreEnabled {
-			return cmdkit.Errorf(cmdkit.ErrClient, filestore.ErrFilestoreNotEnabled.Error())
+		if nocopy {
+			if err := features.Require(n.Repo, features.Filestore); err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+			}
 		}
 
 		// nocopy -> rawblocks
@@ -223,15 +486,15 @@ You can now check what blocks have been created by:
 			return err
 		}
 
-		hashFunCode, ok := mh.Names[strings.ToLower(hashFunStr)]
-		if !ok {
-			return fmt.Errorf("unrecognized hash function: %s", strings.ToLower(hashFunStr))
+		hashFunCode, err := coreunix.HashFunctionCode(hashFunStr)
+		if err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
 		}
 
 		prefix.MhType = hashFunCode
 		prefix.MhLength = -1
 
-		if hash {
+		if hash || !store {
 			nilnode, err := core.NewNode(n.Context(), &core.BuildCfg{
 				//TODO: need this to be true or all files
 				// hashed will be stored in memory!
@@ -247,6 +510,9 @@ You can now check what blocks have been created by:
 		if !(fscache || nocopy) {
 			addblockstore = bstore.NewGCBlockstore(n.BaseBlocks, n.GCLocker)
 		}
+		if !hash && store {
+			addblockstore = core.NewQuotaBlockstore(addblockstore, n.Repo)
+		}
 
 		exch := n.Exchange
 		local, _ := req.Options["local"].(bool)
@@ -255,7 +521,15 @@ You can now check what blocks have been created by:
 		}
 
 		bserv := blockservice.New(addblockstore, exch) // hash security 001
-		dserv := dag.NewDAGService(bserv)
+		var dserv ipld.DAGService = dag.NewDAGService(bserv)
+		if !store {
+			spool, err := car.NewSpool(filepath.Dir(carOutput))
+			if err != nil {
+				return err
+			}
+			defer spool.Close()
+			dserv = spool
+		}
 
 		outChan := make(chan interface{}, adderOutChanSize)
 
@@ -269,6 +543,8 @@ You can now check what blocks have been created by:
 		fileAdder.Progress = progress
 		fileAdder.Hidden = hidden
 		fileAdder.Trickle = trickle
+		fileAdder.Layout = layout
+		fileAdder.Manifest = manifest
 		fileAdder.Wrap = wrap
 		fileAdder.Pin = dopin
 		fileAdder.Silent = silent
@@ -276,8 +552,20 @@ You can now check what blocks have been created by:
 		fileAdder.NoCopy = nocopy
 		fileAdder.Name = pathName
 		fileAdder.CidBuilder = prefix
+		fileAdder.Sharding = n.Sharding
+		fileAdder.DetectMime = detectMime
+		if deterministic {
+			fileAdder.Sharding = &profile.Sharding
+			fileAdder.MaxLinks = profile.MaxLinks
+			fileAdder.Profile = profile.Name
+		}
+		fileAdder.PreserveMode = preserveMode
+		fileAdder.PreserveMtime = preserveMtime
 
 		if inline {
+			if inlineLimit < 1 || inlineLimit > maxInlineLimit {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s must be between 1 and %d", inlineLimitOptionName, maxInlineLimit)
+			}
 			fileAdder.CidBuilder = cidutil.InlineBuilder{
 				Builder: fileAdder.CidBuilder,
 				Limit:   inlineLimit,
@@ -298,6 +586,15 @@ You can now check what blocks have been created by:
 		}
 
 		addAllAndPin := func(f files.File) error {
+			var names []string
+
+			if toFilesSet {
+				// Keep gc away until the result is referenced by mfs,
+				// the blocks aren't pinned with --pin=false.
+				defer n.Blockstore.PinLock().Unlock()
+				fileAdder.PinLocked = true
+			}
+
 			// Iterate over each top-level file and add individually. Otherwise the
 			// single files.File f is treated as a directory, affecting hidden file
 			// semantics.
@@ -312,6 +609,7 @@ You can now check what blocks have been created by:
 				if err := fileAdder.AddFile(file); err != nil {
 					return err
 				}
+				names = append(names, file.FileName())
 			}
 
 			// copy intermediary nodes from editor to our actual dagservice
@@ -320,11 +618,47 @@ You can now check what blocks have been created by:
 				return err
 			}
 
-			if hash {
+			if carSet {
+				if err := writeAddCar(req.Context, fileAdder, dserv, carOutput); err != nil {
+					return err
+				}
+			}
+
+			if hash || !store {
 				return nil
 			}
 
-			return fileAdder.PinRoot()
+			if toFilesSet {
+				if err := addToFiles(req.Context, n, dserv, fileAdder, wrap, names, toFiles); err != nil {
+					return err
+				}
+			}
+
+			if err := fileAdder.PinRoot(); err != nil {
+				return err
+			}
+			if n.Search != nil {
+				root, err := fileAdder.RootNode()
+				if err != nil {
+					return err
+				}
+				// the wrapping directory has no name, the single files and
+				// directories are named after what was added
+				var name string
+				if !wrap && len(names) == 1 {
+					name = gopath.Base(names[0])
+				}
+				n.Search.Add(root.Cid(), name)
+			}
+			// --local adds don't announce the blocks
+			if dopin && !local {
+				root, err := fileAdder.RootNode()
+				if err != nil {
+					return err
+				}
+				n.ProvideNewPin(root.Cid())
+			}
+			return nil
 		}
 
 		errCh := make(chan error)
@@ -388,6 +722,7 @@ You can now check what blocks have been created by:
 
 				lastFile := ""
 				lastHash := ""
+				profile := ""
 				var totalProgress, prevFiles, lastBytes int64
 
 			LOOP:
@@ -397,6 +732,8 @@ You can now check what blocks have been created by:
 						if !ok {
 							if quieter {
 								fmt.Fprintln(os.Stdout, lastHash)
+							} else if !quiet && profile != "" {
+								fmt.Fprintf(os.Stdout, "profile %s\n", profile)
 							}
 
 							break LOOP
@@ -404,6 +741,7 @@ You can now check what blocks have been created by:
 						output := out.(*coreunix.AddedObject)
 						if len(output.Hash) > 0 {
 							lastHash = output.Hash
+							profile = output.Profile
 							if quieter {
 								continue
 							}
@@ -483,3 +821,101 @@ You can now check what blocks have been created by:
 	},
 	Type: coreunix.AddedObject{},
 }
+
+// addToFiles puts the result of an add in mfs at dst. names are the names
+// of the top-level files that were added.
+func addToFiles(ctx context.Context, n *core.IpfsNode, dserv ipld.DAGService, adder *coreunix.Adder, wrap bool, names []string, dst string) error {
+	root, err := adder.RootNode()
+	if err != nil {
+		return err
+	}
+
+	intoDir := strings.HasSuffix(dst, "/")
+	dst = gopath.Clean(dst)
+
+	added := map[string]ipld.Node{}
+	switch {
+	case wrap || len(names) == 1 && !intoDir:
+		added[dst] = root
+	case len(names) == 1:
+		added[gopath.Join(dst, names[0])] = root
+	case !intoDir:
+		return cmdkit.Errorf(cmdkit.ErrClient, "adding several files with --%s requires a directory path ending with '/'", toFilesOptionName)
+	default:
+		// Without wrapping, the root of several files is the directory
+		// the adder built them in.
+		for _, l := range root.Links() {
+			child, err := l.GetNode(ctx, dserv)
+			if err != nil {
+				return err
+			}
+			added[gopath.Join(dst, l.Name)] = child
+		}
+	}
+
+	flush := !n.FilesWriteBack.Enabled()
+	for p, nd := range added {
+		if err := putFilesNode(n, p, nd); err != nil {
+			return err
+		}
+		if err := reshardFiles(ctx, n, false, gopath.Dir(p)); err != nil {
+			return err
+		}
+
+		if flush {
+			err = flushFiles(n, p)
+		} else {
+			err = n.FilesWriteBack.RecordPut(p, nd)
+		}
+		if err != nil {
+			return err
+		}
+		notifyFiles(n, "add", p, "", flush)
+	}
+	return nil
+}
+
+// putFilesNode puts nd at the mfs path p, creating its parent directories.
+func putFilesNode(n *core.IpfsNode, p string, nd ipld.Node) error {
+	root, unlock := n.LockFilesRoot()
+	defer unlock()
+
+	if dir := gopath.Dir(p); dir != "/" {
+		err := mfs.Mkdir(root, dir, mfs.MkdirOpts{Mkparents: true})
+		if err != nil {
+			return err
+		}
+	}
+	if err := mfs.PutNode(root, p, nd); err != nil {
+		return fmt.Errorf("cannot add %s to mfs: %s", p, err)
+	}
+	return nil
+}
+
+// writeAddCar writes the DAG added by adder to a CAR file at path. The file
+// only appears once it is complete.
+func writeAddCar(ctx context.Context, adder *coreunix.Adder, ng ipld.NodeGetter, path string) error {
+	root, err := adder.RootNode()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = car.WriteDAG(ctx, ng, []cid.Cid{root.Cid()}, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %s", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -2,13 +2,16 @@ package commands
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"text/tabwriter"
 
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	lgc "github.com/ipfs/go-ipfs/commands/legacy"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	features "github.com/ipfs/go-ipfs/core/features"
 	bitswap "gx/ipfs/QmUyaGN3WPr3CTLai7DBvMikagK45V4fUi8p8cNRaJQoU1/go-bitswap"
 	decision "gx/ipfs/QmUyaGN3WPr3CTLai7DBvMikagK45V4fUi8p8cNRaJQoU1/go-bitswap/decision"
 
@@ -29,6 +32,73 @@ var BitswapCmd = &cmds.Command{
 		"wantlist":  lgc.NewCommand(showWantlistCmd),
 		"ledger":    lgc.NewCommand(ledgerCmd),
 		"reprovide": lgc.NewCommand(reprovideCmd),
+		"sessions":  bitswapSessionsCmd,
+	},
+}
+
+// BitswapPeerRank is the rank of a peer for bitswap sessions.
+type BitswapPeerRank struct {
+	Peer         string
+	Latency      string
+	DeliveryRate float64
+	Score        float64
+}
+
+type BitswapPeerRanking struct {
+	Peers []BitswapPeerRank
+}
+
+var bitswapSessionsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show how peers are ranked for bitswap sessions.",
+		ShortDescription: `
+With the bitswap-peer-ranking experimental feature enabled, bitswap sessions
+are handed the slow providers of the blocks they fetch only when not enough
+faster ones are found. Peers are ranked
+by their measured latency and by how many bytes per second they delivered
+recently. This command lists the connected peers from best to worst.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.OnlineMode() {
+			return cmdkit.Errorf(cmdkit.ErrClient, ErrNotOnline.Error())
+		}
+
+		if nd.PeerRank == nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, features.DisabledError(features.BitswapPeerRanking).Error())
+		}
+
+		out := &BitswapPeerRanking{Peers: []BitswapPeerRank{}}
+		for _, st := range nd.PeerRank.Ranking() {
+			out.Peers = append(out.Peers, BitswapPeerRank{
+				Peer:         st.Peer.Pretty(),
+				Latency:      st.Latency.String(),
+				DeliveryRate: st.DeliveryRate,
+				Score:        st.Score,
+			})
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: BitswapPeerRanking{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*BitswapPeerRanking)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			fmt.Fprintln(tw, "PEER\tLATENCY\tRATE\tSCORE")
+			for _, p := range out.Peers {
+				fmt.Fprintf(tw, "%s\t%s\t%s/s\t%.2f\n", p.Peer, p.Latency, humanize.Bytes(uint64(p.DeliveryRate)), p.Score)
+			}
+			return tw.Flush()
+		}),
 	},
 }
 
@@ -225,6 +295,11 @@ Trigger reprovider to announce our data to network.
 			return
 		}
 
+		if nd.Reprovider == nil {
+			res.SetError(errors.New("light clients don't reprovide"), cmdkit.ErrClient)
+			return
+		}
+
 		err = nd.Reprovider.Trigger(req.Context())
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
@@ -1,9 +1,13 @@
 package commands
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 
 	util "github.com/ipfs/go-ipfs/blocks/blockstoreutil"
@@ -11,7 +15,10 @@ import (
 	e "github.com/ipfs/go-ipfs/core/commands/e"
 	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
 	"github.com/ipfs/go-ipfs/core/coreapi/interface/options"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
 
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
 	mh "gx/ipfs/QmPnFwZ2JXKnXgMw8CdBPxn7FWh6LLdjUjxV1fKHuJnkr8/go-multihash"
 	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
@@ -41,6 +48,7 @@ multihash.
 		"get":  blockGetCmd,
 		"put":  blockPutCmd,
 		"rm":   blockRmCmd,
+		"ls":   blockLsCmd,
 	},
 }
 
@@ -135,16 +143,37 @@ It reads from stdin, and <key> is a base58 encoded multihash.
 
 By default CIDv0 is going to be generated. Setting 'mhtype' to anything other
 than 'sha2-256' or format to anything other than 'v0' will result in CIDv1.
+The default 'mhtype' is read from the Import.HashFunction config.
+`,
+		LongDescription: `
+'ipfs block put' is a plumbing command for storing raw IPFS blocks.
+It reads from stdin, and <key> is a base58 encoded multihash.
+
+By default CIDv0 is going to be generated. Setting 'mhtype' to anything other
+than 'sha2-256' or format to anything other than 'v0' will result in CIDv1.
+The default 'mhtype' is read from the Import.HashFunction config.
+
+With '--batch', each of the given files is stored as a block, and the keys
+are listed in the same order. With '--length-prefixed', each input holds
+several blocks, each preceded by its length encoded as an unsigned varint.
+The blocks are written to the datastore in batches of up to 16MiB, a batch
+being written at once, which is much faster than putting the blocks one at a
+time:
+
+  > ipfs block put --batch block1 block2 block3
+  > cat blocks.bin | ipfs block put --length-prefixed
 `,
 	},
 
 	Arguments: []cmdkit.Argument{
-		cmdkit.FileArg("data", true, false, "The data to be stored as an IPFS block.").EnableStdin(),
+		cmdkit.FileArg("data", true, true, "The data to be stored as an IPFS block.").EnableStdin(),
 	},
 	Options: []cmdkit.Option{
 		cmdkit.StringOption("format", "f", "cid format for blocks to be created with."),
-		cmdkit.StringOption("mhtype", "multihash hash function").WithDefault("sha2-256"),
+		cmdkit.StringOption("mhtype", "multihash hash function. Default: Import.HashFunction or sha2-256."),
 		cmdkit.IntOption("mhlen", "multihash hash length").WithDefault(-1),
+		cmdkit.BoolOption(blockBatchOptionName, "Store each input as a block, writing them in batches."),
+		cmdkit.BoolOption(blockLengthPrefixedOptionName, "Read several blocks from each input, each prefixed by its length as a varint. Implies --batch."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env)
@@ -152,12 +181,20 @@ than 'sha2-256' or format to anything other than 'v0' will result in CIDv1.
 			return err
 		}
 
-		file, err := req.Files.NextFile()
-		if err != nil {
-			return err
-		}
+		batch, _ := req.Options[blockBatchOptionName].(bool)
+		prefixed, _ := req.Options[blockLengthPrefixedOptionName].(bool)
 
-		mhtype, _ := req.Options["mhtype"].(string)
+		mhtype, mhtypeSet := req.Options["mhtype"].(string)
+		if !mhtypeSet {
+			n, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			mhtype, err = coreunix.ConfiguredHashFunction(n.Repo)
+			if err != nil {
+				return err
+			}
+		}
 		mhtval, ok := mh.Names[mhtype]
 		if !ok {
 			return fmt.Errorf("unrecognized multihash function: %s", mhtype)
@@ -177,11 +214,26 @@ than 'sha2-256' or format to anything other than 'v0' will result in CIDv1.
 			}
 		}
 
-		p, err := api.Block().Put(req.Context, file, options.Block.Hash(mhtval, mhlen), options.Block.Format(format))
+		putOpts := []options.BlockPutOption{options.Block.Hash(mhtval, mhlen), options.Block.Format(format)}
+		if batch || prefixed {
+			return putBlockBatches(req, res, api, prefixed, putOpts)
+		}
+
+		file, err := req.Files.NextFile()
 		if err != nil {
 			return err
 		}
 
+		p, err := api.Block().Put(req.Context, file, putOpts...)
+		if err != nil {
+			return err
+		}
+
+		// checked once the input is read: over HTTP, the next file replaces it
+		if _, err := req.Files.NextFile(); err != io.EOF {
+			return cmdkit.Errorf(cmdkit.ErrClient, "several inputs given, use --%s to put several blocks", blockBatchOptionName)
+		}
+
 		return cmds.EmitOnce(res, &BlockStat{
 			Key:  p.Path().Cid().String(),
 			Size: p.Size(),
@@ -200,31 +252,157 @@ than 'sha2-256' or format to anything other than 'v0' will result in CIDv1.
 	Type: BlockStat{},
 }
 
+const (
+	blockBatchOptionName          = "batch"
+	blockLengthPrefixedOptionName = "length-prefixed"
+)
+
+// blockBatchSize is the amount of block data written to the datastore at once
+// by 'ipfs block put --batch'.
+const blockBatchSize = 16 << 20
+
+// maxPrefixedBlockSize bounds the length of the blocks read with
+// --length-prefixed, so that a corrupt length doesn't exhaust the memory.
+const maxPrefixedBlockSize = 32 << 20
+
+// putBlockBatches stores the blocks of all the inputs of req, in batches.
+func putBlockBatches(req *cmds.Request, res cmds.ResponseEmitter, api coreiface.CoreAPI, prefixed bool, opts []options.BlockPutOption) error {
+	var batch []io.Reader
+	var size int
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		stats, err := api.Block().PutMany(req.Context, batch, opts...)
+		if err != nil {
+			return err
+		}
+		for _, s := range stats {
+			if err := res.Emit(&BlockStat{Key: s.Path().Cid().String(), Size: s.Size()}); err != nil {
+				return err
+			}
+		}
+		batch, size = nil, 0
+		return nil
+	}
+
+	add := func(data []byte) error {
+		batch = append(batch, bytes.NewReader(data))
+		size += len(data)
+		if size >= blockBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	for {
+		file, err := req.Files.NextFile()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = readBlocks(file, prefixed, add)
+		file.Close()
+		if err != nil && file.FileName() != "" {
+			return fmt.Errorf("%s: %s", file.FileName(), err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// readBlocks calls add with the data of each block of r: all of it, or each
+// length-prefixed block if prefixed.
+func readBlocks(r io.Reader, prefixed bool, add func([]byte) error) error {
+	if !prefixed {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return add(data)
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if size > maxPrefixedBlockSize {
+			return fmt.Errorf("block length %d larger than %d", size, maxPrefixedBlockSize)
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if err := add(data); err != nil {
+			return err
+		}
+	}
+}
+
 var blockRmCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Remove IPFS block(s).",
 		ShortDescription: `
 'ipfs block rm' is a plumbing command for removing raw ipfs blocks.
 It takes a list of base58 encoded multihashes to remove.
+`,
+		LongDescription: `
+'ipfs block rm' is a plumbing command for removing raw ipfs blocks.
+It takes a list of base58 encoded multihashes to remove, as arguments or one
+per line on stdin.
+
+The pinset and the files API root are checked once for all the blocks before
+any is removed. Pinned blocks and blocks of the files API root are not
+removed, and make the command fail. With '--force-unpinned-only', they are
+reported as skipped instead, and only the other blocks are removed:
+
+  > ipfs refs local | ipfs block rm --force-unpinned-only
 `,
 	},
 	Arguments: []cmdkit.Argument{
-		cmdkit.StringArg("hash", true, true, "Bash58 encoded multihash of block(s) to remove."),
+		cmdkit.StringArg("hash", true, true, "Bash58 encoded multihash of block(s) to remove.").EnableStdin(),
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("force", "f", "Ignore nonexistent blocks."),
 		cmdkit.BoolOption("quiet", "q", "Write minimal output."),
+		cmdkit.BoolOption("force-unpinned-only", "Skip the pinned blocks and the blocks of the files API root instead of failing. Implies --force."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
 		api, err := cmdenv.GetApi(env)
 		if err != nil {
 			return err
 		}
 
+		err = req.ParseBodyArgs()
+		if err != nil {
+			return err
+		}
+
 		force, _ := req.Options["force"].(bool)
 		quiet, _ := req.Options["quiet"].(bool)
+		unpinnedOnly, _ := req.Options["force-unpinned-only"].(bool)
 
-		// TODO: use batching coreapi when done
+		cids := make([]cid.Cid, 0, len(req.Arguments))
 		for _, b := range req.Arguments {
 			p, err := coreiface.ParsePath(b)
 			if err != nil {
@@ -235,28 +413,29 @@ It takes a list of base58 encoded multihashes to remove.
 			if err != nil {
 				return err
 			}
+			cids = append(cids, rp.Cid())
+		}
 
-			err = api.Block().Rm(req.Context, rp, options.Block.Force(force))
-			if err != nil {
-				err := res.Emit(&util.RemovedBlock{
-					Hash:  rp.Cid().String(),
-					Error: err.Error(),
-				})
-				if err != nil {
-					return err
-				}
-			}
+		protected, err := corerepo.FilesBlocks(req.Context, n)
+		if err != nil {
+			return err
+		}
 
-			if !quiet {
-				err := res.Emit(&util.RemovedBlock{
-					Hash: rp.Cid().String(),
-				})
-				if err != nil {
-					return err
-				}
-			}
+		out, err := util.RmBlocks(n.Blockstore, n.Pinning, cids, util.RmBlocksOpts{
+			Quiet:         quiet,
+			Force:         force || unpinnedOnly,
+			Protected:     protected,
+			SkipProtected: unpinnedOnly,
+		})
+		if err != nil {
+			return err
 		}
 
+		for r := range out {
+			if err := res.Emit(r); err != nil {
+				return err
+			}
+		}
 		return nil
 	},
 	PostRun: cmds.PostRunMap{
@@ -266,3 +445,85 @@ It takes a list of base58 encoded multihashes to remove.
 	},
 	Type: util.RemovedBlock{},
 }
+
+// errBlockstoreChanged is returned when the blocks of a resume token of
+// 'ipfs block ls' are not at their position anymore.
+var errBlockstoreChanged = errors.New("the blockstore changed since the resume token, the listing can't be resumed")
+
+var blockLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the blocks of the local blockstore.",
+		ShortDescription: `
+'ipfs block ls' is a plumbing command listing the keys of the blocks of the
+local blockstore, in the order of the blockstore.
+`,
+		LongDescription: `
+'ipfs block ls' is a plumbing command listing the keys of the blocks of the
+local blockstore, in the order of the blockstore.
+
+Each block of the JSON output, '--enc=json', has a resume token. An
+interrupted listing resumes after a block with '--resume=<token>', without
+writing the blocks before it again. The blockstore is still read from the
+start, and the listing fails if its order changed since the token.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("resume", "Resume the listing after the block of this resume token."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		var resume resumeToken
+		if tok, _ := req.Options["resume"].(string); tok != "" {
+			resume, err = parseResumeToken(tok, "block/ls")
+			if err != nil {
+				return err
+			}
+		}
+
+		keys, err := n.Blockstore.AllKeysChan(req.Context)
+		if err != nil {
+			return err
+		}
+
+		var count uint64
+		for k := range keys {
+			count++
+			if count < resume.Count {
+				continue
+			}
+			if count == resume.Count {
+				if k.String() != resume.Key {
+					return errBlockstoreChanged
+				}
+				continue
+			}
+
+			err := res.Emit(&RefWrapper{
+				Ref:    k.String(),
+				Resume: resumeToken{Cmd: "block/ls", Count: count, Key: k.String()}.String(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if count < resume.Count {
+			return errBlockstoreChanged
+		}
+		return req.Context.Err()
+	},
+	Type: RefWrapper{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			r, ok := v.(*RefWrapper)
+			if !ok {
+				return e.TypeErr(r, v)
+			}
+			_, err := fmt.Fprintln(w, r.Ref)
+			return err
+		}),
+	},
+}
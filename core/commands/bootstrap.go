@@ -3,11 +3,14 @@ package commands
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"os"
 	"sort"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	features "github.com/ipfs/go-ipfs/core/features"
 	repo "github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
 
@@ -17,6 +20,9 @@ import (
 
 type BootstrapOutput struct {
 	Peers []string
+
+	// Warning is set when a deprecated option was used.
+	Warning string `json:",omitempty"`
 }
 
 var peerOptionDesc = "A peer to add to the bootstrap list (in the format '<multiaddr>/<peerID>')"
@@ -53,7 +59,7 @@ in the bootstrap list).
 	},
 
 	Options: []cmdkit.Option{
-		cmdkit.BoolOption("default", "Add default bootstrap nodes. (Deprecated, use 'default' subcommand instead)"),
+		cmdkit.BoolOption("default", features.Help(features.BootstrapAddDefaultFlag, "Add default bootstrap nodes.")),
 	},
 	Subcommands: map[string]*cmds.Command{
 		"default": bootstrapAddDefaultCmd,
@@ -67,7 +73,10 @@ in the bootstrap list).
 		}
 
 		var inputPeers []config.BootstrapPeer
+		var warning string
 		if deflt {
+			warning = features.WarnDeprecated(features.BootstrapAddDefaultFlag)
+
 			// parse separately for meaningful, correct error.
 			defltPeers, err := config.DefaultBootstrapPeers()
 			if err != nil {
@@ -109,7 +118,7 @@ in the bootstrap list).
 			return
 		}
 
-		res.SetOutput(&BootstrapOutput{config.BootstrapPeerStrings(added)})
+		res.SetOutput(&BootstrapOutput{Peers: config.BootstrapPeerStrings(added), Warning: warning})
 	},
 	Type: BootstrapOutput{},
 	Marshalers: cmds.MarshalerMap{
@@ -123,6 +132,7 @@ in the bootstrap list).
 			if !ok {
 				return nil, e.TypeErr(out, v)
 			}
+			bootstrapWriteWarning(out)
 
 			buf := new(bytes.Buffer)
 			if err := bootstrapWritePeers(buf, "added ", out.Peers); err != nil {
@@ -166,7 +176,7 @@ in the bootstrap list).`,
 			return
 		}
 
-		res.SetOutput(&BootstrapOutput{config.BootstrapPeerStrings(added)})
+		res.SetOutput(&BootstrapOutput{Peers: config.BootstrapPeerStrings(added)})
 	},
 	Type: BootstrapOutput{},
 	Marshalers: cmds.MarshalerMap{
@@ -202,7 +212,7 @@ var bootstrapRemoveCmd = &cmds.Command{
 		cmdkit.StringArg("peer", false, true, peerOptionDesc).EnableStdin(),
 	},
 	Options: []cmdkit.Option{
-		cmdkit.BoolOption("all", "Remove all bootstrap peers. (Deprecated, use 'all' subcommand)"),
+		cmdkit.BoolOption("all", features.Help(features.BootstrapRmAllFlag, "Remove all bootstrap peers.")),
 	},
 	Subcommands: map[string]*cmds.Command{
 		"all": bootstrapRemoveAllCmd,
@@ -227,7 +237,9 @@ var bootstrapRemoveCmd = &cmds.Command{
 		}
 
 		var removed []config.BootstrapPeer
+		var warning string
 		if all {
+			warning = features.WarnDeprecated(features.BootstrapRmAllFlag)
 			removed, err = bootstrapRemoveAll(r, cfg)
 		} else {
 			input, perr := config.ParseBootstrapPeers(req.Arguments())
@@ -243,7 +255,7 @@ var bootstrapRemoveCmd = &cmds.Command{
 			return
 		}
 
-		res.SetOutput(&BootstrapOutput{config.BootstrapPeerStrings(removed)})
+		res.SetOutput(&BootstrapOutput{Peers: config.BootstrapPeerStrings(removed), Warning: warning})
 	},
 	Type: BootstrapOutput{},
 	Marshalers: cmds.MarshalerMap{
@@ -257,6 +269,7 @@ var bootstrapRemoveCmd = &cmds.Command{
 			if !ok {
 				return nil, e.TypeErr(out, v)
 			}
+			bootstrapWriteWarning(out)
 
 			buf := new(bytes.Buffer)
 			err = bootstrapWritePeers(buf, "removed ", out.Peers)
@@ -290,7 +303,7 @@ var bootstrapRemoveAllCmd = &cmds.Command{
 			return
 		}
 
-		res.SetOutput(&BootstrapOutput{config.BootstrapPeerStrings(removed)})
+		res.SetOutput(&BootstrapOutput{Peers: config.BootstrapPeerStrings(removed)})
 	},
 	Type: BootstrapOutput{},
 	Marshalers: cmds.MarshalerMap{
@@ -336,7 +349,7 @@ var bootstrapListCmd = &cmds.Command{
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
-		res.SetOutput(&BootstrapOutput{config.BootstrapPeerStrings(peers)})
+		res.SetOutput(&BootstrapOutput{Peers: config.BootstrapPeerStrings(peers)})
 	},
 	Type: BootstrapOutput{},
 	Marshalers: cmds.MarshalerMap{
@@ -360,6 +373,14 @@ func bootstrapMarshaler(res cmds.Response) (io.Reader, error) {
 	return buf, err
 }
 
+// bootstrapWriteWarning shows the warning of out on stderr, keeping the list
+// of peers clean.
+func bootstrapWriteWarning(out *BootstrapOutput) {
+	if out.Warning != "" {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", out.Warning)
+	}
+}
+
 func bootstrapWritePeers(w io.Writer, prefix string, peers []string) error {
 
 	sort.Stable(sort.StringSlice(peers))
@@ -6,6 +6,7 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"sort"
@@ -46,15 +47,39 @@ type Command struct {
 	Subcommands []Command
 	Options     []Option
 
+	// set with --verbose
+	Tagline          string     `json:",omitempty"`
+	ShortDescription string     `json:",omitempty"`
+	LongDescription  string     `json:",omitempty"`
+	Synopsis         string     `json:",omitempty"`
+	Arguments        []Argument `json:",omitempty"`
+
 	showOpts bool
 }
 
 type Option struct {
 	Names []string
+
+	// set with --verbose
+	Type        string      `json:",omitempty"`
+	Default     interface{} `json:",omitempty"`
+	Description string      `json:",omitempty"`
+}
+
+type Argument struct {
+	Name        string
+	Type        string
+	Required    bool
+	Variadic    bool
+	Stdin       bool
+	Recursive   bool
+	Description string
 }
 
 const (
-	flagsOptionName = "flags"
+	flagsOptionName   = "flags"
+	verboseOptionName = "verbose"
+	formatOptionName  = "format"
 )
 
 // CommandsCmd takes in a root command,
@@ -62,19 +87,40 @@ const (
 func CommandsCmd(root *cmds.Command) *cmds.Command {
 	return &cmds.Command{
 		Helptext: cmdkit.HelpText{
-			Tagline:          "List all available commands.",
-			ShortDescription: `Lists all available commands (and subcommands) and exits.`,
+			Tagline: "List all available commands.",
+			ShortDescription: `Lists all available commands (and subcommands) and exits.
+
+With --format=json the command tree is written as JSON, and --verbose adds the
+arguments, the option types and defaults, and the help text of every command,
+for tools generating clients or completions.
+`,
 		},
 		Options: []cmdkit.Option{
 			cmdkit.BoolOption(flagsOptionName, "f", "Show command flags"),
+			cmdkit.BoolOption(verboseOptionName, "v", "Include arguments, option types and defaults, and help text"),
+			cmdkit.StringOption(formatOptionName, "Output format, 'text' or 'json'").WithDefault("text"),
 		},
 		Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
-			rootCmd := cmd2outputCmd("ipfs", root)
+			switch format, _ := req.Options[formatOptionName].(string); format {
+			case "text", "json":
+			default:
+				return fmt.Errorf("unknown format %q, expected 'text' or 'json'", format)
+			}
+
+			verbose, _ := req.Options[verboseOptionName].(bool)
+			rootCmd := cmd2outputCmd("ipfs", root, verbose)
 			rootCmd.showOpts, _ = req.Options[flagsOptionName].(bool)
 			return cmds.EmitOnce(res, &rootCmd)
 		},
 		Encoders: cmds.EncoderMap{
 			cmds.Text: func(req *cmds.Request) func(io.Writer) cmds.Encoder {
+				if format, _ := req.Options[formatOptionName].(string); format == "json" {
+					return func(w io.Writer) cmds.Encoder {
+						enc := json.NewEncoder(w)
+						enc.SetIndent("", "  ")
+						return enc
+					}
+				}
 				return func(w io.Writer) cmds.Encoder { return &commandEncoder{w} }
 			},
 		},
@@ -82,10 +128,15 @@ func CommandsCmd(root *cmds.Command) *cmds.Command {
 	}
 }
 
-func cmd2outputCmd(name string, cmd *cmds.Command) Command {
+func cmd2outputCmd(name string, cmd *cmds.Command, verbose bool) Command {
 	opts := make([]Option, len(cmd.Options))
 	for i, opt := range cmd.Options {
-		opts[i] = Option{opt.Names()}
+		opts[i] = Option{Names: opt.Names()}
+		if verbose {
+			opts[i].Type = opt.Type().String()
+			opts[i].Default = opt.Default()
+			opts[i].Description = opt.Description()
+		}
 	}
 
 	output := Command{
@@ -94,8 +145,35 @@ func cmd2outputCmd(name string, cmd *cmds.Command) Command {
 		Options:     opts,
 	}
 
-	for name, sub := range cmd.Subcommands {
-		output.Subcommands = append(output.Subcommands, cmd2outputCmd(name, sub))
+	if verbose {
+		output.Tagline = cmd.Helptext.Tagline
+		output.ShortDescription = cmd.Helptext.ShortDescription
+		output.LongDescription = cmd.Helptext.LongDescription
+		output.Synopsis = cmd.Helptext.Synopsis
+		for _, arg := range cmd.Arguments {
+			typ := "string"
+			if arg.Type == cmdkit.ArgFile {
+				typ = "file"
+			}
+			output.Arguments = append(output.Arguments, Argument{
+				Name:        arg.Name,
+				Type:        typ,
+				Required:    arg.Required,
+				Variadic:    arg.Variadic,
+				Stdin:       arg.SupportsStdin,
+				Recursive:   arg.Recursive,
+				Description: arg.Description,
+			})
+		}
+	}
+
+	names := make([]string, 0, len(cmd.Subcommands))
+	for name := range cmd.Subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		output.Subcommands = append(output.Subcommands, cmd2outputCmd(name, cmd.Subcommands[name], verbose))
 	}
 
 	return output
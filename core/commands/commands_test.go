@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
 )
 
 func collectPaths(prefix string, cmd *cmds.Command, out map[string]struct{}) {
@@ -25,6 +26,7 @@ func TestROCommands(t *testing.T) {
 		"/dag",
 		"/dag/get",
 		"/dag/resolve",
+		"/dag/stat",
 		"/dns",
 		"/get",
 		"/ls",
@@ -72,10 +74,12 @@ func TestCommands(t *testing.T) {
 		"/bitswap",
 		"/bitswap/ledger",
 		"/bitswap/reprovide",
+		"/bitswap/sessions",
 		"/bitswap/stat",
 		"/bitswap/wantlist",
 		"/block",
 		"/block/get",
+		"/block/ls",
 		"/block/put",
 		"/block/rm",
 		"/block/stat",
@@ -97,10 +101,15 @@ func TestCommands(t *testing.T) {
 		"/dag/get",
 		"/dag/put",
 		"/dag/resolve",
+		"/dag/stat",
 		"/dht",
+		"/dht/bulk",
+		"/dht/bulk/get",
+		"/dht/bulk/put",
 		"/dht/findpeer",
 		"/dht/findprovs",
 		"/dht/get",
+		"/dht/mode",
 		"/dht/provide",
 		"/dht/put",
 		"/dht/query",
@@ -108,24 +117,39 @@ func TestCommands(t *testing.T) {
 		"/diag/cmds",
 		"/diag/cmds/clear",
 		"/diag/cmds/set-time",
+		"/diag/largest",
 		"/diag/sys",
 		"/dns",
+		"/features",
+		"/features/disable",
+		"/features/enable",
+		"/features/ls",
 		"/file",
 		"/file/ls",
 		"/files",
 		"/files/chcid",
+		"/files/chmod",
 		"/files/cp",
+		"/files/export",
+		"/files/export-car",
 		"/files/flush",
 		"/files/ls",
+		"/files/mirror",
+		"/files/mirror/start",
+		"/files/mirror/status",
+		"/files/mirror/stop",
 		"/files/mkdir",
 		"/files/mv",
 		"/files/read",
 		"/files/rm",
 		"/files/stat",
+		"/files/sync",
 		"/filestore",
 		"/filestore/dups",
 		"/filestore/ls",
 		"/filestore/verify",
+		"/files/touch",
+		"/files/watch",
 		"/files/write",
 		"/get",
 		"/id",
@@ -134,9 +158,11 @@ func TestCommands(t *testing.T) {
 		"/key/list",
 		"/key/rename",
 		"/key/rm",
+		"/key/use",
 		"/log",
 		"/log/level",
 		"/log/ls",
+		"/log/status",
 		"/log/tail",
 		"/ls",
 		"/mount",
@@ -172,9 +198,13 @@ func TestCommands(t *testing.T) {
 		"/pin/add",
 		"/ping",
 		"/pin/ls",
+		"/pin/reconcile",
 		"/pin/rm",
+		"/pin/status",
 		"/pin/update",
 		"/pin/verify",
+		"/provide",
+		"/provide/stat",
 		"/pubsub",
 		"/pubsub/ls",
 		"/pubsub/peers",
@@ -183,31 +213,72 @@ func TestCommands(t *testing.T) {
 		"/refs",
 		"/refs/local",
 		"/repo",
+		"/repo/compress",
 		"/repo/fsck",
 		"/repo/gc",
+		"/repo/recover",
+		"/repo/repair",
 		"/repo/stat",
 		"/repo/verify",
 		"/repo/version",
 		"/resolve",
+		"/routing",
+		"/routing/static",
+		"/routing/static/add",
+		"/routing/static/ls",
+		"/routing/static/rm",
+		"/search",
 		"/shutdown",
 		"/stats",
+		"/stats/availability",
 		"/stats/bitswap",
 		"/stats/bw",
+		"/stats/dcutr",
+		"/stats/dht",
+		"/stats/pex",
 		"/stats/repo",
+		"/stats/serve",
 		"/swarm",
 		"/swarm/addrs",
 		"/swarm/addrs/listen",
 		"/swarm/addrs/local",
+		"/swarm/backoff",
+		"/swarm/backoff/clear",
+		"/swarm/backoff/ls",
 		"/swarm/connect",
 		"/swarm/disconnect",
+		"/swarm/discovery",
 		"/swarm/filters",
 		"/swarm/filters/add",
+		"/swarm/filters/ls",
 		"/swarm/filters/rm",
+		"/swarm/key",
+		"/swarm/key/gen",
+		"/swarm/key/rotate",
+		"/swarm/key/show",
+		"/swarm/limit",
+		"/swarm/peering",
+		"/swarm/peering/add",
+		"/swarm/peering/ls",
+		"/swarm/peering/rm",
 		"/swarm/peers",
+		"/swarm/qos",
+		"/swarm/relay",
+		"/swarm/rendezvous",
+		"/swarm/rendezvous/discover",
+		"/swarm/rendezvous/register",
+		"/swarm/reputation",
+		"/swarm/stats",
 		"/tar",
 		"/tar/add",
 		"/tar/cat",
 		"/update",
+		"/upload",
+		"/upload/append",
+		"/upload/ls",
+		"/upload/new",
+		"/upload/rm",
+		"/upload/status",
 		"/urlstore",
 		"/urlstore/add",
 		"/version",
@@ -239,3 +310,42 @@ func TestCommands(t *testing.T) {
 		}
 	}
 }
+
+func TestCommandsVerbose(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"b": {},
+			"a": {
+				Helptext: cmdkit.HelpText{Tagline: "Do a."},
+				Arguments: []cmdkit.Argument{
+					cmdkit.FileArg("path", true, true, "The paths.").EnableRecursive(),
+				},
+				Options: []cmdkit.Option{
+					cmdkit.IntOption("count", "n", "How many.").WithDefault(3),
+				},
+			},
+		},
+	}
+
+	out := cmd2outputCmd("ipfs", root, false)
+	if len(out.Subcommands) != 2 || out.Subcommands[0].Name != "a" || out.Subcommands[1].Name != "b" {
+		t.Fatalf("expected the sorted subcommands, got %+v", out.Subcommands)
+	}
+	if a := out.Subcommands[0]; a.Tagline != "" || a.Arguments != nil || a.Options[0].Type != "" {
+		t.Fatalf("expected no details without verbose, got %+v", a)
+	}
+
+	a := cmd2outputCmd("ipfs", root, true).Subcommands[0]
+	if a.Tagline != "Do a." {
+		t.Errorf("unexpected tagline %q", a.Tagline)
+	}
+	if len(a.Arguments) != 1 {
+		t.Fatalf("expected an argument, got %+v", a.Arguments)
+	}
+	if arg := a.Arguments[0]; arg.Name != "path" || arg.Type != "file" || !arg.Required || !arg.Variadic || !arg.Recursive {
+		t.Errorf("unexpected argument %+v", arg)
+	}
+	if opt := a.Options[0]; opt.Type != "int" || opt.Default != 3 || opt.Description != "How many." {
+		t.Errorf("unexpected option %+v", opt)
+	}
+}
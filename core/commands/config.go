@@ -12,8 +12,10 @@ import (
 	"strings"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
 	repo "github.com/ipfs/go-ipfs/repo"
+	common "github.com/ipfs/go-ipfs/repo/common"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
@@ -332,13 +334,13 @@ var configProfileApplyCmd = &cmds.Command{
 		cmdkit.StringArg("profile", true, false, "The profile to apply to the config."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
-		profile, ok := config.Profiles[req.Arguments()[0]]
+		profile, ok := core.LookupConfigProfile(req.Arguments()[0])
 		if !ok {
 			res.SetError(fmt.Errorf("%s is not a profile", req.Arguments()[0]), cmdkit.ErrNormal)
 			return
 		}
 
-		err := transformConfig(req.InvocContext().ConfigRoot, req.Arguments()[0], profile.Transform)
+		err := transformConfig(req.InvocContext().ConfigRoot, req.Arguments()[0], profile)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
@@ -350,7 +352,7 @@ var configProfileApplyCmd = &cmds.Command{
 func buildProfileHelp() string {
 	var out string
 
-	for name, profile := range config.Profiles {
+	for name, profile := range core.ConfigProfiles() {
 		dlines := strings.Split(profile.Description, "\n")
 		for i := range dlines {
 			dlines[i] = "    " + dlines[i]
@@ -362,7 +364,7 @@ func buildProfileHelp() string {
 	return out
 }
 
-func transformConfig(configRoot string, configName string, transformer config.Transformer) error {
+func transformConfig(configRoot string, configName string, profile core.ConfigProfile) error {
 	r, err := fsrepo.Open(configRoot)
 	if err != nil {
 		return err
@@ -374,7 +376,7 @@ func transformConfig(configRoot string, configName string, transformer config.Tr
 		return err
 	}
 
-	err = transformer(cfg)
+	err = profile.Transform(cfg)
 	if err != nil {
 		return err
 	}
@@ -384,7 +386,13 @@ func transformConfig(configRoot string, configName string, transformer config.Tr
 		return err
 	}
 
-	return r.SetConfig(cfg)
+	if err := r.SetConfig(cfg); err != nil {
+		return err
+	}
+	if profile.Apply != nil {
+		return profile.Apply(r)
+	}
+	return nil
 }
 
 func getConfig(r repo.Repo, key string) (*ConfigField, error) {
@@ -399,6 +407,9 @@ func getConfig(r repo.Repo, key string) (*ConfigField, error) {
 }
 
 func setConfig(r repo.Repo, key string, value interface{}) (*ConfigField, error) {
+	if err := core.ValidateTransportsKey(r, key, value); err != nil {
+		return nil, err
+	}
 	err := r.SetConfigKey(key, value)
 	if err != nil {
 		return nil, fmt.Errorf("failed to set config value: %s (maybe use --json?)", err)
@@ -418,8 +429,18 @@ func editConfig(filename string) error {
 }
 
 func replaceConfig(r repo.Repo, file io.Reader) error {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	// the struct validates the file, the map is written so that the keys
+	// it doesn't model are replaced too
 	var cfg config.Config
-	if err := json.NewDecoder(file).Decode(&cfg); err != nil {
+	var mapconf map[string]interface{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return errors.New("failed to decode file as config")
+	}
+	if err := json.Unmarshal(data, &mapconf); err != nil {
 		return errors.New("failed to decode file as config")
 	}
 	if len(cfg.Identity.PrivKey) != 0 {
@@ -436,7 +457,8 @@ func replaceConfig(r repo.Repo, file io.Reader) error {
 		return fmt.Errorf("private key in config was not a string")
 	}
 
-	cfg.Identity.PrivKey = pkstr
-
-	return r.SetConfig(&cfg)
+	if err := common.MapSetKV(mapconf, config.PrivKeySelector, pkstr); err != nil {
+		return err
+	}
+	return r.ReplaceConfig(mapconf)
 }
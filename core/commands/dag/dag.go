@@ -10,6 +10,7 @@ import (
 	cmds "github.com/ipfs/go-ipfs/commands"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
 	coredag "github.com/ipfs/go-ipfs/core/coredag"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
 	pin "github.com/ipfs/go-ipfs/pin"
 	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
 
@@ -34,12 +35,15 @@ to deprecate and replace the existing 'ipfs object' command moving forward.
 		"put":     DagPutCmd,
 		"get":     DagGetCmd,
 		"resolve": DagResolveCmd,
+		"stat":    DagStatCmd,
 	},
 }
 
 // OutputObject is the output type of 'dag put' command
 type OutputObject struct {
 	Cid cid.Cid
+	// Codec is the codec the object was stored with.
+	Codec string `json:",omitempty"`
 }
 
 // ResolveOutput is the output type of 'dag resolve' command
@@ -48,12 +52,55 @@ type ResolveOutput struct {
 	RemPath string
 }
 
+// configurableHashFormats are the formats whose hash function can be set in
+// the config. The others, like git, require a specific one.
+var configurableHashFormats = map[string]bool{
+	"cbor":     true,
+	"dag-cbor": true,
+	"protobuf": true,
+	"dag-pb":   true,
+	"raw":      true,
+}
+
 var DagPutCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Add a dag node to ipfs.",
 		ShortDescription: `
 'ipfs dag put' accepts input from a file or stdin and parses it
 into an object of the specified format.
+
+The cbor, protobuf and raw formats are hashed with the function set in the
+Import.HashFunction config unless --hash is given. The other formats, like
+git, use the hash function they require.
+`,
+		LongDescription: `
+'ipfs dag put' accepts input from a file or stdin and parses it
+into an object of the specified format.
+
+The codec of the input is set with --input-codec, and the codec the objects
+are stored with with --store-codec. They replace --input-enc and --format,
+which are still accepted. The supported combinations are:
+
+  input codec          store codecs
+  dag-json (json)      dag-cbor, dag-pb, raw
+  dag-cbor (cbor)      dag-cbor, raw
+  dag-pb (protobuf)    dag-pb, raw
+  raw                  dag-cbor, dag-pb, raw
+
+With the git plugin, git objects are stored with --store-codec=git-raw, from
+their raw encoding (--input-codec=raw) or as compressed in .git/objects
+(--input-codec=zlib).
+
+A dag-json input can hold several documents, one after the other, each stored
+as an object:
+
+  > echo '{"a": 1} {"b": 2}' | ipfs dag put --store-codec=dag-cbor
+
+The codec of each stored object is part of the JSON output (--enc=json).
+
+The cbor, protobuf and raw formats are hashed with the function set in the
+Import.HashFunction config unless --hash is given. The other formats, like
+git, use the hash function they require.
 `,
 	},
 	Arguments: []cmdkit.Argument{
@@ -63,7 +110,9 @@ into an object of the specified format.
 		cmdkit.StringOption("format", "f", "Format that the object will be added as.").WithDefault("cbor"),
 		cmdkit.StringOption("input-enc", "Format that the input object will be.").WithDefault("json"),
 		cmdkit.BoolOption("pin", "Pin this object when adding."),
-		cmdkit.StringOption("hash", "Hash function to use").WithDefault(""),
+		cmdkit.StringOption("hash", "Hash function to use. Default: Import.HashFunction or sha2-256."),
+		cmdkit.StringOption("input-codec", "Codec of the input: dag-json, dag-cbor, dag-pb or raw. Replaces --input-enc."),
+		cmdkit.StringOption("store-codec", "Codec the objects are stored with: dag-cbor, dag-pb or raw. Replaces --format."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
@@ -74,6 +123,12 @@ into an object of the specified format.
 
 		ienc, _, _ := req.Option("input-enc").String()
 		format, _, _ := req.Option("format").String()
+		if codec, found, _ := req.Option("input-codec").String(); found {
+			ienc = codec
+		}
+		if codec, found, _ := req.Option("store-codec").String(); found {
+			format = codec
+		}
 		hash, _, err := req.Option("hash").String()
 		dopin, _, err := req.Option("pin").Bool()
 		if err != nil {
@@ -85,6 +140,14 @@ into an object of the specified format.
 		// default hash' (sha256 for cbor, sha1 for git..)
 		mhType := uint64(math.MaxUint64)
 
+		if hash == "" && configurableHashFormats[format] {
+			hash, err = coreunix.ConfiguredHashFunction(n.Repo)
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
 		if hash != "" {
 			var ok bool
 			mhType, ok = mh.Names[hash]
@@ -111,28 +174,35 @@ into an object of the specified format.
 					return err
 				}
 
-				nds, err := coredag.ParseInputs(ienc, format, file, mhType, -1)
-				if err != nil {
-					return err
-				}
-				if len(nds) == 0 {
-					return fmt.Errorf("no node returned from ParseInputs")
-				}
+				var docs int
+				err = coredag.ParseInputStream(ienc, format, file, mhType, -1, func(nds []ipld.Node) error {
+					if len(nds) == 0 {
+						return fmt.Errorf("no node returned from ParseInputs")
+					}
+					docs++
 
-				for _, nd := range nds {
-					err := b.Add(nd)
-					if err != nil {
-						return err
+					for _, nd := range nds {
+						err := b.Add(nd)
+						if err != nil {
+							return err
+						}
 					}
-				}
 
-				cid := nds[0].Cid()
-				cids.Add(cid)
+					c := nds[0].Cid()
+					cids.Add(c)
 
-				select {
-				case outChan <- &OutputObject{Cid: cid}:
-				case <-req.Context().Done():
-					return nil
+					select {
+					case outChan <- &OutputObject{Cid: c, Codec: codecName(c)}:
+						return nil
+					case <-req.Context().Done():
+						return req.Context().Err()
+					}
+				})
+				if err != nil {
+					return err
+				}
+				if docs == 0 {
+					return fmt.Errorf("no object in input")
 				}
 			}
 
@@ -289,6 +359,20 @@ var DagResolveCmd = &cmds.Command{
 	Type: ResolveOutput{},
 }
 
+// codecNames are the names of the codecs of the objects 'dag put' can store.
+var codecNames = map[uint64]string{
+	cid.DagCBOR:     "dag-cbor",
+	cid.DagProtobuf: "dag-pb",
+	cid.Raw:         "raw",
+}
+
+func codecName(c cid.Cid) string {
+	if name, ok := codecNames[c.Type()]; ok {
+		return name
+	}
+	return cid.CodecToStr[c.Type()]
+}
+
 // copy+pasted from ../commands.go
 func unwrapOutput(i interface{}) (interface{}, error) {
 	var (
@@ -0,0 +1,228 @@
+package dagcmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// DagStat is the output type of 'dag stat' command
+type DagStat struct {
+	Cid string `json:",omitempty"`
+	// Size is the size of the DAG walked as a tree, counting the blocks
+	// linked several times each time.
+	Size uint64
+	// DedupSize is the size of the distinct blocks of the DAG.
+	DedupSize uint64
+	NumBlocks int
+	// Depth is the length of the longest path from the root, in links.
+	Depth int
+	// Progress is the number of blocks fetched so far, set while the DAG
+	// is walked with --progress.
+	Progress int `json:",omitempty"`
+}
+
+// dagStatBatchSize is the number of blocks requested at once while walking
+// the DAG.
+const dagStatBatchSize = 1024
+
+var DagStatCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Gets stats for a DAG.",
+		ShortDescription: `
+'ipfs dag stat' fetches a DAG and reports its size and its number of blocks,
+to know what would be stored by pinning it. The blocks missing locally are
+fetched from the network.
+
+The size counts the blocks linked several times each time, as the cumulative
+size of unixfs files does, while the deduplicated size counts them once: this
+is the space taken by the DAG in the repo.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("root", true, false, "The root of the DAG to stat").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("progress", "p", "Show the number of fetched blocks"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		p, err := path.ParsePath(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		root, rem, err := n.Resolver.ResolveToLastNode(req.Context(), p)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if len(rem) > 0 {
+			res.SetError(fmt.Errorf("%s doesn't resolve to a block", p), cmdkit.ErrNormal)
+			return
+		}
+
+		showProgress, _, _ := req.Option("progress").Bool()
+
+		outChan := make(chan interface{}, 1)
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+
+			var progress func(int)
+			if showProgress {
+				var last time.Time
+				progress = func(fetched int) {
+					if time.Since(last) < 500*time.Millisecond {
+						return
+					}
+					last = time.Now()
+					select {
+					case outChan <- &DagStat{Progress: fetched}:
+					case <-req.Context().Done():
+					}
+				}
+			}
+
+			st, err := statDag(req.Context(), n.DAG, root, progress)
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+
+			select {
+			case outChan <- st:
+			case <-req.Context().Done():
+			}
+		}()
+	},
+	Type: DagStat{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			st, ok := v.(*DagStat)
+			if !ok {
+				return nil, e.TypeErr(st, v)
+			}
+
+			if st.Cid == "" {
+				fmt.Fprintf(res.Stderr(), "Fetched %d blocks\r", st.Progress)
+				return new(bytes.Buffer), nil
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Cid: %s\n", st.Cid)
+			fmt.Fprintf(buf, "Size: %d\n", st.Size)
+			fmt.Fprintf(buf, "DedupSize: %d\n", st.DedupSize)
+			fmt.Fprintf(buf, "NumBlocks: %d\n", st.NumBlocks)
+			fmt.Fprintf(buf, "Depth: %d\n", st.Depth)
+			return buf, nil
+		},
+	},
+}
+
+type dagStatNode struct {
+	size  uint64
+	links []cid.Cid
+}
+
+// statDag walks the DAG under root, level by level, calling progress with
+// the number of fetched blocks if it isn't nil.
+func statDag(ctx context.Context, ng ipld.NodeGetter, root cid.Cid, progress func(int)) (*DagStat, error) {
+	nodes := make(map[cid.Cid]*dagStatNode)
+	seen := cid.NewSet()
+	seen.Add(root)
+
+	level := []cid.Cid{root}
+	for len(level) > 0 {
+		var next []cid.Cid
+		for len(level) > 0 {
+			batch := level
+			if len(batch) > dagStatBatchSize {
+				batch = batch[:dagStatBatchSize]
+			}
+			level = level[len(batch):]
+
+			for opt := range ng.GetMany(ctx, batch) {
+				if opt.Err != nil {
+					return nil, opt.Err
+				}
+
+				nd := &dagStatNode{size: uint64(len(opt.Node.RawData()))}
+				for _, l := range opt.Node.Links() {
+					nd.links = append(nd.links, l.Cid)
+					if seen.Visit(l.Cid) {
+						next = append(next, l.Cid)
+					}
+				}
+				nodes[opt.Node.Cid()] = nd
+
+				if progress != nil {
+					progress(len(nodes))
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		level = next
+	}
+
+	st := &DagStat{
+		Cid:       root.String(),
+		NumBlocks: len(nodes),
+	}
+	for _, nd := range nodes {
+		st.DedupSize += nd.size
+	}
+
+	// the size and the depth of the sub-DAGs, which are walked once each
+	type subDag struct {
+		size  uint64
+		depth int
+	}
+	subDags := make(map[cid.Cid]subDag)
+	var walk func(c cid.Cid) subDag
+	walk = func(c cid.Cid) subDag {
+		if sd, ok := subDags[c]; ok {
+			return sd
+		}
+		nd := nodes[c]
+		sd := subDag{size: nd.size}
+		for _, l := range nd.links {
+			child := walk(l)
+			sd.size += child.size
+			if child.depth+1 > sd.depth {
+				sd.depth = child.depth + 1
+			}
+		}
+		subDags[c] = sd
+		return sd
+	}
+	rootDag := walk(root)
+	st.Size = rootDag.size
+	st.Depth = rootDag.depth
+
+	return st, nil
+}
@@ -3,12 +3,15 @@ package commands
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
 	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
 	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
@@ -41,13 +44,21 @@ var DhtCmd = &cmds.Command{
 		"get":       getValueDhtCmd,
 		"put":       putValueDhtCmd,
 		"provide":   provideRefDhtCmd,
+		"bulk":      bulkDhtCmd,
+		"mode":      modeDhtCmd,
 	},
 }
 
 var queryDhtCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
-		Tagline:          "Find the closest Peer IDs to a given Peer ID by querying the DHT.",
-		ShortDescription: "Outputs a list of newline-delimited Peer IDs.",
+		Tagline: "Find the closest Peer IDs to a given Peer ID by querying the DHT.",
+		ShortDescription: `
+Outputs a list of newline-delimited Peer IDs.
+
+With --verbose, each step of the query is printed with the time since its
+start: the peers queried, the closer peers they answered with and how long
+they took to answer, and the errors.
+`,
 	},
 
 	Arguments: []cmdkit.Argument{
@@ -70,6 +81,7 @@ var queryDhtCmd = &cmds.Command{
 
 		events := make(chan *notif.QueryEvent)
 		ctx := notif.RegisterForQueryEvents(req.Context(), events)
+		tr := newQueryTracer()
 
 		id, err := peer.IDB58Decode(req.Arguments()[0])
 		if err != nil {
@@ -100,7 +112,7 @@ var queryDhtCmd = &cmds.Command{
 			defer close(outChan)
 			for e := range events {
 				select {
-				case outChan <- e:
+				case outChan <- tr.trace(e):
 				case <-req.Context().Done():
 					return
 				}
@@ -111,10 +123,19 @@ var queryDhtCmd = &cmds.Command{
 		cmds.Text: func() cmds.Marshaler {
 			pfm := pfuncMap{
 				notif.PeerResponse: func(obj *notif.QueryEvent, out io.Writer, verbose bool) {
+					if verbose {
+						printEventBody(obj, out, verbose, nil)
+						return
+					}
 					for _, p := range obj.Responses {
 						fmt.Fprintf(out, "%s\n", p.ID.Pretty())
 					}
 				},
+				notif.FinalPeer: func(obj *notif.QueryEvent, out io.Writer, verbose bool) {
+					if verbose {
+						fmt.Fprintf(out, "* closest peer %s\n", obj.ID)
+					}
+				},
 			}
 
 			return func(res cmds.Response) (io.Reader, error) {
@@ -123,7 +144,7 @@ var queryDhtCmd = &cmds.Command{
 					return nil, err
 				}
 
-				obj, ok := v.(*notif.QueryEvent)
+				obj, ok := v.(*DhtQueryEvent)
 				if !ok {
 					return nil, e.TypeErr(obj, v)
 				}
@@ -131,18 +152,24 @@ var queryDhtCmd = &cmds.Command{
 				verbose, _, _ := res.Request().Option("v").Bool()
 
 				buf := new(bytes.Buffer)
-				printEvent(obj, buf, verbose, pfm)
+				printQueryEvent(obj, buf, verbose, pfm)
 				return buf, nil
 			}
 		}(),
 	},
-	Type: notif.QueryEvent{},
+	Type: DhtQueryEvent{},
 }
 
 var findProvidersDhtCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
-		Tagline:          "Find peers that can provide a specific value, given a key.",
-		ShortDescription: "Outputs a list of newline-delimited provider Peer IDs.",
+		Tagline: "Find peers that can provide a specific value, given a key.",
+		ShortDescription: `
+Outputs a list of newline-delimited provider Peer IDs.
+
+The search stops after finding --num-providers providers, or after --timeout.
+With --verbose, each step of the search is printed with the time since its
+start, as by 'ipfs dht query --verbose'.
+`,
 	},
 
 	Arguments: []cmdkit.Argument{
@@ -151,6 +178,7 @@ var findProvidersDhtCmd = &cmds.Command{
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("verbose", "v", "Print extra information."),
 		cmdkit.IntOption("num-providers", "n", "The number of providers to find.").WithDefault(20),
+		cmdkit.StringOption("timeout", "Maximum time to spend looking for providers, like 30s."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
@@ -174,15 +202,32 @@ var findProvidersDhtCmd = &cmds.Command{
 			return
 		}
 
-		events := make(chan *notif.QueryEvent)
-		ctx := notif.RegisterForQueryEvents(req.Context(), events)
-		c, err := cid.Parse(req.Arguments()[0])
+		var timeout time.Duration
+		if s, found, _ := req.Option("timeout").String(); found {
+			timeout, err = time.ParseDuration(s)
+			if err != nil || timeout <= 0 {
+				res.SetError(fmt.Errorf("invalid timeout: %q", s), cmdkit.ErrClient)
+				return
+			}
+		}
 
+		c, err := cid.Parse(req.Arguments()[0])
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(req.Context(), timeout)
+		} else {
+			ctx, cancel = context.WithCancel(req.Context())
+		}
+		events := make(chan *notif.QueryEvent)
+		ctx = notif.RegisterForQueryEvents(ctx, events)
+		tr := newQueryTracer()
+
 		outChan := make(chan interface{})
 		res.SetOutput((<-chan interface{})(outChan))
 
@@ -191,7 +236,7 @@ var findProvidersDhtCmd = &cmds.Command{
 			defer close(outChan)
 			for e := range events {
 				select {
-				case outChan <- e:
+				case outChan <- tr.trace(e):
 				case <-req.Context().Done():
 					return
 				}
@@ -199,6 +244,7 @@ var findProvidersDhtCmd = &cmds.Command{
 		}()
 
 		go func() {
+			defer cancel()
 			defer close(events)
 			for p := range pchan {
 				np := p
@@ -238,23 +284,57 @@ var findProvidersDhtCmd = &cmds.Command{
 					return nil, err
 				}
 
-				obj, ok := v.(*notif.QueryEvent)
+				obj, ok := v.(*DhtQueryEvent)
 				if !ok {
 					return nil, e.TypeErr(obj, v)
 				}
 
 				buf := new(bytes.Buffer)
-				printEvent(obj, buf, verbose, pfm)
+				printQueryEvent(obj, buf, verbose, pfm)
 				return buf, nil
 			}
 		}(),
 	},
-	Type: notif.QueryEvent{},
+	Type: DhtQueryEvent{},
+}
+
+// DhtProvideOutput is the output of 'ipfs dht provide': either a query event
+// of the provides, or the outcome of providing a block.
+type DhtProvideOutput struct {
+	Event *notif.QueryEvent `json:",omitempty"`
+
+	// Cid is the block that was provided, or failed to be with Error.
+	// Provided and Failed count the blocks done so far, out of Total.
+	Cid      string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+	Provided int
+	Failed   int
+	Total    int
+	// Queued counts the blocks queued with --priority.
+	Queued int `json:",omitempty"`
 }
 
 var provideRefDhtCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Announce to the network that you are providing given values.",
+		ShortDescription: `
+Announces the given blocks to the routing system now, instead of waiting for
+the reprovider, and prints how many were provided. With '--recursive', all the
+blocks of the DAGs below the given blocks are announced.
+
+A block failing to be provided doesn't stop the others, the failures are
+counted. '--progress' prints the outcome of each block as it is provided.
+
+    $ ipfs dht provide -r --progress QmRoot
+
+With '--priority', the blocks are queued to be provided in the background by
+the provide queue, like the new blocks, instead of being provided now. The
+queue provides the high priority blocks first, and then shares its batches
+between the priorities waiting, 8 to 4 to 1, so that the low priority ones
+aren't starved. 'ipfs provide stat' shows the blocks waiting.
+
+    $ ipfs dht provide -r --priority=low QmDataset
+`,
 	},
 
 	Arguments: []cmdkit.Argument{
@@ -263,6 +343,8 @@ var provideRefDhtCmd = &cmds.Command{
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("verbose", "v", "Print extra information."),
 		cmdkit.BoolOption("recursive", "r", "Recursively provide entire graph."),
+		cmdkit.BoolOption("progress", "p", "Print the outcome of each provided block."),
+		cmdkit.StringOption("priority", "Queue the blocks to be provided in the background with this priority: high, normal or low."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
@@ -276,7 +358,19 @@ var provideRefDhtCmd = &cmds.Command{
 			return
 		}
 
-		if len(n.PeerHost.Network().Conns()) == 0 {
+		prioStr, queued, _ := req.Option("priority").String()
+		prio, err := core.ParsePriority(prioStr)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
+		if queued && n.ProvideQueue == nil {
+			res.SetError(errors.New("cannot queue the provides, the node doesn't provide its blocks"), cmdkit.ErrNormal)
+			return
+		}
+
+		// the queue provides the blocks once peers are connected
+		if !queued && len(n.PeerHost.Network().Conns()) == 0 {
 			res.SetError(errors.New("cannot provide, no connected peers"), cmdkit.ErrNormal)
 			return
 		}
@@ -305,9 +399,37 @@ var provideRefDhtCmd = &cmds.Command{
 			cids = append(cids, c)
 		}
 
+		if rec {
+			cids, err = enumerateProvideKeys(req.Context(), n.DAG, cids)
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		if queued {
+			for _, c := range cids {
+				if err := n.EnqueueProvide(c, prio); err != nil {
+					res.SetError(err, cmdkit.ErrNormal)
+					return
+				}
+			}
+		}
+
 		outChan := make(chan interface{})
 		res.SetOutput((<-chan interface{})(outChan))
 
+		if queued {
+			go func() {
+				defer close(outChan)
+				select {
+				case outChan <- &DhtProvideOutput{Queued: len(cids), Total: len(cids)}:
+				case <-req.Context().Done():
+				}
+			}()
+			return
+		}
+
 		events := make(chan *notif.QueryEvent)
 		ctx := notif.RegisterForQueryEvents(req.Context(), events)
 
@@ -315,7 +437,7 @@ var provideRefDhtCmd = &cmds.Command{
 			defer close(outChan)
 			for e := range events {
 				select {
-				case outChan <- e:
+				case outChan <- &DhtProvideOutput{Event: e}:
 				case <-req.Context().Done():
 					return
 				}
@@ -324,18 +446,12 @@ var provideRefDhtCmd = &cmds.Command{
 
 		go func() {
 			defer close(events)
-			var err error
-			if rec {
-				err = provideKeysRec(ctx, n.Routing, n.DAG, cids)
-			} else {
-				err = provideKeys(ctx, n.Routing, cids)
-			}
-			if err != nil {
-				notif.PublishQueryEvent(ctx, &notif.QueryEvent{
-					Type:  notif.QueryError,
-					Extra: err.Error(),
-				})
-			}
+			provideKeys(ctx, n.Routing, cids, func(out *DhtProvideOutput) {
+				select {
+				case outChan <- out:
+				case <-ctx.Done():
+				}
+			})
 		}()
 	},
 	Marshalers: cmds.MarshalerMap{
@@ -350,64 +466,83 @@ var provideRefDhtCmd = &cmds.Command{
 
 			return func(res cmds.Response) (io.Reader, error) {
 				verbose, _, _ := res.Request().Option("v").Bool()
+				progress, _, _ := res.Request().Option("progress").Bool()
 				v, err := unwrapOutput(res.Output())
 				if err != nil {
 					return nil, err
 				}
-				obj, ok := v.(*notif.QueryEvent)
+				obj, ok := v.(*DhtProvideOutput)
 				if !ok {
 					return nil, e.TypeErr(obj, v)
 				}
 
 				buf := new(bytes.Buffer)
-				printEvent(obj, buf, verbose, pfm)
+				switch {
+				case obj.Event != nil:
+					printEvent(obj.Event, buf, verbose, pfm)
+				case obj.Queued > 0:
+					fmt.Fprintf(buf, "queued %d of %d blocks\n", obj.Queued, obj.Total)
+				case obj.Cid == "":
+					fmt.Fprintf(buf, "provided %d of %d blocks", obj.Provided, obj.Total)
+					if obj.Failed > 0 {
+						fmt.Fprintf(buf, ", %d failed", obj.Failed)
+					}
+					fmt.Fprintln(buf)
+				case progress && obj.Error != "":
+					fmt.Fprintf(buf, "[%d/%d] failed to provide %s: %s\n", obj.Provided+obj.Failed, obj.Total, obj.Cid, obj.Error)
+				case progress:
+					fmt.Fprintf(buf, "[%d/%d] provided %s\n", obj.Provided+obj.Failed, obj.Total, obj.Cid)
+				}
 				return buf, nil
 			}
 		}(),
 	},
-	Type: notif.QueryEvent{},
+	Type: DhtProvideOutput{},
 }
 
-func provideKeys(ctx context.Context, r routing.IpfsRouting, cids []cid.Cid) error {
+// provideKeys provides cids in turn, calling report with the outcome of each
+// of them and then with the final counts.
+func provideKeys(ctx context.Context, r routing.IpfsRouting, cids []cid.Cid, report func(*DhtProvideOutput)) {
+	counts := DhtProvideOutput{Total: len(cids)}
 	for _, c := range cids {
-		err := r.Provide(ctx, c, true)
-		if err != nil {
-			return err
+		if ctx.Err() != nil {
+			return
+		}
+		out := counts
+		out.Cid = c.String()
+		if err := r.Provide(ctx, c, true); err != nil {
+			counts.Failed++
+			out.Error = err.Error()
+		} else {
+			counts.Provided++
 		}
+		out.Provided, out.Failed = counts.Provided, counts.Failed
+		report(&out)
 	}
-	return nil
+	report(&counts)
 }
 
-func provideKeysRec(ctx context.Context, r routing.IpfsRouting, dserv ipld.DAGService, cids []cid.Cid) error {
-	provided := cid.NewSet()
+// enumerateProvideKeys returns the blocks of the DAGs below cids, each once.
+func enumerateProvideKeys(ctx context.Context, dserv ipld.DAGService, cids []cid.Cid) ([]cid.Cid, error) {
+	kset := cid.NewSet()
 	for _, c := range cids {
-		kset := cid.NewSet()
-
 		err := dag.EnumerateChildrenAsync(ctx, dag.GetLinksDirect(dserv), c, kset.Visit)
 		if err != nil {
-			return err
-		}
-
-		for _, k := range kset.Keys() {
-			if provided.Has(k) {
-				continue
-			}
-
-			err = r.Provide(ctx, k, true)
-			if err != nil {
-				return err
-			}
-			provided.Add(k)
+			return nil, err
 		}
 	}
-
-	return nil
+	return kset.Keys(), nil
 }
 
 var findPeerDhtCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
-		Tagline:          "Find the multiaddresses associated with a Peer ID.",
-		ShortDescription: "Outputs a list of newline-delimited multiaddresses.",
+		Tagline: "Find the multiaddresses associated with a Peer ID.",
+		ShortDescription: `
+Outputs a list of newline-delimited multiaddresses.
+
+With --verbose, each step of the search is printed with the time since its
+start, as by 'ipfs dht query --verbose'.
+`,
 	},
 
 	Arguments: []cmdkit.Argument{
@@ -439,12 +574,13 @@ var findPeerDhtCmd = &cmds.Command{
 
 		events := make(chan *notif.QueryEvent)
 		ctx := notif.RegisterForQueryEvents(req.Context(), events)
+		tr := newQueryTracer()
 
 		go func() {
 			defer close(outChan)
 			for v := range events {
 				select {
-				case outChan <- v:
+				case outChan <- tr.trace(v):
 				case <-req.Context().Done():
 				}
 
@@ -486,19 +622,19 @@ var findPeerDhtCmd = &cmds.Command{
 					return nil, err
 				}
 
-				obj, ok := v.(*notif.QueryEvent)
+				obj, ok := v.(*DhtQueryEvent)
 				if !ok {
 					return nil, e.TypeErr(obj, v)
 				}
 
 				buf := new(bytes.Buffer)
-				printEvent(obj, buf, verbose, pfm)
+				printQueryEvent(obj, buf, verbose, pfm)
 
 				return buf, nil
 			}
 		}(),
 	},
-	Type: notif.QueryEvent{},
+	Type: DhtQueryEvent{},
 }
 
 var getValueDhtCmd = &cmds.Command{
@@ -724,7 +860,118 @@ func printEvent(obj *notif.QueryEvent, out io.Writer, verbose bool, override pfu
 	if verbose {
 		fmt.Fprintf(out, "%s: ", time.Now().Format("15:04:05.000"))
 	}
+	printEventBody(obj, out, verbose, override)
+}
+
+// DhtQueryEvent is a query event of 'ipfs dht query', 'findprovs' and
+// 'findpeer' with its timing. It is encoded as the query event, with the
+// timing fields added.
+type DhtQueryEvent struct {
+	Event *notif.QueryEvent
+
+	// Elapsed is the time from the start of the query to the event.
+	Elapsed time.Duration
+	// RTT is how long the peer took to answer, for the responses and errors
+	// of the peers queried.
+	RTT time.Duration
+}
+
+type dhtQueryTiming struct {
+	Elapsed time.Duration
+	RTT     time.Duration `json:",omitempty"`
+}
+
+func (qe *DhtQueryEvent) MarshalJSON() ([]byte, error) {
+	ev, err := json.Marshal(qe.Event)
+	if err != nil {
+		return nil, err
+	}
+	timing, err := json.Marshal(dhtQueryTiming{Elapsed: qe.Elapsed, RTT: qe.RTT})
+	if err != nil {
+		return nil, err
+	}
+	if len(ev) < 2 || ev[0] != '{' {
+		return nil, fmt.Errorf("unexpected query event encoding: %s", ev)
+	}
+
+	// merge the fields of both objects
+	out := append(ev[:len(ev)-1:len(ev)-1], ',')
+	if len(ev) == 2 {
+		out = ev[:1:1]
+	}
+	return append(out, timing[1:]...), nil
+}
+
+func (qe *DhtQueryEvent) UnmarshalJSON(b []byte) error {
+	var timing dhtQueryTiming
+	if err := json.Unmarshal(b, &timing); err != nil {
+		return err
+	}
+	qe.Event = new(notif.QueryEvent)
+	if err := json.Unmarshal(b, qe.Event); err != nil {
+		return err
+	}
+	qe.Elapsed, qe.RTT = timing.Elapsed, timing.RTT
+	return nil
+}
+
+// queryTracer times the query events of a command, the events must be traced
+// in order.
+type queryTracer struct {
+	start time.Time
+	// queried holds when the peers queried were sent the query.
+	queried map[peer.ID]time.Time
+}
+
+func newQueryTracer() *queryTracer {
+	return &queryTracer{
+		start:   time.Now(),
+		queried: make(map[peer.ID]time.Time),
+	}
+}
+
+func (t *queryTracer) trace(ev *notif.QueryEvent) *DhtQueryEvent {
+	now := time.Now()
+	qe := &DhtQueryEvent{Event: ev, Elapsed: now.Sub(t.start)}
+	switch ev.Type {
+	case notif.SendingQuery:
+		t.queried[ev.ID] = now
+	case notif.PeerResponse, notif.QueryError:
+		if sent, ok := t.queried[ev.ID]; ok {
+			qe.RTT = now.Sub(sent)
+			delete(t.queried, ev.ID)
+		}
+	}
+	return qe
+}
+
+// printQueryEvent prints a timed query event. When verbose, the lines are
+// prefixed with the time since the start of the query, and the answers of
+// the peers are followed by how long the peers took.
+func printQueryEvent(obj *DhtQueryEvent, out io.Writer, verbose bool, override pfuncMap) {
+	if !verbose {
+		printEventBody(obj.Event, out, verbose, override)
+		return
+	}
 
+	buf := new(bytes.Buffer)
+	printEventBody(obj.Event, buf, verbose, override)
+	if buf.Len() == 0 {
+		return
+	}
+	body := buf.String()
+	if obj.RTT > 0 {
+		i := strings.IndexByte(body, '\n')
+		if i < 0 {
+			i = len(body)
+		}
+		body = fmt.Sprintf("%s (in %s)%s", strings.TrimRight(body[:i], " "), obj.RTT.Round(time.Millisecond), body[i:])
+	}
+	fmt.Fprintf(out, "%8.3fs: %s", obj.Elapsed.Seconds(), body)
+}
+
+// printEventBody prints obj, without the time of verbose outputs.
+func printEventBody(obj *notif.QueryEvent, out io.Writer, verbose bool, override pfuncMap) {
 	if override != nil {
 		if pf, ok := override[obj.Type]; ok {
 			pf(obj, out, verbose)
@@ -753,7 +1000,11 @@ func printEvent(obj *notif.QueryEvent, out io.Writer, verbose bool, override pfu
 		}
 	case notif.QueryError:
 		if verbose {
-			fmt.Fprintf(out, "error: %s\n", obj.Extra)
+			if obj.ID != "" {
+				fmt.Fprintf(out, "error from %s: %s\n", obj.ID, obj.Extra)
+			} else {
+				fmt.Fprintf(out, "error: %s\n", obj.Extra)
+			}
 		}
 	case notif.DialingPeer:
 		if verbose {
@@ -790,3 +1041,64 @@ func escapeDhtKey(s string) (string, error) {
 		return "", errors.New("invalid key")
 	}
 }
+
+// DhtModeOutput is the mode of the DHT of the node.
+type DhtModeOutput struct {
+	Mode string
+	Auto bool
+}
+
+var modeDhtCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show whether the node answers the DHT queries of its peers.",
+		ShortDescription: `
+Prints 'server' when the node answers the DHT queries of its peers, and
+'client' when it only queries the DHT. With Routing.Type set to 'dhtauto', the
+mode follows the reachability of the node, which is printed as 'auto'.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		mode, ok := n.DHTMode()
+		if !ok {
+			res.SetError(ErrNotDHT, cmdkit.ErrNormal)
+			return
+		}
+
+		out := &DhtModeOutput{Mode: "client", Auto: mode.Auto}
+		if mode.Server {
+			out.Mode = "server"
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*DhtModeOutput)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			s := out.Mode
+			if out.Auto {
+				s += " (auto)"
+			}
+			return strings.NewReader(s + "\n"), nil
+		},
+	},
+	Type: DhtModeOutput{},
+}
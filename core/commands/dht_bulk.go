@@ -0,0 +1,240 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+const defaultDhtBulkConcurrency = 16
+
+// dhtBulkMaxLine is the longest line of the input of 'ipfs dht bulk', which
+// must hold a key and its base64 encoded value.
+const dhtBulkMaxLine = 1 << 20
+
+// DhtBulkResult is the outcome of a single key of 'ipfs dht bulk'.
+type DhtBulkResult struct {
+	Key   string
+	Value []byte `json:",omitempty"`
+	Error string `json:",omitempty"`
+}
+
+var bulkDhtCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Get or put many values in the routing system at once.",
+		ShortDescription: `
+Process many keys in one invocation, reading them from a file or from standard
+input. Keys are processed concurrently, and a result is printed for every key
+as soon as it is available.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"get": bulkGetDhtCmd,
+		"put": bulkPutDhtCmd,
+	},
+}
+
+var bulkGetDhtCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Query the routing system for the best values of many keys.",
+		ShortDescription: `
+Reads one key per line and prints, for each of them, the key followed by its
+best value encoded in base64, or by the error that occurred. The output can be
+given to 'ipfs dht bulk put'.
+
+Empty lines and lines starting with '#' are ignored.
+
+    $ ipfs dht bulk get keys.txt > records.txt
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.FileArg("file", true, false, "File with one key per line.").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.IntOption("concurrency", "c", "Maximum number of keys processed at the same time.").WithDefault(defaultDhtBulkConcurrency),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		runDhtBulk(req, res, func(ctx context.Context, n *core.IpfsNode, line string) *DhtBulkResult {
+			out := &DhtBulkResult{Key: line}
+
+			dhtkey, err := escapeDhtKey(line)
+			if err != nil {
+				out.Error = err.Error()
+				return out
+			}
+			val, err := n.Routing.GetValue(ctx, dhtkey)
+			if err != nil {
+				out.Error = err.Error()
+				return out
+			}
+			out.Value = val
+			return out
+		})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: marshalDhtBulkResult,
+	},
+	Type: DhtBulkResult{},
+}
+
+var bulkPutDhtCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Write many key/value pairs to the routing system.",
+		ShortDescription: `
+Reads one key/value pair per line, separated by whitespace, where the value is
+encoded in base64, and prints for each key whether it was written. This is the
+format printed by 'ipfs dht bulk get'.
+
+Empty lines and lines starting with '#' are ignored. The same restrictions as
+'ipfs dht put' apply to keys and values.
+
+    $ ipfs dht bulk put records.txt
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.FileArg("file", true, false, "File with one key/value pair per line.").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.IntOption("concurrency", "c", "Maximum number of keys processed at the same time.").WithDefault(defaultDhtBulkConcurrency),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		runDhtBulk(req, res, func(ctx context.Context, n *core.IpfsNode, line string) *DhtBulkResult {
+			fields := strings.Fields(line)
+			out := &DhtBulkResult{Key: fields[0]}
+			if len(fields) != 2 {
+				out.Error = "expected a key and a base64 encoded value"
+				return out
+			}
+
+			dhtkey, err := escapeDhtKey(fields[0])
+			if err != nil {
+				out.Error = err.Error()
+				return out
+			}
+			val, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				out.Error = fmt.Sprintf("invalid value: %s", err)
+				return out
+			}
+			if err := n.Routing.PutValue(ctx, dhtkey, val); err != nil {
+				out.Error = err.Error()
+			}
+			return out
+		})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: marshalDhtBulkResult,
+	},
+	Type: DhtBulkResult{},
+}
+
+// runDhtBulk calls do on every line of the file argument of req, running at
+// most --concurrency of them at the same time, and outputs the results.
+func runDhtBulk(req cmds.Request, res cmds.Response, do func(context.Context, *core.IpfsNode, string) *DhtBulkResult) {
+	n, err := req.InvocContext().GetNode()
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+
+	if n.Routing == nil {
+		res.SetError(ErrNotOnline, cmdkit.ErrNormal)
+		return
+	}
+
+	concurrency, _, err := req.Option("concurrency").Int()
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+	if concurrency < 1 {
+		res.SetError(errors.New("concurrency must be positive"), cmdkit.ErrClient)
+		return
+	}
+
+	file, err := req.Files().NextFile()
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+
+	ctx := req.Context()
+	outChan := make(chan interface{})
+	res.SetOutput((<-chan interface{})(outChan))
+
+	go func() {
+		defer close(outChan)
+		defer file.Close()
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, concurrency)
+		defer wg.Wait()
+
+		scan := bufio.NewScanner(file)
+		scan.Buffer(make([]byte, 4096), dhtBulkMaxLine)
+		for scan.Scan() {
+			line := strings.TrimSpace(scan.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				select {
+				case outChan <- do(ctx, n, line):
+				case <-ctx.Done():
+				}
+			}()
+		}
+		if err := scan.Err(); err != nil {
+			res.SetError(fmt.Errorf("failed to read input: %s", err), cmdkit.ErrNormal)
+		}
+	}()
+}
+
+func marshalDhtBulkResult(res cmds.Response) (io.Reader, error) {
+	v, err := unwrapOutput(res.Output())
+	if err != nil {
+		return nil, err
+	}
+
+	out, ok := v.(*DhtBulkResult)
+	if !ok {
+		return nil, e.TypeErr(out, v)
+	}
+
+	buf := new(bytes.Buffer)
+	switch {
+	case out.Error != "":
+		fmt.Fprintf(buf, "%s error: %s\n", out.Key, out.Error)
+	case out.Value != nil:
+		fmt.Fprintf(buf, "%s %s\n", out.Key, base64.StdEncoding.EncodeToString(out.Value))
+	default:
+		fmt.Fprintf(buf, "%s ok\n", out.Key)
+	}
+	return buf, nil
+}
@@ -1,12 +1,17 @@
 package commands
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-ipfs/namesys"
 
 	tu "gx/ipfs/QmRNhSdqzMcuRxX9A1egBeQ3BhDTguDV5HPwi8wRykkPU8/go-testutil"
 	ipns "gx/ipfs/QmbUUxB9ErnEQdwTzy6HTxucnBvAH4am6vsfbD8CiqKhi9/go-ipns"
+	notif "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/notifications"
 )
 
 func TestKeyTranslation(t *testing.T) {
@@ -32,3 +37,59 @@ func TestKeyTranslation(t *testing.T) {
 		t.Fatal("keys didnt match!")
 	}
 }
+
+func TestQueryTracer(t *testing.T) {
+	pid := tu.RandPeerIDFatal(t)
+	tr := newQueryTracer()
+
+	sent := tr.trace(&notif.QueryEvent{Type: notif.SendingQuery, ID: pid})
+	if sent.RTT != 0 {
+		t.Fatalf("expected no RTT for sending a query, got %s", sent.RTT)
+	}
+	time.Sleep(20 * time.Millisecond)
+	resp := tr.trace(&notif.QueryEvent{Type: notif.PeerResponse, ID: pid})
+	if resp.RTT < 20*time.Millisecond || resp.Elapsed < resp.RTT {
+		t.Fatalf("unexpected timing of the response: %+v", resp)
+	}
+
+	buf := new(bytes.Buffer)
+	printQueryEvent(resp, buf, true, nil)
+	if !strings.Contains(buf.String(), "* "+pid.Pretty()+" says use (in ") {
+		t.Fatalf("unexpected verbose output: %q", buf.String())
+	}
+	buf.Reset()
+	printQueryEvent(resp, buf, false, nil)
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing printed without verbose, got %q", buf.String())
+	}
+}
+
+func TestDhtQueryEventJSON(t *testing.T) {
+	pid := tu.RandPeerIDFatal(t)
+	in := &DhtQueryEvent{
+		Event:   &notif.QueryEvent{Type: notif.QueryError, ID: pid, Extra: "timeout"},
+		Elapsed: 3 * time.Second,
+		RTT:     time.Second,
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fields of the query event are kept
+	var ev notif.QueryEvent
+	if err := json.Unmarshal(b, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != notif.QueryError || ev.ID != pid || ev.Extra != "timeout" {
+		t.Fatalf("unexpected query event: %+v", ev)
+	}
+
+	var out DhtQueryEvent
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Event.ID != pid || out.Elapsed != in.Elapsed || out.RTT != in.RTT {
+		t.Fatalf("expected %+v, got %+v", in, out)
+	}
+}
@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// DiagUsage is an entry of 'ipfs diag largest'.
+type DiagUsage struct {
+	Cid    string
+	Path   string `json:",omitempty"`
+	Size   uint64
+	Blocks int
+}
+
+// DiagLargestOutput is the output of 'ipfs diag largest'.
+type DiagLargestOutput struct {
+	Type    string
+	Entries []DiagUsage
+}
+
+var diagLargestCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show what uses the most space in the repo.",
+		ShortDescription: `
+'ipfs diag largest' lists the largest entries of the repo, by --type:
+
+  pins    the pins whose unpinning would free the most bytes: the bytes of
+          the blocks no other pin nor the files API references
+  blocks  the largest blocks
+  mfs     the files and directories of the files API ('ipfs files') with the
+          most bytes, each block counted once
+
+Only the local blocks are counted, nothing is fetched. Walking the pins and
+the files API takes as long as a garbage collection.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("type", "t", "What to list: pins, blocks or mfs.").WithDefault("pins"),
+		cmdkit.IntOption("count", "n", "The number of entries to list.").WithDefault(20),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		typ, _, _ := req.Option("type").String()
+		count, _, _ := req.Option("count").Int()
+		if count < 1 {
+			res.SetError(fmt.Errorf("the count must be positive"), cmdkit.ErrClient)
+			return
+		}
+
+		var usages []corerepo.Usage
+		switch typ {
+		case "pins":
+			usages, err = corerepo.LargestPins(req.Context(), n, count)
+		case "blocks":
+			usages, err = corerepo.LargestBlocks(req.Context(), n, count)
+		case "mfs":
+			usages, err = corerepo.LargestMFS(req.Context(), n, count)
+		default:
+			res.SetError(fmt.Errorf("unknown type %q, expected pins, blocks or mfs", typ), cmdkit.ErrClient)
+			return
+		}
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		out := &DiagLargestOutput{Type: typ, Entries: make([]DiagUsage, len(usages))}
+		for i, u := range usages {
+			out.Entries[i] = DiagUsage{
+				Cid:    u.Cid.String(),
+				Path:   u.Path,
+				Size:   u.Size,
+				Blocks: u.Blocks,
+			}
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*DiagLargestOutput)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			buf := new(bytes.Buffer)
+			w := tabwriter.NewWriter(buf, 1, 2, 2, ' ', 0)
+			for _, u := range out.Entries {
+				switch out.Type {
+				case "blocks":
+					fmt.Fprintf(w, "%s\t%s\n", humanize.Bytes(u.Size), u.Cid)
+				case "mfs":
+					fmt.Fprintf(w, "%s\t%d blocks\t%s\t%s\n", humanize.Bytes(u.Size), u.Blocks, u.Path, u.Cid)
+				default:
+					fmt.Fprintf(w, "%s\t%d blocks\t%s\n", humanize.Bytes(u.Size), u.Blocks, u.Cid)
+				}
+			}
+			w.Flush()
+			return buf, nil
+		},
+	},
+	Type: DiagLargestOutput{},
+}
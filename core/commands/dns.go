@@ -1,18 +1,27 @@
 package commands
 
 import (
+	"fmt"
 	"io"
 	"strings"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
-	ncmd "github.com/ipfs/go-ipfs/core/commands/name"
 	namesys "github.com/ipfs/go-ipfs/namesys"
 	nsopts "github.com/ipfs/go-ipfs/namesys/opts"
 
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
 )
 
+// DNSResult is the resolution of a domain by 'ipfs dns', with the error of
+// the domain when several are resolved.
+type DNSResult struct {
+	Name  string
+	Path  path.Path `json:",omitempty"`
+	Error string    `json:",omitempty"`
+}
+
 var DNSCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Resolve DNS links.",
@@ -44,19 +53,36 @@ The resolver can recursively resolve:
 	dnslink=/ipns/ipfs.io
 	> ipfs dns -r recursive.ipfs.io
 	/ipfs/QmRzTuh2Lpuz7Gr39stNr6mTFdqAghsZec1JoUnfySUzcy
+
+Several domains are resolved concurrently, each result giving its domain,
+and its path or its error:
+
+	> ipfs dns ipfs.io example.com
+	ipfs.io: /ipfs/QmRzTuh2Lpuz7Gr39stNr6mTFdqAghsZec1JoUnfySUzcy
+	example.com: error: could not resolve name
+
+DNS has no portable way to list the subdomains of a domain: the subdomains
+to look up the DNS links of are given with --subdomains, and only those
+which have one are listed:
+
+	> ipfs dns --subdomains=www,docs,blog ipfs.io
+	ipfs.io: /ipfs/QmRzTuh2Lpuz7Gr39stNr6mTFdqAghsZec1JoUnfySUzcy
+	docs.ipfs.io: /ipfs/QmRzTuh2Lpuz7Gr39stNr6mTFdqAghsZec1JoUnfySUzcy
 `,
 	},
 
 	Arguments: []cmdkit.Argument{
-		cmdkit.StringArg("domain-name", true, false, "The domain-name name to resolve.").EnableStdin(),
+		cmdkit.StringArg("domain-name", true, true, "The domain-name names to resolve.").EnableStdin(),
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("recursive", "r", "Resolve until the result is not a DNS link."),
+		cmdkit.StringOption("subdomains", "s", "Comma-separated subdomains of the domains to list the DNS links of."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 
 		recursive, _, _ := req.Option("recursive").Bool()
-		name := req.Arguments()[0]
+		subdomains, _, _ := req.Option("subdomains").String()
+		names := req.Arguments()
 		resolver := namesys.NewDNSResolver()
 
 		var ropts []nsopts.ResolveOpt
@@ -64,16 +90,57 @@ The resolver can recursively resolve:
 			ropts = append(ropts, nsopts.Depth(1))
 		}
 
-		output, err := resolver.Resolve(req.Context(), name, ropts...)
-		if err == namesys.ErrResolveFailed {
-			res.SetError(err, cmdkit.ErrNotFound)
+		if !dnsBatch(req) {
+			output, err := resolver.Resolve(req.Context(), names[0], ropts...)
+			if err == namesys.ErrResolveFailed {
+				res.SetError(err, cmdkit.ErrNotFound)
+				return
+			}
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+			res.SetOutput(&DNSResult{Name: names[0], Path: output})
 			return
 		}
-		if err != nil {
-			res.SetError(err, cmdkit.ErrNormal)
-			return
+
+		// the subdomains are looked up after the domains, and only listed
+		// when they have a DNS link
+		domains := len(names)
+		for _, sub := range strings.Split(subdomains, ",") {
+			sub = strings.Trim(strings.TrimSpace(sub), ".")
+			if sub == "" {
+				continue
+			}
+			for _, name := range names[:domains] {
+				names = append(names, sub+"."+name)
+			}
 		}
-		res.SetOutput(&ncmd.ResolvedPath{Path: output})
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			i := 0
+			for r := range resolver.ResolveDomains(req.Context(), names, ropts...) {
+				out := &DNSResult{Name: r.Name, Path: r.Path}
+				if r.Err != nil {
+					if i >= domains && r.Err == namesys.ErrResolveFailed {
+						i++
+						continue
+					}
+					out.Error = r.Err.Error()
+				}
+				i++
+
+				select {
+				case outChan <- out:
+				case <-req.Context().Done():
+					return
+				}
+			}
+		}()
 	},
 	Marshalers: cmds.MarshalerMap{
 		cmds.Text: func(res cmds.Response) (io.Reader, error) {
@@ -82,12 +149,26 @@ The resolver can recursively resolve:
 				return nil, err
 			}
 
-			output, ok := v.(*ncmd.ResolvedPath)
+			output, ok := v.(*DNSResult)
 			if !ok {
 				return nil, e.TypeErr(output, v)
 			}
-			return strings.NewReader(output.Path.String() + "\n"), nil
+			if !dnsBatch(res.Request()) {
+				return strings.NewReader(output.Path.String() + "\n"), nil
+			}
+			if output.Error != "" {
+				return strings.NewReader(fmt.Sprintf("%s: error: %s\n", output.Name, output.Error)), nil
+			}
+			return strings.NewReader(fmt.Sprintf("%s: %s\n", output.Name, output.Path)), nil
 		},
 	},
-	Type: ncmd.ResolvedPath{},
+	Type: DNSResult{},
+}
+
+// dnsBatch returns whether req resolves several domains, or lists
+// subdomains, the errors being given with the results of their domains
+// rather than failing the command.
+func dnsBatch(req cmds.Request) bool {
+	subdomains, _, _ := req.Option("subdomains").String()
+	return len(req.Arguments()) > 1 || subdomains != ""
 }
@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	features "github.com/ipfs/go-ipfs/core/features"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// FeatureOutput describes an experimental or deprecated feature.
+type FeatureOutput struct {
+	Name        string
+	Stage       string
+	Enabled     bool
+	Description string
+	Warning     string `json:",omitempty"`
+}
+
+type FeatureList struct {
+	Features []FeatureOutput
+}
+
+var FeaturesCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage experimental and deprecated features.",
+		ShortDescription: `
+Experimental features are disabled by default and have to be enabled in the
+config before use. Deprecated features still work but print a warning with
+their removal timeline when used.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"ls":      featuresLsCmd,
+		"enable":  featuresEnableCmd,
+		"disable": featuresDisableCmd,
+	},
+}
+
+var featuresLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List experimental and deprecated features.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		var out FeatureList
+		for _, f := range features.All() {
+			enabled, err := features.Enabled(n.Repo, f.Name)
+			if err != nil {
+				return err
+			}
+
+			fo := FeatureOutput{
+				Name:        f.Name,
+				Stage:       f.Stage.String(),
+				Enabled:     enabled,
+				Description: f.Description,
+			}
+			if f.Stage == features.Deprecated {
+				fo.Warning = f.Warning()
+			}
+			out.Features = append(out.Features, fo)
+		}
+
+		return cmds.EmitOnce(res, &out)
+	},
+	Type: FeatureList{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			list, ok := v.(*FeatureList)
+			if !ok {
+				return e.TypeErr(list, v)
+			}
+
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			for _, f := range list.Features {
+				state := "disabled"
+				if f.Enabled {
+					state = "enabled"
+				}
+				desc := f.Description
+				if f.Warning != "" {
+					desc = f.Warning
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Name, f.Stage, state, desc)
+			}
+			return tw.Flush()
+		}),
+	},
+}
+
+var featuresEnableCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Enable an experimental feature.",
+		ShortDescription: `
+Enables an experimental feature in the config. A running daemon has to be
+restarted for the change to take effect.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("feature", true, false, "Name of the feature."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		return setFeature(req, env, true)
+	},
+}
+
+var featuresDisableCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Disable an experimental feature.",
+		ShortDescription: `
+Disables an experimental feature in the config. A running daemon has to be
+restarted for the change to take effect.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("feature", true, false, "Name of the feature."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		return setFeature(req, env, false)
+	},
+}
+
+func setFeature(req *cmds.Request, env cmds.Environment, enabled bool) error {
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+
+	if err := features.SetEnabled(n.Repo, req.Arguments[0], enabled); err != nil {
+		return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -9,15 +10,21 @@ import (
 	"os"
 	gopath "path"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	lgc "github.com/ipfs/go-ipfs/commands/legacy"
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+	features "github.com/ipfs/go-ipfs/core/features"
+	repo "github.com/ipfs/go-ipfs/repo"
 	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
 	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
+	mod "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/mod"
 	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
 	resolver "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path/resolver"
 	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
@@ -26,7 +33,6 @@ import (
 	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
 	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
-	mh "gx/ipfs/QmPnFwZ2JXKnXgMw8CdBPxn7FWh6LLdjUjxV1fKHuJnkr8/go-multihash"
 	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
 	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
 	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
@@ -55,24 +61,31 @@ operations.
 `,
 	},
 	Options: []cmdkit.Option{
-		cmdkit.BoolOption("f", "flush", "Flush target and ancestors after write.").WithDefault(true),
+		cmdkit.BoolOption("f", "flush", "Flush target and ancestors after write. Defaults to true unless Mfs.WriteBack is enabled."),
 	},
 	Subcommands: map[string]*cmds.Command{
-		"read":  lgc.NewCommand(filesReadCmd),
-		"write": filesWriteCmd,
-		"mv":    lgc.NewCommand(filesMvCmd),
-		"cp":    lgc.NewCommand(filesCpCmd),
-		"ls":    lgc.NewCommand(filesLsCmd),
-		"mkdir": lgc.NewCommand(filesMkdirCmd),
-		"stat":  filesStatCmd,
-		"rm":    lgc.NewCommand(filesRmCmd),
-		"flush": lgc.NewCommand(filesFlushCmd),
-		"chcid": lgc.NewCommand(filesChcidCmd),
+		"read":       lgc.NewCommand(filesReadCmd),
+		"write":      filesWriteCmd,
+		"mv":         lgc.NewCommand(filesMvCmd),
+		"cp":         lgc.NewCommand(filesCpCmd),
+		"ls":         lgc.NewCommand(filesLsCmd),
+		"mirror":     filesMirrorCmd,
+		"mkdir":      lgc.NewCommand(filesMkdirCmd),
+		"stat":       filesStatCmd,
+		"rm":         lgc.NewCommand(filesRmCmd),
+		"flush":      lgc.NewCommand(filesFlushCmd),
+		"chcid":      lgc.NewCommand(filesChcidCmd),
+		"export":     filesExportCmd,
+		"export-car": filesExportCarCmd,
+		"chmod":      filesChmodCmd,
+		"touch":      filesTouchCmd,
+		"sync":       filesSyncCmd,
+		"watch":      filesWatchCmd,
 	},
 }
 
-var cidVersionOption = cmdkit.IntOption("cid-version", "cid-ver", "Cid version to use. (experimental)")
-var hashOption = cmdkit.StringOption("hash", "Hash function to use. Will set Cid version to 1 if used. (experimental)")
+var cidVersionOption = cmdkit.IntOption("cid-version", "cid-ver", features.Help(features.CidVersion, "Cid version to use."))
+var hashOption = cmdkit.StringOption("hash", features.Help(features.HashFunction, "Hash function to use. Will set Cid version to 1 if used."))
 
 var errFormat = errors.New("format was set by multiple options. Only one format option is allowed")
 
@@ -85,6 +98,8 @@ type statOutput struct {
 	WithLocality   bool   `json:",omitempty"`
 	Local          bool   `json:",omitempty"`
 	SizeLocal      uint64 `json:",omitempty"`
+	Mode           uint32 `json:",omitempty"`
+	Mtime          int64  `json:",omitempty"`
 }
 
 const defaultStatFormat = `<hash>
@@ -103,7 +118,7 @@ var filesStatCmd = &cmds.Command{
 	},
 	Options: []cmdkit.Option{
 		cmdkit.StringOption("format", "Print statistics in given format. Allowed tokens: "+
-			"<hash> <size> <cumulsize> <type> <childs>. Conflicts with other format options.").WithDefault(defaultStatFormat),
+			"<hash> <size> <cumulsize> <type> <childs> <mode> <mtime>. Conflicts with other format options.").WithDefault(defaultStatFormat),
 		cmdkit.BoolOption("hash", "Print only hash. Implies '--format=<hash>'. Conflicts with other format options."),
 		cmdkit.BoolOption("size", "Print only size. Implies '--format=<cumulsize>'. Conflicts with other format options."),
 		cmdkit.BoolOption("with-local", "Compute the amount of the dag that is local, and if possible the total size"),
@@ -148,6 +163,13 @@ var filesStatCmd = &cmds.Command{
 			return err
 		}
 
+		m, err := coreunix.FileMetaFromNode(nd)
+		if err != nil {
+			return err
+		}
+		o.Mode = uint32(m.Mode)
+		o.Mtime = m.Mtime
+
 		if !withLocal {
 			return cmds.EmitOnce(res, o)
 		}
@@ -167,15 +189,26 @@ var filesStatCmd = &cmds.Command{
 				return e.TypeErr(out, v)
 			}
 
-			s, _ := statGetFormatOptions(req)
-			s = strings.Replace(s, "<hash>", out.Hash, -1)
+			format, _ := statGetFormatOptions(req)
+			s := strings.Replace(format, "<hash>", out.Hash, -1)
 			s = strings.Replace(s, "<size>", fmt.Sprintf("%d", out.Size), -1)
 			s = strings.Replace(s, "<cumulsize>", fmt.Sprintf("%d", out.CumulativeSize), -1)
 			s = strings.Replace(s, "<childs>", fmt.Sprintf("%d", out.Blocks), -1)
 			s = strings.Replace(s, "<type>", out.Type, -1)
+			s = strings.Replace(s, "<mode>", statMode(out), -1)
+			s = strings.Replace(s, "<mtime>", statMtime(out), -1)
 
 			fmt.Fprintln(w, s)
 
+			if format == defaultStatFormat {
+				if out.Mode != 0 {
+					fmt.Fprintf(w, "Mode: %s\n", statMode(out))
+				}
+				if out.Mtime != 0 {
+					fmt.Fprintf(w, "Mtime: %s\n", statMtime(out))
+				}
+			}
+
 			if out.WithLocality {
 				fmt.Fprintf(w, "Local: %s of %s (%.2f%%)\n",
 					humanize.Bytes(out.SizeLocal),
@@ -194,6 +227,22 @@ func moreThanOne(a, b, c bool) bool {
 	return a && b || b && c || a && c
 }
 
+// statMode formats the recorded permissions in octal, or returns "-" when
+// none were recorded.
+func statMode(out *statOutput) string {
+	if out.Mode == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%04o", out.Mode)
+}
+
+func statMtime(out *statOutput) string {
+	if out.Mtime == 0 {
+		return "-"
+	}
+	return time.Unix(out.Mtime, 0).UTC().Format(time.RFC3339)
+}
+
 func statGetFormatOptions(req *cmds.Request) (string, error) {
 
 	hash, _ := req.Options["hash"].(bool)
@@ -290,14 +339,34 @@ func walkBlock(ctx context.Context, dagserv ipld.DAGService, nd ipld.Node) (bool
 	return local, sizeLocal, nil
 }
 
+// FilesCpOutput reports the progress of fetching the DAG copied by
+// 'ipfs files cp --fetch --progress'.
+type FilesCpOutput struct {
+	Fetched int
+}
+
 var filesCpCmd = &oldcmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Copy files into mfs.",
+		ShortDescription: `
+Copy <source> to <dest> in mfs. Only the root of <source> is copied: the rest
+of its DAG is fetched when it is read, so content copied from the network may
+not be available offline. With '--fetch', the whole DAG is fetched before it
+is copied, and '--progress' shows the number of fetched nodes. The default of
+'--fetch' is set by the Mfs.FetchOnCopy config:
+
+  $ ipfs config --json Mfs.FetchOnCopy true
+`,
 	},
 	Arguments: []cmdkit.Argument{
 		cmdkit.StringArg("source", true, false, "Source object to copy."),
 		cmdkit.StringArg("dest", true, false, "Destination to copy object to."),
 	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("fetch", "Fetch the whole DAG of the source before copying it. Default: Mfs.FetchOnCopy or false."),
+		cmdkit.BoolOption("progress", "Show the progress of --fetch."),
+	},
+	Type: FilesCpOutput{},
 	Run: func(req oldcmds.Request, res oldcmds.Response) {
 		node, err := req.InvocContext().GetNode()
 		if err != nil {
@@ -305,7 +374,14 @@ var filesCpCmd = &oldcmds.Command{
 			return
 		}
 
-		flush, _, _ := req.Option("flush").Bool()
+		flush := filesFlush(node, req)
+
+		fetch, err := filesFetch(node, req)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		progress, _, _ := req.Option("progress").Bool()
 
 		src, err := checkPath(req.Arguments()[0])
 		if err != nil {
@@ -323,6 +399,10 @@ var filesCpCmd = &oldcmds.Command{
 		if dst[len(dst)-1] == '/' {
 			dst += gopath.Base(src)
 		}
+		if err := checkNotMirrored(node, dst); err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
 
 		nd, err := getNodeFromPath(req.Context(), node, node.DAG, src)
 		if err != nil {
@@ -330,21 +410,182 @@ var filesCpCmd = &oldcmds.Command{
 			return
 		}
 
-		err = mfs.PutNode(node.FilesRoot, dst, nd)
-		if err != nil {
-			res.SetError(fmt.Errorf("cp: cannot put node in path %s: %s", dst, err), cmdkit.ErrNormal)
+		if fetch {
+			// Keep gc away from the fetched blocks until they are
+			// referenced by mfs.
+			defer node.Blockstore.PinLock().Unlock()
+		}
+
+		if !fetch || !progress {
+			if fetch {
+				if err := dag.FetchGraph(req.Context(), nd.Cid(), node.DAG); err != nil {
+					res.SetError(fmt.Errorf("cp: cannot fetch %s: %s", src, err), cmdkit.ErrNormal)
+					return
+				}
+			}
+
+			if err := filesCp(req.Context(), node, src, dst, nd, flush); err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+			res.SetOutput(nil)
 			return
 		}
 
-		if flush {
-			err := mfs.FlushPath(node.FilesRoot, dst)
-			if err != nil {
-				res.SetError(fmt.Errorf("cp: cannot flush the created file %s: %s", dst, err), cmdkit.ErrNormal)
+		out := make(chan interface{})
+		res.SetOutput((<-chan interface{})(out))
+		defer close(out)
+
+		v := new(dag.ProgressTracker)
+		ctx := v.DeriveContext(req.Context())
+
+		done := make(chan error, 1)
+		go func() {
+			done <- dag.FetchGraph(ctx, nd.Cid(), node.DAG)
+		}()
+
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+	loop:
+		for {
+			select {
+			case err := <-done:
+				if err != nil {
+					res.SetError(fmt.Errorf("cp: cannot fetch %s: %s", src, err), cmdkit.ErrNormal)
+					return
+				}
+				out <- &FilesCpOutput{Fetched: v.Value()}
+				break loop
+			case <-ticker.C:
+				out <- &FilesCpOutput{Fetched: v.Value()}
+			case <-ctx.Done():
+				res.SetError(ctx.Err(), cmdkit.ErrNormal)
 				return
 			}
 		}
 
-		res.SetOutput(nil)
+		if err := filesCp(req.Context(), node, src, dst, nd, flush); err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+	},
+	Marshalers: oldcmds.MarshalerMap{
+		oldcmds.Text: func(res oldcmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*FilesCpOutput)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			fmt.Fprintf(res.Stderr(), "Fetched %d nodes\r", out.Fetched)
+			return bytes.NewReader(nil), nil
+		},
+	},
+}
+
+// filesCp puts nd at dst in mfs, as the copy of src.
+func filesCp(ctx context.Context, node *core.IpfsNode, src, dst string, nd ipld.Node, flush bool) error {
+	root, unlock := node.LockFilesRoot()
+	err := mfs.PutNode(root, dst, nd)
+	unlock()
+	if err != nil {
+		return fmt.Errorf("cp: cannot put node in path %s: %s", dst, err)
+	}
+
+	err = reshardFiles(ctx, node, false, gopath.Dir(dst))
+	if err != nil {
+		return err
+	}
+
+	if flush {
+		err := flushFiles(node, dst)
+		if err != nil {
+			return fmt.Errorf("cp: cannot flush the created file %s: %s", dst, err)
+		}
+	} else if err := node.FilesWriteBack.RecordPut(dst, nd); err != nil {
+		return err
+	}
+
+	notifyFiles(node, "cp", dst, "", flush)
+	return nil
+}
+
+var filesExportCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Export files from mfs to the local filesystem.",
+		ShortDescription: `
+Write the file or directory tree at <path> to <local-path> on the local
+filesystem, like 'ipfs get' does for an immutable path. The directory
+structure and the symlinks are recreated, and the files and directories get
+the mode and modification time recorded for them, with 'ipfs files chmod',
+'ipfs files touch' or 'ipfs add --preserve-mode --preserve-mtime'.
+
+Example:
+
+    $ ipfs files export /myfs/site ./site-backup
+    Saving file(s) to ./site-backup
+
+When used over the HTTP API, the subtree is returned as a TAR archive.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("path", true, false, "Path to the mfs file or directory to export."),
+		cmdkit.StringArg("local-path", true, false, "Local path to write the exported files to."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		src, err := checkPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		nd, err := getNodeFromPath(req.Context, node, node.DAG, src)
+		if err != nil {
+			return fmt.Errorf("export: cannot get node from path %s: %s", src, err)
+		}
+
+		size, err := nd.Size()
+		if err != nil {
+			return err
+		}
+		res.SetLength(size)
+
+		return res.Emit(tarStream(req.Context, node, nd, gopath.Base(src), gzip.NoCompression, func(tw *coreunix.TarWriter) {
+			tw.Meta = func(nd ipld.Node, fpath string) (coreunix.FileMeta, error) {
+				return coreunix.FileMetaFromNode(nd)
+			}
+		}))
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+
+			outReader, ok := v.(io.Reader)
+			if !ok {
+				return e.New(e.TypeErr(outReader, v))
+			}
+
+			gw := getWriter{
+				Out:         os.Stdout,
+				Err:         os.Stderr,
+				Compression: gzip.NoCompression,
+				Size:        int64(res.Length()),
+				RestoreMeta: true,
+			}
+
+			return gw.Write(outReader, res.Request().Arguments[1])
+		},
 	},
 }
 
@@ -363,7 +604,10 @@ func getNodeFromPath(ctx context.Context, node *core.IpfsNode, dagservice ipld.D
 
 		return core.Resolve(ctx, node.Namesys, resolver, np)
 	default:
-		fsn, err := mfs.Lookup(node.FilesRoot, p)
+		root, unlock := node.LockFilesRoot()
+		defer unlock()
+
+		fsn, err := mfs.Lookup(root, p)
 		if err != nil {
 			return nil, err
 		}
@@ -425,7 +669,10 @@ Examples:
 			return
 		}
 
-		fsn, err := mfs.Lookup(nd.FilesRoot, path)
+		root, unlock := nd.LockFilesRoot()
+		defer unlock()
+
+		fsn, err := mfs.Lookup(root, path)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
@@ -553,7 +800,10 @@ Examples:
 			return
 		}
 
-		fsn, err := mfs.Lookup(n.FilesRoot, path)
+		root, unlock := n.LockFilesRoot()
+		defer unlock()
+
+		fsn, err := mfs.Lookup(root, path)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
@@ -665,13 +915,41 @@ Example:
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
+		if err := checkNotMirrored(n, src, dst); err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
+
+		root, unlock := n.LockFilesRoot()
+
+		// mv into a directory keeps the name of the source
+		moved := dst
+		if target, err := mfs.Lookup(root, dst); err == nil {
+			if _, ok := target.(*mfs.Directory); ok {
+				moved = gopath.Join(dst, gopath.Base(src))
+			}
+		} else if strings.HasSuffix(dst, "/") {
+			moved = gopath.Join(dst, gopath.Base(src))
+		}
+
+		err = mfs.Mv(root, src, dst)
+		if err == nil {
+			err = recordFilesMv(n, root, src, moved)
+		}
+		unlock()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
 
-		err = mfs.Mv(n.FilesRoot, src, dst)
+		err = reshardFiles(req.Context(), n, true, gopath.Dir(gopath.Clean(src)), gopath.Dir(moved))
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
+		notifyFiles(n, "mv", moved, src, true)
+
 		res.SetOutput(nil)
 	},
 }
@@ -694,6 +972,9 @@ Newly created leaves will be in the legacy format (Protobuf) if the
 CID version is 0, or raw is the CID version is non-zero.  Use of the
 --raw-leaves option will override this behavior.
 
+The data written is chunked with the Import.Chunker config, chunks of 256KiB
+by default.
+
 If the '--flush' option is set to false, changes will not be propogated to the
 merkledag root. This can make operations much faster when doing a large number
 of writes to a deeper directory structure.
@@ -720,7 +1001,7 @@ stat' on the file or any of its ancestors.
 		cmdkit.BoolOption("parents", "p", "Make parent directories as needed."),
 		cmdkit.BoolOption("truncate", "t", "Truncate the file to size zero before writing."),
 		cmdkit.IntOption("count", "n", "Maximum number of bytes to read."),
-		cmdkit.BoolOption("raw-leaves", "Use raw blocks for newly created leaf nodes. (experimental)"),
+		cmdkit.BoolOption("raw-leaves", features.Help(features.RawLeaves, "Use raw blocks for newly created leaf nodes. Default: Import.RawLeaves, or true with CIDv1.")),
 		cidVersionOption,
 		hashOption,
 	},
@@ -733,7 +1014,6 @@ stat' on the file or any of its ancestors.
 		create, _ := req.Options["create"].(bool)
 		mkParents, _ := req.Options["parents"].(bool)
 		trunc, _ := req.Options["truncate"].(bool)
-		flush, _ := req.Options["flush"].(bool)
 		rawLeaves, rawLeavesDef := req.Options["raw-leaves"].(bool)
 
 		prefix, err := getPrefixNew(req)
@@ -745,40 +1025,105 @@ stat' on the file or any of its ancestors.
 		if err != nil {
 			return err
 		}
+		flush := filesFlushNew(nd, req)
+		if err := checkNotMirrored(nd, path); err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+		}
+
+		prefix, defaults, err := importDefaults(nd.Repo, prefix)
+		if err != nil {
+			return err
+		}
+		if !rawLeavesDef {
+			rawLeaves, rawLeavesDef = defaults.RawLeaves, defaults.RawLeavesSet
+		}
 
 		offset, _ := req.Options["offset"].(int)
 		if offset < 0 {
 			return fmt.Errorf("cannot have negative write offset")
 		}
 
+		// the root stays locked until the file is closed, the root
+		// directory is resharded after it is unlocked
+		root, unlock := nd.LockFilesRoot()
+
 		if mkParents {
-			err := ensureContainingDirectoryExists(nd.FilesRoot, path, prefix)
+			err := ensureContainingDirectoryExists(root, path, prefix)
 			if err != nil {
+				unlock()
 				return err
 			}
 		}
 
-		fi, err := getFileHandle(nd.FilesRoot, path, create, prefix)
+		fi, err := getFileHandle(root, path, create, prefix)
 		if err != nil {
+			unlock()
 			return err
 		}
 		if rawLeavesDef {
 			fi.RawLeaves = rawLeaves
 		}
 
-		wfd, err := fi.Open(mfs.OpenWriteOnly, flush)
+		// the metadata set on the file may not survive the write
+		prev, err := fileNodeMeta(fi)
 		if err != nil {
+			unlock()
 			return err
 		}
 
+		var wfd fileWriter
+		var closeFile func() error
+		if defaults.Chunker == coreunix.DefaultChunker {
+			fd, err := fi.Open(mfs.OpenWriteOnly, flush)
+			if err != nil {
+				unlock()
+				return err
+			}
+			wfd, closeFile = fd, fd.Close
+		} else {
+			// mfs always writes chunks of the default size
+			dmod, err := newFileModifier(req.Context, nd, fi, defaults.Chunker)
+			if err != nil {
+				unlock()
+				return err
+			}
+			wfd = dmod
+			closeFile = func() error {
+				return replaceModifiedFile(req.Context, nd, root, path, dmod, flush)
+			}
+		}
+
 		defer func() {
-			err := wfd.Close()
+			err := closeFile()
+			switch {
+			case err != nil || retErr != nil:
+			case !prev.IsZero():
+				err = setFileMeta(req.Context, nd, root, path, flush, func(m *coreunix.FileMeta) {
+					m.Mode = prev.Mode
+					if prev.Mtime != 0 {
+						m.Mtime = time.Now().Unix()
+					}
+				})
+			case !flush:
+				var fsn mfs.FSNode
+				if fsn, err = mfs.Lookup(root, path); err == nil {
+					err = recordFileNode(nd, path, fsn)
+				}
+			}
+			unlock()
 			if err != nil {
 				if retErr == nil {
 					retErr = err
 				} else {
 					log.Error("files: error closing file mfs file descriptor", err)
 				}
+				return
+			}
+			if retErr == nil && create {
+				retErr = reshardFiles(req.Context, nd, flush, gopath.Dir(path))
+			}
+			if retErr == nil {
+				notifyFiles(nd, "write", path, "", flush)
 			}
 		}()
 
@@ -853,26 +1198,56 @@ Examples:
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
+		if err := checkNotMirrored(n, dirtomake); err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
 
-		flush, _, _ := req.Option("flush").Bool()
+		flush := filesFlush(n, req)
 
 		prefix, err := getPrefix(req)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
-		root := n.FilesRoot
-
+		prefix, _, err = importDefaults(n.Repo, prefix)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		root, unlock := n.LockFilesRoot()
 		err = mfs.Mkdir(root, dirtomake, mfs.MkdirOpts{
 			Mkparents:  dashp,
 			Flush:      flush,
 			CidBuilder: prefix,
 		})
+		unlock()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		err = reshardFiles(req.Context(), n, flush, gopath.Dir(gopath.Clean(dirtomake)))
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
+		if !flush {
+			root, unlock := n.LockFilesRoot()
+			fsn, err := mfs.Lookup(root, dirtomake)
+			if err == nil {
+				err = recordFileNode(n, dirtomake, fsn)
+			}
+			unlock()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		notifyFiles(n, "mkdir", dirtomake, "", flush)
+
 		res.SetOutput(nil)
 	},
 }
@@ -900,7 +1275,7 @@ are run with the '--flush=false'.
 			path = req.Arguments()[0]
 		}
 
-		err = mfs.FlushPath(nd.FilesRoot, path)
+		err = flushFiles(nd, path)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
@@ -935,8 +1310,12 @@ Change the cid version or hash function of the root node of a given path.
 		if len(req.Arguments()) > 0 {
 			path = req.Arguments()[0]
 		}
+		if err := checkNotMirrored(nd, path); err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
 
-		flush, _, _ := req.Option("flush").Bool()
+		flush := filesFlush(nd, req)
 
 		prefix, err := getPrefix(req)
 		if err != nil {
@@ -944,12 +1323,19 @@ Change the cid version or hash function of the root node of a given path.
 			return
 		}
 
-		err = updatePath(nd.FilesRoot, path, prefix, flush)
+		root, unlock := nd.LockFilesRoot()
+		err = updatePath(root, path, prefix, flush)
+		if err == nil && !flush {
+			err = recordFilesPath(nd, root, path)
+		}
+		unlock()
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
+		notifyFiles(nd, "chcid", path, "", flush)
+
 		res.SetOutput(nil)
 	},
 }
@@ -1018,6 +1404,10 @@ Remove files or directories.
 			res.SetError(fmt.Errorf("cannot delete root"), cmdkit.ErrNormal)
 			return
 		}
+		if err := checkNotMirrored(nd, path); err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
 
 		// 'rm a/b/c/' will fail unless we trim the slash at the end
 		if path[len(path)-1] == '/' {
@@ -1025,14 +1415,17 @@ Remove files or directories.
 		}
 
 		dir, name := gopath.Split(path)
-		parent, err := mfs.Lookup(nd.FilesRoot, dir)
+		root, unlock := nd.LockFilesRoot()
+		parent, err := mfs.Lookup(root, dir)
 		if err != nil {
+			unlock()
 			res.SetError(fmt.Errorf("parent lookup: %s", err), cmdkit.ErrNormal)
 			return
 		}
 
 		pdir, ok := parent.(*mfs.Directory)
 		if !ok {
+			unlock()
 			res.SetError(fmt.Errorf("no such file or directory: %s", path), cmdkit.ErrNormal)
 			return
 		}
@@ -1041,12 +1434,27 @@ Remove files or directories.
 
 		var success bool
 		defer func() {
+			unlock()
 			if success {
-				err := pdir.Flush()
+				// pdir is replaced if it gets resharded, so flush it by path.
+				err := reshardFiles(req.Context(), nd, false, dir)
 				if err != nil {
 					res.SetError(err, cmdkit.ErrNormal)
 					return
 				}
+
+				flush := filesFlush(nd, req)
+				if flush {
+					err = flushFiles(nd, dir)
+				} else {
+					err = nd.FilesWriteBack.RecordRm(path)
+				}
+				if err != nil {
+					res.SetError(err, cmdkit.ErrNormal)
+					return
+				}
+
+				notifyFiles(nd, "rm", path, "", flush)
 			}
 		}()
 
@@ -1084,6 +1492,428 @@ Remove files or directories.
 	},
 }
 
+var filesChmodCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Change the permissions of a file or directory.",
+		ShortDescription: `
+Set the POSIX permissions of a file or directory in mfs. They are stored in
+its unixfs node, so the change gives it, and its parents, a new hash.
+
+    $ ipfs files chmod 0644 /foo/bar
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("mode", true, false, "Octal permission bits."),
+		cmdkit.StringArg("path", true, false, "Path to change."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		mode, err := strconv.ParseUint(req.Arguments[0], 8, 32)
+		if err != nil || mode > 0777 {
+			return cmdkit.Errorf(cmdkit.ErrClient, "invalid mode %q", req.Arguments[0])
+		}
+
+		path, err := checkPath(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+		path = gopath.Clean(path)
+
+		flush := filesFlushNew(n, req)
+		if err := checkNotMirrored(n, path); err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+		}
+
+		root, unlock := n.LockFilesRoot()
+		err = setFileMeta(req.Context, n, root, path, flush, func(m *coreunix.FileMeta) {
+			m.Mode = os.FileMode(mode)
+		})
+		unlock()
+		if err != nil {
+			return err
+		}
+
+		notifyFiles(n, "chmod", path, "", flush)
+		return nil
+	},
+}
+
+var filesTouchCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Change the modification time of a file or directory.",
+		ShortDescription: `
+Set the modification time of a file or directory in mfs to the current time,
+or the one given with --mtime. The file is created if it doesn't exist. As
+with 'ipfs files chmod', the time is stored in its unixfs node.
+
+    $ ipfs files touch /foo/bar
+    $ ipfs files touch --mtime=1546300800 /foo/bar
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("path", true, false, "Path to touch."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.IntOption("mtime", "Modification time in seconds since the epoch."),
+		cidVersionOption,
+		hashOption,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		path, err := checkPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		path = gopath.Clean(path)
+
+		flush := filesFlushNew(n, req)
+		if err := checkNotMirrored(n, path); err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+		}
+
+		prefix, err := getPrefixNew(req)
+		if err != nil {
+			return err
+		}
+		prefix, _, err = importDefaults(n.Repo, prefix)
+		if err != nil {
+			return err
+		}
+
+		mtime := time.Now().Unix()
+		if sec, ok := req.Options["mtime"].(int); ok {
+			mtime = int64(sec)
+		}
+
+		root, unlock := n.LockFilesRoot()
+		_, err = mfs.Lookup(root, path)
+		if err == os.ErrNotExist {
+			_, err = getFileHandle(root, path, true, prefix)
+		}
+		if err == nil {
+			err = setFileMeta(req.Context, n, root, path, flush, func(m *coreunix.FileMeta) {
+				m.Mtime = mtime
+			})
+		}
+		unlock()
+		if err != nil {
+			return err
+		}
+
+		notifyFiles(n, "touch", path, "", flush)
+		return nil
+	},
+}
+
+var filesWatchCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Stream changes made to mfs.",
+		ShortDescription: `
+Print the changes made to a path in mfs, its children or its parents, as they
+happen. Each change is printed on its own line: the operation, the changed
+path, the source path of moves, and the new root CID if the change was
+flushed. The command runs until it is interrupted.
+
+Only changes made through the running daemon are reported.
+
+    $ ipfs files watch /foo
+    mkdir /foo/bar QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("path", false, false, "Path to watch.").WithDefault("/"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if n.LocalMode() {
+			return cmdkit.Errorf(cmdkit.ErrClient, "files watch requires a running daemon")
+		}
+
+		path := "/"
+		if len(req.Arguments) > 0 {
+			path, err = checkPath(req.Arguments[0])
+			if err != nil {
+				return err
+			}
+		}
+
+		for ev := range n.FilesEvents.Subscribe(req.Context, path) {
+			ev := ev
+			if err := res.Emit(&ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: core.FilesEvent{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			ev, ok := v.(*core.FilesEvent)
+			if !ok {
+				return e.TypeErr(ev, v)
+			}
+
+			fields := []string{ev.Op, ev.Path}
+			if ev.From != "" {
+				fields = append(fields, ev.From)
+			}
+			if ev.Root != "" {
+				fields = append(fields, ev.Root)
+			}
+			_, err := fmt.Fprintln(w, strings.Join(fields, " "))
+			return err
+		}),
+	},
+}
+
+// filesFlush returns whether the changes made by a files command should be
+// flushed. Unless --flush is given, they are only flushed when files
+// write-back is disabled.
+func filesFlush(n *core.IpfsNode, req oldcmds.Request) bool {
+	flush, found, _ := req.Option("flush").Bool()
+	if !found {
+		return !n.FilesWriteBack.Enabled()
+	}
+	return flush
+}
+
+// filesFetch returns whether 'ipfs files cp' fetches the DAG it copies: the
+// --fetch option if set, else the Mfs.FetchOnCopy config.
+func filesFetch(n *core.IpfsNode, req oldcmds.Request) (bool, error) {
+	fetch, found, _ := req.Option("fetch").Bool()
+	if found {
+		return fetch, nil
+	}
+
+	fetch = false
+	if err := repo.ConfigSection(n.Repo, "Mfs.FetchOnCopy", &fetch); err != nil {
+		return false, fmt.Errorf("invalid Mfs.FetchOnCopy: %s", err)
+	}
+	return fetch, nil
+}
+
+func filesFlushNew(n *core.IpfsNode, req *cmds.Request) bool {
+	flush, found := req.Options["flush"].(bool)
+	if !found {
+		return !n.FilesWriteBack.Enabled()
+	}
+	return flush
+}
+
+// notifyFiles tells 'ipfs files watch' subscribers that p changed. If the
+// change was flushed, the event includes the new root CID.
+func notifyFiles(n *core.IpfsNode, op, p, from string, flushed bool) {
+	if !n.FilesEvents.Watched() {
+		return
+	}
+
+	e := core.FilesEvent{Op: op, Path: p, From: from}
+	if flushed {
+		root, unlock := n.LockFilesRoot()
+		if nd, err := root.GetDirectory().GetNode(); err == nil {
+			e.Root = nd.Cid().String()
+		}
+		unlock()
+	}
+	n.FilesEvents.Notify(e)
+}
+
+// flushFiles flushes the mfs path p of the files API.
+func flushFiles(n *core.IpfsNode, p string) error {
+	root, unlock := n.LockFilesRoot()
+	defer unlock()
+	return mfs.FlushPath(root, p)
+}
+
+// reshardFiles shards or unshards the mfs directories dirs after their
+// entries changed, flushing the ones that did if flush is set. The files
+// root must not be locked, the root directory may be replaced.
+func reshardFiles(ctx context.Context, n *core.IpfsNode, flush bool, dirs ...string) error {
+	for _, d := range dirs {
+		changed, err := n.ReshardFiles(ctx, d)
+		if err != nil {
+			return err
+		}
+		if changed && flush {
+			if err := flushFiles(n, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordFileNode journals the unflushed mfs node fsn written at path p.
+func recordFileNode(n *core.IpfsNode, p string, fsn mfs.FSNode) error {
+	if !n.FilesWriteBack.Enabled() {
+		return nil
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return err
+	}
+	return n.FilesWriteBack.RecordPut(gopath.Clean(p), nd)
+}
+
+// recordFilesPath journals the unflushed mfs node at path p. The files root
+// lock must be held.
+func recordFilesPath(n *core.IpfsNode, root *mfs.Root, p string) error {
+	if !n.FilesWriteBack.Enabled() {
+		return nil
+	}
+	fsn, err := mfs.Lookup(root, p)
+	if err != nil {
+		return err
+	}
+	return recordFileNode(n, p, fsn)
+}
+
+// recordFilesMv journals the move of src to moved as the removal of src and
+// the put of moved. The files root lock must be held.
+func recordFilesMv(n *core.IpfsNode, root *mfs.Root, src, moved string) error {
+	if !n.FilesWriteBack.Enabled() {
+		return nil
+	}
+	if err := n.FilesWriteBack.RecordRm(gopath.Clean(src)); err != nil {
+		return err
+	}
+	return recordFilesPath(n, root, moved)
+}
+
+// fileWriter writes to an mfs file, through an mfs file descriptor or a
+// DagModifier.
+type fileWriter interface {
+	io.Writer
+	io.Seeker
+	Truncate(int64) error
+}
+
+// newFileModifier returns a DagModifier of the mfs file fi chunking the data
+// written following the chunker spec.
+func newFileModifier(ctx context.Context, n *core.IpfsNode, fi *mfs.File, spec string) (*mod.DagModifier, error) {
+	nd, err := fi.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	dmod, err := mod.NewDagModifier(ctx, nd, n.DAG, coreunix.SplitterGen(spec))
+	if err != nil {
+		return nil, err
+	}
+	dmod.RawLeaves = fi.RawLeaves
+	return dmod, nil
+}
+
+// replaceModifiedFile links the node written by dmod at the mfs path p, in
+// place of the file dmod modified, flushing it if flush is set. The files
+// root must be locked.
+func replaceModifiedFile(ctx context.Context, n *core.IpfsNode, root *mfs.Root, p string, dmod *mod.DagModifier, flush bool) error {
+	nd, err := dmod.GetNode()
+	if err != nil {
+		return err
+	}
+	if err := n.DAG.Add(ctx, nd); err != nil {
+		return err
+	}
+
+	parent, err := mfs.Lookup(root, gopath.Dir(p))
+	if err != nil {
+		return err
+	}
+	pdir, ok := parent.(*mfs.Directory)
+	if !ok {
+		return fmt.Errorf("%s is not a directory", gopath.Dir(p))
+	}
+	name := gopath.Base(p)
+	if err := pdir.Unlink(name); err != nil {
+		return err
+	}
+	if err := pdir.AddChild(name, nd); err != nil {
+		return err
+	}
+
+	if flush {
+		return mfs.FlushPath(root, p)
+	}
+	return nil
+}
+
+// fileNodeMeta returns the metadata stored in the unixfs node of the mfs entry
+// fsn.
+func fileNodeMeta(fsn mfs.FSNode) (coreunix.FileMeta, error) {
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return coreunix.FileMeta{}, err
+	}
+	return coreunix.FileMetaFromNode(nd)
+}
+
+// setFileMeta stores the metadata of the mfs path p, changed by update, in
+// its unixfs node, replacing the node in its parent directory. The files root
+// must be locked.
+func setFileMeta(ctx context.Context, n *core.IpfsNode, root *mfs.Root, p string, flush bool, update func(*coreunix.FileMeta)) error {
+	p = gopath.Clean(p)
+	if p == "/" {
+		return errors.New("cannot set the metadata of the root directory")
+	}
+
+	fsn, err := mfs.Lookup(root, p)
+	if err != nil {
+		return err
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return err
+	}
+	m, err := coreunix.FileMetaFromNode(nd)
+	if err != nil {
+		return err
+	}
+	update(&m)
+	out, err := coreunix.FileNodeWithMeta(nd, m, nd.Cid().Prefix())
+	if err != nil {
+		return err
+	}
+	if err := n.DAG.Add(ctx, out); err != nil {
+		return err
+	}
+
+	parent, err := mfs.Lookup(root, gopath.Dir(p))
+	if err != nil {
+		return err
+	}
+	pdir, ok := parent.(*mfs.Directory)
+	if !ok {
+		return fmt.Errorf("%s is not a directory", gopath.Dir(p))
+	}
+	name := gopath.Base(p)
+	if err := pdir.Unlink(name); err != nil {
+		return err
+	}
+	if err := pdir.AddChild(name, out); err != nil {
+		return err
+	}
+
+	if flush {
+		return mfs.FlushPath(root, p)
+	}
+	if n.FilesWriteBack.Enabled() {
+		return n.FilesWriteBack.RecordPut(p, out)
+	}
+	return nil
+}
+
 func getPrefixNew(req *cmds.Request) (cid.Builder, error) {
 	cidVer, cidVerSet := req.Options["cid-version"].(int)
 	hashFunStr, hashFunSet := req.Options["hash"].(string)
@@ -1102,9 +1932,9 @@ func getPrefixNew(req *cmds.Request) (cid.Builder, error) {
 	}
 
 	if hashFunSet {
-		hashFunCode, ok := mh.Names[strings.ToLower(hashFunStr)]
-		if !ok {
-			return nil, fmt.Errorf("unrecognized hash function: %s", strings.ToLower(hashFunStr))
+		hashFunCode, err := coreunix.HashFunctionCode(hashFunStr)
+		if err != nil {
+			return nil, err
 		}
 		prefix.MhType = hashFunCode
 		prefix.MhLength = -1
@@ -1131,9 +1961,9 @@ func getPrefix(req oldcmds.Request) (cid.Builder, error) {
 	}
 
 	if hashFunSet {
-		hashFunCode, ok := mh.Names[strings.ToLower(hashFunStr)]
-		if !ok {
-			return nil, fmt.Errorf("unrecognized hash function: %s", strings.ToLower(hashFunStr))
+		hashFunCode, err := coreunix.HashFunctionCode(hashFunStr)
+		if err != nil {
+			return nil, err
 		}
 		prefix.MhType = hashFunCode
 		prefix.MhLength = -1
@@ -1142,6 +1972,22 @@ func getPrefix(req oldcmds.Request) (cid.Builder, error) {
 	return &prefix, nil
 }
 
+// importDefaults returns the CID builder of the Import config if prefix, set
+// by the options of a files command, is nil, and the other defaults of the
+// config. The raw leaves implied by the CID version of the config don't apply
+// to the CID versions set by the options.
+func importDefaults(r repo.Repo, prefix cid.Builder) (cid.Builder, coreunix.ImportDefaults, error) {
+	d, err := coreunix.ConfiguredImportDefaults(r)
+	if err != nil {
+		return nil, d, err
+	}
+	if prefix == nil {
+		return d.CidBuilder, d, nil
+	}
+	d.RawLeaves, d.RawLeavesSet, err = coreunix.ConfiguredRawLeaves(r)
+	return prefix, d, err
+}
+
 func ensureContainingDirectoryExists(r *mfs.Root, path string, builder cid.Builder) error {
 	dirtomake := gopath.Dir(path)
 
@@ -1208,6 +2054,17 @@ func getFileHandle(r *mfs.Root, path string, create bool, builder cid.Builder) (
 	}
 }
 
+// checkNotMirrored returns an error if one of the mfs paths is, is below or
+// contains a mirror of another node, which are read-only.
+func checkNotMirrored(n *core.IpfsNode, paths ...string) error {
+	for _, p := range paths {
+		if mp, ok := n.FilesMirrors.Mirrored(p); ok {
+			return fmt.Errorf("%s is read-only: mirrored by 'ipfs files mirror' at %s", p, mp)
+		}
+	}
+	return nil
+}
+
 func checkPath(p string) (string, error) {
 	if len(p) == 0 {
 		return "", fmt.Errorf("paths must not be empty")
@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	car "github.com/ipfs/go-ipfs/car"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+const exportCarOutputOptionName = "output"
+
+var filesExportCarCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Export a snapshot of an mfs subtree as a CAR file.",
+		ShortDescription: `
+Write the DAG of the file or directory at <path> as a CAR (v1) file, to
+stdout or to the file given with -o. The CAR file has the snapshot of the
+subtree as its root, and can be imported on another node, e.g. by posting it
+to the CarIngest endpoint of its gateway.
+
+    $ ipfs files export-car /backups/site -o site.car
+
+The snapshot is taken at once, before the DAG is streamed: the changes made
+under <path> while the export runs, by other commands, aren't part of it.
+Writes to files left open, e.g. by a mount, are only part of it once they
+are flushed. The garbage collection waits for the export to complete, for
+the blocks of the snapshot to stay in the repo.
+
+The blocks missing from the repo are fetched from the network.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("path", true, false, "Path to the mfs file or directory to export."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption(exportCarOutputOptionName, "o", "The path of the CAR file to write, instead of stdout."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		src, err := checkPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		// the lock is held until the DAG is streamed, for the garbage
+		// collection not to remove the blocks the files had when the
+		// snapshot was taken if they change meanwhile
+		unlocker := node.Blockstore.PinLock()
+		nd, err := getNodeFromPath(req.Context, node, node.DAG, src)
+		if err != nil {
+			unlocker.Unlock()
+			return fmt.Errorf("export-car: cannot get node from path %s: %s", src, err)
+		}
+
+		return res.Emit(streamCar(req.Context, node.DAG, nd.Cid(), unlocker.Unlock))
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+
+			outReader, ok := v.(io.Reader)
+			if !ok {
+				return e.New(e.TypeErr(outReader, v))
+			}
+
+			outPath, _ := res.Request().Options[exportCarOutputOptionName].(string)
+			if outPath == "" {
+				_, err = io.Copy(os.Stdout, outReader)
+				return err
+			}
+			return writeFileAtomic(outPath, outReader)
+		},
+	},
+}
+
+// streamCar returns a reader of the CAR file of the DAG of root, written
+// while it is read. done is called once the DAG is written, or the request
+// is cancelled.
+func streamCar(ctx context.Context, ng ipld.NodeGetter, root cid.Cid, done func()) io.Reader {
+	pr, pw := io.Pipe()
+	finished := make(chan struct{})
+	go func() {
+		// the request going away unblocks the writes
+		select {
+		case <-ctx.Done():
+			pr.CloseWithError(ctx.Err())
+		case <-finished:
+		}
+	}()
+	go func() {
+		defer close(finished)
+		w := bufio.NewWriter(pw)
+		err := car.WriteDAG(ctx, ng, []cid.Cid{root}, w)
+		if err == nil {
+			err = w.Flush()
+		}
+		done()
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// writeFileAtomic writes the content of r to the file at path, which only
+// appears once it is complete.
+func writeFileAtomic(path string, r io.Reader) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %s", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
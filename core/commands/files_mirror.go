@@ -0,0 +1,241 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
+)
+
+type filesMirrorStatusOutput struct {
+	Mirrors []core.FilesMirrorStatus
+}
+
+var filesMirrorCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Mirror the files of another node in mfs.",
+		ShortDescription: `
+Keep an mfs path updated with the files of another node, read from its API or
+from the root it publishes under an IPNS name. The mirrored path is read-only.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"start":  filesMirrorStartCmd,
+		"stop":   filesMirrorStopCmd,
+		"status": filesMirrorStatusCmd,
+	},
+}
+
+var filesMirrorStartCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Start mirroring the files of another node at an mfs path.",
+		ShortDescription: `
+Check the root of another node every --interval and, when it changed, fetch
+its DAG and replace the mfs path with it.
+`,
+		LongDescription: `
+Check the root of another node every --interval and, when it changed, fetch
+its DAG and replace the mfs path with it. The path is only replaced once the
+whole DAG is in the repo, so the mirror is always complete.
+
+The --from option is either:
+
+  - the API address of the node, as a multiaddr or an http URL, to mirror its
+    mfs root. The node is connected to before fetching the DAG.
+  - an IPNS name, /ipns/<name>, or a peer ID, to mirror the root published
+    under it, e.g. by 'ipfs name publish' on the other node.
+
+The files commands can't modify the mirrored path until 'ipfs files mirror
+stop' is run, which keeps its content. The mirrors are kept in the repo, and
+resumed when the daemon restarts.
+
+EXAMPLES:
+
+    ipfs files mirror start --from=/ip4/10.0.0.2/tcp/5001 --path=/replica
+    ipfs files mirror start --from=/ipns/QmRemotePeerID --path=/site --interval=5m
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("from", "API address, IPNS name or peer ID of the node to mirror."),
+		cmdkit.StringOption("path", "Mfs path of the mirror."),
+		cmdkit.StringOption("interval", "How often to check the node for changes.").WithDefault(core.DefaultFilesMirrorInterval.String()),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.OnlineMode() {
+			return cmdkit.Errorf(cmdkit.ErrClient, ErrNotOnline.Error())
+		}
+
+		from, _ := req.Options["from"].(string)
+		if from == "" {
+			return cmdkit.Errorf(cmdkit.ErrClient, "missing --from address")
+		}
+		p, _ := req.Options["path"].(string)
+		if p == "" {
+			return cmdkit.Errorf(cmdkit.ErrClient, "missing --path")
+		}
+		p, err = checkPath(p)
+		if err != nil {
+			return err
+		}
+		intervalStr, _ := req.Options["interval"].(string)
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval <= 0 {
+			return cmdkit.Errorf(cmdkit.ErrClient, "invalid interval %q", intervalStr)
+		}
+
+		source, err := filesMirrorSource(n, from)
+		if err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+		}
+		if err := n.FilesMirrors.Start(p, from, interval, source); err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+		}
+		return nil
+	},
+}
+
+var filesMirrorStopCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Stop mirroring at an mfs path.",
+		ShortDescription: `
+Stop updating the mirror at --path. Its last content is kept, and can be
+modified again.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("path", "Mfs path of the mirror."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		p, _ := req.Options["path"].(string)
+		if p == "" {
+			return cmdkit.Errorf(cmdkit.ErrClient, "missing --path")
+		}
+		p, err = checkPath(p)
+		if err != nil {
+			return err
+		}
+		if err := n.FilesMirrors.Stop(p); err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+		}
+		return nil
+	},
+}
+
+var filesMirrorStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the state of the mirrors.",
+		ShortDescription: `
+List the mirrors with the root they mirror and their lag: for how long they
+have been behind the node they mirror, while its new root is fetched. The
+error of the last check or update is shown if it failed.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &filesMirrorStatusOutput{Mirrors: n.FilesMirrors.Status()})
+	},
+	Type: filesMirrorStatusOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*filesMirrorStatusOutput)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			for _, s := range out.Mirrors {
+				root := s.Root
+				if root == "" {
+					root = "-"
+				}
+				fmt.Fprintf(w, "%s %s %s lag %s", s.Path, s.From, root, s.Lag.Truncate(time.Second))
+				if s.Error != "" {
+					fmt.Fprintf(w, " error: %s", s.Error)
+				}
+				fmt.Fprintln(w)
+			}
+			return nil
+		}),
+	},
+}
+
+// ResumeFilesMirrors restarts the mirrors of the files API of n kept in the
+// repo, see core.FilesMirrors.Resume.
+func ResumeFilesMirrors(n *core.IpfsNode) error {
+	return n.FilesMirrors.Resume(func(from string) (core.FilesMirrorSource, error) {
+		return filesMirrorSource(n, from)
+	})
+}
+
+// filesMirrorSource returns the source of the root to mirror: the mfs root
+// of the node with the API address from, or the root published under the
+// IPNS name or the peer ID from.
+func filesMirrorSource(n *core.IpfsNode, from string) (core.FilesMirrorSource, error) {
+	name := strings.TrimPrefix(from, "/ipns/")
+	if _, err := peer.IDB58Decode(from); err == nil {
+		name = from
+	} else if name == from {
+		remote, err := newRemoteAPI(from)
+		if err != nil {
+			return nil, err
+		}
+		return remote.filesRoot(n), nil
+	}
+
+	p, err := path.ParsePath("/ipns/" + name)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) (cid.Cid, error) {
+		return core.ResolveToCid(ctx, n.Namesys, n.Resolver, p)
+	}, nil
+}
+
+// filesRoot returns the source of the mfs root of the remote node, which
+// connects n to it before returning the root, for n to fetch it.
+func (r *remoteAPI) filesRoot(n *core.IpfsNode) core.FilesMirrorSource {
+	return func(ctx context.Context) (cid.Cid, error) {
+		var id IdOutput
+		if err := r.call(ctx, "id", nil, &id); err != nil {
+			return cid.Cid{}, err
+		}
+		pis, err := peersWithAddresses(id.Addresses)
+		if err != nil {
+			return cid.Cid{}, err
+		}
+		for _, pi := range pis {
+			if err := n.PeerHost.Connect(ctx, pi); err != nil {
+				flog.Debugf("failed to connect to %s: %s", pi.ID.Pretty(), err)
+			}
+		}
+
+		var st statOutput
+		if err := r.call(ctx, "files/stat", url.Values{"arg": {"/"}}, &st); err != nil {
+			return cid.Cid{}, err
+		}
+		return cid.Decode(st.Hash)
+	}
+}
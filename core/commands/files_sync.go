@@ -0,0 +1,454 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+	features "github.com/ipfs/go-ipfs/core/features"
+	balanced "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/balanced"
+	ihelper "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/helpers"
+	dagtest "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag/test"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// Actions reported by 'ipfs files sync'.
+const (
+	syncAdd    = "add"
+	syncUpdate = "update"
+	syncDelete = "delete"
+)
+
+type filesSyncOutput struct {
+	Action string
+	Path   string
+}
+
+var filesSyncCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Synchronize a local directory into mfs.",
+		ShortDescription: `
+Update an mfs directory so that it matches a local directory, only importing
+the files that changed since the last sync.
+`,
+		LongDescription: `
+Update an mfs directory so that it matches a local directory, only importing
+the files that changed since the last sync. Every added, updated or deleted
+path is reported.
+
+A file is considered unchanged if its size and modification time match the
+ones stored in the mfs file by the previous sync. Otherwise, or if --checksum
+is passed, the local file is hashed and compared to the mfs file. The synced
+files store their permissions and modification times in their unixfs nodes,
+as with 'ipfs add --preserve-mode --preserve-mtime'. Files only present in mfs
+are kept unless --delete is passed.
+
+The local directory is read by the ipfs daemon, so it must be accessible to
+it.
+
+EXAMPLE:
+
+    ipfs files sync --delete ./public /website
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("localdir", true, false, "Local directory to read from."),
+		cmdkit.StringArg("path", true, false, "Mfs directory to update."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("delete", "Delete mfs entries missing from the local directory."),
+		cmdkit.BoolOption("checksum", "c", "Compare file hashes instead of relying on size and modification time."),
+		cmdkit.BoolOption("dry-run", "n", "Only report what would be changed."),
+		cmdkit.BoolOption("hidden", "H", "Include files that are hidden."),
+		cmdkit.BoolOption("raw-leaves", features.Help(features.RawLeaves, "Use raw blocks for newly created leaf nodes. Default: Import.RawLeaves, or true with CIDv1.")),
+		cidVersionOption,
+		hashOption,
+	},
+	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		local, err := filepath.Abs(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		req.Arguments[0] = local
+		return nil
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		local := req.Arguments[0]
+		if !filepath.IsAbs(local) {
+			return fmt.Errorf("local directory must be an absolute path")
+		}
+		fi, err := os.Stat(local)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", local)
+		}
+
+		dst, err := checkPath(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+		dst = gopath.Clean(dst)
+		if err := checkNotMirrored(n, dst); err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+		}
+
+		builder, err := getPrefixNew(req)
+		if err != nil {
+			return err
+		}
+		builder, defaults, err := importDefaults(n.Repo, builder)
+		if err != nil {
+			return err
+		}
+		rawLeaves, ok := req.Options["raw-leaves"].(bool)
+		if !ok {
+			rawLeaves = defaults.RawLeaves
+		}
+
+		// the root directory is resharded once the root is unlocked
+		root, unlock := n.LockFilesRoot()
+		s := &filesSyncer{
+			ctx:       req.Context,
+			n:         n,
+			root:      root,
+			builder:   builder,
+			chunker:   defaults.Chunker,
+			rawLeaves: rawLeaves,
+			delete:    req.Options["delete"] == true,
+			checksum:  req.Options["checksum"] == true,
+			dryRun:    req.Options["dry-run"] == true,
+			hidden:    req.Options["hidden"] == true,
+			emit: func(action, p string) error {
+				return res.Emit(&filesSyncOutput{Action: action, Path: p})
+			},
+		}
+
+		err = s.syncDir(local, dst)
+		if err == nil && !s.dryRun {
+			err = mfs.FlushPath(root, dst)
+		}
+		unlock()
+		if err != nil || s.dryRun {
+			return err
+		}
+		if s.reshardRoot {
+			if err := reshardFiles(req.Context, n, true, "/"); err != nil {
+				return err
+			}
+		}
+
+		notifyFiles(n, "sync", dst, "", true)
+		return nil
+	},
+	Type: filesSyncOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*filesSyncOutput)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+			_, err := fmt.Fprintf(w, "%s %s\n", out.Action, out.Path)
+			return err
+		}),
+	},
+}
+
+type filesSyncer struct {
+	ctx     context.Context
+	n       *core.IpfsNode
+	root    *mfs.Root // the locked files root
+	builder cid.Builder
+	chunker string
+
+	rawLeaves bool
+	delete    bool
+	checksum  bool
+	dryRun    bool
+	hidden    bool
+
+	// reshardRoot is set when the root directory changed, it can only be
+	// resharded once the files root is unlocked.
+	reshardRoot bool
+
+	emit func(action, path string) error
+}
+
+// syncDir makes the mfs directory mpath match the local directory local. In
+// dry-run mode, mpath may not exist.
+func (s *filesSyncer) syncDir(local, mpath string) error {
+	dir, err := s.mkdir(mpath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(local)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, fi := range entries {
+		name := fi.Name()
+		if !s.hidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		var existing mfs.FSNode
+		if dir != nil {
+			existing, err = dir.Child(name)
+			if err != nil && err != os.ErrNotExist {
+				return err
+			}
+		}
+
+		lp := filepath.Join(local, name)
+		mp := gopath.Join(mpath, name)
+		switch {
+		case fi.IsDir():
+			seen[name] = true
+			if existing != nil && existing.Type() != mfs.TDir {
+				if err := s.remove(dir, name, mp); err != nil {
+					return err
+				}
+			}
+			if err := s.syncDir(lp, mp); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			seen[name] = true
+			if err := s.syncFile(dir, lp, mp, fi, existing); err != nil {
+				return err
+			}
+		default:
+			flog.Warningf("files sync: skipping %s, not a regular file or directory", lp)
+		}
+	}
+
+	if !s.delete || dir == nil {
+		return s.reshard(mpath)
+	}
+
+	names, err := dir.ListNames(s.ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if seen[name] || (!s.hidden && strings.HasPrefix(name, ".")) {
+			continue
+		}
+		if err := s.remove(dir, name, gopath.Join(mpath, name)); err != nil {
+			return err
+		}
+	}
+	return s.reshard(mpath)
+}
+
+func (s *filesSyncer) reshard(mpath string) error {
+	if s.dryRun {
+		return nil
+	}
+	if mpath == "/" {
+		s.reshardRoot = true
+		return nil
+	}
+	_, err := s.n.Sharding.ReshardMfs(s.ctx, s.root, s.n.DAG, mpath)
+	return err
+}
+
+// mkdir returns the mfs directory at p, creating it if needed. It returns a
+// nil directory if p doesn't exist in dry-run mode.
+func (s *filesSyncer) mkdir(p string) (*mfs.Directory, error) {
+	fsn, err := mfs.Lookup(s.root, p)
+	switch err {
+	case nil:
+		dir, ok := fsn.(*mfs.Directory)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a directory", p)
+		}
+		return dir, nil
+	case os.ErrNotExist:
+	default:
+		return nil, err
+	}
+
+	if err := s.emit(syncAdd, p); err != nil {
+		return nil, err
+	}
+	if s.dryRun {
+		return nil, nil
+	}
+
+	err = mfs.Mkdir(s.root, p, mfs.MkdirOpts{
+		Mkparents:  true,
+		CidBuilder: s.builder,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fsn, err = mfs.Lookup(s.root, p)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a directory", p)
+	}
+	return dir, nil
+}
+
+func (s *filesSyncer) syncFile(dir *mfs.Directory, local, mpath string, fi os.FileInfo, existing mfs.FSNode) error {
+	action := syncAdd
+	if existing != nil {
+		action = syncUpdate
+		if f, ok := existing.(*mfs.File); ok {
+			changed, err := s.fileChanged(dir, gopath.Base(mpath), f, local, fi)
+			if err != nil || !changed {
+				return err
+			}
+		}
+	}
+
+	if err := s.emit(action, mpath); err != nil {
+		return err
+	}
+	if s.dryRun {
+		return nil
+	}
+
+	builder := s.builder
+	if builder == nil {
+		builder = dir.GetCidBuilder()
+	}
+	nd, err := s.importFile(local, s.n.DAG, builder)
+	if err != nil {
+		return err
+	}
+	nd, err = coreunix.FileNodeWithMeta(nd, coreunix.FileMetaFromFileInfo(fi, true, true), builder)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return s.replace(dir, gopath.Base(mpath), nd)
+	}
+	if err := s.n.DAG.Add(s.ctx, nd); err != nil {
+		return err
+	}
+	return dir.AddChild(gopath.Base(mpath), nd)
+}
+
+// fileChanged returns true if the content of the local file differs from the
+// one of the mfs file f, named name in dir. If only its metadata changed, it is
+// stored in f.
+func (s *filesSyncer) fileChanged(dir *mfs.Directory, name string, f *mfs.File, local string, fi os.FileInfo) (bool, error) {
+	size, err := f.Size()
+	if err != nil {
+		return false, err
+	}
+	if size != fi.Size() {
+		return true, nil
+	}
+
+	nd, err := f.GetNode()
+	if err != nil {
+		return false, err
+	}
+	meta, err := coreunix.FileMetaFromNode(nd)
+	if err != nil {
+		return false, err
+	}
+	lmeta := coreunix.FileMetaFromFileInfo(fi, true, true)
+	if !s.checksum && meta.Mtime != 0 && meta.Mtime == lmeta.Mtime {
+		return false, nil
+	}
+
+	// Hash the local file with the parameters and the metadata of the mfs
+	// file, without storing it.
+	prefix := nd.Cid().Prefix()
+	lnd, err := s.importFile(local, dagtest.Mock(), &prefix)
+	if err != nil {
+		return false, err
+	}
+	if !meta.IsZero() {
+		lnd, err = coreunix.FileNodeWithMeta(lnd, meta, &prefix)
+		if err != nil {
+			return false, err
+		}
+	}
+	if !lnd.Cid().Equals(nd.Cid()) {
+		return true, nil
+	}
+
+	// Same content, store the new mtime to avoid hashing it next time.
+	if s.dryRun || meta == lmeta {
+		return false, nil
+	}
+	out, err := coreunix.FileNodeWithMeta(nd, lmeta, &prefix)
+	if err != nil {
+		return false, err
+	}
+	return false, s.replace(dir, name, out)
+}
+
+// replace adds nd to the DAG and links it as name in dir, in place of the
+// existing entry.
+func (s *filesSyncer) replace(dir *mfs.Directory, name string, nd ipld.Node) error {
+	if err := s.n.DAG.Add(s.ctx, nd); err != nil {
+		return err
+	}
+	if err := dir.Unlink(name); err != nil {
+		return err
+	}
+	return dir.AddChild(name, nd)
+}
+
+func (s *filesSyncer) importFile(local string, dserv ipld.DAGService, builder cid.Builder) (ipld.Node, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dbp := &ihelper.DagBuilderParams{
+		Dagserv:    dserv,
+		RawLeaves:  s.rawLeaves,
+		Maxlinks:   ihelper.DefaultLinksPerBlock,
+		CidBuilder: builder,
+	}
+	spl, err := coreunix.NewSplitter(f, s.chunker)
+	if err != nil {
+		return nil, err
+	}
+	return balanced.Layout(dbp.New(spl))
+}
+
+func (s *filesSyncer) remove(dir *mfs.Directory, name, mpath string) error {
+	if err := s.emit(syncDelete, mpath); err != nil {
+		return err
+	}
+	if s.dryRun {
+		return nil
+	}
+	return dir.Unlink(name)
+}
@@ -2,16 +2,19 @@ package commands
 
 import (
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	gopath "path"
 	"path/filepath"
 	"strings"
 
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
 
 	uarchive "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/archive"
 	"gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
@@ -20,10 +23,13 @@ import (
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
 	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
 	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
 )
 
 var ErrInvalidCompressionLevel = errors.New("compression level must be between 1 and 9")
 
+const cidHeadersOptionName = "cid-headers"
+
 var GetCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Download IPFS objects.",
@@ -37,6 +43,12 @@ To output a TAR archive instead of unpacked files, use '--archive' or '-a'.
 
 To compress the output with GZIP compression, use '--compress' or '-C'. You
 may also specify the level of compression by specifying '-l=<1-9>'.
+
+Use '--output=-' to write a TAR archive to stdout. Archives written to stdout
+or with '--cid-headers' list directory entries in a deterministic order, and
+'--cid-headers' records the CID of every entry in an 'IPFS.cid' PAX header:
+
+  ipfs get --output=- --cid-headers <dir-cid> | tar -t
 `,
 	},
 
@@ -48,6 +60,7 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		cmdkit.BoolOption("archive", "a", "Output a TAR archive."),
 		cmdkit.BoolOption("compress", "C", "Compress the output with GZIP compression."),
 		cmdkit.IntOption("compression-level", "l", "The level of compression (1-9)."),
+		cmdkit.BoolOption(cidHeadersOptionName, "Record the CID of each entry in a PAX header. Implies --archive."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
 		_, err := getCompressOptions(req)
@@ -85,6 +98,13 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		}
 
 		archive, _ := req.Options["archive"].(bool)
+		cidHeaders, _ := req.Options[cidHeadersOptionName].(bool)
+		if cidHeaders || getOutPath(req) == "-" {
+			return res.Emit(tarStream(ctx, node, dn, gopath.Base(p.String()), cmplvl, func(tw *coreunix.TarWriter) {
+				tw.CidHeaders = cidHeaders
+			}))
+		}
+
 		reader, err := uarchive.DagArchive(ctx, dn, p.String(), node.DAG, archive, cmplvl)
 		if err != nil {
 			return err
@@ -114,6 +134,9 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 			}
 
 			archive, _ := req.Options["archive"].(bool)
+			if cidHeaders, _ := req.Options[cidHeadersOptionName].(bool); cidHeaders {
+				archive = true
+			}
 
 			gw := getWriter{
 				Out:         os.Stdout,
@@ -181,9 +204,17 @@ type getWriter struct {
 	Archive     bool
 	Compression int
 	Size        int64
+
+	// RestoreMeta restores the modes and modification times of the extracted
+	// files recorded in the archive.
+	RestoreMeta bool
 }
 
 func (gw *getWriter) Write(r io.Reader, fpath string) error {
+	if fpath == "-" {
+		_, err := io.Copy(gw.Out, r)
+		return err
+	}
 	if gw.Archive || gw.Compression != gzip.NoCompression {
 		return gw.writeArchive(r, fpath)
 	}
@@ -228,10 +259,44 @@ func (gw *getWriter) writeExtracted(r io.Reader, fpath string) error {
 	defer bar.Finish()
 	defer bar.Set64(gw.Size)
 
+	if gw.RestoreMeta {
+		return coreunix.ExtractTar(r, fpath, bar.Add64)
+	}
 	extractor := &tar.Extractor{Path: fpath, Progress: bar.Add64}
 	return extractor.Extract(r)
 }
 
+// tarStream returns a deterministic tar archive of nd, compressed if cmplvl
+// isn't gzip.NoCompression. setup, if not nil, configures the TarWriter.
+func tarStream(ctx context.Context, n *core.IpfsNode, nd ipld.Node, name string, cmplvl int, setup func(*coreunix.TarWriter)) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.WriteCloser = pw
+		if cmplvl != gzip.NoCompression {
+			gzw, err := gzip.NewWriterLevel(pw, cmplvl)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			w = gzw
+		}
+
+		tw := coreunix.NewTarWriter(ctx, n.DAG, w)
+		if setup != nil {
+			setup(tw)
+		}
+		err := tw.WriteNode(nd, name)
+		if err == nil {
+			err = tw.Close()
+		}
+		if err == nil && w != pw {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
 func getCompressOptions(req *cmds.Request) (int, error) {
 	cmprs, _ := req.Options["compress"].(bool)
 	cmplvl, cmplvlFound := req.Options["compression-level"].(int)
@@ -35,6 +35,14 @@ type IdOutput struct {
 	Addresses       []string
 	AgentVersion    string
 	ProtocolVersion string
+	// Connections are the connections to a remote peer.
+	Connections []IdConnection `json:",omitempty"`
+}
+
+// IdConnection is what was negotiated for a connection to the peer.
+type IdConnection struct {
+	Addr string
+	core.ConnState
 }
 
 var IDCmd = &cmds.Command{
@@ -50,6 +58,8 @@ If no peer is specified, prints out information for local peers.
 <pver>: Protocol version.
 <pubkey>: Public key.
 <addrs>: Addresses (newline delimited).
+<conns>: Connections to the peer, with their transport, security protocol
+         and muxer (newline delimited).
 
 EXAMPLE:
 
@@ -112,6 +122,12 @@ EXAMPLE:
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
+		for _, c := range node.PeerHost.Network().ConnsToPeer(p.ID) {
+			output.Connections = append(output.Connections, IdConnection{
+				Addr:      c.RemoteMultiaddr().String(),
+				ConnState: node.ConnState(c),
+			})
+		}
 		res.SetOutput(output)
 	},
 	Marshalers: cmds.MarshalerMap{
@@ -137,6 +153,7 @@ EXAMPLE:
 				output = strings.Replace(output, "<pver>", val.ProtocolVersion, -1)
 				output = strings.Replace(output, "<pubkey>", val.PublicKey, -1)
 				output = strings.Replace(output, "<addrs>", strings.Join(val.Addresses, "\n"), -1)
+				output = strings.Replace(output, "<conns>", formatConnections(val.Connections), -1)
 				output = strings.Replace(output, "\\n", "\n", -1)
 				output = strings.Replace(output, "\\t", "\t", -1)
 				return strings.NewReader(output), nil
@@ -154,7 +171,7 @@ EXAMPLE:
 	Type: IdOutput{},
 }
 
-func printPeer(ps pstore.Peerstore, p peer.ID) (interface{}, error) {
+func printPeer(ps pstore.Peerstore, p peer.ID) (*IdOutput, error) {
 	if p == "" {
 		return nil, errors.New("attempted to print nil peer")
 	}
@@ -188,6 +205,23 @@ func printPeer(ps pstore.Peerstore, p peer.ID) (interface{}, error) {
 	return info, nil
 }
 
+func formatConnections(conns []IdConnection) string {
+	lines := make([]string, len(conns))
+	for i, c := range conns {
+		fields := []string{c.Addr}
+		for _, s := range []string{c.Transport, c.Security, c.Muxer} {
+			if s != "" {
+				fields = append(fields, s)
+			}
+		}
+		if c.Relayed {
+			fields = append(fields, "relayed")
+		}
+		lines[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
 // printing self is special cased as we get values differently.
 func printSelf(node *core.IpfsNode) (interface{}, error) {
 	info := new(IdOutput)
@@ -7,6 +7,7 @@ import (
 
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	"github.com/ipfs/go-ipfs/core/commands/e"
+	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
 	"github.com/ipfs/go-ipfs/core/coreapi/interface/options"
 
 	"gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
@@ -28,6 +29,12 @@ publish'.
   > ipfs key list
   self
   mykey
+
+'ipfs key use' sets the key 'ipfs name publish' uses when it isn't given
+one with --key, 'self' by default.
+
+  > ipfs key use mykey
+  > ipfs name publish QmSomeHash
 		`,
 	},
 	Subcommands: map[string]*cmds.Command{
@@ -35,12 +42,14 @@ publish'.
 		"list":   keyListCmd,
 		"rename": keyRenameCmd,
 		"rm":     keyRmCmd,
+		"use":    keyUseCmd,
 	},
 }
 
 type KeyOutput struct {
-	Name string
-	Id   string
+	Name    string
+	Id      string
+	Default bool `json:",omitempty"`
 }
 
 type KeyOutputList struct {
@@ -117,6 +126,10 @@ var keyGenCmd = &cmds.Command{
 var keyListCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "List all local keypairs",
+		ShortDescription: `
+'ipfs key list' lists the names of the keys. With -l, it also shows their
+PeerIDs and marks the default key, set with 'ipfs key use'.
+`,
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("l", "Show extra information about keys."),
@@ -132,10 +145,19 @@ var keyListCmd = &cmds.Command{
 			return err
 		}
 
+		def, err := api.Key().Default(req.Context)
+		if err != nil {
+			return err
+		}
+
 		list := make([]KeyOutput, 0, len(keys))
 
 		for _, key := range keys {
-			list = append(list, KeyOutput{Name: key.Name(), Id: key.ID().Pretty()})
+			list = append(list, KeyOutput{
+				Name:    key.Name(),
+				Id:      key.ID().Pretty(),
+				Default: key.Name() == def.Name(),
+			})
 		}
 
 		return cmds.EmitOnce(res, &KeyOutputList{list})
@@ -233,6 +255,63 @@ var keyRmCmd = &cmds.Command{
 	Type: KeyOutputList{},
 }
 
+var keyUseCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Set the default keypair",
+		ShortDescription: `
+'ipfs key use' sets the key 'ipfs name publish' publishes with, and
+'ipfs name resolve' resolves, when they aren't given one. It is stored in the
+Ipns.DefaultKey config. Without a name, it shows the current default key.
+
+  > ipfs key use mykey
+  Default key set to mykey (QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd)
+
+'ipfs key use self' goes back to the key of the node.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", false, false, "name of the key to use by default"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env)
+		if err != nil {
+			return err
+		}
+
+		var key coreiface.Key
+		if len(req.Arguments) == 0 {
+			key, err = api.Key().Default(req.Context)
+		} else {
+			key, err = api.Key().Use(req.Context, req.Arguments[0])
+		}
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &KeyOutput{
+			Name:    key.Name(),
+			Id:      key.ID().Pretty(),
+			Default: true,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			k, ok := v.(*KeyOutput)
+			if !ok {
+				return e.TypeErr(k, v)
+			}
+
+			if len(req.Arguments) == 0 {
+				_, err := fmt.Fprintf(w, "%s (%s)\n", k.Name, k.Id)
+				return err
+			}
+			_, err := fmt.Fprintf(w, "Default key set to %s (%s)\n", k.Name, k.Id)
+			return err
+		}),
+	},
+	Type: KeyOutput{},
+}
+
 func keyOutputListMarshaler() cmds.EncoderFunc {
 	return cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
 		withID, _ := req.Options["l"].(bool)
@@ -244,7 +323,9 @@ func keyOutputListMarshaler() cmds.EncoderFunc {
 
 		tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
 		for _, s := range list.Keys {
-			if withID {
+			if withID && s.Default {
+				fmt.Fprintf(tw, "%s\t%s\t(default)\n", s.Id, s.Name)
+			} else if withID {
 				fmt.Fprintf(tw, "%s\t%s\t\n", s.Id, s.Name)
 			} else {
 				fmt.Fprintf(tw, "%s\n", s.Name)
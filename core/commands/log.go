@@ -1,11 +1,16 @@
 package commands
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"time"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	corelog "github.com/ipfs/go-ipfs/core/corelog"
 
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
 	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
 	lwriter "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log/writer"
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
@@ -27,9 +32,10 @@ output of a running daemon.
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"level": logLevelCmd,
-		"ls":    logLsCmd,
-		"tail":  logTailCmd,
+		"level":  logLevelCmd,
+		"ls":     logLsCmd,
+		"status": logStatusCmd,
+		"tail":   logTailCmd,
 	},
 }
 
@@ -91,6 +97,82 @@ subsystems of a running daemon.
 	Type: stringList{},
 }
 
+type logStatusOutput struct {
+	Sinks []corelog.SinkStatus
+}
+
+var logStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show where the logs are written.",
+		ShortDescription: `
+'ipfs log status' lists the sinks the daemon writes its logs to: stderr, the
+log files set in the Logging config, with their sizes and rotations, and the
+remote collectors, with the entries sent, waiting to be sent and dropped.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		res.SetOutput(&logStatusOutput{Sinks: corelog.Status()})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*logStatusOutput)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			buf := new(bytes.Buffer)
+			for _, s := range out.Sinks {
+				if s.Address != "" {
+					fmt.Fprintf(buf, "%s %s: %d sent, %d queued", s.Type, s.Address, s.Sent, s.Queued)
+					if s.Dropped > 0 {
+						fmt.Fprintf(buf, ", %d dropped", s.Dropped)
+					}
+					if s.LastError != "" {
+						fmt.Fprintf(buf, ", last error: %s", s.LastError)
+					}
+					fmt.Fprintln(buf)
+					continue
+				}
+				if s.Type != corelog.SinkFile {
+					fmt.Fprintln(buf, s.Type)
+					continue
+				}
+
+				fmt.Fprintf(buf, "%s %s: %s", s.Type, s.Path, humanize.Bytes(uint64(s.Size)))
+				if s.MaxSize > 0 {
+					fmt.Fprintf(buf, ", max size %s", humanize.Bytes(uint64(s.MaxSize)))
+				}
+				if s.RotateEvery != "" {
+					fmt.Fprintf(buf, ", rotated every %s", s.RotateEvery)
+				}
+				if s.Rotations > 0 {
+					fmt.Fprintf(buf, ", %d rotations", s.Rotations)
+				}
+				if s.LastRotation != nil {
+					fmt.Fprintf(buf, ", last at %s", s.LastRotation.Format(time.RFC3339))
+				}
+				if s.Backups > 0 {
+					fmt.Fprintf(buf, ", %d backups", s.Backups)
+				}
+				if s.MaxBackups > 0 {
+					fmt.Fprintf(buf, " (max %d)", s.MaxBackups)
+				}
+				if s.Compress {
+					fmt.Fprint(buf, ", compressed")
+				}
+				fmt.Fprintln(buf)
+			}
+			return buf, nil
+		},
+	},
+	Type: logStatusOutput{},
+}
+
 var logTailCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Read the event log.",
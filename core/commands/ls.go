@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"os"
 	"text/tabwriter"
+	"time"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
 	core "github.com/ipfs/go-ipfs/core"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
 	unixfs "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
 	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
 	unixfspb "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/pb"
@@ -27,6 +30,12 @@ type LsLink struct {
 	Name, Hash string
 	Size       uint64
 	Type       unixfspb.Data_DataType
+	// Mode and Mtime are the metadata stored in the node of the file, only
+	// set with --long.
+	Mode  uint32 `json:",omitempty"`
+	Mtime int64  `json:",omitempty"`
+	// MimeType is the MIME type recorded by 'ipfs add --detect-mime'.
+	MimeType string `json:",omitempty"`
 }
 
 type LsObject struct {
@@ -47,7 +56,14 @@ the following format:
 
   <link base58 hash> <link size in bytes> <link name>
 
-The JSON output contains type information.
+The JSON output contains type information, and the MIME type of the files
+added with 'ipfs add --detect-mime'.
+
+With '--long', the permissions and the modification time stored in the nodes
+of the files by 'ipfs add --preserve-mode --preserve-mtime' are listed first,
+or '-' when none is stored:
+
+  <mode> <mtime> <link base58 hash> <link size in bytes> <link name>
 `,
 	},
 
@@ -57,6 +73,7 @@ The JSON output contains type information.
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("headers", "v", "Print table headers (Hash, Size, Name)."),
 		cmdkit.BoolOption("resolve-type", "Resolve linked objects to find out their types.").WithDefault(true),
+		cmdkit.BoolOption("long", "l", "Also list the mode and mtime stored in the nodes of the files."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		nd, err := req.InvocContext().GetNode()
@@ -77,6 +94,11 @@ The JSON output contains type information.
 			return
 		}
 
+		long, _, err := req.Option("long").Bool()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
 		dserv := nd.DAG
 		if !resolve {
 			offlineexch := offline.Exchange(nd.Blockstore)
@@ -134,6 +156,8 @@ The JSON output contains type information.
 
 			for j, link := range links {
 				t := unixfspb.Data_DataType(-1)
+				var mimeType string
+				var meta coreunix.FileMeta
 
 				switch link.Cid.Type() {
 				case cid.Raw:
@@ -156,13 +180,28 @@ The JSON output contains type information.
 							return
 						}
 						t = d.GetType()
+						if t == unixfspb.Data_Metadata {
+							// files added with --detect-mime
+							t = unixfspb.Data_File
+							mimeType = coreunix.MimeType(pn)
+						}
+						if long {
+							// files added with --preserve-mode/mtime
+							if meta, err = coreunix.FileMetaFromNode(pn); err != nil {
+								res.SetError(err, cmdkit.ErrNormal)
+								return
+							}
+						}
 					}
 				}
 				output[i].Links[j] = LsLink{
-					Name: link.Name,
-					Hash: link.Cid.String(),
-					Size: link.Size,
-					Type: t,
+					Name:     link.Name,
+					Hash:     link.Cid.String(),
+					Size:     link.Size,
+					Type:     t,
+					MimeType: mimeType,
+					Mode:     uint32(meta.Mode),
+					Mtime:    meta.Mtime,
 				}
 			}
 		}
@@ -178,6 +217,7 @@ The JSON output contains type information.
 			}
 
 			headers, _, _ := res.Request().Option("headers").Bool()
+			long, _, _ := res.Request().Option("long").Bool()
 			output, ok := v.(*LsOutput)
 			if !ok {
 				return nil, e.TypeErr(output, v)
@@ -190,9 +230,15 @@ The JSON output contains type information.
 					fmt.Fprintf(w, "%s:\n", object.Hash)
 				}
 				if headers {
+					if long {
+						fmt.Fprint(w, "Mode\tMtime\t")
+					}
 					fmt.Fprintln(w, "Hash\tSize\tName")
 				}
 				for _, link := range object.Links {
+					if long {
+						fmt.Fprintf(w, "%s\t%s\t", lsMode(link), lsMtime(link))
+					}
 					if link.Type == unixfspb.Data_Directory {
 						link.Name += "/"
 					}
@@ -209,3 +255,22 @@ The JSON output contains type information.
 	},
 	Type: LsOutput{},
 }
+
+// lsMode formats the recorded permissions of link like 'ls -l' does.
+func lsMode(link LsLink) string {
+	if link.Mode == 0 {
+		return "-"
+	}
+	mode := os.FileMode(link.Mode).Perm()
+	if link.Type == unixfspb.Data_Directory {
+		mode |= os.ModeDir
+	}
+	return mode.String()
+}
+
+func lsMtime(link LsLink) string {
+	if link.Mtime == 0 {
+		return "-"
+	}
+	return time.Unix(link.Mtime, 0).UTC().Format(time.RFC3339)
+}
@@ -7,12 +7,15 @@ import (
 	"strings"
 	"time"
 
+	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	namesys "github.com/ipfs/go-ipfs/namesys"
 	nsopts "github.com/ipfs/go-ipfs/namesys/opts"
 
 	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
 	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
 	offline "gx/ipfs/QmSNe4MWVxZWk6UxxW2z2EKofFo4GdFzud1vfn1iVby3mj/go-ipfs-routing/offline"
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
@@ -30,6 +33,7 @@ const (
 	nocacheOptionName        = "nocache"
 	dhtRecordCountOptionName = "dht-record-count"
 	dhtTimeoutOptionName     = "dht-timeout"
+	pinOptionName            = "pin"
 )
 
 var IpnsCmd = &cmds.Command{
@@ -38,14 +42,14 @@ var IpnsCmd = &cmds.Command{
 		ShortDescription: `
 IPNS is a PKI namespace, where names are the hashes of public keys, and
 the private key enables publishing new (signed) values. In both publish
-and resolve, the default name used is the node's own PeerID,
-which is the hash of its public key.
+and resolve, the default name used is the PeerID of the default key, set
+with 'ipfs key use', which is the node's own PeerID unless changed.
 `,
 		LongDescription: `
 IPNS is a PKI namespace, where names are the hashes of public keys, and
 the private key enables publishing new (signed) values. In both publish
-and resolve, the default name used is the node's own PeerID,
-which is the hash of its public key.
+and resolve, the default name used is the PeerID of the default key, set
+with 'ipfs key use', which is the node's own PeerID unless changed.
 
 You can use the 'ipfs key' commands to list and generate more names and their
 respective keys.
@@ -67,17 +71,24 @@ Resolve the value of a dnslink:
   > ipfs name resolve ipfs.io
   /ipfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
 
+Resolve a name and pin its value, which is the path returned even if the name
+is updated meanwhile. The name must resolve to an /ipfs path:
+
+  > ipfs name resolve --pin -r QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+  /ipfs/QmSiTko9JZyabH56y2fussEt1A5oDqsFXB3CkvAqraFryz
+
 `,
 	},
 
 	Arguments: []cmdkit.Argument{
-		cmdkit.StringArg("name", false, false, "The IPNS name to resolve. Defaults to your node's peerID."),
+		cmdkit.StringArg("name", false, false, "The IPNS name to resolve. Defaults to the peerID of the default key, set with 'ipfs key use'."),
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption(recursiveOptionName, "r", "Resolve until the result is not an IPNS name."),
 		cmdkit.BoolOption(nocacheOptionName, "n", "Do not use cached entries."),
 		cmdkit.UintOption(dhtRecordCountOptionName, "dhtrc", "Number of records to request for DHT resolution."),
 		cmdkit.StringOption(dhtTimeoutOptionName, "dhtt", "Max time to collect values during DHT resolution eg \"30s\". Pass 0 for no timeout."),
+		cmdkit.BoolOption(pinOptionName, "Pin the resolved path recursively before returning it."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -116,7 +127,23 @@ Resolve the value of a dnslink:
 			if n.Identity == "" {
 				return errors.New("identity not loaded")
 			}
-			name = n.Identity.Pretty()
+			kname, err := core.DefaultKey(n.Repo)
+			if err != nil {
+				return err
+			}
+			if kname == "self" {
+				name = n.Identity.Pretty()
+			} else {
+				sk, err := n.Repo.Keystore().Get(kname)
+				if err != nil {
+					return fmt.Errorf("default key %s: %s", kname, err)
+				}
+				pid, err := peer.IDFromPrivateKey(sk)
+				if err != nil {
+					return err
+				}
+				name = pid.Pretty()
+			}
 
 		} else {
 			name = req.Arguments[0]
@@ -153,6 +180,12 @@ Resolve the value of a dnslink:
 			return err
 		}
 
+		if pin, _ := req.Options[pinOptionName].(bool); pin {
+			if err := corerepo.PinResolved(req.Context, n, output); err != nil {
+				return err
+			}
+		}
+
 		// TODO: better errors (in the case of not finding the name, we get "failed to find any peer in table")
 		return cmds.EmitOnce(res, &ResolvedPath{output})
 	},
@@ -16,14 +16,14 @@ var NameCmd = &cmds.Command{
 		ShortDescription: `
 IPNS is a PKI namespace, where names are the hashes of public keys, and
 the private key enables publishing new (signed) values. In both publish
-and resolve, the default name used is the node's own PeerID,
-which is the hash of its public key.
+and resolve, the default name used is the PeerID of the default key, set
+with 'ipfs key use', which is the node's own PeerID unless changed.
 `,
 		LongDescription: `
 IPNS is a PKI namespace, where names are the hashes of public keys, and
 the private key enables publishing new (signed) values. In both publish
-and resolve, the default name used is the node's own PeerID,
-which is the hash of its public key.
+and resolve, the default name used is the PeerID of the default key, set
+with 'ipfs key use', which is the node's own PeerID unless changed.
 
 You can use the 'ipfs key' commands to list and generate more names and their
 respective keys.
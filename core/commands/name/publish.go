@@ -40,14 +40,14 @@ var PublishCmd = &cmds.Command{
 		ShortDescription: `
 IPNS is a PKI namespace, where names are the hashes of public keys, and
 the private key enables publishing new (signed) values. In both publish
-and resolve, the default name used is the node's own PeerID,
-which is the hash of its public key.
+and resolve, the default name used is the PeerID of the default key, set
+with 'ipfs key use', which is the node's own PeerID unless changed.
 `,
 		LongDescription: `
 IPNS is a PKI namespace, where names are the hashes of public keys, and
 the private key enables publishing new (signed) values. In both publish
-and resolve, the default name used is the node's own PeerID,
-which is the hash of its public key.
+and resolve, the default name used is the PeerID of the default key, set
+with 'ipfs key use', which is the node's own PeerID unless changed.
 
 You can use the 'ipfs key' commands to list and generate more names and their
 respective keys.
@@ -65,6 +65,12 @@ Publish an <ipfs-path> with another name, added by an 'ipfs key' command:
   > ipfs name publish --key=mykey /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
   Published to QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd: /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
 
+Make that name the default one, to publish with it without --key:
+
+  > ipfs key use mykey
+  > ipfs name publish /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+  Published to QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd: /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+
 Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by 
 'ipfs key list -l'):
 
@@ -85,7 +91,7 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
     "ns", "us" (or "µs"), "ms", "s", "m", "h".`).WithDefault("24h"),
 		cmdkit.BoolOption(allowOfflineOptionName, "When offline, save the IPNS record to the the local datastore without broadcasting to the network instead of simply failing."),
 		cmdkit.StringOption(ttlOptionName, "Time duration this record should be cached for (caution: experimental)."),
-		cmdkit.StringOption(keyOptionName, "k", "Name of the key to be used or a valid PeerID, as listed by 'ipfs key list -l'. Default: <<default>>.").WithDefault("self"),
+		cmdkit.StringOption(keyOptionName, "k", "Name of the key to be used or a valid PeerID, as listed by 'ipfs key list -l'. Default: the key set with 'ipfs key use', 'self' unless changed."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -136,7 +142,13 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
 			ctx = context.WithValue(ctx, "ipns-publish-ttl", d)
 		}
 
-		kname, _ := req.Options[keyOptionName].(string)
+		kname, found := req.Options[keyOptionName].(string)
+		if !found {
+			kname, err = core.DefaultKey(n.Repo)
+			if err != nil {
+				return err
+			}
+		}
 		k, err := keylookup(n, kname)
 		if err != nil {
 			return err
@@ -12,6 +12,7 @@ import (
 
 	cmds "github.com/ipfs/go-ipfs/commands"
 	core "github.com/ipfs/go-ipfs/core"
+	features "github.com/ipfs/go-ipfs/core/features"
 	p2p "github.com/ipfs/go-ipfs/p2p"
 
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
@@ -496,15 +497,10 @@ func p2pGetNode(req cmds.Request) (*core.IpfsNode, error) {
 		return nil, err
 	}
 
-	config, err := n.Repo.Config()
-	if err != nil {
+	if err := features.Require(n.Repo, features.Libp2pStreamMounting); err != nil {
 		return nil, err
 	}
 
-	if !config.Experimental.Libp2pStreamMounting {
-		return nil, errors.New("libp2p stream mounting not enabled")
-	}
-
 	if !n.OnlineMode() {
 		return nil, ErrNotOnline
 	}
@@ -3,8 +3,14 @@ package commands
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
@@ -16,10 +22,12 @@ import (
 
 	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
 	"gx/ipfs/QmVkMRSkXrpjqrroEXWuYBvDBnXCdMMY6gsKicBGVGUqKT/go-verifcid"
 	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
 	resolver "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path/resolver"
 	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
 	bserv "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
 	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
 )
@@ -30,11 +38,13 @@ var PinCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"add":    addPinCmd,
-		"rm":     rmPinCmd,
-		"ls":     listPinCmd,
-		"verify": verifyPinCmd,
-		"update": updatePinCmd,
+		"add":       addPinCmd,
+		"rm":        rmPinCmd,
+		"ls":        listPinCmd,
+		"verify":    verifyPinCmd,
+		"update":    updatePinCmd,
+		"reconcile": reconcilePinCmd,
+		"status":    statusPinCmd,
 	},
 }
 
@@ -45,12 +55,34 @@ type PinOutput struct {
 type AddPinOutput struct {
 	Pins     []string
 	Progress int `json:",omitempty"`
+	// Job is the ID of the pin job, with --wait=false.
+	Job string `json:",omitempty"`
 }
 
 var addPinCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+With --wait=false, the objects are pinned in the background by the daemon,
+and the ID of the pin job is returned at once. Its progress is shown by
+'ipfs pin status <id>', and streamed until it is done with --watch:
+
+  $ ID=$(ipfs pin add --wait=false /ipfs/QmXyz)
+  $ ipfs pin status --watch $ID
+
+The pins fetch their DAGs taking turns by their --priority: when the fetches
+are busy, the high priority pins get 8 turns and the normal ones 4 for each
+turn of the low priority ones. The roots of the new pins are then provided
+ahead of the other new blocks, unless their priority is low. A bulk pin of a
+dataset can thus run at a low priority in the background, without delaying
+an urgent one:
+
+  $ ipfs pin add --wait=false --priority=low /ipfs/QmDataset
+  $ ipfs pin add --priority=high /ipns/example.com
+`,
 	},
 
 	Arguments: []cmdkit.Argument{
@@ -59,6 +91,8 @@ var addPinCmd = &cmds.Command{
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("recursive", "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmdkit.BoolOption("progress", "Show progress"),
+		cmdkit.BoolOption("wait", "Wait for the pin to complete, or pin in the background and return the ID of the pin job.").WithDefault(true),
+		cmdkit.StringOption("priority", "Priority of the fetch and of the provide of the pin: high, normal or low.").WithDefault("normal"),
 	},
 	Type: AddPinOutput{},
 	Run: func(req cmds.Request, res cmds.Response) {
@@ -68,7 +102,11 @@ var addPinCmd = &cmds.Command{
 			return
 		}
 
-		defer n.Blockstore.PinLock().Unlock()
+		// this may run a GC, so it must happen before taking the pin lock
+		if err := corerepo.CheckStorageQuota(req.Context(), n, 0); err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
 
 		// set recursive flag
 		recursive, _, err := req.Option("recursive").Bool()
@@ -77,9 +115,37 @@ var addPinCmd = &cmds.Command{
 			return
 		}
 		showProgress, _, _ := req.Option("progress").Bool()
+		prioStr, _, _ := req.Option("priority").String()
+		prio, err := core.ParsePriority(prioStr)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
+
+		if wait, _, _ := req.Option("wait").Bool(); !wait {
+			// the job must outlive the command
+			if !n.OnlineMode() {
+				res.SetError(ErrNotOnline, cmdkit.ErrClient)
+				return
+			}
+
+			paths := req.Arguments()
+			id, err := n.PinJobs.Start(func(ctx context.Context, p *core.PinProgress) ([]cid.Cid, error) {
+				defer n.Blockstore.PinLock().Unlock()
+				return corerepo.PinWithProgress(n, ctx, paths, recursive, prio, p)
+			})
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+			res.SetOutput(&AddPinOutput{Job: id})
+			return
+		}
+
+		defer n.Blockstore.PinLock().Unlock()
 
 		if !showProgress {
-			added, err := corerepo.Pin(n, req.Context(), req.Arguments(), recursive)
+			added, err := corerepo.PinWithProgress(n, req.Context(), req.Arguments(), recursive, prio, nil)
 			if err != nil {
 				res.SetError(err, cmdkit.ErrNormal)
 				return
@@ -90,8 +156,8 @@ var addPinCmd = &cmds.Command{
 
 		out := make(chan interface{})
 		res.SetOutput((<-chan interface{})(out))
-		v := new(dag.ProgressTracker)
-		ctx := v.DeriveContext(req.Context())
+		v := new(core.PinProgress)
+		ctx := req.Context()
 
 		type pinResult struct {
 			pins []cid.Cid
@@ -99,7 +165,7 @@ var addPinCmd = &cmds.Command{
 		}
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := corerepo.Pin(n, ctx, req.Arguments(), recursive)
+			added, err := corerepo.PinWithProgress(n, ctx, req.Arguments(), recursive, prio, v)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -114,13 +180,13 @@ var addPinCmd = &cmds.Command{
 					return
 				}
 
-				if pv := v.Value(); pv != 0 {
-					out <- &AddPinOutput{Progress: v.Value()}
+				if pv := v.Blocks(); pv != 0 {
+					out <- &AddPinOutput{Progress: int(pv)}
 				}
 				out <- &AddPinOutput{Pins: cidsToStrings(val.pins)}
 				return
 			case <-ticker.C:
-				out <- &AddPinOutput{Progress: v.Value()}
+				out <- &AddPinOutput{Progress: int(v.Blocks())}
 			case <-ctx.Done():
 				log.Error(ctx.Err())
 				res.SetError(ctx.Err(), cmdkit.ErrNormal)
@@ -139,6 +205,9 @@ var addPinCmd = &cmds.Command{
 
 			switch out := v.(type) {
 			case *AddPinOutput:
+				if out.Job != "" {
+					return bytes.NewBufferString(out.Job + "\n"), nil
+				}
 				if out.Pins != nil {
 					added = out.Pins
 				} else {
@@ -170,6 +239,96 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
+var statusPinCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the progress of a pin job.",
+		ShortDescription: `
+'ipfs pin status' shows the state of a pin job started by
+'ipfs pin add --wait=false': fetching, pinned or failed. The progress counts
+the blocks and the bytes of the DAGs fetched, or found in the repo, and shows
+the depth of the blocks being fetched.
+
+With --watch, the progress is streamed until the job is done, and the command
+fails if the job does. It can be run again to resume watching a job, e.g.
+after losing the connection to the daemon.
+
+The daemon keeps the state of the last 128 finished jobs, and forgets all of
+them when it stops.
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", true, false, "The ID of the pin job."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("watch", "w", "Stream the progress until the job is done."),
+	},
+	Type: core.PinEvent{},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		id := req.Arguments()[0]
+		if watch, _, _ := req.Option("watch").Bool(); !watch {
+			st, ok := n.PinJobs.Status(id)
+			if !ok {
+				res.SetError(fmt.Errorf("unknown pin job %s", id), cmdkit.ErrClient)
+				return
+			}
+			res.SetOutput(&st)
+			return
+		}
+
+		events, ok := n.PinJobs.Watch(req.Context(), id)
+		if !ok {
+			res.SetError(fmt.Errorf("unknown pin job %s", id), cmdkit.ErrClient)
+			return
+		}
+
+		out := make(chan interface{})
+		res.SetOutput((<-chan interface{})(out))
+		defer close(out)
+		for ev := range events {
+			ev := ev
+			select {
+			case out <- &ev:
+			case <-req.Context().Done():
+				return
+			}
+			if ev.State == core.PinJobFailed {
+				res.SetError(fmt.Errorf("pin job %s failed: %s", id, ev.Error), cmdkit.ErrNormal)
+				return
+			}
+		}
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			ev, ok := v.(*core.PinEvent)
+			if !ok {
+				return nil, e.TypeErr(ev, v)
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "%s: %d blocks, %d bytes, depth %d\n", ev.State, ev.Blocks, ev.Bytes, ev.Depth)
+			for _, c := range ev.Pins {
+				fmt.Fprintf(buf, "pinned %s\n", c)
+			}
+			// the command fails with the error when watching
+			if watch, _, _ := res.Request().Option("watch").Bool(); ev.Error != "" && !watch {
+				fmt.Fprintf(buf, "error: %s\n", ev.Error)
+			}
+			return buf, nil
+		},
+	},
+}
+
 var rmPinCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Remove pinned objects from local storage.",
@@ -270,6 +429,14 @@ Example:
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN direct
 	$ ipfs pin ls QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN direct
+
+With --stream, the pins are written as they are listed, the recursive ones
+first, then the indirect and the direct ones, each sorted, instead of all at
+once. Each pin of the JSON output, '--enc=json', then has a resume token, and
+an interrupted listing resumes after a pin with '--resume=<token>' and the
+same type:
+
+	$ ipfs pin ls --stream --resume=<token>
 `,
 	},
 
@@ -279,6 +446,8 @@ Example:
 	Options: []cmdkit.Option{
 		cmdkit.StringOption("type", "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\".").WithDefault("all"),
 		cmdkit.BoolOption("quiet", "q", "Write just hashes of objects."),
+		cmdkit.BoolOption("stream", "s", "Write the pins as they are listed, with their resume tokens."),
+		cmdkit.StringOption("resume", "Resume the streamed listing after the pin of this resume token. Implies --stream."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
@@ -301,6 +470,34 @@ Example:
 			return
 		}
 
+		stream, _, _ := req.Option("stream").Bool()
+		tok, _, _ := req.Option("resume").String()
+		if stream || tok != "" {
+			if len(req.Arguments()) > 0 {
+				res.SetError(errors.New("--stream and --resume list all the pins, without arguments"), cmdkit.ErrClient)
+				return
+			}
+
+			var resume resumeToken
+			if tok != "" {
+				resume, err = parseResumeToken(tok, "pin/ls", typeStr)
+				if err != nil {
+					res.SetError(err, cmdkit.ErrClient)
+					return
+				}
+			}
+
+			out := make(chan interface{})
+			res.SetOutput((<-chan interface{})(out))
+			go func() {
+				defer close(out)
+				if err := pinLsStream(req.Context(), typeStr, n, resume, out); err != nil {
+					log.Errorf("pin ls --stream: %s", err)
+				}
+			}()
+			return
+		}
+
 		var keys map[string]RefKeyObject
 
 		if len(req.Arguments()) > 0 {
@@ -493,8 +690,307 @@ var verifyPinCmd = &cmds.Command{
 	},
 }
 
+// PinReconcileRes is the result returned for each pin that differs between
+// the local node and the remote node in "pin reconcile".
+type PinReconcileRes struct {
+	Cid    string
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+var reconcilePinCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Compare the local recursive pins with another node's.",
+		ShortDescription: `
+'ipfs pin reconcile' fetches the recursive pinset of the node whose API is
+listening on the --with address and reports pins only present on one side.
+
+With --pull, pins missing locally are fetched and pinned. With --push, pins
+missing on the remote node are pinned there through its API.
+`,
+		LongDescription: `
+'ipfs pin reconcile' fetches the recursive pinset of the node whose API is
+listening on the --with address and reports pins only present on one side.
+The address can either be a multiaddr or an http(s) URL:
+
+	$ ipfs pin reconcile --with=/ip4/10.0.0.2/tcp/5001
+	$ ipfs pin reconcile --with=http://10.0.0.2:5001
+
+The pins are compared by multihash, so a DAG pinned with a CIDv0 on one node
+and with a CIDv1 on the other isn't reported. The remote pins are listed with
+'ipfs pin ls --stream', which the remote node must support.
+
+With --pull, pins missing locally are fetched and pinned. With --push, pins
+missing on the remote node are pinned there through its API. Using both
+makes the two pinsets identical, except for pins that failed to transfer.
+
+Status is one of "missing-local", "missing-remote", "pulled" or "pushed".
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("with", "API address of the node to reconcile with."),
+		cmdkit.BoolOption("pull", "Pin what is missing locally."),
+		cmdkit.BoolOption("push", "Pin what is missing on the remote node."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		addr, _, _ := req.Option("with").String()
+		if addr == "" {
+			res.SetError(fmt.Errorf("missing --with address"), cmdkit.ErrClient)
+			return
+		}
+		pull, _, _ := req.Option("pull").Bool()
+		push, _, _ := req.Option("push").Bool()
+
+		remote, err := newRemoteAPI(addr)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
+
+		theirs, err := remote.recursivePins(req.Context())
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		missingLocal, missingRemote := diffPins(n.Pinning.RecursiveKeys(), theirs)
+
+		out := make(chan interface{})
+		res.SetOutput((<-chan interface{})(out))
+
+		go func() {
+			defer close(out)
+
+			emit := func(r *PinReconcileRes) bool {
+				select {
+				case out <- r:
+					return true
+				case <-req.Context().Done():
+					return false
+				}
+			}
+
+			for _, c := range missingLocal {
+				r := &PinReconcileRes{Cid: c, Status: "missing-local"}
+				if pull {
+					if err := pinLocal(req.Context(), n, c); err != nil {
+						r.Error = err.Error()
+					} else {
+						r.Status = "pulled"
+					}
+				}
+				if !emit(r) {
+					return
+				}
+			}
+
+			for _, c := range missingRemote {
+				r := &PinReconcileRes{Cid: c, Status: "missing-remote"}
+				if push {
+					if err := remote.pin(req.Context(), c); err != nil {
+						r.Error = err.Error()
+					} else {
+						r.Status = "pushed"
+					}
+				}
+				if !emit(r) {
+					return
+				}
+			}
+		}()
+	},
+	Type: PinReconcileRes{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			r, ok := v.(*PinReconcileRes)
+			if !ok {
+				return nil, e.TypeErr(r, v)
+			}
+
+			buf := new(bytes.Buffer)
+			if r.Error != "" {
+				fmt.Fprintf(buf, "%s %s: %s\n", r.Status, r.Cid, r.Error)
+			} else {
+				fmt.Fprintf(buf, "%s %s\n", r.Status, r.Cid)
+			}
+			return buf, nil
+		},
+	},
+}
+
+// diffPins returns the pins only present in theirs and those only present in
+// ours, sorted. The pins are compared by multihash, as the same DAG may be
+// pinned with a CIDv0 on a node and with a CIDv1 on the other.
+func diffPins(ours, theirs []cid.Cid) (missingLocal, missingRemote []string) {
+	byHash := func(cids []cid.Cid) map[string]cid.Cid {
+		m := make(map[string]cid.Cid, len(cids))
+		for _, c := range cids {
+			m[string(c.Hash())] = c
+		}
+		return m
+	}
+	o, t := byHash(ours), byHash(theirs)
+
+	for h, c := range t {
+		if _, ok := o[h]; !ok {
+			missingLocal = append(missingLocal, c.String())
+		}
+	}
+	for h, c := range o {
+		if _, ok := t[h]; !ok {
+			missingRemote = append(missingRemote, c.String())
+		}
+	}
+	sort.Strings(missingLocal)
+	sort.Strings(missingRemote)
+	return missingLocal, missingRemote
+}
+
+func pinLocal(ctx context.Context, n *core.IpfsNode, c string) error {
+	if err := corerepo.CheckStorageQuota(ctx, n, 0); err != nil {
+		return err
+	}
+
+	defer n.Blockstore.PinLock().Unlock()
+
+	_, err := corerepo.Pin(n, ctx, []string{c}, true)
+	return err
+}
+
+const (
+	// remoteAPITimeout bounds the calls to the API of another node, a
+	// pin/add pushed to it included.
+	remoteAPITimeout = 30 * time.Minute
+
+	// maxRemoteResponseSize bounds the responses of the API of another
+	// node, but for the listing of its pins, bounded by maxRemotePinLsSize.
+	maxRemoteResponseSize = 1 << 20
+	maxRemotePinLsSize    = 1 << 30
+)
+
+// remoteAPI talks to the HTTP API of another node.
+type remoteAPI struct {
+	base   string
+	client *http.Client
+}
+
+func newRemoteAPI(addr string) (*remoteAPI, error) {
+	base := addr
+	if strings.HasPrefix(addr, "/") {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		_, host, err := manet.DialArgs(maddr)
+		if err != nil {
+			return nil, err
+		}
+		base = "http://" + host
+	} else if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		return nil, fmt.Errorf("invalid API address %q: expected a multiaddr or http(s) URL", addr)
+	}
+
+	return &remoteAPI{
+		base:   strings.TrimSuffix(base, "/") + "/api/v0/",
+		client: &http.Client{Timeout: remoteAPITimeout},
+	}, nil
+}
+
+// do sends cmd to the remote node and returns the response, which must be
+// closed, if it succeeded.
+func (r *remoteAPI) do(ctx context.Context, cmd string, args url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("POST", r.base+cmd+"?"+args.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr cmdkit.Error
+		err := json.NewDecoder(io.LimitReader(resp.Body, maxRemoteResponseSize)).Decode(&apiErr)
+		if err != nil || apiErr.Message == "" {
+			return nil, fmt.Errorf("remote %s: %s", cmd, resp.Status)
+		}
+		return nil, fmt.Errorf("remote %s: %s", cmd, apiErr.Message)
+	}
+	return resp, nil
+}
+
+func (r *remoteAPI) call(ctx context.Context, cmd string, args url.Values, out interface{}) error {
+	resp, err := r.do(ctx, cmd, args)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxRemoteResponseSize)).Decode(out)
+}
+
+// recursivePins streams the recursive pins of the remote node, so that a
+// large pinset isn't buffered by either side as a single object.
+func (r *remoteAPI) recursivePins(ctx context.Context) ([]cid.Cid, error) {
+	resp, err := r.do(ctx, "pin/ls", url.Values{"type": {"recursive"}, "stream": {"true"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var pins []cid.Cid
+	body := &io.LimitedReader{R: resp.Body, N: maxRemotePinLsSize}
+	dec := json.NewDecoder(body)
+	for {
+		var list RefKeyList
+		err := dec.Decode(&list)
+		if err == io.EOF && body.N <= 0 {
+			return nil, fmt.Errorf("remote pin/ls: the pins exceed %d bytes", maxRemotePinLsSize)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("remote pin/ls: %s", err)
+		}
+
+		for k := range list.Keys {
+			c, err := cid.Decode(k)
+			if err != nil {
+				return nil, fmt.Errorf("remote pin/ls: invalid cid %q: %s", k, err)
+			}
+			pins = append(pins, c)
+		}
+	}
+
+	// the trailer is only read with the end of the body
+	if msg := resp.Trailer.Get("X-Stream-Error"); msg != "" {
+		return nil, fmt.Errorf("remote pin/ls: %s", msg)
+	}
+	return pins, nil
+}
+
+func (r *remoteAPI) pin(ctx context.Context, c string) error {
+	var out AddPinOutput
+	return r.call(ctx, "pin/add", url.Values{"arg": {c}, "recursive": {"true"}}, &out)
+}
+
 type RefKeyObject struct {
 	Type string
+	// Resume is the token to resume the streamed listing after the pin.
+	Resume string `json:",omitempty"`
 }
 
 type RefKeyList struct {
@@ -580,6 +1076,69 @@ func pinLsAll(ctx context.Context, typeStr string, n *core.IpfsNode) (map[string
 	return keys, nil
 }
 
+// pinLsStreamTypes are the types of the pins in the order of the streamed
+// listings, each pin listed with the first of its types.
+var pinLsStreamTypes = []string{"recursive", "indirect", "direct"}
+
+// pinLsStream writes the pins of typeStr after the one of the resume token
+// to out, one RefKeyList each. The pins of each type are sorted for the
+// tokens to stay valid while pins are added and removed.
+func pinLsStream(ctx context.Context, typeStr string, n *core.IpfsNode, resume resumeToken, out chan<- interface{}) error {
+	listed := cid.NewSet()
+	resuming := resume.Type != ""
+	for _, t := range pinLsStreamTypes {
+		if typeStr != t && typeStr != "all" {
+			continue
+		}
+
+		var keys []cid.Cid
+		switch t {
+		case "recursive":
+			keys = n.Pinning.RecursiveKeys()
+		case "direct":
+			keys = n.Pinning.DirectKeys()
+		case "indirect":
+			set := cid.NewSet()
+			for _, k := range n.Pinning.RecursiveKeys() {
+				err := dag.EnumerateChildren(ctx, dag.GetLinksWithDAG(n.DAG), k, set.Visit)
+				if err != nil {
+					return err
+				}
+			}
+			keys = set.Keys()
+		}
+
+		strs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			if listed.Visit(k) {
+				strs = append(strs, k.String())
+			}
+		}
+		// the types before the one of the token were listed
+		if resuming && t != resume.Type {
+			continue
+		}
+		sort.Strings(strs)
+
+		for _, k := range strs {
+			if resuming && k <= resume.Key {
+				continue
+			}
+			obj := RefKeyObject{
+				Type:   t,
+				Resume: resumeToken{Cmd: "pin/ls", Args: resumeArgs(typeStr), Type: t, Key: k}.String(),
+			}
+			select {
+			case out <- &RefKeyList{Keys: map[string]RefKeyObject{k: obj}}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		resuming = false
+	}
+	return nil
+}
+
 // PinVerifyRes is the result returned for each pin checked in "pin verify"
 type PinVerifyRes struct {
 	Cid string
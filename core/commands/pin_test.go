@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+)
+
+func TestDiffPins(t *testing.T) {
+	decode := func(s string) cid.Cid {
+		c, err := cid.Decode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	both := decode("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	local := decode("QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB")
+	remote := decode("QmTkzDwWqPbnAh5YiV5VwcTLnGdwSNsNTn2aDxdXBFca7D")
+
+	// the same DAG is pinned as a CIDv0 locally and as a CIDv1 remotely
+	ours := []cid.Cid{both, local}
+	theirs := []cid.Cid{cid.NewCidV1(cid.DagProtobuf, both.Hash()), remote}
+
+	missingLocal, missingRemote := diffPins(ours, theirs)
+	if expected := []string{remote.String()}; !reflect.DeepEqual(missingLocal, expected) {
+		t.Errorf("expected %v missing locally, got %v", expected, missingLocal)
+	}
+	if expected := []string{local.String()}; !reflect.DeepEqual(missingRemote, expected) {
+		t.Errorf("expected %v missing remotely, got %v", expected, missingRemote)
+	}
+}
+
+func TestRemoteRecursivePins(t *testing.T) {
+	pins := []string{
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB",
+	}
+	var streamErr string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/pin/ls" || r.URL.Query().Get("stream") != "true" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Header().Set("Trailer", "X-Stream-Error")
+		for _, p := range pins {
+			fmt.Fprintf(w, `{"Keys":{"%s":{"Type":"recursive"}}}`+"\n", p)
+		}
+		if streamErr != "" {
+			w.Header().Set("X-Stream-Error", streamErr)
+		}
+	}))
+	defer srv.Close()
+
+	remote, err := newRemoteAPI(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := remote.recursivePins(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var strs []string
+	for _, c := range got {
+		strs = append(strs, c.String())
+	}
+	if !reflect.DeepEqual(strs, pins) {
+		t.Errorf("expected the pins %v, got %v", pins, strs)
+	}
+
+	streamErr = "listing failed"
+	if _, err := remote.recursivePins(context.Background()); err == nil {
+		t.Error("expected the error of the stream to be returned")
+	}
+}
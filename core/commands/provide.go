@@ -0,0 +1,155 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	bitswap "gx/ipfs/QmUyaGN3WPr3CTLai7DBvMikagK45V4fUi8p8cNRaJQoU1/go-bitswap"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// ProvideStat is the output of 'ipfs provide stat'.
+type ProvideStat struct {
+	Strategy string
+	Interval string
+
+	// QueueLen is the number of new blocks waiting to be provided,
+	// QueueNewPins the number of roots of new pins among them, which are
+	// provided first, and QueueLow the number of those with a low priority.
+	QueueLen      int
+	QueueNewPins  int
+	QueueLow      int
+	QueueProvided uint64
+	QueueFailed   uint64
+
+	Reproviding         bool
+	ReprovidingProvided int
+
+	// LastReprovide is zero before the first complete reprovide.
+	LastReprovide         time.Time
+	LastReprovideDuration time.Duration
+	LastReprovideKeys     int
+
+	TotalProvides      uint64
+	AvgProvideDuration time.Duration
+}
+
+var ProvideCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the announcements of the local content to the network.",
+		ShortDescription: `
+The blocks added to the node are queued to be announced to the routing system,
+the roots of the new pins first, except the pins with a low --priority, and
+reannounced periodically by the
+reprovider, following Reprovider.Strategy and Reprovider.Interval. The queue
+is kept in the repo, the blocks not announced before the daemon stops are
+announced after it restarts.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"stat": provideStatCmd,
+	},
+}
+
+var provideStatCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the statistics of the provides.",
+		ShortDescription: `
+'ipfs provide stat' shows the reprovider strategy and interval, the number of
+new blocks waiting to be provided, when the last complete reprovide happened
+and how long providing a block takes on average. The provides of the queue
+which failed are retried.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.OnlineMode() {
+			return cmdkit.Errorf(cmdkit.ErrClient, ErrNotOnline.Error())
+		}
+
+		if nd.Reprovider == nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, "light clients don't reprovide")
+		}
+
+		cfg, err := nd.Repo.Config()
+		if err != nil {
+			return err
+		}
+
+		out := &ProvideStat{
+			Strategy: cfg.Reprovider.Strategy,
+			Interval: cfg.Reprovider.Interval,
+		}
+		if out.Strategy == "" {
+			out.Strategy = "all"
+		}
+		if out.Interval == "" {
+			out.Interval = "12h"
+		}
+
+		if bs, ok := nd.Exchange.(*bitswap.Bitswap); ok {
+			st, err := bs.Stat()
+			if err != nil {
+				return err
+			}
+			out.QueueLen = st.ProvideBufLen
+		}
+		if nd.ProvideQueue != nil {
+			st := nd.ProvideQueue.Stat()
+			out.QueueLen += st.High + st.Normal + st.Low
+			out.QueueNewPins = st.High
+			out.QueueLow = st.Low
+			out.QueueProvided = st.Provided
+			out.QueueFailed = st.Failed
+		}
+
+		st := nd.Reprovider.Stat()
+		out.Reproviding = st.Running
+		out.ReprovidingProvided = st.Provided
+		out.LastReprovide = st.LastRun
+		out.LastReprovideDuration = st.LastRunDuration
+		out.LastReprovideKeys = st.LastRunKeys
+		out.TotalProvides = st.TotalProvides
+		out.AvgProvideDuration = st.AvgProvideDuration
+
+		return cmds.EmitOnce(res, out)
+	},
+	Type: ProvideStat{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*ProvideStat)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			fmt.Fprintf(tw, "Strategy:\t%s\n", out.Strategy)
+			fmt.Fprintf(tw, "Interval:\t%s\n", out.Interval)
+			fmt.Fprintf(tw, "Queue:\t%d blocks, %d new pins, %d low priority\n", out.QueueLen, out.QueueNewPins, out.QueueLow)
+			fmt.Fprintf(tw, "Queue provides:\t%d provided, %d failed\n", out.QueueProvided, out.QueueFailed)
+			if out.Reproviding {
+				fmt.Fprintf(tw, "Reproviding:\tyes, %d keys provided so far\n", out.ReprovidingProvided)
+			} else {
+				fmt.Fprintln(tw, "Reproviding:\tno")
+			}
+			if out.LastReprovide.IsZero() {
+				fmt.Fprintln(tw, "Last reprovide:\tnever")
+			} else {
+				fmt.Fprintf(tw, "Last reprovide:\t%s, %d keys in %s\n",
+					out.LastReprovide.Format(time.RFC3339), out.LastReprovideKeys, out.LastReprovideDuration)
+			}
+			fmt.Fprintf(tw, "Average provide:\t%s over %d provides\n", out.AvgProvideDuration, out.TotalProvides)
+			return tw.Flush()
+		}),
+	},
+}
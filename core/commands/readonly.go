@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"strings"
+
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// mutatingCommands are the commands, with their subcommands, rejected by a
+// daemon started with --repo-read-only. When a command only modifies the repo
+// for some requests, the function tells whether the request does. The
+// commands missing here are checked to be read-only by TestReadOnlyCommands.
+var mutatingCommands = map[string]func(*cmds.Request) bool{
+	"add":                nil,
+	"block/put":          nil,
+	"block/rm":           nil,
+	"bootstrap/add":      nil,
+	"bootstrap/rm":       nil,
+	"config":             withArguments(2),
+	"config/edit":        nil,
+	"config/profile":     nil,
+	"config/replace":     nil,
+	"dag/put":            nil,
+	"dht/bulk/put":       nil,
+	"dht/provide":        withOption("priority"),
+	"dht/put":            nil,
+	"features/disable":   nil,
+	"features/enable":    nil,
+	"files/chcid":        nil,
+	"files/chmod":        nil,
+	"files/cp":           nil,
+	"files/flush":        nil,
+	"files/mirror/start": nil,
+	"files/mirror/stop":  nil,
+	"files/mkdir":        nil,
+	"files/mv":           nil,
+	"files/rm":           nil,
+	"files/sync":         nil,
+	"files/touch":        nil,
+	"files/write":        nil,
+	"key/gen":            nil,
+	"key/rename":         nil,
+	"key/rm":             nil,
+	"key/use":            withArguments(1),
+	"name/publish":       nil,
+	"name/resolve":       withOptions("pin"),
+	"object/new":         nil,
+	"object/patch":       nil,
+	"object/put":         nil,
+	"pin/add":            nil,
+	"pin/reconcile":      nil,
+	"pin/rm":             nil,
+	"pin/update":         nil,
+	"repo/compress":      nil,
+	"repo/fsck":          nil,
+	"repo/gc":            nil,
+	"repo/recover":       nil,
+	"repo/repair":        nil,
+	"repo/verify":        withOptions("repair", "quarantine"),
+	"routing/static/add": nil,
+	"routing/static/rm":  nil,
+	"stats/availability": withOption("interval", "duration"),
+	"swarm/filters":      withOption("default-deny"),
+	"swarm/filters/add":  nil,
+	"swarm/filters/rm":   nil,
+	"swarm/key/gen":      nil,
+	"swarm/key/rotate":   nil,
+	"swarm/peering/add":  nil,
+	"swarm/peering/rm":   nil,
+	"tar/add":            nil,
+	"update":             nil,
+	"upload/append":      nil,
+	"upload/new":         nil,
+	"upload/rm":          nil,
+	"urlstore/add":       nil,
+}
+
+// withArguments returns whether a request has at least n arguments.
+func withArguments(n int) func(*cmds.Request) bool {
+	return func(req *cmds.Request) bool { return len(req.Arguments) >= n }
+}
+
+// withOptions returns whether a request enables one of the boolean options.
+func withOptions(names ...string) func(*cmds.Request) bool {
+	return func(req *cmds.Request) bool {
+		for _, name := range names {
+			if on, _ := req.Options[name].(bool); on {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// withOption returns whether a request sets one of the options, to any value.
+func withOption(names ...string) func(*cmds.Request) bool {
+	return func(req *cmds.Request) bool {
+		for _, name := range names {
+			if _, ok := req.Options[name]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func init() {
+	for p, mutates := range mutatingCommands {
+		c := rootSubcommands[strings.Split(p, "/")[0]]
+		for _, name := range strings.Split(p, "/")[1:] {
+			if c == nil {
+				break
+			}
+			c = c.Subcommands[name]
+		}
+		if c == nil {
+			panic("unknown mutating command " + p)
+		}
+		rejectInReadOnly(c, mutates)
+	}
+}
+
+// rejectInReadOnly makes c and its subcommands fail when they would modify
+// the repo of a read-only daemon.
+func rejectInReadOnly(c *cmds.Command, mutates func(*cmds.Request) bool) {
+	if run := c.Run; run != nil {
+		c.Run = func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+			cctx, ok := env.(*oldcmds.Context)
+			if ok && cctx.ReadOnly && (mutates == nil || mutates(req)) {
+				return cmdkit.Errorf(cmdkit.ErrClient, "'ipfs %s' is not allowed: %s, the daemon runs with --repo-read-only",
+					strings.Join(req.Path, " "), core.ErrRepoReadOnly)
+			}
+			return run(req, res, env)
+		}
+	}
+	for _, sub := range c.Subcommands {
+		rejectInReadOnly(sub, mutates)
+	}
+}
@@ -0,0 +1,221 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// TestReadOnlyCommands fails on the commands that are neither known to be
+// read-only nor listed in mutatingCommands, so that a new command is
+// classified when it is added.
+func TestReadOnlyCommands(t *testing.T) {
+	readOnly := []string{
+		"bitswap",
+		"bitswap/ledger",
+		"bitswap/reprovide",
+		"bitswap/sessions",
+		"bitswap/stat",
+		"bitswap/wantlist",
+		"block",
+		"block/get",
+		"block/ls",
+		"block/stat",
+		"bootstrap",
+		"bootstrap/list",
+		"cat",
+		"commands",
+		"dag",
+		"dag/get",
+		"dag/resolve",
+		"dag/stat",
+		"dht",
+		"dht/bulk",
+		"dht/bulk/get",
+		"dht/findpeer",
+		"dht/findprovs",
+		"dht/get",
+		"dht/mode",
+		"dht/query",
+		"diag",
+		"diag/cmds",
+		"diag/cmds/clear",
+		"diag/cmds/set-time",
+		"diag/largest",
+		"diag/sys",
+		"dns",
+		"features",
+		"features/ls",
+		"file",
+		"file/ls",
+		"files",
+		"files/export",
+		"files/export-car",
+		"files/ls",
+		"files/mirror",
+		"files/mirror/status",
+		"files/read",
+		"files/stat",
+		"files/watch",
+		"filestore",
+		"filestore/dups",
+		"filestore/ls",
+		"filestore/verify",
+		"get",
+		"id",
+		"key",
+		"key/list",
+		"log",
+		"log/level",
+		"log/ls",
+		"log/status",
+		"log/tail",
+		"ls",
+		"mount",
+		"name",
+		"name/pubsub",
+		"name/pubsub/cancel",
+		"name/pubsub/state",
+		"name/pubsub/subs",
+		"object",
+		"object/data",
+		"object/diff",
+		"object/get",
+		"object/links",
+		"object/stat",
+		"p2p",
+		"p2p/close",
+		"p2p/forward",
+		"p2p/listen",
+		"p2p/ls",
+		"p2p/stream",
+		"p2p/stream/close",
+		"p2p/stream/ls",
+		"pin",
+		"pin/ls",
+		"pin/status",
+		"pin/verify",
+		"ping",
+		"provide",
+		"provide/stat",
+		"pubsub",
+		"pubsub/ls",
+		"pubsub/peers",
+		"pubsub/pub",
+		"pubsub/sub",
+		"refs",
+		"refs/local",
+		"repo",
+		"repo/stat",
+		"repo/version",
+		"resolve",
+		"routing",
+		"routing/static",
+		"routing/static/ls",
+		"search",
+		"shutdown",
+		"stats",
+		"stats/bitswap",
+		"stats/bw",
+		"stats/dcutr",
+		"stats/dht",
+		"stats/pex",
+		"stats/repo",
+		"stats/serve",
+		"swarm",
+		"swarm/addrs",
+		"swarm/addrs/listen",
+		"swarm/addrs/local",
+		"swarm/backoff",
+		"swarm/backoff/clear",
+		"swarm/backoff/ls",
+		"swarm/connect",
+		"swarm/disconnect",
+		"swarm/discovery",
+		"swarm/key",
+		"swarm/key/show",
+		"swarm/limit",
+		"swarm/peering",
+		"swarm/peering/ls",
+		"swarm/peers",
+		"swarm/qos",
+		"swarm/relay",
+		"swarm/rendezvous",
+		"swarm/rendezvous/discover",
+		"swarm/rendezvous/register",
+		"swarm/reputation",
+		"swarm/stats",
+		"tar",
+		"tar/cat",
+		"upload",
+		"upload/ls",
+		"upload/status",
+		"urlstore",
+		"version",
+	}
+
+	cmdSet := make(map[string]struct{})
+	collectPaths("", Root, cmdSet)
+
+	for _, path := range readOnly {
+		if _, ok := cmdSet["/"+path]; !ok {
+			t.Errorf("read-only command %q not in the command tree", path)
+		}
+		if mutating(path) {
+			t.Errorf("%q is both read-only and mutating", path)
+		}
+		delete(cmdSet, "/"+path)
+	}
+
+	for path := range cmdSet {
+		if !mutating(path[1:]) {
+			t.Errorf("%q is neither read-only nor in mutatingCommands", path)
+		}
+	}
+}
+
+// mutating returns whether the command of path, or one of its parents, is in
+// mutatingCommands.
+func mutating(path string) bool {
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		if _, ok := mutatingCommands[strings.Join(parts[:i+1], "/")]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMutatingRequests(t *testing.T) {
+	for _, c := range []struct {
+		path    string
+		args    []string
+		opts    cmdkit.OptMap
+		mutates bool
+	}{
+		{"config", []string{"Datastore.StorageMax"}, nil, false},
+		{"dht/provide", []string{"QmFoo"}, nil, false},
+		{"dht/provide", []string{"QmFoo"}, cmdkit.OptMap{"priority": "low"}, true},
+		{"config", []string{"Datastore.StorageMax", "20GB"}, nil, true},
+		{"key/use", nil, nil, false},
+		{"key/use", []string{"mykey"}, nil, true},
+		{"name/resolve", nil, nil, false},
+		{"name/resolve", nil, cmdkit.OptMap{"pin": false}, false},
+		{"name/resolve", nil, cmdkit.OptMap{"pin": true}, true},
+		{"repo/verify", nil, nil, false},
+		{"repo/verify", nil, cmdkit.OptMap{"repair": true}, true},
+		{"repo/verify", nil, cmdkit.OptMap{"quarantine": true}, true},
+		{"stats/availability", []string{"QmFoo"}, nil, false},
+		{"stats/availability", []string{"QmFoo"}, cmdkit.OptMap{"interval": "1h"}, true},
+		{"stats/availability", []string{"QmFoo"}, cmdkit.OptMap{"duration": "24h"}, true},
+		{"swarm/filters", nil, nil, false},
+		{"swarm/filters", nil, cmdkit.OptMap{"default-deny": false}, true},
+	} {
+		req := &cmds.Request{Arguments: c.args, Options: c.opts}
+		if m := mutatingCommands[c.path](req); m != c.mutates {
+			t.Errorf("%s %v %v: expected mutating %t, got %t", c.path, c.args, c.opts, c.mutates, m)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 
@@ -51,6 +52,22 @@ with the following format:
   <link base58 hash>
 
 NOTE: List all references recursively by using the flag '-r'.
+`,
+		LongDescription: `
+Lists the hashes of all the links an IPFS or IPNS object(s) contains,
+with the following format:
+
+  <link base58 hash>
+
+NOTE: List all references recursively by using the flag '-r'.
+
+Each ref of the JSON output, '--enc=json', has a resume token. An interrupted
+listing resumes after a ref with '--resume=<token>' and the same arguments
+and options, without walking the DAGs before the ref again:
+
+  > ipfs refs -r --resume=<token> <ipfs-path>
+
+With '--unique', the refs written before the token can be written again.
 `,
 	},
 	Subcommands: map[string]*cmds.Command{
@@ -65,6 +82,7 @@ NOTE: List all references recursively by using the flag '-r'.
 		cmdkit.BoolOption("unique", "u", "Omit duplicate refs from output."),
 		cmdkit.BoolOption("recursive", "r", "Recursively list links of child nodes."),
 		cmdkit.IntOption("max-depth", "Only for recursive refs, limits fetch and listing to the given depth").WithDefault(-1),
+		cmdkit.StringOption("resume", "Resume the listing after the ref of this resume token."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		ctx := req.Context()
@@ -123,12 +141,29 @@ NOTE: List all references recursively by using the flag '-r'.
 			return
 		}
 
+		// the tokens are bound to the resolved objects, the positions of
+		// the refs change with them
+		tokenArgs := []string{fmt.Sprint(unique), fmt.Sprint(maxDepth)}
+		for _, o := range objs {
+			tokenArgs = append(tokenArgs, o.Cid().String())
+		}
+		var resume []int
+		if tok, _, _ := req.Option("resume").String(); tok != "" {
+			t, err := parseResumeToken(tok, "refs", tokenArgs...)
+			if err != nil || len(t.Path) == 0 || t.Path[0] >= len(objs) {
+				res.SetError(ErrInvalidResumeToken, cmdkit.ErrClient)
+				return
+			}
+			resume = t.Path
+		}
+
 		out := make(chan interface{})
 		res.SetOutput((<-chan interface{})(out))
 
 		go func() {
 			defer close(out)
 
+			hash := resumeArgs(tokenArgs...)
 			rw := RefWriter{
 				out:      out,
 				DAG:      n.DAG,
@@ -136,9 +171,20 @@ NOTE: List all references recursively by using the flag '-r'.
 				Unique:   unique,
 				PrintFmt: format,
 				MaxDepth: maxDepth,
+				Token: func(pos []int) string {
+					return resumeToken{Cmd: "refs", Args: hash, Path: pos}.String()
+				},
 			}
 
-			for _, o := range objs {
+			for i, o := range objs {
+				if len(resume) > 0 && i < resume[0] {
+					continue
+				}
+				rw.pos = []int{i}
+				rw.Resume = nil
+				if len(resume) > 0 && i == resume[0] {
+					rw.Resume = resume[1:]
+				}
 				if _, err := rw.WriteRefs(o); err != nil {
 					select {
 					case out <- &RefWrapper{Err: err.Error()}:
@@ -235,6 +281,8 @@ func objectsForPaths(ctx context.Context, n *core.IpfsNode, paths []string) ([]i
 type RefWrapper struct {
 	Ref string
 	Err string
+	// Resume is the token to resume the listing after the ref.
+	Resume string `json:",omitempty"`
 }
 
 type RefWriter struct {
@@ -246,23 +294,57 @@ type RefWriter struct {
 	MaxDepth int
 	PrintFmt string
 
+	// Resume is the position, in the object, of the ref to resume the
+	// listing after: the index of the link at each depth. Token makes the
+	// resume tokens of the positions of the written refs.
+	Resume []int
+	Token  func(pos []int) string
+
 	seen map[string]int
+	pos  []int
 }
 
 // WriteRefs writes refs of the given object to the underlying writer.
 func (rw *RefWriter) WriteRefs(n ipld.Node) (int, error) {
-	return rw.writeRefsRecursive(n, 0)
+	if len(rw.pos) == 0 {
+		rw.pos = []int{0}
+	}
+	return rw.writeRefsRecursive(n, 0, rw.Resume)
 
 }
 
-func (rw *RefWriter) writeRefsRecursive(n ipld.Node, depth int) (int, error) {
+func (rw *RefWriter) writeRefsRecursive(n ipld.Node, depth int, resume []int) (int, error) {
 	nc := n.Cid()
+	links := n.Links()
+
+	// The links before the resumed one are skipped without being fetched.
+	start := 0
+	if len(resume) > 0 {
+		start = resume[0]
+		if start < 0 || start >= len(links) {
+			return 0, ErrInvalidResumeToken
+		}
+	}
+	cids := make([]cid.Cid, 0, len(links)-start)
+	for _, l := range links[start:] {
+		cids = append(cids, l.Cid)
+	}
 
 	var count int
-	for i, ng := range ipld.GetDAG(rw.Ctx, rw.DAG, n) {
-		lc := n.Links()[i].Cid
+	for j, ng := range ipld.GetNodes(rw.Ctx, rw.DAG, cids) {
+		i := start + j
+		lc := links[i].Cid
 		goDeeper, shouldWrite := rw.visit(lc, depth+1) // The children are at depth+1
 
+		// The resumed ref was written before the token, its children are
+		// resumed after the rest of the position.
+		var childResume []int
+		if len(resume) > 0 && j == 0 {
+			shouldWrite = false
+			childResume = resume[1:]
+		}
+		rw.pos = append(rw.pos[:depth+1], i)
+
 		// Avoid "Get()" on the node and continue with next Link.
 		// We can do this if:
 		// - We printed it before (thus it was already seen and
@@ -285,7 +367,7 @@ func (rw *RefWriter) writeRefsRecursive(n ipld.Node, depth int) (int, error) {
 
 		// Write this node if not done before (or !Unique)
 		if shouldWrite {
-			if err := rw.WriteEdge(nc, lc, n.Links()[i].Name); err != nil {
+			if err := rw.WriteEdge(nc, lc, links[i].Name); err != nil {
 				return count, err
 			}
 			count++
@@ -297,7 +379,7 @@ func (rw *RefWriter) writeRefsRecursive(n ipld.Node, depth int) (int, error) {
 		// Note when !Unique, branches are always considered
 		// unexplored and only depth limits apply.
 		if goDeeper {
-			c, err := rw.writeRefsRecursive(nd, depth+1)
+			c, err := rw.writeRefsRecursive(nd, depth+1, childResume)
 			count += c
 			if err != nil {
 				return count, err
@@ -386,6 +468,10 @@ func (rw *RefWriter) WriteEdge(from, to cid.Cid, linkname string) error {
 		s += to.String()
 	}
 
-	rw.out <- &RefWrapper{Ref: s}
+	var token string
+	if rw.Token != nil {
+		token = rw.Token(rw.pos)
+	}
+	rw.out <- &RefWrapper{Ref: s, Resume: token}
 	return nil
 }
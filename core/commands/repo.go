@@ -2,24 +2,31 @@ package commands
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	lgc "github.com/ipfs/go-ipfs/commands/legacy"
+	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	pin "github.com/ipfs/go-ipfs/pin"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
+	dshelp "gx/ipfs/QmPQ7bVbZAbGaJkBVJeTkkKXvLLZeN9CLWTf5fzUQ8yeWs/go-ipfs-ds-help"
 	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
 	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
 	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
 	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
 )
@@ -37,11 +44,14 @@ var RepoCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"stat":    repoStatCmd,
-		"gc":      repoGcCmd,
-		"fsck":    lgc.NewCommand(RepoFsckCmd),
-		"version": lgc.NewCommand(repoVersionCmd),
-		"verify":  lgc.NewCommand(repoVerifyCmd),
+		"stat":     repoStatCmd,
+		"gc":       repoGcCmd,
+		"fsck":     lgc.NewCommand(RepoFsckCmd),
+		"version":  lgc.NewCommand(repoVersionCmd),
+		"verify":   lgc.NewCommand(repoVerifyCmd),
+		"repair":   repoRepairCmd,
+		"recover":  repoRecoverCmd,
+		"compress": repoCompressCmd,
 	},
 }
 
@@ -136,11 +146,16 @@ StorageMax      string Maximum datastore size (from configuration)
 NumObjects      int Number of objects in the local repo.
 RepoPath        string The path to the repo being currently used.
 Version         string The repo version.
+
+With --compression, the blocks of the compressed datastores are read to
+report how many are compressed, their size on disk and their size once
+decompressed.
 `,
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("size-only", "Only report RepoSize and StorageMax."),
-		cmdkit.BoolOption("human", "Output sizes in MiB."),
+		cmdkit.BoolOption("human", "Output sizes in MiB and show how much of StorageMax is used."),
+		cmdkit.BoolOption("compression", "Report the compression of the blocks, reading all of them."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -165,6 +180,13 @@ Version         string The repo version.
 			return err
 		}
 
+		if compression, _ := req.Options["compression"].(bool); compression {
+			stat.Compression, err = corerepo.RepoCompressionStat(req.Context, n)
+			if err != nil {
+				return err
+			}
+		}
+
 		return cmds.EmitOnce(res, &stat)
 	},
 	Type: &corerepo.Stat{},
@@ -196,12 +218,22 @@ Version         string The repo version.
 
 			printSize("RepoSize", stat.RepoSize)
 			printSize("StorageMax", stat.StorageMax)
+			if human && stat.StorageMax != corerepo.NoLimit && stat.StorageMax > 0 {
+				used := float64(stat.RepoSize) / float64(stat.StorageMax) * 100
+				fmt.Fprintf(wtr, "StorageUsed (%%):\t%.1f\n", used)
+			}
 
 			if !sizeOnly {
 				fmt.Fprintf(wtr, "RepoPath:\t%s\n", stat.RepoPath)
 				fmt.Fprintf(wtr, "Version:\t%s\n", stat.Version)
 			}
 
+			if c := stat.Compression; c != nil {
+				fmt.Fprintf(wtr, "CompressedObjects:\t%d\n", c.Compressed)
+				printSize("StoredSize", c.StoredSize)
+				printSize("LogicalSize", c.LogicalSize)
+			}
+
 			return nil
 		}),
 	},
@@ -265,6 +297,19 @@ type VerifyProgress struct {
 var repoVerifyCmd = &oldcmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Verify all blocks in repo are not corrupted.",
+		ShortDescription: `
+'ipfs repo verify' re-hashes every block in the repo and reports the ones
+that don't match their CID.
+
+With --quarantine, corrupt blocks are copied to $IPFS_PATH/quarantine and
+removed from the repo. With --repair, corrupt blocks are removed and
+pinned ones are fetched again from the network. If some corrupt blocks are
+pinned, --repair needs the daemon and leaves the repo untouched without it.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("repair", "Remove corrupt blocks and re-fetch pinned ones from the network."),
+		cmdkit.BoolOption("quarantine", "Move corrupt blocks to $IPFS_PATH/quarantine."),
 	},
 	Run: func(req oldcmds.Request, res oldcmds.Response) {
 		nd, err := req.InvocContext().GetNode()
@@ -273,10 +318,22 @@ var repoVerifyCmd = &oldcmds.Command{
 			return
 		}
 
+		repair, _, _ := req.Option("repair").Bool()
+		quarantine, _, _ := req.Option("quarantine").Bool()
+
 		out := make(chan interface{})
 		res.SetOutput((<-chan interface{})(out))
 		defer close(out)
 
+		emit := func(v *VerifyProgress) bool {
+			select {
+			case out <- v:
+				return true
+			case <-req.Context().Done():
+				return false
+			}
+		}
+
 		bs := bstore.NewBlockstore(nd.Repo.Datastore())
 		bs.HashOnRead(true)
 
@@ -286,37 +343,92 @@ var repoVerifyCmd = &oldcmds.Command{
 			return
 		}
 
-		var fails int
+		var corrupt []cid.Cid
 		var i int
 		for k := range keys {
 			_, err := bs.Get(k)
 			if err != nil {
-				select {
-				case out <- &VerifyProgress{
+				if !emit(&VerifyProgress{
 					Msg: fmt.Sprintf("block %s was corrupt (%s)", k, err),
-				}:
-				case <-req.Context().Done():
+				}) {
 					return
 				}
-				fails++
+				corrupt = append(corrupt, k)
 			}
 			i++
-			select {
-			case out <- &VerifyProgress{Progress: i}:
-			case <-req.Context().Done():
+			if !emit(&VerifyProgress{Progress: i}) {
 				return
 			}
 		}
 
-		if fails == 0 {
-			select {
-			case out <- &VerifyProgress{Msg: "verify complete, all blocks validated."}:
-			case <-req.Context().Done():
+		if len(corrupt) == 0 {
+			emit(&VerifyProgress{Msg: "verify complete, all blocks validated."})
+			return
+		}
+
+		if !repair && !quarantine {
+			res.SetError(fmt.Errorf("verify complete, some blocks were corrupt"), cmdkit.ErrNormal)
+			return
+		}
+
+		var refetch []cid.Cid
+		if repair {
+			// figure out what is pinned before the blocks disappear
+			refetch, err = pinnedKeys(nd, corrupt)
+			if err != nil {
+				log.Warningf("could not determine pin state of corrupt blocks, re-fetching all of them: %s", err)
+				refetch = corrupt
+			}
+		}
+
+		// refuse before deleting anything, otherwise the rerun with the
+		// daemon finds no corrupt blocks and the pinned data is lost
+		if len(refetch) > 0 && !nd.OnlineMode() {
+			res.SetError(fmt.Errorf("%d corrupt blocks are pinned, run the daemon and 'ipfs repo verify --repair' to re-fetch them", len(refetch)), cmdkit.ErrNormal)
+			return
+		}
+
+		for _, k := range corrupt {
+			if quarantine {
+				p, err := quarantineBlock(req.InvocContext().ConfigRoot, nd.Repo.Datastore(), k)
+				if err != nil {
+					res.SetError(fmt.Errorf("quarantining block %s: %s", k, err), cmdkit.ErrNormal)
+					return
+				}
+				if !emit(&VerifyProgress{Msg: fmt.Sprintf("moved block %s to %s", k, p)}) {
+					return
+				}
+			}
+			if err := nd.Blockstore.DeleteBlock(k); err != nil {
+				res.SetError(fmt.Errorf("removing block %s: %s", k, err), cmdkit.ErrNormal)
+				return
+			}
+			if !quarantine && !emit(&VerifyProgress{Msg: fmt.Sprintf("removed block %s", k)}) {
 				return
 			}
-		} else {
-			res.SetError(fmt.Errorf("verify complete, some blocks were corrupt"), cmdkit.ErrNormal)
 		}
+
+		var failed int
+		for _, k := range refetch {
+			ctx, cancel := context.WithTimeout(req.Context(), refetchTimeout)
+			_, err := nd.Blocks.GetBlock(ctx, k)
+			cancel()
+
+			msg := fmt.Sprintf("re-fetched block %s", k)
+			if err != nil {
+				msg = fmt.Sprintf("failed to re-fetch block %s: %s", k, err)
+				failed++
+			}
+			if !emit(&VerifyProgress{Msg: msg}) {
+				return
+			}
+		}
+
+		if failed > 0 {
+			res.SetError(fmt.Errorf("verify complete, %d pinned blocks could not be repaired", failed), cmdkit.ErrNormal)
+			return
+		}
+		emit(&VerifyProgress{Msg: fmt.Sprintf("verify complete, %d corrupt blocks removed.", len(corrupt))})
 	},
 	Type: &VerifyProgress{},
 	Marshalers: oldcmds.MarshalerMap{
@@ -351,6 +463,44 @@ var repoVerifyCmd = &oldcmds.Command{
 	},
 }
 
+// refetchTimeout bounds how long 'repo verify --repair' waits for each
+// corrupt block to be fetched again.
+const refetchTimeout = time.Minute
+
+// pinnedKeys returns the subset of keys that are pinned, directly or
+// indirectly.
+func pinnedKeys(nd *core.IpfsNode, keys []cid.Cid) ([]cid.Cid, error) {
+	pinned, err := nd.Pinning.CheckIfPinned(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []cid.Cid
+	for _, p := range pinned {
+		if p.Mode != pin.NotPinned {
+			out = append(out, p.Key)
+		}
+	}
+	return out, nil
+}
+
+// quarantineBlock copies the raw contents of a block to the quarantine
+// directory of the repo so the data can be inspected after it is removed.
+func quarantineBlock(root string, d ds.Datastore, k cid.Cid) (string, error) {
+	data, err := d.Get(bstore.BlockPrefix.Child(dshelp.CidToDsKey(k)))
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(root, "quarantine")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(dir, k.String())
+	return p, ioutil.WriteFile(p, data, 0644)
+}
+
 var repoVersionCmd = &oldcmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Show the repo version.",
@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// CompressOutput is emitted by 'ipfs repo compress' for each block
+// rewritten, with the change of its size on disk, and once at the end with
+// the totals.
+type CompressOutput struct {
+	Cid     string `json:",omitempty"`
+	Delta   int
+	Blocks  int  `json:",omitempty"`
+	Summary bool `json:",omitempty"`
+}
+
+var repoCompressCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Compress the blocks stored before the compression was enabled.",
+		ShortDescription: `
+'ipfs repo compress' rewrites the blocks of the compressed datastores of
+Datastore.Spec compressed, as new blocks are. With --decompress, all the
+blocks are rewritten uncompressed, which must be done before removing a
+compress datastore from Datastore.Spec.
+`,
+		LongDescription: `
+'ipfs repo compress' rewrites the blocks of the compressed datastores of
+Datastore.Spec compressed, as new blocks are. With --decompress, all the
+blocks are rewritten uncompressed, which must be done before removing a
+compress datastore from Datastore.Spec.
+
+A line is printed for every block rewritten, with the change of its size on
+disk. The garbage collection can't run while a batch of blocks is
+rewritten, the command can be interrupted and run again at any time.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("decompress", "Rewrite all the blocks uncompressed."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		decompress, _ := req.Options["decompress"].(bool)
+
+		total := &CompressOutput{Summary: true}
+		var emitErr error
+		progress := func(c cid.Cid, delta int) {
+			total.Blocks++
+			total.Delta += delta
+			if emitErr == nil {
+				emitErr = res.Emit(&CompressOutput{Cid: c.String(), Delta: delta})
+			}
+		}
+		err = corerepo.Compress(req.Context, n, decompress, progress)
+		if err == corerepo.ErrNoCompression {
+			return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+		if emitErr != nil {
+			return emitErr
+		}
+		return res.Emit(total)
+	},
+	Type: CompressOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*CompressOutput)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			var err error
+			if out.Summary {
+				_, err = fmt.Fprintf(w, "rewrote %d blocks, %+d bytes\n", out.Blocks, out.Delta)
+			} else {
+				_, err = fmt.Fprintf(w, "%s %+d\n", out.Cid, out.Delta)
+			}
+			return err
+		}),
+	},
+}
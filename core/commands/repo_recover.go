@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	journal "github.com/ipfs/go-ipfs/repo/journal"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// RecoverOutput is emitted by 'ipfs repo recover' with the last recovery of
+// the journal, if any.
+type RecoverOutput struct {
+	Enabled    bool
+	Time       *time.Time `json:",omitempty"`
+	Recoveries []journal.Recovery
+}
+
+var repoRecoverCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show what the journal recovered after a crash.",
+		ShortDescription: `
+'ipfs repo recover' shows what was done the last time the node started after
+not shutting down cleanly, with the journal of the pin set and files API root
+updates enabled:
+
+  ipfs config --json Datastore.Journal true
+
+An update is replayed if the new root can be loaded, and rolled back to the
+previous root otherwise. It is unrecoverable if neither can be loaded.
+`,
+		LongDescription: `
+'ipfs repo recover' shows what was done the last time the node started after
+not shutting down cleanly, with the journal of the pin set and files API root
+updates enabled:
+
+  ipfs config --json Datastore.Journal true
+
+The recovery happens when the node starts, before the pin set and the files
+API root are loaded. An update is replayed if the new root can be loaded from
+the local blocks, and rolled back to the previous root otherwise. It is
+unrecoverable if neither can be loaded, 'ipfs repo repair' may help then.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		report, err := journal.LastReport(n.Repo.Datastore())
+		if err != nil {
+			return err
+		}
+
+		out := &RecoverOutput{
+			Enabled:    n.Journal != nil,
+			Recoveries: []journal.Recovery{},
+		}
+		if report != nil {
+			out.Time = &report.Time
+			out.Recoveries = report.Recoveries
+		}
+		return res.Emit(out)
+	},
+	Type: RecoverOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*RecoverOutput)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			if !out.Enabled {
+				fmt.Fprintln(w, "the journal is disabled, set Datastore.Journal to enable it")
+			}
+			if out.Time == nil {
+				_, err := fmt.Fprintln(w, "nothing to recover")
+				return err
+			}
+
+			fmt.Fprintf(w, "last recovery: %s\n", out.Time.Format(time.RFC3339))
+			for _, r := range out.Recoveries {
+				fmt.Fprintf(w, "%s %s: %s", r.Action, r.Key, r.New)
+				if r.Old != "" {
+					fmt.Fprintf(w, " (previous %s)", r.Old)
+				}
+				fmt.Fprintln(w)
+			}
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// RepairOutput is emitted by 'ipfs repo repair' for each block that was
+// missing or corrupt, and once at the end with the number of blocks checked.
+type RepairOutput struct {
+	Cid     string `json:",omitempty"`
+	Problem string `json:",omitempty"`
+	Source  string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+	Checked int    `json:",omitempty"`
+}
+
+var repoRepairCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Re-fetch the missing or corrupt blocks of DAGs.",
+		ShortDescription: `
+'ipfs repo repair' walks the given DAGs, or all the pinned ones with
+--from-pins, and fetches again the blocks that are missing from the repo or
+don't match their CID, for instance after a partial disk loss. A line is
+printed for every block that needed repair.
+`,
+		LongDescription: `
+'ipfs repo repair' walks the given DAGs, or all the pinned ones with
+--from-pins, and fetches again the blocks that are missing from the repo or
+don't match their CID, for instance after a partial disk loss. A line is
+printed for every block that needed repair.
+
+Blocks are requested from the network if the daemon is running, then from the
+gateways listed in the Repair.Gateways config, which must be able to serve
+raw blocks:
+
+  ipfs config --json Repair.Gateways '["https://ipfs.io"]'
+
+The children of a block that can't be fetched can't be known, running the
+command again once it is available checks them.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("cid", false, true, "CIDs of the DAGs to repair."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("from-pins", "Repair all pinned DAGs."),
+		cmdkit.StringOption("timeout", "Maximum time to spend fetching each block.").WithDefault("1m"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		fromPins, _ := req.Options["from-pins"].(bool)
+		if fromPins == (len(req.Arguments) > 0) {
+			return cmdkit.Errorf(cmdkit.ErrClient, "give either CIDs or --from-pins")
+		}
+
+		timeoutStr, _ := req.Options["timeout"].(string)
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			return cmdkit.Errorf(cmdkit.ErrClient, "invalid timeout: %q", timeoutStr)
+		}
+
+		recursive := make([]cid.Cid, 0, len(req.Arguments))
+		var direct []cid.Cid
+		if fromPins {
+			recursive = n.Pinning.RecursiveKeys()
+			direct = n.Pinning.DirectKeys()
+		}
+		for _, arg := range req.Arguments {
+			c, err := cid.Decode(arg)
+			if err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "invalid CID %q: %s", arg, err)
+			}
+			recursive = append(recursive, c)
+		}
+
+		r, err := corerepo.NewRepairer(n, timeout)
+		if err != nil {
+			return err
+		}
+
+		var failed int
+		report := func(rr corerepo.RepairResult) error {
+			out := &RepairOutput{
+				Cid:     rr.Cid.String(),
+				Problem: rr.Problem,
+				Source:  rr.Source,
+			}
+			if rr.Err != nil {
+				out.Error = rr.Err.Error()
+				failed++
+			}
+			return res.Emit(out)
+		}
+
+		checked, err := r.Repair(req.Context, recursive, true, report)
+		if err != nil {
+			return err
+		}
+		checkedDirect, err := r.Repair(req.Context, direct, false, report)
+		if err != nil {
+			return err
+		}
+
+		if err := res.Emit(&RepairOutput{Checked: checked + checkedDirect}); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d blocks could not be repaired", failed)
+		}
+		return nil
+	},
+	Type: RepairOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*RepairOutput)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			var err error
+			switch {
+			case out.Cid == "":
+				_, err = fmt.Fprintf(w, "checked %d blocks\n", out.Checked)
+			case out.Error != "":
+				_, err = fmt.Fprintf(w, "failed %s (%s): %s\n", out.Cid, out.Problem, out.Error)
+			default:
+				_, err = fmt.Fprintf(w, "repaired %s (%s) from %s\n", out.Cid, out.Problem, out.Source)
+			}
+			return err
+		}),
+	},
+}
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidResumeToken is returned for the resume tokens that are not the
+// ones of the command and of its arguments.
+var ErrInvalidResumeToken = errors.New("invalid resume token, the tokens only resume the listings of the same command with the same arguments")
+
+// resumeToken is the position of an entry of a streamed listing, given to
+// the clients with each entry for them to resume an interrupted listing after
+// it with --resume. Its encoding is opaque to them.
+type resumeToken struct {
+	// Cmd is the command of the listing and Args the hash of its arguments.
+	Cmd  string `json:"c"`
+	Args string `json:"a,omitempty"`
+
+	// Path is the position of the refs in their DAGs: the index of the
+	// object, then of the link at each depth.
+	Path []int `json:"p,omitempty"`
+	// Type is the type of the pins and Key the CID of the entry.
+	Type string `json:"t,omitempty"`
+	Key  string `json:"k,omitempty"`
+	// Count is the number of the entries listed before the next one.
+	Count uint64 `json:"n,omitempty"`
+}
+
+func (t resumeToken) String() string {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseResumeToken parses the token s of the listing of cmd with args.
+func parseResumeToken(s, cmd string, args ...string) (resumeToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return resumeToken{}, ErrInvalidResumeToken
+	}
+	var t resumeToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return resumeToken{}, ErrInvalidResumeToken
+	}
+	if t.Cmd != cmd || t.Args != resumeArgs(args...) {
+		return resumeToken{}, ErrInvalidResumeToken
+	}
+	return t, nil
+}
+
+// resumeArgs hashes the arguments and the options of a listing, for its
+// tokens not to resume another one.
+func resumeArgs(args ...string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(args, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"testing"
+)
+
+func TestResumeTokens(t *testing.T) {
+	tok := resumeToken{Cmd: "refs", Args: resumeArgs("false", "-1", "QmFoo"), Path: []int{0, 3, 1}}.String()
+
+	parsed, err := parseResumeToken(tok, "refs", "false", "-1", "QmFoo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Path) != 3 || parsed.Path[1] != 3 || parsed.Path[2] != 1 {
+		t.Fatalf("expected the path of the token, got %v", parsed.Path)
+	}
+
+	for _, c := range []struct {
+		tok, cmd string
+		args     []string
+	}{
+		{tok, "pin/ls", []string{"false", "-1", "QmFoo"}},
+		{tok, "refs", []string{"true", "-1", "QmFoo"}},
+		{tok, "refs", nil},
+		{"not a token", "refs", []string{"false", "-1", "QmFoo"}},
+		{"bm90IGpzb24", "refs", []string{"false", "-1", "QmFoo"}},
+	} {
+		if _, err := parseResumeToken(c.tok, c.cmd, c.args...); err != ErrInvalidResumeToken {
+			t.Errorf("expected %q to be invalid for %s %v, got %v", c.tok, c.cmd, c.args, err)
+		}
+	}
+}
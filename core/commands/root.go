@@ -12,6 +12,7 @@ import (
 	name "github.com/ipfs/go-ipfs/core/commands/name"
 	ocmd "github.com/ipfs/go-ipfs/core/commands/object"
 	unixfs "github.com/ipfs/go-ipfs/core/commands/unixfs"
+	features "github.com/ipfs/go-ipfs/core/features"
 
 	"gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
 	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
@@ -23,13 +24,14 @@ var log = logging.Logger("core/commands")
 var ErrNotOnline = errors.New("this command must be run in online mode. Try running 'ipfs daemon' first")
 
 const (
-	ApiOption = "api"
+	ApiOption       = "api"
+	EphemeralOption = "ephemeral"
 )
 
 var Root = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline:  "Global p2p merkle-dag filesystem.",
-		Synopsis: "ipfs [--config=<config> | -c] [--debug=<debug> | -D] [--help=<help>] [-h=<h>] [--local=<local> | -L] [--api=<api>] <command> ...",
+		Synopsis: "ipfs [--config=<config> | -c] [--debug=<debug> | -D] [--help=<help>] [-h=<h>] [--local=<local> | -L] [--api=<api>] [--ephemeral] <command> ...",
 		Subcommands: `
 BASIC COMMANDS
   init          Initialize ipfs local configuration
@@ -38,12 +40,13 @@ BASIC COMMANDS
   get <ref>     Download IPFS objects
   ls <ref>      List links from an object
   refs <ref>    List hashes of links from an object
+  search <q>    Search the files added and pinned to the node
 
 DATA STRUCTURE COMMANDS
   block         Interact with raw blocks in the datastore
   object        Interact with raw dag nodes
   files         Interact with objects as if they were a unix filesystem
-  dag           Interact with IPLD documents (experimental)
+  dag           ` + features.Help(features.Dag, "Interact with IPLD documents") + `
 
 ADVANCED COMMANDS
   daemon        Start a long-running daemon process
@@ -56,18 +59,20 @@ ADVANCED COMMANDS
   repo          Manipulate the IPFS repository
   stats         Various operational stats
   p2p           Libp2p stream mounting
-  filestore     Manage the filestore (experimental)
+  filestore     ` + features.Help(features.Filestore, "Manage the filestore") + `
 
 NETWORK COMMANDS
   id            Show info about IPFS peers
   bootstrap     Add or remove bootstrap peers
   swarm         Manage connections to the p2p network
   dht           Query the DHT for values or peers
+  provide       Manage the announcements of the local content
   ping          Measure the latency of a connection
   diag          Print diagnostics
 
 TOOL COMMANDS
   config        Manage configuration
+  features      Manage experimental and deprecated features
   version       Show ipfs version information
   update        Download and apply go-ipfs updates
   commands      List all available commands
@@ -80,6 +85,12 @@ environment variable:
 
   export IPFS_PATH=/path/to/ipfsrepo
 
+With --ephemeral, the command runs on a temporary node with a new identity
+and an in-memory repo, dropped once it returns, without using the repo or
+the daemon:
+
+  ipfs --ephemeral cat /ipfs/<cid>
+
 EXIT STATUS
 
 The CLI will exit with one of the following values:
@@ -95,6 +106,7 @@ The CLI will exit with one of the following values:
 		cmdkit.BoolOption("h", "Show a short version of the command help text."),
 		cmdkit.BoolOption("local", "L", "Run the command locally, instead of using the daemon."),
 		cmdkit.StringOption(ApiOption, "Use a specific API instance (defaults to /ip4/127.0.0.1/tcp/5001)"),
+		cmdkit.BoolOption(EphemeralOption, "Run the command on a temporary in-memory node, without using the repo."),
 
 		// global options, added to every command
 		cmds.OptionEncodingType,
@@ -124,6 +136,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"dht":       lgc.NewCommand(DhtCmd),
 	"diag":      lgc.NewCommand(DiagCmd),
 	"dns":       lgc.NewCommand(DNSCmd),
+	"features":  FeaturesCmd,
 	"id":        lgc.NewCommand(IDCmd),
 	"key":       KeyCmd,
 	"log":       lgc.NewCommand(LogCmd),
@@ -134,12 +147,16 @@ var rootSubcommands = map[string]*cmds.Command{
 	"pin":       lgc.NewCommand(PinCmd),
 	"ping":      lgc.NewCommand(PingCmd),
 	"p2p":       lgc.NewCommand(P2PCmd),
+	"provide":   ProvideCmd,
 	"refs":      lgc.NewCommand(RefsCmd),
 	"resolve":   ResolveCmd,
+	"routing":   RoutingCmd,
+	"search":    SearchCmd,
 	"swarm":     lgc.NewCommand(SwarmCmd),
 	"tar":       lgc.NewCommand(TarCmd),
 	"file":      lgc.NewCommand(unixfs.UnixFSCmd),
 	"update":    lgc.NewCommand(ExternalBinary()),
+	"upload":    UploadCmd,
 	"urlstore":  urlStoreCmd,
 	"version":   lgc.NewCommand(VersionCmd),
 	"shutdown":  daemonShutdownCmd,
@@ -181,6 +198,7 @@ var rootROSubcommands = map[string]*cmds.Command{
 		Subcommands: map[string]*oldcmds.Command{
 			"get":     dag.DagGetCmd,
 			"resolve": dag.DagResolveCmd,
+			"stat":    dag.DagStatCmd,
 		},
 	}),
 	"resolve": ResolveCmd,
@@ -0,0 +1,276 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	staticrouting "github.com/ipfs/go-ipfs/p2p/staticrouting"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+)
+
+const staticRoutingPeersKey = core.StaticRoutingKey + ".Peers"
+
+type staticRoutingPeer struct {
+	Address   string
+	ID        string
+	Connected bool
+}
+
+type staticRoutingPeers struct {
+	Peers []staticRoutingPeer
+}
+
+var RoutingCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the routing system.",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"static": routingStaticCmd,
+	},
+}
+
+var routingStaticCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the peers of the static routing.",
+		ShortDescription: `
+With Routing.Type set to "static", the node routes only through a fixed set of
+peers: the providers and the IPNS records are published to all of them and
+looked up on all of them. No DHT is used and the public bootstrap peers are
+never dialed, which suits fully private deployments.
+
+The static peers are stored in Routing.Static.Peers. The changes apply to a
+running daemon routing statically right away.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add": routingStaticAddCmd,
+		"ls":  routingStaticLsCmd,
+		"rm":  routingStaticRmCmd,
+	},
+}
+
+var routingStaticAddCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Add static routing peers.",
+		ShortDescription: `
+'ipfs routing static add' adds peers to the static routing, given by their
+multiaddrs ending with /ipfs/<peer id>:
+
+  ipfs routing static add /ip4/10.0.0.2/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("address", true, true, "Address of the peer to route through.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := staticRoutingConfig(n.Repo)
+		if err != nil {
+			return err
+		}
+
+		added := []string{}
+		for _, s := range req.Arguments {
+			pi, err := staticrouting.ParsePeer(s)
+			if err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, err.Error())
+			}
+			if len(pi.Addrs) == 0 {
+				return cmdkit.Errorf(cmdkit.ErrClient, "static peer %q has no address", s)
+			}
+			if containsString(cfg.Peers, s) {
+				continue
+			}
+			cfg.Peers = append(cfg.Peers, s)
+			added = append(added, s)
+
+			if n.StaticRouting != nil {
+				n.StaticRouting.AddPeer(pi)
+			}
+		}
+
+		if err := n.Repo.SetConfigKey(staticRoutingPeersKey, cfg.Peers); err != nil {
+			return err
+		}
+		if n.StaticRouting != nil {
+			n.StaticRouting.Bootstrap(req.Context)
+		}
+		return cmds.EmitOnce(res, &stringList{added})
+	},
+	Type: stringList{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: staticRoutingListEncoder("added"),
+	},
+}
+
+var routingStaticLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the static routing peers.",
+		ShortDescription: `
+'ipfs routing static ls' lists the peers of the static routing, and whether
+they are currently connected.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := staticRoutingConfig(n.Repo)
+		if err != nil {
+			return err
+		}
+
+		out := &staticRoutingPeers{Peers: []staticRoutingPeer{}}
+		for _, s := range cfg.Peers {
+			p := staticRoutingPeer{Address: s}
+			if pi, err := staticrouting.ParsePeer(s); err == nil {
+				p.ID = pi.ID.Pretty()
+				p.Connected = n.PeerHost != nil && n.PeerHost.Network().Connectedness(pi.ID) == inet.Connected
+			}
+			out.Peers = append(out.Peers, p)
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: staticRoutingPeers{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*staticRoutingPeers)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			for _, p := range out.Peers {
+				state := "disconnected"
+				if p.Connected {
+					state = "connected"
+				}
+				fmt.Fprintf(w, "%s %s\n", p.Address, state)
+			}
+			return nil
+		}),
+	},
+}
+
+var routingStaticRmCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Remove static routing peers.",
+		ShortDescription: `
+'ipfs routing static rm' removes peers from the static routing, given by
+their peer ID or their address. The current connections are left open.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("peer", false, true, "ID or address of the peer to remove.").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("all", "Remove all the static routing peers."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		all, _ := req.Options["all"].(bool)
+		if all == (len(req.Arguments) > 0) {
+			return cmdkit.Errorf(cmdkit.ErrClient, "either peers or --all must be given")
+		}
+
+		var ids []peer.ID
+		for _, s := range req.Arguments {
+			id, err := peer.IDB58Decode(strings.TrimPrefix(s, "/ipfs/"))
+			if err != nil {
+				pi, err := staticrouting.ParsePeer(s)
+				if err != nil {
+					return cmdkit.Errorf(cmdkit.ErrClient, "invalid peer %q", s)
+				}
+				id = pi.ID
+			}
+			ids = append(ids, id)
+		}
+
+		cfg, err := staticRoutingConfig(n.Repo)
+		if err != nil {
+			return err
+		}
+
+		removed := []string{}
+		kept := []string{}
+		for _, s := range cfg.Peers {
+			pi, err := staticrouting.ParsePeer(s)
+			if all || (err == nil && containsPeer(ids, pi.ID)) {
+				removed = append(removed, s)
+				if err == nil && n.StaticRouting != nil {
+					n.StaticRouting.RemovePeer(pi.ID)
+				}
+				continue
+			}
+			kept = append(kept, s)
+		}
+		if !all && len(removed) == 0 {
+			return fmt.Errorf("no static routing peer matches %s", strings.Join(req.Arguments, ", "))
+		}
+
+		if err := n.Repo.SetConfigKey(staticRoutingPeersKey, kept); err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &stringList{removed})
+	},
+	Type: stringList{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: staticRoutingListEncoder("removed"),
+	},
+}
+
+func staticRoutingConfig(r repo.Repo) (staticrouting.Config, error) {
+	var cfg staticrouting.Config
+	err := repo.ConfigSection(r, core.StaticRoutingKey, &cfg)
+	return cfg, err
+}
+
+func staticRoutingListEncoder(verb string) cmds.EncoderFunc {
+	return cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+		list, ok := v.(*stringList)
+		if !ok {
+			return e.TypeErr(list, v)
+		}
+
+		for _, s := range list.Strings {
+			fmt.Fprintf(w, "%s %s\n", verb, s)
+		}
+		return nil
+	})
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPeer(ids []peer.ID, id peer.ID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// ErrSearchDisabled is returned by 'ipfs search' when the index is disabled.
+var ErrSearchDisabled = errors.New("the search index is disabled, enable it with 'ipfs config --json Search.Enabled true'")
+
+// SearchResult is a file or directory matching the query of 'ipfs search'.
+type SearchResult struct {
+	Cid  string
+	Path string
+}
+
+var SearchCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Search the files added and pinned to the node.",
+		ShortDescription: `
+Searches the local index of the names of the files added and pinned to the
+node, and of the words of their text content with Search.Content, returning
+the CIDs and the paths of the files matching all the terms of the query.
+`,
+		LongDescription: `
+Searches the local index of the names of the files added and pinned to the
+node, and of the words of their text content with Search.Content, returning
+the CIDs and the paths of the files matching all the terms of the query. A
+term ending with * matches the words starting with it:
+
+  > ipfs search 'annual report*'
+  QmX...  /ipfs/QmY.../2018/annual-reports.pdf
+
+The index is opt-in, enabled with the Search.Enabled config, and only has the
+files added and pinned since. They are indexed in the background, from the
+blocks stored locally. The files removed by a gc are left out of the results.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("query", true, true, "The terms to search."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.IntOption("limit", "n", "The maximum number of results, 0 for all of them.").WithDefault(100),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if n.Search == nil {
+			return ErrSearchDisabled
+		}
+
+		limit, _ := req.Options["limit"].(int)
+		if limit < 0 {
+			return fmt.Errorf("invalid limit %d", limit)
+		}
+
+		results, err := n.Search.Search(req.Context, strings.Join(req.Arguments, " "), limit)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if err := res.Emit(&SearchResult{Cid: r.Cid.String(), Path: r.Path}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: SearchResult{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			r, ok := v.(*SearchResult)
+			if !ok {
+				return e.TypeErr(r, v)
+			}
+			_, err := fmt.Fprintf(w, "%s %s\n", r.Cid, r.Path)
+			return err
+		}),
+	},
+}
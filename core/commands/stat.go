@@ -27,9 +27,14 @@ for your IPFS node.`,
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"bw":      statBwCmd,
-		"repo":    repoStatCmd,
-		"bitswap": bitswapStatCmd,
+		"bw":           statBwCmd,
+		"repo":         repoStatCmd,
+		"bitswap":      bitswapStatCmd,
+		"dcutr":        statDcutrCmd,
+		"dht":          statDhtCmd,
+		"pex":          statPexCmd,
+		"availability": statAvailabilityCmd,
+		"serve":        statServeCmd,
 	},
 }
 
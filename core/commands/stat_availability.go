@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	availability "github.com/ipfs/go-ipfs/exchange/availability"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+const (
+	defaultAvailabilityInterval = time.Hour
+	defaultAvailabilityDuration = 24 * time.Hour
+)
+
+type availabilitySample struct {
+	Time      time.Time
+	Providers int
+	Reachable int
+	Available bool
+	Error     string `json:",omitempty"`
+}
+
+type availabilitySeries struct {
+	Cid      string
+	Interval string
+	Duration string
+	Started  time.Time
+	Ends     time.Time
+	Probing  bool
+	Samples  []availabilitySample
+}
+
+var statAvailabilityCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Probe and show the availability of content on the network.",
+		ShortDescription: `
+'ipfs stats availability' probes periodically whether content can be found on
+the network without this node: each probe looks up the providers of the CID
+other than this node, and connects to them. The content is available when one
+provider at least is reachable. The series of probes is recorded in the repo,
+and resumed when the daemon restarts, so publishers can check their content
+stays reachable after they go offline.
+
+Passing --interval or --duration starts a new series, replacing the recorded
+one:
+
+  ipfs stats availability <cid> --interval=1h --duration=24h
+
+Without them, the recorded series is shown.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("cid", true, false, "CID of the content to probe."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("interval", "i", "Time between two probes, one minute at least. Default: 1h."),
+		cmdkit.StringOption("duration", "d", "Time to probe for. Default: 24h."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.OnlineMode() || nd.Availability == nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, ErrNotOnline.Error())
+		}
+
+		c, err := cid.Decode(strings.TrimPrefix(req.Arguments[0], "/ipfs/"))
+		if err != nil {
+			return cmdkit.Errorf(cmdkit.ErrClient, "invalid CID: %s", err)
+		}
+
+		intervalS, setInterval := req.Options["interval"].(string)
+		durationS, setDuration := req.Options["duration"].(string)
+
+		var s *availability.Series
+		if setInterval || setDuration {
+			interval := defaultAvailabilityInterval
+			if setInterval {
+				if interval, err = time.ParseDuration(intervalS); err != nil {
+					return cmdkit.Errorf(cmdkit.ErrClient, "invalid interval: %s", err)
+				}
+			}
+			duration := defaultAvailabilityDuration
+			if setDuration {
+				if duration, err = time.ParseDuration(durationS); err != nil {
+					return cmdkit.Errorf(cmdkit.ErrClient, "invalid duration: %s", err)
+				}
+			}
+
+			s, err = nd.Availability.Probe(c, interval, duration)
+		} else {
+			s, err = nd.Availability.Series(c)
+		}
+		if err != nil {
+			return err
+		}
+
+		out := &availabilitySeries{
+			Cid:      s.Cid.String(),
+			Interval: s.Interval.String(),
+			Duration: s.Duration.String(),
+			Started:  s.Started,
+			Ends:     s.Ends(),
+			Probing:  nd.Availability.Probing(c),
+			Samples:  make([]availabilitySample, len(s.Samples)),
+		}
+		for i, sa := range s.Samples {
+			out.Samples[i] = availabilitySample{
+				Time:      sa.Time,
+				Providers: sa.Providers,
+				Reachable: sa.Reachable,
+				Available: sa.Available(),
+				Error:     sa.Error,
+			}
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: availabilitySeries{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*availabilitySeries)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			state := "done"
+			if out.Probing {
+				state = "probing"
+			}
+			fmt.Fprintf(w, "%s every %s for %s, until %s: %s\n", out.Cid, out.Interval, out.Duration, out.Ends.Format(time.RFC3339), state)
+
+			available := 0
+			for _, sa := range out.Samples {
+				status := "available"
+				if !sa.Available {
+					status = "unavailable"
+				} else {
+					available++
+				}
+				fmt.Fprintf(w, "  %s %s, %d providers, %d reachable", sa.Time.Format(time.RFC3339), status, sa.Providers, sa.Reachable)
+				if sa.Error != "" {
+					fmt.Fprintf(w, ": %s", sa.Error)
+				}
+				fmt.Fprintln(w)
+			}
+			if len(out.Samples) > 0 {
+				fmt.Fprintf(w, "Available: %d/%d probes\n", available, len(out.Samples))
+			}
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+type holePunch struct {
+	Peer      string
+	Initiator bool
+	Success   bool
+	Attempts  int
+	RTT       string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+	Time      time.Time
+}
+
+type holePunchStats struct {
+	Successes int
+	Failures  int
+	Active    int
+	Recent    []holePunch
+}
+
+var statDcutrCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the hole punching statistics.",
+		ShortDescription: `
+'ipfs stats dcutr' shows the hole punches tried since the daemon started, to
+upgrade the connections relayed between two peers behind NATs to direct
+connections. It lists the number of successful and failed hole punches, and
+the latest ones.
+
+Hole punching is enabled with Swarm.EnableHolePunching.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.OnlineMode() {
+			return cmdkit.Errorf(cmdkit.ErrClient, ErrNotOnline.Error())
+		}
+		if nd.HolePunch == nil {
+			return fmt.Errorf("hole punching disabled in config")
+		}
+
+		st := nd.HolePunch.Stats()
+		out := &holePunchStats{
+			Successes: st.Successes,
+			Failures:  st.Failures,
+			Active:    st.Active,
+			Recent:    make([]holePunch, len(st.Recent)),
+		}
+		for i, pu := range st.Recent {
+			out.Recent[i] = holePunch{
+				Peer:      pu.Peer.Pretty(),
+				Initiator: pu.Initiator,
+				Success:   pu.Success,
+				Attempts:  pu.Attempts,
+				Error:     pu.Error,
+				Time:      pu.Time,
+			}
+			if pu.RTT > 0 {
+				out.Recent[i].RTT = pu.RTT.String()
+			}
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: holePunchStats{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*holePunchStats)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			fmt.Fprintln(w, "Hole punching")
+			fmt.Fprintf(w, "Successes: %d\n", out.Successes)
+			fmt.Fprintf(w, "Failures: %d\n", out.Failures)
+			fmt.Fprintf(w, "Active: %d\n", out.Active)
+			for _, pu := range out.Recent {
+				status := "ok"
+				if !pu.Success {
+					status = "failed: " + pu.Error
+				}
+				role := "responder"
+				if pu.Initiator {
+					role = "initiator"
+				}
+				fmt.Fprintf(w, "  %s %s %s, %d attempts", pu.Time.Format(time.RFC3339), pu.Peer, role, pu.Attempts)
+				if pu.RTT != "" {
+					fmt.Fprintf(w, ", rtt %s", pu.RTT)
+				}
+				fmt.Fprintf(w, ": %s\n", status)
+			}
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+type dhtStats struct {
+	Mode        DhtModeOutput
+	Accelerated *acceleratedDHTStats `json:",omitempty"`
+}
+
+type acceleratedDHTStats struct {
+	Peers         int
+	LastCrawl     time.Time `json:",omitempty"`
+	CrawlDuration string    `json:",omitempty"`
+}
+
+var statDhtCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the state of the DHT.",
+		ShortDescription: `
+'ipfs stats dht' shows the mode of the DHT, see 'ipfs dht mode', and the table
+of the accelerated DHT client when the accelerated-dht-client feature is
+enabled: the number of peers found by its last crawl of the DHT, and when it
+completed. Until the first crawl completed, the requests go through the DHT.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.OnlineMode() {
+			return cmdkit.Errorf(cmdkit.ErrClient, ErrNotOnline.Error())
+		}
+		mode, ok := nd.DHTMode()
+		if !ok {
+			return ErrNotDHT
+		}
+
+		out := &dhtStats{Mode: DhtModeOutput{Mode: "client", Auto: mode.Auto}}
+		if mode.Server {
+			out.Mode.Mode = "server"
+		}
+		if nd.FullRT != nil {
+			st := nd.FullRT.Stats()
+			out.Accelerated = &acceleratedDHTStats{Peers: st.Peers, LastCrawl: st.LastCrawl}
+			if !st.LastCrawl.IsZero() {
+				out.Accelerated.CrawlDuration = st.CrawlDuration.String()
+			}
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: dhtStats{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*dhtStats)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			mode := out.Mode.Mode
+			if out.Mode.Auto {
+				mode += " (auto)"
+			}
+			fmt.Fprintln(w, "DHT")
+			fmt.Fprintf(w, "Mode: %s\n", mode)
+			switch {
+			case out.Accelerated == nil:
+				fmt.Fprintln(w, "Accelerated client: disabled")
+			case out.Accelerated.LastCrawl.IsZero():
+				fmt.Fprintln(w, "Accelerated client: waiting for the first crawl")
+			default:
+				fmt.Fprintf(w, "Accelerated client: %d peers, crawled at %s in %s\n",
+					out.Accelerated.Peers, out.Accelerated.LastCrawl.Format(time.RFC3339), out.Accelerated.CrawlDuration)
+			}
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	pex "github.com/ipfs/go-ipfs/p2p/pex"
+
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+var statPexCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the peer exchange statistics.",
+		ShortDescription: `
+'ipfs stats pex' shows the peer exchanges since the daemon started: the
+samples of peers asked to the new peers and sent to them, the peers received
+and the connections made to them.
+
+The peer exchange is enabled with Swarm.PeerExchange.Enabled.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.OnlineMode() {
+			return cmdkit.Errorf(cmdkit.ErrClient, ErrNotOnline.Error())
+		}
+		if nd.PeerExchange == nil {
+			return fmt.Errorf("peer exchange disabled in config")
+		}
+
+		st := nd.PeerExchange.Stats()
+		return cmds.EmitOnce(res, &st)
+	},
+	Type: pex.Stats{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			st, ok := v.(*pex.Stats)
+			if !ok {
+				return e.TypeErr(st, v)
+			}
+
+			fmt.Fprintln(w, "Peer exchange")
+			fmt.Fprintf(w, "Exchanges: %d\n", st.Exchanges)
+			fmt.Fprintf(w, "Failures: %d\n", st.Failures)
+			fmt.Fprintf(w, "Served: %d\n", st.Served)
+			fmt.Fprintf(w, "Refused: %d\n", st.Refused)
+			fmt.Fprintf(w, "PeersReceived: %d\n", st.PeersReceived)
+			fmt.Fprintf(w, "PeersIgnored: %d\n", st.PeersIgnored)
+			fmt.Fprintf(w, "Dials: %d\n", st.Dials)
+			fmt.Fprintf(w, "DialFailures: %d\n", st.DialFailures)
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	servestats "github.com/ipfs/go-ipfs/exchange/servestats"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	bserv "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
+	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
+)
+
+// ServeStat is the output of 'ipfs stats serve'.
+type ServeStat struct {
+	Since  time.Time
+	Blocks uint64
+	Bytes  uint64
+	// Pins and Unpinned are set with --by-pin.
+	Pins     []ServePinStat    `json:",omitempty"`
+	Unpinned *servestats.Count `json:",omitempty"`
+}
+
+// ServePinStat is the data served of the DAG of a pin.
+type ServePinStat struct {
+	Cid    string
+	Type   string
+	Blocks uint64
+	Bytes  uint64
+}
+
+var statServeCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the data served to the other peers.",
+		ShortDescription: `
+'ipfs stats serve' shows the blocks served to the other peers with bitswap
+since the daemon started.
+
+With --by-pin, the data served is attributed to the pins whose DAG has the
+blocks, the pins with the most bytes served first. A block of several pins
+counts for each of them, and the data of no pin is shown apart. Up to a
+million distinct blocks are attributed, the following ones only counting in
+the total. The DAGs of the recursive pins are walked for each call.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("by-pin", "Attribute the data served to the pins."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.OnlineMode() {
+			return cmdkit.Errorf(cmdkit.ErrClient, ErrNotOnline.Error())
+		}
+		if nd.ServeStats == nil {
+			return fmt.Errorf("the served blocks aren't counted")
+		}
+
+		total := nd.ServeStats.Total()
+		out := &ServeStat{
+			Since:  nd.ServeStats.Since(),
+			Blocks: total.Blocks,
+			Bytes:  total.Bytes,
+		}
+
+		if byPin, _ := req.Options["by-pin"].(bool); byPin {
+			bs := nd.Blocks.Blockstore()
+			ng := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+			pins, unpinned, err := nd.ServeStats.ByPin(req.Context, nd.Pinning, ng)
+			if err != nil {
+				return err
+			}
+			out.Pins = make([]ServePinStat, len(pins))
+			for i, p := range pins {
+				out.Pins[i] = ServePinStat{
+					Cid:    p.Cid.String(),
+					Type:   p.Type,
+					Blocks: p.Blocks,
+					Bytes:  p.Bytes,
+				}
+			}
+			out.Unpinned = &unpinned
+		}
+
+		return cmds.EmitOnce(res, out)
+	},
+	Type: ServeStat{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			st, ok := v.(*ServeStat)
+			if !ok {
+				return e.TypeErr(st, v)
+			}
+
+			fmt.Fprintf(w, "Served since %s\n", st.Since.Format(time.RFC3339))
+			fmt.Fprintf(w, "Blocks: %d\n", st.Blocks)
+			fmt.Fprintf(w, "Data: %s\n", humanize.Bytes(st.Bytes))
+			if st.Unpinned == nil {
+				return nil
+			}
+
+			fmt.Fprintln(w)
+			tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "PIN\tTYPE\tBLOCKS\tDATA")
+			for _, p := range st.Pins {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", p.Cid, p.Type, p.Blocks, humanize.Bytes(p.Bytes))
+			}
+			fmt.Fprintf(tw, "unpinned\t\t%d\t%s\n", st.Unpinned.Blocks, humanize.Bytes(st.Unpinned.Bytes))
+			return tw.Flush()
+		}),
+	},
+}
@@ -2,22 +2,22 @@ package commands
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
-	repo "github.com/ipfs/go-ipfs/repo"
-	"github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
 	mafilter "gx/ipfs/QmSMZwvs3n4GBikZ7hKzT17c3bk65FmyZo2JqtJ16swqCv/multiaddr-filter"
 	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
-	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
 	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
 	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
 	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
@@ -44,10 +44,20 @@ ipfs peers in the internet.
 	},
 	Subcommands: map[string]*cmds.Command{
 		"addrs":      swarmAddrsCmd,
+		"backoff":    swarmBackoffCmd,
 		"connect":    swarmConnectCmd,
 		"disconnect": swarmDisconnectCmd,
+		"discovery":  swarmDiscoveryCmd,
 		"filters":    swarmFiltersCmd,
+		"key":        swarmKeyCmd,
+		"limit":      swarmLimitCmd,
+		"peering":    swarmPeeringCmd,
 		"peers":      swarmPeersCmd,
+		"qos":        swarmQoSCmd,
+		"relay":      swarmRelayCmd,
+		"rendezvous": swarmRendezvousCmd,
+		"reputation": swarmReputationCmd,
+		"stats":      swarmStatsCmd,
 	},
 }
 
@@ -56,6 +66,15 @@ var swarmPeersCmd = &cmds.Command{
 		Tagline: "List peers with open connections.",
 		ShortDescription: `
 'ipfs swarm peers' lists the set of peers this node is connected to.
+
+With --transport, each connection is followed by its network transport, the
+security protocol and the stream muxer negotiated, and 'relayed' when it goes
+through a relay, to check the Swarm.Transports config is used:
+
+  /ip4/1.2.3.4/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ TCP /secio/1.0.0 /yamux/1.0.0
+
+The muxer is left out when it can't be told apart from the muxer of another
+connection to the peer.
 `,
 	},
 	Options: []cmdkit.Option{
@@ -63,6 +82,7 @@ var swarmPeersCmd = &cmds.Command{
 		cmdkit.BoolOption("streams", "Also list information about open streams for each peer"),
 		cmdkit.BoolOption("latency", "Also list information about latency to each peer"),
 		cmdkit.BoolOption("direction", "Also list information about the direction of connection"),
+		cmdkit.BoolOption("transport", "Also list the transport, security and muxer of the connections, and whether they are relayed"),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 
@@ -81,6 +101,7 @@ var swarmPeersCmd = &cmds.Command{
 		latency, _, _ := req.Option("latency").Bool()
 		streams, _, _ := req.Option("streams").Bool()
 		direction, _, _ := req.Option("direction").Bool()
+		transport, _, _ := req.Option("transport").Bool()
 
 		conns := n.PeerHost.Network().Conns()
 		var out connInfos
@@ -92,14 +113,6 @@ var swarmPeersCmd = &cmds.Command{
 				Peer: pid.Pretty(),
 			}
 
-			/*
-				// FIXME(steb):
-							swcon, ok := c.(*swarm.Conn)
-							if ok {
-								ci.Muxer = fmt.Sprintf("%T", swcon.StreamConn().Conn())
-							}
-			*/
-
 			if verbose || direction {
 				// set direction
 				ci.Direction = c.Stat().Direction
@@ -113,6 +126,13 @@ var swarmPeersCmd = &cmds.Command{
 					ci.Latency = lat.String()
 				}
 			}
+			if verbose || transport {
+				st := n.ConnState(c)
+				ci.Transport = st.Transport
+				ci.Security = st.Security
+				ci.Muxer = st.Muxer
+				ci.Relayed = st.Relayed
+			}
 			if verbose || streams {
 				strs := c.GetStreams()
 
@@ -156,6 +176,15 @@ var swarmPeersCmd = &cmds.Command{
 					fmt.Fprintf(buf, " %s", directionString(info.Direction))
 				}
 
+				for _, s := range []string{info.Transport, info.Security, info.Muxer} {
+					if s != "" {
+						fmt.Fprintf(buf, " %s", s)
+					}
+				}
+				if info.Relayed {
+					fmt.Fprint(buf, " relayed")
+				}
+
 				fmt.Fprintln(buf)
 
 				for _, s := range info.Streams {
@@ -181,7 +210,10 @@ type connInfo struct {
 	Addr      string
 	Peer      string
 	Latency   string
+	Transport string
+	Security  string
 	Muxer     string
+	Relayed   bool
 	Direction inet.Direction
 	Streams   []streamInfo
 }
@@ -251,7 +283,7 @@ var swarmAddrsCmd = &cmds.Command{
 		}
 
 		addrs := make(map[string][]string)
-		ps := n.PeerHost.Network().Peerstore()
+		ps := n.PeerHost.Peerstore()
 		for _, p := range ps.Peers() {
 			s := p.Pretty()
 			for _, a := range ps.Addrs(p) {
@@ -387,11 +419,17 @@ var swarmConnectCmd = &cmds.Command{
 The address format is an IPFS multiaddr:
 
 ipfs swarm connect /ip4/104.131.131.82/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+
+The dial backoff of the peers is cleared first, see 'ipfs swarm backoff'. With
+--timeout, each connection attempt gives up after the given duration.
 `,
 	},
 	Arguments: []cmdkit.Argument{
 		cmdkit.StringArg("address", true, true, "Address of peer to connect to.").EnableStdin(),
 	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("timeout", "Maximum time to spend connecting to each peer, like 30s."),
+	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		ctx := req.Context()
 
@@ -401,6 +439,15 @@ ipfs swarm connect /ip4/104.131.131.82/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3
 			return
 		}
 
+		var timeout time.Duration
+		if s, found, _ := req.Option("timeout").String(); found {
+			timeout, err = time.ParseDuration(s)
+			if err != nil || timeout <= 0 {
+				res.SetError(fmt.Errorf("invalid timeout: %q", s), cmdkit.ErrClient)
+				return
+			}
+		}
+
 		addrs := req.Arguments()
 
 		if n.PeerHost == nil {
@@ -427,8 +474,17 @@ ipfs swarm connect /ip4/104.131.131.82/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3
 
 			output[i] = "connect " + pi.ID.Pretty()
 
-			err := n.PeerHost.Connect(ctx, pi)
+			cctx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				cctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			err := n.PeerHost.Connect(cctx, pi)
 			if err != nil {
+				if cctx.Err() == context.DeadlineExceeded {
+					err = fmt.Errorf("timed out after %s", timeout)
+				}
 				res.SetError(fmt.Errorf("%s failure: %s", output[i], err), cmdkit.ErrNormal)
 				return
 			}
@@ -601,42 +657,61 @@ Where the above is equivalent to the standard CIDR:
 
     192.168.0.0/16
 
-Filters default to those specified under the "Swarm.AddrFilters" config key.
+Filters default to those specified under the "Swarm.AddrFilters" config key,
+and the changes made with the subcommands are saved there.
+
+By default the node doesn't connect to the addresses matching the filters.
+With --default-deny=true the filters are the addresses allowed instead, and
+the node doesn't connect to any other IP address. The mode is saved under the
+"Swarm.AddrFiltersDefaultDeny" config key, and can only be switched while
+there are no filters.
 `,
 	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("default-deny", "Only allow the addresses matching the filters."),
+	},
 	Subcommands: map[string]*cmds.Command{
 		"add": swarmFiltersAddCmd,
 		"rm":  swarmFiltersRmCmd,
+		"ls":  swarmFiltersLsCmd,
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
-		n, err := req.InvocContext().GetNode()
+		n, swrm, err := filtersNode(req)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
-		if n.PeerHost == nil {
-			res.SetError(ErrNotOnline, cmdkit.ErrNormal)
-			return
-		}
-
-		// FIXME(steb)
-		swrm, ok := n.PeerHost.Network().(*swarm.Swarm)
-		if !ok {
-			res.SetError(errors.New("failed to cast network to swarm network"), cmdkit.ErrNormal)
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
-		var output []string
-		for _, f := range swrm.Filters.Filters() {
-			s, err := mafilter.ConvertIPNet(f)
+		deny, found, _ := req.Option("default-deny").Bool()
+		if found {
+			current, err := core.AddrFiltersDefaultDeny(n.Repo)
 			if err != nil {
 				res.SetError(err, cmdkit.ErrNormal)
 				return
 			}
-			output = append(output, s)
+			if deny != current {
+				if len(cfg.Swarm.AddrFilters) > 0 {
+					res.SetError(errors.New("cannot switch the filtering mode while there are filters, remove them first with 'ipfs swarm filters rm all'"), cmdkit.ErrClient)
+					return
+				}
+				if err := n.Repo.SetConfigKey(core.AddrFiltersDefaultDenyKey, deny); err != nil {
+					res.SetError(err, cmdkit.ErrNormal)
+					return
+				}
+				if err := applyFilters(swrm, nil, deny); err != nil {
+					res.SetError(err, cmdkit.ErrNormal)
+					return
+				}
+			}
 		}
-		res.SetOutput(&stringList{output})
+
+		res.SetOutput(&stringList{cfg.Swarm.AddrFilters})
 	},
 	Marshalers: cmds.MarshalerMap{
 		cmds.Text: stringListMarshaler,
@@ -644,69 +719,76 @@ Filters default to those specified under the "Swarm.AddrFilters" config key.
 	Type: stringList{},
 }
 
-var swarmFiltersAddCmd = &cmds.Command{
+var swarmFiltersLsCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
-		Tagline: "Add an address filter.",
+		Tagline: "List address filters.",
 		ShortDescription: `
-'ipfs swarm filters add' will add an address filter to the daemons swarm.
-Filters applied this way will not persist daemon reboots, to achieve that,
-add your filters to the ipfs config file.
+'ipfs swarm filters ls' lists the address filters applied to the swarm, the
+ones denied, or with Swarm.AddrFiltersDefaultDeny the ones allowed.
 `,
 	},
-	Arguments: []cmdkit.Argument{
-		cmdkit.StringArg("address", true, true, "Multiaddr to filter.").EnableStdin(),
-	},
 	Run: func(req cmds.Request, res cmds.Response) {
-		n, err := req.InvocContext().GetNode()
+		n, _, err := filtersNode(req)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
-		if n.PeerHost == nil {
-			res.SetError(ErrNotOnline, cmdkit.ErrNormal)
-			return
-		}
-
-		// FIXME(steb)
-		swrm, ok := n.PeerHost.Network().(*swarm.Swarm)
-		if !ok {
-			res.SetError(errors.New("failed to cast network to swarm network"), cmdkit.ErrNormal)
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
-		if len(req.Arguments()) == 0 {
-			res.SetError(errors.New("no filters to add"), cmdkit.ErrClient)
-			return
-		}
+		res.SetOutput(&stringList{cfg.Swarm.AddrFilters})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: stringListMarshaler,
+	},
+	Type: stringList{},
+}
 
-		r, err := fsrepo.Open(req.InvocContext().ConfigRoot)
+var swarmFiltersAddCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Add an address filter.",
+		ShortDescription: `
+'ipfs swarm filters add' will add an address filter to the daemons swarm, and
+save it under the "Swarm.AddrFilters" config key so that it persists daemon
+reboots.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("address", true, true, "Multiaddr to filter.").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, swrm, err := filtersNode(req)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
-		defer r.Close()
-		cfg, err := r.Config()
-		if err != nil {
-			res.SetError(err, cmdkit.ErrNormal)
+
+		if len(req.Arguments()) == 0 {
+			res.SetError(errors.New("no filters to add"), cmdkit.ErrClient)
 			return
 		}
 
 		for _, arg := range req.Arguments() {
-			mask, err := mafilter.NewMask(arg)
-			if err != nil {
-				res.SetError(err, cmdkit.ErrNormal)
+			if _, err := mafilter.NewMask(arg); err != nil {
+				res.SetError(err, cmdkit.ErrClient)
 				return
 			}
-
-			swrm.Filters.AddDialFilter(mask)
 		}
 
-		added, err := filtersAdd(r, cfg, req.Arguments())
+		cfg, err := n.Repo.Config()
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
+		}
 
+		filters, added := filtersAdd(cfg.Swarm.AddrFilters, req.Arguments())
+		if err := setFilters(n, swrm, filters); err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
 		}
 
 		res.SetOutput(&stringList{added})
@@ -721,73 +803,35 @@ var swarmFiltersRmCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Remove an address filter.",
 		ShortDescription: `
-'ipfs swarm filters rm' will remove an address filter from the daemons swarm.
-Filters removed this way will not persist daemon reboots, to achieve that,
-remove your filters from the ipfs config file.
+'ipfs swarm filters rm' will remove an address filter from the daemons swarm,
+and from the "Swarm.AddrFilters" config key so that it stays removed after a
+daemon reboot. 'ipfs swarm filters rm all' removes all the filters.
 `,
 	},
 	Arguments: []cmdkit.Argument{
 		cmdkit.StringArg("address", true, true, "Multiaddr filter to remove.").EnableStdin(),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
-		n, err := req.InvocContext().GetNode()
+		n, swrm, err := filtersNode(req)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
-		if n.PeerHost == nil {
-			res.SetError(ErrNotOnline, cmdkit.ErrNormal)
-			return
-		}
-
-		swrm, ok := n.PeerHost.Network().(*swarm.Swarm)
-		if !ok {
-			res.SetError(errors.New("failed to cast network to swarm network"), cmdkit.ErrNormal)
-			return
-		}
-
-		r, err := fsrepo.Open(req.InvocContext().ConfigRoot)
-		if err != nil {
-			res.SetError(err, cmdkit.ErrNormal)
-			return
-		}
-		defer r.Close()
-		cfg, err := r.Config()
+		cfg, err := n.Repo.Config()
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
 
+		var filters, removed []string
 		if req.Arguments()[0] == "all" || req.Arguments()[0] == "*" {
-			fs := swrm.Filters.Filters()
-			for _, f := range fs {
-				swrm.Filters.Remove(f)
-			}
-
-			removed, err := filtersRemoveAll(r, cfg)
-			if err != nil {
-				res.SetError(err, cmdkit.ErrNormal)
-				return
-			}
-
-			res.SetOutput(&stringList{removed})
-
-			return
+			removed = cfg.Swarm.AddrFilters
+		} else {
+			filters, removed = filtersRemove(cfg.Swarm.AddrFilters, req.Arguments())
 		}
 
-		for _, arg := range req.Arguments() {
-			mask, err := mafilter.NewMask(arg)
-			if err != nil {
-				res.SetError(err, cmdkit.ErrNormal)
-				return
-			}
-
-			swrm.Filters.Remove(mask)
-		}
-
-		removed, err := filtersRemove(r, cfg, req.Arguments())
-		if err != nil {
+		if err := setFilters(n, swrm, filters); err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
 		}
@@ -800,62 +844,109 @@ remove your filters from the ipfs config file.
 	Type: stringList{},
 }
 
-func filtersAdd(r repo.Repo, cfg *config.Config, filters []string) ([]string, error) {
-	addedMap := map[string]struct{}{}
-	addedList := make([]string, 0, len(filters))
+// filtersNode returns the node and its swarm, which the filters commands need
+// to be online.
+func filtersNode(req cmds.Request) (*core.IpfsNode, *swarm.Swarm, error) {
+	n, err := req.InvocContext().GetNode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if n.PeerHost == nil {
+		return nil, nil, ErrNotOnline
+	}
+
+	// FIXME(steb)
+	swrm, ok := n.PeerHost.Network().(*swarm.Swarm)
+	if !ok {
+		return nil, nil, errors.New("failed to cast network to swarm network")
+	}
+	return n, swrm, nil
+}
+
+// setFilters saves the filters in the config and applies them to the swarm.
+func setFilters(n *core.IpfsNode, swrm *swarm.Swarm, filters []string) error {
+	deny, err := core.AddrFiltersDefaultDeny(n.Repo)
+	if err != nil {
+		return err
+	}
+
+	if filters == nil {
+		filters = []string{}
+	}
+	if err := n.Repo.SetConfigKey("Swarm.AddrFilters", filters); err != nil {
+		return err
+	}
+
+	return applyFilters(swrm, filters, deny)
+}
+
+// applyFilters replaces the filters of the swarm. The new masks are added
+// before the old ones are removed, so that no address is let through while
+// switching.
+func applyFilters(swrm *swarm.Swarm, filters []string, defaultDeny bool) error {
+	masks, err := core.AddrFilterMasks(filters, defaultDeny)
+	if err != nil {
+		return err
+	}
 
-	// re-add cfg swarm filters to rm dupes
-	oldFilters := cfg.Swarm.AddrFilters
-	cfg.Swarm.AddrFilters = nil
+	keep := make(map[string]bool, len(masks))
+	for _, m := range masks {
+		keep[m.String()] = true
+	}
+
+	old := swrm.Filters.Filters()
+	for _, m := range masks {
+		swrm.Filters.AddDialFilter(m)
+	}
+	for _, m := range old {
+		if !keep[m.String()] {
+			swrm.Filters.Remove(m)
+		}
+	}
+	return nil
+}
+
+// filtersAdd returns filters with toAdd prepended, without duplicates, and the
+// filters actually added.
+func filtersAdd(filters, toAdd []string) ([]string, []string) {
+	addedMap := map[string]struct{}{}
+	addedList := make([]string, 0, len(toAdd))
+	out := make([]string, 0, len(filters)+len(toAdd))
 
 	// add new filters
-	for _, filter := range filters {
+	for _, filter := range toAdd {
 		if _, found := addedMap[filter]; found {
 			continue
 		}
 
-		cfg.Swarm.AddrFilters = append(cfg.Swarm.AddrFilters, filter)
+		out = append(out, filter)
 		addedList = append(addedList, filter)
 		addedMap[filter] = struct{}{}
 	}
 
 	// add back original filters. in this order so that we output them.
-	for _, filter := range oldFilters {
+	for _, filter := range filters {
 		if _, found := addedMap[filter]; found {
 			continue
 		}
 
-		cfg.Swarm.AddrFilters = append(cfg.Swarm.AddrFilters, filter)
+		out = append(out, filter)
 		addedMap[filter] = struct{}{}
 	}
 
-	if err := r.SetConfig(cfg); err != nil {
-		return nil, err
-	}
-
-	return addedList, nil
+	return out, addedList
 }
 
-func filtersRemoveAll(r repo.Repo, cfg *config.Config) ([]string, error) {
-	removed := cfg.Swarm.AddrFilters
-	cfg.Swarm.AddrFilters = nil
+// filtersRemove returns filters without toRemove, and the filters actually
+// removed.
+func filtersRemove(filters, toRemove []string) ([]string, []string) {
+	removed := make([]string, 0, len(toRemove))
+	keep := make([]string, 0, len(filters))
 
-	if err := r.SetConfig(cfg); err != nil {
-		return nil, err
-	}
-
-	return removed, nil
-}
-
-func filtersRemove(r repo.Repo, cfg *config.Config, toRemoveFilters []string) ([]string, error) {
-	removed := make([]string, 0, len(toRemoveFilters))
-	keep := make([]string, 0, len(cfg.Swarm.AddrFilters))
-
-	oldFilters := cfg.Swarm.AddrFilters
-
-	for _, oldFilter := range oldFilters {
+	for _, oldFilter := range filters {
 		found := false
-		for _, toRemoveFilter := range toRemoveFilters {
+		for _, toRemoveFilter := range toRemove {
 			if oldFilter == toRemoveFilter {
 				found = true
 				removed = append(removed, toRemoveFilter)
@@ -867,11 +958,6 @@ func filtersRemove(r repo.Repo, cfg *config.Config, toRemoveFilters []string) ([
 			keep = append(keep, oldFilter)
 		}
 	}
-	cfg.Swarm.AddrFilters = keep
-
-	if err := r.SetConfig(cfg); err != nil {
-		return nil, err
-	}
 
-	return removed, nil
+	return keep, removed
 }
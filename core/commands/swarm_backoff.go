@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	swarm "gx/ipfs/QmeDpqUwwdye8ABKVMPXKuWwPVURFdqTqssbTUB39E2Nwd/go-libp2p-swarm"
+)
+
+var swarmBackoffCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Inspect and clear the dial backoff of peers.",
+		ShortDescription: `
+When dialing a peer fails, the node doesn't dial it again for a while, waiting
+longer after each failure. 'ipfs swarm backoff' lists the peers in backoff and
+clears it, to retry connecting to them right away.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"clear": swarmBackoffClearCmd,
+		"ls":    swarmBackoffLsCmd,
+	},
+}
+
+var swarmBackoffLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the peers in dial backoff.",
+		ShortDescription: `
+'ipfs swarm backoff ls' lists the known peers the node doesn't dial because the
+last attempts failed.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if n.PeerHost == nil {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		swrm, ok := n.PeerHost.Network().(*swarm.Swarm)
+		if !ok {
+			res.SetError(fmt.Errorf("peerhost network was not swarm"), cmdkit.ErrNormal)
+			return
+		}
+
+		// the backoff table can't be listed, the known peers are checked
+		// instead
+		var output []string
+		for _, p := range n.Peerstore.Peers() {
+			if swrm.Backoff().Backoff(p) {
+				output = append(output, p.Pretty())
+			}
+		}
+		sort.Strings(output)
+
+		res.SetOutput(&stringList{output})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: stringListMarshaler,
+	},
+	Type: stringList{},
+}
+
+var swarmBackoffClearCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Clear the dial backoff of peers.",
+		ShortDescription: `
+'ipfs swarm backoff clear' clears the dial backoff of the given peers, so that
+the next attempt to connect to them dials right away.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("peer", true, true, "ID of the peer to clear the backoff of.").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if n.PeerHost == nil {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		swrm, ok := n.PeerHost.Network().(*swarm.Swarm)
+		if !ok {
+			res.SetError(fmt.Errorf("peerhost network was not swarm"), cmdkit.ErrNormal)
+			return
+		}
+
+		ids := make([]peer.ID, len(req.Arguments()))
+		for i, s := range req.Arguments() {
+			ids[i], err = peer.IDB58Decode(s)
+			if err != nil {
+				res.SetError(fmt.Errorf("invalid peer ID %q: %s", s, err), cmdkit.ErrClient)
+				return
+			}
+		}
+
+		output := make([]string, len(ids))
+		for i, id := range ids {
+			state := "not in backoff"
+			if swrm.Backoff().Backoff(id) {
+				swrm.Backoff().Clear(id)
+				state = "cleared"
+			}
+			output[i] = "backoff " + id.Pretty() + " " + state
+		}
+
+		res.SetOutput(&stringList{output})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: stringListMarshaler,
+	},
+	Type: stringList{},
+}
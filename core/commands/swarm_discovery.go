@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	mdns "github.com/ipfs/go-ipfs/p2p/mdns"
+
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+var errDiscoveryDisabled = errors.New("local discovery is disabled, see Discovery.MDNS.Enabled")
+
+// discoverySourceMDNS is the source of the peers found with mDNS.
+const discoverySourceMDNS = "mdns"
+
+type discoveredPeer struct {
+	Peer      string
+	Source    string
+	Interface string
+	Network   string
+	Addrs     []string
+	LastSeen  time.Time
+}
+
+type discoveredPeers struct {
+	Peers []discoveredPeer
+}
+
+var swarmDiscoveryCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the peers discovered on the local network.",
+		ShortDescription: `
+'ipfs swarm discovery' lists the peers announced on the local network, which
+the node connects to, with the source they were discovered through: mDNS, the
+network interface and the IP version, ip4 or ip6. A peer found on several
+interfaces is listed once for each.
+
+  > ipfs swarm discovery
+  QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ mdns eth0 ip4 /ip4/192.168.1.7/tcp/4001
+  QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ mdns eth0 ip6 /ip6/fd00::7/tcp/4001
+
+The discovery is configured in Discovery.MDNS.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+		svc, ok := n.Discovery.(*mdns.Service)
+		if !ok {
+			res.SetError(errDiscoveryDisabled, cmdkit.ErrClient)
+			return
+		}
+
+		peers := svc.Peers()
+		out := &discoveredPeers{Peers: make([]discoveredPeer, 0, len(peers))}
+		for _, p := range peers {
+			addrs := make([]string, len(p.Addrs))
+			for i, a := range p.Addrs {
+				addrs[i] = a.String()
+			}
+			out.Peers = append(out.Peers, discoveredPeer{
+				Peer:      p.ID.Pretty(),
+				Source:    discoverySourceMDNS,
+				Interface: p.Interface,
+				Network:   p.Network,
+				Addrs:     addrs,
+				LastSeen:  p.LastSeen,
+			})
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*discoveredPeers)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			buf := new(bytes.Buffer)
+			w := tabwriter.NewWriter(buf, 1, 2, 1, ' ', 0)
+			for _, p := range out.Peers {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Peer, p.Source, p.Interface, p.Network, strings.Join(p.Addrs, " "))
+			}
+			w.Flush()
+			return buf, nil
+		},
+	},
+	Type: discoveredPeers{},
+}
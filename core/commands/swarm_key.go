@@ -0,0 +1,298 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// maxSwarmKeySize bounds the size of the swarm key files read by rotate.
+const maxSwarmKeySize = 1 << 10
+
+var errNoSwarmKey = errors.New("no swarm key, generate one with 'ipfs swarm key gen'")
+
+type swarmKeyOutput struct {
+	// Fingerprint is the fingerprint of the key of the repo.
+	Fingerprint string
+	// Previous is the fingerprint of the key replaced by rotate.
+	Previous string `json:",omitempty"`
+	// Active is the fingerprint of the key used by the running daemon, which
+	// only changes when it restarts.
+	Active string `json:",omitempty"`
+	// Restart is set when the running daemon doesn't use the key of the repo.
+	Restart bool `json:",omitempty"`
+	// Key is the key itself, shown with show --key.
+	Key string `json:",omitempty"`
+}
+
+var swarmKeyCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the key of the private network.",
+		ShortDescription: `
+'ipfs swarm key' manages the swarm.key file of the repo, the pre-shared key
+limiting the swarm to the peers of a private network. The daemon only uses a
+new key once restarted.
+
+To rotate the key of a network, generate a key on one node, copy it to the
+others, and restart them:
+
+  ipfs swarm key rotate
+  ipfs swarm key show --key > new.key
+  # on every other node:
+  ipfs swarm key rotate new.key
+
+The peers using different keys can't connect to each other until they are all
+restarted. The previous key is kept in swarm.key.old, to go back to it.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"gen":    swarmKeyGenCmd,
+		"rotate": swarmKeyRotateCmd,
+		"show":   swarmKeyShowCmd,
+	},
+}
+
+var swarmKeyGenCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Generate the key of a private network.",
+		ShortDescription: `
+'ipfs swarm key gen' generates a random swarm key and saves it in the repo,
+making the node part of a new private network once the daemon is restarted.
+It fails if the repo has a key already, use 'ipfs swarm key rotate' to
+replace it.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		prev, err := n.Repo.SwarmKey()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if prev != nil {
+			res.SetError(errors.New("the repo has a swarm key already, replace it with 'ipfs swarm key rotate'"), cmdkit.ErrClient)
+			return
+		}
+
+		out, err := setSwarmKey(n, nil, "")
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: swarmKeyMarshaler("Generated swarm key %s\n"),
+	},
+	Type: swarmKeyOutput{},
+}
+
+var swarmKeyShowCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the key of the private network.",
+		ShortDescription: `
+'ipfs swarm key show' shows the fingerprint of the swarm key of the repo, and
+the fingerprint of the key used by the daemon when it differs.
+
+With --key, the key itself is shown, in the format of the swarm.key file, to
+be copied to the other nodes of the network. Keep it secret.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("key", "Show the key itself."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		key, err := n.Repo.SwarmKey()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if key == nil {
+			res.SetError(errNoSwarmKey, cmdkit.ErrNormal)
+			return
+		}
+
+		fp, err := core.SwarmKeyFingerprint(key)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		out := newSwarmKeyOutput(n, fp)
+		if showKey, _, _ := req.Option("key").Bool(); showKey {
+			out.Key = string(key)
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			out, ok := v.(*swarmKeyOutput)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			if out.Key != "" {
+				return bytes.NewBufferString(out.Key), nil
+			}
+			return swarmKeyMarshaler("%s\n")(res)
+		},
+	},
+	Type: swarmKeyOutput{},
+}
+
+var swarmKeyRotateCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Replace the key of the private network.",
+		ShortDescription: `
+'ipfs swarm key rotate' replaces the swarm key of the repo with a random one,
+or with the key read from <key-file>, e.g. generated on another node of the
+network. The previous key is kept in swarm.key.old, and the daemon uses it
+until it is restarted.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.FileArg("key-file", false, false, "The file of the new key, in the format of swarm.key."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		prev, err := n.Repo.SwarmKey()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if prev == nil {
+			res.SetError(errNoSwarmKey, cmdkit.ErrClient)
+			return
+		}
+		prevFp, err := core.SwarmKeyFingerprint(prev)
+		if err != nil {
+			// an invalid key can be replaced
+			prevFp = "invalid"
+		}
+
+		var key []byte
+		if req.Files() != nil {
+			f, err := req.Files().NextFile()
+			switch {
+			case err == io.EOF:
+			case err != nil:
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			default:
+				defer f.Close()
+				key, err = ioutil.ReadAll(io.LimitReader(f, maxSwarmKeySize+1))
+				if err != nil {
+					res.SetError(err, cmdkit.ErrNormal)
+					return
+				}
+				if len(key) > maxSwarmKeySize {
+					res.SetError(errors.New("invalid swarm key: file too large"), cmdkit.ErrClient)
+					return
+				}
+			}
+		}
+
+		out, err := setSwarmKey(n, key, prevFp)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		out.Previous = prevFp
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: swarmKeyMarshaler("Rotated swarm key %s\n"),
+	},
+	Type: swarmKeyOutput{},
+}
+
+// setSwarmKey saves key in the repo of n, or a new key if it is nil. It
+// fails if the key has the fingerprint prev, of the key it replaces.
+func setSwarmKey(n *core.IpfsNode, key []byte, prev string) (*swarmKeyOutput, error) {
+	if key == nil {
+		var err error
+		if key, err = core.GenerateSwarmKey(); err != nil {
+			return nil, err
+		}
+	}
+	fp, err := core.SwarmKeyFingerprint(key)
+	if err != nil {
+		return nil, err
+	}
+	if fp == prev {
+		return nil, errors.New("the new swarm key is the current one")
+	}
+	if err := n.Repo.SetSwarmKey(key); err != nil {
+		return nil, err
+	}
+	return newSwarmKeyOutput(n, fp), nil
+}
+
+// newSwarmKeyOutput returns the output for the key of the repo with the
+// fingerprint fp, and the key used by n if it is online.
+func newSwarmKeyOutput(n *core.IpfsNode, fp string) *swarmKeyOutput {
+	out := &swarmKeyOutput{Fingerprint: fp}
+	if n.OnlineMode() {
+		if n.PNetFingerprint != nil {
+			out.Active = fmt.Sprintf("%x", n.PNetFingerprint)
+		}
+		out.Restart = out.Active != fp
+	}
+	return out
+}
+
+// swarmKeyMarshaler prints the fingerprint of the key with format, followed
+// by the restart the key needs.
+func swarmKeyMarshaler(format string) func(cmds.Response) (io.Reader, error) {
+	return func(res cmds.Response) (io.Reader, error) {
+		v, err := unwrapOutput(res.Output())
+		if err != nil {
+			return nil, err
+		}
+		out, ok := v.(*swarmKeyOutput)
+		if !ok {
+			return nil, e.TypeErr(out, v)
+		}
+
+		buf := new(bytes.Buffer)
+		if out.Previous != "" {
+			fmt.Fprintf(buf, "Previous swarm key %s\n", out.Previous)
+		}
+		fmt.Fprintf(buf, format, out.Fingerprint)
+		switch {
+		case !out.Restart:
+		case out.Active != "":
+			fmt.Fprintf(buf, "The daemon uses the swarm key %s until it is restarted\n", out.Active)
+		default:
+			fmt.Fprintln(buf, "The daemon joins the private network once restarted")
+		}
+		return buf, nil
+	}
+}
@@ -0,0 +1,297 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	rcmgr "github.com/ipfs/go-ipfs/p2p/rcmgr"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+var errResourceMgrDisabled = errors.New("the resource manager is disabled, see Swarm.ResourceMgr.Enabled")
+
+var swarmLimitCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show or change the resource limits of the peers.",
+		ShortDescription: `
+'ipfs swarm limit' shows the limits on the resources the peers can use. The
+connections and streams over the limits are closed right away.
+
+Given a limit and a value, it changes the limit until the daemon stops:
+
+  ipfs swarm limit StreamsPerPeer 256
+  ipfs swarm limit Memory 512MB
+  ipfs swarm limit /ipfs/bitswap/1.1.0 1000
+
+A limit starting with a / is a protocol, whose number of streams is limited.
+A zero value removes the limit. Set Swarm.ResourceMgr in the config to change
+the limits permanently.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("limit", false, false, "Name of the limit to change."),
+		cmdkit.StringArg("value", false, false, "New value of the limit."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+		if n.ResourceMgr == nil {
+			res.SetError(errResourceMgrDisabled, cmdkit.ErrClient)
+			return
+		}
+
+		args := req.Arguments()
+		switch len(args) {
+		case 0:
+		case 2:
+			l := n.ResourceMgr.Limits()
+			if err := setLimit(&l, args[0], args[1]); err != nil {
+				res.SetError(err, cmdkit.ErrClient)
+				return
+			}
+			n.ResourceMgr.SetLimits(l)
+		default:
+			res.SetError(errors.New("give both a limit and its value"), cmdkit.ErrClient)
+			return
+		}
+
+		l := n.ResourceMgr.Limits()
+		res.SetOutput(&l)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			l, ok := v.(*rcmgr.Limits)
+			if !ok {
+				return nil, e.TypeErr(l, v)
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Conns: %s\n", countLimit(l.Conns))
+			fmt.Fprintf(buf, "ConnsPerPeer: %s\n", countLimit(l.ConnsPerPeer))
+			fmt.Fprintf(buf, "Streams: %s\n", countLimit(l.Streams))
+			fmt.Fprintf(buf, "StreamsPerPeer: %s\n", countLimit(l.StreamsPerPeer))
+			fmt.Fprintf(buf, "Memory: %s\n", memoryLimit(l.Memory))
+			fmt.Fprintf(buf, "ConnMemory: %s\n", humanize.Bytes(uint64(l.ConnMemory)))
+			fmt.Fprintf(buf, "StreamMemory: %s\n", humanize.Bytes(uint64(l.StreamMemory)))
+
+			protos := make([]string, 0, len(l.Protocols))
+			for p := range l.Protocols {
+				protos = append(protos, p)
+			}
+			sort.Strings(protos)
+			for _, p := range protos {
+				fmt.Fprintf(buf, "%s: %s\n", p, countLimit(l.Protocols[p]))
+			}
+			return buf, nil
+		},
+	},
+	Type: rcmgr.Limits{},
+}
+
+func countLimit(n int) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(n)
+}
+
+func memoryLimit(n int64) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return humanize.Bytes(uint64(n))
+}
+
+// setLimit sets the limit called name in l.
+func setLimit(l *rcmgr.Limits, name, value string) error {
+	if strings.HasPrefix(name, "/") {
+		v, err := parseCountLimit(value)
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			delete(l.Protocols, name)
+		} else {
+			l.Protocols[name] = v
+		}
+		return nil
+	}
+
+	var count *int
+	var memory *int64
+	switch name {
+	case "Conns":
+		count = &l.Conns
+	case "ConnsPerPeer":
+		count = &l.ConnsPerPeer
+	case "Streams":
+		count = &l.Streams
+	case "StreamsPerPeer":
+		count = &l.StreamsPerPeer
+	case "Memory":
+		memory = &l.Memory
+	case "ConnMemory":
+		memory = &l.ConnMemory
+	case "StreamMemory":
+		memory = &l.StreamMemory
+	default:
+		return fmt.Errorf("unknown limit %q", name)
+	}
+
+	if count != nil {
+		v, err := parseCountLimit(value)
+		if err != nil {
+			return err
+		}
+		*count = v
+		return nil
+	}
+
+	v, err := humanize.ParseBytes(value)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %s", value, err)
+	}
+	*memory = int64(v)
+	return nil
+}
+
+func parseCountLimit(value string) (int, error) {
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid limit %q, must be a positive number or 0", value)
+	}
+	return v, nil
+}
+
+type swarmPeerUsage struct {
+	Peer    string
+	Conns   int
+	Streams int
+	Memory  int64
+}
+
+type swarmStats struct {
+	Conns          int
+	Streams        int
+	Memory         int64
+	BlockedConns   int
+	BlockedStreams int
+	Protocols      map[string]int
+	Peers          []swarmPeerUsage `json:",omitempty"`
+}
+
+var swarmStatsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the resources used by the peers.",
+		ShortDescription: `
+'ipfs swarm stats' shows the connections and the streams currently open, the
+memory of the stream multiplexers they are estimated to use, and how many were
+closed because they were over the limits of 'ipfs swarm limit'.
+
+With --verbose, the resources used by every peer are listed, the peers using
+the most memory first.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("verbose", "v", "Also list the resources used by each peer."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+		if n.ResourceMgr == nil {
+			res.SetError(errResourceMgrDisabled, cmdkit.ErrClient)
+			return
+		}
+
+		verbose, _, _ := req.Option("verbose").Bool()
+
+		st := n.ResourceMgr.Stats()
+		out := &swarmStats{
+			Conns:          st.Conns,
+			Streams:        st.Streams,
+			Memory:         st.Memory,
+			BlockedConns:   st.BlockedConns,
+			BlockedStreams: st.BlockedStreams,
+			Protocols:      st.Protocols,
+		}
+		if verbose {
+			for p, u := range st.Peers {
+				out.Peers = append(out.Peers, swarmPeerUsage{
+					Peer:    p.Pretty(),
+					Conns:   u.Conns,
+					Streams: u.Streams,
+					Memory:  u.Memory,
+				})
+			}
+			sort.Slice(out.Peers, func(i, j int) bool {
+				if out.Peers[i].Memory != out.Peers[j].Memory {
+					return out.Peers[i].Memory > out.Peers[j].Memory
+				}
+				return out.Peers[i].Peer < out.Peers[j].Peer
+			})
+		}
+
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*swarmStats)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Conns: %d (%d blocked)\n", out.Conns, out.BlockedConns)
+			fmt.Fprintf(buf, "Streams: %d (%d blocked)\n", out.Streams, out.BlockedStreams)
+			fmt.Fprintf(buf, "Memory: %s\n", humanize.Bytes(uint64(out.Memory)))
+
+			protos := make([]string, 0, len(out.Protocols))
+			for p := range out.Protocols {
+				protos = append(protos, p)
+			}
+			sort.Strings(protos)
+			for _, p := range protos {
+				fmt.Fprintf(buf, "%s: %d streams\n", p, out.Protocols[p])
+			}
+
+			for _, p := range out.Peers {
+				fmt.Fprintf(buf, "%s conns=%d streams=%d memory=%s\n", p.Peer, p.Conns, p.Streams, humanize.Bytes(uint64(p.Memory)))
+			}
+			return buf, nil
+		},
+	},
+	Type: swarmStats{},
+}
@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+)
+
+type peeringPeer struct {
+	ID        string
+	Addrs     []string
+	Connected bool
+}
+
+type peeringPeers struct {
+	Peers []peeringPeer
+}
+
+var swarmPeeringCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Keep connections to a set of peers.",
+		ShortDescription: `
+'ipfs swarm peering' manages the peers the node stays connected to. Their
+connections are protected from the connection manager, and reopened with an
+exponential backoff when they drop.
+
+The peers of the Peering.Peers config are added when the daemon starts. The
+peers added or removed with these commands are only kept until the daemon
+stops: edit the config to make the changes permanent.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add": swarmPeeringAddCmd,
+		"ls":  swarmPeeringLsCmd,
+		"rm":  swarmPeeringRmCmd,
+	},
+}
+
+var swarmPeeringAddCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Add peers to stay connected to.",
+		ShortDescription: `
+'ipfs swarm peering add' adds peers to stay connected to, given by their
+multiaddrs ending with /ipfs/<peer id>:
+
+  ipfs swarm peering add /ip4/104.131.131.82/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+
+A bare /ipfs/<peer id> adds a peer whose addresses are looked up.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("address", true, true, "Address of the peer to stay connected to.").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if n.Peering == nil {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		pis, err := peersWithAddresses(req.Arguments())
+		if err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
+
+		output := make([]string, len(pis))
+		for i, pi := range pis {
+			if err := n.Peering.AddPeer(pi); err != nil {
+				res.SetError(fmt.Errorf("add %s failure: %s", pi.ID.Pretty(), err), cmdkit.ErrNormal)
+				return
+			}
+			output[i] = "add " + pi.ID.Pretty() + " success"
+		}
+
+		res.SetOutput(&stringList{output})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: stringListMarshaler,
+	},
+	Type: stringList{},
+}
+
+var swarmPeeringLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the peers the node stays connected to.",
+		ShortDescription: `
+'ipfs swarm peering ls' lists the peers the node stays connected to, and
+whether they are currently connected.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("verbose", "v", "Also list the known addresses of the peers."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if n.Peering == nil {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		out := &peeringPeers{Peers: []peeringPeer{}}
+		for _, pi := range n.Peering.ListPeers() {
+			p := peeringPeer{
+				ID:        pi.ID.Pretty(),
+				Connected: n.PeerHost.Network().Connectedness(pi.ID) == inet.Connected,
+			}
+			for _, a := range pi.Addrs {
+				p.Addrs = append(p.Addrs, a.String())
+			}
+			out.Peers = append(out.Peers, p)
+		}
+		sort.Slice(out.Peers, func(i, j int) bool {
+			return out.Peers[i].ID < out.Peers[j].ID
+		})
+
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*peeringPeers)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			verbose, _, _ := res.Request().Option("verbose").Bool()
+
+			buf := new(bytes.Buffer)
+			for _, p := range out.Peers {
+				state := "disconnected"
+				if p.Connected {
+					state = "connected"
+				}
+				fmt.Fprintf(buf, "%s %s\n", p.ID, state)
+				if verbose {
+					for _, a := range p.Addrs {
+						fmt.Fprintf(buf, "\t%s\n", a)
+					}
+				}
+			}
+			return buf, nil
+		},
+	},
+	Type: peeringPeers{},
+}
+
+var swarmPeeringRmCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Stop keeping the connections to peers.",
+		ShortDescription: `
+'ipfs swarm peering rm' stops keeping the connections to peers. The current
+connections are left open, use 'ipfs swarm disconnect' to close them.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("peer", true, true, "ID of the peer to stop keeping the connection to.").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if n.Peering == nil {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		ids := make([]peer.ID, len(req.Arguments()))
+		for i, s := range req.Arguments() {
+			ids[i], err = peer.IDB58Decode(s)
+			if err != nil {
+				res.SetError(fmt.Errorf("invalid peer ID %q: %s", s, err), cmdkit.ErrClient)
+				return
+			}
+		}
+
+		output := make([]string, len(ids))
+		for i, id := range ids {
+			if !n.Peering.RemovePeer(id) {
+				res.SetError(fmt.Errorf("rm %s failure: not a peering peer", id.Pretty()), cmdkit.ErrNormal)
+				return
+			}
+			output[i] = "rm " + id.Pretty() + " success"
+		}
+
+		res.SetOutput(&stringList{output})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: stringListMarshaler,
+	},
+	Type: stringList{},
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	qos "github.com/ipfs/go-ipfs/p2p/qos"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+var errQoSDisabled = errors.New("the traffic classes are disabled, see Swarm.QoS.Enabled")
+
+var swarmQoSCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the traffic of the quality of service classes.",
+		ShortDescription: `
+'ipfs swarm qos' shows how the upload bandwidth of Swarm.QoS.Rate is shared:
+the class of each traffic type, and the weight of each class with the bytes it
+sent and the bytes waiting to be sent. When the rate is reached, the classes
+share it in proportion to their weights.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+		if n.QoS == nil {
+			res.SetError(errQoSDisabled, cmdkit.ErrClient)
+			return
+		}
+
+		st := n.QoS.Stat()
+		res.SetOutput(&st)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			st, ok := v.(*qos.Stat)
+			if !ok {
+				return nil, e.TypeErr(st, v)
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Rate: %s/s\n\n", humanize.Bytes(uint64(st.Rate)))
+
+			w := tabwriter.NewWriter(buf, 1, 2, 1, ' ', 0)
+			fmt.Fprintln(w, "Traffic\tClass")
+			for _, t := range qos.TrafficTypes {
+				class := st.Traffic[t]
+				if class == "" {
+					class = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\n", t, class)
+			}
+			fmt.Fprintln(w)
+
+			names := make([]string, 0, len(st.Classes))
+			for name := range st.Classes {
+				names = append(names, name)
+			}
+			sort.Slice(names, func(i, j int) bool {
+				return st.Classes[names[i]].Weight > st.Classes[names[j]].Weight
+			})
+			fmt.Fprintln(w, "Class\tWeight\tSent\tQueued")
+			for _, name := range names {
+				c := st.Classes[name]
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", name, c.Weight, humanize.Bytes(c.Sent), humanize.Bytes(c.Queued))
+			}
+			w.Flush()
+			return buf, nil
+		},
+	},
+	Type: qos.Stat{},
+}
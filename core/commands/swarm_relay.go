@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+type relayReservation struct {
+	Peer    string
+	Expires time.Time
+}
+
+type relayServiceStats struct {
+	Reservations []relayReservation
+	Circuits     int
+}
+
+type relayClientStats struct {
+	Reachability string
+	Relays       []string
+	// Addrs are the relayed addresses of the node
+	Addrs []string
+}
+
+type relayStats struct {
+	Service *relayServiceStats `json:",omitempty"`
+	Client  *relayClientStats  `json:",omitempty"`
+}
+
+var swarmRelayCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the state of the relay service and auto-relay.",
+		ShortDescription: `
+'ipfs swarm relay' shows the peers holding a reservation on the relay service
+of the node and the circuits it relays, enabled with Swarm.RelayService.
+
+When auto-relay is enabled with Swarm.RelayClient, it shows whether the node
+is publicly dialable, and otherwise the relays it reserved and the relayed
+addresses it announces.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		out := &relayStats{}
+		if n.RelayService != nil {
+			st := n.RelayService.Stats()
+			out.Service = &relayServiceStats{
+				Reservations: make([]relayReservation, len(st.Reservations)),
+				Circuits:     st.Circuits,
+			}
+			for i, r := range st.Reservations {
+				out.Service.Reservations[i] = relayReservation{Peer: r.Peer.Pretty(), Expires: r.Expires}
+			}
+		}
+		if n.AutoRelay != nil {
+			st := n.AutoRelay.Stats()
+			out.Client = &relayClientStats{
+				Reachability: st.Reachability.String(),
+				Relays:       make([]string, len(st.Relays)),
+				Addrs:        []string{},
+			}
+			for i, p := range st.Relays {
+				out.Client.Relays[i] = p.Pretty()
+			}
+			for _, a := range n.PeerHost.Addrs() {
+				if s := a.String(); strings.Contains(s, "/p2p-circuit") {
+					out.Client.Addrs = append(out.Client.Addrs, s)
+				}
+			}
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*relayStats)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			buf := new(bytes.Buffer)
+			if s := out.Service; s != nil {
+				fmt.Fprintf(buf, "Relay service: %d reservations, %d circuits\n", len(s.Reservations), s.Circuits)
+				for _, r := range s.Reservations {
+					if r.Expires.IsZero() {
+						fmt.Fprintf(buf, "  %s\n", r.Peer)
+					} else {
+						fmt.Fprintf(buf, "  %s until %s\n", r.Peer, r.Expires.Format(time.RFC3339))
+					}
+				}
+			} else {
+				fmt.Fprintln(buf, "Relay service: disabled")
+			}
+
+			if c := out.Client; c != nil {
+				fmt.Fprintf(buf, "Auto-relay: %s reachability, %d relays\n", c.Reachability, len(c.Relays))
+				for _, p := range c.Relays {
+					fmt.Fprintf(buf, "  relay %s\n", p)
+				}
+				for _, a := range c.Addrs {
+					fmt.Fprintf(buf, "  address %s\n", a)
+				}
+			} else {
+				fmt.Fprintln(buf, "Auto-relay: disabled")
+			}
+			return buf, nil
+		},
+	},
+	Type: relayStats{},
+}
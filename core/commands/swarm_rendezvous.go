@@ -0,0 +1,227 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	rendezvous "github.com/ipfs/go-ipfs/p2p/rendezvous"
+
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+type rendezvousPeer struct {
+	ID        string
+	Namespace string
+	Addrs     []string
+	Error     string `json:",omitempty"`
+}
+
+type rendezvousPeers struct {
+	Peers []rendezvousPeer
+}
+
+var swarmRendezvousCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Discover peers through rendezvous points.",
+		ShortDescription: `
+'ipfs swarm rendezvous' registers the node in namespaces at rendezvous points,
+and lists the peers registered there. This lets the peers of an application
+find each other without relying on the DHT.
+
+The rendezvous points are read from the Rendezvous.Points config, or given
+with --point. The node keeps itself registered in the namespaces listed in
+the Rendezvous.Namespaces config, and connects to their peers.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"register": swarmRendezvousRegisterCmd,
+		"discover": swarmRendezvousDiscoverCmd,
+	},
+}
+
+var swarmRendezvousRegisterCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Register in a namespace at the rendezvous points.",
+		ShortDescription: `
+'ipfs swarm rendezvous register' registers the addresses of the node in a
+namespace at the rendezvous points. The registration expires after --ttl,
+use the Rendezvous.Namespaces config to stay registered.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("namespace", true, false, "Namespace to register in."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("point", "Address of the rendezvous point to use instead of the configured ones."),
+		cmdkit.StringOption("ttl", "Lifetime of the registration. Default: Rendezvous.TTL or 2h."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		ctx := req.Context()
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		points, err := rendezvousPoints(n, req)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
+
+		ttl := n.Rendezvous.TTL()
+		if s, found, _ := req.Option("ttl").String(); found {
+			ttl, err = time.ParseDuration(s)
+			if err != nil || ttl < time.Second {
+				res.SetError(fmt.Errorf("invalid ttl: %q", s), cmdkit.ErrClient)
+				return
+			}
+		}
+
+		ns := req.Arguments()[0]
+		output := make([]string, len(points))
+		for i, pi := range points {
+			if err := n.PeerHost.Connect(ctx, pi); err != nil {
+				res.SetError(fmt.Errorf("cannot connect to rendezvous point %s: %s", pi.ID.Pretty(), err), cmdkit.ErrNormal)
+				return
+			}
+			granted, err := n.Rendezvous.Register(ctx, pi.ID, ns, ttl)
+			if err != nil {
+				res.SetError(fmt.Errorf("register in %s at %s failure: %s", ns, pi.ID.Pretty(), err), cmdkit.ErrNormal)
+				return
+			}
+			output[i] = fmt.Sprintf("registered in %s at %s for %s", ns, pi.ID.Pretty(), granted)
+		}
+
+		res.SetOutput(&stringList{output})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: stringListMarshaler,
+	},
+	Type: stringList{},
+}
+
+var swarmRendezvousDiscoverCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the peers registered at the rendezvous points.",
+		ShortDescription: `
+'ipfs swarm rendezvous discover' lists the peers registered in a namespace at
+the rendezvous points, or in all namespaces if none is given. With --connect,
+the node also connects to them.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("namespace", false, false, "Namespace to list the peers of."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("point", "Address of the rendezvous point to use instead of the configured ones."),
+		cmdkit.BoolOption("connect", "Connect to the discovered peers."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		ctx := req.Context()
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		points, err := rendezvousPoints(n, req)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrClient)
+			return
+		}
+		connect, _, _ := req.Option("connect").Bool()
+
+		var ns string
+		if len(req.Arguments()) > 0 {
+			ns = req.Arguments()[0]
+		}
+
+		out := &rendezvousPeers{Peers: []rendezvousPeer{}}
+		for _, pi := range points {
+			if err := n.PeerHost.Connect(ctx, pi); err != nil {
+				res.SetError(fmt.Errorf("cannot connect to rendezvous point %s: %s", pi.ID.Pretty(), err), cmdkit.ErrNormal)
+				return
+			}
+			regs, err := n.Rendezvous.DiscoverAll(ctx, pi.ID, ns)
+			if err != nil {
+				res.SetError(fmt.Errorf("discover at %s failure: %s", pi.ID.Pretty(), err), cmdkit.ErrNormal)
+				return
+			}
+
+			for _, reg := range regs {
+				p := rendezvousPeer{
+					ID:        reg.Peer.ID.Pretty(),
+					Namespace: reg.Namespace,
+				}
+				for _, a := range reg.Peer.Addrs {
+					p.Addrs = append(p.Addrs, a.String())
+				}
+				if connect && reg.Peer.ID != n.Identity {
+					if err := n.PeerHost.Connect(ctx, reg.Peer); err != nil {
+						p.Error = err.Error()
+					}
+				}
+				out.Peers = append(out.Peers, p)
+			}
+		}
+
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*rendezvousPeers)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			buf := new(bytes.Buffer)
+			for _, p := range out.Peers {
+				if p.Error != "" {
+					fmt.Fprintf(buf, "%s %s: connect failure: %s\n", p.ID, p.Namespace, p.Error)
+				} else {
+					fmt.Fprintf(buf, "%s %s\n", p.ID, p.Namespace)
+				}
+			}
+			return buf, nil
+		},
+	},
+	Type: rendezvousPeers{},
+}
+
+// rendezvousPoints returns the rendezvous point given with --point, or the
+// configured ones.
+func rendezvousPoints(n *core.IpfsNode, req cmds.Request) ([]pstore.PeerInfo, error) {
+	if s, found, _ := req.Option("point").String(); found {
+		pi, err := rendezvous.ParsePoint(s)
+		if err != nil {
+			return nil, err
+		}
+		return []pstore.PeerInfo{pi}, nil
+	}
+
+	points := n.Rendezvous.Points()
+	if len(points) == 0 {
+		return nil, fmt.Errorf("no rendezvous points, set Rendezvous.Points or use --point")
+	}
+	return points, nil
+}
@@ -0,0 +1,152 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	reputation "github.com/ipfs/go-ipfs/p2p/reputation"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+var errReputationDisabled = errors.New("peer reputation is disabled, see Swarm.Reputation.Enabled")
+
+type peerReputation struct {
+	Peer         string
+	Score        float64
+	Bitswap      float64
+	DHT          float64
+	Stability    float64
+	Sent         uint64
+	Recv         uint64
+	DHTResponses uint64
+	DHTErrors    uint64
+	Connects     uint64
+	Flaps        uint64
+	Uptime       time.Duration
+	FirstSeen    time.Time
+	LastSeen     time.Time
+}
+
+type peerReputations struct {
+	Peers []peerReputation
+}
+
+var swarmReputationCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the reputation of peers.",
+		ShortDescription: `
+'ipfs swarm reputation' shows what the node recorded about the behavior of the
+given peers, or lists all the peers it has a record of, best first.
+
+The score of a peer, from 0 to 100, sums up how it reciprocates in bitswap,
+answers DHT queries and keeps its connections up. A peer nothing is known
+about scores 50. The peers with a low score have their connections trimmed
+first, and are asked for blocks last.
+
+The reputation is recorded when Swarm.Reputation.Enabled is set.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("peer", false, true, "ID of the peer to show the reputation of."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if !n.OnlineMode() {
+			res.SetError(ErrNotOnline, cmdkit.ErrClient)
+			return
+		}
+		if n.Reputation == nil {
+			res.SetError(errReputationDisabled, cmdkit.ErrClient)
+			return
+		}
+
+		var reps []reputation.Reputation
+		if len(req.Arguments()) == 0 {
+			reps, err = n.Reputation.List()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+		for _, s := range req.Arguments() {
+			id, err := peer.IDB58Decode(s)
+			if err != nil {
+				res.SetError(fmt.Errorf("invalid peer ID %q: %s", s, err), cmdkit.ErrClient)
+				return
+			}
+			reps = append(reps, n.Reputation.Get(id))
+		}
+
+		out := &peerReputations{Peers: make([]peerReputation, 0, len(reps))}
+		for _, r := range reps {
+			out.Peers = append(out.Peers, peerReputation{
+				Peer:         r.Peer.Pretty(),
+				Score:        r.Score,
+				Bitswap:      r.Bitswap,
+				DHT:          r.DHT,
+				Stability:    r.Stability,
+				Sent:         r.Sent,
+				Recv:         r.Recv,
+				DHTResponses: r.DHTResponses,
+				DHTErrors:    r.DHTErrors,
+				Connects:     r.Connects,
+				Flaps:        r.Flaps,
+				Uptime:       r.Uptime,
+				FirstSeen:    r.FirstSeen,
+				LastSeen:     r.LastSeen,
+			})
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*peerReputations)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			buf := new(bytes.Buffer)
+			if len(res.Request().Arguments()) == 0 {
+				for _, p := range out.Peers {
+					fmt.Fprintf(buf, "%s %.1f\n", p.Peer, p.Score)
+				}
+				return buf, nil
+			}
+
+			for _, p := range out.Peers {
+				fmt.Fprintf(buf, "Reputation of %s\n"+
+					"Score:\t%.1f\n"+
+					"Bitswap:\t%.2f (sent %s, received %s)\n"+
+					"DHT:\t%.2f (%d responses, %d errors)\n"+
+					"Stability:\t%.2f (%d connections, %d dropped within a minute, up %s)\n",
+					p.Peer, p.Score,
+					p.Bitswap, humanize.Bytes(p.Sent), humanize.Bytes(p.Recv),
+					p.DHT, p.DHTResponses, p.DHTErrors,
+					p.Stability, p.Connects, p.Flaps, p.Uptime)
+				if !p.LastSeen.IsZero() {
+					fmt.Fprintf(buf, "Last seen:\t%s\n", p.LastSeen.Format(time.RFC3339))
+				}
+				fmt.Fprintln(buf)
+			}
+			return buf, nil
+		},
+	},
+	Type: peerReputations{},
+}
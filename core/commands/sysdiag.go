@@ -7,6 +7,7 @@ import (
 
 	version "github.com/ipfs/go-ipfs"
 	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
 
 	"gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
 	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
@@ -17,7 +18,9 @@ var sysDiagCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Print system diagnostic information.",
 		ShortDescription: `
-Prints out information about your computer to aid in easier debugging.
+Prints out information about your computer to aid in easier debugging, and
+the subsystems run by the node: the DHT, the reprovider and the gateway are
+disabled on light clients.
 `,
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
@@ -57,6 +60,12 @@ Prints out information about your computer to aid in easier debugging.
 			return
 		}
 
+		err = subsystemsInfo(node, info)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
 		info["ipfs_version"] = version.CurrentVersionNumber
 		info["ipfs_commit"] = version.CurrentCommit
 		res.SetOutput(info)
@@ -140,3 +149,35 @@ func netInfo(online bool, out map[string]interface{}) error {
 	out["net"] = n
 	return nil
 }
+
+// subsystemsInfo reports the subsystems run by the node, light clients
+// disabling some of them.
+func subsystemsInfo(node *core.IpfsNode, out map[string]interface{}) error {
+	cfg, err := node.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	s := make(map[string]interface{})
+	dht := "disabled"
+	if mode, ok := node.DHTMode(); ok {
+		dht = "client"
+		if mode.Server {
+			dht = "server"
+		}
+	}
+	s["dht"] = dht
+	s["reprovider"] = node.Reprovider != nil && cfg.Reprovider.Interval != "0"
+	s["gateway"] = node.OnlineMode() && !node.LightClient() && cfg.Addresses.Gateway != ""
+	s["light_client"] = node.LightClient()
+	if node.LightClient() {
+		delegates, err := core.LightClientDelegates(node.Repo)
+		if err != nil {
+			return err
+		}
+		s["delegates"] = delegates
+	}
+
+	out["subsystems"] = s
+	return nil
+}
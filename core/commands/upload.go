@@ -0,0 +1,351 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
+	cmdkit "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit"
+)
+
+// uploadsDir is the directory of the repo the uploads are kept in.
+const uploadsDir = "uploads"
+
+type uploadList struct {
+	Uploads []*coreunix.UploadStatus
+}
+
+var UploadCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Add large files in resumable uploads.",
+		ShortDescription: `
+A resumable upload adds a file sent in several requests, so that uploading a
+large file to a remote daemon over a flaky connection doesn't restart from
+zero when the connection fails. The daemon keeps the bytes received in the
+uploads directory of the repo, and adds the file once all its bytes are
+received:
+
+  > ipfs upload new 1073741824 --name=big.iso
+  5f0c4a3a0d7c9a1e8b3b6a59f2a1c0de 0/1073741824
+  > ipfs upload append 5f0c4a3a0d7c9a1e8b3b6a59f2a1c0de 0 part1
+  5f0c4a3a0d7c9a1e8b3b6a59f2a1c0de 536870912/1073741824
+  > ipfs upload append 5f0c4a3a0d7c9a1e8b3b6a59f2a1c0de 536870912 part2
+  5f0c4a3a0d7c9a1e8b3b6a59f2a1c0de 1073741824/1073741824 QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB
+
+When a request fails, 'ipfs upload status' tells how many bytes were
+received, and the upload continues from there. Over HTTP, the data is sent
+as the multipart body of the append request:
+
+  curl -F file=@part2 "http://127.0.0.1:5001/api/v0/upload/append?arg=<id>&arg=536870912"
+
+The uploads are removed once they haven't been appended to for
+API.Uploads.Expiry, 24h by default. The total length of the uploads in
+progress is bounded by API.Uploads.MaxSize, 4GiB by default.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"new":    uploadNewCmd,
+		"append": uploadAppendCmd,
+		"status": uploadStatusCmd,
+		"ls":     uploadLsCmd,
+		"rm":     uploadRmCmd,
+	},
+}
+
+// uploadStore returns the store of the uploads of the repo.
+func uploadStore(env cmds.Environment, n *core.IpfsNode) (*coreunix.UploadStore, error) {
+	cctx, ok := env.(*oldcmds.Context)
+	if !ok {
+		return nil, fmt.Errorf("expected env to be of type %T, got %T", cctx, env)
+	}
+
+	var cfg struct {
+		Expiry  string
+		MaxSize string
+	}
+	if err := repo.ConfigSection(n.Repo, "API.Uploads", &cfg); err != nil {
+		return nil, err
+	}
+	var expiry time.Duration
+	if cfg.Expiry != "" {
+		var err error
+		if expiry, err = time.ParseDuration(cfg.Expiry); err != nil || expiry <= 0 {
+			return nil, fmt.Errorf("invalid API.Uploads.Expiry %q", cfg.Expiry)
+		}
+	}
+	var maxSize int64
+	if cfg.MaxSize != "" {
+		s, err := humanize.ParseBytes(cfg.MaxSize)
+		if err != nil || s == 0 || s > math.MaxInt64 {
+			return nil, fmt.Errorf("invalid API.Uploads.MaxSize %q", cfg.MaxSize)
+		}
+		maxSize = int64(s)
+	}
+	return coreunix.NewUploadStore(filepath.Join(cctx.ConfigRoot, uploadsDir), expiry, maxSize), nil
+}
+
+var uploadNewCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Start a resumable upload.",
+		ShortDescription: `
+'ipfs upload new' starts the upload of a file of the given length in bytes,
+and returns its ID. The options of the add of the file are given here, they
+work as the ones of 'ipfs add'.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("length", true, false, "Length of the file in bytes."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("name", "n", "Name of the file, kept when wrapped with a directory."),
+		cmdkit.BoolOption(wrapOptionName, "w", "Wrap the file with a directory object."),
+		cmdkit.StringOption(chunkerOptionName, "s", "Chunking algorithm, size-[bytes], rabin-[min]-[avg]-[max] or buzhash-[min]-[avg]-[max]. Default: Import.Chunker or size-262144."),
+		cmdkit.BoolOption(pinOptionName, "Pin the file once added.").WithDefault(true),
+		cmdkit.BoolOption(rawLeavesOptionName, "Use raw blocks for leaf nodes. Default: Import.RawLeaves, or true with CIDv1."),
+		cmdkit.IntOption(cidVersionOptionName, "CID version. Defaults to Import.CidVersion, or 0 unless an option that depends on CIDv1 is passed."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		length, err := strconv.ParseInt(req.Arguments[0], 10, 64)
+		if err != nil || length < 0 {
+			return cmdkit.Errorf(cmdkit.ErrClient, "invalid length %q", req.Arguments[0])
+		}
+
+		opts := coreunix.UploadOptions{}
+		opts.Name, _ = req.Options["name"].(string)
+		opts.Wrap, _ = req.Options[wrapOptionName].(bool)
+		opts.Pin, _ = req.Options[pinOptionName].(bool)
+		cidVersion, cidVersionSet := req.Options[cidVersionOptionName].(int)
+		rawLeaves, rawLeavesSet := req.Options[rawLeavesOptionName].(bool)
+		if !cidVersionSet {
+			if cidVersion, _, err = coreunix.ConfiguredCidVersion(n.Repo); err != nil {
+				return err
+			}
+		}
+		if !rawLeavesSet {
+			if rawLeaves, rawLeavesSet, err = coreunix.ConfiguredRawLeaves(n.Repo); err != nil {
+				return err
+			}
+		}
+		opts.CidVersion = cidVersion
+		if opts.CidVersion < 0 || opts.CidVersion > 1 {
+			return cmdkit.Errorf(cmdkit.ErrClient, "invalid CID version %d", opts.CidVersion)
+		}
+		// cidV1 -> raw blocks (by default)
+		opts.RawLeaves = rawLeaves || (opts.CidVersion > 0 && !rawLeavesSet)
+		if chunker, ok := req.Options[chunkerOptionName].(string); ok {
+			if err := coreunix.ValidateChunker(chunker); err != nil {
+				return cmdkit.Errorf(cmdkit.ErrClient, "--%s: %s", chunkerOptionName, err)
+			}
+			opts.Chunker = chunker
+		}
+
+		store, err := uploadStore(env, n)
+		if err != nil {
+			return err
+		}
+		// the data of the uploads is kept out of the repo until they are
+		// added, the space they reserve counts in its quota
+		reserved, err := store.Reserved()
+		if err != nil {
+			return err
+		}
+		if err := corerepo.CheckStorageQuota(req.Context, n, uint64(reserved+length)); err != nil {
+			return err
+		}
+		st, err := store.Create(length, opts)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, st)
+	},
+	Type: coreunix.UploadStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: uploadStatusEncoder(),
+	},
+}
+
+var uploadAppendCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Append data to a resumable upload.",
+		ShortDescription: `
+'ipfs upload append' appends data to an upload, at the given offset which
+must be the number of bytes received so far. When the request fails, the
+bytes received before the failure are kept. Once all the bytes of the file
+are received, it is added and its hash returned. If the add fails, appending
+no data at the end of the upload adds it again.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", true, false, "ID of the upload."),
+		cmdkit.StringArg("offset", true, false, "Number of bytes received so far."),
+		cmdkit.FileArg("data", true, false, "Data to append.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		offset, err := strconv.ParseInt(req.Arguments[1], 10, 64)
+		if err != nil || offset < 0 {
+			return cmdkit.Errorf(cmdkit.ErrClient, "invalid offset %q", req.Arguments[1])
+		}
+		file, err := req.Files.NextFile()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		store, err := uploadStore(env, n)
+		if err != nil {
+			return err
+		}
+		st, err := store.Append(req.Arguments[0], offset, file, func(r io.Reader, opts coreunix.UploadOptions) (string, error) {
+			return coreunix.AddUpload(req.Context, n, r, opts)
+		})
+		if err != nil {
+			if st != nil {
+				return fmt.Errorf("%s, %d/%d bytes received", err, st.Offset, st.Length)
+			}
+			return err
+		}
+		return cmds.EmitOnce(res, st)
+	},
+	Type: coreunix.UploadStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: uploadStatusEncoder(),
+	},
+}
+
+var uploadStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the number of bytes received by a resumable upload.",
+		ShortDescription: `
+'ipfs upload status' shows the number of bytes received by an upload, the
+offset the next append starts at, and the hash of the file once added.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", true, false, "ID of the upload."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		store, err := uploadStore(env, n)
+		if err != nil {
+			return err
+		}
+		st, err := store.Status(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, st)
+	},
+	Type: coreunix.UploadStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: uploadStatusEncoder(),
+	},
+}
+
+var uploadLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the resumable uploads.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		store, err := uploadStore(env, n)
+		if err != nil {
+			return err
+		}
+		list, err := store.List()
+		if err != nil {
+			return err
+		}
+		if list == nil {
+			list = []*coreunix.UploadStatus{}
+		}
+		return cmds.EmitOnce(res, &uploadList{Uploads: list})
+	},
+	Type: uploadList{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			list, ok := v.(*uploadList)
+			if !ok {
+				return e.TypeErr(list, v)
+			}
+			for _, st := range list.Uploads {
+				writeUploadStatus(w, st)
+			}
+			return nil
+		}),
+	},
+}
+
+var uploadRmCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Remove a resumable upload.",
+		ShortDescription: `
+'ipfs upload rm' removes an upload and the bytes it received. The file of a
+complete upload stays in the repo.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", true, false, "ID of the upload."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		store, err := uploadStore(env, n)
+		if err != nil {
+			return err
+		}
+		return store.Remove(req.Arguments[0])
+	},
+}
+
+func writeUploadStatus(w io.Writer, st *coreunix.UploadStatus) {
+	fmt.Fprintf(w, "%s %d/%d", st.ID, st.Offset, st.Length)
+	if st.Hash != "" {
+		fmt.Fprintf(w, " %s", st.Hash)
+	}
+	fmt.Fprintln(w)
+}
+
+func uploadStatusEncoder() cmds.EncoderFunc {
+	return cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+		st, ok := v.(*coreunix.UploadStatus)
+		if !ok {
+			return e.TypeErr(st, v)
+		}
+		writeUploadStatus(w, st)
+		return nil
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	features "github.com/ipfs/go-ipfs/core/features"
 	filestore "github.com/ipfs/go-ipfs/filestore"
 
 	balanced "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/balanced"
@@ -63,15 +64,10 @@ time.
 			return fmt.Errorf("unsupported url syntax: %s", url)
 		}
 
-		cfg, err := n.Repo.Config()
-		if err != nil {
+		if err := features.Require(n.Repo, features.Urlstore); err != nil {
 			return err
 		}
 
-		if !cfg.Experimental.UrlstoreEnabled {
-			return filestore.ErrUrlstoreNotEnabled
-		}
-
 		useTrickledag, _ := req.Options[trickleOptionName].(bool)
 
 		hreq, err := http.NewRequest("GET", url, nil)
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	connsec "gx/ipfs/QmXD1SJbD7QSZS3L2F7ASGU8NnuT4QxdKpqpnFMHzsrrJL/go-conn-security"
+	smux "gx/ipfs/QmY9JXR3FupnYAYJWK9aMr9bCpqWKcToQ1tz8DVGTrHpHw/go-stream-muxer"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+)
+
+const (
+	// secioID is the protocol of the secio security transport.
+	secioID = "/secio/1.0.0"
+	// quicID is the security and the muxer of the QUIC connections, built
+	// into QUIC.
+	quicID = "quic"
+)
+
+// ConnState is what was negotiated for a connection of the swarm.
+type ConnState struct {
+	// Transport is the network transport of Swarm.Transports.Network.
+	Transport string
+	// Security and Muxer are empty when the connection couldn't be told
+	// apart from another connection to the peer.
+	Security string
+	Muxer    string
+	Relayed  bool
+}
+
+// ConnState returns what was negotiated for c.
+func (n *IpfsNode) ConnState(c inet.Conn) ConnState {
+	addr := c.RemoteMultiaddr()
+	st := ConnState{Transport: networkTransport(addr)}
+	st.Relayed = st.Transport == relayTransport
+	if st.Transport == quicTransport {
+		st.Security = quicID
+		st.Muxer = quicID
+		return st
+	}
+
+	// the other connections are upgraded with a security transport and a
+	// muxer
+	if n.muxedConns != nil {
+		st.Security, st.Muxer = n.muxedConns.upgrades(c)
+	}
+	return st
+}
+
+// muxedConns records the security transports and the muxers negotiated for
+// the connections of the swarm, which the swarm doesn't tell.
+type muxedConns struct {
+	mu    sync.Mutex
+	conns map[peer.ID][]*muxedConn
+}
+
+func newMuxedConns() *muxedConns {
+	return &muxedConns{conns: make(map[peer.ID][]*muxedConn)}
+}
+
+// transport returns tpt, recording the connections it multiplexes as
+// multiplexed by the muxer id.
+func (m *muxedConns) transport(id string, tpt smux.Transport) smux.Transport {
+	return &recordingMuxer{Transport: tpt, id: id, conns: m}
+}
+
+// secure returns tpt, marking the connections it secures as secured by the
+// security transport id, for the muxers to record it.
+func (m *muxedConns) secure(id string, tpt connsec.Transport) connsec.Transport {
+	return &markingSecurity{Transport: tpt, id: id}
+}
+
+// upgrades returns the security transport and the muxer of c, when it is the
+// only connection to its peer or its remote address tells it apart from the
+// others.
+func (m *muxedConns) upgrades(c inet.Conn) (security, muxer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conns := m.conns[c.RemotePeer()]
+	if len(conns) == 1 {
+		return conns[0].security, conns[0].muxer
+	}
+	remote := c.RemoteMultiaddr().String()
+	if na, err := manet.ToNetAddr(c.RemoteMultiaddr()); err == nil {
+		remote = na.String()
+	}
+	for _, mc := range conns {
+		if mc.remote == remote {
+			return mc.security, mc.muxer
+		}
+	}
+	return "", ""
+}
+
+func (m *muxedConns) add(p peer.ID, mc *muxedConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conns[p] = append(m.conns[p], mc)
+}
+
+func (m *muxedConns) remove(p peer.ID, mc *muxedConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conns := m.conns[p]
+	for i, c := range conns {
+		if c == mc {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(m.conns, p)
+	} else {
+		m.conns[p] = conns
+	}
+}
+
+type markingSecurity struct {
+	connsec.Transport
+	id string
+}
+
+func (t *markingSecurity) SecureInbound(ctx context.Context, insecure net.Conn) (connsec.Conn, error) {
+	c, err := t.Transport.SecureInbound(ctx, insecure)
+	if err != nil {
+		return nil, err
+	}
+	return &securedConn{Conn: c, security: t.id}, nil
+}
+
+func (t *markingSecurity) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (connsec.Conn, error) {
+	c, err := t.Transport.SecureOutbound(ctx, insecure, p)
+	if err != nil {
+		return nil, err
+	}
+	return &securedConn{Conn: c, security: t.id}, nil
+}
+
+// securedConn is a connection secured by the security transport security.
+type securedConn struct {
+	connsec.Conn
+	security string
+}
+
+type recordingMuxer struct {
+	smux.Transport
+	id    string
+	conns *muxedConns
+}
+
+func (t *recordingMuxer) NewConn(nc net.Conn, isServer bool) (smux.Conn, error) {
+	c, err := t.Transport.NewConn(nc, isServer)
+	if err != nil {
+		return nil, err
+	}
+	// the secured connections know their peer
+	sc, ok := nc.(interface{ RemotePeer() peer.ID })
+	if !ok {
+		return c, nil
+	}
+	mc := &muxedConn{Conn: c, muxer: t.id, remote: nc.RemoteAddr().String(), peer: sc.RemotePeer(), conns: t.conns}
+	if sec, ok := nc.(*securedConn); ok {
+		mc.security = sec.security
+	}
+	t.conns.add(mc.peer, mc)
+	return mc, nil
+}
+
+type muxedConn struct {
+	smux.Conn
+	security string
+	muxer    string
+	remote   string
+	peer     peer.ID
+	conns    *muxedConns
+	once     sync.Once
+}
+
+func (c *muxedConn) Close() error {
+	c.once.Do(func() {
+		c.conns.remove(c.peer, c)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	noise "github.com/ipfs/go-ipfs/p2p/noise"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	connsec "gx/ipfs/QmXD1SJbD7QSZS3L2F7ASGU8NnuT4QxdKpqpnFMHzsrrJL/go-conn-security"
+	smux "gx/ipfs/QmY9JXR3FupnYAYJWK9aMr9bCpqWKcToQ1tz8DVGTrHpHw/go-stream-muxer"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+)
+
+type testSecureConn struct {
+	connsec.Conn
+	remote net.Addr
+	peer   peer.ID
+}
+
+func (c *testSecureConn) RemoteAddr() net.Addr { return c.remote }
+func (c *testSecureConn) RemotePeer() peer.ID  { return c.peer }
+
+// testSecurity secures the connections with the testSecureConn of conn.
+type testSecurity struct {
+	conn *testSecureConn
+}
+
+func (t testSecurity) SecureInbound(ctx context.Context, insecure net.Conn) (connsec.Conn, error) {
+	return t.conn, nil
+}
+
+func (t testSecurity) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (connsec.Conn, error) {
+	return t.conn, nil
+}
+
+type testMuxer struct{}
+
+func (testMuxer) NewConn(nc net.Conn, isServer bool) (smux.Conn, error) {
+	return &testMuxedConn{}, nil
+}
+
+type testMuxedConn struct {
+	smux.Conn
+}
+
+func (*testMuxedConn) Close() error { return nil }
+
+type testSwarmConn struct {
+	inet.Conn
+	peer   peer.ID
+	remote ma.Multiaddr
+}
+
+func (c *testSwarmConn) RemotePeer() peer.ID           { return c.peer }
+func (c *testSwarmConn) RemoteMultiaddr() ma.Multiaddr { return c.remote }
+
+func TestConnState(t *testing.T) {
+	conns := newMuxedConns()
+	yamux := conns.transport(yamuxID, testMuxer{})
+	mplex := conns.transport(mplexID, testMuxer{})
+	n := &IpfsNode{muxedConns: conns}
+
+	p := peer.ID("peer")
+	secio := conns.secure(secioID, testSecurity{&testSecureConn{remote: &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4001}, peer: p}})
+	sc, err := secio.SecureOutbound(context.Background(), nil, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, err := yamux.NewConn(sc, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := &testSwarmConn{peer: p, remote: mustAddrs(t, "/ip4/1.2.3.4/tcp/4001")[0]}
+	st := n.ConnState(tcp)
+	if st != (ConnState{Transport: tcpTransport, Security: secioID, Muxer: yamuxID}) {
+		t.Fatalf("unexpected state %+v", st)
+	}
+
+	// the second connection is told apart by its address
+	noiseTpt := conns.secure(noise.ID, testSecurity{&testSecureConn{remote: &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4002}, peer: p}})
+	sc, err = noiseTpt.SecureInbound(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := mplex.NewConn(sc, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp2 := &testSwarmConn{peer: p, remote: mustAddrs(t, "/ip4/1.2.3.4/tcp/4002")[0]}
+	if st := n.ConnState(tcp); st.Security != secioID || st.Muxer != yamuxID {
+		t.Fatalf("unexpected state %+v", st)
+	}
+	if st := n.ConnState(tcp2); st.Security != noise.ID || st.Muxer != mplexID {
+		t.Fatalf("unexpected state %+v", st)
+	}
+	unknown := &testSwarmConn{peer: p, remote: mustAddrs(t, "/ip4/1.2.3.4/tcp/4003")[0]}
+	if st := n.ConnState(unknown); st.Security != "" || st.Muxer != "" {
+		t.Fatalf("expected no security and no muxer, got %+v", st)
+	}
+
+	c2.Close()
+	if st := n.ConnState(unknown); st.Security != secioID || st.Muxer != yamuxID {
+		t.Fatalf("unexpected state %+v", st)
+	}
+	c1.Close()
+	if len(conns.conns) != 0 {
+		t.Fatalf("expected the closed connections to be removed, got %v", conns.conns)
+	}
+
+	quic := &testSwarmConn{peer: p, remote: mustAddrs(t, "/ip4/1.2.3.4/udp/4001/quic")[0]}
+	if st := n.ConnState(quic); st.Security != quicID || st.Muxer != quicID || st.Relayed {
+		t.Fatalf("unexpected state %+v", st)
+	}
+	relayed := &testSwarmConn{peer: p, remote: mustAddrs(t, "/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit")[0]}
+	if st := n.ConnState(relayed); !st.Relayed {
+		t.Fatalf("expected the connection to be relayed, got %+v", st)
+	}
+}
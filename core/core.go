@@ -18,17 +18,38 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	version "github.com/ipfs/go-ipfs"
+	features "github.com/ipfs/go-ipfs/core/features"
+	availability "github.com/ipfs/go-ipfs/exchange/availability"
+	peerrank "github.com/ipfs/go-ipfs/exchange/peerrank"
 	rp "github.com/ipfs/go-ipfs/exchange/reprovide"
+	servestats "github.com/ipfs/go-ipfs/exchange/servestats"
 	filestore "github.com/ipfs/go-ipfs/filestore"
 	mount "github.com/ipfs/go-ipfs/fuse/mount"
 	namesys "github.com/ipfs/go-ipfs/namesys"
 	ipnsrp "github.com/ipfs/go-ipfs/namesys/republisher"
 	p2p "github.com/ipfs/go-ipfs/p2p"
+	composite "github.com/ipfs/go-ipfs/p2p/composite"
+	fullrt "github.com/ipfs/go-ipfs/p2p/fullrt"
+	holepunch "github.com/ipfs/go-ipfs/p2p/holepunch"
+	libp2ptls "github.com/ipfs/go-ipfs/p2p/libp2ptls"
+	noise "github.com/ipfs/go-ipfs/p2p/noise"
+	peering "github.com/ipfs/go-ipfs/p2p/peering"
+	pex "github.com/ipfs/go-ipfs/p2p/pex"
+	qos "github.com/ipfs/go-ipfs/p2p/qos"
+	rcmgr "github.com/ipfs/go-ipfs/p2p/rcmgr"
+	relay "github.com/ipfs/go-ipfs/p2p/relay"
+	rendezvous "github.com/ipfs/go-ipfs/p2p/rendezvous"
+	reputation "github.com/ipfs/go-ipfs/p2p/reputation"
+	staticrouting "github.com/ipfs/go-ipfs/p2p/staticrouting"
+	wss "github.com/ipfs/go-ipfs/p2p/wss"
 	pin "github.com/ipfs/go-ipfs/pin"
 	repo "github.com/ipfs/go-ipfs/repo"
+	journal "github.com/ipfs/go-ipfs/repo/journal"
+	search "github.com/ipfs/go-ipfs/search"
 
 	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
 	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
@@ -37,6 +58,7 @@ import (
 	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
 	exchange "gx/ipfs/QmR1nncPsZR14A4hWr39mq8Lm7BGgS68bHVT9nop8NpWEM/go-ipfs-exchange-interface"
 	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	secio "gx/ipfs/QmReYSQGHjf28pKf93FwyD72mLXoZo94MB2Cq6VBSUHvFB/go-libp2p-secio"
 	quic "gx/ipfs/QmRgFWjY1idcimoGvSAzNenfptnciZnPHuW3P2yq9VgaqA/go-libp2p-quic-transport"
 	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
 	goprocess "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
@@ -58,6 +80,7 @@ import (
 	ifconnmgr "gx/ipfs/QmWGGN1nysi1qgqto31bENwESkmZBY4YGK4sZC3qhnqhSv/go-libp2p-interface-connmgr"
 	circuit "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit"
 	"gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path/resolver"
+	connsec "gx/ipfs/QmXD1SJbD7QSZS3L2F7ASGU8NnuT4QxdKpqpnFMHzsrrJL/go-conn-security"
 	merkledag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
 	floodsub "gx/ipfs/QmY1L5krVk8dv8d74uESmJTXGpoigVYqBVxXXz1aS8aFSb/go-libp2p-floodsub"
 	smux "gx/ipfs/QmY9JXR3FupnYAYJWK9aMr9bCpqWKcToQ1tz8DVGTrHpHw/go-stream-muxer"
@@ -122,9 +145,17 @@ type IpfsNode struct {
 	Blocks          bserv.BlockService   // the block service, get/add blocks.
 	DAG             ipld.DAGService      // the merkle dag service, get/add objects.
 	Resolver        *resolver.Resolver   // the path resolution system
+	PinJobs         *PinJobs             // the pins running in the background
+	FetchQueue      *FetchQueue          // the turns of the pins fetching their DAGs
 	Reporter        metrics.Reporter
 	Discovery       discovery.Service
-	FilesRoot       *mfs.Root
+	FilesRoot       *mfs.Root // replaced by ReshardFiles, see LockFilesRoot
+	FilesWriteBack  *FilesWriteBack
+	FilesEvents     *FilesNotifier
+	FilesMirrors    *FilesMirrors    // the mfs paths mirroring other nodes
+	Journal         *journal.Journal // journals the pin set and files root updates, if enabled
+	Sharding        *ShardingPolicy  // when to shard unixfs directories
+	Search          *search.Index    // the local search index, if Search.Enabled
 	RecordValidator record.Validator
 
 	// Online
@@ -135,13 +166,40 @@ type IpfsNode struct {
 	Namesys      namesys.NameSystem  // the name system, resolves paths to hashes
 	Ping         *ping.PingService
 	Reprovider   *rp.Reprovider // the value reprovider system
+	ProvideQueue *rp.Queue      // the new blocks waiting to be provided
+	Availability *availability.Prober
+	PeerRank     *peerrank.Tracker
+	ServeStats   *servestats.Tracker // the blocks served with bitswap
 	IpnsRepub    *ipnsrp.Republisher
 
 	Floodsub *floodsub.PubSub
 	PSRouter *psrouter.PubsubValueStore
 	DHT      *dht.IpfsDHT
+	FullRT   *fullrt.Router // the accelerated DHT client, if enabled
 	P2P      *p2p.P2P
 
+	StaticRouting *staticrouting.Router
+
+	Rendezvous       *rendezvous.Service
+	RendezvousServer *rendezvous.Server
+	Peering          *peering.Service
+	PeerExchange     *pex.Service
+	ResourceMgr      *rcmgr.Manager
+	QoS              *qos.Scheduler
+	Reputation       *reputation.Service
+	RelayService     *relay.Service
+	AutoRelay        *relay.AutoRelay
+	HolePunch        *holepunch.Service
+	SecureWebSocket  *wss.Front // the TLS listeners of the /wss addresses
+
+	muxedConns *muxedConns  // the muxers of the connections, see ConnState
+	dhtMode    *dhtModeHost // the host of the routing system, see DHTMode
+
+	lightClient bool // see LightClient
+
+	filesPublish func(context.Context, cid.Cid) error // stores the files root, see ReshardFiles
+	filesRootLk  sync.RWMutex                         // held to read FilesRoot, see LockFilesRoot
+
 	proc goprocess.Process
 	ctx  context.Context
 
@@ -173,12 +231,36 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 		return err
 	}
 
-	var libp2pOpts []libp2p.Option
-	for _, s := range cfg.Swarm.AddrFilters {
-		f, err := mamask.NewMask(s)
+	connMgrCfg := cfg.Swarm.ConnMgr
+	n.lightClient, err = features.Enabled(n.Repo, features.LightClient)
+	if err != nil {
+		return err
+	}
+	if n.lightClient {
+		delegates, err := LightClientDelegates(n.Repo)
 		if err != nil {
-			return fmt.Errorf("incorrectly formatted address filter in config: %s", s)
+			return fmt.Errorf("invalid %s config: %s", DelegatesKey, err)
 		}
+		if len(delegates) == 0 {
+			log.Warningf("light client without %s, the content is only found on the connected peers", DelegatesKey)
+			routingOption = NilRouterOption
+		} else {
+			routingOption = DelegatedRoutingOption(delegates)
+		}
+		connMgrCfg = lightClientConnMgr(connMgrCfg)
+	}
+
+	defaultDeny, err := AddrFiltersDefaultDeny(n.Repo)
+	if err != nil {
+		return err
+	}
+	masks, err := AddrFilterMasks(cfg.Swarm.AddrFilters, defaultDeny)
+	if err != nil {
+		return fmt.Errorf("%s in config", err)
+	}
+
+	var libp2pOpts []libp2p.Option
+	for _, f := range masks {
 		libp2pOpts = append(libp2pOpts, libp2p.FilterAddresses(f))
 	}
 
@@ -192,6 +274,9 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 	if err != nil {
 		return err
 	}
+	if err := checkForcePNet(swarmkey); err != nil {
+		return err
+	}
 
 	if swarmkey != nil {
 		protec, err := pnet.NewProtector(bytes.NewReader(swarmkey))
@@ -221,29 +306,80 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 		libp2pOpts = append(libp2pOpts, libp2p.PrivateNetwork(protec))
 	}
 
+	transports, err := ReadTransports(n.Repo, cfg, mplex)
+	if err != nil {
+		return err
+	}
+	// Swarm.Transports.Network.Relay overrides Swarm.DisableRelay
+	swarmCfg := *cfg
+	swarmCfg.Swarm.DisableRelay = !transports.enabled(relayTransport)
+	cfg = &swarmCfg
+
+	relayService, relayClient, err := readRelayConfig(n.Repo, cfg)
+	if err != nil {
+		return err
+	}
+	holePunching, err := readHolePunchingConfig(n.Repo, cfg)
+	if err != nil {
+		return err
+	}
+
+	listenAddrs, err := listenAddresses(cfg)
+	if err != nil {
+		return err
+	}
+	if transports.enabled(quicTransport) {
+		listenAddrs = append(listenAddrs, quicListenAddrs(listenAddrs)...)
+	}
+	// the addresses of the disabled transports aren't listened on
+	listenAddrs = transports.filterAddrs(listenAddrs)
+	n.SecureWebSocket, err = newSecureWebSocket(n.Repo, listenAddrs)
+	if err != nil {
+		return err
+	}
+
 	addrsFactory, err := makeAddrsFactory(cfg.Addresses)
 	if err != nil {
 		return err
 	}
+	if n.SecureWebSocket != nil {
+		// the /wss addresses are announced and filtered as the others
+		addrsFactory = composeAddrsFactory(addrsFactory, n.SecureWebSocket.AddrsFactory)
+	}
 	if !cfg.Swarm.DisableRelay {
 		addrsFactory = composeAddrsFactory(addrsFactory, filterRelayAddrs)
 	}
+	if relayClient.Enabled {
+		n.AutoRelay, err = relay.NewAutoRelay(relayClient)
+		if err != nil {
+			return fmt.Errorf("invalid Swarm.RelayClient config: %s", err)
+		}
+		// the relayed addresses are added after the others are filtered
+		addrsFactory = composeAddrsFactory(n.AutoRelay.AddrsFactory, addrsFactory)
+	}
 	libp2pOpts = append(libp2pOpts, libp2p.AddrsFactory(addrsFactory))
 
-	connm, err := constructConnMgr(cfg.Swarm.ConnMgr)
+	connm, err := constructConnMgr(connMgrCfg)
 	if err != nil {
 		return err
 	}
 	libp2pOpts = append(libp2pOpts, libp2p.ConnectionManager(connm))
 
-	libp2pOpts = append(libp2pOpts, makeSmuxTransportOption(mplex))
+	n.muxedConns = newMuxedConns()
+	securityOpt, err := makeSecurityOption(transports.security, n.PrivateKey, n.muxedConns)
+	if err != nil {
+		return err
+	}
+	libp2pOpts = append(libp2pOpts, securityOpt)
+	libp2pOpts = append(libp2pOpts, makeSmuxTransportOption(transports.muxers, n.muxedConns))
 
 	if !cfg.Swarm.DisableNatPortMap {
 		libp2pOpts = append(libp2pOpts, libp2p.NATPortMap())
 	}
 	if !cfg.Swarm.DisableRelay {
 		var opts []circuit.RelayOpt
-		if cfg.Swarm.EnableRelayHop {
+		// the relay service replaces the unlimited relay
+		if cfg.Swarm.EnableRelayHop && !relayService.Enabled {
 			opts = append(opts, circuit.OptHop)
 		}
 		libp2pOpts = append(libp2pOpts, libp2p.EnableRelay(opts...))
@@ -255,12 +391,29 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 	// explicitly enable the default transports
 	libp2pOpts = append(libp2pOpts, libp2p.DefaultTransports)
 
-	if cfg.Experimental.QUIC {
+	if transports.enabled(quicTransport) {
+		// the QUIC transport isn't wrapped by the protector, it would bypass
+		// the private network
+		if swarmkey != nil {
+			return fmt.Errorf("the QUIC transport doesn't support private networks, disable it with %s", QUICTransportKey)
+		}
 		libp2pOpts = append(libp2pOpts, libp2p.Transport(quic.NewTransport))
 	}
+	ps := dialPriorityPeerstore{Peerstore: n.Peerstore, transports: transports}
+
+	peerhost, err := hostOption(ctx, n.Identity, ps, libp2pOpts...)
+
+	if err != nil {
+		return err
+	}
+	peerhost = dialHost{Host: peerhost, ps: n.Peerstore}
 
-	peerhost, err := hostOption(ctx, n.Identity, n.Peerstore, libp2pOpts...)
+	peerhost, err = n.startResourceMgr(peerhost)
+	if err != nil {
+		return err
+	}
 
+	peerhost, err = n.startQoS(peerhost)
 	if err != nil {
 		return err
 	}
@@ -270,12 +423,38 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 	}
 
 	// Ok, now we're ready to listen.
-	if err := startListening(n.PeerHost, cfg); err != nil {
+	if err := startListening(n.PeerHost, listenAddrs, n.SecureWebSocket); err != nil {
 		return err
 	}
 
 	n.P2P = p2p.NewP2P(n.Identity, n.PeerHost, n.Peerstore)
 
+	if n.dhtMode.auto {
+		n.dhtMode.check()
+		go n.dhtMode.run(n.Process().Closing())
+	}
+
+	if err := n.startRendezvous(ctx); err != nil {
+		return err
+	}
+
+	if err := n.startPeering(); err != nil {
+		return err
+	}
+
+	if err := n.startPeerExchange(connMgrCfg); err != nil {
+		return err
+	}
+
+	if err := n.startRelay(relayService); err != nil {
+		return err
+	}
+
+	if holePunching {
+		n.HolePunch = holepunch.NewService(n.PeerHost)
+		n.HolePunch.Start()
+	}
+
 	// setup local discovery
 	if do != nil {
 		service, err := do(ctx, n.PeerHost)
@@ -287,9 +466,234 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 		}
 	}
 
+	if n.StaticRouting != nil && n.DHT == nil {
+		// the public bootstrap peers are never dialed with static routing
+		cfg := DefaultBootstrapConfig
+		cfg.BootstrapPeers = n.StaticRouting.Peers
+		return n.Bootstrap(cfg)
+	}
 	return n.Bootstrap(DefaultBootstrapConfig)
 }
 
+// startRendezvous sets up the rendezvous point and the registration in the
+// namespaces of the Rendezvous config.
+func (n *IpfsNode) startRendezvous(ctx context.Context) error {
+	var cfg rendezvous.Config
+	if err := repo.ConfigSection(n.Repo, "Rendezvous", &cfg); err != nil {
+		return err
+	}
+
+	svc, err := rendezvous.NewService(n.PeerHost, cfg)
+	if err != nil {
+		return fmt.Errorf("invalid Rendezvous config: %s", err)
+	}
+	n.Rendezvous = svc
+
+	if cfg.EnableServer {
+		n.RendezvousServer = rendezvous.NewServer(n.PeerHost)
+	}
+	if len(cfg.Namespaces) > 0 && len(svc.Points()) > 0 {
+		go svc.Run(ctx)
+	}
+	return nil
+}
+
+// startResourceMgr starts enforcing the limits of the Swarm.ResourceMgr
+// config on h, returning the host the services must use for the protocol
+// limits to apply.
+func (n *IpfsNode) startResourceMgr(h p2phost.Host) (p2phost.Host, error) {
+	rcfg := rcmgr.DefaultConfig()
+	if err := repo.ConfigSection(n.Repo, "Swarm.ResourceMgr", &rcfg); err != nil {
+		return nil, fmt.Errorf("invalid Swarm.ResourceMgr config: %s", err)
+	}
+	if !rcfg.Enabled {
+		return h, nil
+	}
+
+	n.ResourceMgr = rcmgr.New(rcfg.Limits)
+	n.ResourceMgr.Start(h)
+	return n.ResourceMgr.Wrap(h), nil
+}
+
+// startQoS schedules the writes to the streams of h by their traffic class,
+// following the Swarm.QoS config, returning the host the services must use.
+func (n *IpfsNode) startQoS(h p2phost.Host) (p2phost.Host, error) {
+	qcfg := qos.DefaultConfig()
+	if err := repo.ConfigSection(n.Repo, "Swarm.QoS", &qcfg); err != nil {
+		return nil, fmt.Errorf("invalid Swarm.QoS config: %s", err)
+	}
+	if !qcfg.Enabled {
+		return h, nil
+	}
+	if err := qcfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Swarm.QoS config: %s", err)
+	}
+
+	n.QoS = qos.NewScheduler(qcfg)
+	return n.QoS.Wrap(h), nil
+}
+
+// startProvideQueue starts providing the new blocks in the background,
+// following the Provider config section. The blocks not provided before the
+// node stops are provided after the next start.
+func (n *IpfsNode) startProvideQueue(ctx context.Context) error {
+	qcfg := rp.DefaultQueueConfig()
+	if err := repo.ConfigSection(n.Repo, "Provider", &qcfg); err != nil {
+		return fmt.Errorf("invalid Provider config: %s", err)
+	}
+	if err := qcfg.Validate(); err != nil {
+		return fmt.Errorf("invalid Provider config: %s", err)
+	}
+
+	q, err := rp.NewQueue(ctx, n.Routing, n.Repo.Datastore(), qcfg)
+	if err != nil {
+		return err
+	}
+	n.ProvideQueue = q
+	go q.Run()
+	return nil
+}
+
+// ProvideNewPin announces c, the root of a new pin, ahead of the other new
+// blocks. It does nothing if the node doesn't provide its blocks.
+func (n *IpfsNode) ProvideNewPin(c cid.Cid) {
+	n.ProvidePin(c, FetchPriorityNormal)
+}
+
+// ProvidePin announces c, the root of a new pin with priority prio. The
+// roots of the pins are announced ahead of the other new blocks, unless
+// their priority is low.
+func (n *IpfsNode) ProvidePin(c cid.Cid, prio FetchPriority) {
+	if n.ProvideQueue == nil {
+		return
+	}
+	qprio := rp.PriorityHigh
+	if prio == FetchPriorityLow {
+		qprio = rp.PriorityLow
+	}
+	if err := n.ProvideQueue.Enqueue(c, qprio); err != nil {
+		log.Errorf("providing the new pin %s: %s", c, err)
+	}
+}
+
+// EnqueueProvide queues c to be announced in the background with priority
+// prio.
+func (n *IpfsNode) EnqueueProvide(c cid.Cid, prio FetchPriority) error {
+	if n.ProvideQueue == nil {
+		return errors.New("the node doesn't provide its blocks")
+	}
+	return n.ProvideQueue.Enqueue(c, prio.provide())
+}
+
+// readRelayConfig reads the Swarm.RelayService and Swarm.RelayClient config
+// sections.
+func readRelayConfig(r repo.Repo, cfg *config.Config) (relay.ServiceConfig, relay.ClientConfig, error) {
+	scfg := relay.DefaultServiceConfig()
+	if err := repo.ConfigSection(r, "Swarm.RelayService", &scfg); err != nil {
+		return scfg, relay.ClientConfig{}, fmt.Errorf("invalid Swarm.RelayService config: %s", err)
+	}
+	ccfg := relay.DefaultClientConfig()
+	if err := repo.ConfigSection(r, "Swarm.RelayClient", &ccfg); err != nil {
+		return scfg, ccfg, fmt.Errorf("invalid Swarm.RelayClient config: %s", err)
+	}
+
+	if scfg.Enabled && cfg.Swarm.EnableRelayHop {
+		log.Warning("Swarm.EnableRelayHop is ignored, the limited relay of Swarm.RelayService is used instead")
+	}
+	if ccfg.Enabled {
+		if cfg.Swarm.DisableRelay {
+			return scfg, ccfg, errors.New("Swarm.RelayClient needs the relay transport, disabled by Swarm.DisableRelay or Swarm.Transports.Network.Relay")
+		}
+		// the relay service handles the relay protocol, the relayed
+		// connections can't be accepted
+		if scfg.Enabled {
+			return scfg, ccfg, errors.New("Swarm.RelayClient and Swarm.RelayService cannot be both enabled")
+		}
+	}
+	return scfg, ccfg, nil
+}
+
+// readHolePunchingConfig reads whether the hole punching of
+// Swarm.EnableHolePunching is enabled, which needs the relay transport.
+func readHolePunchingConfig(r repo.Repo, cfg *config.Config) (bool, error) {
+	var enabled bool
+	if err := repo.ConfigSection(r, "Swarm.EnableHolePunching", &enabled); err != nil {
+		return false, fmt.Errorf("invalid Swarm.EnableHolePunching config: %s", err)
+	}
+	if enabled && cfg.Swarm.DisableRelay {
+		return false, errors.New("Swarm.EnableHolePunching needs the relay transport, disabled by Swarm.DisableRelay or Swarm.Transports.Network.Relay")
+	}
+	return enabled, nil
+}
+
+// startRelay starts the relay service and the auto-relay client, if enabled.
+func (n *IpfsNode) startRelay(cfg relay.ServiceConfig) error {
+	if cfg.Enabled {
+		limits, err := relay.ParseLimits(cfg.Limits)
+		if err != nil {
+			return fmt.Errorf("invalid Swarm.RelayService config: %s", err)
+		}
+		n.RelayService = relay.NewService(n.PeerHost, limits)
+		n.RelayService.Start()
+	}
+	if n.AutoRelay != nil {
+		n.AutoRelay.Start(n.PeerHost)
+	}
+	return nil
+}
+
+// startPeering starts keeping the connections to the peers of the Peering
+// config.
+func (n *IpfsNode) startPeering() error {
+	var cfg peering.Config
+	if err := repo.ConfigSection(n.Repo, "Peering", &cfg); err != nil {
+		return err
+	}
+
+	peers, err := peering.ParseConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid Peering config: %s", err)
+	}
+
+	n.Peering = peering.NewService(n.PeerHost)
+	for _, pi := range peers {
+		if err := n.Peering.AddPeer(pi); err != nil {
+			return fmt.Errorf("invalid Peering config: %s", err)
+		}
+	}
+	n.Peering.Start()
+	return nil
+}
+
+// startPeerExchange starts the peer exchange if enabled by the
+// Swarm.PeerExchange config. The received peers are dialed until the low
+// water of the connection manager by default.
+func (n *IpfsNode) startPeerExchange(connMgrCfg config.ConnMgr) error {
+	cfg := pex.DefaultConfig()
+	if err := repo.ConfigSection(n.Repo, "Swarm.PeerExchange", &cfg); err != nil {
+		return fmt.Errorf("invalid Swarm.PeerExchange config: %s", err)
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.TargetPeers == 0 {
+		switch connMgrCfg.Type {
+		case "":
+			cfg.TargetPeers = config.DefaultConnMgrLowWater
+		case "basic":
+			cfg.TargetPeers = connMgrCfg.LowWater
+		}
+	}
+
+	svc, err := pex.NewService(n.PeerHost, cfg)
+	if err != nil {
+		return fmt.Errorf("invalid Swarm.PeerExchange config: %s", err)
+	}
+	n.PeerExchange = svc
+	n.PeerExchange.Start()
+	return nil
+}
+
 func constructConnMgr(cfg config.ConnMgr) (ifconnmgr.ConnManager, error) {
 	switch cfg.Type {
 	case "":
@@ -315,17 +719,49 @@ func (n *IpfsNode) startLateOnlineServices(ctx context.Context) error {
 		return err
 	}
 
+	// light clients don't announce their content
+	if !n.lightClient {
+		if err := n.startReprovider(ctx, cfg); err != nil {
+			return err
+		}
+	}
+
+	n.Availability = availability.NewProber(n.PeerHost, n.Routing, n.Repo.Datastore())
+	if err := n.Availability.Start(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (n *IpfsNode) startReprovider(ctx context.Context, cfg *config.Config) error {
 	var keyProvider rp.KeyChanFunc
 
+	filesRoot := func() (ipld.Node, error) {
+		root, unlock := n.LockFilesRoot()
+		defer unlock()
+		if root == nil {
+			return nil, errors.New("mfs is not loaded")
+		}
+		return root.GetDirectory().GetNode()
+	}
 	switch cfg.Reprovider.Strategy {
-	case "all":
-		fallthrough
-	case "":
+	case "all", "":
+		// The roots come first, they matter most if the reprovide of
+		// many blocks doesn't complete.
+		keyProvider = rp.NewPrioritizedProvider(
+			rp.NewPinnedProvider(n.Pinning, n.DAG, true),
+			rp.NewMFSProvider(filesRoot, n.DAG, true),
+			rp.NewBlockstoreProvider(n.Blockstore),
+		)
+	case "flat":
 		keyProvider = rp.NewBlockstoreProvider(n.Blockstore)
 	case "roots":
 		keyProvider = rp.NewPinnedProvider(n.Pinning, n.DAG, true)
 	case "pinned":
 		keyProvider = rp.NewPinnedProvider(n.Pinning, n.DAG, false)
+	case "mfs":
+		keyProvider = rp.NewMFSProvider(filesRoot, n.DAG, false)
 	default:
 		return fmt.Errorf("unknown reprovider strategy '%s'", cfg.Reprovider.Strategy)
 	}
@@ -392,10 +828,9 @@ func makeAddrsFactory(cfg config.Addresses) (p2pbhost.AddrsFactory, error) {
 	}, nil
 }
 
-func makeSmuxTransportOption(mplexExp bool) libp2p.Option {
-	const yamuxID = "/yamux/1.0.0"
-	const mplexID = "/mplex/6.7.0"
-
+// makeSmuxTransportOption enables the stream multiplexers of order, the
+// preferred first, recording the connections they multiplex in conns.
+func makeSmuxTransportOption(order []string, conns *muxedConns) libp2p.Option {
 	ymxtpt := &yamux.Transport{
 		AcceptBacklog:          512,
 		ConnectionWriteTimeout: time.Second * 10,
@@ -409,13 +844,17 @@ func makeSmuxTransportOption(mplexExp bool) libp2p.Option {
 		ymxtpt.LogOutput = os.Stderr
 	}
 
-	muxers := map[string]smux.Transport{yamuxID: ymxtpt}
-	if mplexExp {
-		muxers[mplexID] = mplex.DefaultTransport
+	muxers := make(map[string]smux.Transport)
+	for _, id := range order {
+		switch id {
+		case yamuxID:
+			muxers[id] = ymxtpt
+		case mplexID:
+			muxers[id] = mplex.DefaultTransport
+		}
 	}
 
 	// Allow muxer preference order overriding
-	order := []string{yamuxID, mplexID}
 	if prefs := os.Getenv("LIBP2P_MUX_PREFS"); prefs != "" {
 		order = strings.Fields(prefs)
 	}
@@ -424,26 +863,37 @@ func makeSmuxTransportOption(mplexExp bool) libp2p.Option {
 	for _, id := range order {
 		tpt, ok := muxers[id]
 		if !ok {
-			log.Warning("unknown or duplicate muxer in LIBP2P_MUX_PREFS: %s", id)
+			log.Warning("unknown, disabled or duplicate muxer in LIBP2P_MUX_PREFS: %s", id)
 			continue
 		}
 		delete(muxers, id)
-		opts = append(opts, libp2p.Muxer(id, tpt))
+		opts = append(opts, libp2p.Muxer(id, conns.transport(id, tpt)))
 	}
 
 	return libp2p.ChainOptions(opts...)
 }
 
-func setupDiscoveryOption(d config.Discovery) DiscoveryOption {
-	if d.MDNS.Enabled {
-		return func(ctx context.Context, h p2phost.Host) (discovery.Service, error) {
-			if d.MDNS.Interval == 0 {
-				d.MDNS.Interval = 5
-			}
-			return discovery.NewMdnsService(ctx, h, time.Duration(d.MDNS.Interval)*time.Second, discovery.ServiceTag)
+// makeSecurityOption enables the security transports of order, the preferred
+// first, marking the connections they secure for conns.
+func makeSecurityOption(order []string, sk ic.PrivKey, conns *muxedConns) (libp2p.Option, error) {
+	opts := make([]libp2p.Option, 0, len(order))
+	for _, id := range order {
+		var tpt connsec.Transport
+		var err error
+		switch id {
+		case secioID:
+			tpt, err = secio.New(sk)
+		case libp2ptls.ID:
+			tpt, err = libp2ptls.New(sk)
+		case noise.ID:
+			tpt, err = noise.New(sk)
 		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot create the %s security transport: %s", id, err)
+		}
+		opts = append(opts, libp2p.Security(id, conns.secure(id, tpt)))
 	}
-	return nil
+	return libp2p.ChainOptions(opts...), nil
 }
 
 // HandlePeerFound attempts to connect to peer from `PeerInfo`, if it fails
@@ -491,7 +941,8 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	}
 
 	// setup routing service
-	r, err := routingOption(ctx, host, n.Repo.Datastore(), n.RecordValidator)
+	n.dhtMode = newDHTModeHost(host)
+	r, err := routingOption(ctx, n.dhtMode, n.Repo.Datastore(), n.RecordValidator)
 	if err != nil {
 		return err
 	}
@@ -510,8 +961,29 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	//    PSRouter case below.
 	// 3. Introduce some kind of service manager? (my personal favorite but
 	//    that requires a fair amount of work).
-	if dht, ok := r.(*dht.IpfsDHT); ok {
-		n.DHT = dht
+	composite.Walk(r, func(r routing.IpfsRouting) {
+		switch r := r.(type) {
+		case *dht.IpfsDHT:
+			n.DHT = r
+		case *staticrouting.Router:
+			n.StaticRouting = r
+		}
+	})
+
+	if n.DHT != nil {
+		accelerated, err := features.Enabled(n.Repo, features.AcceleratedDHTClient)
+		if err != nil {
+			return err
+		}
+		if accelerated && n.Routing != routing.IpfsRouting(n.DHT) {
+			log.Warning("the accelerated DHT client isn't used with the custom routing")
+			accelerated = false
+		}
+		if accelerated {
+			n.FullRT = fullrt.New(host, n.DHT, n.RecordValidator)
+			n.Routing = n.FullRT
+			n.Process().Go(n.FullRT.Run)
+		}
 	}
 
 	if ipnsps {
@@ -538,8 +1010,58 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	n.PeerHost = rhost.Wrap(host, n.Routing)
 
 	// setup exchange service
-	bitswapNetwork := bsnet.NewFromIpfsHost(n.PeerHost, n.Routing)
-	n.Exchange = bitswap.New(ctx, bitswapNetwork, n.Blockstore)
+	ranking, err := features.Enabled(n.Repo, features.BitswapPeerRanking)
+	if err != nil {
+		return err
+	}
+
+	repCfg := reputation.Config{}
+	if err := repo.ConfigSection(n.Repo, "Swarm.Reputation", &repCfg); err != nil {
+		return fmt.Errorf("invalid Swarm.Reputation config: %s", err)
+	}
+
+	var bsRouting routing.ContentRouting = n.Routing
+	// light clients don't announce their content
+	if !n.lightClient {
+		if err := n.startProvideQueue(ctx); err != nil {
+			return err
+		}
+		bsRouting = n.ProvideQueue.Routing(bsRouting)
+	}
+	if repCfg.Enabled {
+		n.Reputation = reputation.NewService(n.PeerHost, n.Repo.Datastore())
+		bsRouting = n.Reputation.Routing(bsRouting)
+	}
+	// the reputation biases the ranking of the providers
+	if ranking || n.Reputation != nil {
+		n.PeerRank = peerrank.NewTracker(n.Peerstore, n.PeerHost.Network().Peers)
+		if n.Reputation != nil {
+			n.PeerRank.SetBias(n.Reputation.Bias)
+		}
+		bsRouting = peerrank.NewRouting(bsRouting, n.PeerRank)
+	}
+
+	bitswapNetwork := bsnet.NewFromIpfsHost(n.PeerHost, bsRouting)
+	// bitswap reads the blocks it serves from the blockstore
+	n.ServeStats = servestats.NewTracker()
+	n.Exchange = bitswap.New(ctx, bitswapNetwork, n.ServeStats.Blockstore(n.Blockstore))
+
+	if bs, ok := n.Exchange.(*bitswap.Bitswap); ok && n.PeerRank != nil {
+		n.PeerRank.SetReceived(func(p peer.ID) uint64 {
+			return bs.LedgerForPeer(p).Recv
+		})
+		go n.PeerRank.Run(ctx)
+	}
+
+	if n.Reputation != nil {
+		if bs, ok := n.Exchange.(*bitswap.Bitswap); ok {
+			n.Reputation.SetLedger(func(p peer.ID) (uint64, uint64) {
+				l := bs.LedgerForPeer(p)
+				return l.Sent, l.Recv
+			})
+		}
+		n.Reputation.Start()
+	}
 
 	size, err := n.getCacheSize()
 	if err != nil {
@@ -547,10 +1069,21 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	}
 
 	// setup name system
-	n.Namesys = namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), size)
+	ns := namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), size)
+	hooks, err := readPublishHooks(n.Repo)
+	if err != nil {
+		return err
+	}
+	var repub namesys.Publisher = ns
+	if hooks != nil {
+		hooks.keyName = n.keyName
+		repub = namesys.NewHookedPublisher(ns, n.Repo.Datastore(), true, hooks.publish)
+		ns = namesys.WithPublishHook(ns, n.Repo.Datastore(), hooks.publish)
+	}
+	n.Namesys = ns
 
 	// setup ipns republishing
-	return n.setupIpnsRepublisher()
+	return n.setupIpnsRepublisher(repub)
 }
 
 // getCacheSize returns cache life and cache size
@@ -570,13 +1103,13 @@ func (n *IpfsNode) getCacheSize() (int, error) {
 	return cs, nil
 }
 
-func (n *IpfsNode) setupIpnsRepublisher() error {
+func (n *IpfsNode) setupIpnsRepublisher(pub namesys.Publisher) error {
 	cfg, err := n.Repo.Config()
 	if err != nil {
 		return err
 	}
 
-	n.IpnsRepub = ipnsrp.NewRepublisher(n.Namesys, n.Repo.Datastore(), n.PrivateKey, n.Repo.Keystore())
+	n.IpnsRepub = ipnsrp.NewRepublisher(pub, n.Repo.Datastore(), n.PrivateKey, n.Repo.Keystore())
 
 	if cfg.Ipns.RepublishPeriod != "" {
 		d, err := time.ParseDuration(cfg.Ipns.RepublishPeriod)
@@ -635,14 +1168,38 @@ func (n *IpfsNode) teardown() error {
 	// needs to use another during its shutdown/cleanup process, it should be
 	// closed before that other object
 
+	if n.PinJobs != nil {
+		closers = append(closers, n.PinJobs)
+	}
+
+	if n.Search != nil {
+		closers = append(closers, n.Search)
+	}
+
+	if n.FilesMirrors != nil {
+		closers = append(closers, n.FilesMirrors)
+	}
+
+	if n.FilesWriteBack != nil {
+		closers = append(closers, n.FilesWriteBack)
+	}
+
 	if n.FilesRoot != nil {
 		closers = append(closers, n.FilesRoot)
 	}
 
+	if n.Availability != nil {
+		closers = append(closers, n.Availability)
+	}
+
 	if n.Exchange != nil {
 		closers = append(closers, n.Exchange)
 	}
 
+	if n.ProvideQueue != nil {
+		closers = append(closers, n.ProvideQueue)
+	}
+
 	if n.Mounts.Ipfs != nil && !n.Mounts.Ipfs.IsActive() {
 		closers = append(closers, mount.Closer(n.Mounts.Ipfs))
 	}
@@ -654,6 +1211,10 @@ func (n *IpfsNode) teardown() error {
 		closers = append(closers, n.DHT.Process())
 	}
 
+	if n.StaticRouting != nil {
+		closers = append(closers, n.StaticRouting)
+	}
+
 	if n.Blocks != nil {
 		closers = append(closers, n.Blocks)
 	}
@@ -662,10 +1223,51 @@ func (n *IpfsNode) teardown() error {
 		closers = append(closers, n.Bootstrapper)
 	}
 
+	if n.Peering != nil {
+		closers = append(closers, n.Peering)
+	}
+
+	if n.PeerExchange != nil {
+		closers = append(closers, n.PeerExchange)
+	}
+
+	if n.SecureWebSocket != nil {
+		closers = append(closers, n.SecureWebSocket)
+	}
+	if n.HolePunch != nil {
+		closers = append(closers, n.HolePunch)
+	}
+
+	if n.AutoRelay != nil {
+		closers = append(closers, n.AutoRelay)
+	}
+
+	if n.RelayService != nil {
+		closers = append(closers, n.RelayService)
+	}
+
+	if n.Reputation != nil {
+		closers = append(closers, n.Reputation)
+	}
+
+	if n.ResourceMgr != nil {
+		closers = append(closers, n.ResourceMgr)
+	}
+
+	if n.QoS != nil {
+		closers = append(closers, n.QoS)
+	}
+
 	if n.PeerHost != nil {
 		closers = append(closers, n.PeerHost)
 	}
 
+	// the journal is committed once everything that updates the roots has
+	// been closed
+	if n.Journal != nil {
+		closers = append(closers, n.Journal)
+	}
+
 	// Repo closed last, most things need to preserve state here
 	closers = append(closers, n.Repo)
 
@@ -807,10 +1409,26 @@ func (n *IpfsNode) loadBootstrapPeers() ([]pstore.PeerInfo, error) {
 	return toPeerInfos(parsed), nil
 }
 
+// rootsDatastore returns the datastore the roots of the pin set and of the
+// files API are stored in, which journals their updates if enabled.
+func (n *IpfsNode) rootsDatastore() ds.Datastore {
+	if n.Journal == nil {
+		return n.Repo.Datastore()
+	}
+	return n.Journal.Wrap(n.Repo.Datastore(), pin.PinDatastoreKey, filesRootKey)
+}
+
 func (n *IpfsNode) loadFilesRoot() error {
-	dsk := ds.NewKey("/local/filesroot")
+	n.FilesEvents = NewFilesNotifier()
+
+	rds := n.rootsDatastore()
+	dsk := filesRootKey
 	pf := func(ctx context.Context, c cid.Cid) error {
-		return n.Repo.Datastore().Put(dsk, c.Bytes())
+		if err := rds.Put(dsk, c.Bytes()); err != nil {
+			return err
+		}
+		n.FilesEvents.Notify(FilesEvent{Op: FilesEventPublish, Path: "/", Root: c.String()})
+		return nil
 	}
 
 	var nd *merkledag.ProtoNode
@@ -849,10 +1467,31 @@ func (n *IpfsNode) loadFilesRoot() error {
 		return err
 	}
 
+	if err := replayFilesJournal(n.Context(), mr, n.DAG, rds); err != nil {
+		return err
+	}
+
+	wb, err := newFilesWriteBack(n.Repo, rds, n.LockFilesRoot)
+	if err != nil {
+		return err
+	}
+
+	n.filesPublish = pf
 	n.FilesRoot = mr
+	n.FilesWriteBack = wb
+	n.FilesMirrors = NewFilesMirrors(n.Context(), n.LockFilesRoot, n.DAG, n.Repo.Datastore(), n.FilesEvents)
 	return nil
 }
 
+// LockFilesRoot returns the files API root, and keeps ReshardFiles from
+// replacing it until the returned function is called. The mfs objects
+// obtained from the root must not be used after that. It must not be called
+// again, nor ReshardFiles, before the root is unlocked.
+func (n *IpfsNode) LockFilesRoot() (*mfs.Root, func()) {
+	n.filesRootLk.RLock()
+	return n.FilesRoot, n.filesRootLk.RUnlock
+}
+
 // SetupOfflineRouting instantiates a routing system in offline mode. This is
 // primarily used for offline ipns modifications.
 func (n *IpfsNode) SetupOfflineRouting() error {
@@ -951,16 +1590,26 @@ func composeAddrsFactory(f, g p2pbhost.AddrsFactory) p2pbhost.AddrsFactory {
 }
 
 // startListening on the network addresses
-func startListening(host p2phost.Host, cfg *config.Config) error {
-	listenAddrs, err := listenAddresses(cfg)
-	if err != nil {
-		return err
+func startListening(host p2phost.Host, listenAddrs []ma.Multiaddr, front *wss.Front) error {
+	// the /wss addresses forward to a websocket address of the swarm
+	listenAddrs, wssAddrs := wss.SplitAddrs(listenAddrs)
+	if front != nil {
+		internal, err := ma.NewMultiaddr(wss.InternalListenAddr)
+		if err != nil {
+			return err
+		}
+		listenAddrs = append(listenAddrs, internal)
 	}
 
 	// Actually start listening:
 	if err := host.Network().Listen(listenAddrs...); err != nil {
 		return err
 	}
+	if front != nil {
+		if err := startSecureWebSocket(host, front, wssAddrs); err != nil {
+			return err
+		}
+	}
 
 	// list out our addresses
 	addrs, err := host.Network().InterfaceListenAddresses()
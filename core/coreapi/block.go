@@ -51,6 +51,39 @@ func (api *BlockAPI) Put(ctx context.Context, src io.Reader, opts ...caopts.Bloc
 	return &BlockStat{path: coreiface.IpldPath(b.Cid()), size: len(data)}, nil
 }
 
+func (api *BlockAPI) PutMany(ctx context.Context, srcs []io.Reader, opts ...caopts.BlockPutOption) ([]coreiface.BlockStat, error) {
+	_, pref, err := caopts.BlockPutOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	blks := make([]blocks.Block, 0, len(srcs))
+	stats := make([]coreiface.BlockStat, 0, len(srcs))
+	for _, src := range srcs {
+		data, err := ioutil.ReadAll(src)
+		if err != nil {
+			return nil, err
+		}
+
+		bcid, err := pref.Sum(data)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := blocks.NewBlockWithCid(data, bcid)
+		if err != nil {
+			return nil, err
+		}
+		blks = append(blks, b)
+		stats = append(stats, &BlockStat{path: coreiface.IpldPath(b.Cid()), size: len(data)})
+	}
+
+	if err := api.node.Blocks.AddBlocks(blks); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
 func (api *BlockAPI) Get(ctx context.Context, p coreiface.Path) (io.Reader, error) {
 	rp, err := api.core().ResolvePath(ctx, p)
 	if err != nil {
@@ -2,6 +2,7 @@ package coreapi_test
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -181,3 +182,34 @@ func TestBlockStat(t *testing.T) {
 		t.Error("length doesn't match")
 	}
 }
+
+func TestBlockPutMany(t *testing.T) {
+	ctx := context.Background()
+	_, api, err := makeAPI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := api.Block().PutMany(ctx, []io.Reader{strings.NewReader(`Hello`), strings.NewReader(`World`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(res))
+	}
+	if res[0].Path().Cid().String() != "QmPyo15ynbVrSTVdJL9th7JysHaAbXt9dM9tXk1bMHbRtk" {
+		t.Errorf("got wrong cid: %s", res[0].Path().Cid().String())
+	}
+
+	r, err := api.Block().Get(ctx, res[1].Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d) != "World" {
+		t.Errorf("got wrong data: %s", string(d))
+	}
+}
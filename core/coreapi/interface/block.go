@@ -21,6 +21,11 @@ type BlockAPI interface {
 	// Put imports raw block data, hashing it using specified settings.
 	Put(context.Context, io.Reader, ...options.BlockPutOption) (BlockStat, error)
 
+	// PutMany imports the raw data of several blocks, hashing them using the
+	// specified settings. The blocks are written to the blockstore in a
+	// single batch.
+	PutMany(context.Context, []io.Reader, ...options.BlockPutOption) ([]BlockStat, error)
+
 	// Get attempts to resolve the path and return a reader for data in the block
 	Get(context.Context, Path) (io.Reader, error)
 
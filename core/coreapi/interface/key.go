@@ -35,4 +35,10 @@ type KeyAPI interface {
 
 	// Remove removes keys from keystore. Returns ipns path of the removed key
 	Remove(ctx context.Context, name string) (Key, error)
+
+	// Default returns the key names are published with when no key is given
+	Default(ctx context.Context) (Key, error)
+
+	// Use makes the key with the given name the default key
+	Use(ctx context.Context, name string) (Key, error)
 }
@@ -18,6 +18,7 @@ type NamePublishSettings struct {
 type NameResolveSettings struct {
 	Local bool
 	Cache bool
+	Pin   bool
 
 	ResolveOpts []ropts.ResolveOpt
 }
@@ -45,6 +46,7 @@ func NameResolveOptions(opts ...NameResolveOption) (*NameResolveSettings, error)
 	options := &NameResolveSettings{
 		Local: false,
 		Cache: true,
+		Pin:   false,
 	}
 
 	for _, opt := range opts {
@@ -100,6 +102,17 @@ func (nameOpts) Cache(cache bool) NameResolveOption {
 	}
 }
 
+// Pin is an option for Name.Resolve which specifies if the resolved path
+// should be pinned recursively before it is returned, so that it is the path
+// which gets pinned even if the name is updated meanwhile. The name must
+// resolve to an /ipfs path. Default value is false
+func (nameOpts) Pin(pin bool) NameResolveOption {
+	return func(settings *NameResolveSettings) error {
+		settings.Pin = pin
+		return nil
+	}
+}
+
 //
 func (nameOpts) ResolveOption(opt ropts.ResolveOpt) NameResolveOption {
 	return func(settings *NameResolveSettings) error {
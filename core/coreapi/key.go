@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sort"
 
+	core "github.com/ipfs/go-ipfs/core"
 	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
 	caopts "github.com/ipfs/go-ipfs/core/coreapi/interface/options"
 
@@ -186,7 +187,24 @@ func (api *KeyAPI) Rename(ctx context.Context, oldName string, newName string, o
 		return nil, false, err
 	}
 
-	return &key{newName, pid}, overwrite, ks.Delete(oldName)
+	err = ks.Delete(oldName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the default key follows its renaming
+	def, err := core.DefaultKey(api.node.Repo)
+	if err != nil {
+		return nil, false, err
+	}
+	if def == oldName {
+		err = api.node.Repo.SetConfigKey(core.DefaultKeyKey, newName)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return &key{newName, pid}, overwrite, nil
 }
 
 // Remove removes keys from keystore. Returns ipns path of the removed key.
@@ -197,6 +215,14 @@ func (api *KeyAPI) Remove(ctx context.Context, name string) (coreiface.Key, erro
 		return nil, fmt.Errorf("cannot remove key with name 'self'")
 	}
 
+	def, err := core.DefaultKey(api.node.Repo)
+	if err != nil {
+		return nil, err
+	}
+	if def == name {
+		return nil, fmt.Errorf("cannot remove the default key %s, switch to another key with 'ipfs key use' first", name)
+	}
+
 	removed, err := ks.Get(name)
 	if err != nil {
 		return nil, fmt.Errorf("no key named %s was found", name)
@@ -216,3 +242,48 @@ func (api *KeyAPI) Remove(ctx context.Context, name string) (coreiface.Key, erro
 
 	return &key{"", pid}, nil
 }
+
+// Default returns the key names are published with when no key is given,
+// "self" unless another key was set with Use.
+func (api *KeyAPI) Default(ctx context.Context) (coreiface.Key, error) {
+	name, err := core.DefaultKey(api.node.Repo)
+	if err != nil {
+		return nil, err
+	}
+	return api.key(name)
+}
+
+// Use makes the key named `name` the default key, persisting it in the
+// config.
+func (api *KeyAPI) Use(ctx context.Context, name string) (coreiface.Key, error) {
+	k, err := api.key(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = api.node.Repo.SetConfigKey(core.DefaultKeyKey, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// key returns the key named `name`, "self" being the key of the node.
+func (api *KeyAPI) key(name string) (*key, error) {
+	if name == "self" {
+		return &key{"self", api.node.Identity}, nil
+	}
+
+	privKey, err := api.node.Repo.Keystore().Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("no key named %s was found", name)
+	}
+
+	pid, err := peer.IDFromPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+
+	return &key{name, pid}, nil
+}
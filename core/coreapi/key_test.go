@@ -471,3 +471,29 @@ func TestRemove(t *testing.T) {
 		t.Errorf("expected the key to be called 'self', got '%s'", l[0].Name())
 	}
 }
+
+func TestDefault(t *testing.T) {
+	ctx := context.Background()
+	_, api, err := makeAPI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := api.Key().Default(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.Name() != "self" {
+		t.Errorf("expected the default key to be 'self', got '%s'", k.Name())
+	}
+
+	_, err = api.Key().Use(ctx, "foo")
+	if err == nil {
+		t.Fatal("expected an error using a missing key")
+	}
+
+	if err.Error() != "no key named foo was found" {
+		t.Fatalf("expected error 'no key named foo was found', got '%s'", err.Error())
+	}
+}
@@ -106,6 +106,48 @@ func TestBasicPublishResolveKey(t *testing.T) {
 	}
 }
 
+func TestResolvePin(t *testing.T) {
+	ctx := context.Background()
+	_, apis, err := makeAPISwarm(ctx, true, 5)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	api := apis[0]
+
+	p, err := addTestObject(ctx, api)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	e, err := api.Name().Publish(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	resPath, err := api.Name().Resolve(ctx, e.Name(), opt.Name.Pin(true))
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	if resPath.String() != p.String() {
+		t.Errorf("expected paths to match, '%s'!='%s'", resPath.String(), p.String())
+	}
+
+	pins, err := api.Pin().Ls(ctx, opt.Pin.Type.Recursive())
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	if len(pins) != 1 || pins[0].Path().String() != p.String() {
+		t.Errorf("expected '%s' to be pinned, got %v", p.String(), pins)
+	}
+}
+
 func TestBasicPublishResolveTimeout(t *testing.T) {
 	t.Skip("ValidTime doesn't appear to work at this time resolution")
 
@@ -13,6 +13,7 @@ import (
 
 	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
 	caopts "github.com/ipfs/go-ipfs/core/coreapi/interface/options"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
 	"github.com/ipfs/go-ipfs/dagutils"
 	"github.com/ipfs/go-ipfs/pin"
 
@@ -42,7 +43,7 @@ func (api *ObjectAPI) New(ctx context.Context, opts ...caopts.ObjectNewOption) (
 		return nil, err
 	}
 
-	var n ipld.Node
+	var n *dag.ProtoNode
 	switch options.Type {
 	case "empty":
 		n = new(dag.ProtoNode)
@@ -50,6 +51,9 @@ func (api *ObjectAPI) New(ctx context.Context, opts ...caopts.ObjectNewOption) (
 		n = ft.EmptyDirNode()
 	}
 
+	if err := api.setCidBuilder(n); err != nil {
+		return nil, err
+	}
 	err = api.node.DAG.Add(ctx, n)
 	if err != nil {
 		return nil, err
@@ -117,6 +121,10 @@ func (api *ObjectAPI) Put(ctx context.Context, src io.Reader, opts ...caopts.Obj
 		return nil, err
 	}
 
+	if err := api.setCidBuilder(dagnode); err != nil {
+		return nil, err
+	}
+
 	if options.Pin {
 		defer api.node.Blockstore.PinLock().Unlock()
 	}
@@ -137,6 +145,17 @@ func (api *ObjectAPI) Put(ctx context.Context, src io.Reader, opts ...caopts.Obj
 	return coreiface.IpfsPath(dagnode.Cid()), nil
 }
 
+// setCidBuilder sets the CID builder of the Import config on the new node n,
+// if the config sets one.
+func (api *ObjectAPI) setCidBuilder(n *dag.ProtoNode) error {
+	d, err := coreunix.ConfiguredImportDefaults(api.node.Repo)
+	if err != nil || d.CidBuilder == nil {
+		return err
+	}
+	n.SetCidBuilder(d.CidBuilder)
+	return nil
+}
+
 func (api *ObjectAPI) Get(ctx context.Context, path coreiface.Path) (ipld.Node, error) {
 	return api.core().ResolveNode(ctx, path)
 }
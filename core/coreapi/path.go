@@ -7,6 +7,7 @@ import (
 
 	"github.com/ipfs/go-ipfs/core"
 	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
+	coredag "github.com/ipfs/go-ipfs/core/coredag"
 	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
 	ipfspath "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
 	"gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path/resolver"
@@ -51,7 +52,7 @@ func (api *CoreAPI) ResolvePath(ctx context.Context, p coreiface.Path) (coreifac
 	case "ipfs":
 		resolveOnce = uio.ResolveUnixfsOnce
 	case "ipld":
-		resolveOnce = resolver.ResolveSingle
+		resolveOnce = coredag.ResolveOnce
 	default:
 		return nil, fmt.Errorf("unsupported path namespace: %s", p.Namespace())
 	}
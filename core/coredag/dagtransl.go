@@ -1,6 +1,8 @@
 package coredag
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 
@@ -19,9 +21,19 @@ type InputEncParsers map[string]FormatParsers
 // DefaultInputEncParsers is InputEncParser that is used everywhere
 var DefaultInputEncParsers = InputEncParsers{
 	"json":     defaultJSONParsers,
+	"dag-json": defaultJSONParsers,
 	"raw":      defaultRawParsers,
 	"cbor":     defaultCborParsers,
+	"dag-cbor": defaultCborParsers,
 	"protobuf": defaultProtobufParsers,
+	"dag-pb":   defaultProtobufParsers,
+}
+
+// jsonInputEncs are the input encodings whose inputs are streams of JSON
+// documents.
+var jsonInputEncs = map[string]bool{
+	"json":     true,
+	"dag-json": true,
 }
 
 var defaultJSONParsers = FormatParsers{
@@ -30,6 +42,8 @@ var defaultJSONParsers = FormatParsers{
 
 	"protobuf": dagpbJSONParser,
 	"dag-pb":   dagpbJSONParser,
+
+	"raw": rawRawParser,
 }
 
 var defaultRawParsers = FormatParsers{
@@ -45,11 +59,15 @@ var defaultRawParsers = FormatParsers{
 var defaultCborParsers = FormatParsers{
 	"cbor":     cborRawParser,
 	"dag-cbor": cborRawParser,
+
+	"raw": rawRawParser,
 }
 
 var defaultProtobufParsers = FormatParsers{
 	"protobuf": dagpbRawParser,
 	"dag-pb":   dagpbRawParser,
+
+	"raw": rawRawParser,
 }
 
 // ParseInputs uses DefaultInputEncParsers to parse io.Reader described by
@@ -58,6 +76,12 @@ func ParseInputs(ienc, format string, r io.Reader, mhType uint64, mhLen int) ([]
 	return DefaultInputEncParsers.ParseInputs(ienc, format, r, mhType, mhLen)
 }
 
+// ParseInputStream uses DefaultInputEncParsers to parse the documents of
+// io.Reader, calling fn with the nodes of each, the root first.
+func ParseInputStream(ienc, format string, r io.Reader, mhType uint64, mhLen int, fn func([]ipld.Node) error) error {
+	return DefaultInputEncParsers.ParseInputStream(ienc, format, r, mhType, mhLen, fn)
+}
+
 // AddParser adds DagParser under give input encoding and format
 func (iep InputEncParsers) AddParser(ienc, format string, f DagParser) {
 	m, ok := iep[ienc]
@@ -84,3 +108,37 @@ func (iep InputEncParsers) ParseInputs(ienc, format string, r io.Reader, mhType
 
 	return parser(r, mhType, mhLen)
 }
+
+// ParseInputStream parses the documents of io.Reader described by input
+// encoding and format, calling fn with the nodes of each, the root first.
+// JSON inputs can hold several documents, one after the other. Other inputs
+// hold a single document.
+func (iep InputEncParsers) ParseInputStream(ienc, format string, r io.Reader, mhType uint64, mhLen int, fn func([]ipld.Node) error) error {
+	if !jsonInputEncs[ienc] {
+		nds, err := iep.ParseInputs(ienc, format, r, mhType, mhLen)
+		if err != nil {
+			return err
+		}
+		return fn(nds)
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		nds, err := iep.ParseInputs(ienc, format, bytes.NewReader(doc), mhType, mhLen)
+		if err != nil {
+			return err
+		}
+		if err := fn(nds); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package coredag
+
+import (
+	"context"
+
+	resolver "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path/resolver"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// PathResolvers maps codecs to the functions resolving paths through their
+// nodes, for the codecs whose paths can't be resolved with
+// ipld.Node.ResolveLink alone.
+type PathResolvers map[uint64]resolver.ResolveOnce
+
+// DefaultPathResolvers is the PathResolvers used by the node resolver
+var DefaultPathResolvers = PathResolvers{}
+
+// AddResolver adds the path resolver of the given codec
+func (prs PathResolvers) AddResolver(codec uint64, f resolver.ResolveOnce) {
+	prs[codec] = f
+}
+
+// ResolveOnce resolves the first names of a path in nd with the path resolver
+// of its codec, or with resolver.ResolveSingle if it has none.
+func (prs PathResolvers) ResolveOnce(ctx context.Context, ds ipld.NodeGetter, nd ipld.Node, names []string) (*ipld.Link, []string, error) {
+	if f, ok := prs[nd.Cid().Type()]; ok {
+		return f(ctx, ds, nd, names)
+	}
+	return resolver.ResolveSingle(ctx, ds, nd, names)
+}
+
+// ResolveOnce resolves names in nd with DefaultPathResolvers
+func ResolveOnce(ctx context.Context, ds ipld.NodeGetter, nd ipld.Node, names []string) (*ipld.Link, []string, error) {
+	return DefaultPathResolvers.ResolveOnce(ctx, ds, nd, names)
+}
@@ -0,0 +1,114 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	corelog "github.com/ipfs/go-ipfs/core/corelog"
+)
+
+// streamErrorHeader is the trailer of the responses whose output stream
+// failed.
+const streamErrorHeader = "X-Stream-Error"
+
+// maxAuditErrorBody bounds the part of the error responses kept to find
+// their message.
+const maxAuditErrorBody = 4 << 10
+
+// auditCallerKey is the context key of the caller of the audited requests,
+// set once the request is authenticated.
+type auditCallerKey struct{}
+
+// setAuditCaller sets the caller of the audited request of ctx.
+func setAuditCaller(ctx context.Context, caller string) {
+	if p, ok := ctx.Value(auditCallerKey{}).(*string); ok {
+		*p = caller
+	}
+}
+
+// AuditOption records the API requests in audit, doing nothing if it is
+// nil. It must come before the commands options.
+func AuditOption(audit *corelog.AuditLog) ServeOption {
+	return func(n *core.IpfsNode, l net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		if audit == nil {
+			return parent, nil
+		}
+		mux := http.NewServeMux()
+		parent.Handle("/", withAPIAudit(mux, audit))
+		return mux, nil
+	}
+}
+
+// withAPIAudit wraps h to record the requests to the API commands, but for
+// the CORS preflights.
+func withAPIAudit(h http.Handler, audit *corelog.AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, APIPath+"/") || r.Method == "OPTIONS" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		var caller string
+		r = r.WithContext(context.WithValue(r.Context(), auditCallerKey{}, &caller))
+		aw := &auditWriter{statusWriter: &statusWriter{ResponseWriter: w, status: http.StatusOK}}
+		h.ServeHTTP(aw, r)
+
+		q := r.URL.Query()
+		e := &corelog.AuditEntry{
+			Time:      start.UTC(),
+			Command:   strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/"),
+			Arguments: q["arg"],
+			Caller:    caller,
+			Remote:    r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			RequestID: RequestID(r.Context()),
+			Duration:  time.Since(start),
+			Status:    aw.status,
+		}
+		delete(q, "arg")
+		if len(q) > 0 {
+			e.Options = q
+		}
+		if msg := w.Header().Get(streamErrorHeader); msg != "" {
+			e.Error = msg
+		} else if aw.status >= 400 {
+			e.Error = errorMessage(aw.body)
+		}
+		audit.Record(e)
+	})
+}
+
+// auditWriter keeps the start of the body of the error responses.
+type auditWriter struct {
+	*statusWriter
+	body []byte
+}
+
+func (w *auditWriter) Write(p []byte) (int, error) {
+	if w.status >= 400 && len(w.body) < maxAuditErrorBody {
+		n := len(p)
+		if rest := maxAuditErrorBody - len(w.body); n > rest {
+			n = rest
+		}
+		w.body = append(w.body, p[:n]...)
+	}
+	return w.statusWriter.Write(p)
+}
+
+// errorMessage returns the message of the error response body b, either a
+// command error or a plain text one.
+func errorMessage(b []byte) string {
+	var cmdErr struct {
+		Message string
+	}
+	if err := json.Unmarshal(b, &cmdErr); err == nil && cmdErr.Message != "" {
+		return cmdErr.Message
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,92 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corelog "github.com/ipfs/go-ipfs/core/corelog"
+)
+
+func TestAPIAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	f, err := corelog.OpenFile(path, corelog.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := corelog.NewAuditLog(f, false)
+
+	h := withAPIAudit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setAuditCaller(r.Context(), "app")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/missing"):
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"Message":"no such thing","Code":0,"Type":"error"}`))
+		case strings.HasSuffix(r.URL.Path, "/stream"):
+			w.Header().Set("Trailer", streamErrorHeader)
+			w.Write([]byte("partial"))
+			w.Header().Set(streamErrorHeader, "stream failed")
+		}
+	}), audit)
+
+	for _, target := range []string{
+		APIPath + "/cat?arg=/ipfs/Qm1&arg=/ipfs/Qm2&offset=10",
+		APIPath + "/missing",
+		APIPath + "/stream",
+		"/ipfs/Qm1",
+	} {
+		req := httptest.NewRequest("POST", target, nil)
+		req.Header.Set("User-Agent", "test")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("OPTIONS", APIPath+"/cat", nil))
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 entries, got %q", lines)
+	}
+	var entries []corelog.AuditEntry
+	for _, l := range lines {
+		var e corelog.AuditEntry
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	e := entries[0]
+	if e.Command != "cat" || e.Caller != "app" || e.UserAgent != "test" || e.Status != http.StatusOK || e.Error != "" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if !reflect.DeepEqual(e.Arguments, []string{"/ipfs/Qm1", "/ipfs/Qm2"}) {
+		t.Fatalf("unexpected arguments: %v", e.Arguments)
+	}
+	if !reflect.DeepEqual(e.Options, map[string][]string{"offset": {"10"}}) {
+		t.Fatalf("unexpected options: %v", e.Options)
+	}
+	if e := entries[1]; e.Status != http.StatusInternalServerError || e.Error != "no such thing" {
+		t.Fatalf("expected the error of the command, got %+v", e)
+	}
+	if e := entries[2]; e.Status != http.StatusOK || e.Error != "stream failed" {
+		t.Fatalf("expected the error of the stream, got %+v", e)
+	}
+}
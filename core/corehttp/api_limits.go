@@ -0,0 +1,116 @@
+package corehttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APILimitsConfig is read from the API.Limits config section. Zero or
+// missing limits don't limit anything.
+type APILimitsConfig struct {
+	// Global is the number of requests to the expensive commands and to the
+	// commands in Commands that can run at once, all commands together.
+	Global int
+	// Commands maps the path of commands, like "add" or "pin/add", to the
+	// number of requests to them that can run at once.
+	Commands map[string]int
+}
+
+// expensiveCommands are subject to API.Limits.Global even if they have no
+// limit of their own.
+var expensiveCommands = []string{
+	"add",
+	"dag/stat",
+	"pin/add",
+	"pin/verify",
+	"repo/gc",
+	"repo/verify",
+	"upload/append",
+}
+
+// apiLimiter refuses the API requests to the limited commands beyond their
+// concurrency limits with a 429, instead of queuing them.
+type apiLimiter struct {
+	global chan struct{}
+	// commands maps the limited commands to their semaphores, nil for the
+	// commands only subject to the global limit.
+	commands map[string]chan struct{}
+}
+
+func newAPILimiter(cfg APILimitsConfig) (*apiLimiter, error) {
+	if cfg.Global < 0 {
+		return nil, fmt.Errorf("invalid API.Limits.Global: %d", cfg.Global)
+	}
+
+	l := &apiLimiter{commands: make(map[string]chan struct{})}
+	if cfg.Global > 0 {
+		l.global = make(chan struct{}, cfg.Global)
+		for _, cmd := range expensiveCommands {
+			l.commands[cmd] = nil
+		}
+	}
+	for cmd, n := range cfg.Commands {
+		if n < 0 {
+			return nil, fmt.Errorf("invalid API.Limits.Commands.%s: %d", cmd, n)
+		}
+		if n > 0 {
+			l.commands[strings.Trim(cmd, "/")] = make(chan struct{}, n)
+		}
+	}
+	return l, nil
+}
+
+// acquire takes a slot for running cmd, returning false if cmd is limited
+// and saturated. release must be called once done if it returns true.
+func (l *apiLimiter) acquire(cmd string) (release func(), ok bool) {
+	sem, limited := l.commands[cmd]
+	if !limited {
+		return func() {}, true
+	}
+
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+		default:
+			return nil, false
+		}
+	}
+	releaseCmd := func() {
+		if sem != nil {
+			<-sem
+		}
+	}
+	if l.global == nil {
+		return releaseCmd, true
+	}
+
+	select {
+	case l.global <- struct{}{}:
+		return func() {
+			<-l.global
+			releaseCmd()
+		}, true
+	default:
+		releaseCmd()
+		return nil, false
+	}
+}
+
+// withAPILimits wraps an API handler to enforce the concurrency limits of l.
+func withAPILimits(h http.Handler, l *apiLimiter) http.Handler {
+	if len(l.commands) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cmd := strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/")
+		release, ok := l.acquire(cmd)
+		if !ok {
+			log.Warningf("API request %s to %s refused, too many concurrent requests", requestID(r), cmd)
+			http.Error(w, fmt.Sprintf("too many concurrent %s requests, try again later", cmd), http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,76 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPILimiter(t *testing.T) {
+	l, err := newAPILimiter(APILimitsConfig{
+		Global:   2,
+		Commands: map[string]int{"add": 1, "/pin/add/": 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, ok := l.acquire("add")
+	if !ok {
+		t.Fatal("expected the first add to run")
+	}
+	if _, ok := l.acquire("add"); ok {
+		t.Fatal("expected a second add to be refused")
+	}
+
+	releasePin, ok := l.acquire("pin/add")
+	if !ok {
+		t.Fatal("expected pin/add to run")
+	}
+	if _, ok := l.acquire("repo/gc"); ok {
+		t.Fatal("expected repo/gc to be refused by the global limit")
+	}
+	if _, ok := l.acquire("pin/add"); ok {
+		t.Fatal("expected pin/add to be refused by the global limit")
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, ok := l.acquire("version"); !ok {
+			t.Fatal("expected commands without limits to run")
+		}
+	}
+
+	release()
+	releasePin()
+	for _, cmd := range []string{"add", "repo/gc"} {
+		if _, ok := l.acquire(cmd); !ok {
+			t.Fatalf("expected %s to run once the slots are released", cmd)
+		}
+	}
+}
+
+func TestAPILimiterInvalid(t *testing.T) {
+	if _, err := newAPILimiter(APILimitsConfig{Commands: map[string]int{"add": -1}}); err == nil {
+		t.Fatal("expected a negative limit to be rejected")
+	}
+}
+
+func TestWithAPILimits(t *testing.T) {
+	l, err := newAPILimiter(APILimitsConfig{Commands: map[string]int{"add": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status int
+	h := withAPILimits(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a concurrent add while this one runs
+		rec := httptest.NewRecorder()
+		withAPILimits(http.NotFoundHandler(), l).ServeHTTP(rec, r)
+		status = rec.Code
+	}), l)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", APIPath+"/add", nil))
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, status)
+	}
+}
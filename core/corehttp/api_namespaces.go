@@ -0,0 +1,504 @@
+package corehttp
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	gopath "path"
+	"sort"
+	"strconv"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
+
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+)
+
+// the options of the commands rewritten for the namespaces
+const (
+	noCopyOptionName    = "nocopy"
+	carOutputOptionName = "car-output"
+	manifestOptionName  = "manifest"
+	toFilesOptionName   = "to-files"
+)
+
+// APINamespaceConfig is an entry of the API.Namespaces config section, which
+// maps the names of the namespaces to their settings.
+type APINamespaceConfig struct {
+	// Token is the secret the clients of the namespace send in the
+	// Authorization header of their API requests, as "Bearer <token>".
+	Token string
+	// FilesRoot is the mfs directory the files commands of the namespace
+	// see as their root. Default: /namespaces/<name>.
+	FilesRoot string
+	// KeyPrefix is prepended to the names of the keys of the namespace.
+	// Default: "<name>-".
+	KeyPrefix string
+}
+
+type apiNamespace struct {
+	name      string
+	token     []byte
+	filesRoot string
+	keyPrefix string
+}
+
+// filesPath maps the mfs path p of the namespace to the mfs path of the
+// node. The path is cleaned first, so ".." can't leave the root.
+func (ns *apiNamespace) filesPath(p string) string {
+	return gopath.Join(ns.filesRoot, gopath.Clean("/"+p))
+}
+
+// apiNamespaces confines the API requests bearing the token of a namespace
+// to the commands that can be scoped to it: the files commands see the
+// namespace's mfs root, the key commands its keys, and the pin commands its
+// pins. The requests without a token have the full API, unless required.
+type apiNamespaces struct {
+	node       *core.IpfsNode
+	api        coreiface.CoreAPI
+	namespaces []*apiNamespace
+	required   bool
+	pins       *namespacePins
+}
+
+func newAPINamespaces(n *core.IpfsNode, cfg map[string]APINamespaceConfig, required bool) (*apiNamespaces, error) {
+	if len(cfg) == 0 {
+		if required {
+			return nil, fmt.Errorf("API.RequireNamespace is set without API.Namespaces")
+		}
+		return nil, nil
+	}
+
+	nss := &apiNamespaces{
+		node:     n,
+		api:      coreapi.NewCoreAPI(n),
+		required: required,
+		pins:     newNamespacePins(n.Repo.Datastore()),
+	}
+	for name, c := range cfg {
+		if name == "" || strings.ContainsAny(name, "/ ") {
+			return nil, fmt.Errorf("invalid API.Namespaces name: %q", name)
+		}
+		if c.Token == "" {
+			return nil, fmt.Errorf("API.Namespaces.%s has no Token", name)
+		}
+		ns := &apiNamespace{
+			name:      name,
+			token:     []byte(c.Token),
+			filesRoot: c.FilesRoot,
+			keyPrefix: c.KeyPrefix,
+		}
+		if ns.filesRoot == "" {
+			ns.filesRoot = "/namespaces/" + name
+		}
+		if !strings.HasPrefix(ns.filesRoot, "/") || gopath.Clean(ns.filesRoot) == "/" {
+			return nil, fmt.Errorf("invalid API.Namespaces.%s.FilesRoot: %q", name, c.FilesRoot)
+		}
+		ns.filesRoot = gopath.Clean(ns.filesRoot)
+		if ns.keyPrefix == "" {
+			ns.keyPrefix = name + "-"
+		}
+		nss.namespaces = append(nss.namespaces, ns)
+	}
+	sort.Slice(nss.namespaces, func(i, j int) bool { return nss.namespaces[i].name < nss.namespaces[j].name })
+
+	// the namespaces must not see into each other
+	for i, a := range nss.namespaces {
+		for _, b := range nss.namespaces[i+1:] {
+			if string(a.token) == string(b.token) {
+				return nil, fmt.Errorf("API.Namespaces %s and %s have the same Token", a.name, b.name)
+			}
+			if filesPathWithin(a.filesRoot, b.filesRoot) || filesPathWithin(b.filesRoot, a.filesRoot) {
+				return nil, fmt.Errorf("the FilesRoot of API.Namespaces %s and %s overlap", a.name, b.name)
+			}
+			if strings.HasPrefix(a.keyPrefix, b.keyPrefix) || strings.HasPrefix(b.keyPrefix, a.keyPrefix) {
+				return nil, fmt.Errorf("the KeyPrefix of API.Namespaces %s and %s overlap", a.name, b.name)
+			}
+		}
+	}
+	return nss, nil
+}
+
+// filesPathWithin returns whether the clean mfs path p is dir or is under
+// it.
+func filesPathWithin(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// authenticate returns the namespace of the token of r, or nil for a request
+// without a token. ok is false if the token is unknown, or missing while
+// required.
+func (nss *apiNamespaces) authenticate(r *http.Request) (ns *apiNamespace, ok bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, !nss.required
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+
+	for _, n := range nss.namespaces {
+		if subtle.ConstantTimeCompare(token, n.token) == 1 {
+			ns = n
+		}
+	}
+	return ns, ns != nil
+}
+
+// namespaceError is the refusal of a request of a namespace, with the
+// status it is answered with.
+type namespaceError struct {
+	status int
+	msg    string
+}
+
+func (e *namespaceError) Error() string {
+	return e.msg
+}
+
+func errNamespaceForbidden(format string, a ...interface{}) error {
+	return &namespaceError{status: http.StatusForbidden, msg: fmt.Sprintf(format, a...)}
+}
+
+// namespaceCommand serves a request of the namespace ns to the command cmd,
+// rewriting its query q before passing it to next.
+type namespaceCommand func(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error
+
+// namespaceCommands are the only commands the namespaces can run. The
+// others, like the swarm, config, repo or shutdown commands, act on the
+// whole node.
+var namespaceCommands = map[string]namespaceCommand{
+	"add":          namespaceAdd,
+	"block/get":    namespaceForward,
+	"block/stat":   namespaceForward,
+	"cat":          namespaceForward,
+	"dag/get":      namespaceForward,
+	"dag/resolve":  namespaceForward,
+	"dns":          namespaceForward,
+	"files/chcid":  namespaceFiles,
+	"files/chmod":  namespaceFiles,
+	"files/cp":     namespaceFiles,
+	"files/flush":  namespaceFiles,
+	"files/ls":     namespaceFiles,
+	"files/mkdir":  namespaceFiles,
+	"files/mv":     namespaceFiles,
+	"files/read":   namespaceFiles,
+	"files/rm":     namespaceFiles,
+	"files/stat":   namespaceFiles,
+	"files/touch":  namespaceFiles,
+	"files/write":  namespaceFiles,
+	"get":          namespaceForward,
+	"id":           namespaceForward,
+	"key/gen":      namespaceKeys,
+	"key/list":     namespaceKeys,
+	"key/rename":   namespaceKeys,
+	"key/rm":       namespaceKeys,
+	"ls":           namespaceForward,
+	"name/publish": namespacePublish,
+	"name/resolve": namespaceNameResolve,
+	"object/data":  namespaceForward,
+	"object/get":   namespaceForward,
+	"object/links": namespaceForward,
+	"object/stat":  namespaceForward,
+	"pin/add":      namespacePinAdd,
+	"pin/ls":       namespacePinLs,
+	"pin/rm":       namespacePinRm,
+	"refs":         namespaceForward,
+	"resolve":      namespaceForward,
+	"version":      namespaceForward,
+}
+
+// withAPINamespaces wraps an API handler to authenticate the requests of
+// the namespaces and confine them. It returns h when no namespace is
+// configured.
+func withAPINamespaces(h http.Handler, nss *apiNamespaces) http.Handler {
+	if nss == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the CORS preflights are sent without the token
+		if r.Method == "OPTIONS" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ns, ok := nss.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing API namespace token", http.StatusUnauthorized)
+			return
+		}
+		if ns == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		setAuditCaller(r.Context(), ns.name)
+
+		cmd := strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/")
+		serve, ok := namespaceCommands[cmd]
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s is not available in API namespaces", cmd), http.StatusForbidden)
+			return
+		}
+
+		if err := serve(nss, ns, cmd, r.URL.Query(), w, r, h); err != nil {
+			status := http.StatusInternalServerError
+			if nerr, ok := err.(*namespaceError); ok {
+				status = nerr.status
+			}
+			http.Error(w, err.Error(), status)
+		}
+	})
+}
+
+// withQuery returns a copy of r with the query q.
+func withQuery(r *http.Request, q url.Values) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	r2.URL = &u
+	return r2
+}
+
+// boolOption returns the value of the boolean option with one of names in
+// q, or def if it isn't set.
+func boolOption(q url.Values, def bool, names ...string) (bool, error) {
+	for _, name := range names {
+		v, ok := q[name]
+		if !ok || len(v) == 0 {
+			continue
+		}
+		if v[0] == "" {
+			return true, nil
+		}
+		b, err := strconv.ParseBool(v[0])
+		if err != nil {
+			return false, &namespaceError{status: http.StatusBadRequest, msg: fmt.Sprintf("invalid value for --%s: %q", names[0], v[0])}
+		}
+		return b, nil
+	}
+	return def, nil
+}
+
+func namespaceForward(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	next.ServeHTTP(w, r)
+	return nil
+}
+
+// namespaceNameResolve refuses --pin, which would pin outside of the pins of
+// the namespace: the resolved path is pinned with pin/add instead.
+func namespaceNameResolve(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	if pin, err := boolOption(q, false, "pin"); err != nil {
+		return err
+	} else if pin {
+		return errNamespaceForbidden("--pin is not available in API namespaces, pin the resolved path with pin/add")
+	}
+	next.ServeHTTP(w, r)
+	return nil
+}
+
+// ensureFilesRoot creates the mfs root of ns if it doesn't exist.
+func (nss *apiNamespaces) ensureFilesRoot(ns *apiNamespace) error {
+	root, unlock := nss.node.LockFilesRoot()
+	defer unlock()
+	if root == nil {
+		return fmt.Errorf("the files API is not available")
+	}
+	if _, err := mfs.Lookup(root, ns.filesRoot); err == nil {
+		return nil
+	}
+	return mfs.Mkdir(root, ns.filesRoot, mfs.MkdirOpts{Mkparents: true, Flush: true})
+}
+
+// filesDefaultRoot are the files commands whose path defaults to the root.
+var filesDefaultRoot = map[string]bool{
+	"files/chcid": true,
+	"files/flush": true,
+	"files/ls":    true,
+}
+
+func namespaceFiles(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	if err := nss.ensureFilesRoot(ns); err != nil {
+		return err
+	}
+
+	args := q["arg"]
+	if len(args) == 0 && filesDefaultRoot[cmd] {
+		args = []string{"/"}
+	}
+	for i, arg := range args {
+		// the source of a copy can be outside of mfs
+		if cmd == "files/cp" && i == 0 && (strings.HasPrefix(arg, ipfsPathPrefix) || strings.HasPrefix(arg, ipnsPathPrefix)) {
+			continue
+		}
+		if strings.HasPrefix(arg, "/") {
+			args[i] = ns.filesPath(arg)
+		}
+	}
+	q["arg"] = args
+
+	next.ServeHTTP(w, withQuery(r, q))
+	return nil
+}
+
+func namespaceAdd(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	// these write files of the node, and --manifest named one before the
+	// clients sent its content
+	for _, opt := range []string{noCopyOptionName, carOutputOptionName, manifestOptionName} {
+		if _, ok := q[opt]; ok {
+			return errNamespaceForbidden("--%s is not available in API namespaces", opt)
+		}
+	}
+
+	// the added content is pinned by pin/add, to be in the pins of the
+	// namespace, or kept by --to-files
+	q.Set("pin", "false")
+
+	if dst := q.Get(toFilesOptionName); dst != "" {
+		if !strings.HasPrefix(dst, "/") {
+			return &namespaceError{status: http.StatusBadRequest, msg: fmt.Sprintf("invalid --%s path: %q", toFilesOptionName, dst)}
+		}
+		if err := nss.ensureFilesRoot(ns); err != nil {
+			return err
+		}
+		p := ns.filesPath(dst)
+		if strings.HasSuffix(dst, "/") {
+			p += "/"
+		}
+		q.Set(toFilesOptionName, p)
+	}
+
+	next.ServeHTTP(w, withQuery(r, q))
+	return nil
+}
+
+func namespacePublish(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	key := q.Get("key")
+	if key == "" {
+		key = q.Get("k")
+	}
+	if key == "" {
+		return errNamespaceForbidden("API namespaces must publish with --key")
+	}
+	q.Del("k")
+	q.Set("key", ns.keyPrefix+key)
+
+	next.ServeHTTP(w, withQuery(r, q))
+	return nil
+}
+
+func namespaceKeys(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	args := q["arg"]
+	switch cmd {
+	case "key/gen", "key/rm", "key/rename":
+		for i, arg := range args {
+			args[i] = ns.keyPrefix + arg
+		}
+	}
+	q["arg"] = args
+	q.Set("encoding", "json")
+
+	buf := newResponseBuffer()
+	next.ServeHTTP(buf, withQuery(r, q))
+	if buf.status != http.StatusOK || !strings.HasPrefix(buf.header.Get("Content-Type"), "application/json") {
+		buf.copyTo(w, buf.body.Bytes())
+		return nil
+	}
+
+	// the namespace only sees its keys, without the prefix
+	var out bytes.Buffer
+	dec := json.NewDecoder(&buf.body)
+	dec.UseNumber()
+	enc := json.NewEncoder(&out)
+	for {
+		var v map[string]interface{}
+		if err := dec.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		ns.stripKeyNames(v)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	buf.copyTo(w, out.Bytes())
+	return nil
+}
+
+// stripKeyNames removes the key prefix of ns from the names of keys in the
+// output v of a key command, and the keys of other namespaces from the
+// lists.
+func (ns *apiNamespace) stripKeyNames(v map[string]interface{}) {
+	for _, field := range []string{"Name", "Was", "Now"} {
+		if s, ok := v[field].(string); ok {
+			v[field] = strings.TrimPrefix(s, ns.keyPrefix)
+		}
+	}
+
+	keys, ok := v["Keys"].([]interface{})
+	if !ok {
+		return
+	}
+	filtered := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		key, ok := k.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := key["Name"].(string)
+		if !strings.HasPrefix(name, ns.keyPrefix) {
+			continue
+		}
+		key["Name"] = strings.TrimPrefix(name, ns.keyPrefix)
+		filtered = append(filtered, key)
+	}
+	v["Keys"] = filtered
+}
+
+// responseBuffer holds the response of a command to be inspected before
+// being sent.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *responseBuffer) Flush() {}
+
+// copyTo sends the buffered response to w, with body.
+func (b *responseBuffer) copyTo(w http.ResponseWriter, body []byte) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(b.status)
+	w.Write(body)
+}
+
+// writeNamespaceJSON sends v as the JSON output of a command.
+func writeNamespaceJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
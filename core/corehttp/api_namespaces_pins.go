@@ -0,0 +1,342 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	corecommands "github.com/ipfs/go-ipfs/core/commands"
+	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+)
+
+var (
+	namespacePinsKey     = ds.NewKey("/local/apinamespaces/pins")
+	namespaceExternalKey = ds.NewKey("/local/apinamespaces/external")
+	namespaceHoldersKey  = ds.NewKey("/local/apinamespaces/holders")
+)
+
+// namespacePins records the pins of each namespace in the datastore, under
+// /local/apinamespaces/pins/<namespace>/<cid>, and indexed by CID under
+// /local/apinamespaces/holders/<cid>/<namespace>. A CID stays pinned while a
+// namespace has it. The CIDs that were pinned outside of the namespaces
+// before a namespace pinned them are marked external, and aren't unpinned
+// when the namespaces remove them.
+type namespacePins struct {
+	lk sync.Mutex
+	ds ds.Datastore
+
+	// pending counts the pin/add running for each CID, which the pin/rm of
+	// other namespaces must not unpin. orphaned are the CIDs they left
+	// pinned for them.
+	pending  map[string]int
+	orphaned map[string]bool
+}
+
+func newNamespacePins(d ds.Datastore) *namespacePins {
+	return &namespacePins{
+		ds:       d,
+		pending:  make(map[string]int),
+		orphaned: make(map[string]bool),
+	}
+}
+
+func (p *namespacePins) key(ns string, c cid.Cid) ds.Key {
+	return namespacePinsKey.ChildString(ns).ChildString(c.String())
+}
+
+func (p *namespacePins) holderKey(ns string, c cid.Cid) ds.Key {
+	return namespaceHoldersKey.ChildString(c.String()).ChildString(ns)
+}
+
+// add records that ns has c. The lock must be held.
+func (p *namespacePins) add(ns string, c cid.Cid) error {
+	if err := p.ds.Put(p.holderKey(ns, c), nil); err != nil {
+		return err
+	}
+	return p.ds.Put(p.key(ns, c), nil)
+}
+
+// remove records that ns no longer has c. The lock must be held.
+func (p *namespacePins) remove(ns string, c cid.Cid) error {
+	if err := p.ds.Delete(p.key(ns, c)); err != nil {
+		return err
+	}
+	return p.ds.Delete(p.holderKey(ns, c))
+}
+
+// list returns the pins of ns. The lock must be held.
+func (p *namespacePins) list(ns string) ([]cid.Cid, error) {
+	res, err := p.ds.Query(dsq.Query{Prefix: namespacePinsKey.ChildString(ns).String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	all, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	cids := make([]cid.Cid, 0, len(all))
+	for _, e := range all {
+		c, err := cid.Decode(ds.NewKey(e.Key).BaseNamespace())
+		if err != nil {
+			return nil, fmt.Errorf("corrupt namespace pin %s: %s", e.Key, err)
+		}
+		cids = append(cids, c)
+	}
+	return cids, nil
+}
+
+// held returns whether a namespace has c, including the namespaces no longer
+// configured. The lock must be held.
+func (p *namespacePins) held(c cid.Cid) (bool, error) {
+	res, err := p.ds.Query(dsq.Query{
+		Prefix:   namespaceHoldersKey.ChildString(c.String()).String() + "/",
+		KeysOnly: true,
+		Limit:    1,
+	})
+	if err != nil {
+		return false, err
+	}
+	all, err := res.Rest()
+	if err != nil {
+		return false, err
+	}
+	return len(all) > 0, nil
+}
+
+// resolve resolves the paths args to CIDs.
+func (nss *apiNamespaces) resolve(ctx context.Context, args []string) ([]cid.Cid, error) {
+	cids := make([]cid.Cid, len(args))
+	for i, arg := range args {
+		p, err := coreiface.ParsePath(arg)
+		if err != nil {
+			return nil, &namespaceError{status: http.StatusBadRequest, msg: err.Error()}
+		}
+		rp, err := nss.api.ResolvePath(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		cids[i] = rp.Cid()
+	}
+	return cids, nil
+}
+
+// explicitPin returns the type of the pin of c, or "" if c isn't pinned
+// itself.
+func (nss *apiNamespaces) explicitPin(c cid.Cid) (string, error) {
+	mode, pinned, err := nss.node.Pinning.IsPinned(c)
+	if err != nil || !pinned || (mode != "recursive" && mode != "direct") {
+		return "", err
+	}
+	return mode, nil
+}
+
+func namespacePinAdd(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	if wait, err := boolOption(q, true, "wait"); err != nil {
+		return err
+	} else if !wait {
+		return errNamespaceForbidden("--wait=false is not available in API namespaces")
+	}
+
+	cids, err := nss.resolve(r.Context(), q["arg"])
+	if err != nil {
+		return err
+	}
+
+	pins := nss.pins
+	external := make(map[string]bool)
+	pins.lk.Lock()
+	for _, c := range cids {
+		k := c.String()
+		if pins.pending[k] == 0 {
+			held, err := pins.held(c)
+			if err != nil {
+				pins.lk.Unlock()
+				return err
+			}
+			mode, err := nss.explicitPin(c)
+			if err != nil {
+				pins.lk.Unlock()
+				return err
+			}
+			external[k] = !held && mode != ""
+		}
+		pins.pending[k]++
+	}
+	pins.lk.Unlock()
+
+	// pin what was resolved, the names could change meanwhile
+	args := make([]string, len(cids))
+	for i, c := range cids {
+		args[i] = ipfsPathPrefix + c.String()
+	}
+	q["arg"] = args
+	q.Del("progress")
+	q.Set("encoding", "json")
+
+	buf := newResponseBuffer()
+	next.ServeHTTP(buf, withQuery(r, q))
+	ok := buf.status == http.StatusOK && buf.header.Get("X-Stream-Error") == ""
+
+	pins.lk.Lock()
+	defer pins.lk.Unlock()
+	var recordErr error
+	for _, c := range cids {
+		k := c.String()
+		pins.pending[k]--
+		if pins.pending[k] == 0 {
+			delete(pins.pending, k)
+		}
+
+		if ok {
+			delete(pins.orphaned, k)
+			if external[k] {
+				if err := pins.ds.Put(namespaceExternalKey.ChildString(k), nil); err != nil {
+					recordErr = err
+				}
+			}
+			if err := pins.add(ns.name, c); err != nil {
+				recordErr = err
+			}
+			continue
+		}
+
+		// the pin was left for this one by a pin/rm
+		if pins.orphaned[k] && pins.pending[k] == 0 {
+			delete(pins.orphaned, k)
+			if err := nss.unpin(r.Context(), c); err != nil {
+				log.Warningf("failed to unpin %s of the API namespaces: %s", c, err)
+			}
+		}
+	}
+	if recordErr != nil {
+		return recordErr
+	}
+	buf.copyTo(w, buf.body.Bytes())
+	return nil
+}
+
+// unpin removes the pin of c if no namespace has it and it isn't external.
+// The lock must be held.
+func (nss *apiNamespaces) unpin(ctx context.Context, c cid.Cid) error {
+	pins := nss.pins
+	k := c.String()
+	held, err := pins.held(c)
+	if err != nil || held {
+		return err
+	}
+	if pins.pending[k] > 0 {
+		pins.orphaned[k] = true
+		return nil
+	}
+
+	extKey := namespaceExternalKey.ChildString(k)
+	if ext, err := pins.ds.Has(extKey); err != nil {
+		return err
+	} else if ext {
+		return pins.ds.Delete(extKey)
+	}
+
+	mode, err := nss.explicitPin(c)
+	if err != nil || mode == "" {
+		return err
+	}
+	_, err = corerepo.Unpin(nss.node, ctx, []string{ipfsPathPrefix + k}, mode == "recursive")
+	return err
+}
+
+func namespacePinRm(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	cids, err := nss.resolve(r.Context(), q["arg"])
+	if err != nil {
+		return err
+	}
+
+	pins := nss.pins
+	pins.lk.Lock()
+	defer pins.lk.Unlock()
+	for _, c := range cids {
+		if has, err := pins.ds.Has(pins.key(ns.name, c)); err != nil {
+			return err
+		} else if !has {
+			return &namespaceError{status: http.StatusBadRequest, msg: fmt.Sprintf("%s is not pinned in the namespace", c)}
+		}
+	}
+
+	out := &corecommands.PinOutput{Pins: make([]string, len(cids))}
+	for i, c := range cids {
+		if err := pins.remove(ns.name, c); err != nil {
+			return err
+		}
+		if err := nss.unpin(r.Context(), c); err != nil {
+			return err
+		}
+		out.Pins[i] = c.String()
+	}
+	return writeNamespaceJSON(w, out)
+}
+
+func namespacePinLs(nss *apiNamespaces, ns *apiNamespace, cmd string, q url.Values, w http.ResponseWriter, r *http.Request, next http.Handler) error {
+	typ := q.Get("type")
+	if typ == "" {
+		typ = q.Get("t")
+	}
+	switch typ {
+	case "":
+		typ = "all"
+	case "all", "direct", "indirect", "recursive":
+	default:
+		return &namespaceError{status: http.StatusBadRequest, msg: fmt.Sprintf("invalid type '%s', must be one of {direct, indirect, recursive, all}", typ)}
+	}
+
+	var args []cid.Cid
+	if len(q["arg"]) > 0 {
+		var err error
+		args, err = nss.resolve(r.Context(), q["arg"])
+		if err != nil {
+			return err
+		}
+	}
+
+	pins := nss.pins
+	pins.lk.Lock()
+	defer pins.lk.Unlock()
+
+	cids := args
+	if args == nil {
+		var err error
+		cids, err = pins.list(ns.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the namespace only sees its own pins, not those they pin indirectly
+	out := &corecommands.RefKeyList{Keys: make(map[string]corecommands.RefKeyObject)}
+	for _, c := range cids {
+		has, err := pins.ds.Has(pins.key(ns.name, c))
+		if err != nil {
+			return err
+		}
+		mode := ""
+		if has {
+			if mode, err = nss.explicitPin(c); err != nil {
+				return err
+			}
+		}
+		if mode == "" || (typ != "all" && typ != mode) {
+			if args != nil {
+				return &namespaceError{status: http.StatusBadRequest, msg: fmt.Sprintf("path '%s' is not pinned in the namespace", c)}
+			}
+			continue
+		}
+		out.Keys[c.String()] = corecommands.RefKeyObject{Type: mode}
+	}
+	return writeNamespaceJSON(w, out)
+}
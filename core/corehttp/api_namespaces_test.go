@@ -0,0 +1,260 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	corecommands "github.com/ipfs/go-ipfs/core/commands"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+)
+
+func TestAPINamespacesConfig(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nss, err := newAPINamespaces(n, nil, false); err != nil || nss != nil {
+		t.Fatalf("expected no namespaces, got %v (%v)", nss, err)
+	}
+	for _, cfg := range []map[string]APINamespaceConfig{
+		{"a": {}},
+		{"a/b": {Token: "t"}},
+		{"a": {Token: "t", FilesRoot: "/"}},
+		{"a": {Token: "t"}, "b": {Token: "t"}},
+		{"a": {Token: "t1", FilesRoot: "/apps"}, "b": {Token: "t2", FilesRoot: "/apps/b"}},
+		{"a": {Token: "t1", KeyPrefix: "app-"}, "b": {Token: "t2", KeyPrefix: "app-b-"}},
+	} {
+		if _, err := newAPINamespaces(n, cfg, false); err == nil {
+			t.Errorf("expected %v to be rejected", cfg)
+		}
+	}
+	if _, err := newAPINamespaces(n, nil, true); err == nil {
+		t.Error("expected a required namespace without namespaces to be rejected")
+	}
+
+	nss, err := newAPINamespaces(n, map[string]APINamespaceConfig{"app": {Token: "t"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := nss.namespaces[0]
+	if ns.filesRoot != "/namespaces/app" || ns.keyPrefix != "app-" {
+		t.Fatalf("unexpected defaults: %q, %q", ns.filesRoot, ns.keyPrefix)
+	}
+	for p, expected := range map[string]string{
+		"/":          "/namespaces/app",
+		"/a/b":       "/namespaces/app/a/b",
+		"/../../etc": "/namespaces/app/etc",
+		"/a/../../b": "/namespaces/app/b",
+	} {
+		if fp := ns.filesPath(p); fp != expected {
+			t.Errorf("expected %s to map to %s, got %s", p, expected, fp)
+		}
+	}
+}
+
+func TestAPINamespaces(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nss, err := newAPINamespaces(n, map[string]APINamespaceConfig{
+		"a": {Token: "token-a"},
+		"b": {Token: "token-b", FilesRoot: "/b-root", KeyPrefix: "bee-"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the commands record their query, and pin/add pins its arguments
+	var last url.Values
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		switch strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/") {
+		case "pin/add":
+			for _, arg := range last["arg"] {
+				c, err := cid.Decode(strings.TrimPrefix(arg, ipfsPathPrefix))
+				if err != nil {
+					t.Fatal(err)
+				}
+				nd, err := n.DAG.Get(r.Context(), c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := n.Pinning.Pin(r.Context(), nd, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n.Pinning.Flush()
+			json.NewEncoder(w).Encode(&corecommands.AddPinOutput{Pins: last["arg"]})
+		case "key/list":
+			w.Write([]byte(`{"Keys":[{"Name":"self","Id":"Qm1"},{"Name":"a-one","Id":"Qm2"},{"Name":"bee-two","Id":"Qm3"}]}` + "\n"))
+		case "key/gen":
+			w.Write([]byte(`{"Name":"` + last.Get("arg") + `","Id":"Qm4"}` + "\n"))
+		}
+	})
+	h := withAPINamespaces(next, nss)
+
+	do := func(token, cmd string, q url.Values) *httptest.ResponseRecorder {
+		last = nil
+		req := httptest.NewRequest("POST", APIPath+"/"+cmd+"?"+q.Encode(), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, token := range []string{"", "token-c"} {
+		if rec := do(token, "version", nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 with token %q, got %d", token, rec.Code)
+		}
+	}
+	for _, cmd := range []string{"config", "swarm/peers", "files/mirror", "key/use", "repo/gc"} {
+		if rec := do("token-a", cmd, nil); rec.Code != http.StatusForbidden || last != nil {
+			t.Errorf("expected %s to be forbidden, got %d", cmd, rec.Code)
+		}
+	}
+
+	// files
+	if rec := do("token-a", "files/ls", nil); rec.Code != http.StatusOK || last.Get("arg") != "/namespaces/a" {
+		t.Fatalf("expected files/ls to list the root of the namespace, got %d %v", rec.Code, last)
+	}
+	if _, err := mfs.Lookup(n.FilesRoot, "/namespaces/a"); err != nil {
+		t.Fatalf("expected the root of the namespace to be created: %s", err)
+	}
+	do("token-b", "files/cp", url.Values{"arg": {"/ipfs/QmSrc", "/../dst"}})
+	if args := last["arg"]; len(args) != 2 || args[0] != "/ipfs/QmSrc" || args[1] != "/b-root/dst" {
+		t.Fatalf("unexpected files/cp arguments: %v", args)
+	}
+	do("token-b", "files/mkdir", url.Values{"arg": {"/ipfs/x"}})
+	if last.Get("arg") != "/b-root/ipfs/x" {
+		t.Fatalf("expected mfs paths to be confined, got %v", last["arg"])
+	}
+
+	// add
+	for _, opt := range []string{"nocopy", "car-output", "manifest"} {
+		if rec := do("token-a", "add", url.Values{opt: {"/etc/passwd"}}); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected --%s to be forbidden, got %d", opt, rec.Code)
+		}
+	}
+	do("token-a", "add", url.Values{"pin": {"true"}, "to-files": {"/dir/"}})
+	if last.Get("pin") != "false" || last.Get("to-files") != "/namespaces/a/dir/" {
+		t.Fatalf("unexpected add options: %v", last)
+	}
+
+	// names
+	for _, pin := range []string{"", "true", "1"} {
+		if rec := do("token-a", "name/resolve", url.Values{"arg": {"/ipns/example.com"}, "pin": {pin}}); rec.Code != http.StatusForbidden || last != nil {
+			t.Fatalf("expected name/resolve --pin=%q to be forbidden, got %d", pin, rec.Code)
+		}
+	}
+	if rec := do("token-a", "name/resolve", url.Values{"arg": {"/ipns/example.com"}, "pin": {"false"}}); rec.Code != http.StatusOK || last.Get("arg") != "/ipns/example.com" {
+		t.Fatalf("expected name/resolve to be forwarded, got %d", rec.Code)
+	}
+
+	// keys
+	if rec := do("token-a", "name/publish", url.Values{"arg": {"/ipfs/QmSrc"}}); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected name/publish without --key to be forbidden, got %d", rec.Code)
+	}
+	do("token-b", "name/publish", url.Values{"arg": {"/ipfs/QmSrc"}, "k": {"site"}})
+	if last.Get("key") != "bee-site" || last.Get("k") != "" {
+		t.Fatalf("expected the key to be prefixed, got %v", last)
+	}
+	rec := do("token-b", "key/gen", url.Values{"arg": {"two"}})
+	if last.Get("arg") != "bee-two" || !strings.Contains(rec.Body.String(), `"Name":"two"`) {
+		t.Fatalf("expected key/gen to be prefixed, got %v: %s", last, rec.Body.String())
+	}
+	rec = do("token-a", "key/list", nil)
+	var keys corecommands.KeyOutputList
+	if err := json.Unmarshal(rec.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.Keys) != 1 || keys.Keys[0].Name != "one" || keys.Keys[0].Id != "Qm2" {
+		t.Fatalf("expected key/list to only list the keys of the namespace, got %v", keys)
+	}
+
+	// pins
+	shared, err := coreunix.Add(n, strings.NewReader("shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	external, err := coreunix.Add(n, strings.NewReader("external"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedCid, _ := cid.Decode(shared)
+	externalCid, _ := cid.Decode(external)
+	nd, err := n.DAG.Get(n.Context(), externalCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Pin(n.Context(), nd, true); err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := func(c cid.Cid) bool {
+		_, p, err := n.Pinning.IsPinned(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	pinLs := func(token string) map[string]corecommands.RefKeyObject {
+		rec := do(token, "pin/ls", nil)
+		var out corecommands.RefKeyList
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: %s", err, rec.Body.String())
+		}
+		return out.Keys
+	}
+
+	for _, token := range []string{"token-a", "token-b"} {
+		if rec := do(token, "pin/add", url.Values{"arg": {shared}}); rec.Code != http.StatusOK {
+			t.Fatalf("pin/add failed: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	if rec := do("token-a", "pin/add", url.Values{"arg": {external}}); rec.Code != http.StatusOK {
+		t.Fatalf("pin/add failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if keys := pinLs("token-a"); len(keys) != 2 || keys[shared].Type != "recursive" || keys[external].Type != "recursive" {
+		t.Fatalf("unexpected pins of a: %v", keys)
+	}
+	if keys := pinLs("token-b"); len(keys) != 1 || keys[shared].Type != "recursive" {
+		t.Fatalf("unexpected pins of b: %v", keys)
+	}
+
+	if rec := do("token-b", "pin/rm", url.Values{"arg": {external}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected b not to remove the pins of a, got %d", rec.Code)
+	}
+	if rec := do("token-a", "pin/rm", url.Values{"arg": {shared}}); rec.Code != http.StatusOK {
+		t.Fatalf("pin/rm failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if !pinned(sharedCid) {
+		t.Fatal("expected the pin of b to be kept")
+	}
+	if rec := do("token-b", "pin/rm", url.Values{"arg": {shared}}); rec.Code != http.StatusOK {
+		t.Fatalf("pin/rm failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if pinned(sharedCid) {
+		t.Fatal("expected the last namespace pinning it to unpin it")
+	}
+	if rec := do("token-a", "pin/rm", url.Values{"arg": {external}}); rec.Code != http.StatusOK {
+		t.Fatalf("pin/rm failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if !pinned(externalCid) {
+		t.Fatal("expected the pin made outside of the namespaces to be kept")
+	}
+	if keys := pinLs("token-a"); len(keys) != 0 {
+		t.Fatalf("expected a to have no pins, got %v", keys)
+	}
+}
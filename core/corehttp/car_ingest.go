@@ -0,0 +1,247 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	car "github.com/ipfs/go-ipfs/car"
+	core "github.com/ipfs/go-ipfs/core"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	bserv "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
+	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
+)
+
+// carIngestBatchSize is the number of blocks stored at once.
+const carIngestBatchSize = 64
+
+var errCarTooLarge = errors.New("the CAR file is larger than Gateway.CarIngest.MaxSize")
+
+// CarIngestConfig is read from the Gateway.CarIngest config section.
+type CarIngestConfig struct {
+	Enabled bool
+	// Tokens are the secrets accepted in the Authorization header of the
+	// uploads, as "Bearer <token>".
+	Tokens []string
+	// MaxSize bounds the size of the uploaded CAR files, in bytes. Zero
+	// doesn't limit it.
+	MaxSize int64
+}
+
+// CarIngestRoot is the outcome of an upload for one of the roots of the CAR
+// file.
+type CarIngestRoot struct {
+	Cid string
+	// Complete is set when all the blocks of the DAG are in the repo.
+	Complete bool
+	Pinned   bool
+}
+
+// CarIngestReport is the response to an upload.
+type CarIngestReport struct {
+	Roots []CarIngestRoot
+	// Blocks and Size are the number of blocks stored and their total size.
+	Blocks int
+	Size   uint64
+	// Message tells why the upload failed, the blocks read before the
+	// failure are stored nonetheless.
+	Message string `json:",omitempty"`
+}
+
+// CarIngestOption accepts CAR file uploads on path, when enabled in the
+// Gateway.CarIngest config section. The blocks are verified and stored, and
+// the roots optionally pinned.
+func CarIngestOption(path string) ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		var cfg CarIngestConfig
+		if err := repo.ConfigSection(n.Repo, "Gateway.CarIngest", &cfg); err != nil {
+			return nil, err
+		}
+		if !cfg.Enabled {
+			return mux, nil
+		}
+
+		h, err := newCarIngestHandler(n, cfg)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle(path, h)
+		return mux, nil
+	}
+}
+
+type carIngestHandler struct {
+	node *core.IpfsNode
+	cfg  CarIngestConfig
+}
+
+func newCarIngestHandler(n *core.IpfsNode, cfg CarIngestConfig) (*carIngestHandler, error) {
+	if len(cfg.Tokens) == 0 {
+		return nil, errors.New("Gateway.CarIngest.Tokens must list the tokens allowed to upload")
+	}
+	if cfg.MaxSize < 0 {
+		return nil, fmt.Errorf("invalid Gateway.CarIngest.MaxSize: %d", cfg.MaxSize)
+	}
+	return &carIngestHandler{node: n, cfg: cfg}, nil
+}
+
+func (h *carIngestHandler) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+
+	ok := false
+	for _, t := range h.cfg.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func (h *carIngestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		webErrorWithCode(w, "car ingest", errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="car ingest"`)
+		webErrorWithCode(w, "car ingest", errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	pin := false
+	if s := r.URL.Query().Get("pin"); s != "" {
+		var err error
+		if pin, err = strconv.ParseBool(s); err != nil {
+			webErrorWithCode(w, "car ingest", fmt.Errorf("invalid pin argument %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var body io.Reader = r.Body
+	if h.cfg.MaxSize > 0 {
+		body = &boundedReader{r: r.Body, n: h.cfg.MaxSize, err: errCarTooLarge}
+	}
+
+	report, status := h.ingest(r.Context(), body, pin)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// ingest stores the blocks of the CAR file in body and pins its roots if
+// asked to, returning the report and the status of the response.
+func (h *carIngestHandler) ingest(ctx context.Context, body io.Reader, pin bool) (*CarIngestReport, int) {
+	n := h.node
+	report := &CarIngestReport{Roots: []CarIngestRoot{}}
+
+	// keep the blocks from being collected until they are pinned
+	defer n.Blockstore.PinLock().Unlock()
+
+	fail := func(err error) (*CarIngestReport, int) {
+		report.Message = err.Error()
+		switch err {
+		case errCarTooLarge:
+			return report, http.StatusRequestEntityTooLarge
+		case context.Canceled, context.DeadlineExceeded:
+			return report, http.StatusRequestTimeout
+		default:
+			return report, http.StatusBadRequest
+		}
+	}
+
+	cr, err := car.NewReader(body)
+	if err != nil {
+		return fail(err)
+	}
+
+	batch := make([]blocks.Block, 0, carIngestBatchSize)
+	flush := func() error {
+		if err := n.Blocks.AddBlocks(batch); err != nil {
+			return err
+		}
+		report.Blocks += len(batch)
+		for _, b := range batch {
+			report.Size += uint64(len(b.RawData()))
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		b, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			batch = append(batch, b)
+			if len(batch) < carIngestBatchSize {
+				continue
+			}
+			err = flush()
+		}
+		if err != nil {
+			// store what was read so far, an upload can be resumed
+			if ferr := flush(); ferr != nil {
+				log.Warningf("car ingest: cannot store blocks: %s", ferr)
+			}
+			return fail(err)
+		}
+	}
+	if err := flush(); err != nil {
+		report.Message = err.Error()
+		return report, http.StatusInternalServerError
+	}
+
+	// only the blocks in the repo count, nothing is fetched from the network
+	offlineDAG := dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore)))
+
+	var incomplete []string
+	for _, c := range cr.Header.Roots {
+		root := CarIngestRoot{Cid: c.String()}
+		root.Complete = dag.FetchGraph(ctx, c, offlineDAG) == nil
+		if !root.Complete {
+			incomplete = append(incomplete, root.Cid)
+		}
+
+		if pin && root.Complete {
+			nd, err := offlineDAG.Get(ctx, c)
+			if err == nil {
+				err = n.Pinning.Pin(ctx, nd, true)
+			}
+			if err != nil {
+				report.Message = fmt.Sprintf("cannot pin %s: %s", c, err)
+				return report, http.StatusInternalServerError
+			}
+			root.Pinned = true
+			n.ProvideNewPin(c)
+		}
+		report.Roots = append(report.Roots, root)
+	}
+	if pin {
+		if err := n.Pinning.Flush(); err != nil {
+			report.Message = err.Error()
+			return report, http.StatusInternalServerError
+		}
+	}
+
+	if pin && len(incomplete) > 0 {
+		report.Message = fmt.Sprintf("incomplete DAGs, not pinned: %s", strings.Join(incomplete, ", "))
+		return report, http.StatusUnprocessableEntity
+	}
+	return report, http.StatusOK
+}
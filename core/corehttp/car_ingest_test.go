@@ -0,0 +1,122 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	car "github.com/ipfs/go-ipfs/car"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+)
+
+func makeCar(t *testing.T, root cid.Cid, nds ...*dag.ProtoNode) []byte {
+	var buf bytes.Buffer
+	cw, err := car.NewWriter(&buf, []cid.Cid{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range nds {
+		if err := cw.Put(nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestCarIngest(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCarIngestHandler(n, CarIngestConfig{Enabled: true}); err == nil {
+		t.Fatal("expected the handler to require tokens")
+	}
+	h, err := newCarIngestHandler(n, CarIngestConfig{Enabled: true, Tokens: []string{"secret"}, MaxSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	child := dag.NodeWithData([]byte("child"))
+	parent := dag.NodeWithData([]byte("parent"))
+	if err := parent.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(token, query string, body []byte) (*CarIngestReport, int) {
+		req, err := http.NewRequest("POST", ts.URL+"/car"+query, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusMethodNotAllowed {
+			return nil, res.StatusCode
+		}
+		var report CarIngestReport
+		if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return &report, res.StatusCode
+	}
+
+	full := makeCar(t, parent.Cid(), parent, child)
+	if _, status := post("", "", full); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", status)
+	}
+	if _, status := post("wrong", "", full); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %d", status)
+	}
+	if res, err := http.Get(ts.URL + "/car"); err != nil || res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET to be refused: %v", err)
+	}
+
+	// the DAG is incomplete without the child
+	report, status := post("secret", "?pin=true", makeCar(t, parent.Cid(), parent))
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for an incomplete DAG, got %d: %+v", status, report)
+	}
+	if report.Blocks != 1 || len(report.Roots) != 1 || report.Roots[0].Complete || report.Roots[0].Pinned {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	report, status = post("secret", "?pin=true", full)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", status, report)
+	}
+	if report.Blocks != 2 || len(report.Roots) != 1 || !report.Roots[0].Complete || !report.Roots[0].Pinned {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if _, pinned, err := n.Pinning.IsPinned(parent.Cid()); err != nil || !pinned {
+		t.Fatalf("expected the root to be pinned: %v", err)
+	}
+
+	// blocks that don't match their CID are rejected
+	corrupted := append([]byte{}, full...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	if report, status := post("secret", "", corrupted); status != http.StatusBadRequest || report.Message == "" {
+		t.Fatalf("expected 400 for a corrupted CAR, got %d: %+v", status, report)
+	}
+
+	if report, status := post("secret", "", make([]byte, 2048)); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 past MaxSize, got %d: %+v", status, report)
+	}
+
+	// the blocks were stored
+	if _, err := n.DAG.Get(context.Background(), child.Cid()); err != nil {
+		t.Fatal(err)
+	}
+}
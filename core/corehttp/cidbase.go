@@ -0,0 +1,224 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	multibase "gx/ipfs/QmekxXDhCxCJRNuzmHreuaT3BsuJcsjcXWNrtV9C8DRHtd/go-multibase"
+)
+
+// CidBaseHeader is the request header API clients can use to ask for all
+// CIDs in JSON responses to be encoded in the given multibase.
+const CidBaseHeader = "Accept-Cid-Base"
+
+var cidBases = map[string]multibase.Encoding{
+	"base16":    multibase.Base16,
+	"base32":    multibase.Base32,
+	"base32hex": multibase.Base32hex,
+	"base58btc": multibase.Base58BTC,
+	"base64":    multibase.Base64,
+	"base64url": multibase.Base64url,
+}
+
+// withCidBase wraps an API handler so that, if the client sent a
+// CidBaseHeader, the CIDs of the known CID fields of its JSON output are
+// re-encoded in the requested base. CIDv0 can only be represented in
+// base58btc, so they are upgraded to CIDv1 for any other base.
+func withCidBase(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(CidBaseHeader)
+		if name == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		base, ok := cidBases[strings.ToLower(name)]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unsupported %s %q", CidBaseHeader, name), http.StatusBadRequest)
+			return
+		}
+
+		cw := &cidBaseWriter{ResponseWriter: w, base: base}
+		defer cw.finish()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// cidBaseWriter passes JSON output through a goroutine rewriting CIDs and
+// leaves any other output untouched.
+type cidBaseWriter struct {
+	http.ResponseWriter
+	base multibase.Encoding
+
+	started bool
+	pw      *io.PipeWriter
+	done    chan struct{}
+}
+
+func (w *cidBaseWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return
+	}
+	w.Header().Del("Content-Length")
+
+	pr, pw := io.Pipe()
+	w.pw = pw
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		pr.CloseWithError(rebaseJSONStream(pr, w.ResponseWriter, w.base))
+	}()
+}
+
+func (w *cidBaseWriter) WriteHeader(code int) {
+	w.start()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cidBaseWriter) Write(b []byte) (int, error) {
+	w.start()
+	if w.pw == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.pw.Write(b)
+}
+
+// Flush is a no-op for JSON output, which is flushed after every value
+// by the rewriting goroutine.
+func (w *cidBaseWriter) Flush() {
+	if w.pw != nil {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *cidBaseWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *cidBaseWriter) finish() {
+	if w.pw == nil {
+		return
+	}
+	w.pw.Close()
+	<-w.done
+}
+
+// rebaseJSONStream copies a stream of JSON values from r to w, re-encoding
+// the CIDs they contain. If the stream isn't valid JSON, the rest of it is
+// copied unchanged.
+func rebaseJSONStream(r io.Reader, w io.Writer, base multibase.Encoding) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			_, err = io.Copy(w, io.MultiReader(dec.Buffered(), r))
+			return err
+		}
+
+		if err := enc.Encode(rebaseCids(v, "", base)); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// cidFields are the fields of the command outputs holding CIDs or /ipfs/
+// paths, or lists of them. The other strings are left alone: peer IDs and
+// key IDs parse as CIDv0 too.
+var cidFields = map[string]bool{
+	"/":     true,
+	"Cid":   true,
+	"Hash":  true,
+	"Key":   true,
+	"Path":  true,
+	"Pins":  true,
+	"Ref":   true,
+	"Root":  true,
+	"Roots": true,
+}
+
+// cidKeyFields are the fields of the command outputs holding maps keyed by
+// CIDs.
+var cidKeyFields = map[string]bool{
+	"Keys": true,
+}
+
+// rebaseCids walks a decoded JSON value and re-encodes the CIDs and the
+// /ipfs/ paths of its known CID fields, field being the name of the field
+// holding v.
+func rebaseCids(v interface{}, field string, base multibase.Encoding) interface{} {
+	switch v := v.(type) {
+	case string:
+		if !cidFields[field] {
+			return v
+		}
+		return rebaseCidString(v, base)
+	case []interface{}:
+		for i := range v {
+			v[i] = rebaseCids(v[i], field, base)
+		}
+		return v
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			if cidKeyFields[field] {
+				out[rebaseCidString(k, base)] = rebaseCids(val, "", base)
+			} else {
+				out[k] = rebaseCids(val, k, base)
+			}
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func rebaseCidString(s string, base multibase.Encoding) string {
+	if strings.HasPrefix(s, "/ipfs/") {
+		parts := strings.SplitN(s[len("/ipfs/"):], "/", 2)
+		parts[0] = rebaseCidString(parts[0], base)
+		return "/ipfs/" + strings.Join(parts, "/")
+	}
+
+	c, err := cid.Decode(s)
+	if err != nil {
+		return s
+	}
+
+	if c.Version() == 0 {
+		if base == multibase.Base58BTC {
+			return s
+		}
+		c = cid.NewCidV1(cid.DagProtobuf, c.Hash())
+	}
+
+	out, err := multibase.Encode(base, c.Bytes())
+	if err != nil {
+		return s
+	}
+	return out
+}
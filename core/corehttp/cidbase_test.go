@@ -0,0 +1,100 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	multibase "gx/ipfs/QmekxXDhCxCJRNuzmHreuaT3BsuJcsjcXWNrtV9C8DRHtd/go-multibase"
+)
+
+const testCidV0 = "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+
+func TestCidBaseRewritesJSON(t *testing.T) {
+	h := withCidBase(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Hash":%q,"Path":"/ipfs/%s/a","Name":"foo"}`+"\n", testCidV0, testCidV0)
+		w.(http.Flusher).Flush()
+		fmt.Fprintf(w, `{"Keys":{%q:{"Type":"recursive"}}}`+"\n", testCidV0)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v0/test", nil)
+	req.Header.Set(CidBaseHeader, "base32")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	c, _ := cid.Decode(testCidV0)
+	want, err := multibase.Encode(multibase.Base32, cid.NewCidV1(cid.DagProtobuf, c.Hash()).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(rec.Body)
+	var first struct{ Hash, Path, Name string }
+	if err := dec.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Hash != want || first.Path != "/ipfs/"+want+"/a" || first.Name != "foo" {
+		t.Fatalf("unexpected output: %+v", first)
+	}
+
+	var second struct{ Keys map[string]interface{} }
+	if err := dec.Decode(&second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := second.Keys[want]; !ok {
+		t.Fatalf("map key not rebased: %v", second.Keys)
+	}
+}
+
+func TestCidBaseLeavesTextAlone(t *testing.T) {
+	h := withCidBase(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, testCidV0)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v0/test", nil)
+	req.Header.Set(CidBaseHeader, "base32")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if strings.TrimSpace(rec.Body.String()) != testCidV0 {
+		t.Fatalf("text output was modified: %q", rec.Body.String())
+	}
+}
+
+func TestCidBaseRejectsUnknownBase(t *testing.T) {
+	h := withCidBase(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called")
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v0/test", nil)
+	req.Header.Set(CidBaseHeader, "base1000")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestCidBaseLeavesPeerIDsAlone(t *testing.T) {
+	const peerID = "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe"
+	h := withCidBase(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"ID":%q,"Peers":[{"Peer":%q}],"Keys":[{"Name":"self","Id":%q}],"Extra":{%q:1}}`+"\n", peerID, peerID, peerID, peerID)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v0/id", nil)
+	req.Header.Set(CidBaseHeader, "base32")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if n := strings.Count(rec.Body.String(), peerID); n != 4 {
+		t.Fatalf("expected the peer IDs to be left alone, got %s", rec.Body.String())
+	}
+}
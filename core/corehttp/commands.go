@@ -13,6 +13,7 @@ import (
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	"github.com/ipfs/go-ipfs/core"
 	corecommands "github.com/ipfs/go-ipfs/core/commands"
+	repo "github.com/ipfs/go-ipfs/repo"
 
 	cmds "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds"
 	cmdsHttp "gx/ipfs/QmPXR4tNdLbp8HsZiPMjpsgqphX9Vhw2J6Jh5MKH2ovW3D/go-ipfs-cmds/http"
@@ -129,8 +130,30 @@ func commandsOption(cctx oldcmds.Context, command *cmds.Command) ServeOption {
 		addCORSDefaults(cfg)
 		patchCORSVars(cfg, l.Addr())
 
+		var limits APILimitsConfig
+		if err := repo.ConfigSection(n.Repo, "API.Limits", &limits); err != nil {
+			return nil, err
+		}
+		limiter, err := newAPILimiter(limits)
+		if err != nil {
+			return nil, err
+		}
+
+		var namespaces map[string]APINamespaceConfig
+		if err := repo.ConfigSection(n.Repo, "API.Namespaces", &namespaces); err != nil {
+			return nil, err
+		}
+		var requireNamespace bool
+		if err := repo.ConfigSection(n.Repo, "API.RequireNamespace", &requireNamespace); err != nil {
+			return nil, err
+		}
+		nss, err := newAPINamespaces(n, namespaces, requireNamespace)
+		if err != nil {
+			return nil, err
+		}
+
 		cmdHandler := cmdsHttp.NewHandler(&cctx, command, cfg)
-		mux.Handle(APIPath+"/", cmdHandler)
+		mux.Handle(APIPath+"/", withAPINamespaces(withAPILimits(withCidBase(cmdHandler), limiter), nss))
 		return mux, nil
 	}
 }
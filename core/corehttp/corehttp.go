@@ -43,7 +43,7 @@ func makeHandler(n *core.IpfsNode, l net.Listener, options ...ServeOption) (http
 			return nil, err
 		}
 	}
-	return topMux, nil
+	return withRequestTracing(topMux), nil
 }
 
 // ListenAndServe runs an HTTP server listening at |listeningMultiAddr| with
@@ -70,7 +70,13 @@ func ListenAndServe(n *core.IpfsNode, listeningMultiAddr string, options ...Serv
 	return Serve(n, manet.NetListener(list), options...)
 }
 
+// Serve serves the given serve options on lis until the node closes.
 func Serve(node *core.IpfsNode, lis net.Listener, options ...ServeOption) error {
+	return ServeWithOptions(node, lis, nil, options...)
+}
+
+// ServeWithOptions is Serve with a server tuned by so, if it isn't nil.
+func ServeWithOptions(node *core.IpfsNode, lis net.Listener, so *ServerOptions, options ...ServeOption) error {
 	// make sure we close this no matter what.
 	defer lis.Close()
 
@@ -90,8 +96,12 @@ func Serve(node *core.IpfsNode, lis net.Listener, options ...ServeOption) error
 	default:
 	}
 
-	server := &http.Server{
-		Handler: handler,
+	if so == nil {
+		so = &ServerOptions{}
+	}
+	server, lis, err := so.server(handler, lis)
+	if err != nil {
+		return err
 	}
 
 	var serverError error
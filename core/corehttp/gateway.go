@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"time"
 
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	repo "github.com/ipfs/go-ipfs/repo"
 
 	id "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/protocol/identify"
 )
@@ -16,6 +18,16 @@ type GatewayConfig struct {
 	Headers      map[string][]string
 	Writable     bool
 	PathPrefixes []string
+	Transform    GatewayTransformConfig
+
+	// FirstByteTimeout and RequestTimeout abort requests, see
+	// GatewayTimeoutConfig. Zero disables them.
+	FirstByteTimeout time.Duration
+	RequestTimeout   time.Duration
+
+	// IPNSMaxAge is the max-age of the /ipns/ responses, see
+	// GatewayCacheConfig. Zero sets no Cache-Control.
+	IPNSMaxAge time.Duration
 }
 
 func GatewayOption(writable bool, paths ...string) ServeOption {
@@ -25,15 +37,61 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			return nil, err
 		}
 
+		var transform GatewayTransformConfig
+		if err := repo.ConfigSection(n.Repo, "Gateway.Transform", &transform); err != nil {
+			return nil, err
+		}
+		if _, err := transform.cacheSize(); err != nil {
+			return nil, err
+		}
+		if _, err := transform.maxInputSize(); err != nil {
+			return nil, err
+		}
+
+		timeouts := GatewayTimeoutConfig{FirstByte: DefaultGatewayFirstByteTimeout}
+		if err := repo.ConfigSection(n.Repo, "Gateway.Timeouts", &timeouts); err != nil {
+			return nil, err
+		}
+		firstByte, request, err := timeouts.durations()
+		if err != nil {
+			return nil, err
+		}
+
+		var cache GatewayCacheConfig
+		if err := repo.ConfigSection(n.Repo, "Gateway.Cache", &cache); err != nil {
+			return nil, err
+		}
+		ipnsMaxAge, err := cache.ipnsMaxAge()
+		if err != nil {
+			return nil, err
+		}
+
+		var templates GatewayTemplatesConfig
+		if err := repo.ConfigSection(n.Repo, "Gateway.Templates", &templates); err != nil {
+			return nil, err
+		}
+		sites, err := loadGatewayTemplates(n, templates)
+		if err != nil {
+			return nil, err
+		}
+
 		gateway := newGatewayHandler(n, GatewayConfig{
-			Headers:      cfg.Gateway.HTTPHeaders,
-			Writable:     writable,
-			PathPrefixes: cfg.Gateway.PathPrefixes,
+			Headers:          cfg.Gateway.HTTPHeaders,
+			Writable:         writable,
+			PathPrefixes:     cfg.Gateway.PathPrefixes,
+			Transform:        transform,
+			FirstByteTimeout: firstByte,
+			RequestTimeout:   request,
+			IPNSMaxAge:       ipnsMaxAge,
 		}, coreapi.NewCoreAPI(n))
+		gateway.templates = sites
 
 		for _, p := range paths {
 			mux.Handle(p+"/", gateway)
 		}
+		if templates.hasAssets() {
+			mux.Handle(GatewayAssetsPath, sites)
+		}
 		return mux, nil
 	}
 }
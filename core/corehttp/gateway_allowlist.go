@@ -0,0 +1,151 @@
+package corehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	isd "gx/ipfs/QmZmmuAXgX73UQmX1jRKjTGmjzq24Jinqkq8vzkBtno4uX/go-is-domain"
+)
+
+var errGatewayNotAllowed = errors.New("this gateway only serves the content of its allowlist")
+
+// GatewayAllowlistOption restricts the gateway to the roots of the
+// Gateway.Allowlist config. The other /ipfs/ and /ipns/ paths, and the
+// read-only API which could fetch them, get a 403. It must follow
+// GatewayHostnameOption or IPNSHostnameOption, for the DNSLink domains and
+// the subdomains of the Host header to be checked.
+func GatewayAllowlistOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		var roots []string
+		if err := repo.ConfigSection(n.Repo, "Gateway.Allowlist", &roots); err != nil {
+			return nil, err
+		}
+		allowlist, err := NewGatewayAllowlist(roots)
+		if err != nil {
+			return nil, err
+		}
+		if allowlist == nil {
+			return mux, nil
+		}
+
+		childMux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			p := r.URL.Path
+			content := strings.HasPrefix(p, ipfsPathPrefix) || strings.HasPrefix(p, ipnsPathPrefix)
+			if (content && !allowlist.Allows(p)) || strings.HasPrefix(p, "/api/") {
+				webErrorWithCode(w, "ipfs gateway", errGatewayNotAllowed, http.StatusForbidden)
+				return
+			}
+			childMux.ServeHTTP(w, r)
+		})
+		return childMux, nil
+	}
+}
+
+// GatewayAllowlist is the set of roots the gateway serves, read from the
+// Gateway.Allowlist config. A request is served if the first component of
+// its path, the CID after /ipfs/ or the name after /ipns/, is allowed.
+type GatewayAllowlist struct {
+	// cids are keyed by the CIDv1 of the allowed CIDs, for the CIDv0 and
+	// CIDv1 of a DAG to match
+	cids  map[string]bool
+	names map[string]bool
+	// patterns are the names with wildcards, matched with path.Match
+	patterns []string
+}
+
+// NewGatewayAllowlist returns the allowlist of the roots, given as
+// /ipfs/<cid> or /ipns/<name>. The names are IPNS names or DNSLink domains,
+// where * matches any part of a domain, e.g. /ipns/*.example.com. It returns
+// nil, allowing everything, if there are no roots.
+func NewGatewayAllowlist(roots []string) (*GatewayAllowlist, error) {
+	if len(roots) == 0 {
+		return nil, nil
+	}
+	a := &GatewayAllowlist{
+		cids:  make(map[string]bool),
+		names: make(map[string]bool),
+	}
+	for _, root := range roots {
+		switch {
+		case strings.HasPrefix(root, ipfsPathPrefix):
+			c, err := cid.Decode(strings.TrimPrefix(root, ipfsPathPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Gateway.Allowlist root %q: %s", root, err)
+			}
+			a.cids[cidKey(c)] = true
+		case strings.HasPrefix(root, ipnsPathPrefix):
+			name := normalizeName(strings.TrimPrefix(root, ipnsPathPrefix))
+			if name == "" || strings.Contains(name, "/") {
+				return nil, fmt.Errorf("invalid Gateway.Allowlist root %q: expected /ipns/<name>", root)
+			}
+			if !strings.ContainsAny(name, "*?[") {
+				a.names[name] = true
+				continue
+			}
+			if _, err := path.Match(name, ""); err != nil {
+				return nil, fmt.Errorf("invalid Gateway.Allowlist root %q: %s", root, err)
+			}
+			a.patterns = append(a.patterns, name)
+		default:
+			return nil, fmt.Errorf("invalid Gateway.Allowlist root %q: expected /ipfs/<cid> or /ipns/<name>", root)
+		}
+	}
+	return a, nil
+}
+
+func cidKey(c cid.Cid) string {
+	return cid.NewCidV1(c.Type(), c.Hash()).KeyString()
+}
+
+// normalizeName lowers the case of domains, the peer IDs are case
+// sensitive.
+func normalizeName(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if strings.ContainsAny(name, "*?[") || isd.IsDomain(name) {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// Allows returns whether the gateway serves urlPath.
+func (a *GatewayAllowlist) Allows(urlPath string) bool {
+	if a == nil {
+		return true
+	}
+
+	var prefix string
+	switch {
+	case strings.HasPrefix(urlPath, ipfsPathPrefix):
+		prefix = ipfsPathPrefix
+	case strings.HasPrefix(urlPath, ipnsPathPrefix):
+		prefix = ipnsPathPrefix
+	default:
+		return false
+	}
+	root := strings.SplitN(strings.TrimPrefix(urlPath, prefix), "/", 2)[0]
+
+	if prefix == ipfsPathPrefix {
+		c, err := cid.Decode(root)
+		return err == nil && a.cids[cidKey(c)]
+	}
+
+	name := normalizeName(root)
+	if a.names[name] {
+		return true
+	}
+	for _, p := range a.patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package corehttp
+
+import (
+	"testing"
+)
+
+func TestGatewayAllowlist(t *testing.T) {
+	a, err := NewGatewayAllowlist([]string{
+		"/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn",
+		"/ipns/QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd",
+		"/ipns/docs.example.com",
+		"/ipns/*.sites.example.org",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, allowed := range map[string]bool{
+		"/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn":              true,
+		"/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn/a/b.html":     true,
+		"/ipfs/bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354": true,
+		"/ipfs/QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR":              false,
+		"/ipfs/notacid": false,
+		"/ipfs/":        false,
+		"/ipns/QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd/": true,
+		"/ipns/qmsrpmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd":  false,
+		"/ipns/docs.example.com/index.html":                     true,
+		"/ipns/DOCS.example.com.":                               true,
+		"/ipns/blog.example.com":                                false,
+		"/ipns/a.sites.example.org":                             true,
+		"/ipns/a.b.sites.example.org":                           true,
+		"/ipns/sites.example.org":                               false,
+		"/api/v0/cat":                                           false,
+	} {
+		if a.Allows(p) != allowed {
+			t.Errorf("expected %s allowed to be %t", p, allowed)
+		}
+	}
+
+	var none *GatewayAllowlist
+	if !none.Allows("/ipfs/QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR") {
+		t.Error("expected no allowlist to allow everything")
+	}
+}
+
+func TestGatewayAllowlistInvalid(t *testing.T) {
+	if a, err := NewGatewayAllowlist(nil); a != nil || err != nil {
+		t.Fatal("expected no allowlist without roots")
+	}
+	for _, root := range []string{
+		"QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn",
+		"/ipfs/notacid",
+		"/ipns/",
+		"/ipns/example.com/path",
+		"/ipns/[.example.com",
+	} {
+		if _, err := NewGatewayAllowlist([]string{root}); err == nil {
+			t.Errorf("expected %q to be rejected", root)
+		}
+	}
+}
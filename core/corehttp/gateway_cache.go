@@ -0,0 +1,78 @@
+package corehttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// immutableCacheControl is the Cache-Control of the content of /ipfs/ paths,
+// which can't change.
+const immutableCacheControl = "public, max-age=29030400, immutable"
+
+// GatewayCacheConfig is read from the Gateway.Cache config section.
+type GatewayCacheConfig struct {
+	// IPNSMaxAge is how long the responses of /ipns/ paths, including the
+	// DNSLink websites, may be cached, parsed with time.ParseDuration. They
+	// have no Cache-Control when it is empty.
+	IPNSMaxAge string
+}
+
+func (c GatewayCacheConfig) ipnsMaxAge() (time.Duration, error) {
+	if c.IPNSMaxAge == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.IPNSMaxAge)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid Gateway.Cache.IPNSMaxAge: %q", c.IPNSMaxAge)
+	}
+	return d, nil
+}
+
+// cacheControl returns the Cache-Control of a response for urlPath: the
+// immutable one for the /ipfs/ content which is, and the configured max-age
+// for the /ipns/ paths.
+func (i *gatewayHandler) cacheControl(urlPath string, immutable bool) string {
+	switch {
+	case strings.HasPrefix(urlPath, ipfsPathPrefix):
+		if immutable {
+			return immutableCacheControl
+		}
+	case strings.HasPrefix(urlPath, ipnsPathPrefix):
+		if maxAge := int64(i.config.IPNSMaxAge / time.Second); maxAge > 0 {
+			return fmt.Sprintf("public, max-age=%d", maxAge)
+		}
+	}
+	return ""
+}
+
+// setCacheHeaders sets the ETag and the Cache-Control of a response for
+// urlPath, and answers 304 Not Modified if the request already has the
+// content, returning true. The headers are sent with the 304 for caches to
+// refresh their entry.
+func (i *gatewayHandler) setCacheHeaders(w http.ResponseWriter, r *http.Request, etag, urlPath string, immutable bool) bool {
+	w.Header().Set("Etag", etag)
+	if cc := i.cacheControl(urlPath, immutable); cc != "" {
+		w.Header().Set("Cache-Control", cc)
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagMatch returns whether the If-None-Match list inm matches etag, by the
+// weak comparison of RFC 7232.
+func etagMatch(inm, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+
+	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
+)
+
+func TestEtagMatch(t *testing.T) {
+	for _, test := range []struct {
+		inm   string
+		etag  string
+		match bool
+	}{
+		{`"a"`, `"a"`, true},
+		{`W/"a"`, `"a"`, true},
+		{`"a"`, `W/"a"`, true},
+		{`"b", "a"`, `"a"`, true},
+		{`*`, `"a"`, true},
+		{`"b"`, `"a"`, false},
+		{`"ab"`, `"a"`, false},
+	} {
+		if etagMatch(test.inm, test.etag) != test.match {
+			t.Errorf("expected If-None-Match %s to match %s: %v", test.inm, test.etag, test.match)
+		}
+	}
+}
+
+func TestGatewayCacheHeaders(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+
+	// the Gateway.Cache section can't be set on the mock repo
+	gatewayOption := func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		gateway := newGatewayHandler(n, GatewayConfig{IPNSMaxAge: time.Minute}, coreapi.NewCoreAPI(n))
+		mux.Handle("/ipfs/", gateway)
+		mux.Handle("/ipns/", gateway)
+		return mux, nil
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, gatewayOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString("/ipfs/" + k)
+	emptyDirCid := strings.TrimPrefix(emptyDir, "/ipfs/")
+
+	for _, test := range []struct {
+		path         string
+		inm          string
+		status       int
+		etag         string
+		cacheControl string
+		lastModified bool
+	}{
+		{"/ipfs/" + k, "", http.StatusOK, `"` + k + `"`, immutableCacheControl, true},
+		{"/ipfs/" + k, `"other", W/"` + k + `"`, http.StatusNotModified, `"` + k + `"`, immutableCacheControl, false},
+		{"/ipfs/" + k, `*`, http.StatusNotModified, `"` + k + `"`, immutableCacheControl, false},
+		{"/ipfs/" + k, `"other"`, http.StatusOK, `"` + k + `"`, immutableCacheControl, true},
+		{"/ipns/example.com", "", http.StatusOK, `"` + k + `"`, "public, max-age=60", false},
+		{"/ipns/example.com", `"` + k + `"`, http.StatusNotModified, `"` + k + `"`, "public, max-age=60", false},
+		{emptyDir + "/", "", http.StatusOK, `W/"DirIndex-` + emptyDirCid + `"`, "", false},
+		{emptyDir + "/", `W/"DirIndex-` + emptyDirCid + `"`, http.StatusNotModified, `W/"DirIndex-` + emptyDirCid + `"`, "", false},
+		{emptyDir + "/", `"` + emptyDirCid + `"`, http.StatusOK, `W/"DirIndex-` + emptyDirCid + `"`, "", false},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.inm != "" {
+			req.Header.Set("If-None-Match", test.inm)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.status {
+			t.Errorf("got %d, expected %d from %s with If-None-Match %s", res.StatusCode, test.status, test.path, test.inm)
+			continue
+		}
+		if etag := res.Header.Get("Etag"); etag != test.etag {
+			t.Errorf("unexpected ETag from %s: expected %s, got %s", test.path, test.etag, etag)
+		}
+		if cc := res.Header.Get("Cache-Control"); cc != test.cacheControl {
+			t.Errorf("unexpected Cache-Control from %s: expected %q, got %q", test.path, test.cacheControl, cc)
+		}
+		if lm := res.Header.Get("Last-Modified"); (lm != "") != test.lastModified {
+			t.Errorf("unexpected Last-Modified from %s: %q", test.path, lm)
+		}
+	}
+}
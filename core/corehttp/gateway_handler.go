@@ -1,6 +1,7 @@
 package corehttp
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -15,6 +16,7 @@ import (
 
 	core "github.com/ipfs/go-ipfs/core"
 	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
 	"github.com/ipfs/go-ipfs/dagutils"
 	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
 	"gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer"
@@ -39,9 +41,13 @@ const (
 // gatewayHandler is a HTTP handler that serves IPFS objects (accessible by default at /ipfs/<path>)
 // (it serves requests like GET /ipfs/QmVRzPKPzNtSrEzBFm2UZfxmPAgnaLke4DMcerbsGGSaFe/link)
 type gatewayHandler struct {
-	node   *core.IpfsNode
-	config GatewayConfig
-	api    coreiface.CoreAPI
+	node       *core.IpfsNode
+	config     GatewayConfig
+	api        coreiface.CoreAPI
+	transforms *transformCache
+	noFetch    noFetchBackend
+	// templates are the pages customized by Gateway.Templates
+	templates gatewaySites
 }
 
 func newGatewayHandler(n *core.IpfsNode, c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
@@ -50,6 +56,12 @@ func newGatewayHandler(n *core.IpfsNode, c GatewayConfig, api coreiface.CoreAPI)
 		config: c,
 		api:    api,
 	}
+	if c.Transform.Enabled {
+		// the sizes are validated by GatewayOption
+		size, _ := c.Transform.cacheSize()
+		maxInput, _ := c.Transform.maxInputSize()
+		i.transforms = newTransformCache(c.Transform.CacheEntries, size, maxInput)
+	}
 	return i
 }
 
@@ -64,7 +76,11 @@ func (i *gatewayHandler) newDagFromReader(r io.Reader) (ipld.Node, error) {
 
 // TODO(btc): break this apart into separate handlers using a more expressive muxer
 func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(i.node.Context(), time.Hour)
+	fallback := time.Hour
+	if i.config.RequestTimeout > fallback {
+		fallback = i.config.RequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(contextWithTrace(i.node.Context(), r.Context()), fallback)
 	// the hour is a hard fallback, we don't expect it to happen, but just in case
 	defer cancel()
 
@@ -79,6 +95,12 @@ func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}()
 	}
 
+	tw := newTimeoutWriter(w, cancel)
+	defer tw.stop()
+	tw.after(i.config.FirstByteTimeout, TimeoutFirstByte)
+	tw.after(i.config.RequestTimeout, TimeoutRequest)
+	w = tw
+
 	defer func() {
 		if r := recover(); r != nil {
 			log.Error("A panic occurred in the gateway handler!")
@@ -148,7 +170,8 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 		}
 	}
 
-	// IPNSHostnameOption might have constructed an IPNS path using the Host header.
+	// IPNSHostnameOption or GatewayHostnameOption might have constructed an IPNS
+	// path using the Host header.
 	// In this case, we need the original path for constructing redirects
 	// and links that match the requested URL.
 	// For example, http://example.net would become /ipns/example.net, and
@@ -162,21 +185,28 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 
 	parsedPath, err := coreiface.ParsePath(urlPath)
 	if err != nil {
-		webError(w, "invalid ipfs path", err, http.StatusBadRequest)
+		i.webError(w, r, "invalid ipfs path", err, http.StatusBadRequest)
 		return
 	}
 
+	api, dserv := i.backend(r)
+
 	// Resolve path to the final DAG node for the ETag
-	resolvedPath, err := i.api.ResolvePath(ctx, parsedPath)
+	resolvedPath, err := api.ResolvePath(ctx, parsedPath)
 	if err == coreiface.ErrOffline && !i.node.OnlineMode() {
-		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusServiceUnavailable)
+		i.webError(w, r, "ipfs resolve -r "+escapedURLPath, err, http.StatusServiceUnavailable)
 		return
 	} else if err != nil {
-		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusNotFound)
+		// the sites of the hostnames can answer their missing paths with
+		// the rules of their _redirects file
+		if ipnsHostname && isNotFound(err) && i.serveRedirects(ctx, w, r, api, urlPath, prefix) {
+			return
+		}
+		i.webError(w, r, "ipfs resolve -r "+escapedURLPath, err, http.StatusNotFound)
 		return
 	}
 
-	dr, err := i.api.Unixfs().Cat(ctx, resolvedPath)
+	dr, err := api.Unixfs().Cat(ctx, resolvedPath)
 	dir := false
 	switch err {
 	case nil:
@@ -185,20 +215,30 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 	case coreiface.ErrIsDir:
 		dir = true
 	default:
-		webError(w, "ipfs cat "+escapedURLPath, err, http.StatusNotFound)
+		i.webError(w, r, "ipfs cat "+escapedURLPath, err, http.StatusNotFound)
 		return
 	}
 
-	// Check etag send back to us
-	etag := "\"" + resolvedPath.Cid().String() + "\""
-	if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-None-Match") == "W/"+etag {
-		w.WriteHeader(http.StatusNotModified)
-		return
+	// Derived representations need their own etag, the original one would
+	// match the untransformed content.
+	var transformer GatewayTransformer
+	etagValue := resolvedPath.Cid().String()
+	if tname := r.URL.Query().Get(transformQueryParam); tname != "" && !dir {
+		t, ok := i.getTransformer(tname)
+		if !ok {
+			i.webError(w, r, "ipfs gateway transform", fmt.Errorf("unknown transform %q", tname), http.StatusBadRequest)
+			return
+		}
+		transformer = t
+		etagValue = transformKey(resolvedPath.Cid(), transformParams(t, r.URL.Query()))
 	}
 
+	// The ETags are strong, derived from the CIDs, except the one of the
+	// directory listings generated by the gateway, which can change with it.
+	etag := "\"" + etagValue + "\""
+
 	i.addUserHeaders(w) // ok, _now_ write user's headers.
 	w.Header().Set("X-IPFS-Path", urlPath)
-	w.Header().Set("Etag", etag)
 
 	// set 'allowed' headers
 	// & expose those headers
@@ -206,12 +246,15 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 		"Content-Range",
 		"X-Chunked-Output",
 		"X-Stream-Output",
+		RequestIDHeader,
 	}
 
 	var allowedHeaders = strings.Join(allowedHeadersArr, ", ")
 
-	w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
-	w.Header().Set("Access-Control-Expose-Headers", allowedHeaders)
+	// Range is the request header of the range requests of video players,
+	// and Accept-Ranges tells them the content is seekable.
+	w.Header().Set("Access-Control-Allow-Headers", "Range, "+allowedHeaders)
+	w.Header().Set("Access-Control-Expose-Headers", "Accept-Ranges, Content-Length, "+allowedHeaders)
 
 	// Suborigin header, sandboxes apps from each other in the browser (even
 	// though they are served from the same gateway domain).
@@ -238,7 +281,7 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 
 		base32Encoded, err := multibase.Encode(multibase.Base32, suboriginRaw)
 		if err != nil {
-			internalWebError(w, err)
+			i.internalWebError(w, r, err)
 			return
 		}
 
@@ -246,20 +289,19 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 		w.Header().Set("Suborigin", suborigin)
 	}
 
-	// set these headers _after_ the error, for we may just not have it
-	// and dont want the client to cache a 500 response...
-	// and only if it's /ipfs!
-	// TODO: break this out when we split /ipfs /ipns routes.
-	modtime := time.Now()
-
-	if strings.HasPrefix(urlPath, ipfsPathPrefix) && !dir {
-		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
-
-		// set modtime to a really long time ago, since files are immutable and should stay cached
+	// The content of /ipfs paths is immutable, set its modtime to a really
+	// long time ago for it to stay cached. The /ipns paths have no modtime,
+	// and so no Last-Modified: they change when their name is updated.
+	var modtime time.Time
+	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
 		modtime = time.Unix(1, 0)
 	}
 
 	if !dir {
+		if i.setCacheHeaders(w, r, etag, urlPath, true) {
+			return
+		}
+
 		urlFilename := r.URL.Query().Get("filename")
 		var name string
 		if urlFilename != "" {
@@ -268,19 +310,26 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 		} else {
 			name = gopath.Base(urlPath)
 		}
+		if transformer != nil {
+			i.serveTransformed(ctx, w, r, transformer, etagValue, name, modtime, dr)
+			return
+		}
+		if nd, err := api.ResolveNode(ctx, resolvedPath); err == nil {
+			setRecordedContentType(w, nd)
+		}
 		i.serveFile(w, r, name, modtime, dr)
 		return
 	}
 
-	nd, err := i.api.ResolveNode(ctx, resolvedPath)
+	nd, err := api.ResolveNode(ctx, resolvedPath)
 	if err != nil {
-		internalWebError(w, err)
+		i.internalWebError(w, r, err)
 		return
 	}
 
-	dirr, err := uio.NewDirectoryFromNode(i.node.DAG, nd)
+	dirr, err := uio.NewDirectoryFromNode(dserv, nd)
 	if err != nil {
-		internalWebError(w, err)
+		i.internalWebError(w, r, err)
 		return
 	}
 
@@ -295,31 +344,59 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 			return
 		}
 
-		dr, err := i.api.Unixfs().Cat(ctx, coreiface.IpfsPath(ixnd.Cid()))
+		if i.setCacheHeaders(w, r, etag, urlPath, true) {
+			return
+		}
+
+		dr, err := api.Unixfs().Cat(ctx, coreiface.IpfsPath(ixnd.Cid()))
 		if err != nil {
-			internalWebError(w, err)
+			i.internalWebError(w, r, err)
 			return
 		}
 		defer dr.Close()
 
 		// write to request
-		http.ServeContent(w, r, "index.html", modtime, dr)
+		setRecordedContentType(w, ixnd)
+		i.serveFile(w, r, "index.html", modtime, dr)
 		return
 	default:
-		internalWebError(w, err)
+		i.internalWebError(w, r, err)
 		return
 	case os.IsNotExist(err):
 	}
 
+	// the listing isn't immutable, it depends on the version of the gateway
+	if i.setCacheHeaders(w, r, "W/\"DirIndex-"+etagValue+"\"", urlPath, false) {
+		return
+	}
+
 	if r.Method == "HEAD" {
 		return
 	}
 
 	// storage for directory listing
 	var dirListing []directoryItem
+	var hasMeta bool
 	dirr.ForEachLink(ctx, func(link *ipld.Link) error {
 		// See comment above where originalUrlPath is declared.
-		di := directoryItem{humanize.Bytes(link.Size), link.Name, gopath.Join(originalUrlPath, link.Name)}
+		di := directoryItem{
+			Size: humanize.Bytes(link.Size),
+			Name: link.Name,
+			Path: gopath.Join(originalUrlPath, link.Name),
+		}
+		// the metadata stored by 'ipfs add --preserve-mode/mtime', when
+		// the node is local: fetching all of them would slow the listing
+		m, err := localFileMeta(i.node, link.Cid)
+		if err != nil {
+			log.Debugf("cannot get the metadata of %s: %s", link.Cid, err)
+		}
+		if m.Mode != 0 {
+			di.Mode = m.Mode.String()
+		}
+		if m.Mtime != 0 {
+			di.Mtime = time.Unix(m.Mtime, 0).UTC().Format(time.RFC3339)
+		}
+		hasMeta = hasMeta || !m.IsZero()
 		dirListing = append(dirListing, di)
 		return nil
 	})
@@ -358,43 +435,67 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 
 	// See comment above where originalUrlPath is declared.
 	tplData := listingTemplateData{
-		Listing:  dirListing,
-		Path:     originalUrlPath,
-		BackLink: backLink,
+		Listing:    dirListing,
+		Path:       originalUrlPath,
+		BackLink:   backLink,
+		HasMeta:    hasMeta,
+		AssetsPath: GatewayAssetsPath,
 	}
-	err = listingTemplate.Execute(w, tplData)
+	err = i.templates.site(r).dirIndex.Execute(w, tplData)
 	if err != nil {
-		internalWebError(w, err)
+		i.internalWebError(w, r, err)
 		return
 	}
 }
 
-type sizeReadSeeker interface {
-	Size() uint64
+// serveFile serves content with its ranges, seeking lazily for only the
+// blocks of the requested ranges to be fetched.
+func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
+	ls, err := newLazySeeker(content)
+	if err != nil {
+		i.internalWebError(w, req, err)
+		return
+	}
 
-	io.ReadSeeker
+	http.ServeContent(w, req, name, modtime, ls)
 }
 
-type sizeSeeker struct {
-	sizeReadSeeker
+// setRecordedContentType sets the Content-Type header to the MIME type
+// recorded by 'ipfs add --detect-mime' for the file nd, sparing its
+// detection. The one set in Gateway.HTTPHeaders is kept.
+func setRecordedContentType(w http.ResponseWriter, nd ipld.Node) {
+	if w.Header().Get("Content-Type") != "" {
+		return
+	}
+	if t := coreunix.MimeType(nd); t != "" {
+		w.Header().Set("Content-Type", t)
+	}
 }
 
-func (s *sizeSeeker) Seek(offset int64, whence int) (int64, error) {
-	if whence == io.SeekEnd && offset == 0 {
-		return int64(s.Size()), nil
+// getTransformer returns the named transformer if transformations are
+// enabled on this gateway.
+func (i *gatewayHandler) getTransformer(name string) (GatewayTransformer, bool) {
+	if i.transforms == nil {
+		return nil, false
 	}
-
-	return s.sizeReadSeeker.Seek(offset, whence)
+	return getGatewayTransformer(name)
 }
 
-func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
-	if sp, ok := content.(sizeReadSeeker); ok {
-		content = &sizeSeeker{
-			sizeReadSeeker: sp,
-		}
+func (i *gatewayHandler) serveTransformed(ctx context.Context, w http.ResponseWriter, req *http.Request, t GatewayTransformer, key, name string, modtime time.Time, content io.Reader) {
+	tf, err := i.transforms.transform(ctx, t, key, name, transformParams(t, req.URL.Query()), content)
+	if err == errTransformInputTooLarge {
+		i.webError(w, req, "ipfs gateway transform "+t.Name(), err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		i.webError(w, req, "ipfs gateway transform "+t.Name(), err, http.StatusInternalServerError)
+		return
 	}
 
-	http.ServeContent(w, req, name, modtime, content)
+	if tf.contentType != "" {
+		w.Header().Set("Content-Type", tf.contentType)
+	}
+	http.ServeContent(w, req, name, modtime, bytes.NewReader(tf.data))
 }
 
 func (i *gatewayHandler) postHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -600,27 +701,56 @@ func (i *gatewayHandler) addUserHeaders(w http.ResponseWriter) {
 }
 
 func webError(w http.ResponseWriter, message string, err error, defaultCode int) {
+	webErrorWithCode(w, message, err, webErrorCode(err, defaultCode))
+}
+
+// webErrorCode returns the status of the response to err.
+func webErrorCode(err error, defaultCode int) int {
 	if _, ok := err.(resolver.ErrNoLink); ok {
-		webErrorWithCode(w, message, err, http.StatusNotFound)
+		return http.StatusNotFound
 	} else if err == routing.ErrNotFound {
-		webErrorWithCode(w, message, err, http.StatusNotFound)
+		return http.StatusNotFound
 	} else if err == context.DeadlineExceeded {
-		webErrorWithCode(w, message, err, http.StatusRequestTimeout)
-	} else {
-		webErrorWithCode(w, message, err, defaultCode)
+		return http.StatusRequestTimeout
 	}
+	return defaultCode
 }
 
 func webErrorWithCode(w http.ResponseWriter, message string, err error, code int) {
+	writeWebError(w, message, err, code)
+	if code >= 500 {
+		log.Warningf("server error: %s: %s", message, err)
+	}
+}
+
+func writeWebError(w http.ResponseWriter, message string, err error, code int) {
 	w.WriteHeader(code)
 
 	fmt.Fprintf(w, "%s: %s\n", message, err)
-	if code >= 500 {
-		log.Warningf("server error: %s: %s", err)
-	}
 }
 
 // return a 500 error and log
 func internalWebError(w http.ResponseWriter, err error) {
 	webErrorWithCode(w, "internalWebError", err, http.StatusInternalServerError)
 }
+
+// localFileMeta returns the metadata stored in the unixfs node c, if it is in
+// the blockstore of n.
+func localFileMeta(n *core.IpfsNode, c cid.Cid) (coreunix.FileMeta, error) {
+	if c.Type() != cid.DagProtobuf {
+		return coreunix.FileMeta{}, nil
+	}
+	has, err := n.Blockstore.Has(c)
+	if err != nil || !has {
+		return coreunix.FileMeta{}, err
+	}
+	b, err := n.Blockstore.Get(c)
+	if err != nil {
+		return coreunix.FileMeta{}, err
+	}
+	pn, err := dag.DecodeProtobuf(b.RawData())
+	if err != nil {
+		return coreunix.FileMeta{}, err
+	}
+	return coreunix.FileMetaFromNode(pn)
+}
@@ -0,0 +1,406 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	isd "gx/ipfs/QmZmmuAXgX73UQmX1jRKjTGmjzq24Jinqkq8vzkBtno4uX/go-is-domain"
+	multibase "gx/ipfs/QmekxXDhCxCJRNuzmHreuaT3BsuJcsjcXWNrtV9C8DRHtd/go-multibase"
+)
+
+// libp2pKeyCodec is the multicodec of the CIDs of the peer IDs, used in the
+// subdomains of the IPNS names.
+const libp2pKeyCodec = 0x72
+
+// GatewaySpec is the gateway behavior of a hostname, the values of the
+// Gateway.PublicGateways config.
+type GatewaySpec struct {
+	// Paths are the path prefixes served on the hostname, e.g. /ipfs, /ipns
+	// or /api. The other paths get a 404.
+	Paths []string
+
+	// UseSubdomains serves the content of /ipfs/<cid> and /ipns/<name> from
+	// the subdomains <cid>.ipfs.<hostname> and <name>.ipns.<hostname>, for
+	// every site to have its own origin. The paths redirect to them.
+	UseSubdomains bool
+
+	// NoDNSLink disables the DNSLink website of the hostname itself, served
+	// on the paths which aren't in Paths.
+	NoDNSLink bool
+
+	// NoFetch only serves the content of the repo, the missing blocks aren't
+	// fetched from the network and the IPNS names are resolved from the
+	// records of the repo.
+	NoFetch bool
+}
+
+func (spec *GatewaySpec) hasPath(p string) bool {
+	for _, prefix := range spec.Paths {
+		if prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// GatewayHostnameOption serves the hostnames of the Gateway.PublicGateways
+// config with their GatewaySpec, and the subdomains of the ones using
+// subdomains. The requests for the other hostnames are served like
+// IPNSHostnameOption does, which it replaces.
+func GatewayHostnameOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		var specs map[string]*GatewaySpec
+		if err := repo.ConfigSection(n.Repo, "Gateway.PublicGateways", &specs); err != nil {
+			return nil, err
+		}
+		hosts, err := newGatewayHosts(specs)
+		if err != nil {
+			return nil, err
+		}
+		var templates GatewayTemplatesConfig
+		if err := repo.ConfigSection(n.Repo, "Gateway.Templates", &templates); err != nil {
+			return nil, err
+		}
+		proxies, err := loadTrustedProxies(n)
+		if err != nil {
+			return nil, err
+		}
+
+		childMux := http.NewServeMux()
+		mux.Handle("/", gatewayHostnameHandler(n, hosts, proxies, templates.hasAssets(), childMux))
+		return childMux, nil
+	}
+}
+
+// gatewayHosts are the specs of the Gateway.PublicGateways config, by
+// hostname.
+type gatewayHosts map[string]*GatewaySpec
+
+func newGatewayHosts(specs map[string]*GatewaySpec) (gatewayHosts, error) {
+	hosts := make(gatewayHosts, len(specs))
+	for host, spec := range specs {
+		if spec == nil {
+			continue
+		}
+		h := requestHostname(host)
+		if h != host || strings.ContainsAny(h, ":/[]") {
+			return nil, fmt.Errorf("invalid Gateway.PublicGateways hostname %q: expected a lowercase hostname without port", host)
+		}
+
+		s := *spec
+		s.Paths = make([]string, len(spec.Paths))
+		for i, p := range spec.Paths {
+			if !strings.HasPrefix(p, "/") {
+				return nil, fmt.Errorf("invalid Gateway.PublicGateways path %q of %s: expected an absolute path", p, host)
+			}
+			if p != "/" {
+				p = strings.TrimSuffix(p, "/")
+			}
+			s.Paths[i] = p
+		}
+		hosts[h] = &s
+	}
+	return hosts, nil
+}
+
+// subdomain splits host into the label, the namespace and the hostname of a
+// subdomain <label>.<ns>.<hostname>, of a hostname using subdomains for the
+// namespace.
+func (hosts gatewayHosts) subdomain(host string) (label, ns, hostname string, spec *GatewaySpec, ok bool) {
+	parts := strings.SplitN(host, ".", 3)
+	if len(parts) != 3 || (parts[1] != "ipfs" && parts[1] != "ipns") {
+		return "", "", "", nil, false
+	}
+	spec = hosts[parts[2]]
+	if spec == nil || !spec.UseSubdomains || !spec.hasPath("/"+parts[1]) {
+		return "", "", "", nil, false
+	}
+	return parts[0], parts[1], parts[2], spec, true
+}
+
+func gatewayHostnameHandler(n *core.IpfsNode, hosts gatewayHosts, proxies trustedProxies, assets bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		useForwardedHeaders(r, proxies)
+		host := requestHostname(r.Host)
+
+		// the assets of the gateway templates are served on every hostname
+		if assets && strings.HasPrefix(r.URL.Path, GatewayAssetsPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if spec, ok := hosts[host]; ok {
+			switch {
+			case spec.hasPath(r.URL.Path):
+				if spec.UseSubdomains {
+					if u, ok := subdomainURL(r); ok {
+						http.Redirect(w, r, u, http.StatusMovedPermanently)
+						return
+					}
+				}
+				next.ServeHTTP(w, withGatewaySpec(r, spec))
+			case !spec.NoDNSLink && rewriteDNSLink(n, r, host):
+				next.ServeHTTP(w, withGatewaySpec(r, spec))
+			default:
+				http.NotFound(w, r)
+			}
+			return
+		}
+
+		if label, ns, _, spec, ok := hosts.subdomain(host); ok {
+			name := label
+			switch ns {
+			case "ipfs":
+				c, err := cid.Decode(label)
+				if err != nil {
+					webError(w, "invalid ipfs subdomain "+label, err, http.StatusBadRequest)
+					return
+				}
+				// the CIDs have a single subdomain, in base32
+				l, err := cidLabel(c)
+				if err != nil {
+					internalWebError(w, err)
+					return
+				}
+				if l != label {
+					u := *r.URL
+					u.Scheme = requestScheme(r)
+					u.Host = l + r.Host[strings.Index(r.Host, "."):]
+					http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+					return
+				}
+			case "ipns":
+				name = ipnsNameFromLabel(label)
+			}
+
+			r.Header.Set("X-Ipns-Original-Path", r.URL.Path)
+			r.URL.Path = "/" + ns + "/" + name + r.URL.Path
+			next.ServeHTTP(w, withGatewaySpec(r, spec))
+			return
+		}
+
+		rewriteDNSLink(n, r, host)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// subdomainURL returns the URL of the subdomain serving the /ipfs/ or /ipns/
+// path of the request, if it has one.
+func subdomainURL(r *http.Request) (string, bool) {
+	// "", ns, root, rest
+	parts := strings.SplitN(r.URL.EscapedPath(), "/", 4)
+	if len(parts) < 3 || (parts[1] != "ipfs" && parts[1] != "ipns") {
+		return "", false
+	}
+	root, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return "", false
+	}
+
+	var label string
+	switch ns := parts[1]; {
+	case ns == "ipfs":
+		c, err := cid.Decode(root)
+		if err != nil {
+			return "", false
+		}
+		if label, err = cidLabel(c); err != nil {
+			return "", false
+		}
+	default:
+		if id, err := peer.IDB58Decode(root); err == nil {
+			if label, err = cidLabel(cid.NewCidV1(libp2pKeyCodec, []byte(id))); err != nil {
+				return "", false
+			}
+		} else if c, err := cid.Decode(root); err == nil && c.Type() == libp2pKeyCodec {
+			if label, err = cidLabel(c); err != nil {
+				return "", false
+			}
+		} else if isd.IsDomain(root) {
+			label = dnslinkLabel(root)
+		} else {
+			return "", false
+		}
+	}
+
+	u := requestScheme(r) + "://" + label + "." + parts[1] + "." + r.Host + "/"
+	if len(parts) == 4 {
+		u += parts[3]
+	}
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+	return u, true
+}
+
+// cidLabel returns the subdomain label of c, its CIDv1 in base32.
+func cidLabel(c cid.Cid) (string, error) {
+	if c.Version() == 0 {
+		c = cid.NewCidV1(cid.DagProtobuf, c.Hash())
+	}
+	return multibase.Encode(multibase.Base32, c.Bytes())
+}
+
+// ipnsNameFromLabel returns the IPNS name of a subdomain label, which is
+// either the CID of a peer ID or a DNSLink domain.
+func ipnsNameFromLabel(label string) string {
+	if c, err := cid.Decode(label); err == nil && c.Type() == libp2pKeyCodec {
+		if id, err := peer.IDFromBytes(c.Hash()); err == nil {
+			return id.Pretty()
+		}
+	}
+
+	// the dots of the domain are dashes, and its dashes are doubled
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		switch {
+		case label[i] != '-':
+			b.WriteByte(label[i])
+		case i+1 < len(label) && label[i+1] == '-':
+			b.WriteByte('-')
+			i++
+		default:
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
+
+// dnslinkLabel returns the subdomain label of a DNSLink domain, decoded by
+// ipnsNameFromLabel.
+func dnslinkLabel(domain string) string {
+	return strings.Replace(strings.Replace(domain, "-", "--", -1), ".", "-", -1)
+}
+
+// requestHostname returns the lowercase hostname of the Host header, without
+// the port.
+func requestHostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// trustedProxies are the networks of the Gateway.TrustedProxies config, the
+// reverse proxies whose X-Forwarded-Host and X-Forwarded-Proto headers are
+// used.
+type trustedProxies []*net.IPNet
+
+func newTrustedProxies(addrs []string) (trustedProxies, error) {
+	proxies := make(trustedProxies, 0, len(addrs))
+	for _, a := range addrs {
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid Gateway.TrustedProxies address %q: expected an IP address or a CIDR", a)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Gateway.TrustedProxies address %q: expected an IP address or a CIDR", a)
+		}
+		proxies = append(proxies, ipnet)
+	}
+	return proxies, nil
+}
+
+func loadTrustedProxies(n *core.IpfsNode) (trustedProxies, error) {
+	var addrs []string
+	if err := repo.ConfigSection(n.Repo, "Gateway.TrustedProxies", &addrs); err != nil {
+		return nil, err
+	}
+	return newTrustedProxies(addrs)
+}
+
+// trusts reports whether the request comes from a trusted proxy.
+func (proxies trustedProxies) trusts(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range proxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastHeaderValue returns the last of the comma-separated values of a header,
+// the one appended by the closest proxy.
+func lastHeaderValue(r *http.Request, name string) string {
+	values := r.Header[http.CanonicalHeaderKey(name)]
+	if len(values) == 0 {
+		return ""
+	}
+	v := values[len(values)-1]
+	return strings.TrimSpace(v[strings.LastIndex(v, ",")+1:])
+}
+
+// useForwardedHeaders replaces the Host of the request with the
+// X-Forwarded-Host header of a trusted reverse proxy, for the hostnames and
+// the redirects to be the ones of the client, and keeps the last value of its
+// X-Forwarded-Proto header for requestScheme. The headers of the other
+// clients are removed, they could set any hostname.
+func useForwardedHeaders(r *http.Request, proxies trustedProxies) {
+	if !proxies.trusts(r) {
+		r.Header.Del("X-Forwarded-Host")
+		r.Header.Del("X-Forwarded-Proto")
+		return
+	}
+	if h := lastHeaderValue(r, "X-Forwarded-Host"); h != "" {
+		r.Host = h
+	}
+	if proto := lastHeaderValue(r, "X-Forwarded-Proto"); proto != "" {
+		r.Header.Set("X-Forwarded-Proto", proto)
+	} else {
+		r.Header.Del("X-Forwarded-Proto")
+	}
+}
+
+// requestScheme returns the scheme of the request, from the
+// X-Forwarded-Proto header of a trusted reverse proxy terminating TLS if
+// there is one, once useForwardedHeaders kept its last value.
+func requestScheme(r *http.Request) string {
+	proto := r.Header.Get("X-Forwarded-Proto")
+	switch proto = strings.ToLower(proto); proto {
+	case "http", "https":
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+type gatewaySpecKey struct{}
+
+// withGatewaySpec attaches the spec of its hostname to the request, read by
+// the gateway handler.
+func withGatewaySpec(r *http.Request, spec *GatewaySpec) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), gatewaySpecKey{}, spec))
+}
+
+func gatewaySpecFromRequest(r *http.Request) *GatewaySpec {
+	spec, _ := r.Context().Value(gatewaySpecKey{}).(*GatewaySpec)
+	return spec
+}
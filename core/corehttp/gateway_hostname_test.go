@@ -0,0 +1,306 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	core "github.com/ipfs/go-ipfs/core"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
+)
+
+func TestGatewayHostsInvalid(t *testing.T) {
+	for _, specs := range []map[string]*GatewaySpec{
+		{"Example.com": {Paths: []string{"/ipfs"}}},
+		{"localhost:8080": {Paths: []string{"/ipfs"}}},
+		{"example.com": {Paths: []string{"ipfs"}}},
+	} {
+		if _, err := newGatewayHosts(specs); err == nil {
+			t.Errorf("expected %v to be invalid", specs)
+		}
+	}
+
+	hosts, err := newGatewayHosts(map[string]*GatewaySpec{
+		"example.com": {Paths: []string{"/ipfs/"}},
+		"example.net": nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || !hosts["example.com"].hasPath("/ipfs/Qm") || hosts["example.com"].hasPath("/ipfsx") {
+		t.Fatalf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestIPNSNameFromLabel(t *testing.T) {
+	for domain, label := range map[string]string{
+		"example.com":              "example-com",
+		"en.wikipedia-on-ipfs.org": "en-wikipedia--on--ipfs-org",
+	} {
+		if l := dnslinkLabel(domain); l != label {
+			t.Errorf("expected label %s for %s, got %s", label, domain, l)
+		}
+		if d := ipnsNameFromLabel(label); d != domain {
+			t.Errorf("expected domain %s for %s, got %s", domain, label, d)
+		}
+	}
+
+	id := "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe"
+	r := httptest.NewRequest("GET", "/ipns/"+id+"/a", nil)
+	r.Host = "localhost"
+	u, ok := subdomainURL(r)
+	if !ok {
+		t.Fatal("expected a subdomain for the peer ID")
+	}
+	label := strings.TrimPrefix(u, "http://")
+	label = label[:strings.Index(label, ".")]
+	if name := ipnsNameFromLabel(label); name != id {
+		t.Fatalf("expected the peer ID %s from %s, got %s", id, u, name)
+	}
+}
+
+func TestGatewayHostname(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+
+	specs := map[string]*GatewaySpec{
+		"localhost":         {Paths: []string{"/ipfs", "/ipns"}, UseSubdomains: true},
+		"gw.example.com":    {Paths: []string{"/ipfs"}, NoFetch: true},
+		"site.example.com":  {Paths: []string{"/ipfs"}},
+		"nodns.example.com": {Paths: []string{"/ipfs"}, NoDNSLink: true},
+	}
+	hostnameOption := func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		hosts, err := newGatewayHosts(specs)
+		if err != nil {
+			return nil, err
+		}
+		childMux := http.NewServeMux()
+		mux.Handle("/", gatewayHostnameHandler(n, hosts, nil, false, childMux))
+		return childMux, nil
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, hostnameOption, GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cid.Decode(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label, err := cidLabel(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString("/ipfs/" + k)
+	ns["/ipns/site.example.com"] = path.FromString("/ipfs/" + k)
+	ns["/ipns/nodns.example.com"] = path.FromString("/ipfs/" + k)
+
+	for _, test := range []struct {
+		host     string
+		path     string
+		status   int
+		text     string
+		location string
+	}{
+		// the paths redirect to the subdomains
+		{"localhost:8080", "/ipfs/" + k + "?filename=a.txt", http.StatusMovedPermanently, "", "http://" + label + ".ipfs.localhost:8080/?filename=a.txt"},
+		{"localhost", "/ipns/example.com/a/b", http.StatusMovedPermanently, "", "http://example-com.ipns.localhost/a/b"},
+		{"localhost", "/version", http.StatusNotFound, "", ""},
+		{label + ".ipfs.localhost:8080", "/", http.StatusOK, "fnord", ""},
+		{"example-com.ipns.localhost", "/", http.StatusOK, "fnord", ""},
+		{"invalid.ipfs.localhost", "/", http.StatusBadRequest, "", ""},
+
+		// the path gateways only serve their paths, and their DNSLink
+		{"gw.example.com", "/ipfs/" + k, http.StatusOK, "fnord", ""},
+		{"gw.example.com", "/ipns/example.com", http.StatusNotFound, "", ""},
+		{"site.example.com", "/", http.StatusOK, "fnord", ""},
+		{"nodns.example.com", "/", http.StatusNotFound, "", ""},
+		{label + ".ipfs.gw.example.com", "/", http.StatusNotFound, "", ""},
+
+		// the other hostnames are unchanged
+		{"example.com", "/", http.StatusOK, "fnord", ""},
+		{"127.0.0.1", "/ipfs/" + k, http.StatusOK, "fnord", ""},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = test.host
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		urlstr := "http://" + test.host + test.path
+		if res.StatusCode != test.status {
+			t.Errorf("got %d, expected %d from %s", res.StatusCode, test.status, urlstr)
+			continue
+		}
+		if test.text != "" && string(body) != test.text {
+			t.Errorf("unexpected response body from %s: expected %q, got %q", urlstr, test.text, body)
+		}
+		if loc := res.Header.Get("Location"); loc != test.location {
+			t.Errorf("unexpected redirect from %s: expected %q, got %q", urlstr, test.location, loc)
+		}
+	}
+}
+
+func TestGatewayHostnameForwarded(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+
+	hostnameOption := func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		hosts, err := newGatewayHosts(map[string]*GatewaySpec{
+			"dweb.example.com": {Paths: []string{"/ipfs"}, UseSubdomains: true},
+		})
+		if err != nil {
+			return nil, err
+		}
+		proxies, err := newTrustedProxies([]string{"127.0.0.1"})
+		if err != nil {
+			return nil, err
+		}
+		childMux := http.NewServeMux()
+		mux.Handle("/", gatewayHostnameHandler(n, hosts, proxies, false, childMux))
+		return childMux, nil
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, hostnameOption, GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cid.Decode(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label, err := cidLabel(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString("/ipfs/" + k)
+
+	for _, test := range []struct {
+		host     string
+		proto    string
+		path     string
+		status   int
+		text     string
+		location string
+	}{
+		// the redirects use the host and the scheme of the client
+		{"dweb.example.com", "https", "/ipfs/" + k, http.StatusMovedPermanently, "", "https://" + label + ".ipfs.dweb.example.com/"},
+		{"evil.example.com, dweb.example.com", "http, https", "/ipfs/" + k, http.StatusMovedPermanently, "", "https://" + label + ".ipfs.dweb.example.com/"},
+		{"dweb.example.com", "gopher", "/ipfs/" + k, http.StatusMovedPermanently, "", "http://" + label + ".ipfs.dweb.example.com/"},
+		{label + ".ipfs.dweb.example.com", "https", "/", http.StatusOK, "fnord", ""},
+
+		// the DNSLink websites are served by the forwarded host
+		{"example.com", "", "/", http.StatusOK, "fnord", ""},
+		{"", "", "/", http.StatusNotFound, "", ""},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "127.0.0.1:5001"
+		if test.host != "" {
+			req.Header.Set("X-Forwarded-Host", test.host)
+		}
+		if test.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		urlstr := test.proto + "://" + test.host + test.path
+		if res.StatusCode != test.status {
+			t.Errorf("got %d, expected %d from %s", res.StatusCode, test.status, urlstr)
+			continue
+		}
+		if test.text != "" && string(body) != test.text {
+			t.Errorf("unexpected response body from %s: expected %q, got %q", urlstr, test.text, body)
+		}
+		if loc := res.Header.Get("Location"); loc != test.location {
+			t.Errorf("unexpected redirect from %s: expected %q, got %q", urlstr, test.location, loc)
+		}
+	}
+}
+
+func TestUseForwardedHeaders(t *testing.T) {
+	if _, err := newTrustedProxies([]string{"proxy.internal"}); err == nil {
+		t.Error("expected an error for a hostname")
+	}
+	proxies, err := newTrustedProxies([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		remote string
+		fhost  string
+		fproto string
+		host   string
+		scheme string
+	}{
+		{"10.1.2.3:4000", "dweb.example.com", "https", "dweb.example.com", "https"},
+		{"[::1]:4000", "evil.example.com, dweb.example.com", "http,https", "dweb.example.com", "https"},
+		{"10.1.2.3:4000", "", "", "127.0.0.1:8080", "http"},
+		{"192.0.2.1:4000", "dweb.example.com", "https", "127.0.0.1:8080", "http"},
+		{"[::2]:4000", "dweb.example.com", "https", "127.0.0.1:8080", "http"},
+	} {
+		r := httptest.NewRequest("GET", "http://127.0.0.1:8080/", nil)
+		r.RemoteAddr = test.remote
+		if test.fhost != "" {
+			r.Header.Set("X-Forwarded-Host", test.fhost)
+		}
+		if test.fproto != "" {
+			r.Header.Set("X-Forwarded-Proto", test.fproto)
+		}
+		useForwardedHeaders(r, proxies)
+		if r.Host != test.host {
+			t.Errorf("from %s: expected the host %q, got %q", test.remote, test.host, r.Host)
+		}
+		if s := requestScheme(r); s != test.scheme {
+			t.Errorf("from %s: expected the scheme %q, got %q", test.remote, test.scheme, s)
+		}
+	}
+}
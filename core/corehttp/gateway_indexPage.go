@@ -14,16 +14,31 @@ type listingTemplateData struct {
 	Listing  []directoryItem
 	Path     string
 	BackLink string
+	// HasMeta is set when an item of the listing has metadata, for the mode
+	// and mtime columns to be shown.
+	HasMeta bool
+	// AssetsPath is where the custom templates find their assets.
+	AssetsPath string
 }
 
 type directoryItem struct {
-	Size string
-	Name string
-	Path string
+	Size  string
+	Name  string
+	Path  string
+	Mode  string
+	Mtime string
 }
 
+// metaColumns are added after the size column of the listing template, which
+// doesn't have them.
+const metaColumns = `<td>{{ .Size }}</td>
+          {{ if $.HasMeta }}<td class="mode">{{ .Mode }}</td><td class="mtime">{{ .Mtime }}</td>{{ end }}`
+
 var listingTemplate *template.Template
 
+// listingFuncs are the functions of the listing templates.
+var listingFuncs template.FuncMap
+
 func init() {
 	knownIconsBytes, err := assets.Asset("dir-index-html/knownIcons.txt")
 	if err != nil {
@@ -57,8 +72,11 @@ func init() {
 		panic(err)
 	}
 
-	listingTemplate = template.Must(template.New("dir").Funcs(template.FuncMap{
+	dirIndex := strings.Replace(string(dirIndexBytes), "<td>{{ .Size }}</td>", metaColumns, 1)
+
+	listingFuncs = template.FuncMap{
 		"iconFromExt": iconFromExt,
 		"urlEscape":   urlEscape,
-	}).Parse(string(dirIndexBytes)))
+	}
+	listingTemplate = template.Must(template.New("dir").Funcs(listingFuncs).Parse(dirIndex))
 }
@@ -0,0 +1,109 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	core "github.com/ipfs/go-ipfs/core"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
+	coredag "github.com/ipfs/go-ipfs/core/coredag"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	offroute "gx/ipfs/QmSNe4MWVxZWk6UxxW2z2EKofFo4GdFzud1vfn1iVby3mj/go-ipfs-routing/offline"
+	resolver "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path/resolver"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	bserv "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
+	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+var errGatewayNoFetch = errors.New("this gateway only serves the content of its repo")
+
+type noFetchKey struct{}
+
+// GatewayNoFetchOption only serves the content of the repo, for every
+// hostname, if the Gateway.NoFetch config is set: the gateway never fetches
+// the missing blocks, which get a 404. The read-only API and the writable
+// gateway's PUT and DELETE, which could fetch them, get a 403.
+func GatewayNoFetchOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		var noFetch bool
+		if err := repo.ConfigSection(n.Repo, "Gateway.NoFetch", &noFetch); err != nil {
+			return nil, err
+		}
+		if !noFetch {
+			return mux, nil
+		}
+
+		childMux := http.NewServeMux()
+		mux.Handle("/", gatewayNoFetchHandler(childMux))
+		return childMux, nil
+	}
+}
+
+func gatewayNoFetchHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.Method == "PUT" || r.Method == "DELETE" {
+			webErrorWithCode(w, "ipfs gateway", errGatewayNoFetch, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), noFetchKey{}, true)))
+	})
+}
+
+// noFetchBackend is the view of the node serving the requests which must not
+// fetch content: the blocks missing from the repo aren't fetched from the
+// network, and the IPNS names are resolved from the records of the repo. It
+// is built on first use.
+type noFetchBackend struct {
+	once sync.Once
+	api  coreiface.CoreAPI
+	dag  ipld.DAGService
+}
+
+func (b *noFetchBackend) get(n *core.IpfsNode) (coreiface.CoreAPI, ipld.DAGService) {
+	b.once.Do(func() {
+		// only the fields used by the CoreAPI are set, the node itself
+		// mustn't be copied
+		router := offroute.NewOfflineRouter(n.Repo.Datastore(), n.RecordValidator)
+		off := &core.IpfsNode{
+			Identity:   n.Identity,
+			Repo:       n.Repo,
+			Blockstore: n.Blockstore,
+			Pinning:    n.Pinning,
+			Routing:    router,
+		}
+		off.Blocks = bserv.New(n.Blockstore, offline.Exchange(n.Blockstore))
+		off.DAG = dag.NewDAGService(off.Blocks)
+		off.Resolver = &resolver.Resolver{
+			DAG:         off.DAG,
+			ResolveOnce: coredag.ResolveOnce,
+		}
+		if n.Namesys != nil {
+			off.Namesys = namesys.NewNameSystem(router, n.Repo.Datastore(), 0)
+		}
+
+		b.api = coreapi.NewCoreAPI(off)
+		b.dag = off.DAG
+	})
+	return b.api, b.dag
+}
+
+// backend returns the API and the DAG serving the request, which only have
+// the content of the repo if the gateway or the hostname of the request has
+// NoFetch.
+func (i *gatewayHandler) backend(r *http.Request) (coreiface.CoreAPI, ipld.DAGService) {
+	if noFetch, _ := r.Context().Value(noFetchKey{}).(bool); noFetch {
+		return i.noFetch.get(i.node)
+	}
+	if spec := gatewaySpecFromRequest(r); spec != nil && spec.NoFetch {
+		return i.noFetch.get(i.node)
+	}
+	return i.api, i.node.DAG
+}
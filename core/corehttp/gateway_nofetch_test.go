@@ -0,0 +1,75 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	core "github.com/ipfs/go-ipfs/core"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+)
+
+func TestGatewayNoFetch(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+
+	noFetchOption := func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		childMux := http.NewServeMux()
+		mux.Handle("/", gatewayNoFetchHandler(childMux))
+		return childMux, nil
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, noFetchOption, GatewayOption(true, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+		text   string
+	}{
+		{"GET", "/ipfs/" + k, http.StatusOK, "fnord"},
+		{"HEAD", "/ipfs/" + k, http.StatusOK, ""},
+		{"GET", "/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn", http.StatusNotFound, ""},
+		{"GET", "/api/v0/cat?arg=" + k, http.StatusForbidden, ""},
+		{"PUT", "/ipfs/" + k + "/a", http.StatusForbidden, ""},
+		{"DELETE", "/ipfs/" + k, http.StatusForbidden, ""},
+	} {
+		req, err := http.NewRequest(test.method, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != test.status {
+			t.Errorf("got %d, expected %d from %s %s", res.StatusCode, test.status, test.method, test.path)
+			continue
+		}
+		if test.text != "" && string(body) != test.text {
+			t.Errorf("unexpected response body from %s %s: expected %q, got %q", test.method, test.path, test.text, body)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package corehttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	gopath "path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
+)
+
+// redirectsFile is the file of the rules of a site, at its root.
+const redirectsFile = "_redirects"
+
+// maxRedirectsSize bounds the size of the _redirects files.
+const maxRedirectsSize = 64 << 10
+
+// redirectPlaceholder matches the placeholders of the rules, :name, and the
+// :splat of their trailing *.
+var redirectPlaceholder = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// redirectRule is a rule of a _redirects file, "from to [status]", in the
+// format of Netlify.
+type redirectRule struct {
+	from   string
+	to     string
+	status int
+}
+
+// parseRedirects parses the rules of a _redirects file: one per line, the
+// empty lines and the # comments skipped.
+func parseRedirects(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRedirectRule(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseRedirectRule(fields []string) (redirectRule, error) {
+	if len(fields) < 2 {
+		return redirectRule{}, fmt.Errorf("missing the target")
+	}
+	if len(fields) > 3 {
+		return redirectRule{}, fmt.Errorf("query and header conditions are not supported")
+	}
+
+	rule := redirectRule{from: fields[0], to: fields[1], status: http.StatusMovedPermanently}
+	if !strings.HasPrefix(rule.from, "/") {
+		return redirectRule{}, fmt.Errorf("%q is not an absolute path", rule.from)
+	}
+	if i := strings.Index(rule.from, "*"); i >= 0 && i != len(rule.from)-1 {
+		return redirectRule{}, fmt.Errorf("%q has a * before its end", rule.from)
+	}
+	external := strings.HasPrefix(rule.to, "http://") || strings.HasPrefix(rule.to, "https://")
+	if !external && !strings.HasPrefix(rule.to, "/") {
+		return redirectRule{}, fmt.Errorf("%q is neither an absolute path nor a URL", rule.to)
+	}
+
+	if len(fields) == 3 {
+		// the forced rules, 200!, are applied like the others, to the
+		// missing paths only
+		status, err := strconv.Atoi(strings.TrimSuffix(fields[2], "!"))
+		if err != nil {
+			return redirectRule{}, fmt.Errorf("invalid status %q", fields[2])
+		}
+		rule.status = status
+	}
+	switch rule.status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	case http.StatusOK, http.StatusNotFound, http.StatusGone, http.StatusUnavailableForLegalReasons:
+		if external {
+			return redirectRule{}, fmt.Errorf("the %d rules must serve a path of the site", rule.status)
+		}
+	default:
+		return redirectRule{}, fmt.Errorf("unsupported status %d", rule.status)
+	}
+	return rule, nil
+}
+
+// isRedirect returns whether the rule redirects, instead of serving its
+// target.
+func (rule redirectRule) isRedirect() bool {
+	return rule.status >= 300 && rule.status < 400
+}
+
+// match returns the target of the rule for the path p of the site, with its
+// placeholders replaced, if it matches.
+func (rule redirectRule) match(p string) (string, bool) {
+	vars := make(map[string]string)
+	from := strings.Split(strings.Trim(rule.from, "/"), "/")
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	for n, s := range from {
+		if s == "*" && n == len(from)-1 {
+			vars["splat"] = ""
+			if n < len(segs) {
+				vars["splat"] = strings.Join(segs[n:], "/")
+			}
+			return rule.expand(vars), true
+		}
+		if n >= len(segs) {
+			return "", false
+		}
+		if strings.HasPrefix(s, ":") {
+			vars[s[1:]] = segs[n]
+		} else if s != segs[n] {
+			return "", false
+		}
+	}
+	if len(segs) != len(from) {
+		return "", false
+	}
+	return rule.expand(vars), true
+}
+
+// expand replaces the placeholders of the target of the rule by their vars.
+func (rule redirectRule) expand(vars map[string]string) string {
+	return redirectPlaceholder.ReplaceAllStringFunc(rule.to, func(ph string) string {
+		if v, ok := vars[ph[1:]]; ok {
+			return v
+		}
+		return ph
+	})
+}
+
+// splitSitePath splits urlPath into the root of its site, /ipns/<name> or
+// /ipfs/<cid>, and the path in the site.
+func splitSitePath(urlPath string) (string, string) {
+	parts := strings.SplitN(urlPath, "/", 4)
+	if len(parts) < 3 {
+		return urlPath, "/"
+	}
+	root := strings.Join(parts[:3], "/")
+	if len(parts) == 3 {
+		return root, "/"
+	}
+	return root, "/" + parts[3]
+}
+
+// loadRedirects returns the rules of the _redirects file of the site root,
+// none if it has no such file.
+func (i *gatewayHandler) loadRedirects(ctx context.Context, api coreiface.CoreAPI, root string) ([]redirectRule, error) {
+	p, err := coreiface.ParsePath(gopath.Join(root, redirectsFile))
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := api.ResolvePath(ctx, p)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f, err := api.Unixfs().Cat(ctx, resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxRedirectsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRedirectsSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", redirectsFile, maxRedirectsSize)
+	}
+	return parseRedirects(strings.NewReader(string(data)))
+}
+
+// serveRedirects answers the request r for the missing urlPath with the first
+// matching rule of the _redirects file of its site, returning false if there
+// is none.
+func (i *gatewayHandler) serveRedirects(ctx context.Context, w http.ResponseWriter, r *http.Request, api coreiface.CoreAPI, urlPath, prefix string) bool {
+	root, sitePath := splitSitePath(urlPath)
+	rules, err := i.loadRedirects(ctx, api, root)
+	if err != nil {
+		i.webError(w, r, "ipfs gateway "+redirectsFile, err, http.StatusInternalServerError)
+		return true
+	}
+
+	for _, rule := range rules {
+		to, ok := rule.match(sitePath)
+		if !ok {
+			continue
+		}
+		if rule.isRedirect() {
+			if strings.HasPrefix(to, "/") {
+				to = prefix + to
+			}
+			if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+				to += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, to, rule.status)
+			return true
+		}
+		if i.serveRedirectTarget(ctx, w, r, api, urlPath, root+gopath.Clean("/"+to), rule.status) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRedirectTarget serves the file, or the index.html of the directory, at
+// target with status, returning false if there is none.
+func (i *gatewayHandler) serveRedirectTarget(ctx context.Context, w http.ResponseWriter, r *http.Request, api coreiface.CoreAPI, urlPath, target string, status int) bool {
+	for _, p := range []string{target, gopath.Join(target, "index.html")} {
+		parsed, err := coreiface.ParsePath(p)
+		if err != nil {
+			return false
+		}
+		resolved, err := api.ResolvePath(ctx, parsed)
+		if err != nil {
+			return false
+		}
+		f, err := api.Unixfs().Cat(ctx, resolved)
+		if err == coreiface.ErrIsDir {
+			continue
+		} else if err != nil {
+			return false
+		}
+		defer f.Close()
+
+		i.addUserHeaders(w)
+		if nd, err := api.ResolveNode(ctx, resolved); err == nil {
+			setRecordedContentType(w, nd)
+		}
+		name := gopath.Base(p)
+		if status == http.StatusOK {
+			if i.setCacheHeaders(w, r, "\""+resolved.Cid().String()+"\"", urlPath, true) {
+				return true
+			}
+			i.serveFile(w, r, name, time.Time{}, f)
+			return true
+		}
+
+		// http.ServeContent only answers with 200s and 206s
+		if w.Header().Get("Content-Type") == "" {
+			if ctype := mime.TypeByExtension(gopath.Ext(name)); ctype != "" {
+				w.Header().Set("Content-Type", ctype)
+			}
+		}
+		w.WriteHeader(status)
+		if r.Method != "HEAD" {
+			io.Copy(w, f)
+		}
+		return true
+	}
+	return false
+}
+
+// isNotFound returns whether err means a missing path.
+func isNotFound(err error) bool {
+	return os.IsNotExist(err) || webErrorCode(err, 0) == http.StatusNotFound
+}
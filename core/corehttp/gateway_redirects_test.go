@@ -0,0 +1,81 @@
+package corehttp
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseRedirects(t *testing.T) {
+	rules, err := parseRedirects(strings.NewReader(`
+# the old blog
+/blog/*            /posts/:splat
+/users/:id/photos  /u/:id         302
+/docs              https://docs.example.com/ 308
+/gone              /410.html      410
+/*                 /index.html    200!
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 5 {
+		t.Fatalf("expected 5 rules, got %d", len(rules))
+	}
+	for n, status := range []int{301, 302, 308, 410, 200} {
+		if rules[n].status != status {
+			t.Errorf("expected rule %d to answer %d, got %d", n, status, rules[n].status)
+		}
+	}
+	if !rules[0].isRedirect() || rules[4].isRedirect() {
+		t.Error("expected the 3xx rules only to redirect")
+	}
+
+	for _, bad := range []string{
+		"/missing-target",
+		"relative /to",
+		"/from relative",
+		"/a/*/b /c",
+		"/a /b 418",
+		"/a /b abc",
+		"/a https://example.com/ 200",
+		"/a /b 301 Country=us",
+	} {
+		if _, err := parseRedirects(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRedirectRuleMatch(t *testing.T) {
+	for _, c := range []struct {
+		from, to, path, expected string
+	}{
+		{"/blog/*", "/posts/:splat", "/blog/2018/hello", "/posts/2018/hello"},
+		{"/blog/*", "/posts/:splat", "/blog", "/posts/"},
+		{"/blog/*", "/posts/:splat", "/blogs/hello", ""},
+		{"/users/:id/photos", "/u/:id", "/users/42/photos/", "/u/42"},
+		{"/users/:id/photos", "/u/:id", "/users/42", ""},
+		{"/users/:id", "/u/:id/:other", "/users/42", "/u/42/:other"},
+		{"/old", "https://example.com:8080/new", "/old", "https://example.com:8080/new"},
+		{"/*", "/index.html", "/app/settings", "/index.html"},
+		{"/*", "/index.html", "/", "/index.html"},
+	} {
+		rule := redirectRule{from: c.from, to: c.to, status: http.StatusOK}
+		to, ok := rule.match(c.path)
+		if ok != (c.expected != "") || to != c.expected {
+			t.Errorf("expected %s -> %s to map %s to %q, got %q", c.from, c.to, c.path, c.expected, to)
+		}
+	}
+
+	for urlPath, expected := range map[string][2]string{
+		"/ipns/example.com":           {"/ipns/example.com", "/"},
+		"/ipns/example.com/":          {"/ipns/example.com", "/"},
+		"/ipfs/QmFoo/app/settings":    {"/ipfs/QmFoo", "/app/settings"},
+		"/ipns/example.com/a/b/c.txt": {"/ipns/example.com", "/a/b/c.txt"},
+	} {
+		root, p := splitSitePath(urlPath)
+		if root != expected[0] || p != expected[1] {
+			t.Errorf("expected %s to split into %v, got %s and %s", urlPath, expected, root, p)
+		}
+	}
+}
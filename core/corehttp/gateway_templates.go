@@ -0,0 +1,234 @@
+package corehttp
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+// GatewayAssetsPath is the path the files of the Assets directories of the
+// gateway templates are served at, on every hostname.
+const GatewayAssetsPath = "/ipfs-gateway-assets/"
+
+// GatewayTemplateSet are the files customizing the pages of the gateway.
+// The templates are html/template files, relative to the repo if not
+// absolute. The empty ones keep the default pages.
+type GatewayTemplateSet struct {
+	// DirIndex renders the directory listings, with the data of the
+	// default dir-index.html template.
+	DirIndex string
+	// NotFound renders the 404 errors, and Error the other errors of the
+	// gateway, with an errorTemplateData.
+	NotFound string
+	Error    string
+	// Assets is a directory of files the templates can link to, served at
+	// GatewayAssetsPath.
+	Assets string
+}
+
+// GatewayTemplatesConfig is read from the Gateway.Templates config section.
+// Sites maps hostnames to the templates of their sites, whose empty fields
+// are the ones of the default templates. The subdomains of a hostname of
+// Gateway.PublicGateways use the templates of the hostname.
+type GatewayTemplatesConfig struct {
+	GatewayTemplateSet
+	Sites map[string]GatewayTemplateSet
+}
+
+// errorTemplateData is the data of the NotFound and Error templates.
+type errorTemplateData struct {
+	Status     int
+	StatusText string
+	// Message is what failed, and Error why.
+	Message    string
+	Error      string
+	Path       string
+	AssetsPath string
+	// RequestID is the ID of the request, for the users to report it.
+	RequestID string
+}
+
+// gatewayTemplates are the parsed templates of a site.
+type gatewayTemplates struct {
+	dirIndex *template.Template
+	notFound *template.Template
+	error    *template.Template
+	assets   http.Handler
+}
+
+// gatewaySites are the templates of the gateway, by hostname. The empty
+// hostname has the default ones.
+type gatewaySites map[string]*gatewayTemplates
+
+func loadGatewayTemplates(n *core.IpfsNode, cfg GatewayTemplatesConfig) (gatewaySites, error) {
+	root := ""
+	if r, ok := n.Repo.(interface{ Path() string }); ok {
+		root = r.Path()
+	}
+
+	sites := make(gatewaySites, len(cfg.Sites)+1)
+	def, err := parseGatewayTemplates(cfg.GatewayTemplateSet, root, "Gateway.Templates")
+	if err != nil {
+		return nil, err
+	}
+	sites[""] = def
+
+	for host, set := range cfg.Sites {
+		h := requestHostname(host)
+		if h != host || h == "" || strings.ContainsAny(h, ":/[]") {
+			return nil, fmt.Errorf("invalid Gateway.Templates.Sites hostname %q: expected a lowercase hostname without port", host)
+		}
+		if set.DirIndex == "" {
+			set.DirIndex = cfg.DirIndex
+		}
+		if set.NotFound == "" {
+			set.NotFound = cfg.NotFound
+		}
+		if set.Error == "" {
+			set.Error = cfg.Error
+		}
+		if set.Assets == "" {
+			set.Assets = cfg.Assets
+		}
+		if sites[h], err = parseGatewayTemplates(set, root, "Gateway.Templates.Sites."+host); err != nil {
+			return nil, err
+		}
+	}
+	return sites, nil
+}
+
+func parseGatewayTemplates(set GatewayTemplateSet, root, section string) (*gatewayTemplates, error) {
+	abs := func(p string) string {
+		if !filepath.IsAbs(p) && root != "" {
+			return filepath.Join(root, p)
+		}
+		return p
+	}
+	parse := func(name, file string, funcs template.FuncMap) (*template.Template, error) {
+		if file == "" {
+			return nil, nil
+		}
+		t, err := template.New(filepath.Base(file)).Funcs(funcs).ParseFiles(abs(file))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s.%s: %s", section, name, err)
+		}
+		return t, nil
+	}
+
+	t := &gatewayTemplates{dirIndex: listingTemplate}
+	var err error
+	if set.DirIndex != "" {
+		if t.dirIndex, err = parse("DirIndex", set.DirIndex, listingFuncs); err != nil {
+			return nil, err
+		}
+	}
+	if t.notFound, err = parse("NotFound", set.NotFound, nil); err != nil {
+		return nil, err
+	}
+	if t.error, err = parse("Error", set.Error, nil); err != nil {
+		return nil, err
+	}
+	if set.Assets != "" {
+		t.assets = http.StripPrefix(GatewayAssetsPath, http.FileServer(http.Dir(abs(set.Assets))))
+	}
+	return t, nil
+}
+
+// hasAssets returns whether a site has an Assets directory, for
+// GatewayAssetsPath to be served.
+func (c GatewayTemplatesConfig) hasAssets() bool {
+	if c.Assets != "" {
+		return true
+	}
+	for _, set := range c.Sites {
+		if set.Assets != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// site returns the templates of the hostname of r, the ones of the hostname
+// of Gateway.PublicGateways for its subdomains.
+func (sites gatewaySites) site(r *http.Request) *gatewayTemplates {
+	host := requestHostname(r.Host)
+	if t, ok := sites[host]; ok {
+		return t
+	}
+	if parts := strings.SplitN(host, ".", 3); len(parts) == 3 && (parts[1] == "ipfs" || parts[1] == "ipns") {
+		if t, ok := sites[parts[2]]; ok {
+			return t
+		}
+	}
+	if t, ok := sites[""]; ok {
+		return t
+	}
+	return &gatewayTemplates{dirIndex: listingTemplate}
+}
+
+// ServeHTTP serves the assets of the site of r.
+func (sites gatewaySites) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := sites.site(r)
+	if t.assets == nil {
+		http.NotFound(w, r)
+		return
+	}
+	t.assets.ServeHTTP(w, r)
+}
+
+// errorPage renders the error template of the site of r for code, returning
+// false if it has none or it fails.
+func (sites gatewaySites) errorPage(w http.ResponseWriter, r *http.Request, message string, err error, code int) bool {
+	t := sites.site(r)
+	tpl := t.error
+	if code == http.StatusNotFound && t.notFound != nil {
+		tpl = t.notFound
+	}
+	if tpl == nil {
+		return false
+	}
+
+	var buf bytes.Buffer
+	terr := tpl.Execute(&buf, errorTemplateData{
+		Status:     code,
+		StatusText: http.StatusText(code),
+		Message:    message,
+		Error:      err.Error(),
+		Path:       r.URL.Path,
+		AssetsPath: GatewayAssetsPath,
+		RequestID:  requestID(r),
+	})
+	if terr != nil {
+		log.Errorf("failed to render the error template of the gateway: %s", terr)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+	return true
+}
+
+// webError answers an error of the request r, with the error templates of
+// its site if there are.
+func (i *gatewayHandler) webError(w http.ResponseWriter, r *http.Request, message string, err error, defaultCode int) {
+	i.webErrorWithCode(w, r, message, err, webErrorCode(err, defaultCode))
+}
+
+func (i *gatewayHandler) webErrorWithCode(w http.ResponseWriter, r *http.Request, message string, err error, code int) {
+	if !i.templates.errorPage(w, r, message, err, code) {
+		writeWebError(w, message, err, code)
+	}
+	if code >= 500 {
+		log.Warningf("server error in request %s: %s: %s", requestID(r), message, err)
+	}
+}
+
+func (i *gatewayHandler) internalWebError(w http.ResponseWriter, r *http.Request, err error) {
+	i.webErrorWithCode(w, r, "internalWebError", err, http.StatusInternalServerError)
+}
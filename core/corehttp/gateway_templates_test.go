@@ -0,0 +1,128 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	core "github.com/ipfs/go-ipfs/core"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+)
+
+func TestGatewayTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gateway-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"dir.html":         `<h1>listing {{ .Path }}</h1>{{ range .Listing }}<a href="{{ .Path | urlEscape }}">{{ .Name }}</a>{{ end }}`,
+		"404.html":         `<p>nothing at {{ .Path }}</p>`,
+		"error.html":       `<p>{{ .Status }} {{ .StatusText }}: {{ .Message }}</p><link href="{{ .AssetsPath }}style.css">`,
+		"site-404.html":    `<p>example.com has nothing</p>`,
+		"assets/style.css": `body {}`,
+		"site/style.css":   `h1 {}`,
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := GatewayTemplatesConfig{
+		GatewayTemplateSet: GatewayTemplateSet{
+			DirIndex: filepath.Join(dir, "dir.html"),
+			NotFound: filepath.Join(dir, "404.html"),
+			Error:    filepath.Join(dir, "error.html"),
+			Assets:   filepath.Join(dir, "assets"),
+		},
+		Sites: map[string]GatewayTemplateSet{
+			"example.com": {
+				NotFound: filepath.Join(dir, "site-404.html"),
+				Assets:   filepath.Join(dir, "site"),
+			},
+		},
+	}
+	sites, err := loadGatewayTemplates(n, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+
+	gatewayOption := func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		gateway := newGatewayHandler(n, GatewayConfig{}, coreapi.NewCoreAPI(n))
+		gateway.templates = sites
+		mux.Handle("/ipfs/", gateway)
+		mux.Handle("/ipns/", gateway)
+		mux.Handle(GatewayAssetsPath, sites)
+		return mux, nil
+	}
+	dh.Handler, err = makeHandler(n, ts.Listener, gatewayOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		host   string
+		path   string
+		status int
+		body   string
+	}{
+		{"", emptyDir + "/", http.StatusOK, "<h1>listing " + emptyDir + "/</h1>"},
+		{"", emptyDir + "/missing", http.StatusNotFound, "<p>nothing at " + emptyDir + "/missing</p>"},
+		{"", "/ipfs/invalid", http.StatusBadRequest, `<p>400 Bad Request: invalid ipfs path</p><link href="/ipfs-gateway-assets/style.css">`},
+		{"", GatewayAssetsPath + "style.css", http.StatusOK, "body {}"},
+		{"example.com", emptyDir + "/missing", http.StatusNotFound, "<p>example.com has nothing</p>"},
+		{"example.com", emptyDir + "/", http.StatusOK, "<h1>listing " + emptyDir + "/</h1>"},
+		{"example.com", GatewayAssetsPath + "style.css", http.StatusOK, "h1 {}"},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.host != "" {
+			req.Host = test.host
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != test.status {
+			t.Errorf("expected %d for %s%s, got %d: %s", test.status, test.host, test.path, res.StatusCode, body)
+			continue
+		}
+		if !strings.Contains(string(body), test.body) {
+			t.Errorf("expected %q in the response to %s%s, got %q", test.body, test.host, test.path, body)
+		}
+	}
+
+	if _, err := loadGatewayTemplates(n, GatewayTemplatesConfig{GatewayTemplateSet: GatewayTemplateSet{Error: filepath.Join(dir, "missing.html")}}); err == nil {
+		t.Fatal("expected a missing template to be rejected")
+	}
+	if _, err := loadGatewayTemplates(n, GatewayTemplatesConfig{Sites: map[string]GatewayTemplateSet{"Example.com:80": {}}}); err == nil {
+		t.Fatal("expected an invalid hostname to be rejected")
+	}
+}
@@ -1,10 +1,13 @@
 package corehttp
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io/ioutil"
 	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -562,6 +565,89 @@ func TestGoGetSupport(t *testing.T) {
 	}
 }
 
+func TestGatewayRange(t *testing.T) {
+	ts, n := newTestServerAndNode(t, nil)
+	defer ts.Close()
+
+	// several blocks, for the ranges to be read after seeking in the DAG
+	data := strings.Repeat("0123456789", 60000)
+	k, err := coreunix.Add(n, strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(rng string) *http.Response {
+		req, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", rng)
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, test := range []struct {
+		rng          string
+		text         string
+		contentRange string
+	}{
+		{"bytes=2-5", "2345", "bytes 2-5/600000"},
+		{"bytes=-3", "789", "bytes 599997-599999/600000"},
+		{"bytes=599998-", "89", "bytes 599998-599999/600000"},
+		{"bytes=262140-262150", data[262140:262151], "bytes 262140-262150/600000"},
+	} {
+		res := get(test.rng)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusPartialContent {
+			t.Errorf("got %d, expected %d for %s", res.StatusCode, http.StatusPartialContent, test.rng)
+			continue
+		}
+		if string(body) != test.text {
+			t.Errorf("unexpected body for %s: expected %q, got %q", test.rng, test.text, body)
+		}
+		if cr := res.Header.Get("Content-Range"); cr != test.contentRange {
+			t.Errorf("unexpected Content-Range for %s: expected %q, got %q", test.rng, test.contentRange, cr)
+		}
+	}
+
+	res := get("bytes=0-1,300000-300002")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("got %d, expected %d for a multi-range request", res.StatusCode, http.StatusPartialContent)
+	}
+	mt, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/byteranges" {
+		t.Fatalf("expected multipart/byteranges, got %q (%v)", mt, err)
+	}
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	for _, expected := range []string{"01", "012"} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != expected {
+			t.Errorf("unexpected part: expected %q, got %q", expected, body)
+		}
+	}
+
+	res = get("bytes=700000-")
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("got %d, expected %d for a range past the end", res.StatusCode, http.StatusRequestedRangeNotSatisfiable)
+	}
+}
+
 func TestVersion(t *testing.T) {
 	version.CurrentCommit = "theshortcommithash"
 
@@ -597,3 +683,30 @@ func TestVersion(t *testing.T) {
 		t.Fatalf("response doesn't contain protocol version:\n%s", s)
 	}
 }
+
+func TestListingMetaColumns(t *testing.T) {
+	data := listingTemplateData{
+		Listing: []directoryItem{
+			{Size: "6 B", Name: "a", Path: "/ipfs/x/a", Mode: "-rw-r-----", Mtime: "2019-01-01T00:00:00Z"},
+		},
+		Path:     "/ipfs/x",
+		BackLink: "/ipfs/x/..",
+	}
+
+	var buf bytes.Buffer
+	if err := listingTemplate.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), `class="mode"`) {
+		t.Fatal("expected no metadata columns without metadata")
+	}
+
+	buf.Reset()
+	data.HasMeta = true
+	if err := listingTemplate.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<td class="mode">-rw-r-----</td><td class="mtime">2019-01-01T00:00:00Z</td>`) {
+		t.Fatalf("expected the metadata columns in the listing:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,169 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// GatewayTimeoutHeader tells clients which timeout made the gateway give up
+// on a request with a 504.
+const GatewayTimeoutHeader = "X-Ipfs-Gateway-Timeout"
+
+// Values of GatewayTimeoutHeader.
+const (
+	TimeoutFirstByte = "first-byte"
+	TimeoutRequest   = "request"
+)
+
+// DefaultGatewayFirstByteTimeout is used when Gateway.Timeouts.FirstByte
+// isn't set.
+const DefaultGatewayFirstByteTimeout = "1m"
+
+var errGatewayTimeout = errors.New("gateway timeout")
+
+// GatewayTimeoutConfig is read from the Gateway.Timeouts config section.
+// Durations are parsed with time.ParseDuration, an empty one disables the
+// timeout.
+type GatewayTimeoutConfig struct {
+	// FirstByte is how long the gateway waits for a response to start,
+	// usually while the content is resolved and fetched.
+	FirstByte string
+
+	// Request is the maximum duration of a request, including sending the
+	// response.
+	Request string
+}
+
+func (c GatewayTimeoutConfig) durations() (firstByte, request time.Duration, err error) {
+	parse := func(key, s string) (time.Duration, error) {
+		if s == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid Gateway.Timeouts.%s: %q", key, s)
+		}
+		return d, nil
+	}
+
+	if firstByte, err = parse("FirstByte", c.FirstByte); err != nil {
+		return 0, 0, err
+	}
+	request, err = parse("Request", c.Request)
+	return firstByte, request, err
+}
+
+// timeoutWriter wraps the ResponseWriter of a gateway request to give up on
+// it when a timeout expires. The work of the request is canceled, and the
+// client gets a 504 if the response hasn't started yet.
+//
+// The timers run concurrently with the handler, so the headers set by the
+// handler are only copied to the underlying ResponseWriter when it writes.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	wrote    bool
+	timedOut bool
+	timers   []*time.Timer
+}
+
+func newTimeoutWriter(w http.ResponseWriter, cancel context.CancelFunc) *timeoutWriter {
+	return &timeoutWriter{
+		w:      w,
+		header: make(http.Header),
+		cancel: cancel,
+	}
+}
+
+// after expires the request with the given reason after d. A first-byte
+// timeout doesn't apply once the response started.
+func (tw *timeoutWriter) after(d time.Duration, reason string) {
+	if d <= 0 {
+		return
+	}
+	t := time.AfterFunc(d, func() {
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+
+		if tw.timedOut || (reason == TimeoutFirstByte && tw.wrote) {
+			return
+		}
+		tw.timedOut = true
+		tw.cancel()
+
+		if tw.wrote {
+			log.Warningf("gateway request aborted after %s (%s timeout)", d, reason)
+			return
+		}
+		tw.wrote = true
+
+		h := tw.w.Header()
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		h.Set(GatewayTimeoutHeader, reason)
+		tw.w.WriteHeader(http.StatusGatewayTimeout)
+		fmt.Fprintf(tw.w, "%s: no response after %s (%s timeout)\n", errGatewayTimeout, d, reason)
+	})
+
+	tw.mu.Lock()
+	tw.timers = append(tw.timers, t)
+	tw.mu.Unlock()
+}
+
+// stop stops the timers once the request is over.
+func (tw *timeoutWriter) stop() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	for _, t := range tw.timers {
+		t.Stop()
+	}
+	tw.timedOut = true
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(code)
+	tw.wrote = true
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wrote {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, errGatewayTimeout
+	}
+	if !tw.wrote {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if f, ok := tw.w.(http.Flusher); ok && !tw.timedOut {
+		f.Flush()
+	}
+}
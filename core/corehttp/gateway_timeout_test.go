@@ -0,0 +1,113 @@
+package corehttp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func timeoutServer(firstByte, request time.Duration, handler func(ctx context.Context, w http.ResponseWriter)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tw := newTimeoutWriter(w, cancel)
+		defer tw.stop()
+		tw.after(firstByte, TimeoutFirstByte)
+		tw.after(request, TimeoutRequest)
+
+		handler(ctx, tw)
+	}))
+}
+
+func TestGatewayFirstByteTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	ts := timeoutServer(50*time.Millisecond, 0, func(ctx context.Context, w http.ResponseWriter) {
+		// like content that can't be fetched
+		<-ctx.Done()
+		close(canceled)
+		webError(w, "ipfs cat", ctx.Err(), http.StatusNotFound)
+	})
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, res.StatusCode)
+	}
+	if reason := res.Header.Get(GatewayTimeoutHeader); reason != TimeoutFirstByte {
+		t.Fatalf("expected timeout reason %q, got %q", TimeoutFirstByte, reason)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "ipfs cat") {
+		t.Fatalf("the handler wrote after the timeout: %q", body)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the request context wasn't canceled")
+	}
+}
+
+func TestGatewayFirstByteTimeoutAfterResponseStarted(t *testing.T) {
+	ts := timeoutServer(50*time.Millisecond, 0, func(ctx context.Context, w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello ")
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-time.After(150 * time.Millisecond):
+		case <-ctx.Done():
+			return
+		}
+		io.WriteString(w, "world")
+	})
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != "hello world" {
+		t.Fatalf("unexpected response: %d %q", res.StatusCode, body)
+	}
+}
+
+func TestGatewayRequestTimeout(t *testing.T) {
+	ts := timeoutServer(0, 50*time.Millisecond, func(ctx context.Context, w http.ResponseWriter) {
+		<-ctx.Done()
+	})
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, res.StatusCode)
+	}
+	if reason := res.Header.Get(GatewayTimeoutHeader); reason != TimeoutRequest {
+		t.Fatalf("expected timeout reason %q, got %q", TimeoutRequest, reason)
+	}
+}
@@ -0,0 +1,275 @@
+package corehttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	lru "gx/ipfs/QmQjMHF8ptRgx4E57UFMiT4YM6kqaJeYxZ1MCDX23aw4rK/golang-lru"
+)
+
+// transformQueryParam is the query parameter used to select a transformer.
+const transformQueryParam = "transform"
+
+const (
+	defaultTransformCacheEntries = 256
+	defaultTransformCacheSize    = "64MiB"
+	defaultTransformMaxInputSize = "16MiB"
+)
+
+// errTransformInputTooLarge is returned for the files larger than
+// Gateway.Transform.MaxInputSize.
+var errTransformInputTooLarge = errors.New("file too large to be transformed")
+
+// GatewayTransformer derives a new representation of a file served by the
+// gateway, e.g. a resized image or a compressed copy. Transformers are
+// selected with the "transform" query parameter
+// (e.g. /ipfs/<cid>/img.png?transform=resize&width=200).
+type GatewayTransformer interface {
+	// Name returns the value of the "transform" query parameter this
+	// transformer handles.
+	Name() string
+
+	// Params returns the names of the query parameters the transformer
+	// reads. The derived responses are cached by the values of these only.
+	Params() []string
+
+	// Transform reads the original file and returns the derived content along
+	// with its content type. name is the file name the gateway would serve
+	// the original content under, params holds the query parameters listed
+	// by Params. r fails with an error once more than MaxInputSize bytes are
+	// read.
+	Transform(ctx context.Context, name string, params url.Values, r io.Reader) ([]byte, string, error)
+}
+
+// GatewayTransformConfig is read from the Gateway.Transform config section.
+type GatewayTransformConfig struct {
+	// Enabled turns on the "transform" query parameter handling.
+	Enabled bool
+
+	// CacheEntries is the number of derived responses kept in memory.
+	CacheEntries int
+
+	// CacheSize bounds the total size of the derived responses kept in
+	// memory, parsed with humanize.ParseBytes.
+	CacheSize string
+
+	// MaxInputSize bounds the size of the files transformed, which the
+	// transformers may hold in memory, parsed with humanize.ParseBytes.
+	MaxInputSize string
+}
+
+// cacheSize returns CacheSize in bytes.
+func (c GatewayTransformConfig) cacheSize() (int64, error) {
+	return parseTransformSize("CacheSize", c.CacheSize, defaultTransformCacheSize)
+}
+
+// maxInputSize returns MaxInputSize in bytes.
+func (c GatewayTransformConfig) maxInputSize() (int64, error) {
+	return parseTransformSize("MaxInputSize", c.MaxInputSize, defaultTransformMaxInputSize)
+}
+
+func parseTransformSize(field, s, def string) (int64, error) {
+	v := s
+	if v == "" {
+		v = def
+	}
+	n, err := humanize.ParseBytes(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Gateway.Transform.%s: %q", field, s)
+	}
+	return int64(n), nil
+}
+
+var (
+	transformersLk sync.RWMutex
+	transformers   = map[string]GatewayTransformer{
+		gzipTransformerName: gzipTransformer{},
+	}
+)
+
+// RegisterGatewayTransformer makes a transformer available to all gateways
+// with transformations enabled. It is meant to be called from plugins or
+// init functions of compiled-in transformers.
+func RegisterGatewayTransformer(t GatewayTransformer) error {
+	transformersLk.Lock()
+	defer transformersLk.Unlock()
+
+	if _, ok := transformers[t.Name()]; ok {
+		return fmt.Errorf("gateway transformer %q already registered", t.Name())
+	}
+	transformers[t.Name()] = t
+	return nil
+}
+
+func getGatewayTransformer(name string) (GatewayTransformer, bool) {
+	transformersLk.RLock()
+	defer transformersLk.RUnlock()
+	t, ok := transformers[name]
+	return t, ok
+}
+
+type transformedFile struct {
+	data        []byte
+	contentType string
+}
+
+// transformCache holds derived responses keyed by the source CID and the
+// request parameters. Since the source is immutable, entries never need to
+// be invalidated. The least recently used entries are evicted beyond a
+// number of entries or a total size.
+type transformCache struct {
+	cache    *lru.Cache
+	size     int64 // accessed atomically
+	maxBytes int64
+	maxInput int64
+	addLk    sync.Mutex
+}
+
+func newTransformCache(entries int, maxBytes, maxInput int64) *transformCache {
+	if entries <= 0 {
+		entries = defaultTransformCacheEntries
+	}
+	tc := &transformCache{maxBytes: maxBytes, maxInput: maxInput}
+	// lru.NewWithEvict only fails for non-positive sizes
+	tc.cache, _ = lru.NewWithEvict(entries, func(_, v interface{}) {
+		atomic.AddInt64(&tc.size, -int64(len(v.(*transformedFile).data)))
+	})
+	return tc
+}
+
+// add caches tf, evicting the oldest entries beyond the size bound. The
+// responses larger than the bound are not cached.
+func (tc *transformCache) add(key string, tf *transformedFile) {
+	n := int64(len(tf.data))
+	if n > tc.maxBytes {
+		return
+	}
+	tc.addLk.Lock()
+	defer tc.addLk.Unlock()
+	if tc.cache.Contains(key) {
+		// transformed concurrently, replacing it would miscount the size
+		return
+	}
+	atomic.AddInt64(&tc.size, n)
+	tc.cache.Add(key, tf)
+	for atomic.LoadInt64(&tc.size) > tc.maxBytes && tc.cache.Len() > 0 {
+		tc.cache.RemoveOldest()
+	}
+}
+
+// transformParams returns the query parameters of query used by t.
+func transformParams(t GatewayTransformer, query url.Values) url.Values {
+	params := url.Values{transformQueryParam: {t.Name()}}
+	for _, p := range t.Params() {
+		if v, ok := query[p]; ok {
+			params[p] = v
+		}
+	}
+	return params
+}
+
+// transformKey returns a stable identifier for the given CID and the
+// parameters of transformParams. url.Values.Encode sorts by key, so
+// equivalent queries map to the same key regardless of parameter order.
+func transformKey(c cid.Cid, params url.Values) string {
+	sum := sha256.Sum256([]byte(params.Encode()))
+	return fmt.Sprintf("%s-%x", c.String(), sum[:8])
+}
+
+// transform returns the derived representation of the content, either from
+// the cache or by running t. The content larger than the input bound is
+// refused with errTransformInputTooLarge.
+func (tc *transformCache) transform(ctx context.Context, t GatewayTransformer, key, name string, params url.Values, r io.Reader) (*transformedFile, error) {
+	if v, ok := tc.cache.Get(key); ok {
+		return v.(*transformedFile), nil
+	}
+
+	if s, ok := r.(io.Seeker); ok {
+		size, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if size > tc.maxInput {
+			return nil, errTransformInputTooLarge
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	data, ctype, err := t.Transform(ctx, name, params, &boundedReader{r: r, n: tc.maxInput, err: errTransformInputTooLarge})
+	if err != nil {
+		return nil, err
+	}
+
+	tf := &transformedFile{data: data, contentType: ctype}
+	tc.add(key, tf)
+	return tf, nil
+}
+
+// boundedReader fails with err once more than n bytes are read.
+type boundedReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, b.err
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		return 0, b.err
+	}
+	return n, err
+}
+
+const gzipTransformerName = "gzip"
+
+// gzipTransformer serves a gzip compressed copy of a file.
+type gzipTransformer struct{}
+
+func (gzipTransformer) Name() string {
+	return gzipTransformerName
+}
+
+func (gzipTransformer) Params() []string {
+	return []string{"level"}
+}
+
+func (gzipTransformer) Transform(ctx context.Context, name string, params url.Values, r io.Reader) ([]byte, string, error) {
+	level := gzip.DefaultCompression
+	if l := params.Get("level"); l != "" {
+		if _, err := fmt.Sscanf(l, "%d", &level); err != nil {
+			return nil, "", fmt.Errorf("invalid gzip level %q", l)
+		}
+	}
+
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(gw, r); err != nil {
+		return nil, "", err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "application/gzip", nil
+}
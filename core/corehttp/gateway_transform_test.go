@@ -0,0 +1,120 @@
+package corehttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+)
+
+func TestTransformKeyIgnoresParamOrder(t *testing.T) {
+	c, err := cid.Decode("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := url.ParseQuery("transform=gzip&level=9")
+	b, _ := url.ParseQuery("level=9&transform=gzip")
+	if transformKey(c, a) != transformKey(c, b) {
+		t.Fatal("expected equal keys for reordered query")
+	}
+
+	d, _ := url.ParseQuery("transform=gzip&level=1")
+	if transformKey(c, a) == transformKey(c, d) {
+		t.Fatal("expected different keys for different params")
+	}
+}
+
+func TestTransformKeyIgnoresUnusedParams(t *testing.T) {
+	c, err := cid.Decode("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := url.ParseQuery("transform=gzip&level=9")
+	b, _ := url.ParseQuery("transform=gzip&level=9&filename=a.txt&cachebust=1")
+	pa, pb := transformParams(gzipTransformer{}, a), transformParams(gzipTransformer{}, b)
+	if transformKey(c, pa) != transformKey(c, pb) {
+		t.Fatalf("expected unused params to be ignored, got %v and %v", pa, pb)
+	}
+}
+
+func TestGzipTransformerCached(t *testing.T) {
+	tc := newTransformCache(0, 1<<20, 1<<20)
+	params := url.Values{"transform": []string{"gzip"}}
+
+	tf, err := tc.transform(context.Background(), gzipTransformer{}, "key", "a.txt", params, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.contentType != "application/gzip" {
+		t.Fatalf("unexpected content type %q", tf.contentType)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(tf.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello" {
+		t.Fatalf("unexpected content %q", out)
+	}
+
+	// a cached entry must be returned without reading the source again
+	tf2, err := tc.transform(context.Background(), gzipTransformer{}, "key", "a.txt", params, strings.NewReader("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf2 != tf {
+		t.Fatal("expected cached result")
+	}
+}
+
+func TestTransformCacheSizeBound(t *testing.T) {
+	tc := newTransformCache(0, 10, 10)
+	for _, k := range []string{"a", "b", "c"} {
+		tc.add(k, &transformedFile{data: []byte(k + k + k + k)})
+	}
+	if tc.cache.Contains("a") || !tc.cache.Contains("b") || !tc.cache.Contains("c") {
+		t.Fatalf("expected the oldest entry to be evicted, have %v", tc.cache.Keys())
+	}
+	if tc.size != 8 {
+		t.Fatalf("expected 8 bytes cached, got %d", tc.size)
+	}
+
+	tc.add("big", &transformedFile{data: make([]byte, 11)})
+	if tc.cache.Contains("big") || tc.size != 8 {
+		t.Fatal("expected a response larger than the bound not to be cached")
+	}
+}
+
+func TestTransformInputBound(t *testing.T) {
+	tc := newTransformCache(0, 1<<20, 5)
+	params := url.Values{"transform": []string{"gzip"}}
+
+	if _, err := tc.transform(context.Background(), gzipTransformer{}, "small", "a.txt", params, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a seekable source is refused before being read
+	if _, err := tc.transform(context.Background(), gzipTransformer{}, "seek", "a.txt", params, strings.NewReader("hello!")); err != errTransformInputTooLarge {
+		t.Fatalf("expected errTransformInputTooLarge, got %v", err)
+	}
+
+	// any other once more than the bound was read
+	r := ioutil.NopCloser(strings.NewReader("hello!"))
+	if _, err := tc.transform(context.Background(), gzipTransformer{}, "stream", "a.txt", params, r); err != errTransformInputTooLarge {
+		t.Fatalf("expected errTransformInputTooLarge, got %v", err)
+	}
+	if tc.cache.Contains("seek") || tc.cache.Contains("stream") {
+		t.Fatal("expected refused transforms not to be cached")
+	}
+}
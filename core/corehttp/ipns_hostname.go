@@ -13,27 +13,42 @@ import (
 	isd "gx/ipfs/QmZmmuAXgX73UQmX1jRKjTGmjzq24Jinqkq8vzkBtno4uX/go-is-domain"
 )
 
-// IPNSHostnameOption rewrites an incoming request if its Host: header contains
-// an IPNS name.
+// IPNSHostnameOption rewrites an incoming request if its Host: header, or the
+// X-Forwarded-Host header of a trusted reverse proxy, contains an IPNS name.
 // The rewritten request points at the resolved name on the gateway handler.
 func IPNSHostnameOption() ServeOption {
 	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		proxies, err := loadTrustedProxies(n)
+		if err != nil {
+			return nil, err
+		}
 		childMux := http.NewServeMux()
 		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			ctx, cancel := context.WithCancel(n.Context())
-			defer cancel()
-
+			useForwardedHeaders(r, proxies)
 			host := strings.SplitN(r.Host, ":", 2)[0]
-			if len(host) > 0 && isd.IsDomain(host) {
-				name := "/ipns/" + host
-				_, err := n.Namesys.Resolve(ctx, name, nsopts.Depth(1))
-				if err == nil || err == namesys.ErrResolveRecursion {
-					r.Header.Set("X-Ipns-Original-Path", r.URL.Path)
-					r.URL.Path = name + r.URL.Path
-				}
-			}
+			rewriteDNSLink(n, r, host)
 			childMux.ServeHTTP(w, r)
 		})
 		return childMux, nil
 	}
 }
+
+// rewriteDNSLink points the request at the /ipns/ path of host if it is a
+// domain with an IPNS name, and reports whether it did.
+func rewriteDNSLink(n *core.IpfsNode, r *http.Request, host string) bool {
+	if len(host) == 0 || !isd.IsDomain(host) {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(n.Context())
+	defer cancel()
+
+	name := "/ipns/" + host
+	_, err := n.Namesys.Resolve(ctx, name, nsopts.Depth(1))
+	if err != nil && err != namesys.ErrResolveRecursion {
+		return false
+	}
+	r.Header.Set("X-Ipns-Original-Path", r.URL.Path)
+	r.URL.Path = name + r.URL.Path
+	return true
+}
@@ -0,0 +1,82 @@
+package corehttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+type sizeReadSeeker interface {
+	Size() uint64
+
+	io.ReadSeeker
+}
+
+// lazySeeker defers the seeks of a unixfs reader to its next read.
+//
+// http.ServeContent seeks to the end of the content to find its size, back
+// to the start, and to the start of each range it serves. A unixfs reader
+// fetches the block at the offset it seeks to, so seeking eagerly would
+// fetch blocks which aren't served, like the first one of a file when a
+// video player asks for a range in its middle.
+type lazySeeker struct {
+	reader io.ReadSeeker
+
+	size       int64
+	offset     int64
+	realOffset int64
+}
+
+// newLazySeeker returns a lazySeeker of content, which size is read from its
+// Size method if it has one, like unixfs readers do.
+func newLazySeeker(content io.ReadSeeker) (*lazySeeker, error) {
+	var size int64
+	if sr, ok := content.(sizeReadSeeker); ok {
+		size = int64(sr.Size())
+	} else {
+		end, err := content.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		size = end
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return &lazySeeker{reader: content, size: size}, nil
+}
+
+func (s *lazySeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekEnd:
+		return s.Seek(s.size+offset, io.SeekStart)
+	case io.SeekCurrent:
+		return s.Seek(s.offset+offset, io.SeekStart)
+	case io.SeekStart:
+		if offset < 0 {
+			return s.offset, errors.New("invalid seek offset")
+		}
+		s.offset = offset
+		return s.offset, nil
+	default:
+		return s.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+}
+
+func (s *lazySeeker) Read(b []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+
+	if s.offset != s.realOffset {
+		off, err := s.reader.Seek(s.offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		s.realOffset = off
+	}
+	n, err := s.reader.Read(b)
+	s.realOffset += int64(n)
+	s.offset += int64(n)
+	return n, err
+}
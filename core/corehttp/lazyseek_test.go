@@ -0,0 +1,62 @@
+package corehttp
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type countingSeeker struct {
+	*strings.Reader
+	seeks int
+}
+
+func (s *countingSeeker) Seek(offset int64, whence int) (int64, error) {
+	s.seeks++
+	return s.Reader.Seek(offset, whence)
+}
+
+func TestLazySeeker(t *testing.T) {
+	content := &countingSeeker{Reader: strings.NewReader("0123456789")}
+	ls, err := newLazySeeker(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content.seeks = 0
+
+	if end, err := ls.Seek(0, io.SeekEnd); err != nil || end != 10 {
+		t.Fatalf("expected the size, got %d (%v)", end, err)
+	}
+	if off, err := ls.Seek(-4, io.SeekCurrent); err != nil || off != 6 {
+		t.Fatalf("expected offset 6, got %d (%v)", off, err)
+	}
+	if _, err := ls.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("expected a negative offset to fail")
+	}
+	if content.seeks != 0 {
+		t.Fatalf("expected no seek before reading, got %d", content.seeks)
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(ls, buf); err != nil || string(buf) != "67" {
+		t.Fatalf("expected 67, got %q (%v)", buf, err)
+	}
+	if _, err := io.ReadFull(ls, buf); err != nil || string(buf) != "89" {
+		t.Fatalf("expected 89, got %q (%v)", buf, err)
+	}
+	if content.seeks != 1 {
+		t.Fatalf("expected a single seek for contiguous reads, got %d", content.seeks)
+	}
+	if n, err := ls.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("expected EOF at the end, got %d (%v)", n, err)
+	}
+
+	if _, err := ls.Seek(1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(ls)
+	if err != nil || string(rest) != "123456789" {
+		t.Fatalf("expected the content from offset 1, got %q (%v)", rest, err)
+	}
+}
@@ -1,10 +1,16 @@
 package corehttp
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	core "github.com/ipfs/go-ipfs/core"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	repo "github.com/ipfs/go-ipfs/repo"
 
 	prometheus "gx/ipfs/QmYYv3QFnfQbiwmi1tpkgKF8o4xFnZoBrvpupTiGJwL9nH/client_golang/prometheus"
 )
@@ -30,6 +36,19 @@ var (
 	peersTotalMetric = prometheus.NewDesc(
 		prometheus.BuildFQName("ipfs", "p2p", "peers_total"),
 		"Number of connected peers", []string{"transport"}, nil)
+
+	pexExchangesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "pex", "exchanges_total"),
+		"Number of samples of peers asked to the new peers", []string{"result"}, nil)
+	pexServedMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "pex", "served_total"),
+		"Number of samples of peers sent to the new peers", []string{"result"}, nil)
+	pexPeersMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "pex", "peers_received_total"),
+		"Number of peers received from the peer exchanges", []string{"result"}, nil)
+	pexDialsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "pex", "dials_total"),
+		"Number of connections to the peers received", []string{"result"}, nil)
 )
 
 type IpfsNodeCollector struct {
@@ -38,6 +57,10 @@ type IpfsNodeCollector struct {
 
 func (_ IpfsNodeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- peersTotalMetric
+	ch <- pexExchangesMetric
+	ch <- pexServedMetric
+	ch <- pexPeersMetric
+	ch <- pexDialsMetric
 }
 
 func (c IpfsNodeCollector) Collect(ch chan<- prometheus.Metric) {
@@ -49,6 +72,21 @@ func (c IpfsNodeCollector) Collect(ch chan<- prometheus.Metric) {
 			tr,
 		)
 	}
+
+	if c.Node.PeerExchange != nil {
+		st := c.Node.PeerExchange.Stats()
+		counter := func(desc *prometheus.Desc, val int, result string) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(val), result)
+		}
+		counter(pexExchangesMetric, st.Exchanges-st.Failures, "ok")
+		counter(pexExchangesMetric, st.Failures, "failed")
+		counter(pexServedMetric, st.Served, "ok")
+		counter(pexServedMetric, st.Refused, "refused")
+		counter(pexPeersMetric, st.PeersReceived-st.PeersIgnored, "dial")
+		counter(pexPeersMetric, st.PeersIgnored, "ignored")
+		counter(pexDialsMetric, st.Dials-st.DialFailures, "ok")
+		counter(pexDialsMetric, st.DialFailures, "failed")
+	}
 }
 
 func (c IpfsNodeCollector) PeersTotalValues() map[string]float64 {
@@ -65,3 +103,171 @@ func (c IpfsNodeCollector) PeersTotalValues() map[string]float64 {
 	}
 	return vals
 }
+
+// InventoryConfig is read from the Metrics.Inventory config section.
+type InventoryConfig struct {
+	// Enabled turns on the periodic export of the pin and repo inventory.
+	Enabled bool
+	// Interval is how often the inventory is taken.
+	Interval string
+}
+
+// DefaultInventoryInterval is how often the inventory is taken when
+// Metrics.Inventory.Interval isn't set.
+const DefaultInventoryInterval = 10 * time.Minute
+
+var (
+	pinsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "pins", ""),
+		"Number of pinned blocks", []string{"type"}, nil)
+	pinnedBytesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "pins", "bytes"),
+		"Size of the local pinned blocks", nil, nil)
+	filestoreRefsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "filestore", "references"),
+		"Number of blocks referenced by the filestore", []string{"type"}, nil)
+	filestoreBytesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "filestore", "bytes"),
+		"Size of the blocks referenced by the filestore", nil, nil)
+	inventoryTimeMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "inventory", "timestamp_seconds"),
+		"Time the pin and repo inventory was last taken", nil, nil)
+	filesChangesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "files", "changes_total"),
+		"Number of changes made with the files API", []string{"op"}, nil)
+	filesRootChangesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "files", "root_changes_total"),
+		"Number of times a new files API root was persisted", nil, nil)
+	filesLastChangeMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "files", "last_root_change_timestamp_seconds"),
+		"Time a new files API root was last persisted", nil, nil)
+)
+
+// InventoryCollector periodically takes the inventory of the pins and the
+// filestore of a node, and counts the changes to its files API root, to
+// export them as Prometheus metrics.
+type InventoryCollector struct {
+	Node     *core.IpfsNode
+	Interval time.Duration
+
+	lk             sync.Mutex
+	inv            *corerepo.Inventory
+	taken          time.Time
+	filesChanges   map[string]float64
+	rootChanges    float64
+	lastRootChange time.Time
+}
+
+// NewInventoryCollector returns an InventoryCollector configured by the
+// Metrics.Inventory config section of n, or nil if it isn't enabled.
+func NewInventoryCollector(n *core.IpfsNode) (*InventoryCollector, error) {
+	var cfg InventoryConfig
+	if err := repo.ConfigSection(n.Repo, "Metrics.Inventory", &cfg); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	interval := DefaultInventoryInterval
+	if cfg.Interval != "" {
+		d, err := time.ParseDuration(cfg.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Metrics.Inventory.Interval: %s", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid Metrics.Inventory.Interval: must be positive")
+		}
+		interval = d
+	}
+
+	return &InventoryCollector{
+		Node:         n,
+		Interval:     interval,
+		filesChanges: make(map[string]float64),
+	}, nil
+}
+
+// Run takes the inventory right away and then every Interval, and counts
+// the files API changes, until ctx is done.
+func (c *InventoryCollector) Run(ctx context.Context) {
+	go c.countFilesChanges(ctx)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		c.update(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *InventoryCollector) update(ctx context.Context) {
+	inv, err := corerepo.TakeInventory(ctx, c.Node)
+	if err != nil {
+		log.Errorf("failed to take the pin and repo inventory: %s", err)
+		return
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.inv = inv
+	c.taken = time.Now()
+}
+
+func (c *InventoryCollector) countFilesChanges(ctx context.Context) {
+	for e := range c.Node.FilesEvents.Subscribe(ctx, "/") {
+		c.lk.Lock()
+		if e.Op == core.FilesEventPublish {
+			c.rootChanges++
+			c.lastRootChange = time.Now()
+		} else {
+			c.filesChanges[e.Op]++
+		}
+		c.lk.Unlock()
+	}
+}
+
+func (_ *InventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pinsMetric
+	ch <- pinnedBytesMetric
+	ch <- filestoreRefsMetric
+	ch <- filestoreBytesMetric
+	ch <- inventoryTimeMetric
+	ch <- filesChangesMetric
+	ch <- filesRootChangesMetric
+	ch <- filesLastChangeMetric
+}
+
+func (c *InventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	for op, val := range c.filesChanges {
+		ch <- prometheus.MustNewConstMetric(filesChangesMetric, prometheus.CounterValue, val, op)
+	}
+	ch <- prometheus.MustNewConstMetric(filesRootChangesMetric, prometheus.CounterValue, c.rootChanges)
+	if !c.lastRootChange.IsZero() {
+		ch <- prometheus.MustNewConstMetric(filesLastChangeMetric, prometheus.GaugeValue, unixSeconds(c.lastRootChange))
+	}
+
+	if c.inv == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(pinsMetric, prometheus.GaugeValue, float64(c.inv.RecursivePins), "recursive")
+	ch <- prometheus.MustNewConstMetric(pinsMetric, prometheus.GaugeValue, float64(c.inv.DirectPins), "direct")
+	ch <- prometheus.MustNewConstMetric(pinsMetric, prometheus.GaugeValue, float64(c.inv.IndirectPins), "indirect")
+	ch <- prometheus.MustNewConstMetric(pinnedBytesMetric, prometheus.GaugeValue, float64(c.inv.PinnedBytes))
+	ch <- prometheus.MustNewConstMetric(filestoreRefsMetric, prometheus.GaugeValue, float64(c.inv.FilestoreFiles), "file")
+	ch <- prometheus.MustNewConstMetric(filestoreRefsMetric, prometheus.GaugeValue, float64(c.inv.FilestoreURLs), "url")
+	ch <- prometheus.MustNewConstMetric(filestoreBytesMetric, prometheus.GaugeValue, float64(c.inv.FilestoreBytes))
+	ch <- prometheus.MustNewConstMetric(inventoryTimeMetric, prometheus.GaugeValue, unixSeconds(c.taken))
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
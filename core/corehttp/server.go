@@ -0,0 +1,201 @@
+package corehttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	http2 "golang.org/x/net/http2"
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+)
+
+// ServerConfig is read from the API.Server and Gateway.Server config
+// sections. Durations are parsed with time.ParseDuration and sizes with
+// humanize.ParseBytes, an empty one keeping the default.
+type ServerConfig struct {
+	// HTTP2 serves HTTP/2 on the HTTPS listeners, negotiated with ALPN.
+	// Defaults to true.
+	HTTP2 *bool
+
+	// MaxConcurrentStreams bounds the requests an HTTP/2 connection has in
+	// flight at once. 0 keeps the default of 250.
+	MaxConcurrentStreams int
+
+	// ReadHeaderTimeout is how long the server waits for the headers of a
+	// request, IdleTimeout how long it keeps an idle connection open.
+	ReadHeaderTimeout string
+	IdleTimeout       string
+
+	// ReadTimeout and WriteTimeout bound the time to read a whole request
+	// and to write its response. They also apply to the streaming commands
+	// and to the large files, so they are better left unset.
+	ReadTimeout  string
+	WriteTimeout string
+
+	// MaxHeaderBytes bounds the size of the headers of a request.
+	MaxHeaderBytes string
+
+	// ReadBufferSize and WriteBufferSize set the socket buffers of the
+	// connections.
+	ReadBufferSize  string
+	WriteBufferSize string
+}
+
+// ServerOptions tunes the HTTP server of ServeWithOptions and its
+// connections.
+type ServerOptions struct {
+	HTTP2                bool
+	MaxConcurrentStreams uint32
+
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	MaxHeaderBytes    int
+
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// TLS, when set, terminates the TLS connections of the listener.
+	TLS *tls.Config
+}
+
+// LoadServerOptions returns the server options of the config section key of
+// r.
+func LoadServerOptions(r repo.Repo, key string) (*ServerOptions, error) {
+	var cfg ServerConfig
+	if err := repo.ConfigSection(r, key, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg.options(key)
+}
+
+func (c ServerConfig) options(section string) (*ServerOptions, error) {
+	o := &ServerOptions{HTTP2: c.HTTP2 == nil || *c.HTTP2}
+
+	if c.MaxConcurrentStreams < 0 || int64(c.MaxConcurrentStreams) > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid %s.MaxConcurrentStreams: %d", section, c.MaxConcurrentStreams)
+	}
+	o.MaxConcurrentStreams = uint32(c.MaxConcurrentStreams)
+
+	durations := []struct {
+		key string
+		s   string
+		d   *time.Duration
+	}{
+		{"ReadHeaderTimeout", c.ReadHeaderTimeout, &o.ReadHeaderTimeout},
+		{"IdleTimeout", c.IdleTimeout, &o.IdleTimeout},
+		{"ReadTimeout", c.ReadTimeout, &o.ReadTimeout},
+		{"WriteTimeout", c.WriteTimeout, &o.WriteTimeout},
+	}
+	for _, d := range durations {
+		if d.s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid %s.%s: %q", section, d.key, d.s)
+		}
+		*d.d = v
+	}
+
+	sizes := []struct {
+		key string
+		s   string
+		n   *int
+	}{
+		{"MaxHeaderBytes", c.MaxHeaderBytes, &o.MaxHeaderBytes},
+		{"ReadBufferSize", c.ReadBufferSize, &o.ReadBufferSize},
+		{"WriteBufferSize", c.WriteBufferSize, &o.WriteBufferSize},
+	}
+	for _, s := range sizes {
+		if s.s == "" {
+			continue
+		}
+		v, err := humanize.ParseBytes(s.s)
+		if err != nil || v > 1<<30 {
+			return nil, fmt.Errorf("invalid %s.%s: %q", section, s.key, s.s)
+		}
+		*s.n = int(v)
+	}
+	return o, nil
+}
+
+// server returns the HTTP server of handler and the listener it serves,
+// wrapping lis to set the buffers of the connections and to terminate TLS.
+func (o *ServerOptions) server(handler http.Handler, lis net.Listener) (*http.Server, net.Listener, error) {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: o.ReadHeaderTimeout,
+		IdleTimeout:       o.IdleTimeout,
+		ReadTimeout:       o.ReadTimeout,
+		WriteTimeout:      o.WriteTimeout,
+		MaxHeaderBytes:    o.MaxHeaderBytes,
+	}
+
+	if o.ReadBufferSize > 0 || o.WriteBufferSize > 0 {
+		lis = &bufferListener{Listener: lis, read: o.ReadBufferSize, write: o.WriteBufferSize}
+	}
+
+	if o.TLS != nil {
+		server.TLSConfig = o.TLS.Clone()
+		if o.HTTP2 {
+			// serves HTTP/2 on the TLS connections negotiating h2, which
+			// the TLS config now offers
+			server.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
+			err := http2.ConfigureServer(server, &http2.Server{
+				MaxConcurrentStreams: o.MaxConcurrentStreams,
+				IdleTimeout:          o.IdleTimeout,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			server.TLSConfig.NextProtos = []string{"http/1.1"}
+			server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+		lis = tls.NewListener(lis, server.TLSConfig)
+	}
+	return server, lis, nil
+}
+
+// bufferListener sets the socket buffers of the connections it accepts. The
+// connections of a manet.Listener wrap those of the network and hide their
+// buffers, core.ListenHTTP listens on the multiaddrs instead.
+type bufferListener struct {
+	net.Listener
+	read, write int
+}
+
+type bufferConn interface {
+	SetReadBuffer(int) error
+	SetWriteBuffer(int) error
+}
+
+func (l *bufferListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	bc, ok := c.(bufferConn)
+	if !ok {
+		log.Debugf("cannot set the buffers of the %T connection of %s", c, c.RemoteAddr())
+		return c, nil
+	}
+	if l.read > 0 {
+		if err := bc.SetReadBuffer(l.read); err != nil {
+			log.Debugf("cannot set the read buffer of %s: %s", c.RemoteAddr(), err)
+		}
+	}
+	if l.write > 0 {
+		if err := bc.SetWriteBuffer(l.write); err != nil {
+			log.Debugf("cannot set the write buffer of %s: %s", c.RemoteAddr(), err)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,242 @@
+package corehttp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+)
+
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServerConfig(t *testing.T) {
+	o, err := ServerConfig{}.options("API.Server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.HTTP2 || o.IdleTimeout != 0 || o.MaxHeaderBytes != 0 {
+		t.Fatalf("unexpected defaults: %+v", o)
+	}
+
+	off := false
+	o, err = ServerConfig{
+		HTTP2:                &off,
+		MaxConcurrentStreams: 100,
+		ReadHeaderTimeout:    "10s",
+		IdleTimeout:          "2m",
+		MaxHeaderBytes:       "64KiB",
+		ReadBufferSize:       "1MB",
+	}.options("API.Server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.HTTP2 || o.MaxConcurrentStreams != 100 || o.ReadHeaderTimeout != 10*time.Second ||
+		o.IdleTimeout != 2*time.Minute || o.MaxHeaderBytes != 64<<10 || o.ReadBufferSize != 1000000 {
+		t.Fatalf("unexpected options: %+v", o)
+	}
+
+	for _, cfg := range []ServerConfig{
+		{IdleTimeout: "forever"},
+		{MaxConcurrentStreams: -1},
+		{WriteTimeout: "-1s"},
+		{MaxHeaderBytes: "lots"},
+		{WriteBufferSize: "2GB"},
+	} {
+		if _, err := cfg.options("API.Server"); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestServeHTTP2(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	conf := &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}
+	negotiate := func(so *ServerOptions) string {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ServeWithOptions(n, lis, so, func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+			return mux, nil
+		})
+
+		c, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		defer lis.Close()
+		return c.ConnectionState().NegotiatedProtocol
+	}
+
+	if p := negotiate(&ServerOptions{HTTP2: true, MaxConcurrentStreams: 16, TLS: conf, ReadBufferSize: 1 << 20}); p != "h2" {
+		t.Fatalf("expected HTTP/2 to be negotiated, got %q", p)
+	}
+	if p := negotiate(&ServerOptions{TLS: conf}); p != "http/1.1" {
+		t.Fatalf("expected HTTP/1.1 to be negotiated, got %q", p)
+	}
+}
+
+// http2Get requests path over HTTP/2 on the TLS connection c, and returns the
+// body of the response. The frames are written by hand, as the client of
+// net/http only negotiates HTTP/2 with its default TLS config.
+func http2Get(t *testing.T, c net.Conn, path string) string {
+	frame := func(typ, flags byte, stream uint32, payload []byte) []byte {
+		h := make([]byte, 9, 9+len(payload))
+		h[0], h[1], h[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+		h[3], h[4] = typ, flags
+		binary.BigEndian.PutUint32(h[5:], stream)
+		return append(h, payload...)
+	}
+
+	// GET https://localhost<path>, with the static HPACK table
+	headers := []byte{0x82, 0x87, 0x04, byte(len(path))}
+	headers = append(headers, path...)
+	headers = append(headers, 0x01, 9)
+	headers = append(headers, "localhost"...)
+
+	req := []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	req = append(req, frame(0x4, 0, 0, nil)...)       // SETTINGS
+	req = append(req, frame(0x1, 0x5, 1, headers)...) // HEADERS, END_STREAM|END_HEADERS
+	if _, err := c.Write(req); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(c)
+	var body []byte
+	for {
+		h := make([]byte, 9)
+		if _, err := io.ReadFull(r, h); err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, int(h[0])<<16|int(h[1])<<8|int(h[2]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatal(err)
+		}
+		typ, flags, stream := h[3], h[4], binary.BigEndian.Uint32(h[5:])&0x7fffffff
+		switch {
+		case typ == 0x7: // GOAWAY
+			t.Fatalf("the server closed the connection: %x", payload)
+		case typ == 0x3 && stream == 1: // RST_STREAM
+			t.Fatalf("the server reset the request: %x", payload)
+		case typ == 0x0 && stream == 1: // DATA
+			body = append(body, payload...)
+			if flags&0x1 != 0 {
+				return string(body)
+			}
+		}
+	}
+}
+
+// listenLoopback listens as the daemon does, on the loopback interface.
+func listenLoopback(t *testing.T) (net.Listener, ma.Multiaddr, error) {
+	maddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return core.ListenHTTP(maddr)
+}
+
+func TestServeHTTP2Request(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	// the listener of the daemon, whose connections get their buffers set
+	lis, _, err := listenLoopback(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	so := &ServerOptions{
+		HTTP2:           true,
+		TLS:             &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}},
+		ReadBufferSize:  1 << 20,
+		WriteBufferSize: 1 << 20,
+	}
+	go ServeWithOptions(n, lis, so, func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/proto", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto)
+		})
+		return mux, nil
+	})
+	defer lis.Close()
+
+	c, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if p := http2Get(t, c, "/proto"); p != "HTTP/2.0" {
+		t.Fatalf("expected the request to be served over HTTP/2, got %q", p)
+	}
+}
+
+func TestBufferListener(t *testing.T) {
+	l, _, err := listenLoopback(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	lis := &bufferListener{Listener: l, read: 1 << 16, write: 1 << 16}
+
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err == nil {
+			c.Close()
+		}
+	}()
+
+	c, err := lis.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := c.(bufferConn); !ok {
+		t.Fatalf("the buffers of the %T connections can't be set", c)
+	}
+}
@@ -0,0 +1,187 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	opentracing "gx/ipfs/QmWLWmRVSiagqP15jczsGME1qpob6HDbtbHAY2he9W5iUo/opentracing-go"
+	ext "gx/ipfs/QmWLWmRVSiagqP15jczsGME1qpob6HDbtbHAY2he9W5iUo/opentracing-go/ext"
+)
+
+// RequestIDHeader is the header of the ID of the API and gateway requests,
+// taken from the request when a proxy or a client set it, and set on every
+// response.
+const RequestIDHeader = "X-Request-Id"
+
+// traceparentHeader is the W3C Trace Context header, whose trace ID is the
+// request ID when there is no RequestIDHeader.
+const traceparentHeader = "traceparent"
+
+// maxRequestIDLength bounds the length of the request IDs set by clients.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the HTTP request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the ID of the request r.
+func requestID(r *http.Request) string {
+	return RequestID(r.Context())
+}
+
+// newRequestID returns the ID of the request r: the one set by the client if
+// valid, the trace ID of its traceparent, or a random one.
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	if id, ok := traceparentID(r.Header.Get(traceparentHeader)); ok {
+		return id
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID returns whether id is short and only made of the printable
+// characters that are safe in the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:+/=@", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// traceparentID returns the trace ID of a traceparent header,
+// version-traceid-parentid-flags.
+func traceparentID(tp string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(tp), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || parts[0] == "ff" {
+		return "", false
+	}
+	id := strings.ToLower(parts[1])
+	if _, err := hex.DecodeString(id); err != nil || id == strings.Repeat("0", 32) {
+		return "", false
+	}
+	return id, true
+}
+
+// contextWithTrace returns ctx with the request ID, the loggable and the span
+// of the request context from, for the handlers whose context doesn't derive
+// from the one of the request.
+func contextWithTrace(ctx, from context.Context) context.Context {
+	id := RequestID(from)
+	if id == "" {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	ctx = logging.ContextWithLoggable(ctx, logging.LoggableMap{"requestId": id})
+	if span := opentracing.SpanFromContext(from); span != nil {
+		ctx = opentracing.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
+
+// withRequestTracing gives every request an ID, set on the response and in
+// the context of the request with its span, a child of the span of the
+// trace headers of the request if it has some.
+func withRequestTracing(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := newRequestID(r)
+		w.Header().Set(RequestIDHeader, id)
+
+		tracer := opentracing.GlobalTracer()
+		var opts []opentracing.StartSpanOption
+		if parent, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header)); err == nil {
+			opts = append(opts, ext.RPCServerOption(parent))
+		}
+		span := tracer.StartSpan(operationName(r), opts...)
+		defer span.Finish()
+		ext.SpanKindRPCServer.Set(span)
+		ext.HTTPMethod.Set(span, r.Method)
+		ext.HTTPUrl.Set(span, r.URL.Path)
+		span.SetTag("request.id", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logging.ContextWithLoggable(ctx, logging.LoggableMap{"requestId": id})
+		ctx = opentracing.ContextWithSpan(ctx, span)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		ext.HTTPStatusCode.Set(span, uint16(sw.status))
+		if sw.status >= 500 {
+			ext.Error.Set(span, true)
+		}
+		log.Debugf("request %s: %s %s %d %s", id, r.Method, r.URL.Path, sw.status, time.Since(start))
+	})
+}
+
+// operationName names the span of r after the API command, or the gateway
+// namespace, keeping the CIDs and the paths of the content out of it.
+func operationName(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, APIPath+"/") {
+		return "api " + strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/")
+	}
+	for _, prefix := range []string{ipfsPathPrefix, ipnsPathPrefix} {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return "gateway " + strings.Trim(prefix, "/")
+		}
+	}
+	return "http " + r.Method
+}
+
+// statusWriter records the status of a response, keeping the optional
+// interfaces of the ResponseWriter the handlers use.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status = status
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
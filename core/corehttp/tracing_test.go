@@ -0,0 +1,118 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	opentracing "gx/ipfs/QmWLWmRVSiagqP15jczsGME1qpob6HDbtbHAY2he9W5iUo/opentracing-go"
+	mocktracer "gx/ipfs/QmWLWmRVSiagqP15jczsGME1qpob6HDbtbHAY2he9W5iUo/opentracing-go/mocktracer"
+)
+
+func TestRequestIDs(t *testing.T) {
+	for id, valid := range map[string]bool{
+		"f3a2-7b":                true,
+		"Root=1-5759e988-bd862e": true,
+		"":                       false,
+		"a b":                    false,
+		"a\nb":                   false,
+		strings.Repeat("a", 129): false,
+	} {
+		if validRequestID(id) != valid {
+			t.Errorf("expected the validity of %q to be %v", id, valid)
+		}
+	}
+
+	for tp, expected := range map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": "",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01":                 "",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "",
+		"garbage": "",
+	} {
+		if id, _ := traceparentID(tp); id != expected {
+			t.Errorf("expected the trace ID of %q to be %q, got %q", tp, expected, id)
+		}
+	}
+}
+
+func TestRequestTracing(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	var seen string
+	h := withRequestTracing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r)
+		if opentracing.SpanFromContext(r.Context()) == nil {
+			t.Error("expected the span of the request in its context")
+		}
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("expected the ResponseWriter to stay a Flusher")
+		}
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+		}
+	}))
+
+	do := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("/ipfs/QmFoo", nil)
+	id := rec.Header().Get(RequestIDHeader)
+	if len(id) != 32 || id != seen {
+		t.Fatalf("expected a random request ID in the response and the context, got %q and %q", id, seen)
+	}
+	if other := do("/ipfs/QmFoo", nil).Header().Get(RequestIDHeader); other == id {
+		t.Fatal("expected the request IDs to differ")
+	}
+
+	rec = do("/api/v0/pin/add", http.Header{RequestIDHeader: {"proxy-42"}})
+	if rec.Header().Get(RequestIDHeader) != "proxy-42" || seen != "proxy-42" {
+		t.Fatalf("expected the request ID of the client to be kept, got %q", rec.Header().Get(RequestIDHeader))
+	}
+
+	rec = do("/ipns/example.com/missing", http.Header{
+		RequestIDHeader:   {"bad id"},
+		traceparentHeader: {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	})
+	if rec.Header().Get(RequestIDHeader) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the trace ID of the traceparent, got %q", rec.Header().Get(RequestIDHeader))
+	}
+
+	// a span of the client is the parent of the one of the request
+	parent := tracer.StartSpan("client")
+	header := http.Header{}
+	if err := tracer.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+		t.Fatal(err)
+	}
+	do("/api/v0/version", header)
+	parent.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 6 {
+		t.Fatalf("expected 6 spans, got %d", len(spans))
+	}
+	for i, name := range []string{"gateway ipfs", "gateway ipfs", "api pin/add", "gateway ipns", "api version"} {
+		if spans[i].OperationName != name {
+			t.Errorf("expected span %d to be %q, got %q", i, name, spans[i].OperationName)
+		}
+	}
+	if spans[2].Tag("request.id") != "proxy-42" {
+		t.Errorf("expected the request ID in the span, got %v", spans[2].Tags())
+	}
+	if spans[3].Tag("http.status_code") != uint16(http.StatusNotFound) {
+		t.Errorf("expected the status in the span, got %v", spans[3].Tags())
+	}
+	if spans[4].ParentID != parent.(*mocktracer.MockSpan).SpanContext.SpanID {
+		t.Error("expected the span of the client to be the parent")
+	}
+}
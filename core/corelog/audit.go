@@ -0,0 +1,95 @@
+package corelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+)
+
+// DefaultAuditPath is the audit log file, relative to the repo.
+const DefaultAuditPath = "audit.log"
+
+// AuditConfig is read from the API.Audit config section. The file is
+// rotated as the log files of the Logging config.
+type AuditConfig struct {
+	Enabled bool
+	FileConfig
+	// OmitArguments leaves the arguments and the options of the commands
+	// out of the entries.
+	OmitArguments bool
+}
+
+// AuditEntry is an API request, a line of the audit log.
+type AuditEntry struct {
+	Time      time.Time
+	Command   string
+	Arguments []string            `json:",omitempty"`
+	Options   map[string][]string `json:",omitempty"`
+	// Caller is the API namespace of the token of the request, empty for
+	// the requests without a token.
+	Caller    string `json:",omitempty"`
+	Remote    string
+	UserAgent string `json:",omitempty"`
+	RequestID string `json:",omitempty"`
+	// Duration is in nanoseconds.
+	Duration time.Duration
+	Status   int
+	Error    string `json:",omitempty"`
+}
+
+// AuditLog writes the API requests to a rotated file, as JSON objects, one
+// per line.
+type AuditLog struct {
+	f             *File
+	omitArguments bool
+}
+
+// OpenAuditLog opens the audit log of the API.Audit config of r, whose root
+// directory is root, nil if it is disabled.
+func OpenAuditLog(r repo.Repo, root string) (*AuditLog, error) {
+	var cfg AuditConfig
+	if err := repo.ConfigSection(r, "API.Audit", &cfg); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Path == "" {
+		cfg.Path = DefaultAuditPath
+	}
+
+	f, err := openConfigFile(cfg.FileConfig, root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API.Audit: %s", err)
+	}
+	return NewAuditLog(f, cfg.OmitArguments), nil
+}
+
+// NewAuditLog returns the audit log written to f, without the arguments of
+// the commands if omitArguments is set.
+func NewAuditLog(f *File, omitArguments bool) *AuditLog {
+	return &AuditLog{f: f, omitArguments: omitArguments}
+}
+
+// Record writes e to the log.
+func (l *AuditLog) Record(e *AuditEntry) {
+	if l.omitArguments {
+		e.Arguments = nil
+		e.Options = nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("audit log: %s", err)
+		return
+	}
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		log.Errorf("audit log: %s", err)
+	}
+}
+
+// Close closes the file.
+func (l *AuditLog) Close() error {
+	return l.f.Close()
+}
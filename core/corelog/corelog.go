@@ -0,0 +1,287 @@
+// Package corelog writes the logs of the daemon to the sinks set in the
+// Logging config: stderr, log files rotated by size or age and remote
+// collectors.
+package corelog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	gologging "gx/ipfs/QmcaSwFc5RBg8yCq54QURwEU4nwjfCpjbpmaAm4VbdGLKv/go-logging"
+)
+
+var log = logging.Logger("corelog")
+
+// envLogFile is the file go-log writes to, in addition to stderr.
+const envLogFile = "GOLOG_FILE"
+
+// Sink types.
+const (
+	SinkStderr = "stderr"
+	SinkFile   = "file"
+)
+
+// FileConfig is a log file of the Logging config.
+type FileConfig struct {
+	// Path is the path of the file, relative to the repo if not absolute.
+	Path string
+	// MaxSize is the size above which the file is rotated, e.g. "100MB".
+	MaxSize string
+	// RotateEvery is the age after which the file is rotated, e.g. "24h".
+	RotateEvery string
+	// MaxBackups is the number of rotated files kept. Zero keeps them all.
+	MaxBackups int
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// Config is read from the Logging config section.
+type Config struct {
+	// Stderr writes the logs to stderr. It defaults to true.
+	Stderr  bool
+	Files   []FileConfig
+	Remotes []RemoteConfig
+}
+
+// SinkStatus describes a sink the logs are written to.
+type SinkStatus struct {
+	Type string
+	// The fields below are only set for files.
+	Path         string     `json:",omitempty"`
+	Size         int64      `json:",omitempty"`
+	MaxSize      int64      `json:",omitempty"`
+	RotateEvery  string     `json:",omitempty"`
+	MaxBackups   int        `json:",omitempty"`
+	Compress     bool       `json:",omitempty"`
+	Rotations    int        `json:",omitempty"`
+	LastRotation *time.Time `json:",omitempty"`
+	Backups      int        `json:",omitempty"`
+	// The fields below are only set for remote collectors.
+	Address   string `json:",omitempty"`
+	Queued    int    `json:",omitempty"`
+	Sent      uint64 `json:",omitempty"`
+	Dropped   uint64 `json:",omitempty"`
+	LastError string `json:",omitempty"`
+}
+
+// Sinks are the destinations of the logs.
+type Sinks struct {
+	stderr  bool
+	envFile string
+	files   []*File
+	remotes []*Remote
+}
+
+var (
+	currentLk sync.Mutex
+	current   *Sinks
+)
+
+// Setup reads the Logging config of r, whose root directory is root, and
+// writes the logs to the sinks it sets until the returned Sinks are closed.
+func Setup(r repo.Repo, root string) (*Sinks, error) {
+	cfg := Config{Stderr: true}
+	if err := repo.ConfigSection(r, "Logging", &cfg); err != nil {
+		return nil, err
+	}
+
+	s, err := NewSinks(cfg, root)
+	if err != nil {
+		return nil, err
+	}
+	s.install()
+	return s, nil
+}
+
+// NewSinks opens the sinks set by cfg. Relative file paths are relative to
+// root.
+func NewSinks(cfg Config, root string) (*Sinks, error) {
+	if !cfg.Stderr && len(cfg.Files) == 0 && len(cfg.Remotes) == 0 {
+		return nil, fmt.Errorf("no logs would be written: Logging.Stderr is false and no Logging.Files or Logging.Remotes are set")
+	}
+
+	s := &Sinks{stderr: cfg.Stderr, envFile: os.Getenv(envLogFile)}
+	for i, fc := range cfg.Files {
+		f, err := openConfigFile(fc, root)
+		if err != nil {
+			s.closeFiles()
+			return nil, fmt.Errorf("invalid Logging.Files[%d]: %s", i, err)
+		}
+		s.files = append(s.files, f)
+	}
+	for i, rc := range cfg.Remotes {
+		r, err := openRemote(rc)
+		if err != nil {
+			s.closeFiles()
+			return nil, fmt.Errorf("invalid Logging.Remotes[%d]: %s", i, err)
+		}
+		s.remotes = append(s.remotes, r)
+	}
+	return s, nil
+}
+
+func openConfigFile(fc FileConfig, root string) (*File, error) {
+	if fc.Path == "" {
+		return nil, fmt.Errorf("no path")
+	}
+	path := fc.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+
+	var opts FileOptions
+	if fc.MaxSize != "" {
+		size, err := humanize.ParseBytes(fc.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("MaxSize: %s", err)
+		}
+		opts.MaxSize = int64(size)
+	}
+	if fc.RotateEvery != "" {
+		d, err := time.ParseDuration(fc.RotateEvery)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("RotateEvery: invalid duration %q", fc.RotateEvery)
+		}
+		opts.RotateEvery = d
+	}
+	if fc.MaxBackups < 0 {
+		return nil, fmt.Errorf("MaxBackups must not be negative")
+	}
+	opts.MaxBackups = fc.MaxBackups
+	opts.Compress = fc.Compress
+
+	return OpenFile(path, opts)
+}
+
+// install replaces the backends of the loggers with the sinks. Only the
+// default backends are used if the config doesn't add log files or remote
+// collectors, keeping the file set by GOLOG_FILE untouched.
+func (s *Sinks) install() {
+	currentLk.Lock()
+	defer currentLk.Unlock()
+	current = s
+
+	if s.stderr && len(s.files) == 0 && len(s.remotes) == 0 {
+		return
+	}
+
+	var backends []gologging.Backend
+	if s.envFile != "" {
+		// go-log truncated it at startup, the old backend can't be kept
+		f, err := os.OpenFile(s.envFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Errorf("reopening %s: %s", s.envFile, err)
+			s.envFile = ""
+		} else {
+			backends = append(backends, gologging.NewLogBackend(f, "", 0))
+		}
+	}
+	if s.stderr {
+		backends = append(backends, gologging.NewLogBackend(os.Stderr, "", 0))
+	}
+	plain := gologging.MustStringFormatter(logging.LogFormats["nocolor"])
+	for _, f := range s.files {
+		backends = append(backends, gologging.NewBackendFormatter(gologging.NewLogBackend(f, "", 0), plain))
+	}
+	for _, r := range s.remotes {
+		backends = append(backends, r)
+	}
+	setBackends(backends...)
+}
+
+// setBackends replaces the backends of the loggers, keeping their levels.
+func setBackends(backends ...gologging.Backend) {
+	levels := map[string]gologging.Level{"": gologging.GetLevel("")}
+	for _, name := range logging.GetSubsystems() {
+		levels[name] = gologging.GetLevel(name)
+	}
+
+	gologging.SetBackend(backends...)
+	for name, lvl := range levels {
+		gologging.SetLevel(lvl, name)
+	}
+}
+
+// Close writes the logs to stderr again, closes the log files and ships the
+// entries left to the remote collectors.
+func (s *Sinks) Close() error {
+	currentLk.Lock()
+	if current == s {
+		current = nil
+		if len(s.files) > 0 || len(s.remotes) > 0 {
+			setBackends(gologging.NewLogBackend(os.Stderr, "", 0))
+		}
+	}
+	currentLk.Unlock()
+
+	return s.closeFiles()
+}
+
+func (s *Sinks) closeFiles() error {
+	var err error
+	for _, f := range s.files {
+		if cerr := f.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	for _, r := range s.remotes {
+		if cerr := r.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Status returns the sinks the logs are currently written to.
+func Status() []SinkStatus {
+	currentLk.Lock()
+	s := current
+	currentLk.Unlock()
+
+	if s == nil {
+		s = &Sinks{stderr: true, envFile: os.Getenv(envLogFile)}
+	}
+
+	var out []SinkStatus
+	if s.stderr {
+		out = append(out, SinkStatus{Type: SinkStderr})
+	}
+	if s.envFile != "" {
+		st := SinkStatus{Type: SinkFile, Path: s.envFile}
+		if fi, err := os.Stat(s.envFile); err == nil {
+			st.Size = fi.Size()
+		}
+		out = append(out, st)
+	}
+	for _, f := range s.files {
+		fs := f.Status()
+		st := SinkStatus{
+			Type:       SinkFile,
+			Path:       fs.Path,
+			Size:       fs.Size,
+			MaxSize:    f.opts.MaxSize,
+			MaxBackups: f.opts.MaxBackups,
+			Compress:   f.opts.Compress,
+			Rotations:  fs.Rotations,
+			Backups:    fs.Backups,
+		}
+		if !fs.LastRotation.IsZero() {
+			st.LastRotation = &fs.LastRotation
+		}
+		if f.opts.RotateEvery > 0 {
+			st.RotateEvery = f.opts.RotateEvery.String()
+		}
+		out = append(out, st)
+	}
+	for _, r := range s.remotes {
+		out = append(out, r.status())
+	}
+	return out
+}
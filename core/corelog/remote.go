@@ -0,0 +1,503 @@
+package corelog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gologging "gx/ipfs/QmcaSwFc5RBg8yCq54QURwEU4nwjfCpjbpmaAm4VbdGLKv/go-logging"
+)
+
+// Remote sink types.
+const (
+	SinkSyslog = "syslog"
+	SinkLoki   = "loki"
+	SinkHTTP   = "http"
+)
+
+const (
+	defaultBufferSize    = 10000
+	defaultFlushInterval = time.Second
+	// remoteBatchSize is the most entries shipped at once.
+	remoteBatchSize = 500
+	remoteTimeout   = 10 * time.Second
+)
+
+var (
+	minShipRetryDelay = time.Second
+	maxShipRetryDelay = time.Minute
+)
+
+// RemoteConfig is a remote collector of the Logging config.
+type RemoteConfig struct {
+	// Type is "syslog", "loki" or "http".
+	Type string
+	// Address is the address of the syslog server, e.g. "udp://host:514",
+	// "tcp://host:514" or "unix:///dev/log", the URL of the Loki server or
+	// the URL the entries are posted to for "http".
+	Address string
+	// Tag is the application name of the syslog messages, "ipfs" if unset.
+	Tag string
+	// Labels are the labels of the Loki stream, {"job": "ipfs"} if unset.
+	Labels map[string]string
+	// Headers are added to the requests to Loki and to the HTTP collector,
+	// e.g. for authentication.
+	Headers map[string]string
+	// BufferSize is the number of entries kept while the collector can't be
+	// reached, the oldest are dropped. It defaults to 10000.
+	BufferSize int
+	// FlushInterval is the most time an entry waits to be shipped, e.g.
+	// "5s". It defaults to "1s".
+	FlushInterval string
+}
+
+// Entry is a log entry shipped to a collector.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	System  string    `json:"system"`
+	Message string    `json:"msg"`
+}
+
+// shipper sends entries to a collector.
+type shipper interface {
+	ship(entries []Entry) error
+	Close() error
+}
+
+// Remote is a log backend shipping the entries to a collector in the
+// background. The entries are buffered while the collector can't be reached,
+// and shipped again with an exponential backoff.
+type Remote struct {
+	typ     string
+	address string
+	shipper shipper
+	bufSize int
+	flush   time.Duration
+
+	mu sync.Mutex
+	// buf holds the entries to ship, head is the number of entries removed
+	// from it so far, shipped or dropped.
+	buf     []Entry
+	head    uint64
+	sent    uint64
+	dropped uint64
+	lastErr string
+
+	wake    chan struct{}
+	closing chan struct{}
+	closed  chan struct{}
+}
+
+func openRemote(rc RemoteConfig) (*Remote, error) {
+	if rc.Address == "" {
+		return nil, fmt.Errorf("no address")
+	}
+	if rc.BufferSize < 0 {
+		return nil, fmt.Errorf("BufferSize must not be negative")
+	}
+	flush := defaultFlushInterval
+	if rc.FlushInterval != "" {
+		d, err := time.ParseDuration(rc.FlushInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("FlushInterval: invalid duration %q", rc.FlushInterval)
+		}
+		flush = d
+	}
+
+	var s shipper
+	var err error
+	switch rc.Type {
+	case SinkSyslog:
+		s, err = newSyslogShipper(rc.Address, rc.Tag)
+	case SinkLoki:
+		s, err = newLokiShipper(rc.Address, rc.Labels, rc.Headers)
+	case SinkHTTP:
+		s, err = newHTTPShipper(rc.Address, rc.Headers)
+	default:
+		return nil, fmt.Errorf("unknown type %q, expected %s, %s or %s", rc.Type, SinkSyslog, SinkLoki, SinkHTTP)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newRemote(rc.Type, rc.Address, s, rc.BufferSize, flush), nil
+}
+
+func newRemote(typ, address string, s shipper, bufSize int, flush time.Duration) *Remote {
+	if bufSize == 0 {
+		bufSize = defaultBufferSize
+	}
+	r := &Remote{
+		typ:     typ,
+		address: address,
+		shipper: s,
+		bufSize: bufSize,
+		flush:   flush,
+		wake:    make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Log implements the backend of the loggers.
+func (r *Remote) Log(level gologging.Level, calldepth int, rec *gologging.Record) error {
+	e := Entry{
+		Time:    rec.Time,
+		Level:   level.String(),
+		System:  rec.Module,
+		Message: rec.Message(),
+	}
+
+	r.mu.Lock()
+	if len(r.buf) >= r.bufSize {
+		r.buf[0] = Entry{}
+		r.buf = r.buf[1:]
+		r.head++
+		r.dropped++
+	}
+	r.buf = append(r.buf, e)
+	full := len(r.buf) >= remoteBatchSize
+	r.mu.Unlock()
+
+	if full {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// shipBatch ships the oldest entries and returns how many.
+func (r *Remote) shipBatch() (int, error) {
+	r.mu.Lock()
+	n := len(r.buf)
+	if n > remoteBatchSize {
+		n = remoteBatchSize
+	}
+	batch := make([]Entry, n)
+	copy(batch, r.buf)
+	end := r.head + uint64(n)
+	r.mu.Unlock()
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	err := r.shipper.ship(batch)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.lastErr = err.Error()
+		return 0, err
+	}
+	r.lastErr = ""
+	r.sent += uint64(n)
+	// some of the entries may have been dropped while shipping
+	if end > r.head {
+		shipped := int(end - r.head)
+		for i := 0; i < shipped; i++ {
+			r.buf[i] = Entry{}
+		}
+		r.buf = r.buf[shipped:]
+		r.head = end
+	}
+	return n, nil
+}
+
+func (r *Remote) run() {
+	defer close(r.closed)
+
+	ticker := time.NewTicker(r.flush)
+	defer ticker.Stop()
+
+	delay := minShipRetryDelay
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.wake:
+		case <-r.closing:
+			r.shipLeft()
+			return
+		}
+
+		for {
+			n, err := r.shipBatch()
+			if err != nil {
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-r.closing:
+					timer.Stop()
+					r.shipLeft()
+					return
+				}
+				delay *= 2
+				if delay > maxShipRetryDelay {
+					delay = maxShipRetryDelay
+				}
+				continue
+			}
+			delay = minShipRetryDelay
+			if n < remoteBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// shipLeft makes a last try to ship the entries, what can't be shipped is
+// lost.
+func (r *Remote) shipLeft() {
+	for {
+		n, err := r.shipBatch()
+		if err != nil || n < remoteBatchSize {
+			return
+		}
+	}
+}
+
+// Close ships the entries left, if the collector can be reached, and stops.
+func (r *Remote) Close() error {
+	close(r.closing)
+	<-r.closed
+	return r.shipper.Close()
+}
+
+// status returns the status of the sink.
+func (r *Remote) status() SinkStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return SinkStatus{
+		Type:      r.typ,
+		Address:   r.address,
+		Queued:    len(r.buf),
+		Sent:      r.sent,
+		Dropped:   r.dropped,
+		LastError: r.lastErr,
+	}
+}
+
+// syslogShipper sends the entries as RFC 5424 messages.
+type syslogShipper struct {
+	network  string
+	addr     string
+	tag      string
+	hostname string
+	conn     net.Conn
+}
+
+func newSyslogShipper(address, tag string) (*syslogShipper, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	s := &syslogShipper{tag: tag}
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.network, s.addr = u.Scheme, u.Host
+	case "unix":
+		s.network, s.addr = "unixgram", u.Path
+	default:
+		return nil, fmt.Errorf("unknown syslog address %q, expected udp://, tcp:// or unix://", address)
+	}
+	if s.addr == "" {
+		return nil, fmt.Errorf("no address in %q", address)
+	}
+	if s.tag == "" {
+		s.tag = "ipfs"
+	}
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	return s, nil
+}
+
+// syslogSeverity maps the levels of the loggers to the syslog severities.
+var syslogSeverity = map[string]int{
+	"CRITICAL": 2,
+	"ERROR":    3,
+	"WARNING":  4,
+	"NOTICE":   5,
+	"INFO":     6,
+	"DEBUG":    7,
+}
+
+// syslogUser is the facility of the messages.
+const syslogUser = 1
+
+func (s *syslogShipper) format(e Entry) string {
+	sev, ok := syslogSeverity[e.Level]
+	if !ok {
+		sev = syslogSeverity["INFO"]
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s: %s",
+		syslogUser*8+sev, e.Time.UTC().Format(time.RFC3339Nano), s.hostname, s.tag, os.Getpid(), e.System, e.Message)
+	if s.network == "tcp" {
+		// octet counting framing, RFC 6587
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	return msg
+}
+
+func (s *syslogShipper) ship(entries []Entry) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, remoteTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	for _, e := range entries {
+		s.conn.SetWriteDeadline(time.Now().Add(remoteTimeout))
+		if _, err := s.conn.Write([]byte(s.format(e))); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogShipper) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// postShipper posts the entries to an HTTP endpoint.
+type postShipper struct {
+	url         string
+	contentType string
+	headers     map[string]string
+	client      *http.Client
+	encode      func([]Entry) ([]byte, error)
+}
+
+func checkHTTPURL(address string) (*url.URL, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("expected an http:// or https:// URL, got %q", address)
+	}
+	return u, nil
+}
+
+func newHTTPShipper(address string, headers map[string]string) (*postShipper, error) {
+	if _, err := checkHTTPURL(address); err != nil {
+		return nil, err
+	}
+	return &postShipper{
+		url:         address,
+		contentType: "application/x-ndjson",
+		headers:     headers,
+		client:      &http.Client{Timeout: remoteTimeout},
+		encode:      encodeNDJSON,
+	}, nil
+}
+
+// encodeNDJSON encodes the entries as JSON objects, one per line.
+func encodeNDJSON(entries []Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// lokiPushPath is the path of the push API of Loki, used when the URL of the
+// server has none.
+const lokiPushPath = "/loki/api/v1/push"
+
+func newLokiShipper(address string, labels, headers map[string]string) (*postShipper, error) {
+	u, err := checkHTTPURL(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = lokiPushPath
+	}
+	if len(labels) == 0 {
+		labels = map[string]string{"job": "ipfs"}
+	}
+	return &postShipper{
+		url:         u.String(),
+		contentType: "application/json",
+		headers:     headers,
+		client:      &http.Client{Timeout: remoteTimeout},
+		encode: func(entries []Entry) ([]byte, error) {
+			return encodeLoki(entries, labels)
+		},
+	}, nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// encodeLoki encodes the entries as a stream of the push API of Loki. The
+// lines are the entries in JSON.
+func encodeLoki(entries []Entry, labels map[string]string) ([]byte, error) {
+	stream := lokiStream{Stream: labels}
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), string(line)})
+	}
+	return json.Marshal(map[string][]lokiStream{"streams": {stream}})
+}
+
+func (s *postShipper) ship(entries []Entry) error {
+	body, err := s.encode(entries)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *postShipper) Close() error {
+	return nil
+}
@@ -0,0 +1,226 @@
+package corelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gologging "gx/ipfs/QmcaSwFc5RBg8yCq54QURwEU4nwjfCpjbpmaAm4VbdGLKv/go-logging"
+)
+
+func init() {
+	minShipRetryDelay = 10 * time.Millisecond
+}
+
+// testLogger returns a logger writing to r only.
+func testLogger(r *Remote) *gologging.Logger {
+	l := gologging.MustGetLogger("remote-test")
+	l.SetBackend(gologging.AddModuleLevel(r))
+	return l
+}
+
+// collector records the requests of a remote, failing the first ones.
+type collector struct {
+	mu     sync.Mutex
+	fail   int
+	bodies []string
+	header http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail > 0 {
+		c.fail--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var body strings.Builder
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		body.WriteString(sc.Text() + "\n")
+	}
+	c.bodies = append(c.bodies, body.String())
+	c.header = r.Header
+}
+
+func (c *collector) wait(t *testing.T, n int) []string {
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		bodies := c.bodies
+		c.mu.Unlock()
+		if len(bodies) >= n {
+			return bodies
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d requests", n)
+	return nil
+}
+
+func TestRemoteHTTP(t *testing.T) {
+	c := &collector{fail: 2}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	r, err := openRemote(RemoteConfig{
+		Type:          SinkHTTP,
+		Address:       srv.URL,
+		Headers:       map[string]string{"Authorization": "Bearer secret"},
+		FlushInterval: "10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	l := testLogger(r)
+	l.Errorf("first %d", 1)
+	l.Warning("second")
+
+	bodies := c.wait(t, 1)
+	lines := strings.Split(strings.TrimSpace(bodies[0]), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected the two entries in one request, got %q", bodies[0])
+	}
+	var e Entry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Level != "ERROR" || e.System != "remote-test" || e.Message != "first 1" || e.Time.IsZero() {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if got := c.header.Get("Authorization"); got != "Bearer secret" {
+		t.Fatalf("expected the configured header, got %q", got)
+	}
+
+	st := r.status()
+	if st.Sent != 2 || st.Queued != 0 || st.LastError != "" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+func TestRemoteBufferFull(t *testing.T) {
+	c := &collector{fail: 1 << 30}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	s, err := newHTTPShipper(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRemote(SinkHTTP, srv.URL, s, 3, 10*time.Millisecond)
+	l := testLogger(r)
+	for i := 0; i < 5; i++ {
+		l.Infof("entry %d", i)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	st := r.status()
+	if st.Queued != 3 || st.Dropped != 2 {
+		t.Fatalf("expected the oldest entries to be dropped, got %+v", st)
+	}
+	if !strings.Contains(st.LastError, "503") {
+		t.Fatalf("expected the last error to be recorded, got %q", st.LastError)
+	}
+
+	// the collector comes back, the entries left are shipped on close
+	c.mu.Lock()
+	c.fail = 0
+	c.mu.Unlock()
+	r.Close()
+	bodies := c.wait(t, 1)
+	if !strings.Contains(bodies[0], "entry 2") || strings.Contains(bodies[0], "entry 1") {
+		t.Fatalf("expected the newest entries to be shipped, got %q", bodies[0])
+	}
+}
+
+func TestRemoteLoki(t *testing.T) {
+	var path string
+	var push struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&push)
+		w.WriteHeader(http.StatusNoContent)
+		close(done)
+	}))
+	defer srv.Close()
+
+	r, err := openRemote(RemoteConfig{Type: SinkLoki, Address: srv.URL, FlushInterval: "10ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testLogger(r).Error("to loki")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("nothing pushed to loki")
+	}
+	if path != lokiPushPath {
+		t.Fatalf("expected the push API, got %s", path)
+	}
+	if len(push.Streams) != 1 || push.Streams[0].Stream["job"] != "ipfs" || len(push.Streams[0].Values) != 1 {
+		t.Fatalf("unexpected push: %+v", push)
+	}
+	if line := push.Streams[0].Values[0][1]; !strings.Contains(line, `"msg":"to loki"`) {
+		t.Fatalf("unexpected line: %s", line)
+	}
+}
+
+func TestRemoteSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, err := openRemote(RemoteConfig{
+		Type:          SinkSyslog,
+		Address:       "udp://" + conn.LocalAddr().String(),
+		Tag:           "node1",
+		FlushInterval: "10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testLogger(r).Warning("to syslog")
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// user facility, warning severity
+	if !strings.HasPrefix(msg, "<12>1 ") || !strings.Contains(msg, " node1 ") || !strings.HasSuffix(msg, "remote-test: to syslog") {
+		t.Fatalf("unexpected message: %q", msg)
+	}
+}
+
+func TestOpenRemoteInvalid(t *testing.T) {
+	for _, rc := range []RemoteConfig{
+		{Type: "kafka", Address: "http://localhost"},
+		{Type: SinkHTTP},
+		{Type: SinkHTTP, Address: "localhost:8080"},
+		{Type: SinkSyslog, Address: "http://localhost"},
+		{Type: SinkLoki, Address: "http://localhost", FlushInterval: "soon"},
+	} {
+		if r, err := openRemote(rc); err == nil {
+			r.Close()
+			t.Errorf("expected %+v to be rejected", rc)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package corelog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat is the suffix of rotated files, sorting like their
+// rotation times.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// FileOptions sets when a File is rotated and what happens to rotated files.
+type FileOptions struct {
+	// MaxSize is the size in bytes above which the file is rotated. Zero
+	// disables size based rotation.
+	MaxSize int64
+	// RotateEvery is the age of the file after which it is rotated. Zero
+	// disables time based rotation.
+	RotateEvery time.Duration
+	// MaxBackups is the number of rotated files kept, the oldest are
+	// removed. Zero keeps them all.
+	MaxBackups int
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// File is a log file rotated when it gets too large or too old. Rotated files
+// are renamed with the time of the rotation appended to their name.
+type File struct {
+	path string
+	opts FileOptions
+	now  func() time.Time
+
+	mu           sync.Mutex
+	f            *os.File
+	size         int64
+	opened       time.Time
+	rotations    int
+	lastRotation time.Time
+
+	// rotated files are compressed and pruned in the background, one
+	// rotation at a time.
+	bg sync.Mutex
+	wg sync.WaitGroup
+}
+
+// OpenFile opens the log file at path, appending to it if it exists.
+func OpenFile(path string, opts FileOptions) (*File, error) {
+	return openFile(path, opts, time.Now)
+}
+
+func openFile(path string, opts FileOptions, now func() time.Time) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &File{path: path, opts: opts, now: now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f = file
+	f.size = fi.Size()
+	f.opened = f.now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if needed.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) shouldRotate(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.RotateEvery > 0 && f.now().Sub(f.opened) >= f.opts.RotateEvery
+}
+
+// Rotate renames the file and opens a new one in its place.
+func (f *File) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+
+	now := f.now()
+	rotated := f.path + "." + now.UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(f.path, rotated); err != nil {
+		// keep writing to the file
+		if oerr := f.open(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.rotations++
+	f.lastRotation = now
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.bg.Lock()
+		defer f.bg.Unlock()
+
+		if f.opts.Compress {
+			if err := compressFile(rotated); err != nil {
+				log.Errorf("compressing rotated log file %s: %s", rotated, err)
+			}
+		}
+		if f.opts.MaxBackups > 0 {
+			f.prune()
+		}
+	}()
+	return nil
+}
+
+// backups returns the rotated files, oldest first.
+func (f *File) backups() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := f.path + "."
+	var backups []string
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, prefix), ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, suffix); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (f *File) prune() {
+	backups, err := f.backups()
+	if err != nil {
+		log.Errorf("listing rotated log files of %s: %s", f.path, err)
+		return
+	}
+	for len(backups) > f.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Errorf("removing rotated log file: %s", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(path)
+}
+
+// FileStatus describes a log file.
+type FileStatus struct {
+	Path         string
+	Size         int64
+	Rotations    int
+	LastRotation time.Time
+	Backups      int
+}
+
+// Status returns the current state of the file.
+func (f *File) Status() FileStatus {
+	f.mu.Lock()
+	s := FileStatus{
+		Path:         f.path,
+		Size:         f.size,
+		Rotations:    f.rotations,
+		LastRotation: f.lastRotation,
+	}
+	f.mu.Unlock()
+
+	if backups, err := f.backups(); err == nil {
+		s.Backups = len(backups)
+	}
+	return s
+}
+
+// Close closes the file, and waits for the rotated files to be compressed.
+func (f *File) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.f != nil {
+		err = f.f.Close()
+		f.f = nil
+	}
+	f.mu.Unlock()
+
+	// the background work may log, so it can't be waited for with the
+	// lock held
+	f.wg.Wait()
+	return err
+}
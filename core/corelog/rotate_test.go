@@ -0,0 +1,178 @@
+package corelog
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "corelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeLine(t *testing.T, f *File, line string) {
+	if _, err := f.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	clock := &testClock{time.Unix(1500000000, 0)}
+	path := filepath.Join(dir, "ipfs.log")
+	f, err := openFile(path, FileOptions{MaxSize: 9, MaxBackups: 2}, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"one", "two", "three", "four", "five"} {
+		writeLine(t, f, line)
+		clock.advance(time.Second)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "five\n" {
+		t.Fatalf("unexpected content of the log file: %q", data)
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+	data, err = ioutil.ReadFile(backups[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "four\n" {
+		t.Fatalf("unexpected content of the last backup: %q", data)
+	}
+
+	if s := f.Status(); s.Rotations != 3 || s.Backups != 2 {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	clock := &testClock{time.Unix(1500000000, 0)}
+	path := filepath.Join(dir, "ipfs.log")
+	f, err := openFile(path, FileOptions{RotateEvery: time.Hour}, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	writeLine(t, f, "one")
+	clock.advance(59 * time.Minute)
+	writeLine(t, f, "two")
+	if f.Status().Rotations != 0 {
+		t.Fatal("expected no rotation before the file gets old")
+	}
+
+	clock.advance(time.Minute)
+	writeLine(t, f, "three")
+	if f.Status().Rotations != 1 {
+		t.Fatal("expected the old file to be rotated")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "three\n" {
+		t.Fatalf("unexpected content of the log file: %q", data)
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ipfs.log")
+	f, err := OpenFile(path, FileOptions{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLine(t, f, "compressed")
+	if err := f.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".gz") {
+		t.Fatalf("expected a compressed backup, got %v", backups)
+	}
+
+	gz, err := os.Open(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "compressed\n" {
+		t.Fatalf("unexpected content of the backup: %q", data)
+	}
+}
+
+func TestNewSinksErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, cfg := range []Config{
+		{Stderr: false},
+		{Stderr: true, Files: []FileConfig{{Path: ""}}},
+		{Stderr: true, Files: []FileConfig{{Path: "ipfs.log", MaxSize: "big"}}},
+		{Stderr: true, Files: []FileConfig{{Path: "ipfs.log", RotateEvery: "daily"}}},
+		{Stderr: true, Files: []FileConfig{{Path: "ipfs.log", MaxBackups: -1}}},
+	} {
+		if s, err := NewSinks(cfg, dir); err == nil {
+			s.Close()
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
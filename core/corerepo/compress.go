@@ -0,0 +1,80 @@
+package corerepo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-ipfs/core"
+	compressds "github.com/ipfs/go-ipfs/thirdparty/compressds"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+)
+
+// ErrNoCompression is returned when no datastore of the repo compresses
+// its blocks.
+var ErrNoCompression = errors.New("no compressed datastore in Datastore.Spec")
+
+// CompressionStat reports the compression of the blocks of the repo.
+type CompressionStat struct {
+	Blocks     uint64
+	Compressed uint64
+	// StoredSize is the size of the blocks on disk, LogicalSize their
+	// size once decompressed.
+	StoredSize  uint64
+	LogicalSize uint64
+}
+
+// compressedDatastores returns the datastores of the repo of n compressing
+// their blocks.
+func compressedDatastores(n *core.IpfsNode) []*compressds.Datastore {
+	r, ok := n.Repo.(interface {
+		CompressedDatastores() []*compressds.Datastore
+	})
+	if !ok {
+		return nil
+	}
+	return r.CompressedDatastores()
+}
+
+// RepoCompressionStat reads every block of the compressed datastores of the
+// repo to sum their stored and logical sizes.
+func RepoCompressionStat(ctx context.Context, n *core.IpfsNode) (*CompressionStat, error) {
+	dss := compressedDatastores(n)
+	if len(dss) == 0 {
+		return nil, ErrNoCompression
+	}
+
+	var st CompressionStat
+	for _, d := range dss {
+		s, err := d.Stat(ctx)
+		if err != nil {
+			return nil, err
+		}
+		st.Blocks += s.Blocks
+		st.Compressed += s.Compressed
+		st.StoredSize += s.StoredSize
+		st.LogicalSize += s.LogicalSize
+	}
+	return &st, nil
+}
+
+// Compress rewrites the blocks stored before the compression was enabled
+// compressed, or all the blocks uncompressed when decompress is set.
+// progress is called for every block rewritten, with the change of its size
+// on disk. The garbage collection waits for every batch of rewrites.
+func Compress(ctx context.Context, n *core.IpfsNode, decompress bool, progress func(c cid.Cid, delta int)) error {
+	dss := compressedDatastores(n)
+	if len(dss) == 0 {
+		return ErrNoCompression
+	}
+
+	lock := func() func() {
+		return n.Blockstore.PinLock().Unlock
+	}
+	for _, d := range dss {
+		if err := d.Convert(ctx, decompress, lock, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
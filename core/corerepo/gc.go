@@ -3,7 +3,6 @@ package corerepo
 import (
 	"bytes"
 	"context"
-	"errors"
 	"time"
 
 	"github.com/ipfs/go-ipfs/core"
@@ -14,11 +13,15 @@ import (
 	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
 	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	bserv "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
+	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
 )
 
 var log = logging.Logger("corerepo")
 
-var ErrMaxStorageExceeded = errors.New("maximum storage limit exceeded. Try to unpin some files")
+var ErrMaxStorageExceeded = core.ErrStorageMaxExceeded
 
 type GC struct {
 	Node       *core.IpfsNode
@@ -79,10 +82,51 @@ func BestEffortRoots(filesRoot *mfs.Root) ([]cid.Cid, error) {
 	return []cid.Cid{rootDag.Cid()}, nil
 }
 
+// filesRoots returns the roots of the files API of n. The files root is locked
+// while they are read, ReshardFiles may replace it.
+func filesRoots(n *core.IpfsNode) ([]cid.Cid, error) {
+	root, unlock := n.LockFilesRoot()
+	defer unlock()
+	return BestEffortRoots(root)
+}
+
+// FilesBlocks returns the local blocks of the files API root, which are kept
+// by the garbage collector although they may not be pinned.
+func FilesBlocks(ctx context.Context, n *core.IpfsNode) (*cid.Set, error) {
+	roots, err := filesRoots(n)
+	if err != nil {
+		return nil, err
+	}
+
+	set := cid.NewSet()
+	if err := gc.Descendants(ctx, localLinks(n), set, roots); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// localDAG returns a DAG service of the local blocks of n only.
+func localDAG(n *core.IpfsNode) ipld.DAGService {
+	return dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore)))
+}
+
+// localLinks returns a dag.GetLinks which doesn't fetch the blocks missing
+// from the blockstore of n, and skips them.
+func localLinks(n *core.IpfsNode) dag.GetLinks {
+	ng := localDAG(n)
+	return func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		links, err := ipld.GetLinks(ctx, ng, c)
+		if err == ipld.ErrNotFound {
+			return nil, nil
+		}
+		return links, err
+	}
+}
+
 func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel() // in case error occurs during operation
-	roots, err := BestEffortRoots(n.FilesRoot)
+	roots, err := filesRoots(n)
 	if err != nil {
 		return err
 	}
@@ -146,7 +190,7 @@ func (e *MultiError) Error() string {
 }
 
 func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
-	roots, err := BestEffortRoots(n.FilesRoot)
+	roots, err := filesRoots(n)
 	if err != nil {
 		out := make(chan gc.Result)
 		out <- gc.Result{Error: err}
@@ -0,0 +1,89 @@
+package corerepo
+
+import (
+	"context"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/filestore"
+	gc "github.com/ipfs/go-ipfs/pin/gc"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+)
+
+// Inventory counts the pins of a repo and the references of its filestore.
+type Inventory struct {
+	RecursivePins int
+	DirectPins    int
+	// IndirectPins is the number of local blocks pinned by the recursive
+	// pins, besides the pinned roots.
+	IndirectPins int
+	// PinnedBytes is the size of all the local pinned blocks.
+	PinnedBytes uint64
+
+	// FilestoreFiles and FilestoreURLs are the numbers of blocks referenced
+	// by the filestore and the urlstore.
+	FilestoreFiles int
+	FilestoreURLs  int
+	// FilestoreBytes is the size of all the referenced blocks.
+	FilestoreBytes uint64
+}
+
+// TakeInventory walks the pinned DAGs and the filestore of n to count them.
+// Only the local blocks are counted, nothing is fetched.
+func TakeInventory(ctx context.Context, n *core.IpfsNode) (*Inventory, error) {
+	inv := &Inventory{}
+
+	recursive := n.Pinning.RecursiveKeys()
+	direct := n.Pinning.DirectKeys()
+	inv.RecursivePins = len(recursive)
+	inv.DirectPins = len(direct)
+
+	pinned := cid.NewSet()
+	if err := gc.Descendants(ctx, localLinks(n), pinned, recursive); err != nil {
+		return nil, err
+	}
+	roots := cid.NewSet()
+	for _, c := range recursive {
+		roots.Add(c)
+	}
+	for _, c := range direct {
+		pinned.Add(c)
+		roots.Add(c)
+	}
+	inv.IndirectPins = pinned.Len() - roots.Len()
+
+	err := pinned.ForEach(func(c cid.Cid) error {
+		size, err := n.Blockstore.GetSize(c)
+		if err == nil {
+			inv.PinnedBytes += uint64(size)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Filestore == nil {
+		return inv, nil
+	}
+	next, err := filestore.ListAll(n.Filestore, false)
+	if err != nil {
+		return nil, err
+	}
+	for r := next(); r != nil; r = next() {
+		if !r.Key.Defined() {
+			continue
+		}
+		if filestore.IsURL(r.FilePath) {
+			inv.FilestoreURLs++
+		} else {
+			inv.FilestoreFiles++
+		}
+		inv.FilestoreBytes += r.Size
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
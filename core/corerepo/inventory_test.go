@@ -0,0 +1,55 @@
+package corerepo
+
+import (
+	"context"
+	"testing"
+
+	coremock "github.com/ipfs/go-ipfs/core/mock"
+
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+)
+
+func TestTakeInventory(t *testing.T) {
+	ctx := context.Background()
+	n, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := dag.NodeWithData([]byte("child"))
+	parent := dag.NodeWithData([]byte("parent"))
+	if err := parent.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	direct := dag.NodeWithData([]byte("direct"))
+	unpinned := dag.NodeWithData([]byte("unpinned"))
+	for _, nd := range []*dag.ProtoNode{child, parent, direct, unpinned} {
+		if err := n.DAG.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := n.Pinning.Pin(ctx, parent, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Pin(ctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+
+	inv, err := TakeInventory(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inv.RecursivePins != 1 || inv.DirectPins != 1 || inv.IndirectPins != 1 {
+		t.Fatalf("expected 1 pin of each type, got %d recursive, %d direct, %d indirect",
+			inv.RecursivePins, inv.DirectPins, inv.IndirectPins)
+	}
+	size := uint64(len(child.RawData()) + len(parent.RawData()) + len(direct.RawData()))
+	if inv.PinnedBytes != size {
+		t.Fatalf("expected %d pinned bytes, got %d", size, inv.PinnedBytes)
+	}
+	if inv.FilestoreFiles != 0 || inv.FilestoreURLs != 0 {
+		t.Fatal("expected no filestore references")
+	}
+}
@@ -0,0 +1,302 @@
+package corerepo
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	gopath "path"
+	"sort"
+
+	"github.com/ipfs/go-ipfs/core"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// Usage is the size of a pinned DAG, of a block or of an mfs subtree.
+type Usage struct {
+	Cid cid.Cid
+	// Path is the mfs path of the subtrees.
+	Path string
+	// Size is the number of bytes of the distinct local blocks. For the
+	// pins, only the blocks no other pin nor the files API root references
+	// are counted: the bytes unpinning would free.
+	Size   uint64
+	Blocks int
+}
+
+// usageHeap keeps the largest usages, the smallest of them on top.
+type usageHeap struct {
+	usages []Usage
+	max    int
+}
+
+func (h *usageHeap) Len() int           { return len(h.usages) }
+func (h *usageHeap) Less(i, j int) bool { return h.usages[i].Size < h.usages[j].Size }
+func (h *usageHeap) Swap(i, j int)      { h.usages[i], h.usages[j] = h.usages[j], h.usages[i] }
+func (h *usageHeap) Push(x interface{}) { h.usages = append(h.usages, x.(Usage)) }
+func (h *usageHeap) Pop() interface{} {
+	u := h.usages[len(h.usages)-1]
+	h.usages = h.usages[:len(h.usages)-1]
+	return u
+}
+
+func (h *usageHeap) add(u Usage) {
+	if h.Len() < h.max {
+		heap.Push(h, u)
+	} else if u.Size > h.usages[0].Size {
+		h.usages[0] = u
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the usages, the largest first.
+func (h *usageHeap) sorted() []Usage {
+	out := append([]Usage(nil), h.usages...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Size > out[j].Size })
+	return out
+}
+
+// LargestBlocks returns the count largest local blocks.
+func LargestBlocks(ctx context.Context, n *core.IpfsNode, count int) ([]Usage, error) {
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &usageHeap{max: count}
+	for c := range keys {
+		size, err := n.Blockstore.GetSize(c)
+		if err != nil {
+			continue
+		}
+		h.add(Usage{Cid: c, Size: uint64(size), Blocks: 1})
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return h.sorted(), nil
+}
+
+// sharedOwner marks the blocks referenced by several pins.
+const sharedOwner = -1
+
+// LargestPins returns the count pins whose unpinning would free the most
+// bytes. Only the local blocks are counted, nothing is fetched.
+func LargestPins(ctx context.Context, n *core.IpfsNode, count int) ([]Usage, error) {
+	type pinned struct {
+		c         cid.Cid
+		recursive bool
+	}
+	var pins []pinned
+	for _, c := range n.Pinning.RecursiveKeys() {
+		pins = append(pins, pinned{c, true})
+	}
+	for _, c := range n.Pinning.DirectKeys() {
+		pins = append(pins, pinned{c, false})
+	}
+	// the files API root keeps its blocks too
+	if n.FilesRoot != nil {
+		roots, err := filesRoots(n)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range roots {
+			pins = append(pins, pinned{c, true})
+		}
+	}
+	reported := len(pins)
+	if n.FilesRoot != nil {
+		reported--
+	}
+
+	// owners holds the index of the pin referencing each block, a block
+	// becoming shared is walked again to share its descendants too
+	owners := make(map[string]int)
+	getLinks := localLinks(n)
+	for i, p := range pins {
+		owner := i
+		visit := func(c cid.Cid) bool {
+			k := c.KeyString()
+			o, ok := owners[k]
+			switch {
+			case !ok:
+				owners[k] = owner
+				return true
+			case o == owner || o == sharedOwner:
+				return false
+			default:
+				owners[k] = sharedOwner
+				return true
+			}
+		}
+		if !visit(p.c) || !p.recursive {
+			continue
+		}
+		if err := dag.EnumerateChildren(ctx, getLinks, p.c, visit); err != nil {
+			return nil, err
+		}
+	}
+
+	usages := make([]Usage, reported)
+	for i := range usages {
+		usages[i].Cid = pins[i].c
+	}
+	for k, o := range owners {
+		if o == sharedOwner || o >= reported {
+			continue
+		}
+		c, err := cid.Cast([]byte(k))
+		if err != nil {
+			return nil, err
+		}
+		size, err := n.Blockstore.GetSize(c)
+		if err != nil {
+			continue
+		}
+		usages[o].Size += uint64(size)
+		usages[o].Blocks++
+	}
+
+	h := &usageHeap{max: count}
+	for _, u := range usages {
+		h.add(u)
+	}
+	return h.sorted(), nil
+}
+
+// LargestMFS returns the count largest files and directories of the files
+// API, by the bytes of the distinct local blocks under them. The inner nodes
+// of the sharded directories aren't counted.
+func LargestMFS(ctx context.Context, n *core.IpfsNode, count int) ([]Usage, error) {
+	if n.FilesRoot == nil {
+		return nil, errors.New("the files API root is not loaded")
+	}
+	fr, unlock := n.LockFilesRoot()
+	root, err := fr.GetDirectory().GetNode()
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &mfsUsageWalker{
+		ctx:      ctx,
+		n:        n,
+		dserv:    localDAG(n),
+		getLinks: localLinks(n),
+		sizes:    make(map[string]uint64),
+		h:        &usageHeap{max: count},
+	}
+	if _, err := w.walk(root, "/"); err != nil {
+		return nil, err
+	}
+	return w.h.sorted(), nil
+}
+
+// subtreeBlocks are the distinct blocks of an mfs subtree.
+type subtreeBlocks struct {
+	keys map[string]struct{}
+	size uint64
+}
+
+type mfsUsageWalker struct {
+	ctx      context.Context
+	n        *core.IpfsNode
+	dserv    ipld.DAGService
+	getLinks dag.GetLinks
+	// sizes caches the sizes of the blocks, which may be in several
+	// subtrees
+	sizes map[string]uint64
+	h     *usageHeap
+}
+
+// add adds c to t and returns false if it was already in it.
+func (w *mfsUsageWalker) add(t *subtreeBlocks, c cid.Cid) bool {
+	k := c.KeyString()
+	if _, ok := t.keys[k]; ok {
+		return false
+	}
+	size, ok := w.sizes[k]
+	if !ok {
+		if s, err := w.n.Blockstore.GetSize(c); err == nil {
+			size = uint64(s)
+		}
+		w.sizes[k] = size
+	}
+	t.keys[k] = struct{}{}
+	t.size += size
+	return true
+}
+
+// merge returns the union of a and b, reusing the larger.
+func (w *mfsUsageWalker) merge(a, b *subtreeBlocks) *subtreeBlocks {
+	if len(a.keys) < len(b.keys) {
+		a, b = b, a
+	}
+	for k := range b.keys {
+		if _, ok := a.keys[k]; !ok {
+			a.keys[k] = struct{}{}
+			a.size += w.sizes[k]
+		}
+	}
+	return a
+}
+
+func isUnixfsDir(nd ipld.Node) bool {
+	pbnd, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return false
+	}
+	fsn, err := ft.FromBytes(pbnd.Data())
+	if err != nil {
+		return false
+	}
+	return fsn.GetType() == ft.TDirectory || fsn.GetType() == ft.THAMTShard
+}
+
+func (w *mfsUsageWalker) walk(nd ipld.Node, p string) (*subtreeBlocks, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t := &subtreeBlocks{keys: make(map[string]struct{})}
+	w.add(t, nd.Cid())
+
+	if isUnixfsDir(nd) {
+		dir, err := uio.NewDirectoryFromNode(w.dserv, nd)
+		if err != nil {
+			return nil, err
+		}
+		links, err := dir.Links(w.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range links {
+			child, err := l.GetNode(w.ctx, w.dserv)
+			if err == ipld.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			ct, err := w.walk(child, gopath.Join(p, l.Name))
+			if err != nil {
+				return nil, err
+			}
+			t = w.merge(t, ct)
+		}
+	} else {
+		visit := func(c cid.Cid) bool { return w.add(t, c) }
+		if err := dag.EnumerateChildren(w.ctx, w.getLinks, nd.Cid(), visit); err != nil {
+			return nil, err
+		}
+	}
+
+	if p != "/" {
+		w.h.add(Usage{Cid: nd.Cid(), Path: p, Size: t.size, Blocks: len(t.keys)})
+	}
+	return t, nil
+}
@@ -0,0 +1,67 @@
+package corerepo
+
+import (
+	"context"
+	"testing"
+
+	coremock "github.com/ipfs/go-ipfs/core/mock"
+
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+)
+
+func TestLargestPins(t *testing.T) {
+	ctx := context.Background()
+	n, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared := dag.NodeWithData([]byte("shared by both pins"))
+	own := dag.NodeWithData([]byte("only under the large pin"))
+	large := dag.NodeWithData([]byte("large"))
+	small := dag.NodeWithData([]byte("small"))
+	for _, l := range []struct {
+		parent, child *dag.ProtoNode
+		name          string
+	}{
+		{large, shared, "shared"},
+		{large, own, "own"},
+		{small, shared, "shared"},
+	} {
+		if err := l.parent.AddNodeLink(l.name, l.child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, nd := range []*dag.ProtoNode{shared, own, large, small} {
+		if err := n.DAG.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, nd := range []*dag.ProtoNode{large, small} {
+		if err := n.Pinning.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usages, err := LargestPins(ctx, n, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 || !usages[0].Cid.Equals(large.Cid()) {
+		t.Fatalf("expected the large pin only, got %v", usages)
+	}
+	// the shared block stays with the small pin
+	size := uint64(len(large.RawData()) + len(own.RawData()))
+	if usages[0].Size != size || usages[0].Blocks != 2 {
+		t.Fatalf("expected %d bytes in 2 blocks, got %d bytes in %d blocks",
+			size, usages[0].Size, usages[0].Blocks)
+	}
+
+	blocks, err := LargestBlocks(ctx, n, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Size < blocks[1].Size {
+		t.Fatalf("expected the 2 largest blocks, largest first, got %v", blocks)
+	}
+}
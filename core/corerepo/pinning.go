@@ -16,6 +16,7 @@ package corerepo
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/ipfs/go-ipfs/core"
 	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
@@ -23,11 +24,33 @@ import (
 	resolver "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path/resolver"
 
 	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
 )
 
+// fetchBatchSize is how many blocks of a level of a DAG PinWithProgress
+// fetches at once.
+const fetchBatchSize = 256
+
 func Pin(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool) ([]cid.Cid, error) {
+	return PinWithProgress(n, ctx, paths, recursive, core.FetchPriorityNormal, nil)
+}
+
+// PinWithProgress is Pin, recording the progress of the fetch of the DAGs
+// in p if it isn't nil. The DAGs are fetched a level at a time first, taking
+// turns with the other pins by their priorities, and the roots are provided
+// with prio.
+func PinWithProgress(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool, prio core.FetchPriority, progress *core.PinProgress) ([]cid.Cid, error) {
+	if progress == nil {
+		progress = new(core.PinProgress)
+	}
 	out := make([]cid.Cid, len(paths))
+	// the pins of paths in directories are indexed with their names
+	names := make([]string, len(paths))
 
+	quota, err := fetchQuota(n)
+	if err != nil {
+		return nil, err
+	}
 	r := &resolver.Resolver{
 		DAG:         n.DAG,
 		ResolveOnce: uio.ResolveUnixfsOnce,
@@ -43,11 +66,17 @@ func Pin(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool)
 		if err != nil {
 			return nil, fmt.Errorf("pin: %s", err)
 		}
+		if err := fetchDAG(ctx, n.DAG, n.FetchQueue, prio, dagnode, recursive, progress, quota); err != nil {
+			return nil, fmt.Errorf("pin: %s", err)
+		}
 		err = n.Pinning.Pin(ctx, dagnode, recursive)
 		if err != nil {
 			return nil, fmt.Errorf("pin: %s", err)
 		}
 		out[i] = dagnode.Cid()
+		if segs := p.Segments(); len(segs) > 2 {
+			names[i] = segs[len(segs)-1]
+		}
 	}
 
 	err := n.Pinning.Flush()
@@ -55,9 +84,138 @@ func Pin(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool)
 		return nil, err
 	}
 
+	for i, c := range out {
+		n.ProvidePin(c, prio)
+		if n.Search != nil {
+			n.Search.Add(c, names[i])
+		}
+	}
+
 	return out, nil
 }
 
+// fetchDAG fetches the DAG of nd level by level, or only nd if it isn't
+// recursive, recording the progress in p. The batches of a level are fetched
+// concurrently, each taking its turn in fq with prio.
+func fetchDAG(ctx context.Context, ng ipld.NodeGetter, fq *core.FetchQueue, prio core.FetchPriority, nd ipld.Node, recursive bool, p *core.PinProgress, quota func() error) error {
+	p.Fetched(len(nd.RawData()))
+	if !recursive {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	seen := cid.NewSet()
+	seen.Add(nd.Cid())
+	var level []cid.Cid
+	for _, l := range nd.Links() {
+		if seen.Visit(l.Cid) {
+			level = append(level, l.Cid)
+		}
+	}
+
+	for depth := 1; len(level) > 0; depth++ {
+		p.SetDepth(depth)
+
+		var batches [][]cid.Cid
+		for len(level) > 0 {
+			batch := level
+			if len(batch) > fetchBatchSize {
+				batch = batch[:fetchBatchSize]
+			}
+			level = level[len(batch):]
+			batches = append(batches, batch)
+		}
+
+		next, err := fetchLevel(ctx, ng, fq, prio, batches, seen, p, quota)
+		if err != nil {
+			return err
+		}
+		level = next
+	}
+	return nil
+}
+
+// fetchLevel fetches the batches of a level of a DAG, up to
+// core.FetchSlots at once, and returns the links of their blocks not seen
+// yet.
+func fetchLevel(ctx context.Context, ng ipld.NodeGetter, fq *core.FetchQueue, prio core.FetchPriority, batches [][]cid.Cid, seen *cid.Set, p *core.PinProgress, quota func() error) ([]cid.Cid, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		lk   sync.Mutex
+		next []cid.Cid
+		ferr error
+	)
+	fail := func(err error) {
+		lk.Lock()
+		defer lk.Unlock()
+		if ferr == nil {
+			ferr = err
+			cancel()
+		}
+	}
+
+	todo := make(chan []cid.Cid)
+	for i := 0; i < core.FetchSlots && i < len(batches); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range todo {
+				release, err := fq.Acquire(ctx, prio)
+				if err != nil {
+					fail(err)
+					return
+				}
+				for opt := range ng.GetMany(ctx, batch) {
+					if opt.Err != nil {
+						fail(opt.Err)
+						break
+					}
+					p.Fetched(len(opt.Node.RawData()))
+					lk.Lock()
+					for _, l := range opt.Node.Links() {
+						if seen.Visit(l.Cid) {
+							next = append(next, l.Cid)
+						}
+					}
+					lk.Unlock()
+				}
+				release()
+				if err := ctx.Err(); err != nil {
+					fail(err)
+					return
+				}
+				if quota != nil {
+					if err := quota(); err != nil {
+						fail(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, batch := range batches {
+		select {
+		case todo <- batch:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(todo)
+	wg.Wait()
+
+	if ferr != nil {
+		return nil, ferr
+	}
+	return next, ctx.Err()
+}
+
 func Unpin(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool) ([]cid.Cid, error) {
 	unpinned := make([]cid.Cid, len(paths))
 
@@ -90,3 +248,17 @@ func Unpin(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool
 	}
 	return unpinned, nil
 }
+
+// PinResolved pins p recursively, p being the result of the resolution of a
+// name. p must be an /ipfs path: pinning an /ipns path would resolve the name
+// again, and maybe pin something else than what was resolved.
+func PinResolved(ctx context.Context, n *core.IpfsNode, p path.Path) error {
+	if p.Segments()[0] != "ipfs" {
+		return fmt.Errorf("cannot pin %s, the name must resolve to an /ipfs path (try resolving recursively)", p)
+	}
+
+	defer n.Blockstore.PinLock().Unlock()
+
+	_, err := Pin(n, ctx, []string{p.String()}, true)
+	return err
+}
@@ -0,0 +1,71 @@
+package corerepo
+
+import (
+	"context"
+
+	"github.com/ipfs/go-ipfs/core"
+)
+
+// CheckStorageQuota enforces Datastore.StorageMax before an operation that
+// is about to store offset more bytes, running a GC with the StorageMaxGC
+// policy. It does nothing with the advisory Datastore.StorageMaxPolicy.
+// The blocks written afterwards are checked by the blockstore of the node.
+func CheckStorageQuota(ctx context.Context, n *core.IpfsNode, offset uint64) error {
+	policy, err := core.StorageMaxPolicy(n.Repo)
+	if err != nil {
+		return err
+	}
+	storageMax, err := core.StorageMax(n.Repo)
+	if err != nil || storageMax == 0 {
+		return err
+	}
+
+	exceeded := func() (bool, error) {
+		storage, err := n.Repo.GetStorageUsage()
+		if err != nil {
+			return false, err
+		}
+		return storage+offset > storageMax, nil
+	}
+
+	over, err := exceeded()
+	if err != nil || !over {
+		return err
+	}
+
+	if policy == core.StorageMaxGC {
+		log.Info("StorageMax would be exceeded. Starting repo GC...")
+		if err := GarbageCollect(n, ctx); err != nil {
+			return err
+		}
+
+		over, err = exceeded()
+		if err != nil || !over {
+			return err
+		}
+	}
+
+	return ErrMaxStorageExceeded
+}
+
+// fetchQuota returns the check of Datastore.StorageMax run by the pins after
+// each batch of blocks they fetch, nil when it isn't enforced. The blocks are
+// stored by bitswap, which doesn't check the quota, so the repo is measured
+// again instead, failing the pin once it exceeds StorageMax.
+func fetchQuota(n *core.IpfsNode) (func() error, error) {
+	storageMax, err := core.StorageMax(n.Repo)
+	if err != nil || storageMax == 0 {
+		return nil, err
+	}
+
+	return func() error {
+		storage, err := n.Repo.GetStorageUsage()
+		if err != nil {
+			return err
+		}
+		if storage > storageMax {
+			return ErrMaxStorageExceeded
+		}
+		return nil
+	}, nil
+}
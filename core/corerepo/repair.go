@@ -0,0 +1,233 @@
+package corerepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	exchange "gx/ipfs/QmR1nncPsZR14A4hWr39mq8Lm7BGgS68bHVT9nop8NpWEM/go-ipfs-exchange-interface"
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
+)
+
+// Problems found by Repair.
+const (
+	BlockMissing = "missing"
+	BlockCorrupt = "corrupt"
+)
+
+// RepairSourceNetwork is the RepairResult.Source of blocks fetched from
+// other peers.
+const RepairSourceNetwork = "network"
+
+// maxGatewayBlockSize bounds the blocks downloaded from gateways.
+const maxGatewayBlockSize = 4 << 20
+
+// RepairConfig is read from the Repair config section.
+type RepairConfig struct {
+	// Gateways are HTTP gateways missing blocks are requested from, as raw
+	// blocks, when they can't be fetched from the network.
+	Gateways []string
+}
+
+// RepairResult reports a block of the repaired DAGs that was missing or
+// corrupt.
+type RepairResult struct {
+	Cid     cid.Cid
+	Problem string
+	// Source is where the block was fetched from, RepairSourceNetwork or
+	// the URL of a gateway, if it was repaired.
+	Source string
+	Err    error
+}
+
+// Repairer re-fetches the missing and corrupt blocks of DAGs.
+type Repairer struct {
+	Blockstore bstore.GCBlockstore
+	// Exchange is used first to fetch blocks. It is nil when offline.
+	Exchange exchange.Interface
+	Gateways []string
+	// Timeout bounds the time spent fetching a single block.
+	Timeout time.Duration
+
+	client *http.Client
+}
+
+// NewRepairer returns a Repairer for the blocks of n, fetching them from the
+// network if n is online and from the gateways configured in Repair.Gateways.
+func NewRepairer(n *core.IpfsNode, timeout time.Duration) (*Repairer, error) {
+	var cfg RepairConfig
+	if err := repo.ConfigSection(n.Repo, "Repair", &cfg); err != nil {
+		return nil, err
+	}
+
+	r := &Repairer{
+		Blockstore: n.Blockstore,
+		Gateways:   cfg.Gateways,
+		Timeout:    timeout,
+		client:     http.DefaultClient,
+	}
+	if n.OnlineMode() {
+		r.Exchange = n.Exchange
+	}
+	return r, nil
+}
+
+// Repair checks the blocks of the DAGs under roots, or only the roots
+// themselves if recursive is false, and fetches again the ones that are
+// missing or corrupt. report is called for each of those. It returns the
+// number of blocks checked.
+//
+// The children of a block that can't be repaired can't be known, so they
+// aren't checked.
+func (r *Repairer) Repair(ctx context.Context, roots []cid.Cid, recursive bool, report func(RepairResult) error) (int, error) {
+	// keep gc from removing the fetched blocks before the walk is done
+	defer r.Blockstore.PinLock().Unlock()
+
+	seen := cid.NewSet()
+	stack := append([]cid.Cid(nil), roots...)
+	var checked int
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !seen.Visit(c) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return checked, err
+		}
+		checked++
+
+		blk, problem, err := r.check(c)
+		if err != nil {
+			return checked, err
+		}
+		if problem != "" {
+			res := RepairResult{Cid: c, Problem: problem}
+			blk, res.Source, res.Err = r.fetch(ctx, c, problem)
+			if err := report(res); err != nil {
+				return checked, err
+			}
+			if res.Err != nil {
+				continue
+			}
+		}
+
+		if !recursive {
+			continue
+		}
+		nd, err := ipld.Decode(blk)
+		if err != nil {
+			log.Warningf("repair: cannot decode block %s, not checking its children: %s", c, err)
+			continue
+		}
+		for _, l := range nd.Links() {
+			stack = append(stack, l.Cid)
+		}
+	}
+	return checked, nil
+}
+
+// check returns the block c if it is intact, or the problem with it.
+func (r *Repairer) check(c cid.Cid) (blocks.Block, string, error) {
+	blk, err := r.Blockstore.Get(c)
+	switch err {
+	case nil:
+	case bstore.ErrNotFound:
+		return nil, BlockMissing, nil
+	case bstore.ErrHashMismatch:
+		return nil, BlockCorrupt, nil
+	default:
+		return nil, "", err
+	}
+
+	chk, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return nil, "", err
+	}
+	if !chk.Equals(c) {
+		return nil, BlockCorrupt, nil
+	}
+	return blk, "", nil
+}
+
+func (r *Repairer) fetch(ctx context.Context, c cid.Cid, problem string) (blocks.Block, string, error) {
+	if problem == BlockCorrupt {
+		if err := r.Blockstore.DeleteBlock(c); err != nil {
+			return nil, "", fmt.Errorf("cannot remove corrupt block: %s", err)
+		}
+	}
+
+	var errs []string
+	if r.Exchange != nil {
+		fctx, cancel := context.WithTimeout(ctx, r.Timeout)
+		blk, err := r.Exchange.GetBlock(fctx, c)
+		cancel()
+		if err == nil {
+			return blk, RepairSourceNetwork, r.Blockstore.Put(blk)
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", RepairSourceNetwork, err))
+	}
+
+	for _, gw := range r.Gateways {
+		blk, err := r.fetchFromGateway(ctx, gw, c)
+		if err == nil {
+			return blk, gw, r.Blockstore.Put(blk)
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", gw, err))
+	}
+
+	if len(errs) == 0 {
+		return nil, "", errors.New("the node is offline and no gateways are configured")
+	}
+	return nil, "", errors.New(strings.Join(errs, ", "))
+}
+
+func (r *Repairer) fetchFromGateway(ctx context.Context, gw string, c cid.Cid) (blocks.Block, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	u := strings.TrimRight(gw, "/") + "/ipfs/" + c.String() + "?format=raw"
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.raw")
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxGatewayBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxGatewayBlockSize {
+		return nil, errors.New("block too large")
+	}
+
+	// gateways aren't trusted, the block must match its CID
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !chk.Equals(c) {
+		return nil, errors.New("the gateway sent a block not matching the CID")
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
@@ -24,6 +24,8 @@ type Stat struct {
 	NumObjects uint64
 	RepoPath   string
 	Version    string
+	// Compression is only set when requested, it reads all the blocks.
+	Compression *CompressionStat `json:",omitempty"`
 }
 
 // NoLimit represents the value for unlimited storage
@@ -12,10 +12,9 @@ import (
 
 	core "github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/pin"
+	repo "github.com/ipfs/go-ipfs/repo"
 	unixfs "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
-	balanced "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/balanced"
 	ihelper "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/helpers"
-	trickle "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/trickle"
 	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
 
 	posinfo "gx/ipfs/QmPG32VXR5jmpo9q8R9FNdR4Ae97Ky9CiZE6SctJLUB79H/go-ipfs-posinfo"
@@ -24,7 +23,6 @@ import (
 	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
 	files "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit/files"
 	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
-	chunker "gx/ipfs/QmdSeG9s4EQ9TGruJJS9Us38TQDZtMmFGwzTYUDVqNTURm/go-ipfs-chunker"
 	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
 )
 
@@ -47,10 +45,11 @@ type Object struct {
 }
 
 type AddedObject struct {
-	Name  string
-	Hash  string `json:",omitempty"`
-	Bytes int64  `json:",omitempty"`
-	Size  string `json:",omitempty"`
+	Name    string
+	Hash    string `json:",omitempty"`
+	Bytes   int64  `json:",omitempty"`
+	Size    string `json:",omitempty"`
+	Profile string `json:",omitempty"`
 }
 
 // NewAdder Returns a new Adder used for a file add operation.
@@ -80,6 +79,7 @@ type Adder struct {
 	Hidden     bool
 	Pin        bool
 	Trickle    bool
+	Layout     string
 	RawLeaves  bool
 	Silent     bool
 	Wrap       bool
@@ -92,6 +92,35 @@ type Adder struct {
 	tempRoot   cid.Cid
 	CidBuilder cid.Builder
 	liveNodes  uint64
+
+	// Manifest overrides the layout and the chunker of the added files
+	// matching its rules.
+	Manifest *Manifest
+
+	// MaxLinks is the largest number of links of the file nodes, or
+	// ihelper.DefaultLinksPerBlock if zero.
+	MaxLinks int
+	// Profile is the name of the profile setting the parameters of the add,
+	// reported with the added objects.
+	Profile string
+
+	// PreserveMode and PreserveMtime store the permissions and the
+	// modification time of the added files in their unixfs nodes, when the
+	// input provides them.
+	PreserveMode  bool
+	PreserveMtime bool
+
+	// Sharding converts the added directories to sharded directories when
+	// they get too large.
+	Sharding *core.ShardingPolicy
+
+	// DetectMime wraps the added files in unixfs metadata nodes recording
+	// their MIME type, detected from their extension or their content.
+	DetectMime bool
+
+	// PinLocked is set when the caller holds the pin lock for the whole add,
+	// the adder doesn't take it, nor pauses for GC, then.
+	PinLocked bool
 }
 
 func (adder *Adder) mfsRoot() (*mfs.Root, error) {
@@ -115,7 +144,28 @@ func (adder *Adder) SetMfsRoot(r *mfs.Root) {
 
 // Constructs a node from reader's data, and adds it. Doesn't pin.
 func (adder *Adder) add(reader io.Reader) (ipld.Node, error) {
-	chnk, err := chunker.FromString(reader, adder.Chunker)
+	return adder.addWithStrategy(reader, adder.defaultStrategy())
+}
+
+// defaultStrategy returns the strategy of the files no manifest rule matches.
+func (adder *Adder) defaultStrategy() Strategy {
+	s := Strategy{Layout: adder.Layout, Chunker: adder.Chunker}
+	if s.Layout == "" {
+		s.Layout = LayoutBalanced
+		if adder.Trickle {
+			s.Layout = LayoutTrickle
+		}
+	}
+	return s
+}
+
+func (adder *Adder) addWithStrategy(reader io.Reader, s Strategy) (ipld.Node, error) {
+	layout, ok := layouts[s.Layout]
+	if !ok {
+		return nil, ValidateLayout(s.Layout)
+	}
+
+	chnk, err := NewSplitter(reader, s.Chunker)
 	if err != nil {
 		return nil, err
 	}
@@ -123,16 +173,19 @@ func (adder *Adder) add(reader io.Reader) (ipld.Node, error) {
 	params := ihelper.DagBuilderParams{
 		Dagserv:    adder.dagService,
 		RawLeaves:  adder.RawLeaves,
-		Maxlinks:   ihelper.DefaultLinksPerBlock,
+		Maxlinks:   adder.maxLinks(),
 		NoCopy:     adder.NoCopy,
 		CidBuilder: adder.CidBuilder,
 	}
 
-	if adder.Trickle {
-		return trickle.Layout(params.New(chnk))
-	}
+	return layout(params.New(chnk))
+}
 
-	return balanced.Layout(params.New(chnk))
+func (adder *Adder) maxLinks() int {
+	if adder.MaxLinks > 0 {
+		return adder.MaxLinks
+	}
+	return ihelper.DefaultLinksPerBlock
 }
 
 // RootNode returns the root node of the Added.
@@ -210,6 +263,15 @@ func (adder *Adder) Finalize() (ipld.Node, error) {
 		return nil, err
 	}
 
+	if adder.Wrap {
+		mr, err = adder.reshardRoot(mr)
+		if err != nil {
+			return nil, err
+		}
+		rootdir = mr.GetDirectory()
+		root = rootdir
+	}
+
 	var name string
 	if !adder.Wrap {
 		children, err := rootdir.ListNames(adder.ctx)
@@ -223,7 +285,7 @@ func (adder *Adder) Finalize() (ipld.Node, error) {
 
 		// Replace root with the first child
 		name = children[0]
-		root, err = rootdir.Child(name)
+		root, err = adder.child(rootdir, name)
 		if err != nil {
 			return nil, err
 		}
@@ -242,9 +304,36 @@ func (adder *Adder) Finalize() (ipld.Node, error) {
 	return root.GetNode()
 }
 
+// reshardRoot reshards the wrapping directory, which can't be replaced in
+// its parent like the other directories, and returns the new mfs root.
+func (adder *Adder) reshardRoot(mr *mfs.Root) (*mfs.Root, error) {
+	nd, err := mr.GetDirectory().GetNode()
+	if err != nil {
+		return nil, err
+	}
+	out, err := adder.Sharding.Reshard(adder.ctx, adder.dagService, nd)
+	if err != nil || out.Cid().Equals(nd.Cid()) {
+		return mr, err
+	}
+
+	pbnd, ok := out.(*dag.ProtoNode)
+	if !ok {
+		return nil, dag.ErrNotProtobuf
+	}
+	if err := mr.Close(); err != nil {
+		return nil, err
+	}
+	nmr, err := mfs.NewRoot(adder.ctx, adder.dagService, pbnd, nil)
+	if err != nil {
+		return nil, err
+	}
+	adder.mroot = nmr
+	return nmr, nil
+}
+
 func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
 	switch fsn := fsn.(type) {
-	case *mfs.File:
+	case *mfs.File, metadataFile:
 		return nil
 	case *mfs.Directory:
 		names, err := fsn.ListNames(adder.ctx)
@@ -253,7 +342,7 @@ func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
 		}
 
 		for _, name := range names {
-			child, err := fsn.Child(name)
+			child, err := adder.child(fsn, name)
 			if err != nil {
 				return err
 			}
@@ -271,12 +360,25 @@ func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
 			return err
 		}
 
-		return outputDagnode(adder.Out, path, nd)
+		return adder.outputDagnode(path, nd)
 	default:
 		return fmt.Errorf("unrecognized fsn type: %#v", fsn)
 	}
 }
 
+// useImportConfig sets the chunker, the CID builder and the raw leaves of the
+// Import config of r on the adder.
+func (adder *Adder) useImportConfig(r repo.Repo) error {
+	d, err := ConfiguredImportDefaults(r)
+	if err != nil {
+		return err
+	}
+	adder.Chunker = d.Chunker
+	adder.CidBuilder = d.CidBuilder
+	adder.RawLeaves = d.RawLeaves
+	return nil
+}
+
 // Add builds a merkledag node from a reader, adds it to the blockstore,
 // and returns the key representing that node.
 // If you want to pin it, use NewAdder() and Adder.PinRoot().
@@ -292,6 +394,9 @@ func AddWithContext(ctx context.Context, n *core.IpfsNode, r io.Reader) (string,
 	if err != nil {
 		return "", err
 	}
+	if err := fileAdder.useImportConfig(n.Repo); err != nil {
+		return "", err
+	}
 
 	node, err := fileAdder.add(r)
 	if err != nil {
@@ -320,6 +425,10 @@ func AddR(n *core.IpfsNode, root string) (key string, err error) {
 	if err != nil {
 		return "", err
 	}
+	if err := fileAdder.useImportConfig(n.Repo); err != nil {
+		return "", err
+	}
+	fileAdder.Sharding = n.Sharding
 
 	err = fileAdder.addFile(f)
 	if err != nil {
@@ -344,6 +453,9 @@ func AddWrapped(n *core.IpfsNode, r io.Reader, filename string) (string, ipld.No
 	if err != nil {
 		return "", nil, err
 	}
+	if err := fileAdder.useImportConfig(n.Repo); err != nil {
+		return "", nil, err
+	}
 	fileAdder.Wrap = true
 
 	defer n.Blockstore.PinLock().Unlock()
@@ -393,14 +505,14 @@ func (adder *Adder) addNode(node ipld.Node, path string) error {
 	}
 
 	if !adder.Silent {
-		return outputDagnode(adder.Out, path, node)
+		return adder.outputDagnode(path, node)
 	}
 	return nil
 }
 
 // AddFile adds the given file while respecting the adder.
 func (adder *Adder) AddFile(file files.File) error {
-	if adder.Pin {
+	if adder.Pin && !adder.PinLocked {
 		adder.unlocker = adder.blockstore.PinLock()
 	}
 	defer func() {
@@ -466,10 +578,25 @@ func (adder *Adder) addFile(file files.File) error {
 		}
 	}
 
-	dagnode, err := adder.add(reader)
+	var mimeType string
+	if adder.DetectMime {
+		reader, mimeType, err = detectMimeType(reader, file.FileName())
+		if err != nil {
+			return err
+		}
+	}
+
+	strategy := adder.Manifest.Strategy(file.FileName(), adder.defaultStrategy())
+	dagnode, err := adder.addWithStrategy(reader, strategy)
 	if err != nil {
 		return err
 	}
+	if mimeType != "" {
+		dagnode, err = adder.wrapMetadata(dagnode, mimeType)
+		if err != nil {
+			return err
+		}
+	}
 
 	addFileName := file.FileName()
 	addFileInfo, ok := file.(files.FileInfo)
@@ -479,6 +606,13 @@ func (adder *Adder) addFile(file files.File) error {
 			adder.Name = ""
 		}
 	}
+
+	if m, ok := adder.fileMeta(file); ok {
+		dagnode, err = adder.withFileMeta(dagnode, m)
+		if err != nil {
+			return err
+		}
+	}
 	// patch it into the root
 	return adder.addNode(dagnode, addFileName)
 }
@@ -519,7 +653,35 @@ func (adder *Adder) addDir(dir files.File) error {
 		}
 	}
 
-	return nil
+	_, err = adder.Sharding.ReshardMfs(adder.ctx, mr, adder.dagService, gopath.Join("/", dir.FileName()))
+	return err
+}
+
+// fileMeta returns the metadata to preserve for file, if any.
+func (adder *Adder) fileMeta(file files.File) (FileMeta, bool) {
+	if !(adder.PreserveMode || adder.PreserveMtime) {
+		return FileMeta{}, false
+	}
+
+	fi, ok := file.(files.FileInfo)
+	if !ok || fi.Stat() == nil {
+		return FileMeta{}, false
+	}
+
+	m := FileMetaFromFileInfo(fi.Stat(), adder.PreserveMode, adder.PreserveMtime)
+	return m, !m.IsZero()
+}
+
+// withFileMeta returns the file node nd storing m.
+func (adder *Adder) withFileMeta(nd ipld.Node, m FileMeta) (ipld.Node, error) {
+	out, err := FileNodeWithMeta(nd, m, adder.CidBuilder)
+	if err != nil {
+		return nil, err
+	}
+	if err := adder.dagService.Add(adder.ctx, out); err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
 func (adder *Adder) maybePauseForGC() error {
@@ -536,8 +698,8 @@ func (adder *Adder) maybePauseForGC() error {
 }
 
 // outputDagnode sends dagnode info over the output channel
-func outputDagnode(out chan interface{}, name string, dn ipld.Node) error {
-	if out == nil {
+func (adder *Adder) outputDagnode(name string, dn ipld.Node) error {
+	if adder.Out == nil {
 		return nil
 	}
 
@@ -546,10 +708,11 @@ func outputDagnode(out chan interface{}, name string, dn ipld.Node) error {
 		return err
 	}
 
-	out <- &AddedObject{
-		Hash: o.Hash,
-		Name: name,
-		Size: o.Size,
+	adder.Out <- &AddedObject{
+		Hash:    o.Hash,
+		Name:    name,
+		Size:    o.Size,
+		Profile: adder.Profile,
 	}
 
 	return nil
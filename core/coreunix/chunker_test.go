@@ -0,0 +1,170 @@
+package coreunix
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	chunker "gx/ipfs/QmdSeG9s4EQ9TGruJJS9Us38TQDZtMmFGwzTYUDVqNTURm/go-ipfs-chunker"
+)
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func splitAll(t testing.TB, s chunker.Splitter) [][]byte {
+	var chunks [][]byte
+	for {
+		chunk, err := s.NextBytes()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestBuzhashChunks(t *testing.T) {
+	data := randomData(8<<20, 1)
+	min, avg, max := 64<<10, 64<<10, 256<<10
+
+	chunks := splitAll(t, NewBuzhash(bytes.NewReader(data), min, avg, max))
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+
+	for i, chunk := range chunks {
+		if len(chunk) > max {
+			t.Fatalf("chunk %d is larger than the max: %d", i, len(chunk))
+		}
+		if len(chunk) < min && i != len(chunks)-1 {
+			t.Fatalf("chunk %d is smaller than the min: %d", i, len(chunk))
+		}
+	}
+
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks don't add up to the data")
+	}
+}
+
+func TestBuzhashSmallInput(t *testing.T) {
+	for _, size := range []int{0, 1, buzhashWindow, DefaultBuzhashMin} {
+		data := randomData(size, 2)
+		chunks := splitAll(t, NewBuzhash(bytes.NewReader(data), DefaultBuzhashMin, DefaultBuzhashAvg, DefaultBuzhashMax))
+		if !bytes.Equal(bytes.Join(chunks, nil), data) {
+			t.Fatalf("chunks of %d bytes don't add up to the data", size)
+		}
+		if size > 0 && len(chunks) != 1 {
+			t.Fatalf("expected a single chunk for %d bytes, got %d", size, len(chunks))
+		}
+	}
+}
+
+// TestBuzhashGoIpfsChunker checks that the default buzhash chunks are those of
+// the buzhash chunker of go-ipfs-chunker.
+func TestBuzhashGoIpfsChunker(t *testing.T) {
+	data := randomData(2<<20, 6)
+	expected := []int{192056, 135892, 224595, 346652, 308252, 178517, 242326, 281943, 138374, 48545}
+
+	chunks := splitAll(t, NewBuzhash(bytes.NewReader(data), DefaultBuzhashMin, DefaultBuzhashAvg, DefaultBuzhashMax))
+	if len(chunks) != len(expected) {
+		t.Fatalf("expected %d chunks, got %d", len(expected), len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) != expected[i] {
+			t.Fatalf("chunk %d: expected %d bytes, got %d", i, expected[i], len(chunk))
+		}
+	}
+}
+
+func TestBuzhashShiftResistance(t *testing.T) {
+	data := randomData(4<<20, 3)
+	shifted := append(randomData(1000, 4), data...)
+
+	chunks := splitAll(t, NewBuzhash(bytes.NewReader(data), 16<<10, 16<<10, 64<<10))
+	shiftedChunks := splitAll(t, NewBuzhash(bytes.NewReader(shifted), 16<<10, 16<<10, 64<<10))
+
+	known := make(map[string]bool)
+	for _, chunk := range chunks {
+		known[string(chunk)] = true
+	}
+	var shared int
+	for _, chunk := range shiftedChunks {
+		if known[string(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(chunks)*9/10 {
+		t.Fatalf("only %d of %d chunks are shared after inserting data", shared, len(chunks))
+	}
+}
+
+func TestNewSplitter(t *testing.T) {
+	valid := []string{
+		"",
+		"size-1024",
+		"rabin",
+		"rabin-64-128-256",
+		"buzhash",
+		"buzhash-32-1024-4096",
+	}
+	for _, spec := range valid {
+		if err := ValidateChunker(spec); err != nil {
+			t.Errorf("%q: %s", spec, err)
+		}
+	}
+
+	invalid := []string{
+		"foo",
+		"buzhash-",
+		"buzhash-1024",
+		"buzhash-16-1024-4096",
+		"buzhash-1024-1000-4096",
+		"buzhash-4096-4096-1024",
+		"buzhash-1024-4096-2097152",
+		"buzhash-a-4096-8192",
+	}
+	for _, spec := range invalid {
+		if err := ValidateChunker(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+
+	s, err := NewSplitter(bytes.NewReader(nil), "buzhash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*Buzhash); !ok {
+		t.Fatalf("expected a buzhash splitter, got %T", s)
+	}
+}
+
+func benchmarkSplitter(b *testing.B, spec string) {
+	data := randomData(16<<20, 5)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s, err := NewSplitter(bytes.NewReader(data), spec)
+		if err != nil {
+			b.Fatal(err)
+		}
+		splitAll(b, s)
+	}
+}
+
+func BenchmarkBuzhash(b *testing.B) {
+	benchmarkSplitter(b, "buzhash")
+}
+
+func BenchmarkRabin(b *testing.B) {
+	benchmarkSplitter(b, "rabin")
+}
+
+func BenchmarkSizeSplitter(b *testing.B) {
+	benchmarkSplitter(b, DefaultChunker)
+}
@@ -0,0 +1,195 @@
+package coreunix
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+
+	posinfo "gx/ipfs/QmPG32VXR5jmpo9q8R9FNdR4Ae97Ky9CiZE6SctJLUB79H/go-ipfs-posinfo"
+	unixfs "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// The fields of the unixfs Data message holding the metadata, and the one of
+// the UnixTime message holding the seconds, as in the version 1.5 of the
+// unixfs format.
+const (
+	unixfsModeField    = 7
+	unixfsMtimeField   = 8
+	unixTimeSecsField  = 1
+	protoVarint        = 0
+	protoLengthDelimed = 2
+)
+
+var errBadUnixfsData = errors.New("malformed unixfs data")
+
+// FileMeta holds the POSIX metadata of a file or directory, stored in its
+// unixfs node.
+type FileMeta struct {
+	// Mode holds the permission bits, zero when unknown.
+	Mode os.FileMode
+	// Mtime is the modification time in seconds since the epoch, zero when
+	// unknown.
+	Mtime int64
+}
+
+// IsZero returns true if no metadata is known.
+func (m FileMeta) IsZero() bool {
+	return m.Mode == 0 && m.Mtime == 0
+}
+
+// FileMetaFromFileInfo extracts the metadata to preserve from fi.
+func FileMetaFromFileInfo(fi os.FileInfo, mode, mtime bool) FileMeta {
+	var m FileMeta
+	if mode {
+		m.Mode = fi.Mode().Perm()
+	}
+	if mtime {
+		m.Mtime = fi.ModTime().Unix()
+	}
+	return m
+}
+
+// protoField is a field of a protobuf message.
+type protoField struct {
+	num, typ uint64
+	// raw is the whole field, value its varint or its length-delimited
+	// content.
+	raw, value []byte
+}
+
+// protoFields splits the protobuf message b into its fields.
+func protoFields(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errBadUnixfsData
+		}
+		f := protoField{num: key >> 3, typ: key & 7}
+
+		var l int
+		switch f.typ {
+		case protoVarint:
+			_, m := binary.Uvarint(b[n:])
+			if m <= 0 {
+				return nil, errBadUnixfsData
+			}
+			f.value, l = b[n:n+m], m
+		case 1: // 64-bit
+			l = 8
+		case protoLengthDelimed:
+			size, m := binary.Uvarint(b[n:])
+			if m <= 0 || size > uint64(len(b)-n-m) {
+				return nil, errBadUnixfsData
+			}
+			f.value, l = b[n+m:n+m+int(size)], m+int(size)
+		case 5: // 32-bit
+			l = 4
+		default:
+			return nil, errBadUnixfsData
+		}
+		if l > len(b)-n {
+			return nil, errBadUnixfsData
+		}
+
+		f.raw, b = b[:n+l], b[n+l:]
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func appendVarintField(b []byte, num, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	b = append(b, buf[:binary.PutUvarint(buf[:], num<<3|protoVarint)]...)
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// FileMetaFromNode returns the metadata stored in the unixfs node nd. The raw
+// nodes have none.
+func FileMetaFromNode(nd ipld.Node) (FileMeta, error) {
+	var m FileMeta
+	switch n := nd.(type) {
+	case *posinfo.FilestoreNode:
+		return FileMetaFromNode(n.Node)
+	case *dag.ProtoNode:
+		fields, err := protoFields(n.Data())
+		if err != nil {
+			return m, err
+		}
+		for _, f := range fields {
+			switch {
+			case f.num == unixfsModeField && f.typ == protoVarint:
+				mode, _ := binary.Uvarint(f.value)
+				m.Mode = os.FileMode(mode).Perm()
+			case f.num == unixfsMtimeField && f.typ == protoLengthDelimed:
+				tfields, err := protoFields(f.value)
+				if err != nil {
+					return m, err
+				}
+				for _, tf := range tfields {
+					if tf.num == unixTimeSecsField && tf.typ == protoVarint {
+						secs, _ := binary.Uvarint(tf.value)
+						m.Mtime = int64(secs)
+					}
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// WithFileMeta returns a copy of the unixfs node nd storing m, replacing the
+// metadata it stored.
+func WithFileMeta(nd *dag.ProtoNode, m FileMeta) (*dag.ProtoNode, error) {
+	fields, err := protoFields(nd.Data())
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, f := range fields {
+		if f.num != unixfsModeField && f.num != unixfsMtimeField {
+			data = append(data, f.raw...)
+		}
+	}
+	if m.Mode != 0 {
+		data = appendVarintField(data, unixfsModeField, uint64(m.Mode.Perm()))
+	}
+	if m.Mtime != 0 {
+		mtime := appendVarintField(nil, unixTimeSecsField, uint64(m.Mtime))
+		var buf [binary.MaxVarintLen64]byte
+		data = append(data, buf[:binary.PutUvarint(buf[:], unixfsMtimeField<<3|protoLengthDelimed)]...)
+		data = append(data, buf[:binary.PutUvarint(buf[:], uint64(len(mtime)))]...)
+		data = append(data, mtime...)
+	}
+
+	out := nd.Copy().(*dag.ProtoNode)
+	out.SetData(data)
+	return out, nil
+}
+
+// FileNodeWithMeta returns the unixfs node nd storing m. A raw node can't
+// store it, so it is linked from a unixfs file node built with builder.
+func FileNodeWithMeta(nd ipld.Node, m FileMeta, builder cid.Builder) (*dag.ProtoNode, error) {
+	if fn, ok := nd.(*posinfo.FilestoreNode); ok {
+		nd = fn.Node
+	}
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		fsn := unixfs.NewFSNode(unixfs.TFile)
+		fsn.AddBlockSize(uint64(len(nd.RawData())))
+		data, err := fsn.GetBytes()
+		if err != nil {
+			return nil, err
+		}
+		pn = dag.NodeWithData(data)
+		pn.SetCidBuilder(builder)
+		if err := pn.AddNodeLink("", nd); err != nil {
+			return nil, err
+		}
+	}
+	return WithFileMeta(pn, m)
+}
@@ -0,0 +1,84 @@
+package coreunix
+
+import (
+	"bytes"
+	"testing"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	merkledag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+)
+
+func TestFileMetaInNode(t *testing.T) {
+	nd := merkledag.NodeWithData(ft.FilePBData([]byte("hello"), 5))
+	m := FileMeta{Mode: 0640, Mtime: 1546300800}
+
+	out, err := WithFileMeta(nd, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Cid().Equals(nd.Cid()) {
+		t.Fatal("expected the metadata to change the CID")
+	}
+	if got, err := FileMetaFromNode(out); err != nil || got != m {
+		t.Fatalf("expected %+v, got %+v (%v)", m, got, err)
+	}
+
+	// the node is still a valid unixfs file
+	fsn, err := ft.FromBytes(out.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsn.GetType() != ft.TFile || string(fsn.GetData()) != "hello" {
+		t.Fatalf("unexpected unixfs data %+v", fsn)
+	}
+
+	// the fields of the version 1.5 of the unixfs format
+	spec := []byte{0x38, 0xa0, 0x03, 0x42, 0x06, 0x08, 0x80, 0xdb, 0xaa, 0xe1, 0x05}
+	if !bytes.HasSuffix(out.Data(), spec) {
+		t.Fatalf("unexpected encoding %x", out.Data())
+	}
+
+	// the metadata is replaced, not appended
+	out, err = WithFileMeta(out, FileMeta{Mode: 0600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := FileMetaFromNode(out); got != (FileMeta{Mode: 0600}) {
+		t.Fatalf("expected the mode only, got %+v", got)
+	}
+	out, err = WithFileMeta(out, FileMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Data(), nd.Data()) {
+		t.Fatal("expected removing the metadata to give the original node")
+	}
+
+	raw := merkledag.NewRawNode([]byte("hello"))
+	if got, err := FileMetaFromNode(raw); err != nil || !got.IsZero() {
+		t.Fatalf("expected no metadata in a raw node, got %+v (%v)", got, err)
+	}
+}
+
+func TestFileNodeWithMetaWrapsRawNode(t *testing.T) {
+	raw := merkledag.NewRawNode([]byte("hello"))
+	m := FileMeta{Mtime: 1546300800}
+
+	out, err := FileNodeWithMeta(raw, m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := FileMetaFromNode(out); err != nil || got != m {
+		t.Fatalf("expected %+v, got %+v (%v)", m, got, err)
+	}
+	if len(out.Links()) != 1 || !out.Links()[0].Cid.Equals(raw.Cid()) {
+		t.Fatal("expected the raw node to be linked")
+	}
+	fsn, err := ft.FromBytes(out.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsn.GetType() != ft.TFile || fsn.GetFilesize() != 5 {
+		t.Fatalf("unexpected unixfs data %+v", fsn)
+	}
+}
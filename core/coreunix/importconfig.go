@@ -0,0 +1,167 @@
+package coreunix
+
+import (
+	"fmt"
+	"strings"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	mh "gx/ipfs/QmPnFwZ2JXKnXgMw8CdBPxn7FWh6LLdjUjxV1fKHuJnkr8/go-multihash"
+	verifcid "gx/ipfs/QmVkMRSkXrpjqrroEXWuYBvDBnXCdMMY6gsKicBGVGUqKT/go-verifcid"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+)
+
+// DefaultHashFunction is the hash function new content is hashed with when
+// neither the command nor the Import.HashFunction config sets one.
+const DefaultHashFunction = "sha2-256"
+
+// ImportConfig is read from the Import config section.
+type ImportConfig struct {
+	// HashFunction is the default hash function of 'ipfs add', 'ipfs block
+	// put' and 'ipfs dag put'.
+	HashFunction string
+	// Chunker is the default chunker of 'ipfs add'.
+	Chunker string
+	// CidVersion is the default CID version of the unixfs DAGs, CIDv0 if
+	// unset.
+	CidVersion *int
+	// RawLeaves is whether the leaves of the unixfs DAGs are raw blocks by
+	// default. If unset, they are with CIDv1.
+	RawLeaves *bool
+}
+
+func importConfig(r repo.Repo) (ImportConfig, error) {
+	var cfg ImportConfig
+	err := repo.ConfigSection(r, "Import", &cfg)
+	return cfg, err
+}
+
+// ConfiguredHashFunction returns the hash function set in the Import config of
+// r, or DefaultHashFunction.
+func ConfiguredHashFunction(r repo.Repo) (string, error) {
+	cfg, err := importConfig(r)
+	if err != nil {
+		return "", err
+	}
+	if cfg.HashFunction == "" {
+		return DefaultHashFunction, nil
+	}
+	if _, err := HashFunctionCode(cfg.HashFunction); err != nil {
+		return "", fmt.Errorf("invalid Import.HashFunction: %s", err)
+	}
+	return strings.ToLower(cfg.HashFunction), nil
+}
+
+// ConfiguredChunker returns the chunker set in the Import config of r, or
+// DefaultChunker.
+func ConfiguredChunker(r repo.Repo) (string, error) {
+	cfg, err := importConfig(r)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Chunker == "" {
+		return DefaultChunker, nil
+	}
+	if err := ValidateChunker(cfg.Chunker); err != nil {
+		return "", fmt.Errorf("invalid Import.Chunker: %s", err)
+	}
+	return cfg.Chunker, nil
+}
+
+// ConfiguredCidVersion returns the CID version set in the Import config of r,
+// and whether it is set.
+func ConfiguredCidVersion(r repo.Repo) (int, bool, error) {
+	cfg, err := importConfig(r)
+	if err != nil || cfg.CidVersion == nil {
+		return 0, false, err
+	}
+	if v := *cfg.CidVersion; v != 0 && v != 1 {
+		return 0, false, fmt.Errorf("invalid Import.CidVersion: %d, expected 0 or 1", v)
+	}
+	return *cfg.CidVersion, true, nil
+}
+
+// ConfiguredRawLeaves returns whether the leaves are raw blocks as set in the
+// Import config of r, and whether it is set.
+func ConfiguredRawLeaves(r repo.Repo) (bool, bool, error) {
+	cfg, err := importConfig(r)
+	if err != nil || cfg.RawLeaves == nil {
+		return false, false, err
+	}
+	return *cfg.RawLeaves, true, nil
+}
+
+// ImportDefaults are the settings of the unixfs DAGs of the Import config, for
+// the operations without options of their own.
+type ImportDefaults struct {
+	// CidBuilder is nil if the config keeps CIDv0 and sha2-256.
+	CidBuilder cid.Builder
+	// RawLeaves is only meaningful if RawLeavesSet, when the config sets it
+	// or implies it with CIDv1.
+	RawLeaves    bool
+	RawLeavesSet bool
+	Chunker      string
+}
+
+// ConfiguredImportDefaults returns the ImportDefaults of the Import config of
+// r. Hash functions other than sha2-256 imply CIDv1.
+func ConfiguredImportDefaults(r repo.Repo) (ImportDefaults, error) {
+	var d ImportDefaults
+	hashFunStr, err := ConfiguredHashFunction(r)
+	if err != nil {
+		return d, err
+	}
+	cidVer, _, err := ConfiguredCidVersion(r)
+	if err != nil {
+		return d, err
+	}
+	if d.RawLeaves, d.RawLeavesSet, err = ConfiguredRawLeaves(r); err != nil {
+		return d, err
+	}
+	if d.Chunker, err = ConfiguredChunker(r); err != nil {
+		return d, err
+	}
+
+	if hashFunStr != DefaultHashFunction {
+		cidVer = 1
+	}
+	if cidVer == 0 {
+		return d, nil
+	}
+	if !d.RawLeavesSet {
+		d.RawLeaves, d.RawLeavesSet = true, true
+	}
+
+	prefix, err := dag.PrefixForCidVersion(cidVer)
+	if err != nil {
+		return d, err
+	}
+	if prefix.MhType, err = HashFunctionCode(hashFunStr); err != nil {
+		return d, err
+	}
+	prefix.MhLength = -1
+	d.CidBuilder = &prefix
+	return d, nil
+}
+
+// HashFunctionCode returns the multihash code of the hash function name. It
+// rejects the functions this node can't compute, and those it refuses when
+// verifying blocks because they are insecure or too short, since content
+// hashed with them couldn't be fetched.
+func HashFunctionCode(name string) (uint64, error) {
+	name = strings.ToLower(name)
+	code, ok := mh.Names[name]
+	if !ok {
+		return 0, fmt.Errorf("unrecognized hash function: %s", name)
+	}
+
+	c, err := cid.NewPrefixV1(cid.Raw, code).Sum(nil)
+	if err != nil {
+		return 0, fmt.Errorf("unsupported hash function %s: %s", name, err)
+	}
+	if err := verifcid.ValidateCid(c); err != nil {
+		return 0, fmt.Errorf("hash function %s can't be used: %s", name, err)
+	}
+	return code, nil
+}
@@ -0,0 +1,24 @@
+package coreunix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashFunctionCode(t *testing.T) {
+	for _, name := range []string{"sha2-256", "SHA3-256", "blake2b-256"} {
+		if _, err := HashFunctionCode(name); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	for name, msg := range map[string]string{
+		"blake2b-8": "can't be used",
+		"nope":      "unrecognized hash function",
+	} {
+		_, err := HashFunctionCode(name)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: expected %q, got %v", name, msg, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package coreunix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	gopath "path"
+	"strings"
+
+	balanced "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/balanced"
+	ihelper "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/helpers"
+	trickle "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer/trickle"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// DAG layouts of the added files.
+const (
+	// LayoutBalanced builds balanced trees, fast to seek in.
+	LayoutBalanced = "balanced"
+	// LayoutTrickle builds trickle trees, fast to stream from the start,
+	// which suits media files.
+	LayoutTrickle = "trickle"
+)
+
+var layouts = map[string]func(*ihelper.DagBuilderHelper) (ipld.Node, error){
+	LayoutBalanced: balanced.Layout,
+	LayoutTrickle:  trickle.Layout,
+}
+
+// ValidateLayout returns an error if layout isn't a known DAG layout.
+func ValidateLayout(layout string) error {
+	if _, ok := layouts[layout]; !ok {
+		return fmt.Errorf("unknown layout %q, expected %s or %s", layout, LayoutBalanced, LayoutTrickle)
+	}
+	return nil
+}
+
+// Strategy is how a file is imported.
+type Strategy struct {
+	Layout  string
+	Chunker string
+}
+
+// maxManifestSize bounds the size of layout manifests.
+const maxManifestSize = 1 << 20
+
+// Manifest sets the import strategy of the added files by path.
+type Manifest struct {
+	Rules []ManifestRule
+}
+
+// ManifestRule sets the layout and the chunker, when not empty, of the files
+// matching Pattern.
+type ManifestRule struct {
+	// Pattern uses the path.Match syntax. Patterns without a '/' are matched
+	// against the name of the files, the others against their whole path
+	// from the added directory, e.g. 'photos/*.jpg'.
+	Pattern string
+	Layout  string `json:",omitempty"`
+	Chunker string `json:",omitempty"`
+}
+
+// ParseManifest reads a JSON manifest, like:
+//
+//	{
+//	  "Rules": [
+//	    {"Pattern": "*.mp4", "Layout": "trickle", "Chunker": "size-1048576"},
+//	    {"Pattern": "*.iso", "Chunker": "buzhash"}
+//	  ]
+//	}
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	dec := json.NewDecoder(io.LimitReader(r, maxManifestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %s", err)
+	}
+
+	for i, rule := range m.Rules {
+		if _, err := gopath.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+			return nil, fmt.Errorf("invalid manifest: rule %d: invalid pattern %q", i+1, rule.Pattern)
+		}
+		if rule.Layout != "" {
+			if err := ValidateLayout(rule.Layout); err != nil {
+				return nil, fmt.Errorf("invalid manifest: rule %d: %s", i+1, err)
+			}
+		}
+		if rule.Chunker != "" {
+			if err := ValidateChunker(rule.Chunker); err != nil {
+				return nil, fmt.Errorf("invalid manifest: rule %d: %s", i+1, err)
+			}
+		}
+	}
+	return &m, nil
+}
+
+// Strategy returns the strategy of the file at path: the layout and the
+// chunker of the first matching rule setting them, or those of def.
+func (m *Manifest) Strategy(path string, def Strategy) Strategy {
+	if m == nil {
+		return def
+	}
+
+	path = strings.TrimPrefix(gopath.Clean("/"+path), "/")
+	name := gopath.Base(path)
+
+	var s Strategy
+	for _, rule := range m.Rules {
+		subject := name
+		if strings.Contains(rule.Pattern, "/") {
+			subject = path
+		}
+		if ok, _ := gopath.Match(rule.Pattern, subject); !ok {
+			continue
+		}
+		if s.Layout == "" {
+			s.Layout = rule.Layout
+		}
+		if s.Chunker == "" {
+			s.Chunker = rule.Chunker
+		}
+	}
+
+	if s.Layout == "" {
+		s.Layout = def.Layout
+	}
+	if s.Chunker == "" {
+		s.Chunker = def.Chunker
+	}
+	return s
+}
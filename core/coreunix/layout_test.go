@@ -0,0 +1,56 @@
+package coreunix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestManifestStrategy(t *testing.T) {
+	m, err := ParseManifest(strings.NewReader(`{
+		"Rules": [
+			{"Pattern": "*.mp4", "Layout": "trickle"},
+			{"Pattern": "raw/*", "Chunker": "size-1024"},
+			{"Pattern": "*", "Layout": "balanced", "Chunker": "buzhash"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def := Strategy{Layout: LayoutBalanced, Chunker: DefaultChunker}
+	cases := []struct {
+		path string
+		exp  Strategy
+	}{
+		{"dir/movie.mp4", Strategy{LayoutTrickle, "buzhash"}},
+		{"raw/movie.mp4", Strategy{LayoutTrickle, "size-1024"}},
+		{"raw/data.bin", Strategy{LayoutBalanced, "size-1024"}},
+		{"raw/sub/data.bin", Strategy{LayoutBalanced, "buzhash"}},
+		{"notes.txt", Strategy{LayoutBalanced, "buzhash"}},
+	}
+	for _, c := range cases {
+		if s := m.Strategy(c.path, def); s != c.exp {
+			t.Errorf("%s: expected %v, got %v", c.path, c.exp, s)
+		}
+	}
+
+	var none *Manifest
+	if s := none.Strategy("movie.mp4", def); s != def {
+		t.Errorf("expected no manifest to keep the default strategy, got %v", s)
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	for _, m := range []string{
+		`{"Rules": [{"Pattern": "[", "Layout": "trickle"}]}`,
+		`{"Rules": [{"Pattern": "", "Layout": "trickle"}]}`,
+		`{"Rules": [{"Pattern": "*", "Layout": "spiral"}]}`,
+		`{"Rules": [{"Pattern": "*", "Chunker": "fixed-1024"}]}`,
+		`{"Rules": [{"Pattern": "*", "Layuot": "trickle"}]}`,
+		`not json`,
+	} {
+		if _, err := ParseManifest(strings.NewReader(m)); err == nil {
+			t.Errorf("expected %s to be rejected", m)
+		}
+	}
+}
@@ -1,10 +1,22 @@
 package coreunix
 
 import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+
 	core "github.com/ipfs/go-ipfs/core"
 	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
 	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+
+	posinfo "gx/ipfs/QmPG32VXR5jmpo9q8R9FNdR4Ae97Ky9CiZE6SctJLUB79H/go-ipfs-posinfo"
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+	files "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit/files"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
 )
 
 func AddMetadataTo(n *core.IpfsNode, skey string, m *ft.Metadata) (string, error) {
@@ -55,3 +67,114 @@ func Metadata(n *core.IpfsNode, skey string) (*ft.Metadata, error) {
 
 	return ft.MetadataFromBytes(pbnd.Data())
 }
+
+// mimeSniffLen is the length of the content http.DetectContentType looks at.
+const mimeSniffLen = 512
+
+// MimeType returns the MIME type recorded in the unixfs metadata node nd, or
+// "" if nd isn't one.
+func MimeType(nd ipld.Node) string {
+	pbnd, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return ""
+	}
+	m, err := ft.MetadataFromBytes(pbnd.Data())
+	if err != nil {
+		return ""
+	}
+	return m.MimeType
+}
+
+// sniffedFile keeps the file info of an added file whose head was read to
+// detect its MIME type, for the filestore.
+type sniffedFile struct {
+	io.Reader
+	files.FileInfo
+}
+
+// detectMimeType returns the MIME type of the file name from its extension,
+// or else from the head of its content read from r. The returned reader
+// reads the whole content.
+func detectMimeType(r io.Reader, name string) (io.Reader, string, error) {
+	if t := mime.TypeByExtension(gopath.Ext(name)); t != "" {
+		return r, t, nil
+	}
+
+	head := make([]byte, mimeSniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, "", err
+	}
+	head = head[:n]
+
+	out := io.MultiReader(bytes.NewReader(head), r)
+	if fi, ok := r.(files.FileInfo); ok {
+		out = &sniffedFile{Reader: out, FileInfo: fi}
+	}
+	return out, http.DetectContentType(head), nil
+}
+
+// wrapMetadata wraps the file node nd in a unixfs metadata node recording its
+// MIME type.
+func (adder *Adder) wrapMetadata(nd ipld.Node, mimeType string) (ipld.Node, error) {
+	var size uint64
+	switch n := nd.(type) {
+	case *posinfo.FilestoreNode:
+		return adder.wrapMetadata(n.Node, mimeType)
+	case *dag.RawNode:
+		size = uint64(len(n.RawData()))
+	case *dag.ProtoNode:
+		pbd, err := ft.FromBytes(n.Data())
+		if err != nil {
+			return nil, err
+		}
+		size = pbd.GetFilesize()
+	}
+
+	mdata, err := ft.BytesForMetadata(&ft.Metadata{MimeType: mimeType, Size: size})
+	if err != nil {
+		return nil, err
+	}
+	mdnode := dag.NodeWithData(mdata)
+	mdnode.SetCidBuilder(adder.CidBuilder)
+	if err := mdnode.AddNodeLink("file", nd); err != nil {
+		return nil, err
+	}
+	if err := adder.dagService.Add(adder.ctx, mdnode); err != nil {
+		return nil, err
+	}
+	return mdnode, nil
+}
+
+// metadataFile stands for a unixfs metadata node in the mfs tree of the
+// adder, mfs doesn't load them.
+type metadataFile struct {
+	nd ipld.Node
+}
+
+func (f metadataFile) GetNode() (ipld.Node, error) { return f.nd, nil }
+func (f metadataFile) Flush() error                { return nil }
+func (f metadataFile) Type() mfs.NodeType          { return mfs.TFile }
+
+// child returns the child name of dir like dir.Child, including the unixfs
+// metadata nodes of the files added with DetectMime.
+func (adder *Adder) child(dir *mfs.Directory, name string) (mfs.FSNode, error) {
+	child, err := dir.Child(name)
+	if err != mfs.ErrNotYetImplemented {
+		return child, err
+	}
+
+	dirnd, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	d, err := uio.NewDirectoryFromNode(adder.dagService, dirnd)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := d.Find(adder.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return metadataFile{nd: nd}, nil
+}
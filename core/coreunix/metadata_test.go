@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	core "github.com/ipfs/go-ipfs/core"
+	repo "github.com/ipfs/go-ipfs/repo"
 	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
 	importer "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer"
 	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
@@ -15,8 +17,10 @@ import (
 
 	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	u "gx/ipfs/QmPdKqUcHGFdeSpvjVoaTRPPstGif9GBZb5Q56RVw9o69A/go-ipfs-util"
+	files "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit/files"
 	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
 	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
 	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
 	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
 	chunker "gx/ipfs/QmdSeG9s4EQ9TGruJJS9Us38TQDZtMmFGwzTYUDVqNTURm/go-ipfs-chunker"
@@ -92,3 +96,101 @@ func TestMetadata(t *testing.T) {
 		t.Fatal("read incorrect data")
 	}
 }
+
+func TestDetectMimeType(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, mimeType string
+	}{
+		{"logo.png", "not a png", "image/png"},
+		{"index", "<!DOCTYPE html><html></html>", "text/html; charset=utf-8"},
+		{"image", "\x89PNG\x0D\x0A\x1A\x0A" + strings.Repeat("\x00", 1000), "image/png"},
+		{"empty", "", "text/plain; charset=utf-8"},
+	} {
+		r, mimeType, err := detectMimeType(strings.NewReader(tc.content), tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mimeType != tc.mimeType {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.mimeType, mimeType)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tc.content {
+			t.Errorf("%s: the content wasn't kept", tc.name)
+		}
+	}
+}
+
+func TestAddDetectMime(t *testing.T) {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: testPeerID, // required by offline node
+			},
+		},
+		D: dssync.MutexWrap(ds.NewMapDatastore()),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan interface{})
+	adder, err := NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Out = out
+	adder.DetectMime = true
+	go func() {
+		for range out {
+		}
+	}()
+	defer close(out)
+
+	contents := map[string]string{
+		"index.html": "<p>hello</p>",
+		"notes":      "some notes",
+	}
+	var fs []files.File
+	for name, content := range contents {
+		fs = append(fs, files.NewReaderFile(name, "site/"+name, ioutil.NopCloser(strings.NewReader(content)), nil))
+	}
+	if err := adder.AddFile(files.NewSliceFile("site", "site", fs)); err != nil {
+		t.Fatal(err)
+	}
+	root, err := adder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := uio.NewDirectoryFromNode(node.DAG, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, mimeType := range map[string]string{
+		"index.html": "text/html; charset=utf-8",
+		"notes":      "text/plain; charset=utf-8",
+	} {
+		nd, err := dir.Find(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := MimeType(nd); got != mimeType {
+			t.Errorf("%s: expected %s, got %q", name, mimeType, got)
+		}
+		dr, err := uio.NewDagReader(context.Background(), nd, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(dr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != contents[name] {
+			t.Errorf("%s: expected %q, got %q", name, contents[name], content)
+		}
+	}
+}
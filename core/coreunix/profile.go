@@ -0,0 +1,77 @@
+package coreunix
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+// Profile pins down all the parameters affecting the CIDs of added content,
+// so that the same files added with the same profile give the same CIDs on
+// any machine and with any later release. A released profile must never
+// change: a new version of it is added instead, with a new name.
+type Profile struct {
+	// Name is the name of the profile, ending with its version.
+	Name         string
+	CidVersion   int
+	HashFunction string
+	Chunker      string
+	Layout       string
+	RawLeaves    bool
+	// MaxLinks is the largest number of links of the file nodes.
+	MaxLinks int
+	// Sharding sets when directories are sharded.
+	Sharding core.ShardingPolicy
+}
+
+// DefaultProfile is the profile of 'ipfs add --deterministic'.
+const DefaultProfile = "cidv1-1"
+
+var profiles = map[string]Profile{
+	// the defaults of 'ipfs add' when the profiles were introduced
+	"cidv0-1": {
+		Name:         "cidv0-1",
+		CidVersion:   0,
+		HashFunction: "sha2-256",
+		Chunker:      "size-262144",
+		Layout:       LayoutBalanced,
+		RawLeaves:    false,
+		MaxLinks:     174,
+		Sharding:     core.ShardingPolicy{SizeThreshold: 256 << 10},
+	},
+	"cidv1-1": {
+		Name:         "cidv1-1",
+		CidVersion:   1,
+		HashFunction: "sha2-256",
+		Chunker:      "size-1048576",
+		Layout:       LayoutBalanced,
+		RawLeaves:    true,
+		MaxLinks:     174,
+		Sharding:     core.ShardingPolicy{SizeThreshold: 256 << 10},
+	},
+}
+
+// LookupProfile returns the profile called name, or DefaultProfile if name
+// is empty.
+func LookupProfile(name string) (Profile, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q, expected one of: %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	return p, nil
+}
+
+// ProfileNames returns the names of the profiles, sorted.
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,48 @@
+package coreunix
+
+import (
+	"regexp"
+	"testing"
+)
+
+var profileNameRe = regexp.MustCompile(`^[a-z0-9]+-[0-9]+$`)
+
+func TestProfilesValid(t *testing.T) {
+	for name, p := range profiles {
+		if p.Name != name {
+			t.Errorf("%s: named %s", name, p.Name)
+		}
+		if !profileNameRe.MatchString(name) {
+			t.Errorf("%s: the name must end with the version of the profile", name)
+		}
+		if err := ValidateChunker(p.Chunker); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if err := ValidateLayout(p.Layout); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if _, err := HashFunctionCode(p.HashFunction); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if p.CidVersion == 0 && p.HashFunction != "sha2-256" {
+			t.Errorf("%s: CIDv0 only supports sha2-256", name)
+		}
+		if p.MaxLinks <= 0 {
+			t.Errorf("%s: no max links", name)
+		}
+	}
+}
+
+func TestLookupProfile(t *testing.T) {
+	p, err := LookupProfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != DefaultProfile {
+		t.Fatalf("expected the default profile, got %s", p.Name)
+	}
+
+	if _, err := LookupProfile("cidv1"); err == nil {
+		t.Fatal("expected a profile without version to be rejected")
+	}
+}
@@ -0,0 +1,237 @@
+package coreunix
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
+	tarutil "gx/ipfs/QmQine7gvHncNevKtG9QXxf3nXcwSj6aDDmMm52mHofEEp/tar-utils"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// TarCidRecord is the PAX record holding the CID of a tar entry written by
+// TarWriter when CidHeaders is set.
+const TarCidRecord = "IPFS.cid"
+
+// TarWriter writes unixfs DAGs as tar archives. Unlike the go-unixfs
+// archive writer, its output only depends on the DAG: directory entries are
+// sorted by name (including for sharded directories) and headers carry no
+// timestamps or owners.
+type TarWriter struct {
+	ctx context.Context
+	dag ipld.DAGService
+	tw  *tar.Writer
+
+	// CidHeaders adds the CID of every entry to its header, in the
+	// TarCidRecord PAX record.
+	CidHeaders bool
+
+	// Meta, when set, returns the metadata of the entry of nd at fpath,
+	// whose mode and modification time are written to its header instead of
+	// the defaults.
+	Meta func(nd ipld.Node, fpath string) (FileMeta, error)
+}
+
+// NewTarWriter returns a TarWriter writing to w.
+func NewTarWriter(ctx context.Context, ds ipld.DAGService, w io.Writer) *TarWriter {
+	return &TarWriter{
+		ctx: ctx,
+		dag: ds,
+		tw:  tar.NewWriter(w),
+	}
+}
+
+// WriteNode writes nd and, if it is a directory, its children under fpath.
+func (w *TarWriter) WriteNode(nd ipld.Node, fpath string) error {
+	switch nd := nd.(type) {
+	case *dag.RawNode:
+		return w.writeFile(nd, fpath)
+	case *dag.ProtoNode:
+		fsn, err := ft.FromBytes(nd.Data())
+		if err != nil {
+			return err
+		}
+
+		switch fsn.GetType() {
+		case ft.TDirectory, ft.THAMTShard:
+			return w.writeDir(nd, fpath)
+		case ft.TFile, ft.TRaw:
+			return w.writeFile(nd, fpath)
+		case ft.TSymlink:
+			return w.writeHeader(nd, &tar.Header{
+				Name:     fpath,
+				Linkname: string(fsn.GetData()),
+				Mode:     0777,
+				Typeflag: tar.TypeSymlink,
+			})
+		case ft.TMetadata:
+			if len(nd.Links()) == 0 {
+				return ft.ErrInvalidDirLocation
+			}
+			child, err := nd.Links()[0].GetNode(w.ctx, w.dag)
+			if err != nil {
+				return err
+			}
+			return w.WriteNode(child, fpath)
+		default:
+			return ft.ErrUnrecognizedType
+		}
+	default:
+		return fmt.Errorf("cannot write %T nodes to a tar archive", nd)
+	}
+}
+
+func (w *TarWriter) writeDir(nd ipld.Node, fpath string) error {
+	err := w.writeHeader(nd, &tar.Header{
+		Name:     fpath + "/",
+		Mode:     0755,
+		Typeflag: tar.TypeDir,
+	})
+	if err != nil {
+		return err
+	}
+
+	dir, err := uio.NewDirectoryFromNode(w.dag, nd)
+	if err != nil {
+		return err
+	}
+	links, err := dir.Links(w.ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Name < links[j].Name })
+
+	for _, l := range links {
+		child, err := l.GetNode(w.ctx, w.dag)
+		if err != nil {
+			return err
+		}
+		if err := w.WriteNode(child, gopath.Join(fpath, l.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *TarWriter) writeFile(nd ipld.Node, fpath string) error {
+	r, err := uio.NewDagReader(w.ctx, nd, w.dag)
+	if err != nil {
+		return err
+	}
+
+	err = w.writeHeader(nd, &tar.Header{
+		Name:     fpath,
+		Size:     int64(r.Size()),
+		Mode:     0644,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w.tw, r)
+	return err
+}
+
+func (w *TarWriter) writeHeader(nd ipld.Node, h *tar.Header) error {
+	h.ModTime = time.Unix(0, 0)
+	if w.Meta != nil && h.Typeflag != tar.TypeSymlink {
+		m, err := w.Meta(nd, h.Name)
+		if err != nil {
+			return err
+		}
+		if m.Mode != 0 {
+			h.Mode = int64(m.Mode & os.ModePerm)
+		}
+		if m.Mtime != 0 {
+			h.ModTime = time.Unix(m.Mtime, 0)
+		}
+	}
+	if w.CidHeaders {
+		h.Format = tar.FormatPAX
+		h.PAXRecords = map[string]string{TarCidRecord: nd.Cid().String()}
+	}
+	return w.tw.WriteHeader(h)
+}
+
+// Close finishes the archive. It doesn't close the underlying writer.
+func (w *TarWriter) Close() error {
+	return w.tw.Close()
+}
+
+// ExtractTar extracts the tar archive of r to fpath like the tar-utils
+// Extractor, then restores the modes and modification times of the entries
+// that differ from the defaults of TarWriter. Symlinks keep theirs.
+func ExtractTar(r io.Reader, fpath string, progress func(int64) int64) error {
+	rootExists, rootIsDir := false, false
+	if st, err := os.Stat(fpath); err == nil {
+		rootExists, rootIsDir = true, st.IsDir()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// the headers are read from a copy of the archive, as the extractor
+	// doesn't expose them
+	pr, pw := io.Pipe()
+	headers := make(chan []*tar.Header, 1)
+	go func() {
+		var hs []*tar.Header
+		tr := tar.NewReader(pr)
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+			hs = append(hs, h)
+		}
+		io.Copy(ioutil.Discard, pr)
+		headers <- hs
+	}()
+
+	ex := &tarutil.Extractor{Path: fpath, Progress: progress}
+	err := ex.Extract(io.TeeReader(r, pw))
+	pw.Close()
+	hs := <-headers
+	if err != nil {
+		return err
+	}
+
+	// children first, so that a read-only directory doesn't get in the way
+	for i := len(hs) - 1; i >= 0; i-- {
+		h := hs[i]
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeDir {
+			continue
+		}
+
+		// the path the extractor wrote the entry to
+		elems := strings.Split(strings.TrimSuffix(h.Name, "/"), "/")
+		p := filepath.Join(ex.Path, filepath.FromSlash(strings.Join(elems[1:], "/")))
+		if i == 0 && h.Typeflag == tar.TypeReg && rootExists && rootIsDir && filepath.Base(p) != elems[0] {
+			p = filepath.Join(p, elems[0])
+		}
+
+		mode := os.FileMode(h.Mode) & os.ModePerm
+		if (h.Typeflag == tar.TypeReg && mode != 0644) || (h.Typeflag == tar.TypeDir && mode != 0755) {
+			if err := os.Chmod(p, mode); err != nil {
+				return err
+			}
+		}
+		if h.ModTime.Unix() != 0 {
+			if err := os.Chtimes(p, h.ModTime, h.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
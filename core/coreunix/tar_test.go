@@ -0,0 +1,154 @@
+package coreunix
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	importer "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/importer"
+
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+	chunker "gx/ipfs/QmdSeG9s4EQ9TGruJJS9Us38TQDZtMmFGwzTYUDVqNTURm/go-ipfs-chunker"
+)
+
+func TestTarWriterOrderAndCids(t *testing.T) {
+	ctx := context.Background()
+	ds := getDagserv(t)
+
+	dir := ft.EmptyDirNode()
+	files := map[string]string{"zeta": "last", "alpha": "first", "mid": "middle"}
+	cids := map[string]string{}
+	for name, content := range files {
+		nd, err := importer.BuildDagFromReader(ds, chunker.DefaultSplitter(bytes.NewReader([]byte(content))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dir.AddNodeLink(name, nd); err != nil {
+			t.Fatal(err)
+		}
+		cids["root/"+name] = nd.Cid().String()
+	}
+	if err := ds.Add(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	cids["root/"] = dir.Cid().String()
+
+	var buf bytes.Buffer
+	w := NewTarWriter(ctx, ds, &buf)
+	w.CidHeaders = true
+	if err := w.WriteNode(dir, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+
+		if h.PAXRecords[TarCidRecord] != cids[h.Name] {
+			t.Fatalf("wrong cid for %s: %q", h.Name, h.PAXRecords[TarCidRecord])
+		}
+		if h.Typeflag == tar.TypeReg {
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != files[h.Name[len("root/"):]] {
+				t.Fatalf("wrong content for %s: %q", h.Name, b)
+			}
+		}
+	}
+
+	expected := []string{"root/", "root/alpha", "root/mid", "root/zeta"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+	}
+}
+
+func TestTarMetaRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	ds := getDagserv(t)
+
+	file, err := importer.BuildDagFromReader(ds, chunker.DefaultSplitter(bytes.NewReader([]byte("content"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := ft.EmptyDirNode()
+	if err := sub.AddNodeLink("plain", file); err != nil {
+		t.Fatal(err)
+	}
+	dir := ft.EmptyDirNode()
+	if err := dir.AddNodeLink("script", file); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.AddNodeLink("sub", sub); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []ipld.Node{sub, dir} {
+		if err := ds.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meta := map[string]FileMeta{
+		"root/script": {Mode: 0750, Mtime: 1546300800},
+		"root/sub/":   {Mode: 0700},
+	}
+	var buf bytes.Buffer
+	w := NewTarWriter(ctx, ds, &buf)
+	w.Meta = func(nd ipld.Node, fpath string) (FileMeta, error) {
+		return meta[fpath], nil
+	}
+	if err := w.WriteNode(dir, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "tar-meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	out := filepath.Join(tmp, "out")
+	if err := ExtractTar(&buf, out, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := os.Stat(filepath.Join(out, "script"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0750 || st.ModTime().Unix() != 1546300800 {
+		t.Fatalf("script: got mode %s and mtime %s", st.Mode(), st.ModTime())
+	}
+	if st, err = os.Stat(filepath.Join(out, "sub")); err != nil || st.Mode().Perm() != 0700 {
+		t.Fatalf("sub: got %v (%v)", st.Mode(), err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(out, "sub", "plain"))
+	if err != nil || string(b) != "content" {
+		t.Fatalf("plain: got %q (%v)", b, err)
+	}
+}
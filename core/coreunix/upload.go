@@ -0,0 +1,465 @@
+package coreunix
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+
+	files "gx/ipfs/QmSP88ryZkHSRn1fnngAaV2Vcn63WUJzAavnRM9CVdU1Ky/go-ipfs-cmdkit/files"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	lockfile "gx/ipfs/QmZzgxSj8QpR58KmdeNj97eD66X6xeDAFNjpP2xTY9oKeQ/go-fs-lock"
+	bserv "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
+)
+
+// DefaultUploadExpiry is the time an upload is kept without being appended
+// to.
+const DefaultUploadExpiry = 24 * time.Hour
+
+// DefaultUploadMaxSize bounds the total length of the uploads in progress.
+const DefaultUploadMaxSize = 4 << 30
+
+const (
+	uploadDataFile   = "data"
+	uploadRecordFile = "upload.json"
+	uploadLockFile   = "upload.lock"
+)
+
+var (
+	// ErrNoUpload is returned for unknown or expired uploads.
+	ErrNoUpload = errors.New("no such upload")
+	// ErrUploadBusy is returned when the upload is being appended to by
+	// another request.
+	ErrUploadBusy = errors.New("the upload is being appended to by another request")
+	// ErrUploadComplete is returned when appending to a complete upload.
+	ErrUploadComplete = errors.New("the upload is complete")
+	// ErrUploadTooLarge is returned when an upload would make the uploads
+	// in progress exceed the maximum size of the store.
+	ErrUploadTooLarge = errors.New("the uploads in progress would exceed their maximum size")
+)
+
+// uploadCreateLk serializes the creations of uploads, for the reserved
+// lengths to be checked against the maximum size.
+var uploadCreateLk sync.Mutex
+
+// UploadOptions are the options of the add of an upload, once complete.
+type UploadOptions struct {
+	Name       string
+	Pin        bool
+	RawLeaves  bool
+	CidVersion int
+	Chunker    string
+	Wrap       bool
+}
+
+// UploadStatus is the state of a resumable upload.
+type UploadStatus struct {
+	ID string
+	// Length is the size of the file, Offset the number of bytes received.
+	Length int64
+	Offset int64
+	// Hash is set once the file is added.
+	Hash    string `json:",omitempty"`
+	Expires time.Time
+}
+
+// uploadRecord is stored along the data of an upload.
+type uploadRecord struct {
+	Length  int64
+	Hash    string
+	Options UploadOptions
+	Updated time.Time
+}
+
+// UploadStore keeps the data of resumable uploads in a directory until they
+// are complete and added, so that an upload interrupted by a connection
+// failure can be continued from the last byte received. The uploads are
+// removed once they haven't been appended to for the expiry. The total
+// length of the uploads in progress is bounded by the maximum size.
+type UploadStore struct {
+	dir     string
+	expiry  time.Duration
+	maxSize int64
+}
+
+// NewUploadStore returns the store of the uploads in dir.
+func NewUploadStore(dir string, expiry time.Duration, maxSize int64) *UploadStore {
+	if expiry <= 0 {
+		expiry = DefaultUploadExpiry
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultUploadMaxSize
+	}
+	return &UploadStore{dir: dir, expiry: expiry, maxSize: maxSize}
+}
+
+func (s *UploadStore) path(id, name string) string {
+	return filepath.Join(s.dir, id, name)
+}
+
+// Create starts an upload of length bytes. It fails with ErrUploadTooLarge
+// if the length and the ones of the uploads in progress exceed the maximum
+// size of the store.
+func (s *UploadStore) Create(length int64, opts UploadOptions) (*UploadStatus, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("invalid upload length %d", length)
+	}
+
+	uploadCreateLk.Lock()
+	defer uploadCreateLk.Unlock()
+
+	reserved, err := s.Reserved()
+	if err != nil {
+		return nil, err
+	}
+	if length > s.maxSize-reserved {
+		return nil, ErrUploadTooLarge
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Join(s.dir, id), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(s.path(id, uploadDataFile), nil, 0600); err != nil {
+		return nil, err
+	}
+
+	rec := &uploadRecord{Length: length, Options: opts, Updated: time.Now()}
+	if err := s.put(id, rec); err != nil {
+		return nil, err
+	}
+	return s.status(id, rec)
+}
+
+func (s *UploadStore) put(id string, rec *uploadRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(id, uploadRecordFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(id, uploadRecordFile))
+}
+
+func (s *UploadStore) get(id string) (*uploadRecord, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, ErrNoUpload
+	}
+	b, err := ioutil.ReadFile(s.path(id, uploadRecordFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNoUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &uploadRecord{}
+	if err := json.Unmarshal(b, rec); err != nil {
+		return nil, fmt.Errorf("invalid upload %s: %s", id, err)
+	}
+	if time.Since(rec.Updated) > s.expiry {
+		return nil, ErrNoUpload
+	}
+	return rec, nil
+}
+
+// status returns the status of the upload, the offset being the size of its
+// data.
+func (s *UploadStore) status(id string, rec *uploadRecord) (*UploadStatus, error) {
+	st := &UploadStatus{
+		ID:      id,
+		Length:  rec.Length,
+		Offset:  rec.Length,
+		Hash:    rec.Hash,
+		Expires: rec.Updated.Add(s.expiry),
+	}
+	if rec.Hash == "" {
+		fi, err := os.Stat(s.path(id, uploadDataFile))
+		if err != nil {
+			return nil, err
+		}
+		st.Offset = fi.Size()
+	}
+	return st, nil
+}
+
+// lock locks the upload for the request, failing with ErrUploadBusy if it is
+// locked already.
+func (s *UploadStore) lock(id string) (io.Closer, error) {
+	l, err := lockfile.Lock(filepath.Join(s.dir, id), uploadLockFile)
+	if err != nil {
+		if locked, lerr := lockfile.Locked(filepath.Join(s.dir, id), uploadLockFile); lerr == nil && locked {
+			return nil, ErrUploadBusy
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+// Status returns the status of the upload.
+func (s *UploadStore) Status(id string) (*UploadStatus, error) {
+	rec, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.status(id, rec)
+}
+
+// Append appends the data of r to the upload, at offset which must be the
+// number of bytes received so far. The bytes read from r are kept when it
+// fails, for the upload to be continued from there. Once the upload is
+// complete, add is called with its data, and the data is replaced by the
+// hash add returns. If add fails, appending no data at the end of the upload
+// calls it again.
+func (s *UploadStore) Append(id string, offset int64, r io.Reader, add func(io.Reader, UploadOptions) (string, error)) (*UploadStatus, error) {
+	if _, err := s.get(id); err != nil {
+		return nil, err
+	}
+	l, err := s.lock(id)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	// read again, the upload may have changed before it was locked
+	rec, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.status(id, rec)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Hash != "" {
+		return st, ErrUploadComplete
+	}
+	if offset != st.Offset {
+		return st, fmt.Errorf("the offset %d doesn't match the %d bytes received", offset, st.Offset)
+	}
+
+	f, err := os.OpenFile(s.path(id, uploadDataFile), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.CopyN(f, r, rec.Length-offset)
+	if err == io.EOF {
+		err = nil
+	} else if err == nil {
+		// the data must not go past the length
+		var extra [1]byte
+		if m, _ := io.ReadFull(r, extra[:]); m > 0 {
+			err = fmt.Errorf("the data goes past the length of %d bytes", rec.Length)
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	st.Offset += n
+	rec.Updated = time.Now()
+	st.Expires = rec.Updated.Add(s.expiry)
+	if perr := s.put(id, rec); err == nil {
+		err = perr
+	}
+	if err != nil || st.Offset < rec.Length {
+		return st, err
+	}
+
+	data, err := os.Open(s.path(id, uploadDataFile))
+	if err != nil {
+		return st, err
+	}
+	hash, err := add(data, rec.Options)
+	data.Close()
+	if err != nil {
+		return st, err
+	}
+
+	rec.Hash = hash
+	if err := s.put(id, rec); err != nil {
+		return st, err
+	}
+	st.Hash = hash
+	// the added data is in the repo now
+	if err := os.Remove(s.path(id, uploadDataFile)); err != nil {
+		log.Warningf("cannot remove the data of upload %s: %s", id, err)
+	}
+	return st, nil
+}
+
+// Reserved returns the total length of the uploads in progress, which is
+// the most their data takes once they're complete.
+func (s *UploadStore) Reserved() (int64, error) {
+	s.removeExpired()
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var reserved int64
+	for _, e := range entries {
+		rec, err := s.get(e.Name())
+		if err == ErrNoUpload {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if rec.Hash == "" {
+			reserved += rec.Length
+		}
+	}
+	return reserved, nil
+}
+
+// List returns the uploads, oldest expiry first.
+func (s *UploadStore) List() ([]*UploadStatus, error) {
+	s.removeExpired()
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*UploadStatus
+	for _, e := range entries {
+		st, err := s.Status(e.Name())
+		if err == ErrNoUpload {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Expires.Before(out[j].Expires)
+	})
+	return out, nil
+}
+
+// Remove removes the upload.
+func (s *UploadStore) Remove(id string) error {
+	if _, err := s.get(id); err != nil {
+		return err
+	}
+	l, err := s.lock(id)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return os.RemoveAll(filepath.Join(s.dir, id))
+}
+
+// removeExpired removes the uploads not appended to for the expiry, except
+// the ones being appended to.
+func (s *UploadStore) removeExpired() {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		id := e.Name()
+		// the directory of an upload being created has no record yet, its
+		// modification time is the last update of the record
+		if time.Since(e.ModTime()) <= s.expiry {
+			continue
+		}
+		if _, err := s.get(id); err != ErrNoUpload {
+			continue
+		}
+		l, err := s.lock(id)
+		if err != nil {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, id)); err != nil {
+			log.Warningf("cannot remove expired upload %s: %s", id, err)
+		}
+		l.Close()
+	}
+}
+
+// AddUpload adds the data of a complete upload to n, returning its hash.
+func AddUpload(ctx context.Context, n *core.IpfsNode, r io.Reader, opts UploadOptions) (string, error) {
+	hashFunStr, err := ConfiguredHashFunction(n.Repo)
+	if err != nil {
+		return "", err
+	}
+	chunker := opts.Chunker
+	if chunker == "" {
+		if chunker, err = ConfiguredChunker(n.Repo); err != nil {
+			return "", err
+		}
+	}
+
+	// only CIDv1 supports other hash functions than sha2-256
+	cidVer := opts.CidVersion
+	if hashFunStr != "sha2-256" {
+		cidVer = 1
+	}
+	prefix, err := dag.PrefixForCidVersion(cidVer)
+	if err != nil {
+		return "", err
+	}
+	if prefix.MhType, err = HashFunctionCode(hashFunStr); err != nil {
+		return "", err
+	}
+	prefix.MhLength = -1
+
+	// the upload is an add, its blocks count in the enforced StorageMax
+	dserv := dag.NewDAGService(bserv.New(core.NewQuotaBlockstore(n.Blockstore, n.Repo), n.Exchange))
+	adder, err := NewAdder(ctx, n.Pinning, n.Blockstore, dserv)
+	if err != nil {
+		return "", err
+	}
+	adder.Chunker = chunker
+	adder.Pin = opts.Pin
+	adder.RawLeaves = opts.RawLeaves
+	adder.Wrap = opts.Wrap
+	adder.CidBuilder = prefix
+	adder.Sharding = n.Sharding
+
+	name := opts.Name
+	if name == "" {
+		name = "upload"
+	}
+	file := files.NewReaderFile(name, name, ioutil.NopCloser(r), nil)
+	if err := adder.AddFile(file); err != nil {
+		return "", err
+	}
+	root, err := adder.Finalize()
+	if err != nil {
+		return "", err
+	}
+	if err := adder.PinRoot(); err != nil {
+		return "", err
+	}
+	if opts.Pin {
+		n.ProvideNewPin(root.Cid())
+	}
+	return root.Cid().String(), nil
+}
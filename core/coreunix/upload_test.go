@@ -0,0 +1,176 @@
+package coreunix
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewUploadStore(dir, time.Hour, 0)
+	st, err := s.Create(10, UploadOptions{Name: "file", Pin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Length != 10 || st.Offset != 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	id := st.ID
+
+	var added []byte
+	add := func(r io.Reader, opts UploadOptions) (string, error) {
+		if opts.Name != "file" || !opts.Pin {
+			t.Errorf("unexpected options: %+v", opts)
+		}
+		var err error
+		added, err = ioutil.ReadAll(r)
+		return "QmHash", err
+	}
+	failingAdd := func(io.Reader, UploadOptions) (string, error) {
+		return "", errors.New("add failed")
+	}
+
+	// an interrupted request keeps the bytes received
+	r := io.MultiReader(strings.NewReader("abc"), &errReader{errors.New("connection reset")})
+	st, err = s.Append(id, 0, r, add)
+	if err == nil || st.Offset != 3 {
+		t.Fatalf("expected the append to fail after 3 bytes, got %v %+v", err, st)
+	}
+
+	if _, err := s.Append(id, 0, strings.NewReader("abc"), add); err == nil {
+		t.Fatal("expected the offset to be checked")
+	}
+	if _, err := s.Append(id, 3, strings.NewReader("defghijk"), add); err == nil {
+		t.Fatal("expected the data past the length to be refused")
+	}
+
+	st, err = s.Status(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Offset != 10 || st.Hash != "" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	// the add is retried with no data
+	if _, err := s.Append(id, 10, &bytes.Buffer{}, failingAdd); err == nil {
+		t.Fatal("expected the add to fail")
+	}
+	st, err = s.Append(id, 10, &bytes.Buffer{}, add)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Hash != "QmHash" || string(added) != "abcdefghij" {
+		t.Fatalf("unexpected add: %+v %q", st, added)
+	}
+	if _, err := s.Append(id, 10, &bytes.Buffer{}, add); err != ErrUploadComplete {
+		t.Fatalf("expected the upload to be complete, got %v", err)
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != id || list[0].Hash != "QmHash" {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	if err := s.Remove(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Status(id); err != ErrNoUpload {
+		t.Fatalf("expected the upload to be removed, got %v", err)
+	}
+	if _, err := s.Status("../" + id); err != ErrNoUpload {
+		t.Fatalf("expected an invalid ID to be refused, got %v", err)
+	}
+}
+
+func TestUploadExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewUploadStore(dir, time.Hour, 0)
+	st, err := s.Create(10, UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the upload wasn't appended to for more than the expiry
+	rec, err := s.get(st.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Updated = time.Now().Add(-2 * time.Hour)
+	if err := s.put(st.ID, rec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Status(st.ID); err != ErrNoUpload {
+		t.Fatalf("expected the upload to expire, got %v", err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(dir+"/"+st.ID, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(10, UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir + "/" + st.ID); !os.IsNotExist(err) {
+		t.Fatalf("expected the expired upload to be removed, got %v", err)
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestUploadMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewUploadStore(dir, time.Hour, 10)
+	st, err := s.Create(6, UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(5, UploadOptions{}); err != ErrUploadTooLarge {
+		t.Fatalf("expected ErrUploadTooLarge, got %v", err)
+	}
+	if _, err := s.Create(4, UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if reserved, err := s.Reserved(); err != nil || reserved != 10 {
+		t.Fatalf("expected 10 bytes reserved, got %d (%v)", reserved, err)
+	}
+
+	// complete uploads don't reserve space anymore
+	add := func(io.Reader, UploadOptions) (string, error) { return "QmHash", nil }
+	if _, err := s.Append(st.ID, 0, strings.NewReader("abcdef"), add); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(6, UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
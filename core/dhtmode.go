@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// dhtModeCheckInterval is the interval the reachability of the node is
+// checked at, to switch the mode of the DHT of DHTAutoOption.
+const dhtModeCheckInterval = time.Minute
+
+// DHTMode is the mode of the DHT of the node.
+type DHTMode struct {
+	// Server is whether the node answers the DHT queries of the peers.
+	Server bool
+	// Auto is whether the mode follows the reachability of the node.
+	Auto bool
+}
+
+// DHTAutoOption runs the DHT in server mode while the node is publicly
+// dialable, and in client mode otherwise: an undialable node would be added
+// to the routing tables of its peers without them being able to query it.
+var DHTAutoOption RoutingOption = constructAutoDHTRouting
+
+func constructAutoDHTRouting(ctx context.Context, host p2phost.Host, dstore ds.Batching, validator record.Validator) (routing.IpfsRouting, error) {
+	if mh, ok := host.(*dhtModeHost); ok {
+		mh.auto = true
+	}
+	return constructDHTRouting(ctx, host, dstore, validator)
+}
+
+// dhtModeHost is the host of the routing system, which holds the stream
+// handlers the DHT sets to switch it to client mode by removing them, and to
+// server mode by setting them back.
+type dhtModeHost struct {
+	p2phost.Host
+	auto bool
+
+	mu       sync.Mutex
+	client   bool
+	handlers map[protocol.ID]inet.StreamHandler
+}
+
+func newDHTModeHost(h p2phost.Host) *dhtModeHost {
+	return &dhtModeHost{Host: h, handlers: make(map[protocol.ID]inet.StreamHandler)}
+}
+
+func (h *dhtModeHost) SetStreamHandler(pid protocol.ID, handler inet.StreamHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[pid] = handler
+	if !h.client {
+		h.Host.SetStreamHandler(pid, handler)
+	}
+}
+
+func (h *dhtModeHost) RemoveStreamHandler(pid protocol.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.handlers, pid)
+	h.Host.RemoveStreamHandler(pid)
+}
+
+// setClient switches the DHT to client mode, or back to server mode.
+func (h *dhtModeHost) setClient(client bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if client == h.client {
+		return
+	}
+	h.client = client
+	for pid, handler := range h.handlers {
+		if client {
+			h.Host.RemoveStreamHandler(pid)
+		} else {
+			h.Host.SetStreamHandler(pid, handler)
+		}
+	}
+	if client {
+		log.Info("the node isn't publicly dialable, the DHT runs in client mode")
+	} else {
+		log.Info("the node is publicly dialable, the DHT runs in server mode")
+	}
+}
+
+// mode returns the mode of the DHT, a DHT without handlers being a client.
+func (h *dhtModeHost) mode() DHTMode {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return DHTMode{Server: !h.client && len(h.handlers) > 0, Auto: h.auto}
+}
+
+// check switches the mode of the DHT with the reachability of the node.
+func (h *dhtModeHost) check() {
+	dialable, err := publiclyDialable(h.Host)
+	if err != nil {
+		log.Debugf("cannot list the listen addresses: %s", err)
+		return
+	}
+	h.setClient(!dialable)
+}
+
+// run checks the reachability of the node until it is closed.
+func (h *dhtModeHost) run(closing <-chan struct{}) {
+	ticker := time.NewTicker(dhtModeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.check()
+		case <-closing:
+			return
+		}
+	}
+}
+
+// publiclyDialable returns whether the host listens on a public address. The
+// addresses observed by the peers aren't trusted, a NAT may not let the
+// connections in.
+func publiclyDialable(h p2phost.Host) (bool, error) {
+	addrs, err := h.Network().InterfaceListenAddresses()
+	if err != nil {
+		return false, err
+	}
+	for _, a := range addrs {
+		if manet.IsPublicAddr(a) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DHTMode returns the mode of the DHT of the node, false if it has none.
+func (n *IpfsNode) DHTMode() (DHTMode, bool) {
+	if n.DHT == nil || n.dhtMode == nil {
+		return DHTMode{}, false
+	}
+	return n.dhtMode.mode(), true
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+type handlersHost struct {
+	p2phost.Host
+	handlers map[protocol.ID]inet.StreamHandler
+}
+
+func (h *handlersHost) SetStreamHandler(pid protocol.ID, handler inet.StreamHandler) {
+	h.handlers[pid] = handler
+}
+
+func (h *handlersHost) RemoveStreamHandler(pid protocol.ID) {
+	delete(h.handlers, pid)
+}
+
+func TestDHTModeHost(t *testing.T) {
+	inner := &handlersHost{handlers: make(map[protocol.ID]inet.StreamHandler)}
+	h := newDHTModeHost(inner)
+	if h.mode().Server {
+		t.Fatal("expected a DHT without handlers to be a client")
+	}
+
+	handler := func(inet.Stream) {}
+	h.SetStreamHandler("/ipfs/kad/1.0.0", handler)
+	if !h.mode().Server || len(inner.handlers) != 1 {
+		t.Fatalf("expected the DHT to be a server, got %+v", h.mode())
+	}
+
+	h.setClient(true)
+	h.SetStreamHandler("/ipfs/dht", handler)
+	if h.mode().Server || len(inner.handlers) != 0 {
+		t.Fatalf("expected the handlers to be removed, got %v", inner.handlers)
+	}
+
+	h.setClient(false)
+	if !h.mode().Server || len(inner.handlers) != 2 {
+		t.Fatalf("expected the handlers to be set back, got %v", inner.handlers)
+	}
+
+	h.RemoveStreamHandler("/ipfs/dht")
+	h.setClient(true)
+	h.setClient(false)
+	if len(inner.handlers) != 1 {
+		t.Fatalf("expected the removed handler to stay removed, got %v", inner.handlers)
+	}
+}
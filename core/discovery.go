@@ -0,0 +1,62 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mdns "github.com/ipfs/go-ipfs/p2p/mdns"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	discovery "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/discovery"
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// defaultMDNSInterval is the interval of the queries when
+// Discovery.MDNS.Interval is unset, in seconds.
+const defaultMDNSInterval = 5
+
+// MDNSConfig is the Discovery.MDNS config, with the keys the config structs
+// don't model.
+type MDNSConfig struct {
+	Enabled bool
+	// Interval is the time between the queries, in seconds.
+	Interval int
+	// ServiceTag is the DNS-SD service the peers are announced under.
+	ServiceTag string
+	// IPv6 discovers the peers over IPv6 too, true by default.
+	IPv6 bool
+	// ExcludedInterfaces are the names of the network interfaces not used.
+	ExcludedInterfaces []string
+}
+
+func setupDiscoveryOption(r repo.Repo, d config.Discovery) (DiscoveryOption, error) {
+	cfg := MDNSConfig{
+		Enabled:  d.MDNS.Enabled,
+		Interval: d.MDNS.Interval,
+		IPv6:     true,
+	}
+	if err := repo.ConfigSection(r, "Discovery.MDNS", &cfg); err != nil {
+		return nil, fmt.Errorf("invalid Discovery.MDNS config: %s", err)
+	}
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultMDNSInterval
+	}
+
+	mcfg := mdns.Config{
+		Interval:           time.Duration(cfg.Interval) * time.Second,
+		ServiceTag:         cfg.ServiceTag,
+		IPv6:               cfg.IPv6,
+		ExcludedInterfaces: cfg.ExcludedInterfaces,
+	}
+	if err := mcfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Discovery.MDNS config: %s", err)
+	}
+	return func(ctx context.Context, h p2phost.Host) (discovery.Service, error) {
+		return mdns.NewService(ctx, h, mcfg)
+	}, nil
+}
@@ -0,0 +1,142 @@
+package features
+
+import (
+	"errors"
+
+	filestore "github.com/ipfs/go-ipfs/filestore"
+)
+
+// Names of the built-in features.
+const (
+	Filestore            = "filestore"
+	Urlstore             = "urlstore"
+	Sharding             = "sharding"
+	Libp2pStreamMounting = "libp2p-stream-mounting"
+	QUIC                 = "quic"
+	BitswapPeerRanking   = "bitswap-peer-ranking"
+	AcceleratedDHTClient = "accelerated-dht-client"
+	LightClient          = "light-client"
+
+	RawLeaves     = "raw-leaves"
+	CidVersion    = "cid-version"
+	HashFunction  = "hash-function"
+	InlineBlocks  = "inline-blocks"
+	FileMetadata  = "file-metadata"
+	MimeDetection = "mime-detection"
+	Dag           = "dag"
+
+	BootstrapAddDefaultFlag = "bootstrap-add-default-flag"
+	BootstrapRmAllFlag      = "bootstrap-rm-all-flag"
+)
+
+func init() {
+	Register(Feature{
+		Name:        Filestore,
+		Description: "Add files without duplicating their data (ipfs add --nocopy).",
+		Stage:       Experimental,
+		ConfigKey:   "Experimental.FilestoreEnabled",
+		DisabledErr: filestore.ErrFilestoreNotEnabled,
+	})
+	Register(Feature{
+		Name:        Urlstore,
+		Description: "Reference content served by HTTP URLs (ipfs urlstore add).",
+		Stage:       Experimental,
+		ConfigKey:   "Experimental.UrlstoreEnabled",
+		DisabledErr: filestore.ErrUrlstoreNotEnabled,
+	})
+	Register(Feature{
+		Name:        Sharding,
+		Description: "Shard all new unixfs directories with a HAMT, not only large ones.",
+		Stage:       Experimental,
+		ConfigKey:   "Experimental.ShardingEnabled",
+	})
+	Register(Feature{
+		Name:        Libp2pStreamMounting,
+		Description: "Forward libp2p streams to local ports (ipfs p2p).",
+		Stage:       Experimental,
+		ConfigKey:   "Experimental.Libp2pStreamMounting",
+		DisabledErr: errors.New("libp2p stream mounting not enabled"),
+	})
+	Register(Feature{
+		Name:        QUIC,
+		Description: "Use the QUIC transport.",
+		Stage:       Experimental,
+		ConfigKey:   "Experimental.QUIC",
+	})
+	Register(Feature{
+		Name:        BitswapPeerRanking,
+		Description: "Rank bitswap providers by latency and delivery rate.",
+		Stage:       Experimental,
+		DisabledErr: errors.New("bitswap peer ranking is not enabled, run 'ipfs features enable bitswap-peer-ranking'"),
+	})
+	Register(Feature{
+		Name:        AcceleratedDHTClient,
+		Description: "Crawl the DHT to route straight to the closest peers of the keys.",
+		Stage:       Experimental,
+	})
+	Register(Feature{
+		Name:        LightClient,
+		Description: "Run without DHT, reproviding and gateway, routing through Routing.Delegates.",
+		Stage:       Experimental,
+	})
+
+	Register(Feature{
+		Name:        RawLeaves,
+		Description: "Use raw blocks for the leaf nodes of files (--raw-leaves).",
+		Stage:       Experimental,
+		Ungated:     true,
+	})
+	Register(Feature{
+		Name:        CidVersion,
+		Description: "Pick the CID version of the added files (--cid-version).",
+		Stage:       Experimental,
+		Ungated:     true,
+	})
+	Register(Feature{
+		Name:        HashFunction,
+		Description: "Pick the hash function of the added files (--hash).",
+		Stage:       Experimental,
+		Ungated:     true,
+	})
+	Register(Feature{
+		Name:        InlineBlocks,
+		Description: "Inline small blocks into their CIDs (ipfs add --inline).",
+		Stage:       Experimental,
+		Ungated:     true,
+	})
+	Register(Feature{
+		Name:        FileMetadata,
+		Description: "Store the mode and mtime of added files (ipfs add --preserve-mode, --preserve-mtime).",
+		Stage:       Experimental,
+		Ungated:     true,
+	})
+	Register(Feature{
+		Name:        MimeDetection,
+		Description: "Record the MIME types of added files (ipfs add --detect-mime).",
+		Stage:       Experimental,
+		Ungated:     true,
+	})
+	Register(Feature{
+		Name:        Dag,
+		Description: "Interact with IPLD documents (ipfs dag).",
+		Stage:       Experimental,
+		Ungated:     true,
+	})
+
+	Register(Feature{
+		Name:         BootstrapAddDefaultFlag,
+		Description:  "The --default flag of 'ipfs bootstrap add'.",
+		Stage:        Deprecated,
+		DeprecatedIn: "v0.4.5",
+		RemovedIn:    "v0.5.0",
+		Replacement:  "'ipfs bootstrap add default'",
+	})
+	Register(Feature{
+		Name:         BootstrapRmAllFlag,
+		Description:  "The --all flag of 'ipfs bootstrap rm'.",
+		Stage:        Deprecated,
+		DeprecatedIn: "v0.4.5",
+		RemovedIn:    "v0.5.0",
+		Replacement:  "'ipfs bootstrap rm all'",
+	})
+}
@@ -0,0 +1,227 @@
+// Package features keeps track of experimental and deprecated go-ipfs
+// features, whether they are enabled, and how long deprecated ones are going
+// to stick around.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+)
+
+var log = logging.Logger("features")
+
+// Stage describes how mature a feature is.
+type Stage int
+
+const (
+	// Experimental features are disabled by default and may change or go
+	// away without notice.
+	Experimental Stage = iota
+	// Deprecated features still work but are scheduled for removal.
+	Deprecated
+)
+
+func (s Stage) String() string {
+	switch s {
+	case Experimental:
+		return "experimental"
+	case Deprecated:
+		return "deprecated"
+	default:
+		return fmt.Sprintf("stage(%d)", int(s))
+	}
+}
+
+// Feature describes an experimental or deprecated feature.
+type Feature struct {
+	Name        string
+	Description string
+	Stage       Stage
+
+	// ConfigKey is the boolean config key recording whether an experimental
+	// feature is enabled. Defaults to "Features.<Name>".
+	ConfigKey string
+
+	// Ungated experimental features can be used without being enabled, the
+	// help of their commands and options only flags them as experimental.
+	Ungated bool
+
+	// DisabledErr is returned by Require when the feature is disabled.
+	DisabledErr error
+
+	// DeprecatedIn, RemovedIn and Replacement describe the removal timeline
+	// of deprecated features.
+	DeprecatedIn string
+	RemovedIn    string
+	Replacement  string
+}
+
+func (f Feature) configKey() string {
+	if f.ConfigKey != "" {
+		return f.ConfigKey
+	}
+	return "Features." + f.Name
+}
+
+// Annotation returns the note added to the help of the commands and options
+// of the feature.
+func (f Feature) Annotation() string {
+	switch f.Stage {
+	case Experimental:
+		return "(experimental)"
+	case Deprecated:
+		msg := "(deprecated"
+		if f.RemovedIn != "" {
+			msg += ", will be removed in " + f.RemovedIn
+		}
+		if f.Replacement != "" {
+			msg += ", use " + f.Replacement + " instead"
+		}
+		return msg + ")"
+	default:
+		return ""
+	}
+}
+
+// Warning returns the message shown when a deprecated feature is used.
+func (f Feature) Warning() string {
+	msg := fmt.Sprintf("%s is deprecated", f.Name)
+	if f.DeprecatedIn != "" {
+		msg += " since " + f.DeprecatedIn
+	}
+	if f.RemovedIn != "" {
+		msg += " and will be removed in " + f.RemovedIn
+	}
+	if f.Replacement != "" {
+		msg += ", use " + f.Replacement + " instead"
+	}
+	return msg
+}
+
+var (
+	lk       sync.RWMutex
+	registry = make(map[string]Feature)
+)
+
+// Register declares a feature. It panics if a feature with the same name has
+// already been registered, so it is meant to be called from init functions.
+func Register(f Feature) {
+	lk.Lock()
+	defer lk.Unlock()
+
+	if _, ok := registry[f.Name]; ok {
+		panic(fmt.Sprintf("feature %q registered twice", f.Name))
+	}
+	registry[f.Name] = f
+}
+
+// Get returns the feature registered under name.
+func Get(name string) (Feature, bool) {
+	lk.RLock()
+	defer lk.RUnlock()
+	f, ok := registry[name]
+	return f, ok
+}
+
+// All returns all registered features, sorted by name.
+func All() []Feature {
+	lk.RLock()
+	defer lk.RUnlock()
+
+	out := make([]Feature, 0, len(registry))
+	for _, f := range registry {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Help returns text, the help of a command or an option of the feature name,
+// annotated with the stage of the feature. It panics if the feature isn't
+// registered, so it is meant to be used in the declarations of the commands.
+func Help(name, text string) string {
+	f, ok := Get(name)
+	if !ok {
+		panic(fmt.Sprintf("unknown feature %q", name))
+	}
+	return text + " " + f.Annotation()
+}
+
+func lookup(name string) (Feature, error) {
+	f, ok := Get(name)
+	if !ok {
+		return Feature{}, fmt.Errorf("unknown feature %q", name)
+	}
+	return f, nil
+}
+
+// Enabled returns whether the experimental feature name is enabled in the
+// config of r. Deprecated and ungated features are always enabled, until they
+// are removed.
+func Enabled(r repo.Repo, name string) (bool, error) {
+	f, err := lookup(name)
+	if err != nil {
+		return false, err
+	}
+	if f.Stage == Deprecated || f.Ungated {
+		return true, nil
+	}
+
+	enabled := false
+	if err := repo.ConfigSection(r, f.configKey(), &enabled); err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+// SetEnabled enables or disables the experimental feature name in the config
+// of r.
+func SetEnabled(r repo.Repo, name string, enabled bool) error {
+	f, err := lookup(name)
+	if err != nil {
+		return err
+	}
+	if f.Stage != Experimental {
+		return fmt.Errorf("%s is %s, only experimental features can be toggled", name, f.Stage)
+	}
+	if f.Ungated {
+		return fmt.Errorf("%s can be used without being enabled", name)
+	}
+	return r.SetConfigKey(f.configKey(), enabled)
+}
+
+// Require returns an error if the experimental feature name is disabled.
+func Require(r repo.Repo, name string) error {
+	enabled, err := Enabled(r, name)
+	if err != nil || enabled {
+		return err
+	}
+	return DisabledError(name)
+}
+
+// DisabledError returns the error of the commands of the experimental feature
+// name when it is disabled.
+func DisabledError(name string) error {
+	f, _ := Get(name)
+	if f.DisabledErr != nil {
+		return f.DisabledErr
+	}
+	return fmt.Errorf("experimental feature %s is not enabled, run 'ipfs features enable %s'", name, name)
+}
+
+// WarnDeprecated logs that the deprecated feature name was used and returns
+// the warning.
+func WarnDeprecated(name string) string {
+	f, ok := Get(name)
+	if !ok {
+		return ""
+	}
+	w := f.Warning()
+	log.Warning(w)
+	return w
+}
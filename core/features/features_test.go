@@ -0,0 +1,72 @@
+package features
+
+import (
+	"testing"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+	common "github.com/ipfs/go-ipfs/repo/common"
+
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+)
+
+type testRepo struct {
+	repo.Mock
+	keys map[string]interface{}
+}
+
+func (r *testRepo) SetConfigKey(key string, value interface{}) error {
+	return common.MapSetKV(r.keys, key, value)
+}
+
+func (r *testRepo) GetConfigKey(key string) (interface{}, error) {
+	return common.MapGetKV(r.keys, key)
+}
+
+func TestToggleExperimental(t *testing.T) {
+	r := &testRepo{Mock: repo.Mock{C: config.Config{}}, keys: map[string]interface{}{}}
+
+	if err := Require(r, Urlstore); err == nil {
+		t.Fatal("urlstore should be disabled by default")
+	}
+	if err := SetEnabled(r, Urlstore, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := Require(r, Urlstore); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetEnabled(r, BootstrapRmAllFlag, false); err == nil {
+		t.Fatal("deprecated features can't be toggled")
+	}
+}
+
+func TestDeprecationWarning(t *testing.T) {
+	f, ok := Get(BootstrapAddDefaultFlag)
+	if !ok {
+		t.Fatal("missing feature")
+	}
+	want := "bootstrap-add-default-flag is deprecated since v0.4.5 and will be removed in v0.5.0, use 'ipfs bootstrap add default' instead"
+	if w := f.Warning(); w != want {
+		t.Fatalf("got %q", w)
+	}
+}
+
+func TestUngated(t *testing.T) {
+	r := &testRepo{Mock: repo.Mock{C: config.Config{}}, keys: map[string]interface{}{}}
+
+	if err := Require(r, RawLeaves); err != nil {
+		t.Fatalf("ungated features don't have to be enabled: %s", err)
+	}
+	if err := SetEnabled(r, RawLeaves, false); err == nil {
+		t.Fatal("ungated features can't be toggled")
+	}
+}
+
+func TestHelp(t *testing.T) {
+	if h := Help(RawLeaves, "Use raw blocks."); h != "Use raw blocks. (experimental)" {
+		t.Fatalf("got %q", h)
+	}
+	want := "Add default nodes. (deprecated, will be removed in v0.5.0, use 'ipfs bootstrap add default' instead)"
+	if h := Help(BootstrapAddDefaultFlag, "Add default nodes."); h != want {
+		t.Fatalf("got %q", h)
+	}
+}
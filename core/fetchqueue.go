@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	rp "github.com/ipfs/go-ipfs/exchange/reprovide"
+)
+
+// FetchPriority is the priority of a pin or of a provide, set with --priority.
+type FetchPriority int
+
+const (
+	// FetchPriorityHigh is for the urgent work, e.g. the update of a site.
+	FetchPriorityHigh FetchPriority = iota
+	// FetchPriorityNormal is the default.
+	FetchPriorityNormal
+	// FetchPriorityLow is for the background work, e.g. the bulk pin of a
+	// dataset.
+	FetchPriorityLow
+
+	numPriorities = 3
+)
+
+// fetchWeights are the shares of the fetches of each priority, when several
+// are waiting, like the default weights of the Swarm.QoS classes.
+var fetchWeights = [numPriorities]uint64{8, 4, 1}
+
+// FetchSlots is the number of batches of blocks fetched concurrently by the
+// pins.
+const FetchSlots = 4
+
+// ParseFetchPriority parses "high", "normal" or "low". The empty string is
+// FetchPriorityNormal.
+func ParseFetchPriority(s string) (FetchPriority, error) {
+	switch s {
+	case "high":
+		return FetchPriorityHigh, nil
+	case "normal", "":
+		return FetchPriorityNormal, nil
+	case "low":
+		return FetchPriorityLow, nil
+	default:
+		return FetchPriorityNormal, fmt.Errorf("invalid priority %q, expected high, normal or low", s)
+	}
+}
+
+func (p FetchPriority) String() string {
+	switch p {
+	case FetchPriorityHigh:
+		return "high"
+	case FetchPriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+// provide returns the priority of the provide queue for prio.
+func (p FetchPriority) provide() rp.Priority {
+	switch p {
+	case FetchPriorityHigh:
+		return rp.PriorityHigh
+	case FetchPriorityLow:
+		return rp.PriorityLow
+	default:
+		return rp.PriorityNormal
+	}
+}
+
+// FetchQueue schedules the batches of blocks fetched by the pins. When all
+// its slots are taken, the fetches waiting get the slots freed by weighted
+// turns of their priorities, so that an urgent pin isn't stuck behind a bulk
+// one, and a bulk one still progresses.
+type FetchQueue struct {
+	lk   sync.Mutex
+	free int
+	// waiting are the fetches waiting by priority, and pass the virtual
+	// time of each priority, advanced by the inverse of its weight at each
+	// turn.
+	waiting [numPriorities][]chan struct{}
+	pass    [numPriorities]uint64
+}
+
+// NewFetchQueue returns a FetchQueue running slots fetches at once.
+func NewFetchQueue(slots int) *FetchQueue {
+	return &FetchQueue{free: slots}
+}
+
+// Acquire waits for the turn of a fetch with priority prio, and returns the
+// function to call once it is done. A nil FetchQueue doesn't wait.
+func (fq *FetchQueue) Acquire(ctx context.Context, prio FetchPriority) (func(), error) {
+	if fq == nil {
+		return func() {}, nil
+	}
+	if prio < 0 || prio >= numPriorities {
+		prio = FetchPriorityNormal
+	}
+
+	fq.lk.Lock()
+	if fq.free > 0 && fq.idle() {
+		fq.free--
+		fq.lk.Unlock()
+		return fq.release, nil
+	}
+	if fq.idle() {
+		// nothing is owed to the priorities which aren't waiting
+		fq.pass = [numPriorities]uint64{}
+	} else if len(fq.waiting[prio]) == 0 {
+		// a priority which wasn't waiting doesn't get the turns it missed
+		fq.pass[prio] = maxUint64(fq.pass[prio], fq.minPass())
+	}
+	ch := make(chan struct{})
+	fq.waiting[prio] = append(fq.waiting[prio], ch)
+	fq.lk.Unlock()
+
+	select {
+	case <-ch:
+		return fq.release, nil
+	case <-ctx.Done():
+		fq.lk.Lock()
+		defer fq.lk.Unlock()
+		for i, w := range fq.waiting[prio] {
+			if w == ch {
+				fq.waiting[prio] = append(fq.waiting[prio][:i], fq.waiting[prio][i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// the turn was given meanwhile
+		fq.next()
+		return nil, ctx.Err()
+	}
+}
+
+func (fq *FetchQueue) release() {
+	fq.lk.Lock()
+	defer fq.lk.Unlock()
+	fq.next()
+}
+
+// next gives the freed slot to the priority waiting with the lowest virtual
+// time. The lock must be held.
+func (fq *FetchQueue) next() {
+	best := -1
+	for prio := range fq.waiting {
+		if len(fq.waiting[prio]) > 0 && (best < 0 || fq.pass[prio] < fq.pass[best]) {
+			best = prio
+		}
+	}
+	if best < 0 {
+		fq.free++
+		return
+	}
+
+	ch := fq.waiting[best][0]
+	fq.waiting[best] = fq.waiting[best][1:]
+	fq.pass[best] += fetchWeights[0] * fetchWeights[1] * fetchWeights[2] / fetchWeights[best]
+	close(ch)
+}
+
+// idle returns whether no fetch is waiting. The lock must be held.
+func (fq *FetchQueue) idle() bool {
+	for prio := range fq.waiting {
+		if len(fq.waiting[prio]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// minPass returns the lowest virtual time of the priorities waiting. The
+// lock must be held.
+func (fq *FetchQueue) minPass() uint64 {
+	var min uint64
+	found := false
+	for prio := range fq.waiting {
+		if len(fq.waiting[prio]) > 0 && (!found || fq.pass[prio] < min) {
+			min, found = fq.pass[prio], true
+		}
+	}
+	return min
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
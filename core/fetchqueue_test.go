@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseFetchPriority(t *testing.T) {
+	for s, expected := range map[string]FetchPriority{"high": FetchPriorityHigh, "normal": FetchPriorityNormal, "": FetchPriorityNormal, "low": FetchPriorityLow} {
+		p, err := ParseFetchPriority(s)
+		if err != nil || p != expected {
+			t.Errorf("expected %q to be %s, got %s (%v)", s, expected, p, err)
+		}
+	}
+	if _, err := ParseFetchPriority("urgent"); err == nil {
+		t.Error("expected an unknown priority to be rejected")
+	}
+}
+
+func TestFetchQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var fq *FetchQueue
+	if release, err := fq.Acquire(ctx, FetchPriorityLow); err != nil {
+		t.Fatal(err)
+	} else {
+		release()
+	}
+
+	fq = NewFetchQueue(1)
+	release, err := fq.Acquire(ctx, FetchPriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := func(n int) {
+		for i := 0; i < 200; i++ {
+			fq.lk.Lock()
+			w := len(fq.waiting[FetchPriorityHigh]) + len(fq.waiting[FetchPriorityNormal]) + len(fq.waiting[FetchPriorityLow])
+			fq.lk.Unlock()
+			if w == n {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("expected %d fetches to wait", n)
+	}
+
+	// a canceled fetch stops waiting
+	cctx, ccancel := context.WithCancel(ctx)
+	errCh := make(chan error)
+	go func() {
+		_, err := fq.Acquire(cctx, FetchPriorityHigh)
+		errCh <- err
+	}()
+	waiting(1)
+	ccancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("expected the fetch to be canceled, got %v", err)
+	}
+	waiting(0)
+
+	var lk sync.Mutex
+	var served []FetchPriority
+	var wg sync.WaitGroup
+	acquire := func(prio FetchPriority) {
+		defer wg.Done()
+		release, err := fq.Acquire(ctx, prio)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		lk.Lock()
+		served = append(served, prio)
+		lk.Unlock()
+		release()
+	}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go acquire(FetchPriorityHigh)
+	}
+	waiting(16)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go acquire(FetchPriorityLow)
+	}
+	waiting(20)
+
+	release()
+	wg.Wait()
+
+	// 8 turns of high for each turn of low
+	low := 0
+	for _, prio := range served[:11] {
+		if prio == FetchPriorityLow {
+			low++
+		}
+	}
+	if low != 2 {
+		t.Fatalf("expected 2 low priority fetches in the first 11, got %v", served)
+	}
+	if len(served) != 20 {
+		t.Fatalf("expected all the fetches to be served, got %d", len(served))
+	}
+
+	// the slot is free again
+	release, err = fq.Acquire(ctx, FetchPriorityLow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
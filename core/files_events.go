@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	gopath "path"
+	"strings"
+	"sync"
+)
+
+// filesEventBuffer is how many events a subscriber can lag behind before
+// events are dropped.
+const filesEventBuffer = 64
+
+// Operations of FilesEvents that aren't files commands.
+const (
+	// FilesEventPublish is sent when a new files API root is persisted.
+	FilesEventPublish = "publish"
+)
+
+// FilesEvent describes a change to the files API.
+type FilesEvent struct {
+	// Op is the operation, usually the name of the files command.
+	Op string
+	// Path is the changed path.
+	Path string
+	// From is the source path of moves.
+	From string `json:",omitempty"`
+	// Root is the CID of the files API root after the change, if it was
+	// flushed.
+	Root string `json:",omitempty"`
+}
+
+func (e FilesEvent) affects(p string) bool {
+	for _, ep := range []string{e.Path, e.From} {
+		if ep == "" {
+			continue
+		}
+		if ep == p || isFilesSubpath(ep, p) || isFilesSubpath(p, ep) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFilesSubpath returns true if p is strictly below dir.
+func isFilesSubpath(p, dir string) bool {
+	if dir == "/" {
+		return p != "/"
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+type filesSubscription struct {
+	path string
+	ch   chan FilesEvent
+}
+
+// FilesNotifier dispatches FilesEvents to subscribers.
+type FilesNotifier struct {
+	lk   sync.Mutex
+	subs map[*filesSubscription]struct{}
+}
+
+// NewFilesNotifier returns a FilesNotifier without subscribers.
+func NewFilesNotifier() *FilesNotifier {
+	return &FilesNotifier{subs: make(map[*filesSubscription]struct{})}
+}
+
+// Subscribe returns a channel receiving the events affecting p, its
+// children or its parents, until ctx is done. Events are dropped if the
+// subscriber doesn't keep up.
+func (fn *FilesNotifier) Subscribe(ctx context.Context, p string) <-chan FilesEvent {
+	sub := &filesSubscription{
+		path: gopath.Clean(p),
+		ch:   make(chan FilesEvent, filesEventBuffer),
+	}
+
+	fn.lk.Lock()
+	fn.subs[sub] = struct{}{}
+	fn.lk.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		fn.lk.Lock()
+		delete(fn.subs, sub)
+		close(sub.ch)
+		fn.lk.Unlock()
+	}()
+
+	return sub.ch
+}
+
+// Watched returns whether anyone is subscribed. It is false on a nil
+// FilesNotifier.
+func (fn *FilesNotifier) Watched() bool {
+	if fn == nil {
+		return false
+	}
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
+	return len(fn.subs) > 0
+}
+
+// Notify sends e to the interested subscribers. It is a no-op on a nil
+// FilesNotifier.
+func (fn *FilesNotifier) Notify(e FilesEvent) {
+	if fn == nil {
+		return
+	}
+	e.Path = gopath.Clean(e.Path)
+	if e.From != "" {
+		e.From = gopath.Clean(e.From)
+	}
+
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
+	for sub := range fn.subs {
+		if !e.affects(sub.path) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			log.Warningf("files watch subscriber for %s is too slow, dropping event", sub.path)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	gopath "path"
+	"sort"
+	"sync"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// DefaultFilesMirrorInterval is how often a mirror checks its source when no
+// interval is given.
+const DefaultFilesMirrorInterval = time.Minute
+
+// FilesEventMirror is sent when a mirror updates its mfs path.
+const FilesEventMirror = "mirror"
+
+var filesMirrorsKey = ds.NewKey("/local/filesmirrors")
+
+// filesMirrorRecord is a running mirror, as it is kept in the repo.
+type filesMirrorRecord struct {
+	Path     string
+	From     string
+	Interval time.Duration
+}
+
+func filesMirrorKey(p string) ds.Key {
+	return filesMirrorsKey.Child(ds.NewKey(p))
+}
+
+// FilesMirrorSource returns the CID of the root to mirror.
+type FilesMirrorSource func(ctx context.Context) (cid.Cid, error)
+
+// FilesMirrorStatus is the state of a mirror.
+type FilesMirrorStatus struct {
+	Path string
+	From string
+	// Root is the CID mirrored at Path, and Source the last one the source
+	// had, which Root is behind of while it is fetched.
+	Root   string `json:",omitempty"`
+	Source string `json:",omitempty"`
+	// LastCheck is when the source was last checked, and LastSync when
+	// Path was last updated.
+	LastCheck time.Time
+	LastSync  time.Time
+	// Lag is for how long the mirror has been behind its source, zero if it
+	// is up to date.
+	Lag time.Duration
+	// Error is the error of the last check or update, if it failed.
+	Error string `json:",omitempty"`
+}
+
+type filesMirror struct {
+	cancel context.CancelFunc
+
+	lk          sync.Mutex
+	status      FilesMirrorStatus
+	behindSince time.Time
+}
+
+func (m *filesMirror) update(f func(s *FilesMirrorStatus)) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	f(&m.status)
+}
+
+// FilesMirrors keeps mfs paths updated with the root of another node, like
+// the files API root of a node or the root published under an IPNS name.
+// The mirrored paths are read-only for the files commands. The mirrors are
+// kept in the repo until they are stopped, to be resumed when the daemon
+// restarts.
+type FilesMirrors struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lockRoot func() (*mfs.Root, func())
+	dag      ipld.DAGService
+	dstore   ds.Datastore
+	events   *FilesNotifier
+
+	lk      sync.Mutex
+	mirrors map[string]*filesMirror
+}
+
+// NewFilesMirrors returns the FilesMirrors of the files API root returned by
+// lockRoot, keeping the mirrors in d and running until ctx is done or it is
+// closed. lockRoot locks the root like IpfsNode.LockFilesRoot.
+func NewFilesMirrors(ctx context.Context, lockRoot func() (*mfs.Root, func()), dag ipld.DAGService, d ds.Datastore, events *FilesNotifier) *FilesMirrors {
+	ctx, cancel := context.WithCancel(ctx)
+	return &FilesMirrors{
+		ctx:      ctx,
+		cancel:   cancel,
+		lockRoot: lockRoot,
+		dag:      dag,
+		dstore:   d,
+		events:   events,
+		mirrors:  make(map[string]*filesMirror),
+	}
+}
+
+// Start mirrors the root returned by source at the mfs path p, checking it
+// every interval. from describes the source in the status of the mirror,
+// and is kept in the repo to get the source again in Resume. The path must
+// not overlap another mirror.
+func (fm *FilesMirrors) Start(p, from string, interval time.Duration, source FilesMirrorSource) error {
+	p = gopath.Clean(p)
+	if !gopath.IsAbs(p) || p == "/" {
+		return fmt.Errorf("invalid mirror path %q: expected an mfs path other than /", p)
+	}
+	if interval <= 0 {
+		interval = DefaultFilesMirrorInterval
+	}
+
+	fm.lk.Lock()
+	defer fm.lk.Unlock()
+	if mp, ok := fm.mirrored(p); ok {
+		return fmt.Errorf("%s overlaps the mirror %s", p, mp)
+	}
+
+	b, err := json.Marshal(filesMirrorRecord{Path: p, From: from, Interval: interval})
+	if err != nil {
+		return err
+	}
+	if err := fm.dstore.Put(filesMirrorKey(p), b); err != nil {
+		return err
+	}
+	fm.start(p, from, interval, source)
+	return nil
+}
+
+// Resume restarts the mirrors kept in the repo. source returns the source of
+// the root to mirror for the from of a mirror, as passed to Start. The
+// mirrors whose source fails are kept in the repo, to be resumed next time.
+func (fm *FilesMirrors) Resume(source func(from string) (FilesMirrorSource, error)) error {
+	res, err := fm.dstore.Query(dsq.Query{Prefix: filesMirrorsKey.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+
+	fm.lk.Lock()
+	defer fm.lk.Unlock()
+	for _, e := range entries {
+		var rec filesMirrorRecord
+		if err := json.Unmarshal(e.Value, &rec); err != nil {
+			log.Errorf("corrupt files mirror %s: %s", e.Key, err)
+			continue
+		}
+		if _, ok := fm.mirrored(rec.Path); ok {
+			continue
+		}
+		src, err := source(rec.From)
+		if err != nil {
+			log.Errorf("failed to resume the mirror of %s at %s: %s", rec.From, rec.Path, err)
+			continue
+		}
+		fm.start(rec.Path, rec.From, rec.Interval, src)
+	}
+	return nil
+}
+
+// start runs the mirror of p, fm.lk being held.
+func (fm *FilesMirrors) start(p, from string, interval time.Duration, source FilesMirrorSource) {
+	ctx, cancel := context.WithCancel(fm.ctx)
+	m := &filesMirror{
+		cancel: cancel,
+		status: FilesMirrorStatus{Path: p, From: from},
+	}
+	fm.mirrors[p] = m
+
+	fm.wg.Add(1)
+	go func() {
+		defer fm.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			fm.sync(ctx, m, source)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// sync updates the path of the mirror m if the root of its source changed.
+// The DAG of the new root is fetched before replacing the path, for the
+// mirror to always be complete.
+func (fm *FilesMirrors) sync(ctx context.Context, m *filesMirror, source FilesMirrorSource) {
+	c, err := source(ctx)
+	now := time.Now()
+
+	var p string
+	upToDate := false
+	m.update(func(s *FilesMirrorStatus) {
+		p = s.Path
+		s.LastCheck = now
+		if err != nil {
+			s.Error = err.Error()
+			return
+		}
+		s.Source = c.String()
+		if s.Root == s.Source {
+			s.Error = ""
+			m.behindSince = time.Time{}
+			upToDate = true
+			return
+		}
+		if m.behindSince.IsZero() {
+			m.behindSince = now
+		}
+	})
+	if err != nil || upToDate {
+		return
+	}
+
+	err = fm.put(ctx, p, c)
+	m.update(func(s *FilesMirrorStatus) {
+		if err != nil {
+			if ctx.Err() == nil {
+				s.Error = err.Error()
+			}
+			return
+		}
+		s.Root = c.String()
+		s.LastSync = time.Now()
+		s.Error = ""
+		if s.Root == s.Source {
+			m.behindSince = time.Time{}
+		}
+	})
+	if err != nil {
+		return
+	}
+
+	if fm.events.Watched() {
+		e := FilesEvent{Op: FilesEventMirror, Path: p}
+		root, unlock := fm.lockRoot()
+		if nd, err := root.GetDirectory().GetNode(); err == nil {
+			e.Root = nd.Cid().String()
+		}
+		unlock()
+		fm.events.Notify(e)
+	}
+}
+
+// put fetches the DAG of c and replaces the mfs path p with it.
+func (fm *FilesMirrors) put(ctx context.Context, p string, c cid.Cid) error {
+	if err := dag.FetchGraph(ctx, c, fm.dag); err != nil {
+		return err
+	}
+	nd, err := fm.dag.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	root, unlock := fm.lockRoot()
+	defer unlock()
+	dir, name := gopath.Dir(p), gopath.Base(p)
+	if dir != "/" {
+		if err := mfs.Mkdir(root, dir, mfs.MkdirOpts{Mkparents: true}); err != nil {
+			return err
+		}
+	}
+	parent, err := filesJournalDir(root, dir)
+	if err != nil {
+		return err
+	}
+	if err := parent.Unlink(name); err != nil && err != os.ErrNotExist {
+		return err
+	}
+	if err := parent.AddChild(name, nd); err != nil {
+		return err
+	}
+	return mfs.FlushPath(root, p)
+}
+
+// Stop stops mirroring the mfs path p, which keeps its last content and
+// can be modified again.
+func (fm *FilesMirrors) Stop(p string) error {
+	p = gopath.Clean(p)
+
+	fm.lk.Lock()
+	defer fm.lk.Unlock()
+	m, ok := fm.mirrors[p]
+	if !ok {
+		return fmt.Errorf("%s is not mirrored", p)
+	}
+	if err := fm.dstore.Delete(filesMirrorKey(p)); err != nil && err != ds.ErrNotFound {
+		return err
+	}
+	m.cancel()
+	delete(fm.mirrors, p)
+	return nil
+}
+
+// Status returns the state of the mirrors, sorted by path.
+func (fm *FilesMirrors) Status() []FilesMirrorStatus {
+	fm.lk.Lock()
+	defer fm.lk.Unlock()
+
+	out := make([]FilesMirrorStatus, 0, len(fm.mirrors))
+	for _, m := range fm.mirrors {
+		m.lk.Lock()
+		s := m.status
+		if !m.behindSince.IsZero() {
+			s.Lag = time.Since(m.behindSince)
+		}
+		m.lk.Unlock()
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// Mirrored returns the path of the mirror the mfs path p is, is below or
+// contains, if there is one. It is false on a nil FilesMirrors.
+func (fm *FilesMirrors) Mirrored(p string) (string, bool) {
+	if fm == nil {
+		return "", false
+	}
+	fm.lk.Lock()
+	defer fm.lk.Unlock()
+	return fm.mirrored(gopath.Clean(p))
+}
+
+func (fm *FilesMirrors) mirrored(p string) (string, bool) {
+	for mp := range fm.mirrors {
+		if mp == p || isFilesSubpath(p, mp) || isFilesSubpath(mp, p) {
+			return mp, true
+		}
+	}
+	return "", false
+}
+
+// Close stops the mirrors, and waits for them to stop. They are kept in the
+// repo.
+func (fm *FilesMirrors) Close() error {
+	fm.cancel()
+	fm.wg.Wait()
+	return nil
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+	datastore "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	syncds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	dagtest "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag/test"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+func TestFilesMirrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := dagtest.Mock()
+
+	root, err := mfs.NewRoot(ctx, ds, ft.EmptyDirNode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDir := func(data string) cid.Cid {
+		file := dag.NodeWithData(ft.FilePBData([]byte(data), uint64(len(data))))
+		dir := ft.EmptyDirNode()
+		if err := dir.AddNodeLink("file", file); err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.AddMany(ctx, []ipld.Node{file, dir}); err != nil {
+			t.Fatal(err)
+		}
+		return dir.Cid()
+	}
+	first, second := newDir("first"), newDir("second")
+
+	var lk sync.Mutex
+	current, sourceErr := first, error(nil)
+	source := func(ctx context.Context) (cid.Cid, error) {
+		lk.Lock()
+		defer lk.Unlock()
+		return current, sourceErr
+	}
+	setSource := func(c cid.Cid, err error) {
+		lk.Lock()
+		defer lk.Unlock()
+		current, sourceErr = c, err
+	}
+	waitStatus := func(fm *FilesMirrors, ok func(s FilesMirrorStatus) bool) FilesMirrorStatus {
+		var st []FilesMirrorStatus
+		for i := 0; i < 200; i++ {
+			st = fm.Status()
+			if len(st) == 1 && ok(st[0]) {
+				return st[0]
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("unexpected mirror status: %+v", st)
+		return FilesMirrorStatus{}
+	}
+
+	lockRoot := func() (*mfs.Root, func()) { return root, func() {} }
+	d := syncds.MutexWrap(datastore.NewMapDatastore())
+	fm := NewFilesMirrors(ctx, lockRoot, ds, d, NewFilesNotifier())
+	if err := fm.Start("/", "test", 0, source); err == nil {
+		t.Fatal("expected the files root to be rejected")
+	}
+	if err := fm.Start("/a/mirror", "test", 10*time.Millisecond, source); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Start("/a", "test", 0, source); err == nil {
+		t.Fatal("expected the overlapping mirror to be rejected")
+	}
+
+	st := waitStatus(fm, func(s FilesMirrorStatus) bool { return s.Root == first.String() })
+	if st.Source != first.String() || st.Lag != 0 || st.Error != "" || st.From != "test" {
+		t.Fatalf("unexpected mirror status: %+v", st)
+	}
+	fsn, err := mfs.Lookup(root, "/a/mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd, err := fsn.GetNode(); err != nil || !nd.Cid().Equals(first) {
+		t.Fatalf("expected %s to be mirrored, got %v (%v)", first, nd, err)
+	}
+
+	for p, mirrored := range map[string]bool{"/a/mirror/file": true, "/a": true, "/": true, "/a/other": false, "/a/mirrored": false} {
+		if _, ok := fm.Mirrored(p); ok != mirrored {
+			t.Errorf("expected Mirrored(%s) to be %v", p, mirrored)
+		}
+	}
+
+	// the mirror is resumed by the next FilesMirrors of the repo
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fm = NewFilesMirrors(ctx, lockRoot, ds, d, NewFilesNotifier())
+	err = fm.Resume(func(from string) (FilesMirrorSource, error) {
+		if from != "test" {
+			t.Fatalf("unexpected mirror source %q", from)
+		}
+		return source, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setSource(second, nil)
+	waitStatus(fm, func(s FilesMirrorStatus) bool { return s.Root == second.String() })
+
+	setSource(cid.Cid{}, errors.New("unreachable"))
+	st = waitStatus(fm, func(s FilesMirrorStatus) bool { return s.Error == "unreachable" })
+	if st.Root != second.String() {
+		t.Fatalf("expected the mirror to be kept, got %+v", st)
+	}
+
+	if err := fm.Stop("/a/mirror/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Stop("/a/mirror"); err == nil {
+		t.Fatal("expected the mirror to be stopped")
+	}
+	if _, ok := fm.Mirrored("/a/mirror"); ok {
+		t.Fatal("expected the path not to be mirrored anymore")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a stopped mirror isn't resumed
+	fm = NewFilesMirrors(ctx, lockRoot, ds, d, NewFilesNotifier())
+	err = fm.Resume(func(from string) (FilesMirrorSource, error) { return source, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fm.Status(); len(st) != 0 {
+		t.Fatalf("expected the stopped mirror not to be resumed, got %+v", st)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,311 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	gopath "path"
+	"sort"
+	"sync"
+	"time"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+var (
+	filesRootKey    = ds.NewKey("/local/filesroot")
+	filesJournalKey = ds.NewKey("/local/filesjournal")
+)
+
+// DefaultFilesFlushInterval is how often unflushed files API changes are
+// flushed when Mfs.WriteBack is enabled and no Mfs.FlushInterval is set.
+const DefaultFilesFlushInterval = time.Second
+
+// Operations recorded in the files API journal.
+const (
+	FilesJournalPut = "put"
+	FilesJournalRm  = "rm"
+)
+
+// FilesJournalEntry is an unflushed change to the files API root.
+type FilesJournalEntry struct {
+	Op   string
+	Path string
+	Cid  string `json:",omitempty"`
+}
+
+type filesWriteBackConfig struct {
+	WriteBack     bool
+	FlushInterval string
+}
+
+// FilesWriteBack batches files API changes. When enabled, files commands
+// don't flush their changes by default. Instead, they record them in a
+// journal kept in the repo, and the root is flushed periodically. The
+// journal is replayed on startup if the node didn't shut down cleanly.
+type FilesWriteBack struct {
+	lockRoot func() (*mfs.Root, func())
+	ds       ds.Datastore
+
+	enabled  bool
+	interval time.Duration
+
+	lk  sync.Mutex
+	seq uint64
+
+	closing chan struct{}
+	closed  chan struct{}
+}
+
+func newFilesWriteBack(r repo.Repo, d ds.Datastore, lockRoot func() (*mfs.Root, func())) (*FilesWriteBack, error) {
+	cfg := filesWriteBackConfig{}
+	if err := repo.ConfigSection(r, "Mfs", &cfg); err != nil {
+		return nil, err
+	}
+
+	interval := DefaultFilesFlushInterval
+	if cfg.FlushInterval != "" {
+		d, err := time.ParseDuration(cfg.FlushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Mfs.FlushInterval: %s", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid Mfs.FlushInterval: must be positive")
+		}
+		interval = d
+	}
+
+	wb := &FilesWriteBack{
+		lockRoot: lockRoot,
+		ds:       d,
+		enabled:  cfg.WriteBack,
+		interval: interval,
+		closing:  make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+
+	if !wb.enabled {
+		close(wb.closed)
+		return wb, nil
+	}
+
+	go wb.loop()
+	return wb, nil
+}
+
+// Enabled returns whether files API changes are written back lazily.
+func (wb *FilesWriteBack) Enabled() bool {
+	return wb != nil && wb.enabled
+}
+
+// Record journals an unflushed change. It does nothing unless write-back is
+// enabled.
+func (wb *FilesWriteBack) Record(e FilesJournalEntry) error {
+	if !wb.Enabled() {
+		return nil
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	wb.seq++
+	return wb.ds.Put(filesJournalKey.ChildString(fmt.Sprintf("%020d", wb.seq)), b)
+}
+
+// RecordPut journals that the node nd was written at path p.
+func (wb *FilesWriteBack) RecordPut(p string, nd ipld.Node) error {
+	return wb.Record(FilesJournalEntry{Op: FilesJournalPut, Path: p, Cid: nd.Cid().String()})
+}
+
+// RecordRm journals that path p was removed.
+func (wb *FilesWriteBack) RecordRm(p string) error {
+	return wb.Record(FilesJournalEntry{Op: FilesJournalRm, Path: p})
+}
+
+// Flush flushes the whole files API root, persists it and truncates the
+// journal.
+func (wb *FilesWriteBack) Flush() error {
+	// the root is locked first, like the files commands do before
+	// recording their changes
+	root, unlock := wb.lockRoot()
+	defer unlock()
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+
+	if wb.seq == 0 {
+		return nil
+	}
+	if err := flushFilesRoot(root, wb.ds); err != nil {
+		return err
+	}
+	if err := clearFilesJournal(wb.ds); err != nil {
+		return err
+	}
+	wb.seq = 0
+	return nil
+}
+
+func (wb *FilesWriteBack) loop() {
+	defer close(wb.closed)
+
+	ticker := time.NewTicker(wb.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := wb.Flush(); err != nil {
+				log.Errorf("failed to flush files root: %s", err)
+			}
+		case <-wb.closing:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flush and flushes outstanding changes.
+func (wb *FilesWriteBack) Close() error {
+	if !wb.Enabled() {
+		return nil
+	}
+	close(wb.closing)
+	<-wb.closed
+	return wb.Flush()
+}
+
+// flushFilesRoot writes the root directory to the DAG and stores its CID
+// right away, instead of waiting for the root republisher.
+func flushFilesRoot(root *mfs.Root, d ds.Datastore) error {
+	if err := mfs.FlushPath(root, "/"); err != nil {
+		return err
+	}
+	nd, err := root.GetDirectory().GetNode()
+	if err != nil {
+		return err
+	}
+	return d.Put(filesRootKey, nd.Cid().Bytes())
+}
+
+func clearFilesJournal(d ds.Datastore) error {
+	entries, err := readFilesJournal(d)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := d.Delete(e.key); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+type filesJournalRecord struct {
+	key ds.Key
+	FilesJournalEntry
+}
+
+func readFilesJournal(d ds.Datastore) ([]filesJournalRecord, error) {
+	res, err := d.Query(dsq.Query{Prefix: filesJournalKey.String()})
+	if err != nil {
+		return nil, err
+	}
+	all, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]filesJournalRecord, 0, len(all))
+	for _, r := range all {
+		rec := filesJournalRecord{key: ds.NewKey(r.Key)}
+		if err := json.Unmarshal(r.Value, &rec.FilesJournalEntry); err != nil {
+			return nil, fmt.Errorf("corrupt files journal entry %s: %s", r.Key, err)
+		}
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key.String() < out[j].key.String() })
+	return out, nil
+}
+
+// replayFilesJournal applies the changes that were not flushed before the
+// node stopped, then flushes the root and truncates the journal.
+func replayFilesJournal(ctx context.Context, root *mfs.Root, dag ipld.DAGService, d ds.Datastore) error {
+	entries, err := readFilesJournal(d)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	log.Infof("replaying %d unflushed files API changes", len(entries))
+	for _, e := range entries {
+		if err := replayFilesJournalEntry(ctx, root, dag, e.FilesJournalEntry); err != nil {
+			log.Errorf("failed to replay files journal entry %s %s: %s", e.Op, e.Path, err)
+		}
+	}
+
+	if err := flushFilesRoot(root, d); err != nil {
+		return err
+	}
+	return clearFilesJournal(d)
+}
+
+func replayFilesJournalEntry(ctx context.Context, root *mfs.Root, dag ipld.DAGService, e FilesJournalEntry) error {
+	p := gopath.Clean(e.Path)
+	if p == "/" || !gopath.IsAbs(p) {
+		return fmt.Errorf("invalid path %q", e.Path)
+	}
+	dir, name := gopath.Dir(p), gopath.Base(p)
+
+	switch e.Op {
+	case FilesJournalPut:
+		c, err := cid.Decode(e.Cid)
+		if err != nil {
+			return err
+		}
+		nd, err := dag.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		if dir != "/" {
+			if err := mfs.Mkdir(root, dir, mfs.MkdirOpts{Mkparents: true}); err != nil {
+				return err
+			}
+		}
+		parent, err := filesJournalDir(root, dir)
+		if err != nil {
+			return err
+		}
+		if err := parent.Unlink(name); err != nil && err != os.ErrNotExist {
+			return err
+		}
+		return parent.AddChild(name, nd)
+	case FilesJournalRm:
+		parent, err := filesJournalDir(root, dir)
+		if err != nil {
+			return err
+		}
+		return parent.Unlink(name)
+	default:
+		return fmt.Errorf("unknown operation %q", e.Op)
+	}
+}
+
+func filesJournalDir(root *mfs.Root, p string) (*mfs.Directory, error) {
+	fsn, err := mfs.Lookup(root, p)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a directory", p)
+	}
+	return dir, nil
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+
+	wss "github.com/ipfs/go-ipfs/p2p/wss"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+)
+
+// HTTPSKey is the config section of the HTTPS listeners of the API and the
+// gateway.
+const HTTPSKey = "HTTPS"
+
+// HTTPSConfig is read from HTTPS: the API and the gateway are also served
+// over HTTPS on the API and Gateway addresses, with the certificate read
+// from CertFile and KeyFile, or obtained with AutoCert.
+type HTTPSConfig struct {
+	API      []string
+	Gateway  []string
+	CertFile string
+	KeyFile  string
+	AutoCert *AutoCertConfig
+}
+
+// HTTPS terminates the TLS connections of the HTTPS listeners of the API
+// and the gateway.
+type HTTPS struct {
+	Config HTTPSConfig
+
+	certs wss.Certificates
+	conf  *tls.Config
+}
+
+// NewHTTPS returns the HTTPS listeners of the config of r, nil if it has no
+// HTTPS address. The certificate is obtained in the background when
+// AutoCert is set, the handshakes failing until it is.
+func NewHTTPS(r repo.Repo) (*HTTPS, error) {
+	var cfg HTTPSConfig
+	if err := repo.ConfigSection(r, HTTPSKey, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %s", HTTPSKey, err)
+	}
+	if len(cfg.API) == 0 && len(cfg.Gateway) == 0 {
+		return nil, nil
+	}
+
+	certs, err := loadCertificates(HTTPSKey, cfg.CertFile, cfg.KeyFile, cfg.AutoCert)
+	if err != nil {
+		return nil, err
+	}
+	if certs == nil {
+		return nil, fmt.Errorf("%s: the HTTPS addresses need a certificate, set CertFile and KeyFile or AutoCert", HTTPSKey)
+	}
+
+	return &HTTPS{
+		Config: cfg,
+		certs:  certs,
+		conf: &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		},
+	}, nil
+}
+
+// Listen listens on the multiaddr addr, returning the listener, whose
+// connections are terminated with TLSConfig, and the address it listens on.
+func (h *HTTPS) Listen(addr string) (net.Listener, ma.Multiaddr, error) {
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: invalid address %q: %s", HTTPSKey, addr, err)
+	}
+	l, maddr, err := ListenHTTP(maddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: cannot listen on %s: %s", HTTPSKey, addr, err)
+	}
+	return l, maddr, nil
+}
+
+// ListenHTTP listens on maddr for an HTTP server, returning the address
+// listened on. Unlike the one of manet.Listen, the listener accepts the
+// connections of the network themselves, whose socket buffers can be set.
+func ListenHTTP(maddr ma.Multiaddr) (net.Listener, ma.Multiaddr, error) {
+	network, addr, err := manet.DialArgs(maddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if maddr, err = manet.FromNetAddr(l.Addr()); err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	return l, maddr, nil
+}
+
+// TLSConfig returns the TLS config of the HTTPS listeners.
+func (h *HTTPS) TLSConfig() *tls.Config {
+	return h.conf.Clone()
+}
+
+// Close stops renewing the certificate.
+func (h *HTTPS) Close() error {
+	if c, ok := h.certs.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
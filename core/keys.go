@@ -0,0 +1,22 @@
+package core
+
+import (
+	repo "github.com/ipfs/go-ipfs/repo"
+)
+
+// DefaultKeyKey is the config key of the name of the key 'ipfs name publish'
+// uses when it isn't given one, set with 'ipfs key use'.
+const DefaultKeyKey = "Ipns.DefaultKey"
+
+// DefaultKey returns the name of the default key of r, "self" if none was
+// set.
+func DefaultKey(r repo.Repo) (string, error) {
+	var name string
+	if err := repo.ConfigSection(r, DefaultKeyKey, &name); err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "self", nil
+	}
+	return name, nil
+}
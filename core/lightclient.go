@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+
+	features "github.com/ipfs/go-ipfs/core/features"
+	delegated "github.com/ipfs/go-ipfs/p2p/delegated"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// DelegatesKey is the config key of the delegates routing for light clients,
+// see the light-client feature.
+const DelegatesKey = "Routing.Delegates"
+
+// The connection manager of a light client keeps at most these connections,
+// lower watermarks in Swarm.ConnMgr are kept.
+const (
+	lightClientLowWater  = 8
+	lightClientHighWater = 16
+)
+
+// LightClientProfile is the name of the config profile of light clients.
+const LightClientProfile = "lowpower+"
+
+func init() {
+	lowpower := config.Profiles["lowpower"]
+	configProfiles[LightClientProfile] = ConfigProfile{
+		Description: `Runs the node as a light client for IoT and mobile devices, on top of
+the lowpower profile: no DHT, reproviding or gateway, few connections, and
+the providers and peers looked up through Routing.Delegates.`,
+		Transform: lowpower.Transform,
+		Apply: func(r repo.Repo) error {
+			return features.SetEnabled(r, features.LightClient, true)
+		},
+	}
+}
+
+// LightClientDelegates returns the delegates configured in r.
+func LightClientDelegates(r repo.Repo) ([]string, error) {
+	var delegates []string
+	if err := repo.ConfigSection(r, DelegatesKey, &delegates); err != nil {
+		return nil, err
+	}
+	return delegates, nil
+}
+
+// DelegatedRoutingOption returns a RoutingOption routing through the HTTP API
+// of the delegates, see the delegated package.
+func DelegatedRoutingOption(delegates []string) RoutingOption {
+	return func(ctx context.Context, host p2phost.Host, dstore ds.Batching, validator record.Validator) (routing.IpfsRouting, error) {
+		return delegated.New(delegates)
+	}
+}
+
+// LightClient returns whether the node runs as a light client, see the
+// light-client feature.
+func (n *IpfsNode) LightClient() bool {
+	return n.lightClient
+}
+
+// lightClientConnMgr lowers the watermarks of cfg to the ones of a light
+// client.
+func lightClientConnMgr(cfg config.ConnMgr) config.ConnMgr {
+	if cfg.Type != "basic" {
+		cfg = config.ConnMgr{
+			Type:        "basic",
+			GracePeriod: config.DefaultConnMgrGracePeriod.String(),
+		}
+	}
+	if cfg.LowWater <= 0 || cfg.LowWater > lightClientLowWater {
+		cfg.LowWater = lightClientLowWater
+	}
+	if cfg.HighWater <= 0 || cfg.HighWater > lightClientHighWater {
+		cfg.HighWater = lightClientHighWater
+	}
+	return cfg
+}
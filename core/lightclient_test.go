@@ -0,0 +1,55 @@
+package core
+
+import (
+	"testing"
+
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+)
+
+func TestLightClientConnMgr(t *testing.T) {
+	for _, tc := range []struct {
+		in, out config.ConnMgr
+	}{
+		{
+			in:  config.ConnMgr{Type: "basic", LowWater: 600, HighWater: 900, GracePeriod: "20s"},
+			out: config.ConnMgr{Type: "basic", LowWater: lightClientLowWater, HighWater: lightClientHighWater, GracePeriod: "20s"},
+		},
+		{
+			in:  config.ConnMgr{Type: "basic", LowWater: 2, HighWater: 4, GracePeriod: "1m"},
+			out: config.ConnMgr{Type: "basic", LowWater: 2, HighWater: 4, GracePeriod: "1m"},
+		},
+		{
+			in:  config.ConnMgr{Type: "none"},
+			out: config.ConnMgr{Type: "basic", LowWater: lightClientLowWater, HighWater: lightClientHighWater, GracePeriod: config.DefaultConnMgrGracePeriod.String()},
+		},
+	} {
+		if out := lightClientConnMgr(tc.in); out != tc.out {
+			t.Errorf("%+v: expected %+v, got %+v", tc.in, tc.out, out)
+		}
+	}
+}
+
+func TestLightClientProfile(t *testing.T) {
+	p, ok := LookupConfigProfile(LightClientProfile)
+	if !ok {
+		t.Fatal("light client profile not found")
+	}
+	if p.Apply == nil {
+		t.Fatal("expected the light client profile to enable the light client")
+	}
+
+	cfg := &config.Config{}
+	if err := p.Transform(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Reprovider.Interval != "0" {
+		t.Fatalf("expected the lowpower settings, got %+v", cfg.Reprovider)
+	}
+
+	if _, ok := LookupConfigProfile("lowpower"); !ok {
+		t.Fatal("expected the go-ipfs-config profiles to be found")
+	}
+	if _, ok := ConfigProfiles()[LightClientProfile]; !ok {
+		t.Fatal("expected the light client profile to be listed")
+	}
+}
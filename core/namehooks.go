@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+)
+
+// PublishHooksKey is the config key of the hooks run after the node published
+// or republished an IPNS record.
+const PublishHooksKey = "Ipns.PublishHooks"
+
+const defaultPublishHookTimeout = 30 * time.Second
+
+// PublishHookConfig is a hook of Ipns.PublishHooks, either running a command
+// or POSTing to a webhook.
+type PublishHookConfig struct {
+	// Exec is the command run, with its arguments. The event is passed in the
+	// IPNS_KEY, IPNS_NAME, IPNS_VALUE, IPNS_SEQUENCE and IPNS_REPUBLISH
+	// environment variables.
+	Exec []string
+	// URL is the webhook the event is POSTed to, as JSON.
+	URL string
+	// Timeout is the time the hook is given to complete, 30s by default.
+	Timeout string
+}
+
+// PublishHookEvent is the event passed to the hooks of Ipns.PublishHooks.
+type PublishHookEvent struct {
+	// Key is the name of the key of the keystore the record is published
+	// with, "self" for the key of the node.
+	Key       string
+	Name      string
+	Value     string
+	Sequence  uint64
+	Republish bool
+}
+
+type publishHook struct {
+	PublishHookConfig
+	timeout time.Duration
+}
+
+type publishHooks struct {
+	hooks   []publishHook
+	keyName func(peer.ID) string
+	client  *http.Client
+}
+
+// readPublishHooks reads the hooks of Ipns.PublishHooks, nil if there are
+// none.
+func readPublishHooks(r repo.Repo) (*publishHooks, error) {
+	var cfg []PublishHookConfig
+	if err := repo.ConfigSection(r, PublishHooksKey, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %s", PublishHooksKey, err)
+	}
+	return newPublishHooks(cfg)
+}
+
+func newPublishHooks(cfg []PublishHookConfig) (*publishHooks, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	h := &publishHooks{client: new(http.Client)}
+	for i, c := range cfg {
+		if (len(c.Exec) == 0) == (c.URL == "") {
+			return nil, fmt.Errorf("%s[%d]: expected either Exec or URL", PublishHooksKey, i)
+		}
+		hook := publishHook{PublishHookConfig: c, timeout: defaultPublishHookTimeout}
+		if c.Timeout != "" {
+			d, err := time.ParseDuration(c.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("%s[%d]: invalid timeout %q", PublishHooksKey, i, c.Timeout)
+			}
+			hook.timeout = d
+		}
+		h.hooks = append(h.hooks, hook)
+	}
+	return h, nil
+}
+
+// publish is the namesys.PublishHook running the hooks, in the background not
+// to delay the publishes.
+func (h *publishHooks) publish(ev namesys.PublishEvent) {
+	go h.run(ev)
+}
+
+// run runs the hooks one after the other, logging their failures.
+func (h *publishHooks) run(ev namesys.PublishEvent) {
+	e := PublishHookEvent{
+		Name:      peer.IDB58Encode(ev.Name),
+		Value:     ev.Value.String(),
+		Sequence:  ev.Sequence,
+		Republish: ev.Republish,
+	}
+	if h.keyName != nil {
+		e.Key = h.keyName(ev.Name)
+	}
+	for _, hook := range h.hooks {
+		if err := h.runHook(hook, e); err != nil {
+			log.Errorf("publish hook for /ipns/%s failed: %s", e.Name, err)
+		}
+	}
+}
+
+func (h *publishHooks) runHook(hook publishHook, e PublishHookEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout)
+	defer cancel()
+
+	if hook.URL == "" {
+		cmd := exec.CommandContext(ctx, hook.Exec[0], hook.Exec[1:]...)
+		cmd.Env = append(os.Environ(),
+			"IPNS_KEY="+e.Key,
+			"IPNS_NAME="+e.Name,
+			"IPNS_VALUE="+e.Value,
+			"IPNS_SEQUENCE="+strconv.FormatUint(e.Sequence, 10),
+			"IPNS_REPUBLISH="+strconv.FormatBool(e.Republish),
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %s: %s", hook.Exec[0], err, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", hook.URL, resp.Status)
+	}
+	return nil
+}
+
+// keyName returns the name of the key of the keystore whose ID is id, "" if
+// there is none.
+func (n *IpfsNode) keyName(id peer.ID) string {
+	if id == n.Identity {
+		return "self"
+	}
+	ks := n.Repo.Keystore()
+	names, err := ks.List()
+	if err != nil {
+		return ""
+	}
+	for _, name := range names {
+		k, err := ks.Get(name)
+		if err != nil {
+			continue
+		}
+		if kid, err := peer.IDFromPrivateKey(k); err == nil && kid == id {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	namesys "github.com/ipfs/go-ipfs/namesys"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
+)
+
+func TestPublishHooksConfig(t *testing.T) {
+	for _, cfg := range [][]PublishHookConfig{
+		{{}},
+		{{Exec: []string{"true"}, URL: "http://localhost"}},
+		{{URL: "http://localhost", Timeout: "soon"}},
+		{{URL: "http://localhost", Timeout: "-1s"}},
+	} {
+		if _, err := newPublishHooks(cfg); err == nil {
+			t.Errorf("expected %+v to be refused", cfg)
+		}
+	}
+
+	h, err := newPublishHooks(nil)
+	if err != nil || h != nil {
+		t.Fatalf("expected no hooks, got %v, %v", h, err)
+	}
+	h, err = newPublishHooks([]PublishHookConfig{{URL: "http://localhost", Timeout: "5s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.hooks[0].timeout.Seconds() != 5 {
+		t.Fatalf("unexpected timeout %s", h.hooks[0].timeout)
+	}
+}
+
+func TestPublishHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the exec hook needs sh")
+	}
+
+	var received []PublishHookEvent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e PublishHookEvent
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		received = append(received, e)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "publish-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	h, err := newPublishHooks([]PublishHookConfig{
+		{Exec: []string{"sh", "-c", `echo "$IPNS_KEY $IPNS_NAME $IPNS_VALUE $IPNS_SEQUENCE $IPNS_REPUBLISH" > "$0"`, out}},
+		{Exec: []string{"false"}},
+		{URL: ts.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := peer.ID("peer")
+	h.keyName = func(p peer.ID) string {
+		if p == id {
+			return "self"
+		}
+		return ""
+	}
+
+	// the failing hook doesn't stop the others
+	value := path.Path("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	h.run(namesys.PublishEvent{Name: id, Value: value, Sequence: 3, Republish: true})
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "self " + peer.IDB58Encode(id) + " " + value.String() + " 3 true"
+	if got := strings.TrimSpace(string(b)); got != expected {
+		t.Fatalf("expected the command to get %q, got %q", expected, got)
+	}
+
+	e := PublishHookEvent{Key: "self", Name: peer.IDB58Encode(id), Value: value.String(), Sequence: 3, Republish: true}
+	if len(received) != 1 || received[0] != e {
+		t.Fatalf("expected the webhook to get %+v, got %+v", e, received)
+	}
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+)
+
+// States of the pin jobs.
+const (
+	// PinJobFetching is the state of the jobs fetching the DAGs to pin.
+	PinJobFetching = "fetching"
+	// PinJobPinned is the state of the jobs which pinned their DAGs.
+	PinJobPinned = "pinned"
+	// PinJobFailed is the state of the jobs which failed, with their error.
+	PinJobFailed = "failed"
+)
+
+// pinJobsKept is how many finished pin jobs are kept, for their state to be
+// queried once they are finished.
+const pinJobsKept = 128
+
+// pinJobInterval is how often the progress of the pin jobs is sent to their
+// watchers.
+const pinJobInterval = 500 * time.Millisecond
+
+// PinProgress is the progress of a pin job, updated by the pin with its
+// methods, which can be called concurrently.
+type PinProgress struct {
+	blocks uint64
+	bytes  uint64
+	depth  uint64
+}
+
+// Fetched records a block of size bytes.
+func (p *PinProgress) Fetched(size int) {
+	atomic.AddUint64(&p.blocks, 1)
+	atomic.AddUint64(&p.bytes, uint64(size))
+}
+
+// Blocks returns the number of blocks recorded.
+func (p *PinProgress) Blocks() uint64 {
+	return atomic.LoadUint64(&p.blocks)
+}
+
+// SetDepth records the depth of the blocks being fetched.
+func (p *PinProgress) SetDepth(depth int) {
+	atomic.StoreUint64(&p.depth, uint64(depth))
+}
+
+// PinEvent is the state of a pin job.
+type PinEvent struct {
+	ID    string
+	State string
+	// Blocks and Bytes count the blocks of the DAGs fetched, or found in
+	// the repo, and Depth is the depth of the ones being fetched.
+	Blocks uint64
+	Bytes  uint64
+	Depth  uint64
+	// Pins are the pinned CIDs, once the job is PinJobPinned.
+	Pins  []string `json:",omitempty"`
+	Error string   `json:",omitempty"`
+}
+
+// Finished returns whether the job is done, pinned or failed.
+func (e PinEvent) Finished() bool {
+	return e.State != PinJobFetching
+}
+
+type pinJob struct {
+	// first for the 64-bit alignment of its counters
+	progress PinProgress
+
+	lk    sync.Mutex
+	state PinEvent
+	subs  map[chan PinEvent]struct{}
+}
+
+// publish updates the state of the job with its progress, and sends it to
+// the watchers if it changed. The watchers only get the last state, if they
+// don't keep up.
+func (j *pinJob) publish(update func(*PinEvent)) {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	prev := j.state
+	j.state.Blocks = atomic.LoadUint64(&j.progress.blocks)
+	j.state.Bytes = atomic.LoadUint64(&j.progress.bytes)
+	j.state.Depth = atomic.LoadUint64(&j.progress.depth)
+	if update != nil {
+		update(&j.state)
+	}
+	if update == nil && j.state.Blocks == prev.Blocks && j.state.Bytes == prev.Bytes && j.state.Depth == prev.Depth {
+		return
+	}
+
+	for ch := range j.subs {
+		select {
+		case ch <- j.state:
+		default:
+			// replace the state the watcher didn't read yet
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- j.state
+		}
+		if j.state.Finished() {
+			close(ch)
+		}
+	}
+	if j.state.Finished() {
+		j.subs = nil
+	}
+}
+
+// PinJobs runs the pins started with 'ipfs pin add --wait=false' in the
+// background, and keeps their state for 'ipfs pin status'. The state is lost
+// when the daemon stops.
+type PinJobs struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lk       sync.Mutex
+	jobs     map[string]*pinJob
+	finished []string
+}
+
+// NewPinJobs returns a PinJobs running its jobs until ctx is done, or it is
+// closed.
+func NewPinJobs(ctx context.Context) *PinJobs {
+	ctx, cancel := context.WithCancel(ctx)
+	return &PinJobs{
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(map[string]*pinJob),
+	}
+}
+
+// Start runs pin in the background, as a new job recording its progress in
+// the PinProgress it gets, and returns its ID.
+func (pj *PinJobs) Start(pin func(ctx context.Context, p *PinProgress) ([]cid.Cid, error)) (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+
+	j := &pinJob{
+		state: PinEvent{ID: id, State: PinJobFetching},
+		subs:  make(map[chan PinEvent]struct{}),
+	}
+	pj.lk.Lock()
+	pj.jobs[id] = j
+	pj.lk.Unlock()
+
+	type pinResult struct {
+		pins []cid.Cid
+		err  error
+	}
+	done := make(chan pinResult, 1)
+	pj.wg.Add(1)
+	go func() {
+		pins, err := pin(pj.ctx, &j.progress)
+		done <- pinResult{pins: pins, err: err}
+	}()
+	go func() {
+		defer pj.wg.Done()
+
+		ticker := time.NewTicker(pinJobInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.publish(nil)
+			case res := <-done:
+				j.publish(func(e *PinEvent) {
+					if res.err != nil {
+						e.State = PinJobFailed
+						e.Error = res.err.Error()
+						return
+					}
+					e.State = PinJobPinned
+					for _, c := range res.pins {
+						e.Pins = append(e.Pins, c.String())
+					}
+				})
+				pj.finish(id)
+				return
+			}
+		}
+	}()
+	return id, nil
+}
+
+// finish keeps the state of the job id, dropping the oldest finished jobs.
+func (pj *PinJobs) finish(id string) {
+	pj.lk.Lock()
+	defer pj.lk.Unlock()
+
+	pj.finished = append(pj.finished, id)
+	if len(pj.finished) > pinJobsKept {
+		delete(pj.jobs, pj.finished[0])
+		pj.finished = pj.finished[1:]
+	}
+}
+
+func (pj *PinJobs) job(id string) (*pinJob, bool) {
+	pj.lk.Lock()
+	defer pj.lk.Unlock()
+	j, ok := pj.jobs[id]
+	return j, ok
+}
+
+// Status returns the state of the job id, or false if it isn't known.
+func (pj *PinJobs) Status(id string) (PinEvent, bool) {
+	j, ok := pj.job(id)
+	if !ok {
+		return PinEvent{}, false
+	}
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	return j.state, true
+}
+
+// Watch returns a channel receiving the states of the job id, starting with
+// the current one, until it is finished or ctx is done. It returns false if
+// the job isn't known.
+func (pj *PinJobs) Watch(ctx context.Context, id string) (<-chan PinEvent, bool) {
+	j, ok := pj.job(id)
+	if !ok {
+		return nil, false
+	}
+
+	ch := make(chan PinEvent, 1)
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	ch <- j.state
+	if j.state.Finished() {
+		close(ch)
+		return ch, true
+	}
+	j.subs[ch] = struct{}{}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-pj.ctx.Done():
+		}
+		j.lk.Lock()
+		defer j.lk.Unlock()
+		if _, ok := j.subs[ch]; ok {
+			delete(j.subs, ch)
+			close(ch)
+		}
+	}()
+	return ch, true
+}
+
+// Close cancels the running jobs, and waits for them to stop.
+func (pj *PinJobs) Close() error {
+	pj.cancel()
+	pj.wg.Wait()
+	return nil
+}
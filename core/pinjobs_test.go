@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+)
+
+func TestPinJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := cid.Decode("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pj := NewPinJobs(ctx)
+	release := make(chan struct{})
+	id, err := pj.Start(func(ctx context.Context, p *PinProgress) ([]cid.Cid, error) {
+		p.SetDepth(1)
+		p.Fetched(10)
+		<-release
+		return []cid.Cid{c}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st, ok := pj.Status(id); !ok || st.State != PinJobFetching {
+		t.Fatalf("expected the job to be fetching, got %+v", st)
+	}
+	events, ok := pj.Watch(ctx, id)
+	if !ok {
+		t.Fatal("expected the job to be known")
+	}
+	if ev := <-events; ev.ID != id || ev.State != PinJobFetching {
+		t.Fatalf("expected the current state first, got %+v", ev)
+	}
+
+	close(release)
+	var last PinEvent
+	for ev := range events {
+		last = ev
+	}
+	if last.State != PinJobPinned || len(last.Pins) != 1 || last.Pins[0] != c.String() ||
+		last.Blocks != 1 || last.Bytes != 10 || last.Depth != 1 {
+		t.Fatalf("unexpected last state: %+v", last)
+	}
+
+	// the finished jobs can still be watched
+	failed, err := pj.Start(func(ctx context.Context, p *PinProgress) ([]cid.Cid, error) {
+		return nil, errors.New("not found")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _ = pj.Watch(ctx, failed)
+	for ev := range events {
+		last = ev
+	}
+	if last.State != PinJobFailed || last.Error != "not found" {
+		t.Fatalf("expected the job to fail, got %+v", last)
+	}
+	events, _ = pj.Watch(ctx, failed)
+	if ev := <-events; ev.State != PinJobFailed {
+		t.Fatalf("expected the job to be failed, got %+v", ev)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("expected the events of a finished job to end")
+	}
+
+	if _, ok := pj.Status("unknown"); ok {
+		t.Fatal("expected the job to be unknown")
+	}
+
+	// closing cancels the running jobs
+	running, err := pj.Start(func(ctx context.Context, p *PinProgress) ([]cid.Cid, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pj.Close()
+	if st, _ := pj.Status(running); st.State != PinJobFailed {
+		t.Fatalf("expected the job to be cancelled, got %+v", st)
+	}
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	pnet "gx/ipfs/QmZaQ3K9PRd5sYYoG1xbTGPtd3N7TYiKBRmcBUTsx8HVET/go-libp2p-pnet"
+)
+
+// ForcePNetEnv is the environment variable which, set to 1, makes the node
+// refuse to go online without a swarm key.
+const ForcePNetEnv = "LIBP2P_FORCE_PNET"
+
+// swarmKeyLen is the length of the generated swarm keys.
+const swarmKeyLen = 32
+
+// GenerateSwarmKey returns a new random swarm key, in the format of the
+// swarm.key file.
+func GenerateSwarmKey() ([]byte, error) {
+	b := make([]byte, swarmKeyLen)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("/key/swarm/psk/1.0.0/\n/base16/\n%s\n", hex.EncodeToString(b))), nil
+}
+
+// SwarmKeyFingerprint checks the swarm key and returns its fingerprint, the
+// one printed by the daemon.
+func SwarmKeyFingerprint(key []byte) (string, error) {
+	protec, err := pnet.NewProtector(bytes.NewReader(key))
+	if err != nil {
+		return "", fmt.Errorf("invalid swarm key: %s", err)
+	}
+	return hex.EncodeToString(protec.Fingerprint()), nil
+}
+
+// checkForcePNet returns an error if the private network is enforced by the
+// environment but there is no swarm key.
+func checkForcePNet(swarmkey []byte) error {
+	if swarmkey == nil && os.Getenv(ForcePNetEnv) == "1" {
+		return fmt.Errorf("private network was not configured but is enforced by the environment (%s), generate a swarm key with 'ipfs swarm key gen'", ForcePNetEnv)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+)
+
+// ConfigProfile is a config profile. Besides transforming the config like the
+// go-ipfs-config profiles, it can set the config keys which go-ipfs reads
+// itself, once the repo is initialized.
+type ConfigProfile struct {
+	Description string
+	Transform   config.Transformer
+
+	// Apply, if set, is called on the repo once the config is transformed
+	// and saved.
+	Apply func(repo.Repo) error
+}
+
+// configProfiles are the profiles defined by go-ipfs, on top of the
+// go-ipfs-config ones.
+var configProfiles = make(map[string]ConfigProfile)
+
+// LookupConfigProfile returns the config profile name.
+func LookupConfigProfile(name string) (ConfigProfile, bool) {
+	if p, ok := configProfiles[name]; ok {
+		return p, true
+	}
+	p, ok := config.Profiles[name]
+	if !ok {
+		return ConfigProfile{}, false
+	}
+	return ConfigProfile{Description: p.Description, Transform: p.Transform}, true
+}
+
+// ConfigProfiles returns all the config profiles by name.
+func ConfigProfiles() map[string]ConfigProfile {
+	out := make(map[string]ConfigProfile, len(config.Profiles)+len(configProfiles))
+	for name := range config.Profiles {
+		out[name], _ = LookupConfigProfile(name)
+	}
+	for name, p := range configProfiles {
+		out[name] = p
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
+)
+
+// Values for the Datastore.StorageMaxPolicy config key.
+const (
+	// StorageMaxFail fails operations that would exceed StorageMax.
+	StorageMaxFail = "fail"
+	// StorageMaxGC runs a GC when an operation would exceed StorageMax, and
+	// fails it if that didn't free enough space.
+	StorageMaxGC = "gc"
+	// StorageMaxAdvisory only uses StorageMax to schedule automatic GC, which
+	// was the only use of StorageMax before it was enforced. It is the policy
+	// when none is set, so that the repos initialized before keep working.
+	StorageMaxAdvisory = "advisory"
+)
+
+// ErrStorageMaxExceeded is returned by the writes that would make the repo
+// exceed Datastore.StorageMax, unless Datastore.StorageMaxPolicy is advisory.
+var ErrStorageMaxExceeded = errors.New("maximum storage limit exceeded. Try to unpin some files")
+
+// quotaMeasureInterval is how long the quota blockstore relies on the size
+// of the blocks it stored before measuring the repo again.
+const quotaMeasureInterval = 10 * time.Second
+
+// StorageMaxPolicy returns the Datastore.StorageMaxPolicy of r,
+// StorageMaxAdvisory if it isn't set.
+func StorageMaxPolicy(r repo.Repo) (string, error) {
+	var policy string
+	if err := repo.ConfigSection(r, "Datastore.StorageMaxPolicy", &policy); err != nil {
+		return "", err
+	}
+
+	switch policy {
+	case "":
+		return StorageMaxAdvisory, nil
+	case StorageMaxAdvisory, StorageMaxGC, StorageMaxFail:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid Datastore.StorageMaxPolicy %q", policy)
+	}
+}
+
+// StorageMax returns the Datastore.StorageMax of r when it is enforced, and 0
+// when it isn't set or Datastore.StorageMaxPolicy is advisory.
+func StorageMax(r repo.Repo) (uint64, error) {
+	policy, err := StorageMaxPolicy(r)
+	if err != nil || policy == StorageMaxAdvisory {
+		return 0, err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return 0, err
+	}
+	if cfg.Datastore.StorageMax == "" {
+		return 0, nil
+	}
+	return humanize.ParseBytes(cfg.Datastore.StorageMax)
+}
+
+// quotaBlockstore fails the writes of new blocks that would make the repo
+// exceed the enforced StorageMax. Only the adds store their blocks through
+// it: the blocks fetched by bitswap are always stored, the pins measure the
+// repo between the batches they fetch instead. Measuring the repo can be
+// slow, so it is measured again at most every quotaMeasureInterval, the size
+// of the blocks written being added in between, even when the writes exceed
+// the quota. The garbage collection of the StorageMaxGC policy can't run
+// while blocks are written, it is run before an add or a pin instead.
+type quotaBlockstore struct {
+	bstore.GCBlockstore
+	repo repo.Repo
+
+	mu       sync.Mutex
+	max      uint64
+	usage    uint64
+	measured time.Time
+}
+
+// NewQuotaBlockstore returns bs failing the writes that would make r exceed
+// its enforced StorageMax.
+func NewQuotaBlockstore(bs bstore.GCBlockstore, r repo.Repo) bstore.GCBlockstore {
+	return &quotaBlockstore{GCBlockstore: bs, repo: r}
+}
+
+// measure reads the quota and measures the repo. bs.mu must be held.
+func (bs *quotaBlockstore) measure() error {
+	max, err := StorageMax(bs.repo)
+	if err != nil {
+		return err
+	}
+	bs.max, bs.usage = max, 0
+	if max > 0 {
+		if bs.usage, err = bs.repo.GetStorageUsage(); err != nil {
+			return err
+		}
+	}
+	bs.measured = time.Now()
+	return nil
+}
+
+// quota returns the enforced StorageMax, 0 when there is none.
+func (bs *quotaBlockstore) quota() (uint64, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if time.Since(bs.measured) > quotaMeasureInterval {
+		if err := bs.measure(); err != nil {
+			return 0, err
+		}
+	}
+	return bs.max, nil
+}
+
+// reserve accounts for size more bytes, failing when they would exceed the
+// quota.
+func (bs *quotaBlockstore) reserve(size uint64) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.max > 0 && bs.usage+size > bs.max {
+		// the repo may have been garbage collected since it was measured,
+		// but the writes exceeding the quota mustn't all measure it
+		if time.Since(bs.measured) <= quotaMeasureInterval {
+			return ErrStorageMaxExceeded
+		}
+		if err := bs.measure(); err != nil {
+			return err
+		}
+		if bs.max > 0 && bs.usage+size > bs.max {
+			return ErrStorageMaxExceeded
+		}
+	}
+	bs.usage += size
+	return nil
+}
+
+// newBlocksSize returns the size of the blocks that aren't stored yet.
+func (bs *quotaBlockstore) newBlocksSize(blks []blocks.Block) (uint64, error) {
+	var size uint64
+	for _, b := range blks {
+		has, err := bs.Has(b.Cid())
+		if err != nil {
+			return 0, err
+		}
+		if !has {
+			size += uint64(len(b.RawData()))
+		}
+	}
+	return size, nil
+}
+
+func (bs *quotaBlockstore) check(blks []blocks.Block) error {
+	max, err := bs.quota()
+	if err != nil || max == 0 {
+		return err
+	}
+	size, err := bs.newBlocksSize(blks)
+	if err != nil || size == 0 {
+		return err
+	}
+	return bs.reserve(size)
+}
+
+func (bs *quotaBlockstore) Put(b blocks.Block) error {
+	if err := bs.check([]blocks.Block{b}); err != nil {
+		return err
+	}
+	return bs.GCBlockstore.Put(b)
+}
+
+func (bs *quotaBlockstore) PutMany(blks []blocks.Block) error {
+	if err := bs.check(blks); err != nil {
+		return err
+	}
+	return bs.GCBlockstore.PutMany(blks)
+}
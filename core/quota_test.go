@@ -0,0 +1,83 @@
+package core
+
+import (
+	"testing"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	datastore "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	syncds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
+)
+
+// quotaRepo is a repo of usage bytes with the StorageMaxPolicy policy.
+type quotaRepo struct {
+	*repo.Mock
+	policy string
+	usage  uint64
+}
+
+func (r *quotaRepo) GetConfigKey(key string) (interface{}, error) {
+	if key == "Datastore.StorageMaxPolicy" {
+		return r.policy, nil
+	}
+	return r.Mock.GetConfigKey(key)
+}
+
+func (r *quotaRepo) GetStorageUsage() (uint64, error) {
+	return r.usage, nil
+}
+
+func TestQuotaBlockstore(t *testing.T) {
+	r := &quotaRepo{Mock: &repo.Mock{}, policy: StorageMaxFail, usage: 900}
+	r.C.Datastore.StorageMax = "1000B"
+
+	bs := NewQuotaBlockstore(bstore.NewGCBlockstore(
+		bstore.NewBlockstore(syncds.MutexWrap(datastore.NewMapDatastore())),
+		bstore.NewGCLocker(),
+	), r).(*quotaBlockstore)
+
+	small := blocks.NewBlock(make([]byte, 60))
+	if err := bs.Put(small); err != nil {
+		t.Fatal(err)
+	}
+	// stored blocks don't count
+	if err := bs.Put(small); err != nil {
+		t.Fatal(err)
+	}
+
+	r.usage = 960
+	large := blocks.NewBlock(make([]byte, 59))
+	if err := bs.PutMany([]blocks.Block{large}); err != ErrStorageMaxExceeded {
+		t.Fatalf("expected %s, got %v", ErrStorageMaxExceeded, err)
+	}
+	if has, _ := bs.Has(large.Cid()); has {
+		t.Fatal("the block exceeding the quota was stored")
+	}
+
+	// a garbage collection freed some space, it is only seen once the repo
+	// is measured again
+	r.usage = 100
+	if err := bs.Put(large); err != ErrStorageMaxExceeded {
+		t.Fatalf("expected %s, got %v", ErrStorageMaxExceeded, err)
+	}
+	bs.measured = bs.measured.Add(-2 * quotaMeasureInterval)
+	if err := bs.Put(large); err != nil {
+		t.Fatal(err)
+	}
+
+	// the quota is advisory when no policy is set
+	r.policy = ""
+	r.usage = 2000
+	bs.measured = bs.measured.Add(-2 * quotaMeasureInterval)
+	if err := bs.Put(blocks.NewBlock([]byte("default"))); err != nil {
+		t.Fatal(err)
+	}
+
+	r.policy = StorageMaxAdvisory
+	bs.measured = bs.measured.Add(-2 * quotaMeasureInterval)
+	if err := bs.Put(blocks.NewBlock([]byte("advisory"))); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"errors"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
+)
+
+// ErrRepoReadOnly is returned by operations that would modify the repo of a
+// node built with BuildCfg.ReadOnly.
+var ErrRepoReadOnly = errors.New("the repo is read-only")
+
+// readOnlyBlockstore rejects the writes to the blockstore it wraps.
+type readOnlyBlockstore struct {
+	bstore.GCBlockstore
+}
+
+func (readOnlyBlockstore) Put(blocks.Block) error {
+	return ErrRepoReadOnly
+}
+
+func (readOnlyBlockstore) PutMany([]blocks.Block) error {
+	return ErrRepoReadOnly
+}
+
+func (readOnlyBlockstore) DeleteBlock(cid.Cid) error {
+	return ErrRepoReadOnly
+}
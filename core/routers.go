@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	composite "github.com/ipfs/go-ipfs/p2p/composite"
+	delegated "github.com/ipfs/go-ipfs/p2p/delegated"
+	staticrouting "github.com/ipfs/go-ipfs/p2p/staticrouting"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// The config keys of the custom routing, used with Routing.Type set to
+// "custom": Routing.Routers names the routers, Routing.Router is the one the
+// node routes through.
+const (
+	RoutersKey = "Routing.Routers"
+	RouterKey  = "Routing.Router"
+)
+
+// The types of the routers of Routing.Routers, besides the composite ones.
+const (
+	RouterTypeDHT       = "dht"
+	RouterTypeDelegated = "delegated"
+	RouterTypeStatic    = "static"
+)
+
+// CustomRouting is the routing configured in Routing.Routers.
+type CustomRouting struct {
+	Routers map[string]composite.RouterConfig
+	Router  string
+
+	// static are the peers of the static router, from Routing.Static
+	static []pstore.PeerInfo
+}
+
+// CustomRoutingConfig returns the custom routing configured in r, checked.
+func CustomRoutingConfig(r repo.Repo) (*CustomRouting, error) {
+	c := &CustomRouting{}
+	if err := repo.ConfigSection(r, RoutersKey, &c.Routers); err != nil {
+		return nil, err
+	}
+	if err := repo.ConfigSection(r, RouterKey, &c.Router); err != nil {
+		return nil, err
+	}
+	if c.Router == "" {
+		return nil, fmt.Errorf("%s must name the router of %s to use", RouterKey, RoutersKey)
+	}
+
+	// build the routers without starting them to check the config
+	count := make(map[string]int)
+	check := func(name string, cfg composite.RouterConfig) (routing.IpfsRouting, error) {
+		count[cfg.Type]++
+		switch cfg.Type {
+		case RouterTypeDHT:
+			if _, err := dhtRoutingOption(cfg.Parameters.Mode); err != nil {
+				return nil, err
+			}
+		case RouterTypeDelegated:
+			if len(cfg.Parameters.Endpoints) == 0 {
+				return nil, errors.New("no endpoints")
+			}
+			if _, err := delegated.New(cfg.Parameters.Endpoints); err != nil {
+				return nil, err
+			}
+		case RouterTypeStatic:
+		default:
+			return nil, fmt.Errorf("unknown type %q", cfg.Type)
+		}
+		return nil, nil
+	}
+	if _, err := composite.Build(c.Routers, c.Router, nil, check); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", RoutersKey, err)
+	}
+	// the DHT and the static routing serve the requests of the peers
+	for _, typ := range []string{RouterTypeDHT, RouterTypeStatic} {
+		if count[typ] > 1 {
+			return nil, fmt.Errorf("invalid %s: more than one %s router", RoutersKey, typ)
+		}
+	}
+
+	if count[RouterTypeStatic] > 0 {
+		var err error
+		if c.static, err = StaticRoutingPeers(r); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// dhtRoutingOption returns the RoutingOption of the DHT in mode.
+func dhtRoutingOption(mode string) (RoutingOption, error) {
+	switch mode {
+	case "", "server":
+		return DHTOption, nil
+	case "client":
+		return DHTClientOption, nil
+	case "auto":
+		return DHTAutoOption, nil
+	default:
+		return nil, fmt.Errorf("unknown DHT mode %q, expected server, client or auto", mode)
+	}
+}
+
+// CustomRoutingOption returns a RoutingOption routing through the routers of
+// c.
+func CustomRoutingOption(c *CustomRouting) RoutingOption {
+	return func(ctx context.Context, host p2phost.Host, dstore ds.Batching, validator record.Validator) (routing.IpfsRouting, error) {
+		build := func(name string, cfg composite.RouterConfig) (routing.IpfsRouting, error) {
+			switch cfg.Type {
+			case RouterTypeDHT:
+				opt, err := dhtRoutingOption(cfg.Parameters.Mode)
+				if err != nil {
+					return nil, err
+				}
+				return opt(ctx, host, dstore, validator)
+			case RouterTypeDelegated:
+				return delegated.New(cfg.Parameters.Endpoints)
+			case RouterTypeStatic:
+				return staticrouting.New(host, dstore, validator, c.static), nil
+			default:
+				return nil, fmt.Errorf("unknown type %q", cfg.Type)
+			}
+		}
+		return composite.Build(c.Routers, c.Router, validator, build)
+	}
+}
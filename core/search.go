@@ -0,0 +1,48 @@
+package core
+
+import (
+	"fmt"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+	search "github.com/ipfs/go-ipfs/search"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+// DefaultSearchMaxContentSize is the size above which the content of the
+// files isn't indexed when Search.MaxContentSize isn't set.
+const DefaultSearchMaxContentSize = "1MiB"
+
+// searchConfig is read from the Search config section.
+type searchConfig struct {
+	// Enabled indexes the names of the files added and pinned.
+	Enabled bool
+	// Content also indexes the words of the text files.
+	Content bool
+	// MaxContentSize is the size above which the content of the files
+	// isn't indexed.
+	MaxContentSize string
+}
+
+// setupSearch starts the local search index if Search.Enabled is set. It
+// reads the DAGs with internalDag, the local blocks only.
+func (n *IpfsNode) setupSearch(internalDag ipld.DAGService) error {
+	cfg := searchConfig{MaxContentSize: DefaultSearchMaxContentSize}
+	if err := repo.ConfigSection(n.Repo, "Search", &cfg); err != nil {
+		return err
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+
+	size, err := humanize.ParseBytes(cfg.MaxContentSize)
+	if err != nil {
+		return fmt.Errorf("invalid Search.MaxContentSize: %s", err)
+	}
+
+	n.Search = search.NewIndex(n.Repo.Datastore(), internalDag, n.Blockstore.Has)
+	n.Search.Content = cfg.Content
+	n.Search.MaxContentSize = size
+	return nil
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	cfg "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+)
+
+// SeededIdentity derives an ed25519 identity from seed, the same seed always
+// giving the same peer ID.
+//
+// INSECURE: anyone knowing the seed has the private key. This is meant for
+// tests and demos needing stable peer IDs, never for real nodes.
+func SeededIdentity(seed []byte) (cfg.Identity, error) {
+	sum := sha256.Sum256(seed)
+	priv, pub, err := ci.GenerateEd25519Key(bytes.NewReader(sum[:]))
+	if err != nil {
+		return cfg.Identity{}, err
+	}
+
+	pid, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		return cfg.Identity{}, err
+	}
+
+	privkeyb, err := priv.Bytes()
+	if err != nil {
+		return cfg.Identity{}, err
+	}
+
+	return cfg.Identity{
+		PeerID:  pid.Pretty(),
+		PrivKey: base64.StdEncoding.EncodeToString(privkeyb),
+	}, nil
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestSeededIdentity(t *testing.T) {
+	id1, err := SeededIdentity([]byte("seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := SeededIdentity([]byte("seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 != id2 {
+		t.Fatal("expected the same seed to give the same identity")
+	}
+
+	id3, err := SeededIdentity([]byte("other seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1.PeerID == id3.PeerID {
+		t.Fatal("expected different seeds to give different peer IDs")
+	}
+
+	if _, err := id1.DecodePrivateKey(""); err != nil {
+		t.Fatal(err)
+	}
+}
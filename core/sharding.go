@@ -0,0 +1,247 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	gopath "path"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	hamt "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/hamt"
+	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+type shardingConfig struct {
+	ShardingSizeThreshold  string
+	ShardingEntryThreshold int
+}
+
+// ShardingPolicy decides when unixfs directories are converted to HAMT
+// sharded directories and back. A nil ShardingPolicy never changes
+// directories, which is the case when Experimental.ShardingEnabled makes all
+// new directories sharded.
+type ShardingPolicy struct {
+	// SizeThreshold is the estimated size of the directory node, in bytes,
+	// above which it is sharded. Zero disables it.
+	SizeThreshold uint64
+	// EntryThreshold is the number of entries above which the directory is
+	// sharded. Zero disables it.
+	EntryThreshold int
+}
+
+// loadShardingPolicy reads the sharding thresholds from the UnixFS config
+// section. It returns nil if both thresholds are disabled, which they are
+// unless set: sharding changes the CIDs of the directories.
+func loadShardingPolicy(r repo.Repo) (*ShardingPolicy, error) {
+	cfg := shardingConfig{}
+	if err := repo.ConfigSection(r, "UnixFS", &cfg); err != nil {
+		return nil, err
+	}
+
+	var size uint64
+	if cfg.ShardingSizeThreshold != "" {
+		s, err := humanize.ParseBytes(cfg.ShardingSizeThreshold)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UnixFS.ShardingSizeThreshold: %s", err)
+		}
+		size = s
+	}
+	if cfg.ShardingEntryThreshold < 0 {
+		return nil, fmt.Errorf("invalid UnixFS.ShardingEntryThreshold: must not be negative")
+	}
+
+	if size == 0 && cfg.ShardingEntryThreshold == 0 {
+		return nil, nil
+	}
+	return &ShardingPolicy{
+		SizeThreshold:  size,
+		EntryThreshold: cfg.ShardingEntryThreshold,
+	}, nil
+}
+
+func (p *ShardingPolicy) shouldShard(entries int, size uint64) bool {
+	return (p.SizeThreshold > 0 && size > p.SizeThreshold) ||
+		(p.EntryThreshold > 0 && entries > p.EntryThreshold)
+}
+
+// Reshard converts the unixfs directory nd to a sharded directory if it
+// exceeds one of the thresholds, or to a basic directory if it is sharded
+// and doesn't anymore. The new node is added to dserv. Otherwise, nd is
+// returned unchanged.
+func (p *ShardingPolicy) Reshard(ctx context.Context, dserv ipld.DAGService, nd ipld.Node) (ipld.Node, error) {
+	if p == nil {
+		return nd, nil
+	}
+	pbnd, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return nd, nil
+	}
+	fsn, err := ft.FromBytes(pbnd.Data())
+	if err != nil {
+		return nil, err
+	}
+	sharded := fsn.GetType() == ft.THAMTShard
+	if !sharded && fsn.GetType() != ft.TDirectory {
+		return nd, nil
+	}
+
+	dir, err := uio.NewDirectoryFromNode(dserv, nd)
+	if err != nil {
+		return nil, err
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Estimate the size of a basic directory node holding the links the
+	// same way for both kinds of directories, so that a directory doesn't
+	// flip back and forth.
+	var size uint64
+	for _, l := range links {
+		size += uint64(len(l.Name) + len(l.Cid.Bytes()))
+	}
+
+	shard := p.shouldShard(len(links), size)
+	switch {
+	case shard && !sharded:
+		return shardDirectory(ctx, dserv, pbnd.CidBuilder(), links)
+	case !shard && sharded:
+		return unshardDirectory(ctx, dserv, pbnd.CidBuilder(), links)
+	default:
+		return nd, nil
+	}
+}
+
+func shardDirectory(ctx context.Context, dserv ipld.DAGService, builder cid.Builder, links []*ipld.Link) (ipld.Node, error) {
+	shard, err := hamt.NewShard(dserv, uio.DefaultShardWidth)
+	if err != nil {
+		return nil, err
+	}
+	shard.SetCidBuilder(builder)
+
+	for _, l := range links {
+		child, err := l.GetNode(ctx, dserv)
+		if err != nil {
+			return nil, err
+		}
+		if err := shard.Set(ctx, l.Name, child); err != nil {
+			return nil, err
+		}
+	}
+	return shard.Node()
+}
+
+func unshardDirectory(ctx context.Context, dserv ipld.DAGService, builder cid.Builder, links []*ipld.Link) (ipld.Node, error) {
+	out := ft.EmptyDirNode()
+	out.SetCidBuilder(builder)
+	for _, l := range links {
+		if err := out.AddRawLink(l.Name, l); err != nil {
+			return nil, err
+		}
+	}
+	if err := dserv.Add(ctx, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReshardMfs reshards the mfs directory at dirpath and replaces it in its
+// parent. It returns whether the directory changed, in which case mfs objects
+// previously obtained for it or its children must not be used anymore. The
+// root directory is left alone since it has no parent to be replaced in, the
+// mfs root has to be replaced instead, like ReshardFiles does.
+func (p *ShardingPolicy) ReshardMfs(ctx context.Context, root *mfs.Root, dserv ipld.DAGService, dirpath string) (bool, error) {
+	dirpath = gopath.Clean(dirpath)
+	if p == nil || dirpath == "/" {
+		return false, nil
+	}
+
+	fsn, err := mfs.Lookup(root, dirpath)
+	if err != nil {
+		return false, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return false, nil
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		return false, err
+	}
+
+	out, err := p.Reshard(ctx, dserv, nd)
+	if err != nil || out.Cid().Equals(nd.Cid()) {
+		return false, err
+	}
+
+	pfsn, err := mfs.Lookup(root, gopath.Dir(dirpath))
+	if err != nil {
+		return false, err
+	}
+	parent, ok := pfsn.(*mfs.Directory)
+	if !ok {
+		return false, fmt.Errorf("%s is not a directory", gopath.Dir(dirpath))
+	}
+
+	name := gopath.Base(dirpath)
+	if err := parent.Unlink(name); err != nil {
+		return false, err
+	}
+	return true, parent.AddChild(name, out)
+}
+
+// ReshardFiles reshards the directory at dirpath of the files API, like
+// ReshardMfs. The root directory is resharded too: the files root is
+// replaced with an mfs root of the new directory, which is published. It
+// waits for the root to be unlocked, see LockFilesRoot. It returns whether
+// the directory changed, in which case mfs objects previously obtained for
+// it or its children, or from the old files root, must not be used anymore.
+func (n *IpfsNode) ReshardFiles(ctx context.Context, dirpath string) (bool, error) {
+	if gopath.Clean(dirpath) != "/" {
+		root, unlock := n.LockFilesRoot()
+		defer unlock()
+		return n.Sharding.ReshardMfs(ctx, root, n.DAG, dirpath)
+	}
+	if n.Sharding == nil {
+		return false, nil
+	}
+
+	n.filesRootLk.Lock()
+	defer n.filesRootLk.Unlock()
+
+	old := n.FilesRoot
+	if err := mfs.FlushPath(old, "/"); err != nil {
+		return false, err
+	}
+	nd, err := old.GetDirectory().GetNode()
+	if err != nil {
+		return false, err
+	}
+	out, err := n.Sharding.Reshard(ctx, n.DAG, nd)
+	if err != nil || out.Cid().Equals(nd.Cid()) {
+		return false, err
+	}
+	pbnd, ok := out.(*dag.ProtoNode)
+	if !ok {
+		return false, dag.ErrNotProtobuf
+	}
+
+	// closing publishes the old root, the new one is published once it
+	// replaced it
+	if err := old.Close(); err != nil {
+		return false, err
+	}
+	mr, err := mfs.NewRoot(n.Context(), n.DAG, pbnd, n.filesPublish)
+	if err != nil {
+		return false, err
+	}
+	n.FilesRoot = mr
+	return true, n.filesPublish(ctx, pbnd.Cid())
+}
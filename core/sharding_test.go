@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
+	unixfspb "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/pb"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	mfs "gx/ipfs/QmRkrpnhZqDxTxwGCsDbuZMr7uCFZHH6SGfrcjgEQwxF3t/go-mfs"
+	datastore "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	syncds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	dagtest "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag/test"
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+func dirType(t *testing.T, nd ipld.Node) unixfspb.Data_DataType {
+	fsn, err := ft.FromBytes(nd.(*dag.ProtoNode).Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsn.GetType()
+}
+
+func dirNames(t *testing.T, ctx context.Context, ds ipld.DAGService, nd ipld.Node) map[string]bool {
+	dir, err := uio.NewDirectoryFromNode(ds, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, l := range links {
+		names[l.Name] = true
+	}
+	return names
+}
+
+func TestLoadShardingPolicyDefault(t *testing.T) {
+	r := &repo.Mock{C: config.Config{Identity: testIdentity}}
+	p, err := loadShardingPolicy(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != nil {
+		t.Fatalf("expected sharding to be disabled unless configured, got %+v", p)
+	}
+}
+
+func TestReshard(t *testing.T) {
+	ctx := context.Background()
+	ds := dagtest.Mock()
+
+	dir := ft.EmptyDirNode()
+	for i := 0; i < 3; i++ {
+		child := dag.NodeWithData([]byte(fmt.Sprint(i)))
+		if err := ds.Add(ctx, child); err != nil {
+			t.Fatal(err)
+		}
+		if err := dir.AddNodeLink(fmt.Sprintf("file%d", i), child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ds.Add(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	var nilPolicy *ShardingPolicy
+	out, err := nilPolicy.Reshard(ctx, ds, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Cid().Equals(dir.Cid()) {
+		t.Fatal("nil policy changed the directory")
+	}
+
+	small := &ShardingPolicy{EntryThreshold: 2}
+	sharded, err := small.Reshard(ctx, ds, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirType(t, sharded) != ft.THAMTShard {
+		t.Fatal("expected directory to be sharded")
+	}
+	if names := dirNames(t, ctx, ds, sharded); len(names) != 3 || !names["file1"] {
+		t.Fatalf("unexpected entries in sharded directory: %v", names)
+	}
+
+	again, err := small.Reshard(ctx, ds, sharded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Cid().Equals(sharded.Cid()) {
+		t.Fatal("resharding a sharded directory over the threshold changed it")
+	}
+
+	large := &ShardingPolicy{EntryThreshold: 10}
+	unsharded, err := large.Reshard(ctx, ds, sharded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirType(t, unsharded) != ft.TDirectory {
+		t.Fatal("expected directory to be unsharded")
+	}
+	if names := dirNames(t, ctx, ds, unsharded); len(names) != 3 || !names["file2"] {
+		t.Fatalf("unexpected entries in unsharded directory: %v", names)
+	}
+}
+
+func TestReshardFilesRoot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &repo.Mock{
+		C: config.Config{Identity: testIdentity},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	n, err := NewNode(ctx, &BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Sharding = &ShardingPolicy{EntryThreshold: 2}
+
+	old := n.FilesRoot
+	for i := 0; i < 3; i++ {
+		child := dag.NodeWithData(ft.FilePBData([]byte(fmt.Sprint(i)), 1))
+		if err := n.DAG.Add(ctx, child); err != nil {
+			t.Fatal(err)
+		}
+		if err := old.GetDirectory().AddChild(fmt.Sprintf("file%d", i), child); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the root isn't replaced while it is locked
+	_, unlock := n.LockFilesRoot()
+	type reshardResult struct {
+		changed bool
+		err     error
+	}
+	done := make(chan reshardResult, 1)
+	go func() {
+		changed, err := n.ReshardFiles(ctx, "/")
+		done <- reshardResult{changed, err}
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected ReshardFiles to wait for the files root to be unlocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if !res.changed || n.FilesRoot == old {
+		t.Fatal("expected the files root to be replaced")
+	}
+
+	nd, err := n.FilesRoot.GetDirectory().GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirType(t, nd) != ft.THAMTShard {
+		t.Fatal("expected the files root to be sharded")
+	}
+	if names := dirNames(t, ctx, n.DAG, nd); len(names) != 3 || !names["file0"] {
+		t.Fatalf("unexpected entries in the files root: %v", names)
+	}
+	if _, err := mfs.Lookup(n.FilesRoot, "/file2"); err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := r.D.Get(filesRootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := cid.Cast(val); err != nil || !c.Equals(nd.Cid()) {
+		t.Fatalf("expected the new files root to be published, got %s (%v)", c, err)
+	}
+
+	changed, err := n.ReshardFiles(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Fatal("resharding a sharded files root over the threshold changed it")
+	}
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"context"
+
+	staticrouting "github.com/ipfs/go-ipfs/p2p/staticrouting"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// StaticRoutingKey is the config section of the static routing, used with
+// Routing.Type set to "static".
+const StaticRoutingKey = "Routing.Static"
+
+// StaticRoutingPeers returns the static peers configured in r.
+func StaticRoutingPeers(r repo.Repo) ([]pstore.PeerInfo, error) {
+	var cfg staticrouting.Config
+	if err := repo.ConfigSection(r, StaticRoutingKey, &cfg); err != nil {
+		return nil, err
+	}
+	return staticrouting.ParseConfig(cfg)
+}
+
+// StaticRoutingOption returns a RoutingOption routing only through the given
+// peers, without a DHT.
+func StaticRoutingOption(peers []pstore.PeerInfo) RoutingOption {
+	return func(ctx context.Context, host p2phost.Host, dstore ds.Batching, validator record.Validator) (routing.IpfsRouting, error) {
+		return staticrouting.New(host, dstore, validator, peers), nil
+	}
+}
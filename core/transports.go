@@ -0,0 +1,395 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	libp2ptls "github.com/ipfs/go-ipfs/p2p/libp2ptls"
+	noise "github.com/ipfs/go-ipfs/p2p/noise"
+	wss "github.com/ipfs/go-ipfs/p2p/wss"
+	repo "github.com/ipfs/go-ipfs/repo"
+	common "github.com/ipfs/go-ipfs/repo/common"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	circuit "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit"
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// TransportsKey is the config section enabling and prioritizing the network
+// transports, the security transports and the stream multiplexers of the
+// swarm.
+const TransportsKey = "Swarm.Transports"
+
+// QUICTransportKey is the config key enabling the QUIC transport and setting
+// its dial priority.
+const QUICTransportKey = TransportsKey + ".Network.QUIC"
+
+const (
+	// DefaultQUICPriority is the dial priority of QUIC when enabled, before
+	// the other transports: its handshake takes a single round trip where
+	// TCP needs one for the connection, then the security and the muxer
+	// negotiations.
+	DefaultQUICPriority = 100
+
+	// defaultPriority is the dial priority of TCP and of the transports
+	// without config.
+	defaultPriority = 300
+
+	// the websocket connections are slower to set up than the TCP ones they
+	// run on, and the relayed connections go through another peer
+	defaultWebsocketPriority = 400
+	defaultRelayPriority     = 500
+
+	// secio stays preferred, the peers of this network all support it
+	defaultSECIOPriority = 100
+	defaultTLSPriority   = 200
+	defaultNoisePriority = 300
+
+	defaultYamuxPriority = 100
+	defaultMplexPriority = 200
+)
+
+// The network transports of Swarm.Transports.Network.
+const (
+	tcpTransport       = "TCP"
+	quicTransport      = "QUIC"
+	websocketTransport = "Websocket"
+	relayTransport     = "Relay"
+)
+
+const (
+	yamuxID = "/yamux/1.0.0"
+	mplexID = "/mplex/6.7.0"
+)
+
+// Priority is the config value of a transport: false disables it, true
+// enables it with its default priority and a number enables it with that
+// priority. The addresses of the transports with the lowest priorities are
+// dialed first. When unset, the transport keeps its default.
+type Priority struct {
+	set      bool
+	disabled bool
+	value    int64
+}
+
+// UnmarshalJSON accepts null, a boolean or a positive number.
+func (p *Priority) UnmarshalJSON(b []byte) error {
+	*p = Priority{}
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		return nil
+	case bytes.Equal(b, []byte("true")):
+		p.set = true
+		return nil
+	case bytes.Equal(b, []byte("false")):
+		p.set = true
+		p.disabled = true
+		return nil
+	}
+
+	var v int64
+	if err := json.Unmarshal(b, &v); err != nil || v <= 0 {
+		return fmt.Errorf("invalid transport priority %s, expected a boolean or a positive number", b)
+	}
+	p.set = true
+	p.value = v
+	return nil
+}
+
+// MarshalJSON writes the value back the way it was set.
+func (p Priority) MarshalJSON() ([]byte, error) {
+	switch {
+	case !p.set:
+		return []byte("null"), nil
+	case p.disabled:
+		return []byte("false"), nil
+	case p.value == 0:
+		return []byte("true"), nil
+	}
+	return json.Marshal(p.value)
+}
+
+// WithDefault returns whether the transport is enabled and its priority,
+// given its defaults.
+func (p Priority) WithDefault(enabled bool, priority int64) (bool, int64) {
+	if !p.set {
+		return enabled, priority
+	}
+	if p.disabled {
+		return false, 0
+	}
+	if p.value == 0 {
+		return true, priority
+	}
+	return true, p.value
+}
+
+// TransportsConfig is read from Swarm.Transports.
+type TransportsConfig struct {
+	Network struct {
+		TCP       Priority
+		QUIC      Priority
+		Websocket Priority
+		Relay     Priority
+	}
+	Security struct {
+		SECIO Priority
+		TLS   Priority
+		Noise Priority
+	}
+	Multiplexers struct {
+		Yamux Priority
+		Mplex Priority
+	}
+}
+
+// Transports are the transports of the swarm enabled by Swarm.Transports.
+type Transports struct {
+	// network maps the enabled network transports to their dial priorities
+	network map[string]int64
+	// security are the IDs of the enabled security transports, the
+	// preferred first
+	security []string
+	// muxers are the IDs of the enabled stream multiplexers, the preferred
+	// first
+	muxers []string
+}
+
+// errTLSUnsupported is returned when the TLS security transport is enabled
+// in a build without it: the supported toolchain, Go 1.10, has no TLS 1.3.
+var errTLSUnsupported = fmt.Errorf("%s.Security.TLS: the TLS security transport needs ipfs to be built with Go 1.13 or later, this build doesn't support it", TransportsKey)
+
+// ValidateTransportsKey checks the Swarm.Transports config that setting key
+// to value in r would leave, before it is written: its values must parse and
+// the TLS security transport can't be enabled in a build without it. The
+// other keys are always valid.
+func ValidateTransportsKey(r repo.Repo, key string, value interface{}) error {
+	var section interface{}
+	switch {
+	case key == TransportsKey:
+		section = value
+	case strings.HasPrefix(key, TransportsKey+"."):
+		m := make(map[string]interface{})
+		if err := repo.ConfigSection(r, TransportsKey, &m); err != nil {
+			return fmt.Errorf("invalid %s config: %s", TransportsKey, err)
+		}
+		if err := common.MapSetKV(m, strings.TrimPrefix(key, TransportsKey+"."), value); err != nil {
+			return err
+		}
+		section = m
+	default:
+		return nil
+	}
+
+	b, err := json.Marshal(section)
+	if err != nil {
+		return err
+	}
+	var tc TransportsConfig
+	if err := json.Unmarshal(b, &tc); err != nil {
+		return fmt.Errorf("invalid %s config: %s", TransportsKey, err)
+	}
+	if enabled, _ := tc.Security.TLS.WithDefault(false, defaultTLSPriority); enabled && !libp2ptls.Supported {
+		return errTLSUnsupported
+	}
+	return nil
+}
+
+// ReadTransports reads the transports enabled in r. Without config, QUIC is
+// enabled by Experimental.QUIC, the relay transport disabled by
+// Swarm.DisableRelay and mplex enabled by mplexExp, the
+// --enable-mplex-experiment flag of the daemon.
+func ReadTransports(r repo.Repo, cfg *config.Config, mplexExp bool) (*Transports, error) {
+	var tc TransportsConfig
+	if err := repo.ConfigSection(r, TransportsKey, &tc); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %s", TransportsKey, err)
+	}
+	return newTransports(tc, cfg, mplexExp)
+}
+
+func newTransports(tc TransportsConfig, cfg *config.Config, mplexExp bool) (*Transports, error) {
+	t := &Transports{network: make(map[string]int64)}
+	for _, n := range []struct {
+		name     string
+		p        Priority
+		enabled  bool
+		priority int64
+	}{
+		{tcpTransport, tc.Network.TCP, true, defaultPriority},
+		{quicTransport, tc.Network.QUIC, cfg.Experimental.QUIC, DefaultQUICPriority},
+		{websocketTransport, tc.Network.Websocket, true, defaultWebsocketPriority},
+		{relayTransport, tc.Network.Relay, !cfg.Swarm.DisableRelay, defaultRelayPriority},
+	} {
+		if enabled, priority := n.p.WithDefault(n.enabled, n.priority); enabled {
+			t.network[n.name] = priority
+		}
+	}
+	if len(t.network) == 0 {
+		return nil, fmt.Errorf("%s.Network: no transport enabled", TransportsKey)
+	}
+
+	security := make(map[string]int64)
+	if enabled, priority := tc.Security.SECIO.WithDefault(true, defaultSECIOPriority); enabled {
+		security[secioID] = priority
+	}
+	if enabled, priority := tc.Security.TLS.WithDefault(false, defaultTLSPriority); enabled {
+		if !libp2ptls.Supported {
+			return nil, errTLSUnsupported
+		}
+		security[libp2ptls.ID] = priority
+	}
+	if enabled, priority := tc.Security.Noise.WithDefault(false, defaultNoisePriority); enabled {
+		security[noise.ID] = priority
+	}
+	if len(security) == 0 {
+		return nil, fmt.Errorf("%s.Security: no transport enabled", TransportsKey)
+	}
+	t.security = sortedIDs([]string{secioID, libp2ptls.ID, noise.ID}, security)
+
+	muxers := make(map[string]int64)
+	if enabled, priority := tc.Multiplexers.Yamux.WithDefault(true, defaultYamuxPriority); enabled {
+		muxers[yamuxID] = priority
+	}
+	if enabled, priority := tc.Multiplexers.Mplex.WithDefault(mplexExp, defaultMplexPriority); enabled {
+		muxers[mplexID] = priority
+	}
+	if len(muxers) == 0 {
+		return nil, fmt.Errorf("%s.Multiplexers: no multiplexer enabled", TransportsKey)
+	}
+	t.muxers = sortedIDs([]string{yamuxID, mplexID}, muxers)
+	return t, nil
+}
+
+// sortedIDs returns the IDs of ids enabled in priorities, by priority, the
+// order of ids breaking ties.
+func sortedIDs(ids []string, priorities map[string]int64) []string {
+	var out []string
+	for _, id := range ids {
+		if _, ok := priorities[id]; ok {
+			out = append(out, id)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return priorities[out[i]] < priorities[out[j]]
+	})
+	return out
+}
+
+// enabled returns whether the network transport of Swarm.Transports.Network
+// is enabled.
+func (t *Transports) enabled(name string) bool {
+	_, ok := t.network[name]
+	return ok
+}
+
+// priority returns the dial priority of the transport of a, and whether it is
+// enabled. The addresses of unknown transports are dialed with the default
+// priority, the swarm skipping them if it has no transport for them.
+func (t *Transports) priority(a ma.Multiaddr) (int64, bool) {
+	name := networkTransport(a)
+	if name == "" {
+		return defaultPriority, true
+	}
+	priority, ok := t.network[name]
+	return priority, ok
+}
+
+// filterAddrs returns the addresses of addrs whose transports are enabled.
+func (t *Transports) filterAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var out []ma.Multiaddr
+	for _, a := range addrs {
+		if _, ok := t.priority(a); ok {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// networkTransport returns the network transport of Swarm.Transports.Network
+// dialing a, "" for the others.
+func networkTransport(a ma.Multiaddr) string {
+	if _, err := a.ValueForProtocol(circuit.P_CIRCUIT); err == nil {
+		return relayTransport
+	}
+	for _, p := range a.Protocols() {
+		if p.Name == "ws" || p.Code == wss.P_WSS {
+			return websocketTransport
+		}
+	}
+	if isQUICAddr(a) {
+		return quicTransport
+	}
+	if _, err := a.ValueForProtocol(ma.P_TCP); err == nil {
+		return tcpTransport
+	}
+	return ""
+}
+
+func isQUICAddr(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(ma.P_QUIC)
+	return err == nil
+}
+
+// quicListenAddrs returns QUIC addresses on the IPs and ports of the TCP
+// addresses of listen, for the swarm to listen on QUIC without changing
+// Addresses.Swarm. There are none if listen has QUIC addresses already.
+func quicListenAddrs(listen []ma.Multiaddr) []ma.Multiaddr {
+	for _, a := range listen {
+		if isQUICAddr(a) {
+			return nil
+		}
+	}
+
+	var out []ma.Multiaddr
+	for _, a := range listen {
+		ps := a.Protocols()
+		if len(ps) != 2 || (ps[0].Code != ma.P_IP4 && ps[0].Code != ma.P_IP6) || ps[1].Code != ma.P_TCP {
+			continue
+		}
+		ip, _ := a.ValueForProtocol(ps[0].Code)
+		port, _ := a.ValueForProtocol(ma.P_TCP)
+		q, err := ma.NewMultiaddr(fmt.Sprintf("/%s/%s/udp/%s/quic", ps[0].Name, ip, port))
+		if err != nil {
+			continue
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+// dialPriorityPeerstore returns the addresses of the peers whose transports
+// are enabled, ordered by the priority of their transports, the swarm dialing
+// them in that order. Only the swarm reads it, see dialHost.
+type dialPriorityPeerstore struct {
+	pstore.Peerstore
+	transports *Transports
+}
+
+func (ps dialPriorityPeerstore) Addrs(p peer.ID) []ma.Multiaddr {
+	addrs := ps.transports.filterAddrs(ps.Peerstore.Addrs(p))
+	sort.SliceStable(addrs, func(i, j int) bool {
+		pi, _ := ps.transports.priority(addrs[i])
+		pj, _ := ps.transports.priority(addrs[j])
+		return pi < pj
+	})
+	return addrs
+}
+
+// dialHost is a host whose swarm dials through a dialPriorityPeerstore,
+// returning the peerstore itself to the DHT, identify and the other services,
+// so that the addresses of the disabled transports are still shared with the
+// network.
+type dialHost struct {
+	p2phost.Host
+	ps pstore.Peerstore
+}
+
+func (h dialHost) Peerstore() pstore.Peerstore {
+	return h.ps
+}
@@ -0,0 +1,226 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	libp2ptls "github.com/ipfs/go-ipfs/p2p/libp2ptls"
+	noise "github.com/ipfs/go-ipfs/p2p/noise"
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	pstoremem "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore/pstoremem"
+)
+
+func TestPriority(t *testing.T) {
+	for s, exp := range map[string]struct {
+		enabled  bool
+		priority int64
+	}{
+		"null":  {false, 0},
+		"true":  {true, DefaultQUICPriority},
+		"false": {false, 0},
+		"500":   {true, 500},
+	} {
+		var p Priority
+		if err := json.Unmarshal([]byte(s), &p); err != nil {
+			t.Fatal(err)
+		}
+		enabled, priority := p.WithDefault(false, DefaultQUICPriority)
+		if enabled != exp.enabled || (enabled && priority != exp.priority) {
+			t.Errorf("%s: expected %v %d, got %v %d", s, exp.enabled, exp.priority, enabled, priority)
+		}
+
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s {
+			t.Errorf("expected %s to be marshaled back, got %s", s, b)
+		}
+	}
+
+	for _, s := range []string{"0", "-1", `"quic"`} {
+		var p Priority
+		if err := json.Unmarshal([]byte(s), &p); err == nil {
+			t.Errorf("expected %s to be invalid", s)
+		}
+	}
+}
+
+func mustAddrs(t *testing.T, ss ...string) []ma.Multiaddr {
+	addrs := make([]ma.Multiaddr, len(ss))
+	for i, s := range ss {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs[i] = a
+	}
+	return addrs
+}
+
+func TestQUICListenAddrs(t *testing.T) {
+	listen := mustAddrs(t, "/ip4/0.0.0.0/tcp/4001", "/ip6/::/tcp/4002", "/ip4/0.0.0.0/tcp/8081/ws")
+	quic := quicListenAddrs(listen)
+	exp := mustAddrs(t, "/ip4/0.0.0.0/udp/4001/quic", "/ip6/::/udp/4002/quic")
+	if len(quic) != len(exp) {
+		t.Fatalf("expected %s, got %s", exp, quic)
+	}
+	for i := range exp {
+		if !quic[i].Equal(exp[i]) {
+			t.Fatalf("expected %s, got %s", exp, quic)
+		}
+	}
+
+	// the QUIC addresses configured are kept as they are
+	listen = append(listen, mustAddrs(t, "/ip4/0.0.0.0/udp/5001/quic")...)
+	if quic := quicListenAddrs(listen); len(quic) != 0 {
+		t.Fatalf("expected no address, got %s", quic)
+	}
+}
+
+func mustTransports(t *testing.T, js string, cfg *config.Config, mplexExp bool) *Transports {
+	var tc TransportsConfig
+	if err := json.Unmarshal([]byte(js), &tc); err != nil {
+		t.Fatal(err)
+	}
+	tpts, err := newTransports(tc, cfg, mplexExp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tpts
+}
+
+func TestTransports(t *testing.T) {
+	cfg := &config.Config{}
+	tpts := mustTransports(t, `{}`, cfg, false)
+	for name, exp := range map[string]bool{tcpTransport: true, quicTransport: false, websocketTransport: true, relayTransport: true} {
+		if tpts.enabled(name) != exp {
+			t.Errorf("expected %s enabled to be %v", name, exp)
+		}
+	}
+	if len(tpts.muxers) != 1 || tpts.muxers[0] != yamuxID {
+		t.Fatalf("unexpected muxers %s", tpts.muxers)
+	}
+
+	// the config overrides the flags and the other config keys
+	cfg.Experimental.QUIC = true
+	cfg.Swarm.DisableRelay = true
+	tpts = mustTransports(t, `{"Network": {"QUIC": false, "Relay": true, "TCP": false}}`, cfg, false)
+	if tpts.enabled(quicTransport) || !tpts.enabled(relayTransport) || tpts.enabled(tcpTransport) {
+		t.Fatalf("unexpected transports %v", tpts.network)
+	}
+
+	if len(tpts.security) != 1 || tpts.security[0] != secioID {
+		t.Fatalf("unexpected security transports %s", tpts.security)
+	}
+	tpts = mustTransports(t, `{"Security": {"Noise": true}}`, cfg, false)
+	if len(tpts.security) != 2 || tpts.security[0] != secioID || tpts.security[1] != noise.ID {
+		t.Fatalf("unexpected security transports %s", tpts.security)
+	}
+	tpts = mustTransports(t, `{"Security": {"SECIO": false, "Noise": 10}}`, cfg, false)
+	if len(tpts.security) != 1 || tpts.security[0] != noise.ID {
+		t.Fatalf("unexpected security transports %s", tpts.security)
+	}
+	if libp2ptls.Supported {
+		tpts = mustTransports(t, `{"Security": {"TLS": 50, "Noise": true}}`, cfg, false)
+		if len(tpts.security) != 3 || tpts.security[0] != libp2ptls.ID || tpts.security[2] != noise.ID {
+			t.Fatalf("unexpected security transports %s", tpts.security)
+		}
+	}
+
+	tpts = mustTransports(t, `{"Multiplexers": {"Mplex": 50}}`, cfg, false)
+	if len(tpts.muxers) != 2 || tpts.muxers[0] != mplexID || tpts.muxers[1] != yamuxID {
+		t.Fatalf("unexpected muxers %s", tpts.muxers)
+	}
+	tpts = mustTransports(t, `{"Multiplexers": {"Yamux": false}}`, cfg, true)
+	if len(tpts.muxers) != 1 || tpts.muxers[0] != mplexID {
+		t.Fatalf("unexpected muxers %s", tpts.muxers)
+	}
+
+	for _, js := range []string{
+		`{"Network": {"TCP": false, "QUIC": false, "Websocket": false, "Relay": false}}`,
+		`{"Security": {"SECIO": false}}`,
+		`{"Multiplexers": {"Yamux": false}}`,
+	} {
+		var tc TransportsConfig
+		if err := json.Unmarshal([]byte(js), &tc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newTransports(tc, cfg, false); err == nil {
+			t.Errorf("expected %s to be invalid", js)
+		}
+	}
+}
+
+func TestValidateTransportsKey(t *testing.T) {
+	r := &repo.Mock{}
+	for _, c := range []struct {
+		key   string
+		value interface{}
+		valid bool
+	}{
+		{"Swarm.Transports.Security.Noise", true, true},
+		{"Swarm.Transports.Security.Noise", "yes", false},
+		{"Swarm.Transports.Security.TLS", false, true},
+		{"Swarm.Transports.Security.TLS", true, libp2ptls.Supported},
+		{"Swarm.Transports", map[string]interface{}{"Security": map[string]interface{}{"TLS": 50.0}}, libp2ptls.Supported},
+		{"Swarm.ConnMgr.Type", "none", true},
+	} {
+		err := ValidateTransportsKey(r, c.key, c.value)
+		if (err == nil) != c.valid {
+			t.Errorf("%s = %v: expected valid to be %v, got error %v", c.key, c.value, c.valid, err)
+		}
+	}
+}
+
+func TestDialPriorityPeerstore(t *testing.T) {
+	p := peer.ID("peer")
+	mem := pstoremem.NewPeerstore()
+	mem.AddAddrs(p, mustAddrs(t,
+		"/ip4/1.2.3.4/tcp/4002/ws",
+		"/ip4/1.2.3.4/tcp/4001",
+		"/ip4/1.2.3.4/udp/4001/quic",
+		"/ip6/::1/tcp/4001",
+	), pstore.PermanentAddrTTL)
+
+	cfg := &config.Config{}
+	cfg.Experimental.QUIC = true
+	ps := dialPriorityPeerstore{Peerstore: mem, transports: mustTransports(t, `{}`, cfg, false)}
+	addrs := ps.Addrs(p)
+	if len(addrs) != 4 || !isQUICAddr(addrs[0]) || networkTransport(addrs[3]) != websocketTransport {
+		t.Fatalf("expected QUIC first and the websocket address last, got %s", addrs)
+	}
+
+	ps.transports = mustTransports(t, `{"Network": {"QUIC": 301, "Websocket": false}}`, cfg, false)
+	addrs = ps.Addrs(p)
+	if len(addrs) != 3 || !isQUICAddr(addrs[2]) {
+		t.Fatalf("expected the QUIC address last and no websocket address, got %s", addrs)
+	}
+
+	// the services other than the swarm see every address
+	h := dialHost{ps: mem}
+	if addrs := h.Peerstore().Addrs(p); len(addrs) != 4 {
+		t.Fatalf("expected the host to return every address, got %s", addrs)
+	}
+}
+
+func TestNetworkTransport(t *testing.T) {
+	for s, exp := range map[string]string{
+		"/ip4/1.2.3.4/tcp/4001":      tcpTransport,
+		"/dns4/example.com/tcp/4001": tcpTransport,
+		"/ip4/1.2.3.4/udp/4001/quic": quicTransport,
+		"/ip4/1.2.3.4/tcp/4002/ws":   websocketTransport,
+		"/ip4/1.2.3.4/tcp/443/wss":   websocketTransport,
+		"/ip4/1.2.3.4/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit": relayTransport,
+		"/ip4/1.2.3.4/udp/4001": "",
+	} {
+		if name := networkTransport(mustAddrs(t, s)[0]); name != exp {
+			t.Errorf("%s: expected %q, got %q", s, exp, name)
+		}
+	}
+}
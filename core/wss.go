@@ -0,0 +1,134 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	wss "github.com/ipfs/go-ipfs/p2p/wss"
+	repo "github.com/ipfs/go-ipfs/repo"
+	acme "github.com/ipfs/go-ipfs/thirdparty/acme"
+
+	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// SecureWebSocketKey is the config section of the certificate of the /wss
+// addresses of Addresses.Swarm.
+const SecureWebSocketKey = "Swarm.SecureWebSocket"
+
+// SecureWebSocketConfig is read from Swarm.SecureWebSocket: the certificate
+// is either read from CertFile and KeyFile, or obtained with AutoCert.
+type SecureWebSocketConfig struct {
+	CertFile string
+	KeyFile  string
+	AutoCert *AutoCertConfig
+}
+
+// AutoCertConfig obtains the certificate from an ACME certificate authority,
+// Let's Encrypt by default.
+type AutoCertConfig struct {
+	Domains []string
+	Email   string
+	// CacheDir defaults to the acme directory of the repo.
+	CacheDir string
+	CA       string
+	// HTTPAddr defaults to ":80", the port the certificate authorities
+	// check the challenges on.
+	HTTPAddr string
+}
+
+// newSecureWebSocket returns the TLS listeners of the /wss addresses of
+// listen, nil if there are none. The certificate is obtained in the
+// background when AutoCert is set.
+func newSecureWebSocket(r repo.Repo, listen []ma.Multiaddr) (*wss.Front, error) {
+	if _, wssAddrs := wss.SplitAddrs(listen); len(wssAddrs) == 0 {
+		return nil, nil
+	}
+
+	var cfg SecureWebSocketConfig
+	if err := repo.ConfigSection(r, SecureWebSocketKey, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %s", SecureWebSocketKey, err)
+	}
+
+	certs, err := loadCertificates(SecureWebSocketKey, cfg.CertFile, cfg.KeyFile, cfg.AutoCert)
+	if err != nil {
+		return nil, err
+	}
+	if certs == nil {
+		return nil, errors.New("listening on /wss addresses needs a certificate, set Swarm.SecureWebSocket")
+	}
+	var domains []string
+	if cfg.AutoCert != nil {
+		domains = cfg.AutoCert.Domains
+	}
+	return wss.New(certs, domains), nil
+}
+
+// loadCertificates returns the certificate read from certFile and keyFile,
+// or obtained in the background with ac, nil if neither is set. section
+// names the config section in the errors.
+func loadCertificates(section, certFile, keyFile string, ac *AutoCertConfig) (wss.Certificates, error) {
+	switch {
+	case ac != nil && certFile != "":
+		return nil, fmt.Errorf("%s: CertFile and AutoCert are exclusive", section)
+	case certFile != "":
+		certs, err := wss.LoadCertificate(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot load the certificate: %s", section, err)
+		}
+		return certs, nil
+	case ac != nil:
+		acfg := *ac
+		if acfg.CacheDir == "" {
+			root, err := config.PathRoot()
+			if err != nil {
+				return nil, err
+			}
+			acfg.CacheDir = filepath.Join(root, "acme")
+		}
+		if acfg.HTTPAddr == "" {
+			acfg.HTTPAddr = ":80"
+		}
+		m, err := acme.NewManager(acme.Config{
+			Domains:  acfg.Domains,
+			Email:    acfg.Email,
+			CacheDir: acfg.CacheDir,
+			CA:       acfg.CA,
+			HTTPAddr: acfg.HTTPAddr,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s.AutoCert: %s", section, err)
+		}
+		if err := m.Start(); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return nil, nil
+}
+
+// startSecureWebSocket listens on the /wss addresses, once the swarm listens
+// on the internal websocket address they forward to.
+func startSecureWebSocket(host p2phost.Host, front *wss.Front, wssAddrs []ma.Multiaddr) error {
+	// the internal address is listened on last
+	var internal ma.Multiaddr
+	for _, a := range host.Network().ListenAddresses() {
+		if _, err := a.ValueForProtocol(ma.P_TCP); err != nil || !isWebSocketAddr(a) {
+			continue
+		}
+		if ip, err := a.ValueForProtocol(ma.P_IP4); err == nil && ip == "127.0.0.1" {
+			internal = a
+		}
+	}
+	if internal == nil {
+		return errors.New("the swarm doesn't listen on the websocket address of the /wss addresses")
+	}
+	return front.Listen(wssAddrs, internal)
+}
+
+func isWebSocketAddr(a ma.Multiaddr) bool {
+	ps := a.Protocols()
+	return len(ps) > 0 && ps[len(ps)-1].Name == "ws"
+}
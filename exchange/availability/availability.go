@@ -0,0 +1,320 @@
+// Package availability probes periodically whether content can be found on
+// the network without the local node, and records the series of results in
+// the datastore so they outlive the daemon.
+package availability
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("availability")
+
+const (
+	// MinInterval is the shortest interval between two probes.
+	MinInterval = time.Minute
+
+	// MaxSamples bounds the number of samples of a series.
+	MaxSamples = 10000
+
+	// maxProviders is the number of providers looked up by a probe.
+	maxProviders = 20
+
+	findTimeout    = time.Minute
+	connectTimeout = 15 * time.Second
+)
+
+var seriesPrefix = ds.NewKey("/local/availability")
+
+var (
+	// ErrNoSeries is returned for the content which was never probed.
+	ErrNoSeries = errors.New("no availability series for this content")
+
+	// ErrProbing is returned when a series is started for content already
+	// being probed.
+	ErrProbing = errors.New("the content is already being probed")
+)
+
+// Sample is the result of a probe.
+type Sample struct {
+	Time time.Time
+	// Providers is the number of providers found, other than the node.
+	Providers int
+	// Reachable is the number of providers the node could connect to. The
+	// content is available when there is one at least.
+	Reachable int
+	Error     string `json:",omitempty"`
+}
+
+// Available returns whether the content could be fetched from the network.
+func (s Sample) Available() bool {
+	return s.Reachable > 0
+}
+
+// Series is the record of the probes of a CID.
+type Series struct {
+	Cid      cid.Cid
+	Interval time.Duration
+	Duration time.Duration
+	Started  time.Time
+	Samples  []Sample
+}
+
+// Ends returns when the last probe of the series is due.
+func (s *Series) Ends() time.Time {
+	return s.Started.Add(s.Duration)
+}
+
+// next returns when the next probe is due after now, or false if the series
+// is over. The probes missed while the daemon was stopped are skipped.
+func (s *Series) next(now time.Time) (time.Time, bool) {
+	if len(s.Samples) >= MaxSamples {
+		return time.Time{}, false
+	}
+	due := s.Started
+	if len(s.Samples) > 0 {
+		due = s.Samples[len(s.Samples)-1].Time.Add(s.Interval)
+	}
+	if due.Before(now) {
+		due = now
+	}
+	if due.After(s.Ends()) {
+		return time.Time{}, false
+	}
+	return due, true
+}
+
+// Prober runs the series of probes.
+type Prober struct {
+	host    p2phost.Host
+	routing routing.ContentRouting
+	dstore  ds.Datastore
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]struct{}
+}
+
+// NewProber returns a Prober looking up the providers with r, and storing
+// the series in d.
+func NewProber(h p2phost.Host, r routing.ContentRouting, d ds.Datastore) *Prober {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Prober{
+		host:    h,
+		routing: r,
+		dstore:  d,
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[string]struct{}),
+	}
+}
+
+// Start resumes the series which aren't over.
+func (p *Prober) Start() error {
+	res, err := p.dstore.Query(dsq.Query{Prefix: seriesPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		var s Series
+		if err := json.Unmarshal(e.Value, &s); err != nil {
+			log.Warningf("invalid availability series %s: %s", e.Key, err)
+			continue
+		}
+		if _, ok := s.next(now); ok && p.claim(s.Cid) {
+			p.run(&s)
+		}
+	}
+	return nil
+}
+
+// Close stops probing.
+func (p *Prober) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	return nil
+}
+
+// Probe starts a series of probes of c, every interval for duration. It
+// replaces the previous series of c, unless it isn't over.
+func (p *Prober) Probe(c cid.Cid, interval, duration time.Duration) (*Series, error) {
+	if interval < MinInterval {
+		return nil, fmt.Errorf("the interval must be %s at least", MinInterval)
+	}
+	if duration < interval {
+		return nil, errors.New("the duration must be as long as the interval at least")
+	}
+
+	s := &Series{
+		Cid:      c,
+		Interval: interval,
+		Duration: duration,
+		Started:  time.Now(),
+	}
+	if !p.claim(c) {
+		return nil, ErrProbing
+	}
+	if err := p.put(s); err != nil {
+		p.release(c)
+		return nil, err
+	}
+
+	out := *s
+	p.run(s)
+	return &out, nil
+}
+
+// Series returns the series of probes of c.
+func (p *Prober) Series(c cid.Cid) (*Series, error) {
+	b, err := p.dstore.Get(seriesKey(c))
+	if err == ds.ErrNotFound {
+		return nil, ErrNoSeries
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s Series
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Probing returns whether c is being probed.
+func (p *Prober) Probing(c cid.Cid) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.running[c.KeyString()]
+	return ok
+}
+
+func seriesKey(c cid.Cid) ds.Key {
+	return seriesPrefix.ChildString(c.String())
+}
+
+func (p *Prober) put(s *Series) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return p.dstore.Put(seriesKey(s.Cid), b)
+}
+
+// claim marks c as being probed, returning false if it is already.
+func (p *Prober) claim(c cid.Cid) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.running[c.KeyString()]; ok {
+		return false
+	}
+	p.running[c.KeyString()] = struct{}{}
+	return true
+}
+
+func (p *Prober) release(c cid.Cid) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.running, c.KeyString())
+}
+
+// run probes s in the background until it is over. s must be claimed.
+func (p *Prober) run(s *Series) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer p.release(s.Cid)
+
+		for {
+			due, ok := s.next(time.Now())
+			if !ok {
+				return
+			}
+
+			t := time.NewTimer(time.Until(due))
+			select {
+			case <-t.C:
+			case <-p.ctx.Done():
+				t.Stop()
+				return
+			}
+
+			sample := p.probe(p.ctx, s.Cid)
+			if p.ctx.Err() != nil {
+				// the probe was interrupted, its result is meaningless
+				return
+			}
+			s.Samples = append(s.Samples, sample)
+			if err := p.put(s); err != nil {
+				log.Errorf("cannot record the availability of %s: %s", s.Cid, err)
+			}
+		}
+	}()
+}
+
+// probe looks up the providers of c other than the node, and connects to
+// them.
+func (p *Prober) probe(ctx context.Context, c cid.Cid) Sample {
+	sample := Sample{Time: time.Now()}
+
+	findCtx, cancel := context.WithTimeout(ctx, findTimeout)
+	defer cancel()
+
+	var provs []pstore.PeerInfo
+	for pi := range p.routing.FindProvidersAsync(findCtx, c, maxProviders) {
+		if pi.ID == p.host.ID() {
+			continue
+		}
+		provs = append(provs, pi)
+	}
+	sample.Providers = len(provs)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var lastErr error
+	for _, pi := range provs {
+		wg.Add(1)
+		go func(pi pstore.PeerInfo) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+			defer cancel()
+
+			err := p.host.Connect(ctx, pi)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = err
+				return
+			}
+			sample.Reachable++
+		}(pi)
+	}
+	wg.Wait()
+
+	if sample.Reachable == 0 && lastErr != nil {
+		sample.Error = lastErr.Error()
+	}
+	return sample
+}
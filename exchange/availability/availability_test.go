@@ -0,0 +1,123 @@
+package availability
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+type staticProviders []pstore.PeerInfo
+
+func (staticProviders) Provide(context.Context, cid.Cid, bool) error {
+	return nil
+}
+
+func (sp staticProviders) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	out := make(chan pstore.PeerInfo, len(sp))
+	for _, pi := range sp {
+		out <- pi
+	}
+	close(out)
+	return out
+}
+
+func TestSeriesNext(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Series{Interval: time.Hour, Duration: 3 * time.Hour, Started: start}
+
+	if due, ok := s.next(start); !ok || !due.Equal(start) {
+		t.Fatalf("expected the first probe right away, got %s %v", due, ok)
+	}
+
+	s.Samples = append(s.Samples, Sample{Time: start})
+	if due, ok := s.next(start); !ok || !due.Equal(start.Add(time.Hour)) {
+		t.Fatalf("expected the next probe an interval later, got %s %v", due, ok)
+	}
+
+	// the probes missed are skipped
+	now := start.Add(150 * time.Minute)
+	if due, ok := s.next(now); !ok || !due.Equal(now) {
+		t.Fatalf("expected the probe right away, got %s %v", due, ok)
+	}
+
+	s.Samples = append(s.Samples, Sample{Time: now})
+	if _, ok := s.next(now); ok {
+		t.Fatal("expected the series to be over")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshLinked(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+
+	c := cid.NewCidV0([]byte("\x12\x20aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	provs := staticProviders{
+		hosts[0].Peerstore().PeerInfo(hosts[0].ID()), // the node itself
+		hosts[1].Peerstore().PeerInfo(hosts[1].ID()),
+		hosts[2].Peerstore().PeerInfo(hosts[2].ID()),
+	}
+	if err := mn.UnlinkPeers(hosts[0].ID(), hosts[2].ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	p := NewProber(hosts[0], provs, d)
+	defer func() { p.Close() }()
+
+	if _, err := p.Probe(c, time.Second, time.Hour); err == nil {
+		t.Fatal("expected a too short interval to be rejected")
+	}
+	if _, err := p.Series(c); err != ErrNoSeries {
+		t.Fatalf("expected no series, got %v", err)
+	}
+
+	if _, err := p.Probe(c, time.Hour, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Probe(c, time.Hour, 24*time.Hour); err != ErrProbing {
+		t.Fatalf("expected the content to be probed already, got %v", err)
+	}
+
+	var s *Series
+	for i := 0; i < 100; i++ {
+		s, err = p.Series(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Samples) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(s.Samples) != 1 {
+		t.Fatalf("expected a sample, got %+v", s)
+	}
+	if !s.Cid.Equals(c) || s.Interval != time.Hour || s.Duration != 24*time.Hour {
+		t.Fatalf("unexpected series: %+v", s)
+	}
+	if sa := s.Samples[0]; sa.Providers != 2 || sa.Reachable != 1 || !sa.Available() {
+		t.Fatalf("unexpected sample: %+v", sa)
+	}
+
+	// the series is resumed by a new prober
+	p.Close()
+	p = NewProber(hosts[0], provs, d)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Probing(c) {
+		t.Fatal("expected the series to be resumed")
+	}
+}
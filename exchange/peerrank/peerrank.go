@@ -0,0 +1,199 @@
+// Package peerrank ranks bitswap peers by how fast they are likely to deliver
+// blocks, based on their measured latency and on how many bytes they sent us
+// recently.
+package peerrank
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+)
+
+var log = logging.Logger("peerrank")
+
+const (
+	// SampleInterval is how often delivery rates are sampled.
+	SampleInterval = 5 * time.Second
+
+	// defaultLatency is assumed for peers whose latency wasn't measured yet.
+	defaultLatency = 200 * time.Millisecond
+
+	// rateSmoothing is the weight of the latest sample in the delivery rate
+	// moving average.
+	rateSmoothing = 0.3
+)
+
+// LatencySource reports the measured latency to a peer, usually the
+// peerstore.
+type LatencySource interface {
+	LatencyEWMA(peer.ID) time.Duration
+}
+
+// ReceivedFunc returns the total number of bytes received from a peer, usually
+// from its bitswap ledger.
+type ReceivedFunc func(peer.ID) uint64
+
+// PeersFunc returns the peers to keep track of, usually the connected ones.
+type PeersFunc func() []peer.ID
+
+// BiasFunc returns a factor the score of a peer is multiplied by, usually
+// from its reputation.
+type BiasFunc func(peer.ID) float64
+
+// PeerStat is the ranking information about a peer.
+type PeerStat struct {
+	Peer         peer.ID
+	Latency      time.Duration
+	DeliveryRate float64 // bytes per second
+	Score        float64
+}
+
+type peerStats struct {
+	recv    uint64
+	rate    float64
+	sampled time.Time
+}
+
+// Tracker keeps track of peer latencies and delivery rates.
+type Tracker struct {
+	latency  LatencySource
+	peers    PeersFunc
+	received ReceivedFunc
+	bias     BiasFunc
+
+	lk    sync.Mutex
+	stats map[peer.ID]*peerStats
+}
+
+// NewTracker returns a Tracker. SetReceived must be called before delivery
+// rates are tracked.
+func NewTracker(latency LatencySource, peers PeersFunc) *Tracker {
+	return &Tracker{
+		latency: latency,
+		peers:   peers,
+		stats:   make(map[peer.ID]*peerStats),
+	}
+}
+
+// SetReceived sets the function used to read how many bytes peers sent.
+func (t *Tracker) SetReceived(f ReceivedFunc) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.received = f
+}
+
+// SetBias sets the function used to bias the scores of the peers.
+func (t *Tracker) SetBias(f BiasFunc) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.bias = f
+}
+
+// Run samples delivery rates every SampleInterval until ctx is done.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(SampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.Sample(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sample updates the delivery rates of the tracked peers. Peers that aren't
+// returned by PeersFunc anymore are forgotten.
+func (t *Tracker) Sample(now time.Time) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if t.received == nil {
+		return
+	}
+
+	current := make(map[peer.ID]*peerStats)
+	for _, p := range t.peers() {
+		recv := t.received(p)
+		st, ok := t.stats[p]
+		if !ok {
+			current[p] = &peerStats{recv: recv, sampled: now}
+			continue
+		}
+
+		if elapsed := now.Sub(st.sampled).Seconds(); elapsed > 0 && recv >= st.recv {
+			rate := float64(recv-st.recv) / elapsed
+			st.rate = rateSmoothing*rate + (1-rateSmoothing)*st.rate
+		}
+		st.recv = recv
+		st.sampled = now
+		current[p] = st
+	}
+	t.stats = current
+}
+
+// Stat returns the ranking information about p.
+func (t *Tracker) Stat(p peer.ID) PeerStat {
+	t.lk.Lock()
+	var rate float64
+	if st, ok := t.stats[p]; ok {
+		rate = st.rate
+	}
+	bias := t.bias
+	t.lk.Unlock()
+
+	lat := t.latency.LatencyEWMA(p)
+	st := PeerStat{
+		Peer:         p,
+		Latency:      lat,
+		DeliveryRate: rate,
+		Score:        score(lat, rate),
+	}
+	if bias != nil {
+		st.Score *= bias(p)
+	}
+	return st
+}
+
+// Ranking returns the tracked peers, best first.
+func (t *Tracker) Ranking() []PeerStat {
+	t.lk.Lock()
+	peers := make([]peer.ID, 0, len(t.stats))
+	for p := range t.stats {
+		peers = append(peers, p)
+	}
+	t.lk.Unlock()
+
+	out := make([]PeerStat, 0, len(peers))
+	for _, p := range peers {
+		out = append(out, t.Stat(p))
+	}
+	sortStats(out)
+	return out
+}
+
+// score favors peers that delivered a lot recently and, among them, the ones
+// that are closest. A peer that never sent anything scores by latency only.
+func score(lat time.Duration, rate float64) float64 {
+	if lat <= 0 {
+		lat = defaultLatency
+	}
+	if lat < time.Millisecond {
+		lat = time.Millisecond
+	}
+	return (1 + rate/1024) / lat.Seconds()
+}
+
+func sortStats(s []PeerStat) {
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Score != s[j].Score {
+			return s[i].Score > s[j].Score
+		}
+		return s[i].Peer < s[j].Peer
+	})
+}
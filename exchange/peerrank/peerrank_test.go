@@ -0,0 +1,170 @@
+package peerrank
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+type latencies map[peer.ID]time.Duration
+
+func (l latencies) LatencyEWMA(p peer.ID) time.Duration { return l[p] }
+
+func newTestTracker(lat latencies, recv map[peer.ID]uint64) *Tracker {
+	t := NewTracker(lat, func() []peer.ID {
+		var out []peer.ID
+		for p := range lat {
+			out = append(out, p)
+		}
+		return out
+	})
+	t.SetReceived(func(p peer.ID) uint64 { return recv[p] })
+	return t
+}
+
+func TestRankingPrefersFastDelivery(t *testing.T) {
+	lat := latencies{"slow": 300 * time.Millisecond, "near": 10 * time.Millisecond, "busy": 100 * time.Millisecond}
+	recv := map[peer.ID]uint64{}
+	tr := newTestTracker(lat, recv)
+
+	now := time.Now()
+	tr.Sample(now)
+
+	// without deliveries, the closest peer wins
+	if r := tr.Ranking(); r[0].Peer != "near" || r[2].Peer != "slow" {
+		t.Fatalf("unexpected ranking: %v", r)
+	}
+
+	recv["busy"] = 10 << 20
+	tr.Sample(now.Add(SampleInterval))
+
+	r := tr.Ranking()
+	if r[0].Peer != "busy" {
+		t.Fatalf("expected the peer delivering blocks first: %v", r)
+	}
+	if r[0].DeliveryRate <= 0 {
+		t.Fatalf("expected a delivery rate: %v", r[0])
+	}
+}
+
+func TestBias(t *testing.T) {
+	lat := latencies{"a": 10 * time.Millisecond, "b": 20 * time.Millisecond}
+	tr := newTestTracker(lat, map[peer.ID]uint64{})
+	tr.Sample(time.Now())
+
+	tr.SetBias(func(p peer.ID) float64 {
+		if p == "a" {
+			return 0.1
+		}
+		return 1
+	})
+	if r := tr.Ranking(); r[0].Peer != "b" {
+		t.Fatalf("expected the bias to demote a: %v", r)
+	}
+}
+
+type fakeRouting struct {
+	routing.ContentRouting
+	providers []peer.ID
+}
+
+func (f *fakeRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	out := make(chan pstore.PeerInfo, len(f.providers))
+	for _, p := range f.providers {
+		out <- pstore.PeerInfo{ID: p}
+	}
+	close(out)
+	return out
+}
+
+func TestRoutingPassesOverSlowProviders(t *testing.T) {
+	lat := latencies{"a": 300 * time.Millisecond, "b": 20 * time.Millisecond, "c": 5 * time.Millisecond}
+	tr := newTestTracker(lat, map[peer.ID]uint64{})
+
+	r := NewRouting(&fakeRouting{providers: []peer.ID{"a", "b", "c", "b"}}, tr)
+
+	var got []peer.ID
+	for pi := range r.FindProvidersAsync(context.Background(), cid.Cid{}, 2) {
+		got = append(got, pi.ID)
+	}
+	if len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Fatalf("unexpected providers: %v", got)
+	}
+
+	// the slow providers are returned when the lookup finds no better ones
+	got = nil
+	for pi := range r.FindProvidersAsync(context.Background(), cid.Cid{}, 4) {
+		got = append(got, pi.ID)
+	}
+	if len(got) != 3 || got[2] != "a" {
+		t.Fatalf("unexpected providers: %v", got)
+	}
+}
+
+type streamRouting struct {
+	routing.ContentRouting
+	providers chan pstore.PeerInfo
+}
+
+func (s *streamRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	return s.providers
+}
+
+func TestRoutingStreamsProviders(t *testing.T) {
+	lat := latencies{"a": 300 * time.Millisecond, "b": 20 * time.Millisecond}
+	tr := newTestTracker(lat, map[peer.ID]uint64{})
+
+	s := &streamRouting{providers: make(chan pstore.PeerInfo)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := NewRouting(s, tr).FindProvidersAsync(ctx, cid.Cid{}, 2)
+
+	// the lookup is still running: the fast provider is returned right away
+	// and the slow one held back
+	s.providers <- pstore.PeerInfo{ID: "a"}
+	s.providers <- pstore.PeerInfo{ID: "b"}
+	select {
+	case pi := <-out:
+		if pi.ID != "b" {
+			t.Fatalf("expected b first, got %s", pi.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the provider wasn't returned while the lookup was running")
+	}
+
+	close(s.providers)
+	if pi := <-out; pi.ID != "a" {
+		t.Fatalf("expected a once the lookup ended, got %s", pi.ID)
+	}
+}
+
+func TestRoutingReleasesHeldProviders(t *testing.T) {
+	defer func(d time.Duration) { holdTimeout = d }(holdTimeout)
+	holdTimeout = 50 * time.Millisecond
+
+	lat := latencies{"a": 300 * time.Millisecond}
+	tr := newTestTracker(lat, map[peer.ID]uint64{})
+
+	s := &streamRouting{providers: make(chan pstore.PeerInfo)}
+	defer close(s.providers)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := NewRouting(s, tr).FindProvidersAsync(ctx, cid.Cid{}, 2)
+
+	// the only provider is slow: it is returned once nothing better was
+	// found for a while, although the lookup is still running
+	s.providers <- pstore.PeerInfo{ID: "a"}
+	select {
+	case pi := <-out:
+		if pi.ID != "a" {
+			t.Fatalf("expected a, got %s", pi.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the held provider wasn't returned while the lookup was running")
+	}
+}
@@ -0,0 +1,138 @@
+package peerrank
+
+import (
+	"context"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+// oversample is how many more providers than requested are looked up, so
+// that the slow ones can be passed over.
+const oversample = 3
+
+// holdTimeout is how long the slow providers are held back waiting for a
+// better one, so that content only slow peers provide isn't waiting for the
+// whole lookup.
+var holdTimeout = time.Second
+
+// Routing wraps content routing so that the providers it finds are ranked as
+// they stream in. Bitswap sessions only learn about the providers returned by
+// the lookups, so this steers them towards fast peers.
+type Routing struct {
+	routing.ContentRouting
+	t *Tracker
+}
+
+// NewRouting returns a Routing ranking the providers found by r with t.
+func NewRouting(r routing.ContentRouting, t *Tracker) *Routing {
+	return &Routing{ContentRouting: r, t: t}
+}
+
+// FindProvidersAsync looks up more providers than requested. The providers
+// scoring at least as well as a peer that wasn't measured yet are returned as
+// soon as they are found, the others are held back and returned, best first,
+// when the lookup ends, has found all the providers it looks for, or when
+// holdTimeout passed without a better provider. The slow providers found
+// afterwards are returned right away.
+func (r *Routing) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	if count <= 0 {
+		return r.ContentRouting.FindProvidersAsync(ctx, c, count)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	in := r.ContentRouting.FindProvidersAsync(ctx, c, count*oversample)
+	out := make(chan pstore.PeerInfo)
+	baseline := score(defaultLatency, 0)
+
+	go func() {
+		defer cancel()
+		defer close(out)
+
+		sent := 0
+		send := func(pi pstore.PeerInfo) bool {
+			select {
+			case out <- pi:
+				sent++
+				return sent < count
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		seen := make(map[peer.ID]pstore.PeerInfo)
+		var held []PeerStat
+		release := func() bool {
+			sortStats(held)
+			for _, st := range held {
+				if !send(seen[st.Peer]) {
+					return false
+				}
+			}
+			held = nil
+			return true
+		}
+
+		// the held providers are released when it fires, nil while none
+		// are held, or once they were released
+		var deadline <-chan time.Time
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+		released := false
+
+	lookup:
+		for len(seen) < count*oversample {
+			var pi pstore.PeerInfo
+			select {
+			case p, ok := <-in:
+				if !ok {
+					break lookup
+				}
+				pi = p
+			case <-deadline:
+				deadline, released = nil, true
+				if !release() {
+					return
+				}
+				continue
+			case <-ctx.Done():
+				return
+			}
+			if _, ok := seen[pi.ID]; ok {
+				continue
+			}
+			seen[pi.ID] = pi
+
+			st := r.t.Stat(pi.ID)
+			if st.Score < baseline && !released {
+				held = append(held, st)
+				if timer == nil {
+					timer = time.NewTimer(holdTimeout)
+					deadline = timer.C
+				}
+				continue
+			}
+			if !send(pi) {
+				return
+			}
+			if timer != nil && len(held) > 0 {
+				// a better provider arrived, the held ones wait longer
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(holdTimeout)
+			}
+		}
+
+		release()
+	}()
+
+	return out
+}
@@ -71,3 +71,71 @@ func pinSet(ctx context.Context, pinning pin.Pinner, dag ipld.DAGService, onlyRo
 
 	return set, nil
 }
+
+// NewMFSProvider returns provider supplying the keys of the mfs tree whose
+// root directory node is returned by root, or only the key of the root if
+// onlyRoot is set. root is called on every reprovide, the mfs tree may be
+// loaded after the reprovider is created.
+func NewMFSProvider(root func() (ipld.Node, error), dag ipld.DAGService, onlyRoot bool) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		nd, err := root()
+		if err != nil {
+			return nil, err
+		}
+
+		set := cidutil.NewStreamingSet()
+		go func() {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			defer close(set.New)
+
+			set.Visitor(ctx)(nd.Cid())
+			if onlyRoot {
+				return
+			}
+			err := merkledag.EnumerateChildren(ctx, merkledag.GetLinksWithDAG(dag), nd.Cid(), set.Visitor(ctx))
+			if err != nil {
+				log.Errorf("reprovide mfs: %s", err)
+			}
+		}()
+
+		return set.New, nil
+	}
+}
+
+// NewPrioritizedProvider returns provider supplying the keys of each of
+// providers in turn, each key once. The keys of all but the last provider are
+// kept in memory to skip them in the next ones, so the last one can supply
+// many more keys.
+func NewPrioritizedProvider(providers ...KeyChanFunc) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		outCh := make(chan cid.Cid)
+		go func() {
+			defer close(outCh)
+			seen := cid.NewSet()
+			for i, provider := range providers {
+				keys, err := provider(ctx)
+				if err != nil {
+					log.Errorf("reprovide: %s", err)
+					continue
+				}
+				last := i == len(providers)-1
+				for c := range keys {
+					if seen.Has(c) {
+						continue
+					}
+					if !last {
+						seen.Add(c)
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case outCh <- c:
+					}
+				}
+			}
+		}()
+
+		return outCh, nil
+	}
+}
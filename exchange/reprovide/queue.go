@@ -0,0 +1,445 @@
+package reprovide
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+)
+
+// Priority orders the keys waiting in a Queue, the lower the sooner. The
+// batches share their keys between the priorities by their weights, so that
+// the keys of a low priority aren't starved by a stream of higher ones.
+type Priority int
+
+const (
+	// PriorityHigh is for the roots of the new pins.
+	PriorityHigh Priority = iota
+	// PriorityNormal is for the other new blocks.
+	PriorityNormal
+	// PriorityLow is for the roots of the pins and the blocks provided with
+	// a low priority, e.g. of background bulk pins.
+	PriorityLow
+
+	numPriorities = 3
+)
+
+// priorityWeights are the shares of the batches of each priority, when keys
+// of several priorities are waiting.
+var priorityWeights = [numPriorities]int{8, 4, 1}
+
+var queuePrefix = ds.NewKey("/local/provide/queue")
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 5 * time.Minute
+)
+
+// ErrQueueClosed is returned when enqueueing to a closed queue.
+var ErrQueueClosed = errors.New("provide queue closed")
+
+// QueueConfig is read from the Provider config section.
+type QueueConfig struct {
+	// BatchSize is the number of keys taken from the queue at once. The keys
+	// of a batch are provided in the order of their DHT keys, so that the
+	// keys close to each other are announced together, to the same peers.
+	BatchSize int
+	// Workers is the number of keys provided concurrently.
+	Workers int
+}
+
+// DefaultQueueConfig returns the config used when the section is missing.
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		BatchSize: 256,
+		Workers:   8,
+	}
+}
+
+// Validate returns an error if c is not usable.
+func (c QueueConfig) Validate() error {
+	if c.BatchSize <= 0 {
+		return errors.New("the batch size must be positive")
+	}
+	if c.Workers <= 0 {
+		return errors.New("the number of workers must be positive")
+	}
+	return nil
+}
+
+// QueueStat describes the keys of a Queue.
+type QueueStat struct {
+	// High, Normal and Low count the keys waiting by priority.
+	High   int
+	Normal int
+	Low    int
+
+	// Provided and Failed count the provides since the start. The keys
+	// which failed are retried.
+	Provided uint64
+	Failed   uint64
+}
+
+type queueEntry struct {
+	c    cid.Cid
+	prio Priority
+	key  ds.Key
+	// dhtKey is the position of the key in the DHT keyspace.
+	dhtKey [sha256.Size]byte
+}
+
+// Queue provides keys in the background. The keys waiting are kept in the
+// datastore, the ones not provided before a restart are provided after it.
+type Queue struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	rsys   routing.ContentRouting
+	ds     ds.Datastore
+	cfg    QueueConfig
+
+	mu      sync.Mutex
+	seq     uint64
+	queues  [numPriorities][]*queueEntry
+	pending map[string]*queueEntry
+	stat    QueueStat
+
+	wake   chan struct{}
+	closed chan struct{}
+}
+
+// NewQueue returns a queue providing the keys to rsys, loading the keys left
+// in d by the previous run. Run starts providing them.
+func NewQueue(ctx context.Context, rsys routing.ContentRouting, d ds.Datastore, cfg QueueConfig) (*Queue, error) {
+	q := &Queue{
+		rsys:    rsys,
+		ds:      d,
+		cfg:     cfg,
+		pending: make(map[string]*queueEntry),
+		wake:    make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	q.ctx, q.cancel = context.WithCancel(ctx)
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// queueKey returns the datastore key of the seq-th key enqueued with prio.
+// The sequence is zero-padded so that the keys are listed in order.
+func queueKey(prio Priority, seq uint64) ds.Key {
+	return queuePrefix.ChildString(strconv.Itoa(int(prio))).ChildString(fmt.Sprintf("%016x", seq))
+}
+
+func (q *Queue) load() error {
+	res, err := q.ds.Query(dsq.Query{Prefix: queuePrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	for _, r := range entries {
+		key := ds.NewKey(r.Key)
+		prio, err := strconv.Atoi(key.Parent().BaseNamespace())
+		if err != nil || prio < 0 || prio >= numPriorities {
+			log.Warningf("provide queue: dropping the unknown key %s", key)
+			q.ds.Delete(key)
+			continue
+		}
+		seq, err := strconv.ParseUint(key.BaseNamespace(), 16, 64)
+		if err != nil {
+			log.Warningf("provide queue: dropping the unknown key %s", key)
+			q.ds.Delete(key)
+			continue
+		}
+		c, err := cid.Cast(r.Value)
+		if err != nil {
+			log.Warningf("provide queue: dropping %s: %s", key, err)
+			q.ds.Delete(key)
+			continue
+		}
+		if seq > q.seq {
+			q.seq = seq
+		}
+
+		// the queues are listed by priority, the key is already waiting
+		// with a higher or the same one
+		if _, ok := q.pending[c.KeyString()]; ok {
+			q.ds.Delete(key)
+			continue
+		}
+		q.push(newQueueEntry(c, Priority(prio), key))
+	}
+	if n := len(q.pending); n > 0 {
+		log.Infof("provide queue: %d keys left by the previous run", n)
+	}
+	return nil
+}
+
+func newQueueEntry(c cid.Cid, prio Priority, key ds.Key) *queueEntry {
+	return &queueEntry{
+		c:      c,
+		prio:   prio,
+		key:    key,
+		dhtKey: sha256.Sum256([]byte(c.KeyString())),
+	}
+}
+
+// push adds e to the queue of its priority. The lock must be held.
+func (q *Queue) push(e *queueEntry) {
+	q.queues[e.prio] = append(q.queues[e.prio], e)
+	q.pending[e.c.KeyString()] = e
+	q.count(e.prio, 1)
+}
+
+// count adds n to the number of keys waiting with prio. The lock must be
+// held.
+func (q *Queue) count(prio Priority, n int) {
+	switch prio {
+	case PriorityHigh:
+		q.stat.High += n
+	case PriorityNormal:
+		q.stat.Normal += n
+	case PriorityLow:
+		q.stat.Low += n
+	}
+}
+
+// Enqueue adds c to the keys to provide. A key already waiting with a lower
+// priority is moved ahead.
+func (q *Queue) Enqueue(c cid.Cid, prio Priority) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case <-q.ctx.Done():
+		return ErrQueueClosed
+	default:
+	}
+
+	old, ok := q.pending[c.KeyString()]
+	if ok && old.prio <= prio {
+		return nil
+	}
+
+	q.seq++
+	e := newQueueEntry(c, prio, queueKey(prio, q.seq))
+	if err := q.ds.Put(e.key, c.Bytes()); err != nil {
+		return err
+	}
+	if ok {
+		// the old entry is skipped when its turn comes
+		q.count(old.prio, -1)
+	}
+	q.push(e)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stat returns the number of keys waiting and provided.
+func (q *Queue) Stat() QueueStat {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stat
+}
+
+// nextBatch takes the next keys to provide from the queues, and sorts them
+// by priority and DHT key. The queues waiting share the batch by their
+// weights, the room they leave goes to the highest priorities.
+func (q *Queue) nextBatch() []*queueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var shares [numPriorities]int
+	total := 0
+	for prio := range q.queues {
+		if len(q.queues[prio]) > 0 {
+			total += priorityWeights[prio]
+		}
+	}
+	for prio := range q.queues {
+		if len(q.queues[prio]) > 0 {
+			shares[prio] = q.cfg.BatchSize * priorityWeights[prio] / total
+			if shares[prio] == 0 {
+				shares[prio] = 1
+			}
+		}
+	}
+
+	var batch []*queueEntry
+	take := func(prio, n int) {
+		queue := q.queues[prio]
+		for len(queue) > 0 && n > 0 && len(batch) < q.cfg.BatchSize {
+			e := queue[0]
+			queue[0] = nil
+			queue = queue[1:]
+			if q.pending[e.c.KeyString()] != e {
+				// enqueued again with a higher priority
+				if err := q.ds.Delete(e.key); err != nil && err != ds.ErrNotFound {
+					log.Errorf("provide queue: %s", err)
+				}
+				continue
+			}
+			batch = append(batch, e)
+			n--
+		}
+		q.queues[prio] = queue
+	}
+	for prio := range q.queues {
+		take(prio, shares[prio])
+	}
+	for prio := range q.queues {
+		take(prio, q.cfg.BatchSize)
+	}
+
+	// the priorities come first, then the keyspace
+	sort.SliceStable(batch, func(i, j int) bool {
+		if batch[i].prio != batch[j].prio {
+			return batch[i].prio < batch[j].prio
+		}
+		return bytes.Compare(batch[i].dhtKey[:], batch[j].dhtKey[:]) < 0
+	})
+	return batch
+}
+
+// done records the result of providing e.
+func (q *Queue) done(e *queueEntry, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	current := q.pending[e.c.KeyString()] == e
+	if err != nil {
+		q.stat.Failed++
+		if current {
+			// retried after the keys waiting with the same priority
+			q.queues[e.prio] = append(q.queues[e.prio], e)
+		}
+		return
+	}
+
+	q.stat.Provided++
+	if current {
+		delete(q.pending, e.c.KeyString())
+		q.count(e.prio, -1)
+	}
+	if err := q.ds.Delete(e.key); err != nil && err != ds.ErrNotFound {
+		log.Errorf("provide queue: %s", err)
+	}
+}
+
+// provide announces the keys of batch with the configured number of workers,
+// in order, and returns how many were provided.
+func (q *Queue) provide(batch []*queueEntry) int {
+	entries := make(chan *queueEntry)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var provided int
+	for i := 0; i < q.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range entries {
+				err := q.rsys.Provide(q.ctx, e.c, true)
+				if err != nil {
+					log.Debugf("provide queue: providing %s: %s", e.c, err)
+				} else {
+					mu.Lock()
+					provided++
+					mu.Unlock()
+				}
+				q.done(e, err)
+			}
+		}()
+	}
+
+loop:
+	for _, e := range batch {
+		select {
+		case entries <- e:
+		case <-q.ctx.Done():
+			break loop
+		}
+	}
+	close(entries)
+	wg.Wait()
+	return provided
+}
+
+// Run provides the keys until the queue is closed.
+func (q *Queue) Run() {
+	defer close(q.closed)
+
+	delay := minRetryDelay
+	for {
+		batch := q.nextBatch()
+		if len(batch) == 0 {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.ctx.Done():
+				return
+			}
+		}
+
+		if q.provide(batch) > 0 {
+			delay = minRetryDelay
+			continue
+		}
+
+		// nothing could be provided, e.g. no peer is connected yet
+		log.Debugf("provide queue: batch of %d keys failed, retrying in %s", len(batch), delay)
+		select {
+		case <-time.After(delay):
+		case <-q.ctx.Done():
+			return
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// Close stops providing. The keys waiting are provided after the next start.
+func (q *Queue) Close() error {
+	q.cancel()
+	<-q.closed
+	return nil
+}
+
+// Routing returns content routing whose Provide enqueues the keys, at normal
+// priority, instead of announcing them right away.
+func (q *Queue) Routing(r routing.ContentRouting) routing.ContentRouting {
+	return &queueRouting{ContentRouting: r, q: q}
+}
+
+type queueRouting struct {
+	routing.ContentRouting
+	q *Queue
+}
+
+func (r *queueRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if !announce {
+		return r.ContentRouting.Provide(ctx, c, announce)
+	}
+	return r.q.Enqueue(c, PriorityNormal)
+}
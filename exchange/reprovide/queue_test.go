@@ -0,0 +1,217 @@
+package reprovide_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+
+	. "github.com/ipfs/go-ipfs/exchange/reprovide"
+)
+
+// recordingRouting records the keys provided, or fails to provide them.
+type recordingRouting struct {
+	routing.ContentRouting
+
+	mu       sync.Mutex
+	fail     bool
+	provided []cid.Cid
+}
+
+func (r *recordingRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		return errors.New("no peers")
+	}
+	r.provided = append(r.provided, c)
+	return nil
+}
+
+func (r *recordingRouting) waitProvided(t *testing.T, n int) []cid.Cid {
+	for i := 0; i < 100; i++ {
+		r.mu.Lock()
+		provided := r.provided
+		r.mu.Unlock()
+		if len(provided) >= n {
+			return provided
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d keys to be provided", n)
+	return nil
+}
+
+func testKeys(data ...string) []cid.Cid {
+	var keys []cid.Cid
+	for _, d := range data {
+		keys = append(keys, blocks.NewBlock([]byte(d)).Cid())
+	}
+	return keys
+}
+
+func TestQueuePriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &recordingRouting{}
+	cfg := DefaultQueueConfig()
+	cfg.Workers = 1
+	q, err := NewQueue(ctx, r, dssync.MutexWrap(ds.NewMapDatastore()), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := testKeys("a", "b", "c", "d")
+	for _, c := range keys[:3] {
+		if err := q.Routing(r).Provide(ctx, c, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Enqueue(keys[3], PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	// moved ahead of the new blocks
+	if err := q.Enqueue(keys[2], PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	// already waiting
+	if err := q.Enqueue(keys[3], PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	if st := q.Stat(); st.High != 2 || st.Normal != 2 {
+		t.Fatalf("unexpected stat: %+v", st)
+	}
+
+	go q.Run()
+	defer q.Close()
+
+	provided := r.waitProvided(t, 4)
+	if len(provided) != 4 {
+		t.Fatalf("expected 4 keys to be provided, got %d", len(provided))
+	}
+	high := cid.NewSet()
+	for _, c := range provided[:2] {
+		high.Add(c)
+	}
+	if !high.Has(keys[2]) || !high.Has(keys[3]) {
+		t.Fatalf("expected the keys with a high priority to be provided first, got %s", provided)
+	}
+	if st := q.Stat(); st.High != 0 || st.Normal != 0 || st.Provided != 4 {
+		t.Fatalf("unexpected stat: %+v", st)
+	}
+}
+
+func TestQueueWeights(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &recordingRouting{}
+	cfg := DefaultQueueConfig()
+	cfg.BatchSize = 9
+	cfg.Workers = 1
+	q, err := NewQueue(ctx, r, dssync.MutexWrap(ds.NewMapDatastore()), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []string
+	for i := 0; i < 20; i++ {
+		data = append(data, fmt.Sprintf("key %d", i))
+	}
+	keys := testKeys(data...)
+	low := cid.NewSet()
+	for i, c := range keys {
+		prio := PriorityHigh
+		if i%2 == 1 {
+			prio = PriorityLow
+			low.Add(c)
+		}
+		if err := q.Enqueue(c, prio); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := q.Stat(); st.High != 10 || st.Low != 10 {
+		t.Fatalf("unexpected stat: %+v", st)
+	}
+
+	go q.Run()
+	defer q.Close()
+
+	// a batch of 9 keys is shared 8 to 1
+	provided := r.waitProvided(t, 9)[:9]
+	for i, c := range provided {
+		if low.Has(c) != (i == 8) {
+			t.Fatalf("expected a single key with a low priority, last in the batch, got %s", provided)
+		}
+	}
+	r.waitProvided(t, 20)
+}
+
+func TestQueuePersisted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	r := &recordingRouting{fail: true}
+	q, err := NewQueue(ctx, r, d, DefaultQueueConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go q.Run()
+
+	keys := testKeys("a", "b", "c")
+	for _, c := range keys[:2] {
+		if err := q.Enqueue(c, PriorityNormal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Enqueue(keys[2], PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := q.Stat(); st.Failed == 0 || st.Provided != 0 {
+		t.Fatalf("expected the provides to fail, got %+v", st)
+	}
+	if err := q.Enqueue(keys[0], PriorityHigh); err != ErrQueueClosed {
+		t.Fatalf("expected ErrQueueClosed, got %v", err)
+	}
+
+	// restart
+	r = &recordingRouting{}
+	q, err = NewQueue(ctx, r, d, DefaultQueueConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := q.Stat(); st.High != 1 || st.Normal != 2 {
+		t.Fatalf("expected the keys to be loaded, got %+v", st)
+	}
+	go q.Run()
+	defer q.Close()
+
+	provided := r.waitProvided(t, 3)
+	if !provided[0].Equals(keys[2]) {
+		t.Fatalf("expected the key with a high priority first, got %s", provided[0])
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	q.Close()
+	q, err = NewQueue(ctx, r, d, DefaultQueueConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := q.Stat(); st.High != 0 || st.Normal != 0 {
+		t.Fatalf("expected the keys provided to be removed, got %+v", st)
+	}
+}
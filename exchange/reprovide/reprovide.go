@@ -3,6 +3,7 @@ package reprovide
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	backoff "gx/ipfs/QmPJUtEJsm5YLUWhF6imvyCH8KZXRJa9Wup7FDMwTy5Ufz/backoff"
@@ -26,6 +27,30 @@ type Reprovider struct {
 	rsys routing.ContentRouting
 
 	keyProvider KeyChanFunc
+
+	statLk      sync.Mutex
+	stat        Stat
+	provideTime time.Duration
+}
+
+// Stat describes the reprovides of a Reprovider.
+type Stat struct {
+	// Running is whether a reprovide is in progress, Provided counts the
+	// keys it provided so far.
+	Running  bool
+	Provided int
+
+	// LastRun is when the last complete reprovide started, zero before the
+	// first one. LastRunDuration is how long it took and LastRunKeys how
+	// many keys it provided.
+	LastRun         time.Time
+	LastRunDuration time.Duration
+	LastRunKeys     int
+
+	// TotalProvides counts the keys provided since the start, which took
+	// AvgProvideDuration on average.
+	TotalProvides      uint64
+	AvgProvideDuration time.Duration
 }
 
 // NewReprovider creates new Reprovider instance.
@@ -79,6 +104,17 @@ func (rp *Reprovider) Run(tick time.Duration) {
 
 // Reprovide registers all keys given by rp.keyProvider to libp2p content routing
 func (rp *Reprovider) Reprovide() error {
+	start := time.Now()
+	rp.statLk.Lock()
+	rp.stat.Running = true
+	rp.stat.Provided = 0
+	rp.statLk.Unlock()
+	defer func() {
+		rp.statLk.Lock()
+		rp.stat.Running = false
+		rp.statLk.Unlock()
+	}()
+
 	keychan, err := rp.keyProvider(rp.ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get key chan: %s", err)
@@ -90,11 +126,14 @@ func (rp *Reprovider) Reprovide() error {
 			continue
 		}
 		op := func() error {
+			provideStart := time.Now()
 			err := rp.rsys.Provide(rp.ctx, c, true)
 			if err != nil {
 				log.Debugf("Failed to provide key: %s", err)
+				return err
 			}
-			return err
+			rp.provided(time.Since(provideStart))
+			return nil
 		}
 
 		// TODO: this backoff library does not respect our context, we should
@@ -105,9 +144,35 @@ func (rp *Reprovider) Reprovide() error {
 			return err
 		}
 	}
+
+	rp.statLk.Lock()
+	rp.stat.LastRun = start
+	rp.stat.LastRunDuration = time.Since(start)
+	rp.stat.LastRunKeys = rp.stat.Provided
+	rp.statLk.Unlock()
 	return nil
 }
 
+// provided records a key provided in d.
+func (rp *Reprovider) provided(d time.Duration) {
+	rp.statLk.Lock()
+	defer rp.statLk.Unlock()
+	rp.stat.Provided++
+	rp.stat.TotalProvides++
+	rp.provideTime += d
+}
+
+// Stat returns the statistics of the reprovides.
+func (rp *Reprovider) Stat() Stat {
+	rp.statLk.Lock()
+	defer rp.statLk.Unlock()
+	st := rp.stat
+	if st.TotalProvides > 0 {
+		st.AvgProvideDuration = rp.provideTime / time.Duration(st.TotalProvides)
+	}
+	return st
+}
+
 // Trigger starts reprovision process in rp.Run and waits for it
 func (rp *Reprovider) Trigger(ctx context.Context) error {
 	progressCtx, done := context.WithCancel(ctx)
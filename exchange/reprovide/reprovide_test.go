@@ -3,7 +3,9 @@ package reprovide_test
 import (
 	"context"
 	"testing"
+	"time"
 
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	testutil "gx/ipfs/QmRNhSdqzMcuRxX9A1egBeQ3BhDTguDV5HPwi8wRykkPU8/go-testutil"
 	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
 	mock "gx/ipfs/QmSNe4MWVxZWk6UxxW2z2EKofFo4GdFzud1vfn1iVby3mj/go-ipfs-routing/mock"
@@ -55,3 +57,79 @@ func TestReprovide(t *testing.T) {
 		t.Fatal("Somehow got the wrong peer back as a provider.")
 	}
 }
+
+func TestReprovideStat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clA := mock.NewServer().Client(testutil.RandIdentityOrFatal(t))
+	bstore := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	for _, data := range []string{"a", "b", "c"} {
+		bstore.Put(blocks.NewBlock([]byte(data)))
+	}
+
+	reprov := NewReprovider(ctx, clA, NewBlockstoreProvider(bstore))
+	if st := reprov.Stat(); !st.LastRun.IsZero() || st.TotalProvides != 0 {
+		t.Fatalf("unexpected stat before reproviding: %+v", st)
+	}
+
+	start := time.Now()
+	if err := reprov.Reprovide(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reprov.Reprovide(); err != nil {
+		t.Fatal(err)
+	}
+
+	st := reprov.Stat()
+	if st.Running || st.LastRunKeys != 3 || st.TotalProvides != 6 {
+		t.Fatalf("unexpected stat: %+v", st)
+	}
+	if st.LastRun.Before(start) {
+		t.Fatalf("the last run started before the reprovides: %s", st.LastRun)
+	}
+}
+
+func keysProvider(keys ...cid.Cid) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		out := make(chan cid.Cid, len(keys))
+		for _, c := range keys {
+			out <- c
+		}
+		close(out)
+		return out, nil
+	}
+}
+
+func TestPrioritizedProvider(t *testing.T) {
+	var keys []cid.Cid
+	for _, data := range []string{"a", "b", "c", "d"} {
+		keys = append(keys, blocks.NewBlock([]byte(data)).Cid())
+	}
+	a, b, c, d := keys[0], keys[1], keys[2], keys[3]
+
+	provider := NewPrioritizedProvider(
+		keysProvider(c),
+		keysProvider(c, a),
+		keysProvider(a, b, c, d, d),
+	)
+	out, err := provider(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []cid.Cid
+	for k := range out {
+		got = append(got, k)
+	}
+	// the keys of the last provider aren't deduplicated
+	expected := []cid.Cid{c, a, b, d, d}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range got {
+		if !got[i].Equals(expected[i]) {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Package servestats counts the blocks a node serves to the other peers
+// with bitswap, and attributes them to the pins they belong to, for the
+// publishers to see which of their datasets are downloaded.
+package servestats
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	pin "github.com/ipfs/go-ipfs/pin"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
+)
+
+// MaxTrackedBlocks bounds the number of the blocks counted one by one: the
+// blocks served once it is reached are only counted in the total, and left
+// unattributed.
+const MaxTrackedBlocks = 1 << 20
+
+// Count is an amount of data served.
+type Count struct {
+	Blocks uint64
+	Bytes  uint64
+}
+
+func (c *Count) add(o Count) {
+	c.Blocks += o.Blocks
+	c.Bytes += o.Bytes
+}
+
+// PinCount is the data served of the DAG of a pin.
+type PinCount struct {
+	Cid  cid.Cid
+	Type string
+	Count
+}
+
+// Tracker counts the blocks served since it was created.
+type Tracker struct {
+	start time.Time
+
+	mu     sync.Mutex
+	total  Count
+	blocks map[cid.Cid]Count
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		start:  time.Now(),
+		blocks: make(map[cid.Cid]Count),
+	}
+}
+
+// Since returns when the tracker started counting.
+func (t *Tracker) Since() time.Time {
+	return t.start
+}
+
+// Total returns the data served.
+func (t *Tracker) Total() Count {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total
+}
+
+func (t *Tracker) served(c cid.Cid, size int) {
+	n := Count{Blocks: 1, Bytes: uint64(size)}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total.add(n)
+	bc, ok := t.blocks[c]
+	if !ok && len(t.blocks) >= MaxTrackedBlocks {
+		return
+	}
+	bc.add(n)
+	t.blocks[c] = bc
+}
+
+// Blockstore returns bs counting the blocks read from it as served. It must
+// only be given to bitswap, which reads the blocks it sends to the peers.
+func (t *Tracker) Blockstore(bs bstore.Blockstore) bstore.Blockstore {
+	return &servingBlockstore{Blockstore: bs, t: t}
+}
+
+type servingBlockstore struct {
+	bstore.Blockstore
+	t *Tracker
+}
+
+func (bs *servingBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	b, err := bs.Blockstore.Get(c)
+	if err == nil {
+		bs.t.served(c, len(b.RawData()))
+	}
+	return b, err
+}
+
+// ByPin attributes the data served to the pins of pinner whose DAG has the
+// blocks, read with ng which shouldn't fetch them from the network. A block
+// of several pins counts for each of them. The pins are sorted by the bytes
+// served, the pins without any block served left out, and the data of no pin
+// is returned apart.
+func (t *Tracker) ByPin(ctx context.Context, pinner pin.Pinner, ng ipld.NodeGetter) ([]PinCount, Count, error) {
+	t.mu.Lock()
+	served := make(map[cid.Cid]Count, len(t.blocks))
+	for c, n := range t.blocks {
+		served[c] = n
+	}
+	unpinned := t.total
+	t.mu.Unlock()
+
+	if len(served) == 0 {
+		return nil, unpinned, nil
+	}
+
+	// the blocks missing locally are skipped
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		links, err := ipld.GetLinks(ctx, ng, c)
+		if err == ipld.ErrNotFound {
+			return nil, nil
+		}
+		return links, err
+	}
+
+	pinned := cid.NewSet()
+	var out []PinCount
+	count := func(typ string, root cid.Cid, set *cid.Set) {
+		pc := PinCount{Cid: root, Type: typ}
+		set.ForEach(func(c cid.Cid) error {
+			if n, ok := served[c]; ok {
+				pc.add(n)
+				if pinned.Visit(c) {
+					unpinned.Blocks -= n.Blocks
+					unpinned.Bytes -= n.Bytes
+				}
+			}
+			return nil
+		})
+		if pc.Blocks > 0 {
+			out = append(out, pc)
+		}
+	}
+
+	for _, root := range pinner.RecursiveKeys() {
+		set := cid.NewSet()
+		set.Add(root)
+		if err := dag.EnumerateChildren(ctx, getLinks, root, set.Visit); err != nil {
+			return nil, Count{}, err
+		}
+		count("recursive", root, set)
+	}
+	for _, root := range pinner.DirectKeys() {
+		set := cid.NewSet()
+		set.Add(root)
+		count("direct", root, set)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Cid.String() < out[j].Cid.String()
+	})
+	return out, unpinned, nil
+}
@@ -0,0 +1,102 @@
+package servestats
+
+import (
+	"context"
+	"testing"
+
+	pin "github.com/ipfs/go-ipfs/pin"
+
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	mdag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	bserv "gx/ipfs/Qma2KhbQarYTkmSJAeaMGRAg8HAXAhEWK8ge4SReG7ZSD3/go-blockservice"
+	offline "gx/ipfs/QmcRC35JF2pJQneAxa5LdQBQRumWggccWErogSrCkS1h8T/go-ipfs-exchange-offline"
+	blockstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
+)
+
+func TestByPin(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := blockstore.NewBlockstore(dstore)
+	dserv := mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+
+	add := func(data string, children ...*mdag.ProtoNode) *mdag.ProtoNode {
+		nd := mdag.NodeWithData([]byte(data))
+		for i, c := range children {
+			nd.AddNodeLink(string('a'+rune(i)), c)
+		}
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	shared := add("shared")
+	onlyA := add("only in a")
+	a := add("a", shared, onlyA)
+	b := add("b", shared)
+	direct := add("direct")
+	loose := add("loose")
+
+	if err := pinner.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Pin(ctx, b, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Pin(ctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := NewTracker()
+	served := tr.Blockstore(bs)
+	for _, nd := range []*mdag.ProtoNode{shared, shared, onlyA, loose, direct} {
+		if _, err := served.Get(nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// reading through the other blockstores doesn't count
+	if _, err := bs.Get(a.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	size := func(nds ...*mdag.ProtoNode) uint64 {
+		var n uint64
+		for _, nd := range nds {
+			n += uint64(len(nd.RawData()))
+		}
+		return n
+	}
+
+	total := tr.Total()
+	if total.Blocks != 5 || total.Bytes != size(shared, shared, onlyA, loose, direct) {
+		t.Fatalf("unexpected total: %+v", total)
+	}
+
+	pins, unpinned, err := tr.ByPin(ctx, pinner, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Count{
+		a.Cid().String():      {Blocks: 3, Bytes: size(shared, shared, onlyA)},
+		b.Cid().String():      {Blocks: 2, Bytes: size(shared, shared)},
+		direct.Cid().String(): {Blocks: 1, Bytes: size(direct)},
+	}
+	if len(pins) != len(expected) {
+		t.Fatalf("expected %d pins, got %+v", len(expected), pins)
+	}
+	for i, pc := range pins {
+		if pc.Count != expected[pc.Cid.String()] {
+			t.Fatalf("unexpected count for %s: %+v", pc.Cid, pc.Count)
+		}
+		if i > 0 && pins[i-1].Bytes < pc.Bytes {
+			t.Fatal("expected the pins to be sorted by the bytes served")
+		}
+	}
+	if pins[0].Cid != a.Cid() || pins[0].Type != "recursive" {
+		t.Fatalf("expected the first pin to be the recursive %s, got %+v", a.Cid(), pins[0])
+	}
+	if unpinned.Blocks != 1 || unpinned.Bytes != size(loose) {
+		t.Fatalf("unexpected unpinned count: %+v", unpinned)
+	}
+}
@@ -414,7 +414,7 @@ _ipfs_id()
 
 _ipfs_init()
 {
-    _ipfs_comp "--bits --force --empty-repo --help"
+    _ipfs_comp "--bits --force --empty-repo --seed= --help"
 }
 
 _ipfs_log()
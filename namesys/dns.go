@@ -31,6 +31,63 @@ func (r *DNSResolver) Resolve(ctx context.Context, name string, options ...opts.
 	return resolve(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
 }
 
+// dnsConcurrency bounds the number of domains ResolveDomains resolves at
+// once.
+const dnsConcurrency = 8
+
+// DomainResult is the resolution of a domain by ResolveDomains.
+type DomainResult struct {
+	Name string
+	Path path.Path
+	Err  error
+}
+
+// ResolveDomains resolves the domains names concurrently, sending their
+// results on the returned channel in the order of names. The channel is
+// closed once they are all sent, or when ctx is done.
+func (r *DNSResolver) ResolveDomains(ctx context.Context, names []string, options ...opts.ResolveOpt) <-chan DomainResult {
+	results := make([]chan DomainResult, len(names))
+	for i := range results {
+		results[i] = make(chan DomainResult, 1)
+	}
+
+	go func() {
+		sem := make(chan struct{}, dnsConcurrency)
+		for i, name := range names {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] <- DomainResult{Name: name, Err: ctx.Err()}
+				continue
+			}
+			go func(name string, res chan<- DomainResult) {
+				defer func() { <-sem }()
+				p, err := r.Resolve(ctx, name, options...)
+				res <- DomainResult{Name: name, Path: p, Err: err}
+			}(name, results[i])
+		}
+	}()
+
+	out := make(chan DomainResult)
+	go func() {
+		defer close(out)
+		for _, c := range results {
+			var res DomainResult
+			select {
+			case res = <-c:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
 type lookupRes struct {
 	path  path.Path
 	error error
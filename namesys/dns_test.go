@@ -1,6 +1,7 @@
 package namesys
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -160,3 +161,41 @@ func TestDNSResolution(t *testing.T) {
 	testResolution(t, r, "double.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
 	testResolution(t, r, "conflict.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjE", nil)
 }
+
+func TestResolveDomains(t *testing.T) {
+	mock := newMockDNS()
+	r := &DNSResolver{lookupTXT: mock.lookupTXT}
+
+	var names []string
+	for i := 0; i < 3*dnsConcurrency; i++ {
+		names = append(names, "ipfs.example.com", "bad.example.com", "dns1.example.com")
+	}
+
+	var got []DomainResult
+	for res := range r.ResolveDomains(context.Background(), names) {
+		got = append(got, res)
+	}
+	if len(got) != len(names) {
+		t.Fatalf("expected %d results, got %d", len(names), len(got))
+	}
+	for i, res := range got {
+		if res.Name != names[i] {
+			t.Fatalf("expected the result %d to be %s, got %s", i, names[i], res.Name)
+		}
+		switch res.Name {
+		case "bad.example.com":
+			if res.Err != ErrResolveFailed {
+				t.Fatalf("expected %s to fail with ErrResolveFailed, got %v", res.Name, res.Err)
+			}
+		default:
+			if res.Err != nil || res.Path.String() != "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD" {
+				t.Fatalf("unexpected result for %s: %s, %v", res.Name, res.Path, res.Err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range r.ResolveDomains(ctx, names) {
+	}
+}
@@ -0,0 +1,100 @@
+package namesys
+
+import (
+	"context"
+	"time"
+
+	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	pb "gx/ipfs/QmbUUxB9ErnEQdwTzy6HTxucnBvAH4am6vsfbD8CiqKhi9/go-ipns/pb"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+// PublishEvent describes a record successfully published.
+type PublishEvent struct {
+	// Name is the peer ID of the key the record is published with, the
+	// name resolved under /ipns/.
+	Name  peer.ID
+	Value path.Path
+	// Sequence is the sequence number of the record, 0 if it couldn't be
+	// read back.
+	Sequence uint64
+	// Republish is whether the record was published by the republisher.
+	Republish bool
+}
+
+// PublishHook is called after each successful publish.
+type PublishHook func(PublishEvent)
+
+type hookedPublisher struct {
+	Publisher
+	ds        ds.Datastore
+	republish bool
+	hook      PublishHook
+}
+
+// NewHookedPublisher returns a Publisher publishing with pub, then calling
+// hook with the sequence number of the record pub stored in ds. republish is
+// passed on to the hook.
+func NewHookedPublisher(pub Publisher, ds ds.Datastore, republish bool, hook PublishHook) Publisher {
+	return &hookedPublisher{Publisher: pub, ds: ds, republish: republish, hook: hook}
+}
+
+func (p *hookedPublisher) Publish(ctx context.Context, k ci.PrivKey, value path.Path) error {
+	return p.PublishWithEOL(ctx, k, value, time.Now().Add(DefaultRecordTTL))
+}
+
+func (p *hookedPublisher) PublishWithEOL(ctx context.Context, k ci.PrivKey, value path.Path, eol time.Time) error {
+	if err := p.Publisher.PublishWithEOL(ctx, k, value, eol); err != nil {
+		return err
+	}
+	id, err := peer.IDFromPrivateKey(k)
+	if err != nil {
+		return err
+	}
+	p.hook(PublishEvent{
+		Name:      id,
+		Value:     value,
+		Sequence:  p.sequence(id),
+		Republish: p.republish,
+	})
+	return nil
+}
+
+// sequence returns the sequence number of the record of id stored in the
+// datastore.
+func (p *hookedPublisher) sequence(id peer.ID) uint64 {
+	data, err := p.ds.Get(IpnsDsKey(id))
+	if err != nil {
+		log.Debugf("cannot read the record of %s: %s", id.Pretty(), err)
+		return 0
+	}
+	e := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(data, e); err != nil {
+		log.Debugf("cannot read the record of %s: %s", id.Pretty(), err)
+		return 0
+	}
+	return e.GetSequence()
+}
+
+type hookedNameSystem struct {
+	NameSystem
+	pub Publisher
+}
+
+// WithPublishHook returns ns calling hook after each of its successful
+// publishes, see NewHookedPublisher.
+func WithPublishHook(ns NameSystem, ds ds.Datastore, hook PublishHook) NameSystem {
+	return &hookedNameSystem{NameSystem: ns, pub: NewHookedPublisher(ns, ds, false, hook)}
+}
+
+func (ns *hookedNameSystem) Publish(ctx context.Context, k ci.PrivKey, value path.Path) error {
+	return ns.pub.Publish(ctx, k, value)
+}
+
+func (ns *hookedNameSystem) PublishWithEOL(ctx context.Context, k ci.PrivKey, value path.Path, eol time.Time) error {
+	return ns.pub.PublishWithEOL(ctx, k, value, eol)
+}
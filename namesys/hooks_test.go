@@ -0,0 +1,64 @@
+package namesys
+
+import (
+	"context"
+	"testing"
+
+	path "gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	mockrouting "gx/ipfs/QmSNe4MWVxZWk6UxxW2z2EKofFo4GdFzud1vfn1iVby3mj/go-ipfs-routing/mock"
+	offroute "gx/ipfs/QmSNe4MWVxZWk6UxxW2z2EKofFo4GdFzud1vfn1iVby3mj/go-ipfs-routing/offline"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+)
+
+func TestPublishHook(t *testing.T) {
+	dst := dssync.MutexWrap(ds.NewMapDatastore())
+	priv, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routing := offroute.NewOfflineRouter(dst, mockrouting.MockValidator{})
+
+	var events []PublishEvent
+	hook := func(ev PublishEvent) {
+		events = append(events, ev)
+	}
+	base := NewNameSystem(routing, dst, 0)
+	nsys := WithPublishHook(base, dst, hook)
+	repub := NewHookedPublisher(base, dst, true, hook)
+
+	ctx := context.Background()
+	p1 := path.Path("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	p2 := path.Path("/ipfs/QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")
+	if err := nsys.Publish(ctx, priv, p1); err != nil {
+		t.Fatal(err)
+	}
+	if err := nsys.Publish(ctx, priv, p2); err != nil {
+		t.Fatal(err)
+	}
+	if err := repub.Publish(ctx, priv, p2); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []PublishEvent{
+		{Name: pid, Value: p1, Sequence: 0},
+		{Name: pid, Value: p2, Sequence: 1},
+		// republishing the same value keeps the sequence number
+		{Name: pid, Value: p2, Sequence: 1, Republish: true},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), events)
+	}
+	for i, ev := range events {
+		if ev != expected[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], ev)
+		}
+	}
+}
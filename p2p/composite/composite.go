@@ -0,0 +1,482 @@
+// Package composite composes routers, asking them all at once or one after
+// the other, with a timeout for each. The routers of a parallel router share
+// the providers they find according to their weights, so a router returning
+// many providers quickly doesn't crowd out the others.
+package composite
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	ropts "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/options"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+var log = logging.Logger("composite")
+
+// Child is a router of a composite router.
+type Child struct {
+	routing.IpfsRouting
+	// Name is the name of the router in the config, for the logs.
+	Name string
+	// Timeout bounds every request to the router, no timeout if zero.
+	Timeout time.Duration
+	// Weight is the share of the providers returned by the router, among
+	// the routers of a parallel router. It is one if zero.
+	Weight int
+	// IgnoreErrors makes the errors of the router fail no request.
+	IgnoreErrors bool
+}
+
+func (c *Child) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout > 0 {
+		return context.WithTimeout(ctx, c.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (c *Child) weight() int {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
+
+// failed returns err, unless the errors of c are ignored.
+func (c *Child) failed(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if c.IgnoreErrors {
+		log.Debugf("%s through %s: %s", op, c.Name, err)
+		return nil
+	}
+	return fmt.Errorf("%s: %s", c.Name, err)
+}
+
+// Walk calls f with r and, if r is a composite router, with all the routers
+// it is made of.
+func Walk(r routing.IpfsRouting, f func(routing.IpfsRouting)) {
+	f(r)
+	var children []Child
+	switch r := r.(type) {
+	case *Parallel:
+		children = r.Children
+	case *Sequential:
+		children = r.Children
+	}
+	for _, c := range children {
+		Walk(c.IpfsRouting, f)
+	}
+}
+
+// Parallel asks all its routers at once.
+type Parallel struct {
+	Children []Child
+	// Validator selects the best of the values found by the routers.
+	Validator record.Validator
+}
+
+var _ routing.IpfsRouting = (*Parallel)(nil)
+
+// found is a provider found by a router of a Parallel.
+type found struct {
+	child int
+	pi    pstore.PeerInfo
+	done  bool
+}
+
+// FindProvidersAsync merges the providers found by the routers. When count
+// is set, each router gets its share of count according to its weight, the
+// providers above it are held back until the share of another router that
+// is done is left unused.
+func (p *Parallel) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	out := make(chan pstore.PeerInfo)
+	ctx, cancel := context.WithCancel(ctx)
+
+	results := make(chan found)
+	for i := range p.Children {
+		go func(i int) {
+			child := &p.Children[i]
+			cctx, ccancel := child.context(ctx)
+			defer ccancel()
+			for pi := range child.FindProvidersAsync(cctx, c, count) {
+				select {
+				case results <- found{child: i, pi: pi}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case results <- found{child: i, done: true}:
+			case <-ctx.Done():
+			}
+		}(i)
+	}
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		shares := p.shares(count)
+		sent := make([]int, len(p.Children))
+		held := make([][]pstore.PeerInfo, len(p.Children))
+		seen := make(map[peer.ID]struct{})
+		total, spare, running := 0, 0, len(p.Children)
+
+		send := func(pi pstore.PeerInfo) bool {
+			select {
+			case out <- pi:
+				total++
+				return count <= 0 || total < count
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// release sends the held providers while shares are left unused,
+		// the routers with the greatest weights first.
+		release := func() bool {
+			for _, i := range p.byWeight() {
+				for spare > 0 && len(held[i]) > 0 {
+					pi := held[i][0]
+					held[i] = held[i][1:]
+					spare--
+					if !send(pi) {
+						return false
+					}
+				}
+			}
+			return true
+		}
+
+		for running > 0 {
+			var r found
+			select {
+			case r = <-results:
+			case <-ctx.Done():
+				return
+			}
+
+			if r.done {
+				running--
+				if count > 0 && sent[r.child] < shares[r.child] {
+					spare += shares[r.child] - sent[r.child]
+					sent[r.child] = shares[r.child]
+				}
+				if !release() {
+					return
+				}
+				continue
+			}
+
+			if _, ok := seen[r.pi.ID]; ok {
+				continue
+			}
+			seen[r.pi.ID] = struct{}{}
+			switch {
+			case count <= 0 || sent[r.child] < shares[r.child]:
+				sent[r.child]++
+			case spare > 0:
+				spare--
+			default:
+				held[r.child] = append(held[r.child], r.pi)
+				continue
+			}
+			if !send(r.pi) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// shares divides count between the routers according to their weights,
+// rounding up.
+func (p *Parallel) shares(count int) []int {
+	total := 0
+	for i := range p.Children {
+		total += p.Children[i].weight()
+	}
+	shares := make([]int, len(p.Children))
+	for i := range p.Children {
+		shares[i] = (count*p.Children[i].weight() + total - 1) / total
+	}
+	return shares
+}
+
+// byWeight returns the indexes of the routers, by decreasing weight.
+func (p *Parallel) byWeight() []int {
+	idx := make([]int, len(p.Children))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return p.Children[idx[a]].weight() > p.Children[idx[b]].weight()
+	})
+	return idx
+}
+
+// each calls f with every router at once, and returns the first error that
+// isn't ignored.
+func (p *Parallel) each(ctx context.Context, op string, f func(context.Context, *Child) error) error {
+	errs := make([]error, len(p.Children))
+	var wg sync.WaitGroup
+	for i := range p.Children {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			child := &p.Children[i]
+			cctx, cancel := child.context(ctx)
+			defer cancel()
+			errs[i] = child.failed(op, f(cctx, child))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindPeer returns the first addresses found by a router.
+func (p *Parallel) FindPeer(ctx context.Context, id peer.ID) (pstore.PeerInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		pi  pstore.PeerInfo
+		err error
+	}
+	results := make(chan result, len(p.Children))
+	for i := range p.Children {
+		go func(child *Child) {
+			cctx, ccancel := child.context(ctx)
+			defer ccancel()
+			pi, err := child.FindPeer(cctx, id)
+			if err != nil && err != routing.ErrNotFound {
+				if err = child.failed("find peer", err); err == nil {
+					err = routing.ErrNotFound
+				}
+			}
+			results <- result{pi, err}
+		}(&p.Children[i])
+	}
+
+	var firstErr error
+	for range p.Children {
+		r := <-results
+		if r.err == nil {
+			return r.pi, nil
+		}
+		if firstErr == nil && r.err != routing.ErrNotFound {
+			firstErr = r.err
+		}
+	}
+	if firstErr != nil {
+		return pstore.PeerInfo{}, firstErr
+	}
+	return pstore.PeerInfo{}, routing.ErrNotFound
+}
+
+func (p *Parallel) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return p.each(ctx, "provide", func(ctx context.Context, child *Child) error {
+		return child.Provide(ctx, c, announce)
+	})
+}
+
+func (p *Parallel) PutValue(ctx context.Context, key string, value []byte, opts ...ropts.Option) error {
+	return p.each(ctx, "put value", func(ctx context.Context, child *Child) error {
+		return child.PutValue(ctx, key, value, opts...)
+	})
+}
+
+// GetValue returns the best of the values found by the routers.
+func (p *Parallel) GetValue(ctx context.Context, key string, opts ...ropts.Option) ([]byte, error) {
+	var (
+		mu     sync.Mutex
+		values [][]byte
+	)
+	err := p.each(ctx, "get value", func(ctx context.Context, child *Child) error {
+		v, err := child.GetValue(ctx, key, opts...)
+		if err == routing.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		values = append(values, v)
+		mu.Unlock()
+		return nil
+	})
+	switch {
+	case len(values) == 0 && err != nil:
+		return nil, err
+	case len(values) == 0:
+		return nil, routing.ErrNotFound
+	case len(values) == 1 || p.Validator == nil:
+		return values[0], nil
+	}
+	best, err := p.Validator.Select(key, values)
+	if err != nil {
+		return nil, err
+	}
+	return values[best], nil
+}
+
+func (p *Parallel) Bootstrap(ctx context.Context) error {
+	// the routers keep bootstrapping after the call, they must not be
+	// cancelled by the timeouts
+	for i := range p.Children {
+		child := &p.Children[i]
+		if err := child.failed("bootstrap", child.Bootstrap(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sequential asks its routers one after the other, until one of them
+// answers.
+type Sequential struct {
+	Children []Child
+}
+
+var _ routing.IpfsRouting = (*Sequential)(nil)
+
+// FindProvidersAsync asks the routers in turn until count providers are
+// found.
+func (s *Sequential) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	out := make(chan pstore.PeerInfo)
+	go func() {
+		defer close(out)
+		seen := make(map[peer.ID]struct{})
+		for i := range s.Children {
+			child := &s.Children[i]
+			n := count
+			if n > 0 {
+				n -= len(seen)
+			}
+			cctx, cancel := child.context(ctx)
+			for pi := range child.FindProvidersAsync(cctx, c, n) {
+				if _, ok := seen[pi.ID]; ok {
+					continue
+				}
+				seen[pi.ID] = struct{}{}
+				select {
+				case out <- pi:
+				case <-ctx.Done():
+				}
+				if ctx.Err() != nil || (count > 0 && len(seen) >= count) {
+					cancel()
+					return
+				}
+			}
+			cancel()
+		}
+	}()
+	return out
+}
+
+// FindPeer returns the addresses found by the first router which finds
+// them.
+func (s *Sequential) FindPeer(ctx context.Context, id peer.ID) (pstore.PeerInfo, error) {
+	var firstErr error
+	for i := range s.Children {
+		child := &s.Children[i]
+		cctx, cancel := child.context(ctx)
+		pi, err := child.FindPeer(cctx, id)
+		cancel()
+		if err == nil {
+			return pi, nil
+		}
+		if err != routing.ErrNotFound {
+			if err := child.failed("find peer", err); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if ctx.Err() != nil {
+			return pstore.PeerInfo{}, ctx.Err()
+		}
+	}
+	if firstErr != nil {
+		return pstore.PeerInfo{}, firstErr
+	}
+	return pstore.PeerInfo{}, routing.ErrNotFound
+}
+
+// each calls f with every router in turn, and returns the first error that
+// isn't ignored.
+func (s *Sequential) each(ctx context.Context, op string, f func(context.Context, *Child) error) error {
+	var firstErr error
+	for i := range s.Children {
+		child := &s.Children[i]
+		cctx, cancel := child.context(ctx)
+		err := child.failed(op, f(cctx, child))
+		cancel()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Provide provides c through all the routers.
+func (s *Sequential) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return s.each(ctx, "provide", func(ctx context.Context, child *Child) error {
+		return child.Provide(ctx, c, announce)
+	})
+}
+
+// PutValue puts the value through all the routers.
+func (s *Sequential) PutValue(ctx context.Context, key string, value []byte, opts ...ropts.Option) error {
+	return s.each(ctx, "put value", func(ctx context.Context, child *Child) error {
+		return child.PutValue(ctx, key, value, opts...)
+	})
+}
+
+// GetValue returns the value found by the first router which finds one.
+func (s *Sequential) GetValue(ctx context.Context, key string, opts ...ropts.Option) ([]byte, error) {
+	var firstErr error
+	for i := range s.Children {
+		child := &s.Children[i]
+		cctx, cancel := child.context(ctx)
+		v, err := child.GetValue(cctx, key, opts...)
+		cancel()
+		if err == nil {
+			return v, nil
+		}
+		if err != routing.ErrNotFound {
+			if err := child.failed("get value", err); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, routing.ErrNotFound
+}
+
+func (s *Sequential) Bootstrap(ctx context.Context) error {
+	for i := range s.Children {
+		child := &s.Children[i]
+		if err := child.failed("bootstrap", child.Bootstrap(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
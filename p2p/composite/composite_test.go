@@ -0,0 +1,318 @@
+package composite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	ropts "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/options"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+// testRouter answers every request after delay, or when the request is
+// cancelled.
+type testRouter struct {
+	delay     time.Duration
+	providers []pstore.PeerInfo
+	// peer is whether the router finds the peers
+	peer  bool
+	value []byte
+	err   error
+
+	provided int32
+}
+
+func (r *testRouter) wait(ctx context.Context) error {
+	select {
+	case <-time.After(r.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *testRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	out := make(chan pstore.PeerInfo)
+	go func() {
+		defer close(out)
+		if r.wait(ctx) != nil {
+			return
+		}
+		for _, pi := range r.providers {
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (r *testRouter) FindPeer(ctx context.Context, id peer.ID) (pstore.PeerInfo, error) {
+	if err := r.wait(ctx); err != nil {
+		return pstore.PeerInfo{}, err
+	}
+	switch {
+	case r.err != nil:
+		return pstore.PeerInfo{}, r.err
+	case !r.peer:
+		return pstore.PeerInfo{}, routing.ErrNotFound
+	}
+	return pstore.PeerInfo{ID: id}, nil
+}
+
+func (r *testRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if err := r.wait(ctx); err != nil {
+		return err
+	}
+	atomic.AddInt32(&r.provided, 1)
+	return r.err
+}
+
+func (r *testRouter) PutValue(ctx context.Context, key string, value []byte, opts ...ropts.Option) error {
+	return r.err
+}
+
+func (r *testRouter) GetValue(ctx context.Context, key string, opts ...ropts.Option) ([]byte, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	switch {
+	case r.err != nil:
+		return nil, r.err
+	case r.value == nil:
+		return nil, routing.ErrNotFound
+	}
+	return r.value, nil
+}
+
+func (r *testRouter) Bootstrap(ctx context.Context) error {
+	return nil
+}
+
+// testValidator selects the greatest value.
+type testValidator struct{}
+
+func (testValidator) Validate(key string, value []byte) error {
+	return nil
+}
+
+func (testValidator) Select(key string, values [][]byte) (int, error) {
+	best := 0
+	for i, v := range values {
+		if bytes.Compare(v, values[best]) > 0 {
+			best = i
+		}
+	}
+	return best, nil
+}
+
+func peers(prefix string, n int) []pstore.PeerInfo {
+	pis := make([]pstore.PeerInfo, n)
+	for i := range pis {
+		pis[i].ID = peer.ID(fmt.Sprintf("%s%d", prefix, i))
+	}
+	return pis
+}
+
+// providers returns the number of providers found by each router, by the
+// prefix of their IDs.
+func providers(ch <-chan pstore.PeerInfo) map[string]int {
+	found := make(map[string]int)
+	for pi := range ch {
+		found[string(pi.ID)[:1]]++
+	}
+	return found
+}
+
+func TestParallelWeights(t *testing.T) {
+	fast := &testRouter{providers: peers("f", 10)}
+	slow := &testRouter{delay: 50 * time.Millisecond, providers: peers("s", 10)}
+	p := &Parallel{Children: []Child{
+		{IpfsRouting: fast, Name: "fast", Weight: 1},
+		{IpfsRouting: slow, Name: "slow", Weight: 2},
+	}}
+
+	found := providers(p.FindProvidersAsync(context.Background(), cid.Cid{}, 6))
+	if found["f"] != 2 || found["s"] != 4 {
+		t.Fatalf("expected 2 fast and 4 slow providers, got %v", found)
+	}
+
+	// the share of a router finding too few providers goes to the others
+	slow.providers = peers("s", 1)
+	found = providers(p.FindProvidersAsync(context.Background(), cid.Cid{}, 6))
+	if found["f"] != 5 || found["s"] != 1 {
+		t.Fatalf("expected 5 fast and 1 slow providers, got %v", found)
+	}
+
+	// without a count, all the providers are returned
+	found = providers(p.FindProvidersAsync(context.Background(), cid.Cid{}, 0))
+	if found["f"] != 10 || found["s"] != 1 {
+		t.Fatalf("expected all the providers, got %v", found)
+	}
+}
+
+func TestParallelTimeout(t *testing.T) {
+	hung := &testRouter{delay: time.Hour, providers: peers("h", 1), peer: true, value: []byte("z")}
+	quick := &testRouter{providers: peers("q", 3), peer: true, value: []byte("a")}
+	p := &Parallel{
+		Children: []Child{
+			{IpfsRouting: hung, Name: "hung", Timeout: 50 * time.Millisecond},
+			{IpfsRouting: quick, Name: "quick"},
+		},
+		Validator: testValidator{},
+	}
+
+	start := time.Now()
+	found := providers(p.FindProvidersAsync(context.Background(), cid.Cid{}, 4))
+	if found["q"] != 3 || found["h"] != 0 {
+		t.Fatalf("expected the quick providers, got %v", found)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("the timeout wasn't applied")
+	}
+
+	if _, err := p.FindPeer(context.Background(), "x"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := p.GetValue(context.Background(), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "a" {
+		t.Fatalf("expected the value of the quick router, got %q", v)
+	}
+
+	// the timeout of a router fails the requests publishing records
+	if err := p.Provide(context.Background(), cid.Cid{}, true); err == nil {
+		t.Fatal("expected the timeout to fail the provide")
+	}
+	p.Children[0].IgnoreErrors = true
+	if err := p.Provide(context.Background(), cid.Cid{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&quick.provided) != 2 {
+		t.Fatal("expected the quick router to provide")
+	}
+}
+
+func TestParallelGetValueSelects(t *testing.T) {
+	p := &Parallel{
+		Children: []Child{
+			{IpfsRouting: &testRouter{value: []byte("v1")}, Name: "a"},
+			{IpfsRouting: &testRouter{value: []byte("v2")}, Name: "b"},
+			{IpfsRouting: &testRouter{}, Name: "c"},
+		},
+		Validator: testValidator{},
+	}
+	v, err := p.GetValue(context.Background(), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "v2" {
+		t.Fatalf("expected the best value, got %q", v)
+	}
+}
+
+func TestSequential(t *testing.T) {
+	broken := &testRouter{err: errors.New("broken")}
+	empty := &testRouter{}
+	full := &testRouter{providers: peers("p", 5), peer: true, value: []byte("v")}
+	s := &Sequential{Children: []Child{
+		{IpfsRouting: broken, Name: "broken", IgnoreErrors: true},
+		{IpfsRouting: empty, Name: "empty"},
+		{IpfsRouting: full, Name: "full"},
+	}}
+
+	found := providers(s.FindProvidersAsync(context.Background(), cid.Cid{}, 3))
+	if found["p"] != 3 {
+		t.Fatalf("expected 3 providers, got %v", found)
+	}
+	if _, err := s.FindPeer(context.Background(), "x"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.GetValue(context.Background(), "k")
+	if err != nil || string(v) != "v" {
+		t.Fatalf("expected the value of the last router, got %q, %v", v, err)
+	}
+	if err := s.Provide(context.Background(), cid.Cid{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if empty.provided != 1 || full.provided != 1 {
+		t.Fatal("expected all the routers to provide")
+	}
+
+	s.Children[0].IgnoreErrors = false
+	if _, err := s.GetValue(context.Background(), "k"); err != nil {
+		t.Fatal("expected a later router to answer")
+	}
+	if err := s.Provide(context.Background(), cid.Cid{}, true); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected the error of the broken router, got %v", err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	routers := map[string]RouterConfig{
+		"a": {Type: "test"},
+		"b": {Type: "test"},
+		"both": {Type: TypeParallel, Parameters: Parameters{Routers: []ChildConfig{
+			{Router: "a", Timeout: "10s", Weight: 2},
+			{Router: "b"},
+		}}},
+		"main": {Type: TypeSequential, Parameters: Parameters{Routers: []ChildConfig{
+			{Router: "both"},
+			{Router: "a", IgnoreErrors: true},
+		}}},
+	}
+	built := make(map[string]int)
+	build := func(name string, cfg RouterConfig) (routing.IpfsRouting, error) {
+		if cfg.Type != "test" {
+			return nil, errors.New("unknown type")
+		}
+		built[name]++
+		return &testRouter{}, nil
+	}
+
+	r, err := Build(routers, "main", nil, build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built["a"] != 1 || built["b"] != 1 {
+		t.Fatalf("expected every router to be built once, got %v", built)
+	}
+	s := r.(*Sequential)
+	both := s.Children[0].IpfsRouting.(*Parallel)
+	if both.Children[0].Timeout != 10*time.Second || both.Children[0].Weight != 2 {
+		t.Fatalf("unexpected child: %+v", both.Children[0])
+	}
+	if both.Children[0].IpfsRouting != s.Children[1].IpfsRouting {
+		t.Fatal("expected the router a to be shared")
+	}
+	walked := 0
+	Walk(r, func(routing.IpfsRouting) { walked++ })
+	if walked != 5 {
+		t.Fatalf("expected to walk 5 routers, walked %d", walked)
+	}
+
+	for root, cfg := range map[string]RouterConfig{
+		"missing": {Type: TypeParallel, Parameters: Parameters{Routers: []ChildConfig{{Router: "c"}}}},
+		"cycle":   {Type: TypeSequential, Parameters: Parameters{Routers: []ChildConfig{{Router: "cycle"}}}},
+		"timeout": {Type: TypeParallel, Parameters: Parameters{Routers: []ChildConfig{{Router: "a", Timeout: "soon"}}}},
+		"empty":   {Type: TypeParallel},
+		"type":    {Type: "dns"},
+	} {
+		routers[root] = cfg
+		if _, err := Build(routers, root, nil, build); err == nil {
+			t.Errorf("expected %s to fail", root)
+		}
+	}
+}
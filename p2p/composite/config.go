@@ -0,0 +1,143 @@
+package composite
+
+import (
+	"fmt"
+	"time"
+
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+)
+
+// The types of the composite routers.
+const (
+	TypeParallel   = "parallel"
+	TypeSequential = "sequential"
+)
+
+// RouterConfig is a router of the Routing.Routers config section.
+type RouterConfig struct {
+	// Type is TypeParallel, TypeSequential, or the type of a router built
+	// by the caller of Build.
+	Type       string
+	Parameters Parameters
+}
+
+// Parameters are the parameters of a router, depending on its type.
+type Parameters struct {
+	// Routers are the routers of a composite router.
+	Routers []ChildConfig `json:",omitempty"`
+
+	// Mode is the mode of a DHT router.
+	Mode string `json:",omitempty"`
+
+	// Endpoints are the delegates of a delegated router.
+	Endpoints []string `json:",omitempty"`
+}
+
+// ChildConfig is a router of a composite router.
+type ChildConfig struct {
+	// Router is the name of the router in Routing.Routers.
+	Router string
+	// Timeout is a duration like "10s", no timeout if empty.
+	Timeout string `json:",omitempty"`
+	// Weight is the share of the providers of the router, in a parallel
+	// router.
+	Weight int `json:",omitempty"`
+	// IgnoreErrors makes the errors of the router fail no request.
+	IgnoreErrors bool `json:",omitempty"`
+}
+
+// BuildFunc builds the router name of cfg, whose type isn't a composite
+// one.
+type BuildFunc func(name string, cfg RouterConfig) (routing.IpfsRouting, error)
+
+// Build returns the router named root in routers. The composite routers
+// are built here, the others by build; every router is built once, however
+// many composite routers it is part of.
+func Build(routers map[string]RouterConfig, root string, validator record.Validator, build BuildFunc) (routing.IpfsRouting, error) {
+	b := &builder{
+		routers:   routers,
+		validator: validator,
+		build:     build,
+		built:     make(map[string]routing.IpfsRouting),
+		building:  make(map[string]bool),
+	}
+	return b.router(root)
+}
+
+type builder struct {
+	routers   map[string]RouterConfig
+	validator record.Validator
+	build     BuildFunc
+	built     map[string]routing.IpfsRouting
+	// building are the routers being built, to detect the cycles
+	building map[string]bool
+}
+
+func (b *builder) router(name string) (routing.IpfsRouting, error) {
+	if r, ok := b.built[name]; ok {
+		return r, nil
+	}
+	cfg, ok := b.routers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown router %q", name)
+	}
+	if b.building[name] {
+		return nil, fmt.Errorf("router %q is part of itself", name)
+	}
+	b.building[name] = true
+	defer delete(b.building, name)
+
+	var r routing.IpfsRouting
+	switch cfg.Type {
+	case TypeParallel, TypeSequential:
+		children, err := b.children(name, cfg.Parameters.Routers)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Type == TypeParallel {
+			r = &Parallel{Children: children, Validator: b.validator}
+		} else {
+			r = &Sequential{Children: children}
+		}
+	default:
+		var err error
+		r, err = b.build(name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("router %q: %s", name, err)
+		}
+	}
+	b.built[name] = r
+	return r, nil
+}
+
+func (b *builder) children(name string, cfgs []ChildConfig) ([]Child, error) {
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("router %q: no routers", name)
+	}
+	children := make([]Child, 0, len(cfgs))
+	for _, cc := range cfgs {
+		child := Child{
+			Name:         cc.Router,
+			Weight:       cc.Weight,
+			IgnoreErrors: cc.IgnoreErrors,
+		}
+		if cc.Timeout != "" {
+			d, err := time.ParseDuration(cc.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("router %q: invalid timeout of %q: %q", name, cc.Router, cc.Timeout)
+			}
+			child.Timeout = d
+		}
+		if cc.Weight < 0 {
+			return nil, fmt.Errorf("router %q: negative weight of %q", name, cc.Router)
+		}
+		r, err := b.router(cc.Router)
+		if err != nil {
+			return nil, err
+		}
+		child.IpfsRouting = r
+		children = append(children, child)
+	}
+	return children, nil
+}
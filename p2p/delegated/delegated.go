@@ -0,0 +1,286 @@
+// Package delegated routes through the HTTP API of delegate IPFS nodes, for
+// light clients which don't take part in the DHT: the delegates look the
+// providers and the peers up for them. Only the routing commands of the
+// API are used, so any go-ipfs node exposing them can be a delegate.
+package delegated
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	notif "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/notifications"
+	ropts "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/options"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+var log = logging.Logger("delegated")
+
+const (
+	requestTimeout = time.Minute
+
+	// defaultNumProviders is the number of providers asked to the delegates
+	// when the caller doesn't limit them.
+	defaultNumProviders = 20
+)
+
+// ErrValuesNotSupported is returned when putting or getting values, e.g.
+// IPNS records: the API of the delegates can't carry binary values.
+var ErrValuesNotSupported = errors.New("the delegated routing doesn't support values")
+
+// ParseDelegate returns the base URL of the API of a delegate, given as an
+// URL or as the multiaddr of its API, e.g. /ip4/127.0.0.1/tcp/5001.
+func ParseDelegate(s string) (string, error) {
+	if strings.HasPrefix(s, "/") {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid delegate %q: %s", s, err)
+		}
+		_, host, err := manet.DialArgs(a)
+		if err != nil {
+			return "", fmt.Errorf("invalid delegate %q: %s", s, err)
+		}
+		return "http://" + host, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid delegate %q: %s", s, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid delegate %q: expected an http(s) URL or a multiaddr", s)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// Router is a routing.IpfsRouting asking the delegates.
+type Router struct {
+	delegates []string
+	client    *http.Client
+}
+
+var _ routing.IpfsRouting = (*Router)(nil)
+
+// New returns a Router asking the delegates, given as accepted by
+// ParseDelegate.
+func New(delegates []string) (*Router, error) {
+	r := &Router{client: &http.Client{}}
+	for _, s := range delegates {
+		d, err := ParseDelegate(s)
+		if err != nil {
+			return nil, err
+		}
+		r.delegates = append(r.delegates, d)
+	}
+	return r, nil
+}
+
+// Delegates returns the base URLs of the APIs of the delegates.
+func (r *Router) Delegates() []string {
+	return append([]string(nil), r.delegates...)
+}
+
+// queryEvent is the output of the dht commands of the API. The peers are
+// parsed by the router, a malformed one shouldn't fail the whole request.
+type queryEvent struct {
+	Type      notif.QueryEventType
+	Responses []struct {
+		ID    string
+		Addrs []string
+	}
+	Extra string
+}
+
+func (ev *queryEvent) peers() []pstore.PeerInfo {
+	var pis []pstore.PeerInfo
+	for _, resp := range ev.Responses {
+		id, err := peer.IDB58Decode(resp.ID)
+		if err != nil {
+			log.Debugf("invalid peer %q from a delegate: %s", resp.ID, err)
+			continue
+		}
+		pi := pstore.PeerInfo{ID: id}
+		for _, s := range resp.Addrs {
+			a, err := ma.NewMultiaddr(s)
+			if err != nil {
+				continue
+			}
+			pi.Addrs = append(pi.Addrs, a)
+		}
+		pis = append(pis, pi)
+	}
+	return pis
+}
+
+// request calls the API command cmd of the delegate d, passing each event of
+// the output to handle until it returns false.
+func (r *Router) request(ctx context.Context, d, cmd string, args url.Values, handle func(*queryEvent) bool) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", d+"/api/v0/"+cmd+"?"+args.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct{ Message string }
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if json.Unmarshal(b, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		return fmt.Errorf("%s: %s", resp.Status, apiErr.Message)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev queryEvent
+		switch err := dec.Decode(&ev); err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+		if !handle(&ev) {
+			return nil
+		}
+	}
+}
+
+// FindProvidersAsync asks all the delegates for the providers of c.
+func (r *Router) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	out := make(chan pstore.PeerInfo)
+	ctx, cancel := context.WithCancel(ctx)
+
+	num := count
+	if num <= 0 {
+		num = defaultNumProviders
+	}
+	args := url.Values{
+		"arg":           {c.String()},
+		"num-providers": {strconv.Itoa(num)},
+	}
+
+	var (
+		mu   sync.Mutex
+		seen = make(map[peer.ID]struct{})
+		wg   sync.WaitGroup
+	)
+	send := func(pi pstore.PeerInfo) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if count > 0 && len(seen) >= count {
+			return false
+		}
+		if _, ok := seen[pi.ID]; ok {
+			return true
+		}
+		seen[pi.ID] = struct{}{}
+		select {
+		case out <- pi:
+		case <-ctx.Done():
+			return false
+		}
+		return count <= 0 || len(seen) < count
+	}
+
+	for _, d := range r.delegates {
+		wg.Add(1)
+		go func(d string) {
+			defer wg.Done()
+			err := r.request(ctx, d, "dht/findprovs", args, func(ev *queryEvent) bool {
+				if ev.Type != notif.Provider {
+					return true
+				}
+				for _, pi := range ev.peers() {
+					if !send(pi) {
+						return false
+					}
+				}
+				return true
+			})
+			if err != nil && ctx.Err() == nil {
+				log.Warningf("cannot find the providers of %s through %s: %s", c, d, err)
+			}
+		}(d)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(out)
+	}()
+	return out
+}
+
+// FindPeer asks the delegates for the addresses of p, in turn.
+func (r *Router) FindPeer(ctx context.Context, p peer.ID) (pstore.PeerInfo, error) {
+	args := url.Values{"arg": {p.Pretty()}}
+	for _, d := range r.delegates {
+		var found *pstore.PeerInfo
+		err := r.request(ctx, d, "dht/findpeer", args, func(ev *queryEvent) bool {
+			if ev.Type != notif.FinalPeer {
+				return true
+			}
+			for _, pi := range ev.peers() {
+				if pi.ID == p {
+					found = &pi
+					return false
+				}
+			}
+			return true
+		})
+		if found != nil {
+			return *found, nil
+		}
+		if err != nil {
+			log.Debugf("cannot find %s through %s: %s", p, d, err)
+		}
+		if ctx.Err() != nil {
+			return pstore.PeerInfo{}, ctx.Err()
+		}
+	}
+	return pstore.PeerInfo{}, routing.ErrNotFound
+}
+
+// Provide does nothing: the delegates only provide the blocks they store, so
+// the content of a light client is fetched from it by the peers connected to
+// it.
+func (r *Router) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return nil
+}
+
+// PutValue returns ErrValuesNotSupported.
+func (r *Router) PutValue(ctx context.Context, key string, value []byte, opts ...ropts.Option) error {
+	return ErrValuesNotSupported
+}
+
+// GetValue returns ErrValuesNotSupported.
+func (r *Router) GetValue(ctx context.Context, key string, opts ...ropts.Option) ([]byte, error) {
+	return nil, ErrValuesNotSupported
+}
+
+// Bootstrap does nothing, the delegates are reached over HTTP.
+func (r *Router) Bootstrap(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,150 @@
+package delegated
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	notif "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/notifications"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+const (
+	peer1 = "QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN"
+	peer2 = "QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa"
+)
+
+type delegateResponse struct {
+	ID    string
+	Addrs []string
+}
+
+type delegateEvent struct {
+	Type      notif.QueryEventType
+	Responses []delegateResponse
+	Extra     string
+}
+
+// newDelegate emulates the API of a delegate, answering with the events of
+// each command.
+func newDelegate(t *testing.T, events map[string][]delegateEvent) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		evs, ok := events[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"Message": "unknown command", "Code": 0})
+			return
+		}
+		if req.URL.Query().Get("arg") == "" {
+			t.Errorf("%s called without an argument", req.URL.Path)
+		}
+		enc := json.NewEncoder(w)
+		for _, ev := range evs {
+			enc.Encode(ev)
+		}
+	}))
+}
+
+func TestParseDelegate(t *testing.T) {
+	for in, out := range map[string]string{
+		"/ip4/127.0.0.1/tcp/5001":       "http://127.0.0.1:5001",
+		"https://delegate.example.com/": "https://delegate.example.com",
+		"http://127.0.0.1:5001":         "http://127.0.0.1:5001",
+	} {
+		d, err := ParseDelegate(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != out {
+			t.Errorf("%s: expected %s, got %s", in, out, d)
+		}
+	}
+	for _, in := range []string{"/ip4/127.0.0.1", "ftp://delegate.example.com", "delegate.example.com"} {
+		if _, err := ParseDelegate(in); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+}
+
+func TestFindProviders(t *testing.T) {
+	d1 := newDelegate(t, map[string][]delegateEvent{
+		"/api/v0/dht/findprovs": {
+			{Type: notif.SendingQuery},
+			{Type: notif.Provider, Responses: []delegateResponse{{ID: peer1, Addrs: []string{"/ip4/1.2.3.4/tcp/4001"}}}},
+		},
+	})
+	defer d1.Close()
+	d2 := newDelegate(t, map[string][]delegateEvent{
+		"/api/v0/dht/findprovs": {
+			{Type: notif.Provider, Responses: []delegateResponse{{ID: peer1}, {ID: "invalid"}}},
+			{Type: notif.Provider, Responses: []delegateResponse{{ID: peer2}}},
+		},
+	})
+	defer d2.Close()
+	broken := newDelegate(t, nil)
+	defer broken.Close()
+
+	r, err := New([]string{d1.URL, d2.URL, broken.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cid.NewCidV0([]byte("\x12\x20aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+
+	found := make(map[peer.ID]pstore.PeerInfo)
+	for pi := range r.FindProvidersAsync(context.Background(), c, 0) {
+		if _, ok := found[pi.ID]; ok {
+			t.Fatalf("%s found twice", pi.ID)
+		}
+		found[pi.ID] = pi
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected 2 providers, got %v", found)
+	}
+
+	n := 0
+	for range r.FindProvidersAsync(context.Background(), c, 1) {
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 provider, got %d", n)
+	}
+}
+
+func TestFindPeer(t *testing.T) {
+	broken := newDelegate(t, nil)
+	defer broken.Close()
+	d := newDelegate(t, map[string][]delegateEvent{
+		"/api/v0/dht/findpeer": {
+			{Type: notif.FinalPeer, Responses: []delegateResponse{{ID: peer1, Addrs: []string{"/ip4/1.2.3.4/tcp/4001"}}}},
+		},
+	})
+	defer d.Close()
+
+	r, err := New([]string{broken.URL, d.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDB58Decode(peer1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi, err := r.FindPeer(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pi.Addrs) != 1 || pi.Addrs[0].String() != "/ip4/1.2.3.4/tcp/4001" {
+		t.Fatalf("unexpected addresses %v", pi.Addrs)
+	}
+
+	other, err := peer.IDB58Decode(peer2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.FindPeer(context.Background(), other); err == nil {
+		t.Fatal("expected an unknown peer not to be found")
+	}
+}
@@ -0,0 +1,578 @@
+// Package fullrt is the accelerated DHT client. It crawls the DHT
+// periodically to hold a nearly complete table of its peers, then sends the
+// requests straight to the peers closest to their keys, in a single round
+// trip, instead of walking the DHT towards them hop by hop. Until its first
+// crawl completed, it routes through the DHT.
+//
+// The crawl asks every peer found for the peers closest to keys nearer and
+// nearer to its own, which costs a connection to each peer of the DHT and
+// some memory for their addresses.
+package fullrt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/fullrt/pb"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	goprocess "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
+	gpctx "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess/context"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	ropts "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/options"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	ggio "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/io"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("fullrt")
+
+// ProtocolDHT is the protocol of the DHT.
+const ProtocolDHT = protocol.ID("/ipfs/kad/1.0.0")
+
+const (
+	// DefaultCrawlInterval is the default interval between the crawls.
+	DefaultCrawlInterval = time.Hour
+
+	// InitialCrawlDelay gives the node the time to connect to the
+	// bootstrap peers the first crawl starts from.
+	InitialCrawlDelay = 10 * time.Second
+
+	// FailureRetryInterval is the interval the crawl is retried at while
+	// it fails.
+	FailureRetryInterval = time.Minute
+
+	// bucketSize is the number of peers closest to a key the requests are
+	// sent to, the replication factor of the DHT.
+	bucketSize = 20
+
+	// crawlConcurrency is the number of peers crawled at once.
+	crawlConcurrency = 64
+
+	crawlTimeout   = 10 * time.Second
+	requestTimeout = 30 * time.Second
+	maxMessageSize = 4 << 20
+)
+
+// crawlPrefixes are the lengths of the prefixes the keys each peer is asked
+// the closest peers of share with its own key: the peers answer with the
+// peers of their buckets for these prefixes, down to their neighbors. The
+// peers can't be asked for their own IDs, they answer with themselves.
+var crawlPrefixes = []int{0, 4, 8, 12}
+
+// Stats describe the table of the Router.
+type Stats struct {
+	// Peers is the number of peers of the table.
+	Peers int
+	// LastCrawl is when the last crawl completed, zero before the first.
+	LastCrawl time.Time
+	// CrawlDuration is how long the last crawl took.
+	CrawlDuration time.Duration
+}
+
+// Router is a routing.IpfsRouting sending the requests to the peers its
+// table tells are the closest to their keys. It falls back to the DHT, the
+// fallback router, before the first crawl and for the lookups the closest
+// peers didn't answer.
+type Router struct {
+	host      p2phost.Host
+	fallback  routing.IpfsRouting
+	validator record.Validator
+
+	// CrawlInterval is the interval between the crawls, DefaultCrawlInterval
+	// by default.
+	CrawlInterval time.Duration
+
+	mu    sync.RWMutex
+	peers map[peer.ID][]byte // the crawled peers, to their keys in the DHT
+	stats Stats
+}
+
+var _ routing.IpfsRouting = (*Router)(nil)
+
+// New returns a Router of the DHT h is connected to, falling back to
+// fallback.
+func New(h p2phost.Host, fallback routing.IpfsRouting, validator record.Validator) *Router {
+	return &Router{
+		host:          h,
+		fallback:      fallback,
+		validator:     validator,
+		CrawlInterval: DefaultCrawlInterval,
+		peers:         make(map[peer.ID][]byte),
+	}
+}
+
+// Run crawls the DHT every CrawlInterval until proc is closed.
+func (r *Router) Run(proc goprocess.Process) {
+	ctx := gpctx.OnClosingContext(proc)
+	timer := time.NewTimer(InitialCrawlDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(r.CrawlInterval)
+			if err := r.Crawl(ctx); err != nil {
+				log.Info("the crawl of the DHT failed: ", err)
+				if FailureRetryInterval < r.CrawlInterval {
+					timer.Reset(FailureRetryInterval)
+				}
+			}
+		case <-proc.Closing():
+			return
+		}
+	}
+}
+
+// Stats returns the stats of the table.
+func (r *Router) Stats() Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.stats
+}
+
+// ready returns whether the DHT was crawled.
+func (r *Router) ready() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.peers) > 0
+}
+
+// Crawl crawls the DHT from the connected peers, replacing the table with
+// the peers which answered.
+func (r *Router) Crawl(ctx context.Context) error {
+	start := time.Now()
+	self := r.host.ID()
+	seeds := r.host.Network().Peers()
+	if len(seeds) == 0 {
+		return errors.New("no peer to start from")
+	}
+
+	var (
+		mu    sync.Mutex
+		seen  = make(map[peer.ID]struct{})
+		found = make(map[peer.ID][]byte)
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, crawlConcurrency)
+	)
+	var visit func(p peer.ID)
+	visit = func(p peer.ID) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := seen[p]; ok || p == self {
+			return
+		}
+		seen[p] = struct{}{}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			pis, err := r.crawlPeer(ctx, p)
+			<-sem
+			if err != nil {
+				log.Debugf("cannot crawl %s: %s", p.Pretty(), err)
+				return
+			}
+
+			mu.Lock()
+			found[p] = dhtKey(string(p))
+			mu.Unlock()
+			for _, pi := range pis {
+				if pi.ID == self || len(pi.Addrs) == 0 {
+					continue
+				}
+				// keep the addresses until the next crawl
+				r.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, 2*r.CrawlInterval)
+				visit(pi.ID)
+			}
+		}()
+	}
+	for _, p := range seeds {
+		visit(p)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(found) == 0 {
+		return errors.New("no peer answered")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = found
+	r.stats = Stats{Peers: len(found), LastCrawl: time.Now(), CrawlDuration: time.Since(start)}
+	log.Infof("crawled %d peers of the DHT in %s", len(found), r.stats.CrawlDuration)
+	return nil
+}
+
+// crawlPeer asks p for the peers closest to keys of crawlPrefixes.
+func (r *Router) crawlPeer(ctx context.Context, p peer.ID) ([]pstore.PeerInfo, error) {
+	var reqs []*pb.Message
+	target := dhtKey(string(p))
+	for _, bits := range crawlPrefixes {
+		key, err := keyNear(target, bits)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, &pb.Message{Type: pb.Message_FIND_NODE, Key: key})
+	}
+
+	var pis []pstore.PeerInfo
+	err := r.exchange(ctx, p, crawlTimeout, reqs, func(resp *pb.Message) {
+		for _, pp := range resp.CloserPeers {
+			if pi, err := peerFromPb(pp); err == nil {
+				pis = append(pis, pi)
+			}
+		}
+	})
+	return pis, err
+}
+
+// exchange sends reqs to p, one after the other on a stream, calling handle
+// with their responses. Without handle, the requests are only sent: the DHT
+// doesn't answer the ADD_PROVIDER requests.
+func (r *Router) exchange(ctx context.Context, p peer.ID, timeout time.Duration, reqs []*pb.Message, handle func(*pb.Message)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	s, err := r.host.NewStream(ctx, p, ProtocolDHT)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Reset()
+		case <-done:
+		}
+	}()
+
+	w := ggio.NewDelimitedWriter(s)
+	rd := ggio.NewDelimitedReader(s, maxMessageSize)
+	for _, req := range reqs {
+		if err := w.WriteMsg(req); err != nil {
+			s.Reset()
+			return err
+		}
+		if handle == nil {
+			continue
+		}
+		var resp pb.Message
+		if err := rd.ReadMsg(&resp); err != nil {
+			s.Reset()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		handle(&resp)
+	}
+	return s.Close()
+}
+
+// closest returns the peers of the table closest to key.
+func (r *Router) closest(key string, count int) []peer.ID {
+	target := dhtKey(key)
+
+	r.mu.RLock()
+	type entry struct {
+		id   peer.ID
+		dist []byte
+	}
+	entries := make([]entry, 0, len(r.peers))
+	for p, k := range r.peers {
+		entries = append(entries, entry{p, xor(k, target)})
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].dist, entries[j].dist) < 0
+	})
+	if len(entries) > count {
+		entries = entries[:count]
+	}
+	out := make([]peer.ID, len(entries))
+	for i, e := range entries {
+		out[i] = e.id
+	}
+	return out
+}
+
+// requestClosest sends req to the peers closest to key at once, calling
+// handle with their responses as they come, one at a time. It returns the
+// number of peers the request succeeded with.
+func (r *Router) requestClosest(ctx context.Context, key string, req *pb.Message, handle func(peer.ID, *pb.Message)) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var ok int
+	for _, p := range r.closest(key, bucketSize) {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			var h func(*pb.Message)
+			if handle != nil {
+				h = func(resp *pb.Message) {
+					mu.Lock()
+					defer mu.Unlock()
+					handle(p, resp)
+				}
+			}
+			if err := r.exchange(ctx, p, requestTimeout, []*pb.Message{req}, h); err != nil {
+				log.Debugf("%s request to %s failed: %s", req.Type, p.Pretty(), err)
+				return
+			}
+			mu.Lock()
+			ok++
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+	return ok
+}
+
+// PutValue stores the value on the peers closest to key.
+func (r *Router) PutValue(ctx context.Context, key string, value []byte, opts ...ropts.Option) error {
+	var cfg ropts.Options
+	if err := cfg.Apply(opts...); err != nil {
+		return err
+	}
+	if cfg.Offline || !r.ready() {
+		return r.fallback.PutValue(ctx, key, value, opts...)
+	}
+	if err := r.validator.Validate(key, value); err != nil {
+		return err
+	}
+
+	n := r.requestClosest(ctx, key, &pb.Message{
+		Type:   pb.Message_PUT_VALUE,
+		Key:    []byte(key),
+		Record: &pb.Record{Key: []byte(key), Value: value},
+	}, func(peer.ID, *pb.Message) {})
+	if n == 0 {
+		return fmt.Errorf("no peer stored the value of %s", key)
+	}
+	return nil
+}
+
+// GetValue returns the best value of the peers closest to key, or the one of
+// the DHT if they have none.
+func (r *Router) GetValue(ctx context.Context, key string, opts ...ropts.Option) ([]byte, error) {
+	var cfg ropts.Options
+	if err := cfg.Apply(opts...); err != nil {
+		return nil, err
+	}
+	if cfg.Offline || !r.ready() {
+		return r.fallback.GetValue(ctx, key, opts...)
+	}
+
+	var values [][]byte
+	r.requestClosest(ctx, key, &pb.Message{
+		Type: pb.Message_GET_VALUE,
+		Key:  []byte(key),
+	}, func(p peer.ID, resp *pb.Message) {
+		rec := resp.GetRecord()
+		if len(rec.GetValue()) == 0 || !bytes.Equal(rec.GetKey(), []byte(key)) {
+			return
+		}
+		if err := r.validator.Validate(key, rec.Value); err != nil {
+			log.Debugf("invalid value of %s from %s: %s", key, p.Pretty(), err)
+			return
+		}
+		values = append(values, rec.Value)
+	})
+	if len(values) == 0 {
+		return r.fallback.GetValue(ctx, key, opts...)
+	}
+	i, err := r.validator.Select(key, values)
+	if err != nil {
+		return nil, err
+	}
+	return values[i], nil
+}
+
+// Provide records the node as a provider of c, on the peers closest to c
+// when announced.
+func (r *Router) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if !announce || !r.ready() {
+		return r.fallback.Provide(ctx, c, announce)
+	}
+	// the DHT keeps the local provider record, answering for c itself
+	if err := r.fallback.Provide(ctx, c, false); err != nil {
+		return err
+	}
+
+	self := pstore.PeerInfo{ID: r.host.ID(), Addrs: r.host.Addrs()}
+	n := r.requestClosest(ctx, string(c.Bytes()), &pb.Message{
+		Type:          pb.Message_ADD_PROVIDER,
+		Key:           c.Bytes(),
+		ProviderPeers: []*pb.Message_Peer{peerToPb(self)},
+	}, nil)
+	if n == 0 {
+		return fmt.Errorf("no peer stored the provider record of %s", c)
+	}
+	return nil
+}
+
+// FindProvidersAsync returns the providers of c known to the peers closest
+// to c, or to the DHT if they know none, up to count if it isn't zero.
+func (r *Router) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	if !r.ready() {
+		return r.fallback.FindProvidersAsync(ctx, c, count)
+	}
+
+	out := make(chan pstore.PeerInfo)
+	go func() {
+		defer close(out)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		seen := make(map[peer.ID]struct{})
+		send := func(pi pstore.PeerInfo) bool {
+			if _, ok := seen[pi.ID]; ok {
+				return true
+			}
+			seen[pi.ID] = struct{}{}
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return false
+			}
+			return count <= 0 || len(seen) < count
+		}
+
+		r.requestClosest(ctx, string(c.Bytes()), &pb.Message{
+			Type: pb.Message_GET_PROVIDERS,
+			Key:  c.Bytes(),
+		}, func(p peer.ID, resp *pb.Message) {
+			for _, pp := range resp.ProviderPeers {
+				if ctx.Err() != nil {
+					return
+				}
+				pi, err := peerFromPb(pp)
+				if err != nil {
+					log.Debugf("invalid provider from %s: %s", p.Pretty(), err)
+					continue
+				}
+				if pi.ID != r.host.ID() {
+					r.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.TempAddrTTL)
+				}
+				if !send(pi) {
+					cancel()
+					return
+				}
+			}
+		})
+		if len(seen) > 0 || ctx.Err() != nil {
+			return
+		}
+		for pi := range r.fallback.FindProvidersAsync(ctx, c, count) {
+			if !send(pi) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// FindPeer returns the addresses of p from the peerstore, which holds the
+// ones of the crawled peers, or from the DHT if there are none.
+func (r *Router) FindPeer(ctx context.Context, p peer.ID) (pstore.PeerInfo, error) {
+	if pi := r.host.Peerstore().PeerInfo(p); len(pi.Addrs) > 0 {
+		return pi, nil
+	}
+	return r.fallback.FindPeer(ctx, p)
+}
+
+// Bootstrap bootstraps the DHT, the crawls start from its peers.
+func (r *Router) Bootstrap(ctx context.Context) error {
+	return r.fallback.Bootstrap(ctx)
+}
+
+// dhtKey returns the position of key in the keyspace of the DHT.
+func dhtKey(key string) []byte {
+	h := sha256.Sum256([]byte(key))
+	return h[:]
+}
+
+// keyNear returns a random key whose position shares its first bits bits
+// with target.
+func keyNear(target []byte, bits int) ([]byte, error) {
+	key := make([]byte, 32)
+	for {
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if commonPrefixLen(dhtKey(string(key)), target) >= bits {
+			return key, nil
+		}
+	}
+}
+
+// commonPrefixLen returns the number of leading bits a and b share.
+func commonPrefixLen(a, b []byte) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			n := i * 8
+			for x&0x80 == 0 {
+				x <<= 1
+				n++
+			}
+			return n
+		}
+	}
+	return len(a) * 8
+}
+
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+func peerToPb(pi pstore.PeerInfo) *pb.Message_Peer {
+	pp := &pb.Message_Peer{Id: []byte(pi.ID)}
+	for _, a := range pi.Addrs {
+		pp.Addrs = append(pp.Addrs, a.Bytes())
+	}
+	return pp
+}
+
+func peerFromPb(pp *pb.Message_Peer) (pstore.PeerInfo, error) {
+	id, err := peer.IDFromBytes(pp.Id)
+	if err != nil {
+		return pstore.PeerInfo{}, err
+	}
+	pi := pstore.PeerInfo{ID: id}
+	for _, b := range pp.Addrs {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			continue
+		}
+		pi.Addrs = append(pi.Addrs, a)
+	}
+	return pi, nil
+}
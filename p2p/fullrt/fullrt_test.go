@@ -0,0 +1,175 @@
+package fullrt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	nilrouting "gx/ipfs/QmSNe4MWVxZWk6UxxW2z2EKofFo4GdFzud1vfn1iVby3mj/go-ipfs-routing/none"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	dht "gx/ipfs/QmaXYSwxqJsX3EoGb1ZV2toZ9fXc8hWJPaBW1XAp1h2Tsp/go-libp2p-kad-dht"
+	dhtopts "gx/ipfs/QmaXYSwxqJsX3EoGb1ZV2toZ9fXc8hWJPaBW1XAp1h2Tsp/go-libp2p-kad-dht/opts"
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+)
+
+// testValidator accepts the values starting with "v", the greatest one being
+// the best.
+type testValidator struct{}
+
+func (testValidator) Validate(key string, value []byte) error {
+	if !bytes.HasPrefix(value, []byte("v")) {
+		return errors.New("invalid value")
+	}
+	return nil
+}
+
+func (testValidator) Select(key string, values [][]byte) (int, error) {
+	best := 0
+	for i, v := range values {
+		if bytes.Compare(v, values[best]) > 0 {
+			best = i
+		}
+	}
+	return best, nil
+}
+
+func TestKeyNear(t *testing.T) {
+	target := dhtKey("target")
+	for _, bits := range crawlPrefixes {
+		key, err := keyNear(target, bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cpl := commonPrefixLen(dhtKey(string(key)), target); cpl < bits {
+			t.Fatalf("expected a key sharing %d bits with the target, got %d", bits, cpl)
+		}
+	}
+	if cpl := commonPrefixLen([]byte{0xf0, 0x00}, []byte{0xf0, 0x20}); cpl != 10 {
+		t.Fatalf("expected 10 common bits, got %d", cpl)
+	}
+	if cpl := commonPrefixLen(target, target); cpl != 256 {
+		t.Fatalf("expected 256 common bits, got %d", cpl)
+	}
+}
+
+func TestClosest(t *testing.T) {
+	r := New(nil, nil, testValidator{})
+	for i := 0; i < 50; i++ {
+		p := peer.ID([]byte{byte(i)})
+		r.peers[p] = dhtKey(string(p))
+	}
+
+	closest := r.closest("key", bucketSize)
+	if len(closest) != bucketSize {
+		t.Fatalf("expected %d peers, got %d", bucketSize, len(closest))
+	}
+	target := dhtKey("key")
+	last := xor(r.peers[closest[len(closest)-1]], target)
+	for p, k := range r.peers {
+		in := false
+		for _, c := range closest {
+			in = in || c == p
+		}
+		if !in && bytes.Compare(xor(k, target), last) < 0 {
+			t.Fatalf("%v is closer to the key than the peers returned", p)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(ctx, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	validator := record.NamespacedValidator{"v": testValidator{}}
+	dhts := make([]*dht.IpfsDHT, len(hosts))
+	for i, h := range hosts {
+		dhts[i], err = dht.New(ctx, h,
+			dhtopts.Datastore(dssync.MutexWrap(ds.NewMapDatastore())),
+			dhtopts.Validator(validator),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New(hosts[0], dhts[0], validator)
+	if r.ready() {
+		t.Fatal("expected the router not to be ready before crawling")
+	}
+	if err := r.Crawl(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := r.Stats(); st.Peers != len(hosts)-1 || st.LastCrawl.IsZero() {
+		t.Fatalf("expected all the other peers to be crawled, got %+v", st)
+	}
+
+	// values
+	if err := r.PutValue(ctx, "/v/k", []byte("invalid")); err == nil {
+		t.Fatal("expected an invalid value to be rejected")
+	}
+	if err := r.PutValue(ctx, "/v/k", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := dhts[3].GetValue(ctx, "/v/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "v1" {
+		t.Fatalf("expected the value put, got %q", v)
+	}
+	v, err = r.GetValue(ctx, "/v/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "v1" {
+		t.Fatalf("expected the value put, got %q", v)
+	}
+
+	// providers, the DHT stores them in the background
+	c := cid.NewCidV0([]byte("\x12\x20aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	if err := r.Provide(ctx, c, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a DHT to fall back to, the records are found from the table
+	none, err := nilrouting.ConstructNilRouting(ctx, hosts[1], nil, validator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2 := New(hosts[1], none, validator)
+	if err := r2.Crawl(ctx); err != nil {
+		t.Fatal(err)
+	}
+	v, err = r2.GetValue(ctx, "/v/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "v1" {
+		t.Fatalf("expected the value put, got %q", v)
+	}
+	for {
+		var found []peer.ID
+		for pi := range r2.FindProvidersAsync(ctx, c, 1) {
+			found = append(found, pi.ID)
+		}
+		if len(found) == 1 && found[0] == hosts[0].ID() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected the node as the provider, got %v", found)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// source: p2p/fullrt/pb/dht.proto
+
+package pb
+
+import proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Message_MessageType int32
+
+const (
+	Message_PUT_VALUE     Message_MessageType = 0
+	Message_GET_VALUE     Message_MessageType = 1
+	Message_ADD_PROVIDER  Message_MessageType = 2
+	Message_GET_PROVIDERS Message_MessageType = 3
+	Message_FIND_NODE     Message_MessageType = 4
+	Message_PING          Message_MessageType = 5
+)
+
+var Message_MessageType_name = map[int32]string{
+	0: "PUT_VALUE",
+	1: "GET_VALUE",
+	2: "ADD_PROVIDER",
+	3: "GET_PROVIDERS",
+	4: "FIND_NODE",
+	5: "PING",
+}
+var Message_MessageType_value = map[string]int32{
+	"PUT_VALUE":     0,
+	"GET_VALUE":     1,
+	"ADD_PROVIDER":  2,
+	"GET_PROVIDERS": 3,
+	"FIND_NODE":     4,
+	"PING":          5,
+}
+
+func (x Message_MessageType) String() string {
+	return proto.EnumName(Message_MessageType_name, int32(x))
+}
+
+type Message_ConnectionType int32
+
+const (
+	Message_NOT_CONNECTED  Message_ConnectionType = 0
+	Message_CONNECTED      Message_ConnectionType = 1
+	Message_CAN_CONNECT    Message_ConnectionType = 2
+	Message_CANNOT_CONNECT Message_ConnectionType = 3
+)
+
+var Message_ConnectionType_name = map[int32]string{
+	0: "NOT_CONNECTED",
+	1: "CONNECTED",
+	2: "CAN_CONNECT",
+	3: "CANNOT_CONNECT",
+}
+var Message_ConnectionType_value = map[string]int32{
+	"NOT_CONNECTED":  0,
+	"CONNECTED":      1,
+	"CAN_CONNECT":    2,
+	"CANNOT_CONNECT": 3,
+}
+
+func (x Message_ConnectionType) String() string {
+	return proto.EnumName(Message_ConnectionType_name, int32(x))
+}
+
+type Record struct {
+	Key          []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value        []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	TimeReceived string `protobuf:"bytes,5,opt,name=timeReceived,proto3" json:"timeReceived,omitempty"`
+}
+
+func (m *Record) Reset()         { *m = Record{} }
+func (m *Record) String() string { return proto.CompactTextString(m) }
+func (*Record) ProtoMessage()    {}
+
+func (m *Record) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Record) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Record) GetTimeReceived() string {
+	if m != nil {
+		return m.TimeReceived
+	}
+	return ""
+}
+
+type Message struct {
+	Type            Message_MessageType `protobuf:"varint,1,opt,name=type,proto3,enum=fullrt.pb.Message_MessageType" json:"type,omitempty"`
+	ClusterLevelRaw int32               `protobuf:"varint,10,opt,name=clusterLevelRaw,proto3" json:"clusterLevelRaw,omitempty"`
+	Key             []byte              `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Record          *Record             `protobuf:"bytes,3,opt,name=record" json:"record,omitempty"`
+	CloserPeers     []*Message_Peer     `protobuf:"bytes,8,rep,name=closerPeers" json:"closerPeers,omitempty"`
+	ProviderPeers   []*Message_Peer     `protobuf:"bytes,9,rep,name=providerPeers" json:"providerPeers,omitempty"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+func (m *Message) GetType() Message_MessageType {
+	if m != nil {
+		return m.Type
+	}
+	return Message_PUT_VALUE
+}
+
+func (m *Message) GetClusterLevelRaw() int32 {
+	if m != nil {
+		return m.ClusterLevelRaw
+	}
+	return 0
+}
+
+func (m *Message) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Message) GetRecord() *Record {
+	if m != nil {
+		return m.Record
+	}
+	return nil
+}
+
+func (m *Message) GetCloserPeers() []*Message_Peer {
+	if m != nil {
+		return m.CloserPeers
+	}
+	return nil
+}
+
+func (m *Message) GetProviderPeers() []*Message_Peer {
+	if m != nil {
+		return m.ProviderPeers
+	}
+	return nil
+}
+
+type Message_Peer struct {
+	Id         []byte                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Addrs      [][]byte               `protobuf:"bytes,2,rep,name=addrs" json:"addrs,omitempty"`
+	Connection Message_ConnectionType `protobuf:"varint,3,opt,name=connection,proto3,enum=fullrt.pb.Message_ConnectionType" json:"connection,omitempty"`
+}
+
+func (m *Message_Peer) Reset()         { *m = Message_Peer{} }
+func (m *Message_Peer) String() string { return proto.CompactTextString(m) }
+func (*Message_Peer) ProtoMessage()    {}
+
+func (m *Message_Peer) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Message_Peer) GetAddrs() [][]byte {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func (m *Message_Peer) GetConnection() Message_ConnectionType {
+	if m != nil {
+		return m.Connection
+	}
+	return Message_NOT_CONNECTED
+}
+
+func init() {
+	proto.RegisterType((*Record)(nil), "fullrt.pb.Record")
+	proto.RegisterType((*Message)(nil), "fullrt.pb.Message")
+	proto.RegisterType((*Message_Peer)(nil), "fullrt.pb.Message.Peer")
+	proto.RegisterEnum("fullrt.pb.Message_MessageType", Message_MessageType_name, Message_MessageType_value)
+	proto.RegisterEnum("fullrt.pb.Message_ConnectionType", Message_ConnectionType_name, Message_ConnectionType_value)
+}
@@ -0,0 +1,390 @@
+// Package holepunch upgrades the connections relayed between two peers
+// behind NATs to direct connections, with the direct connection upgrade
+// through relay protocol (DCUtR): the peers exchange their addresses over
+// the relayed connection, measure its round trip time, and dial each other
+// at the same time so that their NATs let the connections in.
+package holepunch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/holepunch/pb"
+	relay "github.com/ipfs/go-ipfs/p2p/relay"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	swarm "gx/ipfs/QmeDpqUwwdye8ABKVMPXKuWwPVURFdqTqssbTUB39E2Nwd/go-libp2p-swarm"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("holepunch")
+
+// ProtocolID is the protocol of the hole punching streams.
+const ProtocolID = "/libp2p/dcutr"
+
+const (
+	// maxAttempts bounds the hole punches tried for a relayed connection.
+	maxAttempts = 3
+	// failureBackoff is how long the hole punch isn't tried again with a
+	// peer after all the attempts failed.
+	failureBackoff = 10 * time.Minute
+
+	streamTimeout = 30 * time.Second
+	dialTimeout   = 10 * time.Second
+	// maxRecent is the number of hole punches kept for the stats.
+	maxRecent = 16
+)
+
+var errNoAddrs = errors.New("the peer has no direct address")
+
+// Punch is the outcome of the hole punch with a peer.
+type Punch struct {
+	Peer peer.ID
+	// Initiator is set when the local node started the hole punch.
+	Initiator bool
+	Success   bool
+	Attempts  int
+	// RTT is the round trip time measured over the relayed connection, by
+	// the initiator.
+	RTT   time.Duration
+	Error string
+	Time  time.Time
+}
+
+// Stats are the hole punches since the service started.
+type Stats struct {
+	Successes int
+	Failures  int
+	// Active is the number of hole punches in progress.
+	Active int
+	// Recent are the latest hole punches, the last one first.
+	Recent []Punch
+}
+
+// Service punches holes for the relayed connections once started, until it
+// is closed.
+type Service struct {
+	host p2phost.Host
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running bool
+	active  map[peer.ID]struct{}
+	failed  map[peer.ID]time.Time
+	stats   Stats
+}
+
+// NewService returns a Service for h. Nothing is done until Start is called.
+func NewService(h p2phost.Host) *Service {
+	return &Service{
+		host:   h,
+		active: make(map[peer.ID]struct{}),
+		failed: make(map[peer.ID]time.Time),
+	}
+}
+
+// Start answers the hole punches of the peers, and starts one for every new
+// relayed connection.
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.host.SetStreamHandler(ProtocolID, s.handleStream)
+	s.host.Network().Notify(s)
+}
+
+// Close stops punching holes, waiting for the hole punches in progress to
+// be canceled.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return nil
+	}
+	s.running = false
+	s.host.Network().StopNotify(s)
+	s.host.RemoveStreamHandler(ProtocolID)
+	s.cancel()
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// Stats returns the hole punches since the service started.
+func (s *Service) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.stats
+	st.Active = len(s.active)
+	st.Recent = append([]Punch(nil), s.stats.Recent...)
+	return st
+}
+
+// begin marks a hole punch with p as active, returning false if one is
+// already in progress or if the last one failed recently.
+func (s *Service) begin(p peer.ID, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return false
+	}
+	if _, ok := s.active[p]; ok {
+		return false
+	}
+	if t, ok := s.failed[p]; ok && now.Sub(t) < failureBackoff {
+		return false
+	}
+	s.active[p] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+// end records the outcome of the hole punch started with begin.
+func (s *Service) end(pu Punch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.active, pu.Peer)
+	if pu.Success {
+		s.stats.Successes++
+		delete(s.failed, pu.Peer)
+	} else {
+		s.stats.Failures++
+		s.failed[pu.Peer] = pu.Time
+	}
+	s.stats.Recent = append([]Punch{pu}, s.stats.Recent...)
+	if len(s.stats.Recent) > maxRecent {
+		s.stats.Recent = s.stats.Recent[:maxRecent]
+	}
+	s.wg.Done()
+}
+
+// initiate punches a hole to p, retrying through the relay when it fails.
+func (s *Service) initiate(p peer.ID) {
+	pu := Punch{Peer: p, Initiator: true}
+	var err error
+	for pu.Attempts < maxAttempts {
+		pu.Attempts++
+		if pu.RTT, err = s.initiateOnce(p); err == nil || err == errNoAddrs || s.ctx.Err() != nil {
+			break
+		}
+		log.Debugf("hole punch %d with %s failed: %s", pu.Attempts, p, err)
+
+		// the relayed connection was closed for the direct dials
+		if s.host.Network().Connectedness(p) != inet.Connected {
+			ctx, cancel := context.WithTimeout(s.ctx, dialTimeout)
+			cerr := s.host.Connect(ctx, pstore.PeerInfo{ID: p})
+			cancel()
+			if cerr != nil {
+				log.Debugf("cannot reconnect to %s through the relay: %s", p, cerr)
+				break
+			}
+		}
+	}
+	s.finish(pu, err)
+}
+
+func (s *Service) finish(pu Punch, err error) {
+	pu.Time = time.Now()
+	pu.Success = err == nil
+	if err != nil {
+		pu.Error = err.Error()
+		log.Infof("cannot punch a hole to %s: %s", pu.Peer, err)
+	} else {
+		log.Infof("punched a hole to %s", pu.Peer)
+	}
+	s.end(pu)
+}
+
+// initiateOnce sends the addresses of the node over the relayed connection
+// to p, and punches once p replied with its own, half a round trip after
+// telling it to punch.
+func (s *Service) initiateOnce(p peer.ID) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, streamTimeout)
+	defer cancel()
+
+	st, err := s.host.NewStream(ctx, p, ProtocolID)
+	if err != nil {
+		return 0, err
+	}
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(streamTimeout))
+
+	start := time.Now()
+	if err := writeMsg(st, newMessage(pb.HolePunch_CONNECT, s.directAddrs())); err != nil {
+		st.Reset()
+		return 0, err
+	}
+	var resp pb.HolePunch
+	if err := readMsg(st, &resp); err != nil {
+		st.Reset()
+		return 0, err
+	}
+	rtt := time.Since(start)
+	if resp.GetType() != pb.HolePunch_CONNECT {
+		st.Reset()
+		return rtt, errors.New("unexpected message")
+	}
+	addrs := msgAddrs(&resp)
+	if len(addrs) == 0 {
+		st.Reset()
+		return rtt, errNoAddrs
+	}
+	if err := writeMsg(st, newMessage(pb.HolePunch_SYNC, nil)); err != nil {
+		st.Reset()
+		return rtt, err
+	}
+
+	// the sync reaches p in half a round trip, when it punches too
+	select {
+	case <-time.After(rtt / 2):
+	case <-s.ctx.Done():
+		return rtt, s.ctx.Err()
+	}
+	return rtt, s.punch(p, addrs)
+}
+
+// handleStream answers a hole punch started by the remote peer.
+func (s *Service) handleStream(st inet.Stream) {
+	defer st.Close()
+	p := st.Conn().RemotePeer()
+	st.SetDeadline(time.Now().Add(streamTimeout))
+
+	var req pb.HolePunch
+	if err := readMsg(st, &req); err != nil || req.GetType() != pb.HolePunch_CONNECT {
+		st.Reset()
+		return
+	}
+	// a hole punch may be tried again after a failure, as soon as the
+	// initiator asks
+	s.mu.Lock()
+	delete(s.failed, p)
+	s.mu.Unlock()
+	if !s.begin(p, time.Now()) {
+		st.Reset()
+		return
+	}
+
+	pu := Punch{Peer: p, Attempts: 1}
+	err := writeMsg(st, newMessage(pb.HolePunch_CONNECT, s.directAddrs()))
+	if err == nil {
+		var msg pb.HolePunch
+		if err = readMsg(st, &msg); err == nil && msg.GetType() != pb.HolePunch_SYNC {
+			err = errors.New("unexpected message")
+		}
+	}
+	if err == nil {
+		if addrs := msgAddrs(&req); len(addrs) == 0 {
+			err = errNoAddrs
+		} else {
+			err = s.punch(p, addrs)
+		}
+	}
+	if err != nil {
+		st.Reset()
+	}
+	s.finish(pu, err)
+}
+
+// directAddrs returns the addresses of the node that aren't relayed, which
+// include the addresses observed by its peers.
+func (s *Service) directAddrs() []ma.Multiaddr {
+	var out []ma.Multiaddr
+	for _, a := range s.host.Addrs() {
+		if !relay.IsRelayAddr(a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// punch dials p on its direct addresses. The swarm returns the relayed
+// connection instead of dialing while it is open, so it is closed first,
+// along with the relayed addresses of p during the dial.
+func (s *Service) punch(p peer.ID, addrs []ma.Multiaddr) error {
+	if s.hasDirectConn(p) {
+		return nil
+	}
+
+	for _, c := range s.host.Network().ConnsToPeer(p) {
+		if isRelayConn(c) {
+			c.Close()
+		}
+	}
+
+	ps := s.host.Peerstore()
+	var relayed []ma.Multiaddr
+	for _, a := range ps.Addrs(p) {
+		if relay.IsRelayAddr(a) {
+			relayed = append(relayed, a)
+		}
+	}
+	ps.SetAddrs(p, relayed, 0)
+	defer ps.AddAddrs(p, relayed, pstore.RecentlyConnectedAddrTTL)
+
+	// the direct dials of the previous attempts are backed off
+	if sw, ok := s.host.Network().(*swarm.Swarm); ok {
+		sw.Backoff().Clear(p)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, dialTimeout)
+	defer cancel()
+	if err := s.host.Connect(ctx, pstore.PeerInfo{ID: p, Addrs: addrs}); err != nil {
+		return err
+	}
+	if !s.hasDirectConn(p) {
+		return errors.New("no direct connection")
+	}
+	return nil
+}
+
+func (s *Service) hasDirectConn(p peer.ID) bool {
+	for _, c := range s.host.Network().ConnsToPeer(p) {
+		if !isRelayConn(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Connected starts a hole punch for a new relayed connection, unless the
+// peers are also connected directly. Only the peer with the lowest ID starts
+// it, so that the peers don't start one each. It is part of the
+// inet.Notifiee interface.
+func (s *Service) Connected(n inet.Network, c inet.Conn) {
+	p := c.RemotePeer()
+	if !isRelayConn(c) || n.LocalPeer() > p || s.hasDirectConn(p) {
+		return
+	}
+	if !s.begin(p, time.Now()) {
+		return
+	}
+	go s.initiate(p)
+}
+
+func (s *Service) Disconnected(inet.Network, inet.Conn)   {}
+func (s *Service) Listen(inet.Network, ma.Multiaddr)      {}
+func (s *Service) ListenClose(inet.Network, ma.Multiaddr) {}
+func (s *Service) OpenedStream(inet.Network, inet.Stream) {}
+func (s *Service) ClosedStream(inet.Network, inet.Stream) {}
+
+func isRelayConn(c inet.Conn) bool {
+	return relay.IsRelayAddr(c.RemoteMultiaddr())
+}
@@ -0,0 +1,112 @@
+package holepunch
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/holepunch/pb"
+
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	a, err := ma.NewMultiaddr("/ip4/1.2.3.4/tcp/4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ma.NewMultiaddr("/ip6/::1/udp/4001/quic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeMsg(&buf, newMessage(pb.HolePunch_CONNECT, []ma.Multiaddr{a, b})); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMsg(&buf, newMessage(pb.HolePunch_SYNC, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	var m pb.HolePunch
+	if err := readMsg(&buf, &m); err != nil {
+		t.Fatal(err)
+	}
+	addrs := msgAddrs(&m)
+	if m.GetType() != pb.HolePunch_CONNECT || len(addrs) != 2 || !addrs[0].Equal(a) || !addrs[1].Equal(b) {
+		t.Fatalf("unexpected message: %s", &m)
+	}
+	if err := readMsg(&buf, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetType() != pb.HolePunch_SYNC || len(m.GetObsAddrs()) != 0 {
+		t.Fatalf("unexpected message: %s", &m)
+	}
+
+	// a message without a type is invalid
+	raw, err := proto.Marshal(&pb.HolePunch{ObsAddrs: [][]byte{a.Bytes()}})
+	if err == nil {
+		err = proto.Unmarshal(raw, &m)
+	}
+	if err == nil {
+		t.Fatal("expected a message without a type to be invalid")
+	}
+}
+
+func TestHolePunch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+
+	services := make([]*Service, len(hosts))
+	for i, h := range hosts {
+		services[i] = NewService(h)
+		services[i].Start()
+		defer services[i].Close()
+	}
+	a, b := services[0], services[1]
+	pb := hosts[1].ID()
+
+	// the peers are connected directly by mocknet, the exchange succeeds
+	// without dialing
+	if !a.begin(pb, time.Now()) {
+		t.Fatal("expected the hole punch to begin")
+	}
+	a.initiate(pb)
+
+	st := a.Stats()
+	if st.Successes != 1 || st.Failures != 0 || st.Active != 0 || len(st.Recent) != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if pu := st.Recent[0]; pu.Peer != pb || !pu.Initiator || !pu.Success || pu.Attempts != 1 {
+		t.Fatalf("unexpected hole punch: %+v", pu)
+	}
+
+	for i := 0; i < 100 && b.Stats().Successes == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := b.Stats(); st.Successes != 1 || st.Recent[0].Initiator {
+		t.Fatalf("unexpected stats of the responder: %+v", st)
+	}
+
+	// failed hole punches aren't tried again for a while
+	if !a.begin(pb, time.Now()) {
+		t.Fatal("expected the hole punch to begin")
+	}
+	a.end(Punch{Peer: pb, Time: time.Now()})
+	if a.begin(pb, time.Now()) {
+		t.Fatal("expected the hole punch to be backed off")
+	}
+	if !a.begin(pb, time.Now().Add(failureBackoff)) {
+		t.Fatal("expected the backoff to expire")
+	}
+	a.end(Punch{Peer: pb, Success: true, Time: time.Now()})
+}
@@ -0,0 +1,70 @@
+package holepunch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	pb "github.com/ipfs/go-ipfs/p2p/holepunch/pb"
+
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	ggio "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/io"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+const maxMessageSize = 4096
+
+func newMessage(typ pb.HolePunch_Type, addrs []ma.Multiaddr) *pb.HolePunch {
+	msg := &pb.HolePunch{Type: typ.Enum()}
+	for _, a := range addrs {
+		msg.ObsAddrs = append(msg.ObsAddrs, a.Bytes())
+	}
+	return msg
+}
+
+// msgAddrs returns the addresses of msg, skipping those that can't be
+// parsed.
+func msgAddrs(msg *pb.HolePunch) []ma.Multiaddr {
+	var addrs []ma.Multiaddr
+	for _, b := range msg.GetObsAddrs() {
+		if a, err := ma.NewMultiaddrBytes(b); err == nil {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// byteReader reads a byte at a time, for the length prefix not to be read
+// past.
+type byteReader struct {
+	r io.Reader
+}
+
+func (br byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(br.r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readMsg reads a message prefixed with its length.
+func readMsg(r io.Reader, msg *pb.HolePunch) error {
+	l, err := binary.ReadUvarint(byteReader{r})
+	if err != nil {
+		return err
+	}
+	if l > maxMessageSize {
+		return fmt.Errorf("message too large: %d bytes", l)
+	}
+
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	return proto.Unmarshal(buf, msg)
+}
+
+func writeMsg(w io.Writer, msg *pb.HolePunch) error {
+	return ggio.NewDelimitedWriter(w).WriteMsg(msg)
+}
@@ -0,0 +1,137 @@
+// +build go1.13
+
+package libp2ptls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+)
+
+// certSigPrefix prefixes the certificate key signed by the identity key.
+const certSigPrefix = "libp2p-tls-handshake:"
+
+// alpn is the ALPN protocol of the handshakes.
+const alpn = "libp2p"
+
+// the certificates are valid long enough for their lifetimes to be those of
+// the transports
+const certValidity = 100 * 365 * 24 * time.Hour
+
+// extensionOID is the certificate extension carrying the signed key.
+var extensionOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53594, 1, 1}
+
+// signedKey is the value of the certificate extension: the identity key of
+// the peer and its signature of the certificate key.
+type signedKey struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// newCert returns a self-signed certificate whose key is signed by sk.
+func newCert(sk ci.PrivKey) (*tls.Certificate, error) {
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&certKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := sk.GetPublic().Bytes()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sk.Sign(append([]byte(certSigPrefix), spki...))
+	if err != nil {
+		return nil, err
+	}
+	ext, err := asn1.Marshal(signedKey{PubKey: pub, Signature: sig})
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:    serial,
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.Add(certValidity),
+		ExtraExtensions: []pkix.Extension{{Id: extensionOID, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &certKey.PublicKey, certKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: certKey}, nil
+}
+
+// verifyCert checks the self-signed certificate of a peer and returns the
+// identity key signing its key.
+func verifyCert(rawCerts [][]byte) (ci.PubKey, error) {
+	if len(rawCerts) != 1 {
+		return nil, errors.New("expected a single certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var ext []byte
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(extensionOID) {
+			ext = e.Value
+			break
+		}
+	}
+	if ext == nil {
+		return nil, errors.New("the certificate has no libp2p extension")
+	}
+	// the extension may be critical, it is handled here
+	var unhandled []asn1.ObjectIdentifier
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if !oid.Equal(extensionOID) {
+			unhandled = append(unhandled, oid)
+		}
+	}
+	cert.UnhandledCriticalExtensions = unhandled
+
+	// the certificate is its own root, checking its signature and its
+	// validity period
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		return nil, fmt.Errorf("invalid certificate: %s", err)
+	}
+
+	var sk signedKey
+	if rest, err := asn1.Unmarshal(ext, &sk); err != nil || len(rest) != 0 {
+		return nil, errors.New("invalid libp2p extension")
+	}
+	pub, err := ci.UnmarshalPublicKey(sk.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity key: %s", err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := pub.Verify(append([]byte(certSigPrefix), spki...), sk.Signature)
+	if err != nil || !ok {
+		return nil, errors.New("the certificate key isn't signed by the identity key")
+	}
+	return pub, nil
+}
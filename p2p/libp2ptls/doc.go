@@ -0,0 +1,9 @@
+// Package libp2ptls is the TLS security transport of libp2p: the peers run
+// a TLS 1.3 handshake with self-signed certificates, whose keys are signed by
+// the identity keys of the peers in an extension. It needs Go 1.13, the
+// first release enabling TLS 1.3; Supported tells whether it was built with
+// it.
+package libp2ptls
+
+// ID is the protocol of the TLS security transport.
+const ID = "/tls/1.0.0"
@@ -0,0 +1,134 @@
+// +build go1.13
+
+package libp2ptls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	connsec "gx/ipfs/QmXD1SJbD7QSZS3L2F7ASGU8NnuT4QxdKpqpnFMHzsrrJL/go-conn-security"
+)
+
+// Supported is true, the transport was built with TLS 1.3.
+const Supported = true
+
+// Transport secures the connections of a peer with TLS.
+type Transport struct {
+	localID peer.ID
+	privKey ci.PrivKey
+	cert    *tls.Certificate
+}
+
+var _ connsec.Transport = (*Transport)(nil)
+
+// New returns the TLS transport of the peer of the identity key sk.
+func New(sk ci.PrivKey) (*Transport, error) {
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := newCert(sk)
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{localID: id, privKey: sk, cert: cert}, nil
+}
+
+// config returns the TLS config of a handshake, which sets the identity key
+// of the remote peer in remote, checking it is the key of expected when set.
+func (t *Transport) config(expected peer.ID, remote *ci.PubKey) *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{*t.cert},
+		// the certificates are self-signed, VerifyPeerCertificate checks
+		// them instead
+		InsecureSkipVerify:     true,
+		ClientAuth:             tls.RequireAnyClientCert,
+		NextProtos:             []string{alpn},
+		SessionTicketsDisabled: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			pub, err := verifyCert(rawCerts)
+			if err != nil {
+				return err
+			}
+			if expected != "" && !expected.MatchesPublicKey(pub) {
+				id, _ := peer.IDFromPublicKey(pub)
+				return fmt.Errorf("expected peer %s, got %s", expected.Pretty(), id.Pretty())
+			}
+			*remote = pub
+			return nil
+		},
+	}
+}
+
+// SecureInbound secures a connection dialed by a peer.
+func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn) (connsec.Conn, error) {
+	var remote ci.PubKey
+	return t.handshake(ctx, tls.Server(insecure, t.config("", &remote)), &remote)
+}
+
+// SecureOutbound secures a connection dialed to the peer p, failing if the
+// remote peer isn't p.
+func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (connsec.Conn, error) {
+	var remote ci.PubKey
+	return t.handshake(ctx, tls.Client(insecure, t.config(p, &remote)), &remote)
+}
+
+func (t *Transport) handshake(ctx context.Context, tlsConn *tls.Conn, remote *ci.PubKey) (*secureConn, error) {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tlsConn.Handshake()
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		// interrupt the handshake
+		tlsConn.Close()
+		<-errCh
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+	if *remote == nil {
+		tlsConn.Close()
+		return nil, errors.New("the peer sent no certificate")
+	}
+
+	id, err := peer.IDFromPublicKey(*remote)
+	if err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+	return &secureConn{
+		Conn:      tlsConn,
+		localID:   t.localID,
+		privKey:   t.privKey,
+		remoteID:  id,
+		remoteKey: *remote,
+	}, nil
+}
+
+// secureConn is a connection secured by TLS.
+type secureConn struct {
+	*tls.Conn
+
+	localID   peer.ID
+	privKey   ci.PrivKey
+	remoteID  peer.ID
+	remoteKey ci.PubKey
+}
+
+func (c *secureConn) LocalPeer() peer.ID          { return c.localID }
+func (c *secureConn) LocalPrivateKey() ci.PrivKey { return c.privKey }
+func (c *secureConn) RemotePeer() peer.ID         { return c.remoteID }
+func (c *secureConn) RemotePublicKey() ci.PubKey  { return c.remoteKey }
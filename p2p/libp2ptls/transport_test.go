@@ -0,0 +1,178 @@
+// +build go1.13
+
+package libp2ptls
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	connsec "gx/ipfs/QmXD1SJbD7QSZS3L2F7ASGU8NnuT4QxdKpqpnFMHzsrrJL/go-conn-security"
+)
+
+func newTestTransport(t *testing.T, typ int) *Transport {
+	sk, _, err := ci.GenerateKeyPair(typ, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpt, err := New(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tpt
+}
+
+// connect runs the handshake of the initiator and the responder over a pipe.
+func connect(t *testing.T, initiator, responder *Transport, expected peer.ID) (connsec.Conn, connsec.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c1, c2 := net.Pipe()
+	type result struct {
+		c   connsec.Conn
+		err error
+	}
+	inbound := make(chan result, 1)
+	go func() {
+		c, err := responder.SecureInbound(ctx, c2)
+		if err != nil {
+			c2.Close()
+		}
+		inbound <- result{c, err}
+	}()
+	out, err := initiator.SecureOutbound(ctx, c1, expected)
+	if err != nil {
+		c1.Close()
+	}
+	in := <-inbound
+	if err == nil {
+		err = in.err
+	}
+	return out, in.c, err
+}
+
+func TestHandshake(t *testing.T) {
+	initiator := newTestTransport(t, ci.Ed25519)
+	responder := newTestTransport(t, ci.RSA)
+
+	out, in, err := connect(t, initiator, responder, responder.localID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	defer in.Close()
+
+	if out.RemotePeer() != responder.localID || in.RemotePeer() != initiator.localID {
+		t.Fatal("unexpected remote peers")
+	}
+	if !out.RemotePublicKey().Equals(responder.privKey.GetPublic()) {
+		t.Fatal("unexpected remote public key")
+	}
+
+	// larger than a TLS record
+	data := bytes.Repeat([]byte("tls"), 30000)
+	go func() {
+		out.Write(data)
+	}()
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(in, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("the data was corrupted")
+	}
+
+	go func() {
+		in.Write([]byte("back"))
+	}()
+	back := make([]byte, 4)
+	if _, err := io.ReadFull(out, back); err != nil || string(back) != "back" {
+		t.Fatalf("unexpected reply %q: %v", back, err)
+	}
+}
+
+func TestHandshakeWrongPeer(t *testing.T) {
+	initiator := newTestTransport(t, ci.Ed25519)
+	responder := newTestTransport(t, ci.Ed25519)
+	other := newTestTransport(t, ci.Ed25519)
+
+	if _, _, err := connect(t, initiator, responder, other.localID); err == nil {
+		t.Fatal("expected the handshake with the wrong peer to fail")
+	}
+}
+
+func TestVerifyCert(t *testing.T) {
+	sk, pub, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := newCert(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := verifyCert(cert.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(pub) {
+		t.Fatal("unexpected identity key")
+	}
+
+	// the extension of another certificate doesn't sign the key
+	other, err := newCert(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(other.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	certKey := cert.PrivateKey.(*ecdsa.PrivateKey)
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       parsed.NotBefore,
+		NotAfter:        parsed.NotAfter,
+		ExtraExtensions: parsed.Extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &certKey.PublicKey, certKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyCert([][]byte{der}); err == nil {
+		t.Fatal("expected the extension of another certificate to be refused")
+	}
+
+	tmpl.ExtraExtensions = nil
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &certKey.PublicKey, certKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyCert([][]byte{der}); err == nil {
+		t.Fatal("expected a certificate without extension to be refused")
+	}
+}
+
+func TestHandshakeCanceled(t *testing.T) {
+	tpt := newTestTransport(t, ci.Ed25519)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	// nobody answers on c2
+	if _, err := tpt.SecureInbound(ctx, c1); err != context.Canceled {
+		t.Fatalf("expected the handshake to be canceled, got %v", err)
+	}
+}
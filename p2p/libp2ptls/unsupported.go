@@ -0,0 +1,18 @@
+// +build !go1.13
+
+package libp2ptls
+
+import (
+	"errors"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+	connsec "gx/ipfs/QmXD1SJbD7QSZS3L2F7ASGU8NnuT4QxdKpqpnFMHzsrrJL/go-conn-security"
+)
+
+// Supported is false, TLS 1.3 needs Go 1.13.
+const Supported = false
+
+// New fails, TLS 1.3 needs Go 1.13.
+func New(sk ci.PrivKey) (connsec.Transport, error) {
+	return nil, errors.New("the TLS security transport needs ipfs to be built with Go 1.13 or later")
+}
@@ -0,0 +1,328 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// The DNS messages of mDNS (RFC 6762), limited to the records DNS-SD
+// (RFC 6763) announces the services with.
+
+const (
+	typeA    uint16 = 1
+	typePTR  uint16 = 12
+	typeTXT  uint16 = 16
+	typeAAAA uint16 = 28
+	typeSRV  uint16 = 33
+	typeANY  uint16 = 255
+
+	classIN uint16 = 1
+	// classTopBit is the cache-flush bit of the records and the
+	// unicast-response bit of the questions
+	classTopBit uint16 = 1 << 15
+
+	// flagResponse sets the QR and AA bits of a response
+	flagResponse uint16 = 0x8400
+
+	headerLen = 12
+	// maxPointers bounds the compression pointers followed in a name
+	maxPointers = 16
+)
+
+var errShortMessage = errors.New("dns message too short")
+
+type question struct {
+	Name    string
+	Type    uint16
+	Unicast bool
+}
+
+type record struct {
+	Name string
+	Type uint16
+	TTL  uint32
+
+	// Target is the name a PTR or SRV record points to
+	Target string
+	// Port is the port of a SRV record
+	Port uint16
+	// Txt are the strings of a TXT record
+	Txt []string
+	// IP is the address of an A or AAAA record
+	IP net.IP
+}
+
+type message struct {
+	ID          uint16
+	Response    bool
+	Questions   []question
+	Answers     []record
+	Additionals []record
+}
+
+func (m *message) pack() ([]byte, error) {
+	b := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	if m.Response {
+		binary.BigEndian.PutUint16(b[2:], flagResponse)
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additionals)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		class := classIN
+		if q.Unicast {
+			class |= classTopBit
+		}
+		b = appendUint16(b, q.Type)
+		b = appendUint16(b, class)
+	}
+	for _, rrs := range [][]record{m.Answers, m.Additionals} {
+		for _, rr := range rrs {
+			if b, err = appendRecord(b, rr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendRecord(b []byte, rr record) ([]byte, error) {
+	b, err := appendName(b, rr.Name)
+	if err != nil {
+		return nil, err
+	}
+	class := classIN
+	// a peer has a single address record and service instance, the shared
+	// PTR records list the instances of all the peers
+	if rr.Type != typePTR {
+		class |= classTopBit
+	}
+	b = appendUint16(b, rr.Type)
+	b = appendUint16(b, class)
+	b = appendUint32(b, rr.TTL)
+
+	lenAt := len(b)
+	b = append(b, 0, 0)
+	switch rr.Type {
+	case typePTR:
+		b, err = appendName(b, rr.Target)
+	case typeSRV:
+		// priority and weight
+		b = appendUint16(b, 0)
+		b = appendUint16(b, 0)
+		b = appendUint16(b, rr.Port)
+		b, err = appendName(b, rr.Target)
+	case typeTXT:
+		if len(rr.Txt) == 0 {
+			b = append(b, 0)
+		}
+		for _, s := range rr.Txt {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("txt string longer than 255 bytes: %q", s)
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	case typeA:
+		ip := rr.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("not an IPv4 address: %s", rr.IP)
+		}
+		b = append(b, ip...)
+	case typeAAAA:
+		ip := rr.IP.To16()
+		if ip == nil || rr.IP.To4() != nil {
+			return nil, fmt.Errorf("not an IPv6 address: %s", rr.IP)
+		}
+		b = append(b, ip...)
+	default:
+		return nil, fmt.Errorf("unsupported record type %d", rr.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(b[lenAt:], uint16(len(b)-lenAt-2))
+	return b, nil
+}
+
+// appendName appends the fully qualified name, without compression.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid dns name %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// unpack parses a message. The records of the authority section, and the
+// records of other types than those of DNS-SD, are skipped.
+func unpack(b []byte) (*message, error) {
+	if len(b) < headerLen {
+		return nil, errShortMessage
+	}
+	m := &message{
+		ID:       binary.BigEndian.Uint16(b[0:]),
+		Response: binary.BigEndian.Uint16(b[2:])&(1<<15) != 0,
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+	nscount := int(binary.BigEndian.Uint16(b[8:]))
+	arcount := int(binary.BigEndian.Uint16(b[10:]))
+
+	off := headerLen
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, errShortMessage
+		}
+		class := binary.BigEndian.Uint16(b[next+2:])
+		m.Questions = append(m.Questions, question{
+			Name:    name,
+			Type:    binary.BigEndian.Uint16(b[next:]),
+			Unicast: class&classTopBit != 0,
+		})
+		off = next + 4
+	}
+
+	for i := 0; i < ancount+nscount+arcount; i++ {
+		rr, next, err := readRecord(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		switch {
+		case rr == nil:
+		case i < ancount:
+			m.Answers = append(m.Answers, *rr)
+		case i >= ancount+nscount:
+			m.Additionals = append(m.Additionals, *rr)
+		}
+	}
+	return m, nil
+}
+
+// readRecord reads the record at off, returning a nil record for the types
+// it doesn't parse, and the offset of the next record.
+func readRecord(b []byte, off int) (*record, int, error) {
+	name, off, err := readName(b, off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if off+10 > len(b) {
+		return nil, 0, errShortMessage
+	}
+	rr := &record{
+		Name: name,
+		Type: binary.BigEndian.Uint16(b[off:]),
+		TTL:  binary.BigEndian.Uint32(b[off+4:]),
+	}
+	rdlen := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if off+rdlen > len(b) {
+		return nil, 0, errShortMessage
+	}
+	data := b[off : off+rdlen]
+	next := off + rdlen
+
+	switch rr.Type {
+	case typePTR:
+		rr.Target, _, err = readName(b, off)
+	case typeSRV:
+		if rdlen < 7 {
+			return nil, 0, errShortMessage
+		}
+		rr.Port = binary.BigEndian.Uint16(data[4:])
+		rr.Target, _, err = readName(b, off+6)
+	case typeTXT:
+		for i := 0; i < len(data); {
+			l := int(data[i])
+			i++
+			if i+l > len(data) {
+				return nil, 0, errShortMessage
+			}
+			if l > 0 {
+				rr.Txt = append(rr.Txt, string(data[i:i+l]))
+			}
+			i += l
+		}
+	case typeA, typeAAAA:
+		if (rr.Type == typeA && rdlen != net.IPv4len) || (rr.Type == typeAAAA && rdlen != net.IPv6len) {
+			return nil, 0, fmt.Errorf("invalid address record of %d bytes", rdlen)
+		}
+		rr.IP = append(net.IP(nil), data...)
+	default:
+		return nil, next, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return rr, next, nil
+}
+
+// readName reads the possibly compressed name at off, returning it fully
+// qualified and the offset following it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	// end is the offset following the name, before the first pointer
+	end := -1
+	for pointers := 0; ; {
+		if off >= len(b) {
+			return "", 0, errShortMessage
+		}
+		l := int(b[off])
+		switch l & 0xc0 {
+		case 0:
+			off++
+			if l == 0 {
+				if end < 0 {
+					end = off
+				}
+				return strings.Join(labels, ".") + ".", end, nil
+			}
+			if off+l > len(b) {
+				return "", 0, errShortMessage
+			}
+			labels = append(labels, string(b[off:off+l]))
+			off += l
+		case 0xc0:
+			if off+2 > len(b) {
+				return "", 0, errShortMessage
+			}
+			if pointers++; pointers > maxPointers {
+				return "", 0, errors.New("too many compression pointers in dns name")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		default:
+			return "", 0, fmt.Errorf("invalid dns label type %#x", l&0xc0)
+		}
+	}
+}
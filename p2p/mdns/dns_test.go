@@ -0,0 +1,87 @@
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPackUnpack(t *testing.T) {
+	m := &message{
+		ID:        7,
+		Response:  true,
+		Questions: []question{{Name: "_test._udp.local.", Type: typePTR, Unicast: true}},
+		Answers: []record{
+			{Name: "_test._udp.local.", Type: typePTR, TTL: 120, Target: "a._test._udp.local."},
+		},
+		Additionals: []record{
+			{Name: "a._test._udp.local.", Type: typeSRV, TTL: 120, Target: "a.local.", Port: 4001},
+			{Name: "a._test._udp.local.", Type: typeTXT, TTL: 120, Txt: []string{"x", "y"}},
+			{Name: "a.local.", Type: typeA, TTL: 120, IP: net.IPv4(192, 168, 1, 2).To4()},
+			{Name: "a.local.", Type: typeAAAA, TTL: 120, IP: net.ParseIP("fd00::2")},
+		},
+	}
+	b, err := m.pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, out) {
+		t.Fatalf("expected %+v, got %+v", m, out)
+	}
+
+	for i := range b {
+		if _, err := unpack(b[:i]); err == nil {
+			t.Fatalf("expected the message truncated to %d bytes to fail", i)
+		}
+	}
+}
+
+func TestUnpackCompressed(t *testing.T) {
+	b := []byte{
+		0, 0, 0x84, 0, // response
+		0, 0, 0, 2, 0, 1, 0, 1, // 2 answers, 1 authority, 1 additional
+	}
+	service := len(b)
+	b = append(b, 5, '_', 't', 'e', 's', 't', 4, '_', 'u', 'd', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0)
+	b = append(b, 0, 12, 0, 1, 0, 0, 0, 120, 0, 4)
+	// PTR to a.<service>
+	instance := len(b)
+	b = append(b, 1, 'a', 0xc0, byte(service))
+	// an unknown record of the instance, skipped
+	b = append(b, 0xc0, byte(instance), 0, 99, 0, 1, 0, 0, 0, 120, 0, 2, 1, 2)
+	// an authority, skipped
+	b = append(b, 0xc0, byte(service), 0, 12, 0, 1, 0, 0, 0, 120, 0, 2, 0xc0, byte(service))
+	// SRV of the instance, its target compressed
+	b = append(b, 0xc0, byte(instance), 0, 33, 0x80, 1, 0, 0, 0, 60, 0, 10, 0, 0, 0, 0, 0x0f, 0xa1, 1, 'h', 0xc0, byte(service+11))
+
+	m, err := unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &message{
+		Response: true,
+		Answers: []record{
+			{Name: "_test._udp.local.", Type: typePTR, TTL: 120, Target: "a._test._udp.local."},
+		},
+		Additionals: []record{
+			{Name: "a._test._udp.local.", Type: typeSRV, TTL: 60, Target: "h.local.", Port: 4001},
+		},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, m)
+	}
+
+	// a pointer to itself
+	loop := append(b[:headerLen:headerLen], 0xc0, headerLen)
+	loop[5] = 1
+	loop[7] = 0
+	loop[9] = 0
+	loop[11] = 0
+	if _, err := unpack(loop); err == nil {
+		t.Fatal("expected the pointer loop to fail")
+	}
+}
@@ -0,0 +1,27 @@
+package mdns
+
+import (
+	"net"
+	"syscall"
+)
+
+// setLoopback makes the messages sent on c received by the other sockets
+// of the host, which Go disables on the multicast sockets.
+func setLoopback(c *net.UDPConn, network string) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if network == NetworkIPv6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 1)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
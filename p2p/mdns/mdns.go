@@ -0,0 +1,635 @@
+// Package mdns discovers the peers of the local network with multicast DNS
+// (RFC 6762), over IPv4 and IPv6, on each interface of the host.
+//
+// The peers are announced as the DNS-SD (RFC 6763) instances of a service,
+// with their peer ID in a TXT record and their TCP address in the SRV and
+// A or AAAA records, the way the mDNS discovery of go-libp2p announces them,
+// for the nodes using either to find each other.
+package mdns
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	discovery "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/discovery"
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("mdns")
+
+const (
+	// ServiceTag is the service the peers are announced under by default,
+	// the one of go-libp2p.
+	ServiceTag = "_ipfs-discovery._udp"
+
+	// DefaultInterval is the time between the queries by default.
+	DefaultInterval = 10 * time.Second
+
+	mdnsPort = 5353
+	// recordTTL is the time, in seconds, the announces are valid for
+	recordTTL = 120
+	// maxPacketSize is the largest mDNS message
+	maxPacketSize = 9000
+)
+
+// The networks the peers are discovered over.
+const (
+	NetworkIPv4 = "ip4"
+	NetworkIPv6 = "ip6"
+)
+
+var (
+	groupIPv4 = net.IPv4(224, 0, 0, 251)
+	groupIPv6 = net.ParseIP("ff02::fb")
+)
+
+// Config configures the discovery.
+type Config struct {
+	// Interval is the time between the queries, DefaultInterval if zero.
+	Interval time.Duration
+	// ServiceTag is the DNS-SD service the peers are announced under, like
+	// _name._udp. Only the peers using the same tag find each other.
+	// ServiceTag if empty.
+	ServiceTag string
+	// IPv6 discovers the peers over IPv6 too.
+	IPv6 bool
+	// ExcludedInterfaces are the names of the interfaces the peers are
+	// neither announced nor discovered on.
+	ExcludedInterfaces []string
+}
+
+// Validate checks the service tag of the config.
+func (cfg Config) Validate() error {
+	if cfg.ServiceTag == "" {
+		return nil
+	}
+	labels := strings.Split(cfg.ServiceTag, ".")
+	if len(labels) != 2 || len(labels[0]) < 2 || len(labels[0]) > 63 || labels[0][0] != '_' ||
+		(labels[1] != "_udp" && labels[1] != "_tcp") {
+		return fmt.Errorf("invalid service tag %q, expected _name._udp or _name._tcp", cfg.ServiceTag)
+	}
+	return nil
+}
+
+// Peer is a peer found on the local network.
+type Peer struct {
+	ID    peer.ID
+	Addrs []ma.Multiaddr
+	// Interface is the network interface the peer was found on, and
+	// Network the IP version it was found over, NetworkIPv4 or NetworkIPv6.
+	Interface string
+	Network   string
+	LastSeen  time.Time
+
+	expires time.Time
+}
+
+// Service announces the host and discovers the peers of the local network,
+// until it is closed. It is a discovery.Service, its notifees being passed
+// the peers found.
+type Service struct {
+	id    peer.ID
+	addrs func() []ma.Multiaddr
+	cfg   Config
+	// service is the fully qualified name of the service
+	service string
+	// queryID tells the queries of the service apart when they are looped
+	// back
+	queryID uint16
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	conns    map[connKey]*conn
+	peers    map[peerKey]*Peer
+	notifees []discovery.Notifee
+}
+
+type connKey struct {
+	iface   string
+	network string
+}
+
+type peerKey struct {
+	id peer.ID
+	connKey
+}
+
+// conn receives the messages of an interface over a network, and sends
+// them.
+type conn struct {
+	*net.UDPConn
+	connKey
+	group *net.UDPAddr
+	// nets are the addresses of the interface, updated under the lock of
+	// the service
+	nets []*net.IPNet
+}
+
+// NewService starts the discovery of the peers of h.
+func NewService(ctx context.Context, h p2phost.Host, cfg Config) (*Service, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	addrs := func() []ma.Multiaddr {
+		addrs, err := h.Network().InterfaceListenAddresses()
+		if err != nil {
+			log.Warningf("mdns: listing the addresses: %s", err)
+		}
+		return addrs
+	}
+	s := newService(ctx, h.ID(), addrs, cfg)
+	go s.run()
+	return s, nil
+}
+
+func newService(ctx context.Context, id peer.ID, addrs func() []ma.Multiaddr, cfg Config) *Service {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.ServiceTag == "" {
+		cfg.ServiceTag = ServiceTag
+	}
+	s := &Service{
+		id:      id,
+		addrs:   addrs,
+		cfg:     cfg,
+		service: cfg.ServiceTag + ".local.",
+		queryID: randomID(),
+		done:    make(chan struct{}),
+		conns:   make(map[connKey]*conn),
+		peers:   make(map[peerKey]*Peer),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	return s
+}
+
+// Close stops the discovery, telling the peers the host is gone.
+func (s *Service) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// RegisterNotifee makes the service pass the peers it finds to n.
+func (s *Service) RegisterNotifee(n discovery.Notifee) {
+	s.mu.Lock()
+	s.notifees = append(s.notifees, n)
+	s.mu.Unlock()
+}
+
+// UnregisterNotifee stops passing the peers found to n.
+func (s *Service) UnregisterNotifee(n discovery.Notifee) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, notifee := range s.notifees {
+		if notifee == n {
+			s.notifees = append(s.notifees[:i], s.notifees[i+1:]...)
+			return
+		}
+	}
+}
+
+// Peers returns the peers announced on the local network, by interface and
+// network, sorted.
+func (s *Service) Peers() []Peer {
+	now := time.Now()
+	s.mu.Lock()
+	peers := make([]Peer, 0, len(s.peers))
+	for k, p := range s.peers {
+		if now.After(p.expires) {
+			delete(s.peers, k)
+			continue
+		}
+		peers = append(peers, *p)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(peers, func(i, j int) bool {
+		a, b := peers[i], peers[j]
+		switch {
+		case a.ID != b.ID:
+			return a.ID < b.ID
+		case a.Interface != b.Interface:
+			return a.Interface < b.Interface
+		}
+		return a.Network < b.Network
+	})
+	return peers
+}
+
+func (s *Service) run() {
+	defer close(s.done)
+	t := time.NewTicker(s.cfg.Interval)
+	defer t.Stop()
+	for {
+		// the interfaces are listed again on each query, to use those
+		// brought up meanwhile
+		s.refresh()
+		s.query()
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			s.shutdown()
+			return
+		}
+	}
+}
+
+func (s *Service) networks() []string {
+	if s.cfg.IPv6 {
+		return []string{NetworkIPv4, NetworkIPv6}
+	}
+	return []string{NetworkIPv4}
+}
+
+func (s *Service) excluded(iface string) bool {
+	for _, name := range s.cfg.ExcludedInterfaces {
+		if name == iface {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh listens on the multicast interfaces that are up, and stops
+// listening on those gone.
+func (s *Service) refresh() {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Warningf("mdns: listing the interfaces: %s", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[connKey]bool)
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 ||
+			ifi.Flags&net.FlagLoopback != 0 || s.excluded(ifi.Name) {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, network := range s.networks() {
+			nets := interfaceNets(addrs, network)
+			if len(nets) == 0 {
+				continue
+			}
+			k := connKey{iface: ifi.Name, network: network}
+			seen[k] = true
+			if c, ok := s.conns[k]; ok {
+				c.nets = nets
+				continue
+			}
+			c, err := listen(ifi, network)
+			if err != nil {
+				log.Debugf("mdns: listening on %s over %s: %s", ifi.Name, network, err)
+				continue
+			}
+			c.nets = nets
+			s.conns[k] = c
+			go s.serve(c)
+		}
+	}
+	for k, c := range s.conns {
+		if !seen[k] {
+			delete(s.conns, k)
+			c.Close()
+		}
+	}
+}
+
+func interfaceNets(addrs []net.Addr, network string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if ok && (ipn.IP.To4() != nil) == (network == NetworkIPv4) {
+			nets = append(nets, ipn)
+		}
+	}
+	return nets
+}
+
+func listen(ifi net.Interface, network string) (*conn, error) {
+	group := &net.UDPAddr{IP: groupIPv4, Port: mdnsPort}
+	udp := "udp4"
+	if network == NetworkIPv6 {
+		group = &net.UDPAddr{IP: groupIPv6, Port: mdnsPort, Zone: ifi.Name}
+		udp = "udp6"
+	}
+	uc, err := net.ListenMulticastUDP(udp, &ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	// for the nodes of the host to find each other
+	if err := setLoopback(uc, network); err != nil {
+		log.Debugf("mdns: looping back the messages on %s: %s", ifi.Name, err)
+	}
+	return &conn{
+		UDPConn: uc,
+		connKey: connKey{iface: ifi.Name, network: network},
+		group:   group,
+	}, nil
+}
+
+func (s *Service) listening(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[c.connKey] == c
+}
+
+func (s *Service) serve(c *conn) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := c.ReadFromUDP(buf)
+		if err != nil {
+			if !s.listening(c) {
+				return
+			}
+			log.Debugf("mdns: reading on %s: %s", c.iface, err)
+			continue
+		}
+		if !s.fromInterface(c, src) {
+			continue
+		}
+		m, err := unpack(buf[:n])
+		if err != nil {
+			log.Debugf("mdns: invalid message from %s: %s", src, err)
+			continue
+		}
+		if m.Response {
+			s.handleResponse(c, m)
+		} else {
+			s.handleQuery(c, src, m)
+		}
+	}
+}
+
+// fromInterface returns whether src is on the interface of c, the messages
+// sent to the group on another interface being received too.
+func (s *Service) fromInterface(c *conn, src *net.UDPAddr) bool {
+	if src.Zone != "" {
+		return src.Zone == c.iface
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range c.nets {
+		if n.Contains(src.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) query() {
+	q := &message{
+		ID:        s.queryID,
+		Questions: []question{{Name: s.service, Type: typePTR}},
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		s.send(c, q, c.group)
+	}
+}
+
+// shutdown announces the host is gone and stops listening.
+func (s *Service) shutdown() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = make(map[connKey]*conn)
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		if bye := s.response(c, 0); bye != nil {
+			s.send(c, bye, c.group)
+		}
+		c.Close()
+	}
+}
+
+func (s *Service) send(c *conn, m *message, dst *net.UDPAddr) {
+	b, err := m.pack()
+	if err != nil {
+		log.Errorf("mdns: packing the message: %s", err)
+		return
+	}
+	if _, err := c.WriteToUDP(b, dst); err != nil {
+		log.Debugf("mdns: sending to %s on %s: %s", dst, c.iface, err)
+	}
+}
+
+func (s *Service) handleQuery(c *conn, src *net.UDPAddr, q *message) {
+	if q.ID == s.queryID {
+		// our own query, looped back
+		return
+	}
+	asked := false
+	// the legacy resolvers don't send from the mDNS port and only receive
+	// unicast responses
+	unicast := src.Port != mdnsPort
+	for _, qq := range q.Questions {
+		if (qq.Type == typePTR || qq.Type == typeANY) && strings.EqualFold(qq.Name, s.service) {
+			asked = true
+			unicast = unicast || qq.Unicast
+		}
+	}
+	if !asked {
+		return
+	}
+
+	resp := s.response(c, recordTTL)
+	if resp == nil {
+		return
+	}
+	dst := c.group
+	if unicast {
+		resp.ID = q.ID
+		dst = src
+	}
+	s.send(c, resp, dst)
+}
+
+// response returns the announce of the host on the interface of c, with
+// the TCP addresses the host listens on there, valid for ttl seconds. It
+// returns nil when the host doesn't listen on the interface.
+func (s *Service) response(c *conn, ttl uint32) *message {
+	s.mu.Lock()
+	nets := c.nets
+	s.mu.Unlock()
+
+	port, ips := announced(s.addrs(), c.network, nets)
+	if len(ips) == 0 {
+		return nil
+	}
+
+	id := s.id.Pretty()
+	instance := id + "." + s.service
+	host := id + ".local."
+	m := &message{
+		Response: true,
+		Answers: []record{
+			{Name: s.service, Type: typePTR, TTL: ttl, Target: instance},
+		},
+		Additionals: []record{
+			{Name: instance, Type: typeSRV, TTL: ttl, Target: host, Port: port},
+			{Name: instance, Type: typeTXT, TTL: ttl, Txt: []string{id}},
+		},
+	}
+	typ := typeA
+	if c.network == NetworkIPv6 {
+		typ = typeAAAA
+	}
+	for _, ip := range ips {
+		m.Additionals = append(m.Additionals, record{Name: host, Type: typ, TTL: ttl, IP: ip})
+	}
+	return m
+}
+
+// announced returns the port and the IPs of the TCP addresses of addrs on
+// the interface of nets. The link-local IPv6 addresses aren't announced, as
+// they can't be dialed without their zone. The DNS-SD records only have a
+// single port, the addresses on another port are left out.
+func announced(addrs []ma.Multiaddr, network string, nets []*net.IPNet) (uint16, []net.IP) {
+	var port uint16
+	var ips []net.IP
+	for _, a := range addrs {
+		na, err := manet.ToNetAddr(a)
+		if err != nil {
+			continue
+		}
+		tcp, ok := na.(*net.TCPAddr)
+		if !ok || (tcp.IP.To4() != nil) != (network == NetworkIPv4) || tcp.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		onInterface := false
+		for _, n := range nets {
+			onInterface = onInterface || n.IP.Equal(tcp.IP)
+		}
+		if !onInterface || (port != 0 && uint16(tcp.Port) != port) {
+			continue
+		}
+		port = uint16(tcp.Port)
+		ips = append(ips, tcp.IP)
+	}
+	return port, ips
+}
+
+// instance is a service instance of a response.
+type instance struct {
+	host string
+	port uint16
+	txt  []string
+	ttl  uint32
+}
+
+func (s *Service) handleResponse(c *conn, m *message) {
+	records := append(append([]record(nil), m.Answers...), m.Additionals...)
+
+	instances := make(map[string]*instance)
+	for _, rr := range records {
+		if rr.Type == typePTR && strings.EqualFold(rr.Name, s.service) {
+			instances[strings.ToLower(rr.Target)] = &instance{}
+		}
+	}
+	ips := make(map[string][]net.IP)
+	for _, rr := range records {
+		name := strings.ToLower(rr.Name)
+		switch rr.Type {
+		case typeSRV:
+			if in := instances[name]; in != nil {
+				in.host = strings.ToLower(rr.Target)
+				in.port = rr.Port
+				in.ttl = rr.TTL
+			}
+		case typeTXT:
+			if in := instances[name]; in != nil {
+				in.txt = rr.Txt
+			}
+		case typeA, typeAAAA:
+			ips[name] = append(ips[name], rr.IP)
+		}
+	}
+
+	for _, in := range instances {
+		if in.port == 0 || len(in.txt) == 0 {
+			continue
+		}
+		// go-libp2p joins the strings of the TXT record
+		id, err := peer.IDB58Decode(strings.Join(in.txt, "|"))
+		if err != nil || id == s.id {
+			continue
+		}
+		if in.ttl == 0 {
+			s.forget(peerKey{id: id, connKey: c.connKey})
+			continue
+		}
+
+		var addrs []ma.Multiaddr
+		for _, ip := range ips[in.host] {
+			if ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				continue
+			}
+			a, err := manet.FromNetAddr(&net.TCPAddr{IP: ip, Port: int(in.port)})
+			if err == nil {
+				addrs = append(addrs, a)
+			}
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		s.found(&Peer{
+			ID:        id,
+			Addrs:     addrs,
+			Interface: c.iface,
+			Network:   c.network,
+			LastSeen:  time.Now(),
+			expires:   time.Now().Add(time.Duration(in.ttl) * time.Second),
+		})
+	}
+}
+
+func (s *Service) found(p *Peer) {
+	s.mu.Lock()
+	s.peers[peerKey{id: p.ID, connKey: connKey{iface: p.Interface, network: p.Network}}] = p
+	notifees := append([]discovery.Notifee(nil), s.notifees...)
+	s.mu.Unlock()
+
+	pi := pstore.PeerInfo{ID: p.ID, Addrs: p.Addrs}
+	for _, n := range notifees {
+		go n.HandlePeerFound(pi)
+	}
+}
+
+func (s *Service) forget(k peerKey) {
+	s.mu.Lock()
+	delete(s.peers, k)
+	s.mu.Unlock()
+}
+
+// randomID returns a non-zero random ID for the queries.
+func randomID() uint16 {
+	var b [2]byte
+	for b == [2]byte{} {
+		if _, err := rand.Read(b[:]); err != nil {
+			return uint16(time.Now().UnixNano()) | 1
+		}
+	}
+	return uint16(b[0])<<8 | uint16(b[1])
+}
@@ -0,0 +1,161 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+type testNotifee chan pstore.PeerInfo
+
+func (n testNotifee) HandlePeerFound(pi pstore.PeerInfo) {
+	n <- pi
+}
+
+func testService(t *testing.T, id string, addrs ...string) *Service {
+	pid, err := peer.IDB58Decode(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mas := make([]ma.Multiaddr, len(addrs))
+	for i, a := range addrs {
+		if mas[i], err = ma.NewMultiaddr(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newService(context.Background(), pid, func() []ma.Multiaddr { return mas }, Config{})
+}
+
+func testConn(network string, cidrs ...string) *conn {
+	c := &conn{connKey: connKey{iface: "eth0", network: network}}
+	for _, cidr := range cidrs {
+		ip, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		n.IP = ip
+		c.nets = append(c.nets, n)
+	}
+	return c
+}
+
+// exchange passes the announce of from on c to to.
+func exchange(t *testing.T, from, to *Service, c *conn, ttl uint32) {
+	resp := from.response(c, ttl)
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+	b, err := resp.pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to.handleResponse(c, m)
+}
+
+func TestDiscover(t *testing.T) {
+	a := testService(t, "QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd",
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip4/192.168.1.2/tcp/4001",
+		"/ip4/10.0.0.2/tcp/4001",
+		"/ip4/192.168.1.2/udp/4001/quic",
+		"/ip6/fe80::2/tcp/4001",
+		"/ip6/fd00::2/tcp/4001",
+	)
+	b := testService(t, "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	notifee := make(testNotifee, 2)
+	b.RegisterNotifee(notifee)
+
+	c4 := testConn(NetworkIPv4, "192.168.1.2/24")
+	c6 := testConn(NetworkIPv6, "fe80::2/64", "fd00::2/64")
+	exchange(t, a, b, c4, recordTTL)
+	exchange(t, a, b, c6, recordTTL)
+
+	peers := b.Peers()
+	if len(peers) != 2 {
+		t.Fatalf("expected the peer on both networks, got %v", peers)
+	}
+	for i, expected := range []struct{ network, addr string }{
+		{NetworkIPv4, "/ip4/192.168.1.2/tcp/4001"},
+		{NetworkIPv6, "/ip6/fd00::2/tcp/4001"},
+	} {
+		p := peers[i]
+		if p.ID != a.id || p.Interface != "eth0" || p.Network != expected.network {
+			t.Fatalf("unexpected peer %+v", p)
+		}
+		if len(p.Addrs) != 1 || p.Addrs[0].String() != expected.addr {
+			t.Fatalf("expected the address %s, got %v", expected.addr, p.Addrs)
+		}
+		select {
+		case pi := <-notifee:
+			if pi.ID != a.id {
+				t.Fatalf("expected the notifee to be passed %s, got %s", a.id, pi.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the notifee to be passed the peer")
+		}
+	}
+
+	// the host ignores its own announces
+	exchange(t, a, a, c4, recordTTL)
+	if len(a.Peers()) != 0 {
+		t.Fatal("expected the host not to discover itself")
+	}
+
+	// a goodbye forgets the peer
+	exchange(t, a, b, c4, 0)
+	peers = b.Peers()
+	if len(peers) != 1 || peers[0].Network != NetworkIPv6 {
+		t.Fatalf("expected the peer to be forgotten over IPv4, got %v", peers)
+	}
+
+	// no announce on the interfaces the host doesn't listen on
+	if a.response(testConn(NetworkIPv4, "172.16.0.2/16"), recordTTL) != nil {
+		t.Fatal("expected no response")
+	}
+}
+
+func TestFromInterface(t *testing.T) {
+	s := testService(t, "QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd")
+	c4 := testConn(NetworkIPv4, "192.168.1.2/24")
+	c6 := testConn(NetworkIPv6, "fe80::2/64")
+	for _, tc := range []struct {
+		c    *conn
+		src  net.UDPAddr
+		from bool
+	}{
+		{c4, net.UDPAddr{IP: net.ParseIP("192.168.1.7")}, true},
+		{c4, net.UDPAddr{IP: net.ParseIP("10.0.0.7")}, false},
+		{c6, net.UDPAddr{IP: net.ParseIP("fe80::7"), Zone: "eth0"}, true},
+		{c6, net.UDPAddr{IP: net.ParseIP("fe80::7"), Zone: "wlan0"}, false},
+	} {
+		if s.fromInterface(tc.c, &tc.src) != tc.from {
+			t.Errorf("expected %s to be from %s: %t", tc.src.String(), tc.c.iface, tc.from)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for tag, valid := range map[string]bool{
+		"":                     true,
+		ServiceTag:             true,
+		"_lan._tcp":            true,
+		"lan._udp":             false,
+		"_._udp":               false,
+		"_lan._sctp":           false,
+		"_lan._udp.local":      false,
+		"_ipfs-discovery-test": false,
+	} {
+		if err := (Config{ServiceTag: tag}).Validate(); (err == nil) != valid {
+			t.Errorf("expected %q to be valid: %t, got %v", tag, valid, err)
+		}
+	}
+}
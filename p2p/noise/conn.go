@@ -0,0 +1,82 @@
+package noise
+
+import (
+	"net"
+	"sync"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	connsec "gx/ipfs/QmXD1SJbD7QSZS3L2F7ASGU8NnuT4QxdKpqpnFMHzsrrJL/go-conn-security"
+)
+
+// maxPlaintextSize is the largest payload of a message, with its tag.
+const maxPlaintextSize = maxMessageSize - tagSize
+
+// secureConn is a connection secured by the Noise handshake, its messages
+// being encrypted and prefixed with their lengths.
+type secureConn struct {
+	net.Conn
+
+	localID   peer.ID
+	privKey   ci.PrivKey
+	remoteID  peer.ID
+	remoteKey ci.PubKey
+
+	readLk  sync.Mutex
+	dec     *cipherState
+	frame   []byte
+	pending []byte
+
+	writeLk sync.Mutex
+	enc     *cipherState
+}
+
+var _ connsec.Conn = (*secureConn)(nil)
+
+func (c *secureConn) Read(b []byte) (int, error) {
+	c.readLk.Lock()
+	defer c.readLk.Unlock()
+
+	for len(c.pending) == 0 {
+		frame, err := readFrame(c.Conn, c.frame)
+		if err != nil {
+			return 0, err
+		}
+		c.frame = frame
+		// decrypt in place, the frame is reused once read
+		if c.pending, err = c.dec.decrypt(frame[:0], nil, frame); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *secureConn) Write(b []byte) (int, error) {
+	c.writeLk.Lock()
+	defer c.writeLk.Unlock()
+
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxPlaintextSize {
+			chunk = chunk[:maxPlaintextSize]
+		}
+		msg, err := c.enc.encrypt(nil, nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		if err := writeFrame(c.Conn, msg); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+func (c *secureConn) LocalPeer() peer.ID          { return c.localID }
+func (c *secureConn) LocalPrivateKey() ci.PrivKey { return c.privKey }
+func (c *secureConn) RemotePeer() peer.ID         { return c.remoteID }
+func (c *secureConn) RemotePublicKey() ci.PubKey  { return c.remoteKey }
@@ -0,0 +1,324 @@
+package noise
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/chacha20poly1305"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/curve25519"
+)
+
+// protocolName is the Noise protocol of libp2p: the XX handshake pattern
+// with X25519, ChaCha20-Poly1305 and SHA-256. It is as long as a hash, it
+// is the initial hash as is.
+const protocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+
+// maxMessageSize bounds the Noise messages, which are prefixed with their
+// length on 2 bytes.
+const maxMessageSize = 65535
+
+// tagSize is the size of the authentication tags of ChaCha20-Poly1305.
+const tagSize = 16
+
+var errMessageTooLarge = errors.New("noise message too large")
+
+type keypair struct {
+	priv [32]byte
+	pub  [32]byte
+}
+
+func newKeypair() (keypair, error) {
+	var kp keypair
+	if _, err := io.ReadFull(rand.Reader, kp.priv[:]); err != nil {
+		return kp, err
+	}
+	curve25519.ScalarBaseMult(&kp.pub, &kp.priv)
+	return kp, nil
+}
+
+func dh(priv, pub [32]byte) ([]byte, error) {
+	var out [32]byte
+	curve25519.ScalarMult(&out, &priv, &pub)
+	// a low order point of the peer gives a zero secret
+	var zero [32]byte
+	if hmac.Equal(out[:], zero[:]) {
+		return nil, errors.New("noise: invalid public key")
+	}
+	return out[:], nil
+}
+
+// cipherState encrypts with a key and a counter nonce.
+type cipherState struct {
+	k     [32]byte
+	n     uint64
+	isSet bool
+}
+
+func (c *cipherState) nonce() []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], c.n)
+	return nonce[:]
+}
+
+func (c *cipherState) encrypt(out, ad, plaintext []byte) ([]byte, error) {
+	if !c.isSet {
+		return append(out, plaintext...), nil
+	}
+	aead, err := chacha20poly1305.New(c.k[:])
+	if err != nil {
+		return nil, err
+	}
+	out = aead.Seal(out, c.nonce(), plaintext, ad)
+	c.n++
+	return out, nil
+}
+
+func (c *cipherState) decrypt(out, ad, ciphertext []byte) ([]byte, error) {
+	if !c.isSet {
+		return append(out, ciphertext...), nil
+	}
+	aead, err := chacha20poly1305.New(c.k[:])
+	if err != nil {
+		return nil, err
+	}
+	out, err = aead.Open(out, c.nonce(), ciphertext, ad)
+	if err != nil {
+		return nil, err
+	}
+	c.n++
+	return out, nil
+}
+
+// symmetricState is the chaining key and the hash of the handshake, mixed
+// with its messages and secrets.
+type symmetricState struct {
+	cs cipherState
+	ck [32]byte
+	h  [32]byte
+}
+
+func newSymmetricState() *symmetricState {
+	s := &symmetricState{}
+	copy(s.h[:], protocolName)
+	s.ck = s.h
+	// the prologue is empty
+	s.mixHash(nil)
+	return s
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h[:])
+	h.Write(data)
+	h.Sum(s.h[:0])
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	var k [32]byte
+	hkdf(s.ck[:], ikm, &s.ck, &k)
+	s.cs = cipherState{k: k, isSet: true}
+}
+
+func (s *symmetricState) encryptAndHash(out, plaintext []byte) ([]byte, error) {
+	start := len(out)
+	out, err := s.cs.encrypt(out, s.h[:], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(out[start:])
+	return out, nil
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.cs.decrypt(nil, s.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the cipher states of the messages sent by the initiator and
+// by the responder.
+func (s *symmetricState) split() (*cipherState, *cipherState) {
+	c1, c2 := &cipherState{isSet: true}, &cipherState{isSet: true}
+	hkdf(s.ck[:], nil, &c1.k, &c2.k)
+	return c1, c2
+}
+
+// hkdf derives two keys from the chaining key ck and ikm, the way of the
+// Noise specification.
+func hkdf(ck, ikm []byte, out1, out2 *[32]byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{0x01})
+	mac.Sum(out1[:0])
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{0x02})
+	mac.Sum(out2[:0])
+}
+
+// handshakeState runs the XX pattern:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+//
+// The payloads of the second and the third messages authenticate the static
+// keys with the identity keys of the peers.
+type handshakeState struct {
+	ss        *symmetricState
+	initiator bool
+	s, e      keypair
+	rs, re    [32]byte
+}
+
+func newHandshakeState(initiator bool, s keypair) (*handshakeState, error) {
+	e, err := newKeypair()
+	if err != nil {
+		return nil, err
+	}
+	return &handshakeState{ss: newSymmetricState(), initiator: initiator, s: s, e: e}, nil
+}
+
+// writeMessage returns the message n of the handshake, from 0, carrying
+// payload.
+func (hs *handshakeState) writeMessage(n int, payload []byte) ([]byte, error) {
+	var out []byte
+	var err error
+	switch n {
+	case 0:
+		out = append(out, hs.e.pub[:]...)
+		hs.ss.mixHash(hs.e.pub[:])
+	case 1:
+		out = append(out, hs.e.pub[:]...)
+		hs.ss.mixHash(hs.e.pub[:])
+		if err := hs.mix(hs.e.priv, hs.re); err != nil {
+			return nil, err
+		}
+		if out, err = hs.ss.encryptAndHash(out, hs.s.pub[:]); err != nil {
+			return nil, err
+		}
+		if err := hs.mix(hs.s.priv, hs.re); err != nil {
+			return nil, err
+		}
+	case 2:
+		if out, err = hs.ss.encryptAndHash(out, hs.s.pub[:]); err != nil {
+			return nil, err
+		}
+		if err := hs.mix(hs.s.priv, hs.re); err != nil {
+			return nil, err
+		}
+	}
+	return hs.ss.encryptAndHash(out, payload)
+}
+
+// readMessage reads the message n of the handshake and returns its payload.
+func (hs *handshakeState) readMessage(n int, msg []byte) ([]byte, error) {
+	// the static keys are encrypted, with a tag
+	const sealedKeySize = 32 + tagSize
+
+	switch n {
+	case 0:
+		if len(msg) < 32 {
+			return nil, errors.New("noise: short message")
+		}
+		copy(hs.re[:], msg)
+		hs.ss.mixHash(hs.re[:])
+		msg = msg[32:]
+	case 1:
+		if len(msg) < 32+sealedKeySize {
+			return nil, errors.New("noise: short message")
+		}
+		copy(hs.re[:], msg)
+		hs.ss.mixHash(hs.re[:])
+		if err := hs.mix(hs.e.priv, hs.re); err != nil {
+			return nil, err
+		}
+		if err := hs.readStatic(msg[32 : 32+sealedKeySize]); err != nil {
+			return nil, err
+		}
+		if err := hs.mix(hs.e.priv, hs.rs); err != nil {
+			return nil, err
+		}
+		msg = msg[32+sealedKeySize:]
+	case 2:
+		if len(msg) < sealedKeySize {
+			return nil, errors.New("noise: short message")
+		}
+		if err := hs.readStatic(msg[:sealedKeySize]); err != nil {
+			return nil, err
+		}
+		if err := hs.mix(hs.e.priv, hs.rs); err != nil {
+			return nil, err
+		}
+		msg = msg[sealedKeySize:]
+	}
+	return hs.ss.decryptAndHash(msg)
+}
+
+func (hs *handshakeState) readStatic(sealed []byte) error {
+	rs, err := hs.ss.decryptAndHash(sealed)
+	if err != nil {
+		return fmt.Errorf("noise: cannot decrypt the static key: %s", err)
+	}
+	copy(hs.rs[:], rs)
+	return nil
+}
+
+// mix mixes the DH of priv and pub into the key.
+func (hs *handshakeState) mix(priv, pub [32]byte) error {
+	secret, err := dh(priv, pub)
+	if err != nil {
+		return err
+	}
+	hs.ss.mixKey(secret)
+	return nil
+}
+
+// ciphers returns the cipher states encrypting the messages sent and
+// received once the handshake is done.
+func (hs *handshakeState) ciphers() (enc, dec *cipherState) {
+	c1, c2 := hs.ss.split()
+	if hs.initiator {
+		return c1, c2
+	}
+	return c2, c1
+}
+
+func writeFrame(w io.Writer, msg []byte) error {
+	if len(msg) > maxMessageSize {
+		return errMessageTooLarge
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader, buf []byte) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(size[:]))
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,92 @@
+package noise
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// payloadSigPrefix prefixes the static key signed by the identity key.
+const payloadSigPrefix = "noise-libp2p-static-key:"
+
+// payload is the NoiseHandshakePayload protobuf message of the libp2p Noise
+// specification, with the identity key of a peer and its signature of the
+// static key.
+type payload struct {
+	IdentityKey []byte
+	IdentitySig []byte
+}
+
+const (
+	identityKeyField = 1
+	identitySigField = 2
+
+	bytesWireType = 2
+)
+
+func (p *payload) marshal() []byte {
+	var out []byte
+	for _, f := range []struct {
+		num  uint64
+		data []byte
+	}{
+		{identityKeyField, p.IdentityKey},
+		{identitySigField, p.IdentitySig},
+	} {
+		out = appendUvarint(out, f.num<<3|bytesWireType)
+		out = appendUvarint(out, uint64(len(f.data)))
+		out = append(out, f.data...)
+	}
+	return out
+}
+
+var errInvalidPayload = errors.New("noise: invalid handshake payload")
+
+// unmarshal reads the fields of the payload, skipping the others.
+func (p *payload) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errInvalidPayload
+		}
+		b = b[n:]
+
+		switch tag & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return errInvalidPayload
+			}
+			b = b[n:]
+		case 1: // 64 bits
+			if len(b) < 8 {
+				return errInvalidPayload
+			}
+			b = b[8:]
+		case 5: // 32 bits
+			if len(b) < 4 {
+				return errInvalidPayload
+			}
+			b = b[4:]
+		case bytesWireType:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errInvalidPayload
+			}
+			data := b[n : n+int(size)]
+			b = b[n+int(size):]
+			switch tag >> 3 {
+			case identityKeyField:
+				p.IdentityKey = data
+			case identitySigField:
+				p.IdentitySig = data
+			}
+		default:
+			return errInvalidPayload
+		}
+	}
+	return nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
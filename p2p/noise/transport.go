@@ -0,0 +1,175 @@
+// Package noise is the Noise security transport of libp2p: the peers run a
+// Noise XX handshake, authenticate their static keys with their identity
+// keys, then encrypt their messages with ChaCha20-Poly1305.
+package noise
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	ci "gx/ipfs/QmPvyPwuCgJ7pDmrKDxRtsScJgBaM5h4EpRL2qQJsmXf4n/go-libp2p-crypto"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	connsec "gx/ipfs/QmXD1SJbD7QSZS3L2F7ASGU8NnuT4QxdKpqpnFMHzsrrJL/go-conn-security"
+)
+
+// ID is the protocol of the Noise security transport.
+const ID = "/noise"
+
+// Transport secures the connections of a peer with Noise.
+type Transport struct {
+	localID peer.ID
+	privKey ci.PrivKey
+	// static is the Noise static key of the peer, authenticated in each
+	// handshake by its identity key
+	static keypair
+}
+
+var _ connsec.Transport = (*Transport)(nil)
+
+// New returns the Noise transport of the peer of the identity key sk.
+func New(sk ci.PrivKey) (*Transport, error) {
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	static, err := newKeypair()
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{localID: id, privKey: sk, static: static}, nil
+}
+
+// SecureInbound secures a connection dialed by a peer.
+func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn) (connsec.Conn, error) {
+	return t.handshake(ctx, insecure, false, "")
+}
+
+// SecureOutbound secures a connection dialed to the peer p, failing if the
+// remote peer isn't p.
+func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (connsec.Conn, error) {
+	return t.handshake(ctx, insecure, true, p)
+}
+
+func (t *Transport) handshake(ctx context.Context, insecure net.Conn, initiator bool, expected peer.ID) (*secureConn, error) {
+	// the handshake is interrupted by the context
+	if deadline, ok := ctx.Deadline(); ok {
+		insecure.SetDeadline(deadline)
+	}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			insecure.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	c, err := t.runHandshake(insecure, initiator, expected)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	}
+	insecure.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (t *Transport) runHandshake(insecure net.Conn, initiator bool, expected peer.ID) (*secureConn, error) {
+	hs, err := newHandshakeState(initiator, t.static)
+	if err != nil {
+		return nil, err
+	}
+	pl, err := t.payload()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &secureConn{Conn: insecure, localID: t.localID, privKey: t.privKey}
+	var buf []byte
+	for n := 0; n < 3; n++ {
+		if (n%2 == 0) == initiator {
+			// the first message has no payload, the static keys aren't
+			// known yet
+			var out []byte
+			if n == 0 {
+				out, err = hs.writeMessage(n, nil)
+			} else {
+				out, err = hs.writeMessage(n, pl)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := writeFrame(insecure, out); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if buf, err = readFrame(insecure, buf); err != nil {
+			return nil, err
+		}
+		remote, err := hs.readMessage(n, buf)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			if err := c.authenticate(hs.rs, remote, expected); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	c.enc, c.dec = hs.ciphers()
+	return c, nil
+}
+
+// payload returns the handshake payload signing the static key with the
+// identity key.
+func (t *Transport) payload() ([]byte, error) {
+	key, err := t.privKey.GetPublic().Bytes()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := t.privKey.Sign(append([]byte(payloadSigPrefix), t.static.pub[:]...))
+	if err != nil {
+		return nil, err
+	}
+	p := payload{IdentityKey: key, IdentitySig: sig}
+	return p.marshal(), nil
+}
+
+// authenticate checks that the remote static key rs is signed by the
+// identity key of the payload, of the expected peer when set.
+func (c *secureConn) authenticate(rs [32]byte, b []byte, expected peer.ID) error {
+	var p payload
+	if err := p.unmarshal(b); err != nil {
+		return err
+	}
+	pub, err := ci.UnmarshalPublicKey(p.IdentityKey)
+	if err != nil {
+		return fmt.Errorf("noise: invalid identity key: %s", err)
+	}
+	ok, err := pub.Verify(append([]byte(payloadSigPrefix), rs[:]...), p.IdentitySig)
+	if err != nil || !ok {
+		return fmt.Errorf("noise: the static key isn't signed by the identity key")
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	if expected != "" && id != expected {
+		return fmt.Errorf("noise: expected peer %s, got %s", expected.Pretty(), id.Pretty())
+	}
+	c.remoteID = id
+	c.remoteKey = pub
+	return nil
+}
@@ -0,0 +1,280 @@
+// Package peering keeps the node connected to a set of peers, reconnecting
+// with backoff when the connections drop.
+package peering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("peering")
+
+const (
+	// connMgrTag tags the peered peers in the connection manager, with a
+	// value high enough for their connections to be trimmed last.
+	connMgrTag   = "peering"
+	connMgrValue = 1000
+
+	connectTimeout = 30 * time.Second
+	initialBackoff = time.Second
+	maxBackoff     = 10 * time.Minute
+)
+
+// ErrSelf is returned when peering with the local peer.
+var ErrSelf = errors.New("cannot peer with self")
+
+// Config is read from the Peering config section.
+type Config struct {
+	Peers []PeerConfig
+}
+
+// PeerConfig is a peer to stay connected to.
+type PeerConfig struct {
+	ID string
+	// Addrs are the addresses of the peer, found in the peerstore or
+	// through the routing system if there are none.
+	Addrs []string
+}
+
+// ParseConfig returns the peers of cfg.
+func ParseConfig(cfg Config) ([]pstore.PeerInfo, error) {
+	pis := make([]pstore.PeerInfo, 0, len(cfg.Peers))
+	for _, pc := range cfg.Peers {
+		id, err := peer.IDB58Decode(pc.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID %q: %s", pc.ID, err)
+		}
+		pi := pstore.PeerInfo{ID: id}
+		for _, s := range pc.Addrs {
+			a, err := ma.NewMultiaddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q of %s: %s", s, pc.ID, err)
+			}
+			pi.Addrs = append(pi.Addrs, a)
+		}
+		pis = append(pis, pi)
+	}
+	return pis, nil
+}
+
+// Service keeps the host connected to its peers once started, until it is
+// closed. Peers can be added and removed at any time.
+type Service struct {
+	host p2phost.Host
+
+	mu      sync.Mutex
+	peers   map[peer.ID]*peerHandler
+	running bool
+}
+
+// peerHandler reconnects to a peer when it is disconnected.
+type peerHandler struct {
+	s  *Service
+	id peer.ID
+
+	// the fields below are protected by the lock of the service
+	backoff time.Duration
+	timer   *time.Timer
+	// removed is set once the peer is removed, for the pending reconnects
+	// to give up
+	removed bool
+}
+
+// NewService returns a Service for h. Nothing is done until Start is called.
+func NewService(h p2phost.Host) *Service {
+	return &Service{
+		host:  h,
+		peers: make(map[peer.ID]*peerHandler),
+	}
+}
+
+// Start connects to the peers, and reconnects to them whenever they are
+// disconnected until Close is called.
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+	s.host.Network().Notify(s)
+	for _, ph := range s.peers {
+		ph.schedule(0)
+	}
+}
+
+// Close stops reconnecting to the peers, the current connections are left
+// open.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return nil
+	}
+	s.running = false
+	s.host.Network().StopNotify(s)
+	for _, ph := range s.peers {
+		ph.stop()
+	}
+	return nil
+}
+
+// AddPeer adds a peer to stay connected to, or the addresses of a peer
+// already added.
+func (s *Service) AddPeer(pi pstore.PeerInfo) error {
+	if pi.ID == s.host.ID() {
+		return ErrSelf
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.PermanentAddrTTL)
+	if _, ok := s.peers[pi.ID]; ok {
+		return nil
+	}
+
+	ph := &peerHandler{s: s, id: pi.ID}
+	s.peers[pi.ID] = ph
+	s.host.ConnManager().TagPeer(pi.ID, connMgrTag, connMgrValue)
+	if s.running {
+		ph.schedule(0)
+	}
+	return nil
+}
+
+// RemovePeer stops keeping the connection to a peer, returning false if it
+// wasn't added. The connection is left open.
+func (s *Service) RemovePeer(id peer.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ph, ok := s.peers[id]
+	if !ok {
+		return false
+	}
+	delete(s.peers, id)
+	ph.stop()
+	ph.removed = true
+	s.host.ConnManager().UntagPeer(id, connMgrTag)
+	s.host.Peerstore().UpdateAddrs(id, pstore.PermanentAddrTTL, pstore.TempAddrTTL)
+	return true
+}
+
+// ListPeers returns the peers added to the service, with their known
+// addresses.
+func (s *Service) ListPeers() []pstore.PeerInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pis := make([]pstore.PeerInfo, 0, len(s.peers))
+	for id := range s.peers {
+		pis = append(pis, s.host.Peerstore().PeerInfo(id))
+	}
+	return pis
+}
+
+// schedule reconnects to the peer after delay, unless it is already
+// scheduled. The lock of the service must be held.
+func (ph *peerHandler) schedule(delay time.Duration) {
+	if ph.timer != nil || ph.removed {
+		return
+	}
+	ph.timer = time.AfterFunc(delay, ph.reconnect)
+}
+
+// stop cancels the scheduled reconnect. The lock of the service must be
+// held.
+func (ph *peerHandler) stop() {
+	if ph.timer != nil {
+		ph.timer.Stop()
+		ph.timer = nil
+	}
+}
+
+func (ph *peerHandler) reconnect() {
+	s := ph.s
+	h := s.host
+
+	s.mu.Lock()
+	ph.timer = nil
+	if !s.running || ph.removed {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	if h.Network().Connectedness(ph.id) == inet.Connected {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	err := h.Connect(ctx, pstore.PeerInfo{ID: ph.id})
+	cancel()
+	if err == nil {
+		// the backoff is reset once the connection is notified
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ph.backoff == 0 {
+		ph.backoff = initialBackoff
+	} else if ph.backoff *= 2; ph.backoff > maxBackoff {
+		ph.backoff = maxBackoff
+	}
+	// the jitter keeps the peers which went down together from being all
+	// reconnected at once
+	delay := ph.backoff + time.Duration(rand.Int63n(int64(ph.backoff)/2+1))
+	log.Debugf("cannot connect to %s, retrying in %s: %s", ph.id, delay, err)
+	if s.running {
+		ph.schedule(delay)
+	}
+}
+
+// Connected resets the backoff of the peer. It is part of the
+// inet.Notifiee interface.
+func (s *Service) Connected(_ inet.Network, c inet.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ph, ok := s.peers[c.RemotePeer()]; ok {
+		ph.backoff = 0
+		ph.stop()
+	}
+}
+
+// Disconnected reconnects to the peer once its last connection is closed.
+// It is part of the inet.Notifiee interface.
+func (s *Service) Disconnected(n inet.Network, c inet.Conn) {
+	if n.Connectedness(c.RemotePeer()) == inet.Connected {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ph, ok := s.peers[c.RemotePeer()]; ok && s.running {
+		log.Infof("peer %s disconnected, reconnecting", c.RemotePeer())
+		ph.schedule(0)
+	}
+}
+
+func (s *Service) Listen(inet.Network, ma.Multiaddr)      {}
+func (s *Service) ListenClose(inet.Network, ma.Multiaddr) {}
+func (s *Service) OpenedStream(inet.Network, inet.Stream) {}
+func (s *Service) ClosedStream(inet.Network, inet.Stream) {}
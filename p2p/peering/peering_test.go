@@ -0,0 +1,75 @@
+package peering
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+func waitConnectedness(t *testing.T, h, other p2phost.Host, expected inet.Connectedness) {
+	for i := 0; i < 100; i++ {
+		if h.Network().Connectedness(other.ID()) == expected {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected connectedness %d to %s", expected, other.ID())
+}
+
+func TestPeering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	h1, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(h1)
+	if err := s.AddPeer(pstore.PeerInfo{ID: h1.ID()}); err != ErrSelf {
+		t.Fatalf("expected ErrSelf, got %v", err)
+	}
+	if err := s.AddPeer(pstore.PeerInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	if peers := s.ListPeers(); len(peers) != 1 || peers[0].ID != h2.ID() {
+		t.Fatalf("unexpected peers: %v", peers)
+	}
+
+	s.Start()
+	defer s.Close()
+	waitConnectedness(t, h1, h2, inet.Connected)
+
+	// dropped connections are reopened
+	if err := h1.Network().ClosePeer(h2.ID()); err != nil {
+		t.Fatal(err)
+	}
+	waitConnectedness(t, h1, h2, inet.Connected)
+
+	if !s.RemovePeer(h2.ID()) {
+		t.Fatal("expected the peer to be removed")
+	}
+	if s.RemovePeer(h2.ID()) {
+		t.Fatal("expected the peer to be removed once")
+	}
+	if err := h1.Network().ClosePeer(h2.ID()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if h1.Network().Connectedness(h2.ID()) == inet.Connected {
+		t.Fatal("expected a removed peer not to be reconnected")
+	}
+}
@@ -0,0 +1,93 @@
+package pex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	pb "github.com/ipfs/go-ipfs/p2p/pex/pb"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	ggio "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/io"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+const maxMessageSize = 64 << 10
+
+func newMessage(peers []pstore.PeerInfo) *pb.Exchange {
+	msg := &pb.Exchange{Peers: make([]*pb.Exchange_Peer, len(peers))}
+	for i, pi := range peers {
+		p := &pb.Exchange_Peer{Id: []byte(pi.ID)}
+		for _, a := range pi.Addrs {
+			p.Addrs = append(p.Addrs, a.Bytes())
+		}
+		msg.Peers[i] = p
+	}
+	return msg
+}
+
+// msgPeers returns the peers of msg, skipping the peers with an invalid ID
+// and the addresses that can't be parsed. It fails if there are more than
+// maxPeers peers, or more than maxAddrs addresses for a peer.
+func msgPeers(msg *pb.Exchange, maxPeers, maxAddrs int) ([]pstore.PeerInfo, error) {
+	if len(msg.GetPeers()) > maxPeers {
+		return nil, fmt.Errorf("more than %d peers", maxPeers)
+	}
+
+	var peers []pstore.PeerInfo
+	for _, p := range msg.GetPeers() {
+		if len(p.GetAddrs()) > maxAddrs {
+			return nil, fmt.Errorf("more than %d addresses", maxAddrs)
+		}
+		id, err := peer.IDFromBytes(p.GetId())
+		if err != nil {
+			continue
+		}
+
+		pi := pstore.PeerInfo{ID: id}
+		for _, b := range p.GetAddrs() {
+			if a, err := ma.NewMultiaddrBytes(b); err == nil {
+				pi.Addrs = append(pi.Addrs, a)
+			}
+		}
+		peers = append(peers, pi)
+	}
+	return peers, nil
+}
+
+// byteReader reads a byte at a time, for the length prefix not to be read
+// past.
+type byteReader struct {
+	r io.Reader
+}
+
+func (br byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(br.r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readMsg reads a message prefixed with its length.
+func readMsg(r io.Reader, msg *pb.Exchange) error {
+	l, err := binary.ReadUvarint(byteReader{r})
+	if err != nil {
+		return err
+	}
+	if l > maxMessageSize {
+		return fmt.Errorf("message too large: %d bytes", l)
+	}
+
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	return proto.Unmarshal(buf, msg)
+}
+
+func writeMsg(w io.Writer, msg *pb.Exchange) error {
+	return ggio.NewDelimitedWriter(w).WriteMsg(msg)
+}
@@ -0,0 +1,57 @@
+// source: p2p/pex/pb/pex.proto
+
+package pb
+
+import proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Exchange struct {
+	Peers            []*Exchange_Peer `protobuf:"bytes,1,rep,name=peers" json:"peers,omitempty"`
+	XXX_unrecognized []byte           `json:"-"`
+}
+
+func (m *Exchange) Reset()         { *m = Exchange{} }
+func (m *Exchange) String() string { return proto.CompactTextString(m) }
+func (*Exchange) ProtoMessage()    {}
+
+func (m *Exchange) GetPeers() []*Exchange_Peer {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+type Exchange_Peer struct {
+	Id               []byte   `protobuf:"bytes,1,req,name=id" json:"id,omitempty"`
+	Addrs            [][]byte `protobuf:"bytes,2,rep,name=addrs" json:"addrs,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *Exchange_Peer) Reset()         { *m = Exchange_Peer{} }
+func (m *Exchange_Peer) String() string { return proto.CompactTextString(m) }
+func (*Exchange_Peer) ProtoMessage()    {}
+
+func (m *Exchange_Peer) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Exchange_Peer) GetAddrs() [][]byte {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Exchange)(nil), "pex.pb.Exchange")
+	proto.RegisterType((*Exchange_Peer)(nil), "pex.pb.Exchange.Peer")
+}
@@ -0,0 +1,416 @@
+// Package pex implements a peer exchange: when two peers connect, each
+// sends the other a sample of the peers it is connected to, which the other
+// dials until it has enough connections. The mesh of a private network forms
+// quickly even with few bootstrap peers.
+package pex
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/pex/pb"
+	relay "github.com/ipfs/go-ipfs/p2p/relay"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("pex")
+
+// ProtocolID is the protocol of the peer exchange streams.
+const ProtocolID = "/ipfs/pex/1.0.0"
+
+const (
+	// DefaultSampleSize is the number of peers sent to a new peer.
+	DefaultSampleSize = 16
+	// DefaultMaxDials is the number of the received peers dialed.
+	DefaultMaxDials = 8
+	// MaxSampleSize bounds the peers of a sample, sent or received.
+	MaxSampleSize = 64
+	// maxAddrs bounds the addresses of a peer, sent or received.
+	maxAddrs = 8
+
+	// requestBackoff is how long the peers aren't asked again for a sample
+	// once they were.
+	requestBackoff = 10 * time.Minute
+	// serveInterval is how often a peer can get a sample.
+	serveInterval = time.Minute
+	// maxActive bounds the exchanges in progress.
+	maxActive = 16
+	// maxTracked is the number of peers remembered by the backoffs before
+	// the expired ones are forgotten.
+	maxTracked = 1024
+
+	streamTimeout = 30 * time.Second
+	dialTimeout   = 10 * time.Second
+)
+
+// Config is read from the Swarm.PeerExchange config section.
+type Config struct {
+	Enabled bool
+	// SampleSize is the number of peers sent to a new peer.
+	SampleSize int
+	// MaxDials is the number of peers dialed from the sample of a new peer.
+	MaxDials int
+	// TargetPeers is the number of connected peers above which the
+	// received peers aren't dialed, unlimited if 0.
+	TargetPeers int
+}
+
+// DefaultConfig returns the config of the peer exchange, disabled.
+func DefaultConfig() Config {
+	return Config{
+		SampleSize: DefaultSampleSize,
+		MaxDials:   DefaultMaxDials,
+	}
+}
+
+// Validate checks the limits of the config.
+func (c Config) Validate() error {
+	if c.SampleSize < 0 || c.SampleSize > MaxSampleSize {
+		return fmt.Errorf("SampleSize must be between 0 and %d", MaxSampleSize)
+	}
+	if c.MaxDials < 0 || c.MaxDials > MaxSampleSize {
+		return fmt.Errorf("MaxDials must be between 0 and %d", MaxSampleSize)
+	}
+	if c.TargetPeers < 0 {
+		return fmt.Errorf("TargetPeers must be positive")
+	}
+	return nil
+}
+
+// Stats are the peer exchanges since the service started.
+type Stats struct {
+	// Exchanges and Failures are the samples asked to the new peers, and
+	// those that couldn't be read, e.g. from the peers without the peer
+	// exchange enabled.
+	Exchanges int
+	Failures  int
+	// Served is the number of samples sent, Refused the number of those
+	// refused to the peers asking too often.
+	Served  int
+	Refused int
+	// PeersReceived is the number of peers in the samples received, and
+	// PeersIgnored the number of those that weren't to be dialed: the
+	// connected peers, those without a direct address and those over
+	// MaxDials.
+	PeersReceived int
+	PeersIgnored  int
+	// Dials and DialFailures are the connections to the received peers.
+	Dials        int
+	DialFailures int
+}
+
+// Service exchanges peers with the new peers once started, until it is
+// closed.
+type Service struct {
+	host p2phost.Host
+	cfg  Config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	running   bool
+	active    map[peer.ID]struct{}
+	requested map[peer.ID]time.Time
+	served    map[peer.ID]time.Time
+	stats     Stats
+}
+
+// NewService returns a Service for h. Nothing is done until Start is called.
+func NewService(h p2phost.Host, cfg Config) (*Service, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Service{
+		host:      h,
+		cfg:       cfg,
+		active:    make(map[peer.ID]struct{}),
+		requested: make(map[peer.ID]time.Time),
+		served:    make(map[peer.ID]time.Time),
+	}, nil
+}
+
+// Start sends samples to the peers asking for them, and asks every new peer
+// for one.
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.host.SetStreamHandler(ProtocolID, s.handleStream)
+	s.host.Network().Notify(s)
+}
+
+// Close stops the exchanges, waiting for those in progress to be canceled.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return nil
+	}
+	s.running = false
+	s.host.Network().StopNotify(s)
+	s.host.RemoveStreamHandler(ProtocolID)
+	s.cancel()
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// Stats returns the peer exchanges since the service started.
+func (s *Service) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// begin marks the exchange with p as active, returning false if p was asked
+// for a sample recently, or if there are too many exchanges in progress.
+func (s *Service) begin(p peer.ID, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running || len(s.active) >= maxActive {
+		return false
+	}
+	if _, ok := s.active[p]; ok {
+		return false
+	}
+	if t, ok := s.requested[p]; ok && now.Sub(t) < requestBackoff {
+		return false
+	}
+	prune(s.requested, now, requestBackoff)
+	s.requested[p] = now
+	s.active[p] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+// end marks the exchange started with begin as complete.
+func (s *Service) end(p peer.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.active, p)
+	s.wg.Done()
+}
+
+// exchange asks p for a sample of its peers, and dials them one at a time
+// until there are enough connected peers.
+func (s *Service) exchange(p peer.ID) {
+	defer s.end(p)
+
+	peers, err := s.request(p)
+	if err != nil {
+		log.Debugf("cannot exchange peers with %s: %s", p, err)
+	}
+	dial := s.filter(p, peers)
+
+	s.mu.Lock()
+	s.stats.Exchanges++
+	if err != nil {
+		s.stats.Failures++
+	}
+	s.stats.PeersReceived += len(peers)
+	s.stats.PeersIgnored += len(peers) - len(dial)
+	s.mu.Unlock()
+
+	for _, pi := range dial {
+		if s.ctx.Err() != nil {
+			return
+		}
+		if s.cfg.TargetPeers > 0 && len(s.host.Network().Peers()) >= s.cfg.TargetPeers {
+			return
+		}
+		s.dial(pi)
+	}
+}
+
+func (s *Service) request(p peer.ID) ([]pstore.PeerInfo, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, streamTimeout)
+	defer cancel()
+
+	st, err := s.host.NewStream(ctx, p, ProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(streamTimeout))
+
+	var m pb.Exchange
+	if err := readMsg(st, &m); err != nil {
+		st.Reset()
+		return nil, err
+	}
+	peers, err := msgPeers(&m, MaxSampleSize, maxAddrs)
+	if err != nil {
+		st.Reset()
+		return nil, err
+	}
+	return peers, nil
+}
+
+// filter returns up to MaxDials of the peers received from p in a random
+// order, without the local peer, the connected peers and the addresses that
+// can't be dialed directly.
+func (s *Service) filter(from peer.ID, peers []pstore.PeerInfo) []pstore.PeerInfo {
+	self := s.host.ID()
+	seen := make(map[peer.ID]struct{}, len(peers))
+	var out []pstore.PeerInfo
+	for _, pi := range peers {
+		if _, ok := seen[pi.ID]; ok || pi.ID == self || pi.ID == from {
+			continue
+		}
+		seen[pi.ID] = struct{}{}
+		if s.host.Network().Connectedness(pi.ID) == inet.Connected {
+			continue
+		}
+		addrs := directAddrs(pi.Addrs)
+		if len(addrs) == 0 {
+			continue
+		}
+		out = append(out, pstore.PeerInfo{ID: pi.ID, Addrs: addrs})
+	}
+
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	if len(out) > s.cfg.MaxDials {
+		out = out[:s.cfg.MaxDials]
+	}
+	return out
+}
+
+func (s *Service) dial(pi pstore.PeerInfo) {
+	ctx, cancel := context.WithTimeout(s.ctx, dialTimeout)
+	defer cancel()
+
+	err := s.host.Connect(ctx, pi)
+	if err != nil {
+		log.Debugf("cannot connect to %s received from a peer exchange: %s", pi.ID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Dials++
+	if err != nil {
+		s.stats.DialFailures++
+	}
+}
+
+// handleStream sends a sample of the connected peers to the remote peer,
+// unless it got one recently.
+func (s *Service) handleStream(st inet.Stream) {
+	defer st.Close()
+	p := st.Conn().RemotePeer()
+
+	now := time.Now()
+	s.mu.Lock()
+	if t, ok := s.served[p]; ok && now.Sub(t) < serveInterval {
+		s.stats.Refused++
+		s.mu.Unlock()
+		st.Reset()
+		return
+	}
+	prune(s.served, now, serveInterval)
+	s.served[p] = now
+	s.mu.Unlock()
+
+	st.SetDeadline(now.Add(streamTimeout))
+	if err := writeMsg(st, newMessage(s.sample(p))); err != nil {
+		st.Reset()
+		return
+	}
+
+	s.mu.Lock()
+	s.stats.Served++
+	s.mu.Unlock()
+}
+
+// sample returns up to SampleSize of the peers connected directly, other
+// than p, with the addresses they can be dialed on.
+func (s *Service) sample(p peer.ID) []pstore.PeerInfo {
+	n := s.host.Network()
+	peers := n.Peers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+
+	var out []pstore.PeerInfo
+	for _, id := range peers {
+		if len(out) == s.cfg.SampleSize {
+			break
+		}
+		if id == p || !hasDirectConn(n, id) {
+			continue
+		}
+		addrs := directAddrs(s.host.Peerstore().Addrs(id))
+		if len(addrs) == 0 {
+			continue
+		}
+		if len(addrs) > maxAddrs {
+			addrs = addrs[:maxAddrs]
+		}
+		out = append(out, pstore.PeerInfo{ID: id, Addrs: addrs})
+	}
+	return out
+}
+
+// Connected asks the new peers for a sample of their peers. It is part of
+// the inet.Notifiee interface.
+func (s *Service) Connected(n inet.Network, c inet.Conn) {
+	p := c.RemotePeer()
+	if !s.begin(p, time.Now()) {
+		return
+	}
+	go s.exchange(p)
+}
+
+func (s *Service) Disconnected(inet.Network, inet.Conn)   {}
+func (s *Service) Listen(inet.Network, ma.Multiaddr)      {}
+func (s *Service) ListenClose(inet.Network, ma.Multiaddr) {}
+func (s *Service) OpenedStream(inet.Network, inet.Stream) {}
+func (s *Service) ClosedStream(inet.Network, inet.Stream) {}
+
+// prune forgets the peers of m seen longer than d ago, once there are too
+// many of them.
+func prune(m map[peer.ID]time.Time, now time.Time, d time.Duration) {
+	if len(m) < maxTracked {
+		return
+	}
+	for p, t := range m {
+		if now.Sub(t) >= d {
+			delete(m, p)
+		}
+	}
+}
+
+func directAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var out []ma.Multiaddr
+	for _, a := range addrs {
+		if !relay.IsRelayAddr(a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func hasDirectConn(n inet.Network, p peer.ID) bool {
+	for _, c := range n.ConnsToPeer(p) {
+		if !relay.IsRelayAddr(c.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package pex
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/pex/pb"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	a, err := peer.IDB58Decode("QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := peer.IDB58Decode("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := ma.NewMultiaddr("/ip4/1.2.3.4/tcp/4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	in := newMessage([]pstore.PeerInfo{
+		{ID: a, Addrs: []ma.Multiaddr{addr}},
+		{ID: b},
+	})
+	if err := writeMsg(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+
+	var m pb.Exchange
+	if err := readMsg(&buf, &m); err != nil {
+		t.Fatal(err)
+	}
+	peers, err := msgPeers(&m, MaxSampleSize, maxAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || peers[0].ID != a || len(peers[0].Addrs) != 1 ||
+		!peers[0].Addrs[0].Equal(addr) || peers[1].ID != b || len(peers[1].Addrs) != 0 {
+		t.Fatalf("unexpected peers: %+v", peers)
+	}
+
+	// the samples over the limits are rejected
+	if _, err := msgPeers(&m, 1, maxAddrs); err == nil {
+		t.Fatal("expected the sample of too many peers to be rejected")
+	}
+	many := pstore.PeerInfo{ID: a}
+	for i := 0; i <= maxAddrs; i++ {
+		many.Addrs = append(many.Addrs, addr)
+	}
+	if _, err := msgPeers(newMessage([]pstore.PeerInfo{many}), MaxSampleSize, maxAddrs); err == nil {
+		t.Fatal("expected the peer with too many addresses to be rejected")
+	}
+
+	// the peers with an invalid ID are skipped
+	invalid := &pb.Exchange{Peers: []*pb.Exchange_Peer{{Id: []byte("invalid")}}}
+	if peers, err := msgPeers(invalid, MaxSampleSize, maxAddrs); err != nil || len(peers) != 0 {
+		t.Fatalf("expected the invalid peer to be skipped, got %+v, %v", peers, err)
+	}
+	if err := proto.Unmarshal([]byte{1<<3 | 2, 5}, &m); err == nil {
+		t.Fatal("expected an invalid message")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range []Config{
+		{SampleSize: MaxSampleSize + 1},
+		{SampleSize: -1},
+		{MaxDials: MaxSampleSize + 1},
+		{TargetPeers: -1},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", cfg)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.WithNPeers(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	services := make([]*Service, len(hosts))
+	for i, h := range hosts {
+		if services[i], err = NewService(h, DefaultConfig()); err != nil {
+			t.Fatal(err)
+		}
+		services[i].Start()
+		defer services[i].Close()
+	}
+
+	// 0 is connected to 1 and 2, which 3 finds through it
+	for _, i := range []int{1, 2} {
+		hosts[0].Peerstore().AddAddrs(hosts[i].ID(), hosts[i].Addrs(), pstore.PermanentAddrTTL)
+		if _, err := mn.ConnectPeers(hosts[0].ID(), hosts[i].ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mn.ConnectPeers(hosts[3].ID(), hosts[0].ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	connected := func() bool {
+		n := hosts[3].Network()
+		return n.Connectedness(hosts[1].ID()) == inet.Connected &&
+			n.Connectedness(hosts[2].ID()) == inet.Connected
+	}
+	for i := 0; i < 200 && !connected(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !connected() {
+		t.Fatal("expected the peers of the sample to be dialed")
+	}
+
+	if st := services[0].Stats(); st.Served == 0 {
+		t.Fatalf("expected a sample to be served: %+v", st)
+	}
+	var st Stats
+	for i := 0; i < 200; i++ {
+		if st = services[3].Stats(); st.Dials >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.PeersReceived < 2 || st.Dials < 2 || st.DialFailures != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	// the peers are only asked once, and can't ask too often
+	if services[3].begin(hosts[0].ID(), time.Now()) {
+		t.Fatal("expected the exchange to be backed off")
+	}
+	if !services[3].begin(hosts[0].ID(), time.Now().Add(requestBackoff)) {
+		t.Fatal("expected the backoff to expire")
+	}
+	services[3].end(hosts[0].ID())
+	if _, err := services[3].request(hosts[0].ID()); err == nil {
+		t.Fatal("expected the sample to be refused")
+	}
+	if st := services[0].Stats(); st.Refused != 1 {
+		t.Fatalf("expected the sample to be refused: %+v", st)
+	}
+}
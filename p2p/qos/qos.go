@@ -0,0 +1,384 @@
+// Package qos shares the upload bandwidth of the node between classes of
+// traffic: bitswap, the DHT, pubsub, the p2p tunnels and the rest.
+//
+// The writes to the streams are cut in chunks which a scheduler lets through
+// at the configured rate. When the rate is reached, the classes waiting to
+// write share it in proportion to their weights, so that the background
+// traffic, e.g. reproviding to the DHT, can't starve bitswap serving the
+// blocks fetched interactively.
+package qos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// The traffic types, by the protocols of the streams.
+const (
+	Bitswap = "bitswap"
+	DHT     = "dht"
+	Pubsub  = "pubsub"
+	P2P     = "p2p"
+	Other   = "other"
+)
+
+// TrafficTypes are all the traffic types.
+var TrafficTypes = []string{Bitswap, DHT, Pubsub, P2P, Other}
+
+// chunkSize is the most written at once, a class waits at most for a chunk of
+// each other class.
+const chunkSize = 8 << 10
+
+// ErrClosed is returned by the writes waiting when the scheduler is closed.
+var ErrClosed = errors.New("qos scheduler closed")
+
+// Config is read from the Swarm.QoS config section.
+type Config struct {
+	Enabled bool
+	// Rate is the bandwidth shared by the streams, in bytes per second.
+	Rate int64
+	// Classes are the weights of the classes.
+	Classes map[string]int
+	// Traffic is the class of each traffic type.
+	Traffic map[string]string
+}
+
+// DefaultConfig returns the config used when the section is missing.
+func DefaultConfig() Config {
+	return Config{
+		Classes: map[string]int{
+			"high":   8,
+			"normal": 4,
+			"low":    1,
+		},
+		Traffic: map[string]string{
+			Bitswap: "high",
+			DHT:     "low",
+			Pubsub:  "normal",
+			P2P:     "normal",
+			Other:   "normal",
+		},
+	}
+}
+
+// Validate returns an error if c is not usable.
+func (c Config) Validate() error {
+	if c.Rate <= 0 {
+		return errors.New("the rate must be positive")
+	}
+	for name, w := range c.Classes {
+		if w <= 0 {
+			return fmt.Errorf("the weight of class %q must be positive", name)
+		}
+	}
+	for t, class := range c.Traffic {
+		if !isTrafficType(t) {
+			return fmt.Errorf("unknown traffic type %q, expected one of %s", t, strings.Join(TrafficTypes, ", "))
+		}
+		if _, ok := c.Classes[class]; !ok {
+			return fmt.Errorf("unknown class %q of traffic %s", class, t)
+		}
+	}
+	return nil
+}
+
+func isTrafficType(t string) bool {
+	for _, tt := range TrafficTypes {
+		if t == tt {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify returns the traffic type of the streams of protocol proto.
+func Classify(proto protocol.ID) string {
+	p := string(proto)
+	switch {
+	case strings.HasPrefix(p, "/ipfs/bitswap"):
+		return Bitswap
+	case strings.HasPrefix(p, "/ipfs/kad/"), strings.HasPrefix(p, "/ipfs/dht"):
+		return DHT
+	case strings.HasPrefix(p, "/meshsub/"), strings.HasPrefix(p, "/floodsub/"):
+		return Pubsub
+	case strings.HasPrefix(p, "/x/"):
+		return P2P
+	default:
+		return Other
+	}
+}
+
+// ClassStat is the traffic of a class.
+type ClassStat struct {
+	Weight int
+	// Sent is the number of bytes written, Queued the number of bytes
+	// waiting to be.
+	Sent   uint64
+	Queued uint64
+}
+
+// Stat is the traffic of all the classes.
+type Stat struct {
+	Rate    int64
+	Classes map[string]ClassStat
+	Traffic map[string]string
+}
+
+type request struct {
+	n     int
+	ready chan struct{}
+}
+
+type class struct {
+	weight int
+	// vtime is the virtual time of the class: the bytes it sent divided by
+	// its weight. The waiting class with the lowest one writes next.
+	vtime   float64
+	waiting []*request
+	sent    uint64
+	queued  uint64
+}
+
+// Scheduler lets the writes through at a rate, sharing it between the classes.
+type Scheduler struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	vnow    float64
+	classes map[string]*class
+	traffic map[string]string
+
+	wake    chan struct{}
+	closing chan struct{}
+	closed  chan struct{}
+}
+
+// NewScheduler starts a scheduler following c, which must be valid.
+func NewScheduler(c Config) *Scheduler {
+	s := &Scheduler{
+		rate:    float64(c.Rate),
+		classes: make(map[string]*class, len(c.Classes)),
+		traffic: make(map[string]string, len(TrafficTypes)),
+		wake:    make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		closed:  make(chan struct{}),
+		last:    time.Now(),
+	}
+	// a 20ms burst, to write several chunks without waking up for each
+	s.burst = s.rate / 50
+	if s.burst < 4*chunkSize {
+		s.burst = 4 * chunkSize
+	}
+	s.tokens = s.burst
+
+	for name, w := range c.Classes {
+		s.classes[name] = &class{weight: w}
+	}
+	for _, t := range TrafficTypes {
+		s.traffic[t] = c.Traffic[t]
+	}
+	go s.run()
+	return s
+}
+
+// Close releases the writes waiting and stops the scheduler.
+func (s *Scheduler) Close() error {
+	select {
+	case <-s.closing:
+	default:
+		close(s.closing)
+	}
+	<-s.closed
+	return nil
+}
+
+// Stat returns the traffic of the classes.
+func (s *Scheduler) Stat() Stat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Stat{
+		Rate:    int64(s.rate),
+		Classes: make(map[string]ClassStat, len(s.classes)),
+		Traffic: make(map[string]string, len(s.traffic)),
+	}
+	for name, c := range s.classes {
+		st.Classes[name] = ClassStat{Weight: c.weight, Sent: c.sent, Queued: c.queued}
+	}
+	for t, class := range s.traffic {
+		st.Traffic[t] = class
+	}
+	return st
+}
+
+// class returns the class of the traffic type t, nil if it has none, whose
+// writes aren't scheduled.
+func (s *Scheduler) class(t string) *class {
+	return s.classes[s.traffic[t]]
+}
+
+// wait blocks until n bytes of the class c can be written.
+func (s *Scheduler) wait(c *class, n int) error {
+	req := &request{n: n, ready: make(chan struct{})}
+
+	s.mu.Lock()
+	if len(c.waiting) == 0 && c.vtime < s.vnow {
+		// an idle class doesn't get to catch up on the time it was idle
+		c.vtime = s.vnow
+	}
+	c.waiting = append(c.waiting, req)
+	c.queued += uint64(n)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-req.ready:
+		return nil
+	case <-s.closing:
+		return ErrClosed
+	}
+}
+
+// next returns the waiting class which should write next. The lock must be
+// held.
+func (s *Scheduler) next() *class {
+	var best *class
+	for _, c := range s.classes {
+		if len(c.waiting) > 0 && (best == nil || c.vtime < best.vtime) {
+			best = c
+		}
+	}
+	return best
+}
+
+func (s *Scheduler) run() {
+	defer close(s.closed)
+
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		s.tokens += now.Sub(s.last).Seconds() * s.rate
+		if s.tokens > s.burst {
+			s.tokens = s.burst
+		}
+		s.last = now
+
+		var delay time.Duration
+		c := s.next()
+		if c != nil {
+			req := c.waiting[0]
+			if need := float64(req.n) - s.tokens; need > 0 {
+				delay = time.Duration(need / s.rate * float64(time.Second))
+			} else {
+				s.tokens -= float64(req.n)
+				s.vnow = c.vtime
+				c.vtime += float64(req.n) / float64(c.weight)
+				c.waiting = c.waiting[1:]
+				c.queued -= uint64(req.n)
+				c.sent += uint64(req.n)
+				close(req.ready)
+				s.mu.Unlock()
+				continue
+			}
+		}
+		s.mu.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if c != nil {
+			timer = time.NewTimer(delay)
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+		case <-s.wake:
+			// a class with a lower virtual time may be waiting now
+		case <-s.closing:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Wrap returns a host whose streams are scheduled by s, by the traffic type
+// of their protocol.
+func (s *Scheduler) Wrap(h p2phost.Host) p2phost.Host {
+	return &scheduledHost{Host: h, s: s}
+}
+
+type scheduledHost struct {
+	p2phost.Host
+	s *Scheduler
+}
+
+func (h *scheduledHost) wrap(str inet.Stream) inet.Stream {
+	c := h.s.class(Classify(str.Protocol()))
+	if c == nil {
+		return str
+	}
+	return &stream{Stream: str, s: h.s, c: c}
+}
+
+func (h *scheduledHost) SetStreamHandler(pid protocol.ID, handler inet.StreamHandler) {
+	h.Host.SetStreamHandler(pid, func(str inet.Stream) {
+		handler(h.wrap(str))
+	})
+}
+
+func (h *scheduledHost) SetStreamHandlerMatch(pid protocol.ID, match func(string) bool, handler inet.StreamHandler) {
+	h.Host.SetStreamHandlerMatch(pid, match, func(str inet.Stream) {
+		handler(h.wrap(str))
+	})
+}
+
+func (h *scheduledHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (inet.Stream, error) {
+	str, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return h.wrap(str), nil
+}
+
+// stream schedules the writes to a stream.
+type stream struct {
+	inet.Stream
+	s *Scheduler
+	c *class
+}
+
+func (str *stream) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		n := len(b)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if err := str.s.wait(str.c, n); err != nil {
+			return written, err
+		}
+		m, err := str.Stream.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,120 @@
+package qos
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+)
+
+func TestClassify(t *testing.T) {
+	for proto, traffic := range map[protocol.ID]string{
+		"/ipfs/bitswap/1.1.0": Bitswap,
+		"/ipfs/bitswap":       Bitswap,
+		"/ipfs/kad/1.0.0":     DHT,
+		"/meshsub/1.0.0":      Pubsub,
+		"/floodsub/1.0.0":     Pubsub,
+		"/x/ssh":              P2P,
+		"/ipfs/id/1.0.0":      Other,
+		"":                    Other,
+	} {
+		if got := Classify(proto); got != traffic {
+			t.Errorf("%s: expected %s, got %s", proto, traffic, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := DefaultConfig()
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected the default config to need a rate")
+	}
+	c.Rate = 1 << 20
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Traffic["bitswap"] = "urgent"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected an unknown class to be rejected")
+	}
+	c = DefaultConfig()
+	c.Rate = 1 << 20
+	c.Traffic["ipns"] = "low"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected an unknown traffic type to be rejected")
+	}
+	c = DefaultConfig()
+	c.Rate = 1 << 20
+	c.Classes["low"] = 0
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected a zero weight to be rejected")
+	}
+}
+
+func TestSchedulerShares(t *testing.T) {
+	c := DefaultConfig()
+	c.Rate = 4 << 20
+	s := NewScheduler(c)
+
+	// bitswap (high, 8) and the DHT (low, 1) write as much as they can
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, traffic := range []string{Bitswap, DHT} {
+		wg.Add(1)
+		go func(cl *class) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := s.wait(cl, chunkSize); err != nil {
+					return
+				}
+			}
+		}(s.class(traffic))
+	}
+	time.Sleep(500 * time.Millisecond)
+	close(done)
+	s.Close()
+	wg.Wait()
+
+	st := s.Stat()
+	high, low := st.Classes["high"].Sent, st.Classes["low"].Sent
+	if low == 0 {
+		t.Fatal("the low class was starved")
+	}
+	if ratio := float64(high) / float64(low); ratio < 5 || ratio > 11 {
+		t.Fatalf("expected the high class to send about 8 times more than the low one, got %d and %d", high, low)
+	}
+	if total := high + low; total > 3<<20 {
+		t.Fatalf("expected about 2MB to be sent in 500ms, got %d bytes", total)
+	}
+}
+
+func TestSchedulerClose(t *testing.T) {
+	c := DefaultConfig()
+	c.Rate = 1
+	s := NewScheduler(c)
+	cl := s.class(Bitswap)
+
+	// the burst lets the first chunks through
+	for i := 0; i < 4; i++ {
+		if err := s.wait(cl, chunkSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.wait(cl, chunkSize)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
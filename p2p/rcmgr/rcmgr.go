@@ -0,0 +1,346 @@
+// Package rcmgr limits the resources the peers of the node can use: the
+// connections, the streams, per peer and per protocol, and the memory of the
+// stream multiplexers.
+//
+// Connections and streams over the limits are closed as soon as they are
+// opened, so that a single misbehaving peer can't exhaust the file
+// descriptors or the memory of the node.
+package rcmgr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("rcmgr")
+
+// Limits are the resources the peers can use. A zero limit is unlimited.
+type Limits struct {
+	// Conns is the maximum number of open connections.
+	Conns int
+	// ConnsPerPeer is the maximum number of connections to a peer.
+	ConnsPerPeer int
+	// Streams is the maximum number of open streams.
+	Streams int
+	// StreamsPerPeer is the maximum number of streams with a peer.
+	StreamsPerPeer int
+	// Protocols is the maximum number of streams of each protocol.
+	Protocols map[string]int `json:",omitempty"`
+
+	// Memory is the maximum memory used by the stream multiplexers, in
+	// bytes, estimated from the number of connections and streams.
+	Memory int64
+	// ConnMemory is the estimated memory of a connection.
+	ConnMemory int64
+	// StreamMemory is the estimated memory of a stream, mostly the buffers
+	// of its receive window.
+	StreamMemory int64
+}
+
+// DefaultLimits keep a peer from opening more than a reasonable number of
+// connections and streams, and leave the rest unlimited.
+var DefaultLimits = Limits{
+	ConnsPerPeer:   8,
+	StreamsPerPeer: 512,
+	ConnMemory:     64 << 10,
+	StreamMemory:   256 << 10,
+}
+
+// Config is read from the Swarm.ResourceMgr config section.
+type Config struct {
+	Enabled bool
+	Limits
+}
+
+// DefaultConfig returns the config used when the section is missing.
+func DefaultConfig() Config {
+	return Config{Enabled: true, Limits: DefaultLimits}
+}
+
+// Usage is the resources used by a peer or by all the peers.
+type Usage struct {
+	Conns   int
+	Streams int
+	Memory  int64
+}
+
+// Stats are the resources used, and how many connections and streams were
+// closed because of the limits.
+type Stats struct {
+	Usage
+	Peers          map[peer.ID]Usage
+	Protocols      map[string]int
+	BlockedConns   int
+	BlockedStreams int
+}
+
+// Manager enforces the limits on a host.
+type Manager struct {
+	host p2phost.Host
+
+	mu     sync.Mutex
+	limits Limits
+
+	conns     int
+	streams   int
+	peers     map[peer.ID]*Usage
+	protocols map[string]int
+	// protoStreams are the streams counted in protocols
+	protoStreams map[inet.Stream]string
+
+	blockedConns   int
+	blockedStreams int
+}
+
+// New returns a Manager enforcing l.
+func New(l Limits) *Manager {
+	m := &Manager{
+		peers:        make(map[peer.ID]*Usage),
+		protocols:    make(map[string]int),
+		protoStreams: make(map[inet.Stream]string),
+	}
+	m.SetLimits(l)
+	return m
+}
+
+// Limits returns the current limits.
+func (m *Manager) Limits() Limits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.limits
+	l.Protocols = make(map[string]int, len(m.limits.Protocols))
+	for p, n := range m.limits.Protocols {
+		l.Protocols[p] = n
+	}
+	return l
+}
+
+// SetLimits changes the limits. The connections and streams already open are
+// kept, even if they are over the new limits.
+func (m *Manager) SetLimits(l Limits) {
+	protos := make(map[string]int, len(l.Protocols))
+	for p, n := range l.Protocols {
+		protos[p] = n
+	}
+	l.Protocols = protos
+
+	m.mu.Lock()
+	m.limits = l
+	m.mu.Unlock()
+}
+
+// Stats returns the resources currently used.
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := Stats{
+		Usage:          m.usage(m.conns, m.streams),
+		Peers:          make(map[peer.ID]Usage, len(m.peers)),
+		Protocols:      make(map[string]int, len(m.protocols)),
+		BlockedConns:   m.blockedConns,
+		BlockedStreams: m.blockedStreams,
+	}
+	for p, u := range m.peers {
+		st.Peers[p] = m.usage(u.Conns, u.Streams)
+	}
+	for p, n := range m.protocols {
+		st.Protocols[p] = n
+	}
+	return st
+}
+
+// usage estimates the memory used by the muxers for conns and streams. The
+// lock must be held.
+func (m *Manager) usage(conns, streams int) Usage {
+	return Usage{
+		Conns:   conns,
+		Streams: streams,
+		Memory:  int64(conns)*m.limits.ConnMemory + int64(streams)*m.limits.StreamMemory,
+	}
+}
+
+func over(n, limit int) bool {
+	return limit > 0 && n > limit
+}
+
+func (m *Manager) overMemory() bool {
+	return m.limits.Memory > 0 && m.usage(m.conns, m.streams).Memory > m.limits.Memory
+}
+
+func (m *Manager) peer(p peer.ID) *Usage {
+	u, ok := m.peers[p]
+	if !ok {
+		u = &Usage{}
+		m.peers[p] = u
+	}
+	return u
+}
+
+func (m *Manager) release(p peer.ID) {
+	if u, ok := m.peers[p]; ok && u.Conns <= 0 && u.Streams <= 0 {
+		delete(m.peers, p)
+	}
+}
+
+// Wrap returns a host whose stream handlers and new streams are subject to
+// the protocol limits. The host must be started with Start.
+func (m *Manager) Wrap(h p2phost.Host) p2phost.Host {
+	return &limitedHost{Host: h, m: m}
+}
+
+// Start accounts the connections and streams of h until Close is called.
+func (m *Manager) Start(h p2phost.Host) {
+	m.host = h
+	h.Network().Notify(m)
+	for _, c := range h.Network().Conns() {
+		m.Connected(h.Network(), c)
+	}
+}
+
+// Close stops accounting the connections and streams.
+func (m *Manager) Close() error {
+	if m.host != nil {
+		m.host.Network().StopNotify(m)
+	}
+	return nil
+}
+
+// reserveProtocol counts s in the streams of its protocol, returning an
+// error if there are too many of them.
+func (m *Manager) reserveProtocol(s inet.Stream) error {
+	proto := string(s.Protocol())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.protoStreams[s]; ok {
+		return nil
+	}
+	if over(m.protocols[proto]+1, m.limits.Protocols[proto]) {
+		m.blockedStreams++
+		return fmt.Errorf("too many %s streams", proto)
+	}
+	m.protocols[proto]++
+	m.protoStreams[s] = proto
+	return nil
+}
+
+// Connected accounts a new connection, closing it if it is over the limits.
+// It is part of the inet.Notifiee interface.
+func (m *Manager) Connected(_ inet.Network, c inet.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := c.RemotePeer()
+	u := m.peer(p)
+	m.conns++
+	u.Conns++
+
+	if over(m.conns, m.limits.Conns) || over(u.Conns, m.limits.ConnsPerPeer) || m.overMemory() {
+		m.blockedConns++
+		log.Debugf("closing connection to %s: over the resource limits", p)
+		// the connection is released once the disconnection is notified
+		go c.Close()
+	}
+}
+
+// Disconnected releases a connection. It is part of the inet.Notifiee
+// interface.
+func (m *Manager) Disconnected(_ inet.Network, c inet.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := c.RemotePeer()
+	u := m.peer(p)
+	m.conns--
+	u.Conns--
+	m.release(p)
+}
+
+// OpenedStream accounts a new stream, resetting it if it is over the
+// limits. It is part of the inet.Notifiee interface.
+func (m *Manager) OpenedStream(_ inet.Network, s inet.Stream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := s.Conn().RemotePeer()
+	u := m.peer(p)
+	m.streams++
+	u.Streams++
+
+	if over(m.streams, m.limits.Streams) || over(u.Streams, m.limits.StreamsPerPeer) || m.overMemory() {
+		m.blockedStreams++
+		log.Debugf("resetting stream with %s: over the resource limits", p)
+		// the stream is released once its closing is notified
+		go s.Reset()
+	}
+}
+
+// ClosedStream releases a stream. It is part of the inet.Notifiee interface.
+func (m *Manager) ClosedStream(_ inet.Network, s inet.Stream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := s.Conn().RemotePeer()
+	u := m.peer(p)
+	m.streams--
+	u.Streams--
+	m.release(p)
+
+	if proto, ok := m.protoStreams[s]; ok {
+		delete(m.protoStreams, s)
+		if m.protocols[proto]--; m.protocols[proto] <= 0 {
+			delete(m.protocols, proto)
+		}
+	}
+}
+
+func (m *Manager) Listen(inet.Network, ma.Multiaddr)      {}
+func (m *Manager) ListenClose(inet.Network, ma.Multiaddr) {}
+
+// limitedHost applies the protocol limits to the streams of a host.
+type limitedHost struct {
+	p2phost.Host
+	m *Manager
+}
+
+func (h *limitedHost) handler(handler inet.StreamHandler) inet.StreamHandler {
+	return func(s inet.Stream) {
+		if err := h.m.reserveProtocol(s); err != nil {
+			log.Debugf("resetting stream with %s: %s", s.Conn().RemotePeer(), err)
+			s.Reset()
+			return
+		}
+		handler(s)
+	}
+}
+
+func (h *limitedHost) SetStreamHandler(pid protocol.ID, handler inet.StreamHandler) {
+	h.Host.SetStreamHandler(pid, h.handler(handler))
+}
+
+func (h *limitedHost) SetStreamHandlerMatch(pid protocol.ID, match func(string) bool, handler inet.StreamHandler) {
+	h.Host.SetStreamHandlerMatch(pid, match, h.handler(handler))
+}
+
+func (h *limitedHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (inet.Stream, error) {
+	s, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.m.reserveProtocol(s); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	return s, nil
+}
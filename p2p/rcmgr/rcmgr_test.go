@@ -0,0 +1,119 @@
+package rcmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+func waitStats(t *testing.T, m *Manager, cond func(Stats) bool) Stats {
+	var st Stats
+	for i := 0; i < 100; i++ {
+		if st = m.Stats(); cond(st) {
+			return st
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("unexpected stats: %+v", st)
+	return st
+}
+
+func twoPeers(t *testing.T, ctx context.Context) (mocknet.Mocknet, p2phost.Host, p2phost.Host) {
+	mn := mocknet.New(ctx)
+	h1, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	return mn, h1, h2
+}
+
+func TestConnsPerPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, h1, h2 := twoPeers(t, ctx)
+
+	m := New(Limits{ConnsPerPeer: 1, ConnMemory: 10})
+	m.Start(h1)
+	defer m.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := mn.ConnectPeers(h1.ID(), h2.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st := waitStats(t, m, func(st Stats) bool {
+		return st.BlockedConns == 1 && st.Conns == 1
+	})
+	if u := st.Peers[h2.ID()]; u.Conns != 1 || u.Memory != 10 {
+		t.Fatalf("unexpected usage of %s: %+v", h2.ID(), u)
+	}
+	if len(h1.Network().ConnsToPeer(h2.ID())) != 1 {
+		t.Fatal("expected the connection over the limit to be closed")
+	}
+}
+
+func TestProtocolStreams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, h1, h2 := twoPeers(t, ctx)
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(Limits{Protocols: map[string]int{"/test": 1}})
+	m.Start(h1)
+	defer m.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	m.Wrap(h1).SetStreamHandler("/test", func(s inet.Stream) {
+		<-done
+		s.Close()
+	})
+
+	open := func() {
+		s, err := h2.NewStream(ctx, h1.ID(), "/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Write([]byte{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open()
+	waitStats(t, m, func(st Stats) bool {
+		return st.Protocols["/test"] == 1
+	})
+
+	open()
+	st := waitStats(t, m, func(st Stats) bool {
+		return st.BlockedStreams == 1
+	})
+	if st.Protocols["/test"] != 1 {
+		t.Fatalf("expected a single /test stream, got %d", st.Protocols["/test"])
+	}
+
+	// the limits can be changed at runtime
+	l := m.Limits()
+	l.Protocols["/test"] = 0
+	m.SetLimits(l)
+	open()
+	waitStats(t, m, func(st Stats) bool {
+		return st.Protocols["/test"] == 2
+	})
+}
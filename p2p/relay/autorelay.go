@@ -0,0 +1,470 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	pbv2 "github.com/ipfs/go-ipfs/p2p/relay/pb"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	circuit "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit"
+	pb "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit/pb"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+	iaddr "gx/ipfs/QmePSRaGafvmURQwQkHPDBJsaGwKXC1WpBBHVCQxdr8FPn/go-ipfs-addr"
+)
+
+const (
+	// relayTag tags the reserved relays in the connection manager, the node
+	// is only reachable while it is connected to them.
+	relayTag   = "relay"
+	relayValue = 100
+
+	// checkInterval is how often the reachability is checked and the
+	// reservations kept.
+	checkInterval = time.Minute
+	// refreshInterval bounds the time between two refreshes of a
+	// reservation, the v2 reservations being also refreshed half-way to
+	// their expiration and the v1 relays not telling it.
+	refreshInterval = 15 * time.Minute
+	// refusedBackoff is how long a peer refusing a reservation isn't asked
+	// again.
+	refusedBackoff = time.Hour
+	// maxProbes bounds the peers asked for a reservation at every check.
+	maxProbes = 8
+
+	requestTimeout = 30 * time.Second
+)
+
+// ClientConfig is read from the Swarm.RelayClient config section.
+type ClientConfig struct {
+	Enabled bool
+	// StaticRelays are the addresses of the relays to reserve first, ending
+	// with /ipfs/<peer id>. The other relays are found among the connected
+	// peers.
+	StaticRelays []string
+	// Relays is the number of relays to hold a reservation on.
+	Relays int
+	// Reachability forces the reachability of the node, "public" or
+	// "private". It is detected from its listen addresses by default.
+	Reachability string
+}
+
+// DefaultClientConfig returns the config used for the keys missing from the
+// Swarm.RelayClient config section.
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{Relays: 2}
+}
+
+// Reachability of the node.
+type Reachability int
+
+const (
+	ReachabilityUnknown Reachability = iota
+	ReachabilityPublic
+	ReachabilityPrivate
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case ReachabilityPublic:
+		return "public"
+	case ReachabilityPrivate:
+		return "private"
+	default:
+		return "unknown"
+	}
+}
+
+// ClientStats are the reachability of the node and the relays it reserved.
+type ClientStats struct {
+	Reachability Reachability
+	Relays       []peer.ID
+}
+
+// AutoRelay reserves relays when the node isn't publicly dialable, and adds
+// the relayed addresses to the addresses of the host. It accepts the circuits
+// the v2 relays open to the node.
+type AutoRelay struct {
+	static []pstore.PeerInfo
+	want   int
+	forced Reachability
+
+	mu           sync.Mutex
+	host         p2phost.Host
+	reachability Reachability
+	relays       map[peer.ID]time.Time // refresh time of the reservation
+	refused      map[peer.ID]time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewAutoRelay returns an AutoRelay for cfg. Its AddrsFactory must be set on
+// the host, and it must be started once the host is listening.
+func NewAutoRelay(cfg ClientConfig) (*AutoRelay, error) {
+	ar := &AutoRelay{
+		want:    cfg.Relays,
+		relays:  make(map[peer.ID]time.Time),
+		refused: make(map[peer.ID]time.Time),
+	}
+
+	switch cfg.Reachability {
+	case "":
+	case "public":
+		ar.forced = ReachabilityPublic
+	case "private":
+		ar.forced = ReachabilityPrivate
+	default:
+		return nil, fmt.Errorf("invalid Reachability %q, expected \"public\" or \"private\"", cfg.Reachability)
+	}
+
+	for _, s := range cfg.StaticRelays {
+		a, err := iaddr.ParseString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relay %q: %s", s, err)
+		}
+		pi := pstore.PeerInfo{ID: a.ID()}
+		if tpt := a.Transport(); tpt != nil {
+			pi.Addrs = append(pi.Addrs, tpt)
+		}
+		ar.static = append(ar.static, pi)
+	}
+	return ar, nil
+}
+
+// Start keeps the reservations of h, and accepts the circuits of its v2
+// relays, until Close is called.
+func (ar *AutoRelay) Start(h p2phost.Host) {
+	ar.mu.Lock()
+	ar.host = h
+	ar.mu.Unlock()
+
+	h.SetStreamHandler(ProtoIDv2Stop, ar.handleStop)
+
+	for _, pi := range ar.static {
+		h.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.PermanentAddrTTL)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ar.cancel = cancel
+	ar.done = make(chan struct{})
+
+	go func() {
+		defer close(ar.done)
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			ar.check(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops keeping the reservations and accepting the v2 circuits, the
+// connections to the relays are left open.
+func (ar *AutoRelay) Close() error {
+	if ar.cancel == nil {
+		return nil
+	}
+	ar.host.RemoveStreamHandler(ProtoIDv2Stop)
+	ar.cancel()
+	<-ar.done
+	return nil
+}
+
+// Stats returns the reachability of the node and its relays.
+func (ar *AutoRelay) Stats() ClientStats {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	st := ClientStats{Reachability: ar.reachability}
+	for p := range ar.relays {
+		st.Relays = append(st.Relays, p)
+	}
+	sort.Slice(st.Relays, func(i, j int) bool { return st.Relays[i] < st.Relays[j] })
+	return st
+}
+
+// AddrsFactory adds the addresses through the relays to addrs, when
+// the node isn't publicly dialable.
+func (ar *AutoRelay) AddrsFactory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if ar.host == nil || ar.reachability != ReachabilityPrivate {
+		return addrs
+	}
+
+	out := addrs
+	for p := range ar.relays {
+		for _, a := range ar.host.Peerstore().Addrs(p) {
+			if !manet.IsPublicAddr(a) || IsRelayAddr(a) {
+				continue
+			}
+			ra, err := ma.NewMultiaddr(a.String() + "/ipfs/" + p.Pretty() + "/p2p-circuit")
+			if err != nil {
+				continue
+			}
+			out = append(out, ra)
+		}
+	}
+	return out
+}
+
+// detectReachability returns public if the node listens on a public address.
+// The addresses observed by the peers aren't trusted, a NAT may not let the
+// connections in.
+func (ar *AutoRelay) detectReachability(h p2phost.Host) Reachability {
+	if ar.forced != ReachabilityUnknown {
+		return ar.forced
+	}
+
+	addrs, err := h.Network().InterfaceListenAddresses()
+	if err != nil {
+		log.Debugf("cannot list the listen addresses: %s", err)
+		return ReachabilityUnknown
+	}
+	for _, a := range addrs {
+		if manet.IsPublicAddr(a) {
+			return ReachabilityPublic
+		}
+	}
+	return ReachabilityPrivate
+}
+
+// check updates the reachability, asks the relays again and looks for new
+// ones until there are enough.
+func (ar *AutoRelay) check(ctx context.Context) {
+	h := ar.host
+	now := time.Now()
+
+	reach := ar.detectReachability(h)
+	ar.mu.Lock()
+	if reach != ar.reachability {
+		log.Infof("reachability is %s", reach)
+	}
+	ar.reachability = reach
+	ar.mu.Unlock()
+
+	if reach != ReachabilityPrivate {
+		ar.mu.Lock()
+		for p := range ar.relays {
+			ar.drop(p)
+		}
+		ar.mu.Unlock()
+		return
+	}
+
+	ar.mu.Lock()
+	var refresh []peer.ID
+	for p, at := range ar.relays {
+		if h.Network().Connectedness(p) != inet.Connected || !now.Before(at) {
+			refresh = append(refresh, p)
+		}
+	}
+	ar.mu.Unlock()
+
+	for _, p := range refresh {
+		if err := ar.useRelay(ctx, p); err != nil {
+			log.Debugf("lost the relay %s: %s", p, err)
+			ar.mu.Lock()
+			ar.drop(p)
+			ar.mu.Unlock()
+		}
+	}
+
+	for _, p := range ar.candidates(now) {
+		ar.mu.Lock()
+		enough := len(ar.relays) >= ar.want
+		ar.mu.Unlock()
+		if enough || ctx.Err() != nil {
+			return
+		}
+
+		if err := ar.useRelay(ctx, p); err != nil {
+			log.Debugf("cannot use the relay %s: %s", p, err)
+			ar.mu.Lock()
+			ar.refused[p] = now
+			ar.mu.Unlock()
+			continue
+		}
+		log.Infof("using relay %s", p)
+	}
+}
+
+// candidates returns the static relays, then connected peers in a random
+// order, leaving out the relays in use and the peers which didn't relay
+// recently.
+func (ar *AutoRelay) candidates(now time.Time) []peer.ID {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	skip := func(p peer.ID) bool {
+		if _, ok := ar.relays[p]; ok {
+			return true
+		}
+		t, ok := ar.refused[p]
+		if ok && now.Sub(t) >= refusedBackoff {
+			delete(ar.refused, p)
+			return false
+		}
+		return ok
+	}
+
+	// the static relays are asked again at every check
+	var out []peer.ID
+	for _, pi := range ar.static {
+		if _, ok := ar.relays[pi.ID]; !ok {
+			out = append(out, pi.ID)
+		}
+	}
+
+	peers := ar.host.Network().Peers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	for _, p := range peers {
+		if len(out) >= len(ar.static)+maxProbes {
+			break
+		}
+		if !skip(p) && !ar.isStatic(p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (ar *AutoRelay) isStatic(p peer.ID) bool {
+	for _, pi := range ar.static {
+		if pi.ID == p {
+			return true
+		}
+	}
+	return false
+}
+
+// useRelay connects to p and makes or refreshes a reservation on it, with a
+// v2 RESERVE request, or a v1 CAN_HOP request if p doesn't speak v2.
+func (ar *AutoRelay) useRelay(ctx context.Context, p peer.ID) error {
+	h := ar.host
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	if err := h.Connect(ctx, pstore.PeerInfo{ID: p}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	refresh := now.Add(refreshInterval)
+	exp, err := Reserve(ctx, h, p)
+	switch {
+	case err == nil:
+		if half := now.Add(exp.Sub(now) / 2); half.Before(refresh) {
+			refresh = half
+		}
+	case err == ErrRefused:
+		return err
+	default:
+		// p may not speak v2
+		if CanHop(ctx, h, p) != nil {
+			return err
+		}
+	}
+
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.relays[p] = refresh
+	h.ConnManager().TagPeer(p, relayTag, relayValue)
+	return nil
+}
+
+// drop forgets the relay p. The lock must be held.
+func (ar *AutoRelay) drop(p peer.ID) {
+	delete(ar.relays, p)
+	ar.host.ConnManager().UntagPeer(p, relayTag)
+}
+
+// ErrRefused is returned when a peer doesn't relay.
+var ErrRefused = errors.New("relay refused")
+
+// Reserve makes or refreshes a reservation on the relay p, with a circuit
+// relay v2 RESERVE request, returning its expiration. The relay opens the
+// circuits to the node with the v2 stop protocol.
+func Reserve(ctx context.Context, h p2phost.Host, p peer.ID) (time.Time, error) {
+	s, err := h.NewStream(ctx, p, ProtoIDv2Hop)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer s.Close()
+
+	if dl, ok := ctx.Deadline(); ok {
+		s.SetDeadline(dl)
+	}
+
+	var resp pbv2.HopMessage
+	err = writeMsg(s, &pbv2.HopMessage{Type: pbv2.HopMessage_RESERVE.Enum()})
+	if err == nil {
+		err = readMsg(s, &resp)
+	}
+	if err != nil {
+		s.Reset()
+		return time.Time{}, err
+	}
+
+	if resp.GetType() != pbv2.HopMessage_STATUS {
+		return time.Time{}, fmt.Errorf("unexpected relay response %s", resp.GetType())
+	}
+	if resp.GetStatus() != pbv2.Status_OK {
+		return time.Time{}, ErrRefused
+	}
+	exp := time.Unix(int64(resp.GetReservation().GetExpire()), 0)
+	if !exp.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("the reservation expires at %s, in the past", exp)
+	}
+	return exp, nil
+}
+
+// CanHop asks p whether it relays connections, with a circuit relay v1
+// CAN_HOP request, for the relays which don't speak v2. A v1 relay relays to
+// the peers connected to it, and the Service records the request as a
+// reservation.
+func CanHop(ctx context.Context, h p2phost.Host, p peer.ID) error {
+	s, err := h.NewStream(ctx, p, circuit.ProtoID)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if dl, ok := ctx.Deadline(); ok {
+		s.SetDeadline(dl)
+	}
+
+	var resp pb.CircuitRelay
+	err = writeMsg(s, &pb.CircuitRelay{Type: pb.CircuitRelay_CAN_HOP.Enum()})
+	if err == nil {
+		err = readMsg(s, &resp)
+	}
+	if err != nil {
+		s.Reset()
+		return err
+	}
+
+	if resp.GetType() != pb.CircuitRelay_STATUS {
+		return fmt.Errorf("unexpected relay response %s", resp.GetType())
+	}
+	if resp.GetCode() != pb.CircuitRelay_SUCCESS {
+		return ErrRefused
+	}
+	return nil
+}
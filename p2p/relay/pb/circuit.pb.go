@@ -0,0 +1,314 @@
+// source: p2p/relay/pb/circuit.proto
+
+package pb
+
+import proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Status int32
+
+const (
+	Status_UNUSED                  Status = 0
+	Status_OK                      Status = 100
+	Status_RESERVATION_REFUSED     Status = 200
+	Status_RESOURCE_LIMIT_EXCEEDED Status = 201
+	Status_PERMISSION_DENIED       Status = 202
+	Status_CONNECTION_FAILED       Status = 203
+	Status_NO_RESERVATION          Status = 204
+	Status_MALFORMED_MESSAGE       Status = 400
+	Status_UNEXPECTED_MESSAGE      Status = 401
+)
+
+var Status_name = map[int32]string{
+	0:   "UNUSED",
+	100: "OK",
+	200: "RESERVATION_REFUSED",
+	201: "RESOURCE_LIMIT_EXCEEDED",
+	202: "PERMISSION_DENIED",
+	203: "CONNECTION_FAILED",
+	204: "NO_RESERVATION",
+	400: "MALFORMED_MESSAGE",
+	401: "UNEXPECTED_MESSAGE",
+}
+var Status_value = map[string]int32{
+	"UNUSED":                  0,
+	"OK":                      100,
+	"RESERVATION_REFUSED":     200,
+	"RESOURCE_LIMIT_EXCEEDED": 201,
+	"PERMISSION_DENIED":       202,
+	"CONNECTION_FAILED":       203,
+	"NO_RESERVATION":          204,
+	"MALFORMED_MESSAGE":       400,
+	"UNEXPECTED_MESSAGE":      401,
+}
+
+func (x Status) Enum() *Status {
+	p := new(Status)
+	*p = x
+	return p
+}
+func (x Status) String() string {
+	return proto.EnumName(Status_name, int32(x))
+}
+func (x *Status) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(Status_value, data, "Status")
+	if err != nil {
+		return err
+	}
+	*x = Status(value)
+	return nil
+}
+
+type HopMessage_Type int32
+
+const (
+	HopMessage_RESERVE HopMessage_Type = 0
+	HopMessage_CONNECT HopMessage_Type = 1
+	HopMessage_STATUS  HopMessage_Type = 2
+)
+
+var HopMessage_Type_name = map[int32]string{
+	0: "RESERVE",
+	1: "CONNECT",
+	2: "STATUS",
+}
+var HopMessage_Type_value = map[string]int32{
+	"RESERVE": 0,
+	"CONNECT": 1,
+	"STATUS":  2,
+}
+
+func (x HopMessage_Type) Enum() *HopMessage_Type {
+	p := new(HopMessage_Type)
+	*p = x
+	return p
+}
+func (x HopMessage_Type) String() string {
+	return proto.EnumName(HopMessage_Type_name, int32(x))
+}
+func (x *HopMessage_Type) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(HopMessage_Type_value, data, "HopMessage_Type")
+	if err != nil {
+		return err
+	}
+	*x = HopMessage_Type(value)
+	return nil
+}
+
+type StopMessage_Type int32
+
+const (
+	StopMessage_CONNECT StopMessage_Type = 0
+	StopMessage_STATUS  StopMessage_Type = 1
+)
+
+var StopMessage_Type_name = map[int32]string{
+	0: "CONNECT",
+	1: "STATUS",
+}
+var StopMessage_Type_value = map[string]int32{
+	"CONNECT": 0,
+	"STATUS":  1,
+}
+
+func (x StopMessage_Type) Enum() *StopMessage_Type {
+	p := new(StopMessage_Type)
+	*p = x
+	return p
+}
+func (x StopMessage_Type) String() string {
+	return proto.EnumName(StopMessage_Type_name, int32(x))
+}
+func (x *StopMessage_Type) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(StopMessage_Type_value, data, "StopMessage_Type")
+	if err != nil {
+		return err
+	}
+	*x = StopMessage_Type(value)
+	return nil
+}
+
+type HopMessage struct {
+	Type             *HopMessage_Type `protobuf:"varint,1,req,name=type,enum=circuit.pb.HopMessage_Type" json:"type,omitempty"`
+	Peer             *Peer            `protobuf:"bytes,2,opt,name=peer" json:"peer,omitempty"`
+	Reservation      *Reservation     `protobuf:"bytes,3,opt,name=reservation" json:"reservation,omitempty"`
+	Limit            *Limit           `protobuf:"bytes,4,opt,name=limit" json:"limit,omitempty"`
+	Status           *Status          `protobuf:"varint,5,opt,name=status,enum=circuit.pb.Status" json:"status,omitempty"`
+	XXX_unrecognized []byte           `json:"-"`
+}
+
+func (m *HopMessage) Reset()         { *m = HopMessage{} }
+func (m *HopMessage) String() string { return proto.CompactTextString(m) }
+func (*HopMessage) ProtoMessage()    {}
+
+func (m *HopMessage) GetType() HopMessage_Type {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return HopMessage_RESERVE
+}
+
+func (m *HopMessage) GetPeer() *Peer {
+	if m != nil {
+		return m.Peer
+	}
+	return nil
+}
+
+func (m *HopMessage) GetReservation() *Reservation {
+	if m != nil {
+		return m.Reservation
+	}
+	return nil
+}
+
+func (m *HopMessage) GetLimit() *Limit {
+	if m != nil {
+		return m.Limit
+	}
+	return nil
+}
+
+func (m *HopMessage) GetStatus() Status {
+	if m != nil && m.Status != nil {
+		return *m.Status
+	}
+	return Status_UNUSED
+}
+
+type StopMessage struct {
+	Type             *StopMessage_Type `protobuf:"varint,1,req,name=type,enum=circuit.pb.StopMessage_Type" json:"type,omitempty"`
+	Peer             *Peer             `protobuf:"bytes,2,opt,name=peer" json:"peer,omitempty"`
+	Limit            *Limit            `protobuf:"bytes,3,opt,name=limit" json:"limit,omitempty"`
+	Status           *Status           `protobuf:"varint,4,opt,name=status,enum=circuit.pb.Status" json:"status,omitempty"`
+	XXX_unrecognized []byte            `json:"-"`
+}
+
+func (m *StopMessage) Reset()         { *m = StopMessage{} }
+func (m *StopMessage) String() string { return proto.CompactTextString(m) }
+func (*StopMessage) ProtoMessage()    {}
+
+func (m *StopMessage) GetType() StopMessage_Type {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return StopMessage_CONNECT
+}
+
+func (m *StopMessage) GetPeer() *Peer {
+	if m != nil {
+		return m.Peer
+	}
+	return nil
+}
+
+func (m *StopMessage) GetLimit() *Limit {
+	if m != nil {
+		return m.Limit
+	}
+	return nil
+}
+
+func (m *StopMessage) GetStatus() Status {
+	if m != nil && m.Status != nil {
+		return *m.Status
+	}
+	return Status_UNUSED
+}
+
+type Peer struct {
+	Id               []byte   `protobuf:"bytes,1,req,name=id" json:"id,omitempty"`
+	Addrs            [][]byte `protobuf:"bytes,2,rep,name=addrs" json:"addrs,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *Peer) Reset()         { *m = Peer{} }
+func (m *Peer) String() string { return proto.CompactTextString(m) }
+func (*Peer) ProtoMessage()    {}
+
+func (m *Peer) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Peer) GetAddrs() [][]byte {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+type Reservation struct {
+	Expire           *uint64  `protobuf:"varint,1,req,name=expire" json:"expire,omitempty"`
+	Addrs            [][]byte `protobuf:"bytes,2,rep,name=addrs" json:"addrs,omitempty"`
+	Voucher          []byte   `protobuf:"bytes,3,opt,name=voucher" json:"voucher,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *Reservation) Reset()         { *m = Reservation{} }
+func (m *Reservation) String() string { return proto.CompactTextString(m) }
+func (*Reservation) ProtoMessage()    {}
+
+func (m *Reservation) GetExpire() uint64 {
+	if m != nil && m.Expire != nil {
+		return *m.Expire
+	}
+	return 0
+}
+
+func (m *Reservation) GetAddrs() [][]byte {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func (m *Reservation) GetVoucher() []byte {
+	if m != nil {
+		return m.Voucher
+	}
+	return nil
+}
+
+type Limit struct {
+	Duration         *uint32 `protobuf:"varint,1,opt,name=duration" json:"duration,omitempty"`
+	Data             *uint64 `protobuf:"varint,2,opt,name=data" json:"data,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Limit) Reset()         { *m = Limit{} }
+func (m *Limit) String() string { return proto.CompactTextString(m) }
+func (*Limit) ProtoMessage()    {}
+
+func (m *Limit) GetDuration() uint32 {
+	if m != nil && m.Duration != nil {
+		return *m.Duration
+	}
+	return 0
+}
+
+func (m *Limit) GetData() uint64 {
+	if m != nil && m.Data != nil {
+		return *m.Data
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*HopMessage)(nil), "circuit.pb.HopMessage")
+	proto.RegisterType((*StopMessage)(nil), "circuit.pb.StopMessage")
+	proto.RegisterType((*Peer)(nil), "circuit.pb.Peer")
+	proto.RegisterType((*Reservation)(nil), "circuit.pb.Reservation")
+	proto.RegisterType((*Limit)(nil), "circuit.pb.Limit")
+	proto.RegisterEnum("circuit.pb.Status", Status_name, Status_value)
+	proto.RegisterEnum("circuit.pb.HopMessage_Type", HopMessage_Type_name, HopMessage_Type_value)
+	proto.RegisterEnum("circuit.pb.StopMessage_Type", StopMessage_Type_name, StopMessage_Type_value)
+}
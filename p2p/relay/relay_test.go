@@ -0,0 +1,307 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	pbv2 "github.com/ipfs/go-ipfs/p2p/relay/pb"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	circuit "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit"
+	pb "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit/pb"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+func genHosts(t *testing.T, ctx context.Context, n int) []p2phost.Host {
+	mn := mocknet.New(ctx)
+	hosts := make([]p2phost.Host, n)
+	for i := range hosts {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts[i] = h
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	return hosts
+}
+
+// hop asks the relay r to open a circuit from h to dst, returning the
+// stream once the circuit is open.
+func hop(ctx context.Context, h, r, dst p2phost.Host) (inet.Stream, pb.CircuitRelay_Status, error) {
+	s, err := h.NewStream(ctx, r.ID(), circuit.ProtoID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = writeMsg(s, &pb.CircuitRelay{
+		Type:    pb.CircuitRelay_HOP.Enum(),
+		SrcPeer: &pb.CircuitRelay_Peer{Id: []byte(h.ID())},
+		DstPeer: &pb.CircuitRelay_Peer{Id: []byte(dst.ID())},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var resp pb.CircuitRelay
+	if err := readMsg(s, &resp); err != nil {
+		return nil, 0, err
+	}
+	return s, resp.GetCode(), nil
+}
+
+// echoStop accepts the relayed connections to h, echoing their data.
+func echoStop(h p2phost.Host) {
+	h.SetStreamHandler(circuit.ProtoID, func(s inet.Stream) {
+		var msg pb.CircuitRelay
+		if err := readMsg(s, &msg); err != nil || msg.GetType() != pb.CircuitRelay_STOP {
+			s.Reset()
+			return
+		}
+		writeStatus(s, pb.CircuitRelay_SUCCESS)
+		io.Copy(s, s)
+		s.Close()
+	})
+}
+
+func TestService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := genHosts(t, ctx, 4)
+	relay, dst, src, other := hosts[0], hosts[1], hosts[2], hosts[3]
+	echoStop(dst)
+
+	l, err := ParseLimits(DefaultServiceConfig().Limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Reservations = 1
+	l.Data = 6
+	s := NewService(relay, l)
+	s.Start()
+	defer s.Close()
+
+	// peers without a reservation aren't relayed to
+	if _, code, err := hop(ctx, src, relay, dst); err != nil || code != pb.CircuitRelay_HOP_NO_CONN_TO_DST {
+		t.Fatalf("expected HOP_NO_CONN_TO_DST, got %s, %v", code, err)
+	}
+
+	if err := CanHop(ctx, dst, relay.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := CanHop(ctx, other, relay.ID()); err != ErrRefused {
+		t.Fatalf("expected the reservation to be refused, got %v", err)
+	}
+	if st := s.Stats(); len(st.Reservations) != 1 || st.Reservations[0].Peer != dst.ID() {
+		t.Fatalf("unexpected reservations: %+v", st.Reservations)
+	}
+
+	c, code, err := hop(ctx, src, relay, dst)
+	if err != nil || code != pb.CircuitRelay_SUCCESS {
+		t.Fatalf("expected the circuit to open, got %s, %v", code, err)
+	}
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q: %v", buf, err)
+	}
+
+	// the circuit is reset past the data limit
+	c.Write([]byte("more"))
+	if _, err := io.Copy(ioutil.Discard, c); err == nil {
+		t.Fatal("expected the circuit to be reset")
+	}
+	for i := 0; i < 100 && s.Stats().Circuits != 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := s.Stats().Circuits; n != 0 {
+		t.Fatalf("expected the circuit to be released, %d open", n)
+	}
+
+	// expired reservations are dropped
+	s.expire(time.Now().Add(l.ReservationTTL))
+	if st := s.Stats(); len(st.Reservations) != 0 {
+		t.Fatalf("expected the reservation to expire: %+v", st.Reservations)
+	}
+}
+
+// hopV2 sends msg to the v2 hop protocol of the relay r, returning the
+// stream and the response.
+func hopV2(ctx context.Context, h, r p2phost.Host, msg *pbv2.HopMessage) (inet.Stream, *pbv2.HopMessage, error) {
+	s, err := h.NewStream(ctx, r.ID(), ProtoIDv2Hop)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeMsg(s, msg); err != nil {
+		return nil, nil, err
+	}
+	var resp pbv2.HopMessage
+	if err := readMsg(s, &resp); err != nil {
+		return nil, nil, err
+	}
+	return s, &resp, nil
+}
+
+// echoStopV2 accepts the v2 circuits to h from src, echoing their data.
+func echoStopV2(h p2phost.Host, src peer.ID) {
+	h.SetStreamHandler(ProtoIDv2Stop, func(s inet.Stream) {
+		var msg pbv2.StopMessage
+		if err := readMsg(s, &msg); err != nil || msg.GetType() != pbv2.StopMessage_CONNECT || string(msg.GetPeer().GetId()) != string(src) {
+			s.Reset()
+			return
+		}
+		writeMsg(s, &pbv2.StopMessage{
+			Type:   pbv2.StopMessage_STATUS.Enum(),
+			Status: pbv2.Status_OK.Enum(),
+		})
+		io.Copy(s, s)
+		s.Close()
+	})
+}
+
+func TestServiceV2(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := genHosts(t, ctx, 4)
+	relay, dst, src, other := hosts[0], hosts[1], hosts[2], hosts[3]
+	echoStopV2(dst, src.ID())
+
+	l, err := ParseLimits(DefaultServiceConfig().Limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Reservations = 1
+	s := NewService(relay, l)
+	s.Start()
+	defer s.Close()
+
+	connect := &pbv2.HopMessage{
+		Type: pbv2.HopMessage_CONNECT.Enum(),
+		Peer: &pbv2.Peer{Id: []byte(dst.ID())},
+	}
+
+	// peers without a reservation aren't relayed to
+	if _, resp, err := hopV2(ctx, src, relay, connect); err != nil || resp.GetStatus() != pbv2.Status_NO_RESERVATION {
+		t.Fatalf("expected NO_RESERVATION, got %v, %v", resp, err)
+	}
+
+	reserve := &pbv2.HopMessage{Type: pbv2.HopMessage_RESERVE.Enum()}
+	_, resp, err := hopV2(ctx, dst, relay, reserve)
+	if err != nil || resp.GetStatus() != pbv2.Status_OK {
+		t.Fatalf("expected the reservation to be accepted, got %v, %v", resp, err)
+	}
+	exp := time.Unix(int64(resp.GetReservation().GetExpire()), 0)
+	if d := time.Until(exp); d <= 0 || d > l.ReservationTTL {
+		t.Fatalf("unexpected reservation expiration %s", exp)
+	}
+	if lim := resp.GetLimit(); lim.GetDuration() != uint32(l.Duration/time.Second) || lim.GetData() != uint64(l.Data) {
+		t.Fatalf("unexpected limit %v", lim)
+	}
+	if _, resp, err := hopV2(ctx, other, relay, reserve); err != nil || resp.GetStatus() != pbv2.Status_RESERVATION_REFUSED {
+		t.Fatalf("expected the reservation to be refused, got %v, %v", resp, err)
+	}
+
+	c, resp, err := hopV2(ctx, src, relay, connect)
+	if err != nil || resp.GetStatus() != pbv2.Status_OK {
+		t.Fatalf("expected the circuit to open, got %v, %v", resp, err)
+	}
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q: %v", buf, err)
+	}
+	c.Close()
+}
+
+func TestAutoRelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := genHosts(t, ctx, 3)
+	relay, h, src := hosts[0], hosts[1], hosts[2]
+	// stands for the relay transport of h
+	echoStop(h)
+
+	l, err := ParseLimits(DefaultServiceConfig().Limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(relay, l)
+	s.Start()
+	defer s.Close()
+
+	public, err := ma.NewMultiaddr("/ip4/1.2.3.4/tcp/4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Peerstore().AddAddr(relay.ID(), public, time.Hour)
+
+	cfg := DefaultClientConfig()
+	cfg.Reachability = "private"
+	ar, err := NewAutoRelay(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar.Start(h)
+	defer ar.Close()
+
+	for i := 0; i < 100 && len(ar.Stats().Relays) == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if st := ar.Stats(); st.Reachability != ReachabilityPrivate || len(st.Relays) != 1 || st.Relays[0] != relay.ID() {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if !s.reserved(h.ID(), time.Now()) {
+		t.Fatal("expected a reservation on the relay")
+	}
+	ar.mu.Lock()
+	refresh := ar.relays[relay.ID()]
+	ar.mu.Unlock()
+	if d := time.Until(refresh); d <= 0 || d > l.ReservationTTL/2 {
+		t.Fatalf("expected the reservation to be refreshed half-way, at %s", refresh)
+	}
+
+	// the v2 circuits are handed to the relay transport
+	c, resp, err := hopV2(ctx, src, relay, &pbv2.HopMessage{
+		Type: pbv2.HopMessage_CONNECT.Enum(),
+		Peer: &pbv2.Peer{Id: []byte(h.ID())},
+	})
+	if err != nil || resp.GetStatus() != pbv2.Status_OK {
+		t.Fatalf("expected the circuit to open, got %v, %v", resp, err)
+	}
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q: %v", buf, err)
+	}
+	c.Close()
+
+	expected := "/ip4/1.2.3.4/tcp/4001/ipfs/" + relay.ID().Pretty() + "/p2p-circuit"
+	addrs := ar.AddrsFactory(nil)
+	if len(addrs) != 1 || addrs[0].String() != expected {
+		t.Fatalf("expected %s, got %v", expected, addrs)
+	}
+
+	if _, err := NewAutoRelay(ClientConfig{Reachability: "maybe"}); err == nil {
+		t.Fatal("expected an invalid reachability to fail")
+	}
+}
@@ -0,0 +1,588 @@
+// Package relay implements a relay service with resource limits, and an
+// auto-relay client reserving relays when the node isn't publicly dialable.
+//
+// The service speaks circuit relay v2, whose peers reserve a slot on the
+// relay with a RESERVE request of the hop protocol and are told the limits
+// of their circuits, and the circuit relay v1 protocol of go-libp2p-circuit,
+// so that the relay transport of any peer can dial through the service. The
+// v1 peers reserve a slot with a CAN_HOP request. The relay only relays to
+// the peers holding a reservation, made with either protocol.
+//
+// The auto-relay client reserves a slot on the v2 relays with a RESERVE
+// request, refreshed before it expires, and accepts the circuits they open
+// with the v2 stop protocol. The relay transport of the node only accepting
+// v1 circuits, the stop streams are handed to it as v1 ones. The relays which
+// don't speak v2 are asked with a v1 CAN_HOP request, and reach the node
+// through the connection it keeps to them.
+package relay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	pbv2 "github.com/ipfs/go-ipfs/p2p/relay/pb"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	circuit "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit"
+	pb "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit/pb"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("relay")
+
+const (
+	// ProtoIDv2Hop is the circuit relay v2 protocol of the clients of the
+	// relay, reserving a slot and opening circuits.
+	ProtoIDv2Hop protocol.ID = "/libp2p/circuit/relay/0.2.0/hop"
+	// ProtoIDv2Stop is the circuit relay v2 protocol of the relay, opening
+	// the circuits to their destination.
+	ProtoIDv2Stop protocol.ID = "/libp2p/circuit/relay/0.2.0/stop"
+)
+
+const (
+	// reservationTag tags the peers holding a reservation in the connection
+	// manager, so that the connections they are reachable through are
+	// trimmed last.
+	reservationTag   = "relay-reservation"
+	reservationValue = 100
+
+	// stopTimeout bounds the time taken to open the circuit to the
+	// destination.
+	stopTimeout = 30 * time.Second
+)
+
+// ServiceConfig is read from the Swarm.RelayService config section.
+type ServiceConfig struct {
+	Enabled bool
+	Limits  LimitsConfig
+}
+
+// LimitsConfig are the limits of the relay service, as found in the config.
+// Zero values disable a limit.
+type LimitsConfig struct {
+	// Reservations is the maximum number of peers holding a reservation.
+	Reservations int
+	// ReservationTTL is how long a reservation lasts unless refreshed.
+	ReservationTTL string
+	// Circuits is the maximum number of circuits relayed at once, and
+	// CircuitsPerPeer the maximum number opened by a single peer.
+	Circuits        int
+	CircuitsPerPeer int
+	// Duration is how long a circuit is kept open.
+	Duration string
+	// Data is the maximum number of bytes relayed in each direction of a
+	// circuit.
+	Data int64
+}
+
+// DefaultServiceConfig returns the config used for the keys missing from the
+// Swarm.RelayService config section.
+func DefaultServiceConfig() ServiceConfig {
+	return ServiceConfig{
+		Limits: LimitsConfig{
+			Reservations:    128,
+			ReservationTTL:  "1h",
+			Circuits:        256,
+			CircuitsPerPeer: 16,
+			Duration:        "2m",
+			Data:            128 << 10,
+		},
+	}
+}
+
+// Limits are the limits of the relay service.
+type Limits struct {
+	Reservations    int
+	ReservationTTL  time.Duration
+	Circuits        int
+	CircuitsPerPeer int
+	Duration        time.Duration
+	Data            int64
+}
+
+// ParseLimits returns the limits of cfg.
+func ParseLimits(cfg LimitsConfig) (Limits, error) {
+	l := Limits{
+		Reservations:    cfg.Reservations,
+		Circuits:        cfg.Circuits,
+		CircuitsPerPeer: cfg.CircuitsPerPeer,
+		Data:            cfg.Data,
+	}
+
+	var err error
+	if cfg.ReservationTTL != "" {
+		if l.ReservationTTL, err = time.ParseDuration(cfg.ReservationTTL); err != nil {
+			return l, fmt.Errorf("invalid ReservationTTL: %s", err)
+		}
+	}
+	if cfg.Duration != "" {
+		if l.Duration, err = time.ParseDuration(cfg.Duration); err != nil {
+			return l, fmt.Errorf("invalid Duration: %s", err)
+		}
+	}
+	return l, nil
+}
+
+func over(n, limit int) bool {
+	return limit > 0 && n > limit
+}
+
+// Reservation is a peer the service relays to.
+type Reservation struct {
+	Peer    peer.ID
+	Expires time.Time
+}
+
+// ServiceStats are the reservations and circuits of the service.
+type ServiceStats struct {
+	Reservations []Reservation
+	Circuits     int
+}
+
+// Service relays connections to the peers holding a reservation, within its
+// limits. It replaces the circuit relay stream handler of the host, so the
+// node doesn't accept relayed connections itself while it runs.
+type Service struct {
+	host   p2phost.Host
+	limits Limits
+
+	mu           sync.Mutex
+	reservations map[peer.ID]time.Time
+	circuits     map[peer.ID]int
+	total        int
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewService returns a Service for h. Nothing is relayed until Start is
+// called.
+func NewService(h p2phost.Host, l Limits) *Service {
+	return &Service{
+		host:         h,
+		limits:       l,
+		reservations: make(map[peer.ID]time.Time),
+		circuits:     make(map[peer.ID]int),
+	}
+}
+
+// Start handles the relay requests until Close is called.
+func (s *Service) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	s.host.SetStreamHandler(circuit.ProtoID, s.handleStream)
+	s.host.SetStreamHandler(ProtoIDv2Hop, s.handleHopV2)
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.expire(time.Now())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops accepting relay requests. The open circuits are left to run
+// out.
+func (s *Service) Close() error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.host.RemoveStreamHandler(circuit.ProtoID)
+	s.host.RemoveStreamHandler(ProtoIDv2Hop)
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// Stats returns the current reservations and the number of open circuits.
+func (s *Service) Stats() ServiceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := ServiceStats{
+		Reservations: make([]Reservation, 0, len(s.reservations)),
+		Circuits:     s.total,
+	}
+	for p, exp := range s.reservations {
+		st.Reservations = append(st.Reservations, Reservation{Peer: p, Expires: exp})
+	}
+	sort.Slice(st.Reservations, func(i, j int) bool {
+		return st.Reservations[i].Peer < st.Reservations[j].Peer
+	})
+	return st
+}
+
+// reserve makes or refreshes the reservation of p, returning its expiration,
+// zero if it doesn't expire, and false if there are too many reservations
+// already.
+func (s *Service) reserve(p peer.ID, now time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.reservations[p]; !ok {
+		if over(len(s.reservations)+1, s.limits.Reservations) {
+			return time.Time{}, false
+		}
+		s.host.ConnManager().TagPeer(p, reservationTag, reservationValue)
+	}
+
+	var exp time.Time
+	if s.limits.ReservationTTL > 0 {
+		exp = now.Add(s.limits.ReservationTTL)
+	}
+	s.reservations[p] = exp
+	return exp, true
+}
+
+// reserved returns whether p holds a reservation.
+func (s *Service) reserved(p peer.ID, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp, ok := s.reservations[p]
+	return ok && (exp.IsZero() || now.Before(exp))
+}
+
+// expire drops the reservations not refreshed in time.
+func (s *Service) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p, exp := range s.reservations {
+		if !exp.IsZero() && !now.Before(exp) {
+			delete(s.reservations, p)
+			s.host.ConnManager().UntagPeer(p, reservationTag)
+		}
+	}
+}
+
+// acquire counts a circuit opened by p, returning false if it is over the
+// limits.
+func (s *Service) acquire(p peer.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if over(s.total+1, s.limits.Circuits) || over(s.circuits[p]+1, s.limits.CircuitsPerPeer) {
+		return false
+	}
+	s.total++
+	s.circuits[p]++
+	return true
+}
+
+func (s *Service) release(p peer.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.total--
+	if s.circuits[p]--; s.circuits[p] <= 0 {
+		delete(s.circuits, p)
+	}
+}
+
+func (s *Service) handleStream(st inet.Stream) {
+	var msg pb.CircuitRelay
+	if err := readMsg(st, &msg); err != nil {
+		log.Debugf("error reading relay request from %s: %s", st.Conn().RemotePeer(), err)
+		st.Reset()
+		return
+	}
+
+	switch msg.GetType() {
+	case pb.CircuitRelay_CAN_HOP:
+		code := pb.CircuitRelay_SUCCESS
+		if _, ok := s.reserve(st.Conn().RemotePeer(), time.Now()); !ok {
+			log.Debugf("refusing the reservation of %s: too many reservations", st.Conn().RemotePeer())
+			code = pb.CircuitRelay_HOP_CANT_SPEAK_RELAY
+		}
+		writeStatus(st, code)
+		st.Close()
+	case pb.CircuitRelay_HOP:
+		s.handleHop(st, &msg)
+	case pb.CircuitRelay_STOP:
+		writeStatus(st, pb.CircuitRelay_STOP_RELAY_REFUSED)
+		st.Close()
+	default:
+		writeStatus(st, pb.CircuitRelay_MALFORMED_MESSAGE)
+		st.Close()
+	}
+}
+
+func (s *Service) handleHop(src inet.Stream, msg *pb.CircuitRelay) {
+	fail := func(code pb.CircuitRelay_Status) {
+		writeStatus(src, code)
+		src.Close()
+	}
+
+	srcID := src.Conn().RemotePeer()
+	if id, err := peer.IDFromBytes(msg.GetSrcPeer().GetId()); err != nil || id != srcID {
+		fail(pb.CircuitRelay_HOP_SRC_MULTIADDR_INVALID)
+		return
+	}
+	dstID, err := peer.IDFromBytes(msg.GetDstPeer().GetId())
+	if err != nil {
+		fail(pb.CircuitRelay_HOP_DST_MULTIADDR_INVALID)
+		return
+	}
+	if dstID == s.host.ID() {
+		fail(pb.CircuitRelay_HOP_CANT_RELAY_TO_SELF)
+		return
+	}
+
+	// only the peers holding a reservation are relayed to, through the
+	// connection they keep to the relay
+	if !s.reserved(dstID, time.Now()) || s.host.Network().Connectedness(dstID) != inet.Connected {
+		fail(pb.CircuitRelay_HOP_NO_CONN_TO_DST)
+		return
+	}
+
+	if !s.acquire(srcID) {
+		log.Debugf("refusing to relay %s to %s: too many circuits", srcID, dstID)
+		fail(pb.CircuitRelay_HOP_CANT_SPEAK_RELAY)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+
+	dst, err := s.host.NewStream(ctx, dstID, circuit.ProtoID)
+	if err != nil {
+		s.release(srcID)
+		fail(pb.CircuitRelay_HOP_CANT_OPEN_DST_STREAM)
+		return
+	}
+
+	dst.SetDeadline(time.Now().Add(stopTimeout))
+	stop := &pb.CircuitRelay{
+		Type:    pb.CircuitRelay_STOP.Enum(),
+		SrcPeer: msg.SrcPeer,
+		DstPeer: msg.DstPeer,
+	}
+	var resp pb.CircuitRelay
+	err = writeMsg(dst, stop)
+	if err == nil {
+		err = readMsg(dst, &resp)
+	}
+	if err != nil || resp.GetType() != pb.CircuitRelay_STATUS {
+		dst.Reset()
+		s.release(srcID)
+		fail(pb.CircuitRelay_HOP_CANT_OPEN_DST_STREAM)
+		return
+	}
+	if resp.GetCode() != pb.CircuitRelay_SUCCESS {
+		dst.Close()
+		s.release(srcID)
+		fail(resp.GetCode())
+		return
+	}
+	dst.SetDeadline(time.Time{})
+
+	if err := writeStatus(src, pb.CircuitRelay_SUCCESS); err != nil {
+		dst.Reset()
+		src.Reset()
+		s.release(srcID)
+		return
+	}
+
+	log.Debugf("relaying %s to %s", srcID, dstID)
+	go s.relay(srcID, src, dst)
+}
+
+func (s *Service) handleHopV2(st inet.Stream) {
+	st.SetDeadline(time.Now().Add(stopTimeout))
+
+	var msg pbv2.HopMessage
+	if err := readMsg(st, &msg); err != nil {
+		log.Debugf("error reading relay v2 request from %s: %s", st.Conn().RemotePeer(), err)
+		writeHopStatus(st, pbv2.Status_MALFORMED_MESSAGE)
+		st.Close()
+		return
+	}
+
+	switch msg.GetType() {
+	case pbv2.HopMessage_RESERVE:
+		s.handleReserveV2(st)
+		st.Close()
+	case pbv2.HopMessage_CONNECT:
+		s.handleConnectV2(st, &msg)
+	default:
+		writeHopStatus(st, pbv2.Status_UNEXPECTED_MESSAGE)
+		st.Close()
+	}
+}
+
+func (s *Service) handleReserveV2(st inet.Stream) {
+	p := st.Conn().RemotePeer()
+	now := time.Now()
+	exp, ok := s.reserve(p, now)
+	if !ok {
+		log.Debugf("refusing the reservation of %s: too many reservations", p)
+		writeHopStatus(st, pbv2.Status_RESERVATION_REFUSED)
+		return
+	}
+	if exp.IsZero() {
+		// the v2 clients need an expiration, they refresh the reservation
+		// before it
+		exp = now.Add(time.Hour)
+	}
+
+	rsvp := &pbv2.Reservation{Expire: proto.Uint64(uint64(exp.Unix()))}
+	self, err := ma.NewMultiaddr("/ipfs/" + s.host.ID().Pretty())
+	if err == nil {
+		for _, a := range s.host.Addrs() {
+			rsvp.Addrs = append(rsvp.Addrs, a.Encapsulate(self).Bytes())
+		}
+	}
+
+	writeMsg(st, &pbv2.HopMessage{
+		Type:        pbv2.HopMessage_STATUS.Enum(),
+		Status:      pbv2.Status_OK.Enum(),
+		Reservation: rsvp,
+		Limit:       s.limitV2(),
+	})
+}
+
+func (s *Service) handleConnectV2(src inet.Stream, msg *pbv2.HopMessage) {
+	fail := func(code pbv2.Status) {
+		writeHopStatus(src, code)
+		src.Close()
+	}
+
+	srcID := src.Conn().RemotePeer()
+	dstID, err := peer.IDFromBytes(msg.GetPeer().GetId())
+	if err != nil {
+		fail(pbv2.Status_MALFORMED_MESSAGE)
+		return
+	}
+
+	// only the peers holding a reservation are relayed to, through the
+	// connection they keep to the relay
+	if !s.reserved(dstID, time.Now()) || s.host.Network().Connectedness(dstID) != inet.Connected {
+		fail(pbv2.Status_NO_RESERVATION)
+		return
+	}
+
+	if !s.acquire(srcID) {
+		log.Debugf("refusing to relay %s to %s: too many circuits", srcID, dstID)
+		fail(pbv2.Status_RESOURCE_LIMIT_EXCEEDED)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+
+	dst, err := s.host.NewStream(ctx, dstID, ProtoIDv2Stop)
+	if err != nil {
+		s.release(srcID)
+		fail(pbv2.Status_CONNECTION_FAILED)
+		return
+	}
+
+	dst.SetDeadline(time.Now().Add(stopTimeout))
+	stop := &pbv2.StopMessage{
+		Type:  pbv2.StopMessage_CONNECT.Enum(),
+		Peer:  &pbv2.Peer{Id: []byte(srcID)},
+		Limit: s.limitV2(),
+	}
+	var resp pbv2.StopMessage
+	err = writeMsg(dst, stop)
+	if err == nil {
+		err = readMsg(dst, &resp)
+	}
+	if err != nil || resp.GetType() != pbv2.StopMessage_STATUS || resp.GetStatus() != pbv2.Status_OK {
+		dst.Reset()
+		s.release(srcID)
+		fail(pbv2.Status_CONNECTION_FAILED)
+		return
+	}
+	dst.SetDeadline(time.Time{})
+
+	err = writeMsg(src, &pbv2.HopMessage{
+		Type:   pbv2.HopMessage_STATUS.Enum(),
+		Status: pbv2.Status_OK.Enum(),
+		Limit:  s.limitV2(),
+	})
+	if err != nil {
+		dst.Reset()
+		src.Reset()
+		s.release(srcID)
+		return
+	}
+	src.SetDeadline(time.Time{})
+
+	log.Debugf("relaying %s to %s over circuit v2", srcID, dstID)
+	go s.relay(srcID, src, dst)
+}
+
+// limitV2 is the limit of the circuits, told to the v2 peers.
+func (s *Service) limitV2() *pbv2.Limit {
+	if s.limits.Duration <= 0 && s.limits.Data <= 0 {
+		return nil
+	}
+	l := &pbv2.Limit{}
+	if s.limits.Duration > 0 {
+		l.Duration = proto.Uint32(uint32(s.limits.Duration / time.Second))
+	}
+	if s.limits.Data > 0 {
+		l.Data = proto.Uint64(uint64(s.limits.Data))
+	}
+	return l
+}
+
+// relay copies the data between the two ends of a circuit, until both are
+// closed or the circuit runs over the limits.
+func (s *Service) relay(srcID peer.ID, src, dst inet.Stream) {
+	defer s.release(srcID)
+
+	reset := func() {
+		src.Reset()
+		dst.Reset()
+	}
+	if s.limits.Duration > 0 {
+		t := time.AfterFunc(s.limits.Duration, reset)
+		defer t.Stop()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(w, r inet.Stream) {
+		defer wg.Done()
+		var err error
+		if s.limits.Data > 0 {
+			_, err = io.CopyN(w, r, s.limits.Data)
+			if err == nil {
+				// the data limit is reached
+				reset()
+				return
+			}
+		} else {
+			_, err = io.Copy(w, r)
+		}
+		if err != nil && err != io.EOF {
+			reset()
+			return
+		}
+		w.Close()
+	}
+	go pipe(dst, src)
+	go pipe(src, dst)
+	wg.Wait()
+}
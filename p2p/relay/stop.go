@@ -0,0 +1,179 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	pbv2 "github.com/ipfs/go-ipfs/p2p/relay/pb"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	circuit "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit"
+	pb "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit/pb"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// multistreamID is the protocol of the protocol negotiation of the streams.
+const multistreamID = "/multistream/1.0.0"
+
+func writeStopStatus(w io.Writer, code pbv2.Status) error {
+	return writeMsg(w, &pbv2.StopMessage{
+		Type:   pbv2.StopMessage_STATUS.Enum(),
+		Status: code.Enum(),
+	})
+}
+
+// handleStop accepts the circuits a v2 relay holding a reservation of the
+// node opens to it. The relay transport of the node only accepts v1
+// circuits, so the stream is handed to its v1 handler as a v1 STOP request,
+// and the v1 status it answers is sent back as a v2 one.
+func (ar *AutoRelay) handleStop(s inet.Stream) {
+	s.SetDeadline(time.Now().Add(stopTimeout))
+
+	var msg pbv2.StopMessage
+	if err := readMsg(s, &msg); err != nil {
+		log.Debugf("error reading relay v2 stop request from %s: %s", s.Conn().RemotePeer(), err)
+		writeStopStatus(s, pbv2.Status_MALFORMED_MESSAGE)
+		s.Close()
+		return
+	}
+	if msg.GetType() != pbv2.StopMessage_CONNECT {
+		writeStopStatus(s, pbv2.Status_UNEXPECTED_MESSAGE)
+		s.Close()
+		return
+	}
+	src, err := peer.IDFromBytes(msg.GetPeer().GetId())
+	if err != nil {
+		writeStopStatus(s, pbv2.Status_MALFORMED_MESSAGE)
+		s.Close()
+		return
+	}
+
+	ar.mu.Lock()
+	_, reserved := ar.relays[s.Conn().RemotePeer()]
+	ar.mu.Unlock()
+	if !reserved {
+		writeStopStatus(s, pbv2.Status_PERMISSION_DENIED)
+		s.Close()
+		return
+	}
+
+	handler, err := circuitHandler(ar.host)
+	if err != nil {
+		log.Debugf("cannot accept the circuit from %s: %s", src, err)
+		writeStopStatus(s, pbv2.Status_CONNECTION_FAILED)
+		s.Close()
+		return
+	}
+
+	stop, err := proto.Marshal(&pb.CircuitRelay{
+		Type:    pb.CircuitRelay_STOP.Enum(),
+		SrcPeer: &pb.CircuitRelay_Peer{Id: []byte(src), Addrs: msg.GetPeer().GetAddrs()},
+		DstPeer: &pb.CircuitRelay_Peer{Id: []byte(ar.host.ID())},
+	})
+	if err != nil {
+		writeStopStatus(s, pbv2.Status_CONNECTION_FAILED)
+		s.Close()
+		return
+	}
+	var buf [binary.MaxVarintLen64]byte
+	req := append(buf[:binary.PutUvarint(buf[:], uint64(len(stop)))], stop...)
+
+	log.Debugf("accepting the v2 circuit from %s through %s", src, s.Conn().RemotePeer())
+	handler(string(circuit.ProtoID), &v1StopStream{
+		Stream: s,
+		r:      io.MultiReader(bytes.NewReader(req), s),
+	})
+}
+
+// circuitHandler returns the handler of the circuit relay v1 protocol of h,
+// the one of its relay transport, by negotiating the protocol with the
+// protocol muxer of h.
+func circuitHandler(h p2phost.Host) (func(string, io.ReadWriteCloser) error, error) {
+	var in bytes.Buffer
+	for _, tok := range []string{multistreamID, string(circuit.ProtoID)} {
+		var buf [binary.MaxVarintLen64]byte
+		in.Write(buf[:binary.PutUvarint(buf[:], uint64(len(tok)+1))])
+		in.WriteString(tok + "\n")
+	}
+
+	_, handler, err := h.Mux().Negotiate(negotiation{&in})
+	if err != nil {
+		return nil, err
+	}
+	if handler == nil {
+		return nil, errors.New("the node doesn't accept relayed connections")
+	}
+	return handler, nil
+}
+
+// negotiation plays the side of the peer of a protocol negotiation.
+type negotiation struct {
+	io.Reader
+}
+
+func (negotiation) Write(b []byte) (int, error) {
+	return ioutil.Discard.Write(b)
+}
+
+func (negotiation) Close() error {
+	return nil
+}
+
+// v1StopStream is a v2 stop stream as seen by the v1 handler: it reads a v1
+// STOP request before the relayed data, and the v1 status written by the
+// handler is sent as a v2 one.
+type v1StopStream struct {
+	inet.Stream
+	r io.Reader
+
+	mu     sync.Mutex
+	status []byte // the part of the v1 status written so far
+	sent   bool   // the status was sent
+}
+
+func (s *v1StopStream) Read(b []byte) (int, error) {
+	return s.r.Read(b)
+}
+
+func (s *v1StopStream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sent {
+		return s.Stream.Write(b)
+	}
+
+	s.status = append(s.status, b...)
+	l, n := binary.Uvarint(s.status)
+	if n <= 0 || uint64(len(s.status)-n) < l {
+		// the status is written in several parts
+		return len(b), nil
+	}
+	var msg pb.CircuitRelay
+	if err := proto.Unmarshal(s.status[n:n+int(l)], &msg); err != nil {
+		return 0, err
+	}
+	rest := s.status[n+int(l):]
+	s.status, s.sent = nil, true
+
+	if msg.GetType() != pb.CircuitRelay_STATUS || msg.GetCode() != pb.CircuitRelay_SUCCESS {
+		log.Debugf("the relay transport refused the circuit: %s", msg.GetCode())
+		return len(b), writeStopStatus(s.Stream, pbv2.Status_CONNECTION_FAILED)
+	}
+	if err := writeStopStatus(s.Stream, pbv2.Status_OK); err != nil {
+		return 0, err
+	}
+	s.Stream.SetDeadline(time.Time{})
+	if len(rest) > 0 {
+		if _, err := s.Stream.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
@@ -0,0 +1,73 @@
+package relay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	pbv2 "github.com/ipfs/go-ipfs/p2p/relay/pb"
+
+	circuit "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit"
+	pb "gx/ipfs/QmWX6RySJ3yAYmfjLSw1LtRZnDh5oVeA9kM3scNQJkysqa/go-libp2p-circuit/pb"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	ggio "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/io"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+const maxMessageSize = 4096
+
+// IsRelayAddr returns whether a is the address of a relayed connection.
+func IsRelayAddr(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(circuit.P_CIRCUIT)
+	return err == nil
+}
+
+// byteReader reads a byte at a time, for the varint length prefix not to be
+// read past.
+type byteReader struct {
+	r io.Reader
+}
+
+func (br byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(br.r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readMsg reads a length-prefixed message without buffering, as the relayed
+// data follows the messages on the same stream.
+func readMsg(r io.Reader, msg proto.Message) error {
+	l, err := binary.ReadUvarint(byteReader{r})
+	if err != nil {
+		return err
+	}
+	if l > maxMessageSize {
+		return fmt.Errorf("message too large: %d bytes", l)
+	}
+
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	return proto.Unmarshal(buf, msg)
+}
+
+func writeMsg(w io.Writer, msg proto.Message) error {
+	return ggio.NewDelimitedWriter(w).WriteMsg(msg)
+}
+
+func writeStatus(w io.Writer, code pb.CircuitRelay_Status) error {
+	return writeMsg(w, &pb.CircuitRelay{
+		Type: pb.CircuitRelay_STATUS.Enum(),
+		Code: code.Enum(),
+	})
+}
+
+func writeHopStatus(w io.Writer, code pbv2.Status) error {
+	return writeMsg(w, &pbv2.HopMessage{
+		Type:   pbv2.HopMessage_STATUS.Enum(),
+		Status: code.Enum(),
+	})
+}
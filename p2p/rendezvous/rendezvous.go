@@ -0,0 +1,252 @@
+// Package rendezvous implements the libp2p rendezvous protocol, letting peers
+// register in namespaces at well known rendezvous points and discover the
+// other peers registered there, without relying on the DHT.
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/rendezvous/pb"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	ggio "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/io"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("rendezvous")
+
+// ProtocolID is the protocol spoken with rendezvous points.
+const ProtocolID = protocol.ID("/rendezvous/1.0.0")
+
+const (
+	// DefaultTTL is the lifetime of registrations that don't set one.
+	DefaultTTL = 2 * time.Hour
+	// MaxTTL is the longest lifetime of a registration.
+	MaxTTL = 72 * time.Hour
+	// MaxNamespaceLength is the length limit of namespaces.
+	MaxNamespaceLength = 255
+	// MaxDiscoverLimit is the largest number of registrations returned by a
+	// single discover request.
+	MaxDiscoverLimit = 1000
+)
+
+const maxMessageSize = 1 << 20
+
+// Registration is a peer registered in a namespace.
+type Registration struct {
+	Peer      pstore.PeerInfo
+	Namespace string
+	TTL       time.Duration
+}
+
+// Error is returned by the client when a rendezvous point rejects a request.
+type Error struct {
+	Status pb.Message_ResponseStatus
+	Text   string
+}
+
+func (e *Error) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("rendezvous error: %s", e.Status)
+	}
+	return fmt.Sprintf("rendezvous error: %s: %s", e.Status, e.Text)
+}
+
+// Client registers in namespaces and discovers peers at rendezvous points.
+type Client struct {
+	host p2phost.Host
+}
+
+// NewClient returns a Client using the streams of h.
+func NewClient(h p2phost.Host) *Client {
+	return &Client{host: h}
+}
+
+// Register registers the addresses of the local peer in ns at point for ttl,
+// or DefaultTTL if ttl is 0. It returns the lifetime of the registration
+// granted by the point.
+func (c *Client) Register(ctx context.Context, point peer.ID, ns string, ttl time.Duration) (time.Duration, error) {
+	if err := checkNamespace(ns); err != nil {
+		return 0, err
+	}
+
+	addrs := c.host.Addrs()
+	if len(addrs) == 0 {
+		return 0, errors.New("no addresses to register")
+	}
+	info := &pb.Message_PeerInfo{Id: []byte(c.host.ID())}
+	for _, a := range addrs {
+		info.Addrs = append(info.Addrs, a.Bytes())
+	}
+
+	resp, err := c.request(ctx, point, &pb.Message{
+		Type: pb.Message_REGISTER,
+		Register: &pb.Message_Register{
+			Ns:   ns,
+			Peer: info,
+			Ttl:  int64(ttl / time.Second),
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.GetType() != pb.Message_REGISTER_RESPONSE {
+		return 0, fmt.Errorf("unexpected response: %s", resp.GetType())
+	}
+	r := resp.GetRegisterResponse()
+	if r.GetStatus() != pb.Message_OK {
+		return 0, &Error{Status: r.GetStatus(), Text: r.GetStatusText()}
+	}
+	return time.Duration(r.GetTtl()) * time.Second, nil
+}
+
+// Unregister removes the registration of the local peer in ns at point.
+func (c *Client) Unregister(ctx context.Context, point peer.ID, ns string) error {
+	if err := checkNamespace(ns); err != nil {
+		return err
+	}
+
+	s, err := c.host.NewStream(ctx, point, ProtocolID)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return ggio.NewDelimitedWriter(s).WriteMsg(&pb.Message{
+		Type: pb.Message_UNREGISTER,
+		Unregister: &pb.Message_Unregister{
+			Ns: ns,
+			Id: []byte(c.host.ID()),
+		},
+	})
+}
+
+// Discover returns up to limit peers registered in ns at point, or in all
+// namespaces if ns is empty. The returned cookie can be passed to a later call
+// to only get the peers registered since.
+func (c *Client) Discover(ctx context.Context, point peer.ID, ns string, limit int, cookie []byte) ([]Registration, []byte, error) {
+	if ns != "" {
+		if err := checkNamespace(ns); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	resp, err := c.request(ctx, point, &pb.Message{
+		Type: pb.Message_DISCOVER,
+		Discover: &pb.Message_Discover{
+			Ns:     ns,
+			Limit:  int64(limit),
+			Cookie: cookie,
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.GetType() != pb.Message_DISCOVER_RESPONSE {
+		return nil, nil, fmt.Errorf("unexpected response: %s", resp.GetType())
+	}
+	r := resp.GetDiscoverResponse()
+	if r.GetStatus() != pb.Message_OK {
+		return nil, nil, &Error{Status: r.GetStatus(), Text: r.GetStatusText()}
+	}
+
+	regs := make([]Registration, 0, len(r.GetRegistrations()))
+	for _, reg := range r.GetRegistrations() {
+		pi, err := peerInfo(reg.GetPeer())
+		if err != nil {
+			log.Debugf("ignoring invalid registration from %s: %s", point, err)
+			continue
+		}
+		regs = append(regs, Registration{
+			Peer:      pi,
+			Namespace: reg.GetNs(),
+			TTL:       time.Duration(reg.GetTtl()) * time.Second,
+		})
+	}
+	return regs, r.GetCookie(), nil
+}
+
+// DiscoverAll returns all the peers registered in ns at point, with as many
+// discover requests as needed.
+func (c *Client) DiscoverAll(ctx context.Context, point peer.ID, ns string) ([]Registration, error) {
+	var all []Registration
+	var cookie []byte
+	for {
+		regs, next, err := c.Discover(ctx, point, ns, MaxDiscoverLimit, cookie)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, regs...)
+		if len(regs) < MaxDiscoverLimit {
+			return all, nil
+		}
+		cookie = next
+	}
+}
+
+func (c *Client) request(ctx context.Context, point peer.ID, req *pb.Message) (*pb.Message, error) {
+	s, err := c.host.NewStream(ctx, point, ProtocolID)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Reset()
+		case <-done:
+		}
+	}()
+
+	if err := ggio.NewDelimitedWriter(s).WriteMsg(req); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	var resp pb.Message
+	if err := ggio.NewDelimitedReader(s, maxMessageSize).ReadMsg(&resp); err != nil {
+		s.Reset()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return &resp, s.Close()
+}
+
+func checkNamespace(ns string) error {
+	switch {
+	case ns == "":
+		return errors.New("empty namespace")
+	case len(ns) > MaxNamespaceLength:
+		return fmt.Errorf("namespace longer than %d bytes", MaxNamespaceLength)
+	}
+	return nil
+}
+
+func peerInfo(info *pb.Message_PeerInfo) (pstore.PeerInfo, error) {
+	id, err := peer.IDFromBytes(info.GetId())
+	if err != nil {
+		return pstore.PeerInfo{}, err
+	}
+	pi := pstore.PeerInfo{ID: id}
+	for _, b := range info.GetAddrs() {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return pstore.PeerInfo{}, err
+		}
+		pi.Addrs = append(pi.Addrs, a)
+	}
+	if len(pi.Addrs) == 0 {
+		return pstore.PeerInfo{}, errors.New("no addresses")
+	}
+	return pi, nil
+}
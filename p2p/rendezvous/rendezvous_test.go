@@ -0,0 +1,158 @@
+package rendezvous
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/rendezvous/pb"
+
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+func setupHosts(t *testing.T, ctx context.Context, n int) []p2phost.Host {
+	mn := mocknet.New(ctx)
+	var hosts []p2phost.Host
+	for i := 0; i < n; i++ {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, h)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	return hosts
+}
+
+func TestRegisterDiscover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := setupHosts(t, ctx, 4)
+	NewServer(hosts[0])
+	point := hosts[0].ID()
+
+	for _, h := range hosts[1:] {
+		ttl, err := NewClient(h).Register(ctx, point, "app", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl != DefaultTTL {
+			t.Fatalf("expected the default ttl, got %s", ttl)
+		}
+	}
+	if _, err := NewClient(hosts[1]).Register(ctx, point, "other", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(hosts[3])
+	regs, cookie, err := c.Discover(ctx, point, "app", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != 2 || regs[0].Peer.ID != hosts[1].ID() || regs[1].Peer.ID != hosts[2].ID() {
+		t.Fatalf("unexpected registrations: %v", regs)
+	}
+	if len(regs[0].Peer.Addrs) == 0 {
+		t.Fatal("expected the addresses of the registered peer")
+	}
+
+	regs, _, err = c.Discover(ctx, point, "app", 2, cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != 1 || regs[0].Peer.ID != hosts[3].ID() {
+		t.Fatalf("unexpected registrations after the cookie: %v", regs)
+	}
+
+	all, err := c.DiscoverAll(ctx, point, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("expected 4 registrations in all namespaces, got %d", len(all))
+	}
+
+	if err := NewClient(hosts[2]).Unregister(ctx, point, "app"); err != nil {
+		t.Fatal(err)
+	}
+	// unregistering has no response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		regs, err := c.DiscoverAll(ctx, point, "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(regs) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 registrations after unregistering, got %d", len(regs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := setupHosts(t, ctx, 2)
+	NewServer(hosts[0])
+	c := NewClient(hosts[1])
+
+	_, err := c.Register(ctx, hosts[0].ID(), "app", MaxTTL+time.Hour)
+	if rerr, ok := err.(*Error); !ok || rerr.Status != pb.Message_E_INVALID_TTL {
+		t.Fatalf("expected an invalid ttl error, got %v", err)
+	}
+
+	if _, _, err := c.Discover(ctx, hosts[0].ID(), "app", 0, []byte("bad")); err == nil {
+		t.Fatal("expected an invalid cookie error")
+	}
+
+	if _, err := c.Register(ctx, hosts[0].ID(), "", 0); err == nil {
+		t.Fatal("expected an empty namespace to be rejected")
+	}
+}
+
+func TestRegistrationsExpire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := setupHosts(t, ctx, 2)
+	s := NewServer(hosts[0])
+	p := hosts[1].ID()
+	info := &pb.Message_PeerInfo{Id: []byte(p)}
+	for _, a := range hosts[1].Addrs() {
+		info.Addrs = append(info.Addrs, a.Bytes())
+	}
+
+	now := time.Now()
+	resp := s.register(p, &pb.Message_Register{Ns: "app", Peer: info, Ttl: 60}, now)
+	if resp.GetStatus() != pb.Message_OK {
+		t.Fatal(resp.GetStatusText())
+	}
+
+	found := s.discover(&pb.Message_Discover{Ns: "app"}, now.Add(59*time.Second))
+	if len(found.GetRegistrations()) != 1 {
+		t.Fatal("expected the registration to be found before it expires")
+	}
+	found = s.discover(&pb.Message_Discover{Ns: "app"}, now.Add(time.Minute))
+	if len(found.GetRegistrations()) != 0 {
+		t.Fatal("expected the registration to expire")
+	}
+	if len(s.perPeer) != 0 || len(s.regs) != 0 {
+		t.Fatal("expected the expired registration to be forgotten")
+	}
+
+	resp = s.register(hosts[0].ID(), &pb.Message_Register{Ns: "app", Peer: info}, now)
+	if resp.GetStatus() != pb.Message_E_NOT_AUTHORIZED {
+		t.Fatal("expected registering another peer to be rejected")
+	}
+}
@@ -0,0 +1,243 @@
+package rendezvous
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/rendezvous/pb"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	ggio "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/io"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+// MaxRegistrationsPerPeer bounds the namespaces a single peer can be
+// registered in at a rendezvous point.
+const MaxRegistrationsPerPeer = 1000
+
+type registration struct {
+	peer   pstore.PeerInfo
+	ns     string
+	expire time.Time
+	seq    uint64
+}
+
+// Server is a rendezvous point, keeping the registrations in memory.
+type Server struct {
+	host p2phost.Host
+
+	mu      sync.Mutex
+	regs    map[string]map[peer.ID]*registration
+	perPeer map[peer.ID]int
+	seq     uint64
+}
+
+// NewServer makes h a rendezvous point.
+func NewServer(h p2phost.Host) *Server {
+	s := &Server{
+		host:    h,
+		regs:    make(map[string]map[peer.ID]*registration),
+		perPeer: make(map[peer.ID]int),
+	}
+	h.SetStreamHandler(ProtocolID, s.handleStream)
+	return s
+}
+
+// Close stops serving rendezvous requests.
+func (s *Server) Close() error {
+	s.host.RemoveStreamHandler(ProtocolID)
+	return nil
+}
+
+func (s *Server) handleStream(str inet.Stream) {
+	defer str.Close()
+
+	p := str.Conn().RemotePeer()
+	r := ggio.NewDelimitedReader(str, maxMessageSize)
+	w := ggio.NewDelimitedWriter(str)
+	for {
+		var req pb.Message
+		if err := r.ReadMsg(&req); err != nil {
+			if err != io.EOF {
+				log.Debugf("error reading request from %s: %s", p, err)
+				str.Reset()
+			}
+			return
+		}
+
+		var resp *pb.Message
+		switch req.GetType() {
+		case pb.Message_REGISTER:
+			resp = &pb.Message{
+				Type:             pb.Message_REGISTER_RESPONSE,
+				RegisterResponse: s.register(p, req.GetRegister(), time.Now()),
+			}
+		case pb.Message_UNREGISTER:
+			s.unregister(p, req.GetUnregister())
+			continue
+		case pb.Message_DISCOVER:
+			resp = &pb.Message{
+				Type:             pb.Message_DISCOVER_RESPONSE,
+				DiscoverResponse: s.discover(req.GetDiscover(), time.Now()),
+			}
+		default:
+			log.Debugf("unexpected %s request from %s", req.GetType(), p)
+			str.Reset()
+			return
+		}
+
+		if err := w.WriteMsg(resp); err != nil {
+			log.Debugf("error writing response to %s: %s", p, err)
+			str.Reset()
+			return
+		}
+	}
+}
+
+func (s *Server) register(p peer.ID, req *pb.Message_Register, now time.Time) *pb.Message_RegisterResponse {
+	reject := func(status pb.Message_ResponseStatus, text string) *pb.Message_RegisterResponse {
+		return &pb.Message_RegisterResponse{Status: status, StatusText: text}
+	}
+
+	if err := checkNamespace(req.GetNs()); err != nil {
+		return reject(pb.Message_E_INVALID_NAMESPACE, err.Error())
+	}
+	pi, err := peerInfo(req.GetPeer())
+	if err != nil {
+		return reject(pb.Message_E_INVALID_PEER_INFO, err.Error())
+	}
+	if pi.ID != p {
+		return reject(pb.Message_E_NOT_AUTHORIZED, "peers can only register themselves")
+	}
+	ttl := time.Duration(req.GetTtl()) * time.Second
+	switch {
+	case ttl == 0:
+		ttl = DefaultTTL
+	case ttl < 0 || ttl > MaxTTL:
+		return reject(pb.Message_E_INVALID_TTL, "ttl out of range")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+
+	ns := s.regs[req.GetNs()]
+	if ns == nil {
+		ns = make(map[peer.ID]*registration)
+		s.regs[req.GetNs()] = ns
+	}
+	if _, ok := ns[p]; !ok {
+		if s.perPeer[p] >= MaxRegistrationsPerPeer {
+			return reject(pb.Message_E_NOT_AUTHORIZED, "too many registrations")
+		}
+		s.perPeer[p]++
+	}
+	s.seq++
+	ns[p] = &registration{
+		peer:   pi,
+		ns:     req.GetNs(),
+		expire: now.Add(ttl),
+		seq:    s.seq,
+	}
+	return &pb.Message_RegisterResponse{Ttl: int64(ttl / time.Second)}
+}
+
+func (s *Server) unregister(p peer.ID, req *pb.Message_Unregister) {
+	if peer.ID(req.GetId()) != p {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(req.GetNs(), p)
+}
+
+func (s *Server) discover(req *pb.Message_Discover, now time.Time) *pb.Message_DiscoverResponse {
+	if req.GetNs() != "" {
+		if err := checkNamespace(req.GetNs()); err != nil {
+			return &pb.Message_DiscoverResponse{Status: pb.Message_E_INVALID_NAMESPACE, StatusText: err.Error()}
+		}
+	}
+
+	var since uint64
+	switch len(req.GetCookie()) {
+	case 0:
+	case 8:
+		since = binary.BigEndian.Uint64(req.GetCookie())
+	default:
+		return &pb.Message_DiscoverResponse{Status: pb.Message_E_INVALID_COOKIE}
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 || limit > MaxDiscoverLimit {
+		limit = MaxDiscoverLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+
+	var found []*registration
+	for ns, regs := range s.regs {
+		if req.GetNs() != "" && ns != req.GetNs() {
+			continue
+		}
+		for _, reg := range regs {
+			if reg.seq > since {
+				found = append(found, reg)
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq < found[j].seq })
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	resp := &pb.Message_DiscoverResponse{Cookie: req.GetCookie()}
+	for _, reg := range found {
+		info := &pb.Message_PeerInfo{Id: []byte(reg.peer.ID)}
+		for _, a := range reg.peer.Addrs {
+			info.Addrs = append(info.Addrs, a.Bytes())
+		}
+		resp.Registrations = append(resp.Registrations, &pb.Message_Register{
+			Ns:   reg.ns,
+			Peer: info,
+			Ttl:  int64(reg.expire.Sub(now) / time.Second),
+		})
+	}
+	if len(found) > 0 {
+		resp.Cookie = make([]byte, 8)
+		binary.BigEndian.PutUint64(resp.Cookie, found[len(found)-1].seq)
+	}
+	return resp
+}
+
+// expire removes the registrations that expired at now. s.mu must be held.
+func (s *Server) expire(now time.Time) {
+	for ns, regs := range s.regs {
+		for p, reg := range regs {
+			if !now.Before(reg.expire) {
+				s.remove(ns, p)
+			}
+		}
+	}
+}
+
+// remove removes the registration of p in ns. s.mu must be held.
+func (s *Server) remove(ns string, p peer.ID) {
+	regs := s.regs[ns]
+	if _, ok := regs[p]; !ok {
+		return
+	}
+	delete(regs, p)
+	if len(regs) == 0 {
+		delete(s.regs, ns)
+	}
+	if s.perPeer[p]--; s.perPeer[p] == 0 {
+		delete(s.perPeer, p)
+	}
+}
@@ -0,0 +1,160 @@
+package rendezvous
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+	iaddr "gx/ipfs/QmePSRaGafvmURQwQkHPDBJsaGwKXC1WpBBHVCQxdr8FPn/go-ipfs-addr"
+)
+
+// maxRefreshInterval bounds the time between two discoveries of the peers of
+// the configured namespaces.
+const maxRefreshInterval = 10 * time.Minute
+
+const connectTimeout = 30 * time.Second
+
+// Config is read from the Rendezvous config section.
+type Config struct {
+	// Points are the addresses of the rendezvous points, ending with
+	// /ipfs/<peer id>.
+	Points []string
+	// Namespaces the node registers in, and discovers and connects to the
+	// peers of, at every rendezvous point.
+	Namespaces []string
+	// TTL is the lifetime of the registrations in Namespaces, which are
+	// renewed before they expire.
+	TTL string
+	// EnableServer makes the node a rendezvous point.
+	EnableServer bool
+}
+
+// Service keeps the local peer registered in the configured namespaces and
+// connected to the other peers registered there.
+type Service struct {
+	*Client
+
+	points     []pstore.PeerInfo
+	namespaces []string
+	ttl        time.Duration
+}
+
+// NewService returns a Service for the rendezvous points and namespaces of
+// cfg. Nothing is done until Run is called.
+func NewService(h p2phost.Host, cfg Config) (*Service, error) {
+	s := &Service{
+		Client:     NewClient(h),
+		namespaces: cfg.Namespaces,
+		ttl:        DefaultTTL,
+	}
+
+	if cfg.TTL != "" {
+		ttl, err := time.ParseDuration(cfg.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TTL: %s", err)
+		}
+		if ttl < time.Second || ttl > MaxTTL {
+			return nil, fmt.Errorf("TTL must be between 1s and %s", MaxTTL)
+		}
+		s.ttl = ttl
+	}
+
+	for _, ns := range cfg.Namespaces {
+		if err := checkNamespace(ns); err != nil {
+			return nil, fmt.Errorf("invalid namespace %q: %s", ns, err)
+		}
+	}
+
+	for _, p := range cfg.Points {
+		pi, err := ParsePoint(p)
+		if err != nil {
+			return nil, err
+		}
+		s.points = append(s.points, pi)
+	}
+	return s, nil
+}
+
+// ParsePoint parses the address of a rendezvous point.
+func ParsePoint(s string) (pstore.PeerInfo, error) {
+	a, err := iaddr.ParseString(s)
+	if err != nil {
+		return pstore.PeerInfo{}, fmt.Errorf("invalid rendezvous point %q: %s", s, err)
+	}
+	pi := pstore.PeerInfo{ID: a.ID()}
+	if tpt := a.Transport(); tpt != nil {
+		pi.Addrs = append(pi.Addrs, tpt)
+	}
+	return pi, nil
+}
+
+// Points returns the configured rendezvous points.
+func (s *Service) Points() []pstore.PeerInfo {
+	return s.points
+}
+
+// TTL returns the lifetime of the registrations of the service.
+func (s *Service) TTL() time.Duration {
+	return s.ttl
+}
+
+// Run registers in the configured namespaces and connects to their peers,
+// then does it again regularly, until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	interval := s.ttl / 2
+	if interval > maxRefreshInterval {
+		interval = maxRefreshInterval
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		s.refresh(ctx)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Service) refresh(ctx context.Context) {
+	for _, point := range s.points {
+		if err := s.connect(ctx, point); err != nil {
+			log.Warningf("cannot connect to rendezvous point %s: %s", point.ID, err)
+			continue
+		}
+
+		for _, ns := range s.namespaces {
+			if _, err := s.Register(ctx, point.ID, ns, s.ttl); err != nil {
+				log.Warningf("cannot register in %s at %s: %s", ns, point.ID, err)
+			}
+
+			peers, err := s.DiscoverAll(ctx, point.ID, ns)
+			if err != nil {
+				log.Warningf("cannot discover the peers of %s at %s: %s", ns, point.ID, err)
+				continue
+			}
+			for _, reg := range peers {
+				if reg.Peer.ID == s.host.ID() {
+					continue
+				}
+				if err := s.connect(ctx, reg.Peer); err != nil {
+					log.Debugf("cannot connect to %s found in %s: %s", reg.Peer.ID, ns, err)
+				}
+			}
+		}
+	}
+}
+
+func (s *Service) connect(ctx context.Context, pi pstore.PeerInfo) error {
+	if s.host.Network().Connectedness(pi.ID) == inet.Connected {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	return s.host.Connect(ctx, pi)
+}
@@ -0,0 +1,416 @@
+// Package reputation keeps a persistent record of how the peers behaved:
+// whether they reciprocate in bitswap, answer DHT queries and keep their
+// connections up. The records are summed up in a score used to bias the
+// connection manager trimming and the bitswap provider ranking.
+package reputation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+)
+
+var log = logging.Logger("reputation")
+
+var recordsKey = ds.NewKey("/local/reputation")
+
+const (
+	// SampleInterval is how often the bitswap ledgers are sampled, the
+	// connection manager tags updated and the records saved.
+	SampleInterval = 10 * time.Second
+
+	// flapDuration is how long a connection must stay up not to count as
+	// unstable.
+	flapDuration = time.Minute
+
+	// connMgrTag tags the connected peers with their score in the
+	// connection manager, so that the worst peers are trimmed first.
+	connMgrTag = "reputation"
+
+	// the weights of the components of the score
+	bitswapWeight   = 0.4
+	dhtWeight       = 0.3
+	stabilityWeight = 0.3
+)
+
+// Config is read from the Swarm.Reputation config section.
+type Config struct {
+	Enabled bool
+}
+
+// Record is what is known about the behavior of a peer.
+type Record struct {
+	// Sent and Recv are the bytes exchanged with the peer through bitswap.
+	Sent uint64
+	Recv uint64
+
+	// DHTResponses and DHTErrors count the DHT queries the peer answered
+	// and failed to answer.
+	DHTResponses uint64
+	DHTErrors    uint64
+
+	// Connects counts the connections to the peer, and Flaps the ones that
+	// dropped within a minute.
+	Connects uint64
+	Flaps    uint64
+	// Uptime is how long the peer was connected in total.
+	Uptime time.Duration
+
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Reputation is the record of a peer and its score.
+type Reputation struct {
+	Peer peer.ID
+	Record
+
+	// Bitswap, DHT and Stability are the components of the score, between
+	// 0 and 1. A peer nothing is known about is at 0.5.
+	Bitswap   float64
+	DHT       float64
+	Stability float64
+	// Score sums up the components, between 0 and 100.
+	Score float64
+}
+
+// smoothed returns good/total, pulled towards 0.5 when there are few
+// observations.
+func smoothed(good, total uint64) float64 {
+	return (float64(good) + 1) / (float64(total) + 2)
+}
+
+// score computes the reputation of r.
+func score(p peer.ID, r Record) Reputation {
+	rep := Reputation{
+		Peer:      p,
+		Record:    r,
+		Bitswap:   smoothed(r.Recv, r.Recv+r.Sent),
+		DHT:       smoothed(r.DHTResponses, r.DHTResponses+r.DHTErrors),
+		Stability: smoothed(r.Connects-r.Flaps, r.Connects),
+	}
+	rep.Score = 100 * (bitswapWeight*rep.Bitswap + dhtWeight*rep.DHT + stabilityWeight*rep.Stability)
+	return rep
+}
+
+// LedgerFunc returns the bytes sent to and received from a peer, usually
+// from its bitswap ledger.
+type LedgerFunc func(peer.ID) (sent, recv uint64)
+
+type ledgerSample struct {
+	sent, recv uint64
+}
+
+type record struct {
+	Record
+	dirty bool
+
+	// session state, not persisted
+	connectedAt time.Time
+	ledger      *ledgerSample
+}
+
+// Service records the behavior of the peers of a host.
+type Service struct {
+	host p2phost.Host
+	d    ds.Datastore
+
+	mu      sync.Mutex
+	ledger  LedgerFunc
+	records map[peer.ID]*record
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewService returns a Service storing the records in d. Nothing is recorded
+// until Start is called.
+func NewService(h p2phost.Host, d ds.Datastore) *Service {
+	return &Service{
+		host:    h,
+		d:       d,
+		records: make(map[peer.ID]*record),
+	}
+}
+
+// SetLedger sets the function used to read the bitswap ledgers.
+func (s *Service) SetLedger(f LedgerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ledger = f
+}
+
+// Start records the behavior of the peers until Close is called.
+func (s *Service) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	s.host.Network().Notify(s)
+	for _, p := range s.host.Network().Peers() {
+		s.connected(p, time.Now())
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(SampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Sample()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops recording and saves the records.
+func (s *Service) Close() error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.host.Network().StopNotify(s)
+	s.cancel()
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func recordKey(p peer.ID) ds.Key {
+	return recordsKey.ChildString(p.Pretty())
+}
+
+// get returns the record of p, loading it from the datastore if needed. The
+// lock must be held.
+func (s *Service) get(p peer.ID) *record {
+	if r, ok := s.records[p]; ok {
+		return r
+	}
+
+	r := &record{}
+	b, err := s.d.Get(recordKey(p))
+	switch err {
+	case nil:
+		if err := json.Unmarshal(b, &r.Record); err != nil {
+			log.Warningf("corrupt reputation record of %s: %s", p, err)
+		}
+	case ds.ErrNotFound:
+	default:
+		log.Warningf("cannot load the reputation record of %s: %s", p, err)
+	}
+	s.records[p] = r
+	return r
+}
+
+// save writes the records changed since the last save, and forgets the ones
+// of the disconnected peers. The lock must be held.
+func (s *Service) save() error {
+	var firstErr error
+	for p, r := range s.records {
+		if r.dirty {
+			b, err := json.Marshal(r.Record)
+			if err == nil {
+				err = s.d.Put(recordKey(p), b)
+			}
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			r.dirty = false
+		}
+		if r.connectedAt.IsZero() {
+			delete(s.records, p)
+		}
+	}
+	return firstErr
+}
+
+// Sample adds the bytes exchanged since the last sample to the records of
+// the connected peers, updates their tags in the connection manager and saves
+// the records that changed.
+func (s *Service) Sample() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p, r := range s.records {
+		if r.connectedAt.IsZero() {
+			continue
+		}
+
+		s.sampleLedger(p, r)
+		s.host.ConnManager().TagPeer(p, connMgrTag, int(score(p, r.Record).Score))
+	}
+
+	if err := s.save(); err != nil {
+		log.Warningf("cannot save the reputation records: %s", err)
+	}
+}
+
+// sampleLedger adds the bytes exchanged with p since the last sample to its
+// record. The lock must be held.
+func (s *Service) sampleLedger(p peer.ID, r *record) {
+	if s.ledger == nil {
+		return
+	}
+	sent, recv := s.ledger(p)
+	if l := r.ledger; l != nil && sent >= l.sent && recv >= l.recv {
+		if sent != l.sent || recv != l.recv {
+			r.Sent += sent - l.sent
+			r.Recv += recv - l.recv
+			r.dirty = true
+		}
+	}
+	r.ledger = &ledgerSample{sent: sent, recv: recv}
+}
+
+// Get returns the reputation of p.
+func (s *Service) Get(p peer.ID) Reputation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return score(p, s.get(p).Record)
+}
+
+// List returns the reputation of all the peers with a record, best first.
+func (s *Service) List() ([]Reputation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	res, err := s.d.Query(dsq.Query{Prefix: recordsKey.String()})
+	if err != nil {
+		return nil, err
+	}
+	all, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Reputation, 0, len(all))
+	for _, e := range all {
+		p, err := peer.IDB58Decode(ds.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			log.Warningf("invalid reputation record key %s", e.Key)
+			continue
+		}
+		if r, ok := s.records[p]; ok {
+			out = append(out, score(p, r.Record))
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(e.Value, &r); err != nil {
+			return nil, fmt.Errorf("corrupt reputation record of %s: %s", p, err)
+		}
+		out = append(out, score(p, r))
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Peer < out[j].Peer
+	})
+	return out, nil
+}
+
+// Bias returns a factor between 0 and 2 favoring the peers with a good
+// reputation, 1 for a peer nothing is known about.
+func (s *Service) Bias(p peer.ID) float64 {
+	return s.Get(p).Score / 50
+}
+
+// dhtQuery records the outcome of a DHT query sent to p.
+func (s *Service) dhtQuery(p peer.ID, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.get(p)
+	if ok {
+		r.DHTResponses++
+	} else {
+		r.DHTErrors++
+	}
+	r.dirty = true
+}
+
+func (s *Service) connected(p peer.ID, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.get(p)
+	if !r.connectedAt.IsZero() {
+		return
+	}
+	r.connectedAt = now
+	r.Connects++
+	// the ledgers are kept while the daemon runs, the bytes exchanged
+	// before the connection are already recorded
+	if s.ledger != nil {
+		sent, recv := s.ledger(p)
+		r.ledger = &ledgerSample{sent: sent, recv: recv}
+	}
+	if r.FirstSeen.IsZero() {
+		r.FirstSeen = now
+	}
+	r.LastSeen = now
+	r.dirty = true
+}
+
+func (s *Service) disconnected(p peer.ID, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.get(p)
+	if r.connectedAt.IsZero() {
+		return
+	}
+	s.sampleLedger(p, r)
+	up := now.Sub(r.connectedAt)
+	r.Uptime += up
+	if up < flapDuration {
+		r.Flaps++
+	}
+	r.connectedAt = time.Time{}
+	r.ledger = nil
+	r.LastSeen = now
+	r.dirty = true
+}
+
+// Connected records a new connection to a peer. It is part of the
+// inet.Notifiee interface.
+func (s *Service) Connected(n inet.Network, c inet.Conn) {
+	s.connected(c.RemotePeer(), time.Now())
+}
+
+// Disconnected records the end of the connection to a peer, once its last
+// connection is closed. It is part of the inet.Notifiee interface.
+func (s *Service) Disconnected(n inet.Network, c inet.Conn) {
+	if n.Connectedness(c.RemotePeer()) == inet.Connected {
+		return
+	}
+	s.disconnected(c.RemotePeer(), time.Now())
+}
+
+func (s *Service) Listen(inet.Network, ma.Multiaddr)      {}
+func (s *Service) ListenClose(inet.Network, ma.Multiaddr) {}
+func (s *Service) OpenedStream(inet.Network, inet.Stream) {}
+func (s *Service) ClosedStream(inet.Network, inet.Stream) {}
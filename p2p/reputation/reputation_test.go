@@ -0,0 +1,82 @@
+package reputation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+)
+
+func TestScore(t *testing.T) {
+	if s := score("a", Record{}).Score; s != 50 {
+		t.Fatalf("expected an unknown peer to score 50, got %f", s)
+	}
+
+	good := score("a", Record{Recv: 1 << 20, DHTResponses: 10, Connects: 3})
+	bad := score("b", Record{Sent: 1 << 20, DHTErrors: 10, Connects: 3, Flaps: 3})
+	if good.Score <= 50 || bad.Score >= 50 {
+		t.Fatalf("unexpected scores: good %f, bad %f", good.Score, bad.Score)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	h1, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := dsync.MutexWrap(ds.NewMapDatastore())
+	s := NewService(h1, d)
+	var sent, recv uint64
+	s.SetLedger(func(peer.ID) (uint64, uint64) { return sent, recv })
+	s.Start()
+
+	if _, err := mn.ConnectPeers(h1.ID(), h2.ID()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && s.Get(h2.ID()).Connects == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	recv = 1000
+	s.Sample()
+	s.dhtQuery(h2.ID(), true)
+
+	if err := h1.Network().ClosePeer(h2.ID()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && s.Get(h2.ID()).Flaps == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records are kept across restarts
+	reps, err := NewService(h1, d).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reps) != 1 {
+		t.Fatalf("expected a record, got %v", reps)
+	}
+	r := reps[0]
+	if r.Peer != h2.ID() || r.Recv != 1000 || r.DHTResponses != 1 || r.Connects != 1 || r.Flaps != 1 {
+		t.Fatalf("unexpected record: %+v", r)
+	}
+}
@@ -0,0 +1,67 @@
+package reputation
+
+import (
+	"context"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	notif "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/notifications"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+// Routing wraps content routing to record which peers answer the DHT
+// queries sent while looking for providers.
+//
+// It should only wrap the lookups of internal services, like bitswap: the
+// query events of a context can only be registered once, the ones of the
+// 'ipfs dht' commands would be lost.
+type Routing struct {
+	routing.ContentRouting
+	s *Service
+}
+
+// Routing returns a Routing recording the queries of r.
+func (s *Service) Routing(r routing.ContentRouting) *Routing {
+	return &Routing{ContentRouting: r, s: s}
+}
+
+// FindProvidersAsync looks for providers, recording the outcome of the DHT
+// queries until the lookup is over.
+func (r *Routing) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	ctx, cancel := context.WithCancel(ctx)
+	events := make(chan *notif.QueryEvent)
+	ctx = notif.RegisterForQueryEvents(ctx, events)
+
+	in := r.ContentRouting.FindProvidersAsync(ctx, c, count)
+	out := make(chan pstore.PeerInfo)
+
+	go func() {
+		for {
+			select {
+			case ev := <-events:
+				switch ev.Type {
+				case notif.PeerResponse:
+					r.s.dhtQuery(ev.ID, true)
+				case notif.QueryError:
+					r.s.dhtQuery(ev.ID, false)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		defer close(out)
+		for pi := range in {
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
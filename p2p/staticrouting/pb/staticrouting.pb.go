@@ -0,0 +1,117 @@
+// source: p2p/staticrouting/pb/staticrouting.proto
+
+package pb
+
+import proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Message_MessageType int32
+
+const (
+	Message_PUT_VALUE     Message_MessageType = 0
+	Message_GET_VALUE     Message_MessageType = 1
+	Message_ADD_PROVIDER  Message_MessageType = 2
+	Message_GET_PROVIDERS Message_MessageType = 3
+	Message_FIND_PEER     Message_MessageType = 4
+)
+
+var Message_MessageType_name = map[int32]string{
+	0: "PUT_VALUE",
+	1: "GET_VALUE",
+	2: "ADD_PROVIDER",
+	3: "GET_PROVIDERS",
+	4: "FIND_PEER",
+}
+var Message_MessageType_value = map[string]int32{
+	"PUT_VALUE":     0,
+	"GET_VALUE":     1,
+	"ADD_PROVIDER":  2,
+	"GET_PROVIDERS": 3,
+	"FIND_PEER":     4,
+}
+
+func (x Message_MessageType) String() string {
+	return proto.EnumName(Message_MessageType_name, int32(x))
+}
+
+type Message struct {
+	Type  Message_MessageType `protobuf:"varint,1,opt,name=type,proto3,enum=staticrouting.pb.Message_MessageType" json:"type,omitempty"`
+	Key   []byte              `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value []byte              `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Peers []*Message_Peer     `protobuf:"bytes,4,rep,name=peers" json:"peers,omitempty"`
+	Error string              `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+func (m *Message) GetType() Message_MessageType {
+	if m != nil {
+		return m.Type
+	}
+	return Message_PUT_VALUE
+}
+
+func (m *Message) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Message) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Message) GetPeers() []*Message_Peer {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+func (m *Message) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type Message_Peer struct {
+	Id    []byte   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Addrs [][]byte `protobuf:"bytes,2,rep,name=addrs" json:"addrs,omitempty"`
+}
+
+func (m *Message_Peer) Reset()         { *m = Message_Peer{} }
+func (m *Message_Peer) String() string { return proto.CompactTextString(m) }
+func (*Message_Peer) ProtoMessage()    {}
+
+func (m *Message_Peer) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Message_Peer) GetAddrs() [][]byte {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Message)(nil), "staticrouting.pb.Message")
+	proto.RegisterType((*Message_Peer)(nil), "staticrouting.pb.Message.Peer")
+	proto.RegisterEnum("staticrouting.pb.Message_MessageType", Message_MessageType_name, Message_MessageType_value)
+}
@@ -0,0 +1,583 @@
+// Package staticrouting routes through a configured set of peers only, for
+// private deployments without a DHT or public bootstrap peers. The records
+// are published to all the static peers, and the lookups ask all of them:
+// every static peer is one hop away, so no peer is discovered through them.
+package staticrouting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/staticrouting/pb"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	peer "gx/ipfs/QmQsErDt8Qgw1XrsXf2BpEzDgGWtB1YLsTAARBup5b6B9W/go-libp2p-peer"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	inet "gx/ipfs/QmZNJyx9GGCX4GeuHnLB8fxaxMLs4MjTjHokxfQcCd6Nve/go-libp2p-net"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	record "gx/ipfs/QmdHb9aBELnQKTVhvvA3hsQbRgUAwsWUzBP2vZ6Y5FBYvE/go-libp2p-record"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	ropts "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/options"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+	ggio "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/io"
+	p2phost "gx/ipfs/QmeMYW7Nj8jnnEfs9qhm7SxKkoDPUWXu3MsxX6BFwz34tf/go-libp2p-host"
+	iaddr "gx/ipfs/QmePSRaGafvmURQwQkHPDBJsaGwKXC1WpBBHVCQxdr8FPn/go-ipfs-addr"
+)
+
+var log = logging.Logger("staticrouting")
+
+// ProtocolID is the protocol spoken with the static peers.
+const ProtocolID = protocol.ID("/ipfs/staticrouting/1.0.0")
+
+const (
+	// ProviderTTL is how long the provider records are kept, they are
+	// provided again by the reprovider well before.
+	ProviderTTL = 24 * time.Hour
+
+	// connMgrTag tags the static peers in the connection manager, the node
+	// can't route without them.
+	connMgrTag   = "static-routing"
+	connMgrValue = 100
+
+	requestTimeout = 30 * time.Second
+	maxMessageSize = 1 << 20
+)
+
+var valuesPrefix = ds.NewKey("/local/staticrouting/values")
+
+// Config is read from the Routing.Static config section.
+type Config struct {
+	// Peers are the addresses of the static peers, ending with
+	// /ipfs/<peer id>.
+	Peers []string
+}
+
+// ParsePeer parses the address of a static peer.
+func ParsePeer(s string) (pstore.PeerInfo, error) {
+	a, err := iaddr.ParseString(s)
+	if err != nil {
+		return pstore.PeerInfo{}, fmt.Errorf("invalid static peer %q: %s", s, err)
+	}
+	pi := pstore.PeerInfo{ID: a.ID()}
+	if len(a.Transport().Bytes()) > 0 {
+		pi.Addrs = []ma.Multiaddr{a.Transport()}
+	}
+	return pi, nil
+}
+
+// ParseConfig returns the static peers of cfg.
+func ParseConfig(cfg Config) ([]pstore.PeerInfo, error) {
+	pis := make([]pstore.PeerInfo, 0, len(cfg.Peers))
+	for _, s := range cfg.Peers {
+		pi, err := ParsePeer(s)
+		if err != nil {
+			return nil, err
+		}
+		pis = append(pis, pi)
+	}
+	return pis, nil
+}
+
+// Router is a routing.IpfsRouting asking the static peers. It answers the
+// requests of the other peers from its own records.
+type Router struct {
+	host      p2phost.Host
+	dstore    ds.Datastore
+	validator record.Validator
+
+	mu        sync.Mutex
+	peers     map[peer.ID]struct{}
+	providers map[string]map[peer.ID]time.Time
+}
+
+var _ routing.IpfsRouting = (*Router)(nil)
+
+// New returns a Router using the static peers, serving requests on h until
+// it is closed. The values are stored in d.
+func New(h p2phost.Host, d ds.Datastore, validator record.Validator, peers []pstore.PeerInfo) *Router {
+	r := &Router{
+		host:      h,
+		dstore:    d,
+		validator: validator,
+		peers:     make(map[peer.ID]struct{}),
+		providers: make(map[string]map[peer.ID]time.Time),
+	}
+	for _, pi := range peers {
+		r.AddPeer(pi)
+	}
+	h.SetStreamHandler(ProtocolID, r.handleStream)
+	return r
+}
+
+// Close stops serving the requests of the other peers.
+func (r *Router) Close() error {
+	r.host.RemoveStreamHandler(ProtocolID)
+	return nil
+}
+
+// AddPeer adds a static peer, or the addresses of a static peer already
+// added.
+func (r *Router) AddPeer(pi pstore.PeerInfo) {
+	if pi.ID == r.host.ID() {
+		return
+	}
+	r.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.PermanentAddrTTL)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers[pi.ID] = struct{}{}
+	r.host.ConnManager().TagPeer(pi.ID, connMgrTag, connMgrValue)
+}
+
+// RemovePeer removes a static peer, returning false if it wasn't added. The
+// connection to it is left open.
+func (r *Router) RemovePeer(id peer.ID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.peers[id]; !ok {
+		return false
+	}
+	delete(r.peers, id)
+	r.host.ConnManager().UntagPeer(id, connMgrTag)
+	r.host.Peerstore().UpdateAddrs(id, pstore.PermanentAddrTTL, pstore.TempAddrTTL)
+	return true
+}
+
+// Peers returns the static peers with their known addresses, sorted by ID.
+func (r *Router) Peers() []pstore.PeerInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pis := make([]pstore.PeerInfo, 0, len(r.peers))
+	for id := range r.peers {
+		pis = append(pis, r.host.Peerstore().PeerInfo(id))
+	}
+	sort.Slice(pis, func(i, j int) bool { return pis[i].ID < pis[j].ID })
+	return pis
+}
+
+// Bootstrap connects to the static peers which aren't connected.
+func (r *Router) Bootstrap(ctx context.Context) error {
+	for _, pi := range r.Peers() {
+		if r.host.Network().Connectedness(pi.ID) == inet.Connected {
+			continue
+		}
+		go func(pi pstore.PeerInfo) {
+			ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+			defer cancel()
+			if err := r.host.Connect(ctx, pi); err != nil {
+				log.Debugf("cannot connect to the static peer %s: %s", pi.ID, err)
+			}
+		}(pi)
+	}
+	return nil
+}
+
+// request sends req to the static peer p, returning its response.
+func (r *Router) request(ctx context.Context, p peer.ID, req *pb.Message) (*pb.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	s, err := r.host.NewStream(ctx, p, ProtocolID)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Reset()
+		case <-done:
+		}
+	}()
+
+	if err := ggio.NewDelimitedWriter(s).WriteMsg(req); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	var resp pb.Message
+	if err := ggio.NewDelimitedReader(s, maxMessageSize).ReadMsg(&resp); err != nil {
+		s.Reset()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if resp.Error != "" {
+		s.Close()
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, s.Close()
+}
+
+// requestAll sends req to all the static peers at once, calling handle with
+// the responses as they come.
+func (r *Router) requestAll(ctx context.Context, req *pb.Message, handle func(peer.ID, *pb.Message)) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs int
+	for _, pi := range r.Peers() {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			resp, err := r.request(ctx, p, req)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Debugf("%s request to %s failed: %s", req.Type, p, err)
+				errs++
+				return
+			}
+			handle(p, resp)
+		}(pi.ID)
+	}
+	wg.Wait()
+	return errs
+}
+
+// PutValue stores the value locally and on all the static peers. It fails if
+// none of them stored it.
+func (r *Router) PutValue(ctx context.Context, key string, value []byte, opts ...ropts.Option) error {
+	if err := r.putLocal(key, value); err != nil {
+		return err
+	}
+
+	var cfg ropts.Options
+	if err := cfg.Apply(opts...); err != nil {
+		return err
+	}
+	if cfg.Offline {
+		return nil
+	}
+
+	n := len(r.Peers())
+	errs := r.requestAll(ctx, &pb.Message{
+		Type:  pb.Message_PUT_VALUE,
+		Key:   []byte(key),
+		Value: value,
+	}, func(peer.ID, *pb.Message) {})
+	if n > 0 && errs == n {
+		return fmt.Errorf("no static peer stored the value of %s", key)
+	}
+	return nil
+}
+
+// GetValue returns the best value among the local one and the ones of the
+// static peers.
+func (r *Router) GetValue(ctx context.Context, key string, opts ...ropts.Option) ([]byte, error) {
+	var cfg ropts.Options
+	if err := cfg.Apply(opts...); err != nil {
+		return nil, err
+	}
+
+	var values [][]byte
+	if v, err := r.getLocal(key); err == nil {
+		values = append(values, v)
+	} else if err != ds.ErrNotFound {
+		return nil, err
+	}
+
+	if !cfg.Offline {
+		r.requestAll(ctx, &pb.Message{
+			Type: pb.Message_GET_VALUE,
+			Key:  []byte(key),
+		}, func(p peer.ID, resp *pb.Message) {
+			if len(resp.Value) == 0 {
+				return
+			}
+			if err := r.validator.Validate(key, resp.Value); err != nil {
+				log.Debugf("invalid value of %s from %s: %s", key, p, err)
+				return
+			}
+			values = append(values, resp.Value)
+		})
+	}
+
+	if len(values) == 0 {
+		return nil, routing.ErrNotFound
+	}
+	i, err := r.validator.Select(key, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the best value, as the static peers do
+	if err := r.putLocal(key, values[i]); err != nil {
+		log.Debugf("cannot store the value of %s: %s", key, err)
+	}
+	return values[i], nil
+}
+
+func valueKey(key string) ds.Key {
+	return valuesPrefix.ChildString(fmt.Sprintf("%x", key))
+}
+
+func (r *Router) getLocal(key string) ([]byte, error) {
+	v, err := r.dstore.Get(valueKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if err := r.validator.Validate(key, v); err != nil {
+		// an expired record
+		return nil, ds.ErrNotFound
+	}
+	return v, nil
+}
+
+// putLocal stores value if it is valid and better than the one stored.
+func (r *Router) putLocal(key string, value []byte) error {
+	if err := r.validator.Validate(key, value); err != nil {
+		return err
+	}
+
+	old, err := r.getLocal(key)
+	switch err {
+	case nil:
+		i, err := r.validator.Select(key, [][]byte{old, value})
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			return nil
+		}
+	case ds.ErrNotFound:
+	default:
+		return err
+	}
+	return r.dstore.Put(valueKey(key), value)
+}
+
+// Provide records the node as a provider of c, on all the static peers when
+// announced.
+func (r *Router) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	self := r.host.ID()
+	r.addProvider(c, self, time.Now())
+	if !announce {
+		return nil
+	}
+
+	r.requestAll(ctx, &pb.Message{
+		Type:  pb.Message_ADD_PROVIDER,
+		Key:   c.Bytes(),
+		Peers: []*pb.Message_Peer{peerToPb(r.host.Peerstore().PeerInfo(self))},
+	}, func(peer.ID, *pb.Message) {})
+	return nil
+}
+
+// FindProvidersAsync returns the providers of c known locally and to the
+// static peers, up to count if it isn't zero.
+func (r *Router) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan pstore.PeerInfo {
+	out := make(chan pstore.PeerInfo)
+	go func() {
+		defer close(out)
+
+		seen := make(map[peer.ID]struct{})
+		send := func(pi pstore.PeerInfo) bool {
+			if _, ok := seen[pi.ID]; ok {
+				return true
+			}
+			seen[pi.ID] = struct{}{}
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return false
+			}
+			return count <= 0 || len(seen) < count
+		}
+
+		for _, p := range r.getProviders(c, time.Now()) {
+			if !send(r.host.Peerstore().PeerInfo(p)) {
+				return
+			}
+		}
+
+		var found []pstore.PeerInfo
+		r.requestAll(ctx, &pb.Message{
+			Type: pb.Message_GET_PROVIDERS,
+			Key:  c.Bytes(),
+		}, func(p peer.ID, resp *pb.Message) {
+			for _, pp := range resp.Peers {
+				pi, err := peerFromPb(pp)
+				if err != nil {
+					log.Debugf("invalid provider from %s: %s", p, err)
+					continue
+				}
+				found = append(found, pi)
+			}
+		})
+		for _, pi := range found {
+			if pi.ID != r.host.ID() {
+				r.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.TempAddrTTL)
+			}
+			if !send(pi) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (r *Router) addProvider(c cid.Cid, p peer.ID, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := c.KeyString()
+	provs, ok := r.providers[k]
+	if !ok {
+		provs = make(map[peer.ID]time.Time)
+		r.providers[k] = provs
+	}
+	provs[p] = now.Add(ProviderTTL)
+}
+
+// getProviders returns the providers of c, dropping the expired records.
+func (r *Router) getProviders(c cid.Cid, now time.Time) []peer.ID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := c.KeyString()
+	var out []peer.ID
+	for p, expires := range r.providers[k] {
+		if now.After(expires) {
+			delete(r.providers[k], p)
+			continue
+		}
+		out = append(out, p)
+	}
+	if len(r.providers[k]) == 0 {
+		delete(r.providers, k)
+	}
+	return out
+}
+
+// FindPeer returns the addresses of p from the peerstore, or from the static
+// peers if there are none.
+func (r *Router) FindPeer(ctx context.Context, p peer.ID) (pstore.PeerInfo, error) {
+	if pi := r.host.Peerstore().PeerInfo(p); len(pi.Addrs) > 0 {
+		return pi, nil
+	}
+
+	var addrs []ma.Multiaddr
+	r.requestAll(ctx, &pb.Message{
+		Type: pb.Message_FIND_PEER,
+		Key:  []byte(p),
+	}, func(from peer.ID, resp *pb.Message) {
+		for _, pp := range resp.Peers {
+			pi, err := peerFromPb(pp)
+			if err != nil || pi.ID != p {
+				continue
+			}
+			addrs = append(addrs, pi.Addrs...)
+		}
+	})
+	if len(addrs) == 0 {
+		return pstore.PeerInfo{}, routing.ErrNotFound
+	}
+	r.host.Peerstore().AddAddrs(p, addrs, pstore.TempAddrTTL)
+	return r.host.Peerstore().PeerInfo(p), nil
+}
+
+func (r *Router) handleStream(s inet.Stream) {
+	defer s.Close()
+
+	var req pb.Message
+	if err := ggio.NewDelimitedReader(s, maxMessageSize).ReadMsg(&req); err != nil {
+		s.Reset()
+		return
+	}
+	resp, err := r.handle(s.Conn().RemotePeer(), &req)
+	if err != nil {
+		resp = &pb.Message{Type: req.Type, Error: err.Error()}
+	}
+	if err := ggio.NewDelimitedWriter(s).WriteMsg(resp); err != nil {
+		s.Reset()
+	}
+}
+
+// handle answers a request of the peer from.
+func (r *Router) handle(from peer.ID, req *pb.Message) (*pb.Message, error) {
+	resp := &pb.Message{Type: req.Type, Key: req.Key}
+	switch req.Type {
+	case pb.Message_PUT_VALUE:
+		if err := r.putLocal(string(req.Key), req.Value); err != nil {
+			return nil, err
+		}
+
+	case pb.Message_GET_VALUE:
+		v, err := r.getLocal(string(req.Key))
+		if err != nil && err != ds.ErrNotFound {
+			return nil, err
+		}
+		resp.Value = v
+
+	case pb.Message_ADD_PROVIDER:
+		c, err := cid.Cast(req.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, pp := range req.Peers {
+			pi, err := peerFromPb(pp)
+			// peers only provide for themselves
+			if err != nil || pi.ID != from {
+				continue
+			}
+			r.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, ProviderTTL)
+			r.addProvider(c, pi.ID, time.Now())
+		}
+
+	case pb.Message_GET_PROVIDERS:
+		c, err := cid.Cast(req.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range r.getProviders(c, time.Now()) {
+			resp.Peers = append(resp.Peers, peerToPb(r.host.Peerstore().PeerInfo(p)))
+		}
+
+	case pb.Message_FIND_PEER:
+		p, err := peer.IDFromBytes(req.Key)
+		if err != nil {
+			return nil, err
+		}
+		if pi := r.host.Peerstore().PeerInfo(p); len(pi.Addrs) > 0 {
+			resp.Peers = []*pb.Message_Peer{peerToPb(pi)}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown request type %d", req.Type)
+	}
+	return resp, nil
+}
+
+func peerToPb(pi pstore.PeerInfo) *pb.Message_Peer {
+	pp := &pb.Message_Peer{Id: []byte(pi.ID)}
+	for _, a := range pi.Addrs {
+		pp.Addrs = append(pp.Addrs, a.Bytes())
+	}
+	return pp
+}
+
+func peerFromPb(pp *pb.Message_Peer) (pstore.PeerInfo, error) {
+	id, err := peer.IDFromBytes(pp.Id)
+	if err != nil {
+		return pstore.PeerInfo{}, err
+	}
+	pi := pstore.PeerInfo{ID: id}
+	for _, b := range pp.Addrs {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			continue
+		}
+		pi.Addrs = append(pi.Addrs, a)
+	}
+	return pi, nil
+}
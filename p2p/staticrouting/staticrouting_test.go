@@ -0,0 +1,174 @@
+package staticrouting
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/p2p/staticrouting/pb"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	mocknet "gx/ipfs/QmUEqyXr97aUbNmQADHYNknjwjjdVpJXEt1UZXmSG81EV4/go-libp2p/p2p/net/mock"
+	routing "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing"
+	ropts "gx/ipfs/QmdKS5YtmuSWKuLLgbHG176mS3VX3AKiyVmaaiAfvgcuch/go-libp2p-routing/options"
+	pstore "gx/ipfs/Qmda4cPRvSRyox3SqgJN6DfSZGU5TtHufPTp9uXjFj71X6/go-libp2p-peerstore"
+)
+
+// testValidator accepts the values starting with "v", the greatest one being
+// the best.
+type testValidator struct{}
+
+func (testValidator) Validate(key string, value []byte) error {
+	if !bytes.HasPrefix(value, []byte("v")) {
+		return errors.New("invalid value")
+	}
+	return nil
+}
+
+func (testValidator) Select(key string, values [][]byte) (int, error) {
+	best := 0
+	for i, v := range values {
+		if bytes.Compare(v, values[best]) > 0 {
+			best = i
+		}
+	}
+	return best, nil
+}
+
+func TestParseConfig(t *testing.T) {
+	pis, err := ParseConfig(Config{Peers: []string{
+		"/ip4/10.0.0.2/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pis) != 1 || pis[0].ID.Pretty() != "QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ" || len(pis[0].Addrs) != 1 {
+		t.Fatalf("unexpected peers: %v", pis)
+	}
+	if pis[0].Addrs[0].String() != "/ip4/10.0.0.2/tcp/4001" {
+		t.Fatalf("unexpected address: %s", pis[0].Addrs[0])
+	}
+
+	if _, err := ParseConfig(Config{Peers: []string{"/ip4/10.0.0.2/tcp/4001"}}); err == nil {
+		t.Fatal("expected an error for an address without a peer ID")
+	}
+}
+
+func TestStaticRouting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+
+	// a and b route through s
+	server := pstore.PeerInfo{ID: hosts[0].ID(), Addrs: hosts[0].Addrs()}
+	routers := make([]*Router, len(hosts))
+	for i, h := range hosts {
+		var peers []pstore.PeerInfo
+		if i > 0 {
+			peers = []pstore.PeerInfo{server}
+		}
+		routers[i] = New(h, dssync.MutexWrap(ds.NewMapDatastore()), testValidator{}, peers)
+		defer routers[i].Close()
+	}
+	s, a, b := routers[0], routers[1], routers[2]
+
+	if len(s.Peers()) != 0 {
+		t.Fatalf("expected no static peer, got %v", s.Peers())
+	}
+	if pis := a.Peers(); len(pis) != 1 || pis[0].ID != server.ID {
+		t.Fatalf("unexpected static peers: %v", pis)
+	}
+
+	// providers
+	c := cid.NewCidV0([]byte("\x12\x20aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	if err := a.Provide(ctx, c, true); err != nil {
+		t.Fatal(err)
+	}
+	var found []pstore.PeerInfo
+	for pi := range b.FindProvidersAsync(ctx, c, 0) {
+		found = append(found, pi)
+	}
+	if len(found) != 1 || found[0].ID != hosts[1].ID() {
+		t.Fatalf("expected a as the provider, got %v", found)
+	}
+
+	// values
+	if err := a.PutValue(ctx, "/test/k", []byte("invalid")); err == nil {
+		t.Fatal("expected an invalid value to be rejected")
+	}
+	if err := a.PutValue(ctx, "/test/k", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PutValue(ctx, "/test/k", []byte("v0"), ropts.Offline); err != nil {
+		t.Fatal(err)
+	}
+	v, err := b.GetValue(ctx, "/test/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "v1" {
+		t.Fatalf("expected the best value, got %q", v)
+	}
+	if _, err := b.GetValue(ctx, "/test/missing"); err != routing.ErrNotFound {
+		t.Fatalf("expected the value not to be found, got %v", err)
+	}
+
+	// without static peers, nothing is looked up
+	if !b.RemovePeer(server.ID) {
+		t.Fatal("expected the static peer to be removed")
+	}
+	if b.RemovePeer(server.ID) {
+		t.Fatal("expected the static peer to be removed already")
+	}
+	if _, err := b.GetValue(ctx, "/test/other"); err != routing.ErrNotFound {
+		t.Fatalf("expected the value not to be found, got %v", err)
+	}
+}
+
+func TestProvidersOnlyForThemselves(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	r := New(hosts[0], dssync.MutexWrap(ds.NewMapDatastore()), testValidator{}, nil)
+	defer r.Close()
+
+	c := cid.NewCidV0([]byte("\x12\x20bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"))
+	req := &pb.Message{
+		Type:  pb.Message_ADD_PROVIDER,
+		Key:   c.Bytes(),
+		Peers: []*pb.Message_Peer{{Id: []byte(hosts[0].ID())}},
+	}
+	if _, err := r.handle(hosts[1].ID(), req); err != nil {
+		t.Fatal(err)
+	}
+	if provs := r.getProviders(c, time.Now()); len(provs) != 0 {
+		t.Fatalf("expected no provider, got %v", provs)
+	}
+
+	req.Peers = []*pb.Message_Peer{{Id: []byte(hosts[1].ID())}}
+	if _, err := r.handle(hosts[1].ID(), req); err != nil {
+		t.Fatal(err)
+	}
+	if provs := r.getProviders(c, time.Now()); len(provs) != 1 || provs[0] != hosts[1].ID() {
+		t.Fatalf("expected the sender as provider, got %v", provs)
+	}
+
+	// the records expire
+	if provs := r.getProviders(c, time.Now().Add(ProviderTTL+time.Minute)); len(provs) != 0 {
+		t.Fatalf("expected the record to expire, got %v", provs)
+	}
+}
@@ -0,0 +1,279 @@
+// Package wss serves the websocket transport of the swarm over TLS, for
+// browsers to dial /wss addresses. The websocket transport doesn't support
+// TLS itself: each /wss address is a TLS listener forwarding its connections
+// to a websocket listener of the swarm on the loopback interface, so these
+// connections appear to come from the loopback address.
+package wss
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+	// registers the dns4 protocol of the announced addresses
+	_ "gx/ipfs/QmfXU2MhWoegxHoeMd3A2ytL2P6CY4FfqGWc23LTNWBwZt/go-multiaddr-dns"
+)
+
+var log = logging.Logger("wss")
+
+// P_WSS is the code of the wss multiaddr protocol.
+const P_WSS = 0x01DE
+
+// WssProtocol is the wss multiaddr protocol, registered unless the multiaddr
+// package knows it already.
+var WssProtocol = ma.Protocol{
+	Code:  P_WSS,
+	Name:  "wss",
+	VCode: ma.CodeToVarint(P_WSS),
+}
+
+// InternalListenAddr is the websocket address the swarm listens on for the
+// connections to be forwarded to.
+const InternalListenAddr = "/ip4/127.0.0.1/tcp/0/ws"
+
+func init() {
+	if ma.ProtocolWithCode(P_WSS).Code == 0 {
+		if err := ma.AddProtocol(WssProtocol); err != nil {
+			panic(fmt.Errorf("error registering wss protocol: %s", err))
+		}
+	}
+}
+
+// IsWssAddr returns whether a is a /wss address.
+func IsWssAddr(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(P_WSS)
+	return err == nil
+}
+
+// SplitAddrs returns the /wss addresses of addrs apart from the others.
+func SplitAddrs(addrs []ma.Multiaddr) (others, wss []ma.Multiaddr) {
+	for _, a := range addrs {
+		if IsWssAddr(a) {
+			wss = append(wss, a)
+		} else {
+			others = append(others, a)
+		}
+	}
+	return others, wss
+}
+
+// Certificates provides the certificate of the TLS listeners. It is closed
+// with the Front if it is an io.Closer.
+type Certificates interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+type staticCertificate tls.Certificate
+
+func (c *staticCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return (*tls.Certificate)(c), nil
+}
+
+// LoadCertificate returns the certificate of a PEM certificate chain file
+// and key file.
+func LoadCertificate(certFile, keyFile string) (Certificates, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return (*staticCertificate)(&cert), nil
+}
+
+// Front is the set of TLS listeners of the /wss addresses.
+type Front struct {
+	certs   Certificates
+	domains []string
+
+	mu        sync.Mutex
+	target    net.Addr
+	listeners []net.Listener
+	addrs     []ma.Multiaddr
+	internal  ma.Multiaddr
+	wg        sync.WaitGroup
+}
+
+// New returns a Front serving certs. When domains are given, the /wss
+// addresses are announced with them rather than with the IPs listened on,
+// for browsers to check the certificate.
+func New(certs Certificates, domains []string) *Front {
+	return &Front{certs: certs, domains: domains}
+}
+
+// Listen listens on the /wss addresses, forwarding the connections to
+// internal, the websocket address the swarm listens on.
+func (f *Front) Listen(addrs []ma.Multiaddr, internal ma.Multiaddr) error {
+	ws, err := ma.NewMultiaddr("/ws")
+	if err != nil {
+		return err
+	}
+	wss, err := ma.NewMultiaddr("/wss")
+	if err != nil {
+		return err
+	}
+	target, err := manet.ToNetAddr(internal.Decapsulate(ws))
+	if err != nil {
+		return fmt.Errorf("invalid websocket address %s: %s", internal, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.target = target
+	f.internal = internal
+
+	conf := &tls.Config{GetCertificate: f.certs.GetCertificate}
+	for _, a := range addrs {
+		nl, err := manet.Listen(a.Decapsulate(wss))
+		if err != nil {
+			return fmt.Errorf("cannot listen on %s: %s", a, err)
+		}
+		bound := nl.Multiaddr().Encapsulate(wss)
+		l := tls.NewListener(manet.NetListener(nl), conf)
+		f.listeners = append(f.listeners, l)
+		f.addrs = append(f.addrs, bound)
+
+		f.wg.Add(1)
+		go f.serve(l)
+		log.Infof("secure websocket listening at %s", bound)
+	}
+	return nil
+}
+
+func (f *Front) serve(l net.Listener) {
+	defer f.wg.Done()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go f.forward(c)
+	}
+}
+
+func (f *Front) forward(c net.Conn) {
+	defer c.Close()
+
+	t, err := net.Dial(f.target.Network(), f.target.String())
+	if err != nil {
+		log.Errorf("cannot forward the connection of %s: %s", c.RemoteAddr(), err)
+		return
+	}
+	defer t.Close()
+
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		defer func() { done <- struct{}{} }()
+		cw, ok := dst.(interface {
+			CloseWrite() error
+		})
+		if _, err := io.Copy(dst, src); err != nil || !ok {
+			// unblock the other direction
+			dst.Close()
+			src.Close()
+			return
+		}
+		cw.CloseWrite()
+	}
+	go cp(t, c)
+	go cp(c, t)
+	<-done
+	<-done
+}
+
+// Addrs returns the /wss addresses listened on.
+func (f *Front) Addrs() []ma.Multiaddr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ma.Multiaddr(nil), f.addrs...)
+}
+
+// AddrsFactory removes the internal websocket address from the addresses of
+// the host, and adds the /wss addresses.
+func (f *Front) AddrsFactory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.internal == nil {
+		return addrs
+	}
+
+	out := make([]ma.Multiaddr, 0, len(addrs)+len(f.addrs))
+	for _, a := range addrs {
+		if !a.Equal(f.internal) {
+			out = append(out, a)
+		}
+	}
+	for _, a := range f.addrs {
+		out = append(out, f.announced(a, addrs)...)
+	}
+	return out
+}
+
+// announced returns the addresses a is dialed on: its port with the domains
+// if there are, else with the IPs of the host addresses when a listens on
+// all the interfaces.
+func (f *Front) announced(a ma.Multiaddr, hostAddrs []ma.Multiaddr) []ma.Multiaddr {
+	port, err := a.ValueForProtocol(ma.P_TCP)
+	if err != nil {
+		return nil
+	}
+
+	var out []ma.Multiaddr
+	if len(f.domains) > 0 {
+		for _, d := range f.domains {
+			if da, err := ma.NewMultiaddr(fmt.Sprintf("/dns4/%s/tcp/%s/wss", d, port)); err == nil {
+				out = append(out, da)
+			}
+		}
+		return out
+	}
+
+	if !manet.IsIPUnspecified(a) {
+		return []ma.Multiaddr{a}
+	}
+	// the TCP addresses of the host have the IPs of the interfaces already
+	seen := make(map[string]bool)
+	for _, h := range hostAddrs {
+		ps := h.Protocols()
+		if len(ps) != 2 || ps[0].Code != a.Protocols()[0].Code || ps[1].Code != ma.P_TCP {
+			continue
+		}
+		ip, err := h.ValueForProtocol(ps[0].Code)
+		if err != nil || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		if wa, err := ma.NewMultiaddr(fmt.Sprintf("/%s/%s/tcp/%s/wss", ps[0].Name, ip, port)); err == nil {
+			out = append(out, wa)
+		}
+	}
+	return out
+}
+
+// Close stops listening, and closes the certificates.
+func (f *Front) Close() error {
+	f.mu.Lock()
+	var errs []string
+	for _, l := range f.listeners {
+		if err := l.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	f.listeners = nil
+	f.mu.Unlock()
+	f.wg.Wait()
+
+	if c, ok := f.certs.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprint(errs))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package wss
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	manet "gx/ipfs/QmV6FjemM1K8oXjrvuq3wuVWWoU2TLDPmNnKrxHzY3v6Ai/go-multiaddr-net"
+	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
+)
+
+func selfSigned(t *testing.T) *staticCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &staticCertificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func mustAddr(t *testing.T, s string) ma.Multiaddr {
+	a, err := ma.NewMultiaddr(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestSplitAddrs(t *testing.T) {
+	others, wss := SplitAddrs([]ma.Multiaddr{
+		mustAddr(t, "/ip4/0.0.0.0/tcp/4001"),
+		mustAddr(t, "/ip4/0.0.0.0/tcp/4002/ws"),
+		mustAddr(t, "/ip4/0.0.0.0/tcp/4003/wss"),
+	})
+	if len(others) != 2 || len(wss) != 1 || wss[0].String() != "/ip4/0.0.0.0/tcp/4003/wss" {
+		t.Fatalf("unexpected split: %s %s", others, wss)
+	}
+}
+
+func TestForward(t *testing.T) {
+	// the websocket listener of the swarm
+	target, err := manet.Listen(mustAddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		c, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		b, _ := ioutil.ReadAll(c)
+		c.Write(append([]byte("echo "), b...))
+	}()
+
+	f := New(selfSigned(t), nil)
+	defer f.Close()
+	internal := target.Multiaddr().Encapsulate(mustAddr(t, "/ws"))
+	if err := f.Listen([]ma.Multiaddr{mustAddr(t, "/ip4/127.0.0.1/tcp/0/wss")}, internal); err != nil {
+		t.Fatal(err)
+	}
+	addrs := f.Addrs()
+	if len(addrs) != 1 || !IsWssAddr(addrs[0]) {
+		t.Fatalf("unexpected addresses: %s", addrs)
+	}
+
+	na, err := manet.ToNetAddr(addrs[0].Decapsulate(mustAddr(t, "/wss")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := tls.Dial("tcp", na.String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c.CloseWrite()
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "echo hello" {
+		t.Fatalf("unexpected answer %q", b)
+	}
+
+	// the internal address is replaced by the wss one
+	out := f.AddrsFactory([]ma.Multiaddr{mustAddr(t, "/ip4/1.2.3.4/tcp/4001"), internal})
+	if len(out) != 2 || out[0].String() != "/ip4/1.2.3.4/tcp/4001" || !out[1].Equal(addrs[0]) {
+		t.Fatalf("unexpected announced addresses: %s", out)
+	}
+}
+
+func TestAnnounced(t *testing.T) {
+	host := []ma.Multiaddr{
+		mustAddr(t, "/ip4/127.0.0.1/tcp/4001"),
+		mustAddr(t, "/ip4/1.2.3.4/tcp/4001"),
+		mustAddr(t, "/ip4/1.2.3.4/tcp/4002/ws"),
+		mustAddr(t, "/ip4/5.6.7.8/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit"),
+		mustAddr(t, "/ip6/::1/tcp/4001"),
+	}
+	wss := mustAddr(t, "/ip4/0.0.0.0/tcp/443/wss")
+
+	f := New(selfSigned(t), nil)
+	out := f.announced(wss, host)
+	if len(out) != 2 || out[0].String() != "/ip4/127.0.0.1/tcp/443/wss" || out[1].String() != "/ip4/1.2.3.4/tcp/443/wss" {
+		t.Fatalf("unexpected addresses: %s", out)
+	}
+
+	f = New(selfSigned(t), []string{"example.com"})
+	out = f.announced(wss, host)
+	if len(out) != 1 || out[0].String() != "/dns4/example.com/tcp/443/wss" {
+		t.Fatalf("unexpected addresses: %s", out)
+	}
+}
@@ -20,7 +20,8 @@ import (
 
 var log = logging.Logger("pin")
 
-var pinDatastoreKey = ds.NewKey("/local/pins")
+// PinDatastoreKey is the datastore key of the root of the pin set.
+var PinDatastoreKey = ds.NewKey("/local/pins")
 
 var emptyKey cid.Cid
 
@@ -440,7 +441,7 @@ func cidSetWithValues(cids []cid.Cid) *cid.Set {
 func LoadPinner(d ds.Datastore, dserv, internal ipld.DAGService) (Pinner, error) {
 	p := new(pinner)
 
-	rootKey, err := d.Get(pinDatastoreKey)
+	rootKey, err := d.Get(PinDatastoreKey)
 	if err != nil {
 		return nil, fmt.Errorf("cannot load pin state: %v", err)
 	}
@@ -570,7 +571,7 @@ func (p *pinner) Flush() error {
 	k := root.Cid()
 
 	internalset.Add(k)
-	if err := p.dstore.Put(pinDatastoreKey, k.Bytes()); err != nil {
+	if err := p.dstore.Put(PinDatastoreKey, k.Bytes()); err != nil {
 		return fmt.Errorf("cannot store pin state: %v", err)
 	}
 	p.internalPin = internalset
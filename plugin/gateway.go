@@ -0,0 +1,13 @@
+package plugin
+
+import (
+	"github.com/ipfs/go-ipfs/core/corehttp"
+)
+
+// PluginGateway is an interface that can be implemented to add response
+// transformers to the gateway
+type PluginGateway interface {
+	Plugin
+
+	GatewayTransformers() []corehttp.GatewayTransformer
+}
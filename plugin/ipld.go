@@ -14,3 +14,11 @@ type PluginIPLD interface {
 	RegisterBlockDecoders(dec ipld.BlockDecoder) error
 	RegisterInputEncParsers(iec coredag.InputEncParsers) error
 }
+
+// PluginIPLDPath is an interface that can be implemented by IPLD plugins
+// whose formats need custom path resolution
+type PluginIPLDPath interface {
+	PluginIPLD
+
+	RegisterPathResolvers(prs coredag.PathResolvers) error
+}
@@ -2,6 +2,7 @@ package loader
 
 import (
 	"github.com/ipfs/go-ipfs/core/coredag"
+	"github.com/ipfs/go-ipfs/core/corehttp"
 	"github.com/ipfs/go-ipfs/plugin"
 	"gx/ipfs/QmWLWmRVSiagqP15jczsGME1qpob6HDbtbHAY2he9W5iUo/opentracing-go"
 
@@ -32,6 +33,11 @@ func run(plugins []plugin.Plugin) error {
 			if err != nil {
 				return err
 			}
+		case plugin.PluginGateway:
+			err := runGatewayPlugin(pl)
+			if err != nil {
+				return err
+			}
 		default:
 			panic(pl)
 		}
@@ -44,7 +50,14 @@ func runIPLDPlugin(pl plugin.PluginIPLD) error {
 	if err != nil {
 		return err
 	}
-	return pl.RegisterInputEncParsers(coredag.DefaultInputEncParsers)
+	err = pl.RegisterInputEncParsers(coredag.DefaultInputEncParsers)
+	if err != nil {
+		return err
+	}
+	if pl, ok := pl.(plugin.PluginIPLDPath); ok {
+		return pl.RegisterPathResolvers(coredag.DefaultPathResolvers)
+	}
+	return nil
 }
 
 func runTracerPlugin(pl plugin.PluginTracer) error {
@@ -55,3 +68,12 @@ func runTracerPlugin(pl plugin.PluginTracer) error {
 	opentracing.SetGlobalTracer(tracer)
 	return nil
 }
+
+func runGatewayPlugin(pl plugin.PluginGateway) error {
+	for _, t := range pl.GatewayTransformers() {
+		if err := corehttp.RegisterGatewayTransformer(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
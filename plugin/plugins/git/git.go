@@ -2,6 +2,7 @@ package git
 
 import (
 	"compress/zlib"
+	"context"
 	"fmt"
 	"io"
 	"math"
@@ -12,6 +13,7 @@ import (
 	"gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
 	mh "gx/ipfs/QmPnFwZ2JXKnXgMw8CdBPxn7FWh6LLdjUjxV1fKHuJnkr8/go-multihash"
 	git "gx/ipfs/QmRNxeMQs2eVPouLxwa4tN72yTLLgWAi1cChXqgdEz5Ko4/go-ipld-git"
+	"gx/ipfs/QmX7uSbkNz76yNwBhuwYwRbhihLnJqM73VTCjS3UMJud9A/go-path/resolver"
 	"gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
 )
 
@@ -22,7 +24,7 @@ var Plugins = []plugin.Plugin{
 
 type gitPlugin struct{}
 
-var _ plugin.PluginIPLD = (*gitPlugin)(nil)
+var _ plugin.PluginIPLDPath = (*gitPlugin)(nil)
 
 func (*gitPlugin) Name() string {
 	return "ipld-git"
@@ -44,9 +46,40 @@ func (*gitPlugin) RegisterBlockDecoders(dec format.BlockDecoder) error {
 func (*gitPlugin) RegisterInputEncParsers(iec coredag.InputEncParsers) error {
 	iec.AddParser("raw", "git", parseRawGit)
 	iec.AddParser("zlib", "git", parseZlibGit)
+	// "git-raw" is the name of the codec, "git" predates it
+	iec.AddParser("raw", "git-raw", parseRawGit)
+	iec.AddParser("zlib", "git-raw", parseZlibGit)
 	return nil
 }
 
+func (*gitPlugin) RegisterPathResolvers(prs coredag.PathResolvers) error {
+	prs.AddResolver(cid.GitRaw, resolveGitOnce)
+	return nil
+}
+
+// resolveGitOnce resolves the names of tree entries to the objects they point
+// to, so that paths through trees read like file paths: <commit>/tree/src
+// rather than <commit>/tree/src/hash. The fields of an entry are still
+// reachable with <tree>/<name>/hash and <tree>/<name>/mode.
+func resolveGitOnce(ctx context.Context, ds format.NodeGetter, nd format.Node, names []string) (*format.Link, []string, error) {
+	if _, ok := nd.(*git.Tree); !ok || len(names) == 0 {
+		return resolver.ResolveSingle(ctx, ds, nd, names)
+	}
+	if len(names) > 1 && (names[1] == "hash" || names[1] == "mode") {
+		return resolver.ResolveSingle(ctx, ds, nd, names)
+	}
+
+	out, _, err := nd.Resolve([]string{names[0], "hash"})
+	if err != nil {
+		return nil, nil, err
+	}
+	lnk, ok := out.(*format.Link)
+	if !ok {
+		return nil, nil, fmt.Errorf("tree entry %s is not a link", names[0])
+	}
+	return lnk, names[1:], nil
+}
+
 func parseRawGit(r io.Reader, mhType uint64, mhLen int) ([]format.Node, error) {
 	if mhType != math.MaxUint64 && mhType != mh.SHA1 {
 		return nil, fmt.Errorf("unsupported mhType %d", mhType)
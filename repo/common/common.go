@@ -21,12 +21,26 @@ func MapGetKV(v map[string]interface{}, key string) (interface{}, error) {
 
 		cursor, ok = mcursor[part]
 		if !ok {
-			return nil, fmt.Errorf("%s key has no attributes", sofar)
+			return nil, keyNotFoundError(fmt.Sprintf("%s key has no attributes", sofar))
 		}
 	}
 	return cursor, nil
 }
 
+// keyNotFoundError is returned by MapGetKV when the requested key is absent.
+type keyNotFoundError string
+
+func (e keyNotFoundError) Error() string {
+	return string(e)
+}
+
+// IsKeyNotFound returns true if err signals that a key lookup failed because
+// the key is not present.
+func IsKeyNotFound(err error) bool {
+	_, ok := err.(keyNotFoundError)
+	return ok
+}
+
 func MapSetKV(v map[string]interface{}, key string, value interface{}) error {
 	var ok bool
 	var mcursor map[string]interface{}
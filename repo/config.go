@@ -0,0 +1,32 @@
+package repo
+
+import (
+	"encoding/json"
+
+	"github.com/ipfs/go-ipfs/repo/common"
+)
+
+// ConfigSection decodes the config value stored under key into out. If the
+// key is not present in the config, out is left untouched, so callers should
+// fill it with defaults beforehand.
+//
+// This is used for config sections that are read by go-ipfs itself but not
+// (yet) modeled by the go-ipfs-config structs.
+func ConfigSection(r Repo, key string, out interface{}) error {
+	v, err := r.GetConfigKey(key)
+	if err != nil {
+		if common.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
@@ -71,6 +71,18 @@ var measureConfig = []byte(`{
           "type": "measure"
 }`)
 
+var compressConfig = []byte(`{
+          "child": {
+            "path": "blocks",
+            "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2",
+            "sync": true,
+            "type": "flatfs"
+          },
+          "algorithm": "zstd",
+          "minSize": 1024,
+          "type": "compress"
+}`)
+
 func TestDefaultDatastoreConfig(t *testing.T) {
 	dir, err := ioutil.TempDir("", "ipfs-datastore-config-test")
 	if err != nil {
@@ -217,3 +229,48 @@ func TestMeasureConfig(t *testing.T) {
 		t.Errorf("expected '*measure.measure' got '%s'", typ)
 	}
 }
+
+func TestCompressConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfs-datastore-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	spec := make(map[string]interface{})
+	err = json.Unmarshal(compressConfig, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dsc, err := AnyDatastoreConfig(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the compression can be enabled on an existing repo
+	expected := `{"path":"blocks","shardFunc":"/repo/flatfs/shard/v1/next-to-last/2","type":"flatfs"}`
+	if dsc.DiskSpec().String() != expected {
+		t.Errorf("expected '%s' got '%s' as DiskId", expected, dsc.DiskSpec().String())
+	}
+
+	ds, err := dsc.Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if typ := reflect.TypeOf(ds).String(); typ != "*compressds.Datastore" {
+		t.Errorf("expected '*compressds.Datastore' got '%s'", typ)
+	}
+	if created := compressedDatastores(dsc); len(created) != 1 || created[0] != ds {
+		t.Errorf("expected the created datastore to be found, got %v", created)
+	}
+
+	spec["algorithm"] = "lzma"
+	if dsc, err = AnyDatastoreConfig(spec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dsc.Create(dir); err == nil {
+		t.Error("expected an unknown algorithm to be rejected")
+	}
+}
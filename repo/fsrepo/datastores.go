@@ -7,8 +7,11 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"time"
 
 	repo "github.com/ipfs/go-ipfs/repo"
+	compressds "github.com/ipfs/go-ipfs/thirdparty/compressds"
+	s3ds "github.com/ipfs/go-ipfs/thirdparty/s3ds"
 
 	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
 	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
@@ -65,6 +68,8 @@ func init() {
 		"mem":      MemDatastoreConfig,
 		"log":      LogDatastoreConfig,
 		"measure":  MeasureDatastoreConfig,
+		"s3ds":     S3dsDatastoreConfig,
+		"compress": CompressDatastoreConfig,
 	}
 }
 
@@ -410,3 +415,227 @@ func (c *badgerdsDatastoreConfig) Create(path string) (repo.Datastore, error) {
 
 	return badgerds.NewDatastore(p, &defopts)
 }
+
+type s3dsDatastoreConfig struct {
+	cfg s3ds.Config
+
+	// credentialsFile and profile locate the credentials, read from the
+	// environment when credentialsFile is empty
+	credentialsFile string
+	profile         string
+
+	// cache is nil when the write-back cache is disabled
+	cache *s3ds.CacheOptions
+}
+
+// S3dsDatastoreConfig returns a configuration stub for an S3 backed
+// datastore from the given parameters
+func S3dsDatastoreConfig(params map[string]interface{}) (DatastoreConfig, error) {
+	var c s3dsDatastoreConfig
+	var ok bool
+
+	c.cfg.Bucket, ok = params["bucket"].(string)
+	if !ok || c.cfg.Bucket == "" {
+		return nil, fmt.Errorf("'bucket' field is missing or not string")
+	}
+
+	// the config can be read through the API, it mustn't hold secrets
+	for _, field := range []string{"accessKey", "secretKey"} {
+		if _, found := params[field]; found {
+			return nil, fmt.Errorf("'%s' field is not supported, set the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables or 'credentialsFile' instead", field)
+		}
+	}
+
+	c.profile = "default"
+	for field, dst := range map[string]*string{
+		"endpoint":        &c.cfg.Endpoint,
+		"region":          &c.cfg.Region,
+		"rootDirectory":   &c.cfg.RootDirectory,
+		"credentialsFile": &c.credentialsFile,
+		"profile":         &c.profile,
+	} {
+		v, found := params[field]
+		if !found {
+			continue
+		}
+		if *dst, ok = v.(string); !ok {
+			return nil, fmt.Errorf("'%s' field was not a string", field)
+		}
+	}
+
+	if c.cfg.Endpoint == "" {
+		c.cfg.Endpoint = "https://s3.amazonaws.com"
+	}
+
+	if cv, found := params["cache"]; found {
+		cm, ok := cv.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'cache' field was not a map")
+		}
+
+		opts := s3ds.DefaultCacheOptions()
+		for field, dst := range map[string]*int{
+			"entries":    &opts.CacheEntries,
+			"maxPending": &opts.MaxPending,
+			"workers":    &opts.Workers,
+		} {
+			v, found := cm[field]
+			if !found {
+				continue
+			}
+			n, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("'cache.%s' field was not a number", field)
+			}
+			*dst = int(n)
+		}
+		for field, dst := range map[string]*time.Duration{
+			"flushTimeout": &opts.FlushTimeout,
+			"closeTimeout": &opts.CloseTimeout,
+		} {
+			v, found := cm[field]
+			if !found {
+				continue
+			}
+			str, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("'cache.%s' field was not a string", field)
+			}
+			d, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("'cache.%s' field was not a duration: %s", field, err)
+			}
+			*dst = d
+		}
+		c.cache = &opts
+	}
+
+	return &c, nil
+}
+
+func (c *s3dsDatastoreConfig) DiskSpec() DiskSpec {
+	return map[string]interface{}{
+		"type":          "s3ds",
+		"endpoint":      c.cfg.Endpoint,
+		"bucket":        c.cfg.Bucket,
+		"rootDirectory": c.cfg.RootDirectory,
+	}
+}
+
+func (c *s3dsDatastoreConfig) Create(path string) (repo.Datastore, error) {
+	cfg := c.cfg
+	if c.credentialsFile != "" {
+		p := c.credentialsFile
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(path, p)
+		}
+		var err error
+		cfg.AccessKey, cfg.SecretKey, err = s3ds.ReadCredentials(p, c.profile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	d, err := s3ds.NewDatastore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if c.cache == nil {
+		return d, nil
+	}
+	return s3ds.NewWriteBack(d, *c.cache)
+}
+
+type compressDatastoreConfig struct {
+	child DatastoreConfig
+	opts  compressds.Options
+
+	// created is the datastore returned by Create
+	created *compressds.Datastore
+}
+
+// CompressDatastoreConfig returns a DatastoreConfig compressing the blocks of
+// its child from the given parameters
+func CompressDatastoreConfig(params map[string]interface{}) (DatastoreConfig, error) {
+	childField, ok := params["child"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("'child' field is missing or not a map")
+	}
+	child, err := AnyDatastoreConfig(childField)
+	if err != nil {
+		return nil, err
+	}
+
+	c := compressDatastoreConfig{child: child, opts: compressds.DefaultOptions()}
+	if v, found := params["algorithm"]; found {
+		if c.opts.Algorithm, ok = v.(string); !ok {
+			return nil, fmt.Errorf("'algorithm' field was not a string")
+		}
+	}
+	if v, found := params["minSize"]; found {
+		n, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("'minSize' field was not a number")
+		}
+		c.opts.MinSize = int(n)
+	}
+	if v, found := params["skipCodecs"]; found {
+		codecs, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'skipCodecs' field was not an array")
+		}
+		c.opts.SkipCodecs = make([]string, len(codecs))
+		for i, codec := range codecs {
+			if c.opts.SkipCodecs[i], ok = codec.(string); !ok {
+				return nil, fmt.Errorf("'skipCodecs' field was not an array of strings")
+			}
+		}
+	}
+	return &c, nil
+}
+
+// DiskSpec returns the spec of the child: the compressed values are told
+// apart from the others, the wrapper can be added to an existing repo.
+func (c *compressDatastoreConfig) DiskSpec() DiskSpec {
+	return c.child.DiskSpec()
+}
+
+func (c *compressDatastoreConfig) Create(path string) (repo.Datastore, error) {
+	child, err := c.child.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := compressds.Wrap(child, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.created = d
+	return d, nil
+}
+
+// compressedDatastores returns the compressed datastores created from the
+// config tree of c.
+func compressedDatastores(c DatastoreConfig) []*compressds.Datastore {
+	switch c := c.(type) {
+	case *mountDatastoreConfig:
+		var out []*compressds.Datastore
+		for _, m := range c.mounts {
+			out = append(out, compressedDatastores(m.ds)...)
+		}
+		return out
+	case *logDatastoreConfig:
+		return compressedDatastores(c.child)
+	case *measureDatastoreConfig:
+		return compressedDatastores(c.child)
+	case *compressDatastoreConfig:
+		if c.created == nil {
+			return nil
+		}
+		return []*compressds.Datastore{c.created}
+	default:
+		return nil
+	}
+}
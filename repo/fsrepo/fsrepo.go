@@ -16,6 +16,7 @@ import (
 	repo "github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/common"
 	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+	compressds "github.com/ipfs/go-ipfs/thirdparty/compressds"
 	dir "github.com/ipfs/go-ipfs/thirdparty/dir"
 
 	util "gx/ipfs/QmPdKqUcHGFdeSpvjVoaTRPPstGif9GBZb5Q56RVw9o69A/go-ipfs-util"
@@ -66,6 +67,9 @@ func (err NoRepoError) Error() string {
 const apiFile = "api"
 const swarmKeyFile = "swarm.key"
 
+// swarmKeyBackupFile is the previous swarm key, replaced by SetSwarmKey.
+const swarmKeyBackupFile = "swarm.key.old"
+
 const specFn = "datastore_spec"
 
 var (
@@ -101,6 +105,8 @@ type FSRepo struct {
 	lockfile io.Closer
 	config   *config.Config
 	ds       repo.Datastore
+	// dsc is the config the datastore was created from
+	dsc      DatastoreConfig
 	keystore keystore.Keystore
 	filemgr  *filestore.FileManager
 }
@@ -426,6 +432,7 @@ func (r *FSRepo) openDatastore() error {
 		return err
 	}
 	r.ds = d
+	r.dsc = dsc
 
 	// Wrap it with metrics gathering
 	prefix := "ipfs.fsrepo.datastore"
@@ -657,10 +664,38 @@ func (r *FSRepo) SetConfigKey(key string, value interface{}) error {
 	if err != nil {
 		return err
 	}
+	// the map is written as is, so that the keys the config struct doesn't
+	// model in the sections it does are kept
 	if err := serialize.WriteConfigFile(filename, mapconf); err != nil {
 		return err
 	}
-	return r.setConfigUnsynced(conf) // TODO roll this into this method
+	*r.config = *conf
+	return nil
+}
+
+// ReplaceConfig writes mapconf as the config of the FSRepo, keys the config
+// struct doesn't model included.
+func (r *FSRepo) ReplaceConfig(mapconf map[string]interface{}) error {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	if r.closed {
+		return errors.New("repo is closed")
+	}
+
+	conf, err := config.FromMap(mapconf)
+	if err != nil {
+		return err
+	}
+	filename, err := config.Filename(r.path)
+	if err != nil {
+		return err
+	}
+	if err := serialize.WriteConfigFile(filename, mapconf); err != nil {
+		return err
+	}
+	*r.config = *conf
+	return nil
 }
 
 // Datastore returns a repo-owned datastore. If FSRepo is Closed, return value
@@ -677,6 +712,17 @@ func (r *FSRepo) GetStorageUsage() (uint64, error) {
 	return ds.DiskUsage(r.Datastore())
 }
 
+// CompressedDatastores returns the datastores compressing blocks, created
+// from the "compress" entries of Datastore.Spec.
+func (r *FSRepo) CompressedDatastores() []*compressds.Datastore {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+	if r.dsc == nil {
+		return nil
+	}
+	return compressedDatastores(r.dsc)
+}
+
 func (r *FSRepo) SwarmKey() ([]byte, error) {
 	repoPath := filepath.Clean(r.path)
 	spath := filepath.Join(repoPath, swarmKeyFile)
@@ -693,6 +739,46 @@ func (r *FSRepo) SwarmKey() ([]byte, error) {
 	return ioutil.ReadAll(f)
 }
 
+// SetSwarmKey writes key to the swarm.key file, readable by the owner only.
+// The previous key, if any, is moved to swarm.key.old.
+func (r *FSRepo) SetSwarmKey(key []byte) error {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	if r.closed {
+		return errors.New("repo is closed")
+	}
+
+	repoPath := filepath.Clean(r.path)
+	spath := filepath.Join(repoPath, swarmKeyFile)
+
+	tmp, err := ioutil.TempFile(repoPath, swarmKeyFile+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// TempFile creates the file with the 0600 mode
+	_, err = tmp.Write(key)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	prev, err := ioutil.ReadFile(spath)
+	switch {
+	case err == nil:
+		if err := ioutil.WriteFile(filepath.Join(repoPath, swarmKeyBackupFile), prev, 0600); err != nil {
+			return fmt.Errorf("cannot back up the swarm key: %s", err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	return os.Rename(tmp.Name(), spath)
+}
+
 var _ io.Closer = &FSRepo{}
 var _ repo.Repo = &FSRepo{}
 
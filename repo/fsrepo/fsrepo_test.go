@@ -116,3 +116,55 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestSetConfigKeyKeepsUnmodeledKeys(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("unmodeled", t)
+	defer Remove(path)
+	assert.Nil(Init(path, &config.Config{Datastore: config.DefaultDatastoreConfig()}), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	assert.Nil(r.SetConfigKey("Gateway.Timeouts", map[string]interface{}{"FirstByte": "10s"}), t)
+	assert.Nil(r.SetConfigKey("Gateway.Writable", true), t)
+	assert.Nil(r.SetConfigKey("Gateway.Server.HTTP2", false), t)
+
+	v, err := r.GetConfigKey("Gateway.Timeouts.FirstByte")
+	assert.Nil(err, t, "the section must survive the next SetConfigKey")
+	assert.True(v == "10s", t, "unexpected value")
+	v, err = r.GetConfigKey("Gateway.Server.HTTP2")
+	assert.Nil(err, t)
+	assert.True(v == false, t, "unexpected value")
+}
+
+func TestReplaceConfigRemovesKeys(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("replace", t)
+	defer Remove(path)
+	assert.Nil(Init(path, &config.Config{Datastore: config.DefaultDatastoreConfig()}), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	assert.Nil(r.SetConfigKey("Gateway.HTTPHeaders", map[string]interface{}{"X-Stale": []string{"1"}}), t)
+	assert.Nil(r.SetConfigKey("Unmodeled", map[string]interface{}{"Stale": true}), t)
+
+	cfg, err := r.Config()
+	assert.Nil(err, t)
+	mapconf, err := config.ToMap(cfg)
+	assert.Nil(err, t)
+	mapconf["Gateway"].(map[string]interface{})["HTTPHeaders"] = map[string]interface{}{}
+	mapconf["Other"] = map[string]interface{}{"Kept": true}
+	assert.Nil(r.ReplaceConfig(mapconf), t)
+
+	_, err = r.GetConfigKey("Gateway.HTTPHeaders.X-Stale")
+	assert.Err(err, t, "the removed header must be gone")
+	_, err = r.GetConfigKey("Unmodeled")
+	assert.Err(err, t, "the section missing from the replacement must be gone")
+	v, err := r.GetConfigKey("Other.Kept")
+	assert.Nil(err, t)
+	assert.True(v == true, t, "unexpected value")
+}
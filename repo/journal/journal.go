@@ -0,0 +1,295 @@
+// Package journal implements a write-ahead journal of the updates of the
+// datastore keys holding the roots of the node state, like the pin set or
+// the files API root.
+//
+// These roots are updated once the DAG they point to has been written. If
+// the node crashes before these blocks are durably stored, the key can point
+// to a root that can't be loaded anymore, and the whole state is lost. The
+// journal keeps the previous root of every update until the node shuts down
+// cleanly, so that Recover can roll back to it.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+)
+
+var (
+	entriesKey = ds.NewKey("/local/journal")
+	reportKey  = ds.NewKey("/local/lastrecovery")
+)
+
+// Recovery actions.
+const (
+	// Replayed is the action of the updates whose new root can be loaded,
+	// which are kept, or applied if the node crashed before.
+	Replayed = "replayed"
+	// RolledBack is the action of the updates whose new root can't be
+	// loaded, reverted to the previous root.
+	RolledBack = "rolled back"
+	// Unrecoverable is the action of the updates whose previous root can't
+	// be loaded either, which are left as they are.
+	Unrecoverable = "unrecoverable"
+)
+
+// Entry is an update of a root key.
+type Entry struct {
+	Key string
+	// Old is the CID of the previous root, empty if the key wasn't set.
+	Old string `json:",omitempty"`
+	New string
+}
+
+// Recovery is an update found in the journal by Recover, and what was done
+// with it.
+type Recovery struct {
+	Entry
+	Action string
+}
+
+// Report lists what the last recovery did.
+type Report struct {
+	Time       time.Time
+	Recoveries []Recovery
+}
+
+// Check tells whether the root c of key can be loaded.
+type Check func(key ds.Key, c cid.Cid) bool
+
+// Journal records the updates of the root keys of a datastore.
+type Journal struct {
+	d ds.Datastore
+
+	lk sync.Mutex
+}
+
+// New returns a Journal stored in d.
+func New(d ds.Datastore) *Journal {
+	return &Journal{d: d}
+}
+
+func entryKey(key ds.Key) ds.Key {
+	return entriesKey.Child(key)
+}
+
+// Put sets key to c, recording the update in the journal first. The previous
+// root of key is kept until Commit is called.
+func (j *Journal) Put(key ds.Key, c cid.Cid) error {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	e := Entry{Key: key.String(), New: c.String()}
+
+	// if the key was already updated since the last commit, the oldest
+	// root is kept: it is the last one known to be loadable
+	b, err := j.d.Get(entryKey(key))
+	switch err {
+	case nil:
+		var prev Entry
+		if err := json.Unmarshal(b, &prev); err != nil {
+			return fmt.Errorf("corrupt journal entry for %s: %s", key, err)
+		}
+		e.Old = prev.Old
+	case ds.ErrNotFound:
+		v, err := j.d.Get(key)
+		switch err {
+		case nil:
+			old, err := cid.Cast(v)
+			if err != nil {
+				return err
+			}
+			e.Old = old.String()
+		case ds.ErrNotFound:
+		default:
+			return err
+		}
+	default:
+		return err
+	}
+
+	b, err = json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := j.d.Put(entryKey(key), b); err != nil {
+		return err
+	}
+	return j.d.Put(key, c.Bytes())
+}
+
+// Commit clears the journal, once all the recorded roots are durably stored,
+// like when the node shuts down cleanly.
+func (j *Journal) Commit() error {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	entries, err := j.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := j.d.Delete(entryKey(ds.NewKey(e.Key))); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close commits the journal.
+func (j *Journal) Close() error {
+	return j.Commit()
+}
+
+func (j *Journal) entries() ([]Entry, error) {
+	res, err := j.d.Query(dsq.Query{Prefix: entriesKey.String()})
+	if err != nil {
+		return nil, err
+	}
+	all, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Entry, 0, len(all))
+	for _, r := range all {
+		var e Entry
+		if err := json.Unmarshal(r.Value, &e); err != nil {
+			return nil, fmt.Errorf("corrupt journal entry %s: %s", r.Key, err)
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// Recover goes through the updates left in the journal by a node which
+// didn't shut down cleanly. The updates whose root can be loaded are kept,
+// the others are rolled back to the previous root. What was done is returned,
+// and saved as the last report if there was anything to recover.
+func (j *Journal) Recover(check Check) ([]Recovery, error) {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	entries, err := j.entries()
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	out := make([]Recovery, 0, len(entries))
+	for _, e := range entries {
+		r, err := j.recover(e, check)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+
+	b, err := json.Marshal(Report{Time: time.Now(), Recoveries: out})
+	if err != nil {
+		return nil, err
+	}
+	if err := j.d.Put(reportKey, b); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (j *Journal) recover(e Entry, check Check) (Recovery, error) {
+	key := ds.NewKey(e.Key)
+	r := Recovery{Entry: e}
+
+	next, err := cid.Decode(e.New)
+	if err != nil {
+		return r, fmt.Errorf("corrupt journal entry for %s: %s", key, err)
+	}
+
+	switch {
+	case check(key, next):
+		r.Action = Replayed
+		err = j.d.Put(key, next.Bytes())
+	case e.Old == "":
+		r.Action = RolledBack
+		err = j.d.Delete(key)
+		if err == ds.ErrNotFound {
+			err = nil
+		}
+	default:
+		old, err := cid.Decode(e.Old)
+		if err != nil {
+			return r, fmt.Errorf("corrupt journal entry for %s: %s", key, err)
+		}
+		if !check(key, old) {
+			r.Action = Unrecoverable
+			break
+		}
+		r.Action = RolledBack
+		if err := j.d.Put(key, old.Bytes()); err != nil {
+			return r, err
+		}
+	}
+	if err != nil {
+		return r, err
+	}
+
+	if err := j.d.Delete(entryKey(key)); err != nil && err != ds.ErrNotFound {
+		return r, err
+	}
+	return r, nil
+}
+
+// LastReport returns the report of the last recovery of the journal stored
+// in d, or nil if nothing was ever recovered.
+func LastReport(d ds.Datastore) (*Report, error) {
+	b, err := d.Get(reportKey)
+	if err == ds.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("corrupt journal report: %s", err)
+	}
+	return r, nil
+}
+
+// journaledDatastore journals the updates of a set of root keys.
+type journaledDatastore struct {
+	ds.Datastore
+	j    *Journal
+	keys map[ds.Key]bool
+}
+
+// Wrap returns a datastore updating the given root keys through the journal.
+// Their values must be CIDs.
+func (j *Journal) Wrap(d ds.Datastore, keys ...ds.Key) ds.Datastore {
+	jd := &journaledDatastore{
+		Datastore: d,
+		j:         j,
+		keys:      make(map[ds.Key]bool, len(keys)),
+	}
+	for _, k := range keys {
+		jd.keys[k] = true
+	}
+	return jd
+}
+
+func (jd *journaledDatastore) Put(key ds.Key, value []byte) error {
+	if !jd.keys[key] {
+		return jd.Datastore.Put(key, value)
+	}
+	c, err := cid.Cast(value)
+	if err != nil {
+		return fmt.Errorf("journaled key %s must be set to a CID: %s", key, err)
+	}
+	return jd.j.Put(key, c)
+}
@@ -0,0 +1,168 @@
+package journal
+
+import (
+	"testing"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	mh "gx/ipfs/QmPnFwZ2JXKnXgMw8CdBPxn7FWh6LLdjUjxV1fKHuJnkr8/go-multihash"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+)
+
+var rootKey = ds.NewKey("/local/root")
+
+func testCid(t *testing.T, s string) cid.Cid {
+	h, err := mh.Sum([]byte(s), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func getRoot(t *testing.T, d ds.Datastore) cid.Cid {
+	v, err := d.Get(rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cid.Cast(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func loadable(cids ...cid.Cid) Check {
+	return func(_ ds.Key, c cid.Cid) bool {
+		for _, l := range cids {
+			if l.Equals(c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestRecoverRollBack(t *testing.T) {
+	d := ds.NewMapDatastore()
+	a, b, c := testCid(t, "a"), testCid(t, "b"), testCid(t, "c")
+
+	if err := d.Put(rootKey, a.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	j := New(d)
+	jd := j.Wrap(d, rootKey)
+	if err := jd.Put(rootKey, b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := jd.Put(rootKey, c.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if r := getRoot(t, d); !r.Equals(c) {
+		t.Fatalf("expected root %s, got %s", c, r)
+	}
+
+	// the oldest root is kept until the journal is committed
+	recovered, err := New(d).Recover(loadable(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 || recovered[0].Action != RolledBack || recovered[0].Old != a.String() {
+		t.Fatalf("unexpected recovery: %v", recovered)
+	}
+	if r := getRoot(t, d); !r.Equals(a) {
+		t.Fatalf("expected root %s, got %s", a, r)
+	}
+
+	report, err := LastReport(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil || len(report.Recoveries) != 1 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+
+	// recovered entries are cleared
+	recovered, err = New(d).Recover(loadable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 0 {
+		t.Fatalf("unexpected recovery: %v", recovered)
+	}
+}
+
+func TestRecoverReplay(t *testing.T) {
+	d := ds.NewMapDatastore()
+	a := testCid(t, "a")
+
+	j := New(d)
+	if err := j.Put(rootKey, a); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := New(d).Recover(loadable(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 || recovered[0].Action != Replayed || recovered[0].Old != "" {
+		t.Fatalf("unexpected recovery: %v", recovered)
+	}
+	if r := getRoot(t, d); !r.Equals(a) {
+		t.Fatalf("expected root %s, got %s", a, r)
+	}
+}
+
+func TestRecoverUnset(t *testing.T) {
+	d := ds.NewMapDatastore()
+
+	if err := New(d).Put(rootKey, testCid(t, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := New(d).Recover(loadable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 || recovered[0].Action != RolledBack {
+		t.Fatalf("unexpected recovery: %v", recovered)
+	}
+	if _, err := d.Get(rootKey); err != ds.ErrNotFound {
+		t.Fatalf("expected the root to be deleted, got %v", err)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	d := ds.NewMapDatastore()
+	a := testCid(t, "a")
+
+	j := New(d)
+	jd := j.Wrap(d, rootKey)
+	if err := jd.Put(rootKey, a.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := jd.Put(ds.NewKey("/other"), []byte("not a cid")); err != nil {
+		t.Fatal(err)
+	}
+	if err := jd.Put(rootKey, []byte("not a cid")); err == nil {
+		t.Fatal("expected journaled keys to be set to CIDs only")
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := New(d).Recover(loadable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 0 {
+		t.Fatalf("expected nothing to recover after a commit, got %v", recovered)
+	}
+	if r := getRoot(t, d); !r.Equals(a) {
+		t.Fatalf("expected root %s, got %s", a, r)
+	}
+
+	report, err := LastReport(d)
+	if err != nil || report != nil {
+		t.Fatalf("expected no report, got %v, %v", report, err)
+	}
+}
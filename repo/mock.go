@@ -5,6 +5,7 @@ import (
 
 	filestore "github.com/ipfs/go-ipfs/filestore"
 	keystore "github.com/ipfs/go-ipfs/keystore"
+	common "github.com/ipfs/go-ipfs/repo/common"
 
 	config "gx/ipfs/QmYVqYJTVjetcf1guieEgWpK1PZtHPytP624vKzTF1P3r2/go-ipfs-config"
 	ma "gx/ipfs/QmYmsdtJ3HsodkePE3eU3TsCaP2YvPZJ4LoXnNkDE5Tpt7/go-multiaddr"
@@ -28,6 +29,15 @@ func (m *Mock) SetConfig(updated *config.Config) error {
 	return nil
 }
 
+func (m *Mock) ReplaceConfig(mapconf map[string]interface{}) error {
+	conf, err := config.FromMap(mapconf)
+	if err != nil {
+		return err
+	}
+	m.C = *conf // FIXME threadsafety
+	return nil
+}
+
 func (m *Mock) BackupConfig(prefix string) (string, error) {
 	return "", errTODO
 }
@@ -37,7 +47,11 @@ func (m *Mock) SetConfigKey(key string, value interface{}) error {
 }
 
 func (m *Mock) GetConfigKey(key string) (interface{}, error) {
-	return nil, errTODO
+	mapconf, err := config.ToMap(&m.C)
+	if err != nil {
+		return nil, err
+	}
+	return common.MapGetKV(mapconf, key)
 }
 
 func (m *Mock) Datastore() Datastore { return m.D }
@@ -54,4 +68,6 @@ func (m *Mock) SwarmKey() ([]byte, error) {
 	return nil, nil
 }
 
+func (m *Mock) SetSwarmKey(key []byte) error { return errTODO }
+
 func (m *Mock) FileManager() *filestore.FileManager { return nil }
@@ -29,6 +29,10 @@ type Repo interface {
 	// SetConfig persists the given configuration struct to storage.
 	SetConfig(*config.Config) error
 
+	// ReplaceConfig persists the given configuration map in place of the
+	// current one, the keys the configuration struct doesn't model included.
+	ReplaceConfig(map[string]interface{}) error
+
 	// SetConfigKey sets the given key-value pair within the config and persists it to storage.
 	SetConfigKey(key string, value interface{}) error
 
@@ -53,6 +57,10 @@ type Repo interface {
 	// SwarmKey returns the configured shared symmetric key for the private networks feature.
 	SwarmKey() ([]byte, error)
 
+	// SetSwarmKey replaces the shared symmetric key of the private network,
+	// keeping the previous one as a backup.
+	SetSwarmKey(key []byte) error
+
 	io.Closer
 }
 
@@ -0,0 +1,359 @@
+// Package search implements a local full-text index of the names, and
+// optionally of the text content, of the UnixFS files added and pinned to a
+// node.
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	uio "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs/io"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	ipld "gx/ipfs/QmdDXJs4axxefSPgK6Y1QhpJWKuDPnGJiqgq4uncb4rFHL/go-ipld-format"
+)
+
+var log = logging.Logger("search")
+
+var (
+	termsPrefix   = ds.NewKey("/local/search/terms")
+	entriesPrefix = ds.NewKey("/local/search/entries")
+)
+
+// ErrEmptyQuery is returned for the queries without any term.
+var ErrEmptyQuery = errors.New("the query has no term to search")
+
+// queueSize is the number of roots waiting to be indexed above which the
+// new ones are dropped.
+const queueSize = 1024
+
+// Result is a file or a directory matching a query.
+type Result struct {
+	Cid cid.Cid
+	// Path is the path of the file in the DAG it was indexed with,
+	// /ipfs/<root>/<names>.
+	Path string
+}
+
+// entry is an indexed file, with its terms to remove it from the index.
+type entry struct {
+	Cid   string
+	Path  string
+	Terms []string
+}
+
+func (e *entry) id() string {
+	sum := sha256.Sum256([]byte(e.Cid + "\x00" + e.Path))
+	return hex.EncodeToString(sum[:16])
+}
+
+type root struct {
+	c    cid.Cid
+	name string
+}
+
+// Index is the full-text index of the files of a node, kept in its
+// datastore. The roots are indexed in the background, with the blocks
+// stored locally only.
+type Index struct {
+	ds  ds.Datastore
+	dag ipld.DAGService
+	has func(cid.Cid) (bool, error)
+
+	// Content also indexes the words of the text files of at most
+	// MaxContentSize bytes.
+	Content        bool
+	MaxContentSize uint64
+
+	lk      sync.Mutex
+	queue   chan root
+	closed  chan struct{}
+	done    chan struct{}
+	closing sync.Once
+}
+
+// NewIndex returns the index of the datastore d, reading the DAGs with dserv,
+// which shouldn't fetch blocks from the network, and dropping the results
+// whose block has since been removed according to has.
+func NewIndex(d ds.Datastore, dserv ipld.DAGService, has func(cid.Cid) (bool, error)) *Index {
+	ix := &Index{
+		ds:     d,
+		dag:    dserv,
+		has:    has,
+		queue:  make(chan root, queueSize),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go ix.run()
+	return ix
+}
+
+func (ix *Index) run() {
+	defer close(ix.done)
+	for {
+		select {
+		case r := <-ix.queue:
+			ix.index(r)
+		case <-ix.closed:
+			// the roots queued before the close are indexed, for the adds
+			// of the commands run without a daemon
+			for {
+				select {
+				case r := <-ix.queue:
+					ix.index(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (ix *Index) index(r root) {
+	if err := ix.IndexDAG(context.Background(), r.c, r.name); err != nil {
+		log.Warningf("failed to index %s: %s", r.c, err)
+	}
+}
+
+// Add queues the DAG of c, added with the name name if it has one, to be
+// indexed in the background.
+func (ix *Index) Add(c cid.Cid, name string) {
+	select {
+	case <-ix.closed:
+		log.Warningf("search index closed, %s is not indexed", c)
+		return
+	default:
+	}
+	select {
+	case ix.queue <- root{c: c, name: name}:
+	default:
+		log.Warningf("search index queue full, %s is not indexed", c)
+	}
+}
+
+// Close waits for the queued roots to be indexed.
+func (ix *Index) Close() error {
+	ix.closing.Do(func() { close(ix.closed) })
+	<-ix.done
+	return nil
+}
+
+// IndexDAG indexes the files and directories of the DAG of c, the root of
+// the DAG named name. The missing blocks are skipped.
+func (ix *Index) IndexDAG(ctx context.Context, c cid.Cid, name string) error {
+	nd, err := ix.dag.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	return ix.walk(ctx, nd, "/ipfs/"+c.String(), name)
+}
+
+func (ix *Index) walk(ctx context.Context, nd ipld.Node, p, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	terms := newTermSet()
+	terms.add(name)
+
+	switch n := nd.(type) {
+	case *dag.RawNode:
+		ix.addContent(ctx, terms, nd, name, uint64(len(n.RawData())))
+	case *dag.ProtoNode:
+		fsn, err := ft.FromBytes(n.Data())
+		if err != nil {
+			// not UnixFS, only its name is indexed
+			break
+		}
+		switch fsn.GetType() {
+		case ft.TDirectory, ft.THAMTShard:
+			dir, err := uio.NewDirectoryFromNode(ix.dag, nd)
+			if err != nil {
+				return err
+			}
+			err = dir.ForEachLink(ctx, func(l *ipld.Link) error {
+				child, err := l.GetNode(ctx, ix.dag)
+				if err != nil {
+					log.Debugf("not indexing %s/%s: %s", p, l.Name, err)
+					return ctx.Err()
+				}
+				return ix.walk(ctx, child, p+"/"+l.Name, l.Name)
+			})
+			if err != nil {
+				return err
+			}
+		case ft.TFile, ft.TRaw:
+			ix.addContent(ctx, terms, nd, name, fsn.GetFilesize())
+		}
+	}
+
+	if terms.len() == 0 {
+		return nil
+	}
+	return ix.put(&entry{Cid: nd.Cid().String(), Path: p, Terms: terms.list()})
+}
+
+// addContent adds the terms of the content of the file nd to terms, if it
+// is a small text file.
+func (ix *Index) addContent(ctx context.Context, terms *termSet, nd ipld.Node, name string, size uint64) {
+	if !ix.Content || size > ix.MaxContentSize {
+		return
+	}
+	r, err := uio.NewDagReader(ctx, nd, ix.dag)
+	if err != nil {
+		log.Debugf("not indexing the content of %s: %s", nd.Cid(), err)
+		return
+	}
+	defer r.Close()
+	if err := terms.addText(r, name); err != nil {
+		log.Debugf("not indexing the content of %s: %s", nd.Cid(), err)
+	}
+}
+
+func termKey(term, id string) ds.Key {
+	return termsPrefix.ChildString(term).ChildString(id)
+}
+
+// put indexes e, replacing its previous terms.
+func (ix *Index) put(e *entry) error {
+	ix.lk.Lock()
+	defer ix.lk.Unlock()
+
+	id := e.id()
+	if err := ix.remove(id); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	for _, t := range e.Terms {
+		if err := ix.ds.Put(termKey(t, id), []byte(e.Cid+" "+e.Path)); err != nil {
+			return err
+		}
+	}
+	return ix.ds.Put(entriesPrefix.ChildString(id), b)
+}
+
+// remove forgets the entry id and its terms. It must be called with the
+// lock held.
+func (ix *Index) remove(id string) error {
+	b, err := ix.ds.Get(entriesPrefix.ChildString(id))
+	if err == ds.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return err
+	}
+	for _, t := range e.Terms {
+		if err := ix.ds.Delete(termKey(t, id)); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return ix.ds.Delete(entriesPrefix.ChildString(id))
+}
+
+// Search returns the files and directories whose name or content has all
+// the terms of query, a trailing * matching the terms with that prefix, in
+// the order of their paths. A limit of zero doesn't limit them.
+func (ix *Index) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	var matches map[string]string
+	n := 0
+	for _, q := range strings.Fields(query) {
+		// the * only applies to the last term of the word
+		prefix := strings.HasSuffix(q, "*")
+		terms := Terms(strings.TrimSuffix(q, "*"))
+		for i, t := range terms {
+			m, err := ix.match(t, prefix && i == len(terms)-1)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				matches = m
+			} else {
+				for id := range matches {
+					if _, ok := m[id]; !ok {
+						delete(matches, id)
+					}
+				}
+			}
+			n++
+		}
+	}
+	if n == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	var out []Result
+	for id, v := range matches {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		parts := strings.SplitN(v, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		c, err := cid.Decode(parts[0])
+		if err != nil {
+			continue
+		}
+		if ok, err := ix.has(c); err != nil {
+			return nil, err
+		} else if !ok {
+			// removed by a gc since it was indexed
+			ix.lk.Lock()
+			err := ix.remove(id)
+			ix.lk.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		out = append(out, Result{Cid: c, Path: parts[1]})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// match returns the entries of the term t, or of the terms starting with t,
+// by ID.
+func (ix *Index) match(t string, prefix bool) (map[string]string, error) {
+	q := termsPrefix.ChildString(t).String()
+	if !prefix {
+		q += "/"
+	}
+	res, err := ix.ds.Query(dsq.Query{Prefix: q})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	out := make(map[string]string)
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		k := ds.NewKey(r.Key)
+		out[k.BaseNamespace()] = string(r.Value)
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	ft "gx/ipfs/QmPL8bYtbACcSFFiSr4s2du7Na382NxRADR8hC7D9FkEA2/go-unixfs"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	dag "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag"
+	mdtest "gx/ipfs/QmXv5mwmQ74r4aiHcNeQ4GAmfB3aWJuqaE4WyDfDfvkgLM/go-merkledag/test"
+)
+
+func TestTerms(t *testing.T) {
+	got := Terms("Annual-Report_2018.PDF, a résumé")
+	expected := []string{"annual", "report", "2018", "pdf", "résumé"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+
+	file := func(data string) *dag.ProtoNode {
+		nd := dag.NodeWithData(ft.FilePBData([]byte(data), uint64(len(data))))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	report := file("the annual figures of the cooperative")
+	notes := file("meeting notes about the figures")
+	binary := file("figures\x00\x01\x02")
+
+	sub := ft.EmptyDirNode()
+	sub.AddNodeLink("annual-report.txt", report)
+	sub.AddNodeLink("data.bin", binary)
+	if err := dserv.Add(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	root := ft.EmptyDirNode()
+	root.AddNodeLink("2018", sub)
+	root.AddNodeLink("notes.md", notes)
+	if err := dserv.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := map[cid.Cid]bool{}
+	has := func(c cid.Cid) (bool, error) { return !removed[c], nil }
+	ix := NewIndex(dssync.MutexWrap(ds.NewMapDatastore()), dserv, has)
+	ix.Content = true
+	ix.MaxContentSize = 1 << 20
+	defer ix.Close()
+
+	if err := ix.IndexDAG(ctx, root.Cid(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(q string) []string {
+		res, err := ix.Search(ctx, q, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, r := range res {
+			paths = append(paths, r.Path)
+		}
+		return paths
+	}
+	p := "/ipfs/" + root.Cid().String()
+
+	for q, expected := range map[string][]string{
+		"annual":            {p + "/2018/annual-report.txt"},
+		"REPORT txt":        {p + "/2018/annual-report.txt"},
+		"figures":           {p + "/2018/annual-report.txt", p + "/notes.md"},
+		"figures meeting":   {p + "/notes.md"},
+		"coop*":             {p + "/2018/annual-report.txt"},
+		"2018":              {p + "/2018"},
+		"bin":               {p + "/2018/data.bin"},
+		"nothing":           nil,
+		"figures nothing*":  nil,
+		"annual-rep* notes": nil,
+	} {
+		if got := search(q); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected %q to find %v, got %v", q, expected, got)
+		}
+	}
+
+	if _, err := ix.Search(ctx, "a !", 0); err != ErrEmptyQuery {
+		t.Fatalf("expected ErrEmptyQuery, got %v", err)
+	}
+	if got, _ := ix.Search(ctx, "figures", 1); len(got) != 1 {
+		t.Fatalf("expected the limit to apply, got %d results", len(got))
+	}
+
+	// the removed files are left out
+	removed[notes.Cid()] = true
+	if got := search("figures"); !reflect.DeepEqual(got, []string{p + "/2018/annual-report.txt"}) {
+		t.Fatalf("expected the removed file to be left out, got %v", got)
+	}
+
+	// indexing again replaces the terms
+	ix.Content = false
+	if err := ix.IndexDAG(ctx, root.Cid(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("cooperative"); got != nil {
+		t.Fatalf("expected the content to be forgotten, got %v", got)
+	}
+
+	// the queued roots are indexed before the index closes
+	single := file("single")
+	ix.Add(single.Cid(), "lonely.txt")
+	ix.Close()
+	if got := search("lonely"); !reflect.DeepEqual(got, []string{"/ipfs/" + single.Cid().String()}) {
+		t.Fatalf("expected the queued root to be indexed, got %v", got)
+	}
+}
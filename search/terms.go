@@ -0,0 +1,109 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// minTermLength and maxTermLength bound the length of the indexed
+	// terms, in runes.
+	minTermLength = 2
+	maxTermLength = 64
+	// maxTerms bounds the number of the terms of a file.
+	maxTerms = 10000
+)
+
+// Terms splits s into its lowercased words, the terms of the index.
+func Terms(s string) []string {
+	var out []string
+	for _, w := range strings.FieldsFunc(s, isSeparator) {
+		if t, ok := normalize(w); ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func normalize(w string) (string, bool) {
+	n := len([]rune(w))
+	if n < minTermLength || n > maxTermLength {
+		return "", false
+	}
+	return strings.ToLower(w), true
+}
+
+// termSet is the set of the terms of a file.
+type termSet struct {
+	terms map[string]struct{}
+}
+
+func newTermSet() *termSet {
+	return &termSet{terms: make(map[string]struct{})}
+}
+
+func (s *termSet) len() int {
+	return len(s.terms)
+}
+
+func (s *termSet) add(text string) {
+	for _, t := range Terms(text) {
+		if len(s.terms) >= maxTerms {
+			return
+		}
+		s.terms[t] = struct{}{}
+	}
+}
+
+// addText adds the words of r if it is text, according to the extension of
+// name or to its first bytes.
+func (s *termSet) addText(r io.Reader, name string) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	}
+	if !isText(name, head) {
+		return nil
+	}
+
+	sc := bufio.NewScanner(br)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	sc.Split(bufio.ScanWords)
+	for sc.Scan() && len(s.terms) < maxTerms {
+		s.add(sc.Text())
+	}
+	return sc.Err()
+}
+
+func isText(name string, head []byte) bool {
+	t := mime.TypeByExtension(path.Ext(name))
+	if t == "" {
+		if bytes.IndexByte(head, 0) >= 0 {
+			return false
+		}
+		t = http.DetectContentType(head)
+	}
+	return strings.HasPrefix(t, "text/") || strings.Contains(t, "json") ||
+		strings.Contains(t, "xml") || strings.Contains(t, "javascript")
+}
+
+func (s *termSet) list() []string {
+	out := make([]string, 0, len(s.terms))
+	for t := range s.terms {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -61,6 +61,32 @@ test_expect_success "'ipfs commands' output looks good" '
   grep "ipfs update" commands.txt
 '
 
+test_expect_success "'ipfs commands --format=json' succeeds" '
+  ipfs commands --format=json >commands.json
+'
+
+test_expect_success "'ipfs commands --format=json' output looks good" '
+  grep "\"Name\": \"ipfs\"" commands.json &&
+  grep "\"Name\": \"add\"" commands.json &&
+  test_must_fail grep "\"Tagline\"" commands.json
+'
+
+test_expect_success "'ipfs commands --format=json --verbose' succeeds" '
+  ipfs commands --format=json --verbose >commands_verbose.json
+'
+
+test_expect_success "'ipfs commands --format=json --verbose' output looks good" '
+  grep "\"Tagline\": \"Add a file or directory to ipfs.\"" commands_verbose.json &&
+  grep "\"Type\": \"file\"" commands_verbose.json &&
+  grep "\"Type\": \"bool\"" commands_verbose.json &&
+  grep "\"Default\": \"self\"" commands_verbose.json
+'
+
+test_expect_success "'ipfs commands --format' rejects unknown formats" '
+  test_must_fail ipfs commands --format=yaml 2>err &&
+  grep "unknown format" err
+'
+
 test_expect_success "All commands accept --help" '
   echo 0 > fail
   while read -r cmd
@@ -181,6 +181,55 @@ test_expect_success "clean up ipfs dir" '
   rm -rf "$IPFS_PATH"
 '
 
+test_expect_success "'ipfs init --profile=lowpower+' succeeds" '
+  BITS="1024" &&
+  ipfs init --bits="$BITS" --profile=lowpower+
+'
+
+test_expect_success "'ipfs init --profile=lowpower+' enables the light client" '
+  ipfs features ls | grep "^light-client  *experimental  *enabled" &&
+  ipfs config Reprovider.Interval > actual_config &&
+  test $(cat actual_config) = "0"
+'
+
+test_expect_success "clean up ipfs dir" '
+  rm -rf "$IPFS_PATH"
+'
+
+# test seeded identities
+test_expect_success "'ipfs init --seed' succeeds" '
+  ipfs init --seed=00112233 --empty-repo >actual_init
+'
+
+test_expect_success "'ipfs init --seed' output looks good" '
+  PEERID=$(ipfs config Identity.PeerID) &&
+  echo "initializing IPFS node at $IPFS_PATH" >expected &&
+  echo "peer identity: $PEERID (derived from the seed, INSECURE)" >>expected &&
+  test_cmp expected actual_init
+'
+
+test_expect_success "'ipfs init --seed' gives the same identity again" '
+  rm -rf "$IPFS_PATH" &&
+  ipfs init --seed=00112233 --empty-repo &&
+  test "$(ipfs config Identity.PeerID)" = "$PEERID"
+'
+
+test_expect_success "'ipfs init --seed' with another seed gives another identity" '
+  rm -rf "$IPFS_PATH" &&
+  ipfs init --seed=44556677 --empty-repo &&
+  test "$(ipfs config Identity.PeerID)" != "$PEERID"
+'
+
+test_expect_success "'ipfs init --seed' rejects invalid seeds" '
+  rm -rf "$IPFS_PATH" &&
+  test_must_fail ipfs init --seed=xyz 2>seed_err &&
+  grep "invalid seed" seed_err
+'
+
+test_expect_success "clean up ipfs dir" '
+  rm -rf "$IPFS_PATH"
+'
+
 test_init_ipfs
 
 test_launch_ipfs_daemon
@@ -0,0 +1,61 @@
+#!/usr/bin/env bash
+
+test_description="Test features command"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "ipfs features ls lists urlstore as disabled" '
+  ipfs features ls >actual &&
+  grep "^urlstore  *experimental  *disabled" actual
+'
+
+test_expect_success "ipfs features ls lists deprecated flags" '
+  grep "bootstrap-rm-all-flag  *deprecated .*will be removed in" actual
+'
+
+test_expect_success "urlstore add fails while the feature is disabled" '
+  test_must_fail ipfs urlstore add http://127.0.0.1:1/foo 2>err &&
+  grep "urlstore is not enabled" err
+'
+
+test_expect_success "ipfs features enable urlstore succeeds" '
+  ipfs features enable urlstore &&
+  echo true >expected &&
+  ipfs config Experimental.UrlstoreEnabled >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "ipfs features ls lists urlstore as enabled" '
+  ipfs features ls | grep "^urlstore  *experimental  *enabled"
+'
+
+test_expect_success "ipfs features disable urlstore succeeds" '
+  ipfs features disable urlstore &&
+  echo false >expected &&
+  ipfs config Experimental.UrlstoreEnabled >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "enabling an unknown feature fails" '
+  test_must_fail ipfs features enable no-such-feature
+'
+
+test_expect_success "deprecated features can not be toggled" '
+  test_must_fail ipfs features disable bootstrap-rm-all-flag
+'
+
+test_expect_success "ungated features are listed as enabled" '
+  ipfs features ls | grep "^raw-leaves  *experimental  *enabled" &&
+  test_must_fail ipfs features disable raw-leaves
+'
+
+test_expect_success "the help flags the options of the features" '
+  ipfs add --help >help &&
+  grep -- "--raw-leaves .*(experimental)" help &&
+  ipfs bootstrap add --help >help &&
+  grep -- "--default .*(deprecated, will be removed in v0.5.0" help
+'
+
+test_done
@@ -0,0 +1,56 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the commands run on an ephemeral node"
+
+. lib/test-lib.sh
+
+test_expect_success "'ipfs --ephemeral add' works without a repo" '
+  echo "Hello Worlds!" > hello.txt &&
+  ipfs --ephemeral add -q hello.txt > actual &&
+  echo QmVr26fY1tKyspEJBniVhqxQeEjhF78XerGiqWAwraVLQH > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the repo isn't created" '
+  test_path_is_missing "$IPFS_PATH"
+'
+
+test_expect_success "'ipfs --ephemeral init' and 'daemon' fail" '
+  test_must_fail ipfs --ephemeral init 2> err &&
+  grep "can.t run with --ephemeral" err &&
+  test_must_fail ipfs --ephemeral daemon 2> err &&
+  grep "can.t run with --ephemeral" err
+'
+
+test_init_ipfs
+
+test_expect_success "'ipfs --ephemeral' doesn't use the repo" '
+  HASH=$(echo "ephemeral content" | ipfs --ephemeral add -q) &&
+  ipfs refs local > refs &&
+  test_must_fail grep $HASH refs &&
+  ipfs --ephemeral id -f="<id>" > ephemeral_id &&
+  ipfs id -f="<id>" > repo_id &&
+  test_must_fail test_cmp repo_id ephemeral_id
+'
+
+test_expect_success "'ipfs --ephemeral config' doesn't edit the repo" '
+  test_must_fail ipfs --ephemeral config Datastore.StorageMax 1GB &&
+  ipfs config Datastore.StorageMax > actual &&
+  echo 10GB > expected &&
+  test_cmp expected actual
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "'ipfs --ephemeral' doesn't use the daemon" '
+  HASH=$(echo "ephemeral content" | ipfs --ephemeral add -q) &&
+  ipfs refs local > refs &&
+  test_must_fail grep $HASH refs
+'
+
+test_kill_ipfs_daemon
+
+test_done
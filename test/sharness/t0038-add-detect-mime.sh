@@ -0,0 +1,75 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs add --detect-mime"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create files to add" '
+  mkdir -p site &&
+  echo "<!DOCTYPE html><p>hello</p>" > site/index.html &&
+  echo "body {}" > site/style.css &&
+  { printf "\211PNG\r\n\032\n" && random 1000 1; } > site/logo
+'
+
+test_add_detect_mime() {
+  test_expect_success "add a directory with --detect-mime" '
+    SITE=$(ipfs add -Q -r --detect-mime site)
+  '
+
+  test_expect_success "the files are wrapped in metadata nodes" '
+    test "$SITE" != "$(ipfs add -Q -r site)" &&
+    ipfs cat "$SITE/index.html" > actual &&
+    test_cmp site/index.html actual
+  '
+
+  test_expect_success "ls reports the recorded MIME types" '
+    ipfs ls --enc=json "$SITE" > ls_out &&
+    grep "\"Name\":\"logo\",[^}]*\"MimeType\":\"image/png\"" ls_out &&
+    grep "\"Name\":\"index.html\",[^}]*\"MimeType\":\"text/html; charset=utf-8\"" ls_out
+  '
+
+  test_expect_success "ls reports the wrapped files as files" '
+    ipfs ls "$SITE" > ls_out &&
+    ! grep "logo/" ls_out
+  '
+
+  test_expect_success "--detect-mime can't be used with --to-files" '
+    test_must_fail ipfs add -r --detect-mime --to-files=/site site 2> err &&
+    grep "can.t be used with" err
+  '
+
+  test_expect_success "--detect-mime can't be used with --deterministic" '
+    test_must_fail ipfs add -r --detect-mime --deterministic site 2> err &&
+    grep "can.t be used with" err
+  '
+}
+
+test_add_detect_mime
+
+test_launch_ipfs_daemon
+
+test_add_detect_mime
+
+test_expect_success "the gateway serves the recorded Content-Type" '
+  curl -sI "http://127.0.0.1:$GWAY_PORT/ipfs/$SITE/logo" > headers &&
+  grep -i "Content-Type: image/png" headers
+'
+
+test_expect_success "the recorded Content-Type wins over the file name" '
+  curl -sI "http://127.0.0.1:$GWAY_PORT/ipfs/$SITE/logo?filename=logo.txt" > headers &&
+  grep -i "Content-Type: image/png" headers
+'
+
+test_expect_success "the gateway serves the recorded Content-Type of index.html" '
+  curl -sI "http://127.0.0.1:$GWAY_PORT/ipfs/$SITE/" > headers &&
+  grep -i "Content-Type: text/html" headers
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,85 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs add --deterministic"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create files to add" '
+  mkdir planets &&
+  echo "Hello Mars!" > planets/mars.txt &&
+  echo "Hello Venus!" > planets/venus.txt &&
+  random 5242880 41 > bigfile
+'
+
+test_add_deterministic() {
+  test_expect_success "the cidv0-1 profile gives the CIDs of the defaults" '
+    ipfs add -r --deterministic --profile=cidv0-1 planets > actual &&
+    echo "added QmPrrHqJzto9m7SyiRzarwkqPcCSsKR2EB1AyqJfe8L8tN planets/mars.txt" > expected &&
+    echo "added QmU5kp3BH3B8tnWUU2Pikdb2maksBNkb92FHRr56hyghh4 planets/venus.txt" >> expected &&
+    echo "added QmWSgS32xQEcXMeqd3YPJLrNBLSdsfYCep2U7CFkyrjXwY planets" >> expected &&
+    echo "profile cidv0-1" >> expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "the cidv0-1 profile chunks large files" '
+    ipfs add -q --deterministic --profile=cidv0-1 bigfile > actual &&
+    echo QmSr7FqYkxYWGoSfy8ZiaMWQ5vosb18DQGCzjwEQnVHkTb > expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "the default profile is cidv1-1" '
+    ipfs add -r --deterministic planets > actual &&
+    echo "added zb2rhZdTkQNawVajsTNiYc9cTPHqgLdJVvBRkZok9RjkgQYRU planets/mars.txt" > expected &&
+    echo "added zb2rhn6TGvnUaMAg4VV4y9HVx5W42HihcH4jsyrDv8mkepFqq planets/venus.txt" >> expected &&
+    echo "added zdj7Wnbun6P41Z5ddTkNvZaDTmQ8ZLdiKFcJrL9sV87rPScMP planets" >> expected &&
+    echo "profile cidv1-1" >> expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "the profile ignores the Import config" '
+    ipfs add -q --deterministic bigfile > expected &&
+    ipfs config Import.Chunker buzhash &&
+    ipfs config Import.HashFunction sha2-512 &&
+    ipfs add -q --deterministic bigfile > actual &&
+    ipfs config --json Import {} &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "options set by the profile are rejected" '
+    for opt in --chunker=size-1024 --trickle --layout=trickle --cid-version=1 \
+        --hash=sha2-512 --raw-leaves --inline --preserve-mtime; do
+      test_must_fail ipfs add --deterministic $opt planets/mars.txt 2> err &&
+      grep "can.t be used with --deterministic" err || return 1
+    done
+  '
+
+  test_expect_success "an unknown profile is rejected" '
+    test_must_fail ipfs add --deterministic --profile=cidv9-1 planets/mars.txt 2> err &&
+    grep "unknown profile" err
+  '
+
+  test_expect_success "--profile requires --deterministic" '
+    test_must_fail ipfs add --profile=cidv1-1 planets/mars.txt 2> err &&
+    grep "requires --deterministic" err
+  '
+}
+
+test_add_deterministic
+
+test_launch_ipfs_daemon
+
+test_add_deterministic
+
+test_expect_success "the profile is reported by the API" '
+  curl -sf -X POST -F file=@planets/mars.txt "http://$API_ADDR/api/v0/add?deterministic=true" > json &&
+  grep "\"Profile\":\"cidv1-1\"" json
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -164,6 +164,14 @@ test_add_cat_file() {
     test_expect_code 1 ipfs add -Q --chunker rabin-12-512-1024 mountdir/hello.txt
   '
 
+  test_expect_success "ipfs add --chunker buzhash succeeds" '
+    ipfs add -Q --chunker buzhash mountdir/hello.txt
+  '
+
+  test_expect_success "ipfs add --chunker buzhash-16-512-1024 failed" '
+    test_expect_code 1 ipfs add -Q --chunker buzhash-16-512-1024 mountdir/hello.txt
+  '
+
 
   test_expect_success "ipfs add on hidden file succeeds" '
     echo "Hello Worlds!" >mountdir/.hello.txt &&
@@ -650,7 +658,7 @@ test_add_cat_expensive "--cid-version=1" "zdj7WcatQrtuE4WMkS4XsfsMixuQN2po4irkYh
 # encoded with the blake2b-256 hash funtion
 test_add_cat_expensive '--hash=blake2b-256' "zDMZof1kwndounDzQCANUHjiE3zt1mPEgx7RE3JTHoZrRRa79xcv"
 
-test_add_named_pipe " Post http://$API_ADDR/api/v0/add?chunker=size-262144&encoding=json&hash=sha2-256&inline-limit=32&pin=true&progress=true&recursive=true&stream-channels=true:"
+test_add_named_pipe " Post http://$API_ADDR/api/v0/add?encoding=json&inline-limit=32&pin=true&progress=true&recursive=true&store=true&stream-channels=true:"
 
 test_add_pwd_is_symlink
 
@@ -683,6 +691,124 @@ test_add_named_pipe ""
 
 test_add_pwd_is_symlink
 
+test_expect_success "ipfs add uses Import.HashFunction by default" '
+  echo "hash agility" > agile.txt &&
+  ipfs add -q --hash=sha3-256 agile.txt > expected &&
+  ipfs config Import.HashFunction sha3-256 &&
+  ipfs add -q agile.txt > actual &&
+  ipfs config --json Import {} &&
+  test_cmp expected actual
+'
+
+test_expect_success "content hashed with sha3-256 can be read back" '
+  ipfs cat $(cat actual) > agile_out &&
+  test_cmp agile.txt agile_out &&
+  test "$(cid-fmt %h $(cat actual))" = sha3-256
+'
+
+test_expect_success "--hash overrides Import.HashFunction" '
+  ipfs config Import.HashFunction blake2b-256 &&
+  ipfs add -q --hash=sha2-256 agile.txt > actual &&
+  ipfs config --json Import {} &&
+  test "$(cid-fmt %h $(cat actual))" = sha2-256
+'
+
+test_expect_success "ipfs add rejects insecure hash functions" '
+  test_must_fail ipfs add --hash=sha1 agile.txt 2> err &&
+  grep "sha1 can.t be used" err
+'
+
+test_expect_success "ipfs add rejects unknown hash functions" '
+  test_must_fail ipfs add --hash=nope agile.txt 2> err &&
+  grep "unrecognized hash function: nope" err
+'
+
+test_expect_success "ipfs add rejects an invalid Import.HashFunction" '
+  ipfs config Import.HashFunction nope &&
+  test_must_fail ipfs add agile.txt 2> err &&
+  ipfs config --json Import {} &&
+  grep "Import.HashFunction" err
+'
+
+test_expect_success "ipfs add --chunker=buzhash round-trips a large file" '
+  random 5000000 42 > bigbuz &&
+  BUZHASH=$(ipfs add -q --chunker=buzhash bigbuz) &&
+  ipfs cat $BUZHASH > bigbuz_out &&
+  test_cmp bigbuz bigbuz_out
+'
+
+test_expect_success "buzhash chunks are content defined" '
+  ipfs refs $BUZHASH > refs &&
+  test $(wc -l < refs) -gt 1 &&
+  test "$BUZHASH" != "$(ipfs add -q -n bigbuz)"
+'
+
+test_expect_success "ipfs add uses Import.Chunker by default" '
+  ipfs config Import.Chunker buzhash &&
+  ipfs add -q bigbuz > actual &&
+  ipfs config --json Import {} &&
+  echo $BUZHASH > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "--chunker overrides Import.Chunker" '
+  ipfs config Import.Chunker buzhash &&
+  ipfs add -q --chunker=size-262144 bigbuz > actual &&
+  ipfs config --json Import {} &&
+  ipfs add -q bigbuz > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "ipfs add rejects an invalid Import.Chunker" '
+  ipfs config Import.Chunker buzhash-1 &&
+  test_must_fail ipfs add bigbuz 2> err &&
+  ipfs config --json Import {} &&
+  grep "Import.Chunker" err
+'
+
+test_expect_success "ipfs add uses Import.CidVersion by default" '
+  ipfs add -q --cid-version=1 agile.txt > expected &&
+  ipfs config --json Import.CidVersion 1 &&
+  ipfs add -q agile.txt > actual &&
+  ipfs config --json Import {} &&
+  test_cmp expected actual &&
+  test "$(cid-fmt %v-%c $(cat actual))" = "cidv1-raw"
+'
+
+test_expect_success "--cid-version and --raw-leaves override the Import config" '
+  ipfs config --json Import.CidVersion 1 &&
+  ipfs config --json Import.RawLeaves false &&
+  ipfs add -q --cid-version=0 agile.txt > actual_v0 &&
+  ipfs add -q --raw-leaves agile.txt > actual_raw &&
+  ipfs add -q agile.txt > actual_noraw &&
+  ipfs config --json Import {} &&
+  ipfs add -q agile.txt > expected_v0 &&
+  test_cmp expected_v0 actual_v0 &&
+  test "$(cid-fmt %v-%c $(cat actual_raw))" = "cidv1-raw" &&
+  test "$(cid-fmt %v-%c $(cat actual_noraw))" = "cidv1-protobuf"
+'
+
+test_expect_success "ipfs add rejects an invalid Import.CidVersion" '
+  ipfs config --json Import.CidVersion 2 &&
+  test_must_fail ipfs add agile.txt 2> err &&
+  ipfs config --json Import {} &&
+  grep "invalid Import.CidVersion" err
+'
+
+test_expect_success "ipfs files and ipfs object use the Import config" '
+  ipfs config --json Import.CidVersion 1 &&
+  ipfs files mkdir /import-config &&
+  ipfs files write --create /import-config/agile.txt < agile.txt &&
+  ipfs object new unixfs-dir > actual_obj &&
+  ipfs config --json Import {} &&
+  test "$(cid-fmt %v-%c $(ipfs files stat --hash /import-config))" = "cidv1-protobuf" &&
+  test "$(cid-fmt %v $(ipfs files stat --hash /import-config/agile.txt))" = cidv1 &&
+  ipfs files read /import-config/agile.txt > actual &&
+  test_cmp agile.txt actual &&
+  test "$(cid-fmt %v-%c $(cat actual_obj))" = "cidv1-protobuf" &&
+  ipfs files rm -r /import-config
+'
+
 # Test daemon in offline mode
 test_launch_ipfs_daemon --offline
 
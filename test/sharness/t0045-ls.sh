@@ -112,15 +112,73 @@ test_ls_object() {
   '
 }
 
+# the daemon doesn't get the metadata of the files sent to it, add them offline
+test_expect_success "'ipfs add --preserve-mode --preserve-mtime' succeeds" '
+  mkdir -p metaData &&
+  echo "with metadata" > metaData/meta &&
+  chmod 0640 metaData/meta &&
+  TZ=UTC touch -t 201901010000.00 metaData/meta &&
+  META_DIR=$(ipfs add -r -Q --preserve-mode --preserve-mtime metaData) &&
+  echo "without metadata" > nometa &&
+  NOMETA=$(ipfs add -Q nometa) &&
+  ipfs files mkdir -p /lslong &&
+  ipfs files cp /ipfs/$NOMETA /lslong/nometa &&
+  NOMETA_DIR=$(ipfs files stat --hash /lslong) &&
+  ipfs files rm -r /lslong
+'
+
+test_expect_success "the metadata is stored in the node of the file" '
+  test "$(ipfs add -Q metaData/meta)" != "$(ipfs add -Q --preserve-mode --preserve-mtime metaData/meta)"
+'
+
+test_ls_long() {
+  test_expect_success "'ipfs ls -l' lists the recorded metadata" '
+    ipfs ls -l $META_DIR > ls_long &&
+    grep "^-rw-r----- *2019-01-01T00:00:00Z .* meta$" ls_long
+  '
+
+  test_expect_success "'ipfs ls -l' shows - without metadata" '
+    ipfs ls -l -v $NOMETA_DIR > ls_long &&
+    grep "^Mode *Mtime *Hash *Size *Name$" ls_long &&
+    grep "^- *- *$NOMETA .* nometa$" ls_long
+  '
+
+  test_expect_success "'ipfs ls' doesn't list the metadata without -l" '
+    ipfs ls --enc=json $META_DIR > ls_json &&
+    test_must_fail grep "Mtime" ls_json &&
+    ipfs ls -l --enc=json $META_DIR > ls_json &&
+    grep "\"Mtime\":1546300800" ls_json
+  '
+
+  test_expect_success "'ipfs files stat --format' supports <mode> and <mtime>" '
+    ipfs files stat --format="<mode> <mtime>" /ipfs/$META_DIR/meta > stat_out &&
+    echo "0640 2019-01-01T00:00:00Z" > stat_exp &&
+    test_cmp stat_exp stat_out
+  '
+}
+
 # should work offline
 test_ls_cmd
 test_ls_cmd_raw_leaves
 test_ls_object
+test_ls_long
 
 # should work online
 test_launch_ipfs_daemon
 test_ls_cmd
 test_ls_cmd_raw_leaves
+test_ls_long
+
+test_expect_success "the gateway lists the recorded metadata" '
+  curl -s "http://$GWAY_ADDR/ipfs/$META_DIR/" > listing &&
+  grep "<td class=\"mode\">-rw-r-----</td><td class=\"mtime\">2019-01-01T00:00:00Z</td>" listing
+'
+
+test_expect_success "the gateway doesn't add metadata columns without metadata" '
+  curl -s "http://$GWAY_ADDR/ipfs/$NOMETA_DIR/" > listing &&
+  grep "nometa" listing &&
+  test_must_fail grep "class=\"mode\"" listing
+'
 test_kill_ipfs_daemon
 test_ls_object
 
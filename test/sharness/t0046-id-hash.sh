@@ -78,6 +78,37 @@ test_expect_success "ipfs add --inline --raw-leaves outputs the correct hash on
   test "$HASH0" = "$HASH"
 '
 
+test_expect_success "ipfs add --inline of a directory is deterministic" '
+  mkdir -p inlinedir/sub &&
+  echo "tiny" > inlinedir/tiny &&
+  echo "small" > inlinedir/sub/small &&
+  cp 1000bytes inlinedir/sub/ &&
+  ipfs add -q -r -n --inline --raw-leaves inlinedir > expected &&
+  ipfs add -q -r --inline --raw-leaves inlinedir > actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "small files of an inlined directory use the id multihash" '
+  DIR_HASH=$(tail -n1 actual) &&
+  ipfs ls $DIR_HASH/sub | grep small | cut -d" " -f1 > small_hash &&
+  test "$(cid-fmt %h $(cat small_hash))" = id &&
+  ipfs ls $DIR_HASH/sub | grep 1000bytes | cut -d" " -f1 > big_hash &&
+  test "$(cid-fmt %h $(cat big_hash))" = sha2-256
+'
+
+test_expect_success "inlined files are not stored in the repo" '
+  ipfs refs local > refs &&
+  test_must_fail grep "$(cat small_hash)" refs &&
+  ipfs cat $DIR_HASH/sub/small > actual &&
+  test_cmp inlinedir/sub/small actual
+'
+
+test_expect_success "ipfs add --inline-limit must be in range" '
+  test_must_fail ipfs add --inline --inline-limit=0 afile 2> err &&
+  grep "inline-limit" err &&
+  test_must_fail ipfs add --inline --inline-limit=128 afile
+'
+
 test_expect_success "enable filestore" '
   ipfs config --json Experimental.FilestoreEnabled true
 '
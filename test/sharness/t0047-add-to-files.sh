@@ -0,0 +1,91 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs add --to-files"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create files to add" '
+  mkdir -p mydir/sub &&
+  echo "hello" > mydir/hello.txt &&
+  echo "world" > mydir/sub/world.txt &&
+  echo "foo" > foo.txt &&
+  echo "bar" > bar.txt
+'
+
+test_add_to_files() {
+  test_expect_success "add a file to mfs with a new name" '
+    HASH=$(ipfs add -q --to-files=/renamed.txt foo.txt) &&
+    ipfs files stat --hash /renamed.txt > actual &&
+    echo "$HASH" > expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "add a file into an mfs directory" '
+    ipfs add -q --to-files=/into/a/dir/ foo.txt &&
+    ipfs files read /into/a/dir/foo.txt > actual &&
+    test_cmp foo.txt actual
+  '
+
+  test_expect_success "add a directory to mfs" '
+    HASH=$(ipfs add -q -r --to-files=/dirs/ mydir | tail -n1) &&
+    ipfs files stat --hash /dirs/mydir > actual &&
+    echo "$HASH" > expected &&
+    test_cmp expected actual &&
+    ipfs files read /dirs/mydir/sub/world.txt > actual &&
+    test_cmp mydir/sub/world.txt actual
+  '
+
+  test_expect_success "add several files into an mfs directory" '
+    ipfs add -q --to-files=/several/ foo.txt bar.txt &&
+    ipfs files ls /several > actual &&
+    printf "bar.txt\nfoo.txt\n" > expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "adding several files requires a directory path" '
+    test_must_fail ipfs add --to-files=/several-file foo.txt bar.txt 2> err &&
+    grep "ending with" err
+  '
+
+  test_expect_success "add a wrapped directory to mfs" '
+    HASH=$(ipfs add -q -w --to-files=/wrapped foo.txt bar.txt | tail -n1) &&
+    ipfs files stat --hash /wrapped > actual &&
+    echo "$HASH" > expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "adding to an existing mfs path fails" '
+    test_must_fail ipfs add --to-files=/renamed.txt bar.txt
+  '
+
+  test_expect_success "--to-files can't be used with --only-hash" '
+    test_must_fail ipfs add -n --to-files=/hashed.txt foo.txt 2> err &&
+    grep "only-hash" err
+  '
+
+  test_expect_success "unpinned content added to mfs survives gc" '
+    HASH=$(ipfs add -q --pin=false --to-files=/unpinned.txt bar.txt) &&
+    ipfs repo gc &&
+    ipfs cat "$HASH" > actual &&
+    test_cmp bar.txt actual
+  '
+
+  test_expect_success "clean up mfs" '
+    ipfs files ls / | while read name; do ipfs files rm -r "/$name" || exit 1; done
+  '
+}
+
+test_add_to_files
+
+test_launch_ipfs_daemon
+
+test_add_to_files
+
+test_kill_ipfs_daemon
+
+test_done
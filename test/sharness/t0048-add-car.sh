@@ -0,0 +1,80 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs add --car-output"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create files to add" '
+  mkdir -p mydir/sub &&
+  echo "hello" > mydir/hello.txt &&
+  echo "world" > mydir/sub/world.txt &&
+  random 1000000 42 > mydir/big
+'
+
+test_add_car() {
+  test_expect_success "add a directory with --car-output" '
+    HASH=$(ipfs add -q -r --car-output=stored.car mydir | tail -n1) &&
+    test -s stored.car &&
+    ipfs refs local > refs &&
+    grep "$HASH" refs
+  '
+
+  test_expect_success "the CAR file holds the content of the files" '
+    test $(wc -c < stored.car) -gt 1000000 &&
+    grep -a "world" stored.car
+  '
+
+  test_expect_success "add with --store=false doesn't touch the repo" '
+    echo "not stored $1" > unstored.txt &&
+    UNSTORED=$(ipfs add -q --store=false --car-output=unstored.car unstored.txt) &&
+    test -s unstored.car &&
+    ipfs refs local > refs &&
+    test_must_fail grep "$UNSTORED" refs
+  '
+
+  test_expect_success "--store=false gives the same hash as a normal add" '
+    ipfs add -q -n unstored.txt > expected &&
+    echo "$UNSTORED" > actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "--store=false requires --car-output" '
+    test_must_fail ipfs add --store=false unstored.txt 2> err &&
+    grep "car-output" err
+  '
+
+  test_expect_success "--store=false leaves no spool file behind" '
+    ls -a > files &&
+    test_must_fail grep car-spool files
+  '
+
+  test_expect_success "--car-output can't be used with --only-hash" '
+    test_must_fail ipfs add -n --car-output=hashed.car unstored.txt 2> err &&
+    grep "only-hash" err &&
+    test ! -e hashed.car
+  '
+
+  test_expect_success "a failed add doesn't leave a CAR file behind" '
+    test_must_fail ipfs add --car-output=missing.car does-not-exist &&
+    test ! -e missing.car
+  '
+
+  test_expect_success "clean up" '
+    rm -f stored.car unstored.car
+  '
+}
+
+test_add_car offline
+
+test_launch_ipfs_daemon
+
+test_add_car online
+
+test_kill_ipfs_daemon
+
+test_done
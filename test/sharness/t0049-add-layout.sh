@@ -0,0 +1,111 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs add --layout and --manifest"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create files to add" '
+  mkdir -p media/clips &&
+  random 2000000 1 > media/movie.mp4 &&
+  random 2000000 2 > media/clips/clip.mp4 &&
+  random 2000000 3 > media/data.bin
+'
+
+test_add_layout() {
+  test_expect_success "--layout=trickle is the same as --trickle" '
+    ipfs add -q -t media/movie.mp4 > expected &&
+    ipfs add -q --layout=trickle media/movie.mp4 > actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "--layout=balanced is the default layout" '
+    ipfs add -q media/movie.mp4 > expected &&
+    ipfs add -q --layout=balanced media/movie.mp4 > actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "layouts give different DAGs" '
+    test "$(cat expected)" != "$(ipfs add -q -t media/movie.mp4)"
+  '
+
+  test_expect_success "an unknown layout is rejected" '
+    test_must_fail ipfs add --layout=spiral media/movie.mp4 2> err &&
+    grep "unknown layout" err
+  '
+
+  test_expect_success "--trickle conflicts with --layout=balanced" '
+    test_must_fail ipfs add -t --layout=balanced media/movie.mp4 2> err &&
+    grep "layout" err
+  '
+
+  test_expect_success "create a manifest" '
+    cat > manifest.json <<-\EOF
+	{
+	  "Rules": [
+	    {"Pattern": "*.mp4", "Layout": "trickle"},
+	    {"Pattern": "media/clips/*", "Chunker": "size-1024"}
+	  ]
+	}
+	EOF
+  '
+
+  test_expect_success "add a directory with --manifest" '
+    ipfs add -r --manifest=manifest.json media > added
+  '
+
+  test_expect_success "the manifest sets the layout of matching files" '
+    ipfs add -q -t media/movie.mp4 > expected &&
+    grep "media/movie.mp4" added | cut -d" " -f2 > actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "the manifest rules are combined" '
+    ipfs add -q -t --chunker=size-1024 media/clips/clip.mp4 > expected &&
+    grep "media/clips/clip.mp4" added | cut -d" " -f2 > actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "files matching no rule use the options" '
+    ipfs add -q media/data.bin > expected &&
+    grep "media/data.bin" added | cut -d" " -f2 > actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "an invalid manifest is rejected" '
+    echo "{\"Rules\": [{\"Pattern\": \"*\", \"Layout\": \"spiral\"}]}" > bad.json &&
+    test_must_fail ipfs add --manifest=bad.json media/movie.mp4 2> err &&
+    grep "invalid manifest" err
+  '
+
+  test_expect_success "a missing manifest is rejected" '
+    test_must_fail ipfs add --manifest=missing.json media/movie.mp4
+  '
+}
+
+test_add_layout
+
+test_launch_ipfs_daemon
+
+test_add_layout
+
+test_expect_success "the HTTP API takes the content of the manifest" '
+  ipfs add -q -t media/movie.mp4 > expected &&
+  # {"Rules":[{"Pattern":"*.mp4","Layout":"trickle"}]}
+  MANIFEST="%7B%22Rules%22%3A%5B%7B%22Pattern%22%3A%22*.mp4%22%2C%22Layout%22%3A%22trickle%22%7D%5D%7D" &&
+  curl -sf -X POST -F file=@media/movie.mp4 "http://$API_ADDR/api/v0/add?manifest=$MANIFEST" > json &&
+  grep "$(cat expected)" json
+'
+
+test_expect_success "the HTTP API doesn'"'"'t read manifests from the node" '
+  curl -s -X POST -F file=@media/movie.mp4 "http://$API_ADDR/api/v0/add?manifest=$(pwd)/manifest.json" > json &&
+  grep "invalid manifest" json
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -221,4 +221,116 @@ test_expect_success "put with sha3 and cidv0 fails" '
   echo "foooo" | test_must_fail ipfs block put --mhtype=sha3 --mhlen=20 --format=v0
 '
 
+test_expect_success "block put uses Import.HashFunction by default" '
+  echo "foooo" | ipfs block put --mhtype=sha3-256 > expected &&
+  ipfs config Import.HashFunction sha3-256 &&
+  echo "foooo" | ipfs block put > actual &&
+  ipfs config --json Import {} &&
+  test_cmp expected actual
+'
+
+test_expect_success "--mhtype overrides Import.HashFunction" '
+  echo "foooo" | ipfs block put > expected &&
+  ipfs config Import.HashFunction sha3-256 &&
+  echo "foooo" | ipfs block put --mhtype=sha2-256 > actual &&
+  ipfs config --json Import {} &&
+  test_cmp expected actual
+'
+
+test_expect_success "block put rejects an invalid Import.HashFunction" '
+  ipfs config Import.HashFunction sha1 &&
+  echo "foooo" | test_must_fail ipfs block put 2> err &&
+  ipfs config --json Import {} &&
+  grep "Import.HashFunction" err
+'
+
+#
+# "block put --batch" tests
+#
+
+test_expect_success "create blocks to put in batch" '
+  echo "batch block 1" > batch1 &&
+  echo "batch block 2" > batch2 &&
+  echo "batch block 3" > batch3 &&
+  for f in batch1 batch2 batch3; do
+    ipfs block put --mhtype=sha2-512 $f || return 1
+  done > expected
+'
+
+test_expect_success "'ipfs block put --batch' gives the keys of single puts" '
+  ipfs block put --batch --mhtype=sha2-512 batch1 batch2 batch3 > actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "the batched blocks can be read back" '
+  ipfs block get $(sed -n 2p actual) > batch2_out &&
+  test_cmp batch2 batch2_out
+'
+
+test_expect_success "several inputs without --batch fail" '
+  test_must_fail ipfs block put batch1 batch2 2> err &&
+  grep "use --batch" err
+'
+
+test_expect_success "'ipfs block put --length-prefixed' reads several blocks" '
+  printf "\014Hello Mars!\n\006foooo\n" | ipfs block put --length-prefixed > actual &&
+  echo "Hello Mars!" | ipfs block put > expected &&
+  echo "foooo" | ipfs block put >> expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "a truncated length-prefixed block fails" '
+  printf "\020abc" | test_must_fail ipfs block put --length-prefixed 2> err &&
+  grep "unexpected EOF" err
+'
+
+test_expect_success "an oversized length-prefixed block fails" '
+  printf "\377\377\377\377\017" | test_must_fail ipfs block put --length-prefixed 2> err &&
+  grep "larger than" err
+'
+
+#
+# pin-aware "block rm" tests
+#
+
+test_expect_success "create pinned, mfs and unpinned blocks" '
+  PINNED=$(echo "pinned block" | ipfs add -q) &&
+  MFSBLOCK=$(echo "mfs block" | ipfs add -q) &&
+  ipfs files cp /ipfs/$MFSBLOCK /mfsblock &&
+  UNPINNED1=$(echo "unpinned block 1" | ipfs block put) &&
+  UNPINNED2=$(echo "unpinned block 2" | ipfs block put) &&
+  ipfs pin rm $MFSBLOCK
+'
+
+test_expect_success "blocks of the files API root can't be removed" '
+  test_must_fail ipfs block rm $MFSBLOCK 2> err &&
+  grep "cannot remove $MFSBLOCK: referenced by the files API root" err &&
+  ipfs block stat $MFSBLOCK
+'
+
+test_expect_success "'ipfs block rm --force-unpinned-only' reads stdin" '
+  printf "%s\n" $PINNED $UNPINNED1 $MFSBLOCK $UNPINNED2 $RANDOMHASH |
+    ipfs block rm --force-unpinned-only > actual
+'
+
+test_expect_success "'ipfs block rm --force-unpinned-only' reports each block" '
+  grep "^skipped $PINNED: pinned" actual &&
+  grep "^skipped $MFSBLOCK: referenced by the files API root" actual &&
+  grep "^removed $UNPINNED1" actual &&
+  grep "^removed $UNPINNED2" actual &&
+  test $(wc -l < actual) -eq 4
+'
+
+test_expect_success "only the unpinned blocks were removed" '
+  ipfs block stat $PINNED &&
+  ipfs block stat $MFSBLOCK &&
+  test_must_fail ipfs block stat $UNPINNED1 &&
+  test_must_fail ipfs block stat $UNPINNED2
+'
+
+test_expect_success "'ipfs block rm --force-unpinned-only -q' produces no output" '
+  ipfs block rm --force-unpinned-only -q $PINNED > actual &&
+  test ! -s actual
+'
+
 test_done
@@ -142,6 +142,21 @@ test_dag_cmd() {
     test $EXPHASH = $IPLDHASH
   '
 
+  test_expect_success "dag put uses Import.HashFunction by default" '
+    ipfs config Import.HashFunction sha3 &&
+    CFGHASH=$(cat ipld_object | ipfs dag put) &&
+    ipfs config --json Import {} &&
+    test $IPLDHASH = $CFGHASH
+  '
+
+  test_expect_success "--hash overrides Import.HashFunction in dag put" '
+    ipfs config Import.HashFunction sha3 &&
+    cat ipld_object | ipfs dag put --hash sha2-256 > cfg_out &&
+    ipfs config --json Import {} &&
+    cat ipld_object | ipfs dag put > cfg_exp &&
+    test_cmp cfg_exp cfg_out
+  '
+
   test_expect_success "prepare dag-pb object" '
     echo foo > test_file &&
     HASH=$(ipfs add -wq test_file | tail -n1)
@@ -206,6 +221,73 @@ test_dag_cmd() {
     test_cmp resolve_obj_exp resolve_obj &&
     test_cmp resolve_data_exp resolve_data
   '
+
+  test_expect_success "dag put reads several json documents" '
+    printf "{\"a\":1}\n{\"b\":2}" | ipfs dag put --input-codec=dag-json --store-codec=dag-cbor > multi_out &&
+    echo "{\"a\":1}" | ipfs dag put > multi_exp &&
+    echo "{\"b\":2}" | ipfs dag put >> multi_exp &&
+    test_cmp multi_exp multi_out
+  '
+
+  test_expect_success "dag put reports the stored codec" '
+    echo "{\"a\":1}" | ipfs dag put --enc=json > codec_out &&
+    grep "\"Codec\":\"dag-cbor\"" codec_out &&
+    echo "{\"data\":\"CAISB2Zvb2JhcgoYBw==\",\"links\":[]}" |
+      ipfs dag put --store-codec=dag-pb --enc=json > codec_out &&
+    grep "\"Codec\":\"dag-pb\"" codec_out
+  '
+
+  test_expect_success "dag put can store raw blocks" '
+    echo "raw leaf" > raw_leaf &&
+    ipfs dag put --input-codec=raw --store-codec=raw raw_leaf > raw_out &&
+    ipfs block put --format=raw raw_leaf > raw_exp &&
+    test_cmp raw_exp raw_out
+  '
+
+  test_expect_success "dag put can store a cbor input as is" '
+    RAWHASH=$(ipfs dag put --input-codec=dag-cbor --store-codec=raw ../t0053-dag-data/non-canon.cbor) &&
+    ipfs block get $RAWHASH > non_canon_out &&
+    test_cmp ../t0053-dag-data/non-canon.cbor non_canon_out
+  '
+
+  test_expect_success "dag put rejects unsupported codec combinations" '
+    test_must_fail ipfs dag put --input-codec=dag-cbor --store-codec=dag-pb ../t0053-dag-data/non-canon.cbor 2> codec_err &&
+    grep "no parser for format" codec_err
+  '
+
+  test_expect_success "create a dag with a duplicated block" '
+    mkdir -p dupdir &&
+    echo "same content" > dupdir/a &&
+    echo "same content" > dupdir/b &&
+    DUPDIR=$(ipfs add -r -Q dupdir) &&
+    DUPFILE=$(ipfs add -Q dupdir/a) &&
+    DIRSIZE=$(ipfs block stat $DUPDIR | sed -n "s/^Size: //p") &&
+    FILESIZE=$(ipfs block stat $DUPFILE | sed -n "s/^Size: //p")
+  '
+
+  test_expect_success "dag stat works" '
+    ipfs dag stat $DUPDIR > stat_out
+  '
+
+  test_expect_success "dag stat output looks good" '
+    echo "Cid: $DUPDIR" > stat_exp &&
+    echo "Size: $((DIRSIZE + 2 * FILESIZE))" >> stat_exp &&
+    echo "DedupSize: $((DIRSIZE + FILESIZE))" >> stat_exp &&
+    echo "NumBlocks: 2" >> stat_exp &&
+    echo "Depth: 1" >> stat_exp &&
+    test_cmp stat_exp stat_out
+  '
+
+  test_expect_success "dag stat --progress works" '
+    ipfs dag stat --progress $DUPDIR > stat_out &&
+    test_cmp stat_exp stat_out
+  '
+
+  test_expect_success "dag stat of a single block" '
+    ipfs dag stat $DUPFILE > stat_out &&
+    grep "NumBlocks: 1" stat_out &&
+    grep "Depth: 0" stat_out
+  '
 }
 
 # should work offline
@@ -0,0 +1,90 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the resumable uploads"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create a file in two parts" '
+  random 300000 42 > file &&
+  head -c 100000 file > part1 &&
+  tail -c 200000 file > part2 &&
+  HASH=$(ipfs add -q --only-hash file)
+'
+
+test_upload() {
+  test_expect_success "start an upload ($1)" '
+    ipfs upload new 300000 > new_out &&
+    ID=$(cut -d" " -f1 new_out) &&
+    echo "$ID 0/300000" > expected &&
+    test_cmp expected new_out
+  '
+
+  test_expect_success "append the first part ($1)" '
+    ipfs upload append $ID 0 part1 > append_out &&
+    echo "$ID 100000/300000" > expected &&
+    test_cmp expected append_out
+  '
+
+  test_expect_success "a wrong offset is refused ($1)" '
+    test_must_fail ipfs upload append $ID 0 part2 2> err &&
+    grep "the offset 0 doesn.t match the 100000 bytes received" err &&
+    ipfs upload status $ID > status_out &&
+    test_cmp expected status_out
+  '
+
+  test_expect_success "append the second part ($1)" '
+    ipfs upload append $ID 100000 part2 > append_out &&
+    echo "$ID 300000/300000 $HASH" > expected &&
+    test_cmp expected append_out
+  '
+
+  test_expect_success "the file is added and pinned ($1)" '
+    ipfs cat $HASH > cat_out &&
+    test_cmp file cat_out &&
+    ipfs pin ls --type=recursive $HASH
+  '
+
+  test_expect_success "a complete upload can't be appended to ($1)" '
+    test_must_fail ipfs upload append $ID 300000 part2 2> err &&
+    grep "the upload is complete" err
+  '
+
+  test_expect_success "the upload is listed and removed ($1)" '
+    ipfs upload ls > ls_out &&
+    grep "^$ID 300000/300000 $HASH$" ls_out &&
+    ipfs upload rm $ID &&
+    test_must_fail ipfs upload status $ID 2> err &&
+    grep "no such upload" err
+  '
+}
+
+test_upload "offline"
+
+test_expect_success "an invalid length is refused" '
+  test_must_fail ipfs upload new 1.5 &&
+  test_must_fail ipfs upload new big
+'
+
+test_launch_ipfs_daemon
+
+test_upload "online"
+
+test_expect_success "upload over HTTP" '
+  curl -sf -X POST "http://$API_ADDR/api/v0/upload/new?arg=300000&wrap-with-directory=true&name=file" > new_json &&
+  ID=$(sed -e "s/.*\"ID\":\"\([0-9a-f]*\)\".*/\1/" new_json) &&
+  curl -sf -X POST -F file=@part1 "http://$API_ADDR/api/v0/upload/append?arg=$ID&arg=0" > append_json &&
+  grep "\"Offset\":100000" append_json &&
+  curl -sf -X POST -F file=@part2 "http://$API_ADDR/api/v0/upload/append?arg=$ID&arg=100000" > append_json &&
+  DIR=$(sed -e "s/.*\"Hash\":\"\([^\"]*\)\".*/\1/" append_json) &&
+  ipfs cat $DIR/file > cat_out &&
+  test_cmp file cat_out
+'
+
+test_kill_ipfs_daemon
+
+test_done
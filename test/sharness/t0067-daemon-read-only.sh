@@ -0,0 +1,72 @@
+#!/usr/bin/env bash
+
+test_description="Test daemon with a read-only repo"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "add content before going read-only" '
+  echo "hello mirror" >expected &&
+  HASH=$(ipfs add -q expected) &&
+  ipfs files mkdir /site
+'
+
+test_expect_success "--repo-read-only can't be combined with --enable-gc" '
+  test_must_fail ipfs daemon --repo-read-only --enable-gc 2>err &&
+  grep "can.t be used with --repo-read-only" err
+'
+
+test_launch_ipfs_daemon --repo-read-only
+
+test_expect_success "daemon reports the read-only repo" '
+  grep "Repo is read-only" actual_daemon
+'
+
+test_expect_success "reads still work" '
+  ipfs cat "$HASH" >actual &&
+  test_cmp expected actual &&
+  ipfs files ls / &&
+  ipfs pin ls --type=recursive | grep "$HASH" &&
+  ipfs config Addresses.API &&
+  ipfs repo verify &&
+  ipfs key use
+'
+
+test_expect_success "gateway serves local content" '
+  curl -sfo actual "http://$GWAY_ADDR/ipfs/$HASH" &&
+  test_cmp expected actual
+'
+
+test_read_only_rejects() {
+  READ_ONLY_CMD="$*"
+  test_expect_success "'ipfs $READ_ONLY_CMD' is rejected" '
+    test_must_fail ipfs $READ_ONLY_CMD </dev/null 2>err &&
+    grep "the daemon runs with --repo-read-only" err
+  '
+}
+
+test_read_only_rejects add expected
+test_read_only_rejects pin rm "$HASH"
+test_read_only_rejects files mkdir /other
+test_read_only_rejects files rm -r /site
+test_read_only_rejects config Foo.Bar baz
+test_read_only_rejects object patch add-link "$HASH" foo "$HASH"
+test_read_only_rejects repo gc
+test_read_only_rejects repo verify --repair
+test_read_only_rejects name resolve --pin
+test_read_only_rejects key use self
+test_read_only_rejects swarm filters add /ip4/10.0.0.0/ipcidr/8
+test_read_only_rejects swarm key rotate
+test_read_only_rejects stats availability "$HASH" --interval=1h
+test_read_only_rejects dht provide --priority=low "$HASH"
+
+test_expect_success "rejected commands didn't change anything" '
+  ipfs pin ls --type=recursive | grep "$HASH" &&
+  ipfs files stat /site &&
+  test_must_fail ipfs files stat /other
+'
+
+test_kill_ipfs_daemon
+
+test_done
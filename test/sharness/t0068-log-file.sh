@@ -0,0 +1,65 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test writing the daemon logs to files"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "the logs are written to stderr by default" '
+  ipfs log status > status &&
+  echo stderr > expected &&
+  test_cmp expected status
+'
+
+test_expect_success "configure a rotated log file" '
+  ipfs config --json Logging "{
+    \"Stderr\": false,
+    \"Files\": [{\"Path\": \"logs/ipfs.log\", \"MaxSize\": \"1KB\", \"MaxBackups\": 2, \"Compress\": true}]
+  }"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "ipfs log status lists the log file" '
+  ipfs log status > status &&
+  grep "^file $IPFS_PATH/logs/ipfs.log" status &&
+  test_must_fail grep stderr status
+'
+
+test_expect_success "the logs are written to the file" '
+  ipfs log level all debug &&
+  for i in $(test_seq 1 20); do
+    echo "log file $i" | ipfs add -q > /dev/null || return 1
+  done &&
+  ipfs log level all error &&
+  go-sleep 500ms &&
+  for f in "$IPFS_PATH"/logs/ipfs.log*; do
+    gunzip -cf "$f"
+  done | grep "DEBUG" > /dev/null &&
+  test_must_fail grep "DEBUG" daemon_err
+'
+
+test_expect_success "the log file is rotated and compressed" '
+  ipfs log status > status &&
+  grep "rotations" status &&
+  ls "$IPFS_PATH/logs" > backups &&
+  grep "ipfs.log.*.gz" backups
+'
+
+test_expect_success "old backups are removed" '
+  test $(grep -c "ipfs.log\..*\.gz" backups) -le 2
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "an invalid log file config stops the daemon" '
+  ipfs config --json Logging.Files "[{\"Path\": \"ipfs.log\", \"MaxSize\": \"huge\"}]" &&
+  test_must_fail ipfs daemon 2> invalid_err &&
+  grep "Logging.Files" invalid_err
+'
+
+test_done
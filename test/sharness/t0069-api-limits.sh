@@ -0,0 +1,54 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the concurrency limits of the API"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "limit the concurrent adds" '
+  ipfs config --json API.Limits "{\"Commands\": {\"add\": 1}}"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "start a slow add" '
+  { echo "slow"; go-sleep 3s; } |
+    curl -sf -X POST -F file=@- "http://$API_ADDR/api/v0/add" > slow_add &
+  SLOW_PID=$! &&
+  go-sleep 1s
+'
+
+test_expect_success "a concurrent add is refused" '
+  echo "refused" | test_must_fail ipfs add 2> err &&
+  grep "too many concurrent add requests" err
+'
+
+test_expect_success "the API answers 429" '
+  curl -s -o /dev/null -w "%{http_code}" -X POST -F file=@err "http://$API_ADDR/api/v0/add" > code &&
+  echo 429 > expected &&
+  test_cmp expected code
+'
+
+test_expect_success "other commands still run" '
+  ipfs version
+'
+
+test_expect_success "adds run again once the slow one is done" '
+  wait $SLOW_PID &&
+  grep "\"Hash\"" slow_add &&
+  echo "accepted" | ipfs add -q
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "an invalid limit stops the daemon" '
+  ipfs config --json API.Limits.Commands "{\"add\": -1}" &&
+  test_must_fail ipfs daemon 2> err &&
+  grep "API.Limits.Commands.add" err
+'
+
+test_done
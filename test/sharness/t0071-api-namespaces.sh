@@ -0,0 +1,134 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the API namespaces"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "configure two namespaces" '
+  ipfs config --json API.Namespaces "{\"app1\": {\"Token\": \"secret1\"}, \"app2\": {\"Token\": \"secret2\", \"KeyPrefix\": \"two-\"}}"
+'
+
+test_launch_ipfs_daemon
+
+api() {
+  token=$1 && shift &&
+  cmd=$1 && shift &&
+  curl -sf -X POST -H "Authorization: Bearer $token" "$@" "http://$API_ADDR/api/v0/$cmd"
+}
+
+api_code() {
+  token=$1 && shift &&
+  cmd=$1 && shift &&
+  curl -s -o /dev/null -w "%{http_code}" -X POST -H "Authorization: Bearer $token" "$@" "http://$API_ADDR/api/v0/$cmd"
+}
+
+test_expect_success "an unknown token is refused" '
+  api_code nope version > code &&
+  printf 401 > expected &&
+  test_cmp expected code
+'
+
+test_expect_success "the node commands are forbidden" '
+  api_code secret1 "config?arg=API" > code &&
+  printf 403 > expected &&
+  test_cmp expected code
+'
+
+test_expect_success "the namespaces write to their own mfs root" '
+  echo "one" > one &&
+  api secret1 "files/write?arg=/file&create=true" -F file=@one &&
+  api secret1 "files/read?arg=/file" > read &&
+  test_cmp one read &&
+  ipfs files read /namespaces/app1/file > read &&
+  test_cmp one read
+'
+
+test_expect_success "requests without a token have the full API" '
+  ipfs files ls /namespaces > ls &&
+  grep app1 ls
+'
+
+test_expect_success "the namespaces can't see each other's files" '
+  api secret2 "files/ls?arg=/" > ls2 &&
+  test_must_fail grep file ls2 &&
+  test_must_fail api secret2 "files/read?arg=/../app1/file"
+'
+
+test_expect_success "the keys of the namespaces are prefixed" '
+  api secret2 "key/gen?arg=site&type=ed25519" > gen &&
+  grep "\"Name\":\"site\"" gen &&
+  ipfs key list > keys &&
+  grep "^two-site$" keys &&
+  api secret1 "key/list" > list1 &&
+  test_must_fail grep site list1 &&
+  api secret2 "key/list" > list2 &&
+  grep "\"Name\":\"site\"" list2 &&
+  test_must_fail grep self list2
+'
+
+test_expect_success "name publish needs a key of the namespace" '
+  api_code secret2 "name/publish?arg=/ipfs/$(ipfs files stat --hash /namespaces/app1/file)" > code &&
+  printf 403 > expected &&
+  test_cmp expected code
+'
+
+test_expect_success "add doesn't pin in the namespaces" '
+  HASH=$(api secret1 "add?quiet=true" -F file=@one | sed -e "s/.*\"Hash\":\"\([^\"]*\)\".*/\1/") &&
+  test_must_fail ipfs pin ls "$HASH"
+'
+
+test_expect_success "the namespaces list their own pins" '
+  api secret1 "pin/add?arg=$HASH" &&
+  ipfs pin ls --type=recursive "$HASH" &&
+  api secret1 "pin/ls" > pins1 &&
+  grep "$HASH" pins1 &&
+  api secret2 "pin/ls" > pins2 &&
+  test_must_fail grep "$HASH" pins2
+'
+
+test_expect_success "the namespaces only remove their own pins" '
+  api_code secret2 "pin/rm?arg=$HASH" > code &&
+  printf 400 > expected &&
+  test_cmp expected code &&
+  api secret1 "pin/rm?arg=$HASH" &&
+  test_must_fail ipfs pin ls "$HASH"
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "a namespace is required" '
+  ipfs config --json API.RequireNamespace true
+'
+
+# the readiness check of test_launch_ipfs_daemon has no token
+test_expect_success "start the daemon" '
+  ipfs daemon >actual_daemon 2>daemon_err &
+  IPFS_PID=$! &&
+  test_wait_for_file 50 100ms "$IPFS_PATH/api"
+'
+
+test_expect_success "requests without a token are refused" '
+  for i in $(test_seq 1 50); do
+    curl -s -o /dev/null -w "%{http_code}" -X POST "http://$API_ADDR/api/v0/version" > code
+    test "$(cat code)" != 000 && break
+    go-sleep 100ms
+  done &&
+  test_must_fail ipfs files ls / &&
+  printf 401 > expected &&
+  test_cmp expected code
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "overlapping namespaces stop the daemon" '
+  ipfs config --json API.Namespaces.app2.FilesRoot "\"/namespaces/app1/sub\"" &&
+  test_must_fail ipfs daemon 2> err &&
+  grep "the FilesRoot of API.Namespaces app1 and app2 overlap" err
+'
+
+test_done
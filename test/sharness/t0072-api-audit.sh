@@ -0,0 +1,56 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the audit log of the API"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "enable the audit log" '
+  ipfs config --json API.Audit "{\"Enabled\": true}" &&
+  ipfs config --json API.Namespaces "{\"app\": {\"Token\": \"secret\"}}"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the commands are recorded" '
+  echo "audited" > file &&
+  HASH=$(ipfs add -q file) &&
+  ipfs cat "$HASH" > /dev/null &&
+  grep "\"Command\":\"cat\"" "$IPFS_PATH/audit.log" > cat_entry &&
+  grep "\"Arguments\":\[\"$HASH\"\]" cat_entry &&
+  grep "\"Status\":200" cat_entry
+'
+
+test_expect_success "the failed commands are recorded with their error" '
+  test_must_fail ipfs cat QmInvalid &&
+  grep "\"Arguments\":\[\"QmInvalid\"\]" "$IPFS_PATH/audit.log" > err_entry &&
+  grep "\"Error\":\"" err_entry
+'
+
+test_expect_success "the caller is the namespace of the token" '
+  curl -sf -X POST -H "Authorization: Bearer secret" "http://$API_ADDR/api/v0/version" > /dev/null &&
+  grep "\"Command\":\"version\".*\"Caller\":\"app\"" "$IPFS_PATH/audit.log"
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "the arguments can be left out" '
+  ipfs config --json API.Audit.OmitArguments true
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the arguments are not recorded" '
+  ipfs cat "$HASH" > /dev/null &&
+  tail -n 1 "$IPFS_PATH/audit.log" > last_entry &&
+  grep "\"Command\":\"cat\"" last_entry &&
+  test_must_fail grep "$HASH" last_entry
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,41 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test shipping the daemon logs to remote collectors"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "an invalid collector stops the daemon" '
+  ipfs config --json Logging.Remotes "[{\"Type\": \"kafka\", \"Address\": \"http://127.0.0.1:1\"}]" &&
+  test_must_fail ipfs daemon 2> invalid_err &&
+  grep "invalid Logging.Remotes\[0\]: unknown type \"kafka\"" invalid_err
+'
+
+test_expect_success "configure a collector which can't be reached" '
+  ipfs config --json Logging.Remotes "[{\"Type\": \"http\", \"Address\": \"http://127.0.0.1:1/logs\", \"FlushInterval\": \"100ms\"}]"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "ipfs log status lists the collector" '
+  ipfs log status > status &&
+  grep "^stderr$" status &&
+  grep "^http http://127.0.0.1:1/logs: 0 sent, " status
+'
+
+test_expect_success "the entries wait for the collector" '
+  ipfs log level all debug &&
+  echo "remote log" | ipfs add -q > /dev/null &&
+  ipfs log level all error &&
+  go-sleep 500ms &&
+  ipfs log status > status &&
+  grep "^http http://127.0.0.1:1/logs: 0 sent, [1-9][0-9]* queued, last error: " status
+'
+
+test_kill_ipfs_daemon
+
+test_done
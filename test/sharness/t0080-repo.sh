@@ -283,4 +283,46 @@ test_expect_success "'ipfs repo stat' still succeeds" '
   ipfs repo stat > repo-stats
 '
 
+test_expect_success "set a tiny StorageMax" '
+  ipfs config Datastore.StorageMax 1KB
+'
+
+test_expect_success "'ipfs repo stat --human' shows StorageMax usage" '
+  ipfs repo stat --human > repo-stats-human &&
+  grep "StorageUsed" repo-stats-human
+'
+
+test_expect_success "ipfs init leaves StorageMax advisory" '
+  echo "" >expected &&
+  ipfs config Datastore.StorageMaxPolicy >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "adding is allowed while StorageMax is advisory" '
+  echo "quota" | ipfs add -q
+'
+
+test_expect_success "adding fails with the fail StorageMaxPolicy" '
+  ipfs config Datastore.StorageMaxPolicy fail &&
+  echo "quota exceeded" | test_must_fail ipfs add -q 2> add_err &&
+  grep "maximum storage limit exceeded" add_err
+'
+
+test_expect_success "pinning fails with the fail StorageMaxPolicy" '
+  test_must_fail ipfs pin add $(echo "quota" | ipfs add -q -n)
+'
+
+test_expect_success "block put is allowed with the fail StorageMaxPolicy" '
+  echo "quota block" | ipfs block put
+'
+
+test_expect_success "files write is allowed with the fail StorageMaxPolicy" '
+  echo "quota file" | ipfs files write --create /quota
+'
+
+test_expect_success "adding is allowed with the advisory StorageMaxPolicy" '
+  ipfs config Datastore.StorageMaxPolicy advisory &&
+  echo "quota advisory" | ipfs add -q
+'
+
 test_done
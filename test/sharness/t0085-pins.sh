@@ -144,6 +144,15 @@ test_pin_dag --raw-leaves
 
 test_pin_progress
 
+test_expect_success "pin reconcile with itself reports no differences" '
+  ipfs pin reconcile --with="$API_MADDR" > reconcile_out &&
+  test_must_be_empty reconcile_out
+'
+
+test_expect_success "pin reconcile rejects invalid addresses" '
+  test_must_fail ipfs pin reconcile --with=foo
+'
+
 test_kill_ipfs_daemon
 
 test_done
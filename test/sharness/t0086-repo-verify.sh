@@ -57,4 +57,36 @@ do
   check_random_corruption
 done
 
+test_expect_success "corrupt a block" '
+  to_break=$(find "$IPFS_PATH/blocks" -type f -name "*.data" | sort_rand | head -n 1) &&
+  echo "this is super broken" > "$to_break" &&
+  cp "$to_break" expected_quarantine
+'
+
+test_expect_success "repo verify --repair fails offline for pinned blocks" '
+  test_expect_code 1 ipfs repo verify --repair > repair_out 2> repair_err &&
+  test_expect_code 1 grep "removed block" repair_out &&
+  grep "run the daemon" repair_err
+'
+
+test_expect_success "repo verify --repair kept the corrupt block" '
+  test_expect_code 1 ipfs repo verify
+'
+
+test_expect_success "repo verify --quarantine moves the block aside" '
+  ipfs repo verify --quarantine > quarantine_out &&
+  grep "moved block" quarantine_out &&
+  test $(ls "$IPFS_PATH/quarantine" | wc -l) -eq 1 &&
+  test_cmp expected_quarantine "$IPFS_PATH/quarantine/"*
+'
+
+test_expect_success "repo verify passes after quarantine" '
+  ipfs repo verify
+'
+
+test_expect_success "re-adding the files restores the block" '
+  ipfs add -r -q foobar > /dev/null &&
+  ipfs pin verify
+'
+
 test_done
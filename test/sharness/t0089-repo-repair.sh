@@ -0,0 +1,77 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs repo repair"
+
+. lib/test-lib.sh
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+# The full leaves of a file added with the default chunker.
+leaf_blocks() {
+  find "$IPTB_ROOT/$1/blocks" -type f -name "*.data" -size 262158c
+}
+
+test_expect_success "add the same file to both nodes" '
+  random 1000000 7 > afile &&
+  HASH=$(ipfsi 0 add -q afile) &&
+  HASH1=$(ipfsi 1 add -q afile) &&
+  test "$HASH" = "$HASH1"
+'
+
+test_expect_success "remove blocks from node 1" '
+  leaf_blocks 1 > removed &&
+  test -s removed &&
+  cat removed | xargs rm
+'
+
+test_expect_success "repo repair requires CIDs or --from-pins" '
+  test_must_fail ipfsi 1 repo repair &&
+  test_must_fail ipfsi 1 repo repair --from-pins $HASH
+'
+
+test_expect_success "repo repair reports blocks it can't fetch offline" '
+  test_must_fail ipfsi 1 repo repair $HASH > repair_out &&
+  grep "^failed .* (missing): the node is offline" repair_out &&
+  test $(grep -c "^failed" repair_out) -eq $(wc -l < removed)
+'
+
+startup_cluster 2
+
+test_expect_success "repo repair fetches missing blocks from the network" '
+  ipfsi 1 repo repair --timeout=10s $HASH > repair_out &&
+  test $(grep -c "^repaired .* (missing) from network" repair_out) -eq $(wc -l < removed) &&
+  grep "^checked" repair_out
+'
+
+test_expect_success "the repaired file can be read" '
+  ipfsi 1 cat $HASH > afile_out &&
+  test_cmp afile afile_out
+'
+
+test_expect_success "corrupt a block on node 1" '
+  leaf_blocks 1 | head -n1 > corrupted &&
+  echo "this is super broken" > "$(cat corrupted)"
+'
+
+test_expect_success "repo repair --from-pins fetches corrupt blocks again" '
+  ipfsi 1 repo repair --from-pins > repair_out &&
+  grep "^repaired .* (corrupt) from network" repair_out &&
+  ipfsi 1 repo verify
+'
+
+test_expect_success "repo repair doesn't report intact DAGs" '
+  ipfsi 1 repo repair --from-pins > repair_out &&
+  test_must_fail grep "^repaired" repair_out &&
+  grep "^checked" repair_out
+'
+
+test_expect_success "stop iptb" '
+  iptb stop
+'
+
+test_done
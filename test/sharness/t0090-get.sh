@@ -128,6 +128,32 @@ test_get_cmd() {
     rm -r "$HASH2"
   '
 
+  test_expect_success "ipfs get -o - streams a tar to stdout (directory)" '
+    ipfs get -o - "$HASH2" >stdout.tar &&
+    tar -tf stdout.tar >actual &&
+    printf "%s\n" "$HASH2/" "$HASH2/a" "$HASH2/b/" "$HASH2/b/c" >expected &&
+    test_cmp expected actual &&
+    tar -xf stdout.tar &&
+    test_cmp dir/b/c "$HASH2"/b/c &&
+    rm -r "$HASH2"
+  '
+
+  test_expect_success "ipfs get -o - output is deterministic (directory)" '
+    ipfs get -o - "$HASH2" >stdout2.tar &&
+    test_cmp stdout.tar stdout2.tar
+  '
+
+  test_expect_success "ipfs get -o - -C streams a gzipped tar (directory)" '
+    ipfs get -o - -C "$HASH2" | tar -tzf - >actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs get --cid-headers records entry CIDs" '
+    ipfs get -o - --cid-headers "$HASH2" >cids.tar &&
+    HASH_C=$(ipfs resolve -r /ipfs/"$HASH2"/b/c | cut -d/ -f3) &&
+    grep -a "IPFS.cid=$HASH_C" cids.tar
+  '
+
   test_expect_success "ipfs get ../.. should fail" '
     echo "Error: invalid 'ipfs ref' path" >expected &&
     test_must_fail ipfs get ../.. 2>actual &&
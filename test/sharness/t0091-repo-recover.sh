@@ -0,0 +1,62 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the journal of the pin set and files root updates"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "repo recover tells the journal is disabled" '
+  ipfs repo recover > recover_out &&
+  grep "the journal is disabled" recover_out &&
+  grep "nothing to recover" recover_out
+'
+
+test_expect_success "enable the journal" '
+  ipfs config --json Datastore.Journal true
+'
+
+test_expect_success "nothing to recover after a clean shutdown" '
+  ipfs pin ls > /dev/null &&
+  ipfs repo recover > recover_out &&
+  echo "nothing to recover" > expected &&
+  test_cmp expected recover_out
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "update the pin set and the files root" '
+  echo "journaled" > afile &&
+  HASH=$(ipfs add -q afile) &&
+  ipfs files mkdir /journaled &&
+  ipfs files flush /
+'
+
+test_expect_success "kill the daemon without a clean shutdown" '
+  kill -9 $IPFS_PID &&
+  { wait $IPFS_PID; true; }
+'
+
+test_expect_success "the updates are replayed when the node starts" '
+  ipfs repo recover > recover_out &&
+  grep "^last recovery: " recover_out &&
+  grep "^replayed /local/pins: " recover_out &&
+  grep "^replayed /local/filesroot: " recover_out
+'
+
+test_expect_success "the pin and the files root were kept" '
+  ipfs pin ls --type=recursive $HASH &&
+  ipfs files ls / > files_out &&
+  grep "^journaled$" files_out
+'
+
+test_expect_success "repo recover --enc=json reports the recoveries" '
+  ipfs repo recover --enc=json > recover_json &&
+  grep "\"Action\":\"replayed\"" recover_json &&
+  grep "\"Key\":\"/local/pins\"" recover_json
+'
+
+test_done
@@ -0,0 +1,83 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the compression of the stored blocks"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+text_file() {
+  for i in $(seq $2); do
+    echo "line $i of a text file compressing well"
+  done > "$1"
+}
+
+compressed_objects() {
+  ipfs repo stat --compression | grep "^CompressedObjects:" | awk "{ print \$2 }"
+}
+
+test_expect_success "add a text file" '
+  text_file before 20000 &&
+  HASH_BEFORE=$(ipfs add -q before)
+'
+
+test_expect_success "repo compress fails without a compressed datastore" '
+  test_must_fail ipfs repo compress 2> compress_err &&
+  grep "no compressed datastore" compress_err
+'
+
+test_expect_success "wrap the blocks datastore" '
+  ipfs config Datastore.Spec > spec_orig &&
+  ipfs config --json Datastore.Spec "$(cat ../t0092-files/spec-compress)"
+'
+
+test_expect_success "the blocks stored before can be read" '
+  ipfs cat $HASH_BEFORE > before_out &&
+  test_cmp before before_out
+'
+
+test_expect_success "the blocks stored before aren't compressed" '
+  test "$(compressed_objects)" = 0
+'
+
+test_expect_success "new blocks are compressed" '
+  text_file after 30000 &&
+  HASH_AFTER=$(ipfs add -q after) &&
+  test "$(compressed_objects)" -gt 0 &&
+  ipfs cat $HASH_AFTER > after_out &&
+  test_cmp after after_out
+'
+
+test_expect_success "repo compress compresses the blocks stored before" '
+  BEFORE=$(compressed_objects) &&
+  ipfs repo compress > compress_out &&
+  grep "^rewrote [1-9][0-9]* blocks, -[0-9]* bytes" compress_out &&
+  test "$(compressed_objects)" -gt "$BEFORE" &&
+  ipfs cat $HASH_BEFORE > before_out &&
+  test_cmp before before_out
+'
+
+test_expect_success "repo stat reports the logical size" '
+  ipfs repo stat --compression > stat_out &&
+  STORED=$(grep "^StoredSize:" stat_out | awk "{ print \$2 }") &&
+  LOGICAL=$(grep "^LogicalSize:" stat_out | awk "{ print \$2 }") &&
+  test "$STORED" -lt "$LOGICAL"
+'
+
+test_expect_success "repo compress --decompress decompresses all the blocks" '
+  ipfs repo compress --decompress > decompress_out &&
+  grep "^rewrote [1-9][0-9]* blocks, +[0-9]* bytes" decompress_out &&
+  test "$(compressed_objects)" = 0
+'
+
+test_expect_success "the blocks can be read once the wrapper is removed" '
+  ipfs config --json Datastore.Spec "$(cat spec_orig)" &&
+  ipfs cat $HASH_AFTER > after_out &&
+  test_cmp after after_out &&
+  ipfs repo verify
+'
+
+test_done
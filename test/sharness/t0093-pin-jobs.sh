@@ -0,0 +1,65 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the pins running in the background"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "add a directory without pinning it" '
+  mkdir -p dir/sub &&
+  echo "a" > dir/a &&
+  echo "b" > dir/sub/b &&
+  DIR=$(ipfs add -r -Q --pin=false dir)
+'
+
+test_expect_success "pin jobs need the daemon" '
+  test_must_fail ipfs pin add --wait=false "$DIR" 2>err &&
+  grep "online mode" err
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "pin add --wait=false returns the ID of the job" '
+  ID=$(ipfs pin add --wait=false "$DIR") &&
+  test -n "$ID"
+'
+
+test_expect_success "pin status --watch streams the progress until the pin is done" '
+  ipfs pin status --watch "$ID" > watch_out &&
+  grep "^pinned: 4 blocks, [0-9]* bytes, depth 2$" watch_out &&
+  grep "^pinned $DIR$" watch_out
+'
+
+test_expect_success "the DAG is pinned" '
+  ipfs pin ls --type=recursive "$DIR"
+'
+
+test_expect_success "pin status shows the finished job" '
+  ipfs pin status "$ID" > status_out &&
+  grep "^pinned: 4 blocks" status_out &&
+  ipfs pin status --enc=json "$ID" > status_json &&
+  grep "\"State\":\"pinned\"" status_json &&
+  grep "\"Blocks\":4" status_json
+'
+
+test_expect_success "pin status --watch fails with the job" '
+  FAILED=$(ipfs pin add --wait=false "/ipfs/$DIR/missing") &&
+  test_must_fail ipfs pin status --watch "$FAILED" 2>err &&
+  grep "pin job $FAILED failed" err &&
+  ipfs pin status "$FAILED" > status_out &&
+  grep "^failed: " status_out &&
+  grep "^error: " status_out
+'
+
+test_expect_success "pin status rejects unknown jobs" '
+  test_must_fail ipfs pin status 0123456789abcdef 2>err &&
+  grep "unknown pin job" err
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,78 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the resume tokens of the streamed listings"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+# token <n> <file>: the resume token of the nth entry of a JSON listing
+token() {
+  sed -n "$1p" "$2" | sed "s/.*\"Resume\":\"\([^\"]*\)\".*/\1/"
+}
+
+test_expect_success "add a tree and some pins" '
+  mkdir -p tree/a/b tree/c &&
+  for f in 1 2 3; do
+    echo "a$f" > tree/a/$f &&
+    echo "b$f" > tree/a/b/$f &&
+    echo "c$f" > tree/c/$f
+  done &&
+  TREE=$(ipfs add -r -q tree | tail -n1) &&
+  for f in 1 2 3 4; do
+    PIN=$(echo "pin $f" | ipfs add -q --pin=false) &&
+    ipfs pin add -r=false $PIN > /dev/null
+  done
+'
+
+test_launch_ipfs_daemon --offline
+
+test_expect_success "'ipfs refs -r' resumes after a token" '
+  ipfs refs -r $TREE > refs_all &&
+  ipfs refs -r --enc=json $TREE > refs_json &&
+  test_line_count = $(wc -l < refs_all) refs_json &&
+  ipfs refs -r --resume="$(token 4 refs_json)" $TREE > refs_resumed &&
+  tail -n +5 refs_all > expected &&
+  test_cmp expected refs_resumed
+'
+
+test_expect_success "'ipfs refs' rejects the tokens of other arguments" '
+  test_must_fail ipfs refs -r --unique --resume="$(token 4 refs_json)" $TREE 2> err &&
+  grep "invalid resume token" err
+'
+
+test_expect_success "'ipfs pin ls --stream' resumes after a token" '
+  ipfs pin ls --stream > pins_all &&
+  ipfs pin ls | sort > expected &&
+  sort pins_all > actual &&
+  test_cmp expected actual &&
+  ipfs pin ls --stream --enc=json > pins_json &&
+  ipfs pin ls --resume="$(token 3 pins_json)" > pins_resumed &&
+  tail -n +4 pins_all > expected &&
+  test_cmp expected pins_resumed
+'
+
+test_expect_success "'ipfs pin ls --stream' lists all the pins" '
+  test_must_fail ipfs pin ls --stream $TREE 2> err &&
+  grep "without arguments" err &&
+  test_must_fail ipfs pin ls --type=direct --resume="$(token 3 pins_json)" 2> err &&
+  grep "invalid resume token" err
+'
+
+test_expect_success "'ipfs block ls' resumes after a token" '
+  ipfs block ls > blocks_all &&
+  ipfs refs local | sort > expected &&
+  sort blocks_all > actual &&
+  test_cmp expected actual &&
+  ipfs block ls --enc=json > blocks_json &&
+  ipfs block ls --resume="$(token 5 blocks_json)" > blocks_resumed &&
+  tail -n +6 blocks_all > expected &&
+  test_cmp expected blocks_resumed
+'
+
+test_kill_ipfs_daemon
+
+test_done
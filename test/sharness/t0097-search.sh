@@ -0,0 +1,71 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the local search index"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "'ipfs search' fails when the index is disabled" '
+  test_must_fail ipfs search report 2> err &&
+  grep "the search index is disabled" err
+'
+
+test_expect_success "enable the index of the content" '
+  ipfs config --json Search.Enabled true &&
+  ipfs config --json Search.Content true
+'
+
+test_expect_success "add some files" '
+  mkdir -p docs/2018 &&
+  echo "the annual figures of the cooperative" > docs/2018/annual-report.txt &&
+  echo "meeting notes about the figures" > docs/notes.md &&
+  DOCS=$(ipfs add -r -q docs | tail -n1) &&
+  SINGLE=$(echo "a lonely file" | ipfs add -q --stdin-name=lonely.txt) &&
+  REPORT=$(ipfs resolve -r /ipfs/$DOCS/2018/annual-report.txt | sed "s|/ipfs/||")
+'
+
+test_expect_success "'ipfs search' finds the names" '
+  ipfs search annual > actual &&
+  echo "$REPORT /ipfs/$DOCS/2018/annual-report.txt" > expected &&
+  test_cmp expected actual &&
+  ipfs search lonely > actual &&
+  echo "$SINGLE /ipfs/$SINGLE" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "'ipfs search' finds the content" '
+  ipfs search figures > actual &&
+  test_line_count = 2 actual &&
+  ipfs search "coop*" > actual &&
+  grep "/ipfs/$DOCS/2018/annual-report.txt" actual &&
+  ipfs search figures meeting > actual &&
+  test_line_count = 1 actual &&
+  grep "/ipfs/$DOCS/notes.md" actual
+'
+
+test_expect_success "'ipfs search' leaves out the removed files" '
+  ipfs pin rm $SINGLE &&
+  ipfs repo gc > /dev/null &&
+  ipfs search lonely > actual &&
+  test_must_be_empty actual
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the pins are indexed by the daemon" '
+  mkdir pinned &&
+  echo "quarterly summary" > pinned/summary.txt &&
+  PINNED=$(ipfs add -r -q --pin=false pinned | tail -n1) &&
+  ipfs pin add $PINNED > /dev/null &&
+  go-sleep 1s &&
+  ipfs search quarterly > actual &&
+  grep "/ipfs/$PINNED/summary.txt" actual
+'
+
+test_kill_ipfs_daemon
+
+test_done
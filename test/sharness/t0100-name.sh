@@ -92,6 +92,29 @@ test_expect_success "publish an explicit node ID as key name looks good" '
 '
 
 
+# test resolving with --pin
+
+test_expect_success "'ipfs name resolve --pin' succeeds" '
+  PINHASH=$(echo "resolve and pin" | ipfs add -q --pin=false) &&
+  ipfs name publish --allow-offline "/ipfs/$PINHASH" &&
+  ipfs name resolve --pin >resolve_pin_out
+'
+
+test_expect_success "'ipfs name resolve --pin' output looks good" '
+  echo "/ipfs/$PINHASH" >expected_resolve_pin &&
+  test_cmp expected_resolve_pin resolve_pin_out
+'
+
+test_expect_success "resolved path was pinned" '
+  ipfs pin ls --type=recursive | grep "$PINHASH"
+'
+
+test_expect_success "'ipfs name resolve --pin' fails on an /ipns value" '
+  ipfs name publish --allow-offline --resolve=false "/ipns/$NEWID" &&
+  test_must_fail ipfs name resolve --pin 2>resolve_pin_err &&
+  grep "must resolve to an /ipfs path" resolve_pin_err
+'
+
 # test publishing nothing
 
 test_expect_success "'ipfs name publish' fails" '
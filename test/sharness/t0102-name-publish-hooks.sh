@@ -0,0 +1,72 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the hooks run after name publish"
+
+. lib/test-lib.sh
+
+# wait_for_file waits for the hooks, run in the background, to write $1
+wait_for_file() {
+  for i in $(test_seq 1 50); do
+    test -s "$1" && return 0
+    go-sleep 100ms
+  done
+  return 1
+}
+
+test_init_ipfs
+
+test_expect_success "write the hook" '
+  HOOK_OUT="$(pwd)/hook_out" &&
+  cat >hook.sh <<-EOF &&
+	#!/bin/sh
+	echo "\$IPNS_KEY \$IPNS_NAME \$IPNS_VALUE \$IPNS_SEQUENCE \$IPNS_REPUBLISH" >>"$HOOK_OUT"
+	EOF
+  chmod +x hook.sh
+'
+
+test_expect_success "configure the hooks" '
+  ipfs config --json Ipns.PublishHooks "[{\"Exec\": [\"$(pwd)/hook.sh\"]}, {\"Exec\": [\"false\"]}]"
+'
+
+test_expect_success "invalid hooks are refused" '
+  ipfs config --json Ipns.PublishHooks "[{\"Timeout\": \"1s\"}]" &&
+  test_must_fail ipfs daemon --routing=none >daemon_out 2>&1 &&
+  grep "expected either Exec or URL" daemon_out &&
+  ipfs config --json Ipns.PublishHooks "[{\"Exec\": [\"$(pwd)/hook.sh\"]}, {\"Exec\": [\"false\"]}]"
+'
+
+test_launch_ipfs_daemon --routing=none
+
+test_expect_success "name publish runs the hooks" '
+  PEERID=$(ipfs id -f="<id>") &&
+  HASH=$(echo "hooked" | ipfs add -q) &&
+  ipfs name publish "/ipfs/$HASH" &&
+  wait_for_file hook_out &&
+  echo "self $PEERID /ipfs/$HASH 0 false" >expected &&
+  test_cmp expected hook_out
+'
+
+test_expect_success "publishing another value runs them with the next sequence" '
+  rm hook_out &&
+  HASH2=$(echo "hooked again" | ipfs add -q) &&
+  ipfs name publish "/ipfs/$HASH2" &&
+  wait_for_file hook_out &&
+  echo "self $PEERID /ipfs/$HASH2 1 false" >expected &&
+  test_cmp expected hook_out
+'
+
+test_expect_success "publishing with a key passes its name" '
+  rm hook_out &&
+  KEYID=$(ipfs key gen --type=rsa --size=2048 hookkey) &&
+  ipfs name publish --key=hookkey "/ipfs/$HASH" &&
+  wait_for_file hook_out &&
+  echo "hookkey $KEYID /ipfs/$HASH 0 false" >expected &&
+  test_cmp expected hook_out
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,84 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the HTTPS listeners of the API and the gateway"
+
+. lib/test-lib.sh
+
+type openssl >/dev/null 2>&1 && test_set_prereq OPENSSL
+curl -V 2>/dev/null | grep -q HTTP2 && test_set_prereq CURL_HTTP2
+
+test_init_ipfs
+
+test_expect_success "the daemon fails to start without a certificate" '
+  ipfs config --json HTTPS.Gateway "[\"/ip4/127.0.0.1/tcp/0\"]" &&
+  test_must_fail ipfs daemon > daemon_out 2>&1 &&
+  grep "the HTTPS addresses need a certificate" daemon_out
+'
+
+test_expect_success OPENSSL "create a certificate" '
+  openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes \
+    -subj "/CN=localhost" -days 1 -keyout key.pem -out cert.pem 2>/dev/null &&
+  ipfs config HTTPS.CertFile "$(pwd)/cert.pem" &&
+  ipfs config HTTPS.KeyFile "$(pwd)/key.pem" &&
+  ipfs config --json HTTPS.API "[\"/ip4/127.0.0.1/tcp/0\"]"
+'
+
+test_expect_success !OPENSSL "remove the HTTPS addresses" '
+  ipfs config --json HTTPS "{}"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success OPENSSL "the API and the gateway listen over HTTPS" '
+  go-sleep 1s &&
+  grep "^API server listening over HTTPS on " actual_daemon > api_https &&
+  grep "^Gateway (readonly) server listening over HTTPS on " actual_daemon > gw_https &&
+  API_HTTPS_PORT=$(sed -e "s|.*/tcp/||" api_https) &&
+  GW_HTTPS_PORT=$(sed -e "s|.*/tcp/||" gw_https)
+'
+
+test_expect_success OPENSSL "the gateway serves the files over HTTPS" '
+  HASH=$(echo "over tls" | ipfs add -q) &&
+  curl -sf --cacert cert.pem "https://localhost:$GW_HTTPS_PORT/ipfs/$HASH" > actual &&
+  echo "over tls" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success OPENSSL "the API serves the commands over HTTPS" '
+  curl -sf --cacert cert.pem "https://localhost:$API_HTTPS_PORT/api/v0/version" > actual &&
+  grep "\"Version\"" actual
+'
+
+test_expect_success OPENSSL "the plain listeners still serve" '
+  curl -sf "http://127.0.0.1:$GWAY_PORT/ipfs/$HASH" > actual &&
+  test_cmp expected actual
+'
+
+test_expect_success OPENSSL,CURL_HTTP2 "the gateway serves HTTP/2 over HTTPS" '
+  curl -sf --http2 --cacert cert.pem -o /dev/null -w "%{http_version}" "https://localhost:$GW_HTTPS_PORT/ipfs/$HASH" > actual &&
+  printf 2 > expected &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "disable HTTP/2 on the gateway" '
+  ipfs config --json Gateway.Server "{\"HTTP2\": false, \"IdleTimeout\": \"1m\"}"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success OPENSSL,CURL_HTTP2 "the gateway only serves HTTP/1.1" '
+  go-sleep 1s &&
+  GW_HTTPS_PORT=$(grep "^Gateway (readonly) server listening over HTTPS on " actual_daemon | sed -e "s|.*/tcp/||") &&
+  curl -sf --http2 --cacert cert.pem -o /dev/null -w "%{http_version}" "https://localhost:$GW_HTTPS_PORT/ipfs/$HASH" > actual &&
+  printf 1.1 > expected &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_done
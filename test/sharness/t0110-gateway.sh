@@ -187,6 +187,58 @@ test_expect_success "try fetching it from gateway" '
   test_cmp rfile ffile
 '
 
+test_expect_success "add a file of several blocks" '
+  random 1000000 42 > bigfile &&
+  BIG_HASH=$(ipfs add -q bigfile)
+'
+
+test_expect_success "GET a range of a file" '
+  curl -sf -D range_headers -H "Range: bytes=262140-262150" -o actual "http://127.0.0.1:$port/ipfs/$BIG_HASH" &&
+  dd if=bigfile of=expected bs=1 skip=262140 count=11 2>/dev/null &&
+  test_cmp expected actual &&
+  grep "HTTP/1.1 206 Partial Content" range_headers &&
+  grep "Content-Range: bytes 262140-262150/1000000" range_headers
+'
+
+test_expect_success "GET a suffix range of a file" '
+  curl -sf -H "Range: bytes=-10" -o actual "http://127.0.0.1:$port/ipfs/$BIG_HASH" &&
+  tail -c 10 bigfile > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "GET several ranges of a file" '
+  curl -sf -D range_headers -H "Range: bytes=0-9,500000-500009" -o actual "http://127.0.0.1:$port/ipfs/$BIG_HASH" &&
+  grep "Content-Type: multipart/byteranges" range_headers &&
+  grep "Content-Range: bytes 0-9/1000000" actual &&
+  grep "Content-Range: bytes 500000-500009/1000000" actual
+'
+
+test_expect_success "GET a range past the end of a file fails" '
+  curl -s -o /dev/null -w "%{http_code}" -H "Range: bytes=2000000-" "http://127.0.0.1:$port/ipfs/$BIG_HASH" > code &&
+  printf 416 > expected &&
+  test_cmp expected code
+'
+
+test_expect_success "GET a file has caching headers" '
+  curl -sf -D cache_headers -o /dev/null "http://127.0.0.1:$port/ipfs/$HASH" &&
+  grep "Etag: \"$HASH\"" cache_headers &&
+  grep "Cache-Control: public, max-age=29030400, immutable" cache_headers
+'
+
+test_expect_success "GET a file with a matching If-None-Match returns 304" '
+  curl -s -D cache_headers -o /dev/null -w "%{http_code}" -H "If-None-Match: \"other\", W/\"$HASH\"" "http://127.0.0.1:$port/ipfs/$HASH" > code &&
+  printf 304 > expected_code &&
+  test_cmp expected_code code &&
+  grep "Etag: \"$HASH\"" cache_headers &&
+  grep "Cache-Control: public, max-age=29030400, immutable" cache_headers
+'
+
+test_expect_success "GET a directory listing has a weak ETag" '
+  curl -sf -D cache_headers -o /dev/null "http://127.0.0.1:$port/ipfs/$HASH2/" &&
+  grep "Etag: W/\"DirIndex-$HASH2\"" cache_headers &&
+  test_must_fail grep "immutable" cache_headers
+'
+
 test_expect_success "Add compact blocks" '
   ipfs block put ../t0110-gateway-data/foo.block &&
   FOO2_HASH=$(ipfs block put ../t0110-gateway-data/foofoo.block) &&
@@ -0,0 +1,81 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the CAR ingest endpoint of the gateway"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create CAR files" '
+  mkdir -p dir &&
+  echo "hello car" > dir/a &&
+  echo "hello again" > dir/b &&
+  ROOT=$(ipfs add -r -Q --store=false --car-output=full.car dir) &&
+  FILE=$(ipfs add -Q --store=false --car-output=file.car dir/a) &&
+  head -c 60 full.car > truncated.car
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the endpoint is disabled by default" '
+  curl -s -o /dev/null -w "%{http_code}" -X POST --data-binary @full.car "http://$GWAY_ADDR/car" > status &&
+  test "$(cat status)" != 200
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "enable the endpoint" '
+  ipfs config --json Gateway.CarIngest "{\"Enabled\": true, \"Tokens\": [\"s3cret\"], \"MaxSize\": 1048576}"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "uploads without a valid token are refused" '
+  curl -s -o /dev/null -w "%{http_code}" -X POST --data-binary @full.car "http://$GWAY_ADDR/car" > status &&
+  echo 401 > expected &&
+  test_cmp expected status &&
+  curl -s -o /dev/null -w "%{http_code}" -H "Authorization: Bearer wrong" -X POST --data-binary @full.car "http://$GWAY_ADDR/car" > status &&
+  test_cmp expected status
+'
+
+test_expect_success "upload and pin a CAR file" '
+  curl -s -w "\n%{http_code}" -H "Authorization: Bearer s3cret" -X POST --data-binary @full.car "http://$GWAY_ADDR/car?pin=true" > out &&
+  tail -n 1 out > status &&
+  echo 200 > expected &&
+  test_cmp expected status &&
+  grep "\"Cid\":\"$ROOT\"" out &&
+  grep "\"Complete\":true,\"Pinned\":true" out &&
+  ipfs pin ls --type=recursive | grep $ROOT &&
+  ipfs cat $ROOT/b > b_out &&
+  echo "hello again" > b_exp &&
+  test_cmp b_exp b_out
+'
+
+test_expect_success "truncated CAR files are rejected" '
+  curl -s -o /dev/null -w "%{http_code}" -H "Authorization: Bearer s3cret" -X POST --data-binary @truncated.car "http://$GWAY_ADDR/car" > status &&
+  echo 400 > expected &&
+  test_cmp expected status
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "limit the size of the uploads" '
+  ipfs config --json Gateway.CarIngest.MaxSize 10
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "large uploads are refused" '
+  curl -s -o /dev/null -w "%{http_code}" -H "Authorization: Bearer s3cret" -X POST --data-binary @file.car "http://$GWAY_ADDR/car" > status &&
+  echo 413 > expected &&
+  test_cmp expected status &&
+  ipfs refs local > refs &&
+  test_must_fail grep $FILE refs
+'
+
+test_kill_ipfs_daemon
+
+test_done
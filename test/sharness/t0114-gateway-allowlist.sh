@@ -0,0 +1,68 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the allowlist of the gateway"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+status() {
+  curl -s -o /dev/null -w "%{http_code}" "http://$GWAY_ADDR$1"
+}
+
+test_expect_success "add content" '
+  ALLOWED=$(echo "allowed" | ipfs add -q) &&
+  OTHER=$(echo "other" | ipfs add -q) &&
+  SITE=$(echo "site" | ipfs add -q) &&
+  PEERID=$(ipfs config Identity.PeerID) &&
+  ipfs name publish --allow-offline "/ipfs/$SITE"
+'
+
+test_expect_success "an invalid root is rejected" '
+  ipfs config --json Gateway.Allowlist "[\"$ALLOWED\"]" &&
+  test_must_fail ipfs daemon 2>err &&
+  grep "invalid Gateway.Allowlist root" err
+'
+
+test_expect_success "allow a CID and an IPNS name" '
+  ipfs config --json Gateway.Allowlist "[\"/ipfs/$ALLOWED\", \"/ipns/$PEERID\", \"/ipns/*.example.com\"]"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the allowed CID is served" '
+  curl -sf "http://$GWAY_ADDR/ipfs/$ALLOWED" > actual &&
+  echo "allowed" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the allowed IPNS name is served" '
+  curl -sf "http://$GWAY_ADDR/ipns/$PEERID" > actual &&
+  echo "site" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the other content is forbidden" '
+  test "$(status /ipfs/$OTHER)" = 403 &&
+  test "$(status /ipfs/$SITE)" = 403 &&
+  test "$(status /ipns/example.com)" = 403 &&
+  curl -s "http://$GWAY_ADDR/ipfs/$OTHER" > out &&
+  grep "only serves the content of its allowlist" out
+'
+
+test_expect_success "the read-only API is forbidden" '
+  test "$(status /api/v0/cat?arg=$OTHER)" = 403
+'
+
+test_expect_success "the API isn't restricted" '
+  ipfs cat "$OTHER" > actual &&
+  echo "other" > expected &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,107 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the per-hostname config of the gateway"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+# get <host> <path>
+get() {
+  curl -s -H "Host: $1" "http://$GWAY_ADDR$2"
+}
+
+status() {
+  curl -s -o /dev/null -w "%{http_code}" -H "Host: $1" "http://$GWAY_ADDR$2"
+}
+
+location() {
+  curl -s -o /dev/null -w "%{redirect_url}" -H "Host: $1" "http://$GWAY_ADDR$2"
+}
+
+test_expect_success "add content" '
+  HASH=$(echo "hello" | ipfs add -q) &&
+  MISSING=$(echo "missing" | ipfs add -q --only-hash)
+'
+
+test_expect_success "an invalid hostname is rejected" '
+  ipfs config --json Gateway.PublicGateways "{\"Example.com\": {\"Paths\": [\"/ipfs\"]}}" &&
+  test_must_fail ipfs daemon 2>err &&
+  grep "invalid Gateway.PublicGateways hostname" err
+'
+
+test_expect_success "configure a subdomain gateway and a path gateway" '
+  ipfs config --json Gateway.PublicGateways "{
+    \"localhost\": {\"Paths\": [\"/ipfs\", \"/ipns\"], \"UseSubdomains\": true},
+    \"gw.example.com\": {\"Paths\": [\"/ipfs\"], \"NoDNSLink\": true, \"NoFetch\": true}
+  }"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the paths redirect to the subdomains" '
+  test "$(status localhost /ipfs/$HASH)" = 301 &&
+  URL=$(location localhost "/ipfs/$HASH?filename=hello.txt") &&
+  echo "$URL" | grep "^http://b[a-z2-7]*\.ipfs\.localhost/?filename=hello.txt$" &&
+  LABEL=$(echo "$URL" | sed "s|^http://\([^.]*\)\..*|\1|")
+'
+
+test_expect_success "the subdomains serve the content" '
+  get "$LABEL.ipfs.localhost" / > actual &&
+  echo "hello" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the subdomain gateway only serves its paths" '
+  test "$(status localhost /api/v0/version)" = 404 &&
+  test "$(status localhost /version)" = 404
+'
+
+test_expect_success "the path gateway serves the content of the repo" '
+  get gw.example.com "/ipfs/$HASH" > actual &&
+  test_cmp expected actual &&
+  test "$(status gw.example.com /ipns/example.com)" = 404
+'
+
+test_expect_success "the path gateway doesn't fetch the missing content" '
+  test "$(curl -s -m 10 -o /dev/null -w "%{http_code}" -H "Host: gw.example.com" "http://$GWAY_ADDR/ipfs/$MISSING")" = 404
+'
+
+test_expect_success "the forwarded host of an untrusted client is ignored" '
+  test "$(curl -s -o /dev/null -w "%{http_code}" -H "X-Forwarded-Host: localhost" \
+    "http://$GWAY_ADDR/ipfs/$HASH")" = 200
+'
+
+test_expect_success "the other hostnames are unchanged" '
+  curl -sf "http://$GWAY_ADDR/ipfs/$HASH" > actual &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "an invalid trusted proxy is rejected" '
+  ipfs config --json Gateway.TrustedProxies "[\"proxy.internal\"]" &&
+  test_must_fail ipfs daemon 2>err &&
+  grep "invalid Gateway.TrustedProxies address" err
+'
+
+test_expect_success "trust the local reverse proxy" '
+  ipfs config --json Gateway.TrustedProxies "[\"127.0.0.1\"]"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the forwarded host and scheme of the closest proxy are used" '
+  URL=$(curl -s -o /dev/null -w "%{redirect_url}" -H "X-Forwarded-Host: evil.example.com, localhost" \
+    -H "X-Forwarded-Proto: https" "http://$GWAY_ADDR/ipfs/$HASH") &&
+  echo "$URL" | grep "^https://$LABEL\.ipfs\.localhost/$" &&
+  curl -s -H "X-Forwarded-Host: $LABEL.ipfs.localhost" "http://$GWAY_ADDR/" > actual &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,40 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the gateway only serving the content of the repo"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+status() {
+  curl -s -m 10 -o /dev/null -w "%{http_code}" -X "$1" "http://$GWAY_ADDR$2"
+}
+
+test_expect_success "add content and enable Gateway.NoFetch" '
+  HASH=$(echo "hello" | ipfs add -q) &&
+  MISSING=$(echo "missing" | ipfs add -q --only-hash) &&
+  ipfs config --json Gateway.NoFetch true
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the gateway serves the content of the repo" '
+  curl -sf "http://$GWAY_ADDR/ipfs/$HASH" > actual &&
+  echo "hello" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the gateway doesn't fetch the missing content" '
+  test "$(status GET /ipfs/$MISSING)" = 404
+'
+
+test_expect_success "the read-only API of the gateway is forbidden" '
+  test "$(status GET /api/v0/cat?arg=$HASH)" = 403
+'
+
+test_kill_ipfs_daemon
+
+test_done
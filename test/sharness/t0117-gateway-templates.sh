@@ -0,0 +1,87 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the custom templates of the gateway"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "write the templates" '
+  mkdir -p "$IPFS_PATH/templates/assets" "$IPFS_PATH/templates/docs" &&
+  echo "<h1>Listing of {{ .Path }}</h1>{{ range .Listing }}<li>{{ .Name }}</li>{{ end }}" > "$IPFS_PATH/templates/dir.html" &&
+  echo "<h1>Lost: {{ .Path }}</h1>" > "$IPFS_PATH/templates/404.html" &&
+  echo "<h1>{{ .Status }}: {{ .Message }}</h1>" > "$IPFS_PATH/templates/error.html" &&
+  echo "<h1>Docs page not found</h1>" > "$IPFS_PATH/templates/docs-404.html" &&
+  echo "body { color: red }" > "$IPFS_PATH/templates/assets/style.css" &&
+  echo "body { color: blue }" > "$IPFS_PATH/templates/docs/style.css"
+'
+
+test_expect_success "configure the templates" '
+  ipfs config --json Gateway.Templates "{
+    \"DirIndex\": \"templates/dir.html\",
+    \"NotFound\": \"templates/404.html\",
+    \"Error\": \"templates/error.html\",
+    \"Assets\": \"templates/assets\",
+    \"Sites\": {
+      \"docs.example.com\": {
+        \"NotFound\": \"templates/docs-404.html\",
+        \"Assets\": \"templates/docs\"
+      }
+    }
+  }"
+'
+
+test_expect_success "add a directory" '
+  mkdir dir &&
+  echo "hello" > dir/hello.txt &&
+  DIR=$(ipfs add -r -q dir | tail -n1)
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the listing uses the template" '
+  curl -sf "http://$GWAY_ADDR/ipfs/$DIR/" > listing &&
+  grep "<h1>Listing of /ipfs/$DIR/</h1>" listing &&
+  grep "<li>hello.txt</li>" listing
+'
+
+test_expect_success "the 404s use the template" '
+  curl -s -o lost -w "%{http_code}" "http://$GWAY_ADDR/ipfs/$DIR/missing" > code &&
+  printf 404 > expected &&
+  test_cmp expected code &&
+  grep "<h1>Lost: /ipfs/$DIR/missing</h1>" lost
+'
+
+test_expect_success "the other errors use the template" '
+  curl -s "http://$GWAY_ADDR/ipfs/invalid" > error &&
+  grep "<h1>400: invalid ipfs path</h1>" error
+'
+
+test_expect_success "the assets are served" '
+  curl -sf "http://$GWAY_ADDR/ipfs-gateway-assets/style.css" > style &&
+  echo "body { color: red }" > expected &&
+  test_cmp expected style
+'
+
+test_expect_success "a site has its own templates and assets" '
+  curl -s -H "Host: docs.example.com" "http://$GWAY_ADDR/ipfs/$DIR/missing" > lost &&
+  grep "<h1>Docs page not found</h1>" lost &&
+  curl -sf -H "Host: docs.example.com" "http://$GWAY_ADDR/ipfs/$DIR/" > listing &&
+  grep "<li>hello.txt</li>" listing &&
+  curl -sf -H "Host: docs.example.com" "http://$GWAY_ADDR/ipfs-gateway-assets/style.css" > style &&
+  echo "body { color: blue }" > expected &&
+  test_cmp expected style
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "an invalid template stops the daemon" '
+  echo "{{ .Path " > "$IPFS_PATH/templates/404.html" &&
+  test_must_fail ipfs daemon 2> err &&
+  grep "invalid Gateway.Templates.NotFound" err
+'
+
+test_done
@@ -0,0 +1,94 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the _redirects files of the sites served by the gateway"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+# status <host> <path>
+status() {
+  curl -s -o /dev/null -w "%{http_code}" -H "Host: $1" "http://$GWAY_ADDR$2"
+}
+
+location() {
+  curl -s -o /dev/null -w "%{redirect_url}" -H "Host: $1" "http://$GWAY_ADDR$2"
+}
+
+test_expect_success "add a single-page app" '
+  mkdir -p app/docs &&
+  echo "<h1>app</h1>" > app/index.html &&
+  echo "<h1>lost</h1>" > app/404.html &&
+  echo "<h1>docs</h1>" > app/docs/index.html &&
+  cat > app/_redirects <<-\EOR &&
+	# moved pages
+	/old/:page   /new/:page   302
+	/blog/*      https://blog.example.com/:splat
+	/docs/*      /docs/       200
+	/missing/*   /404.html    404
+	/*           /index.html  200
+	EOR
+  APP=$(ipfs add -r -q --cid-version=1 app | tail -n1)
+'
+
+test_expect_success "configure a subdomain gateway" '
+  ipfs config --json Gateway.PublicGateways "{
+    \"localhost\": {\"Paths\": [\"/ipfs\"], \"UseSubdomains\": true}
+  }"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "find the subdomain of the app" '
+  URL=$(location localhost "/ipfs/$APP/") &&
+  HOST=$(echo "$URL" | sed "s|^http://\([^/]*\)/.*|\1|") &&
+  echo "$HOST" | grep "\.ipfs\.localhost$"
+'
+
+test_expect_success "the existing paths are served" '
+  curl -sf -H "Host: $HOST" "http://$GWAY_ADDR/docs/" > actual &&
+  echo "<h1>docs</h1>" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the redirect rules redirect" '
+  test "$(status $HOST /old/about)" = 302 &&
+  test "$(location $HOST "/old/about?x=1")" = "http://$HOST/new/about?x=1" &&
+  test "$(status $HOST /blog/2018/hello)" = 301 &&
+  test "$(location $HOST /blog/2018/hello)" = "https://blog.example.com/2018/hello"
+'
+
+test_expect_success "the rewrite rules serve their target" '
+  curl -sf -H "Host: $HOST" "http://$GWAY_ADDR/app/settings" > actual &&
+  echo "<h1>app</h1>" > expected &&
+  test_cmp expected actual &&
+  curl -sf -H "Host: $HOST" "http://$GWAY_ADDR/docs/deep/link" > actual &&
+  echo "<h1>docs</h1>" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the 404 rules serve their page" '
+  test "$(status $HOST /missing/page)" = 404 &&
+  curl -s -H "Host: $HOST" "http://$GWAY_ADDR/missing/page" > actual &&
+  echo "<h1>lost</h1>" > expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the path gateway ignores the rules" '
+  test "$(curl -s -o /dev/null -w "%{http_code}" "http://$GWAY_ADDR/ipfs/$APP/app/settings")" = 404
+'
+
+test_expect_success "an invalid _redirects file is an error" '
+  mkdir bad &&
+  echo "/a /b 418" > bad/_redirects &&
+  BAD=$(ipfs add -r -q --cid-version=1 bad | tail -n1) &&
+  BADHOST=$(location localhost "/ipfs/$BAD/" | sed "s|^http://\([^/]*\)/.*|\1|") &&
+  test "$(status $BADHOST /a)" = 500
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,54 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the pin and repo inventory metrics"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_launch_ipfs_daemon
+
+test_expect_success "the inventory is not exported by default" '
+  curl -sf "http://$API_ADDR/debug/metrics/prometheus" > metrics &&
+  test_must_fail grep "^ipfs_pins" metrics
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "enable the inventory" '
+  ipfs config --json Metrics.Inventory "{\"Enabled\": true, \"Interval\": \"1s\"}" &&
+  echo "inventory" > file &&
+  ipfs add -q file > hash
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the pins are exported" '
+  go-sleep 1500ms &&
+  curl -sf "http://$API_ADDR/debug/metrics/prometheus" > metrics &&
+  grep "^ipfs_pins{type=\"recursive\"}" metrics &&
+  grep "^ipfs_pins_bytes" metrics &&
+  grep "^ipfs_filestore_references{type=\"file\"} 0" metrics
+'
+
+test_expect_success "the files API root changes are counted" '
+  ipfs files mkdir /inventory &&
+  ipfs files cp /ipfs/$(cat hash) /inventory/file &&
+  go-sleep 500ms &&
+  curl -sf "http://$API_ADDR/debug/metrics/prometheus" > metrics &&
+  grep "^ipfs_files_changes_total{op=\"mkdir\"} 1" metrics &&
+  grep "^ipfs_files_changes_total{op=\"cp\"} 1" metrics
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "an invalid interval stops the daemon" '
+  ipfs config Metrics.Inventory.Interval never &&
+  test_must_fail ipfs daemon 2> err &&
+  grep "Metrics.Inventory.Interval" err
+'
+
+test_done
@@ -86,7 +86,11 @@ test_bootstrap_cmd() {
   test_bootstrap_list_cmd $BP2
 
   test_expect_success "'ipfs bootstrap add --default' succeeds" '
-    ipfs bootstrap add --default >add2_actual
+    ipfs bootstrap add --default >add2_actual 2>add2_err
+  '
+
+  test_expect_success "'ipfs bootstrap add --default' warns about the deprecation" '
+    grep "WARNING: bootstrap-add-default-flag is deprecated" add2_err
   '
 
   test_expect_success "'ipfs bootstrap add --default' output has default BP" '
@@ -109,7 +113,11 @@ test_bootstrap_cmd() {
   test_bootstrap_list_cmd $BP1 $BP2 $BP3 $BP4 $BP5 $BP6 $BP7 $BP8 $BP9 $BP10 $BP11 $BP12 $BP13 $BP14 $BP15 $BP16 $BP17
 
   test_expect_success "'ipfs bootstrap rm --all' succeeds" '
-    ipfs bootstrap rm --all >rm2_actual
+    ipfs bootstrap rm --all >rm2_actual 2>rm2_err
+  '
+
+  test_expect_success "'ipfs bootstrap rm --all' warns about the deprecation" '
+    grep "WARNING: bootstrap-rm-all-flag is deprecated" rm2_err
   '
 
   test_expect_success "'ipfs bootstrap rm' output looks good" '
@@ -0,0 +1,64 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the quality of service classes of the swarm"
+
+. lib/test-lib.sh
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+test_expect_success "a rate is needed to enable the classes" '
+  ipfsi 0 config --json Swarm.QoS.Enabled true &&
+  test_must_fail ipfsi 0 daemon 2> daemon_err &&
+  grep "invalid Swarm.QoS config: the rate must be positive" daemon_err
+'
+
+test_expect_success "configure the classes of node 0" '
+  ipfsi 0 config --json Swarm.QoS.Rate 1048576 &&
+  ipfsi 0 config --json Swarm.QoS.Classes.bulk 2 &&
+  ipfsi 0 config Swarm.QoS.Traffic.pubsub bulk
+'
+
+startup_cluster 2 --routing=none
+
+test_expect_success "swarm qos needs the classes enabled" '
+  test_must_fail ipfsi 1 swarm qos 2> qos_err &&
+  grep "traffic classes are disabled" qos_err
+'
+
+test_expect_success "swarm qos shows the classes" '
+  ipfsi 0 swarm qos > qos_out &&
+  grep "^Rate: 1.0 MB/s$" qos_out &&
+  grep "^bitswap *high$" qos_out &&
+  grep "^dht *low$" qos_out &&
+  grep "^pubsub *bulk$" qos_out &&
+  grep "^bulk *2 " qos_out
+'
+
+test_expect_success "node 1 fetches a file from node 0" '
+  random 500000 42 > bigfile &&
+  HASH=$(ipfsi 0 add -q bigfile) &&
+  ipfsi 1 cat $HASH > fetched &&
+  test_cmp bigfile fetched
+'
+
+test_expect_success "the blocks were sent in the bitswap class" '
+  ipfsi 0 swarm qos --enc=json > qos_json &&
+  grep "\"high\":{\"Weight\":8,\"Sent\":[1-9][0-9]\{5,\}," qos_json
+'
+
+test_expect_success "stop the cluster" '
+  iptb stop
+'
+
+test_expect_success "an unknown class is rejected" '
+  ipfsi 0 config Swarm.QoS.Traffic.dht urgent &&
+  test_must_fail ipfsi 0 daemon 2> daemon_err &&
+  grep "unknown class \"urgent\" of traffic dht" daemon_err
+'
+
+test_done
@@ -47,6 +47,33 @@ test_expect_success "cant trigger a dial backoff with swarm connect" '
   test_expect_code 1 grep "backoff" connect_out
 '
 
+test_expect_success "a failed dial puts the peer in backoff" '
+  ipfs swarm backoff ls > backoff_out &&
+  grep "^QmUWKoHbjsqsSMesRC2Zoscs8edyFz6F77auBB1YBBhgpX$" backoff_out
+'
+
+test_expect_success "swarm backoff clear clears the backoff" '
+  ipfs swarm backoff clear QmUWKoHbjsqsSMesRC2Zoscs8edyFz6F77auBB1YBBhgpX > clear_out &&
+  echo "backoff QmUWKoHbjsqsSMesRC2Zoscs8edyFz6F77auBB1YBBhgpX cleared" > expected &&
+  test_cmp expected clear_out &&
+  ipfs swarm backoff ls > backoff_out &&
+  test_must_fail grep "QmUWKoHbjsqsSMesRC2Zoscs8edyFz6F77auBB1YBBhgpX" backoff_out
+'
+
+test_expect_success "swarm backoff clear rejects invalid peer IDs" '
+  test_must_fail ipfs swarm backoff clear foo
+'
+
+test_expect_success "swarm connect --timeout rejects invalid timeouts" '
+  test_must_fail ipfs swarm connect --timeout=never $addr 2> connect_out &&
+  grep "invalid timeout" connect_out
+'
+
+test_expect_success "swarm connect --timeout still reports failures" '
+  test_expect_code 1 ipfs swarm connect --timeout=5s $addr 2> connect_out &&
+  grep "failure" connect_out
+'
+
 test_kill_ipfs_daemon
 
 announceCfg='["/ip4/127.0.0.1/tcp/4001", "/ip4/1.2.3.4/tcp/1234"]'
@@ -111,10 +111,68 @@ test_expect_success "adding addresses to the config to filter succeeds" '
   ipfs config --json Swarm.AddrFilters "[\"$AF1\", \"$AF4\"]"
 '
 
+test_expect_success "setting an unmodeled Swarm key succeeds" '
+  ipfs config --json Swarm.Reputation.Enabled false
+'
+
 test_launch_ipfs_daemon
 
 test_swarm_filters
 
+test_expect_success "'ipfs swarm filters ls' lists the added filters" '
+  ipfs swarm filters add $AF1 $AF3 &&
+  printf "$AF1\n$AF3\n" >expected &&
+  ipfs swarm filters ls >actual &&
+  test_sort_cmp expected actual
+'
+
+test_expect_success "the other Swarm keys are kept" '
+  echo false >expected &&
+  ipfs config Swarm.Reputation.Enabled >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "switching to default deny fails while there are filters" '
+  test_must_fail ipfs swarm filters --default-deny=true 2>err &&
+  grep "remove them first" err
+'
+
+test_expect_success "switching to default deny succeeds without filters" '
+  ipfs swarm filters rm all &&
+  ipfs swarm filters --default-deny=true &&
+  echo true >expected &&
+  ipfs config Swarm.AddrFiltersDefaultDeny >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "allowed ranges can be added in default deny mode" '
+  ipfs swarm filters add $AF2 &&
+  echo "$AF2" >expected &&
+  ipfs swarm filters ls >actual &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_launch_ipfs_daemon
+
+test_expect_success "the filters persist across restarts" '
+  echo "$AF2" >expected &&
+  ipfs swarm filters >actual &&
+  test_cmp expected actual &&
+  echo true >expected &&
+  ipfs config Swarm.AddrFiltersDefaultDeny >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "switching back to default allow succeeds without filters" '
+  ipfs swarm filters rm all &&
+  ipfs swarm filters --default-deny=false &&
+  echo false >expected &&
+  ipfs config Swarm.AddrFiltersDefaultDeny >actual &&
+  test_cmp expected actual
+'
+
 test_kill_ipfs_daemon
 
 test_done
@@ -0,0 +1,73 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the resource limits of the swarm"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "swarm limit needs the daemon" '
+  test_must_fail ipfs swarm limit 2> limit_err &&
+  grep "online mode" limit_err
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "swarm limit shows the default limits" '
+  ipfs swarm limit > limit_out &&
+  grep "^Conns: unlimited$" limit_out &&
+  grep "^ConnsPerPeer: 8$" limit_out &&
+  grep "^StreamsPerPeer: 512$" limit_out &&
+  grep "^Memory: unlimited$" limit_out
+'
+
+test_expect_success "swarm limit changes a limit" '
+  ipfs swarm limit StreamsPerPeer 256 > limit_out &&
+  grep "^StreamsPerPeer: 256$" limit_out &&
+  ipfs swarm limit Memory 512MB > limit_out &&
+  grep "^Memory: 512 MB$" limit_out
+'
+
+test_expect_success "swarm limit changes a protocol limit" '
+  ipfs swarm limit /ipfs/bitswap/1.1.0 100 > limit_out &&
+  grep "^/ipfs/bitswap/1.1.0: 100$" limit_out &&
+  ipfs swarm limit /ipfs/bitswap/1.1.0 0 > limit_out &&
+  test_must_fail grep "^/ipfs/bitswap" limit_out
+'
+
+test_expect_success "swarm limit rejects invalid limits" '
+  test_must_fail ipfs swarm limit Foo 1 &&
+  test_must_fail ipfs swarm limit Conns -1 &&
+  test_must_fail ipfs swarm limit Conns
+'
+
+test_expect_success "swarm stats shows the resources used" '
+  ipfs swarm stats > stats_out &&
+  grep "^Conns: [0-9]* (0 blocked)$" stats_out &&
+  grep "^Streams: [0-9]* (0 blocked)$" stats_out
+'
+
+test_expect_success "swarm stats --enc=json" '
+  ipfs swarm stats --enc=json > stats_json &&
+  grep "\"BlockedConns\":0" stats_json
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "the resource manager can be disabled" '
+  ipfs config --json Swarm.ResourceMgr.Enabled false
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "swarm limit fails when disabled" '
+  test_must_fail ipfs swarm limit 2> limit_err &&
+  grep "resource manager is disabled" limit_err
+'
+
+test_kill_ipfs_daemon
+
+test_done
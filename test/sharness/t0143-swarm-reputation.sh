@@ -0,0 +1,56 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the reputation of the peers"
+
+. lib/test-lib.sh
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+test_expect_success "enable the reputation on node 0" '
+  ipfsi 0 config --json Swarm.Reputation.Enabled true
+'
+
+startup_cluster 2 --routing=none
+
+test_expect_success "peer ids" '
+  PEERID_0=$(iptb get id 0) &&
+  PEERID_1=$(iptb get id 1)
+'
+
+test_expect_success "swarm reputation needs the reputation enabled" '
+  test_must_fail ipfsi 1 swarm reputation 2> rep_err &&
+  grep "peer reputation is disabled" rep_err
+'
+
+test_expect_success "swarm reputation shows a peer" '
+  ipfsi 0 swarm reputation $PEERID_1 > rep_out &&
+  grep "^Reputation of $PEERID_1$" rep_out &&
+  grep "^Score:" rep_out &&
+  grep "^Stability:" rep_out
+'
+
+test_expect_success "swarm reputation rejects invalid peer IDs" '
+  test_must_fail ipfsi 0 swarm reputation foo
+'
+
+test_expect_success "swarm reputation lists the connected peers" '
+  ipfsi 0 swarm reputation > rep_list &&
+  grep "^$PEERID_1 " rep_list
+'
+
+test_expect_success "swarm reputation --enc=json" '
+  ipfsi 0 swarm reputation --enc=json $PEERID_1 > rep_json &&
+  grep "\"Peer\":\"$PEERID_1\"" rep_json &&
+  grep "\"Connects\":1" rep_json
+'
+
+test_expect_success "stop the cluster" '
+  iptb stop
+'
+
+test_done
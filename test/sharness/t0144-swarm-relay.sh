@@ -0,0 +1,89 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the relay service and auto-relay"
+
+. lib/test-lib.sh
+
+# Network toplogy: A <-> Relay <-> B, B uses the relay
+test_expect_success "init iptb" '
+  iptb init -n 3 --bootstrap=none --port=0
+'
+
+test_expect_success "start up nodes for configuration" '
+  iptb start --args --routing=none
+'
+
+test_expect_success "peer ids" '
+  PEERID_0=$(iptb get id 0) &&
+  PEERID_1=$(iptb get id 1) &&
+  PEERID_2=$(iptb get id 2) &&
+  RELAY_ADDR=$(ipfsi 1 swarm addrs local | head -1)
+'
+
+test_expect_success "swarm relay shows everything disabled" '
+  ipfsi 0 swarm relay > relay_out &&
+  grep "^Relay service: disabled$" relay_out &&
+  grep "^Auto-relay: disabled$" relay_out
+'
+
+test_expect_success "configure the relay service and auto-relay" '
+  ipfsi 1 config --json Addresses.Swarm "[\"$RELAY_ADDR\"]" &&
+  ipfsi 1 config --json Swarm.RelayService.Enabled true &&
+  ipfsi 2 config --json Swarm.RelayClient "{\"Enabled\": true, \"Reachability\": \"private\", \"StaticRelays\": [\"$RELAY_ADDR/ipfs/$PEERID_1\"]}"
+'
+
+test_expect_success "restart nodes" '
+  iptb stop &&
+  iptb_wait_stop &&
+  iptb start --args --routing=none
+'
+
+test_expect_success "connect A <-> Relay" '
+  iptb connect 0 1
+'
+
+test_expect_success "B uses the relay" '
+  for i in $(test_seq 1 50); do
+    ipfsi 2 swarm relay > relay_out &&
+    grep "relay $PEERID_1" relay_out && break
+    go-sleep 200ms
+  done &&
+  grep "^Auto-relay: private reachability, 1 relays$" relay_out
+'
+
+test_expect_success "the relay shows the reservation of B" '
+  ipfsi 1 swarm relay > relay_out &&
+  grep "^Relay service: 1 reservations, 0 circuits$" relay_out &&
+  grep "^  $PEERID_2 until " relay_out
+'
+
+test_expect_success "the relay doesn't relay to peers without a reservation" '
+  test_must_fail ipfsi 2 swarm connect /ipfs/$PEERID_1/p2p-circuit/ipfs/$PEERID_0
+'
+
+test_expect_success "connect A <-Relay-> B" '
+  ipfsi 0 swarm connect /ipfs/$PEERID_1/p2p-circuit/ipfs/$PEERID_2 > peers_out &&
+  echo "connect $PEERID_2 success" > peers_exp &&
+  test_cmp peers_exp peers_out
+'
+
+test_expect_success "cat an object of A in B through the relay" '
+  echo "hello relay" | ipfsi 0 add -q > hash &&
+  ipfsi 2 cat $(cat hash) > cat_out &&
+  echo "hello relay" > cat_exp &&
+  test_cmp cat_exp cat_out
+'
+
+test_expect_success "swarm relay --enc=json" '
+  ipfsi 1 swarm relay --enc=json > relay_json &&
+  grep "\"Peer\":\"$PEERID_2\"" relay_json
+'
+
+test_expect_success "stop iptb" '
+  iptb stop
+'
+
+test_done
@@ -0,0 +1,93 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the hole punching of relayed connections"
+
+. lib/test-lib.sh
+
+# Network toplogy: A <-> Relay <-> B, B reserves the relay and A connects to B
+# through it, the relayed connection is then upgraded
+test_expect_success "init iptb" '
+  iptb init -n 3 --bootstrap=none --port=0
+'
+
+test_expect_success "start up nodes for configuration" '
+  iptb start --args --routing=none
+'
+
+test_expect_success "peer ids" '
+  PEERID_0=$(iptb get id 0) &&
+  PEERID_1=$(iptb get id 1) &&
+  PEERID_2=$(iptb get id 2) &&
+  RELAY_ADDR=$(ipfsi 1 swarm addrs local | head -1)
+'
+
+test_expect_success "stats dcutr fails when hole punching is disabled" '
+  test_must_fail ipfsi 0 stats dcutr 2> dcutr_err &&
+  grep "hole punching disabled in config" dcutr_err
+'
+
+test_expect_success "configure the relay and hole punching" '
+  ipfsi 1 config --json Addresses.Swarm "[\"$RELAY_ADDR\"]" &&
+  ipfsi 1 config --json Swarm.RelayService.Enabled true &&
+  ipfsi 2 config --json Swarm.RelayClient "{\"Enabled\": true, \"Reachability\": \"private\", \"StaticRelays\": [\"$RELAY_ADDR/ipfs/$PEERID_1\"]}" &&
+  ipfsi 0 config --json Swarm.EnableHolePunching true &&
+  ipfsi 2 config --json Swarm.EnableHolePunching true
+'
+
+test_expect_success "restart nodes" '
+  iptb stop &&
+  iptb_wait_stop &&
+  iptb start --args --routing=none
+'
+
+test_expect_success "stats dcutr shows no hole punch" '
+  ipfsi 0 stats dcutr > dcutr_out &&
+  grep "^Successes: 0$" dcutr_out &&
+  grep "^Failures: 0$" dcutr_out
+'
+
+test_expect_success "connect A <-> Relay and wait for the reservation of B" '
+  iptb connect 0 1 &&
+  for i in $(test_seq 1 50); do
+    ipfsi 2 swarm relay | grep "relay $PEERID_1" && break
+    go-sleep 200ms
+  done
+'
+
+test_expect_success "connect A <-Relay-> B" '
+  ipfsi 0 swarm connect /ipfs/$PEERID_1/p2p-circuit/ipfs/$PEERID_2
+'
+
+test_expect_success "the relayed connection is upgraded" '
+  for i in $(test_seq 1 50); do
+    ipfsi 0 stats dcutr > dcutr_out_0 &&
+    ipfsi 2 stats dcutr > dcutr_out_2 &&
+    grep "^Successes: 1$" dcutr_out_0 &&
+    grep "^Successes: 1$" dcutr_out_2 && break
+    go-sleep 200ms
+  done &&
+  grep "^Successes: 1$" dcutr_out_0 &&
+  grep "^Successes: 1$" dcutr_out_2 &&
+  grep "$PEERID_2 .*: ok$" dcutr_out_0
+'
+
+test_expect_success "A and B are connected directly" '
+  ipfsi 0 swarm peers > peers_out &&
+  grep "$PEERID_2" peers_out > peer_out &&
+  test_must_fail grep p2p-circuit peer_out
+'
+
+test_expect_success "stats dcutr --enc=json" '
+  ipfsi 0 stats dcutr --enc=json > dcutr_json &&
+  grep "\"Peer\":\"$PEERID_2\"" dcutr_json &&
+  grep "\"Success\":true" dcutr_json
+'
+
+test_expect_success "stop iptb" '
+  iptb stop
+'
+
+test_done
@@ -0,0 +1,124 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the static routing"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "routing static ls is empty" '
+  ipfs routing static ls > ls_out &&
+  test_must_be_empty ls_out
+'
+
+PEER_ADDR=/ip4/10.0.0.2/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+OTHER_ADDR=/ip4/10.0.0.3/tcp/4001/ipfs/QmSoLPppuBtQSGwKDZT2M73ULpjvfd3aZ6ha4oFGL1KrGM
+
+test_expect_success "routing static add stores the peers" '
+  ipfs routing static add $PEER_ADDR $OTHER_ADDR > add_out &&
+  printf "added %s\nadded %s\n" $PEER_ADDR $OTHER_ADDR > add_exp &&
+  test_cmp add_exp add_out &&
+  ipfs config Routing.Static.Peers > config_out &&
+  grep $PEER_ADDR config_out &&
+  grep $OTHER_ADDR config_out
+'
+
+test_expect_success "routing static add skips the peers already added" '
+  ipfs routing static add $PEER_ADDR > add_out &&
+  test_must_be_empty add_out
+'
+
+test_expect_success "routing static add rejects addresses without peer ID" '
+  test_must_fail ipfs routing static add /ip4/10.0.0.2/tcp/4001
+'
+
+test_expect_success "routing static ls lists the peers" '
+  ipfs routing static ls > ls_out &&
+  printf "%s disconnected\n%s disconnected\n" $PEER_ADDR $OTHER_ADDR > ls_exp &&
+  test_cmp ls_exp ls_out
+'
+
+test_expect_success "routing static rm removes a peer by ID" '
+  ipfs routing static rm QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ > rm_out &&
+  echo "removed $PEER_ADDR" > rm_exp &&
+  test_cmp rm_exp rm_out &&
+  ipfs routing static ls > ls_out &&
+  echo "$OTHER_ADDR disconnected" > ls_exp &&
+  test_cmp ls_exp ls_out
+'
+
+test_expect_success "routing static rm fails for unknown peers" '
+  test_must_fail ipfs routing static rm QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+'
+
+test_expect_success "routing static rm --all removes all the peers" '
+  ipfs routing static rm --all &&
+  ipfs routing static ls > ls_out &&
+  test_must_be_empty ls_out
+'
+
+# Network topology: A and B route through the static peer S only, they are
+# never connected directly before looking up
+test_expect_success "init iptb" '
+  iptb init -n 3 --bootstrap=none --port=0
+'
+
+test_expect_success "start up nodes for configuration" '
+  iptb start --args --routing=none
+'
+
+test_expect_success "configure the static routing" '
+  SWARM_ADDR=$(ipfsi 0 swarm addrs local | head -1) &&
+  STATIC_ADDR=$SWARM_ADDR/ipfs/$(iptb get id 0) &&
+  PEERID_1=$(iptb get id 1) &&
+  ipfsi 0 config --json Addresses.Swarm "[\"$SWARM_ADDR\"]" &&
+  ipfsi 0 config Routing.Type static &&
+  ipfsi 1 config Routing.Type static &&
+  ipfsi 2 config Routing.Type static &&
+  ipfsi 1 config --json Bootstrap "[]" &&
+  ipfsi 2 config --json Bootstrap "[]"
+'
+
+test_expect_success "restart nodes" '
+  iptb stop &&
+  iptb_wait_stop &&
+  iptb start
+'
+
+test_expect_success "add the static peer to the running nodes" '
+  ipfsi 1 routing static add $STATIC_ADDR &&
+  ipfsi 2 routing static add $STATIC_ADDR &&
+  for i in $(test_seq 1 50); do
+    ipfsi 2 routing static ls | grep " connected$" && break
+    go-sleep 200ms
+  done &&
+  ipfsi 1 routing static ls > ls_out &&
+  echo "$STATIC_ADDR connected" > ls_exp &&
+  test_cmp ls_exp ls_out
+'
+
+test_expect_success "A adds content and publishes a name" '
+  echo "static routing" > file &&
+  HASH=$(ipfsi 1 add -q file) &&
+  ipfsi 1 name publish --allow-offline /ipfs/$HASH
+'
+
+test_expect_success "B finds the content through the static peer" '
+  ipfsi 2 cat $HASH > cat_out &&
+  test_cmp file cat_out
+'
+
+test_expect_success "B resolves the name through the static peer" '
+  echo "/ipfs/$HASH" > resolve_exp &&
+  ipfsi 2 name resolve $PEERID_1 > resolve_out &&
+  test_cmp resolve_exp resolve_out
+'
+
+test_expect_success "stop nodes" '
+  iptb stop
+'
+
+test_done
@@ -0,0 +1,41 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the QUIC transport"
+
+. lib/test-lib.sh
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+test_expect_success "enable QUIC" '
+  ipfsi 0 config --json Swarm.Transports.Network.QUIC true &&
+  ipfsi 1 config --json Swarm.Transports.Network.QUIC 50
+'
+
+test_expect_success "start up nodes" '
+  iptb start
+'
+
+test_expect_success "the nodes listen on QUIC" '
+  ipfsi 0 swarm addrs local > addrs_0 &&
+  grep "/udp/[0-9]*/quic$" addrs_0 &&
+  ipfsi 1 swarm addrs local > addrs_1 &&
+  grep "/udp/[0-9]*/quic$" addrs_1
+'
+
+test_expect_success "the nodes connect over QUIC" '
+  PEERID_1=$(iptb get id 1) &&
+  ipfsi 0 swarm connect $(grep "/quic$" addrs_1 | head -1)/ipfs/$PEERID_1 &&
+  ipfsi 0 swarm peers > peers_0 &&
+  grep "/quic/ipfs/$PEERID_1$" peers_0
+'
+
+test_expect_success "stop nodes" '
+  iptb stop
+'
+
+test_done
@@ -0,0 +1,56 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the websocket and secure websocket listeners"
+
+. lib/test-lib.sh
+
+type openssl >/dev/null 2>&1 && test_set_prereq OPENSSL
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+test_expect_success "node 0 fails to listen on /wss without a certificate" '
+  ipfsi 0 config --json Addresses.Swarm "[\"/ip4/127.0.0.1/tcp/0\", \"/ip4/127.0.0.1/tcp/0/ws\", \"/ip4/127.0.0.1/tcp/0/wss\"]" &&
+  test_must_fail iptb start 0
+'
+
+test_expect_success OPENSSL "create a certificate" '
+  openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes \
+    -subj "/CN=localhost" -days 1 -keyout key.pem -out cert.pem 2>/dev/null &&
+  ipfsi 0 config --json Swarm.SecureWebSocket "{\"CertFile\": \"$(pwd)/cert.pem\", \"KeyFile\": \"$(pwd)/key.pem\"}"
+'
+
+test_expect_success OPENSSL "start up nodes" '
+  iptb start
+'
+
+test_expect_success OPENSSL "node 0 listens on /ws and /wss" '
+  ipfsi 0 swarm addrs local > addrs_0 &&
+  grep "/ws$" addrs_0 > ws_addrs &&
+  test $(wc -l < ws_addrs) -eq 1 &&
+  grep "/wss$" addrs_0 > wss_addrs &&
+  test $(wc -l < wss_addrs) -eq 1
+'
+
+test_expect_success OPENSSL "node 1 connects over /ws" '
+  PEERID_0=$(iptb get id 0) &&
+  ipfsi 1 swarm connect $(cat ws_addrs)/ipfs/$PEERID_0 &&
+  ipfsi 1 swarm peers > peers_1 &&
+  grep "/ws/ipfs/$PEERID_0$" peers_1
+'
+
+test_expect_success OPENSSL "/wss serves the certificate" '
+  WSS_PORT=$(sed -e "s|.*/tcp/\([0-9]*\)/wss|\1|" wss_addrs) &&
+  openssl s_client -connect 127.0.0.1:$WSS_PORT < /dev/null > s_client_out 2>&1 &&
+  grep "CN *= *localhost" s_client_out
+'
+
+test_expect_success OPENSSL "stop nodes" '
+  iptb stop
+'
+
+test_done
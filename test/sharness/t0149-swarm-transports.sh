@@ -0,0 +1,130 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the Swarm.Transports config"
+
+. lib/test-lib.sh
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+test_expect_success "listen on websocket too" '
+  ipfsi 0 config --json Addresses.Swarm "[\"/ip4/127.0.0.1/tcp/0\", \"/ip4/127.0.0.1/tcp/0/ws\"]" &&
+  ipfsi 1 config --json Addresses.Swarm "[\"/ip4/127.0.0.1/tcp/0\", \"/ip4/127.0.0.1/tcp/0/ws\"]"
+'
+
+test_expect_success "disable TCP on node 1" '
+  ipfsi 1 config --json Swarm.Transports.Network.TCP false &&
+  ipfsi 1 config --json Swarm.Transports.Multiplexers.Mplex 50
+'
+
+test_expect_success "start up nodes" '
+  iptb start
+'
+
+test_expect_success "node 1 only listens on websocket" '
+  ipfsi 0 swarm addrs local > addrs_0 &&
+  grep "/tcp/[0-9]*$" addrs_0 &&
+  ipfsi 1 swarm addrs local > addrs_1 &&
+  grep "/ws$" addrs_1 &&
+  test_must_fail grep "/tcp/[0-9]*$" addrs_1
+'
+
+test_expect_success "node 1 does not dial TCP addresses" '
+  PEERID_0=$(iptb get id 0) &&
+  test_must_fail ipfsi 1 swarm connect $(grep "/tcp/[0-9]*$" addrs_0 | head -1)/ipfs/$PEERID_0
+'
+
+test_expect_success "the nodes connect over websocket" '
+  ipfsi 1 swarm connect $(grep "/ws$" addrs_0 | head -1)/ipfs/$PEERID_0 &&
+  ipfsi 1 swarm peers > peers_1 &&
+  grep "/ws/ipfs/$PEERID_0$" peers_1
+'
+
+test_expect_success "swarm peers shows the transport of the connection" '
+  ipfsi 1 swarm peers --transport > peers_1 &&
+  grep "/ws/ipfs/$PEERID_0 Websocket /secio/1.0.0 /yamux/1.0.0$" peers_1 &&
+  ipfsi 1 swarm peers -v > peers_1 &&
+  grep "Websocket /secio/1.0.0 /yamux/1.0.0$" peers_1
+'
+
+test_expect_success "ipfs id shows the connections to the peer" '
+  ipfsi 1 id -f "<conns>" $PEERID_0 > conns_1 &&
+  grep "/ws Websocket /secio/1.0.0 /yamux/1.0.0$" conns_1 &&
+  ipfsi 1 id $PEERID_0 > id_1 &&
+  grep "\"Muxer\": \"/yamux/1.0.0\"" id_1
+'
+
+test_expect_success "stop nodes" '
+  iptb stop
+'
+
+test_expect_success "only enable Noise on node 0" '
+  ipfsi 0 config --json Swarm.Transports.Security.SECIO false &&
+  ipfsi 0 config --json Swarm.Transports.Security.Noise true &&
+  ipfsi 1 config --json Swarm.Transports.Security.Noise true
+'
+
+test_expect_success "start up nodes" '
+  iptb start
+'
+
+test_expect_success "the nodes connect with Noise" '
+  ipfsi 1 swarm connect $(ipfsi 0 swarm addrs local | grep "/ws$" | head -1)/ipfs/$PEERID_0 &&
+  ipfsi 1 swarm peers --transport > peers_1 &&
+  grep "/ws/ipfs/$PEERID_0 Websocket /noise /yamux/1.0.0$" peers_1
+'
+
+test_expect_success "stop nodes" '
+  iptb stop
+'
+
+test_expect_success "disable Noise" '
+  ipfsi 0 config --json Swarm.Transports.Security.Noise null &&
+  ipfsi 1 config --json Swarm.Transports.Security.Noise null
+'
+
+# TLS needs ipfs to be built with Go 1.13, the config refuses it otherwise
+if ipfsi 0 config --json Swarm.Transports.Security.TLS true 2> tls_err; then
+  test_set_prereq TLS
+fi
+
+test_expect_success !TLS "ipfs config refuses TLS in a build without it" '
+  grep "needs ipfs to be built with Go 1.13 or later" tls_err
+'
+
+test_expect_success TLS "enable TLS on node 1 too" '
+  ipfsi 1 config --json Swarm.Transports.Security.TLS true
+'
+
+test_expect_success TLS "start up nodes" '
+  iptb start
+'
+
+test_expect_success TLS "the nodes connect with TLS" '
+  ipfsi 1 swarm connect $(ipfsi 0 swarm addrs local | grep "/ws$" | head -1)/ipfs/$PEERID_0 &&
+  ipfsi 1 swarm peers --transport > peers_1 &&
+  grep "/ws/ipfs/$PEERID_0 Websocket /tls/1.0.0 /yamux/1.0.0$" peers_1
+'
+
+test_expect_success TLS "stop nodes" '
+  iptb stop
+'
+
+test_expect_success "the daemon refuses a swarm without security transport" '
+  ipfsi 0 config --json Swarm.Transports.Security.TLS null &&
+  test_must_fail ipfsi 0 daemon > daemon_out 2>&1 &&
+  grep "Security: no transport enabled" daemon_out
+'
+
+test_expect_success "the daemon refuses a swarm without multiplexer" '
+  ipfsi 0 config --json Swarm.Transports.Security.SECIO null &&
+  ipfsi 0 config --json Swarm.Transports.Multiplexers.Yamux false &&
+  test_must_fail ipfsi 0 daemon > daemon_out 2>&1 &&
+  grep "no multiplexer enabled" daemon_out
+'
+
+test_done
@@ -18,7 +18,9 @@ test_expect_success "output contains some expected keys" '
   grep "virt" output &&
   grep "interface_addresses" output &&
   grep "arch" output &&
-  grep "online" output
+  grep "online" output &&
+  grep "subsystems" output &&
+  grep "light_client" output
 '
 
 test_expect_success "uname succeeds" '
@@ -0,0 +1,61 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs diag largest"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "add a large and a small file" '
+  random 500000 41 > large &&
+  random 1000 42 > small &&
+  LARGE=$(ipfs add -q large) &&
+  SMALL=$(ipfs add -q small)
+'
+
+test_expect_success "the largest pin comes first" '
+  ipfs diag largest -n 1 > largest_out &&
+  test_line_count = 1 largest_out &&
+  grep "$LARGE" largest_out &&
+  grep " blocks " largest_out
+'
+
+test_expect_success "a pin sharing all its blocks frees nothing" '
+  ipfs pin add -r $(ipfs object patch add-link $(ipfs object new unixfs-dir) large $LARGE) &&
+  ipfs diag largest --enc=json > largest_json &&
+  grep -v "\"Cid\":\"$LARGE\",\"Size\":[1-9]" largest_json
+'
+
+test_expect_success "the largest blocks are listed" '
+  ipfs diag largest --type=blocks -n 3 > blocks_out &&
+  test_line_count = 3 blocks_out &&
+  ipfs refs -r $LARGE > large_refs &&
+  head -n 1 blocks_out | awk "{print \$NF}" > largest_block &&
+  grep -f largest_block large_refs
+'
+
+test_expect_success "the heaviest mfs subtrees are listed" '
+  ipfs files mkdir -p /dir/sub &&
+  ipfs files cp /ipfs/$LARGE /dir/sub/large &&
+  ipfs files cp /ipfs/$SMALL /dir/small &&
+  ipfs diag largest -t mfs -n 3 > mfs_out &&
+  head -n 1 mfs_out | grep " /dir " &&
+  grep " /dir/sub " mfs_out &&
+  grep " /dir/sub/large " mfs_out &&
+  test_must_fail grep " /dir/small " mfs_out
+'
+
+test_expect_success "an unknown type is rejected" '
+  test_must_fail ipfs diag largest --type=files 2> err_out &&
+  grep "unknown type \"files\"" err_out
+'
+
+test_expect_success "a count of 0 is rejected" '
+  test_must_fail ipfs diag largest -n 0 2> err_out &&
+  grep "the count must be positive" err_out
+'
+
+test_done
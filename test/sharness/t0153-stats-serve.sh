@@ -0,0 +1,53 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the stats of the data served to the other peers"
+
+. lib/test-lib.sh
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+startup_cluster 2
+
+test_expect_success "nothing is served at first" '
+  ipfsi 0 stats serve --enc=json > serve_out &&
+  grep "\"Blocks\":0" serve_out
+'
+
+test_expect_success "add two datasets on node 0" '
+  random 300000 1 > dataset_a &&
+  random 1000 2 > dataset_b &&
+  HASH_A=$(ipfsi 0 add -q dataset_a) &&
+  HASH_B=$(ipfsi 0 add -q dataset_b) &&
+  echo "not pinned" > loose &&
+  HASH_LOOSE=$(ipfsi 0 add -q --pin=false loose)
+'
+
+test_expect_success "node 1 fetches the first dataset and the unpinned file" '
+  ipfsi 1 cat $HASH_A > fetched &&
+  test_cmp dataset_a fetched &&
+  ipfsi 1 cat $HASH_LOOSE > fetched &&
+  test_cmp loose fetched
+'
+
+test_expect_success "'ipfs stats serve' counts the blocks served" '
+  ipfsi 0 stats serve > serve_out &&
+  grep "^Blocks: [1-9]" serve_out
+'
+
+test_expect_success "'ipfs stats serve --by-pin' attributes them to the dataset" '
+  ipfsi 0 stats serve --by-pin > serve_out &&
+  grep "^$HASH_A  *recursive" serve_out &&
+  test_must_fail grep "^$HASH_B" serve_out &&
+  grep "^unpinned  *[1-9]" serve_out
+'
+
+test_expect_success "stop the cluster" '
+  iptb stop
+'
+
+test_done
@@ -0,0 +1,97 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs key use"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "self is the default key" '
+  PEERID=$(ipfs config Identity.PeerID) &&
+  echo "self ($PEERID)" >expected &&
+  ipfs key use >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "generate a key" '
+  KEYID=$(ipfs key gen mykey --type=ed25519)
+'
+
+test_expect_success "key use fails with a missing key" '
+  test_must_fail ipfs key use missing 2>err &&
+  grep "no key named missing was found" err
+'
+
+test_expect_success "key use sets the default key" '
+  echo "Default key set to mykey ($KEYID)" >expected &&
+  ipfs key use mykey >actual &&
+  test_cmp expected actual &&
+  test "$(ipfs config Ipns.DefaultKey)" = mykey
+'
+
+test_expect_success "key list -l marks the default key" '
+  ipfs key list -l >list &&
+  grep "$KEYID\s\+mykey\s\+(default)" list &&
+  test_must_fail grep "self\s\+(default)" list
+'
+
+test_expect_success "key list without -l only lists the names" '
+  printf "mykey\nself\n" >expected &&
+  ipfs key list | sort >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "name publish uses the default key" '
+  HASH=$(echo "hello" | ipfs add -q) &&
+  echo "Published to $KEYID: /ipfs/$HASH" >expected &&
+  ipfs name publish --allow-offline $HASH >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "name publish --key overrides the default key" '
+  echo "Published to $PEERID: /ipfs/$HASH" >expected &&
+  ipfs name publish --allow-offline --key=self $HASH >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "name resolve resolves the default key" '
+  echo "/ipfs/$HASH" >expected &&
+  ipfs name resolve >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "the default key can't be removed" '
+  test_must_fail ipfs key rm mykey 2>err &&
+  grep "cannot remove the default key mykey" err
+'
+
+test_expect_success "renaming the default key keeps it the default" '
+  ipfs key rename mykey otherkey &&
+  test "$(ipfs config Ipns.DefaultKey)" = otherkey &&
+  echo "otherkey ($KEYID)" >expected &&
+  ipfs key use >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "key use self goes back to the node key" '
+  ipfs key use self &&
+  ipfs key rm otherkey &&
+  echo "self ($PEERID)" >expected &&
+  ipfs key use >actual &&
+  test_cmp expected actual
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "key use works with the daemon running" '
+  ipfs key gen daemonkey --type=ed25519 >daemonkey_id &&
+  ipfs key use daemonkey &&
+  ipfs key list -l | grep "$(cat daemonkey_id)\s\+daemonkey\s\+(default)"
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -43,6 +43,31 @@ test_dht() {
     ipfsi 1 dht get "/ipns/$PEERID_2" | grep -aq "/ipfs/$HASH"
   '
   
+  # ipfs dht bulk get <file>
+  test_expect_success 'bulk get reports every key' '
+    printf "/ipns/$PEERID_2\n\n# comment\nfoo\n" >keys &&
+    ipfsi 1 dht bulk get keys >records &&
+    grep "^foo error: " records &&
+    grep "^/ipns/$PEERID_2 " records >ipns_record &&
+    test $(wc -l <records) -eq 2
+  '
+
+  test_expect_success 'bulk get values are base64 encoded records' '
+    cut -d" " -f2 ipns_record | base64 -d | grep -aq "/ipfs/$HASH"
+  '
+
+  # ipfs dht bulk put <file>
+  test_expect_success 'bulk put accepts the output of bulk get' '
+    ipfsi 3 dht bulk put -c 2 <ipns_record >actual &&
+    echo "/ipns/$PEERID_2 ok" >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success 'bulk put reports malformed lines' '
+    echo "/ipns/$PEERID_2" | ipfsi 3 dht bulk put >actual &&
+    grep "^/ipns/$PEERID_2 error: expected a key and a base64 encoded value" actual
+  '
+
   test_expect_success 'put with bad keys fails (issue #5113)' '
     ipfsi 0 dht put "foo" "bar" >putted
     ipfsi 0 dht put "/pk/foo" "bar" >>putted
@@ -75,6 +100,32 @@ test_dht() {
   '
   
   
+  # ipfs dht provide <key>
+  test_expect_success 'provide reports the provided blocks' '
+    ipfsi 3 dht provide $HASH >actual &&
+    echo "provided 1 of 1 blocks" >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success 'provide --recursive provides the whole DAG' '
+    mkdir -p adir &&
+    echo "some more stuff" >adir/afile &&
+    echo "other stuff" >adir/bfile &&
+    DIR=$(ipfsi 3 add -r -Q adir) &&
+    ipfsi 3 dht provide -r --progress $DIR >actual &&
+    grep "^\[1/3\] provided " actual &&
+    grep "^\[3/3\] provided " actual &&
+    tail -n1 actual >summary &&
+    echo "provided 3 of 3 blocks" >expected &&
+    test_cmp expected summary
+  '
+
+  test_expect_success 'the recursively provided blocks are found' '
+    ipfsi 4 dht findprovs $(ipfsi 3 add -Q adir/bfile) > provs &&
+    iptb get id 3 > expected &&
+    test_cmp provs expected
+  '
+
   # ipfs dht query <peerID>
   ## We query 3 different keys, to statisically lower the chance that the queryer
   ## turns out to be the closest to what a key hashes to.
@@ -88,6 +139,28 @@ test_dht() {
     test_might_fail test_fsh cat actual
   '
 
+  test_expect_success 'query --verbose traces the steps of the query' '
+    ipfsi 3 dht query -v "$PEERID_0" >trace &&
+    grep "^ *[0-9]*\.[0-9]\{3\}s: \* querying " trace &&
+    grep "^ *[0-9]*\.[0-9]\{3\}s: \* .* says use .*(in [0-9.]*[mµ]*s)$" trace
+  '
+
+  test_expect_success 'query events are timed' '
+    ipfsi 3 dht query --enc=json "$PEERID_0" >trace_json &&
+    grep "\"Elapsed\":[0-9]" trace_json
+  '
+
+  test_expect_success 'findprovs --timeout' '
+    ipfsi 4 dht findprovs --timeout=30s $HASH > provs &&
+    iptb get id 3 > expected &&
+    test_cmp provs expected
+  '
+
+  test_expect_success 'findprovs rejects an invalid timeout' '
+    test_must_fail ipfsi 4 dht findprovs --timeout=soon $HASH 2> err &&
+    grep "invalid timeout" err
+  '
+
   test_expect_success 'stop iptb' '
     iptb stop
   '
@@ -0,0 +1,73 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the DHT client and server modes"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "listen on the loopback interface only" '
+  ipfs config --json Addresses.Swarm "[\"/ip4/127.0.0.1/tcp/0\"]"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "the DHT runs in server mode by default" '
+  echo "server" > expected &&
+  ipfs dht mode > actual &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_launch_ipfs_daemon --routing=dhtauto
+
+test_expect_success "an undialable dhtauto node runs the DHT in client mode" '
+  echo "client (auto)" > expected &&
+  ipfs dht mode > actual &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_launch_ipfs_daemon --routing=dhtserver
+
+test_expect_success "dhtserver always answers the DHT queries" '
+  echo "server" > expected &&
+  ipfs dht mode > actual &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "set Routing.Type to dhtclient" '
+  ipfs config Routing.Type dhtclient
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "dhtclient never answers the DHT queries" '
+  echo "client" > expected &&
+  ipfs dht mode > actual &&
+  test_cmp expected actual
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "set Routing.Type to none" '
+  ipfs config Routing.Type none
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "dht mode fails without a DHT" '
+  test_must_fail ipfs dht mode 2> err &&
+  grep "routing service is not a DHT" err
+'
+
+test_kill_ipfs_daemon
+
+test_done
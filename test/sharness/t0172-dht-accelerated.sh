@@ -0,0 +1,67 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the accelerated DHT client"
+
+. lib/test-lib.sh
+
+NUM_NODES=4
+
+test_expect_success "init iptb" '
+  iptb init -n $NUM_NODES --bootstrap=none --port=0
+'
+
+test_expect_success "enable the accelerated DHT client on node 1" '
+  ipfsi 1 features enable accelerated-dht-client
+'
+
+# the nodes listen on the loopback interface, they must answer the DHT
+# queries to be crawled
+startup_cluster $NUM_NODES --routing=dhtserver
+
+test_expect_success "peer ids" '
+  PEERID_1=$(iptb get id 1)
+'
+
+test_expect_success "the other nodes are crawled" '
+  for i in $(test_seq 1 60); do
+    ipfsi 1 stats dht >stats &&
+    grep "Accelerated client: 3 peers" stats && break
+    go-sleep 1s
+  done &&
+  grep "Accelerated client: 3 peers" stats &&
+  grep "Mode: server" stats
+'
+
+test_expect_success "the accelerated client is disabled on the other nodes" '
+  ipfsi 0 stats dht >stats_0 &&
+  grep "Accelerated client: disabled" stats_0
+'
+
+test_expect_success "providing goes to the closest peers" '
+  HASH=$(echo "accelerated" | ipfsi 1 add -q --local) &&
+  ipfsi 1 dht provide "$HASH" &&
+  ipfsi 3 dht findprovs "$HASH" >provs &&
+  grep "$PEERID_1" provs
+'
+
+test_expect_success "the IPNS records are published to the closest peers" '
+  ipfsi 1 name publish "/ipfs/$HASH" &&
+  ipfsi 2 name resolve "$PEERID_1" >resolved &&
+  echo "/ipfs/$HASH" >expected &&
+  test_cmp expected resolved
+'
+
+test_expect_success "the records are looked up from the closest peers" '
+  ipfsi 2 name publish "/ipfs/$HASH" &&
+  ipfsi 1 name resolve "$(iptb get id 2)" >resolved &&
+  test_cmp expected resolved
+'
+
+test_expect_success "stop iptb" '
+  iptb stop
+'
+
+test_done
@@ -0,0 +1,81 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the light client mode"
+
+. lib/test-lib.sh
+
+# node 0 is the delegate of the light client, node 2, and node 1 provides the
+# content
+test_expect_success "init iptb" '
+  iptb init -n 3 --bootstrap=none --port=0
+'
+
+test_expect_success "peer ids" '
+  PEERID_1=$(iptb get id 1)
+'
+
+test_expect_success "start the full nodes" '
+  iptb start [0-1] --args --routing=dhtserver
+'
+
+test_expect_success "the lowpower+ profile applies" '
+  ipfsi 2 config profile apply lowpower+ &&
+  ipfsi 2 features ls | grep "^light-client  *experimental  *enabled"
+'
+
+test_expect_success "the profile is listed" '
+  ipfsi 2 config profile --help >help &&
+  grep "lowpower+" help
+'
+
+test_expect_success "node 0 is the delegate" '
+  ipfsi 2 config --json Routing.Delegates "[\"$(cat "$IPTB_ROOT/0/api")\"]"
+'
+
+test_expect_success "start the light client" '
+  iptb start 2 &&
+  iptb connect [1-2] 0
+'
+
+test_expect_success "the subsystems are reported" '
+  ipfsi 2 diag sys >sys &&
+  grep "\"light_client\": true" sys &&
+  grep "\"dht\": \"disabled\"" sys &&
+  grep "\"gateway\": false" sys &&
+  grep "\"reprovider\": false" sys &&
+  ipfsi 0 diag sys >sys_0 &&
+  grep "\"light_client\": false" sys_0 &&
+  grep "\"dht\": \"server\"" sys_0
+'
+
+test_expect_success "the light client doesn't reprovide" '
+  test_must_fail ipfsi 2 bitswap reprovide 2>err &&
+  grep "light clients" err
+'
+
+test_expect_success "the providers are found through the delegate" '
+  HASH=$(echo "light client" | ipfsi 1 add -q) &&
+  ipfsi 1 dht provide "$HASH" &&
+  ipfsi 2 dht findprovs -n 1 "$HASH" >provs &&
+  echo "$PEERID_1" >expected &&
+  test_cmp expected provs
+'
+
+test_expect_success "the content is fetched from the provider" '
+  ipfsi 2 cat "$HASH" >actual &&
+  echo "light client" >expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the IPNS records aren't supported by the delegates" '
+  test_must_fail ipfsi 2 name publish "/ipfs/$HASH"
+'
+
+test_expect_success "stop iptb" '
+  iptb stop
+'
+
+test_done
@@ -0,0 +1,78 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the custom routing"
+
+. lib/test-lib.sh
+
+# node 0 is the delegate of node 2, node 1 provides the content
+test_expect_success "init iptb" '
+  iptb init -n 3 --bootstrap=none --port=0
+'
+
+test_expect_success "peer ids" '
+  PEERID_1=$(iptb get id 1)
+'
+
+test_expect_success "an unknown router is rejected" '
+  ipfsi 2 config Routing.Type custom &&
+  ipfsi 2 config Routing.Router main &&
+  ipfsi 2 config --json Routing.Routers "{\"main\": {\"Type\": \"parallel\", \"Parameters\": {\"Routers\": [{\"Router\": \"dht\"}]}}}" &&
+  test_must_fail ipfsi 2 daemon 2>err &&
+  grep "unknown router \"dht\"" err
+'
+
+test_expect_success "a router can't be part of itself" '
+  ipfsi 2 config --json Routing.Routers "{\"main\": {\"Type\": \"sequential\", \"Parameters\": {\"Routers\": [{\"Router\": \"main\"}]}}}" &&
+  test_must_fail ipfsi 2 daemon 2>err &&
+  grep "part of itself" err
+'
+
+test_expect_success "start the full nodes" '
+  iptb start [0-1] --args --routing=dhtserver
+'
+
+test_expect_success "route through the DHT and the delegate" '
+  ipfsi 2 config --json Routing.Routers "{
+    \"dht\": {\"Type\": \"dht\", \"Parameters\": {\"Mode\": \"client\"}},
+    \"delegate\": {\"Type\": \"delegated\", \"Parameters\": {\"Endpoints\": [\"$(cat "$IPTB_ROOT/0/api")\"]}},
+    \"main\": {\"Type\": \"parallel\", \"Parameters\": {\"Routers\": [
+      {\"Router\": \"dht\", \"Timeout\": \"30s\"},
+      {\"Router\": \"delegate\", \"Timeout\": \"10s\", \"Weight\": 2, \"IgnoreErrors\": true}
+    ]}}
+  }" &&
+  iptb start 2 &&
+  iptb connect 2 0
+'
+
+test_expect_success "the DHT is part of the routing" '
+  ipfsi 2 diag sys >sys &&
+  grep "\"dht\": \"client\"" sys
+'
+
+test_expect_success "the providers are found" '
+  HASH=$(echo "custom routing" | ipfsi 1 add -q) &&
+  iptb connect 1 0 &&
+  ipfsi 1 dht provide "$HASH" &&
+  ipfsi 2 dht findprovs -n 1 "$HASH" >provs &&
+  echo "$PEERID_1" >expected &&
+  test_cmp expected provs
+'
+
+test_expect_success "the content is fetched from the provider" '
+  ipfsi 2 cat "$HASH" >actual &&
+  echo "custom routing" >expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "the IPNS records are published through the DHT" '
+  ipfsi 2 name publish "/ipfs/$HASH"
+'
+
+test_expect_success "stop iptb" '
+  iptb stop
+'
+
+test_done
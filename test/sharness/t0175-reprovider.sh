@@ -121,6 +121,56 @@ test_expect_success 'stop peer 1' '
   iptb stop 1
 '
 
+# Test 'mfs' strategy
+init_strategy 'mfs'
+
+test_expect_success 'add test objects' '
+  HASH_FOO=$(echo foo | ipfsi 0 add -q --local) &&
+  HASH_BAR=$(echo bar | ipfsi 0 add -q --local --pin=false) &&
+  ipfsi 0 files cp /ipfs/$HASH_BAR /bar
+'
+
+findprovs_empty '$HASH_FOO'
+findprovs_empty '$HASH_BAR'
+
+reprovide
+
+findprovs_empty '$HASH_FOO'
+findprovs_expect '$HASH_BAR' '$PEERID_0'
+
+test_expect_success 'provide stat reports the reprovide' '
+  ipfsi 0 provide stat > stat_out &&
+  grep "Strategy: *mfs" stat_out &&
+  grep "Reproviding: *no" stat_out &&
+  grep "Last reprovide: .*, [0-9]* keys in " stat_out &&
+  ! grep "Last reprovide: *never" stat_out
+'
+
+test_expect_success 'stop peer 1' '
+  iptb stop 1
+'
+
+# Test 'flat' strategy
+init_strategy 'flat'
+
+test_expect_success 'add test object' '
+  HASH_0=$(echo "flat" | ipfsi 0 add -q --local --pin=false)
+'
+
+test_expect_success 'provide stat reports no reprovide yet' '
+  ipfsi 0 provide stat > stat_out &&
+  grep "Strategy: *flat" stat_out &&
+  grep "Last reprovide: *never" stat_out
+'
+
+findprovs_empty '$HASH_0'
+reprovide
+findprovs_expect '$HASH_0' '$PEERID_0'
+
+test_expect_success 'stop peer 1' '
+  iptb stop 1
+'
+
 # Test reprovider working with ticking disabled
 test_expect_success 'init iptb' '
   iptb init -f -n $NUM_NODES --bootstrap=none --port=0
@@ -0,0 +1,97 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the queue of the blocks to provide"
+
+. lib/test-lib.sh
+
+wait_provider() {
+  for i in $(test_seq 1 30); do
+    ipfsi 1 dht findprovs -n 1 "$1" > findprovsOut &&
+    test -s findprovsOut && return 0
+    sleep 1
+  done
+  return 1
+}
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+test_expect_success "peer id" '
+  PEERID_0=$(iptb get id 0)
+'
+
+test_expect_success "an invalid config is rejected" '
+  ipfsi 0 config --json Provider.Workers 0 &&
+  test_must_fail ipfsi 0 daemon 2> daemon_err &&
+  grep "invalid Provider config: the number of workers must be positive" daemon_err &&
+  ipfsi 0 config --json Provider.Workers 2
+'
+
+test_expect_success "start node 0 alone" '
+  iptb start 0
+'
+
+test_expect_success "add a file without peers to provide it to" '
+  HASH=$(echo "queued" | ipfsi 0 add -q)
+'
+
+test_expect_success "the new pin waits in the queue" '
+  ipfsi 0 provide stat > stat_out &&
+  grep "Queue: *1 blocks, 1 new pins" stat_out
+'
+
+test_expect_success "the root of a low priority pin waits with a low priority" '
+  HASH_LOW=$(echo "low" | ipfsi 0 add -q --local --pin=false) &&
+  ipfsi 0 pin add --priority=low $HASH_LOW &&
+  ipfsi 0 provide stat > stat_out &&
+  grep "Queue: *2 blocks, 1 new pins, 1 low priority" stat_out
+'
+
+test_expect_success "dht provide --priority queues the blocks without peers" '
+  HASH_QUEUED=$(echo "dht queued" | ipfsi 0 add -q --local) &&
+  ipfsi 0 dht provide --priority=high $HASH_QUEUED > provide_out &&
+  echo "queued 1 of 1 blocks" > provide_expected &&
+  test_cmp provide_expected provide_out
+'
+
+test_expect_success "an invalid priority is rejected" '
+  test_must_fail ipfsi 0 pin add --priority=urgent $HASH_LOW 2> pin_err &&
+  grep "invalid priority \"urgent\", expected high, normal or low" pin_err &&
+  test_must_fail ipfsi 0 dht provide --priority=urgent $HASH_LOW 2> provide_err &&
+  grep "invalid priority" provide_err
+'
+
+test_expect_success "stop node 0" '
+  iptb stop 0
+'
+
+startup_cluster 2
+
+test_expect_success "the queue was kept across the restart" '
+  wait_provider $HASH &&
+  echo $PEERID_0 > expected &&
+  test_cmp expected findprovsOut
+'
+
+test_expect_success "the queue is empty" '
+  ipfsi 0 provide stat > stat_out &&
+  grep "Queue: *0 blocks, 0 new pins" stat_out &&
+  grep "Queue provides: *[1-9][0-9]* provided" stat_out
+'
+
+test_expect_success "pin add provides the pin" '
+  HASH_PIN=$(echo "pinned" | ipfsi 0 add -q --local --pin=false) &&
+  ipfsi 0 pin add $HASH_PIN &&
+  wait_provider $HASH_PIN &&
+  test_cmp expected findprovsOut
+'
+
+test_expect_success "stop the cluster" '
+  iptb stop
+'
+
+test_done
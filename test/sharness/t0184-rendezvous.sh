@@ -0,0 +1,105 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test peer discovery through rendezvous points"
+
+. lib/test-lib.sh
+
+NUM_NODES=3
+test_expect_success 'init iptb' '
+  iptb init -n $NUM_NODES --bootstrap=none --port=0
+'
+
+test_expect_success 'make node 1 a rendezvous point' '
+  ipfsi 1 config --json Rendezvous.EnableServer true
+'
+
+test_expect_success 'start up nodes' '
+  iptb start --args --routing=none
+'
+
+test_expect_success 'peer ids' '
+  PEERID_0=$(iptb get id 0) &&
+  PEERID_1=$(iptb get id 1) &&
+  PEERID_2=$(iptb get id 2) &&
+  POINT="$(ipfsi 1 swarm addrs local | head -n1)/ipfs/$PEERID_1"
+'
+
+test_expect_success 'rendezvous commands require a rendezvous point' '
+  test_must_fail ipfsi 0 swarm rendezvous discover 2> err &&
+  grep "no rendezvous points" err
+'
+
+test_expect_success 'register nodes 0 and 2' '
+  ipfsi 0 swarm rendezvous register --point="$POINT" app > register_out &&
+  echo "registered in app at $PEERID_1 for 2h0m0s" > register_exp &&
+  test_cmp register_exp register_out &&
+  ipfsi 2 swarm rendezvous register --point="$POINT" --ttl=10m app
+'
+
+test_expect_success 'register node 2 in another namespace' '
+  ipfsi 2 swarm rendezvous register --point="$POINT" other
+'
+
+test_expect_success 'discover the peers of a namespace' '
+  ipfsi 0 swarm rendezvous discover --point="$POINT" app | sort > discover_out &&
+  printf "%s app\n%s app\n" $PEERID_0 $PEERID_2 | sort > discover_exp &&
+  test_cmp discover_exp discover_out
+'
+
+test_expect_success 'discover the peers of all namespaces' '
+  ipfsi 0 swarm rendezvous discover --point="$POINT" > discover_out &&
+  test $(wc -l < discover_out) -eq 3
+'
+
+test_expect_success 'nodes 0 and 2 are not connected' '
+  ipfsi 0 swarm peers > peers_out &&
+  test_must_fail grep $PEERID_2 peers_out
+'
+
+test_expect_success 'discover --connect connects to the peers' '
+  ipfsi 0 swarm rendezvous discover --point="$POINT" --connect app &&
+  ipfsi 0 swarm peers | grep $PEERID_2
+'
+
+test_expect_success 'a rendezvous point rejects invalid registrations' '
+  test_must_fail ipfsi 0 swarm rendezvous register --point="$POINT" --ttl=100h app 2> err &&
+  grep E_INVALID_TTL err
+'
+
+test_expect_success 'stop iptb' '
+  iptb stop
+'
+
+test_expect_success 'configure the rendezvous point and namespace' '
+  for i in 0 2; do
+    ipfsi $i config --json Rendezvous.Points "[\"$POINT\"]" &&
+    ipfsi $i config --json Rendezvous.Namespaces "[\"app2\"]" || return 1
+  done
+'
+
+test_expect_success 'restart nodes' '
+  iptb start --args --routing=none
+'
+
+test_expect_success 'nodes find each other through the configured namespace' '
+  for i in $(test_seq 1 50); do
+    ipfsi 0 swarm peers | grep $PEERID_2 && return 0
+    sleep 0.2
+  done
+  return 1
+'
+
+test_expect_success 'configured points are used by the commands' '
+  ipfsi 2 swarm rendezvous discover app2 | sort > discover_out &&
+  printf "%s app2\n%s app2\n" $PEERID_0 $PEERID_2 | sort > discover_exp &&
+  test_cmp discover_exp discover_out
+'
+
+test_expect_success 'stop iptb' '
+  iptb stop
+'
+
+test_done
@@ -0,0 +1,99 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test permanent peer connections"
+
+. lib/test-lib.sh
+
+# wait_peering_state <peer id> <connected|disconnected>
+wait_peering_state() {
+  for i in $(test_seq 1 50); do
+    ipfsi 0 swarm peering ls | grep -q "^$1 $2\$" && return 0
+    go-sleep 200ms
+  done
+  return 1
+}
+
+NUM_NODES=3
+test_expect_success 'init iptb' '
+  iptb init -n $NUM_NODES --bootstrap=none --port=0
+'
+
+test_expect_success 'start up nodes 1 and 2' '
+  iptb start [1-2] --args="--routing=none"
+'
+
+test_expect_success 'peer ids' '
+  PEERID_1=$(iptb get id 1) &&
+  PEERID_2=$(iptb get id 2) &&
+  ADDR_1=$(ipfsi 1 swarm addrs local | head -n1) &&
+  ADDR_2=$(ipfsi 2 swarm addrs local | head -n1)
+'
+
+test_expect_success 'configure node 1 as a peer of node 0' '
+  ipfsi 0 config --json Peering.Peers "[{\"ID\": \"$PEERID_1\", \"Addrs\": [\"$ADDR_1\"]}]"
+'
+
+test_expect_success 'start up node 0' '
+  iptb start 0 --args="--routing=none"
+'
+
+test_expect_success 'node 0 connects to the configured peer' '
+  wait_peering_state $PEERID_1 connected
+'
+
+test_expect_success 'node 0 is not connected to node 2' '
+  ipfsi 0 swarm peering ls > ls_out &&
+  echo "$PEERID_1 connected" > ls_exp &&
+  test_cmp ls_exp ls_out
+'
+
+test_expect_success 'add node 2 as a peer' '
+  ipfsi 0 swarm peering add "$ADDR_2/ipfs/$PEERID_2" > add_out &&
+  echo "add $PEERID_2 success" > add_exp &&
+  test_cmp add_exp add_out &&
+  wait_peering_state $PEERID_2 connected
+'
+
+test_expect_success 'peering ls --verbose lists the addresses' '
+  ipfsi 0 swarm peering ls -v > ls_out &&
+  grep "$ADDR_2" ls_out
+'
+
+test_expect_success 'dropped connections are reopened' '
+  ipfsi 0 swarm disconnect "/ipfs/$PEERID_1" &&
+  wait_peering_state $PEERID_1 connected
+'
+
+test_expect_success 'remove node 2' '
+  ipfsi 0 swarm peering rm $PEERID_2 > rm_out &&
+  echo "rm $PEERID_2 success" > rm_exp &&
+  test_cmp rm_exp rm_out &&
+  ipfsi 0 swarm peering ls > ls_out &&
+  test_must_fail grep $PEERID_2 ls_out
+'
+
+test_expect_success 'removed peers are not reconnected' '
+  ipfsi 0 swarm disconnect "/ipfs/$PEERID_2" &&
+  go-sleep 1s &&
+  ipfsi 0 swarm peers > peers_out &&
+  test_must_fail grep $PEERID_2 peers_out
+'
+
+test_expect_success 'removing an unknown peer fails' '
+  test_must_fail ipfsi 0 swarm peering rm $PEERID_2 2> rm_err &&
+  grep "not a peering peer" rm_err
+'
+
+test_expect_success 'node 0 cannot peer with itself' '
+  test_must_fail ipfsi 0 swarm peering add "/ipfs/$(iptb get id 0)" 2> add_err &&
+  grep "cannot peer with self" add_err
+'
+
+test_expect_success 'stop iptb' '
+  iptb stop
+'
+
+test_done
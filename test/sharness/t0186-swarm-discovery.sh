@@ -0,0 +1,86 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the mDNS discovery and ipfs swarm discovery"
+
+. lib/test-lib.sh
+
+# the nodes of a host only hear each other's announces on Linux
+test "$TEST_OS" = "LINUX" && test_set_prereq MDNS_LOOPBACK
+
+test_expect_success "init iptb" '
+  iptb init -n 3 --bootstrap=none --port=0
+'
+
+test_expect_success "peer ids" '
+  PEERID_0=$(iptb get id 0) &&
+  PEERID_1=$(iptb get id 1) &&
+  PEERID_2=$(iptb get id 2)
+'
+
+test_expect_success "swarm discovery fails offline" '
+  test_must_fail ipfsi 0 swarm discovery 2>err &&
+  grep "this command must be run in online mode" err
+'
+
+test_expect_success "an invalid service tag is rejected" '
+  ipfsi 0 config Discovery.MDNS.ServiceTag ipfs-test &&
+  test_must_fail ipfsi 0 daemon 2>err &&
+  grep "invalid service tag \"ipfs-test\"" err
+'
+
+test_expect_success "configure the discovery" '
+  for i in 0 1 2; do
+    ipfsi $i config --json Discovery.MDNS.Enabled true &&
+    ipfsi $i config --json Discovery.MDNS.Interval 1 || return 1
+  done &&
+  ipfsi 0 config Discovery.MDNS.ServiceTag _ipfs-test._udp &&
+  ipfsi 1 config Discovery.MDNS.ServiceTag _ipfs-test._udp
+'
+
+test_expect_success "start the nodes" '
+  iptb start --args --routing=none
+'
+
+test_expect_success MDNS_LOOPBACK "the nodes with the same tag find each other" '
+  for i in $(test_seq 1 50); do
+    ipfsi 0 swarm discovery | grep "^$PEERID_1 \+mdns " && return 0
+    sleep 0.2
+  done
+  return 1
+'
+
+test_expect_success MDNS_LOOPBACK "the peers discovered are connected" '
+  for i in $(test_seq 1 50); do
+    ipfsi 1 swarm peers | grep $PEERID_0 && return 0
+    sleep 0.2
+  done
+  return 1
+'
+
+test_expect_success "the nodes with another tag aren't found" '
+  ipfsi 0 swarm discovery >discovered &&
+  test_must_fail grep $PEERID_2 discovered
+'
+
+test_expect_success "swarm discovery lists the source of the peers" '
+  ipfsi 0 swarm discovery --enc=json >discovered.json &&
+  test_must_fail grep "\"Source\":\"[^m]" discovered.json &&
+  test_must_fail grep "\"Network\":\"[^i]" discovered.json
+'
+
+test_expect_success "swarm discovery fails with the discovery disabled" '
+  iptb stop 2 &&
+  ipfsi 2 config --json Discovery.MDNS.Enabled false &&
+  iptb start 2 --args --routing=none &&
+  test_must_fail ipfsi 2 swarm discovery 2>err &&
+  grep "local discovery is disabled" err
+'
+
+test_expect_success "stop iptb" '
+  iptb stop
+'
+
+test_done
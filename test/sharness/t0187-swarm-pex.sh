@@ -0,0 +1,89 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the peer exchange"
+
+. lib/test-lib.sh
+
+# wait_connected <node> <peer id>
+wait_connected() {
+  for i in $(test_seq 1 50); do
+    ipfsi $1 swarm peers | grep -q "$2" && return 0
+    go-sleep 200ms
+  done
+  return 1
+}
+
+# Network topology: 0 is connected to 1 and 2, and 3 finds them by connecting
+# to 0
+test_expect_success "init iptb" '
+  iptb init -n 4 --bootstrap=none --port=0
+'
+
+test_expect_success "peer ids" '
+  PEERID_0=$(iptb get id 0) &&
+  PEERID_1=$(iptb get id 1) &&
+  PEERID_2=$(iptb get id 2)
+'
+
+test_expect_success "stats pex fails when the peer exchange is disabled" '
+  iptb start 0 --args --routing=none &&
+  test_must_fail ipfsi 0 stats pex 2> pex_err &&
+  grep "peer exchange disabled in config" pex_err &&
+  iptb stop 0
+'
+
+test_expect_success "invalid config is rejected" '
+  ipfsi 0 config --json Swarm.PeerExchange "{\"Enabled\": true, \"SampleSize\": 100}" &&
+  test_must_fail ipfsi 0 daemon --routing=none 2> daemon_err &&
+  grep "invalid Swarm.PeerExchange config: SampleSize must be between 0 and 64" daemon_err
+'
+
+test_expect_success "enable the peer exchange" '
+  for i in 0 1 2 3; do
+    ipfsi $i config --json Swarm.PeerExchange.Enabled true || return 1
+  done &&
+  ipfsi 0 config --json Swarm.PeerExchange.SampleSize 16
+'
+
+test_expect_success "start up nodes" '
+  iptb start --args --routing=none
+'
+
+test_expect_success "connect 0 to 1 and 2" '
+  iptb connect 0 1 &&
+  iptb connect 0 2
+'
+
+test_expect_success "3 is not connected to 1 and 2" '
+  ipfsi 3 swarm peers > peers_out &&
+  test_must_fail grep "$PEERID_1" peers_out &&
+  test_must_fail grep "$PEERID_2" peers_out
+'
+
+test_expect_success "3 connects to 1 and 2 through 0" '
+  iptb connect 3 0 &&
+  wait_connected 3 "$PEERID_1" &&
+  wait_connected 3 "$PEERID_2"
+'
+
+test_expect_success "stats pex shows the exchanges" '
+  ipfsi 3 stats pex > pex_out_3 &&
+  grep "^PeersReceived: [2-9]" pex_out_3 &&
+  grep "^Dials: [2-9]" pex_out_3 &&
+  ipfsi 0 stats pex > pex_out_0 &&
+  grep "^Served: [1-9]" pex_out_0
+'
+
+test_expect_success "stats pex --enc=json" '
+  ipfsi 3 stats pex --enc=json > pex_json &&
+  grep "\"DialFailures\":0" pex_json
+'
+
+test_expect_success "stop iptb" '
+  iptb stop
+'
+
+test_done
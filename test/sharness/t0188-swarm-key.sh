@@ -0,0 +1,115 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the swarm key commands"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "swarm key show fails without a key" '
+  test_must_fail ipfs swarm key show 2> show_err &&
+  grep "no swarm key, generate one with .ipfs swarm key gen." show_err
+'
+
+test_expect_success "swarm key rotate fails without a key" '
+  test_must_fail ipfs swarm key rotate 2> rotate_err &&
+  grep "no swarm key" rotate_err
+'
+
+test_expect_success "daemon won't start with force pnet env but with no key" '
+  test_must_fail env LIBP2P_FORCE_PNET=1 go-timeout 5 ipfs daemon > daemon_out 2>&1 &&
+  grep "enforced by the environment (LIBP2P_FORCE_PNET), generate a swarm key with .ipfs swarm key gen." daemon_out
+'
+
+test_expect_success "swarm key gen generates a key" '
+  ipfs swarm key gen > gen_out &&
+  FP_1=$(sed -n "s/^Generated swarm key \([0-9a-f]*\)$/\1/p" gen_out) &&
+  test -n "$FP_1" &&
+  test -f "$IPFS_PATH/swarm.key" &&
+  head -n2 "$IPFS_PATH/swarm.key" > key_header &&
+  printf "/key/swarm/psk/1.0.0/\n/base16/\n" > key_header_exp &&
+  test_cmp key_header_exp key_header
+'
+
+test_expect_success "the key is only readable by its owner" '
+  ls -l "$IPFS_PATH/swarm.key" | grep "^-rw-------"
+'
+
+test_expect_success "swarm key gen fails with a key" '
+  test_must_fail ipfs swarm key gen 2> gen_err &&
+  grep "the repo has a swarm key already" gen_err
+'
+
+test_expect_success "swarm key show shows the fingerprint" '
+  echo "$FP_1" > show_exp &&
+  ipfs swarm key show > show_out &&
+  test_cmp show_exp show_out
+'
+
+test_expect_success "swarm key show --key shows the key" '
+  ipfs swarm key show --key > key_1 &&
+  test_cmp "$IPFS_PATH/swarm.key" key_1
+'
+
+test_expect_success "swarm key rotate replaces the key" '
+  ipfs swarm key rotate > rotate_out &&
+  grep "^Previous swarm key $FP_1$" rotate_out &&
+  FP_2=$(sed -n "s/^Rotated swarm key \([0-9a-f]*\)$/\1/p" rotate_out) &&
+  test -n "$FP_2" &&
+  test "$FP_1" != "$FP_2" &&
+  test_cmp key_1 "$IPFS_PATH/swarm.key.old"
+'
+
+test_expect_success "swarm key rotate rejects the current key" '
+  ipfs swarm key show --key > key_2 &&
+  test_must_fail ipfs swarm key rotate key_2 2> rotate_err &&
+  grep "the new swarm key is the current one" rotate_err
+'
+
+test_expect_success "swarm key rotate rejects an invalid key" '
+  echo "not a key" > invalid_key &&
+  test_must_fail ipfs swarm key rotate invalid_key 2> rotate_err &&
+  grep "invalid swarm key" rotate_err &&
+  test_cmp key_2 "$IPFS_PATH/swarm.key"
+'
+
+test_expect_success "swarm key rotate installs a key file" '
+  ipfs swarm key rotate key_1 > rotate_out &&
+  grep "^Previous swarm key $FP_2$" rotate_out &&
+  grep "^Rotated swarm key $FP_1$" rotate_out &&
+  test_cmp key_1 "$IPFS_PATH/swarm.key"
+'
+
+LIBP2P_FORCE_PNET=1 test_launch_ipfs_daemon
+
+test_expect_success "the daemon uses the key" '
+  grep "Swarm key fingerprint: $FP_1" actual_daemon
+'
+
+test_expect_success "swarm key rotate on a running daemon needs a restart" '
+  ipfs swarm key rotate key_2 > rotate_out &&
+  grep "^Rotated swarm key $FP_2$" rotate_out &&
+  grep "^The daemon uses the swarm key $FP_1 until it is restarted$" rotate_out &&
+  ipfs swarm key show --enc=json > show_json &&
+  grep "\"Fingerprint\":\"$FP_2\"" show_json &&
+  grep "\"Active\":\"$FP_1\"" show_json &&
+  grep "\"Restart\":true" show_json
+'
+
+test_kill_ipfs_daemon
+
+test_launch_ipfs_daemon
+
+test_expect_success "the daemon uses the new key once restarted" '
+  grep "Swarm key fingerprint: $FP_2" actual_daemon &&
+  echo "$FP_2" > show_exp &&
+  ipfs swarm key show > show_out &&
+  test_cmp show_exp show_out
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -77,6 +77,25 @@ test_expect_success "'ipfs bitswap wantlist -p' output looks good" '
   test_cmp wantlist_out wantlist_p_out
 '
 
+test_expect_success "'ipfs bitswap sessions' fails without peer ranking" '
+  test_must_fail ipfs bitswap sessions 2>sessions_err &&
+  grep "bitswap peer ranking is not enabled" sessions_err
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "enable bitswap peer ranking" '
+  ipfs features enable bitswap-peer-ranking
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "'ipfs bitswap sessions' works" '
+  ipfs bitswap sessions >sessions_out &&
+  echo "PEER LATENCY RATE SCORE" >expected &&
+  test_cmp expected sessions_out
+'
+
 test_kill_ipfs_daemon
 
 test_done
@@ -0,0 +1,66 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test the content availability reports"
+
+. lib/test-lib.sh
+
+test_expect_success "init iptb" '
+  iptb init -n 2 --bootstrap=none --port=0
+'
+
+startup_cluster 2
+
+test_expect_success "B adds content" '
+  echo "available" > file &&
+  HASH=$(ipfsi 1 add -q file) &&
+  for i in $(test_seq 1 50); do
+    ipfsi 0 dht findprovs $HASH | grep $(iptb get id 1) && break
+    go-sleep 200ms
+  done
+'
+
+test_expect_success "availability fails without a series" '
+  test_must_fail ipfsi 0 stats availability $HASH 2> err &&
+  grep "no availability series" err
+'
+
+test_expect_success "availability rejects invalid options" '
+  test_must_fail ipfsi 0 stats availability notacid --duration=1h &&
+  test_must_fail ipfsi 0 stats availability $HASH --interval=1s &&
+  test_must_fail ipfsi 0 stats availability $HASH --interval=2h --duration=1h
+'
+
+test_expect_success "availability starts a series" '
+  ipfsi 0 stats availability $HASH --duration=2h > start_out &&
+  grep "^$HASH every 1h0m0s for 2h0m0s, until .*: probing$" start_out
+'
+
+test_expect_success "a series can't be started twice" '
+  test_must_fail ipfsi 0 stats availability $HASH --duration=2h 2> err &&
+  grep "already being probed" err
+'
+
+test_expect_success "the first probe finds B" '
+  for i in $(test_seq 1 50); do
+    ipfsi 0 stats availability $HASH > series_out &&
+    grep "Available:" series_out && break
+    go-sleep 200ms
+  done &&
+  grep " available, 1 providers, 1 reachable$" series_out &&
+  grep "^Available: 1/1 probes$" series_out
+'
+
+test_expect_success "the series is recorded as json" '
+  ipfsi 0 stats availability --enc=json $HASH > series_json &&
+  grep "\"Probing\":true" series_json &&
+  grep "\"Available\":true" series_json
+'
+
+test_expect_success "stop nodes" '
+  iptb stop
+'
+
+test_done
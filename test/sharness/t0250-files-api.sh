@@ -597,6 +597,69 @@ test_files_api() {
     ipfs files ls /adir | grep foobar
   '
 
+  test_expect_success "can export a directory $EXTRA" '
+    rm -rf adir_export &&
+    ipfs files export /adir adir_export &&
+    echo "blah" > export_expected &&
+    test_cmp export_expected adir_export/foobar
+  '
+
+  test_expect_success "can touch a new file $EXTRA" '
+    ipfs files touch --mtime=1546300800 /touched &&
+    ipfs files stat /touched > touched_stat &&
+    grep "^Type: file" touched_stat &&
+    grep "^Mtime: 2019-01-01T00:00:00Z" touched_stat
+  '
+
+  test_expect_success "can chmod a file $EXTRA" '
+    TOUCHED=$(ipfs files stat --hash /touched) &&
+    ipfs files chmod 0600 /touched &&
+    ipfs files stat /touched | grep "^Mode: 0600" &&
+    test "$(ipfs files stat --hash /touched)" != "$TOUCHED"
+  '
+
+  test_expect_success "export restores the mode and mtime $EXTRA" '
+    rm -f touched_export &&
+    ipfs files export /touched touched_export &&
+    test "$(generic_stat touched_export)" = "-rw-------" &&
+    touch -t 201901020000 touched_ref &&
+    test touched_export -ot touched_ref
+  '
+
+  test_expect_success "metadata follows files around $EXTRA" '
+    ipfs files mv /touched /touched2 &&
+    ipfs files stat /touched2 | grep "^Mode: 0600" &&
+    ipfs files cp /touched2 /touched3 &&
+    ipfs files stat /touched3 | grep "^Mtime: 2019-01-01T00:00:00Z" &&
+    ipfs files rm /touched2 &&
+    ipfs files rm /touched3 &&
+    ipfs files touch /touched3 &&
+    ipfs files stat /touched3 | test_must_fail grep "^Mode:" &&
+    ipfs files rm /touched3
+  '
+
+  test_expect_success "write keeps the mode of a file $EXTRA" '
+    ipfs files touch /touched4 &&
+    ipfs files chmod 0640 /touched4 &&
+    echo "written" | ipfs files write /touched4 &&
+    ipfs files stat /touched4 | grep "^Mode: 0640" &&
+    echo "written" > touched_expected &&
+    ipfs files read /touched4 > touched_read &&
+    test_cmp touched_expected touched_read &&
+    ipfs files rm /touched4
+  '
+
+  if [ -z "$ONLINE" ]; then
+    test_expect_success "add --preserve-mode records the mode $EXTRA" '
+      echo "preserved" > preserved &&
+      chmod 0640 preserved &&
+      PRESERVED=$(ipfs add -q --preserve-mode preserved) &&
+      ipfs files cp /ipfs/$PRESERVED /preserved &&
+      ipfs files stat /preserved | grep "^Mode: 0640" &&
+      ipfs files rm /preserved
+    '
+  fi
+
   test_expect_success "should fail to write file and create intermediate directories with no --parents flag set $EXTRA" '
     echo "ipfs rocks" | test_must_fail ipfs files write --create /parents/foo/ipfs.txt
   '
@@ -726,4 +789,25 @@ test_sharding "(cidv1 root)" "--cid-version=1"
 
 test_kill_ipfs_daemon
 
+test_expect_success "files write uses Import.Chunker" '
+  ipfs config Import.Chunker size-1024 &&
+  random 4000 42 > chunked &&
+  ipfs files write --create /chunked < chunked &&
+  ipfs config --json Import {} &&
+  ipfs files read /chunked > chunked_out &&
+  test_cmp chunked chunked_out &&
+  ipfs object links $(ipfs files stat --hash /chunked) > chunked_links &&
+  test_line_count = 4 chunked_links
+'
+
+test_expect_success "files write appends with Import.Chunker" '
+  ipfs config Import.Chunker size-1024 &&
+  random 1000 43 > chunked_tail &&
+  ipfs files write --offset 4000 /chunked < chunked_tail &&
+  ipfs config --json Import {} &&
+  cat chunked chunked_tail > chunked_expected &&
+  ipfs files read /chunked > chunked_out &&
+  test_cmp chunked_expected chunked_out
+'
+
 test_done
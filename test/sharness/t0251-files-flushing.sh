@@ -50,4 +50,49 @@ test_expect_success "file is still there" '
 
 test_kill_ipfs_daemon
 
+test_expect_success "enable files write-back" '
+  ipfs config --json Mfs.WriteBack true &&
+  ipfs config Mfs.FlushInterval 1h
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "can change files without flushing" '
+  echo "bar" | ipfs files write --create /wb-file &&
+  ipfs files mkdir /wb-dir &&
+  echo "baz" | ipfs files write --create /wb-dir/baz &&
+  ipfs files rm /file
+'
+
+verify_dir_contents / wb-dir wb-file
+
+test_expect_success "kill the daemon without a clean shutdown" '
+  kill -9 $IPFS_PID &&
+  { wait $IPFS_PID; true; }
+'
+
+test_launch_ipfs_daemon
+
+verify_dir_contents / wb-dir wb-file
+
+test_expect_success "unflushed writes were replayed from the journal" '
+  echo "baz" >expected &&
+  ipfs files read /wb-dir/baz >actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "--flush still flushes right away" '
+  echo "qux" | ipfs files write --flush --create /wb-flushed &&
+  ipfs files stat --hash / >root_hash
+'
+
+test_kill_ipfs_daemon
+
+verify_dir_contents / wb-dir wb-file wb-flushed
+
+test_expect_success "clean shutdown kept the flushed root" '
+  ipfs files stat --hash / >root_hash_after &&
+  test_cmp root_hash root_hash_after
+'
+
 test_done
@@ -0,0 +1,85 @@
+#!/usr/bin/env bash
+
+test_description="test the files sync command"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create local directory" '
+  mkdir -p site/css &&
+  echo "index" >site/index.html &&
+  echo "style" >site/css/style.css &&
+  echo "secret" >site/.hidden
+'
+
+test_expect_success "first sync adds everything" '
+  ipfs files sync site /site >actual &&
+  printf "add /site\nadd /site/css\nadd /site/css/style.css\nadd /site/index.html\n" >expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "synced files have the right content" '
+  ipfs files read /site/index.html >actual &&
+  test_cmp site/index.html actual &&
+  ipfs files read /site/css/style.css >actual &&
+  test_cmp site/css/style.css actual
+'
+
+test_expect_success "hidden files are skipped" '
+  test_must_fail ipfs files stat /site/.hidden
+'
+
+test_expect_success "syncing again changes nothing" '
+  ipfs files sync site /site >actual &&
+  test_must_be_empty actual
+'
+
+test_expect_success "modify the local directory" '
+  echo "new index" >site/index.html &&
+  echo "about" >site/about.html &&
+  rm site/css/style.css
+'
+
+test_expect_success "dry run reports changes without applying them" '
+  ipfs files sync --dry-run --delete site /site >actual &&
+  printf "add /site/about.html\ndelete /site/css/style.css\nupdate /site/index.html\n" >expected &&
+  sort actual >actual_sorted &&
+  test_cmp expected actual_sorted &&
+  test_must_fail ipfs files stat /site/about.html
+'
+
+test_expect_success "sync without --delete keeps removed files" '
+  ipfs files sync site /site >actual &&
+  printf "add /site/about.html\nupdate /site/index.html\n" >expected &&
+  sort actual >actual_sorted &&
+  test_cmp expected actual_sorted &&
+  ipfs files stat /site/css/style.css
+'
+
+test_expect_success "sync with --delete removes them" '
+  ipfs files sync --delete site /site >actual &&
+  echo "delete /site/css/style.css" >expected &&
+  test_cmp expected actual &&
+  ipfs files read /site/index.html >actual &&
+  test_cmp site/index.html actual
+'
+
+test_expect_success "touched but unchanged files are not updated" '
+  touch -d "2001-01-01 00:00:00" site/about.html &&
+  ipfs files sync site /site >actual &&
+  test_must_be_empty actual
+'
+
+test_expect_success "touched files keep their new mtime" '
+  ipfs files stat /site/about.html >actual &&
+  grep "^Mtime: 200[01]-" actual
+'
+
+test_expect_success "result matches ipfs add with the file metadata" '
+  ipfs add -r -Q --preserve-mode --preserve-mtime site >expected &&
+  ipfs files stat --hash /site >actual &&
+  test_cmp expected actual
+'
+
+test_done
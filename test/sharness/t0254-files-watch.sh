@@ -0,0 +1,53 @@
+#!/usr/bin/env bash
+
+test_description="test the files watch command"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "files watch fails without a daemon" '
+  test_must_fail ipfs files watch 2>err &&
+  grep "requires a running daemon" err
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "start watching /foo" '
+  ipfs files watch /foo >watch_out &
+  WATCHPID=$!
+  go-sleep 500ms
+'
+
+test_expect_success "make changes" '
+  ipfs files mkdir /foo &&
+  echo "hello" | ipfs files write --create /foo/bar &&
+  ipfs files mkdir /unrelated &&
+  ipfs files mv /foo/bar /foo/baz &&
+  ipfs files rm /foo/baz &&
+  go-sleep 500ms
+'
+
+test_expect_success "stop watching" '
+  kill $WATCHPID
+  wait $WATCHPID || true
+'
+
+test_expect_success "changes to /foo were reported" '
+  grep -v "^publish" watch_out | cut -d" " -f1,2 >actual &&
+  printf "mkdir /foo\nwrite /foo/bar\nmv /foo/baz\nrm /foo/baz\n" >expected &&
+  test_cmp expected actual
+'
+
+test_expect_success "moves report their source" '
+  grep "^mv /foo/baz /foo/bar " watch_out
+'
+
+test_expect_success "flushed changes carry the root hash" '
+  grep "^mkdir /foo " watch_out | cut -d" " -f3 >root_hash &&
+  test -s root_hash
+'
+
+test_kill_ipfs_daemon
+
+test_done
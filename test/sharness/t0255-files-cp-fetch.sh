@@ -0,0 +1,65 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test fetching the DAG copied by ipfs files cp"
+
+. lib/test-lib.sh
+
+test_expect_success "set up a two node testbed" '
+  iptb init -n 2 -p 0 -f --bootstrap=none
+'
+
+startup_cluster 2
+
+test_expect_success "add files on node 1" '
+  random 1000000 1 > lazyfile &&
+  random 1000000 2 > fetchedfile &&
+  random 1000000 3 > configfile &&
+  LAZY_HASH=$(ipfsi 1 add -q lazyfile) &&
+  FETCHED_HASH=$(ipfsi 1 add -q fetchedfile) &&
+  CONFIG_HASH=$(ipfsi 1 add -q configfile)
+'
+
+test_expect_success "files cp only copies the root by default" '
+  ipfsi 0 files cp /ipfs/$LAZY_HASH /lazy &&
+  ipfsi 0 files stat --with-local /lazy > stat_out &&
+  test_must_fail grep "(100.00%)" stat_out
+'
+
+test_expect_success "files cp --fetch fetches the whole DAG" '
+  ipfsi 0 files cp --fetch --progress /ipfs/$FETCHED_HASH /fetched 2> progress &&
+  grep "Fetched [0-9]* nodes" progress &&
+  ipfsi 0 files stat --with-local /fetched > stat_out &&
+  grep "(100.00%)" stat_out
+'
+
+test_expect_success "Mfs.FetchOnCopy sets the default of --fetch" '
+  ipfsi 0 config --json Mfs.FetchOnCopy true &&
+  ipfsi 0 files cp /ipfs/$CONFIG_HASH /config &&
+  ipfsi 0 files stat --with-local /config > stat_out &&
+  grep "(100.00%)" stat_out
+'
+
+test_expect_success "--fetch=false overrides Mfs.FetchOnCopy" '
+  ipfsi 0 files rm /lazy &&
+  ipfsi 0 files cp --fetch=false /ipfs/$LAZY_HASH /lazy &&
+  ipfsi 0 files stat --with-local /lazy > stat_out &&
+  test_must_fail grep "(100.00%)" stat_out
+'
+
+test_expect_success "shut down node 1" '
+  iptb stop 1
+'
+
+test_expect_success "the fetched file is still available" '
+  ipfsi 0 files read /fetched > fetched_out &&
+  test_cmp fetchedfile fetched_out
+'
+
+test_expect_success "shut down nodes" '
+  iptb stop && iptb_wait_stop
+'
+
+test_done
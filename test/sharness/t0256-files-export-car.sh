@@ -0,0 +1,79 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs files export-car"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "create files to add" '
+  mkdir -p mydir/sub &&
+  echo "hello" > mydir/hello.txt &&
+  echo "world" > mydir/sub/world.txt &&
+  random 1000000 42 > mydir/big
+'
+
+test_export_car() {
+  test_expect_success "copy a directory to mfs ($1)" '
+    HASH=$(ipfs add -q -r --car-output=expected.car mydir | tail -n1) &&
+    ipfs files cp /ipfs/$HASH /mydir
+  '
+
+  test_expect_success "export-car writes the DAG of the directory ($1)" '
+    ipfs files export-car /mydir -o exported.car &&
+    test_cmp expected.car exported.car
+  '
+
+  test_expect_success "export-car writes to stdout without -o ($1)" '
+    ipfs files export-car /mydir > stdout.car &&
+    test_cmp expected.car stdout.car
+  '
+
+  test_expect_success "export-car exports a file ($1)" '
+    ipfs add -q --car-output=file.car mydir/big &&
+    ipfs files export-car /mydir/big -o exported.car &&
+    test_cmp file.car exported.car
+  '
+
+  test_expect_success "the export is a snapshot of the changed directory ($1)" '
+    echo "changed" | ipfs files write --create /mydir/changed.txt &&
+    ipfs files export-car /mydir -o changed.car &&
+    test_must_fail test_cmp expected.car changed.car &&
+    grep -a "changed" changed.car
+  '
+
+  test_expect_success "export-car fails on a missing path ($1)" '
+    test_must_fail ipfs files export-car /missing -o missing.car 2>err &&
+    grep "cannot get node from path /missing" err &&
+    test ! -e missing.car
+  '
+
+  test_expect_success "the gc can run after an export ($1)" '
+    ipfs repo gc >/dev/null
+  '
+
+  test_expect_success "clean up ($1)" '
+    ipfs files rm -r /mydir &&
+    rm -f expected.car exported.car stdout.car file.car changed.car
+  '
+}
+
+test_export_car offline
+
+test_launch_ipfs_daemon
+
+test_export_car online
+
+test_expect_success "export-car over the HTTP API" '
+  HASH=$(ipfs add -q -r --car-output=expected.car mydir | tail -n1) &&
+  ipfs files cp /ipfs/$HASH /mydir &&
+  curl -sf -X POST "http://$API_ADDR/api/v0/files/export-car?arg=/mydir" > http.car &&
+  test_cmp expected.car http.car
+'
+
+test_kill_ipfs_daemon
+
+test_done
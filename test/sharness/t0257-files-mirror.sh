@@ -0,0 +1,133 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test mirroring the files of another node with ipfs files mirror"
+
+. lib/test-lib.sh
+
+test_expect_success "set up a two node testbed" '
+  iptb init -n 2 -p 0 -f --bootstrap=none
+'
+
+startup_cluster 2
+
+test_expect_success "write files on node 1" '
+  echo "first" > first &&
+  echo "second" > second &&
+  ipfsi 1 files write --create /mirrored first &&
+  API_1=$(cat "$IPTB_ROOT/1/api") &&
+  PEERID_1=$(iptb get id 1)
+'
+
+test_expect_success "files mirror start mirrors the mfs root of a node API" '
+  ipfsi 0 files mirror start --from="$API_1" --path=/replica --interval=1s
+'
+
+test_expect_success "the mirror gets the files of node 1" '
+  for i in $(test_seq 1 50); do
+    ipfsi 0 files read /replica/mirrored > replica_out 2>/dev/null && break
+    sleep 0.2
+  done &&
+  test_cmp first replica_out
+'
+
+test_expect_success "the mirror follows the changes of node 1" '
+  ipfsi 1 files write --truncate /mirrored second &&
+  for i in $(test_seq 1 50); do
+    ipfsi 0 files read /replica/mirrored > replica_out 2>/dev/null &&
+    test_cmp second replica_out 2>/dev/null && break
+    sleep 0.2
+  done &&
+  test_cmp second replica_out
+'
+
+test_expect_success "files mirror status shows the mirror" '
+  ipfsi 0 files mirror status > status_out &&
+  ROOT_1=$(ipfsi 1 files stat --hash /) &&
+  grep "^/replica $API_1 $ROOT_1 lag " status_out
+'
+
+test_expect_success "the mirrored path is read-only" '
+  test_must_fail ipfsi 0 files rm -r /replica 2> rm_err &&
+  grep "read-only" rm_err &&
+  test_must_fail ipfsi 0 files write --create /replica/new first 2> write_err &&
+  grep "read-only" write_err &&
+  test_must_fail ipfsi 0 files mv /replica /moved 2> mv_err &&
+  grep "read-only" mv_err
+'
+
+test_expect_success "files mirror start rejects overlapping paths" '
+  test_must_fail ipfsi 0 files mirror start --from="$API_1" --path=/replica/sub 2> start_err &&
+  grep "overlaps the mirror /replica" start_err
+'
+
+test_expect_success "restart node 0" '
+  iptb stop 0 &&
+  iptb start 0 &&
+  iptb connect 0 1
+'
+
+test_expect_success "the mirror is resumed when the daemon restarts" '
+  ipfsi 0 files mirror status > status_out &&
+  grep "^/replica $API_1 " status_out
+'
+
+test_expect_success "the resumed mirror follows the changes of node 1" '
+  ipfsi 1 files write --truncate /mirrored first &&
+  for i in $(test_seq 1 50); do
+    ipfsi 0 files read /replica/mirrored > replica_out 2>/dev/null &&
+    test_cmp first replica_out 2>/dev/null && break
+    sleep 0.2
+  done &&
+  test_cmp first replica_out &&
+  ipfsi 1 files write --truncate /mirrored second &&
+  for i in $(test_seq 1 50); do
+    ipfsi 0 files read /replica/mirrored > replica_out 2>/dev/null &&
+    test_cmp second replica_out 2>/dev/null && break
+    sleep 0.2
+  done &&
+  test_cmp second replica_out
+'
+
+test_expect_success "files mirror stop keeps the content" '
+  ipfsi 0 files mirror stop --path=/replica &&
+  ipfsi 0 files mirror status > status_out &&
+  test_must_be_empty status_out &&
+  ipfsi 0 files read /replica/mirrored > replica_out &&
+  test_cmp second replica_out &&
+  ipfsi 0 files rm -r /replica
+'
+
+test_expect_success "files mirror stop fails on a path which isn't mirrored" '
+  test_must_fail ipfsi 0 files mirror stop --path=/replica 2> stop_err &&
+  grep "/replica is not mirrored" stop_err
+'
+
+test_expect_success "files mirror start mirrors the root published by a peer" '
+  ipfsi 1 name publish "/ipfs/$(ipfsi 1 files stat --hash /)" &&
+  ipfsi 0 files mirror start --from="/ipns/$PEERID_1" --path=/site --interval=1s &&
+  for i in $(test_seq 1 50); do
+    ipfsi 0 files read /site/mirrored > site_out 2>/dev/null && break
+    sleep 0.2
+  done &&
+  test_cmp second site_out &&
+  ipfsi 0 files mirror stop --path=/site
+'
+
+test_expect_success "files mirror start validates its options" '
+  test_must_fail ipfsi 0 files mirror start --path=/x 2> err &&
+  grep "missing --from" err &&
+  test_must_fail ipfsi 0 files mirror start --from="$API_1" 2> err &&
+  grep "missing --path" err &&
+  test_must_fail ipfsi 0 files mirror start --from="$API_1" --path=/ 2> err &&
+  test_must_fail ipfsi 0 files mirror start --from="$API_1" --path=/x --interval=0s 2> err &&
+  grep "invalid interval" err
+'
+
+test_expect_success "shut down nodes" '
+  iptb stop && iptb_wait_stop
+'
+
+test_done
@@ -116,4 +116,69 @@ test_add_large_dir_v1 "$SHARDEDV1"
 
 test_kill_ipfs_daemon
 
+test_expect_success "disable sharding and lower the automatic threshold" '
+  ipfs config --json Experimental.ShardingEnabled false &&
+  ipfs config --json UnixFS.ShardingEntryThreshold 1000
+'
+
+test_add_large_dir "$SHARDED"
+
+test_expect_success "small directories are not sharded" '
+  mkdir smalldir &&
+  echo a > smalldir/a &&
+  ipfs add -r -Q smalldir > small_hash &&
+  ipfs object links $(cat small_hash) | awk "{print \$3}" > small_links &&
+  echo a > expected &&
+  test_cmp expected small_links
+'
+
+test_expect_success "lower the threshold for the files API" '
+  ipfs config --json UnixFS.ShardingEntryThreshold 2
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "files directories get sharded when they grow" '
+  ipfs files mkdir /auto &&
+  for i in 1 2 3; do
+    echo $i | ipfs files write --create /auto/file$i || return 1
+  done &&
+  ipfs object links $(ipfs files stat --hash /auto) | awk "{print \$3}" > auto_links &&
+  test_must_fail grep -x file1 auto_links
+'
+
+test_expect_success "sharded files directories can be read" '
+  ipfs files ls /auto | sort > actual &&
+  printf "file1\nfile2\nfile3\n" > expected &&
+  test_cmp expected actual &&
+  echo 2 > expected &&
+  ipfs files read /auto/file2 > actual &&
+  test_cmp expected actual
+'
+
+test_expect_success "files directories get unsharded when they shrink" '
+  ipfs files rm /auto/file3 &&
+  ipfs object links $(ipfs files stat --hash /auto) | awk "{print \$3}" | sort > auto_links &&
+  printf "file1\nfile2\n" > expected &&
+  test_cmp expected auto_links
+'
+
+test_expect_success "the files root gets sharded when it grows" '
+  echo a | ipfs files write --create /rootfile1 &&
+  echo b | ipfs files write --create /rootfile2 &&
+  ipfs object links $(ipfs files stat --hash /) | awk "{print \$3}" > root_links &&
+  test_must_fail grep -x auto root_links
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "the sharded files root is kept across restarts" '
+  ipfs files ls / | sort > actual &&
+  printf "auto\nrootfile1\nrootfile2\n" > expected &&
+  test_cmp expected actual &&
+  echo b > expected &&
+  ipfs files read /rootfile2 > actual &&
+  test_cmp expected actual
+'
+
 test_done
@@ -39,6 +39,22 @@ test_dag_git() {
   test_expect_success "outputs look correct" '
     test_cmp file1 out1
   '
+
+  test_expect_success "tree entries resolve to their objects" '
+    ipfs dag get z8mWaJh5RLq16Zwgtd8gZxd63P4hgwNNx/object/parents/0/tree/dir2/f3 > out2 &&
+    test_cmp file1 out2
+  '
+
+  test_expect_success "add objects with the git-raw codec" '
+    find objects -type f -exec ipfs dag put --store-codec=git-raw --input-codec=zlib {} \; -exec echo \; > hashes_codec &&
+    test_cmp hashes hashes_codec
+  '
+
+  test_expect_success "dag put reports the git-raw codec" '
+    OBJ=$(find objects -type f | head -1) &&
+    ipfs dag put --store-codec=git-raw --input-codec=zlib --enc=json "$OBJ" > git_codec_out &&
+    grep "\"Codec\":\"git-raw\"" git_codec_out
+  '
 }
 
 # should work offline
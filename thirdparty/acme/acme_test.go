@@ -0,0 +1,388 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCA is an ACME server issuing certificates once it could fetch the
+// answers of the http-01 challenges from the manager.
+type fakeCA struct {
+	t      *testing.T
+	srv    *httptest.Server
+	answer http.Handler
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu       sync.Mutex
+	nonce    int
+	account  *ecdsa.PublicKey
+	domains  []string
+	tokens   map[string]string // token -> domain
+	valid    map[string]bool   // domain -> validated
+	chainPEM []byte
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	ca := &fakeCA{
+		t:      t,
+		tokens: make(map[string]string),
+		valid:  make(map[string]bool),
+	}
+
+	var err error
+	ca.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &ca.caKey.PublicKey, ca.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.caCert, _ = x509.ParseCertificate(der)
+
+	ca.srv = httptest.NewServer(http.HandlerFunc(ca.serve))
+	return ca
+}
+
+func (ca *fakeCA) url(path string) string {
+	return ca.srv.URL + path
+}
+
+func (ca *fakeCA) newNonce(w http.ResponseWriter) {
+	ca.mu.Lock()
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", ca.nonce))
+	ca.mu.Unlock()
+}
+
+// payload checks the JWS of the request and returns its payload.
+func (ca *fakeCA) payload(r *http.Request) ([]byte, error) {
+	var jws struct {
+		Protected, Payload, Signature string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg, Nonce, URL, Kid string
+		Jwk                  *struct{ X, Y string }
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "ES256" || header.URL != ca.url(r.URL.Path) || header.Nonce == "" {
+		return nil, fmt.Errorf("invalid header %s", protected)
+	}
+
+	ca.mu.Lock()
+	pub := ca.account
+	ca.mu.Unlock()
+	if header.Jwk != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(header.Jwk.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.Jwk.Y)
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if header.Kid != ca.url("/account/1") {
+		return nil, fmt.Errorf("unknown account %s", header.Kid)
+	}
+	if pub == nil {
+		return nil, fmt.Errorf("no account")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil || len(sig) != 64 {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	sum := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if !ecdsa.Verify(pub, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, fmt.Errorf("bad signature")
+	}
+	if header.Jwk != nil {
+		ca.mu.Lock()
+		ca.account = pub
+		ca.mu.Unlock()
+	}
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
+
+func (ca *fakeCA) serve(w http.ResponseWriter, r *http.Request) {
+	ca.newNonce(w)
+	if r.URL.Path == "/directory" {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   ca.url("/nonce"),
+			"newAccount": ca.url("/account"),
+			"newOrder":   ca.url("/order"),
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	payload, err := ca.payload(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"type":"urn:ietf:params:acme:error:malformed","detail":%q}`, err.Error())
+		return
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/account":
+		w.Header().Set("Location", ca.url("/account/1"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+
+	case r.URL.Path == "/order":
+		var req struct{ Identifiers []identifier }
+		json.Unmarshal(payload, &req)
+		ca.domains = nil
+		var authzs []string
+		for _, id := range req.Identifiers {
+			ca.domains = append(ca.domains, id.Value)
+			authzs = append(authzs, ca.url("/authz/"+id.Value))
+		}
+		w.Header().Set("Location", ca.url("/order/1"))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(order{
+			Status:         "pending",
+			Authorizations: authzs,
+			Finalize:       ca.url("/finalize"),
+		})
+
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		domain := strings.TrimPrefix(r.URL.Path, "/authz/")
+		token := "token-" + domain
+		ca.tokens[token] = domain
+		status := "pending"
+		if ca.valid[domain] {
+			status = "valid"
+		}
+		json.NewEncoder(w).Encode(authorization{
+			Status:     status,
+			Identifier: identifier{Type: "dns", Value: domain},
+			Challenges: []challenge{{Type: "http-01", URL: ca.url("/chall/" + token), Token: token}},
+		})
+
+	case strings.HasPrefix(r.URL.Path, "/chall/"):
+		token := strings.TrimPrefix(r.URL.Path, "/chall/")
+		rec := httptest.NewRecorder()
+		ca.answer.ServeHTTP(rec, httptest.NewRequest("GET", challengePath+token, nil))
+		sum := sha256.Sum256(jwk(ca.account))
+		if rec.Body.String() == token+"."+base64.RawURLEncoding.EncodeToString(sum[:]) {
+			ca.valid[ca.tokens[token]] = true
+		}
+		w.Write([]byte("{}"))
+
+	case r.URL.Path == "/finalize":
+		var req struct{ Csr string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.Csr)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			ca.t.Error(err)
+			return
+		}
+		for _, d := range ca.domains {
+			if !ca.valid[d] {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprintf(w, `{"type":"urn:ietf:params:acme:error:unauthorized","detail":"%s not validated"}`, d)
+				return
+			}
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		cert, err := x509.CreateCertificate(rand.Reader, tmpl, ca.caCert, csr.PublicKey, ca.caKey)
+		if err != nil {
+			ca.t.Error(err)
+			return
+		}
+		ca.chainPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...)
+		json.NewEncoder(w).Encode(order{Status: "processing"})
+
+	case r.URL.Path == "/order/1":
+		json.NewEncoder(w).Encode(order{Status: "valid", Certificate: ca.url("/cert")})
+
+	case r.URL.Path == "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.chainPEM)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 members order and base64url coordinates without padding
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var k map[string]string
+	if err := json.Unmarshal(jwk(&key.PublicKey), &k); err != nil {
+		t.Fatal(err)
+	}
+	if k["crv"] != "P-256" || k["kty"] != "EC" || len(k["x"]) != 43 || len(k["y"]) != 43 {
+		t.Fatalf("unexpected jwk: %v", k)
+	}
+	if !strings.HasPrefix(string(jwk(&key.PublicKey)), `{"crv":`) {
+		t.Fatal("expected the members in lexicographic order")
+	}
+	if len(thumbprint(&key.PublicKey)) != 43 {
+		t.Fatal("unexpected thumbprint length")
+	}
+}
+
+func TestManager(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "acme-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newFakeCA(t)
+	defer ca.srv.Close()
+
+	cfg := Config{
+		Domains:  []string{"example.com", "www.example.com"},
+		Email:    "admin@example.com",
+		CacheDir: dir,
+		CA:       ca.url("/directory"),
+	}
+	m, err := NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.answer = m.HTTPHandler(nil)
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err != ErrNoCertificate {
+		t.Fatalf("expected no certificate, got %v", err)
+	}
+	if !m.needsRenewal(time.Now()) {
+		t.Fatal("expected the certificate to be needed")
+	}
+
+	if err := m.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Leaf.VerifyHostname("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if m.needsRenewal(time.Now()) {
+		t.Fatal("expected the certificate not to be renewed yet")
+	}
+	if !m.needsRenewal(time.Now().Add(70 * 24 * time.Hour)) {
+		t.Fatal("expected the certificate to be renewed before it expires")
+	}
+
+	// the certificate and the account key are cached
+	m, err = NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+		t.Fatalf("expected the cached certificate, got %v", err)
+	}
+	if m.client.key.X.Cmp(ca.account.X) != 0 {
+		t.Fatal("expected the cached account key")
+	}
+
+	// a cached certificate for other domains isn't used
+	cfg.Domains = []string{"other.example.com"}
+	m, err = NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err != ErrNoCertificate {
+		t.Fatalf("expected no certificate, got %v", err)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := NewManager(Config{Domains: []string{"example.com"}, CacheDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := m.HTTPHandler(fallback)
+
+	cleanup := m.respond("tok", "tok.thumb")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", challengePath+"tok", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "tok.thumb" {
+		t.Fatalf("unexpected answer: %d %q", rec.Code, rec.Body.String())
+	}
+
+	cleanup()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", challengePath+"tok", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected the challenge to be gone, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Fatalf("expected the fallback, got %d", rec.Code)
+	}
+
+	for _, d := range []string{"", "1.2.3.4", "example.com:443"} {
+		if _, err := NewManager(Config{Domains: []string{d}, CacheDir: dir}); err == nil {
+			t.Errorf("expected %q to be rejected", d)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pollInterval is the time between two checks of the status of an
+// authorization or of an order.
+var pollInterval = 2 * time.Second
+
+const (
+	// pollTimeout bounds the time waited for the CA to validate the
+	// challenges and to issue the certificate.
+	pollTimeout = 5 * time.Minute
+
+	maxResponseSize = 1 << 20
+)
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *problem `json:"error"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *problem `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+// problem is an error document of the CA (RFC 7807).
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *problem) Error() string {
+	return fmt.Sprintf("acme: %s: %s", p.Type, p.Detail)
+}
+
+const badNonce = "urn:ietf:params:acme:error:badNonce"
+
+// client is a minimal ACME (RFC 8555) client, issuing certificates with the
+// http-01 challenge.
+type client struct {
+	dirURL string
+	key    *ecdsa.PrivateKey
+	http   *http.Client
+
+	mu     sync.Mutex
+	dir    *directory
+	kid    string
+	nonces []string
+}
+
+func newClient(dirURL string, key *ecdsa.PrivateKey) *client {
+	return &client{
+		dirURL: dirURL,
+		key:    key,
+		http:   &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (c *client) directory() (*directory, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir != nil {
+		return c.dir, nil
+	}
+
+	resp, err := c.http.Get(c.dirURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("acme: directory %s: %s", c.dirURL, resp.Status)
+	}
+
+	var dir directory
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&dir); err != nil {
+		return nil, fmt.Errorf("acme: invalid directory: %s", err)
+	}
+	if dir.NewNonce == "" || dir.NewAccount == "" || dir.NewOrder == "" {
+		return nil, errors.New("acme: incomplete directory")
+	}
+	c.dir = &dir
+	return c.dir, nil
+}
+
+func (c *client) nonce() (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+
+	dir, err := c.directory()
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Head(dir.NewNonce)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("acme: no nonce returned")
+	}
+	return nonce, nil
+}
+
+func (c *client) saveNonce(h http.Header) {
+	if nonce := h.Get("Replay-Nonce"); nonce != "" {
+		c.mu.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mu.Unlock()
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padded returns the big-endian bytes of v on size bytes.
+func padded(v *big.Int, size int) []byte {
+	b := v.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// jwk returns the JSON web key of pub, with its members in lexicographic
+// order as the thumbprint needs (RFC 7638).
+func jwk(pub *ecdsa.PublicKey) []byte {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	return []byte(fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`,
+		pub.Curve.Params().Name, b64(padded(pub.X, size)), b64(padded(pub.Y, size))))
+}
+
+// thumbprint returns the thumbprint of the JSON web key of pub.
+func thumbprint(pub *ecdsa.PublicKey) string {
+	sum := sha256.Sum256(jwk(pub))
+	return b64(sum[:])
+}
+
+// keyAuthorization returns the content served for the http-01 challenge of
+// token.
+func (c *client) keyAuthorization(token string) string {
+	return token + "." + thumbprint(&c.key.PublicKey)
+}
+
+// sign returns the JWS of payload for a request to url. The account key is
+// given by its URL once the account is registered.
+func (c *client) sign(url string, payload []byte) ([]byte, error) {
+	nonce, err := c.nonce()
+	if err != nil {
+		return nil, err
+	}
+
+	header := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": nonce,
+		"url":   url,
+	}
+	c.mu.Lock()
+	if c.kid != "" {
+		header["kid"] = c.kid
+	} else {
+		header["jwk"] = json.RawMessage(jwk(&c.key.PublicKey))
+	}
+	c.mu.Unlock()
+
+	protected, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	input := b64(protected) + "." + b64(payload)
+
+	sum := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := append(padded(r, 32), padded(s, 32)...)
+
+	return json.Marshal(map[string]string{
+		"protected": b64(protected),
+		"payload":   b64(payload),
+		"signature": b64(sig),
+	})
+}
+
+// post sends payload to url, decoding the response in out if not nil. A nil
+// payload is a POST-as-GET request.
+func (c *client) post(url string, payload interface{}, out interface{}) (http.Header, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	h, b, err := c.postRaw(url, body)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			return nil, fmt.Errorf("acme: invalid response from %s: %s", url, err)
+		}
+	}
+	return h, nil
+}
+
+func (c *client) postRaw(url string, payload []byte) (http.Header, []byte, error) {
+	for retry := 0; ; retry++ {
+		jws, err := c.sign(url, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resp, err := c.http.Post(url, "application/jose+json", bytes.NewReader(jws))
+		if err != nil {
+			return nil, nil, err
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.saveNonce(resp.Header)
+
+		if resp.StatusCode/100 == 2 {
+			return resp.Header, b, nil
+		}
+
+		p := &problem{}
+		if json.Unmarshal(b, p) != nil || p.Type == "" {
+			return nil, nil, fmt.Errorf("acme: %s: %s", url, resp.Status)
+		}
+		// a nonce may expire, the request is sent again with a new one
+		if p.Type == badNonce && retry == 0 {
+			continue
+		}
+		return nil, nil, p
+	}
+}
+
+// register creates the account of the key, or finds it if it exists.
+func (c *client) register(email string) error {
+	dir, err := c.directory()
+	if err != nil {
+		return err
+	}
+
+	req := map[string]interface{}{
+		"termsOfServiceAgreed": true,
+	}
+	if email != "" {
+		req["contact"] = []string{"mailto:" + email}
+	}
+	h, err := c.post(dir.NewAccount, req, nil)
+	if err != nil {
+		return err
+	}
+	kid := h.Get("Location")
+	if kid == "" {
+		return errors.New("acme: no account URL returned")
+	}
+
+	c.mu.Lock()
+	c.kid = kid
+	c.mu.Unlock()
+	return nil
+}
+
+// obtain issues a certificate for the CSR of the domains, calling respond
+// for each http-01 challenge to answer. It returns the PEM certificate
+// chain.
+func (c *client) obtain(domains []string, csr []byte, respond func(token, keyAuth string) func()) ([]byte, error) {
+	dir, err := c.directory()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]identifier, len(domains))
+	for i, d := range domains {
+		ids[i] = identifier{Type: "dns", Value: d}
+	}
+	var o order
+	h, err := c.post(dir.NewOrder, map[string]interface{}{"identifiers": ids}, &o)
+	if err != nil {
+		return nil, err
+	}
+	orderURL := h.Get("Location")
+	if orderURL == "" {
+		return nil, errors.New("acme: no order URL returned")
+	}
+
+	for _, authzURL := range o.Authorizations {
+		if err := c.authorize(authzURL, respond); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := c.post(o.Finalize, map[string]string{"csr": b64(csr)}, &o); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(pollTimeout)
+	for o.Status != "valid" {
+		switch {
+		case o.Status == "invalid":
+			if o.Error != nil {
+				return nil, o.Error
+			}
+			return nil, errors.New("acme: order invalid")
+		case time.Now().After(deadline):
+			return nil, errors.New("acme: timeout waiting for the certificate")
+		}
+		time.Sleep(pollInterval)
+		if _, err := c.post(orderURL, nil, &o); err != nil {
+			return nil, err
+		}
+	}
+
+	_, chain, err := c.postRaw(o.Certificate, nil)
+	if err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// authorize answers the http-01 challenge of the authorization, and waits
+// for it to be valid.
+func (c *client) authorize(url string, respond func(token, keyAuth string) func()) error {
+	var authz authorization
+	if _, err := c.post(url, nil, &authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			chal = &authz.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: no http-01 challenge for %s", authz.Identifier.Value)
+	}
+
+	cleanup := respond(chal.Token, c.keyAuthorization(chal.Token))
+	defer cleanup()
+
+	if _, err := c.post(chal.URL, struct{}{}, nil); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(pollTimeout)
+	for {
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "invalid":
+			for _, ch := range authz.Challenges {
+				if ch.Error != nil {
+					return fmt.Errorf("acme: %s: %s", authz.Identifier.Value, ch.Error.Detail)
+				}
+			}
+			return fmt.Errorf("acme: authorization of %s failed", authz.Identifier.Value)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("acme: timeout waiting for the authorization of %s", authz.Identifier.Value)
+		}
+		time.Sleep(pollInterval)
+		if _, err := c.post(url, nil, &authz); err != nil {
+			return err
+		}
+	}
+}
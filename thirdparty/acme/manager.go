@@ -0,0 +1,326 @@
+// Package acme obtains and renews TLS certificates from an ACME certificate
+// authority such as Let's Encrypt, answering the http-01 challenges.
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+)
+
+var log = logging.Logger("acme")
+
+// LetsEncryptURL is the directory of the Let's Encrypt certificate
+// authority.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+const (
+	// RenewBefore is how long before its expiry a certificate is renewed.
+	RenewBefore = 30 * 24 * time.Hour
+
+	// checkInterval is the time between two checks of the expiry of the
+	// certificate, retryInterval the time between two attempts when
+	// obtaining it fails.
+	checkInterval = 12 * time.Hour
+	retryInterval = 10 * time.Minute
+
+	challengePath = "/.well-known/acme-challenge/"
+
+	accountKeyFile = "account.key"
+	certFile       = "cert.pem"
+	keyFile        = "key.pem"
+)
+
+// ErrNoCertificate is returned by GetCertificate until the certificate is
+// obtained.
+var ErrNoCertificate = errors.New("acme: no certificate obtained yet")
+
+// Config configures a Manager.
+type Config struct {
+	// Domains are the names of the certificate.
+	Domains []string
+	// Email is the contact address of the account, optional.
+	Email string
+	// CacheDir is the directory the account key and the certificate are
+	// stored in.
+	CacheDir string
+	// CA is the directory URL of the certificate authority, Let's Encrypt
+	// by default.
+	CA string
+	// HTTPAddr is the address the http-01 challenges are answered on, ":80"
+	// for the certificate authorities. If empty, they must be answered by
+	// the HTTPHandler of the Manager on port 80.
+	HTTPAddr string
+}
+
+// Manager obtains a certificate for the domains of its config and renews it
+// before it expires.
+type Manager struct {
+	cfg    Config
+	client *client
+
+	mu         sync.Mutex
+	cert       *tls.Certificate
+	challenges map[string]string
+
+	httpServer *http.Server
+	closing    chan struct{}
+	done       chan struct{}
+}
+
+// NewManager returns a Manager, with the certificate cached in the cache
+// directory if there is one.
+func NewManager(cfg Config) (*Manager, error) {
+	if len(cfg.Domains) == 0 {
+		return nil, errors.New("acme: no domain")
+	}
+	for _, d := range cfg.Domains {
+		if d == "" || strings.ContainsAny(d, "/: ") || net.ParseIP(d) != nil {
+			return nil, fmt.Errorf("acme: invalid domain %q", d)
+		}
+	}
+	if cfg.CacheDir == "" {
+		return nil, errors.New("acme: no cache directory")
+	}
+	if cfg.CA == "" {
+		cfg.CA = LetsEncryptURL
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0700); err != nil {
+		return nil, err
+	}
+
+	key, err := loadOrCreateKey(filepath.Join(cfg.CacheDir, accountKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		cfg:        cfg,
+		client:     newClient(cfg.CA, key),
+		challenges: make(map[string]string),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.path(certFile), m.path(keyFile))
+	switch {
+	case err == nil:
+		if m.covers(&cert) {
+			m.cert = &cert
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("acme: invalid cached certificate: %s", err)
+	}
+	return m, nil
+}
+
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.cfg.CacheDir, name)
+}
+
+// covers returns whether cert is valid for all the domains.
+func (m *Manager) covers(cert *tls.Certificate) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+	cert.Leaf = leaf
+	for _, d := range m.cfg.Domains {
+		if leaf.VerifyHostname(d) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Start answers the challenges on HTTPAddr, and obtains and renews the
+// certificate in the background.
+func (m *Manager) Start() error {
+	if m.cfg.HTTPAddr != "" {
+		l, err := net.Listen("tcp", m.cfg.HTTPAddr)
+		if err != nil {
+			return fmt.Errorf("acme: cannot answer the challenges: %s", err)
+		}
+		m.httpServer = &http.Server{Handler: m.HTTPHandler(nil)}
+		go m.httpServer.Serve(l)
+	}
+
+	go m.loop()
+	return nil
+}
+
+// Close stops renewing the certificate.
+func (m *Manager) Close() error {
+	close(m.closing)
+	<-m.done
+	if m.httpServer != nil {
+		return m.httpServer.Close()
+	}
+	return nil
+}
+
+func (m *Manager) loop() {
+	defer close(m.done)
+	for {
+		wait := checkInterval
+		if m.needsRenewal(time.Now()) {
+			if err := m.Renew(); err != nil {
+				log.Errorf("cannot obtain the certificate of %s: %s", strings.Join(m.cfg.Domains, ", "), err)
+				wait = retryInterval
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-m.closing:
+			return
+		}
+	}
+}
+
+func (m *Manager) needsRenewal(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cert == nil || now.Add(RenewBefore).After(m.cert.Leaf.NotAfter)
+}
+
+// Renew obtains a new certificate.
+func (m *Manager) Renew() error {
+	if err := m.client.register(m.cfg.Email); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.cfg.Domains[0]},
+		DNSNames: m.cfg.Domains,
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	chain, err := m.client.obtain(m.cfg.Domains, csr, m.respond)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(chain, keyPEM)
+	if err != nil {
+		return fmt.Errorf("acme: invalid certificate issued: %s", err)
+	}
+	if !m.covers(&cert) {
+		return errors.New("acme: the certificate issued doesn't cover the domains")
+	}
+
+	if err := ioutil.WriteFile(m.path(keyFile), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.path(certFile), chain, 0600); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	return nil
+}
+
+// respond answers the http-01 challenge of token until the returned function
+// is called.
+func (m *Manager) respond(token, keyAuth string) func() {
+	m.mu.Lock()
+	m.challenges[token] = keyAuth
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		delete(m.challenges, token)
+		m.mu.Unlock()
+	}
+}
+
+// GetCertificate returns the certificate, for tls.Config.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, ErrNoCertificate
+	}
+	return m.cert, nil
+}
+
+// HTTPHandler answers the http-01 challenges, passing the other requests to
+// fallback. A nil fallback answers them with 404.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	if fallback == nil {
+		fallback = http.NotFoundHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, challengePath) {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		m.mu.Lock()
+		keyAuth, ok := m.challenges[strings.TrimPrefix(r.URL.Path, challengePath)]
+		m.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(keyAuth))
+	})
+}
+
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("acme: invalid account key %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(path, pemKey, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
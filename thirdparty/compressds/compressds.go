@@ -0,0 +1,186 @@
+// Package compressds implements a datastore wrapper compressing the blocks
+// it stores, for repos holding mostly text and structured data. The
+// compressed values are stored in an envelope naming their algorithm and
+// their size, so a wrapped datastore can hold compressed and uncompressed
+// blocks side by side.
+package compressds
+
+import (
+	"fmt"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	goprocess "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+)
+
+// Options configures a Datastore.
+type Options struct {
+	// Algorithm compresses the new blocks. "none" stores them as they are,
+	// while still reading the compressed ones.
+	Algorithm string
+
+	// MinSize is the size below which the blocks are stored as they are.
+	MinSize int
+
+	// SkipCodecs are the codecs of the blocks stored as they are, like
+	// the git objects which are deflated already.
+	SkipCodecs []string
+}
+
+// DefaultOptions returns the options of a new compressed datastore.
+func DefaultOptions() Options {
+	return Options{
+		Algorithm:  "zstd",
+		MinSize:    512,
+		SkipCodecs: []string{"git-raw"},
+	}
+}
+
+// Datastore compresses the blocks stored in a child datastore. The keys
+// which aren't blocks are passed through.
+type Datastore struct {
+	child ds.Batching
+	// comp compresses the new blocks, nil with the "none" algorithm
+	comp      Compressor
+	algorithm string
+	minSize   int
+	skip      map[uint64]bool
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+
+// Wrap returns child compressing its blocks.
+func Wrap(child ds.Batching, opts Options) (*Datastore, error) {
+	d := &Datastore{
+		child:   child,
+		minSize: opts.MinSize,
+		skip:    make(map[uint64]bool),
+	}
+	if opts.Algorithm != "none" {
+		c, ok := compressors[opts.Algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unknown compression algorithm: %s", opts.Algorithm)
+		}
+		d.comp = c
+		d.algorithm = opts.Algorithm
+	}
+	for _, name := range opts.SkipCodecs {
+		codec, ok := cid.Codecs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown codec: %s", name)
+		}
+		d.skip[codec] = true
+	}
+	return d, nil
+}
+
+func (d *Datastore) Put(k ds.Key, value []byte) error {
+	return d.child.Put(k, d.encode(k, value))
+}
+
+// Get returns the block stored at k, decompressed.
+func (d *Datastore) Get(k ds.Key) ([]byte, error) {
+	val, err := d.child.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(k, val)
+}
+
+func (d *Datastore) Has(k ds.Key) (bool, error) {
+	return d.child.Has(k)
+}
+
+// GetSize returns the size of the block stored at k, as written in the
+// envelope of the compressed value. Unlike the size of the uncompressed
+// values, it can't be known without reading the value.
+func (d *Datastore) GetSize(k ds.Key) (int, error) {
+	if _, ok := blockCid(k); !ok {
+		return d.child.GetSize(k)
+	}
+	val, err := d.child.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	size, _, err := d.size(k, val)
+	if err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+func (d *Datastore) Delete(k ds.Key) error {
+	return d.child.Delete(k)
+}
+
+// Query decompresses the values of the results as they are read, so the
+// filters and orders on the values are applied here. KeysOnly queries are
+// passed through.
+func (d *Datastore) Query(q dsq.Query) (dsq.Results, error) {
+	if q.KeysOnly {
+		return d.child.Query(q)
+	}
+
+	res, err := d.child.Query(dsq.Query{Prefix: q.Prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	// the prefix has already been applied by the child
+	q.Prefix = ""
+	r := dsq.ResultsWithProcess(q, func(p goprocess.Process, out chan<- dsq.Result) {
+		defer res.Close()
+		for {
+			r, ok := res.NextSync()
+			if !ok {
+				return
+			}
+			if r.Error == nil {
+				r.Value, r.Error = d.decode(ds.RawKey(r.Key), r.Value)
+				if r.Error != nil {
+					r.Error = fmt.Errorf("%s: %s", r.Key, r.Error)
+				}
+			}
+			select {
+			case out <- r:
+			case <-p.Closing():
+				return
+			}
+			if r.Error != nil {
+				return
+			}
+		}
+	})
+	return dsq.NaiveQueryApply(q, r), nil
+}
+
+func (d *Datastore) Batch() (ds.Batch, error) {
+	b, err := d.child.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &batch{Batch: b, d: d}, nil
+}
+
+// DiskUsage returns the disk usage of the child, the compressed size.
+func (d *Datastore) DiskUsage() (uint64, error) {
+	return ds.DiskUsage(d.child)
+}
+
+func (d *Datastore) Close() error {
+	if c, ok := d.child.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// batch compresses the blocks put in a batch of the child.
+type batch struct {
+	ds.Batch
+	d *Datastore
+}
+
+func (b *batch) Put(k ds.Key, value []byte) error {
+	return b.Batch.Put(k, b.d.encode(k, value))
+}
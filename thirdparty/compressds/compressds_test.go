@@ -0,0 +1,285 @@
+package compressds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	zstd "github.com/ipfs/go-ipfs/thirdparty/zstd"
+
+	dshelp "gx/ipfs/QmPQ7bVbZAbGaJkBVJeTkkKXvLLZeN9CLWTf5fzUQ8yeWs/go-ipfs-ds-help"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	u "gx/ipfs/QmPdKqUcHGFdeSpvjVoaTRPPstGif9GBZb5Q56RVw9o69A/go-ipfs-util"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+)
+
+func block(codec uint64, data []byte) (ds.Key, cid.Cid) {
+	c := cid.NewCidV1(codec, u.Hash(data))
+	return dshelp.CidToDsKey(c), c
+}
+
+func text(n int) []byte {
+	var b strings.Builder
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, `{"id":%d,"name":"item %d"}`+"\n", i, i%13)
+	}
+	return []byte(b.String())
+}
+
+func random(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func TestPutGet(t *testing.T) {
+	child := ds.NewMapDatastore()
+	d, err := Wrap(child, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		codec      uint64
+		data       []byte
+		compressed bool
+	}{
+		{"text", cid.Raw, text(8 << 10), true},
+		{"small", cid.Raw, text(100), false},
+		{"random", cid.Raw, random(8 << 10), false},
+		{"gzip", cid.Raw, append([]byte{0x1f, 0x8b, 8}, text(8<<10)...), false},
+		{"git", cid.GitRaw, text(8 << 10), false},
+	} {
+		k, _ := block(tc.codec, tc.data)
+		if err := d.Put(k, tc.data); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := child.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, ok, _ := parseEnvelope(stored); (ok && e.algorithm == "zstd") != tc.compressed {
+			t.Errorf("%s: expected compressed to be %t", tc.name, tc.compressed)
+		}
+
+		got, err := d.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tc.data) {
+			t.Errorf("%s: got another block back", tc.name)
+		}
+		size, err := d.GetSize(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != len(tc.data) {
+			t.Errorf("%s: expected a size of %d, got %d", tc.name, len(tc.data), size)
+		}
+	}
+
+	// the keys which aren't blocks are left alone
+	k := ds.NewKey("/local/filesroot")
+	if err := d.Put(k, text(8<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := child.Get(k); bytes.HasPrefix(stored, envelopeMagic) {
+		t.Error("expected a key which isn't a block not to be compressed")
+	}
+}
+
+func TestLooksCompressedBlock(t *testing.T) {
+	// a zstd file added as is must not be decompressed
+	file := zstd.Compress(nil, text(8<<10))
+	k, _ := block(cid.Raw, file)
+
+	child := ds.NewMapDatastore()
+	if err := child.Put(k, file); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Wrap(child, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Get(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, file) {
+		t.Fatal("expected the block to be returned as is")
+	}
+	// its size isn't read from the zstd header
+	if size, err := d.GetSize(k); err != nil || size != len(file) {
+		t.Fatalf("expected a size of %d, got %d (%v)", len(file), size, err)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	child := ds.NewMapDatastore()
+	d, err := Wrap(child, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a block starting like an envelope is escaped
+	data := append(append([]byte{}, envelopeMagic...), random(100)...)
+	k, _ := block(cid.Raw, data)
+	if err := d.Put(k, data); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := child.Get(k); bytes.Equal(stored, data) {
+		t.Fatal("expected the block to be escaped")
+	}
+	if got, err := d.Get(k); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("expected the escaped block back, got %v", err)
+	}
+
+	// so is one larger than MaxBlockSize, which is stored as it is
+	large := append(append([]byte{}, envelopeMagic...), random(MaxBlockSize)...)
+	lk, _ := block(cid.Raw, large)
+	if err := d.Put(lk, large); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.Get(lk); err != nil || !bytes.Equal(got, large) {
+		t.Fatalf("expected the large escaped block back, got %v", err)
+	}
+	if size, err := d.GetSize(lk); err != nil || size != len(large) {
+		t.Fatalf("expected the size of the large escaped block, got %d (%v)", size, err)
+	}
+
+	// the values are never decompressed beyond the size of their envelope,
+	// nor beyond MaxBlockSize
+	bomb := zstd.Compress(nil, make([]byte, MaxBlockSize+1))
+	for _, val := range [][]byte{
+		appendEnvelope(nil, "zstd", 10, bomb),
+		appendEnvelope(nil, "zstd", MaxBlockSize+1, bomb),
+		appendEnvelope(nil, "lzma", 10, bomb),
+		envelopeMagic,
+	} {
+		if err := child.Put(k, val); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Get(k); err == nil {
+			t.Error("expected an invalid envelope to fail")
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	d, err := Wrap(ds.NewMapDatastore(), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := text(8 << 10)
+	k, _ := block(cid.Raw, data)
+	if err := d.Put(k, data); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := d.Query(dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !bytes.Equal(entries[0].Value, data) {
+		t.Fatal("expected the query to return the decompressed block")
+	}
+
+	// an invalid value fails the query when it is read
+	if err := d.child.Put(k, envelopeMagic); err != nil {
+		t.Fatal(err)
+	}
+	res, err = d.Query(dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.Rest(); err == nil {
+		t.Fatal("expected the invalid value to fail the query")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	child := ds.NewMapDatastore()
+	data := text(64 << 10)
+	k, c := block(cid.Raw, data)
+	if err := child.Put(k, data); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Wrap(child, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := d.Stat(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Blocks != 1 || before.Compressed != 0 || before.StoredSize != uint64(len(data)) {
+		t.Fatalf("unexpected stat: %+v", before)
+	}
+
+	locked := 0
+	lock := func() func() {
+		locked++
+		return func() { locked-- }
+	}
+	var converted []cid.Cid
+	progress := func(c cid.Cid, delta int) {
+		if locked != 1 {
+			t.Error("expected the lock to be held")
+		}
+		if delta >= 0 {
+			t.Errorf("expected the block to shrink, got %d", delta)
+		}
+		converted = append(converted, c)
+	}
+	if err := d.Convert(context.Background(), false, lock, progress); err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != 1 || !converted[0].Equals(c) {
+		t.Fatalf("expected the block to be converted, got %v", converted)
+	}
+
+	after, err := d.Stat(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Compressed != 1 || after.LogicalSize != uint64(len(data)) || after.StoredSize >= after.LogicalSize {
+		t.Fatalf("unexpected stat: %+v", after)
+	}
+
+	// converting again changes nothing, decompressing restores the block
+	converted = nil
+	if err := d.Convert(context.Background(), false, lock, progress); err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != 0 {
+		t.Fatal("expected the compressed block to be left alone")
+	}
+	if err := d.Convert(context.Background(), true, lock, func(cid.Cid, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := child.Get(k); !bytes.Equal(stored, data) {
+		t.Fatal("expected the block to be decompressed")
+	}
+}
+
+func TestWrapInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{Algorithm: "lzma"},
+		{Algorithm: "zstd", SkipCodecs: []string{"jpeg"}},
+	} {
+		if _, err := Wrap(ds.NewMapDatastore(), opts); err == nil {
+			t.Errorf("expected %+v to be rejected", opts)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package compressds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	zstd "github.com/ipfs/go-ipfs/thirdparty/zstd"
+
+	dshelp "gx/ipfs/QmPQ7bVbZAbGaJkBVJeTkkKXvLLZeN9CLWTf5fzUQ8yeWs/go-ipfs-ds-help"
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+)
+
+// Compressor is a compression algorithm of a Datastore.
+type Compressor interface {
+	// Compress appends the compressed src to dst.
+	Compress(dst, src []byte) []byte
+
+	// Decompress appends the decompressed src to dst, failing without
+	// decompressing more than max bytes.
+	Decompress(dst, src []byte, max int) ([]byte, error)
+}
+
+var compressors = map[string]Compressor{
+	"zstd": zstdCompressor{},
+}
+
+// Register adds a compression algorithm. Its name is stored with the values
+// it compresses, it must be at most 255 bytes.
+func Register(name string, c Compressor) {
+	compressors[name] = c
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Compress(dst, src []byte) []byte { return zstd.Compress(dst, src) }
+
+func (zstdCompressor) Decompress(dst, src []byte, max int) ([]byte, error) {
+	return zstd.Decompress(dst, src, max)
+}
+
+// MaxBlockSize is the size above which the blocks are stored as they are,
+// and which the values are never decompressed beyond.
+const MaxBlockSize = 4 << 20
+
+// envelopeMagic starts the values of the blocks the datastore wrote
+// compressed, or escaped when they start with it. No block is mistaken for a
+// compressed one: the blocks starting with envelopeMagic are stored in an
+// envelope too.
+var envelopeMagic = []byte("\x00cds")
+
+// envelope is a value of a block: the name of the algorithm compressing it,
+// empty for an escaped block, and the size of the block.
+type envelope struct {
+	algorithm string
+	size      int
+	payload   []byte
+}
+
+// appendEnvelope appends the envelope of payload, the block of size bytes
+// compressed with algorithm, to dst.
+func appendEnvelope(dst []byte, algorithm string, size int, payload []byte) []byte {
+	dst = append(dst, envelopeMagic...)
+	dst = append(dst, byte(len(algorithm)))
+	dst = append(dst, algorithm...)
+	var b [binary.MaxVarintLen64]byte
+	dst = append(dst, b[:binary.PutUvarint(b[:], uint64(size))]...)
+	return append(dst, payload...)
+}
+
+var errInvalidEnvelope = errors.New("compressds: invalid stored value")
+
+// parseEnvelope returns the envelope of val, ok being false if val isn't
+// in one.
+func parseEnvelope(val []byte) (e envelope, ok bool, err error) {
+	if !bytes.HasPrefix(val, envelopeMagic) {
+		return e, false, nil
+	}
+	rest := val[len(envelopeMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return e, true, errInvalidEnvelope
+	}
+	e.algorithm = string(rest[1 : 1+rest[0]])
+	rest = rest[1+rest[0]:]
+	size, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return e, true, errInvalidEnvelope
+	}
+	e.payload = rest[n:]
+	// The size bounds the decompression of a compressed block. An escaped
+	// block is stored as it is, whatever its size.
+	if e.algorithm == "" && size != uint64(len(e.payload)) {
+		return e, true, errInvalidEnvelope
+	}
+	if e.algorithm != "" && size > MaxBlockSize {
+		return e, true, errInvalidEnvelope
+	}
+	e.size = int(size)
+	return e, true, nil
+}
+
+// open returns the block of the envelope, decompressing at most its size.
+func (e envelope) open() ([]byte, error) {
+	if e.algorithm == "" {
+		return e.payload, nil
+	}
+	c, ok := compressors[e.algorithm]
+	if !ok {
+		return nil, fmt.Errorf("compressds: unknown compression algorithm: %s", e.algorithm)
+	}
+	out, err := c.Decompress(nil, e.payload, e.size)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != e.size {
+		return nil, errInvalidEnvelope
+	}
+	return out, nil
+}
+
+// blockCid returns the CID of the block stored at k. Blocks are stored at
+// the root of the datastore, or below /blocks when the whole repo
+// datastore is wrapped.
+func blockCid(k ds.Key) (cid.Cid, bool) {
+	if p := k.Parent().String(); p != "/" && p != "/blocks" {
+		return cid.Cid{}, false
+	}
+	c, err := dshelp.DsKeyToCid(ds.NewKey(k.BaseNamespace()))
+	if err != nil {
+		return cid.Cid{}, false
+	}
+	return c, true
+}
+
+const (
+	// a compressed block must be at least an eighth smaller to be worth
+	// decompressing it
+	minSavings = 8
+
+	// entropySample is the number of bytes sampled to estimate the
+	// entropy of a block
+	entropySample = 4096
+
+	// maxEntropy is the entropy, in bits per byte, above which a block
+	// isn't worth compressing
+	maxEntropy = 7.5
+)
+
+// encode returns the value stored for the block value at k: compressed if
+// that's worth it, as is otherwise.
+func (d *Datastore) encode(k ds.Key, value []byte) []byte {
+	if _, ok := blockCid(k); !ok {
+		return value
+	}
+	if d.comp != nil && len(value) >= d.minSize && len(value) <= MaxBlockSize {
+		c, _ := blockCid(k)
+		if !d.skip[c.Type()] && !looksCompressed(value) {
+			z := d.comp.Compress(nil, value)
+			if len(z) <= len(value)-len(value)/minSavings {
+				return appendEnvelope(nil, d.algorithm, len(value), z)
+			}
+		}
+	}
+	return raw(value)
+}
+
+// raw returns the value storing the block value as is, escaped if it starts
+// like an envelope.
+func raw(value []byte) []byte {
+	if bytes.HasPrefix(value, envelopeMagic) {
+		return appendEnvelope(nil, "", len(value), value)
+	}
+	return value
+}
+
+// decode returns the block stored as val at k.
+func (d *Datastore) decode(k ds.Key, val []byte) ([]byte, error) {
+	if _, ok := blockCid(k); !ok {
+		return val, nil
+	}
+	e, ok, err := parseEnvelope(val)
+	if err != nil || !ok {
+		return val, err
+	}
+	return e.open()
+}
+
+// size returns the size of the block stored as val at k, read from the
+// envelope of the compressed blocks.
+func (d *Datastore) size(k ds.Key, val []byte) (size int, compressed bool, err error) {
+	if _, ok := blockCid(k); !ok {
+		return len(val), false, nil
+	}
+	e, ok, err := parseEnvelope(val)
+	if err != nil || !ok {
+		return len(val), false, err
+	}
+	return e.size, e.algorithm != "", nil
+}
+
+// magics are the first bytes of compressed media and archives.
+var magics = [][]byte{
+	{0x1f, 0x8b},             // gzip
+	{0x28, 0xb5, 0x2f, 0xfd}, // zstd
+	[]byte("BZh"),            // bzip2
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c},
+	[]byte("PK\x03\x04"), // zip, docx, jar
+	[]byte("Rar!\x1a\x07"),
+	{0x89, 'P', 'N', 'G'},
+	{0xff, 0xd8, 0xff}, // jpeg
+	[]byte("GIF8"),
+	[]byte("ftyp"), // mp4, mov, heic
+	[]byte("OggS"),
+	[]byte("fLaC"),
+	{0x1a, 0x45, 0xdf, 0xa3}, // matroska, webm
+	[]byte("ID3"),            // mp3
+	[]byte("wOF2"),
+}
+
+// magicWindow is how far the magic numbers are searched for: the data of a
+// unixfs block follows a few bytes of protobuf.
+const magicWindow = 24
+
+// looksCompressed returns whether data starts like compressed media or an
+// archive, or has the entropy of compressed data.
+func looksCompressed(data []byte) bool {
+	head := data
+	if len(head) > magicWindow {
+		head = head[:magicWindow]
+	}
+	for _, m := range magics {
+		if bytes.Contains(head, m) {
+			return true
+		}
+	}
+	return entropy(data) > maxEntropy
+}
+
+// entropy estimates the entropy of data, in bits per byte, from a sample.
+func entropy(data []byte) float64 {
+	sample := data
+	if len(sample) > entropySample {
+		sample = sample[:entropySample]
+	}
+	var counts [256]int
+	for _, b := range sample {
+		counts[b]++
+	}
+	e := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(len(sample))
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
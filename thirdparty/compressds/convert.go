@@ -0,0 +1,144 @@
+package compressds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+)
+
+// Stat is the compression of the blocks of a Datastore.
+type Stat struct {
+	Blocks     uint64
+	Compressed uint64
+	// StoredSize is the size of the stored values, LogicalSize the size
+	// of the blocks.
+	StoredSize  uint64
+	LogicalSize uint64
+}
+
+// Stat reads every block to sum their stored and logical sizes. The logical
+// sizes of the compressed blocks are read from their envelopes.
+func (d *Datastore) Stat(ctx context.Context) (Stat, error) {
+	var st Stat
+	res, err := d.child.Query(dsq.Query{})
+	if err != nil {
+		return st, err
+	}
+	defer res.Close()
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return st, r.Error
+		}
+		if err := ctx.Err(); err != nil {
+			return st, err
+		}
+		if _, ok := blockCid(ds.RawKey(r.Key)); !ok {
+			continue
+		}
+
+		st.Blocks++
+		st.StoredSize += uint64(len(r.Value))
+		size, compressed, err := d.size(ds.RawKey(r.Key), r.Value)
+		if err != nil {
+			return st, fmt.Errorf("%s: %s", r.Key, err)
+		}
+		if compressed {
+			st.Compressed++
+		}
+		st.LogicalSize += uint64(size)
+	}
+	return st, nil
+}
+
+// convertBatch is the number of blocks rewritten while holding the lock
+// of Convert.
+const convertBatch = 256
+
+// Convert rewrites the stored blocks compressed, as a new block would be,
+// or uncompressed when decompress is set, before removing the wrapper. lock
+// is held around every batch of rewrites, for the blocks not to be removed
+// in between; it returns the function releasing it. progress is called for
+// every block rewritten, with the change of its stored size.
+func (d *Datastore) Convert(ctx context.Context, decompress bool, lock func() func(), progress func(c cid.Cid, delta int)) error {
+	res, err := d.child.Query(dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	type block struct {
+		k ds.Key
+		c cid.Cid
+	}
+	blocks := make([]block, 0, convertBatch)
+	flush := func() error {
+		unlock := lock()
+		defer unlock()
+		for _, b := range blocks {
+			delta, err := d.convert(b.k, decompress)
+			if err != nil {
+				return err
+			}
+			if delta != 0 {
+				progress(b.c, delta)
+			}
+		}
+		blocks = blocks[:0]
+		return nil
+	}
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		k := ds.RawKey(r.Key)
+		c, ok := blockCid(k)
+		if !ok {
+			continue
+		}
+		blocks = append(blocks, block{k, c})
+		if len(blocks) == convertBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// convert rewrites the block stored at k and returns the change of its
+// stored size. A block removed meanwhile is skipped.
+func (d *Datastore) convert(k ds.Key, decompress bool) (int, error) {
+	stored, err := d.child.Get(k)
+	if err == ds.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	want, err := d.decode(k, stored)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", k, err)
+	}
+	// decompressed for the datastore without the wrapper, so the blocks
+	// starting like an envelope aren't escaped
+	if !decompress {
+		want = d.encode(k, want)
+	}
+	if bytes.Equal(want, stored) {
+		return 0, nil
+	}
+	if err := d.child.Put(k, want); err != nil {
+		return 0, err
+	}
+	return len(want) - len(stored), nil
+}
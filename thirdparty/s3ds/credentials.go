@@ -0,0 +1,56 @@
+package s3ds
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadCredentials reads the access and secret keys of profile from the file
+// at path, in the format of the AWS shared credentials file:
+//
+//	[default]
+//	aws_access_key_id = <access key>
+//	aws_secret_access_key = <secret key>
+func ReadCredentials(path, profile string) (accessKey, secretKey string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var section string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		case section != profile:
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			continue
+		}
+		switch strings.TrimSpace(line[:eq]) {
+		case "aws_access_key_id":
+			accessKey = strings.TrimSpace(line[eq+1:])
+		case "aws_secret_access_key":
+			secretKey = strings.TrimSpace(line[eq+1:])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", "", err
+	}
+
+	if accessKey == "" || secretKey == "" {
+		return "", "", fmt.Errorf("no credentials for the profile %q in %s", profile, path)
+	}
+	return accessKey, secretKey, nil
+}
@@ -0,0 +1,194 @@
+// Package s3ds implements a go-datastore backed by an S3 compatible object
+// store, with an optional write-back cache for hot blocks.
+package s3ds
+
+import (
+	"path"
+	"strings"
+
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+)
+
+// Config holds the parameters needed to talk to the object store.
+type Config struct {
+	Endpoint      string
+	Region        string
+	Bucket        string
+	RootDirectory string
+	AccessKey     string
+	SecretKey     string
+}
+
+// Datastore stores every key as an object named after the key below
+// RootDirectory in the configured bucket.
+type Datastore struct {
+	client *client
+	root   string
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+
+// NewDatastore returns a datastore writing to the configured bucket. It does
+// not contact the object store.
+func NewDatastore(cfg Config) (*Datastore, error) {
+	c, err := newClient(cfg.Endpoint, cfg.Region, cfg.Bucket, cfg.AccessKey, cfg.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Datastore{
+		client: c,
+		root:   strings.Trim(cfg.RootDirectory, "/"),
+	}, nil
+}
+
+func (d *Datastore) objectKey(k ds.Key) string {
+	return path.Join(d.root, strings.TrimPrefix(k.String(), "/"))
+}
+
+func (d *Datastore) dsKey(obj string) ds.Key {
+	return ds.NewKey(strings.TrimPrefix(obj, d.root))
+}
+
+func (d *Datastore) Put(k ds.Key, value []byte) error {
+	return d.client.Put(d.objectKey(k), value)
+}
+
+func (d *Datastore) Get(k ds.Key) ([]byte, error) {
+	val, err := d.client.Get(d.objectKey(k))
+	if err == errNotFound {
+		return nil, ds.ErrNotFound
+	}
+	return val, err
+}
+
+func (d *Datastore) Has(k ds.Key) (bool, error) {
+	_, err := d.GetSize(k)
+	switch err {
+	case nil:
+		return true, nil
+	case ds.ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (d *Datastore) GetSize(k ds.Key) (int, error) {
+	size, err := d.client.Size(d.objectKey(k))
+	if err == errNotFound {
+		return -1, ds.ErrNotFound
+	}
+	return size, err
+}
+
+func (d *Datastore) Delete(k ds.Key) error {
+	return d.client.Delete(d.objectKey(k))
+}
+
+// Query lists the bucket below the query prefix, a page at a time as the
+// results are read. Values are fetched one request per object, so KeysOnly
+// queries are strongly preferred.
+func (d *Datastore) Query(q dsq.Query) (dsq.Results, error) {
+	prefix := d.root
+	if p := strings.Trim(q.Prefix, "/"); p != "" {
+		prefix = path.Join(prefix, p)
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	it := &listIterator{d: d, prefix: prefix, keysOnly: q.KeysOnly}
+	// the prefix has already been applied by the listing
+	q.Prefix = ""
+	r := dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next:  it.next,
+		Close: func() error { return nil },
+	})
+	return dsq.NaiveQueryApply(q, r), nil
+}
+
+// listIterator returns the objects of the listing of a query, requesting the
+// next page once the previous one was read.
+type listIterator struct {
+	d        *Datastore
+	prefix   string
+	keysOnly bool
+
+	page  []listObject
+	token string
+	done  bool
+}
+
+func (it *listIterator) next() (dsq.Result, bool) {
+	for len(it.page) == 0 {
+		if it.done {
+			return dsq.Result{}, false
+		}
+		res, token, err := it.d.client.listPage(it.prefix, it.token)
+		if err != nil {
+			it.done = true
+			return dsq.Result{Error: err}, true
+		}
+		it.page, it.token, it.done = res.Contents, token, token == ""
+	}
+
+	obj := it.page[0]
+	it.page = it.page[1:]
+	e := dsq.Entry{Key: it.d.dsKey(obj.Key).String()}
+	if !it.keysOnly {
+		val, err := it.d.client.Get(obj.Key)
+		if err != nil {
+			it.page, it.done = nil, true
+			return dsq.Result{Error: err}, true
+		}
+		e.Value = val
+	}
+	return dsq.Result{Entry: e}, true
+}
+
+func (d *Datastore) Batch() (ds.Batch, error) {
+	return &batch{d: d, ops: make(map[ds.Key]batchOp)}, nil
+}
+
+func (d *Datastore) Close() error {
+	return nil
+}
+
+type batchOp struct {
+	value  []byte
+	delete bool
+}
+
+// batch collects writes and issues them on commit. S3 has no transactions,
+// so a failed commit may have applied a subset of the operations.
+type batch struct {
+	d   ds.Datastore
+	ops map[ds.Key]batchOp
+}
+
+func (b *batch) Put(k ds.Key, value []byte) error {
+	b.ops[k] = batchOp{value: value}
+	return nil
+}
+
+func (b *batch) Delete(k ds.Key) error {
+	b.ops[k] = batchOp{delete: true}
+	return nil
+}
+
+func (b *batch) Commit() error {
+	for k, op := range b.ops {
+		var err error
+		if op.delete {
+			err = b.d.Delete(k)
+		} else {
+			err = b.d.Put(k, op.value)
+		}
+		if err != nil {
+			return err
+		}
+		delete(b.ops, k)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package s3ds
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+)
+
+// fakeS3 implements just enough of the S3 API for the datastore.
+type fakeS3 struct {
+	lk      sync.Mutex
+	objects map[string][]byte
+
+	// pageSize, when set, splits the listings in pages of that many objects
+	pageSize int
+	lists    int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "unsigned", http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket")
+	key = strings.TrimPrefix(key, "/")
+
+	switch r.Method {
+	case "PUT":
+		b, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = b
+	case "GET", "HEAD":
+		if key == "" {
+			f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token"))
+			return
+		}
+		b, ok := f.objects[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	case "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, token string) {
+	f.lists++
+	var res listResult
+	for k, v := range f.objects {
+		if strings.HasPrefix(k, prefix) && k > token {
+			res.Contents = append(res.Contents, listObject{k, len(v)})
+		}
+	}
+	sort.Slice(res.Contents, func(i, j int) bool {
+		return res.Contents[i].Key < res.Contents[j].Key
+	})
+	if f.pageSize > 0 && len(res.Contents) > f.pageSize {
+		res.Contents = res.Contents[:f.pageSize]
+		res.IsTruncated = true
+		res.NextContinuationToken = res.Contents[f.pageSize-1].Key
+	}
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listResult
+	}{listResult: res})
+}
+
+func newTestDatastore(t *testing.T) (*Datastore, *fakeS3, func()) {
+	f := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+
+	d, err := NewDatastore(Config{
+		Endpoint:      srv.URL,
+		Bucket:        "bucket",
+		RootDirectory: "ipfs",
+		AccessKey:     "key",
+		SecretKey:     "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, f, srv.Close
+}
+
+func TestBasicOps(t *testing.T) {
+	d, f, done := newTestDatastore(t)
+	defer done()
+
+	k := ds.NewKey("/blocks/FOO")
+	if err := d.Put(k, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.objects["ipfs/blocks/FOO"]; !ok {
+		t.Fatal("object not stored under root directory")
+	}
+
+	v, err := d.Get(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "bar" {
+		t.Fatalf("got %q", v)
+	}
+
+	size, err := d.GetSize(k)
+	if err != nil || size != 3 {
+		t.Fatalf("got size %d, err %v", size, err)
+	}
+
+	if err := d.Delete(k); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(k); err != ds.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if has, err := d.Has(k); err != nil || has {
+		t.Fatalf("expected key to be gone, has=%v err=%v", has, err)
+	}
+}
+
+func TestQueryPages(t *testing.T) {
+	d, f, done := newTestDatastore(t)
+	defer done()
+	f.pageSize = 2
+
+	for _, k := range []string{"/A", "/B", "/C", "/D", "/E"} {
+		if err := d.Put(ds.NewKey(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := d.Query(dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	r, ok := res.NextSync()
+	if !ok || r.Error != nil || r.Key != "/A" || string(r.Value) != "/A" {
+		t.Fatalf("unexpected first result %+v", r)
+	}
+	f.lk.Lock()
+	lists := f.lists
+	f.lk.Unlock()
+	if lists != 1 {
+		t.Fatalf("expected only the first page to be listed, got %d listings", lists)
+	}
+
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[3].Key != "/E" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	if f.lists != 3 {
+		t.Fatalf("expected 3 listings, got %d", f.lists)
+	}
+}
+
+func TestQueryPrefix(t *testing.T) {
+	d, _, done := newTestDatastore(t)
+	defer done()
+
+	for _, k := range []string{"/blocks/A", "/blocks/B", "/blocksx/C", "/other"} {
+		if err := d.Put(ds.NewKey(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := d.Query(dsq.Query{Prefix: "/blocks", KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "/blocks/A,/blocks/B" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	d, f, done := newTestDatastore(t)
+	defer done()
+
+	wb, err := NewWriteBack(d, CacheOptions{MaxPending: 2, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"/a", "/b", "/c", "/d"} {
+		if err := wb.Put(ds.NewKey(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wb.Delete(ds.NewKey("/d")); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := wb.Has(ds.NewKey("/d")); has {
+		t.Fatal("deleted key still reported present")
+	}
+	v, err := wb.Get(ds.NewKey("/c"))
+	if err != nil || string(v) != "/c" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+
+	if err := wb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if len(f.objects) != 3 {
+		t.Fatalf("expected 3 objects after flush, got %d", len(f.objects))
+	}
+	if _, ok := f.objects["ipfs/d"]; ok {
+		t.Fatal("deleted key was flushed")
+	}
+}
+
+func TestWriteBackConcurrentPutDelete(t *testing.T) {
+	d, _, done := newTestDatastore(t)
+	defer done()
+
+	wb, err := NewWriteBack(d, CacheOptions{Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+
+	k := ds.NewKey("/racy")
+	for i := 0; i < 50; i++ {
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			wb.Put(k, []byte("value"))
+		}()
+		go func() {
+			defer wg.Done()
+			wb.Get(k)
+		}()
+		go func() {
+			defer wg.Done()
+			wb.Delete(k)
+		}()
+		wg.Wait()
+		if err := wb.Delete(k); err != nil {
+			t.Fatal(err)
+		}
+		if err := wb.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if has, _ := wb.Has(k); has {
+			t.Fatal("deleted key still reported present")
+		}
+		if _, err := wb.Get(k); err != ds.ErrNotFound {
+			t.Fatalf("expected %s, got %v", ds.ErrNotFound, err)
+		}
+	}
+}
+
+// failingDatastore fails writing the keys under /bad.
+type failingDatastore struct {
+	ds.Batching
+}
+
+func (f failingDatastore) Put(k ds.Key, value []byte) error {
+	if strings.HasPrefix(k.String(), "/bad") {
+		return errors.New("write failed")
+	}
+	return f.Batching.Put(k, value)
+}
+
+func TestWriteBackCloseTimeout(t *testing.T) {
+	child := failingDatastore{ds.NewMapDatastore()}
+	wb, err := NewWriteBack(child, CacheOptions{Workers: 2, CloseTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"/good", "/bad/1", "/bad/0"} {
+		if err := wb.Put(ds.NewKey(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	err = wb.Close()
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Close took %s", time.Since(start))
+	}
+	uerr, ok := err.(*UnflushedError)
+	if !ok {
+		t.Fatalf("expected an UnflushedError, got %v", err)
+	}
+	if len(uerr.Keys) != 2 || uerr.Keys[0] != ds.NewKey("/bad/0") || uerr.Keys[1] != ds.NewKey("/bad/1") {
+		t.Fatalf("unexpected unflushed keys: %v", uerr.Keys)
+	}
+	if has, _ := child.Has(ds.NewKey("/good")); !has {
+		t.Fatal("good key wasn't flushed")
+	}
+}
+
+func TestWriteBackFlushTimeout(t *testing.T) {
+	child := failingDatastore{ds.NewMapDatastore()}
+	wb, err := NewWriteBack(child, CacheOptions{
+		Workers:      2,
+		FlushTimeout: 100 * time.Millisecond,
+		CloseTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+
+	if err := wb.Put(ds.NewKey("/bad/0"), []byte("bad")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = wb.Query(dsq.Query{})
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Query took %s", time.Since(start))
+	}
+	uerr, ok := err.(*UnflushedError)
+	if !ok {
+		t.Fatalf("expected an UnflushedError, got %v", err)
+	}
+	if len(uerr.Keys) != 1 || uerr.Keys[0] != ds.NewKey("/bad/0") {
+		t.Fatalf("unexpected unflushed keys: %v", uerr.Keys)
+	}
+}
+
+func TestReadCredentials(t *testing.T) {
+	f, err := ioutil.TempFile("", "s3ds-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`# comment
+[default]
+aws_access_key_id = defkey
+aws_secret_access_key = defsecret
+
+[ipfs]
+aws_access_key_id=key
+aws_secret_access_key=secret
+`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access, secret, err := ReadCredentials(f.Name(), "ipfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access != "key" || secret != "secret" {
+		t.Fatalf("unexpected credentials %q %q", access, secret)
+	}
+
+	if _, _, err := ReadCredentials(f.Name(), "missing"); err == nil {
+		t.Fatal("expected an error for a missing profile")
+	}
+}
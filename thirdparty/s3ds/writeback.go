@@ -0,0 +1,367 @@
+package s3ds
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lru "gx/ipfs/QmQjMHF8ptRgx4E57UFMiT4YM6kqaJeYxZ1MCDX23aw4rK/golang-lru"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dsq "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/query"
+)
+
+var log = logging.Logger("s3ds")
+
+// retryDelay is how long a flush worker waits before retrying a failed write.
+const retryDelay = time.Second
+
+// CacheOptions configures a WriteBack datastore.
+type CacheOptions struct {
+	// CacheEntries is the number of recently used values kept in memory.
+	CacheEntries int
+
+	// MaxPending is the number of writes that may be buffered before Put
+	// blocks until the backing store catches up.
+	MaxPending int
+
+	// Workers is the number of concurrent writes to the backing store.
+	Workers int
+
+	// FlushTimeout is how long Flush, and therefore Query, waits for the
+	// pending writes to reach the backing store before failing.
+	FlushTimeout time.Duration
+
+	// CloseTimeout is how long Close waits for the pending writes to reach
+	// the backing store. The writes failing after that aren't retried.
+	CloseTimeout time.Duration
+}
+
+// DefaultCacheOptions returns sensible defaults for a WriteBack datastore.
+func DefaultCacheOptions() CacheOptions {
+	return CacheOptions{
+		CacheEntries: 1024,
+		MaxPending:   256,
+		Workers:      8,
+		FlushTimeout: 30 * time.Second,
+		CloseTimeout: 30 * time.Second,
+	}
+}
+
+type pendingOp struct {
+	value  []byte
+	delete bool
+}
+
+// WriteBack wraps a slow datastore (like an object store), keeping
+// recently used values in memory and acknowledging writes before they reach
+// the backing store. Pending writes are flushed in the background and on
+// Close. Writes buffered in memory are lost on a crash, which is acceptable
+// for blocks that can be re-fetched but not for the rest of the repo.
+type WriteBack struct {
+	child        ds.Batching
+	cache        *lru.Cache
+	flushTimeout time.Duration
+	closeTimeout time.Duration
+
+	// lk guards the cache updates too, so that it agrees with pending
+	lk         sync.Mutex
+	cond       *sync.Cond
+	pending    map[ds.Key]*pendingOp
+	queue      []ds.Key
+	maxPending int
+	closed     bool
+
+	// enqueued counts the enqueued ops, a value read from child while it
+	// changed may be stale
+	enqueued uint64
+
+	workers sync.WaitGroup
+}
+
+var _ ds.Batching = (*WriteBack)(nil)
+
+// NewWriteBack wraps child with a write-back cache.
+func NewWriteBack(child ds.Batching, opts CacheOptions) (*WriteBack, error) {
+	def := DefaultCacheOptions()
+	if opts.CacheEntries <= 0 {
+		opts.CacheEntries = def.CacheEntries
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = def.MaxPending
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = def.Workers
+	}
+	if opts.FlushTimeout <= 0 {
+		opts.FlushTimeout = def.FlushTimeout
+	}
+	if opts.CloseTimeout <= 0 {
+		opts.CloseTimeout = def.CloseTimeout
+	}
+
+	cache, err := lru.New(opts.CacheEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	wb := &WriteBack{
+		child:        child,
+		cache:        cache,
+		flushTimeout: opts.FlushTimeout,
+		closeTimeout: opts.CloseTimeout,
+		pending:      make(map[ds.Key]*pendingOp),
+		maxPending:   opts.MaxPending,
+	}
+	wb.cond = sync.NewCond(&wb.lk)
+
+	wb.workers.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go wb.worker()
+	}
+	return wb, nil
+}
+
+func (wb *WriteBack) enqueue(k ds.Key, op *pendingOp) {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+
+	for len(wb.pending) >= wb.maxPending && !wb.closed {
+		if _, ok := wb.pending[k]; ok {
+			// replacing a queued op doesn't grow the queue
+			break
+		}
+		wb.cond.Wait()
+	}
+
+	if _, ok := wb.pending[k]; !ok {
+		wb.queue = append(wb.queue, k)
+	}
+	wb.pending[k] = op
+	wb.enqueued++
+	if op.delete {
+		wb.cache.Remove(k)
+	} else {
+		wb.cache.Add(k, op.value)
+	}
+	wb.cond.Broadcast()
+}
+
+func (wb *WriteBack) worker() {
+	defer wb.workers.Done()
+
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	for {
+		for len(wb.queue) == 0 && !wb.closed {
+			wb.cond.Wait()
+		}
+		if len(wb.queue) == 0 {
+			return
+		}
+
+		k := wb.queue[0]
+		wb.queue = wb.queue[1:]
+		op := wb.pending[k]
+
+		wb.lk.Unlock()
+		var err error
+		if op.delete {
+			err = wb.child.Delete(k)
+			if err == ds.ErrNotFound {
+				err = nil
+			}
+		} else {
+			err = wb.child.Put(k, op.value)
+		}
+		wb.lk.Lock()
+		closed := wb.closed
+		wb.lk.Unlock()
+		if err != nil && !closed {
+			log.Errorf("s3ds: writing %s failed, retrying: %s", k, err)
+			time.Sleep(retryDelay)
+		}
+		wb.lk.Lock()
+
+		switch {
+		case err != nil && wb.closed:
+			// left pending, Close reports it
+			log.Errorf("s3ds: writing %s failed: %s", k, err)
+		case err != nil || wb.pending[k] != op:
+			// failed, or replaced while we were writing it
+			wb.queue = append(wb.queue, k)
+		default:
+			delete(wb.pending, k)
+		}
+		wb.cond.Broadcast()
+	}
+}
+
+// lookupPending returns the pending op of k, if any, and the count of
+// enqueued ops.
+func (wb *WriteBack) lookupPending(k ds.Key) (*pendingOp, bool, uint64) {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	op, ok := wb.pending[k]
+	return op, ok, wb.enqueued
+}
+
+// fill caches the value of k read from child, unless an op was enqueued since
+// enqueued was read, which may have made it stale.
+func (wb *WriteBack) fill(k ds.Key, val []byte, enqueued uint64) {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	if wb.enqueued == enqueued {
+		wb.cache.Add(k, val)
+	}
+}
+
+func (wb *WriteBack) Put(k ds.Key, value []byte) error {
+	wb.enqueue(k, &pendingOp{value: value})
+	return nil
+}
+
+func (wb *WriteBack) Get(k ds.Key) ([]byte, error) {
+	op, ok, enqueued := wb.lookupPending(k)
+	if ok {
+		if op.delete {
+			return nil, ds.ErrNotFound
+		}
+		return op.value, nil
+	}
+	if v, ok := wb.cache.Get(k); ok {
+		return v.([]byte), nil
+	}
+
+	val, err := wb.child.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	wb.fill(k, val, enqueued)
+	return val, nil
+}
+
+func (wb *WriteBack) Has(k ds.Key) (bool, error) {
+	if op, ok, _ := wb.lookupPending(k); ok {
+		return !op.delete, nil
+	}
+	if wb.cache.Contains(k) {
+		return true, nil
+	}
+	return wb.child.Has(k)
+}
+
+func (wb *WriteBack) GetSize(k ds.Key) (int, error) {
+	if op, ok, _ := wb.lookupPending(k); ok {
+		if op.delete {
+			return -1, ds.ErrNotFound
+		}
+		return len(op.value), nil
+	}
+	if v, ok := wb.cache.Get(k); ok {
+		return len(v.([]byte)), nil
+	}
+	return wb.child.GetSize(k)
+}
+
+func (wb *WriteBack) Delete(k ds.Key) error {
+	wb.enqueue(k, &pendingOp{delete: true})
+	return nil
+}
+
+// Query flushes pending writes and queries the backing store. It fails if
+// they don't reach it within FlushTimeout.
+func (wb *WriteBack) Query(q dsq.Query) (dsq.Results, error) {
+	if err := wb.Flush(); err != nil {
+		return nil, err
+	}
+	return wb.child.Query(q)
+}
+
+func (wb *WriteBack) Batch() (ds.Batch, error) {
+	return &batch{d: wb, ops: make(map[ds.Key]batchOp)}, nil
+}
+
+// Flush blocks until all writes buffered so far reached the backing store.
+// After FlushTimeout, it returns an UnflushedError listing the writes still
+// pending, which keep being retried in the background.
+func (wb *WriteBack) Flush() error {
+	wb.flushWithin(wb.flushTimeout)
+	return wb.unflushed()
+}
+
+// flushWithin waits for the pending writes to be flushed, giving up after
+// timeout.
+func (wb *WriteBack) flushWithin(timeout time.Duration) {
+	expired := false
+	t := time.AfterFunc(timeout, func() {
+		wb.lk.Lock()
+		expired = true
+		wb.cond.Broadcast()
+		wb.lk.Unlock()
+	})
+	defer t.Stop()
+
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	for len(wb.pending) > 0 && !expired {
+		wb.cond.Wait()
+	}
+}
+
+// unflushed returns an UnflushedError of the pending writes, if any.
+func (wb *WriteBack) unflushed() error {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	if len(wb.pending) == 0 {
+		return nil
+	}
+	uerr := &UnflushedError{}
+	for k := range wb.pending {
+		uerr.Keys = append(uerr.Keys, k)
+	}
+	sort.Slice(uerr.Keys, func(i, j int) bool { return uerr.Keys[i].Less(uerr.Keys[j]) })
+	return uerr
+}
+
+// UnflushedError is returned by Flush and Close when pending writes didn't
+// reach the backing store.
+type UnflushedError struct {
+	Keys []ds.Key
+}
+
+func (e *UnflushedError) Error() string {
+	keys := make([]string, 0, len(e.Keys))
+	for i, k := range e.Keys {
+		if i == 10 {
+			keys = append(keys, "...")
+			break
+		}
+		keys = append(keys, k.String())
+	}
+	return fmt.Sprintf("s3ds: %d writes didn't reach the backing store: %s", len(e.Keys), strings.Join(keys, ", "))
+}
+
+// Close flushes pending writes and stops the flush workers. The writes still
+// failing after CloseTimeout are tried once more, and the ones that didn't
+// reach the backing store are returned in an UnflushedError.
+func (wb *WriteBack) Close() error {
+	wb.flushWithin(wb.closeTimeout)
+
+	wb.lk.Lock()
+	wb.closed = true
+	wb.cond.Broadcast()
+	wb.lk.Unlock()
+
+	wb.workers.Wait()
+
+	err := wb.unflushed()
+	if c, ok := wb.child.(interface{ Close() error }); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package verifbs
+
+import (
+	"context"
+	"sync/atomic"
+
+	cid "gx/ipfs/QmPSQnBKM9g7BaUcZCvswUJVscQ1ipjmwxN5PXCjkp9EQ7/go-cid"
+	logging "gx/ipfs/QmRREK2CAZ5Re2Bd9zZFG6FeYDppUWt5cMgsoUEp3ktgSr/go-log"
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
+	metrics "gx/ipfs/QmekzFM3hPZjTjUFGTABdQkEnQ3PTiMstY198PwSFr5w1Q/go-metrics-interface"
+)
+
+var log = logging.Logger("verifbs")
+
+// RehashBS re-hashes every block read from the wrapped blockstore and
+// compares it against the requested CID. Unlike the blockstore's own
+// HashOnRead, corrupt blocks are logged and counted so that disk corruption
+// doesn't go unnoticed.
+type RehashBS struct {
+	bstore.Blockstore
+
+	rehash  int32 // set atomically, 1 when enabled
+	corrupt metrics.Counter
+}
+
+// NewRehashBS wraps bs with hash verification enabled. The corruption
+// counter is registered in the metrics scope of ctx.
+func NewRehashBS(ctx context.Context, bs bstore.Blockstore) *RehashBS {
+	return &RehashBS{
+		Blockstore: bs,
+		rehash:     1,
+		corrupt: metrics.NewCtx(ctx, "blockstore.corrupt_blocks_total",
+			"Number of blocks read from the local blockstore that failed hash verification").Counter(),
+	}
+}
+
+// HashOnRead toggles verification. It is handled here instead of being
+// passed down so blocks aren't hashed twice.
+func (bs *RehashBS) HashOnRead(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&bs.rehash, v)
+}
+
+func (bs *RehashBS) Get(c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.Blockstore.Get(c)
+	if err != nil || atomic.LoadInt32(&bs.rehash) == 0 {
+		return blk, err
+	}
+
+	rbcid, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return nil, err
+	}
+	if !rbcid.Equals(c) {
+		bs.corrupt.Inc()
+		log.Errorf("corrupt block %s in local blockstore: data hashes to %s", c, rbcid)
+		return nil, bstore.ErrHashMismatch
+	}
+	return blk, nil
+}
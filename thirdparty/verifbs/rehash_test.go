@@ -0,0 +1,65 @@
+package verifbs
+
+import (
+	"context"
+	"testing"
+
+	blocks "gx/ipfs/QmRcHuYzAyswytBuMF78rj3LTChYszomRFXNg4685ZN1WM/go-block-format"
+	ds "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore"
+	dssync "gx/ipfs/QmSpg1CvpXQQow5ernt1gNBXaXV6yxyNqi7XoeerWfzB5w/go-datastore/sync"
+	bstore "gx/ipfs/QmegPGspn3RpTMQ23Fd3GVVMopo1zsEMurudbFMZ5UXBLH/go-ipfs-blockstore"
+)
+
+func TestRehashDetectsCorruption(t *testing.T) {
+	base := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bs := NewRehashBS(context.Background(), base)
+
+	good := blocks.NewBlock([]byte("good data"))
+	if err := bs.Put(good); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.Get(good.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	// store data under a CID it doesn't hash to
+	c := blocks.NewBlock([]byte("original")).Cid()
+	bad, err := blocks.NewBlockWithCid([]byte("bitrot"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := base.Put(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bs.Get(c); err != bstore.ErrHashMismatch {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+
+	bs.HashOnRead(false)
+	if _, err := bs.Get(c); err != nil {
+		t.Fatalf("expected unverified read to succeed, got %v", err)
+	}
+}
+
+func TestHashOnReadConcurrent(t *testing.T) {
+	bs := NewRehashBS(context.Background(), bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())))
+	blk := blocks.NewBlock([]byte("data"))
+	if err := bs.Put(blk); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			bs.HashOnRead(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := bs.Get(blk.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,691 @@
+package zstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"sort"
+)
+
+const (
+	frameMagic = 0xFD2FB528
+
+	// maxBlockSize is the largest block content. RFC 3.1.1.2.3.
+	maxBlockSize = 128 << 10
+
+	// minMatch is the shortest match worth a sequence.
+	minMatch = 4
+
+	// maxOffset keeps the offsets within the predefined offset table.
+	maxOffset = 1 << 27
+
+	hashLog = 15
+
+	// minHuffmanLiterals is the smallest number of literals worth a
+	// Huffman table.
+	minHuffmanLiterals = 64
+)
+
+const (
+	blockRaw        = 0
+	blockCompressed = 2
+)
+
+// Compress appends the zstd frame of src to dst. The frame is a single
+// segment holding the content size, without a checksum. Matches are
+// searched greedily in the whole of src, the literals are Huffman coded when
+// they fit a table directly described by their weights (byte values up to
+// 128, like text), and the sequences use the predefined tables.
+func Compress(dst, src []byte) []byte {
+	dst = appendFrameHeader(dst, uint64(len(src)))
+	if len(src) == 0 {
+		// a last, empty raw block
+		return append(dst, 1, 0, 0)
+	}
+
+	e := &encoder{src: src, table: make([]int32, 1<<hashLog)}
+	for start := 0; start < len(src); start += maxBlockSize {
+		end := start + maxBlockSize
+		if end > len(src) {
+			end = len(src)
+		}
+		dst = e.appendBlock(dst, start, end, end == len(src))
+	}
+	return dst
+}
+
+// ErrTooLarge is returned by Decompress for the content larger than its
+// limit.
+var ErrTooLarge = errors.New("zstd: the content is too large")
+
+// Decompress appends the content of the zstd frames of src to dst, failing
+// with ErrTooLarge without decompressing more than max bytes.
+func Decompress(dst, src []byte, max int) ([]byte, error) {
+	out, err := ioutil.ReadAll(io.LimitReader(NewReader(bytes.NewReader(src)), int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrTooLarge
+	}
+	return append(dst, out...), nil
+}
+
+// IsFrame returns whether data starts like a zstd frame.
+func IsFrame(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data) == frameMagic
+}
+
+var errNoContentSize = errors.New("zstd: the frame has no content size")
+
+// ContentSize returns the content size written in the header of the frame
+// starting data, without decompressing it.
+func ContentSize(data []byte) (uint64, error) {
+	if !IsFrame(data) || len(data) < 5 {
+		return 0, errors.New("zstd: not a zstd frame")
+	}
+	descriptor := data[4]
+	singleSegment := descriptor&(1<<5) != 0
+	off := 5
+	if !singleSegment {
+		// window descriptor
+		off++
+	}
+	off += [4]int{0, 4, 2, 4}[descriptor&3] // dictionary id
+
+	var size int
+	switch descriptor >> 6 {
+	case 0:
+		if !singleSegment {
+			return 0, errNoContentSize
+		}
+		size = 1
+	case 1:
+		size = 2
+	case 2:
+		size = 4
+	case 3:
+		size = 8
+	}
+	if off+size > len(data) {
+		return 0, errors.New("zstd: truncated frame header")
+	}
+	switch size {
+	case 1:
+		return uint64(data[off]), nil
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(data[off:])) + 256, nil
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(data[off:])), nil
+	default:
+		return binary.LittleEndian.Uint64(data[off:]), nil
+	}
+}
+
+// appendFrameHeader appends the header of a single segment frame of size
+// bytes. RFC 3.1.1.1.
+func appendFrameHeader(dst []byte, size uint64) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], frameMagic)
+	dst = append(dst, b[:]...)
+
+	const singleSegment = 1 << 5
+	switch {
+	case size < 256:
+		dst = append(dst, singleSegment, byte(size))
+	case size < 65536+256:
+		dst = append(dst, 1<<6|singleSegment, byte(size-256), byte((size-256)>>8))
+	case size < 1<<32:
+		dst = append(dst, 2<<6|singleSegment)
+		binary.LittleEndian.PutUint32(b[:], uint32(size))
+		dst = append(dst, b[:]...)
+	default:
+		dst = append(dst, 3<<6|singleSegment)
+		var b8 [8]byte
+		binary.LittleEndian.PutUint64(b8[:], size)
+		dst = append(dst, b8[:]...)
+	}
+	return dst
+}
+
+type sequence struct {
+	litLen, matchLen, offset uint32
+}
+
+type encoder struct {
+	src []byte
+	// table holds the last position+1 of each hashed 4 bytes
+	table []int32
+
+	seqs []sequence
+	lits []byte
+}
+
+func (e *encoder) hash(i int) uint32 {
+	return (binary.LittleEndian.Uint32(e.src[i:]) * 2654435761) >> (32 - hashLog)
+}
+
+// appendBlock appends the block of src[start:end], compressed if that's
+// smaller.
+func (e *encoder) appendBlock(dst []byte, start, end int, last bool) []byte {
+	hdr := len(dst)
+	dst = append(dst, 0, 0, 0)
+	out := e.compressBlock(dst, start, end)
+	if size := len(out) - hdr - 3; size < end-start {
+		putBlockHeader(out[hdr:], last, blockCompressed, size)
+		return out
+	}
+	dst = append(dst[:hdr+3], e.src[start:end]...)
+	putBlockHeader(dst[hdr:], last, blockRaw, end-start)
+	return dst
+}
+
+// putBlockHeader writes a block header. RFC 3.1.1.2.
+func putBlockHeader(b []byte, last bool, typ, size int) {
+	v := uint32(typ)<<1 | uint32(size)<<3
+	if last {
+		v |= 1
+	}
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// compressBlock appends the literals and sequences sections of the block of
+// src[start:end]. RFC 3.1.1.3.
+func (e *encoder) compressBlock(dst []byte, start, end int) []byte {
+	src := e.src
+	seqs := e.seqs[:0]
+	lits := e.lits[:0]
+
+	litStart := start
+	for i := start; i+minMatch <= end; {
+		h := e.hash(i)
+		cand := int(e.table[h]) - 1
+		e.table[h] = int32(i + 1)
+		if cand < 0 || i-cand > maxOffset ||
+			binary.LittleEndian.Uint32(src[cand:]) != binary.LittleEndian.Uint32(src[i:]) {
+			// skip faster through the incompressible parts
+			i += 1 + (i-litStart)>>6
+			continue
+		}
+
+		for i > litStart && cand > 0 && src[i-1] == src[cand-1] {
+			i--
+			cand--
+		}
+		n := minMatch
+		for i+n < end && src[cand+n] == src[i+n] {
+			n++
+		}
+
+		lits = append(lits, src[litStart:i]...)
+		seqs = append(seqs, sequence{
+			litLen:   uint32(i - litStart),
+			matchLen: uint32(n),
+			offset:   uint32(i - cand),
+		})
+		i += n
+		litStart = i
+		if i-2+minMatch <= len(src) {
+			e.table[e.hash(i-2)] = int32(i - 2 + 1)
+		}
+	}
+	lits = append(lits, src[litStart:end]...)
+	e.seqs, e.lits = seqs, lits
+
+	dst = appendLiterals(dst, lits)
+	return appendSequences(dst, seqs)
+}
+
+// appendLiterals appends the literals section. RFC 3.1.1.3.1.
+func appendLiterals(dst, lits []byte) []byte {
+	if out, ok := appendHuffmanLiterals(dst, lits); ok {
+		return out
+	}
+
+	n := len(lits)
+	switch {
+	case n < 1<<5:
+		dst = append(dst, byte(n<<3))
+	case n < 1<<12:
+		dst = append(dst, byte(n<<4)|1<<2, byte(n>>4))
+	default:
+		dst = append(dst, byte(n<<4)|3<<2, byte(n>>4), byte(n>>12))
+	}
+	return append(dst, lits...)
+}
+
+// appendHuffmanLiterals appends a compressed literals block, if the
+// literals fit a table described directly by their weights and that is
+// smaller than the raw literals. RFC 3.1.1.3.1.4.
+func appendHuffmanLiterals(dst, lits []byte) ([]byte, bool) {
+	if len(lits) < minHuffmanLiterals {
+		return dst, false
+	}
+
+	var counts [256]int
+	for _, b := range lits {
+		counts[b]++
+	}
+	lastSym, symbols := 0, 0
+	for s, c := range counts {
+		if c > 0 {
+			lastSym = s
+			symbols++
+		}
+	}
+	// the direct representation holds the weights of 128 symbols, the
+	// weight of the last symbol is implied
+	if lastSym > 128 || symbols < 2 {
+		return dst, false
+	}
+
+	lengths := huffmanLengths(counts[:lastSym+1], maxHuffmanBits)
+	maxBits := 0
+	for _, l := range lengths {
+		if l > maxBits {
+			maxBits = l
+		}
+	}
+	codes := canonicalCodes(lengths, maxBits)
+
+	// the table description: RFC 4.2.1.1
+	hdr := len(dst)
+	dst = append(dst, 0, 0, 0, 0, 0)
+	body := len(dst)
+	dst = append(dst, byte(127+lastSym))
+	for s := 0; s < lastSym; s += 2 {
+		b := huffmanWeight(lengths[s], maxBits) << 4
+		if s+1 < lastSym {
+			b |= huffmanWeight(lengths[s+1], maxBits)
+		}
+		dst = append(dst, b)
+	}
+
+	streams := 1
+	if len(lits) > 1023 {
+		streams = 4
+	}
+	if streams == 1 {
+		dst = appendHuffmanStream(dst, lits, codes, lengths)
+	} else {
+		jump := len(dst)
+		dst = append(dst, 0, 0, 0, 0, 0, 0)
+		per := (len(lits) + 3) / 4
+		for i := 0; i < 4; i++ {
+			from, to := i*per, (i+1)*per
+			if to > len(lits) {
+				to = len(lits)
+			}
+			at := len(dst)
+			dst = appendHuffmanStream(dst, lits[from:to], codes, lengths)
+			if i < 3 {
+				if len(dst)-at > 0xffff {
+					return dst[:hdr], false
+				}
+				binary.LittleEndian.PutUint16(dst[jump+2*i:], uint16(len(dst)-at))
+			}
+		}
+	}
+
+	compressed := len(dst) - body
+	regenerated := len(lits)
+	// the header size depends on the sizes, the body is moved to follow it
+	var h []byte
+	switch {
+	case compressed < 1<<10 && regenerated < 1<<10:
+		format := uint64(0)
+		if streams == 4 {
+			format = 1
+		}
+		v := 2 | format<<2 | uint64(regenerated)<<4 | uint64(compressed)<<14
+		h = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case compressed < 1<<14 && regenerated < 1<<14:
+		v := 2 | 2<<2 | uint64(regenerated)<<4 | uint64(compressed)<<18
+		h = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	default:
+		v := 2 | 3<<2 | uint64(regenerated)<<4 | uint64(compressed)<<22
+		h = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32)}
+	}
+	if len(h)+compressed >= 3+len(lits) {
+		return dst[:hdr], false
+	}
+	copy(dst[hdr:], h)
+	copy(dst[hdr+len(h):], dst[body:])
+	return dst[:hdr+len(h)+compressed], true
+}
+
+func huffmanWeight(length, maxBits int) byte {
+	if length == 0 {
+		return 0
+	}
+	return byte(maxBits + 1 - length)
+}
+
+// appendHuffmanStream appends the bitstream of lits, the first literal read
+// first, so written last.
+func appendHuffmanStream(dst, lits []byte, codes []uint16, lengths []int) []byte {
+	w := bitWriter{out: dst}
+	for i := len(lits) - 1; i >= 0; i-- {
+		s := lits[i]
+		w.add(uint64(codes[s]), uint(lengths[s]))
+	}
+	return w.close()
+}
+
+// huffmanLengths returns the code lengths of a Huffman code of the counts,
+// no longer than maxBits. The counts are flattened until the code fits.
+func huffmanLengths(counts []int, maxBits int) []int {
+	lengths := make([]int, len(counts))
+	counts = append([]int(nil), counts...)
+	for {
+		type node struct {
+			count       int
+			left, right int // children, -1 for the leaves
+			sym         int
+		}
+		var nodes []node
+		var queue []int
+		for s, c := range counts {
+			if c > 0 {
+				nodes = append(nodes, node{count: c, left: -1, right: -1, sym: s})
+				queue = append(queue, len(nodes)-1)
+			}
+		}
+		sort.SliceStable(queue, func(i, j int) bool { return nodes[queue[i]].count < nodes[queue[j]].count })
+
+		// two queues: the sorted leaves and the merged nodes, which are
+		// created in increasing count order
+		var merged []int
+		pop := func() int {
+			if len(merged) == 0 || (len(queue) > 0 && nodes[queue[0]].count <= nodes[merged[0]].count) {
+				n := queue[0]
+				queue = queue[1:]
+				return n
+			}
+			n := merged[0]
+			merged = merged[1:]
+			return n
+		}
+		for len(queue)+len(merged) > 1 {
+			a, b := pop(), pop()
+			nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, left: a, right: b, sym: -1})
+			merged = append(merged, len(nodes)-1)
+		}
+
+		tooLong := false
+		var walk func(n, depth int)
+		walk = func(n, depth int) {
+			if nodes[n].left < 0 {
+				lengths[nodes[n].sym] = depth
+				if depth > maxBits {
+					tooLong = true
+				}
+				return
+			}
+			walk(nodes[n].left, depth+1)
+			walk(nodes[n].right, depth+1)
+		}
+		walk(len(nodes)-1, 0)
+		if !tooLong {
+			return lengths
+		}
+		for s, c := range counts {
+			if c > 0 {
+				counts[s] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// canonicalCodes assigns the codes the way the decoder builds its table:
+// the longest codes first, in symbol order. RFC 4.2.1.3.
+func canonicalCodes(lengths []int, maxBits int) []uint16 {
+	codes := make([]uint16, len(lengths))
+	code := 0
+	for l := maxBits; l > 0; l-- {
+		for s, sl := range lengths {
+			if sl == l {
+				codes[s] = uint16(code)
+				code++
+			}
+		}
+		code >>= 1
+	}
+	return codes
+}
+
+// bitWriter writes a bitstream read backwards by the decoder. RFC 4.1.
+type bitWriter struct {
+	out  []byte
+	bits uint64
+	n    uint
+}
+
+func (w *bitWriter) add(v uint64, n uint) {
+	w.bits |= (v & (1<<n - 1)) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.n -= 8
+	}
+}
+
+// close writes the final 1 bit the decoder looks for.
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.n > 0 {
+		w.out = append(w.out, byte(w.bits))
+	}
+	return w.out
+}
+
+// fseEncoder encodes the symbols of a normalized distribution.
+type fseEncoder struct {
+	tableLog   uint
+	stateTable []uint16
+	symbols    []fseSymbol
+}
+
+type fseSymbol struct {
+	deltaFindState int32
+	deltaNbBits    uint32
+}
+
+// newFSEEncoder builds the encoding table of norm, spreading the symbols
+// like the decoder does. RFC 4.1.1.
+func newFSEEncoder(norm []int16, tableLog uint) *fseEncoder {
+	tableSize := 1 << tableLog
+	mask := tableSize - 1
+	step := (tableSize >> 1) + (tableSize >> 3) + 3
+	highThreshold := tableSize - 1
+
+	tableSymbol := make([]int, tableSize)
+	cumul := make([]int, len(norm)+1)
+	for s, n := range norm {
+		if n == -1 {
+			cumul[s+1] = cumul[s] + 1
+			tableSymbol[highThreshold] = s
+			highThreshold--
+		} else {
+			cumul[s+1] = cumul[s] + int(n)
+		}
+	}
+
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			tableSymbol[pos] = s
+			pos = (pos + step) & mask
+			for pos > highThreshold {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	e := &fseEncoder{
+		tableLog:   tableLog,
+		stateTable: make([]uint16, tableSize),
+		symbols:    make([]fseSymbol, len(norm)),
+	}
+	for u := 0; u < tableSize; u++ {
+		s := tableSymbol[u]
+		e.stateTable[cumul[s]] = uint16(tableSize + u)
+		cumul[s]++
+	}
+
+	total := 0
+	for s, n := range norm {
+		switch n {
+		case 0:
+			e.symbols[s].deltaNbBits = uint32((tableLog+1)<<16) - uint32(tableSize)
+		case -1, 1:
+			e.symbols[s].deltaNbBits = uint32(tableLog<<16) - uint32(tableSize)
+			e.symbols[s].deltaFindState = int32(total - 1)
+			total++
+		default:
+			maxBitsOut := tableLog - uint(bits.Len16(uint16(n-1))-1)
+			minStatePlus := uint32(n) << maxBitsOut
+			e.symbols[s].deltaNbBits = uint32(maxBitsOut<<16) - minStatePlus
+			e.symbols[s].deltaFindState = int32(total - int(n))
+			total += int(n)
+		}
+	}
+	return e
+}
+
+type fseState struct {
+	e     *fseEncoder
+	state uint32
+}
+
+// init sets the state of the last symbol, written without bits.
+func (st *fseState) init(e *fseEncoder, sym uint8) {
+	st.e = e
+	tt := e.symbols[sym]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - tt.deltaNbBits
+	st.state = uint32(e.stateTable[int32(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (st *fseState) encode(w *bitWriter, sym uint8) {
+	tt := st.e.symbols[sym]
+	nbBitsOut := (st.state + tt.deltaNbBits) >> 16
+	w.add(uint64(st.state), uint(nbBitsOut))
+	st.state = uint32(st.e.stateTable[int32(st.state>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (st *fseState) flush(w *bitWriter) {
+	w.add(uint64(st.state), st.e.tableLog)
+}
+
+// The predefined distributions. RFC 3.1.1.3.2.2.
+var (
+	literalLengthEncoder = newFSEEncoder([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}, 6)
+	matchLengthEncoder = newFSEEncoder([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}, 6)
+	offsetEncoder = newFSEEncoder([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}, 5)
+)
+
+var (
+	literalLengthBaselines = []uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	literalLengthExtraBits = []uint{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	matchLengthBaselines = []uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	matchLengthExtraBits = []uint{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// lengthCode returns the code of v, the last baseline not above it.
+func lengthCode(v uint32, baselines []uint32) uint8 {
+	c := len(baselines) - 1
+	for baselines[c] > v {
+		c--
+	}
+	return uint8(c)
+}
+
+// appendSequences appends the sequences section, using the predefined
+// tables. RFC 3.1.1.3.2.
+func appendSequences(dst []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7f00:
+		dst = append(dst, byte(n>>8)+128, byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+	// predefined modes for the three tables
+	dst = append(dst, 0)
+
+	type codes struct {
+		ll, ml, of uint8
+	}
+	cs := make([]codes, n)
+	for i, s := range seqs {
+		cs[i] = codes{
+			ll: lengthCode(s.litLen, literalLengthBaselines),
+			ml: lengthCode(s.matchLen, matchLengthBaselines),
+			of: uint8(bits.Len32(s.offset+3) - 1),
+		}
+	}
+	extra := func(w *bitWriter, s sequence, c codes) {
+		w.add(uint64(s.litLen-literalLengthBaselines[c.ll]), literalLengthExtraBits[c.ll])
+		w.add(uint64(s.matchLen-matchLengthBaselines[c.ml]), matchLengthExtraBits[c.ml])
+		w.add(uint64(s.offset+3-1<<c.of), uint(c.of))
+	}
+
+	// written backwards, the decoder reads the first sequence first
+	w := bitWriter{out: dst}
+	var ll, ml, of fseState
+	last := n - 1
+	ml.init(matchLengthEncoder, cs[last].ml)
+	of.init(offsetEncoder, cs[last].of)
+	ll.init(literalLengthEncoder, cs[last].ll)
+	extra(&w, seqs[last], cs[last])
+	for i := n - 2; i >= 0; i-- {
+		of.encode(&w, cs[i].of)
+		ml.encode(&w, cs[i].ml)
+		ll.encode(&w, cs[i].ll)
+		extra(&w, seqs[i], cs[i])
+	}
+	ml.flush(&w)
+	of.flush(&w)
+	ll.flush(&w)
+	return w.close()
+}
@@ -0,0 +1,102 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func testInputs() map[string][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 300<<10)
+	rnd.Read(random)
+
+	var js strings.Builder
+	for i := 0; js.Len() < 400<<10; i++ {
+		fmt.Fprintf(&js, `{"id":%d,"name":"object %d","tags":["a","b"],"score":%d}`+"\n", i, i%97, rnd.Intn(1000))
+	}
+
+	// bytes above 128 don't fit a direct Huffman table
+	binary := make([]byte, 64<<10)
+	for i := range binary {
+		binary[i] = byte(rnd.Intn(4)) * 85
+	}
+
+	return map[string][]byte{
+		"empty":    {},
+		"one":      {'x'},
+		"short":    []byte("hello, hello, hello"),
+		"runs":     bytes.Repeat([]byte{0}, 200<<10),
+		"random":   random,
+		"json":     []byte(js.String()),
+		"binary":   binary,
+		"boundary": []byte(js.String()[:maxBlockSize]),
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	for name, in := range testInputs() {
+		c := Compress(nil, in)
+		if !IsFrame(c) {
+			t.Fatalf("%s: not a frame", name)
+		}
+		size, err := ContentSize(c)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if size != uint64(len(in)) {
+			t.Fatalf("%s: expected a content size of %d, got %d", name, len(in), size)
+		}
+
+		out, err := Decompress(nil, c, len(in))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !bytes.Equal(out, in) {
+			t.Fatalf("%s: the decompressed content differs", name)
+		}
+		if len(in) > 0 {
+			if _, err := Decompress(nil, c, len(in)-1); err != ErrTooLarge {
+				t.Fatalf("%s: expected the limit to apply, got %v", name, err)
+			}
+		}
+	}
+}
+
+func TestCompressRatio(t *testing.T) {
+	in := testInputs()
+	for name, max := range map[string]float64{
+		"runs":   0.01,
+		"json":   0.3,
+		"binary": 0.7,
+		"random": 1.001,
+	} {
+		c := Compress(nil, in[name])
+		if r := float64(len(c)) / float64(len(in[name])); r > max {
+			t.Errorf("%s: expected a ratio below %.3f, got %.3f", name, max, r)
+		}
+	}
+}
+
+func TestHuffmanLengthsLimited(t *testing.T) {
+	// fibonacci counts make the deepest Huffman trees
+	counts := make([]int, 30)
+	a, b := 1, 1
+	for i := range counts {
+		counts[i] = a
+		a, b = b, a+b
+	}
+	lengths := huffmanLengths(counts, maxHuffmanBits)
+	kraft := 0
+	for _, l := range lengths {
+		if l > maxHuffmanBits {
+			t.Fatalf("code length %d above %d", l, maxHuffmanBits)
+		}
+		kraft += 1 << uint(maxHuffmanBits-l)
+	}
+	if kraft != 1<<maxHuffmanBits {
+		t.Fatalf("the code isn't complete: %d", kraft)
+	}
+}